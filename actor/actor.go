@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,9 +17,17 @@ const fieldActorName = "tid"
 type Descriptor struct {
 	absoluteName string
 	log          *log.Entry
+	parent       *Descriptor
 
 	childrenMu     sync.Mutex
 	childrenCounts map[string]int32
+
+	// Unix time in nanoseconds, accessed atomically.
+	lastActivity int64
+
+	queuesMu sync.Mutex
+	queues   map[string]func() int
+	gauges   map[string]func() int64
 }
 
 var root = Descriptor{log: log.NewEntry(log.StandardLogger())}
@@ -55,6 +65,7 @@ func (d *Descriptor) NewChild(nameParts ...interface{}) *Descriptor {
 	child := Descriptor{
 		absoluteName: childAbsName,
 		log:          childLog,
+		parent:       d,
 	}
 	return &child
 }
@@ -77,6 +88,34 @@ func (d *Descriptor) String() string {
 	return d.absoluteName
 }
 
+// Touch records the current time as the time of the latest activity of the
+// actor. Actors are supposed to call it every time they handle an event.
+func (d *Descriptor) Touch() {
+	atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+}
+
+// ObserveQueue registers a function that returns the current depth of an
+// actor queue, e.g. `len` of a buffered channel, to be reported by Dump.
+func (d *Descriptor) ObserveQueue(name string, depthFn func() int) {
+	d.queuesMu.Lock()
+	if d.queues == nil {
+		d.queues = make(map[string]func() int)
+	}
+	d.queues[name] = depthFn
+	d.queuesMu.Unlock()
+}
+
+// ObserveGauge registers a function that returns the current value of an
+// actor metric, e.g. a counter of handled errors, to be reported by Dump.
+func (d *Descriptor) ObserveGauge(name string, valueFn func() int64) {
+	d.queuesMu.Lock()
+	if d.gauges == nil {
+		d.gauges = make(map[string]func() int64)
+	}
+	d.gauges[name] = valueFn
+	d.queuesMu.Unlock()
+}
+
 // Spawn starts function `f` as a goroutine making it a member of the `wg`
 // wait group.
 func Spawn(actDesc *Descriptor, wg *sync.WaitGroup, f func()) {
@@ -87,6 +126,8 @@ func Spawn(actDesc *Descriptor, wg *sync.WaitGroup, f func()) {
 		if wg != nil {
 			defer wg.Done()
 		}
+		register(actDesc)
+		defer unregister(actDesc)
 		actDesc.Log().Info("Started")
 		defer func() {
 			if p := recover(); p != nil {
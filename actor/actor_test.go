@@ -2,6 +2,7 @@ package actor
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -46,6 +47,44 @@ func (s *IDSuite) TestNewChildEmpty(c *C) {
 	c.Assert(id.NewChild(), Equals, id)
 }
 
+func (s *IDSuite) TestDump(c *C) {
+	parent := root.NewChild("dump")
+	child := parent.NewChild("child")
+	ch := make(chan int, 3)
+	ch <- 1
+	child.ObserveQueue("ch", func() int { return len(ch) })
+	child.ObserveGauge("g", func() int64 { return 42 })
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	Spawn(child, &wg, func() {
+		child.Touch()
+		<-stopCh
+	})
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+
+	// When
+	var childState *State
+	for childState == nil {
+		for _, parentState := range Dump().Children {
+			if parentState.Name == parent.String() {
+				c.Assert(parentState.Running, Equals, 0)
+				c.Assert(len(parentState.Children), Equals, 1)
+				childState = parentState.Children[0]
+			}
+		}
+	}
+
+	// Then
+	c.Check(childState.Name, Equals, "/dump.0/child.0")
+	c.Check(childState.Running, Equals, 1)
+	c.Check(childState.StartedAt, NotNil)
+	c.Check(childState.Queues, DeepEquals, map[string]int{"ch": 1})
+	c.Check(childState.Gauges, DeepEquals, map[string]int64{"g": 42})
+}
+
 func (s *IDSuite) TestNewChildComplex(c *C) {
 	c.Assert(root.NewChild("foo", 0, []string{"d"}, nil, "bar").String(), Equals, "/foo_0_[d]_<nil>_bar.0")
 }
@@ -0,0 +1,126 @@
+package actor
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State is a snapshot of an actor and its descendants. Descriptors that are
+// not running themselves are included only if they have running descendants,
+// so that the hierarchy is preserved.
+type State struct {
+	Name           string           `json:"name"`
+	Running        int              `json:"running,omitempty"`
+	StartedAt      *time.Time       `json:"started_at,omitempty"`
+	LastActivityAt *time.Time       `json:"last_activity_at,omitempty"`
+	Queues         map[string]int   `json:"queues,omitempty"`
+	Gauges         map[string]int64 `json:"gauges,omitempty"`
+	Children       []*State         `json:"children,omitempty"`
+}
+
+type runningActor struct {
+	count     int
+	startedAt time.Time
+}
+
+var (
+	runningMu sync.Mutex
+	running   = make(map[*Descriptor]*runningActor)
+)
+
+func register(d *Descriptor) {
+	runningMu.Lock()
+	ra := running[d]
+	if ra == nil {
+		ra = &runningActor{startedAt: time.Now()}
+		running[d] = ra
+	}
+	ra.count++
+	runningMu.Unlock()
+}
+
+func unregister(d *Descriptor) {
+	runningMu.Lock()
+	if ra := running[d]; ra != nil {
+		ra.count--
+		if ra.count <= 0 {
+			delete(running, d)
+		}
+	}
+	runningMu.Unlock()
+}
+
+// Dump returns a snapshot of all running actors arranged into a hierarchy
+// rooted at the root descriptor.
+func Dump() *State {
+	runningMu.Lock()
+	states := make(map[*Descriptor]*State, len(running))
+	for d, ra := range running {
+		startedAt := ra.startedAt
+		s := d.state()
+		s.Running = ra.count
+		s.StartedAt = &startedAt
+		states[d] = s
+	}
+	runningMu.Unlock()
+
+	rootState := states[&root]
+	if rootState == nil {
+		rootState = &State{Name: "/"}
+		states[&root] = rootState
+	}
+	linked := make(map[*Descriptor]bool, len(states))
+	var descs []*Descriptor
+	for d := range states {
+		descs = append(descs, d)
+	}
+	// Link every running actor to its parent, and ancestors that are not
+	// running to theirs, until an already linked ancestor is reached.
+	for _, d := range descs {
+		for child := d; child.parent != nil && !linked[child]; child = child.parent {
+			parentState := states[child.parent]
+			if parentState == nil {
+				parentState = child.parent.state()
+				states[child.parent] = parentState
+			}
+			parentState.Children = append(parentState.Children, states[child])
+			linked[child] = true
+		}
+	}
+	sortChildren(rootState)
+	return rootState
+}
+
+func (d *Descriptor) state() *State {
+	s := State{Name: d.absoluteName}
+	if lastActivity := atomic.LoadInt64(&d.lastActivity); lastActivity != 0 {
+		lastActivityAt := time.Unix(0, lastActivity)
+		s.LastActivityAt = &lastActivityAt
+	}
+	d.queuesMu.Lock()
+	if len(d.queues) > 0 {
+		s.Queues = make(map[string]int, len(d.queues))
+		for name, depthFn := range d.queues {
+			s.Queues[name] = depthFn()
+		}
+	}
+	if len(d.gauges) > 0 {
+		s.Gauges = make(map[string]int64, len(d.gauges))
+		for name, valueFn := range d.gauges {
+			s.Gauges[name] = valueFn()
+		}
+	}
+	d.queuesMu.Unlock()
+	return &s
+}
+
+func sortChildren(s *State) {
+	sort.Slice(s.Children, func(i, j int) bool {
+		return s.Children[i].Name < s.Children[j].Name
+	})
+	for _, child := range s.Children {
+		sortChildren(child)
+	}
+}
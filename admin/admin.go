@@ -11,6 +11,8 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/offsetstore"
+	"github.com/mailgun/kafka-pixy/zkconn"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -28,8 +30,15 @@ type T struct {
 	parentActDesc *actor.Descriptor
 	cfg           *config.Proxy
 	kafkaClt      sarama.Client
+	clusterAdmin  sarama.ClusterAdmin
 	zkConn        *zk.Conn
+	offsetStore   offsetstore.T
+	metadataCache metadataCache
 	mtx           sync.Mutex
+
+	// Groups migrated from the offset store to Kafka, see SetKafkaGroups.
+	kafkaGroupsMu sync.RWMutex
+	kafkaGroups   map[string]bool
 }
 
 // Spawn creates an admin instance with the specified configuration and starts
@@ -39,6 +48,12 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 		parentActDesc: parentActDesc,
 		cfg:           cfg,
 	}
+	if cfg.OffsetStorage.Backend != config.OffsetStorageKafka {
+		var err error
+		if a.offsetStore, err = offsetstore.New(cfg.OffsetStorage); err != nil {
+			return nil, errors.Wrap(err, "failed to create offset store")
+		}
+	}
 	return &a, nil
 }
 
@@ -49,9 +64,15 @@ func (a *T) Stop() {
 	if a.kafkaClt != nil {
 		a.kafkaClt.Close()
 	}
+	if a.clusterAdmin != nil {
+		a.clusterAdmin.Close()
+	}
 	if a.zkConn != nil {
 		a.zkConn.Close()
 	}
+	if a.offsetStore != nil {
+		a.offsetStore.Close()
+	}
 }
 
 type PartitionOffset struct {
@@ -75,6 +96,30 @@ type TopicMetadata struct {
 	Partitions []PartitionMetadata
 }
 
+// GroupDescription is a consumer group state as registered in ZooKeeper.
+type GroupDescription struct {
+	// Generation is the number of times members joined or left the group.
+	Generation int32
+	// Members maps IDs of the group members to topics they are subscribed to.
+	Members map[string][]string
+}
+
+// PartitionOwner is a group member that claimed a topic partition.
+type PartitionOwner struct {
+	Partition int32
+	Owner     string
+	ClaimedAt time.Time
+}
+
+// Message is a message read from a topic partition by PeekMessages.
+type Message struct {
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+	Headers   []*sarama.RecordHeader
+}
+
 type TopicConfig struct {
 	Version int32             `json:"version"`
 	Config  map[string]string `json:"config"`
@@ -92,6 +137,7 @@ func (a *T) ResetKafkaClt() {
 		a.kafkaClt.Close()
 		a.kafkaClt = nil
 	}
+	a.resetMetadataCache()
 }
 
 // GetGroupOffsets for every partition of the specified topic it returns the
@@ -107,10 +153,80 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 }
 
 func (a *T) getGroupOffsets(group, topic string) ([]PartitionOffset, error) {
+	return a.getGroupOffsetsFrom(group, topic, a.groupStore(group))
+}
+
+// getGroupOffsetsFrom gets offsets committed by a group from the specified
+// store, or from Kafka if it is nil.
+func (a *T) getGroupOffsetsFrom(group, topic string, store offsetstore.T) ([]PartitionOffset, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := getOffsetRanges(kafkaClt, topic)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		for i := range offsets {
+			offset, err := store.Fetch(group, topic, offsets[i].Partition)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to fetch offset, partition=%d", offsets[i].Partition)
+			}
+			offsets[i].Offset = offset.Val
+			offsets[i].Metadata = offset.Meta
+		}
+		return offsets, nil
+	}
+
+	// Fetch the last committed offsets for all partitions of the group/topic.
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get coordinator")
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: ProtocolVer1}
+	for _, po := range offsets {
+		req.AddPartition(topic, po.Partition)
+	}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch offsets")
+	}
+	for i := range offsets {
+		p := offsets[i].Partition
+		block := res.GetBlock(topic, p)
+		if block == nil {
+			return nil, errors.Wrapf(nil, "offset block is missing, partition=%d", p)
+		}
+		offsets[i].Offset = block.Offset
+		offsets[i].Metadata = block.Metadata
+	}
+
+	return offsets, nil
+}
+
+// GetTopicOffsets returns the current offset range for every partition of
+// the specified topic.
+func (a *T) GetTopicOffsets(topic string) ([]PartitionOffset, error) {
+	results, err := a.getTopicOffsets(topic)
+	if err != nil {
+		a.ResetKafkaClt()
+		return a.getTopicOffsets(topic)
+	}
+	return results, nil
+}
+
+func (a *T) getTopicOffsets(topic string) ([]PartitionOffset, error) {
 	kafkaClt, err := a.lazyKafkaClt()
 	if err != nil {
 		return nil, err
 	}
+	return getOffsetRanges(kafkaClt, topic)
+}
+
+// getOffsetRanges returns the oldest and newest offsets for all partitions of
+// a topic.
+func getOffsetRanges(kafkaClt sarama.Client, topic string) ([]PartitionOffset, error) {
 	partitions, err := kafkaClt.Partitions(topic)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get topic partitions")
@@ -175,29 +291,6 @@ func (a *T) getGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 	if err, ok := <-errorsCh; ok {
 		return nil, err
 	}
-
-	// Fetch the last committed offsets for all partitions of the group/topic.
-	coordinator, err := kafkaClt.Coordinator(group)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get coordinator")
-	}
-	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: ProtocolVer1}
-	for _, p := range partitions {
-		req.AddPartition(topic, p)
-	}
-	res, err := coordinator.FetchOffset(&req)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to fetch offsets")
-	}
-	for i, p := range partitions {
-		block := res.GetBlock(topic, p)
-		if block == nil {
-			return nil, errors.Wrapf(nil, "offset block is missing, partition=%d", p)
-		}
-		offsets[i].Offset = block.Offset
-		offsets[i].Metadata = block.Metadata
-	}
-
 	return offsets, nil
 }
 
@@ -212,6 +305,21 @@ func (a *T) SetGroupOffsets(group, topic string, offsets []PartitionOffset) erro
 }
 
 func (a *T) setGroupOffsets(group, topic string, offsets []PartitionOffset) error {
+	return a.setGroupOffsetsTo(group, topic, offsets, a.groupStore(group))
+}
+
+// setGroupOffsetsTo commits offsets of a group to the specified store, or to
+// Kafka if it is nil.
+func (a *T) setGroupOffsetsTo(group, topic string, offsets []PartitionOffset, store offsetstore.T) error {
+	if store != nil {
+		for _, po := range offsets {
+			offset := offsetstore.Offset{Val: po.Offset, Meta: po.Metadata}
+			if err := store.Commit(group, topic, po.Partition, offset); err != nil {
+				return errors.Wrapf(err, "failed to commit offset, partition=%d", po.Partition)
+			}
+		}
+		return nil
+	}
 	kafkaClt, err := a.lazyKafkaClt()
 	if err != nil {
 		return err
@@ -241,6 +349,98 @@ func (a *T) setGroupOffsets(group, topic string, offsets []PartitionOffset) erro
 	return nil
 }
 
+// CloneGroupOffsets commits offsets committed by a consumer group for a topic
+// on behalf of another group, so that the clone starts consuming exactly
+// where the original group is. The clone group must have no offsets committed
+// for the topic. Partitions that the original group has not committed offsets
+// for are skipped. It returns the cloned offsets.
+func (a *T) CloneGroupOffsets(group, clone, topic string) ([]PartitionOffset, error) {
+	if group == clone {
+		return nil, ErrInvalidParam(errors.New("clone group is the same as the original one"))
+	}
+	cloneOffsets, err := a.GetGroupOffsets(clone, topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get clone offsets")
+	}
+	for _, po := range cloneOffsets {
+		if po.Offset >= 0 {
+			return nil, ErrInvalidParam(errors.Errorf("group %s has offsets committed for %s", clone, topic))
+		}
+	}
+	offsets, err := a.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get original offsets")
+	}
+	cloned := make([]PartitionOffset, 0, len(offsets))
+	for _, po := range offsets {
+		if po.Offset >= 0 {
+			cloned = append(cloned, po)
+		}
+	}
+	if len(cloned) == 0 {
+		return cloned, nil
+	}
+	if err := a.SetGroupOffsets(clone, topic, cloned); err != nil {
+		return nil, errors.Wrap(err, "failed to set clone offsets")
+	}
+	return cloned, nil
+}
+
+// CopyGroupOffsetsToKafka commits offsets committed by a consumer group for a
+// topic to the offset store to Kafka, replacing any offsets the group has in
+// Kafka. Partitions that the group has not committed offsets for are skipped.
+// It returns the copied offsets.
+func (a *T) CopyGroupOffsetsToKafka(group, topic string) ([]PartitionOffset, error) {
+	if a.offsetStore == nil {
+		return nil, errors.New("offsets are committed to Kafka already")
+	}
+	offsets, err := a.getGroupOffsetsFrom(group, topic, a.offsetStore)
+	if err != nil {
+		a.ResetKafkaClt()
+		if offsets, err = a.getGroupOffsetsFrom(group, topic, a.offsetStore); err != nil {
+			return nil, errors.Wrap(err, "failed to get stored offsets")
+		}
+	}
+	copied := make([]PartitionOffset, 0, len(offsets))
+	for _, po := range offsets {
+		if po.Offset >= 0 {
+			copied = append(copied, po)
+		}
+	}
+	if len(copied) == 0 {
+		return copied, nil
+	}
+	if err := a.setGroupOffsetsTo(group, topic, copied, nil); err != nil {
+		a.ResetKafkaClt()
+		if err := a.setGroupOffsetsTo(group, topic, copied, nil); err != nil {
+			return nil, errors.Wrap(err, "failed to commit offsets to Kafka")
+		}
+	}
+	return copied, nil
+}
+
+// SetKafkaGroups replaces the set of groups migrated from the offset store to
+// Kafka, offsets of these groups are got and set in Kafka.
+func (a *T) SetKafkaGroups(groups map[string]bool) {
+	a.kafkaGroupsMu.Lock()
+	a.kafkaGroups = groups
+	a.kafkaGroupsMu.Unlock()
+}
+
+// groupStore returns the offset store that a group commits offsets to, or
+// nil if it commits them to Kafka.
+func (a *T) groupStore(group string) offsetstore.T {
+	if a.offsetStore == nil {
+		return nil
+	}
+	a.kafkaGroupsMu.RLock()
+	defer a.kafkaGroupsMu.RUnlock()
+	if a.kafkaGroups[group] {
+		return nil
+	}
+	return a.offsetStore
+}
+
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
@@ -315,6 +515,337 @@ func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, e
 	return consumers, nil
 }
 
+// ListGroups returns a sorted list of all consumer groups registered in
+// ZooKeeper.
+func (a *T) ListGroups() ([]string, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	groupsPath := fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot)
+	groups, _, err := zkConn.Children(groupsPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to fetch consumer groups")
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// GetGroupMembers returns IDs of members of a consumer group registered in
+// ZooKeeper. Members registered in the legacy layout are included, so that
+// a group consumed by older Kafka-Pixy versions is not mistaken for idle.
+func (a *T) GetGroupMembers(group string) ([]string, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	membersPath := fmt.Sprintf("%s/consumers/%s/ids", a.cfg.ZooKeeper.Chroot, group)
+	members, _, err := zkConn.Children(membersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return nil, errors.Wrap(err, "failed to fetch group members")
+	}
+	legacyMembers, _, err := zkConn.Children(a.legacyGroupPath(group, legacyMembersDir))
+	if err != nil && err != zk.ErrNoNode {
+		return nil, errors.Wrap(err, "failed to fetch legacy group members")
+	}
+	return append(members, legacyMembers...), nil
+}
+
+// DescribeGroup returns the generation of a consumer group along with its
+// members and their subscriptions.
+func (a *T) DescribeGroup(group string) (GroupDescription, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return GroupDescription{}, err
+	}
+	membersPath := fmt.Sprintf("%s/consumers/%s/ids", a.cfg.ZooKeeper.Chroot, group)
+	members, stat, err := zkConn.Children(membersPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return GroupDescription{}, ErrInvalidParam(errors.Errorf("unknown consumer group %v", group))
+		}
+		return GroupDescription{}, errors.Wrap(err, "failed to fetch group members")
+	}
+	gd := GroupDescription{
+		Generation: stat.Cversion,
+		Members:    make(map[string][]string, len(members)),
+	}
+	for _, member := range members {
+		memberSpecJSON, _, err := zkConn.Get(fmt.Sprintf("%s/%s", membersPath, member))
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return GroupDescription{}, errors.Wrapf(err, "failed to fetch member %s", member)
+		}
+		var memberSpec struct {
+			Subscription map[string]int `json:"subscription"`
+		}
+		if err := json.Unmarshal(memberSpecJSON, &memberSpec); err != nil {
+			return GroupDescription{}, errors.Wrapf(err, "invalid member %s, data=%s", member, memberSpecJSON)
+		}
+		topics := make([]string, 0, len(memberSpec.Subscription))
+		for topic := range memberSpec.Subscription {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		gd.Members[member] = topics
+	}
+	return gd, nil
+}
+
+// GetGroupPartitionOwners returns topic -> partition-owners-list mapping for
+// all topics consumed by a consumer group. Partition owners are sorted by
+// partition.
+func (a *T) GetGroupPartitionOwners(group string) (map[string][]PartitionOwner, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	groupPath := fmt.Sprintf("%s/consumers/%s", a.cfg.ZooKeeper.Chroot, group)
+	if _, _, err := zkConn.Children(groupPath); err != nil {
+		if err == zk.ErrNoNode {
+			return nil, ErrInvalidParam(errors.Errorf("unknown consumer group %v", group))
+		}
+		return nil, errors.Wrap(err, "failed to fetch group data")
+	}
+	ownersPath := groupPath + "/owners"
+	topics, _, err := zkConn.Children(ownersPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return map[string][]PartitionOwner{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to fetch consumed topics")
+	}
+	owners := make(map[string][]PartitionOwner, len(topics))
+	for _, topic := range topics {
+		topicOwnersPath := fmt.Sprintf("%s/%s", ownersPath, topic)
+		partitionNodes, _, err := zkConn.Children(topicOwnersPath)
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to fetch partition owners, topic=%s", topic)
+		}
+		var topicOwners []PartitionOwner
+		for _, partitionNode := range partitionNodes {
+			partition, err := strconv.Atoi(partitionNode)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid partition id, %s", partitionNode)
+			}
+			owner, stat, err := zkConn.Get(fmt.Sprintf("%s/%s", topicOwnersPath, partitionNode))
+			if err != nil {
+				// The partition could have been released in the meantime.
+				if err == zk.ErrNoNode {
+					continue
+				}
+				return nil, errors.Wrapf(err, "failed to fetch partition owner, topic=%s, partition=%d", topic, partition)
+			}
+			topicOwners = append(topicOwners, PartitionOwner{
+				Partition: int32(partition),
+				Owner:     string(owner),
+				ClaimedAt: time.Unix(0, stat.Ctime*int64(time.Millisecond)).UTC(),
+			})
+		}
+		if len(topicOwners) == 0 {
+			continue
+		}
+		sort.Slice(topicOwners, func(i, j int) bool { return topicOwners[i].Partition < topicOwners[j].Partition })
+		owners[topic] = topicOwners
+	}
+	return owners, nil
+}
+
+// DeleteGroup deletes offsets committed by a consumer group to Kafka and the
+// group registration in ZooKeeper. It fails if the group has members.
+func (a *T) DeleteGroup(group string) error {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return err
+	}
+	groupPath := fmt.Sprintf("%s/consumers/%s", a.cfg.ZooKeeper.Chroot, group)
+	// The members znode is deleted first, for it cannot be deleted while
+	// there are members registered under it.
+	if err := zkConn.Delete(groupPath+"/ids", -1); err != nil && err != zk.ErrNoNode {
+		return errors.Wrap(err, "failed to delete group members")
+	}
+
+	clusterAdmin, err := sarama.NewClusterAdmin(a.cfg.Kafka.SeedPeers, a.cfg.SaramaClientCfg())
+	if err != nil {
+		return errors.Wrap(err, "failed to create sarama.ClusterAdmin")
+	}
+	defer clusterAdmin.Close()
+	if err = clusterAdmin.DeleteConsumerGroup(group); err != nil && err != sarama.ErrGroupIDNotFound {
+		return errors.Wrap(err, "failed to delete offsets")
+	}
+
+	if err := deleteZNode(zkConn, groupPath); err != nil {
+		return errors.Wrap(err, "failed to delete group registration")
+	}
+	return nil
+}
+
+// deleteZNode deletes a znode along with all its descendants. If a child is
+// created concurrently, then deletion fails with zk.ErrNotEmpty.
+func deleteZNode(zkConn *zk.Conn, path string) error {
+	children, _, err := zkConn.Children(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return err
+	}
+	for _, child := range children {
+		if err := deleteZNode(zkConn, path+"/"+child); err != nil {
+			return err
+		}
+	}
+	if err := zkConn.Delete(path, -1); err != nil && err != zk.ErrNoNode {
+		return errors.Wrapf(err, "failed to delete %s", path)
+	}
+	return nil
+}
+
+// PeekMessages returns up to `count` most recent messages of a topic
+// partition. Messages are read directly from Kafka without involving any
+// consumer group, so no offsets are committed. If not all messages could be
+// read within the long polling timeout, then the messages read so far are
+// returned.
+func (a *T) PeekMessages(topic string, partition int32, count int) ([]Message, error) {
+	if count <= 0 {
+		return nil, ErrInvalidParam(errors.Errorf("invalid message count: %d", count))
+	}
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	begin, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get oldest offset")
+	}
+	end, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get newest offset")
+	}
+	start := end - int64(count)
+	if start < begin {
+		start = begin
+	}
+	messages := make([]Message, 0, end-start)
+	if start >= end {
+		return messages, nil
+	}
+
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consumer")
+	}
+	defer kafkaConsumer.Close()
+	partitionConsumer, err := kafkaConsumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to consume partition")
+	}
+	defer partitionConsumer.Close()
+
+	timeoutCh := time.After(a.cfg.Consumer.LongPollingTimeout)
+	for {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			messages = append(messages, Message{
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Timestamp: msg.Timestamp,
+				Headers:   msg.Headers,
+			})
+			if msg.Offset >= end-1 {
+				return messages, nil
+			}
+		case <-timeoutCh:
+			return messages, nil
+		}
+	}
+}
+
+// FetchMessages returns up to `limit` messages of a topic partition starting
+// from `offset`. Messages are read
+// directly from Kafka without involving any consumer group. If there are no
+// messages at the offset yet, then it waits for the first one for up to the
+// long polling timeout, and returns an empty list if none arrives.
+func (a *T) FetchMessages(topic string, partition int32, offset int64, limit int) ([]Message, error) {
+	if limit <= 0 {
+		return nil, ErrInvalidParam(errors.Errorf("invalid message limit: %d", limit))
+	}
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return nil, err
+	}
+	begin, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get oldest offset")
+	}
+	end, err := kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get newest offset")
+	}
+	if offset < begin || offset > end {
+		return nil, ErrInvalidParam(errors.Errorf("offset %d is out of range [%d, %d]", offset, begin, end))
+	}
+
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consumer")
+	}
+	defer kafkaConsumer.Close()
+	partitionConsumer, err := kafkaConsumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to consume partition")
+	}
+	defer partitionConsumer.Close()
+
+	messages := make([]Message, 0, limit)
+	timeoutCh := time.After(a.cfg.Consumer.LongPollingTimeout)
+	for len(messages) < limit {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			messages = append(messages, Message{
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Timestamp: msg.Timestamp,
+				Headers:   msg.Headers,
+			})
+			// Do not wait for messages produced after the request started.
+			if msg.Offset >= end-1 {
+				return messages, nil
+			}
+		case <-timeoutCh:
+			return messages, nil
+		}
+	}
+	return messages, nil
+}
+
+// CreateTopic creates a topic with the specified number of partitions,
+// replication factor and config overrides. It is not an error if the topic
+// already exists.
+func (a *T) CreateTopic(topic string, detail *sarama.TopicDetail) error {
+	clusterAdmin, err := sarama.NewClusterAdmin(a.cfg.Kafka.SeedPeers, a.cfg.SaramaClientCfg())
+	if err != nil {
+		return errors.Wrap(err, "failed to create sarama.ClusterAdmin")
+	}
+	defer clusterAdmin.Close()
+
+	err = clusterAdmin.CreateTopic(topic, detail, false)
+	if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
+		return nil
+	}
+	return err
+}
+
 func (a *T) lazyKafkaClt() (sarama.Client, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -327,12 +858,38 @@ func (a *T) lazyKafkaClt() (sarama.Client, error) {
 	return a.kafkaClt, nil
 }
 
+// lazyClusterAdmin returns a cluster admin that is shared by requests that
+// are made often, e.g. describing topic configs on produce, so that every one
+// of them does not have to connect to the brokers anew.
+func (a *T) lazyClusterAdmin() (sarama.ClusterAdmin, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.clusterAdmin == nil {
+		var err error
+		if a.clusterAdmin, err = sarama.NewClusterAdmin(a.cfg.Kafka.SeedPeers, a.cfg.SaramaClientCfg()); err != nil {
+			return nil, errors.Wrap(err, "failed to create sarama.ClusterAdmin")
+		}
+	}
+	return a.clusterAdmin, nil
+}
+
+// resetClusterAdmin closes the shared cluster admin, if it is still the
+// given one, so that the next request connects anew.
+func (a *T) resetClusterAdmin(clusterAdmin sarama.ClusterAdmin) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.clusterAdmin == clusterAdmin {
+		a.clusterAdmin.Close()
+		a.clusterAdmin = nil
+	}
+}
+
 func (a *T) lazyZKConn() (*zk.Conn, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 	if a.zkConn == nil {
 		var err error
-		if a.zkConn, _, err = zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second); err != nil {
+		if a.zkConn, _, err = zkconn.Connect(a.parentActDesc.NewChild("admin"), a.cfg, 1*time.Second); err != nil {
 			return nil, errors.Wrap(err, "failed to create zk.Conn")
 		}
 	}
@@ -354,6 +911,7 @@ func getOffsetResult(res *sarama.OffsetResponse, topic string, partition int32)
 }
 
 // ListTopics returns a list of all topics existing in the Kafka cluster.
+// Metadata is refreshed if it is older than `kafka.metadata_cache_ttl`.
 func (a *T) ListTopics(withPartitions, withConfig bool) ([]TopicMetadata, error) {
 	kafkaClt, err := a.lazyKafkaClt()
 	if err != nil {
@@ -361,9 +919,8 @@ func (a *T) ListTopics(withPartitions, withConfig bool) ([]TopicMetadata, error)
 	}
 
 	// Refresh metadata as topic metadata may have updated recently
-	err = kafkaClt.RefreshMetadata()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to refresh metadata")
+	if err = a.refreshMetadata(kafkaClt, false); err != nil {
+		return nil, err
 	}
 
 	topics, err := kafkaClt.Topics()
@@ -425,18 +982,9 @@ func (a *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (Top
 		}
 	}
 	if withConfig {
-		kzConn, err := a.lazyZKConn()
-		if err != nil {
-			return TopicMetadata{}, errors.Wrap(err, "failed to connect to zookeeper")
-		}
-		cfgPath := fmt.Sprintf("%s/config/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
-		cfg, _, err := kzConn.Get(cfgPath)
+		topicConfig, err := a.getTopicConfig(topic)
 		if err != nil {
-			return TopicMetadata{}, errors.Wrap(err, "failed to fetch topic configuration")
-		}
-		topicConfig := TopicConfig{}
-		if err = json.Unmarshal(cfg, &topicConfig); err != nil {
-			return TopicMetadata{}, errors.Wrap(err, "bad config")
+			return TopicMetadata{}, err
 		}
 		tm.Config = &topicConfig
 	}
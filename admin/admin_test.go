@@ -110,3 +110,41 @@ func (s *AdminSuite) TestSetOffsetsPartialUpdate(c *C) {
 
 	a.Stop()
 }
+
+// A group has consumed a partition up to an offset if it committed the same
+// or a greater offset, otherwise the difference is the remaining lag.
+func (s *AdminSuite) TestGetGroupProgress(c *C) {
+	a, err := Spawn(s.ns, s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	s.kh.PutMessages("progress", "test.4", map[string]int{"A": 3})
+	offsets, err := a.GetTopicOffsets("test.4")
+	c.Assert(err, IsNil)
+	partition, end := offsets[0].Partition, offsets[0].End
+	c.Assert(a.SetGroupOffsets("foo", "test.4", []PartitionOffset{
+		{Partition: partition, Offset: end - 2},
+	}), IsNil)
+
+	for i, tc := range []struct {
+		target  int64
+		reached bool
+		lag     int64
+	}{
+		{target: end - 3, reached: true, lag: 0},
+		{target: end - 2, reached: true, lag: 0},
+		{target: end, reached: false, lag: 2},
+	} {
+		// When
+		progress, err := a.GetGroupProgress("foo", "test.4", ProgressTarget{Partition: partition, Offset: tc.target})
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(progress, DeepEquals, Progress{
+			Reached: tc.reached,
+			Lag:     tc.lag,
+			Partitions: []PartitionProgress{
+				{Partition: partition, Offset: end - 2, Target: tc.target, Lag: tc.lag},
+			},
+		}, Commentf("case #%d", i))
+	}
+}
@@ -0,0 +1,228 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Older Kafka-Pixy versions, as well as the wvanbergen/kafka consumergroup
+// library, registered group members under `/consumers/<group>/instances`
+// rather than under `/consumers/<group>/ids`, and committed offsets to
+// ZooKeeper under `/consumers/<group>/offsets/<topic>/<partition>` as
+// decimal strings.
+const (
+	legacyMembersDir = "instances"
+	legacyOffsetsDir = "offsets"
+)
+
+// ErrLegacyGroupActive is returned when a legacy consumer group is migrated
+// while members are registered in the legacy layout, for they would keep
+// committing offsets to ZooKeeper.
+var ErrLegacyGroupActive = errors.New("the group has members registered in the legacy layout")
+
+// LegacyMigration describes a migration of offsets committed by a consumer
+// group to ZooKeeper in the legacy layout.
+type LegacyMigration struct {
+	MigratedAt time.Time `json:"migrated_at"`
+
+	// Offsets copied from ZooKeeper mapped to topics. Partitions that the
+	// group had offsets committed for already are not included.
+	Copied map[string][]PartitionOffset `json:"copied"`
+}
+
+// ListLegacyGroups returns a sorted list of consumer groups that have
+// offsets committed to ZooKeeper in the legacy layout and have not been
+// migrated yet.
+func (a *T) ListLegacyGroups() ([]string, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	migrated, _, err := zkConn.Children(a.legacyMigrationsPath())
+	if err != nil && err != zk.ErrNoNode {
+		return nil, errors.Wrap(err, "failed to fetch legacy migrations")
+	}
+	isMigrated := make(map[string]bool, len(migrated))
+	for _, group := range migrated {
+		isMigrated[group] = true
+	}
+	var legacyGroups []string
+	for _, group := range groups {
+		if isMigrated[group] {
+			continue
+		}
+		exists, _, err := zkConn.Exists(a.legacyGroupPath(group, legacyOffsetsDir))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check legacy offsets, group=%s", group)
+		}
+		if exists {
+			legacyGroups = append(legacyGroups, group)
+		}
+	}
+	return legacyGroups, nil
+}
+
+// GetLegacyGroupOffsets returns offsets committed by a consumer group to
+// ZooKeeper in the legacy layout, mapped to topics and sorted by partition.
+func (a *T) GetLegacyGroupOffsets(group string) (map[string][]PartitionOffset, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return nil, err
+	}
+	offsetsPath := a.legacyGroupPath(group, legacyOffsetsDir)
+	topics, _, err := zkConn.Children(offsetsPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return map[string][]PartitionOffset{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to fetch legacy offset topics")
+	}
+	offsets := make(map[string][]PartitionOffset, len(topics))
+	for _, topic := range topics {
+		topicPath := offsetsPath + "/" + topic
+		partitions, _, err := zkConn.Children(topicPath)
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to fetch legacy offsets, topic=%s", topic)
+		}
+		topicOffsets := make([]PartitionOffset, 0, len(partitions))
+		for _, partitionStr := range partitions {
+			partition, err := strconv.ParseInt(partitionStr, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("bad legacy partition %s/%s", topicPath, partitionStr)
+			}
+			data, _, err := zkConn.Get(topicPath + "/" + partitionStr)
+			if err != nil {
+				if err == zk.ErrNoNode {
+					continue
+				}
+				return nil, errors.Wrapf(err, "failed to get legacy offset, topic=%s, partition=%d", topic, partition)
+			}
+			offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("bad legacy offset %s/%s: %q", topicPath, partitionStr, data)
+			}
+			topicOffsets = append(topicOffsets, PartitionOffset{Partition: int32(partition), Offset: offset})
+		}
+		sort.Slice(topicOffsets, func(i, j int) bool {
+			return topicOffsets[i].Partition < topicOffsets[j].Partition
+		})
+		offsets[topic] = topicOffsets
+	}
+	return offsets, nil
+}
+
+// MigrateLegacyGroup copies offsets committed by a consumer group to
+// ZooKeeper in the legacy layout to where the group commits offsets now,
+// and records the migration so that it is done only once. Partitions that
+// the group has offsets committed for already are skipped, for these offsets
+// are more recent than the legacy ones. It fails with ErrLegacyGroupActive
+// if the group has members registered in the legacy layout.
+func (a *T) MigrateLegacyGroup(group string) (LegacyMigration, error) {
+	zkConn, err := a.lazyZKConn()
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+	legacyMembers, _, err := zkConn.Children(a.legacyGroupPath(group, legacyMembersDir))
+	if err != nil && err != zk.ErrNoNode {
+		return LegacyMigration{}, errors.Wrap(err, "failed to fetch legacy members")
+	}
+	if len(legacyMembers) > 0 {
+		return LegacyMigration{}, ErrLegacyGroupActive
+	}
+	legacyOffsets, err := a.GetLegacyGroupOffsets(group)
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+	migration := LegacyMigration{Copied: make(map[string][]PartitionOffset, len(legacyOffsets))}
+	for topic, topicLegacyOffsets := range legacyOffsets {
+		offsets, err := a.GetGroupOffsets(group, topic)
+		if err != nil {
+			return LegacyMigration{}, errors.Wrapf(err, "failed to get offsets, topic=%s", topic)
+		}
+		committed := make(map[int32]bool, len(offsets))
+		for _, po := range offsets {
+			committed[po.Partition] = po.Offset >= 0
+		}
+		var copied []PartitionOffset
+		for _, po := range topicLegacyOffsets {
+			if po.Offset < 0 || committed[po.Partition] {
+				continue
+			}
+			copied = append(copied, po)
+		}
+		if len(copied) == 0 {
+			continue
+		}
+		if err := a.SetGroupOffsets(group, topic, copied); err != nil {
+			return LegacyMigration{}, errors.Wrapf(err, "failed to set offsets, topic=%s", topic)
+		}
+		migration.Copied[topic] = copied
+	}
+	migration.MigratedAt = time.Now().UTC()
+	if err := a.recordLegacyMigration(zkConn, group, migration); err != nil {
+		return LegacyMigration{}, err
+	}
+	return migration, nil
+}
+
+// recordLegacyMigration creates a znode named after the group in the legacy
+// migration directory, creating the directory if it does not exist.
+func (a *T) recordLegacyMigration(zkConn *zk.Conn, group string, migration LegacyMigration) error {
+	data, _ := json.Marshal(migration)
+	dir := a.legacyMigrationsPath()
+	path := dir + "/" + group
+	for {
+		_, err := zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+		switch err {
+		case nil:
+			return nil
+		case zk.ErrNoNode:
+			if err := createZNodePath(zkConn, dir); err != nil {
+				return err
+			}
+			continue
+		case zk.ErrNodeExists:
+			if _, err := zkConn.Set(path, data, -1); err != nil {
+				return errors.Wrapf(err, "failed to update %s", path)
+			}
+			return nil
+		default:
+			return errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+}
+
+func (a *T) legacyGroupPath(group, dir string) string {
+	return fmt.Sprintf("%s/consumers/%s/%s", a.cfg.ZooKeeper.Chroot, group, dir)
+}
+
+func (a *T) legacyMigrationsPath() string {
+	return fmt.Sprintf("%s/kafka-pixy/legacy_migrations", a.cfg.ZooKeeper.Chroot)
+}
+
+// createZNodePath creates a znode along with all its ancestors.
+func createZNodePath(zkConn *zk.Conn, path string) error {
+	current := ""
+	for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		current += "/" + name
+		_, err := zkConn.Create(current, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "failed to create %s", current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// metadataCache keeps track of when metadata of all topics was last
+// refreshed, and caches topic configurations, so that listings within the
+// configured TTL are served without hitting Kafka and ZooKeeper.
+type metadataCache struct {
+	mu             sync.Mutex
+	refreshedAt    time.Time
+	topicConfigs   map[string]cachedTopicConfig
+	effectiveConfs map[string]cachedConfigEntries
+}
+
+type cachedTopicConfig struct {
+	config    TopicConfig
+	fetchedAt time.Time
+}
+
+type cachedConfigEntries struct {
+	entries   []ConfigEntry
+	fetchedAt time.Time
+}
+
+// ConfigEntry is a topic configuration parameter as it is in effect on the
+// brokers, whether it is overridden for the topic or not.
+type ConfigEntry struct {
+	Name      string
+	Value     string
+	Default   bool
+	ReadOnly  bool
+	Sensitive bool
+}
+
+// RefreshMetadata makes the Kafka client fetch metadata of all topics and
+// drops cached topic configurations regardless of the cache TTL.
+func (a *T) RefreshMetadata() error {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return err
+	}
+	a.metadataCache.mu.Lock()
+	a.metadataCache.topicConfigs = nil
+	a.metadataCache.effectiveConfs = nil
+	a.metadataCache.mu.Unlock()
+	return a.refreshMetadata(kafkaClt, true)
+}
+
+// refreshMetadata makes the Kafka client fetch metadata of all topics,
+// unless it was done within the cache TTL and force is not set.
+func (a *T) refreshMetadata(kafkaClt sarama.Client, force bool) error {
+	a.metadataCache.mu.Lock()
+	defer a.metadataCache.mu.Unlock()
+	if !force && time.Since(a.metadataCache.refreshedAt) < a.cfg.Kafka.MetadataCacheTTL {
+		return nil
+	}
+	if err := kafkaClt.RefreshMetadata(); err != nil {
+		return errors.Wrap(err, "failed to refresh metadata")
+	}
+	a.metadataCache.refreshedAt = time.Now()
+	return nil
+}
+
+// resetMetadataCache makes the next listing refresh metadata.
+func (a *T) resetMetadataCache() {
+	a.metadataCache.mu.Lock()
+	a.metadataCache.refreshedAt = time.Time{}
+	a.metadataCache.topicConfigs = nil
+	a.metadataCache.effectiveConfs = nil
+	a.metadataCache.mu.Unlock()
+}
+
+// getTopicConfig returns a topic configuration stored in ZooKeeper, or a
+// cached copy of it if it was fetched within the cache TTL.
+func (a *T) getTopicConfig(topic string) (TopicConfig, error) {
+	a.metadataCache.mu.Lock()
+	cached, ok := a.metadataCache.topicConfigs[topic]
+	a.metadataCache.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < a.cfg.Kafka.MetadataCacheTTL {
+		return cached.config, nil
+	}
+
+	kzConn, err := a.lazyZKConn()
+	if err != nil {
+		return TopicConfig{}, errors.Wrap(err, "failed to connect to zookeeper")
+	}
+	cfgPath := fmt.Sprintf("%s/config/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+	cfg, _, err := kzConn.Get(cfgPath)
+	if err != nil {
+		return TopicConfig{}, errors.Wrap(err, "failed to fetch topic configuration")
+	}
+	topicConfig := TopicConfig{}
+	if err = json.Unmarshal(cfg, &topicConfig); err != nil {
+		return TopicConfig{}, errors.Wrap(err, "bad config")
+	}
+
+	if a.cfg.Kafka.MetadataCacheTTL > 0 {
+		a.metadataCache.mu.Lock()
+		if a.metadataCache.topicConfigs == nil {
+			a.metadataCache.topicConfigs = make(map[string]cachedTopicConfig)
+		}
+		a.metadataCache.topicConfigs[topic] = cachedTopicConfig{config: topicConfig, fetchedAt: time.Now()}
+		a.metadataCache.mu.Unlock()
+	}
+	return topicConfig, nil
+}
+
+// DescribeTopicConfig returns the effective configuration of a topic as
+// reported by the brokers via DescribeConfigs, that is topic overrides along
+// with broker defaults, sorted by name. Entries are cached within the
+// metadata cache TTL. Requires Kafka 0.11+.
+func (a *T) DescribeTopicConfig(topic string) ([]ConfigEntry, error) {
+	a.metadataCache.mu.Lock()
+	cached, ok := a.metadataCache.effectiveConfs[topic]
+	a.metadataCache.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < a.cfg.Kafka.MetadataCacheTTL {
+		return cached.entries, nil
+	}
+
+	clusterAdmin, err := a.lazyClusterAdmin()
+	if err != nil {
+		return nil, err
+	}
+	saramaEntries, err := clusterAdmin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+	if err != nil {
+		// sarama does not tell errors reported by brokers from connection
+		// failures, so the next request connects anew just in case.
+		a.resetClusterAdmin(clusterAdmin)
+		return nil, errors.Wrap(err, "failed to describe topic configuration")
+	}
+	// Brokers report an error without a message for unknown topics, and
+	// sarama returns no entries in that case.
+	if len(saramaEntries) == 0 {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	entries := make([]ConfigEntry, len(saramaEntries))
+	for i, se := range saramaEntries {
+		entries[i] = ConfigEntry{
+			Name:      se.Name,
+			Value:     se.Value,
+			Default:   se.Default,
+			ReadOnly:  se.ReadOnly,
+			Sensitive: se.Sensitive,
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	if a.cfg.Kafka.MetadataCacheTTL > 0 {
+		a.metadataCache.mu.Lock()
+		if a.metadataCache.effectiveConfs == nil {
+			a.metadataCache.effectiveConfs = make(map[string]cachedConfigEntries)
+		}
+		a.metadataCache.effectiveConfs[topic] = cachedConfigEntries{entries: entries, fetchedAt: time.Now()}
+		a.metadataCache.mu.Unlock()
+	}
+	return entries, nil
+}
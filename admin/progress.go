@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// ProgressTarget is a point in a topic that a consumer group is checked to
+// have consumed up to. If Timestamp is not zero, then the group has to have
+// consumed all messages with earlier timestamps in every partition, otherwise
+// all messages before Offset in Partition.
+type ProgressTarget struct {
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+// PartitionProgress describes how far a consumer group has consumed a
+// partition relative to a target offset.
+type PartitionProgress struct {
+	Partition int32
+	// The offset committed by the group, negative if there is none.
+	Offset int64
+	// The offset of the first message that does not have to be consumed.
+	Target int64
+	// The number of messages before the target yet to be consumed.
+	Lag int64
+}
+
+// Progress tells whether a consumer group has consumed a topic up to a
+// target, and how many messages it has yet to consume to get there.
+type Progress struct {
+	Reached    bool
+	Lag        int64
+	Partitions []PartitionProgress
+}
+
+// GetGroupProgress checks whether a consumer group has consumed a topic up
+// to the specified target. A partition that the group has not committed an
+// offset for has not been consumed at all. Messages removed by retention do
+// not count as lag, and an offset target beyond the end of a partition is
+// only reached when the group consumes messages up to it.
+func (a *T) GetGroupProgress(group, topic string, target ProgressTarget) (Progress, error) {
+	offsets, err := a.GetGroupOffsets(group, topic)
+	if err != nil {
+		return Progress{}, err
+	}
+	progress := Progress{Reached: true}
+	for _, po := range offsets {
+		if target.Timestamp.IsZero() && po.Partition != target.Partition {
+			continue
+		}
+		pp := PartitionProgress{Partition: po.Partition, Offset: po.Offset, Target: target.Offset}
+		if !target.Timestamp.IsZero() {
+			if pp.Target, err = a.getOffsetByTime(topic, po.Partition, target.Timestamp); err != nil {
+				return Progress{}, errors.Wrapf(err, "failed to get offset by time, partition=%d", po.Partition)
+			}
+		}
+		consumed := po.Offset
+		if consumed < po.Begin {
+			consumed = po.Begin
+		}
+		if pp.Target > consumed {
+			pp.Lag = pp.Target - consumed
+		}
+		if pp.Lag > 0 {
+			progress.Reached = false
+		}
+		progress.Lag += pp.Lag
+		progress.Partitions = append(progress.Partitions, pp)
+	}
+	if progress.Partitions == nil {
+		return Progress{}, errors.Wrapf(sarama.ErrUnknownTopicOrPartition, "partition=%d", target.Partition)
+	}
+	return progress, nil
+}
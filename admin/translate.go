@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// OffsetTranslation describes how an offset committed by a consumer group for
+// a partition in one cluster is translated to an offset in another cluster.
+type OffsetTranslation struct {
+	Partition    int32
+	SourceOffset int64
+	// Timestamp of the message at the source offset. It is zero if the
+	// source offset is at the end of the partition.
+	Timestamp    time.Time
+	TargetOffset int64
+}
+
+// TranslateGroupOffsets maps offsets committed by a consumer group for a topic
+// in the source cluster to offsets of the same topic in the target cluster,
+// that the topic is mirrored to, and commits them on behalf of the same group
+// in the target cluster. An offset is mapped by the timestamp of the message
+// that the group is to consume next, to the offset of the first message in
+// the same partition of the target cluster with the same or later timestamp.
+// Partitions that the group has not committed offsets for are skipped.
+func TranslateGroupOffsets(src, dst *T, group, topic string) ([]OffsetTranslation, error) {
+	srcOffsets, err := src.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get source offsets")
+	}
+	dstOffsets, err := dst.GetTopicOffsets(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get target offsets")
+	}
+	dstEnds := make(map[int32]int64, len(dstOffsets))
+	for _, po := range dstOffsets {
+		dstEnds[po.Partition] = po.End
+	}
+
+	var translations []OffsetTranslation
+	for _, po := range srcOffsets {
+		if po.Offset < 0 {
+			continue
+		}
+		dstEnd, ok := dstEnds[po.Partition]
+		if !ok {
+			return nil, errors.Errorf("partition is missing in target cluster, partition=%d", po.Partition)
+		}
+		ot := OffsetTranslation{Partition: po.Partition, SourceOffset: po.Offset, TargetOffset: dstEnd}
+		if po.Offset < po.End {
+			// If the committed offset has expired, then the group is to
+			// consume the oldest message next.
+			offset := po.Offset
+			if offset < po.Begin {
+				offset = po.Begin
+			}
+			if ot.Timestamp, err = src.getMessageTimestamp(topic, po.Partition, offset); err != nil {
+				return nil, errors.Wrapf(err, "failed to get timestamp, partition=%d", po.Partition)
+			}
+			if ot.TargetOffset, err = dst.getOffsetByTime(topic, po.Partition, ot.Timestamp); err != nil {
+				return nil, errors.Wrapf(err, "failed to get offset by time, partition=%d", po.Partition)
+			}
+		}
+		translations = append(translations, ot)
+	}
+
+	if len(translations) == 0 {
+		return translations, nil
+	}
+	offsets := make([]PartitionOffset, len(translations))
+	for i, ot := range translations {
+		offsets[i] = PartitionOffset{Partition: ot.Partition, Offset: ot.TargetOffset}
+	}
+	if err := dst.SetGroupOffsets(group, topic, offsets); err != nil {
+		return nil, errors.Wrap(err, "failed to set target offsets")
+	}
+	return translations, nil
+}
+
+// getMessageTimestamp returns the timestamp of the message at the specified
+// offset, or of the first message after it if the offset has been compacted
+// away.
+func (a *T) getMessageTimestamp(topic string, partition int32, offset int64) (time.Time, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return time.Time{}, err
+	}
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to create consumer")
+	}
+	defer kafkaConsumer.Close()
+	partitionConsumer, err := kafkaConsumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to consume partition")
+	}
+	defer partitionConsumer.Close()
+
+	select {
+	case msg := <-partitionConsumer.Messages():
+		if msg.Timestamp.IsZero() {
+			return time.Time{}, errors.Errorf("message has no timestamp, offset=%d", msg.Offset)
+		}
+		return msg.Timestamp, nil
+	case <-time.After(a.cfg.Consumer.LongPollingTimeout):
+		return time.Time{}, errors.Errorf("timeout reading message, offset=%d", offset)
+	}
+}
+
+// getOffsetByTime returns the offset of the first message with the same or
+// later timestamp than the specified one. If there is no such message, then
+// the newest offset is returned.
+func (a *T) getOffsetByTime(topic string, partition int32, timestamp time.Time) (int64, error) {
+	kafkaClt, err := a.lazyKafkaClt()
+	if err != nil {
+		return 0, err
+	}
+	offset, err := kafkaClt.GetOffset(topic, partition, timestamp.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return kafkaClt.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+	return offset, nil
+}
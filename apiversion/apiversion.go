@@ -0,0 +1,95 @@
+// Package apiversion negotiates the Kafka protocol level to use with a
+// cluster. Brokers 0.10.0.0 and later report the versions of API requests
+// they support in response to ApiVersionsRequest, and the highest Kafka
+// version whose requests are all supported is picked.
+package apiversion
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// API keys as defined by the Kafka protocol.
+const (
+	apiKeyFetch       = 1
+	apiKeyOffsetFetch = 9
+)
+
+// requirements lists Kafka versions in descending order along with the
+// maximum version of an API request that was introduced by them.
+var requirements = []struct {
+	version       sarama.KafkaVersion
+	apiKey        int16
+	minMaxVersion int16
+}{
+	{sarama.V2_3_0_0, apiKeyFetch, 11},
+	{sarama.V2_1_0_0, apiKeyFetch, 10},
+	{sarama.V2_0_0_0, apiKeyFetch, 8},
+	{sarama.V1_1_0_0, apiKeyFetch, 7},
+	{sarama.V1_0_0_0, apiKeyFetch, 6},
+	{sarama.V0_11_0_0, apiKeyFetch, 5},
+	{sarama.V0_10_2_0, apiKeyOffsetFetch, 2},
+	{sarama.V0_10_1_0, apiKeyFetch, 3},
+}
+
+// Negotiate asks seed brokers one by one for the versions of API requests
+// they support until one of them responds, and returns the highest Kafka
+// version that the responding broker supports. An error is returned if none
+// of the brokers responds, that is also the case with brokers older than
+// 0.10.0.0, for they do not support ApiVersionsRequest.
+func Negotiate(seedPeers []string, saramaCfg *sarama.Config) (sarama.KafkaVersion, error) {
+	var lastErr error = errors.New("no seed peers")
+	for _, addr := range seedPeers {
+		version, err := Supported(addr, saramaCfg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return version, nil
+	}
+	return sarama.KafkaVersion{}, lastErr
+}
+
+// Supported returns the highest Kafka version that a broker supports.
+func Supported(addr string, saramaCfg *sarama.Config) (sarama.KafkaVersion, error) {
+	// Sarama refuses to send requests that the configured version does not
+	// support, and ApiVersionsRequest was introduced in 0.10.0.0.
+	probeCfg := *saramaCfg
+	probeCfg.Version = sarama.V0_10_0_0
+	apiVersions, err := fetchAPIVersions(addr, &probeCfg)
+	if err != nil {
+		return sarama.KafkaVersion{}, errors.Wrapf(err, "failed to get API versions, addr=%s", addr)
+	}
+	return FromAPIVersions(apiVersions), nil
+}
+
+// FromAPIVersions returns the highest Kafka version whose API requests are
+// supported according to an ApiVersionsResponse.
+func FromAPIVersions(apiVersions []*sarama.ApiVersionsResponseBlock) sarama.KafkaVersion {
+	maxVersions := make(map[int16]int16, len(apiVersions))
+	for _, block := range apiVersions {
+		maxVersions[block.ApiKey] = block.MaxVersion
+	}
+	for _, rq := range requirements {
+		if maxVersion, ok := maxVersions[rq.apiKey]; ok && maxVersion >= rq.minMaxVersion {
+			return rq.version
+		}
+	}
+	return sarama.V0_10_0_0
+}
+
+func fetchAPIVersions(addr string, saramaCfg *sarama.Config) ([]*sarama.ApiVersionsResponseBlock, error) {
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(saramaCfg); err != nil {
+		return nil, err
+	}
+	defer broker.Close()
+	res, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if res.Err != sarama.ErrNoError {
+		return nil, res.Err
+	}
+	return res.ApiVersions, nil
+}
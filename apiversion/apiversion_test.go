@@ -0,0 +1,77 @@
+package apiversion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type APIVersionSuite struct{}
+
+var _ = Suite(&APIVersionSuite{})
+
+func (s *APIVersionSuite) TestFromAPIVersions(c *C) {
+	for i, tc := range []struct {
+		fetch       int16
+		offsetFetch int16
+		version     sarama.KafkaVersion
+	}{
+		{fetch: 2, offsetFetch: 1, version: sarama.V0_10_0_0},
+		{fetch: 3, offsetFetch: 1, version: sarama.V0_10_1_0},
+		{fetch: 3, offsetFetch: 2, version: sarama.V0_10_2_0},
+		{fetch: 5, offsetFetch: 3, version: sarama.V0_11_0_0},
+		{fetch: 6, offsetFetch: 3, version: sarama.V1_0_0_0},
+		{fetch: 7, offsetFetch: 3, version: sarama.V1_1_0_0},
+		{fetch: 8, offsetFetch: 4, version: sarama.V2_0_0_0},
+		{fetch: 10, offsetFetch: 5, version: sarama.V2_1_0_0},
+		{fetch: 11, offsetFetch: 6, version: sarama.V2_3_0_0},
+		{fetch: 12, offsetFetch: 7, version: sarama.V2_3_0_0},
+	} {
+		// When
+		version := FromAPIVersions([]*sarama.ApiVersionsResponseBlock{
+			{ApiKey: apiKeyFetch, MaxVersion: tc.fetch},
+			{ApiKey: apiKeyOffsetFetch, MaxVersion: tc.offsetFetch},
+		})
+
+		// Then
+		c.Check(version, Equals, tc.version, Commentf("case #%d", i))
+	}
+}
+
+// Seed brokers are probed until one of them responds.
+func (s *APIVersionSuite) TestNegotiate(c *C) {
+	broker := sarama.NewMockBroker(c, 0)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+			ApiVersions: []*sarama.ApiVersionsResponseBlock{
+				{ApiKey: apiKeyFetch, MaxVersion: 7},
+			},
+		}),
+	})
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Net.DialTimeout = 100 * time.Millisecond
+
+	// When
+	version, err := Negotiate([]string{"127.0.0.1:1", broker.Addr()}, saramaCfg)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(version, Equals, sarama.V1_1_0_0)
+}
+
+func (s *APIVersionSuite) TestNegotiateNoBrokers(c *C) {
+	saramaCfg := sarama.NewConfig()
+
+	// When
+	_, err := Negotiate([]string{"127.0.0.1:1"}, saramaCfg)
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to get API versions, addr=127.0.0.1:1: .*")
+}
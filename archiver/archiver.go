@@ -0,0 +1,453 @@
+// Package archiver archives topics to S3 compatible object storage. An
+// archive consumes a topic and writes messages of every partition to a file
+// of its own, either JSON lines or an Avro object container file. A file is
+// uploaded when it grows large or old enough, and only then the offset that
+// follows the last message in it is committed, hence every message is
+// archived at least once. Files are named after the topic, partition and
+// offset of the first message in them:
+//
+//	<prefix><topic>/<partition>/<first offset, 20 digits>.<format>
+//
+// so that listing a partition directory yields files in offset order.
+//
+// There is no coordination between Kafka-Pixy instances, an archive must be
+// configured on one instance only.
+package archiver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/claimcheck"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxFileSize = 64 << 20
+	defaultMaxFileAge  = 10 * time.Minute
+
+	// How often files are checked for age, and high water marks of
+	// partitions are sampled for lag.
+	tickInterval = time.Second
+
+	offsetFetchVer  = 1
+	offsetCommitVer = 1
+)
+
+// Status is the progress of an archive.
+type Status struct {
+	Name  string
+	Topic string
+
+	// The number of messages in the topic that are not archived yet, that
+	// is either not consumed yet, or consumed but not uploaded yet.
+	Lag int64
+
+	UploadedFiles    int64
+	UploadedMessages int64
+	UploadedAt       time.Time
+
+	// The error the archive last failed with, if any. It is cleared as soon
+	// as a file is uploaded.
+	Err error
+}
+
+// T archives a topic.
+type T struct {
+	actDesc  *actor.Descriptor
+	name     string
+	cfg      config.Archive
+	proxyCfg *config.Proxy
+	store    *claimcheck.Store
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+	now      func() time.Time
+
+	// Creates producers for replays, it is replaced in tests.
+	newProducer func(cfg *config.Proxy) (sarama.AsyncProducer, error)
+
+	mu         sync.Mutex
+	status     Status
+	partitions map[int32]*partitionProgress
+	replays    map[string]*replay
+	replaySeq  int
+}
+
+// partitionProgress is what lag of a partition is calculated from.
+type partitionProgress struct {
+	committed     int64
+	highWaterMark int64
+}
+
+// session writes messages consumed in one Kafka session to files, and
+// uploads them. Files in progress are dropped with the session, and since
+// offsets of messages in them are not committed, the messages are consumed
+// again by the next one.
+type session struct {
+	a      *T
+	commit func(partition int32, offset int64) error
+	files  map[int32]*file
+}
+
+// Spawn creates an archive and starts its goroutines. Kafka and object
+// storage errors do not make it fail, the archive keeps retrying in the
+// background instead.
+func Spawn(parentActDesc *actor.Descriptor, name string, cfg config.Archive, proxyCfg *config.Proxy) *T {
+	a := newArchive(parentActDesc.NewChild("archive", name), name, cfg, proxyCfg)
+	actor.Spawn(a.actDesc, &a.wg, a.run)
+	return a
+}
+
+func newArchive(actDesc *actor.Descriptor, name string, cfg config.Archive, proxyCfg *config.Proxy) *T {
+	if cfg.Group == "" {
+		cfg.Group = name
+	}
+	if cfg.Format == "" {
+		cfg.Format = config.ArchiveFormatJSONL
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = defaultMaxFileSize
+	}
+	if cfg.MaxFileAge <= 0 {
+		cfg.MaxFileAge = defaultMaxFileAge
+	}
+	a := &T{
+		actDesc:  actDesc,
+		name:     name,
+		cfg:      cfg,
+		proxyCfg: proxyCfg,
+		store: claimcheck.New(config.ClaimCheck{
+			Endpoint:        cfg.Endpoint,
+			Region:          cfg.Region,
+			Bucket:          cfg.Bucket,
+			Prefix:          cfg.Prefix,
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Timeout:         cfg.Timeout,
+		}),
+		stopCh:      make(chan none.T),
+		now:         time.Now,
+		newProducer: newProducer,
+		status:      Status{Name: name, Topic: cfg.Topic},
+		partitions:  make(map[int32]*partitionProgress),
+		replays:     make(map[string]*replay),
+	}
+	a.actDesc.ObserveGauge("lag", func() int64 {
+		return a.Status().Lag
+	})
+	a.actDesc.ObserveGauge("uploaded_files", func() int64 {
+		return a.Status().UploadedFiles
+	})
+	return a
+}
+
+// Stop terminates the archive and cancels running replays. Files that are
+// not uploaded yet are dropped, their messages are archived again after
+// restart.
+func (a *T) Stop() {
+	close(a.stopCh)
+	a.wg.Wait()
+}
+
+// Name returns the name of the archive.
+func (a *T) Name() string {
+	return a.name
+}
+
+// Status returns the progress of the archive.
+func (a *T) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := a.status
+	for _, pp := range a.partitions {
+		if pp.highWaterMark > pp.committed {
+			status.Lag += pp.highWaterMark - pp.committed
+		}
+	}
+	return status
+}
+
+// Statuses returns statuses of archives sorted by name.
+func Statuses(archives []*T) []Status {
+	statuses := make([]Status, len(archives))
+	for i, a := range archives {
+		statuses[i] = a.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (a *T) run() {
+	for {
+		err := a.runSession()
+		if err == nil {
+			return
+		}
+		a.mu.Lock()
+		a.status.Err = err
+		a.mu.Unlock()
+		a.actDesc.Log().WithError(err).Errorf("Archive failed, retrying in %v", a.proxyCfg.Consumer.RetryBackoff)
+		select {
+		case <-time.After(a.proxyCfg.Consumer.RetryBackoff):
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// runSession archives messages until either the archive is stopped, in
+// which case nil is returned, or an error occurs.
+func (a *T) runSession() error {
+	kafkaClt, err := sarama.NewClient(a.proxyCfg.Kafka.SeedPeers, a.proxyCfg.SaramaClientCfg())
+	if err != nil {
+		return errors.Wrap(err, "failed to create Kafka client")
+	}
+	defer kafkaClt.Close()
+
+	offsets, err := fetchOffsets(kafkaClt, a.cfg.Topic, a.cfg.Group)
+	if err != nil {
+		return err
+	}
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return errors.Wrap(err, "failed to create consumer")
+	}
+	defer kafkaConsumer.Close()
+
+	msgCh := make(chan *sarama.ConsumerMessage)
+	doneCh := make(chan none.T)
+	var wg sync.WaitGroup
+	defer func() {
+		close(doneCh)
+		wg.Wait()
+	}()
+	a.mu.Lock()
+	a.partitions = make(map[int32]*partitionProgress, len(offsets))
+	a.mu.Unlock()
+	pcs := make(map[int32]sarama.PartitionConsumer, len(offsets))
+	for partition, offset := range offsets {
+		pc, err := a.consumePartition(kafkaClt, kafkaConsumer, partition, offset)
+		if err != nil {
+			return err
+		}
+		pcs[partition] = pc
+		actor.Spawn(a.actDesc.NewChild("p", partition), &wg, func() {
+			forwardMessages(pc, msgCh, doneCh)
+		})
+	}
+	a.actDesc.Log().Infof("Archive started: offsets=%v", offsets)
+
+	s := a.newSession(func(partition int32, offset int64) error {
+		return commitOffset(kafkaClt, a.cfg.Topic, a.cfg.Group, partition, offset)
+	})
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-msgCh:
+			if err := s.add(msg); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			for partition, pc := range pcs {
+				a.setHighWaterMark(partition, pc.HighWaterMarkOffset())
+			}
+			if err := s.uploadExpired(); err != nil {
+				return err
+			}
+		case <-a.stopCh:
+			return nil
+		}
+	}
+}
+
+// consumePartition starts consuming a partition from the committed offset.
+// If there is no committed offset, then the partition is consumed from the
+// oldest available offset, so that nothing that is still in the topic is
+// missed. Messages removed by retention before they were archived are lost.
+func (a *T) consumePartition(kafkaClt sarama.Client, kafkaConsumer sarama.Consumer, partition int32, offset int64,
+) (sarama.PartitionConsumer, error) {
+	newest, err := kafkaClt.GetOffset(a.cfg.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+	}
+	oldest, err := kafkaClt.GetOffset(a.cfg.Topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get oldest offset, partition=%d", partition)
+	}
+	if offset > newest {
+		offset = newest
+	}
+	if offset < oldest {
+		if offset >= 0 {
+			a.actDesc.Log().Warnf("Messages removed by retention before archived: partition=%d, lost=%d",
+				partition, oldest-offset)
+		}
+		offset = oldest
+	}
+	pc, err := kafkaConsumer.ConsumePartition(a.cfg.Topic, partition, offset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to consume partition %d", partition)
+	}
+	a.mu.Lock()
+	a.partitions[partition] = &partitionProgress{committed: offset, highWaterMark: newest}
+	a.mu.Unlock()
+	return pc, nil
+}
+
+func forwardMessages(pc sarama.PartitionConsumer, msgCh chan<- *sarama.ConsumerMessage, doneCh <-chan none.T) {
+	defer pc.Close()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			select {
+			case msgCh <- msg:
+			case <-doneCh:
+				return
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+func (a *T) newSession(commit func(partition int32, offset int64) error) *session {
+	return &session{a: a, commit: commit, files: make(map[int32]*file)}
+}
+
+// add writes a message to the file of its partition, and uploads the file
+// if it has grown large enough.
+func (s *session) add(msg *sarama.ConsumerMessage) error {
+	f := s.files[msg.Partition]
+	if f == nil {
+		f = newFile(s.a.cfg.Format, s.a.now())
+		s.files[msg.Partition] = f
+	}
+	f.add(msg)
+	if f.enc.size() < s.a.cfg.MaxFileSize {
+		return nil
+	}
+	return s.upload(msg.Partition)
+}
+
+// uploadExpired uploads files that were created long enough ago.
+func (s *session) uploadExpired() error {
+	now := s.a.now()
+	for partition, f := range s.files {
+		if now.Sub(f.createdAt) < s.a.cfg.MaxFileAge {
+			continue
+		}
+		if err := s.upload(partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upload uploads the file of a partition and commits the offset following
+// the last message in it.
+func (s *session) upload(partition int32) error {
+	f := s.files[partition]
+	name := objectName(s.a.cfg.Topic, partition, f.firstOffset, s.a.cfg.Format)
+	if _, err := s.a.store.PutObject(name, f.enc.finish()); err != nil {
+		return errors.Wrapf(err, "failed to upload file, partition=%d", partition)
+	}
+	delete(s.files, partition)
+	if err := s.commit(partition, f.lastOffset+1); err != nil {
+		return errors.Wrapf(err, "failed to commit offset, partition=%d", partition)
+	}
+	s.a.actDesc.Log().Infof("File uploaded: name=%s, messages=%d", name, f.count)
+
+	s.a.mu.Lock()
+	defer s.a.mu.Unlock()
+	s.a.status.UploadedFiles++
+	s.a.status.UploadedMessages += int64(f.count)
+	s.a.status.UploadedAt = s.a.now()
+	s.a.status.Err = nil
+	if pp := s.a.partitions[partition]; pp != nil {
+		pp.committed = f.lastOffset + 1
+		if pp.highWaterMark < pp.committed {
+			pp.highWaterMark = pp.committed
+		}
+	}
+	return nil
+}
+
+func (a *T) setHighWaterMark(partition int32, highWaterMark int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pp := a.partitions[partition]; pp != nil && highWaterMark > pp.highWaterMark {
+		pp.highWaterMark = highWaterMark
+	}
+}
+
+// objectName returns the name of an archive file relative to the prefix.
+func objectName(topic string, partition int32, firstOffset int64, format string) string {
+	return fmt.Sprintf("%s/%d/%020d.%s", topic, partition, firstOffset, format)
+}
+
+// fetchOffsets returns offsets committed by the group for all partitions
+// of the topic. A partition that the group has not committed an offset for
+// is mapped to sarama.OffsetOldest.
+func fetchOffsets(kafkaClt sarama.Client, topic, group string) (map[int32]int64, error) {
+	partitions, err := kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get partitions")
+	}
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get group coordinator")
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: offsetFetchVer}
+	for _, partition := range partitions {
+		req.AddPartition(topic, partition)
+	}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch offsets")
+	}
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		block := res.GetBlock(topic, partition)
+		if block == nil {
+			return nil, errors.Errorf("offset block is missing, partition=%d", partition)
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, errors.Wrapf(block.Err, "failed to fetch offset, partition=%d", partition)
+		}
+		offsets[partition] = block.Offset
+		if block.Offset < 0 {
+			offsets[partition] = sarama.OffsetOldest
+		}
+	}
+	return offsets, nil
+}
+
+// commitOffset commits an offset of a partition on behalf of the group. The
+// group has no members, so the offset is committed outside of a generation.
+func commitOffset(kafkaClt sarama.Client, topic, group string, partition int32, offset int64) error {
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return errors.Wrap(err, "failed to get group coordinator")
+	}
+	req := sarama.OffsetCommitRequest{
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		Version:                 offsetCommitVer,
+	}
+	req.AddBlock(topic, partition, offset, sarama.ReceiveTime, "")
+	res, err := coordinator.CommitOffset(&req)
+	if err != nil {
+		return err
+	}
+	if kerr := res.Errors[topic][partition]; kerr != sarama.ErrNoError {
+		return kerr
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ArchiverSuite struct {
+	ns        *actor.Descriptor
+	objectsMu sync.Mutex
+	objects   map[string][]byte
+	failPuts  bool
+	srv       *httptest.Server
+	committed map[int32]int64
+	now       time.Time
+}
+
+var _ = Suite(&ArchiverSuite{})
+
+func (s *ArchiverSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ArchiverSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.objects = make(map[string][]byte)
+	s.failPuts = false
+	s.committed = make(map[int32]int64)
+	s.now = time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.objectsMu.Lock()
+		defer s.objectsMu.Unlock()
+		if s.failPuts {
+			http.Error(w, "SlowDown", http.StatusServiceUnavailable)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			s.objects[r.URL.Path] = body
+		case r.URL.Query().Get("list-type") == "2":
+			prefix := r.URL.Path + "/" + r.URL.Query().Get("prefix")
+			fmt.Fprint(w, "<ListBucketResult>")
+			for path := range s.objects {
+				if strings.HasPrefix(path, prefix) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", strings.TrimPrefix(path, r.URL.Path+"/"))
+				}
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		default:
+			body, ok := s.objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+}
+
+func (s *ArchiverSuite) TearDownTest(c *C) {
+	s.srv.Close()
+}
+
+func (s *ArchiverSuite) newSession(cfg config.Archive) (*T, *session) {
+	cfg.Topic = "t1"
+	cfg.Endpoint = s.srv.URL
+	cfg.Bucket = "archive"
+	cfg.Prefix = "kafka/"
+	a := newArchive(s.ns, "a1", cfg, config.DefaultProxy())
+	a.now = func() time.Time { return s.now }
+	return a, a.newSession(func(partition int32, offset int64) error {
+		s.committed[partition] = offset
+		return nil
+	})
+}
+
+func newMessage(partition int32, offset int64, value string) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "t1",
+		Partition: partition,
+		Offset:    offset,
+		Timestamp: time.Date(2019, 8, 1, 11, 0, 0, 0, time.UTC),
+		Value:     []byte(value),
+	}
+}
+
+// A file is uploaded as soon as it reaches the maximum size, and the offset
+// following the last message in it is committed.
+func (s *ArchiverSuite) TestUploadBySize(c *C) {
+	_, ses := s.newSession(config.Archive{MaxFileSize: 200})
+
+	// When
+	for i := 0; i < 3; i++ {
+		c.Assert(ses.add(newMessage(0, int64(10+i), "foo")), IsNil)
+		c.Assert(ses.add(newMessage(1, int64(20+i), "bar")), IsNil)
+	}
+
+	// Then
+	c.Check(s.committed, DeepEquals, map[int32]int64{0: 12, 1: 22})
+	c.Check(s.objects["/archive/kafka/t1/0/00000000000000000010.jsonl"], DeepEquals, []byte(""+
+		`{"topic":"t1","partition":0,"offset":10,"timestamp":"2019-08-01T11:00:00Z","key":null,"value":"Zm9v"}`+"\n"+
+		`{"topic":"t1","partition":0,"offset":11,"timestamp":"2019-08-01T11:00:00Z","key":null,"value":"Zm9v"}`+"\n"))
+	c.Check(s.objects, HasLen, 2)
+	c.Check(ses.files, HasLen, 2)
+	c.Check(ses.files[0].firstOffset, Equals, int64(12))
+}
+
+// A file is uploaded once it gets old enough, no matter how small it is.
+func (s *ArchiverSuite) TestUploadByAge(c *C) {
+	_, ses := s.newSession(config.Archive{MaxFileAge: time.Minute})
+	c.Assert(ses.add(newMessage(0, 10, "foo")), IsNil)
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(ses.add(newMessage(1, 20, "bar")), IsNil)
+	s.now = s.now.Add(30 * time.Second)
+
+	// When
+	err := ses.uploadExpired()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(s.committed, DeepEquals, map[int32]int64{0: 11})
+	c.Check(s.objects["/archive/kafka/t1/0/00000000000000000010.jsonl"], NotNil)
+	c.Check(ses.files, HasLen, 1)
+}
+
+// If a file fails to be uploaded, then its offsets are not committed.
+func (s *ArchiverSuite) TestUploadError(c *C) {
+	_, ses := s.newSession(config.Archive{MaxFileSize: 1})
+	s.failPuts = true
+
+	// When
+	err := ses.add(newMessage(0, 10, "foo"))
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to upload file, partition=0: failed to upload value: "+
+		"PUT archive/kafka/t1/0/00000000000000000010.jsonl: 503 Service Unavailable SlowDown")
+	c.Check(s.committed, HasLen, 0)
+}
+
+// Lag is the number of messages between the committed offset and the high
+// water mark, summed over all partitions.
+func (s *ArchiverSuite) TestStatus(c *C) {
+	a, ses := s.newSession(config.Archive{MaxFileSize: 1})
+	a.partitions[0] = &partitionProgress{committed: 10, highWaterMark: 15}
+	a.partitions[1] = &partitionProgress{committed: 20, highWaterMark: 20}
+	a.setHighWaterMark(1, 23)
+
+	// When
+	c.Assert(ses.add(newMessage(0, 10, "foo")), IsNil)
+	c.Assert(ses.add(newMessage(0, 11, "foo")), IsNil)
+
+	// Then
+	c.Check(a.Status(), DeepEquals, Status{
+		Name:             "a1",
+		Topic:            "t1",
+		Lag:              6,
+		UploadedFiles:    2,
+		UploadedMessages: 2,
+		UploadedAt:       s.now,
+	})
+}
+
+func (s *ArchiverSuite) TestJSONLHeaders(c *C) {
+	f := newFile(config.ArchiveFormatJSONL, s.now)
+	msg := newMessage(0, 10, "foo")
+	msg.Key = []byte("bar")
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("v1")}}
+
+	// When
+	f.add(msg)
+
+	// Then
+	c.Check(string(f.enc.finish()), Equals, `{"topic":"t1","partition":0,"offset":10,`+
+		`"timestamp":"2019-08-01T11:00:00Z","key":"YmFy","value":"Zm9v","headers":[{"key":"h1","value":"djE="}]}`+"\n")
+}
+
+// An Avro file is an object container with the schema, the null codec, and
+// one block with all records.
+func (s *ArchiverSuite) TestAvro(c *C) {
+	f := newFile(config.ArchiveFormatAvro, s.now)
+	msg := newMessage(1, 10, "foo")
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("v1")}}
+	f.add(msg)
+	f.add(&sarama.ConsumerMessage{Topic: "t1", Partition: 1, Offset: 11, Key: []byte("k")})
+
+	// When
+	data := f.enc.finish()
+
+	// Then
+	var header bytes.Buffer
+	header.WriteString("Obj\x01\x04")
+	header.WriteString("\x16avro.schema")
+	writeAvroBytes(&header, []byte(avroSchema))
+	header.WriteString("\x14avro.codec\x08null\x00")
+	c.Assert(bytes.HasPrefix(data, header.Bytes()), Equals, true)
+	sync := data[header.Len() : header.Len()+16]
+	block := data[header.Len()+16:]
+	records := "" +
+		// topic, partition, offset, timestamp 1564657200000 ms
+		"\x04t1\x02\x14\x80\xae\xb1\xcd\x89\x5b" +
+		// null key, value, one header
+		"\x00\x02\x06foo\x02\x04h1\x04v1\x00" +
+		// topic, partition, offset, no timestamp
+		"\x04t1\x02\x16\x00" +
+		// key, null value, no headers
+		"\x02\x02k\x00\x00"
+	c.Check(string(block), Equals, "\x04"+string([]byte{byte(len(records) * 2)})+records+string(sync))
+}
+
+func (s *ArchiverSuite) TestObjectName(c *C) {
+	c.Check(objectName("foo", 3, 12345, config.ArchiveFormatAvro), Equals, "foo/3/00000000000000012345.avro")
+	c.Check(strings.HasSuffix(objectName("foo", 3, 1, config.ArchiveFormatJSONL), ".jsonl"), Equals, true)
+}
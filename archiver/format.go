@@ -0,0 +1,381 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// avroSchema is the schema of records in Avro archive files. Timestamps are
+// in milliseconds since the Unix epoch, and null keys and values are kept
+// apart from empty ones.
+const avroSchema = `{"type":"record","name":"Message","namespace":"com.mailgun.kafkapixy","fields":[` +
+	`{"name":"topic","type":"string"},` +
+	`{"name":"partition","type":"int"},` +
+	`{"name":"offset","type":"long"},` +
+	`{"name":"timestamp","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"key","type":["null","bytes"]},` +
+	`{"name":"value","type":["null","bytes"]},` +
+	`{"name":"headers","type":{"type":"array","items":{"type":"record","name":"Header","fields":[` +
+	`{"name":"key","type":"string"},{"name":"value","type":"bytes"}]}}}]}`
+
+var avroMagic = []byte("Obj\x01")
+
+// file is an archive file of a partition that is being written.
+type file struct {
+	enc         encoder
+	createdAt   time.Time
+	firstOffset int64
+	lastOffset  int64
+	count       int
+}
+
+// encoder serializes messages in an archive file format.
+type encoder interface {
+	// encode appends a message to the file.
+	encode(msg *sarama.ConsumerMessage)
+
+	// size returns the number of bytes encoded so far.
+	size() int
+
+	// finish returns the complete file content.
+	finish() []byte
+}
+
+func newFile(format string, now time.Time) *file {
+	var enc encoder
+	if format == config.ArchiveFormatAvro {
+		enc = &avroEncoder{}
+	} else {
+		enc = &jsonlEncoder{}
+	}
+	return &file{enc: enc, createdAt: now}
+}
+
+func (f *file) add(msg *sarama.ConsumerMessage) {
+	if f.count == 0 {
+		f.firstOffset = msg.Offset
+	}
+	f.lastOffset = msg.Offset
+	f.count++
+	f.enc.encode(msg)
+}
+
+// jsonRecord is a line of a JSON lines archive file. Keys and values are
+// base64 encoded, the same way as in consume responses of the HTTP API.
+type jsonRecord struct {
+	Topic     string       `json:"topic"`
+	Partition int32        `json:"partition"`
+	Offset    int64        `json:"offset"`
+	Timestamp time.Time    `json:"timestamp"`
+	Key       []byte       `json:"key"`
+	Value     []byte       `json:"value"`
+	Headers   []jsonHeader `json:"headers,omitempty"`
+}
+
+type jsonHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type jsonlEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *jsonlEncoder) encode(msg *sarama.ConsumerMessage) {
+	rec := jsonRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for _, h := range msg.Headers {
+		rec.Headers = append(rec.Headers, jsonHeader{Key: string(h.Key), Value: h.Value})
+	}
+	// Encoder appends a newline after every record. The record has only
+	// strings, numbers and byte slices in it, so it cannot fail.
+	_ = json.NewEncoder(&e.buf).Encode(&rec)
+}
+
+func (e *jsonlEncoder) size() int {
+	return e.buf.Len()
+}
+
+func (e *jsonlEncoder) finish() []byte {
+	return e.buf.Bytes()
+}
+
+// avroEncoder writes an Avro object container file with all records in one
+// uncompressed block.
+type avroEncoder struct {
+	buf   bytes.Buffer
+	count int64
+}
+
+func (e *avroEncoder) encode(msg *sarama.ConsumerMessage) {
+	e.count++
+	writeAvroString(&e.buf, msg.Topic)
+	writeAvroLong(&e.buf, int64(msg.Partition))
+	writeAvroLong(&e.buf, msg.Offset)
+	var timestamp int64
+	if !msg.Timestamp.IsZero() {
+		timestamp = msg.Timestamp.UnixNano() / int64(time.Millisecond)
+	}
+	writeAvroLong(&e.buf, timestamp)
+	writeAvroNullableBytes(&e.buf, msg.Key)
+	writeAvroNullableBytes(&e.buf, msg.Value)
+	if len(msg.Headers) > 0 {
+		writeAvroLong(&e.buf, int64(len(msg.Headers)))
+		for _, h := range msg.Headers {
+			writeAvroString(&e.buf, string(h.Key))
+			writeAvroBytes(&e.buf, h.Value)
+		}
+	}
+	writeAvroLong(&e.buf, 0)
+}
+
+func (e *avroEncoder) size() int {
+	return e.buf.Len()
+}
+
+func (e *avroEncoder) finish() []byte {
+	var out bytes.Buffer
+	out.Write(avroMagic)
+	writeAvroLong(&out, 2)
+	writeAvroString(&out, "avro.schema")
+	writeAvroBytes(&out, []byte(avroSchema))
+	writeAvroString(&out, "avro.codec")
+	writeAvroBytes(&out, []byte("null"))
+	writeAvroLong(&out, 0)
+	sync := make([]byte, 16)
+	_, _ = rand.Read(sync)
+	out.Write(sync)
+	if e.count > 0 {
+		writeAvroLong(&out, e.count)
+		writeAvroLong(&out, int64(e.buf.Len()))
+		out.Write(e.buf.Bytes())
+		out.Write(sync)
+	}
+	return out.Bytes()
+}
+
+// writeAvroLong writes a long as Avro does, that is as a zig-zag encoded
+// variable length integer, which is exactly what binary.PutVarint does.
+func writeAvroLong(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeAvroBytes(buf *bytes.Buffer, v []byte) {
+	writeAvroLong(buf, int64(len(v)))
+	buf.Write(v)
+}
+
+func writeAvroString(buf *bytes.Buffer, v string) {
+	writeAvroLong(buf, int64(len(v)))
+	buf.WriteString(v)
+}
+
+// writeAvroNullableBytes writes a value of the ["null", "bytes"] union.
+func writeAvroNullableBytes(buf *bytes.Buffer, v []byte) {
+	if v == nil {
+		writeAvroLong(buf, 0)
+		return
+	}
+	writeAvroLong(buf, 1)
+	writeAvroBytes(buf, v)
+}
+
+// decodeFile returns messages of an archive file. Only files written by the
+// archiver are understood.
+func decodeFile(format string, data []byte) ([]*sarama.ConsumerMessage, error) {
+	if format == config.ArchiveFormatAvro {
+		return decodeAvro(data)
+	}
+	return decodeJSONL(data)
+}
+
+func decodeJSONL(data []byte) ([]*sarama.ConsumerMessage, error) {
+	var msgs []*sarama.ConsumerMessage
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var rec jsonRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, errors.Wrapf(err, "bad record #%d", len(msgs))
+		}
+		msg := &sarama.ConsumerMessage{
+			Topic:     rec.Topic,
+			Partition: rec.Partition,
+			Offset:    rec.Offset,
+			Timestamp: rec.Timestamp,
+			Key:       rec.Key,
+			Value:     rec.Value,
+		}
+		for _, h := range rec.Headers {
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, scanner.Err()
+}
+
+func decodeAvro(data []byte) ([]*sarama.ConsumerMessage, error) {
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, avroMagic) {
+		return nil, errors.New("not an Avro object container file")
+	}
+	meta, err := readAvroMap(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad file header")
+	}
+	if codec := meta["avro.codec"]; len(codec) > 0 && string(codec) != "null" {
+		return nil, errors.Errorf("unsupported codec: %s", codec)
+	}
+	sync := make([]byte, 16)
+	if _, err := io.ReadFull(r, sync); err != nil {
+		return nil, errors.Wrap(err, "bad file header")
+	}
+	var msgs []*sarama.ConsumerMessage
+	for r.Len() > 0 {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad block")
+		}
+		if _, err := binary.ReadVarint(r); err != nil {
+			return nil, errors.Wrap(err, "bad block")
+		}
+		for i := int64(0); i < count; i++ {
+			msg, err := readAvroMessage(r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad record #%d", len(msgs))
+			}
+			msgs = append(msgs, msg)
+		}
+		blockSync := make([]byte, 16)
+		if _, err := io.ReadFull(r, blockSync); err != nil || !bytes.Equal(blockSync, sync) {
+			return nil, errors.New("bad block sync marker")
+		}
+	}
+	return msgs, nil
+}
+
+func readAvroMessage(r *bytes.Reader) (*sarama.ConsumerMessage, error) {
+	var msg sarama.ConsumerMessage
+	var err error
+	if msg.Topic, err = readAvroString(r); err != nil {
+		return nil, err
+	}
+	partition, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	msg.Partition = int32(partition)
+	if msg.Offset, err = binary.ReadVarint(r); err != nil {
+		return nil, err
+	}
+	timestamp, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp != 0 {
+		msg.Timestamp = time.Unix(0, timestamp*int64(time.Millisecond)).UTC()
+	}
+	if msg.Key, err = readAvroNullableBytes(r); err != nil {
+		return nil, err
+	}
+	if msg.Value, err = readAvroNullableBytes(r); err != nil {
+		return nil, err
+	}
+	for {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return &msg, nil
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroString(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readAvroBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: value})
+		}
+	}
+}
+
+// readAvroMap reads a map with bytes values, as the file metadata is.
+func readAvroMap(r *bytes.Reader) (map[string][]byte, error) {
+	m := make(map[string][]byte)
+	for {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return m, nil
+		}
+		if count < 0 {
+			// A negative count is followed by the block size in bytes.
+			count = -count
+			if _, err := binary.ReadVarint(r); err != nil {
+				return nil, err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroString(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = readAvroBytes(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func readAvroBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || size > int64(r.Len()) {
+		return nil, errors.Errorf("bad length: %d", size)
+	}
+	v := make([]byte, size)
+	_, err = io.ReadFull(r, v)
+	return v, err
+}
+
+func readAvroString(r *bytes.Reader) (string, error) {
+	v, err := readAvroBytes(r)
+	return string(v), err
+}
+
+func readAvroNullableBytes(r *bytes.Reader) ([]byte, error) {
+	branch, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if branch == 0 {
+		return nil, nil
+	}
+	return readAvroBytes(r)
+}
@@ -0,0 +1,394 @@
+package archiver
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// States of a replay.
+const (
+	ReplayRunning   = "running"
+	ReplayCompleted = "completed"
+	ReplayFailed    = "failed"
+	ReplayCanceled  = "canceled"
+)
+
+var (
+	// ErrNotFound is returned when there is no archive with a given name.
+	ErrNotFound = errors.New("archive not found. Consider adding it to `archives` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+
+	// ErrReplayNotFound is returned when an archive has no replay with a
+	// given ID.
+	ErrReplayNotFound = errors.New("replay not found")
+
+	errReplayCanceled = errors.New("replay canceled")
+
+	// errProduceFailed stops submitting messages when the producer fails,
+	// the replay reports the producer error instead.
+	errProduceFailed = errors.New("produce failed")
+)
+
+// ReplayRequest defines what part of an archive to replay and where to.
+type ReplayRequest struct {
+	// Topic, and cluster along with its config, that messages are produced
+	// to.
+	Topic     string
+	Cluster   string
+	TargetCfg *config.Proxy
+
+	// Messages with offsets from FromOffset inclusive to ToOffset exclusive
+	// are replayed. Zero ToOffset means there is no upper bound.
+	FromOffset int64
+	ToOffset   int64
+
+	// The maximum number of messages produced per second. Zero means no
+	// limit.
+	Rate int
+}
+
+// Replay is the progress of a replay.
+type Replay struct {
+	ID         string
+	Topic      string
+	Cluster    string
+	FromOffset int64
+	ToOffset   int64
+	Rate       int
+	State      string
+
+	// The number of archive files to replay, and the number of them that
+	// have been read so far.
+	Files     int
+	FilesRead int
+
+	// The number of messages acknowledged by the target cluster.
+	ProducedMessages int64
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// The error the replay failed with, if any.
+	Err error
+}
+
+type replay struct {
+	rq       ReplayRequest
+	status   Replay
+	cancelCh chan none.T
+	cancelMu sync.Mutex
+	canceled bool
+}
+
+// archiveFile is a file listed in object storage.
+type archiveFile struct {
+	name        string
+	partition   int32
+	firstOffset int64
+	format      string
+}
+
+// StartReplay starts producing messages from the archive files to a topic,
+// possibly on another cluster, in the background. Messages of a partition
+// are produced in offset order, and keep their keys, headers and
+// timestamps. They go to partitions that their keys are mapped to by the
+// target cluster partitioner.
+func (a *T) StartReplay(rq ReplayRequest) Replay {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replaySeq++
+	rp := &replay{
+		rq: rq,
+		status: Replay{
+			ID:         strconv.Itoa(a.replaySeq),
+			Topic:      rq.Topic,
+			Cluster:    rq.Cluster,
+			FromOffset: rq.FromOffset,
+			ToOffset:   rq.ToOffset,
+			Rate:       rq.Rate,
+			State:      ReplayRunning,
+			StartedAt:  a.now(),
+		},
+		cancelCh: make(chan none.T),
+	}
+	a.replays[rp.status.ID] = rp
+	actor.Spawn(a.actDesc.NewChild("replay", rp.status.ID), &a.wg, func() {
+		a.runReplay(rp)
+	})
+	return rp.status
+}
+
+// CancelReplay stops a running replay. Messages that have already been
+// submitted to the producer are still produced.
+func (a *T) CancelReplay(id string) error {
+	a.mu.Lock()
+	rp, ok := a.replays[id]
+	a.mu.Unlock()
+	if !ok {
+		return ErrReplayNotFound
+	}
+	rp.cancelMu.Lock()
+	defer rp.cancelMu.Unlock()
+	if !rp.canceled {
+		rp.canceled = true
+		close(rp.cancelCh)
+	}
+	return nil
+}
+
+// Replay returns the progress of a replay.
+func (a *T) Replay(id string) (Replay, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rp, ok := a.replays[id]
+	if !ok {
+		return Replay{}, ErrReplayNotFound
+	}
+	return rp.status, nil
+}
+
+// Replays returns progress of all replays of the archive since start, in
+// the order they were started.
+func (a *T) Replays() []Replay {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	replays := make([]Replay, 0, len(a.replays))
+	for _, rp := range a.replays {
+		replays = append(replays, rp.status)
+	}
+	sort.Slice(replays, func(i, j int) bool {
+		seqI, _ := strconv.Atoi(replays[i].ID)
+		seqJ, _ := strconv.Atoi(replays[j].ID)
+		return seqI < seqJ
+	})
+	return replays
+}
+
+func (a *T) runReplay(rp *replay) {
+	a.actDesc.Log().Infof("Replay started: id=%s, topic=%s, cluster=%s", rp.status.ID, rp.rq.Topic, rp.rq.Cluster)
+	err := a.replay(rp)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rp.status.FinishedAt = a.now()
+	switch err {
+	case nil:
+		rp.status.State = ReplayCompleted
+	case errReplayCanceled:
+		rp.status.State = ReplayCanceled
+	default:
+		rp.status.State = ReplayFailed
+		rp.status.Err = err
+		a.actDesc.Log().WithError(err).Errorf("Replay failed: id=%s, produced=%d", rp.status.ID, rp.status.ProducedMessages)
+		return
+	}
+	a.actDesc.Log().Infof("Replay %s: id=%s, produced=%d", rp.status.State, rp.status.ID, rp.status.ProducedMessages)
+}
+
+func (a *T) replay(rp *replay) error {
+	files, err := a.listFiles(rp.rq.FromOffset, rp.rq.ToOffset)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	rp.status.Files = len(files)
+	a.mu.Unlock()
+
+	producer, err := a.newProducer(rp.rq.TargetCfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to create producer")
+	}
+	failedCh := make(chan none.T)
+	ackedCh := make(chan none.T)
+	var produceErr error
+	go func() {
+		defer close(ackedCh)
+		successes, errs := producer.Successes(), producer.Errors()
+		for successes != nil || errs != nil {
+			select {
+			case _, ok := <-successes:
+				if !ok {
+					successes = nil
+					continue
+				}
+				a.mu.Lock()
+				rp.status.ProducedMessages++
+				a.mu.Unlock()
+			case prodErr, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				if produceErr == nil {
+					produceErr = errors.Wrap(prodErr.Err, "failed to produce message")
+					close(failedCh)
+				}
+			}
+		}
+	}()
+	err = a.produceFiles(rp, files, producer, failedCh)
+	producer.AsyncClose()
+	<-ackedCh
+	if produceErr != nil {
+		return produceErr
+	}
+	return err
+}
+
+// produceFiles submits messages of archive files to the producer, no faster
+// than the replay rate allows.
+func (a *T) produceFiles(rp *replay, files []archiveFile, producer sarama.AsyncProducer, failedCh <-chan none.T) error {
+	startedAt := time.Now()
+	var submitted int64
+	// Files uploaded more than once may overlap, so offsets already
+	// submitted are skipped.
+	nextOffsets := make(map[int32]int64)
+	for _, f := range files {
+		data, err := a.store.GetObject(f.name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", f.name)
+		}
+		msgs, err := decodeFile(f.format, data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode file %s", f.name)
+		}
+		for _, msg := range msgs {
+			if msg.Offset < rp.rq.FromOffset || (rp.rq.ToOffset > 0 && msg.Offset >= rp.rq.ToOffset) {
+				continue
+			}
+			if next, ok := nextOffsets[f.partition]; ok && msg.Offset < next {
+				continue
+			}
+			nextOffsets[f.partition] = msg.Offset + 1
+
+			if rp.rq.Rate > 0 {
+				dueAt := startedAt.Add(time.Duration(submitted) * time.Second / time.Duration(rp.rq.Rate))
+				if err := a.waitUntil(rp, dueAt, failedCh); err != nil {
+					return err
+				}
+			}
+			prodMsg := &sarama.ProducerMessage{
+				Topic:     rp.rq.Topic,
+				Value:     sarama.ByteEncoder(msg.Value),
+				Timestamp: msg.Timestamp,
+			}
+			if msg.Key != nil {
+				prodMsg.Key = sarama.ByteEncoder(msg.Key)
+			}
+			for _, h := range msg.Headers {
+				prodMsg.Headers = append(prodMsg.Headers, *h)
+			}
+			select {
+			case producer.Input() <- prodMsg:
+				submitted++
+			case <-rp.cancelCh:
+				return errReplayCanceled
+			case <-a.stopCh:
+				return errReplayCanceled
+			case <-failedCh:
+				return errProduceFailed
+			}
+		}
+		a.mu.Lock()
+		rp.status.FilesRead++
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// waitUntil blocks until the given time, or until the replay has to stop.
+func (a *T) waitUntil(rp *replay, dueAt time.Time, failedCh <-chan none.T) error {
+	delay := time.Until(dueAt)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-rp.cancelCh:
+		return errReplayCanceled
+	case <-a.stopCh:
+		return errReplayCanceled
+	case <-failedCh:
+		return errProduceFailed
+	}
+}
+
+// listFiles returns archive files that may contain messages in the offset
+// range, ordered by partition and offset.
+func (a *T) listFiles(fromOffset, toOffset int64) ([]archiveFile, error) {
+	names, err := a.store.ListObjects(a.cfg.Topic + "/")
+	if err != nil {
+		return nil, err
+	}
+	var files []archiveFile
+	for _, name := range names {
+		f, ok := parseObjectName(a.cfg.Topic, name)
+		if !ok {
+			continue
+		}
+		if toOffset > 0 && f.firstOffset >= toOffset {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].partition != files[j].partition {
+			return files[i].partition < files[j].partition
+		}
+		return files[i].firstOffset < files[j].firstOffset
+	})
+	// A file that is followed by one starting at or before the first
+	// offset to replay cannot contain messages to replay.
+	var filtered []archiveFile
+	for i, f := range files {
+		if i+1 < len(files) && files[i+1].partition == f.partition && files[i+1].firstOffset <= fromOffset {
+			continue
+		}
+		filtered = append(filtered, f)
+	}
+	return filtered, nil
+}
+
+// parseObjectName parses a name returned by objectName. It returns false if
+// the name is not of an archive file of the topic.
+func parseObjectName(topic, name string) (archiveFile, bool) {
+	parts := strings.Split(strings.TrimPrefix(name, topic+"/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(name, topic+"/") {
+		return archiveFile{}, false
+	}
+	partition, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return archiveFile{}, false
+	}
+	dot := strings.IndexByte(parts[1], '.')
+	if dot < 0 {
+		return archiveFile{}, false
+	}
+	format := parts[1][dot+1:]
+	if format != config.ArchiveFormatJSONL && format != config.ArchiveFormatAvro {
+		return archiveFile{}, false
+	}
+	firstOffset, err := strconv.ParseInt(parts[1][:dot], 10, 64)
+	if err != nil {
+		return archiveFile{}, false
+	}
+	return archiveFile{name: name, partition: int32(partition), firstOffset: firstOffset, format: format}, true
+}
+
+func newProducer(cfg *config.Proxy) (sarama.AsyncProducer, error) {
+	saramaCfg := cfg.SaramaProducerCfg()
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Producer.Return.Errors = true
+	return sarama.NewAsyncProducer(cfg.Kafka.SeedPeers, saramaCfg)
+}
@@ -0,0 +1,246 @@
+package archiver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+// archive uploads files with messages of two partitions, three messages per
+// file, in both formats.
+func (s *ArchiverSuite) archive(c *C, a *T, ses *session) {
+	for i := 0; i < 6; i++ {
+		c.Assert(ses.add(newMessage(0, int64(10+i), fmt.Sprintf("p0m%d", i))), IsNil)
+		c.Assert(ses.add(newMessage(1, int64(20+i), fmt.Sprintf("p1m%d", i))), IsNil)
+		if i == 2 {
+			s.now = s.now.Add(time.Hour)
+			c.Assert(ses.uploadExpired(), IsNil)
+			a.cfg.Format = config.ArchiveFormatAvro
+		}
+	}
+	s.now = s.now.Add(time.Hour)
+	c.Assert(ses.uploadExpired(), IsNil)
+}
+
+func (s *ArchiverSuite) waitReplay(c *C, a *T, id string) Replay {
+	for i := 0; i < 100; i++ {
+		rp, err := a.Replay(id)
+		c.Assert(err, IsNil)
+		if rp.State != ReplayRunning {
+			return rp
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("replay is still running: id=%s", id)
+	return Replay{}
+}
+
+// Messages are replayed in offset order of every partition with their keys,
+// headers and timestamps, and files of both formats are read.
+func (s *ArchiverSuite) TestReplay(c *C) {
+	a, ses := s.newSession(config.Archive{})
+	s.archive(c, a, ses)
+	c.Assert(s.objects, HasLen, 4)
+	prod := newFakeProducer(-1)
+	a.newProducer = func(cfg *config.Proxy) (sarama.AsyncProducer, error) { return prod, nil }
+
+	// When
+	rp := a.StartReplay(ReplayRequest{Topic: "t2", Cluster: "c1"})
+
+	// Then
+	c.Check(rp.ID, Equals, "1")
+	c.Check(rp.State, Equals, ReplayRunning)
+	rp = s.waitReplay(c, a, rp.ID)
+	c.Check(rp.State, Equals, ReplayCompleted)
+	c.Check(rp.Err, IsNil)
+	c.Check(rp.Files, Equals, 4)
+	c.Check(rp.FilesRead, Equals, 4)
+	c.Check(rp.ProducedMessages, Equals, int64(12))
+	var values []string
+	for _, msg := range prod.messages() {
+		c.Check(msg.Topic, Equals, "t2")
+		c.Check(msg.Key, IsNil)
+		c.Check(msg.Timestamp, Equals, time.Date(2019, 8, 1, 11, 0, 0, 0, time.UTC))
+		value, _ := msg.Value.Encode()
+		values = append(values, string(value))
+	}
+	c.Check(values, DeepEquals, []string{
+		"p0m0", "p0m1", "p0m2", "p0m3", "p0m4", "p0m5",
+		"p1m0", "p1m1", "p1m2", "p1m3", "p1m4", "p1m5",
+	})
+	c.Check(a.Replays(), DeepEquals, []Replay{rp})
+}
+
+// Only messages in the offset range are replayed, the range applies to every
+// partition. Files that cannot contain any of them are not read.
+func (s *ArchiverSuite) TestReplayRange(c *C) {
+	a, ses := s.newSession(config.Archive{})
+	s.archive(c, a, ses)
+	prod := newFakeProducer(-1)
+	a.newProducer = func(cfg *config.Proxy) (sarama.AsyncProducer, error) { return prod, nil }
+
+	// When
+	rp := a.StartReplay(ReplayRequest{Topic: "t2", FromOffset: 14, ToOffset: 22})
+
+	// Then
+	rp = s.waitReplay(c, a, rp.ID)
+	c.Check(rp.State, Equals, ReplayCompleted)
+	c.Check(rp.Files, Equals, 2)
+	var values []string
+	for _, msg := range prod.messages() {
+		value, _ := msg.Value.Encode()
+		values = append(values, string(value))
+	}
+	c.Check(values, DeepEquals, []string{"p0m4", "p0m5", "p1m0", "p1m1"})
+}
+
+// If the target cluster fails to accept a message, then the replay fails.
+func (s *ArchiverSuite) TestReplayProduceError(c *C) {
+	a, ses := s.newSession(config.Archive{})
+	s.archive(c, a, ses)
+	a.newProducer = func(cfg *config.Proxy) (sarama.AsyncProducer, error) { return newFakeProducer(2), nil }
+
+	// When
+	rp := a.StartReplay(ReplayRequest{Topic: "t2"})
+
+	// Then
+	rp = s.waitReplay(c, a, rp.ID)
+	c.Check(rp.State, Equals, ReplayFailed)
+	c.Check(rp.Err, ErrorMatches, "failed to produce message: kaboom")
+	c.Check(rp.ProducedMessages, Equals, int64(2))
+}
+
+// A replay is paced by the rate, and can be canceled while waiting for its
+// turn to produce.
+func (s *ArchiverSuite) TestReplayCancel(c *C) {
+	a, ses := s.newSession(config.Archive{})
+	s.archive(c, a, ses)
+	prod := newFakeProducer(-1)
+	a.newProducer = func(cfg *config.Proxy) (sarama.AsyncProducer, error) { return prod, nil }
+	rp := a.StartReplay(ReplayRequest{Topic: "t2", Rate: 2})
+	time.Sleep(200 * time.Millisecond)
+
+	// When
+	err := a.CancelReplay(rp.ID)
+
+	// Then
+	c.Assert(err, IsNil)
+	rp = s.waitReplay(c, a, rp.ID)
+	c.Check(rp.State, Equals, ReplayCanceled)
+	c.Check(rp.ProducedMessages, Equals, int64(1))
+	c.Check(a.CancelReplay(rp.ID), IsNil)
+	c.Check(a.CancelReplay("foo"), Equals, ErrReplayNotFound)
+}
+
+func (s *ArchiverSuite) TestReplayStorageError(c *C) {
+	a, _ := s.newSession(config.Archive{})
+	s.srv.Close()
+
+	// When
+	rp := a.StartReplay(ReplayRequest{Topic: "t2"})
+
+	// Then
+	rp = s.waitReplay(c, a, rp.ID)
+	c.Check(rp.State, Equals, ReplayFailed)
+	c.Check(rp.Err, ErrorMatches, "failed to list objects: .*connection refused")
+}
+
+func (s *ArchiverSuite) TestParseObjectName(c *C) {
+	for i, tc := range []struct {
+		name string
+		file archiveFile
+		ok   bool
+	}{
+		{name: "t1/3/00000000000000012345.avro", ok: true,
+			file: archiveFile{name: "t1/3/00000000000000012345.avro", partition: 3, firstOffset: 12345, format: "avro"}},
+		{name: "t1/0/00000000000000000000.jsonl", ok: true,
+			file: archiveFile{name: "t1/0/00000000000000000000.jsonl", format: "jsonl"}},
+		{name: "t1/0/00000000000000000000.csv"},
+		{name: "t1/x/00000000000000000000.jsonl"},
+		{name: "t1/0/foo.jsonl"},
+		{name: "t1/0/1/00000000000000000000.jsonl"},
+		{name: "t11/0/00000000000000000000.jsonl"},
+	} {
+		// When
+		file, ok := parseObjectName("t1", tc.name)
+
+		// Then
+		c.Check(ok, Equals, tc.ok, Commentf("case #%d", i))
+		c.Check(file, DeepEquals, tc.file, Commentf("case #%d", i))
+	}
+}
+
+func (s *ArchiverSuite) TestDecodeFile(c *C) {
+	msg := newMessage(1, 10, "foo")
+	msg.Key = []byte("bar")
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("v1")}}
+	for i, format := range []string{config.ArchiveFormatJSONL, config.ArchiveFormatAvro} {
+		f := newFile(format, s.now)
+		f.add(msg)
+		f.add(&sarama.ConsumerMessage{Topic: "t1", Partition: 1, Offset: 11, Value: []byte{}})
+
+		// When
+		msgs, err := decodeFile(format, f.enc.finish())
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(msgs, DeepEquals, []*sarama.ConsumerMessage{
+			msg, {Topic: "t1", Partition: 1, Offset: 11, Value: []byte{}},
+		}, Commentf("case #%d", i))
+	}
+	_, err := decodeFile(config.ArchiveFormatAvro, []byte("{}"))
+	c.Check(err, ErrorMatches, "not an Avro object container file")
+}
+
+// fakeProducer acknowledges messages submitted to it, except for the one
+// with the failAt index and all following it.
+type fakeProducer struct {
+	inputCh     chan *sarama.ProducerMessage
+	successesCh chan *sarama.ProducerMessage
+	errorsCh    chan *sarama.ProducerError
+	failAt      int
+	mu          sync.Mutex
+	submitted   []*sarama.ProducerMessage
+}
+
+func newFakeProducer(failAt int) *fakeProducer {
+	p := &fakeProducer{
+		inputCh:     make(chan *sarama.ProducerMessage),
+		successesCh: make(chan *sarama.ProducerMessage, 100),
+		errorsCh:    make(chan *sarama.ProducerError, 100),
+		failAt:      failAt,
+	}
+	go func() {
+		defer close(p.successesCh)
+		defer close(p.errorsCh)
+		for msg := range p.inputCh {
+			p.mu.Lock()
+			p.submitted = append(p.submitted, msg)
+			failed := p.failAt >= 0 && len(p.submitted) > p.failAt
+			p.mu.Unlock()
+			if failed {
+				p.errorsCh <- &sarama.ProducerError{Msg: msg, Err: errors.New("kaboom")}
+				continue
+			}
+			p.successesCh <- msg
+		}
+	}()
+	return p
+}
+
+func (p *fakeProducer) messages() []*sarama.ProducerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.submitted
+}
+
+func (p *fakeProducer) AsyncClose()                               { close(p.inputCh) }
+func (p *fakeProducer) Close() error                              { p.AsyncClose(); return nil }
+func (p *fakeProducer) Input() chan<- *sarama.ProducerMessage     { return p.inputCh }
+func (p *fakeProducer) Successes() <-chan *sarama.ProducerMessage { return p.successesCh }
+func (p *fakeProducer) Errors() <-chan *sarama.ProducerError      { return p.errorsCh }
@@ -0,0 +1,259 @@
+// Package autoprof captures CPU, heap and goroutine profiles when consume
+// latency, the number of goroutines or the heap size crosses a configured
+// threshold, so that there is data for post-incident analysis even if nobody
+// was watching when it happened. Every capture is written to a subdirectory
+// of the configured directory named after the time and the trigger:
+//
+//	<dir>/<time, UTC>_<trigger>/{heap,goroutine,cpu}.pprof
+//
+// so that listing the directory yields captures in time order. Only the most
+// recent captures are kept.
+package autoprof
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// Triggers of a capture.
+const (
+	TriggerConsumeLatency = "consume_latency"
+	TriggerGoroutines     = "goroutines"
+	TriggerHeapSize       = "heap_size"
+)
+
+const (
+	captureTimeFormat = "20060102T150405.000Z"
+
+	fileHeap      = "heap.pprof"
+	fileGoroutine = "goroutine.pprof"
+	fileCPU       = "cpu.pprof"
+	fileReason    = "reason.txt"
+)
+
+// The longest time in nanoseconds that a consume request served with a
+// message took since the last check. It is process wide, for consume
+// requests of all proxies are observed.
+var maxConsumeLatency int64
+
+// ConsumeStarted should be called when a consume request starts. The
+// returned function should be called when the request is served with a
+// message. Requests that time out are not observed, for their latency is
+// the long polling timeout.
+func ConsumeStarted() func() {
+	begin := time.Now()
+	return func() {
+		latency := int64(time.Since(begin))
+		for {
+			max := atomic.LoadInt64(&maxConsumeLatency)
+			if latency <= max || atomic.CompareAndSwapInt64(&maxConsumeLatency, max, latency) {
+				return
+			}
+		}
+	}
+}
+
+// T checks thresholds periodically and captures profiles when any of them
+// is crossed.
+type T struct {
+	actDesc       *actor.Descriptor
+	cfg           config.AutoProfile
+	stopCh        chan none.T
+	wg            sync.WaitGroup
+	captures      int64
+	lastCaptureAt time.Time
+
+	// For tests only!
+	now    func() time.Time
+	sample func() sample
+}
+
+// sample is what thresholds are checked against.
+type sample struct {
+	consumeLatency time.Duration
+	goroutines     int
+	heapSize       int64
+}
+
+// Spawn creates a watchdog and starts checking thresholds with the
+// configured interval.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.AutoProfile) *T {
+	w := newWatchdog(parentActDesc.NewChild("auto_profile"), cfg)
+	actor.Spawn(w.actDesc, &w.wg, w.run)
+	return w
+}
+
+func newWatchdog(actDesc *actor.Descriptor, cfg config.AutoProfile) *T {
+	w := &T{
+		actDesc: actDesc,
+		cfg:     cfg,
+		stopCh:  make(chan none.T),
+		now:     time.Now,
+		sample:  takeSample,
+	}
+	w.actDesc.ObserveGauge("captures", func() int64 {
+		return atomic.LoadInt64(&w.captures)
+	})
+	return w
+}
+
+// Stop makes the watchdog stop checking thresholds. A CPU profile being
+// collected is cut short and written as is.
+func (w *T) Stop() {
+	close(w.stopCh)
+	w.wg.Wait()
+}
+
+func (w *T) run() {
+	ticker := time.NewTicker(w.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// check captures profiles if a threshold is crossed, unless the previous
+// capture was made less than the minimum interval ago.
+func (w *T) check() {
+	smp := w.sample()
+	trigger, reason := w.crossed(smp)
+	if trigger == "" {
+		return
+	}
+	now := w.now()
+	if !w.lastCaptureAt.IsZero() && now.Sub(w.lastCaptureAt) < w.cfg.MinInterval {
+		return
+	}
+	w.lastCaptureAt = now
+	name := now.UTC().Format(captureTimeFormat) + "_" + trigger
+	w.actDesc.Log().Warnf("Threshold crossed, capturing profiles: name=%s, reason=%s", name, reason)
+	if err := w.capture(filepath.Join(w.cfg.Dir, name), reason); err != nil {
+		w.actDesc.Log().WithError(err).Errorf("Failed to capture profiles: name=%s", name)
+	} else {
+		atomic.AddInt64(&w.captures, 1)
+	}
+	if err := w.prune(); err != nil {
+		w.actDesc.Log().WithError(err).Error("Failed to delete old captures")
+	}
+}
+
+// crossed returns the first threshold crossed by a sample, along with a
+// description of the sample for the record.
+func (w *T) crossed(smp sample) (string, string) {
+	reason := fmt.Sprintf("consume_latency=%v, goroutines=%d, heap_size=%d",
+		smp.consumeLatency, smp.goroutines, smp.heapSize)
+	switch {
+	case w.cfg.ConsumeLatency > 0 && smp.consumeLatency > w.cfg.ConsumeLatency:
+		return TriggerConsumeLatency, reason
+	case w.cfg.Goroutines > 0 && smp.goroutines > w.cfg.Goroutines:
+		return TriggerGoroutines, reason
+	case w.cfg.HeapSize > 0 && smp.heapSize > w.cfg.HeapSize:
+		return TriggerHeapSize, reason
+	}
+	return "", reason
+}
+
+// capture writes heap and goroutine profiles, that show the moment the
+// threshold was found crossed, and then collects a CPU profile for the
+// configured duration.
+func (w *T) capture(dir, reason string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fileReason), []byte(reason+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "failed to write reason")
+	}
+	if err := writeProfile(filepath.Join(dir, fileHeap), "heap"); err != nil {
+		return err
+	}
+	if err := writeProfile(filepath.Join(dir, fileGoroutine), "goroutine"); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, fileCPU))
+	if err != nil {
+		return errors.Wrap(err, "failed to create CPU profile")
+	}
+	defer f.Close()
+	// It fails if a CPU profile is already being collected, e.g. via
+	// /debug/pprof/profile.
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return errors.Wrap(err, "failed to start CPU profile")
+	}
+	select {
+	case <-time.After(w.cfg.CPUDuration):
+	case <-w.stopCh:
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+func writeProfile(path, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s profile", name)
+	}
+	defer f.Close()
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		return errors.Wrapf(err, "failed to write %s profile", name)
+	}
+	return nil
+}
+
+// prune deletes the oldest captures in excess of the maximum. Only
+// subdirectories named like captures are considered.
+func (w *T) prune() error {
+	entries, err := ioutil.ReadDir(w.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	var captures []string
+	for _, entry := range entries {
+		if entry.IsDir() && isCapture(entry.Name()) {
+			captures = append(captures, entry.Name())
+		}
+	}
+	sort.Strings(captures)
+	for len(captures) > w.cfg.MaxCaptures {
+		if err := os.RemoveAll(filepath.Join(w.cfg.Dir, captures[0])); err != nil {
+			return err
+		}
+		captures = captures[1:]
+	}
+	return nil
+}
+
+func isCapture(name string) bool {
+	if len(name) <= len(captureTimeFormat) || name[len(captureTimeFormat)] != '_' {
+		return false
+	}
+	_, err := time.Parse(captureTimeFormat, name[:len(captureTimeFormat)])
+	return err == nil
+}
+
+func takeSample() sample {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return sample{
+		consumeLatency: time.Duration(atomic.SwapInt64(&maxConsumeLatency, 0)),
+		goroutines:     runtime.NumGoroutine(),
+		heapSize:       int64(memStats.HeapAlloc),
+	}
+}
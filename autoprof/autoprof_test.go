@@ -0,0 +1,162 @@
+package autoprof
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type AutoProfSuite struct {
+	ns  *actor.Descriptor
+	dir string
+	now time.Time
+	smp sample
+}
+
+var _ = Suite(&AutoProfSuite{})
+
+func (s *AutoProfSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *AutoProfSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.dir = c.MkDir()
+	s.now = time.Date(2018, 7, 22, 10, 30, 0, 0, time.UTC)
+	s.smp = sample{consumeLatency: time.Millisecond, goroutines: 10, heapSize: 1000}
+}
+
+func (s *AutoProfSuite) newWatchdog(cfg config.AutoProfile) *T {
+	cfg.Dir = s.dir
+	cfg.CheckInterval = time.Second
+	cfg.CPUDuration = 10 * time.Millisecond
+	if cfg.MaxCaptures == 0 {
+		cfg.MaxCaptures = 10
+	}
+	w := newWatchdog(s.ns, cfg)
+	w.now = func() time.Time { return s.now }
+	w.sample = func() sample { return s.smp }
+	return w
+}
+
+func (s *AutoProfSuite) listCaptures(c *C) []string {
+	entries, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+// When a threshold is crossed, heap, goroutine and CPU profiles are captured
+// to a directory named after the time and the threshold.
+func (s *AutoProfSuite) TestCapture(c *C) {
+	for i, tc := range []struct {
+		smp     sample
+		trigger string
+	}{
+		{smp: sample{consumeLatency: 3 * time.Second}, trigger: TriggerConsumeLatency},
+		{smp: sample{goroutines: 10001}, trigger: TriggerGoroutines},
+		{smp: sample{heapSize: 1 << 30}, trigger: TriggerHeapSize},
+	} {
+		s.dir = c.MkDir()
+		w := s.newWatchdog(config.AutoProfile{ConsumeLatency: time.Second, Goroutines: 10000, HeapSize: 1 << 20})
+		s.smp = tc.smp
+
+		// When
+		w.check()
+
+		// Then
+		name := "20180722T103000.000Z_" + tc.trigger
+		c.Check(s.listCaptures(c), DeepEquals, []string{name}, Commentf("case #%d", i))
+		for _, file := range []string{fileCPU, fileGoroutine, fileHeap, fileReason} {
+			info, err := os.Stat(filepath.Join(s.dir, name, file))
+			c.Assert(err, IsNil, Commentf("case #%d, file=%s", i, file))
+			c.Check(info.Size() > 0, Equals, true, Commentf("case #%d, file=%s", i, file))
+		}
+		c.Check(atomic.LoadInt64(&w.captures), Equals, int64(1), Commentf("case #%d", i))
+	}
+}
+
+// Nothing is captured while all thresholds are respected.
+func (s *AutoProfSuite) TestNoCapture(c *C) {
+	w := s.newWatchdog(config.AutoProfile{ConsumeLatency: time.Second, Goroutines: 10000, HeapSize: 1 << 20})
+
+	// When
+	w.check()
+
+	// Then
+	c.Check(s.listCaptures(c), DeepEquals, []string{})
+	c.Check(atomic.LoadInt64(&w.captures), Equals, int64(0))
+}
+
+// While a threshold stays crossed, profiles are captured at most once per the
+// minimum interval.
+func (s *AutoProfSuite) TestMinInterval(c *C) {
+	w := s.newWatchdog(config.AutoProfile{Goroutines: 5, MinInterval: time.Minute})
+
+	// When
+	w.check()
+	s.now = s.now.Add(59 * time.Second)
+	w.check()
+	s.now = s.now.Add(time.Second)
+	w.check()
+
+	// Then
+	c.Check(s.listCaptures(c), DeepEquals, []string{
+		"20180722T103000.000Z_goroutines",
+		"20180722T103100.000Z_goroutines",
+	})
+}
+
+// Only the most recent captures are kept, and what else is in the directory
+// is left alone.
+func (s *AutoProfSuite) TestMaxCaptures(c *C) {
+	c.Assert(os.Mkdir(filepath.Join(s.dir, "other"), 0755), IsNil)
+	w := s.newWatchdog(config.AutoProfile{Goroutines: 5, MaxCaptures: 2})
+
+	// When
+	for i := 0; i < 3; i++ {
+		w.check()
+		s.now = s.now.Add(time.Minute)
+	}
+
+	// Then
+	c.Check(s.listCaptures(c), DeepEquals, []string{
+		"20180722T103100.000Z_goroutines",
+		"20180722T103200.000Z_goroutines",
+		"other",
+	})
+}
+
+// The longest latency of consume requests since the last sample is reported,
+// and reset by sampling.
+func (s *AutoProfSuite) TestConsumeLatency(c *C) {
+	takeSample()
+	done1 := ConsumeStarted()
+	time.Sleep(50 * time.Millisecond)
+	done2 := ConsumeStarted()
+	done2()
+	done1()
+
+	// When
+	smp1 := takeSample()
+	smp2 := takeSample()
+
+	// Then
+	c.Check(smp1.consumeLatency >= 50*time.Millisecond, Equals, true, Commentf("got %v", smp1.consumeLatency))
+	c.Check(smp2.consumeLatency, Equals, time.Duration(0))
+}
@@ -0,0 +1,56 @@
+// Package backoff implements an exponential backoff policy with jitter, that
+// is used to space out retries, so that a fleet of Kafka-Pixy instances does
+// not retry in sync after a broker blip.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential is a backoff policy where every retry waits twice as long as
+// the previous one, starting with Base and up to Cap. Backoffs are randomly
+// shortened by up to the Jitter fraction of them.
+type Exponential struct {
+	Base   time.Duration
+	Cap    time.Duration
+	Jitter float64
+}
+
+// Backoff returns how long to wait before a retry, given the number of
+// retries including this one.
+func (e Exponential) Backoff(retries int) time.Duration {
+	backoff := e.max(retries)
+	if e.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * e.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+// RetriesWithin returns the number of retries that can be made before total
+// backoff exceeds the budget. Jitter is ignored, that is backoffs are assumed
+// to be as long as they can get.
+func (e Exponential) RetriesWithin(budget time.Duration) int {
+	retries := 0
+	for total := e.max(1); total <= budget; total += e.max(retries + 1) {
+		retries++
+		// Once backoffs hit the cap they stop growing, so the rest can be
+		// calculated straight away.
+		if e.max(retries) >= e.Cap {
+			return retries + int((budget-total)/e.Cap)
+		}
+	}
+	return retries
+}
+
+// max returns the backoff before jitter is applied.
+func (e Exponential) max(retries int) time.Duration {
+	backoff := e.Base
+	for i := 1; i < retries && backoff < e.Cap; i++ {
+		backoff *= 2
+	}
+	if backoff > e.Cap {
+		return e.Cap
+	}
+	return backoff
+}
@@ -0,0 +1,70 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type BackoffSuite struct{}
+
+var _ = Suite(&BackoffSuite{})
+
+func (s *BackoffSuite) TestBackoff(c *C) {
+	e := Exponential{Base: time.Second, Cap: 10 * time.Second}
+	var backoffs []time.Duration
+	for retries := 1; retries <= 7; retries++ {
+		backoffs = append(backoffs, e.Backoff(retries))
+	}
+	c.Check(backoffs, DeepEquals, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second, 10 * time.Second,
+	})
+}
+
+// Jitter shortens backoffs by up to the configured fraction of them.
+func (s *BackoffSuite) TestBackoffJitter(c *C) {
+	e := Exponential{Base: time.Second, Cap: 10 * time.Second, Jitter: 0.2}
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		backoff := e.Backoff(3)
+		c.Assert(backoff <= 4*time.Second, Equals, true, Commentf("backoff=%v", backoff))
+		c.Assert(backoff >= 3200*time.Millisecond, Equals, true, Commentf("backoff=%v", backoff))
+		distinct[backoff] = true
+	}
+	c.Check(len(distinct) > 1, Equals, true)
+}
+
+func (s *BackoffSuite) TestRetriesWithin(c *C) {
+	e := Exponential{Base: time.Second, Cap: 10 * time.Second}
+	for i, tc := range []struct {
+		budget  time.Duration
+		retries int
+	}{
+		{budget: 0, retries: 0},
+		{budget: 999 * time.Millisecond, retries: 0},
+		{budget: time.Second, retries: 1},
+		{budget: 3 * time.Second, retries: 2},
+		{budget: 14 * time.Second, retries: 3},
+		{budget: 25 * time.Second, retries: 5},
+		{budget: 35 * time.Second, retries: 6},
+		{budget: 44 * time.Second, retries: 6},
+		{budget: 125 * time.Second, retries: 15},
+	} {
+		c.Check(e.RetriesWithin(tc.budget), Equals, tc.retries, Commentf("case #%d", i))
+	}
+}
+
+// If the base equals the cap, then backoffs are fixed.
+func (s *BackoffSuite) TestRetriesWithinFixed(c *C) {
+	e := Exponential{Base: 10 * time.Second, Cap: 10 * time.Second}
+	c.Check(e.Backoff(1), Equals, 10*time.Second)
+	c.Check(e.Backoff(5), Equals, 10*time.Second)
+	c.Check(e.RetriesWithin(59*time.Second), Equals, 5)
+	c.Check(e.RetriesWithin(60*time.Second), Equals, 6)
+}
@@ -0,0 +1,181 @@
+// Package brokerconn keeps track of connections that Kafka-Pixy opens to
+// Kafka brokers, and optionally caps their number per cluster. Every Kafka
+// client that Kafka-Pixy creates keeps its own connection to every broker it
+// talks to, so under partition heavy workloads the number of connections can
+// grow large enough to trip broker connection limits. Connections are tracked
+// process wide per cluster, so that all clients of a cluster share the cap.
+package brokerconn
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// ErrTooManyConnections is returned by Dial if the cluster has no free
+// connection slot and none got free while waiting.
+var ErrTooManyConnections = errors.New("too many broker connections")
+
+// Stats describes broker connections of a cluster.
+type Stats struct {
+	// Connections that are currently open.
+	Open int
+
+	// Dials that are waiting for a free connection slot.
+	Queued int
+
+	// The configured cap, zero means no cap.
+	MaxConnections int
+
+	// Dials that gave up waiting for a free connection slot since start.
+	Rejected int64
+}
+
+// Dialer opens connections to brokers of a cluster. It implements the
+// proxy.Dialer interface, that sarama accepts in `Net.Proxy.Dialer`.
+type Dialer struct {
+	maxConns    int
+	dialTimeout time.Duration
+	slots       chan none.T
+	open        int32
+	queued      int32
+	rejected    int64
+
+	secureMu sync.Mutex
+	secure   SecureFn
+}
+
+// SecureFn is called with every connection the dialer establishes, e.g. to
+// perform a TLS handshake and authenticate, and returns the connection that
+// should be used instead.
+type SecureFn func(conn net.Conn, addr string) (net.Conn, error)
+
+var (
+	mu      sync.Mutex
+	dialers = make(map[string]*Dialer)
+)
+
+// ForCluster returns the dialer of a cluster, creating it on the first call.
+// Up to maxConns connections can be open at a time, zero means no cap. If
+// there is no free slot, then a dial waits for one for at most dialTimeout,
+// that also bounds establishing the connection itself. Parameters of the
+// first call win, subsequent calls return the same dialer.
+func ForCluster(cluster string, maxConns int, dialTimeout time.Duration) *Dialer {
+	mu.Lock()
+	defer mu.Unlock()
+	if d := dialers[cluster]; d != nil {
+		return d
+	}
+	d := &Dialer{
+		maxConns:    maxConns,
+		dialTimeout: dialTimeout,
+	}
+	if maxConns > 0 {
+		d.slots = make(chan none.T, maxConns)
+	}
+	dialers[cluster] = d
+	return d
+}
+
+// Get returns broker connection stats of a cluster.
+func Get(cluster string) Stats {
+	mu.Lock()
+	d := dialers[cluster]
+	mu.Unlock()
+	if d == nil {
+		return Stats{}
+	}
+	return d.Stats()
+}
+
+// Stats returns broker connection stats of the dialer cluster.
+func (d *Dialer) Stats() Stats {
+	return Stats{
+		Open:           int(atomic.LoadInt32(&d.open)),
+		Queued:         int(atomic.LoadInt32(&d.queued)),
+		MaxConnections: d.maxConns,
+		Rejected:       atomic.LoadInt64(&d.rejected),
+	}
+}
+
+// Dial takes a connection slot, waiting for one if necessary, and connects
+// to the given address. The slot is freed when the connection is closed.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	if !d.acquire() {
+		atomic.AddInt64(&d.rejected, 1)
+		return nil, errors.Wrapf(ErrTooManyConnections, "max=%d, addr=%s", d.maxConns, addr)
+	}
+	dialer := net.Dialer{Timeout: d.dialTimeout}
+	conn, err := dialer.Dial(network, addr)
+	if err != nil {
+		d.release()
+		return nil, err
+	}
+	atomic.AddInt32(&d.open, 1)
+	conn = chaos.WrapBrokerConn(&trackedConn{Conn: conn, d: d})
+	d.secureMu.Lock()
+	secure := d.secure
+	d.secureMu.Unlock()
+	if secure == nil {
+		return conn, nil
+	}
+	return secure(conn, addr)
+}
+
+// Secure makes the dialer pass connections it establishes from now on to the
+// given function. Nil means that connections are used as established.
+func (d *Dialer) Secure(fn SecureFn) {
+	d.secureMu.Lock()
+	d.secure = fn
+	d.secureMu.Unlock()
+}
+
+func (d *Dialer) acquire() bool {
+	if d.slots == nil {
+		return true
+	}
+	select {
+	case d.slots <- none.V:
+		return true
+	default:
+	}
+	atomic.AddInt32(&d.queued, 1)
+	defer atomic.AddInt32(&d.queued, -1)
+	timer := time.NewTimer(d.dialTimeout)
+	defer timer.Stop()
+	select {
+	case d.slots <- none.V:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (d *Dialer) release() {
+	if d.slots == nil {
+		return
+	}
+	<-d.slots
+}
+
+// trackedConn frees the connection slot when closed. Sarama may close a
+// connection more than once, so only the first close counts.
+type trackedConn struct {
+	net.Conn
+	d         *Dialer
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		atomic.AddInt32(&c.d.open, -1)
+		c.d.release()
+	})
+	return err
+}
@@ -0,0 +1,143 @@
+package brokerconn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type BrokerConnSuite struct {
+	listener net.Listener
+}
+
+var _ = Suite(&BrokerConnSuite{})
+
+func (s *BrokerConnSuite) SetUpTest(c *C) {
+	mu.Lock()
+	dialers = make(map[string]*Dialer)
+	mu.Unlock()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+}
+
+func (s *BrokerConnSuite) TearDownTest(c *C) {
+	s.listener.Close()
+}
+
+// Open connections are counted, and closing a connection more than once
+// does not make the count go off.
+func (s *BrokerConnSuite) TestCount(c *C) {
+	d := ForCluster("count", 0, time.Second)
+	c.Check(ForCluster("count", 5, time.Minute), Equals, d)
+
+	// When
+	conn1, err := d.Dial("tcp", s.listener.Addr().String())
+	c.Assert(err, IsNil)
+	conn2, err := d.Dial("tcp", s.listener.Addr().String())
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Check(Get("count"), DeepEquals, Stats{Open: 2})
+	conn1.Close()
+	conn1.Close()
+	c.Check(Get("count"), DeepEquals, Stats{Open: 1})
+	conn2.Close()
+	c.Check(Get("count"), DeepEquals, Stats{})
+	c.Check(Get("unknown"), DeepEquals, Stats{})
+}
+
+// When all slots are taken, a dial waits for a free one for at most the
+// dial timeout.
+func (s *BrokerConnSuite) TestCap(c *C) {
+	d := ForCluster("cap", 1, 200*time.Millisecond)
+	conn1, err := d.Dial("tcp", s.listener.Addr().String())
+	c.Assert(err, IsNil)
+
+	// When
+	begin := time.Now()
+	_, err = d.Dial("tcp", s.listener.Addr().String())
+
+	// Then
+	c.Check(err, ErrorMatches, "max=1, addr=.*: too many broker connections")
+	c.Check(time.Since(begin) >= 200*time.Millisecond, Equals, true)
+	c.Check(Get("cap"), DeepEquals, Stats{Open: 1, MaxConnections: 1, Rejected: 1})
+
+	// When: a slot gets free while a dial is queued
+	connCh := make(chan net.Conn)
+	go func() {
+		conn, err := d.Dial("tcp", s.listener.Addr().String())
+		c.Check(err, IsNil)
+		connCh <- conn
+	}()
+	for i := 0; i < 100 && d.Stats().Queued == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(d.Stats().Queued, Equals, 1)
+	conn1.Close()
+
+	// Then
+	conn2 := <-connCh
+	c.Check(Get("cap"), DeepEquals, Stats{Open: 1, MaxConnections: 1, Rejected: 1})
+	conn2.Close()
+}
+
+// A failed dial frees its slot.
+func (s *BrokerConnSuite) TestDialError(c *C) {
+	d := ForCluster("error", 1, 200*time.Millisecond)
+	addr := s.listener.Addr().String()
+	s.listener.Close()
+
+	// When
+	_, err := d.Dial("tcp", addr)
+
+	// Then
+	c.Check(err, NotNil)
+	c.Check(Get("error"), DeepEquals, Stats{MaxConnections: 1})
+}
+
+// Established connections are passed through the secure function, and if it
+// fails, then the connection slot is freed.
+func (s *BrokerConnSuite) TestSecure(c *C) {
+	d := ForCluster("secure", 1, 200*time.Millisecond)
+	var securedAddr string
+	d.Secure(func(conn net.Conn, addr string) (net.Conn, error) {
+		securedAddr = addr
+		conn.Close()
+		return nil, errors.New("handshake failed")
+	})
+
+	// When
+	_, err := d.Dial("tcp", s.listener.Addr().String())
+
+	// Then
+	c.Check(err, ErrorMatches, "handshake failed")
+	c.Check(securedAddr, Equals, s.listener.Addr().String())
+	c.Check(Get("secure"), DeepEquals, Stats{MaxConnections: 1})
+
+	// When
+	d.Secure(nil)
+	conn, err := d.Dial("tcp", s.listener.Addr().String())
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(Get("secure"), DeepEquals, Stats{Open: 1, MaxConnections: 1})
+	conn.Close()
+}
@@ -0,0 +1,29 @@
+// Package buildinfo tells which build of Kafka-Pixy is running. The version
+// and the commit are set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/mailgun/kafka-pixy/buildinfo.Version=v0.18.0"
+package buildinfo
+
+import (
+	"time"
+)
+
+var (
+	// Version is the release version that Kafka-Pixy was built from.
+	Version = "dev-build"
+
+	// Commit is the git commit that Kafka-Pixy was built from.
+	Commit = ""
+
+	startedAt = time.Now()
+)
+
+// StartedAt returns the time the process started at.
+func StartedAt() time.Time {
+	return startedAt
+}
+
+// Uptime returns how long the process has been running.
+func Uptime() time.Duration {
+	return time.Since(startedAt)
+}
@@ -0,0 +1,44 @@
+// Package chaos injects faults into Kafka-Pixy, so that integration tests can
+// verify redelivery and rebalance behavior deterministically: broker
+// connections can be dropped, responses from ZooKeeper delayed, and a share
+// of offset commits failed. Faults are controlled via the `/_chaos` debug
+// HTTP endpoint.
+//
+// Fault injection is only compiled in with the `chaos` build tag, e.g.
+// `go build -tags chaos`. In regular builds all hooks are no-ops, `Enabled`
+// is false, and faults cannot be set.
+package chaos
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInjected is returned by operations that fail due to an injected
+	// fault.
+	ErrInjected = errors.New("injected fault")
+
+	// ErrDisabled is returned by Set if fault injection is not compiled in.
+	ErrDisabled = errors.New("fault injection is not compiled in, build with -tags chaos")
+)
+
+// Faults describes faults that are injected.
+type Faults struct {
+	// Every response read from ZooKeeper is delayed by this much.
+	ZooKeeperDelay time.Duration
+
+	// Percentage of offset commit requests that fail without being sent.
+	CommitFailurePercent int
+}
+
+func (f Faults) validate() error {
+	if f.ZooKeeperDelay < 0 {
+		return errors.New("ZooKeeper delay must be >= 0")
+	}
+	if f.CommitFailurePercent < 0 || f.CommitFailurePercent > 100 {
+		return errors.New("commit failure percent must be within [0, 100]")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build !chaos
+// +build !chaos
+
+package chaos
+
+import (
+	"net"
+	"time"
+)
+
+// Enabled tells whether fault injection is compiled in.
+const Enabled = false
+
+// Get returns faults that are currently injected.
+func Get() Faults {
+	return Faults{}
+}
+
+// Set replaces faults that are injected.
+func Set(f Faults) error {
+	return ErrDisabled
+}
+
+// DropBrokerConns closes all open broker connections and returns their
+// number.
+func DropBrokerConns() int {
+	return 0
+}
+
+// WrapBrokerConn makes a broker connection droppable by `DropBrokerConns`.
+func WrapBrokerConn(conn net.Conn) net.Conn {
+	return conn
+}
+
+// DialZooKeeper implements `zk.Dialer`.
+func DialZooKeeper(network, addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, addr, timeout)
+}
+
+// FailCommit returns `ErrInjected` for `Faults.CommitFailurePercent` percent
+// of calls.
+func FailCommit() error {
+	return nil
+}
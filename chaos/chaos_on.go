@@ -0,0 +1,109 @@
+//go:build chaos
+// +build chaos
+
+package chaos
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// Enabled tells whether fault injection is compiled in.
+const Enabled = true
+
+var (
+	mu          sync.Mutex
+	faults      Faults
+	brokerConns = make(map[*brokerConn]none.T)
+)
+
+// Get returns faults that are currently injected.
+func Get() Faults {
+	mu.Lock()
+	defer mu.Unlock()
+	return faults
+}
+
+// Set replaces faults that are injected.
+func Set(f Faults) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	faults = f
+	mu.Unlock()
+	return nil
+}
+
+// DropBrokerConns closes all open broker connections and returns their
+// number. Kafka clients reconnect as they would after a network failure.
+func DropBrokerConns() int {
+	mu.Lock()
+	conns := make([]*brokerConn, 0, len(brokerConns))
+	for conn := range brokerConns {
+		conns = append(conns, conn)
+	}
+	mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// WrapBrokerConn makes a broker connection droppable by `DropBrokerConns`.
+func WrapBrokerConn(conn net.Conn) net.Conn {
+	bc := &brokerConn{Conn: conn}
+	mu.Lock()
+	brokerConns[bc] = none.V
+	mu.Unlock()
+	return bc
+}
+
+// DialZooKeeper implements `zk.Dialer`. Responses read from connections that
+// it makes are delayed by `Faults.ZooKeeperDelay`.
+func DialZooKeeper(network, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &zkConn{Conn: conn}, nil
+}
+
+// FailCommit returns `ErrInjected` for `Faults.CommitFailurePercent` percent
+// of calls. It is called before every offset commit request.
+func FailCommit() error {
+	mu.Lock()
+	percent := faults.CommitFailurePercent
+	mu.Unlock()
+	if percent > 0 && rand.Intn(100) < percent {
+		return ErrInjected
+	}
+	return nil
+}
+
+type brokerConn struct {
+	net.Conn
+}
+
+func (c *brokerConn) Close() error {
+	mu.Lock()
+	delete(brokerConns, c)
+	mu.Unlock()
+	return c.Conn.Close()
+}
+
+type zkConn struct {
+	net.Conn
+}
+
+func (c *zkConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if delay := Get().ZooKeeperDelay; delay > 0 {
+		time.Sleep(delay)
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+//go:build chaos
+// +build chaos
+
+package chaos
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ChaosSuite struct{}
+
+var _ = Suite(&ChaosSuite{})
+
+func (s *ChaosSuite) TearDownTest(c *C) {
+	Set(Faults{})
+}
+
+func (s *ChaosSuite) TestSetInvalid(c *C) {
+	for i, tc := range []struct {
+		faults Faults
+		error  string
+	}{{
+		faults: Faults{ZooKeeperDelay: -time.Second},
+		error:  "ZooKeeper delay must be >= 0",
+	}, {
+		faults: Faults{CommitFailurePercent: 101},
+		error:  "commit failure percent must be within \\[0, 100\\]",
+	}} {
+		// When
+		err := Set(tc.faults)
+
+		// Then
+		c.Check(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+		c.Check(Get(), DeepEquals, Faults{}, Commentf("case #%d", i))
+	}
+}
+
+func (s *ChaosSuite) TestFailCommit(c *C) {
+	for i, tc := range []struct {
+		percent int
+		failed  int
+	}{
+		{percent: 0, failed: 0},
+		{percent: 100, failed: 100},
+	} {
+		c.Assert(Set(Faults{CommitFailurePercent: tc.percent}), IsNil)
+
+		// When
+		failed := 0
+		for j := 0; j < 100; j++ {
+			if FailCommit() == ErrInjected {
+				failed++
+			}
+		}
+
+		// Then
+		c.Check(failed, Equals, tc.failed, Commentf("case #%d", i))
+	}
+}
+
+// Dropped connections are closed, and only open ones are dropped.
+func (s *ChaosSuite) TestDropBrokerConns(c *C) {
+	conn1, peer1 := net.Pipe()
+	conn2, peer2 := net.Pipe()
+	defer peer1.Close()
+	defer peer2.Close()
+	wrapped1 := WrapBrokerConn(conn1)
+	WrapBrokerConn(conn2)
+	wrapped1.Close()
+
+	// When
+	dropped := DropBrokerConns()
+
+	// Then
+	c.Check(dropped, Equals, 1)
+	_, err := peer2.Read(make([]byte, 1))
+	c.Check(err, NotNil)
+	c.Check(DropBrokerConns(), Equals, 0)
+}
+
+func (s *ChaosSuite) TestZooKeeperDelay(c *C) {
+	c.Assert(Set(Faults{ZooKeeperDelay: 100 * time.Millisecond}), IsNil)
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	go peer.Write([]byte("x"))
+	zkc := &zkConn{Conn: conn}
+	startedAt := time.Now()
+
+	// When
+	_, err := zkc.Read(make([]byte, 1))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(time.Since(startedAt) >= 100*time.Millisecond, Equals, true)
+}
@@ -0,0 +1,220 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/pkg/errors"
+)
+
+// Assembler collects chunks of messages consumed by groups and reassembles
+// them. Chunks of messages in progress are kept in memory, until the
+// configured limit is reached, after that they are spilled to disk.
+type Assembler struct {
+	actDesc   *actor.Descriptor
+	spillDir  string
+	maxMemory int64
+	timeout   time.Duration
+	now       func() time.Time
+
+	mu         sync.Mutex
+	assemblies map[assemblyID]*assembly
+	memory     int64
+}
+
+type assemblyID struct {
+	group     string
+	topic     string
+	partition int32
+	id        string
+}
+
+type assembly struct {
+	info      info
+	parts     []part
+	received  int
+	startedAt time.Time
+	latest    consumer.Message
+}
+
+type part struct {
+	received bool
+	offset   int64
+	data     []byte
+	// Path to a file that the chunk data is spilled to.
+	path string
+}
+
+// Expired describes chunks of a message that was not reassembled in time.
+type Expired struct {
+	Msg     consumer.Message
+	Offsets []int64
+}
+
+// NewAssembler creates a chunk assembler. If spillDir is empty, then chunks
+// are spilled to the default temporary directory.
+func NewAssembler(actDesc *actor.Descriptor, spillDir string, maxMemory int64, timeout time.Duration) *Assembler {
+	if spillDir == "" {
+		spillDir = os.TempDir()
+	}
+	return &Assembler{
+		actDesc:    actDesc,
+		spillDir:   spillDir,
+		maxMemory:  maxMemory,
+		timeout:    timeout,
+		now:        time.Now,
+		assemblies: make(map[assemblyID]*assembly),
+	}
+}
+
+// Add adds a chunk consumed by a group. When the last missing chunk of a
+// message is added, the reassembled message is returned along with offsets
+// of all its chunks, and true. The reassembled message has the partition
+// and offset of the chunk that completed it. A chunk that was already added,
+// e.g. because it was offered again, is ignored.
+func (a *Assembler) Add(group, topic string, msg consumer.Message) (consumer.Message, []int64, bool, error) {
+	ci, err := parseInfo(&msg.ConsumerMessage)
+	if err != nil {
+		return consumer.Message{}, nil, false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	asmID := assemblyID{group, topic, msg.Partition, ci.id}
+	asm := a.assemblies[asmID]
+	if asm == nil {
+		asm = &assembly{
+			parts:     make([]part, ci.count),
+			startedAt: a.now(),
+		}
+		a.assemblies[asmID] = asm
+	}
+	if len(asm.parts) != ci.count {
+		return consumer.Message{}, nil, false, errors.Errorf("chunk count mismatch: id=%s, %d != %d",
+			ci.id, ci.count, len(asm.parts))
+	}
+	p := &asm.parts[ci.index]
+	if p.received {
+		return consumer.Message{}, nil, false, nil
+	}
+	p.received = true
+	p.offset = msg.Offset
+	if ci.index == 0 {
+		asm.info = ci
+	}
+	if asm.received == 0 || msg.Offset > asm.latest.Offset {
+		asm.latest = msg
+	}
+	asm.received++
+	if asm.received < len(asm.parts) {
+		a.store(p, msg.Value)
+		return consumer.Message{}, nil, false, nil
+	}
+
+	delete(a.assemblies, asmID)
+	defer a.release(asm)
+	var value bytes.Buffer
+	offsets := make([]int64, len(asm.parts))
+	for i := range asm.parts {
+		data := msg.Value
+		if i != ci.index {
+			if data, err = a.load(&asm.parts[i]); err != nil {
+				return consumer.Message{}, nil, false, err
+			}
+		}
+		value.Write(data)
+		offsets[i] = asm.parts[i].offset
+	}
+	assembled := asm.latest
+	assembled.Value = value.Bytes()
+	assembled.Headers = asm.info.headers
+	if asm.info.keyless {
+		assembled.Key = nil
+	}
+	return assembled, offsets, true, nil
+}
+
+// Expire removes messages that have not been reassembled within the timeout
+// and returns chunks that they consisted of.
+func (a *Assembler) Expire() []Expired {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var expired []Expired
+	deadline := a.now().Add(-a.timeout)
+	for asmID, asm := range a.assemblies {
+		if asm.startedAt.After(deadline) {
+			continue
+		}
+		delete(a.assemblies, asmID)
+		a.release(asm)
+		exp := Expired{Msg: asm.latest}
+		for _, p := range asm.parts {
+			if p.received {
+				exp.Offsets = append(exp.Offsets, p.offset)
+			}
+		}
+		expired = append(expired, exp)
+	}
+	return expired
+}
+
+// Close drops all messages in progress and removes spill files.
+func (a *Assembler) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for asmID, asm := range a.assemblies {
+		delete(a.assemblies, asmID)
+		a.release(asm)
+	}
+}
+
+// store keeps chunk data in memory if the limit permits, otherwise it
+// spills the data to a file.
+func (a *Assembler) store(p *part, data []byte) {
+	if a.memory+int64(len(data)) <= a.maxMemory {
+		p.data = data
+		a.memory += int64(len(data))
+		return
+	}
+	f, err := ioutil.TempFile(a.spillDir, "kafka-pixy-chunk-")
+	if err == nil {
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			p.path = f.Name()
+			return
+		}
+		os.Remove(f.Name())
+	}
+	a.actDesc.Log().WithError(err).Error("Failed to spill chunk, keeping it in memory")
+	p.data = data
+	a.memory += int64(len(data))
+}
+
+func (a *Assembler) load(p *part) ([]byte, error) {
+	if p.path == "" {
+		return p.data, nil
+	}
+	data, err := ioutil.ReadFile(p.path)
+	return data, errors.Wrap(err, "failed to read spilled chunk")
+}
+
+// release frees memory and spill files occupied by a message in progress.
+func (a *Assembler) release(asm *assembly) {
+	for i := range asm.parts {
+		p := &asm.parts[i]
+		if p.path != "" {
+			os.Remove(p.path)
+			p.path = ""
+		} else if p.data != nil {
+			a.memory -= int64(len(p.data))
+		}
+		p.data = nil
+	}
+}
@@ -0,0 +1,117 @@
+// Package chunk implements splitting of messages that exceed the broker
+// message size limit into chunks, and reassembly of chunks back into
+// messages on consume.
+//
+// Chunks of a message are produced with the same key, so that they end up
+// in the same partition, and carry sequencing headers. Headers of the
+// original message are carried by the first chunk. If the original message
+// does not have a key, then the chunk ID is used as the key, and the
+// reassembled message gets the nil key back.
+package chunk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+const (
+	HeaderID      = "X-Kafka-Pixy-Chunk-Id"
+	HeaderIndex   = "X-Kafka-Pixy-Chunk-Index"
+	HeaderCount   = "X-Kafka-Pixy-Chunk-Count"
+	HeaderKeyless = "X-Kafka-Pixy-Chunk-Keyless"
+)
+
+// Chunk is a part of a message to be produced.
+type Chunk struct {
+	Key     []byte
+	Value   []byte
+	Headers []sarama.RecordHeader
+}
+
+// Split splits a message value into chunks of the specified size.
+func Split(key, value []byte, headers []sarama.RecordHeader, size int) []Chunk {
+	id := newID()
+	keyless := key == nil
+	if keyless {
+		key = []byte(id)
+	}
+	count := (len(value) + size - 1) / size
+	chunks := make([]Chunk, count)
+	for i := range chunks {
+		end := (i + 1) * size
+		if end > len(value) {
+			end = len(value)
+		}
+		var chunkHeaders []sarama.RecordHeader
+		if i == 0 {
+			chunkHeaders = append(chunkHeaders, headers...)
+		}
+		chunkHeaders = append(chunkHeaders,
+			sarama.RecordHeader{Key: []byte(HeaderID), Value: []byte(id)},
+			sarama.RecordHeader{Key: []byte(HeaderIndex), Value: []byte(strconv.Itoa(i))},
+			sarama.RecordHeader{Key: []byte(HeaderCount), Value: []byte(strconv.Itoa(count))})
+		if keyless {
+			chunkHeaders = append(chunkHeaders, sarama.RecordHeader{Key: []byte(HeaderKeyless), Value: []byte("1")})
+		}
+		chunks[i] = Chunk{Key: key, Value: value[i*size : end], Headers: chunkHeaders}
+	}
+	return chunks
+}
+
+// IsChunk returns true if a message is a chunk of a larger message.
+func IsChunk(msg *sarama.ConsumerMessage) bool {
+	for _, h := range msg.Headers {
+		if string(h.Key) == HeaderID {
+			return true
+		}
+	}
+	return false
+}
+
+// info is chunk sequencing data extracted from message headers.
+type info struct {
+	id      string
+	index   int
+	count   int
+	keyless bool
+	// Headers of the original message.
+	headers []*sarama.RecordHeader
+}
+
+func parseInfo(msg *sarama.ConsumerMessage) (info, error) {
+	var ci info
+	var err error
+	ci.index, ci.count = -1, -1
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case HeaderID:
+			ci.id = string(h.Value)
+		case HeaderIndex:
+			if ci.index, err = strconv.Atoi(string(h.Value)); err != nil {
+				return info{}, errors.Wrap(err, "bad chunk index")
+			}
+		case HeaderCount:
+			if ci.count, err = strconv.Atoi(string(h.Value)); err != nil {
+				return info{}, errors.Wrap(err, "bad chunk count")
+			}
+		case HeaderKeyless:
+			ci.keyless = true
+		default:
+			ci.headers = append(ci.headers, h)
+		}
+	}
+	if ci.id == "" || ci.count <= 0 || ci.index < 0 || ci.index >= ci.count {
+		return info{}, errors.Errorf("bad chunk headers: id=%s, index=%d, count=%d", ci.id, ci.index, ci.count)
+	}
+	return ci, nil
+}
+
+func newID() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
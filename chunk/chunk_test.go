@@ -0,0 +1,189 @@
+package chunk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ChunkSuite struct {
+	ns     *actor.Descriptor
+	tmpDir string
+}
+
+var _ = Suite(&ChunkSuite{})
+
+func (s *ChunkSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ChunkSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	var err error
+	s.tmpDir, err = ioutil.TempDir("", "chunk_test")
+	c.Assert(err, IsNil)
+}
+
+func (s *ChunkSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.tmpDir)
+}
+
+func (s *ChunkSuite) TestSplit(c *C) {
+	headers := []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}}
+
+	// When
+	chunks := Split([]byte("key"), []byte("0123456789"), headers, 4)
+
+	// Then
+	c.Assert(len(chunks), Equals, 3)
+	c.Check(string(chunks[0].Value), Equals, "0123")
+	c.Check(string(chunks[1].Value), Equals, "4567")
+	c.Check(string(chunks[2].Value), Equals, "89")
+	for i, ch := range chunks {
+		c.Check(string(ch.Key), Equals, "key")
+		ci, err := parseInfo(toConsumerMessage(ch, int64(i)))
+		c.Assert(err, IsNil)
+		c.Check(ci.index, Equals, i)
+		c.Check(ci.count, Equals, 3)
+		c.Check(ci.keyless, Equals, false)
+	}
+	c.Check(chunks[0].Headers[0], DeepEquals, headers[0])
+	c.Check(len(chunks[1].Headers), Equals, 3)
+}
+
+// Chunks are reassembled regardless of the order they arrive in, and the
+// reassembled message has the offset of the chunk that completed it.
+func (s *ChunkSuite) TestAssembleOutOfOrder(c *C) {
+	headers := []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}}
+	chunks := Split([]byte("key"), []byte("0123456789"), headers, 4)
+	a := NewAssembler(s.ns, s.tmpDir, 1024, time.Minute)
+
+	// When
+	_, _, ok, err := a.Add("g", "t", toMsg(chunks[2], 12))
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+	_, _, ok, err = a.Add("g", "t", toMsg(chunks[0], 10))
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+	msg, offsets, ok, err := a.Add("g", "t", toMsg(chunks[1], 11))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Check(string(msg.Key), Equals, "key")
+	c.Check(string(msg.Value), Equals, "0123456789")
+	c.Check(msg.Offset, Equals, int64(12))
+	c.Check(msg.Headers, DeepEquals, []*sarama.RecordHeader{&headers[0]})
+	c.Check(offsets, DeepEquals, []int64{10, 11, 12})
+	c.Check(a.memory, Equals, int64(0))
+}
+
+// A chunk offered again is ignored.
+func (s *ChunkSuite) TestAssembleRedelivery(c *C) {
+	chunks := Split(nil, []byte("0123456789"), nil, 6)
+	a := NewAssembler(s.ns, s.tmpDir, 1024, time.Minute)
+
+	// When
+	_, _, ok, err := a.Add("g", "t", toMsg(chunks[0], 0))
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+	_, _, ok, err = a.Add("g", "t", toMsg(chunks[0], 0))
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+	msg, offsets, ok, err := a.Add("g", "t", toMsg(chunks[1], 1))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Check(msg.Key, IsNil)
+	c.Check(string(msg.Value), Equals, "0123456789")
+	c.Check(offsets, DeepEquals, []int64{0, 1})
+}
+
+// Chunks that do not fit into memory are spilled to disk.
+func (s *ChunkSuite) TestAssembleSpill(c *C) {
+	chunks := Split([]byte("key"), []byte("0123456789"), nil, 4)
+	a := NewAssembler(s.ns, s.tmpDir, 5, time.Minute)
+
+	// When
+	_, _, _, err := a.Add("g", "t", toMsg(chunks[0], 0))
+	c.Assert(err, IsNil)
+	_, _, _, err = a.Add("g", "t", toMsg(chunks[1], 1))
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Check(a.memory, Equals, int64(4))
+	files, err := ioutil.ReadDir(s.tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(len(files), Equals, 1)
+
+	// When
+	msg, _, ok, err := a.Add("g", "t", toMsg(chunks[2], 2))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Check(string(msg.Value), Equals, "0123456789")
+	files, err = ioutil.ReadDir(s.tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(len(files), Equals, 0)
+	c.Check(a.memory, Equals, int64(0))
+}
+
+func (s *ChunkSuite) TestExpire(c *C) {
+	chunks := Split([]byte("key"), []byte("0123456789"), nil, 4)
+	now := time.Unix(1000, 0)
+	a := NewAssembler(s.ns, s.tmpDir, 1024, time.Minute)
+	a.now = func() time.Time { return now }
+	_, _, _, err := a.Add("g", "t", toMsg(chunks[0], 7))
+	c.Assert(err, IsNil)
+	_, _, _, err = a.Add("g", "t", toMsg(chunks[2], 9))
+	c.Assert(err, IsNil)
+	c.Check(a.Expire(), IsNil)
+
+	// When
+	now = now.Add(time.Minute)
+	expired := a.Expire()
+
+	// Then
+	c.Assert(len(expired), Equals, 1)
+	c.Check(expired[0].Offsets, DeepEquals, []int64{7, 9})
+	c.Check(len(a.assemblies), Equals, 0)
+	c.Check(a.memory, Equals, int64(0))
+}
+
+func (s *ChunkSuite) TestBadHeaders(c *C) {
+	msg := consumer.Message{ConsumerMessage: sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{{Key: []byte(HeaderID), Value: []byte("foo")}},
+	}}
+	a := NewAssembler(s.ns, s.tmpDir, 1024, time.Minute)
+
+	// When
+	_, _, _, err := a.Add("g", "t", msg)
+
+	// Then
+	c.Check(err, ErrorMatches, "bad chunk headers: id=foo, index=-1, count=-1")
+}
+
+func toConsumerMessage(ch Chunk, offset int64) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Key: ch.Key, Value: ch.Value, Offset: offset}
+	for i := range ch.Headers {
+		msg.Headers = append(msg.Headers, &ch.Headers[i])
+	}
+	return msg
+}
+
+func toMsg(ch Chunk, offset int64) consumer.Message {
+	return consumer.Message{ConsumerMessage: *toConsumerMessage(ch, offset)}
+}
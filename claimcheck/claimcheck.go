@@ -0,0 +1,335 @@
+// Package claimcheck implements the claim-check pattern: large message
+// values are uploaded to S3 compatible object storage, and only references
+// to them are produced to Kafka. The references are resolved back to the
+// values on consume.
+//
+// Requests are signed with AWS Signature Version 4, that is understood by
+// AWS S3, MinIO, and Google Cloud Storage in the interoperability mode.
+package claimcheck
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Header that references an offloaded value. Its value has the form
+	// `<bucket>/<object>`.
+	Header = "X-Kafka-Pixy-Claim-Check"
+
+	// Header that is added to a consumed message, along with the reference,
+	// if the value it references can never be fetched.
+	ErrorHeader = "X-Kafka-Pixy-Claim-Check-Error"
+
+	defaultRegion  = "us-east-1"
+	defaultTimeout = 30 * time.Second
+
+	amzDateFormat = "20060102T150405Z"
+	signAlgorithm = "AWS4-HMAC-SHA256"
+)
+
+var (
+	// ErrBadReference is returned by Get for a reference that does not
+	// point into the bucket and prefix of the store.
+	ErrBadReference = errors.New("bad claim-check reference")
+
+	// ErrNotConfigured is returned when a message with a reference is
+	// consumed from a topic that has no claim-check store.
+	ErrNotConfigured = errors.New("claim-check is not configured")
+)
+
+// statusError is returned when object storage responds with a status
+// other than 200 OK.
+type statusError struct {
+	method string
+	ref    string
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.ref, e.msg)
+}
+
+// IsPermanent tells whether a claim-check error is going to be returned
+// again however many times the request is retried, e.g. because the
+// referenced object does not exist or access to it is denied.
+func IsPermanent(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *statusError:
+		return cause.status >= 400 && cause.status < 500 &&
+			cause.status != http.StatusRequestTimeout && cause.status != http.StatusTooManyRequests
+	default:
+		return cause == ErrBadReference || cause == ErrNotConfigured
+	}
+}
+
+// Store uploads values to and downloads them from object storage.
+type Store struct {
+	cfg     config.ClaimCheck
+	httpClt *http.Client
+	now     func() time.Time
+}
+
+// New creates a store for a topic claim-check configuration.
+func New(cfg config.ClaimCheck) *Store {
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &Store{
+		cfg:     cfg,
+		httpClt: &http.Client{Timeout: cfg.Timeout},
+		now:     time.Now,
+	}
+}
+
+// Threshold returns the size in bytes that values larger than should be
+// offloaded.
+func (s *Store) Threshold() int {
+	return s.cfg.Threshold
+}
+
+// Put uploads a value and returns a reference to it.
+func (s *Store) Put(value []byte) (string, error) {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return s.PutObject(hex.EncodeToString(token), value)
+}
+
+// PutObject uploads a value as an object with the given name, that is
+// relative to the store prefix, and returns a reference to it.
+func (s *Store) PutObject(name string, value []byte) (string, error) {
+	ref := s.cfg.Bucket + "/" + s.cfg.Prefix + name
+	rs, err := s.do(http.MethodPut, ref, nil, value)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to upload value")
+	}
+	rs.Body.Close()
+	return ref, nil
+}
+
+// Get downloads a value by a reference returned by Put. References come from
+// message headers that producers could have set to anything, so only those
+// that point into the bucket and prefix of the store are fetched, lest the
+// store credentials are used to read arbitrary objects.
+func (s *Store) Get(ref string) ([]byte, error) {
+	prefix := s.cfg.Bucket + "/" + s.cfg.Prefix
+	if !strings.HasPrefix(ref, prefix) || len(ref) == len(prefix) {
+		return nil, errors.Wrap(ErrBadReference, ref)
+	}
+	rs, err := s.do(http.MethodGet, ref, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download value")
+	}
+	defer rs.Body.Close()
+	value, err := ioutil.ReadAll(rs.Body)
+	return value, errors.Wrap(err, "failed to download value")
+}
+
+// GetObject downloads an object by a name relative to the store prefix.
+func (s *Store) GetObject(name string) ([]byte, error) {
+	return s.Get(s.cfg.Bucket + "/" + s.cfg.Prefix + name)
+}
+
+// ListObjects returns names of all objects, relative to the store prefix,
+// that start with the given prefix. Names are sorted lexicographically.
+func (s *Store) ListObjects(prefix string) ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+	for {
+		rs, err := s.do(http.MethodGet, s.cfg.Bucket, query, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list objects")
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(rs.Body).Decode(&result)
+		rs.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse object list")
+		}
+		for _, content := range result.Contents {
+			names = append(names, strings.TrimPrefix(content.Key, s.cfg.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// listBucketResult is a response to a ListObjectsV2 request.
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+func (s *Store) do(method, ref string, query url.Values, body []byte) (*http.Response, error) {
+	uri := "/" + escapePath(ref)
+	canonicalQuery := canonicalQueryString(query)
+	rawURL := s.cfg.Endpoint + uri
+	if canonicalQuery != "" {
+		rawURL += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, uri, canonicalQuery, body)
+	rs, err := s.httpClt.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		return nil, &statusError{method, ref, rs.StatusCode, strings.TrimSpace(rs.Status + " " + string(msg))}
+	}
+	return rs, nil
+}
+
+// sign adds AWS Signature Version 4 authorization headers to a request.
+func (s *Store) sign(req *http.Request, uri, canonicalQuery string, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.AccessKeyID == "" {
+		return // Anonymous access.
+	}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		canonicalQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := signAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(signingKey(s.cfg.SecretAccessKey, now, s.cfg.Region, "s3"), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func signingKey(secret string, now time.Time, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), now.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath escapes an object path as S3 expects, that is every byte
+// except unreserved characters and slashes is percent-encoded.
+func escapePath(path string) string {
+	var buf strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+// canonicalQueryString returns query parameters sorted by name, with names
+// and values escaped as S3 expects, that is the same way as object paths
+// except that slashes are percent-encoded too.
+func canonicalQueryString(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, escapeQuery(name)+"="+escapeQuery(value))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.Replace(escapePath(s), "/", "%2F", -1)
+}
+
+// Reference returns the claim-check reference of a consumed message, or an
+// empty string if the message value was not offloaded.
+func Reference(msg *sarama.ConsumerMessage) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == Header {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// StripProducedHeader returns headers of a message to be produced without a
+// claim-check reference, that can only be set by Kafka-Pixy itself.
+func StripProducedHeader(headers []sarama.RecordHeader) []sarama.RecordHeader {
+	for i, h := range headers {
+		if string(h.Key) != Header {
+			continue
+		}
+		stripped := make([]sarama.RecordHeader, i, len(headers)-1)
+		copy(stripped, headers[:i])
+		for _, h := range headers[i+1:] {
+			if string(h.Key) != Header {
+				stripped = append(stripped, h)
+			}
+		}
+		return stripped
+	}
+	return headers
+}
+
+// StripHeader returns message headers without the claim-check reference.
+func StripHeader(headers []*sarama.RecordHeader) []*sarama.RecordHeader {
+	var stripped []*sarama.RecordHeader
+	for _, h := range headers {
+		if string(h.Key) != Header {
+			stripped = append(stripped, h)
+		}
+	}
+	return stripped
+}
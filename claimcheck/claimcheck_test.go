@@ -0,0 +1,225 @@
+package claimcheck
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ClaimCheckSuite struct {
+	objectsMu sync.Mutex
+	objects   map[string][]byte
+	authz     []string
+	srv       *httptest.Server
+}
+
+var _ = Suite(&ClaimCheckSuite{})
+
+func (s *ClaimCheckSuite) SetUpTest(c *C) {
+	s.objects = make(map[string][]byte)
+	s.authz = nil
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.objectsMu.Lock()
+		defer s.objectsMu.Unlock()
+		s.authz = append(s.authz, r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			s.objects[r.URL.Path] = body
+		case http.MethodGet:
+			if r.URL.Query().Get("list-type") == "2" {
+				s.listObjects(w, r)
+				return
+			}
+			body, ok := s.objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+}
+
+func (s *ClaimCheckSuite) TearDownTest(c *C) {
+	s.srv.Close()
+}
+
+// listObjects responds to a ListObjectsV2 request with at most two objects
+// per page, so that pagination is exercised.
+func (s *ClaimCheckSuite) listObjects(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Path + "/" + r.URL.Query().Get("prefix")
+	var keys []string
+	for path := range s.objects {
+		if strings.HasPrefix(path, prefix) && path > r.URL.Path+"/"+r.URL.Query().Get("continuation-token") {
+			keys = append(keys, strings.TrimPrefix(path, r.URL.Path+"/"))
+		}
+	}
+	sort.Strings(keys)
+	fmt.Fprint(w, "<ListBucketResult>")
+	if len(keys) > 2 {
+		keys = keys[:2]
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[1])
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>1</Size></Contents>", key)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func (s *ClaimCheckSuite) TestPutGet(c *C) {
+	store := New(config.ClaimCheck{
+		Endpoint:        s.srv.URL + "/",
+		Bucket:          "foo",
+		Prefix:          "bar/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	store.now = func() time.Time { return time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC) }
+
+	// When
+	ref, err := store.Put([]byte("Bazinga!"))
+	c.Assert(err, IsNil)
+	value, err := store.Get(ref)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(string(value), Equals, "Bazinga!")
+	c.Check(ref, Matches, "foo/bar/[0-9a-f]{32}")
+	c.Check(s.objects["/"+ref], DeepEquals, []byte("Bazinga!"))
+	authzRE := regexp.MustCompile("^AWS4-HMAC-SHA256 Credential=AKID/20190801/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}$")
+	for _, authz := range s.authz {
+		c.Check(authzRE.MatchString(authz), Equals, true, Commentf(authz))
+	}
+}
+
+// Objects are listed page by page, and the query string is signed along
+// with the rest of the request.
+func (s *ClaimCheckSuite) TestListObjects(c *C) {
+	store := New(config.ClaimCheck{
+		Endpoint:        s.srv.URL,
+		Bucket:          "foo",
+		Prefix:          "bar/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	for _, name := range []string{"t1/0/1", "t1/0/2", "t1/1/1", "t2/0/1", "t1/1/2"} {
+		_, err := store.PutObject(name, []byte("Bazinga!"))
+		c.Assert(err, IsNil)
+	}
+	s.objects["/other/bar/t1/0/3"] = []byte("Bazinga!")
+	s.authz = nil
+
+	// When
+	names, err := store.ListObjects("t1/")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(names, DeepEquals, []string{"t1/0/1", "t1/0/2", "t1/1/1", "t1/1/2"})
+	c.Check(s.authz, HasLen, 2)
+	value, err := store.GetObject(names[0])
+	c.Assert(err, IsNil)
+	c.Check(string(value), Equals, "Bazinga!")
+}
+
+func (s *ClaimCheckSuite) TestCanonicalQueryString(c *C) {
+	c.Check(canonicalQueryString(url.Values{"prefix": {"a/b c"}, "list-type": {"2"}}), Equals, "list-type=2&prefix=a%2Fb%20c")
+	c.Check(canonicalQueryString(nil), Equals, "")
+}
+
+func (s *ClaimCheckSuite) TestGetMissing(c *C) {
+	store := New(config.ClaimCheck{Endpoint: s.srv.URL, Bucket: "foo"})
+
+	// When
+	_, err := store.Get("foo/bar")
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to download value: GET foo/bar: 404 Not Found NoSuchKey")
+}
+
+// References that do not point into the bucket and prefix of the store are
+// rejected without making a request.
+func (s *ClaimCheckSuite) TestGetForeign(c *C) {
+	store := New(config.ClaimCheck{Endpoint: s.srv.URL, Bucket: "foo", Prefix: "bar/"})
+	s.objects["/foo/baz"] = []byte("secret")
+	s.objects["/other/bar/x"] = []byte("secret")
+
+	for i, ref := range []string{"foo/baz", "other/bar/x", "foo/bar/", "foo", ""} {
+		// When
+		_, err := store.Get(ref)
+
+		// Then
+		c.Check(errors.Cause(err), Equals, ErrBadReference, Commentf("case #%d", i))
+		c.Check(IsPermanent(err), Equals, true, Commentf("case #%d", i))
+	}
+	c.Check(s.authz, HasLen, 0)
+}
+
+func (s *ClaimCheckSuite) TestIsPermanent(c *C) {
+	for i, tc := range []struct {
+		err       error
+		permanent bool
+	}{
+		{err: &statusError{status: http.StatusNotFound}, permanent: true},
+		{err: errors.Wrap(&statusError{status: http.StatusForbidden}, "foo"), permanent: true},
+		{err: &statusError{status: http.StatusTooManyRequests}},
+		{err: &statusError{status: http.StatusRequestTimeout}},
+		{err: &statusError{status: http.StatusServiceUnavailable}},
+		{err: errors.Wrap(ErrNotConfigured, "foo"), permanent: true},
+		{err: errors.New("connection refused")},
+	} {
+		c.Check(IsPermanent(tc.err), Equals, tc.permanent, Commentf("case #%d", i))
+	}
+}
+
+// The signing key derivation example from the AWS documentation.
+func (s *ClaimCheckSuite) TestSigningKey(c *C) {
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", time.Date(2012, 2, 15, 0, 0, 0, 0, time.UTC),
+		"us-east-1", "iam")
+	c.Check(hex.EncodeToString(key), Equals, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d")
+}
+
+func (s *ClaimCheckSuite) TestEscapePath(c *C) {
+	c.Check(escapePath("foo/a b+c~d.e_f-g"), Equals, "foo/a%20b%2Bc~d.e_f-g")
+}
+
+func (s *ClaimCheckSuite) TestReference(c *C) {
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("foo"), Value: []byte("bar")},
+		{Key: []byte(Header), Value: []byte("bucket/object")},
+	}}
+
+	c.Check(Reference(msg), Equals, "bucket/object")
+	c.Check(StripHeader(msg.Headers), DeepEquals, msg.Headers[:1])
+	c.Check(Reference(&sarama.ConsumerMessage{}), Equals, "")
+}
+
+func (s *ClaimCheckSuite) TestStripProducedHeader(c *C) {
+	headers := []sarama.RecordHeader{
+		{Key: []byte(Header), Value: []byte("bucket/object")},
+		{Key: []byte("foo"), Value: []byte("bar")},
+		{Key: []byte(Header), Value: []byte("bucket/object")},
+	}
+	c.Check(StripProducedHeader(headers), DeepEquals, headers[1:2])
+	c.Check(StripProducedHeader(headers[1:2]), DeepEquals, headers[1:2])
+	c.Check(StripProducedHeader(nil), IsNil)
+}
@@ -0,0 +1,408 @@
+// Package client implements a Kafka-Pixy client on top of the gRPC API. It
+// hides protocol details like acknowledgement piggybacking, group generation
+// metadata and long polling timeouts behind plain Go types, so that
+// applications do not have to hand-roll a consume-n-ack loop of their own.
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/backoff"
+	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Metadata keys used by the gRPC API.
+	mdGeneration = "x-kafka-generation"
+
+	// lastAckTimeout is how long acknowledging the last consumed message can
+	// take once a consume loop is stopped.
+	lastAckTimeout = 10 * time.Second
+)
+
+// DefaultBackoff is how long the consume loop waits before retrying after a
+// retryable error, unless overridden with `WithBackoff`.
+var DefaultBackoff = backoff.Exponential{Base: 100 * time.Millisecond, Cap: 10 * time.Second, Jitter: 0.2}
+
+// T is a Kafka-Pixy client. It is safe for concurrent use.
+type T struct {
+	conn     *grpc.ClientConn
+	ownConn  bool
+	clt      pb.KafkaPixyClient
+	cluster  string
+	backoff  backoff.Exponential
+	dialOpts []grpc.DialOption
+}
+
+// Option configures optional features of a client.
+type Option func(*T)
+
+// WithCluster makes the client operate on the specified cluster rather than
+// on the default cluster of Kafka-Pixy.
+func WithCluster(cluster string) Option {
+	return func(c *T) {
+		c.cluster = cluster
+	}
+}
+
+// WithBackoff makes the consume loop space out retries with the specified
+// backoff policy.
+func WithBackoff(b backoff.Exponential) Option {
+	return func(c *T) {
+		c.backoff = b
+	}
+}
+
+// WithDialOptions makes `Dial` use the specified gRPC dial options instead of
+// an insecure connection. It is ignored by `New`.
+func WithDialOptions(dialOpts ...grpc.DialOption) Option {
+	return func(c *T) {
+		c.dialOpts = dialOpts
+	}
+}
+
+// Header is a Kafka record header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a message consumed from a topic.
+type Message struct {
+	Partition int32
+	Offset    int64
+	// Key is nil if the message was produced to a random partition.
+	Key     []byte
+	Value   []byte
+	Headers []Header
+	// Generation of the consumer group that the message was consumed in.
+	Generation int32
+}
+
+// Handler processes a consumed message. If it returns an error, then the
+// message is not acknowledged, hence it is consumed again once
+// `consumer.ack_timeout` elapses, possibly by another group member.
+type Handler func(ctx context.Context, msg *Message) error
+
+// PartitionOffset describes a partition and the offset of a consumer group
+// in it.
+type PartitionOffset struct {
+	Partition   int32
+	Begin       int64
+	End         int64
+	Count       int64
+	Offset      int64
+	Lag         int64
+	Metadata    string
+	SparseAcks  string
+	AckMetadata string
+}
+
+// PartitionMetadata describes a partition replicas.
+type PartitionMetadata struct {
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	ISR       []int32
+}
+
+// TopicMetadata describes a topic.
+type TopicMetadata struct {
+	Version    int32
+	Config     map[string]string
+	Partitions []PartitionMetadata
+}
+
+// Dial connects to Kafka-Pixy gRPC API at the specified address, e.g.
+// "127.0.0.1:19091". The connection is insecure unless `WithDialOptions` says
+// otherwise. It is closed by `Close`.
+func Dial(addr string, opts ...Option) (*T, error) {
+	c := newT(opts)
+	dialOpts := c.dialOpts
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	conn, err := grpc.Dial(addr, dialOpts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial %s", addr)
+	}
+	c.conn = conn
+	c.ownConn = true
+	c.clt = pb.NewKafkaPixyClient(conn)
+	return c, nil
+}
+
+// New creates a client that uses an existing gRPC connection. The connection
+// is not closed by `Close`.
+func New(conn *grpc.ClientConn, opts ...Option) *T {
+	c := newT(opts)
+	c.conn = conn
+	c.clt = pb.NewKafkaPixyClient(conn)
+	return c
+}
+
+func newT(opts []Option) *T {
+	c := &T{backoff: DefaultBackoff}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes the gRPC connection if it was created by `Dial`.
+func (c *T) Close() error {
+	if !c.ownConn {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Produce writes a message to a topic and returns the partition and offset it
+// was written to. If key is nil, then the message is written to a random
+// partition.
+func (c *T) Produce(ctx context.Context, topic string, key, value []byte, headers ...Header) (int32, int64, error) {
+	rs, err := c.clt.Produce(ctx, c.prodRq(topic, key, value, headers, false))
+	if err != nil {
+		return 0, 0, err
+	}
+	return rs.Partition, rs.Offset, nil
+}
+
+// ProduceAsync hands a message over to Kafka-Pixy to be written to a topic in
+// the background. It can be lost if Kafka-Pixy crashes before that.
+func (c *T) ProduceAsync(ctx context.Context, topic string, key, value []byte, headers ...Header) error {
+	_, err := c.clt.Produce(ctx, c.prodRq(topic, key, value, headers, true))
+	return err
+}
+
+// Consume runs a consume-n-ack loop that passes messages consumed from the
+// topic by the group to the handler, until the context is done. A message is
+// acknowledged once the handler returns nil, piggybacked on the next consume
+// request. The last one is acknowledged when the loop stops. Retryable errors
+// are retried with backoff, other errors stop the loop and are returned.
+//
+// Requests issued by the loop do not use the context, so that a request in
+// progress completes gracefully, and the message it returns is not lost
+// until the ack timeout.
+func (c *T) Consume(ctx context.Context, group, topic string, handler Handler) error {
+	var (
+		pending *Message
+		retries int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if pending == nil {
+				return nil
+			}
+			ackCtx, cancel := context.WithTimeout(context.Background(), lastAckTimeout)
+			defer cancel()
+			return errors.Wrap(c.ack(ackCtx, group, topic, pending), "while acking last")
+		default:
+		}
+
+		msg, err := c.consume(group, topic, pending)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.NotFound:
+				// The long polling timeout elapsed with nothing to consume,
+				// and the pending message, if any, is acknowledged.
+				pending = nil
+				retries = 0
+				continue
+			case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.DeadlineExceeded:
+				retries++
+				select {
+				case <-ctx.Done():
+				case <-time.After(c.backoff.Backoff(retries)):
+				}
+				continue
+			}
+			return errors.Wrap(err, "while consuming")
+		}
+		retries = 0
+		pending = nil
+		if err := handler(ctx, msg); err == nil {
+			pending = msg
+		}
+	}
+}
+
+// Ack acknowledges a message consumed from a topic. Messages passed to a
+// `Consume` handler are acknowledged automatically, so it is only needed if
+// a message is to be acknowledged out of the consume loop.
+func (c *T) Ack(ctx context.Context, group, topic string, msg *Message) error {
+	return c.ack(ctx, group, topic, msg)
+}
+
+// GetOffsets returns offsets of the group in all partitions of the topic.
+func (c *T) GetOffsets(ctx context.Context, group, topic string) ([]PartitionOffset, error) {
+	rs, err := c.clt.GetOffsets(ctx, &pb.GetOffsetsRq{Cluster: c.cluster, Topic: topic, Group: group})
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]PartitionOffset, len(rs.Offsets))
+	for i, po := range rs.Offsets {
+		offsets[i] = PartitionOffset{
+			Partition:   po.Partition,
+			Begin:       po.Begin,
+			End:         po.End,
+			Count:       po.Count,
+			Offset:      po.Offset,
+			Lag:         po.Lag,
+			Metadata:    po.Metadata,
+			SparseAcks:  po.SparseAcks,
+			AckMetadata: po.AckMetadata,
+		}
+	}
+	return offsets, nil
+}
+
+// SetOffsets sets offsets of the group in partitions of the topic. Only
+// `Partition`, `Offset` and `Metadata` of offsets are used.
+func (c *T) SetOffsets(ctx context.Context, group, topic string, offsets []PartitionOffset) error {
+	rq := pb.SetOffsetsRq{
+		Cluster: c.cluster,
+		Topic:   topic,
+		Group:   group,
+		Offsets: make([]*pb.PartitionOffset, len(offsets)),
+	}
+	for i, po := range offsets {
+		rq.Offsets[i] = &pb.PartitionOffset{Partition: po.Partition, Offset: po.Offset, Metadata: po.Metadata}
+	}
+	_, err := c.clt.SetOffsets(ctx, &rq)
+	return err
+}
+
+// ListTopics returns metadata of all topics by name.
+func (c *T) ListTopics(ctx context.Context, withPartitions bool) (map[string]TopicMetadata, error) {
+	rs, err := c.clt.ListTopics(ctx, &pb.ListTopicRq{Cluster: c.cluster, WithPartitions: withPartitions})
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]TopicMetadata, len(rs.Topics))
+	for topic, tm := range rs.Topics {
+		topics[topic] = toTopicMetadata(tm)
+	}
+	return topics, nil
+}
+
+// GetTopicMetadata returns metadata of the topic.
+func (c *T) GetTopicMetadata(ctx context.Context, topic string, withPartitions bool) (TopicMetadata, error) {
+	rs, err := c.clt.GetTopicMetadata(ctx, &pb.GetTopicMetadataRq{Cluster: c.cluster, Topic: topic, WithPartitions: withPartitions})
+	if err != nil {
+		return TopicMetadata{}, err
+	}
+	return toTopicMetadata(rs), nil
+}
+
+// ListConsumers returns partitions of the topic claimed by group members, by
+// group and member. If group is not empty, then only that group is listed.
+func (c *T) ListConsumers(ctx context.Context, topic, group string) (map[string]map[string][]int32, error) {
+	rs, err := c.clt.ListConsumers(ctx, &pb.ListConsumersRq{Cluster: c.cluster, Topic: topic, Group: group})
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]map[string][]int32, len(rs.Groups))
+	for group, consumers := range rs.Groups {
+		members := make(map[string][]int32, len(consumers.Consumers))
+		for member, partitions := range consumers.Consumers {
+			members[member] = partitions.Partitions
+		}
+		groups[group] = members
+	}
+	return groups, nil
+}
+
+// consume issues a consume request that acknowledges the pending message, if
+// there is one.
+func (c *T) consume(group, topic string, pending *Message) (*Message, error) {
+	ctx := context.Background()
+	rq := pb.ConsNAckRq{Cluster: c.cluster, Topic: topic, Group: group, NoAck: true}
+	if pending != nil {
+		ctx = withGeneration(ctx, pending.Generation)
+		rq.NoAck = false
+		rq.AckPartition = pending.Partition
+		rq.AckOffset = pending.Offset
+	}
+	var md metadata.MD
+	rs, err := c.clt.ConsumeNAck(ctx, &rq, grpc.Header(&md))
+	if err != nil {
+		return nil, err
+	}
+	msg := Message{
+		Partition: rs.Partition,
+		Offset:    rs.Offset,
+		Value:     rs.Message,
+	}
+	if !rs.KeyUndefined {
+		msg.Key = rs.KeyValue
+		if msg.Key == nil {
+			msg.Key = []byte{}
+		}
+	}
+	for _, h := range rs.Headers {
+		msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	if values := md.Get(mdGeneration); len(values) > 0 {
+		generation, _ := strconv.Atoi(values[0])
+		msg.Generation = int32(generation)
+	}
+	return &msg, nil
+}
+
+func (c *T) ack(ctx context.Context, group, topic string, msg *Message) error {
+	_, err := c.clt.Ack(withGeneration(ctx, msg.Generation), &pb.AckRq{
+		Cluster:   c.cluster,
+		Topic:     topic,
+		Group:     group,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	})
+	return err
+}
+
+func (c *T) prodRq(topic string, key, value []byte, headers []Header, async bool) *pb.ProdRq {
+	rq := pb.ProdRq{
+		Cluster:      c.cluster,
+		Topic:        topic,
+		KeyValue:     key,
+		KeyUndefined: key == nil,
+		Message:      value,
+		AsyncMode:    async,
+	}
+	for _, h := range headers {
+		rq.Headers = append(rq.Headers, &pb.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return &rq
+}
+
+// withGeneration attaches the group generation to acks of a request, so that
+// acks of messages consumed before a rebalance are fenced. Zero means that
+// the generation is unknown.
+func withGeneration(ctx context.Context, generation int32) context.Context {
+	if generation == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, mdGeneration, strconv.Itoa(int(generation)))
+}
+
+func toTopicMetadata(tm *pb.GetTopicMetadataRs) TopicMetadata {
+	md := TopicMetadata{Version: tm.Version, Config: tm.Config}
+	for _, pm := range tm.Partitions {
+		md.Partitions = append(md.Partitions, PartitionMetadata{
+			Partition: pm.Partition,
+			Leader:    pm.Leader,
+			Replicas:  pm.Replicas,
+			ISR:       pm.Isr,
+		})
+	}
+	return md
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/service"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ClientSuite struct {
+	cfg *config.App
+	kh  *kafkahelper.T
+	svc *service.T
+	clt *T
+}
+
+var _ = Suite(&ClientSuite{})
+
+func (s *ClientSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ClientSuite) SetUpTest(c *C) {
+	s.cfg = &config.App{Proxies: make(map[string]*config.Proxy)}
+	s.cfg.GRPCAddr = "127.0.0.1:19091"
+	proxyCfg := testhelpers.NewTestProxyCfg("client_test")
+	proxyCfg.Consumer.LongPollingTimeout = 500 * time.Millisecond
+	s.cfg.Proxies["pxyC"] = proxyCfg
+	s.cfg.DefaultCluster = "pxyC"
+	s.kh = kafkahelper.New(c)
+
+	var err error
+	s.svc, err = service.Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	s.clt, err = Dial(s.cfg.GRPCAddr)
+	c.Assert(err, IsNil)
+}
+
+func (s *ClientSuite) TearDownTest(c *C) {
+	s.clt.Close()
+	s.svc.Stop()
+	s.kh.Close()
+}
+
+// Messages produced with a key land in the same partition and are consumed
+// with their keys and headers intact.
+func (s *ClientSuite) TestProduceConsume(c *C) {
+	s.kh.ResetOffsets("client", "test.4")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var produced []int64
+	for i := 0; i < 3; i++ {
+		partition, offset, err := s.clt.Produce(ctx, "test.4", []byte("foo"), []byte(fmt.Sprintf("m%d", i)),
+			Header{Key: "bar", Value: []byte("bazz")})
+		c.Assert(err, IsNil)
+		c.Check(partition, Equals, int32(0))
+		produced = append(produced, offset)
+	}
+
+	// When
+	var consumed []*Message
+	consCtx, consCancel := context.WithCancel(ctx)
+	err := s.clt.Consume(consCtx, "client", "test.4", func(ctx context.Context, msg *Message) error {
+		consumed = append(consumed, msg)
+		if len(consumed) == 3 {
+			consCancel()
+		}
+		return nil
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(consumed, HasLen, 3)
+	for i, msg := range consumed {
+		c.Check(string(msg.Key), Equals, "foo", Commentf("msg #%d", i))
+		c.Check(string(msg.Value), Equals, fmt.Sprintf("m%d", i), Commentf("msg #%d", i))
+		c.Check(msg.Headers, DeepEquals, []Header{{Key: "bar", Value: []byte("bazz")}}, Commentf("msg #%d", i))
+		c.Check(msg.Offset, Equals, produced[i], Commentf("msg #%d", i))
+	}
+	offsets, err := s.clt.GetOffsets(ctx, "client", "test.4")
+	c.Assert(err, IsNil)
+	c.Check(offsets[0].Offset, Equals, produced[2]+1)
+}
+
+// A message that the handler failed to process is not acknowledged.
+func (s *ClientSuite) TestConsumeHandlerError(c *C) {
+	s.kh.ResetOffsets("client", "test.1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, offset, err := s.clt.Produce(ctx, "test.1", nil, []byte("m"))
+	c.Assert(err, IsNil)
+
+	// When
+	consCtx, consCancel := context.WithCancel(ctx)
+	err = s.clt.Consume(consCtx, "client", "test.1", func(ctx context.Context, msg *Message) error {
+		consCancel()
+		return errors.New("failed")
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	offsets, err := s.clt.GetOffsets(ctx, "client", "test.1")
+	c.Assert(err, IsNil)
+	c.Check(offsets[0].Offset, Equals, offset)
+}
+
+func (s *ClientSuite) TestGetTopicMetadata(c *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// When
+	md, err := s.clt.GetTopicMetadata(ctx, "test.4", true)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(md.Partitions, HasLen, 4)
+}
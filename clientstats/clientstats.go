@@ -0,0 +1,103 @@
+// Package clientstats counts API requests by the client library that makes
+// them, as reported by clients in request metadata, so that it can be told
+// which client versions are still in use before an API change breaks them.
+// The registry is process wide, for requests are counted by the API servers
+// regardless of the cluster they go to.
+package clientstats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxLength is the maximum length of a client name or version, longer
+	// ones are truncated.
+	MaxLength = 64
+
+	// MaxClients is the maximum number of distinct client name and version
+	// pairs counted separately, the rest are counted under Other.
+	MaxClients = 1000
+
+	// Other is the name that clients over MaxClients are counted under.
+	Other = "_other"
+)
+
+// Client describes requests made by a particular version of a client
+// library. Requests of clients that do not report themselves are counted
+// under an empty name and version.
+type Client struct {
+	Name    string
+	Version string
+
+	// Numbers of requests by API, e.g. `grpc:Produce` or
+	// `http:POST /topics/{topic}/messages`.
+	Requests map[string]int64
+
+	// When the latest request was made.
+	LastSeen time.Time
+}
+
+type clientID struct {
+	name    string
+	version string
+}
+
+var (
+	mu      sync.Mutex
+	clients = make(map[clientID]*Client)
+
+	// For tests only!
+	now = time.Now
+)
+
+// Record counts a request to an API made by a client.
+func Record(name, version, api string) {
+	id := clientID{truncate(name), truncate(version)}
+	mu.Lock()
+	defer mu.Unlock()
+	client := clients[id]
+	if client == nil {
+		if len(clients) >= MaxClients {
+			id = clientID{Other, Other}
+			client = clients[id]
+		}
+		if client == nil {
+			client = &Client{Name: id.name, Version: id.version, Requests: make(map[string]int64)}
+			clients[id] = client
+		}
+	}
+	client.Requests[api]++
+	client.LastSeen = now()
+}
+
+// Report returns a snapshot of request counters of all clients sorted by
+// name and version.
+func Report() []Client {
+	mu.Lock()
+	report := make([]Client, 0, len(clients))
+	for _, client := range clients {
+		snapshot := *client
+		snapshot.Requests = make(map[string]int64, len(client.Requests))
+		for api, count := range client.Requests {
+			snapshot.Requests[api] = count
+		}
+		report = append(report, snapshot)
+	}
+	mu.Unlock()
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Name != report[j].Name {
+			return report[i].Name < report[j].Name
+		}
+		return report[i].Version < report[j].Version
+	})
+	return report
+}
+
+func truncate(s string) string {
+	if len(s) > MaxLength {
+		return s[:MaxLength]
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package clientstats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ClientStatsSuite struct{}
+
+var _ = Suite(&ClientStatsSuite{})
+
+func (s *ClientStatsSuite) SetUpTest(c *C) {
+	clients = make(map[clientID]*Client)
+	now = time.Now
+}
+
+// Requests are counted by client name, version and API. Clients that do not
+// report themselves are counted under an empty name and version.
+func (s *ClientStatsSuite) TestReport(c *C) {
+	begin := time.Now()
+	now = func() time.Time { return begin }
+	Record("pixy-py", "0.3.1", "grpc:Produce")
+	Record("pixy-go", "1.0.0", "grpc:ConsumeNAck")
+	Record("pixy-py", "0.3.1", "grpc:Produce")
+	Record("", "", "http:GET /_ping")
+	now = func() time.Time { return begin.Add(time.Minute) }
+	Record("pixy-py", "0.3.1", "grpc:Ack")
+
+	// When
+	report := Report()
+
+	// Then
+	c.Check(report, DeepEquals, []Client{{
+		Requests: map[string]int64{"http:GET /_ping": 1},
+		LastSeen: begin,
+	}, {
+		Name:     "pixy-go",
+		Version:  "1.0.0",
+		Requests: map[string]int64{"grpc:ConsumeNAck": 1},
+		LastSeen: begin,
+	}, {
+		Name:     "pixy-py",
+		Version:  "0.3.1",
+		Requests: map[string]int64{"grpc:Produce": 2, "grpc:Ack": 1},
+		LastSeen: begin.Add(time.Minute),
+	}})
+}
+
+// Clients over the limit are counted under Other, and too long names and
+// versions are truncated.
+func (s *ClientStatsSuite) TestLimits(c *C) {
+	for i := 0; i < MaxClients; i++ {
+		Record(fmt.Sprintf("client-%04d", i), "1.0", "grpc:Produce")
+	}
+	long := strings.Repeat("a", MaxLength+1)
+
+	// When
+	Record("client-0000", "1.0", "grpc:Produce")
+	Record("client-1000", "1.0", "grpc:Produce")
+	Record(long, long, "grpc:Produce")
+
+	// Then
+	report := Report()
+	c.Check(len(report), Equals, MaxClients+1)
+	c.Check(report[0].Name, Equals, Other)
+	c.Check(report[0].Requests, DeepEquals, map[string]int64{"grpc:Produce": 2})
+	c.Check(report[1].Requests, DeepEquals, map[string]int64{"grpc:Produce": 2})
+
+	clients = make(map[clientID]*Client)
+	Record(long, long, "grpc:Produce")
+	c.Check(Report()[0].Name, Equals, long[:MaxLength])
+	c.Check(Report()[0].Version, Equals, long[:MaxLength])
+}
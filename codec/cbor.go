@@ -0,0 +1,455 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cborCodec implements the CBOR format, see RFC 8949. Date/time tags are
+// decoded into times, and other tags are ignored, that is the tagged item is
+// decoded as if it was not tagged.
+type cborCodec struct{}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+const (
+	cborIndefinite = 31
+	cborBreak      = 0xff
+
+	cborTagDateTime = 0
+	cborTagEpoch    = 1
+)
+
+var errCBORTruncated = errors.New("truncated cbor value")
+
+// cborBreakMark is returned by the decoder for the break stop code, that
+// terminates indefinite length items.
+type cborBreakMark struct{}
+
+func (cborCodec) Decode(data []byte) (interface{}, error) {
+	d := cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("trailing data after value")
+	}
+	return v, nil
+}
+
+func (cborCodec) Encode(v interface{}) ([]byte, error) {
+	var e cborEncoder
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	v, err := d.decodeItem(depth)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(cborBreakMark); ok {
+		return nil, errors.New("unexpected cbor break")
+	}
+	return v, nil
+}
+
+// decodeItem decodes a data item, or returns cborBreakMark for the break
+// stop code.
+func (d *cborDecoder) decodeItem(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor value nested too deep")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	if b[0] == cborBreak {
+		return cborBreakMark{}, nil
+	}
+	if major == cborSimple {
+		return d.decodeSimple(info)
+	}
+	if info == cborIndefinite {
+		switch major {
+		case cborBytes, cborText:
+			return d.decodeChunks(major, depth)
+		case cborArray:
+			return d.decodeArray(-1, depth)
+		case cborMap:
+			return d.decodeMap(-1, depth)
+		}
+		return nil, errors.Errorf("bad cbor indefinite length of major type %d", major)
+	}
+	arg, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case cborArray:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case cborMap:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	default: // cborTag
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborUntag(arg, v)
+	}
+}
+
+// argument reads the argument of a data item as encoded by its additional
+// information.
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	if info < 24 {
+		return uint64(info), nil
+	}
+	if info > 27 {
+		return 0, errors.Errorf("bad cbor additional information: %d", info)
+	}
+	b, err := d.next(1 << (info - 24))
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *cborDecoder) length(arg uint64) (int, error) {
+	if arg > uint64(len(d.data)) {
+		return 0, errCBORTruncated
+	}
+	return int(arg), nil
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *cborDecoder) decodeSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null and undefined
+		return nil, nil
+	case 25:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat64(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, errors.Errorf("unsupported cbor simple value: %d", info)
+}
+
+// decodeChunks decodes an indefinite length byte or text string, that is a
+// sequence of definite length chunks of the same major type terminated by a
+// break.
+func (d *cborDecoder) decodeChunks(major byte, depth int) (interface{}, error) {
+	var buf []byte
+	for {
+		v, err := d.decodeItem(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch chunk := v.(type) {
+		case cborBreakMark:
+			if major == cborText {
+				return string(buf), nil
+			}
+			return buf, nil
+		case []byte:
+			if major != cborBytes {
+				return nil, errors.New("bad cbor text string chunk")
+			}
+			buf = append(buf, chunk...)
+		case string:
+			if major != cborText {
+				return nil, errors.New("bad cbor byte string chunk")
+			}
+			buf = append(buf, chunk...)
+		default:
+			return nil, errors.New("bad cbor string chunk")
+		}
+	}
+}
+
+// decodeArray decodes n elements of an array, or elements up to a break if
+// n is negative.
+func (d *cborDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	arr := []interface{}{}
+	if n > 0 {
+		arr = make([]interface{}, 0, n)
+	}
+	for i := 0; n < 0 || i < n; i++ {
+		v, err := d.decodeItem(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(cborBreakMark); ok {
+			if n >= 0 {
+				return nil, errors.New("unexpected cbor break")
+			}
+			break
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// decodeMap decodes n pairs of a map, or pairs up to a break if n is
+// negative.
+func (d *cborDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for i := 0; n < 0 || i < n; i++ {
+		key, err := d.decodeItem(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(cborBreakMark); ok {
+			if n >= 0 {
+				return nil, errors.New("unexpected cbor break")
+			}
+			break
+		}
+		if m[mapKey(key)], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// cborUntag interprets date/time tags, and returns other tagged items as is.
+func cborUntag(tag uint64, v interface{}) (interface{}, error) {
+	switch tag {
+	case cborTagDateTime:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("bad cbor date/time string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad cbor date/time string")
+		}
+		return t, nil
+	case cborTagEpoch:
+		switch epoch := v.(type) {
+		case int64:
+			return time.Unix(epoch, 0).UTC(), nil
+		case uint64:
+			return nil, errors.New("cbor epoch time overflows int64")
+		case float64:
+			sec, frac := math.Modf(epoch)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("bad cbor epoch time")
+	}
+	return v, nil
+}
+
+// halfToFloat64 converts an IEEE 754 half precision float.
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+type cborEncoder struct {
+	buf []byte
+}
+
+func (e *cborEncoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, cborSimple<<5|22)
+	case bool:
+		if v {
+			e.buf = append(e.buf, cborSimple<<5|21)
+		} else {
+			e.buf = append(e.buf, cborSimple<<5|20)
+		}
+	case int:
+		e.encodeInt(int64(v))
+	case int8:
+		e.encodeInt(int64(v))
+	case int16:
+		e.encodeInt(int64(v))
+	case int32:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case uint:
+		e.encodeHead(cborUint, uint64(v))
+	case uint8:
+		e.encodeHead(cborUint, uint64(v))
+	case uint16:
+		e.encodeHead(cborUint, uint64(v))
+	case uint32:
+		e.encodeHead(cborUint, uint64(v))
+	case uint64:
+		e.encodeHead(cborUint, v)
+	case float32:
+		e.buf = append(e.buf, cborSimple<<5|26)
+		e.buf = appendUint(e.buf, uint64(math.Float32bits(v)), 4)
+	case float64:
+		e.buf = append(e.buf, cborSimple<<5|27)
+		e.buf = appendUint(e.buf, math.Float64bits(v), 8)
+	case json.Number:
+		n, err := fromJSONNumber(v)
+		if err != nil {
+			return err
+		}
+		return e.encode(n)
+	case string:
+		e.encodeHead(cborText, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	case []byte:
+		e.encodeHead(cborBytes, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	case time.Time:
+		e.encodeHead(cborTag, cborTagDateTime)
+		s := v.Format(time.RFC3339Nano)
+		e.encodeHead(cborText, uint64(len(s)))
+		e.buf = append(e.buf, s...)
+	case []interface{}:
+		e.encodeHead(cborArray, uint64(len(v)))
+		for _, elem := range v {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.encodeHead(cborMap, uint64(len(v)))
+		for _, key := range sortedKeys(v) {
+			e.encodeHead(cborText, uint64(len(key)))
+			e.buf = append(e.buf, key...)
+			if err := e.encode(v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("unsupported type: %T", v)
+	}
+	return nil
+}
+
+func (e *cborEncoder) encodeInt(i int64) {
+	if i >= 0 {
+		e.encodeHead(cborUint, uint64(i))
+		return
+	}
+	e.encodeHead(cborNegInt, uint64(-1-i))
+}
+
+// encodeHead appends the initial byte of a data item of the major type with
+// the shortest encoding of the argument.
+func (e *cborEncoder) encodeHead(major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		e.buf = append(e.buf, major<<5|byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf = append(e.buf, major<<5|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.buf = append(e.buf, major<<5|25)
+		e.buf = appendUint(e.buf, arg, 2)
+	case arg <= math.MaxUint32:
+		e.buf = append(e.buf, major<<5|26)
+		e.buf = appendUint(e.buf, arg, 4)
+	default:
+		e.buf = append(e.buf, major<<5|27)
+		e.buf = appendUint(e.buf, arg, 8)
+	}
+}
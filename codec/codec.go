@@ -0,0 +1,173 @@
+// Package codec implements a registry of message value codecs that consume
+// responses can be transcoded with, e.g. values of a topic stored as
+// MessagePack can be served as JSON to clients that ask for it. A value is
+// transcoded by decoding it into a generic value with the codec of the topic,
+// and encoding that with the codec that a client asked for.
+//
+// Generic values are nil, bool, int64, uint64, float64, string, []byte,
+// time.Time, []interface{} and map[string]interface{}. Codecs decode into
+// those types only, but encoders also accept other integer and float types
+// and json.Number. Map keys that are not strings are converted to strings on
+// decoding, for not every codec supports them.
+package codec
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the built-in codecs.
+const (
+	JSON        = "json"
+	MessagePack = "msgpack"
+	CBOR        = "cbor"
+)
+
+// maxDepth is the maximum nesting depth of arrays and maps that decoders
+// accept, so that a malicious value cannot exhaust the stack.
+const maxDepth = 512
+
+// Codec decodes values into generic values and encodes them back.
+type Codec interface {
+	Decode(data []byte) (interface{}, error)
+	Encode(v interface{}) ([]byte, error)
+}
+
+type registration struct {
+	codec        Codec
+	contentTypes []string
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]registration)
+)
+
+func init() {
+	Register(JSON, jsonCodec{}, "application/json")
+	Register(MessagePack, msgpackCodec{}, "application/msgpack", "application/x-msgpack")
+	Register(CBOR, cborCodec{}, "application/cbor")
+}
+
+// Register makes a codec available by name to be listed in
+// `value_codecs`, and by the content types that clients can ask for it
+// with. It is supposed to be called from `init` functions of packages that
+// are compiled into a custom Kafka-Pixy build. It panics if a codec with the
+// same name or content type has already been registered.
+func Register(name string, codec Codec, contentTypes ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("codec registered twice: %s", name))
+	}
+	for _, ct := range contentTypes {
+		if registered, ok := byContentType(ct); ok {
+			panic(fmt.Sprintf("content type %s registered twice: %s and %s", ct, registered, name))
+		}
+	}
+	registry[name] = registration{codec: codec, contentTypes: contentTypes}
+}
+
+// Lookup returns a codec registered with the specified name.
+func Lookup(name string) (Codec, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	reg, ok := registry[name]
+	return reg.codec, ok
+}
+
+// Names returns names of all registered codecs sorted.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FromAccept returns the name of the codec of the first media type listed
+// in an HTTP Accept header that a codec is registered for. Quality values
+// are not taken into account. If there is no such media type, then false
+// is returned.
+func FromAccept(accept string) (string, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if name, ok := byContentType(mediaType); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// byContentType must be called with the registry mutex held.
+func byContentType(contentType string) (string, bool) {
+	for name, reg := range registry {
+		for _, ct := range reg.contentTypes {
+			if strings.EqualFold(ct, contentType) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Transcode decodes a value with one codec and encodes it with another. If
+// both codecs are the same, then the value is returned as is.
+func Transcode(value []byte, from, to string) ([]byte, error) {
+	if from == to {
+		return value, nil
+	}
+	fromCodec, ok := Lookup(from)
+	if !ok {
+		return nil, errors.Errorf("unknown codec: %s", from)
+	}
+	toCodec, ok := Lookup(to)
+	if !ok {
+		return nil, errors.Errorf("unknown codec: %s", to)
+	}
+	v, err := fromCodec.Decode(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", from)
+	}
+	encoded, err := toCodec.Encode(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode %s", to)
+	}
+	return encoded, nil
+}
+
+// mapKey converts a decoded map key to a string.
+func mapKey(key interface{}) string {
+	switch key := key.(type) {
+	case string:
+		return key
+	case []byte:
+		return string(key)
+	default:
+		return fmt.Sprint(key)
+	}
+}
+
+// sortedKeys returns keys of a map sorted, so that encoding is
+// deterministic.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,244 @@
+package codec
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CodecSuite struct{}
+
+var _ = Suite(&CodecSuite{})
+
+func unhex(c *C, s string) []byte {
+	b, err := hex.DecodeString(s)
+	c.Assert(err, IsNil)
+	return b
+}
+
+// Values are encoded in the shortest form and decode back to themselves.
+func (s *CodecSuite) TestMsgpackRoundTrip(c *C) {
+	for i, tc := range []struct {
+		v       interface{}
+		encoded string
+	}{
+		{v: nil, encoded: "c0"},
+		{v: true, encoded: "c3"},
+		{v: int64(1), encoded: "01"},
+		{v: int64(-1), encoded: "ff"},
+		{v: int64(-33), encoded: "d0df"},
+		{v: int64(200), encoded: "ccc8"},
+		{v: int64(-1000), encoded: "d1fc18"},
+		{v: int64(70000), encoded: "ce00011170"},
+		{v: uint64(math.MaxUint64), encoded: "cfffffffffffffffff"},
+		{v: int64(math.MinInt64), encoded: "d38000000000000000"},
+		{v: 1.5, encoded: "cb3ff8000000000000"},
+		{v: "a", encoded: "a161"},
+		{v: []byte{1, 2}, encoded: "c4020102"},
+		{v: []interface{}{int64(1), "a"}, encoded: "9201a161"},
+		{v: map[string]interface{}{"b": int64(2), "a": int64(1)}, encoded: "82a16101a16202"},
+		{v: time.Unix(1, 5).UTC(), encoded: "c70cff000000050000000000000001"},
+	} {
+		encoded, err := msgpackCodec{}.Encode(tc.v)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(hex.EncodeToString(encoded), Equals, tc.encoded, Commentf("case #%d", i))
+
+		decoded, err := msgpackCodec{}.Decode(encoded)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(decoded, DeepEquals, tc.v, Commentf("case #%d", i))
+	}
+}
+
+// Longer strings, arrays and maps take length prefixes of growing size.
+func (s *CodecSuite) TestMsgpackLengths(c *C) {
+	for i, n := range []int{31, 32, 255, 256, 65535, 65536} {
+		str := string(make([]byte, n))
+		arr := make([]interface{}, n)
+		for j := range arr {
+			arr[j] = int64(j % 100)
+		}
+		for _, v := range []interface{}{str, arr} {
+			encoded, err := msgpackCodec{}.Encode(v)
+			c.Assert(err, IsNil, Commentf("case #%d", i))
+			decoded, err := msgpackCodec{}.Decode(encoded)
+			c.Assert(err, IsNil, Commentf("case #%d", i))
+			c.Check(decoded, DeepEquals, v, Commentf("case #%d", i))
+		}
+	}
+}
+
+func (s *CodecSuite) TestMsgpackDecodeInvalid(c *C) {
+	for i, tc := range []struct {
+		encoded string
+		errMsg  string
+	}{
+		{encoded: "", errMsg: "truncated msgpack value"},
+		{encoded: "c1", errMsg: "bad msgpack type: 0xc1"},
+		{encoded: "a261", errMsg: "truncated msgpack value"},
+		{encoded: "dcffff", errMsg: "truncated msgpack value"},
+		{encoded: "0101", errMsg: "trailing data after value"},
+		{encoded: "d40100", errMsg: "unsupported msgpack extension type: 1"},
+	} {
+		_, err := msgpackCodec{}.Decode(unhex(c, tc.encoded))
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+// Examples of RFC 8949 Appendix A decode as expected.
+func (s *CodecSuite) TestCBORDecode(c *C) {
+	for i, tc := range []struct {
+		encoded string
+		v       interface{}
+	}{
+		{encoded: "00", v: int64(0)},
+		{encoded: "17", v: int64(23)},
+		{encoded: "1818", v: int64(24)},
+		{encoded: "1903e8", v: int64(1000)},
+		{encoded: "1bffffffffffffffff", v: uint64(math.MaxUint64)},
+		{encoded: "20", v: int64(-1)},
+		{encoded: "3903e7", v: int64(-1000)},
+		{encoded: "f93e00", v: 1.5},
+		{encoded: "f90001", v: 5.960464477539063e-8},
+		{encoded: "fa47c35000", v: float64(100000)},
+		{encoded: "fb3ff199999999999a", v: 1.1},
+		{encoded: "f4", v: false},
+		{encoded: "f6", v: nil},
+		{encoded: "4401020304", v: []byte{1, 2, 3, 4}},
+		{encoded: "6449455446", v: "IETF"},
+		{encoded: "83010203", v: []interface{}{int64(1), int64(2), int64(3)}},
+		{encoded: "a201020304", v: map[string]interface{}{"1": int64(2), "3": int64(4)}},
+		{encoded: "5f42010243030405ff", v: []byte{1, 2, 3, 4, 5}},
+		{encoded: "7f657374726561646d696e67ff", v: "streaming"},
+		{encoded: "9fff", v: []interface{}{}},
+		{encoded: "bf61610161629f0203ffff", v: map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{encoded: "c074323031332d30332d32315432303a30343a30305a", v: time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{encoded: "c11a514b67b0", v: time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{encoded: "d74401020304", v: []byte{1, 2, 3, 4}},
+	} {
+		decoded, err := cborCodec{}.Decode(unhex(c, tc.encoded))
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(decoded, DeepEquals, tc.v, Commentf("case #%d", i))
+	}
+}
+
+func (s *CodecSuite) TestCBORRoundTrip(c *C) {
+	for i, tc := range []struct {
+		v       interface{}
+		encoded string
+	}{
+		{v: int64(500), encoded: "1901f4"},
+		{v: int64(-500), encoded: "3901f3"},
+		{v: "a", encoded: "6161"},
+		{v: []interface{}{true, nil}, encoded: "82f5f6"},
+		{v: map[string]interface{}{"b": int64(2), "a": int64(1)}, encoded: "a2616101616202"},
+	} {
+		encoded, err := cborCodec{}.Encode(tc.v)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(hex.EncodeToString(encoded), Equals, tc.encoded, Commentf("case #%d", i))
+
+		decoded, err := cborCodec{}.Decode(encoded)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(decoded, DeepEquals, tc.v, Commentf("case #%d", i))
+	}
+}
+
+func (s *CodecSuite) TestCBORDecodeInvalid(c *C) {
+	for i, tc := range []struct {
+		encoded string
+		errMsg  string
+	}{
+		{encoded: "", errMsg: "truncated cbor value"},
+		{encoded: "ff", errMsg: "unexpected cbor break"},
+		{encoded: "1c", errMsg: "bad cbor additional information: 28"},
+		{encoded: "3bffffffffffffffff", errMsg: "cbor negative integer overflows int64"},
+		{encoded: "9bffffffffffffffff", errMsg: "truncated cbor value"},
+		{encoded: "8201ff", errMsg: "unexpected cbor break"},
+		{encoded: "1f", errMsg: "bad cbor indefinite length of major type 0"},
+		{encoded: "5f6161ff", errMsg: "bad cbor byte string chunk"},
+	} {
+		_, err := cborCodec{}.Decode(unhex(c, tc.encoded))
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+// Values nested too deep are rejected rather than exhaust the stack.
+func (s *CodecSuite) TestNestedTooDeep(c *C) {
+	deep := make([]byte, 10000)
+	for i := range deep {
+		deep[i] = 0x91
+	}
+	_, err := msgpackCodec{}.Decode(deep)
+	c.Check(err, ErrorMatches, "msgpack value nested too deep")
+	for i := range deep {
+		deep[i] = 0x81
+	}
+	_, err = cborCodec{}.Decode(deep)
+	c.Check(err, ErrorMatches, "cbor value nested too deep")
+}
+
+func (s *CodecSuite) TestTranscode(c *C) {
+	msgpack := unhex(c, "82a16101a162920102")
+
+	// When
+	encoded, err := Transcode(msgpack, MessagePack, JSON)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(string(encoded), Equals, `{"a":1,"b":[1,2]}`)
+
+	encoded, err = Transcode(encoded, JSON, CBOR)
+	c.Assert(err, IsNil)
+	encoded, err = Transcode(encoded, CBOR, MessagePack)
+	c.Assert(err, IsNil)
+	c.Check(encoded, DeepEquals, msgpack)
+}
+
+// Numbers are decoded from JSON as integers if they fit.
+func (s *CodecSuite) TestJSONNumbers(c *C) {
+	_, err := jsonCodec{}.Decode([]byte(`[1, -2, 18446744073709551615, 1.5, 1e400]`))
+	c.Check(err, ErrorMatches, "bad number: 1e400")
+
+	decoded, err := jsonCodec{}.Decode([]byte(`[1, -2, 18446744073709551615, 1.5]`))
+	c.Assert(err, IsNil)
+	c.Check(decoded, DeepEquals, []interface{}{int64(1), int64(-2), uint64(math.MaxUint64), 1.5})
+}
+
+func (s *CodecSuite) TestTranscodeInvalid(c *C) {
+	_, err := Transcode([]byte("{"), JSON, MessagePack)
+	c.Check(err, ErrorMatches, "failed to decode json: unexpected EOF")
+	_, err = Transcode([]byte("{}"), JSON, "avro")
+	c.Check(err, ErrorMatches, "unknown codec: avro")
+	_, err = Transcode([]byte("{} 1"), JSON, CBOR)
+	c.Check(err, ErrorMatches, "failed to decode json: trailing data after value")
+}
+
+func (s *CodecSuite) TestFromAccept(c *C) {
+	for i, tc := range []struct {
+		accept string
+		name   string
+		ok     bool
+	}{
+		{accept: "application/json", name: JSON, ok: true},
+		{accept: "text/html, application/x-msgpack;q=0.9", name: MessagePack, ok: true},
+		{accept: "Application/CBOR", name: CBOR, ok: true},
+		{accept: "*/*", ok: false},
+		{accept: "", ok: false},
+	} {
+		name, ok := FromAccept(tc.accept)
+		c.Check(name, Equals, tc.name, Commentf("case #%d", i))
+		c.Check(ok, Equals, tc.ok, Commentf("case #%d", i))
+	}
+}
+
+func (s *CodecSuite) TestRegisterTwice(c *C) {
+	c.Check(func() { Register(JSON, jsonCodec{}) }, PanicMatches, "codec registered twice: json")
+	c.Check(func() { Register("json2", jsonCodec{}, "application/json") }, PanicMatches,
+		"content type application/json registered twice: json and json2")
+}
@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// jsonCodec encodes byte strings as base64 strings and times as RFC 3339
+// strings, the way encoding/json does, so they do not survive a round trip.
+type jsonCodec struct{}
+
+func (jsonCodec) Decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after value")
+	}
+	return fromJSONNumbers(v)
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// fromJSONNumbers replaces numbers decoded as json.Number with int64 if they
+// fit, uint64 if they do not but are positive integers, and float64
+// otherwise.
+func fromJSONNumbers(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return fromJSONNumber(v)
+	case []interface{}:
+		for i, elem := range v {
+			var err error
+			if v[i], err = fromJSONNumbers(elem); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for key, elem := range v {
+			var err error
+			if v[key], err = fromJSONNumbers(elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func fromJSONNumber(n json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, errors.Errorf("bad number: %s", n)
+	}
+	return f, nil
+}
@@ -0,0 +1,398 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// msgpackCodec implements the MessagePack format, see
+// https://github.com/msgpack/msgpack/blob/master/spec.md. Of extension types
+// only timestamps are supported.
+type msgpackCodec struct{}
+
+const msgpackTimestampExt = -1
+
+var errMsgpackTruncated = errors.New("truncated msgpack value")
+
+func (msgpackCodec) Decode(data []byte) (interface{}, error) {
+	d := msgpackDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("trailing data after value")
+	}
+	return v, nil
+}
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	var e msgpackEncoder
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("msgpack value nested too deep")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	typ := b[0]
+	switch {
+	case typ <= 0x7f:
+		return int64(typ), nil
+	case typ >= 0xe0:
+		return int64(int8(typ)), nil
+	case typ&0xf0 == 0x80:
+		return d.decodeMap(int(typ&0x0f), depth)
+	case typ&0xf0 == 0x90:
+		return d.decodeArray(int(typ&0x0f), depth)
+	case typ&0xe0 == 0xa0:
+		return d.decodeStr(int(typ & 0x1f))
+	}
+	switch typ {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(typ - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(typ - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (typ - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (typ - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend the value to 64 bits.
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (typ - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(typ - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeStr(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(typ - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(typ - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	}
+	return nil, errors.Errorf("bad msgpack type: 0x%x", typ)
+}
+
+// length reads a length of 1, 2 or 4 bytes as selected by sizeIdx 0, 1 or
+// 2 respectively.
+func (d *msgpackDecoder) length(sizeIdx byte) (int, error) {
+	u, err := d.uint(1 << sizeIdx)
+	if err != nil {
+		return 0, err
+	}
+	if u > uint64(len(d.data)) {
+		return 0, errMsgpackTruncated
+	}
+	return int(u), nil
+}
+
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) decodeStr(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	// Every element takes at least one byte.
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		var err error
+		if arr[i], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	// Every key and value takes at least one byte.
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if m[mapKey(key)], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	extType := int8(b[0])
+	if b, err = d.next(n); err != nil {
+		return nil, err
+	}
+	if extType != msgpackTimestampExt {
+		return nil, errors.Errorf("unsupported msgpack extension type: %d", extType)
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC(), nil
+	case 8:
+		u := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(u&0x3ffffffff), int64(u>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(b[:4])
+		sec := int64(binary.BigEndian.Uint64(b[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+	return nil, errors.Errorf("bad msgpack timestamp size: %d", n)
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 0xc0)
+	case bool:
+		if v {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case int:
+		e.encodeInt(int64(v))
+	case int8:
+		e.encodeInt(int64(v))
+	case int16:
+		e.encodeInt(int64(v))
+	case int32:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case uint:
+		e.encodeUint(uint64(v))
+	case uint8:
+		e.encodeUint(uint64(v))
+	case uint16:
+		e.encodeUint(uint64(v))
+	case uint32:
+		e.encodeUint(uint64(v))
+	case uint64:
+		e.encodeUint(v)
+	case float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = appendUint(e.buf, uint64(math.Float32bits(v)), 4)
+	case float64:
+		e.buf = append(e.buf, 0xcb)
+		e.buf = appendUint(e.buf, math.Float64bits(v), 8)
+	case json.Number:
+		n, err := fromJSONNumber(v)
+		if err != nil {
+			return err
+		}
+		return e.encode(n)
+	case string:
+		e.encodeStrHeader(len(v))
+		e.buf = append(e.buf, v...)
+	case []byte:
+		e.encodeHeader(len(v), 0, 0, 0xc4, 0xc5, 0xc6)
+		e.buf = append(e.buf, v...)
+	case time.Time:
+		e.encodeTime(v)
+	case []interface{}:
+		e.encodeHeader(len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, elem := range v {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.encodeHeader(len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range sortedKeys(v) {
+			e.encodeStrHeader(len(key))
+			e.buf = append(e.buf, key...)
+			if err := e.encode(v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("unsupported type: %T", v)
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStrHeader(n int) {
+	e.encodeHeader(n, 0xa0, 32, 0xd9, 0xda, 0xdb)
+}
+
+// encodeHeader appends the type and the length of a string, a byte string,
+// an array or a map. Lengths below fixLimit are encoded in the fixType
+// byte, and longer ones in 1, 2 or 4 bytes following the type8, type16 or
+// type32 type byte respectively. Arrays and maps have no 1 byte length
+// variant, that is given as zero type8.
+func (e *msgpackEncoder) encodeHeader(n int, fixType byte, fixLimit int, type8, type16, type32 byte) {
+	switch {
+	case n < fixLimit:
+		e.buf = append(e.buf, fixType|byte(n))
+	case n <= math.MaxUint8 && type8 != 0:
+		e.buf = append(e.buf, type8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, type16)
+		e.buf = appendUint(e.buf, uint64(n), 2)
+	default:
+		e.buf = append(e.buf, type32)
+		e.buf = appendUint(e.buf, uint64(n), 4)
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(i))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = appendUint(e.buf, uint64(i), 2)
+	case i >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = appendUint(e.buf, uint64(i), 4)
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = appendUint(e.buf, uint64(i), 8)
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf = append(e.buf, byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = appendUint(e.buf, u, 2)
+	case u <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = appendUint(e.buf, u, 4)
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = appendUint(e.buf, u, 8)
+	}
+}
+
+// encodeTime appends a timestamp extension in the 12 byte format, that
+// covers all times.
+func (e *msgpackEncoder) encodeTime(t time.Time) {
+	e.buf = append(e.buf, 0xc7, 12, byte(0xff))
+	e.buf = appendUint(e.buf, uint64(t.Nanosecond()), 4)
+	e.buf = appendUint(e.buf, uint64(t.Unix()), 8)
+}
+
+// appendUint appends the size least significant bytes of u in big endian
+// order.
+func appendUint(buf []byte, u uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		buf = append(buf, byte(u>>(8*uint(i))))
+	}
+	return buf
+}
@@ -0,0 +1,130 @@
+// Package commitalert posts an event to a webhook when offset commits of a
+// partition fail a configured number of times in a row, so that operators
+// learn about a partition that is going to be redelivered from a stale offset
+// before it happens.
+package commitalert
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/lifecycle"
+	"github.com/pkg/errors"
+)
+
+// T watches lifecycle events of a cluster for failed offset commits.
+type T struct {
+	actDesc *actor.Descriptor
+	cfg     *config.Proxy
+	sub     *lifecycle.Subscription
+	httpClt *http.Client
+	alertCh chan lifecycle.Event
+	dropped int64
+	wg      sync.WaitGroup
+}
+
+type partitionID struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// Spawn starts watching offset commit failures in the cluster of `cfg`. It
+// returns nil if no webhook is configured.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) *T {
+	alertCfg := cfg.Consumer.CommitFailureAlert
+	if alertCfg.Threshold <= 0 || alertCfg.WebhookURL == "" {
+		return nil
+	}
+	t := T{
+		actDesc: parentActDesc.NewChild("commit_alert"),
+		cfg:     cfg,
+		sub:     lifecycle.Subscribe(cfg.Consumer.ChannelBufferSize),
+		httpClt: &http.Client{Timeout: alertCfg.WebhookTimeout},
+		alertCh: make(chan lifecycle.Event, cfg.Consumer.ChannelBufferSize),
+	}
+	t.actDesc.ObserveQueue("alerts", func() int { return len(t.alertCh) })
+	t.actDesc.ObserveGauge("dropped_alerts", func() int64 { return atomic.LoadInt64(&t.dropped) })
+	actor.Spawn(t.actDesc, &t.wg, t.run)
+	actor.Spawn(t.actDesc.NewChild("poster"), &t.wg, t.runPoster)
+	return &t
+}
+
+// Stop stops watching, alerts that are queued are posted first.
+func (t *T) Stop() {
+	t.sub.Close()
+	t.wg.Wait()
+}
+
+// run watches lifecycle events and queues an alert for a partition once per
+// streak of failed commits. Events can be dropped by the lifecycle bus, so
+// an alert is raised by the first event at or above the threshold, rather
+// than by the one that reaches it exactly. A streak ends when the partition
+// recovers, or is released, or when an event below the threshold shows that
+// a new streak has started.
+func (t *T) run() {
+	defer close(t.alertCh)
+	threshold := t.cfg.Consumer.CommitFailureAlert.Threshold
+	alerted := make(map[partitionID]bool)
+	for ev := range t.sub.Events() {
+		if ev.Cluster != t.cfg.Cluster || ev.Partition == nil {
+			continue
+		}
+		id := partitionID{ev.Group, ev.Topic, *ev.Partition}
+		switch ev.Type {
+		case lifecycle.OffsetCommitFailed:
+			if ev.Failures < threshold {
+				delete(alerted, id)
+				continue
+			}
+			if alerted[id] {
+				continue
+			}
+			alerted[id] = true
+			t.actDesc.Log().Errorf("Offset commits keep failing: group=%s, topic=%s, partition=%d, failures=%d, class=%s",
+				ev.Group, ev.Topic, *ev.Partition, ev.Failures, ev.ErrorClass)
+			// The poster must not hold back the subscription, otherwise
+			// more lifecycle events are dropped.
+			select {
+			case t.alertCh <- ev:
+			default:
+				atomic.AddInt64(&t.dropped, 1)
+				t.actDesc.Log().Errorf("Alert queue is full, alert dropped: group=%s, topic=%s, partition=%d",
+					ev.Group, ev.Topic, *ev.Partition)
+			}
+		case lifecycle.OffsetCommitRecovered, lifecycle.PartitionReleased:
+			delete(alerted, id)
+		}
+	}
+}
+
+// runPoster posts queued alerts to the webhook one by one.
+func (t *T) runPoster() {
+	url := t.cfg.Consumer.CommitFailureAlert.WebhookURL
+	for ev := range t.alertCh {
+		if err := t.post(url, ev); err != nil {
+			t.actDesc.Log().WithError(err).Errorf("Failed to post commit failure alert: url=%s", url)
+		}
+	}
+}
+
+func (t *T) post(url string, ev lifecycle.Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	rs, err := t.httpClt.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+		return errors.Errorf("unexpected status: %d", rs.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package commitalert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/lifecycle"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CommitAlertSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&CommitAlertSuite{})
+
+func (s *CommitAlertSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Cluster = "foo"
+	s.cfg.Consumer.CommitFailureAlert.Threshold = 2
+}
+
+// An alert is posted once, when consecutive failures of a partition of the
+// cluster reach the threshold.
+func (s *CommitAlertSuite) TestAlert(c *C) {
+	var mu sync.Mutex
+	var posted []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev map[string]interface{}
+		c.Check(json.NewDecoder(r.Body).Decode(&ev), IsNil)
+		mu.Lock()
+		posted = append(posted, ev)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	s.cfg.Consumer.CommitFailureAlert.WebhookURL = server.URL
+	alert := Spawn(actor.Root(), s.cfg)
+	c.Assert(alert, NotNil)
+
+	barCfg := config.DefaultProxy()
+	barCfg.Cluster = "bar"
+
+	// When
+	for failures := 1; failures <= 3; failures++ {
+		ev := lifecycle.NewPartitionEvent(s.cfg, lifecycle.OffsetCommitFailed, "g1", "t1", 3)
+		ev.Error = "kaboom"
+		ev.ErrorClass = "kafka"
+		ev.Failures = failures
+		lifecycle.Publish(ev)
+	}
+	ev := lifecycle.NewPartitionEvent(barCfg, lifecycle.OffsetCommitFailed, "g1", "t1", 3)
+	ev.Failures = 2
+	lifecycle.Publish(ev)
+	alert.Stop()
+
+	// Then
+	c.Assert(posted, HasLen, 1)
+	c.Check(posted[0]["type"], Equals, "offset_commit_failed")
+	c.Check(posted[0]["cluster"], Equals, "foo")
+	c.Check(posted[0]["group"], Equals, "g1")
+	c.Check(posted[0]["topic"], Equals, "t1")
+	c.Check(posted[0]["partition"], Equals, float64(3))
+	c.Check(posted[0]["error"], Equals, "kaboom")
+	c.Check(posted[0]["error_class"], Equals, "kafka")
+	c.Check(posted[0]["failures"], Equals, float64(2))
+}
+
+// If the event that reaches the threshold is missed, then the alert is
+// raised by a later one. Another alert is raised for a partition only after
+// it recovers.
+func (s *CommitAlertSuite) TestAlertAboveThreshold(c *C) {
+	var mu sync.Mutex
+	var posted []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev map[string]interface{}
+		c.Check(json.NewDecoder(r.Body).Decode(&ev), IsNil)
+		mu.Lock()
+		posted = append(posted, ev)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	s.cfg.Consumer.CommitFailureAlert.WebhookURL = server.URL
+	alert := Spawn(actor.Root(), s.cfg)
+	c.Assert(alert, NotNil)
+
+	// When
+	for _, failures := range []int{3, 4} {
+		ev := lifecycle.NewPartitionEvent(s.cfg, lifecycle.OffsetCommitFailed, "g1", "t1", 3)
+		ev.Failures = failures
+		lifecycle.Publish(ev)
+	}
+	lifecycle.Publish(lifecycle.NewPartitionEvent(s.cfg, lifecycle.OffsetCommitRecovered, "g1", "t1", 3))
+	ev := lifecycle.NewPartitionEvent(s.cfg, lifecycle.OffsetCommitFailed, "g1", "t1", 3)
+	ev.Failures = 5
+	lifecycle.Publish(ev)
+	alert.Stop()
+
+	// Then
+	c.Assert(posted, HasLen, 2)
+	c.Check(posted[0]["failures"], Equals, float64(3))
+	c.Check(posted[1]["failures"], Equals, float64(5))
+}
+
+// Nothing is spawned if no webhook is configured.
+func (s *CommitAlertSuite) TestNoWebhook(c *C) {
+	c.Check(Spawn(actor.Root(), s.cfg), IsNil)
+}
@@ -8,21 +8,93 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/backoff"
+	"github.com/mailgun/kafka-pixy/brokerconn"
+	"github.com/mailgun/kafka-pixy/codec"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"gopkg.in/yaml.v2"
 )
 
+// Listener names as used in `read_only_listeners`.
+const (
+	ListenerGRPC  = "grpc"
+	ListenerTCP   = "tcp"
+	ListenerUnix  = "unix"
+	ListenerMQTT  = "mqtt"
+	ListenerSTOMP = "stomp"
+)
+
+// Lifecycle events that operator hooks can be fired on, as used in
+// `hooks.<name>.events`.
+const (
+	HookStarted          = "started"
+	HookClusterConnected = "cluster_connected"
+	HookDrainStarted     = "drain_started"
+	HookShutdownComplete = "shutdown_complete"
+)
+
+// Endpoint groups as used in `listener_endpoints`.
+const (
+	EndpointsProduce = "produce"
+	EndpointsConsume = "consume"
+	EndpointsOffsets = "offsets"
+	EndpointsAdmin   = "admin"
+	EndpointsDebug   = "debug"
+)
+
+// Policies that partitions are multiplexed with as used in
+// `consumer.mux_policy`.
+const (
+	MuxPolicyLag        = "lag"
+	MuxPolicyRoundRobin = "round_robin"
+	MuxPolicyWeighted   = "weighted"
+	MuxPolicyTimestamp  = "timestamp"
+)
+
+// Offset storage backends as used in `offset_storage.backend`.
+const (
+	OffsetStorageKafka = "kafka"
+	OffsetStorageRedis = "redis"
+	OffsetStorageEtcd  = "etcd"
+)
+
+// Modes of maintenance windows as used in `maintenance_windows.<name>.mode`.
+const (
+	MaintenancePause    = "pause"
+	MaintenanceReadOnly = "read_only"
+)
+
+// Policies applied when a produce validator fails to respond as used in
+// `produce_validation.<topic>.failure_policy`.
+const (
+	FailOpen   = "fail_open"
+	FailClosed = "fail_closed"
+)
+
+// Archive file formats as used in `archives.<name>.format`.
+const (
+	ArchiveFormatJSONL = "jsonl"
+	ArchiveFormatAvro  = "avro"
+)
+
+// Matches variables of a client ID template, e.g. `{{hostname}}`.
+var clientIDVarPattern = regexp.MustCompile(`{{[^{}]*}}`)
+
 // App defines Kafka-Pixy application configuration. It mirrors the structure
 // of the JSON configuration file.
 type App struct {
-	// TCP address that gRPC API server should listen on.
+	// TCP address that gRPC API server should listen on. Several comma
+	// separated addresses can be given, e.g. `[::]:19091,0.0.0.0:19091`, that
+	// is also the case with other TCP addresses below.
 	GRPCAddr string `yaml:"grpc_addr"`
 
 	// TCP address that HTTP API server should listen on.
@@ -32,6 +104,110 @@ type App struct {
 	// Listening on a unix domain socket is disabled by default.
 	UnixAddr string `yaml:"unix_addr"`
 
+	// TCP address that MQTT 3.1.1 server should listen on. The MQTT server
+	// is disabled by default.
+	MQTTAddr string `yaml:"mqtt_addr"`
+
+	// TCP address that STOMP 1.2 server should listen on. The STOMP server
+	// is disabled by default.
+	STOMPAddr string `yaml:"stomp_addr"`
+
+	// Static mapping of STOMP destinations to Kafka topics and consumer
+	// groups. Only destinations mentioned here can be used by STOMP clients.
+	STOMPDestinations map[string]STOMPDestination `yaml:"stomp_destinations"`
+
+	// Google Cloud Pub/Sub compatible API configuration.
+	PubSub struct {
+		// If set, HTTP API servers also serve a subset of Google Cloud
+		// Pub/Sub REST API: topics.publish, subscriptions.pull and
+		// subscriptions.acknowledge.
+		Enabled bool `yaml:"enabled"`
+
+		// Mapping of Pub/Sub subscriptions to Kafka topics and consumer
+		// groups. Only subscriptions mentioned here can be pulled from.
+		Subscriptions map[string]PubSubSubscription `yaml:"subscriptions"`
+	} `yaml:"pubsub"`
+
+	// Web dashboard configuration.
+	UI struct {
+		// If set, HTTP API servers also serve a web dashboard at `/ui/`
+		// that shows cluster health, topics, consumer groups and lag, and
+		// allows to peek and produce messages.
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"ui"`
+
+	// HTTP consume response compression configuration.
+	HTTPCompression struct {
+		// If set, HTTP API servers gzip consume responses to clients that
+		// accept it. Responses that carry already compressed messages, that
+		// is ones that start with magic bytes of a known compression or
+		// image format, or have a `Content-Encoding` header, are never
+		// compressed again.
+		Enabled bool `yaml:"enabled"`
+
+		// Responses shorter than this many bytes are not compressed.
+		MinSize int `yaml:"min_size"`
+	} `yaml:"http_compression"`
+
+	// Limits on produce request bodies that HTTP API servers read into
+	// memory before handing them over to the producer.
+	HTTPProduce struct {
+		// The maximum size of a produce request body in bytes. Zero means
+		// no limit.
+		MaxBodySize int64 `yaml:"max_body_size"`
+
+		// The total size in bytes of produce request bodies that all HTTP
+		// API servers hold in memory at a time. Zero means no limit.
+		BufferSize int64 `yaml:"buffer_size"`
+
+		// How long a request waits for its share of buffer_size before it
+		// is rejected.
+		BufferTimeout time.Duration `yaml:"buffer_timeout"`
+	} `yaml:"http_produce"`
+
+	// Tenants that share Kafka-Pixy. If configured, gRPC and HTTP API
+	// callers have to authenticate with API keys, and topic and group names
+	// they use are prefixed with the prefix of their tenant. Tenants are
+	// identified by names.
+	Tenants map[string]Tenant `yaml:"tenants"`
+
+	// API keys that authorize callers of the KafkaPixyAdmin gRPC service.
+	// If configured, or if tenants are, then the service requires callers
+	// to pass one of them in the `authorization` metadata. Tenant API keys
+	// do not authorize admin calls, and admin API keys do not authenticate
+	// callers of the KafkaPixy service.
+	AdminAPIKeys []string `yaml:"admin_api_keys"`
+
+	// Listeners that only allow to consume and read metadata. Producing and
+	// setting consumer group offsets via them are not allowed. Listeners
+	// are identified by the names of their address parameters without the
+	// `_addr` suffix, that is grpc, tcp, unix, mqtt and stomp.
+	ReadOnlyListeners []string `yaml:"read_only_listeners"`
+
+	// Endpoint groups served by listeners: produce, consume, offsets, admin
+	// and debug. Listeners are identified the same way as in
+	// `read_only_listeners`, but only grpc, tcp and unix are supported. If a
+	// listener is not mentioned, then it serves all endpoint groups.
+	ListenerEndpoints map[string][]string `yaml:"listener_endpoints"`
+
+	// Bounded worker pools that requests of endpoint groups are handled on,
+	// shared by all gRPC and HTTP listeners. They keep requests of one group,
+	// e.g. a flood of consume long polls, from taking up the capacity to
+	// serve the others.
+	ListenerPools ListenerPools `yaml:"listener_pools"`
+
+	// Names of gRPC interceptors that requests go through in order, after
+	// authentication. Besides the built-in `logging` interceptor, ones
+	// compiled into a custom build with `grpcsrv.RegisterInterceptor` can be
+	// used.
+	GRPCInterceptors []string `yaml:"grpc_interceptors"`
+
+	// Names of HTTP middleware that requests go through in order, after
+	// authentication. Besides the built-in `logging` middleware, ones
+	// compiled into a custom build with `httpsrv.RegisterMiddleware` can be
+	// used.
+	HTTPMiddleware []string `yaml:"http_middleware"`
+
 	// An arbitrary number of proxies to different Kafka/ZooKeeper clusters can
 	// be configured. Each proxy configuration is identified by a cluster name.
 	Proxies map[string]*Proxy `yaml:"proxies"`
@@ -41,18 +217,333 @@ type App struct {
 	// one mentioned in the `Proxies` section first is assumed.
 	DefaultCluster string `yaml:"default_cluster"`
 
+	// Alias names of clusters. An alias can be used anywhere a cluster name
+	// is expected in API calls, e.g. to keep client URLs stable while the
+	// cluster behind them is replaced.
+	ClusterAliases map[string]string `yaml:"cluster_aliases"`
+
+	// Routes produce requests that do not select a cluster explicitly to
+	// clusters by the value of a message header.
+	ProduceRouting ProduceRouting `yaml:"produce_routing"`
+
+	// Directory to keep host level lock files in. If set, then Kafka-Pixy
+	// locks a file per proxy client ID there, and refuses to start if
+	// another instance on the host uses any of the client IDs.
+	LockDir string `yaml:"lock_dir"`
+
+	// Hot standby configuration, see Standby.
+	Standby Standby `yaml:"standby"`
+
+	// How long API servers report the instance as draining to health checks
+	// on shutdown before it actually stops, so that load balancers and
+	// service meshes steer clients to other instances first. Requests are
+	// still served while draining. Zero by default.
+	DrainPeriod time.Duration `yaml:"drain_period"`
+
+	// Garbage collector tuning, for the default GC behavior can cause
+	// periodic long polling latency spikes at high message rates.
+	GC struct {
+		// The GC target percentage, see GOGC in the runtime package
+		// documentation. Zero keeps the runtime default, and -1 disables
+		// garbage collection altogether.
+		Percent int `yaml:"percent"`
+
+		// If set, a ballast of this many bytes is allocated on start. It
+		// is never touched so it takes no physical memory, but it raises
+		// the heap size that triggers garbage collection, making it run
+		// less often when the live heap is small.
+		BallastSize int64 `yaml:"ballast_size"`
+	} `yaml:"gc"`
+
+	// Automatic capture of profiles when the process looks unhealthy, so
+	// that there is data for post-incident analysis even if nobody was
+	// watching.
+	AutoProfile AutoProfile `yaml:"auto_profile"`
+
+	// Commands to run and webhooks to call on lifecycle events of the
+	// instance, so that site specific automation, e.g. service discovery
+	// registration, can key off its actual state. Hooks are identified by
+	// names.
+	Hooks map[string]Hook `yaml:"hooks"`
+
+	// Maintenance windows of all clusters, or of the clusters listed in a
+	// window. Windows are identified by names, that must not clash with
+	// names of windows configured for clusters in the `proxies` section.
+	MaintenanceWindows map[string]MaintenanceWindow `yaml:"maintenance_windows"`
+
+	// Masking of message contents in everything that Kafka-Pixy logs or
+	// taps, so that personal data does not leak into observability systems.
+	Redaction Redaction `yaml:"redaction"`
+
+	// Experimental behaviors to enable, by flag name. All of them are
+	// disabled by default.
+	Features map[string]bool `yaml:"features"`
+
 	// TLS is the application TLS configuration
 	TLS `yaml:"tls"`
 }
 
+// Standby defines a hot standby mode. If an election name is set, then only
+// one of Kafka-Pixy instances with the same election name serves API
+// requests, and the others stand by, with API servers stopped, to take over
+// when it goes away.
+type Standby struct {
+	// Name of an election in ZooKeeper that instances run in.
+	Election string `yaml:"election"`
+
+	// Cluster whose ZooKeeper hosts the election. The default cluster is
+	// used if not set.
+	Cluster string `yaml:"cluster"`
+}
+
+// AutoProfile defines when CPU, heap and goroutine profiles are captured
+// automatically, and how many captures are kept.
+type AutoProfile struct {
+	// Directory to write captures to, every capture to a subdirectory of
+	// its own. Automatic capture is disabled if empty.
+	Dir string `yaml:"dir"`
+
+	// How often the thresholds are checked.
+	CheckInterval time.Duration `yaml:"check_interval"`
+
+	// Thresholds that trigger a capture when crossed. The consume latency
+	// is the longest time that a consume request served with a message
+	// took since the previous check, the heap size is the number of bytes
+	// of allocated heap objects. Zero disables a threshold.
+	ConsumeLatency time.Duration `yaml:"consume_latency"`
+	Goroutines     int           `yaml:"goroutines"`
+	HeapSize       int64         `yaml:"heap_size"`
+
+	// How long a CPU profile is collected for.
+	CPUDuration time.Duration `yaml:"cpu_duration"`
+
+	// The minimum time between captures, so that a lasting anomaly does
+	// not fill the directory with the same picture.
+	MinInterval time.Duration `yaml:"min_interval"`
+
+	// The maximum number of captures kept in the directory. When a new
+	// capture is made the oldest ones are deleted.
+	MaxCaptures int `yaml:"max_captures"`
+}
+
+// Hook defines an operator hook. Exactly one of a command and a URL must be
+// set. Kafka-Pixy waits for a hook to complete before it carries on, up to
+// the timeout, and a failed hook is only logged.
+type Hook struct {
+	// Lifecycle events that the hook is fired on: started,
+	// cluster_connected, drain_started and shutdown_complete.
+	Events []string `yaml:"events"`
+
+	// A command to execute, the executable followed by arguments. The event
+	// is passed to it in KAFKA_PIXY_EVENT and KAFKA_PIXY_CLUSTER environment
+	// variables.
+	Command []string `yaml:"command"`
+
+	// A URL that the event is POSTed to as a JSON object. Any 2xx response
+	// means success.
+	URL string `yaml:"url"`
+
+	// The maximum time the hook may take. 10 seconds if zero.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h *Hook) validate() error {
+	if len(h.Events) == 0 {
+		return errors.New("events must be set")
+	}
+	for _, event := range h.Events {
+		switch event {
+		case HookStarted, HookClusterConnected, HookDrainStarted, HookShutdownComplete:
+		default:
+			return errors.Errorf("unknown event: %s", event)
+		}
+	}
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return errors.New("exactly one of command and url must be set")
+	}
+	if h.Timeout < 0 {
+		return errors.New("timeout must be >= 0")
+	}
+	return nil
+}
+
+// MaintenanceWindow defines a period of time during which consumption of a
+// cluster is paused, or the cluster is read-only. When the window ends the
+// cluster gets back to normal.
+type MaintenanceWindow struct {
+	// When the window starts and ends, e.g. `2026-11-01T02:00:00Z`.
+	Start time.Time `yaml:"start"`
+	End   time.Time `yaml:"end"`
+
+	// If set, then the window repeats with this period since the start,
+	// e.g. `24h` for a daily window or `168h` for a weekly one.
+	Every time.Duration `yaml:"every"`
+
+	// Either `pause` to pause consumption by consumer groups, or `read_only`
+	// to disable producing and setting offsets. Defaults to `pause`.
+	Mode string `yaml:"mode"`
+
+	// Consumer groups that are paused. If empty, then all groups are. Only
+	// allowed in the `pause` mode.
+	Groups []string `yaml:"groups"`
+
+	// Clusters that a global window applies to. If empty, then it applies
+	// to all clusters. Only allowed in global windows.
+	Clusters []string `yaml:"clusters"`
+}
+
+// appliesTo tells whether a global window applies to a cluster.
+func (w *MaintenanceWindow) appliesTo(cluster string) bool {
+	if len(w.Clusters) == 0 {
+		return true
+	}
+	for _, windowCluster := range w.Clusters {
+		if windowCluster == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *MaintenanceWindow) validate() error {
+	switch {
+	case w.Start.IsZero():
+		return errors.New("start must be set")
+	case !w.End.After(w.Start):
+		return errors.New("end must be after start")
+	case w.Every < 0:
+		return errors.New("every must be >= 0")
+	case w.Every > 0 && w.Every < w.End.Sub(w.Start):
+		return errors.New("every must be >= end - start")
+	}
+	switch w.Mode {
+	case "", MaintenancePause:
+	case MaintenanceReadOnly:
+		if len(w.Groups) > 0 {
+			return errors.New("groups are only allowed in the pause mode")
+		}
+	default:
+		return errors.Errorf("mode is invalid: %s", w.Mode)
+	}
+	return nil
+}
+
+// ListenerPools defines worker pools of endpoint groups. Requests of a group
+// without a pool, and those of the debug group, are not limited.
+type ListenerPools struct {
+	Produce Limit `yaml:"produce"`
+	Consume Limit `yaml:"consume"`
+	Offsets Limit `yaml:"offsets"`
+	Admin   Limit `yaml:"admin"`
+
+	// The maximum time a request waits in a queue for a free worker before
+	// it is rejected.
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+// Limits returns the pool limits mapped to endpoint groups.
+func (lp *ListenerPools) Limits() map[string]Limit {
+	return map[string]Limit{
+		EndpointsProduce: lp.Produce,
+		EndpointsConsume: lp.Consume,
+		EndpointsOffsets: lp.Offsets,
+		EndpointsAdmin:   lp.Admin,
+	}
+}
+
+// Redaction defines what message contents are masked in logs and taps.
+type Redaction struct {
+	// Regular expressions whose matches in message values are masked.
+	Patterns []string `yaml:"patterns"`
+
+	// Paths of fields in JSON object message values whose values are
+	// masked, e.g. `user.email` or `$.user.email`.
+	JSONFields []string `yaml:"json_fields"`
+
+	// Names of record headers whose values are masked. They are matched
+	// case insensitively.
+	Headers []string `yaml:"headers"`
+}
+
+// ProduceRouting defines how produce requests that do not select a cluster
+// explicitly are routed to clusters by a message header value.
+type ProduceRouting struct {
+	// Name of the message header whose value selects a cluster. It is
+	// matched case insensitively. Routing is disabled if empty.
+	Header string `yaml:"header"`
+
+	// Header value to cluster name or alias map.
+	Routes map[string]string `yaml:"routes"`
+
+	// Cluster name or alias that messages without the header, or with a
+	// header value not mentioned in routes, are produced to. If empty then
+	// the default cluster is assumed.
+	DefaultRoute string `yaml:"default_route"`
+}
+
+// STOMPDestination defines what Kafka topic and consumer group a STOMP
+// destination corresponds to.
+type STOMPDestination struct {
+	// Name of a cluster the topic belongs to. If empty then the default
+	// cluster is assumed.
+	Cluster string `yaml:"cluster"`
+
+	// Kafka topic that messages sent to the destination are produced to, and
+	// subscriptions to the destination consume from.
+	Topic string `yaml:"topic"`
+
+	// Consumer group that subscriptions to the destination consume on behalf
+	// of. If empty, then the destination can only be sent to.
+	Group string `yaml:"group"`
+}
+
+// Tenant defines how callers authenticate as a tenant and what topics and
+// groups the tenant can access.
+type Tenant struct {
+	// Prefix that is prepended to all topic and group names used by the
+	// tenant callers. Prefixes of different tenants must not be prefixes of
+	// each other, e.g. `acme.` and `acme.eu.`.
+	Prefix string `yaml:"prefix"`
+
+	// API keys that authenticate callers as the tenant.
+	APIKeys []string `yaml:"api_keys"`
+}
+
+// PubSubSubscription defines what Kafka topic and consumer group a Google
+// Cloud Pub/Sub subscription corresponds to.
+type PubSubSubscription struct {
+	// Kafka topic that the subscription consumes from.
+	Topic string `yaml:"topic"`
+
+	// Consumer group that the subscription consumes on behalf of. If empty,
+	// then the subscription name is used as the group.
+	Group string `yaml:"group"`
+}
+
 // Proxy defines configuration of a proxy to a particular Kafka/ZooKeeper
 // cluster.
 type Proxy struct {
 	// Unique ID that identifies a Kafka-Pixy instance in both ZooKeeper and
 	// Kafka. It is automatically generated by default and it is recommended to
-	// leave it like that.
+	// leave it like that. It can be a template with `{{hostname}}`,
+	// `{{cluster}}`, `{{pod}}` and `{{pid}}` variables resolved at startup,
+	// e.g. `pixy_{{hostname}}_{{cluster}}`, where `{{pod}}` is the value of
+	// the `POD_NAME` environment variable or the hostname if it is not set.
 	ClientID string `yaml:"client_id"`
 
+	// Alias of the cluster that the proxy is configured for in the
+	// `proxies` section. It is not read from the config but set when the
+	// config is parsed.
+	Cluster string `yaml:"-"`
+
+	// If set, then producing to the cluster and setting consumer group
+	// offsets are not allowed via any API.
+	ReadOnly bool `yaml:"read_only"`
+
+	// Maintenance windows of the cluster, in addition to the global ones
+	// that apply to it. Windows are identified by names.
+	MaintenanceWindows map[string]MaintenanceWindow `yaml:"maintenance_windows"`
+
 	Kafka struct {
 
 		// List of seed Kafka peers that Kafka-Pixy should access to resolve
@@ -61,6 +552,38 @@ type Proxy struct {
 
 		// Version of the Kafka cluster. Supported versions are 0.10.2.1 - 2.0.0
 		Version KafkaVersion
+
+		// If true, then seed brokers are asked for the API versions they
+		// support at startup, and the highest Kafka version supported by
+		// them is used instead of `version`. If no broker responds, e.g.
+		// because brokers are older than 0.10.0.0, then `version` is used.
+		NegotiateVersion bool `yaml:"negotiate_version"`
+
+		// For how long topic listings are served from cached metadata and
+		// topic configurations. Zero means that metadata is refreshed on
+		// every listing.
+		MetadataCacheTTL time.Duration `yaml:"metadata_cache_ttl"`
+
+		// How often to check cluster metadata for partition leadership
+		// movements, brokers joining and leaving, and controller changes.
+		// When leaders move, message fetchers are reassigned to new leaders
+		// right away. Zero disables the checks.
+		TopologyCheckInterval time.Duration `yaml:"topology_check_interval"`
+
+		// TLS that connections to brokers are secured with.
+		TLS ClientTLS `yaml:"tls"`
+
+		// SASL/PLAIN authentication that is performed on every connection
+		// to brokers, after the TLS handshake if TLS is enabled.
+		SASL struct {
+			Enabled bool   `yaml:"enabled"`
+			User    string `yaml:"user"`
+
+			// The password is either given inline, or read from a file. A
+			// file takes precedence, and is re-read when it changes.
+			Password     string `yaml:"password"`
+			PasswordPath string `yaml:"password_path"`
+		} `yaml:"sasl"`
 	} `yaml:"kafka"`
 
 	ZooKeeper struct {
@@ -72,12 +595,34 @@ type Proxy struct {
 		// A root directory in ZooKeeper to store consumers data.
 		Chroot string `yaml:"chroot"`
 
+		// If true, then the chroot directory and the consumers directory in
+		// it are created on startup if missing, e.g. in a fresh environment.
+		CreateChroot bool `yaml:"create_chroot"`
+
 		// ZooKeeper session timeout has to be a minimum of 2 times the
 		// tickTime (as set in the server configuration) and a maximum of 20
 		// times the tickTime. The default ZooKeeper tickTime is 2 seconds.
 		//
 		// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
 		SessionTimeout time.Duration `yaml:"session_timeout"`
+
+		// A secondary ZooKeeper ensemble that group coordination fails over
+		// to, e.g. while migrating between ensembles. It is expected to have
+		// the same chroot.
+		Secondary struct {
+			// List of seed ZooKeeper peers of the secondary ensemble. If
+			// empty, then there is no fail over.
+			SeedPeers []string `yaml:"seed_peers"`
+
+			// How long a session with the primary ensemble can fail to be
+			// established before failing over. Once failed over, the
+			// secondary ensemble is used until restart.
+			FailoverAfter time.Duration `yaml:"failover_after"`
+		} `yaml:"secondary"`
+
+		// TLS that connections to ZooKeeper peers are secured with, e.g.
+		// to the secure client port of ZooKeeper 3.5.5+.
+		TLS ClientTLS `yaml:"tls"`
 	} `yaml:"zoo_keeper"`
 
 	// Networking timeouts. These all pass through to sarama's `config.Net`
@@ -91,6 +636,20 @@ type Proxy struct {
 
 		// How long to wait for a transmit.
 		WriteTimeout time.Duration `yaml:"write_timeout"`
+
+		// The maximum number of connections to the cluster brokers that
+		// can be open at a time, shared by all Kafka clients of the
+		// cluster. If there are no free connection slots, then a
+		// connection waits for one for at most dial_timeout. Zero means no
+		// limit.
+		MaxConnections int `yaml:"max_connections"`
+
+		// How often certificate, key and password files of
+		// `kafka.tls`, `kafka.sasl` and `zoo_keeper.tls` are checked
+		// for changes. Changed files are re-read and used by connections
+		// established after that, so short lived credentials can be rotated
+		// without a restart. Zero means that files are only read on start.
+		CredentialsCheckInterval time.Duration `yaml:"credentials_check_interval"`
 	} `yaml:"net"`
 
 	Producer struct {
@@ -110,12 +669,25 @@ type Proxy struct {
 		// The best-effort frequency of flushes.
 		FlushFrequency time.Duration `yaml:"flush_frequency"`
 
-		// How long to wait for the cluster to settle between retries.
+		// How long to wait for the cluster to settle before the first retry.
+		// Every next retry waits twice as long as the previous one, up to
+		// RetryBackoffMax.
 		RetryBackoff time.Duration `yaml:"retry_backoff"`
 
+		// The maximum time to wait between retries.
+		RetryBackoffMax time.Duration `yaml:"retry_backoff_max"`
+
+		// A fraction of a retry backoff by that it is randomly shortened, so
+		// that retries of a Kafka-Pixy fleet are spread over time.
+		RetryJitter float64 `yaml:"retry_jitter"`
+
 		// The total number of times to retry sending a message.
 		RetryMax int `yaml:"retry_max"`
 
+		// If greater than zero, then the number of retries is limited so that
+		// the total time spent waiting between them does not exceed this.
+		RetryBudget time.Duration `yaml:"retry_budget"`
+
 		// The level of acknowledgement reliability needed from the broker.
 		RequiredAcks RequiredAcks `yaml:"required_acks"`
 
@@ -133,6 +705,73 @@ type Proxy struct {
 		// The broker will wait for replication to complete up to this duration
 		// before returning an error.
 		Timeout time.Duration `yaml:"timeout"`
+
+		// If greater than zero, then messages with values larger than this
+		// number of bytes are split into chunks of this size that are
+		// reassembled back on consume. Requires Kafka 0.11+.
+		ChunkSize int `yaml:"chunk_size"`
+
+		// If not empty, then messages produced via gRPC and HTTP APIs get
+		// the ID of the API request in a record header with this key.
+		// Requires Kafka 0.11+.
+		RequestIDHeader string `yaml:"request_id_header"`
+
+		// Record headers that are stamped onto every message produced via
+		// the APIs, so that platform wide metadata conventions do not
+		// depend on every client. Requires Kafka 0.11+.
+		Enrichment struct {
+			// Headers with fixed values, e.g. environment and region.
+			Headers map[string]string `yaml:"headers"`
+
+			// Headers of messages produced to particular topics, e.g.
+			// schema IDs, by topic. They take precedence over `headers`.
+			Topics map[string]map[string]string `yaml:"topics"`
+
+			// If not empty, then messages produced by a tenant get the
+			// tenant name in a record header with this key.
+			ProducerHeader string `yaml:"producer_header"`
+
+			// If true, then enrichment headers replace headers with the
+			// same keys given by clients. Otherwise headers given by clients
+			// are kept as they are.
+			Override bool `yaml:"override"`
+		} `yaml:"enrichment"`
+
+		// If true, then messages larger than `max.message.bytes` of the
+		// topic they are produced to are rejected without being sent to
+		// Kafka. Requires Kafka 0.11+.
+		CheckMaxMessageBytes bool `yaml:"check_max_message_bytes"`
+
+		// Regular expressions that callback URLs of asynchronous produce
+		// requests have to match entirely. If empty, then callbacks are not
+		// allowed.
+		CallbackURLWhitelist []string `yaml:"callback_url_whitelist"`
+
+		// How long to wait for a callback URL to accept a produce receipt.
+		CallbackTimeout time.Duration `yaml:"callback_timeout"`
+
+		// Where to keep messages that failed to be produced after all
+		// retries. If not configured, then such messages are dropped.
+		DeadLetter DeadLetter `yaml:"dead_letter"`
+
+		// Regular expressions that topics have to match entirely to be
+		// produced to. If empty, then all topics are allowed.
+		TopicWhitelist []string `yaml:"topic_whitelist"`
+
+		// Regular expressions that topics must not match entirely to be
+		// produced to. It takes precedence over the whitelist.
+		TopicBlacklist []string `yaml:"topic_blacklist"`
+
+		// If set, then topics that do not exist are created via the admin
+		// API before they are produced to. Otherwise produce requests to
+		// such topics are rejected. Requires Kafka 0.10.1.0+.
+		CreateMissingTopics bool `yaml:"create_missing_topics"`
+
+		// The number of partitions of topics created by Kafka-Pixy.
+		NewTopicPartitions int32 `yaml:"new_topic_partitions"`
+
+		// The replication factor of topics created by Kafka-Pixy.
+		NewTopicReplicationFactor int16 `yaml:"new_topic_replication_factor"`
 	} `yaml:"producer"`
 
 	Consumer struct {
@@ -144,6 +783,18 @@ type Proxy struct {
 		// before retrying.
 		AckTimeout time.Duration `yaml:"ack_timeout"`
 
+		// The longest ack timeout that a consume request may ask for, for
+		// messages that legitimately take long to handle. Zero means that
+		// requests can only ask for ack timeouts shorter than AckTimeout.
+		AckTimeoutCeiling time.Duration `yaml:"ack_timeout_ceiling"`
+
+		// The longest time a message can be handled by consumers, counting
+		// from when it was first offered, regardless of ack timeouts asked
+		// for. A message that is not acknowledged by then is written to the
+		// producer dead letter sink with the "processing timeout" reason and
+		// acknowledged, rather than offered again. Zero means no limit.
+		MaxProcessingTime time.Duration `yaml:"max_processing_time"`
+
 		// Size of all buffered channels created by the consumer module.
 		ChannelBufferSize int `yaml:"channel_buffer_size"`
 
@@ -155,6 +806,12 @@ type Proxy struct {
 		// for the producer to send messages larger than the consumer can fetch.
 		FetchMaxBytes int `yaml:"fetch_max_bytes"`
 
+		// If a message at the current offset of a partition is larger than
+		// FetchMaxBytes, then the partition is fetched again with twice the
+		// fetch size, but no larger than this value. Zero means that fetch
+		// size is never raised.
+		FetchMaxBytesCeiling int `yaml:"fetch_max_bytes_ceiling"`
+
 		// The maximum amount of time the server will block before answering
 		// the fetch request if there isn't data immediately available.
 		FetchMaxWait time.Duration `yaml:"fetch_max_wait"`
@@ -163,6 +820,11 @@ type Proxy struct {
 		// topic to become available before expiring.
 		LongPollingTimeout time.Duration `yaml:"long_polling_timeout"`
 
+		// The maximum number of messages per partition that are fetched and
+		// staged ahead of consume requests. Zero means that
+		// channel_buffer_size is used.
+		PrefetchCount int `yaml:"prefetch_count"`
+
 		// The maximum number of unacknowledged messages allowed for a
 		// particular group-topic-partition at a time. When this number is
 		// reached subsequent consume requests will return long polling timeout
@@ -178,7 +840,10 @@ type Proxy struct {
 		// parameter to -1.
 		MaxRetries int `yaml:"max_retries"`
 
-		// How frequently to commit offsets to Kafka.
+		// How frequently to commit offsets to Kafka. Offsets of all
+		// partitions of a group submitted within an interval are committed
+		// in one request, so it can be set well below a second when a tight
+		// redelivery window matters more than the commit request rate.
 		OffsetsCommitInterval time.Duration `yaml:"offsets_commit_interval"`
 
 		// Kafka-Pixy should wait this long after it gets notification that a
@@ -193,7 +858,603 @@ type Proxy struct {
 		// Period of time that Kafka-Pixy should keep subscription to
 		// a topic by a group in absence of requests from the consumer group.
 		SubscriptionTimeout time.Duration `yaml:"subscription_timeout"`
+
+		// The number of latest rebalances to keep in history per consumer
+		// group. If zero, then the history is not kept.
+		RebalanceHistory int `yaml:"rebalance_history"`
+
+		// The maximum total size of chunks of large messages that are kept in
+		// memory while waiting for the remaining chunks. Chunks that do not
+		// fit are spilled to disk.
+		ChunkMaxMemory int64 `yaml:"chunk_max_memory"`
+
+		// Directory that chunks of large messages are spilled to. If empty,
+		// then the default directory for temporary files is used.
+		ChunkSpillDir string `yaml:"chunk_spill_dir"`
+
+		// How long to wait for all chunks of a large message to arrive. When
+		// the timeout expires, received chunks are acknowledged and dropped.
+		ChunkTimeout time.Duration `yaml:"chunk_timeout"`
+
+		// De-duplication windows of consumer groups. If a group has one,
+		// then messages with an ID that has already been delivered to the
+		// group within the window are acknowledged without being delivered
+		// again. Windows are identified by consumer group names.
+		Dedup map[string]Dedup `yaml:"dedup"`
+
+		// The maximum age of messages by topic. Messages with record
+		// timestamps older than that are acknowledged and skipped instead of
+		// being delivered.
+		MaxAge map[string]time.Duration `yaml:"max_age"`
+
+		// Latest per key catch-up of consumer groups. If a group has it, and
+		// it starts consuming a partition lagging behind by at least the
+		// configured number of messages, then up to the high water mark only
+		// the latest message of every key is delivered, the others are
+		// acknowledged and skipped. Catch-ups are identified by consumer
+		// group names.
+		CatchUp map[string]CatchUp `yaml:"catch_up"`
+
+		// Health probes of services that consumer groups feed. While the
+		// probe of a group fails, messages are not offered to the group, so
+		// that consumption backs off while its downstream is unavailable.
+		// Probes are identified by consumer group names.
+		HealthProbes map[string]HealthProbe `yaml:"health_probes"`
+
+		// Policies that messages from partitions of a topic are multiplexed
+		// with to consumers of a group, by consumer group name. One of: lag,
+		// round_robin, weighted, timestamp. Groups that are not mentioned
+		// use lag.
+		MuxPolicy map[string]string `yaml:"mux_policy"`
+
+		// How long the timestamp policy holds a message back while
+		// partitions that have just run out of fetched messages may yet
+		// fetch older ones.
+		MuxTimestampWindow time.Duration `yaml:"mux_timestamp_window"`
+
+		// Partitions pinned to particular group members, by consumer group
+		// name, topic and member client ID. A pinned partition is assigned
+		// to its member whenever the member consumes the topic, and the
+		// remaining partitions are assigned as usual among members that have
+		// none pinned. All members of a group must have the same pins.
+		PartitionPins map[string]map[string]map[string][]int32 `yaml:"partition_pins"`
+
+		// What to do when offset commits of a partition fail repeatedly.
+		CommitFailureAlert CommitFailureAlert `yaml:"commit_failure_alert"`
+
+		// Cache of messages offered to clients but not acknowledged yet.
+		// When a partition consumer is restarted, e.g. after its group
+		// expired, it offers cached messages again instead of fetching them
+		// from Kafka.
+		MessageCache struct {
+			// The maximum total size of cached messages in bytes. Zero
+			// disables the cache.
+			MaxBytes int `yaml:"max_bytes"`
+		} `yaml:"message_cache"`
+
+		// Retry backoffs of ZooKeeper operations that maintain consumer group
+		// membership. Zero means that retry_backoff is used.
+		GroupMembership struct {
+			// How long to wait before trying to claim a partition again
+			// while it is still owned by another member. It also limits
+			// how often failed claims make the member resubmit its
+			// subscription.
+			ClaimRetryBackoff time.Duration `yaml:"claim_retry_backoff"`
+
+			// How long to wait before trying again to submit the member
+			// subscription and to fetch subscriptions of the group, which
+			// re-establishes the membership watch.
+			WatchRetryBackoff time.Duration `yaml:"watch_retry_backoff"`
+
+			// How long to wait before trying again to release a partition
+			// or to deregister the member.
+			ReleaseRetryBackoff time.Duration `yaml:"release_retry_backoff"`
+		} `yaml:"group_membership"`
 	} `yaml:"consumer"`
+
+	// Limits on concurrent requests to the cluster, so that a hot cluster
+	// cannot starve the others of goroutines and file descriptors.
+	Limits struct {
+		// Concurrent consume requests, that are long polls.
+		Consume Limit `yaml:"consume"`
+
+		// Concurrent synchronous produce requests.
+		Produce Limit `yaml:"produce"`
+
+		// The maximum number of consumer groups that Kafka-Pixy can be a
+		// member of at a time. Consume requests on behalf of other groups
+		// are rejected until some groups expire after the subscription
+		// timeout. Zero means no limit.
+		MaxGroups int `yaml:"max_groups"`
+	} `yaml:"limits"`
+
+	LifecycleEvents struct {
+		// Kafka topic that internal lifecycle events, e.g. rebalances and
+		// partition claims, are produced to as JSON documents keyed by
+		// consumer group names. If empty, then events are not produced.
+		Topic string `yaml:"topic"`
+	} `yaml:"lifecycle_events"`
+
+	// W3C trace context propagation. If enabled, then the `traceparent`
+	// and `tracestate` passed to gRPC and HTTP produce requests are stamped
+	// into record headers of produced messages, and a new trace is started
+	// for requests that carry none. Consume responses get the trace context
+	// of messages in the same headers. Requires Kafka 0.11+.
+	TraceContext struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"trace_context"`
+
+	// Counters of messages and bytes produced and consumed by topic, and
+	// consumed by group and topic, reported by `GET /_throughput`.
+	Throughput struct {
+		// The maximum number of topics and groups that are counted
+		// separately. The rest are counted together under `_other`. Zero
+		// means no limit.
+		MaxTopics int `yaml:"max_topics"`
+		MaxGroups int `yaml:"max_groups"`
+	} `yaml:"throughput"`
+
+	// Purging of consumer groups that have been idle for too long.
+	GroupJanitor GroupJanitor `yaml:"group_janitor"`
+
+	// Migration of consumer groups that committed offsets to ZooKeeper in
+	// the layout written by older Kafka-Pixy versions and the
+	// wvanbergen/kafka consumergroup library.
+	LegacyGroups LegacyGroups `yaml:"legacy_groups"`
+
+	// Consumer lag service level objectives of groups, evaluated by burn
+	// rates of their error budgets.
+	LagSLO LagSLOs `yaml:"lag_slo"`
+
+	// Latency service level objectives of synchronous produce requests to
+	// topics.
+	ProduceSLO ProduceSLOs `yaml:"produce_slo"`
+
+	// Codecs that message values of topics are encoded with, by topic.
+	// Values of these topics are transcoded in consume responses to a codec
+	// that a client asks for. Besides the built-in `json`, `msgpack` and
+	// `cbor` codecs, ones compiled into a custom build with
+	// `codec.Register` can be used.
+	ValueCodecs map[string]string `yaml:"value_codecs"`
+
+	// Storage that consumer group offsets are committed to.
+	OffsetStorage OffsetStorage `yaml:"offset_storage"`
+
+	// Key-value tables materialized from compacted topics. A table tails
+	// a topic and keeps the latest value of every key in memory to serve
+	// point lookups. Tables are identified by the topic names.
+	Tables map[string]Table `yaml:"tables"`
+
+	// Consume-transform-produce pipelines. A pipeline consumes messages from
+	// a source topic, passes them through a transformation, and produces
+	// results to a target topic. Produced messages and consumed offsets are
+	// committed in Kafka transactions, so every source message is reflected
+	// in the target topic exactly once. Pipelines are identified by names
+	// that are also used as Kafka transactional IDs. Requires Kafka 0.11+.
+	//
+	// Pipelines are not coordinated between Kafka-Pixy instances, so a
+	// pipeline must be configured on one instance only. Instances running
+	// a pipeline with the same name keep fencing each other off.
+	Pipelines map[string]Pipeline `yaml:"pipelines"`
+
+	// Archives of topics in S3 compatible object storage. An archive
+	// consumes a topic and writes messages to files, one per partition,
+	// that are uploaded when they grow large or old enough. Offsets are
+	// committed only after the files that contain the messages are
+	// uploaded, so every message is archived at least once. Archives are
+	// identified by names.
+	//
+	// Like pipelines, archives are not coordinated between Kafka-Pixy
+	// instances, so an archive must be configured on one instance only.
+	Archives map[string]Archive `yaml:"archives"`
+
+	// Claim-check offload of large message values to S3 compatible object
+	// storage. Values larger than the threshold are uploaded to a bucket,
+	// and only references to them are produced to Kafka. On consume the
+	// values are fetched back transparently. Claim-checks are identified by
+	// topic names.
+	ClaimCheck map[string]ClaimCheck `yaml:"claim_check"`
+
+	// Shadow produce duplicates a share of messages produced to a topic to a
+	// shadow topic, possibly on another cluster, so that new consumers can
+	// be tested against live traffic without touching the real topic.
+	// Shadows are identified by topic names.
+	Shadows map[string]Shadow `yaml:"shadows"`
+
+	// Validation webhooks that messages produced to topics are posted to
+	// before they are produced. Only messages that a validator accepts with
+	// a 2xx response are produced. Validations are identified by topic
+	// names.
+	ProduceValidation map[string]ProduceValidation `yaml:"produce_validation"`
+
+	// Settings of topics created by Kafka-Pixy, e.g. on produce if
+	// `producer.create_missing_topics` is set, instead of broker defaults.
+	TopicDefaults TopicDefaults `yaml:"topic_defaults"`
+}
+
+// DeadLetter defines where messages that failed to be produced are kept.
+// Either SpoolDir or Topic can be set, but not both.
+type DeadLetter struct {
+	// Directory that failed messages are appended to, as JSON lines, in a
+	// file named after the cluster.
+	SpoolDir string `yaml:"spool_dir"`
+
+	// Name of a cluster that failed messages are produced to. If empty, then
+	// the cluster that they failed to be produced to is used.
+	Cluster string `yaml:"cluster"`
+
+	// Topic that failed messages are produced to.
+	Topic string `yaml:"topic"`
+}
+
+// Shadow defines where copies of messages produced to a topic go.
+type Shadow struct {
+	// Name of a cluster that copies are produced to. If empty, then the
+	// cluster of the original topic is used.
+	Cluster string `yaml:"cluster"`
+
+	// Topic that copies are produced to. If empty, then the original topic
+	// name is used, that is only allowed with another cluster.
+	Topic string `yaml:"topic"`
+
+	// Percentage of produced messages that are copied, from 1 to 100.
+	Percent int `yaml:"percent"`
+}
+
+// ProduceValidation defines a validation webhook of a topic.
+type ProduceValidation struct {
+	// URL that candidate messages are posted to as JSON.
+	URL string `yaml:"url"`
+
+	// How long to wait for a validator to respond.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// What to do with a message if a validator fails to respond, or
+	// responds with 5xx, 408 or 429: either `fail_closed` to reject the
+	// message, or `fail_open` to produce it. Defaults to `fail_closed`.
+	FailurePolicy string `yaml:"failure_policy"`
+
+	// For how long verdicts on messages are cached. Identical messages, that
+	// is with the same key, value and headers, are validated once within
+	// that period. If zero, then verdicts are not cached.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// The maximum number of cached verdicts.
+	CacheSize int `yaml:"cache_size"`
+}
+
+// TopicDefaults defines settings that topics are created with.
+type TopicDefaults struct {
+	// The number of partitions. If zero, then
+	// `producer.new_topic_partitions` is used.
+	Partitions int32 `yaml:"partitions"`
+
+	// The replication factor. If zero, then
+	// `producer.new_topic_replication_factor` is used.
+	ReplicationFactor int16 `yaml:"replication_factor"`
+
+	// Topic config overrides, e.g. `retention.ms` or `cleanup.policy`.
+	Config map[string]string `yaml:"config"`
+}
+
+// CommitFailureAlert defines what happens when offset commits of a partition
+// fail repeatedly.
+type CommitFailureAlert struct {
+	// The number of consecutive failed commits of a partition that triggers
+	// the alert. If zero, then the alert is disabled.
+	Threshold int `yaml:"threshold"`
+
+	// URL that a commit failure event is posted to when a partition reaches
+	// the threshold. If empty, then nothing is posted.
+	WebhookURL string `yaml:"webhook_url"`
+
+	// How long to wait for the webhook to accept an event.
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+
+	// If true, then messages of a partition are not delivered while the
+	// number of its consecutive failed commits is at or above the threshold.
+	PauseDelivery bool `yaml:"pause_delivery"`
+}
+
+// ClaimCheck defines object storage offload for a topic.
+type ClaimCheck struct {
+	// Values larger than this number of bytes are offloaded.
+	Threshold int `yaml:"threshold"`
+
+	// URL of an S3 compatible API endpoint, e.g. https://s3.amazonaws.com,
+	// https://storage.googleapis.com or http://minio:9000.
+	Endpoint string `yaml:"endpoint"`
+
+	// Region that requests are signed for.
+	Region string `yaml:"region"`
+
+	// Bucket that values are uploaded to.
+	Bucket string `yaml:"bucket"`
+
+	// Prefix added to names of uploaded objects.
+	Prefix string `yaml:"prefix"`
+
+	// Credentials used to sign requests. For Google Cloud Storage these are
+	// HMAC keys of a service account.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+
+	// Timeout of object storage requests.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Pipeline defines a consume-transform-produce pipeline.
+type Pipeline struct {
+	// Topic that messages are consumed from.
+	SourceTopic string `yaml:"source_topic"`
+
+	// Topic that transformed messages are produced to.
+	TargetTopic string `yaml:"target_topic"`
+
+	// Consumer group that source offsets are committed on behalf of. It
+	// must not be used by any other consumers. If empty, then the pipeline
+	// name is used as the group.
+	Group string `yaml:"group"`
+
+	// Name of a registered transformation. The only built-in transformation
+	// is `identity`, that copies messages as is. It is used if neither
+	// transformation nor plugin is specified.
+	Transform string `yaml:"transform"`
+
+	// Path to a Go plugin that exports a transformation function named
+	// `Transform`. If specified, then `transform` must be empty.
+	Plugin string `yaml:"plugin"`
+
+	// The maximum number of source messages processed in one transaction.
+	BatchSize int `yaml:"batch_size"`
+
+	// The maximum time to wait for a batch to fill up before committing it.
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+
+	// The maximum time a transaction may stay open before the broker aborts
+	// it. It must not exceed `transaction.max.timeout.ms` of the brokers.
+	TransactionTimeout time.Duration `yaml:"transaction_timeout"`
+}
+
+// Archive defines where and how messages of a topic are archived.
+type Archive struct {
+	// Topic that messages are archived from.
+	Topic string `yaml:"topic"`
+
+	// Consumer group that offsets of archived messages are committed on
+	// behalf of. It must not be used by any other consumers. If empty, then
+	// the archive name is used as the group.
+	Group string `yaml:"group"`
+
+	// Format of archive files, either `jsonl`, that is JSON lines, or
+	// `avro`, that is Avro object container files.
+	Format string `yaml:"format"`
+
+	// A file is uploaded as soon as it grows to this number of bytes...
+	MaxFileSize int `yaml:"max_file_size"`
+
+	// ...or this long after the first message was written to it.
+	MaxFileAge time.Duration `yaml:"max_file_age"`
+
+	// URL of an S3 compatible API endpoint, e.g. https://s3.amazonaws.com,
+	// https://storage.googleapis.com or http://minio:9000.
+	Endpoint string `yaml:"endpoint"`
+
+	// Region that requests are signed for.
+	Region string `yaml:"region"`
+
+	// Bucket that files are uploaded to.
+	Bucket string `yaml:"bucket"`
+
+	// Prefix added to names of uploaded files.
+	Prefix string `yaml:"prefix"`
+
+	// Credentials used to sign requests. For Google Cloud Storage these are
+	// HMAC keys of a service account.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+
+	// Timeout of object storage requests.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Limit defines a concurrency limit of a request type.
+type Limit struct {
+	// The maximum number of requests processed at a time. Zero means no
+	// limit.
+	Concurrency int `yaml:"concurrency"`
+
+	// The maximum number of requests waiting for their turn when the
+	// concurrency limit is reached. Requests that do not fit into the queue,
+	// or that have been waiting for longer than the long polling timeout,
+	// are rejected.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// CatchUp defines a consumer group latest per key catch-up.
+type CatchUp struct {
+	// The minimum partition lag that triggers a catch-up.
+	MinLag int64 `yaml:"min_lag"`
+
+	// The maximum number of keys indexed for a partition. When the limit is
+	// reached, the catch-up range ends at the first message with a new key.
+	// Zero means the default of 1000000.
+	MaxKeys int `yaml:"max_keys"`
+}
+
+// HealthProbe defines a health probe of a service that a consumer group
+// feeds.
+type HealthProbe struct {
+	// A URL that is probed with GET requests. Any 2xx response means that
+	// the service is healthy.
+	URL string `yaml:"url"`
+
+	// How often the URL is probed. Zero means the default of 5s.
+	Interval time.Duration `yaml:"interval"`
+
+	// How long a probe may take. Zero means the default of 2s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// How many probes in a row have to fail for the group to be paused, and
+	// how many have to succeed for it to be resumed. Zero values mean the
+	// defaults of 3 and 1 respectively.
+	FailureThreshold int `yaml:"failure_threshold"`
+	SuccessThreshold int `yaml:"success_threshold"`
+}
+
+// Dedup defines a consumer group de-duplication window.
+type Dedup struct {
+	// Name of a message header that holds a message ID. If empty, then the
+	// message key is used as the ID. Messages without an ID are never
+	// considered duplicates.
+	Header string `yaml:"header"`
+
+	// How long a message ID is remembered after a message is delivered.
+	Window time.Duration `yaml:"window"`
+
+	// The maximum number of message IDs remembered for a group. When the
+	// limit is reached, the oldest IDs are forgotten first. Zero means the
+	// default of 100000.
+	MaxKeys int `yaml:"max_keys"`
+}
+
+// GroupJanitor defines purging of idle consumer groups.
+type GroupJanitor struct {
+	// Consumer groups that have had no members for longer than this are
+	// purged, that is offsets they committed to Kafka and their
+	// registrations in ZooKeeper are deleted. Zero disables purging.
+	// Requires Kafka 1.1.0+.
+	Retention time.Duration `yaml:"retention"`
+
+	// How often consumer groups are checked.
+	CheckInterval time.Duration `yaml:"check_interval"`
+
+	// If set, then groups that are due to be purged are only logged.
+	DryRun bool `yaml:"dry_run"`
+}
+
+// LegacyGroups defines migration of consumer groups that committed offsets
+// to ZooKeeper under `/consumers/<group>/offsets/<topic>/<partition>`.
+type LegacyGroups struct {
+	// If set, then on startup offsets that groups committed to ZooKeeper
+	// are copied to the offset storage, except for partitions that groups
+	// have offsets committed for there already. Every group is migrated
+	// once, migrations are recorded in ZooKeeper. Ignored if `read_only`
+	// is set.
+	Migrate bool `yaml:"migrate"`
+
+	// How often groups that could not be migrated, e.g. because members
+	// registered by older versions still consume them, are retried.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+
+	// If set, then offsets that would be migrated are only logged.
+	DryRun bool `yaml:"dry_run"`
+}
+
+// LagSLOs defines consumer lag service level objectives of groups.
+type LagSLOs struct {
+	// How often lag of the groups is checked. Every check is a sample that
+	// is either within the objective or not.
+	CheckInterval time.Duration `yaml:"check_interval"`
+
+	// Objectives mapped to consumer groups.
+	Groups map[string]LagSLO `yaml:"groups"`
+}
+
+// LagSLO defines a consumer lag service level objective of a group. The lag
+// of a group is within the objective if it is not above any of the maximums.
+type LagSLO struct {
+	// Topics consumed by the group, the lag is summed across all their
+	// partitions.
+	Topics []string `yaml:"topics"`
+
+	// The maximum number of messages the group may have yet to consume.
+	// Zero means no limit.
+	MaxLagMessages int64 `yaml:"max_lag_messages"`
+
+	// The maximum age of the oldest message the group has yet to consume.
+	// Zero means no limit.
+	MaxLagTime time.Duration `yaml:"max_lag_time"`
+
+	// The fraction of checks that have to be within the objective, e.g.
+	// 0.99. Zero means the default of 0.99.
+	Objective float64 `yaml:"objective"`
+
+	// The objective fires when the error budget is burnt at least this many
+	// times faster than the objective allows both over the short and the
+	// long window. Zero values mean the defaults: 1/12 of the long window,
+	// 1h and 14.4 respectively.
+	ShortWindow       time.Duration `yaml:"short_window"`
+	LongWindow        time.Duration `yaml:"long_window"`
+	BurnRateThreshold float64       `yaml:"burn_rate_threshold"`
+}
+
+// ProduceSLOs defines produce latency service level objectives of topics.
+type ProduceSLOs struct {
+	// Objectives mapped to topics.
+	Topics map[string]ProduceSLO `yaml:"topics"`
+}
+
+// ProduceSLO defines a latency service level objective of synchronous
+// produce requests to a topic. The objective is met if the given percentile
+// of request latencies is not above the maximum.
+type ProduceSLO struct {
+	// The maximum latency of a produce request, measured from when
+	// Kafka-Pixy gets the request until the message is acknowledged by
+	// Kafka.
+	MaxLatency time.Duration `yaml:"max_latency"`
+
+	// The percentile of requests that have to be within max_latency, e.g.
+	// 99. Zero means the default of 99.
+	Percentile float64 `yaml:"percentile"`
+}
+
+// OffsetStorage defines where consumer group offsets are committed to.
+type OffsetStorage struct {
+	// Backend that offsets are committed to, one of: kafka, redis, etcd.
+	// With kafka offsets are committed to the group coordinator of the
+	// cluster. The other backends are for Kafka compatible brokers that
+	// have no group coordinator.
+	Backend string `yaml:"backend"`
+
+	// Addresses of the backend that are tried in order: host:port of Redis
+	// servers, or URLs of etcd v3 endpoints, e.g. http://etcd1:2379.
+	Addrs []string `yaml:"addrs"`
+
+	// Prefix of keys that offsets are stored under. A key is the prefix
+	// followed by `<group>/<topic>/<partition>`.
+	KeyPrefix string `yaml:"key_prefix"`
+
+	// Credentials of the backend. Redis only uses the password.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Redis database number.
+	DB int `yaml:"db"`
+
+	// Timeout of backend requests.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Table defines a key-value table materialized from a compacted topic.
+type Table struct {
+	// Maximum total size of keys and values in bytes that a table can hold
+	// in memory. When the limit is reached, messages with new keys are
+	// dropped and lookups of missing keys fail. Zero means no limit.
+	MaxBytes int64 `yaml:"max_bytes"`
+
+	// Path to a file that a table is periodically saved to, so that on start
+	// it is loaded from the file and only the messages produced since the
+	// last save are read from the topic. If empty, then a table is rebuilt
+	// from the beginning of the topic on every start.
+	SnapshotPath string `yaml:"snapshot_path"`
+
+	// How often a table is saved to the snapshot file.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+
+	// If set, then the snapshot file is ignored on start and a table is
+	// rebuilt from the beginning of the topic.
+	RebuildOnStart bool `yaml:"rebuild_on_start"`
 }
 
 type KafkaVersion struct {
@@ -244,6 +1505,10 @@ func (kv *KafkaVersion) IsAtLeast(v sarama.KafkaVersion) bool {
 	return kv.v.IsAtLeast(v)
 }
 
+func (kv KafkaVersion) String() string {
+	return kv.v.String()
+}
+
 type Compression sarama.CompressionCodec
 
 func (c *Compression) UnmarshalText(text []byte) error {
@@ -301,19 +1566,57 @@ func (p *Proxy) SaramaProducerCfg() *sarama.Config {
 	saramaCfg.Net.DialTimeout = p.Net.DialTimeout
 	saramaCfg.Net.ReadTimeout = p.Net.ReadTimeout
 	saramaCfg.Net.WriteTimeout = p.Net.WriteTimeout
+	saramaCfg.Net.Proxy.Enable = true
+	saramaCfg.Net.Proxy.Dialer = brokerconn.ForCluster(p.Cluster, p.Net.MaxConnections, p.Net.DialTimeout)
 
 	saramaCfg.Producer.MaxMessageBytes = p.Producer.MaxMessageBytes
 	saramaCfg.Producer.Compression = sarama.CompressionCodec(p.Producer.Compression)
 	saramaCfg.Producer.Flush.Frequency = p.Producer.FlushFrequency
 	saramaCfg.Producer.Flush.Bytes = p.Producer.FlushBytes
+	retryBackoff := backoff.Exponential{
+		Base:   p.Producer.RetryBackoff,
+		Cap:    p.Producer.RetryBackoffMax,
+		Jitter: p.Producer.RetryJitter,
+	}
 	saramaCfg.Producer.Retry.Backoff = p.Producer.RetryBackoff
+	saramaCfg.Producer.Retry.BackoffFunc = func(retries, _ int) time.Duration {
+		return retryBackoff.Backoff(retries)
+	}
 	saramaCfg.Producer.Retry.Max = p.Producer.RetryMax
+	if p.Producer.RetryBudget > 0 {
+		if retries := retryBackoff.RetriesWithin(p.Producer.RetryBudget); retries < p.Producer.RetryMax {
+			saramaCfg.Producer.Retry.Max = retries
+		}
+	}
 	saramaCfg.Producer.RequiredAcks = sarama.RequiredAcks(p.Producer.RequiredAcks)
 	saramaCfg.Producer.Partitioner, _ = p.Producer.Partitioner.ToPartitionerConstructor()
 	saramaCfg.Producer.Timeout = p.Producer.Timeout
 	return saramaCfg
 }
 
+// NewTopicDetail returns the partition count, the replication factor and
+// config overrides that Kafka-Pixy creates topics with.
+func (p *Proxy) NewTopicDetail() *sarama.TopicDetail {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     p.TopicDefaults.Partitions,
+		ReplicationFactor: p.TopicDefaults.ReplicationFactor,
+	}
+	if detail.NumPartitions == 0 {
+		detail.NumPartitions = p.Producer.NewTopicPartitions
+	}
+	if detail.ReplicationFactor == 0 {
+		detail.ReplicationFactor = p.Producer.NewTopicReplicationFactor
+	}
+	if len(p.TopicDefaults.Config) > 0 {
+		detail.ConfigEntries = make(map[string]*string, len(p.TopicDefaults.Config))
+		for name, value := range p.TopicDefaults.Config {
+			value := value
+			detail.ConfigEntries[name] = &value
+		}
+	}
+	return detail
+}
+
 func (p *Proxy) SaramaClientCfg() *sarama.Config {
 	saramaCfg := sarama.NewConfig()
 	saramaCfg.ChannelBufferSize = p.Consumer.ChannelBufferSize
@@ -323,6 +1626,8 @@ func (p *Proxy) SaramaClientCfg() *sarama.Config {
 	saramaCfg.Net.DialTimeout = p.Net.DialTimeout
 	saramaCfg.Net.ReadTimeout = p.Net.ReadTimeout
 	saramaCfg.Net.WriteTimeout = p.Net.WriteTimeout
+	saramaCfg.Net.Proxy.Enable = true
+	saramaCfg.Net.Proxy.Dialer = brokerconn.ForCluster(p.Cluster, p.Net.MaxConnections, p.Net.DialTimeout)
 
 	return saramaCfg
 }
@@ -332,6 +1637,7 @@ func (p *Proxy) SaramaClientCfg() *sarama.Config {
 func DefaultApp(cluster string) *App {
 	appCfg := newApp()
 	proxyCfg := DefaultProxy()
+	proxyCfg.Cluster = cluster
 	appCfg.Proxies[cluster] = proxyCfg
 	appCfg.DefaultCluster = cluster
 	return appCfg
@@ -393,6 +1699,10 @@ func FromYAML(data []byte) (*App, error) {
 		if err := yaml.Unmarshal(encodedProxyCfg, proxyCfg); err != nil {
 			return nil, errors.Wrapf(err, "failed to parse proxy config, cluster=%s", cluster)
 		}
+		proxyCfg.Cluster = cluster
+		if proxyCfg.ClientID, err = expandClientID(proxyCfg.ClientID, cluster); err != nil {
+			return nil, errors.Wrapf(err, "invalid config parameter: invalid config, cluster=%s", cluster)
+		}
 		appCfg.Proxies[cluster] = proxyCfg
 		if appCfg.DefaultCluster == "" {
 			appCfg.DefaultCluster = cluster
@@ -402,6 +1712,7 @@ func FromYAML(data []byte) (*App, error) {
 	if err := appCfg.validate(); err != nil {
 		return nil, errors.Wrap(err, "invalid config parameter")
 	}
+	appCfg.applyMaintenanceWindows()
 
 	return appCfg, nil
 }
@@ -414,11 +1725,384 @@ func (a *App) validate() error {
 		if err := proxyCfg.validate(); err != nil {
 			return errors.Wrapf(err, "invalid config, cluster=%s", cluster)
 		}
+		if deadLetter := proxyCfg.Producer.DeadLetter; deadLetter.Topic != "" {
+			fallbackCfg := a.DeadLetterProxy(cluster)
+			if fallbackCfg == nil {
+				return errors.Errorf("invalid config, cluster=%s: producer.dead_letter.cluster is unknown: %s",
+					cluster, deadLetter.Cluster)
+			}
+			if !fallbackCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+				return errors.Errorf("invalid config, cluster=%s: producer.dead_letter.topic requires kafka.version >= 0.11.0.0",
+					cluster)
+			}
+		}
+	}
+	for cluster := range a.Proxies {
+		if err := a.validateShadows(cluster); err != nil {
+			return errors.Wrapf(err, "invalid config, cluster=%s", cluster)
+		}
+	}
+	if _, ok := a.Proxies[a.DefaultCluster]; !ok {
+		return errors.Errorf("default_cluster is unknown: %s", a.DefaultCluster)
+	}
+	for alias, cluster := range a.ClusterAliases {
+		if _, ok := a.Proxies[alias]; ok {
+			return errors.Errorf("cluster_aliases.%s clashes with a cluster name", alias)
+		}
+		if _, ok := a.Proxies[cluster]; !ok {
+			return errors.Errorf("cluster_aliases.%s refers to unknown cluster: %s", alias, cluster)
+		}
+	}
+	if err := a.validateProduceRouting(); err != nil {
+		return err
+	}
+	if a.HTTPCompression.MinSize < 0 {
+		return errors.New("http_compression.min_size must be >= 0")
+	}
+	if a.HTTPProduce.MaxBodySize < 0 {
+		return errors.New("http_produce.max_body_size must be >= 0")
+	}
+	if a.HTTPProduce.BufferSize < 0 {
+		return errors.New("http_produce.buffer_size must be >= 0")
+	}
+	if a.HTTPProduce.BufferTimeout <= 0 {
+		return errors.New("http_produce.buffer_timeout must be > 0")
+	}
+	if a.DrainPeriod < 0 {
+		return errors.New("drain_period must be >= 0")
+	}
+	if a.GC.Percent < -1 {
+		return errors.New("gc.percent must be >= -1")
+	}
+	if a.GC.BallastSize < 0 {
+		return errors.New("gc.ballast_size must be >= 0")
+	}
+	if a.AutoProfile.Dir != "" {
+		autoProfile := &a.AutoProfile
+		switch {
+		case autoProfile.CheckInterval <= 0:
+			return errors.New("auto_profile.check_interval must be > 0")
+		case autoProfile.ConsumeLatency < 0:
+			return errors.New("auto_profile.consume_latency must be >= 0")
+		case autoProfile.Goroutines < 0:
+			return errors.New("auto_profile.goroutines must be >= 0")
+		case autoProfile.HeapSize < 0:
+			return errors.New("auto_profile.heap_size must be >= 0")
+		case autoProfile.ConsumeLatency == 0 && autoProfile.Goroutines == 0 && autoProfile.HeapSize == 0:
+			return errors.New("auto_profile requires at least one threshold")
+		case autoProfile.CPUDuration <= 0:
+			return errors.New("auto_profile.cpu_duration must be > 0")
+		case autoProfile.MinInterval < 0:
+			return errors.New("auto_profile.min_interval must be >= 0")
+		case autoProfile.MaxCaptures <= 0:
+			return errors.New("auto_profile.max_captures must be > 0")
+		}
+	}
+	for name, hook := range a.Hooks {
+		if err := hook.validate(); err != nil {
+			return errors.Wrapf(err, "hooks.%s", name)
+		}
+	}
+	for name, window := range a.MaintenanceWindows {
+		if err := window.validate(); err != nil {
+			return errors.Wrapf(err, "maintenance_windows.%s", name)
+		}
+		for _, cluster := range window.Clusters {
+			if _, ok := a.Proxies[cluster]; !ok {
+				return errors.Errorf("maintenance_windows.%s.clusters has unknown cluster: %s", name, cluster)
+			}
+		}
+		for cluster, proxyCfg := range a.Proxies {
+			if _, ok := proxyCfg.MaintenanceWindows[name]; ok {
+				return errors.Errorf("maintenance_windows.%s clashes with a window of cluster %s", name, cluster)
+			}
+		}
+	}
+	if cluster := a.Standby.Cluster; cluster != "" {
+		if _, ok := a.Proxies[cluster]; !ok {
+			return errors.Errorf("standby.cluster is unknown: %s", cluster)
+		}
+	}
+	for _, pattern := range a.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "redaction.patterns has invalid pattern: %s", pattern)
+		}
+	}
+	for name, dst := range a.STOMPDestinations {
+		if dst.Topic == "" {
+			return errors.Errorf("stomp_destinations.%s.topic must be set", name)
+		}
+		if _, ok := a.Proxies[dst.Cluster]; dst.Cluster != "" && !ok {
+			return errors.Errorf("stomp_destinations.%s.cluster is unknown: %s", name, dst.Cluster)
+		}
+	}
+	for name, sub := range a.PubSub.Subscriptions {
+		if sub.Topic == "" {
+			return errors.Errorf("pubsub.subscriptions.%s.topic must be set", name)
+		}
+	}
+	for _, listener := range a.ReadOnlyListeners {
+		switch listener {
+		case ListenerGRPC, ListenerTCP, ListenerUnix, ListenerMQTT, ListenerSTOMP:
+		default:
+			return errors.Errorf("read_only_listeners has unknown listener: %s", listener)
+		}
+	}
+	for listener, groups := range a.ListenerEndpoints {
+		switch listener {
+		case ListenerGRPC, ListenerTCP, ListenerUnix:
+		default:
+			return errors.Errorf("listener_endpoints has unsupported listener: %s", listener)
+		}
+		for _, group := range groups {
+			switch group {
+			case EndpointsProduce, EndpointsConsume, EndpointsOffsets, EndpointsAdmin, EndpointsDebug:
+			default:
+				return errors.Errorf("listener_endpoints.%s has unknown endpoint group: %s", listener, group)
+			}
+		}
+	}
+	for group, limit := range a.ListenerPools.Limits() {
+		switch {
+		case limit.Concurrency < 0:
+			return errors.Errorf("listener_pools.%s.concurrency must be >= 0", group)
+		case limit.QueueSize < 0:
+			return errors.Errorf("listener_pools.%s.queue_size must be >= 0", group)
+		}
+	}
+	if a.ListenerPools.QueueTimeout <= 0 {
+		return errors.Errorf("listener_pools.queue_timeout must be > 0")
+	}
+	for _, apiKey := range a.AdminAPIKeys {
+		if apiKey == "" {
+			return errors.New("admin_api_keys must not be empty")
+		}
+		for name, tenant := range a.Tenants {
+			for _, tenantAPIKey := range tenant.APIKeys {
+				if apiKey == tenantAPIKey {
+					return errors.Errorf("admin_api_keys overlap with tenants.%s.api_keys", name)
+				}
+			}
+		}
+	}
+	return a.validateTenants()
+}
+
+// HasServers tells whether any API server is configured.
+func (a *App) HasServers() bool {
+	return a.GRPCAddr != "" || a.TCPAddr != "" || a.UnixAddr != "" || a.MQTTAddr != "" || a.STOMPAddr != ""
+}
+
+// StandbyProxy returns the config of a proxy whose ZooKeeper hosts the hot
+// standby election.
+func (a *App) StandbyProxy() *Proxy {
+	if a.Standby.Cluster != "" {
+		return a.Proxies[a.Standby.Cluster]
+	}
+	return a.Proxies[a.DefaultCluster]
+}
+
+// SetInstance makes client IDs of all proxies distinct for an instance, when
+// several instances are intended to run on one host with the same config, by
+// appending the instance name to them.
+func (a *App) SetInstance(instance string) {
+	for _, proxyCfg := range a.Proxies {
+		proxyCfg.ClientID += "_" + instance
+	}
+}
+
+// ClientIDs returns distinct client IDs of all proxies sorted.
+func (a *App) ClientIDs() []string {
+	seen := make(map[string]bool, len(a.Proxies))
+	var clientIDs []string
+	for _, proxyCfg := range a.Proxies {
+		if !seen[proxyCfg.ClientID] {
+			seen[proxyCfg.ClientID] = true
+			clientIDs = append(clientIDs, proxyCfg.ClientID)
+		}
+	}
+	sort.Strings(clientIDs)
+	return clientIDs
+}
+
+// CompileTopicPattern compiles a regular expression from a topic whitelist
+// or blacklist so that it only matches entire topic names.
+func CompileTopicPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// DeadLetterProxy returns the config of a proxy that messages that failed to
+// be produced to the specified cluster are produced to, or nil if the
+// configured cluster is unknown.
+func (a *App) DeadLetterProxy(cluster string) *Proxy {
+	proxyCfg := a.Proxies[cluster]
+	if proxyCfg == nil {
+		return nil
+	}
+	if deadLetterCluster := proxyCfg.Producer.DeadLetter.Cluster; deadLetterCluster != "" {
+		return a.Proxies[deadLetterCluster]
+	}
+	return proxyCfg
+}
+
+// ShadowProxies returns configs of proxies that copies of messages produced to
+// topics of the specified cluster are produced to, keyed by topic. A topic is
+// mapped to nil if its shadow cluster is unknown.
+func (a *App) ShadowProxies(cluster string) map[string]*Proxy {
+	proxyCfg := a.Proxies[cluster]
+	if proxyCfg == nil {
+		return nil
+	}
+	shadowCfgs := make(map[string]*Proxy, len(proxyCfg.Shadows))
+	for topic, shadow := range proxyCfg.Shadows {
+		if shadow.Cluster == "" {
+			shadowCfgs[topic] = proxyCfg
+			continue
+		}
+		shadowCfgs[topic] = a.Proxies[shadow.Cluster]
+	}
+	return shadowCfgs
+}
+
+// applyMaintenanceWindows adds global maintenance windows to the windows of
+// the clusters that they apply to.
+func (a *App) applyMaintenanceWindows() {
+	for name, window := range a.MaintenanceWindows {
+		for cluster, proxyCfg := range a.Proxies {
+			if !window.appliesTo(cluster) {
+				continue
+			}
+			if proxyCfg.MaintenanceWindows == nil {
+				proxyCfg.MaintenanceWindows = make(map[string]MaintenanceWindow)
+			}
+			proxyCfg.MaintenanceWindows[name] = window
+		}
+	}
+}
+
+// IsReadOnly tells whether a listener is configured to be read-only.
+func (a *App) IsReadOnly(listener string) bool {
+	for _, readOnlyListener := range a.ReadOnlyListeners {
+		if readOnlyListener == listener {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) validateShadows(cluster string) error {
+	for topic, shadowCfg := range a.ShadowProxies(cluster) {
+		shadow := a.Proxies[cluster].Shadows[topic]
+		if shadowCfg == nil {
+			return errors.Errorf("shadows.%s.cluster is unknown: %s", topic, shadow.Cluster)
+		}
+		if !shadowCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.Errorf("shadows.%s requires kafka.version >= 0.11.0.0", topic)
+		}
 	}
 	return nil
 }
 
+func (a *App) validateProduceRouting() error {
+	routing := a.ProduceRouting
+	if routing.Header == "" {
+		if len(routing.Routes) != 0 || routing.DefaultRoute != "" {
+			return errors.New("produce_routing.header must be set")
+		}
+		return nil
+	}
+	for value, cluster := range routing.Routes {
+		if !a.isClusterOrAlias(cluster) {
+			return errors.Errorf("produce_routing.routes.%s refers to unknown cluster: %s", value, cluster)
+		}
+	}
+	if routing.DefaultRoute != "" && !a.isClusterOrAlias(routing.DefaultRoute) {
+		return errors.Errorf("produce_routing.default_route refers to unknown cluster: %s", routing.DefaultRoute)
+	}
+	return nil
+}
+
+func (a *App) isClusterOrAlias(name string) bool {
+	if _, ok := a.Proxies[name]; ok {
+		return true
+	}
+	_, ok := a.ClusterAliases[name]
+	return ok
+}
+
+func (a *App) validateTenants() error {
+	if len(a.Tenants) == 0 {
+		return nil
+	}
+	if a.MQTTAddr != "" {
+		return errors.New("mqtt_addr cannot be used with tenants")
+	}
+	if a.STOMPAddr != "" {
+		return errors.New("stomp_addr cannot be used with tenants")
+	}
+	apiKeys := make(map[string]string)
+	for name, tenant := range a.Tenants {
+		if tenant.Prefix == "" {
+			return errors.Errorf("tenants.%s.prefix must be set", name)
+		}
+		if len(tenant.APIKeys) == 0 {
+			return errors.Errorf("tenants.%s.api_keys must be set", name)
+		}
+		for _, apiKey := range tenant.APIKeys {
+			if apiKey == "" {
+				return errors.Errorf("tenants.%s.api_keys must not be empty", name)
+			}
+			if other, ok := apiKeys[apiKey]; ok && other != name {
+				return errors.Errorf("tenants.%s.api_keys overlap with tenants.%s.api_keys", name, other)
+			}
+			apiKeys[apiKey] = name
+		}
+		for otherName, other := range a.Tenants {
+			if otherName != name && strings.HasPrefix(tenant.Prefix, other.Prefix) {
+				return errors.Errorf("tenants.%s.prefix overlaps with tenants.%s.prefix", name, otherName)
+			}
+		}
+	}
+	return nil
+}
+
+// MaxAckTimeout returns the longest ack timeout that a consume request may
+// ask for.
+func (p *Proxy) MaxAckTimeout() time.Duration {
+	if p.Consumer.AckTimeoutCeiling > p.Consumer.AckTimeout {
+		return p.Consumer.AckTimeoutCeiling
+	}
+	return p.Consumer.AckTimeout
+}
+
 func (p *Proxy) validate() error {
+	if len(p.ZooKeeper.Secondary.SeedPeers) > 0 && p.ZooKeeper.Secondary.FailoverAfter <= 0 {
+		return errors.New("zoo_keeper.secondary.failover_after must be > 0")
+	}
+	if p.Kafka.MetadataCacheTTL < 0 {
+		return errors.New("kafka.metadata_cache_ttl must be >= 0")
+	}
+	if p.Kafka.TopologyCheckInterval < 0 {
+		return errors.New("kafka.topology_check_interval must be >= 0")
+	}
+	if p.Net.MaxConnections < 0 {
+		return errors.New("net.max_connections must be >= 0")
+	}
+	if p.Net.CredentialsCheckInterval < 0 {
+		return errors.New("net.credentials_check_interval must be >= 0")
+	}
+	if err := p.Kafka.TLS.validate("kafka.tls"); err != nil {
+		return err
+	}
+	if p.Kafka.SASL.Enabled && p.Kafka.SASL.User == "" {
+		return errors.New("kafka.sasl.user must be set")
+	}
+	if p.Kafka.SASL.Enabled && p.Kafka.SASL.Password == "" && p.Kafka.SASL.PasswordPath == "" {
+		return errors.New("either kafka.sasl.password or kafka.sasl.password_path must be set")
+	}
+	if err := p.ZooKeeper.TLS.validate("zoo_keeper.tls"); err != nil {
+		return err
+	}
 	// Validate the Producer parameters.
 	switch {
 	case p.Producer.ChannelBufferSize <= 0:
@@ -429,8 +2113,14 @@ func (p *Proxy) validate() error {
 		return errors.New("producer.flush_frequency must be >= 0")
 	case p.Producer.RetryBackoff <= 0:
 		return errors.New("producer.retry_backoff must be > 0")
+	case p.Producer.RetryBackoffMax < p.Producer.RetryBackoff:
+		return errors.New("producer.retry_backoff_max must be >= producer.retry_backoff")
+	case p.Producer.RetryJitter < 0 || p.Producer.RetryJitter >= 1:
+		return errors.New("producer.retry_jitter must be >= 0 and < 1")
 	case p.Producer.RetryMax <= 0:
 		return errors.New("producer.retry_max must be > 0")
+	case p.Producer.RetryBudget < 0:
+		return errors.New("producer.retry_budget must be >= 0")
 	case p.Producer.ShutdownTimeout < 0:
 		return errors.New("producer.shutdown_timeout must be >= 0")
 	case p.Producer.Timeout < 0:
@@ -443,10 +2133,22 @@ func (p *Proxy) validate() error {
 	switch {
 	case p.Consumer.AckTimeout <= 0:
 		return errors.New("consumer.ack_timeout must be > 0")
+	case p.Consumer.AckTimeoutCeiling < 0:
+		return errors.New("consumer.ack_timeout_ceiling must be >= 0")
+	case p.Consumer.AckTimeoutCeiling > 0 && p.Consumer.AckTimeoutCeiling < p.Consumer.AckTimeout:
+		return errors.New("consumer.ack_timeout_ceiling must be >= consumer.ack_timeout")
+	case p.Consumer.MaxProcessingTime < 0:
+		return errors.New("consumer.max_processing_time must be >= 0")
 	case p.Consumer.ChannelBufferSize <= 0:
 		return errors.New("consumer.channel_buffer_size must be > 0")
+	case p.Consumer.PrefetchCount < 0:
+		return errors.New("consumer.prefetch_count must be >= 0")
 	case p.Consumer.FetchMaxBytes <= 0:
 		return errors.New("consumer.fetch_bytes must be > 0")
+	case p.Consumer.FetchMaxBytesCeiling < 0:
+		return errors.New("consumer.fetch_max_bytes_ceiling must be >= 0")
+	case p.Consumer.FetchMaxBytesCeiling > 0 && p.Consumer.FetchMaxBytesCeiling < p.Consumer.FetchMaxBytes:
+		return errors.New("consumer.fetch_max_bytes_ceiling must be >= consumer.fetch_max_bytes")
 	case p.Consumer.LongPollingTimeout <= 0:
 		return errors.New("consumer.long_polling_timeout must be > 0")
 	case p.Consumer.MaxPendingMessages <= 0:
@@ -457,8 +2159,316 @@ func (p *Proxy) validate() error {
 		return errors.New("consumer.offsets_commit_interval must be > 0")
 	case p.Consumer.SubscriptionTimeout <= 0:
 		return errors.New("consumer.subscription_timeout must be > 0")
+	case p.Consumer.RebalanceHistory < 0:
+		return errors.New("consumer.rebalance_history must be >= 0")
 	case p.Consumer.RetryBackoff <= 0:
 		return errors.New("consumer.retry_backoff must be > 0")
+	case p.Consumer.MuxTimestampWindow <= 0:
+		return errors.New("consumer.mux_timestamp_window must be > 0")
+	}
+	switch {
+	case p.Producer.ChunkSize < 0:
+		return errors.New("producer.chunk_size must be >= 0")
+	case p.Producer.ChunkSize > 0 && p.Producer.ChunkSize >= p.Producer.MaxMessageBytes:
+		return errors.New("producer.chunk_size must be < producer.max_message_bytes")
+	case p.Producer.ChunkSize > 0 && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0):
+		return errors.New("producer.chunk_size requires kafka.version >= 0.11.0.0")
+	case p.Producer.RequestIDHeader != "" && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0):
+		return errors.New("producer.request_id_header requires kafka.version >= 0.11.0.0")
+	case (len(p.Producer.Enrichment.Headers) > 0 || len(p.Producer.Enrichment.Topics) > 0 ||
+		p.Producer.Enrichment.ProducerHeader != "") && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0):
+		return errors.New("producer.enrichment requires kafka.version >= 0.11.0.0")
+	case p.TraceContext.Enabled && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0):
+		return errors.New("trace_context requires kafka.version >= 0.11.0.0")
+	case p.Producer.CheckMaxMessageBytes && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0):
+		return errors.New("producer.check_max_message_bytes requires kafka.version >= 0.11.0.0")
+	case p.Producer.CallbackTimeout <= 0:
+		return errors.New("producer.callback_timeout must be > 0")
+	case p.Producer.DeadLetter.SpoolDir != "" && p.Producer.DeadLetter.Topic != "":
+		return errors.New("producer.dead_letter.spool_dir and producer.dead_letter.topic are mutually exclusive")
+	case p.Producer.DeadLetter.Cluster != "" && p.Producer.DeadLetter.Topic == "":
+		return errors.New("producer.dead_letter.cluster requires producer.dead_letter.topic")
+	case p.Producer.NewTopicPartitions <= 0:
+		return errors.New("producer.new_topic_partitions must be > 0")
+	case p.Producer.NewTopicReplicationFactor <= 0:
+		return errors.New("producer.new_topic_replication_factor must be > 0")
+	case p.Producer.CreateMissingTopics && !p.Kafka.Version.IsAtLeast(sarama.V0_10_1_0):
+		return errors.New("producer.create_missing_topics requires kafka.version >= 0.10.1.0")
+	case p.TopicDefaults.Partitions < 0:
+		return errors.New("topic_defaults.partitions must be >= 0")
+	case p.TopicDefaults.ReplicationFactor < 0:
+		return errors.New("topic_defaults.replication_factor must be >= 0")
+	case p.Consumer.ChunkMaxMemory < 0:
+		return errors.New("consumer.chunk_max_memory must be >= 0")
+	case p.Consumer.ChunkTimeout <= 0:
+		return errors.New("consumer.chunk_timeout must be > 0")
+	case p.Consumer.CommitFailureAlert.Threshold < 0:
+		return errors.New("consumer.commit_failure_alert.threshold must be >= 0")
+	case p.Consumer.CommitFailureAlert.WebhookTimeout <= 0:
+		return errors.New("consumer.commit_failure_alert.webhook_timeout must be > 0")
+	case p.Consumer.MessageCache.MaxBytes < 0:
+		return errors.New("consumer.message_cache.max_bytes must be >= 0")
+	case p.Consumer.GroupMembership.ClaimRetryBackoff < 0:
+		return errors.New("consumer.group_membership.claim_retry_backoff must be >= 0")
+	case p.Consumer.GroupMembership.WatchRetryBackoff < 0:
+		return errors.New("consumer.group_membership.watch_retry_backoff must be >= 0")
+	case p.Consumer.GroupMembership.ReleaseRetryBackoff < 0:
+		return errors.New("consumer.group_membership.release_retry_backoff must be >= 0")
+	case p.Throughput.MaxTopics < 0:
+		return errors.New("throughput.max_topics must be >= 0")
+	case p.Throughput.MaxGroups < 0:
+		return errors.New("throughput.max_groups must be >= 0")
+	case p.Consumer.CommitFailureAlert.Threshold == 0 &&
+		(p.Consumer.CommitFailureAlert.WebhookURL != "" || p.Consumer.CommitFailureAlert.PauseDelivery):
+		return errors.New("consumer.commit_failure_alert requires threshold > 0")
+	}
+	for _, pattern := range p.Producer.TopicWhitelist {
+		if _, err := CompileTopicPattern(pattern); err != nil {
+			return errors.Wrapf(err, "producer.topic_whitelist has invalid pattern: %s", pattern)
+		}
+	}
+	for _, pattern := range p.Producer.TopicBlacklist {
+		if _, err := CompileTopicPattern(pattern); err != nil {
+			return errors.Wrapf(err, "producer.topic_blacklist has invalid pattern: %s", pattern)
+		}
+	}
+	for _, pattern := range p.Producer.CallbackURLWhitelist {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "producer.callback_url_whitelist has invalid pattern: %s", pattern)
+		}
+	}
+	// Validate the Limits parameters.
+	switch {
+	case p.Limits.Consume.Concurrency < 0:
+		return errors.New("limits.consume.concurrency must be >= 0")
+	case p.Limits.Consume.QueueSize < 0:
+		return errors.New("limits.consume.queue_size must be >= 0")
+	case p.Limits.Produce.Concurrency < 0:
+		return errors.New("limits.produce.concurrency must be >= 0")
+	case p.Limits.Produce.QueueSize < 0:
+		return errors.New("limits.produce.queue_size must be >= 0")
+	case p.Limits.MaxGroups < 0:
+		return errors.New("limits.max_groups must be >= 0")
+	}
+	// Validate the GroupJanitor parameters.
+	switch {
+	case p.GroupJanitor.Retention < 0:
+		return errors.New("group_janitor.retention must be >= 0")
+	case p.GroupJanitor.CheckInterval <= 0:
+		return errors.New("group_janitor.check_interval must be > 0")
+	case p.GroupJanitor.Retention > 0 && !p.Kafka.Version.IsAtLeast(sarama.V1_1_0_0):
+		return errors.New("group_janitor.retention requires kafka.version >= 1.1.0")
+	}
+	if p.LegacyGroups.Migrate && p.LegacyGroups.RetryInterval <= 0 {
+		return errors.New("legacy_groups.retry_interval must be > 0")
+	}
+	// Validate the LagSLO parameters.
+	if p.LagSLO.CheckInterval <= 0 {
+		return errors.New("lag_slo.check_interval must be > 0")
+	}
+	for group, slo := range p.LagSLO.Groups {
+		switch {
+		case len(slo.Topics) == 0:
+			return errors.Errorf("lag_slo.groups.%s.topics must not be empty", group)
+		case slo.MaxLagMessages < 0:
+			return errors.Errorf("lag_slo.groups.%s.max_lag_messages must be >= 0", group)
+		case slo.MaxLagTime < 0:
+			return errors.Errorf("lag_slo.groups.%s.max_lag_time must be >= 0", group)
+		case slo.MaxLagMessages == 0 && slo.MaxLagTime == 0:
+			return errors.Errorf("lag_slo.groups.%s needs max_lag_messages or max_lag_time", group)
+		case slo.Objective < 0 || slo.Objective >= 1:
+			return errors.Errorf("lag_slo.groups.%s.objective must be in [0, 1)", group)
+		case slo.ShortWindow < 0 || slo.LongWindow < 0:
+			return errors.Errorf("lag_slo.groups.%s windows must be >= 0", group)
+		case slo.ShortWindow > 0 && slo.LongWindow > 0 && slo.ShortWindow > slo.LongWindow:
+			return errors.Errorf("lag_slo.groups.%s.short_window must be <= long_window", group)
+		case slo.BurnRateThreshold < 0:
+			return errors.Errorf("lag_slo.groups.%s.burn_rate_threshold must be >= 0", group)
+		}
+	}
+	// Validate the ProduceSLO parameters.
+	for topic, slo := range p.ProduceSLO.Topics {
+		switch {
+		case slo.MaxLatency <= 0:
+			return errors.Errorf("produce_slo.topics.%s.max_latency must be > 0", topic)
+		case slo.Percentile < 0 || slo.Percentile >= 100:
+			return errors.Errorf("produce_slo.topics.%s.percentile must be in [0, 100)", topic)
+		}
+	}
+	for topic, codecName := range p.ValueCodecs {
+		if _, ok := codec.Lookup(codecName); !ok {
+			return errors.Errorf("value_codecs.%s is unknown: %s", topic, codecName)
+		}
+	}
+	// Validate the OffsetStorage parameters.
+	switch p.OffsetStorage.Backend {
+	case OffsetStorageKafka:
+	case OffsetStorageRedis, OffsetStorageEtcd:
+		switch {
+		case len(p.OffsetStorage.Addrs) == 0:
+			return errors.New("offset_storage.addrs must have at least one entry")
+		case p.OffsetStorage.DB < 0:
+			return errors.New("offset_storage.db must be >= 0")
+		case p.OffsetStorage.Timeout <= 0:
+			return errors.New("offset_storage.timeout must be > 0")
+		case p.GroupJanitor.Retention > 0:
+			return errors.New("group_janitor.retention requires offset_storage.backend kafka")
+		}
+	default:
+		return errors.Errorf("offset_storage.backend is invalid: %s", p.OffsetStorage.Backend)
+	}
+	for group, policy := range p.Consumer.MuxPolicy {
+		switch policy {
+		case MuxPolicyLag, MuxPolicyRoundRobin, MuxPolicyWeighted, MuxPolicyTimestamp:
+		default:
+			return errors.Errorf("consumer.mux_policy.%s is invalid: %s", group, policy)
+		}
+	}
+	for group, topicPins := range p.Consumer.PartitionPins {
+		for topic, memberPins := range topicPins {
+			pinnedTo := make(map[int32]string)
+			for clientID, partitions := range memberPins {
+				for _, partition := range partitions {
+					if partition < 0 {
+						return errors.Errorf("consumer.partition_pins.%s.%s.%s has invalid partition: %d", group, topic, clientID, partition)
+					}
+					if other, ok := pinnedTo[partition]; ok && other != clientID {
+						return errors.Errorf("consumer.partition_pins.%s.%s has partition %d pinned to more than one member", group, topic, partition)
+					}
+					pinnedTo[partition] = clientID
+				}
+			}
+		}
+	}
+	for topic, maxAge := range p.Consumer.MaxAge {
+		if maxAge <= 0 {
+			return errors.Errorf("consumer.max_age.%s must be > 0", topic)
+		}
+	}
+	for group, catchUp := range p.Consumer.CatchUp {
+		if catchUp.MinLag < 0 {
+			return errors.Errorf("consumer.catch_up.%s.min_lag must be >= 0", group)
+		}
+		if catchUp.MaxKeys < 0 {
+			return errors.Errorf("consumer.catch_up.%s.max_keys must be >= 0", group)
+		}
+	}
+	for group, probe := range p.Consumer.HealthProbes {
+		switch {
+		case probe.URL == "":
+			return errors.Errorf("consumer.health_probes.%s.url must be set", group)
+		case probe.Interval < 0:
+			return errors.Errorf("consumer.health_probes.%s.interval must be >= 0", group)
+		case probe.Timeout < 0:
+			return errors.Errorf("consumer.health_probes.%s.timeout must be >= 0", group)
+		case probe.FailureThreshold < 0 || probe.SuccessThreshold < 0:
+			return errors.Errorf("consumer.health_probes.%s thresholds must be >= 0", group)
+		}
+	}
+	// Validate the Dedup parameters.
+	for group, dedup := range p.Consumer.Dedup {
+		if dedup.Window <= 0 {
+			return errors.Errorf("consumer.dedup.%s.window must be > 0", group)
+		}
+		if dedup.MaxKeys < 0 {
+			return errors.Errorf("consumer.dedup.%s.max_keys must be >= 0", group)
+		}
+	}
+	// Validate the Tables parameters.
+	for topic, tbl := range p.Tables {
+		switch {
+		case tbl.MaxBytes < 0:
+			return errors.Errorf("tables.%s.max_bytes must be >= 0", topic)
+		case tbl.SnapshotInterval < 0:
+			return errors.Errorf("tables.%s.snapshot_interval must be >= 0", topic)
+		}
+	}
+	// Validate the ClaimCheck parameters.
+	for topic, cc := range p.ClaimCheck {
+		switch {
+		case cc.Threshold <= 0:
+			return errors.Errorf("claim_check.%s.threshold must be > 0", topic)
+		case cc.Endpoint == "":
+			return errors.Errorf("claim_check.%s.endpoint must be set", topic)
+		case cc.Bucket == "":
+			return errors.Errorf("claim_check.%s.bucket must be set", topic)
+		case cc.Timeout < 0:
+			return errors.Errorf("claim_check.%s.timeout must be >= 0", topic)
+		}
+		if !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("claim_check requires kafka.version >= 0.11.0.0")
+		}
+	}
+	// Validate the Shadows parameters.
+	for topic, shadow := range p.Shadows {
+		switch {
+		case shadow.Percent < 1 || shadow.Percent > 100:
+			return errors.Errorf("shadows.%s.percent must be between 1 and 100", topic)
+		case shadow.Cluster == "" && (shadow.Topic == "" || shadow.Topic == topic):
+			return errors.Errorf("shadows.%s must name another topic or cluster", topic)
+		}
+	}
+	for name, window := range p.MaintenanceWindows {
+		if err := window.validate(); err != nil {
+			return errors.Wrapf(err, "maintenance_windows.%s", name)
+		}
+		if len(window.Clusters) > 0 {
+			return errors.Errorf("maintenance_windows.%s.clusters is only allowed in global windows", name)
+		}
+	}
+	// Validate the ProduceValidation parameters.
+	for topic, pv := range p.ProduceValidation {
+		switch {
+		case pv.URL == "":
+			return errors.Errorf("produce_validation.%s.url must be set", topic)
+		case pv.Timeout < 0:
+			return errors.Errorf("produce_validation.%s.timeout must be >= 0", topic)
+		case pv.FailurePolicy != "" && pv.FailurePolicy != FailOpen && pv.FailurePolicy != FailClosed:
+			return errors.Errorf("produce_validation.%s.failure_policy is invalid: %s", topic, pv.FailurePolicy)
+		case pv.CacheTTL < 0:
+			return errors.Errorf("produce_validation.%s.cache_ttl must be >= 0", topic)
+		case pv.CacheSize < 0:
+			return errors.Errorf("produce_validation.%s.cache_size must be >= 0", topic)
+		}
+	}
+	// Validate the Pipelines parameters.
+	if len(p.Pipelines) > 0 && !p.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.New("pipelines require kafka.version >= 0.11.0.0")
+	}
+	for name, pl := range p.Pipelines {
+		switch {
+		case pl.SourceTopic == "":
+			return errors.Errorf("pipelines.%s.source_topic must be set", name)
+		case pl.TargetTopic == "":
+			return errors.Errorf("pipelines.%s.target_topic must be set", name)
+		case pl.Transform != "" && pl.Plugin != "":
+			return errors.Errorf("pipelines.%s: transform and plugin are mutually exclusive", name)
+		case pl.BatchSize < 0:
+			return errors.Errorf("pipelines.%s.batch_size must be >= 0", name)
+		case pl.BatchTimeout < 0:
+			return errors.Errorf("pipelines.%s.batch_timeout must be >= 0", name)
+		case pl.TransactionTimeout < 0:
+			return errors.Errorf("pipelines.%s.transaction_timeout must be >= 0", name)
+		}
+	}
+	// Validate the Archives parameters.
+	for name, arc := range p.Archives {
+		switch {
+		case arc.Topic == "":
+			return errors.Errorf("archives.%s.topic must be set", name)
+		case arc.Format != "" && arc.Format != ArchiveFormatJSONL && arc.Format != ArchiveFormatAvro:
+			return errors.Errorf("archives.%s.format must be either %s or %s", name, ArchiveFormatJSONL, ArchiveFormatAvro)
+		case arc.MaxFileSize < 0:
+			return errors.Errorf("archives.%s.max_file_size must be >= 0", name)
+		case arc.MaxFileAge < 0:
+			return errors.Errorf("archives.%s.max_file_age must be >= 0", name)
+		case arc.Endpoint == "":
+			return errors.Errorf("archives.%s.endpoint must be set", name)
+		case arc.Bucket == "":
+			return errors.Errorf("archives.%s.bucket must be set", name)
+		case arc.Timeout < 0:
+			return errors.Errorf("archives.%s.timeout must be >= 0", name)
+		}
 	}
 	return nil
 }
@@ -467,6 +2477,13 @@ func newApp() *App {
 	appCfg := &App{}
 	appCfg.GRPCAddr = "0.0.0.0:19091"
 	appCfg.TCPAddr = "0.0.0.0:19092"
+	appCfg.HTTPCompression.MinSize = 1024
+	appCfg.HTTPProduce.BufferTimeout = 3 * time.Second
+	appCfg.ListenerPools.QueueTimeout = 3 * time.Second
+	appCfg.AutoProfile.CheckInterval = 10 * time.Second
+	appCfg.AutoProfile.CPUDuration = 10 * time.Second
+	appCfg.AutoProfile.MinInterval = 10 * time.Minute
+	appCfg.AutoProfile.MaxCaptures = 10
 	appCfg.Proxies = make(map[string]*Proxy)
 	return appCfg
 }
@@ -476,6 +2493,7 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.ClientID = clientID
 	c.ZooKeeper.SeedPeers = []string{"localhost:2181"}
 	c.ZooKeeper.SessionTimeout = 15 * time.Second
+	c.ZooKeeper.Secondary.FailoverAfter = time.Minute
 
 	c.Kafka.SeedPeers = []string{"localhost:9092"}
 
@@ -491,6 +2509,7 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.Net.DialTimeout = 30 * time.Second
 	c.Net.ReadTimeout = 30 * time.Second
 	c.Net.WriteTimeout = 30 * time.Second
+	c.Net.CredentialsCheckInterval = 10 * time.Second
 
 	c.Producer.ChannelBufferSize = 4096
 	c.Producer.MaxMessageBytes = 1000000
@@ -498,11 +2517,24 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.Producer.FlushFrequency = 500 * time.Millisecond
 	c.Producer.FlushBytes = 1024 * 1024
 	c.Producer.RequiredAcks = RequiredAcks(sarama.WaitForAll)
-	c.Producer.RetryBackoff = 10 * time.Second
+	c.Producer.RetryBackoff = time.Second
+	c.Producer.RetryBackoffMax = 10 * time.Second
+	c.Producer.RetryJitter = 0.2
 	c.Producer.RetryMax = 6
 	c.Producer.ShutdownTimeout = 30 * time.Second
 	c.Producer.Partitioner = PartitionerConstructor("hash")
 	c.Producer.Timeout = 10 * time.Second
+	c.Producer.CallbackTimeout = 5 * time.Second
+	c.Producer.NewTopicPartitions = 1
+	c.Producer.NewTopicReplicationFactor = 1
+	c.GroupJanitor.CheckInterval = time.Hour
+	c.LegacyGroups.RetryInterval = time.Minute
+	c.LagSLO.CheckInterval = 30 * time.Second
+	c.Throughput.MaxTopics = 1000
+	c.Throughput.MaxGroups = 1000
+	c.OffsetStorage.Backend = OffsetStorageKafka
+	c.OffsetStorage.KeyPrefix = "kafka-pixy/offsets/"
+	c.OffsetStorage.Timeout = 5 * time.Second
 
 	c.Consumer.AckTimeout = 300 * time.Second
 	c.Consumer.ChannelBufferSize = 64
@@ -513,7 +2545,12 @@ func defaultProxyWithClientID(clientID string) *Proxy {
 	c.Consumer.MaxRetries = -1
 	c.Consumer.OffsetsCommitInterval = 500 * time.Millisecond
 	c.Consumer.SubscriptionTimeout = 15 * time.Second
+	c.Consumer.RebalanceHistory = 10
 	c.Consumer.RetryBackoff = 500 * time.Millisecond
+	c.Consumer.MuxTimestampWindow = 100 * time.Millisecond
+	c.Consumer.ChunkMaxMemory = 64 * 1024 * 1024
+	c.Consumer.ChunkTimeout = time.Minute
+	c.Consumer.CommitFailureAlert.WebhookTimeout = 5 * time.Second
 	return c
 }
 
@@ -533,6 +2570,43 @@ func newClientID() string {
 	return "kp_" + hostname + "_" + cid
 }
 
+// expandClientID resolves variables of a client ID template.
+func expandClientID(clientID, cluster string) (string, error) {
+	if !strings.Contains(clientID, "{{") {
+		return clientID, nil
+	}
+	var err error
+	expanded := clientIDVarPattern.ReplaceAllStringFunc(clientID, func(v string) string {
+		name := strings.TrimSpace(v[2 : len(v)-2])
+		switch name {
+		case "cluster":
+			return cluster
+		case "pid":
+			return strconv.Itoa(os.Getpid())
+		case "hostname", "pod":
+			if pod := os.Getenv("POD_NAME"); name == "pod" && pod != "" {
+				return pod
+			}
+			hostname, hostnameErr := os.Hostname()
+			if hostnameErr != nil && err == nil {
+				err = errors.Wrap(hostnameErr, "client_id cannot be resolved")
+			}
+			return hostname
+		}
+		if err == nil {
+			err = errors.Errorf("client_id has unknown variable: %s", name)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(expanded, "{{") || strings.Contains(expanded, "}}") {
+		return "", errors.Errorf("client_id is malformed: %s", clientID)
+	}
+	return expanded, nil
+}
+
 func getDockerCID() (string, error) {
 	f, err := os.Open("/proc/self/cgroup")
 	if err != nil {
@@ -563,6 +2637,35 @@ type TLS struct {
 	KeyPath  string `yaml:"key_path"`
 }
 
+// ClientTLS is TLS configuration of connections that Kafka-Pixy makes.
+type ClientTLS struct {
+	Enabled bool `yaml:"enabled"`
+
+	// PEM encoded CA certificates that server certificates are verified
+	// with. If not set, then the host root CA set is used.
+	CAPath string `yaml:"ca_path"`
+
+	// PEM encoded client certificate and key. If not set, then no client
+	// certificate is presented.
+	CertPath string `yaml:"certificate_path"`
+	KeyPath  string `yaml:"key_path"`
+
+	// Server name that server certificates are verified against. Defaults
+	// to the host name of a peer.
+	ServerName string `yaml:"server_name"`
+
+	// If true, then server certificates are not verified. For testing
+	// only!
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+func (t *ClientTLS) validate(section string) error {
+	if (t.CertPath == "") != (t.KeyPath == "") {
+		return errors.Errorf("%s.certificate_path and %s.key_path must be set together", section, section)
+	}
+	return nil
+}
+
 // GRPCSecurityOpts returns an array (possibly empty) with gRPC security
 // configuration if properly configured
 func (a *App) GRPCSecurityOpts() ([]grpc.ServerOption, error) {
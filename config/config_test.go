@@ -1,6 +1,10 @@
 package config
 
 import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -96,6 +100,304 @@ func (s *ConfigSuite) TestFromYAMLDefault(c *C) {
 	c.Assert(appCfg.Proxies["bazz"].ClientID, Equals, "bazz_id")
 }
 
+// Aliases must refer to configured clusters and must not clash with them.
+func (s *ConfigSuite) TestFromYAMLClusterAliases(c *C) {
+	for i, tc := range []struct {
+		aliases string
+		err     string
+	}{{
+		aliases: "  main: foo\n",
+	}, {
+		aliases: "  main: bazz\n",
+		err:     "invalid config parameter: cluster_aliases.main refers to unknown cluster: bazz",
+	}, {
+		aliases: "  bar: foo\n",
+		err:     "invalid config parameter: cluster_aliases.bar clashes with a cluster name",
+	}} {
+		data := []byte("" +
+			"cluster_aliases:\n" + tc.aliases +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n" +
+			"  bar:\n" +
+			"    client_id: bar_id\n")
+
+		// When
+		appCfg, err := FromYAML(data)
+
+		// Then
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err, Commentf("case #%d", i))
+			continue
+		}
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(appCfg.ClusterAliases, DeepEquals, map[string]string{"main": "foo"}, Commentf("case #%d", i))
+	}
+}
+
+// Produce routes must refer to configured clusters or aliases.
+func (s *ConfigSuite) TestFromYAMLProduceRouting(c *C) {
+	for i, tc := range []struct {
+		routing string
+		err     string
+	}{{
+		routing: "" +
+			"  header: region\n" +
+			"  routes:\n" +
+			"    eu: foo\n" +
+			"    us: main\n" +
+			"  default_route: bar\n",
+	}, {
+		routing: "" +
+			"  routes:\n" +
+			"    eu: foo\n",
+		err: "invalid config parameter: produce_routing.header must be set",
+	}, {
+		routing: "" +
+			"  header: region\n" +
+			"  routes:\n" +
+			"    eu: bazz\n",
+		err: "invalid config parameter: produce_routing.routes.eu refers to unknown cluster: bazz",
+	}, {
+		routing: "" +
+			"  header: region\n" +
+			"  default_route: bazz\n",
+		err: "invalid config parameter: produce_routing.default_route refers to unknown cluster: bazz",
+	}} {
+		data := []byte("" +
+			"cluster_aliases:\n" +
+			"  main: foo\n" +
+			"produce_routing:\n" + tc.routing +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n" +
+			"  bar:\n" +
+			"    client_id: bar_id\n")
+
+		// When
+		appCfg, err := FromYAML(data)
+
+		// Then
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err, Commentf("case #%d", i))
+			continue
+		}
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(appCfg.ProduceRouting, DeepEquals, ProduceRouting{
+			Header:       "region",
+			Routes:       map[string]string{"eu": "foo", "us": "main"},
+			DefaultRoute: "bar",
+		}, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLRedaction(c *C) {
+	data := []byte("" +
+		"redaction:\n" +
+		"  patterns: ['\\d{16}']\n" +
+		"  json_fields: [$.user.email]\n" +
+		"  headers: [Authorization]\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Redaction, DeepEquals, Redaction{
+		Patterns:   []string{`\d{16}`},
+		JSONFields: []string{"$.user.email"},
+		Headers:    []string{"Authorization"},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLRedactionInvalid(c *C) {
+	data := []byte("" +
+		"redaction:\n" +
+		"  patterns: ['(']\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: redaction.patterns has invalid pattern: \\(: .*")
+}
+
+func (s *ConfigSuite) TestFromYAMLFeatures(c *C) {
+	data := []byte("" +
+		"features:\n" +
+		"  adaptive_fetch: true\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Features, DeepEquals, map[string]bool{"adaptive_fetch": true})
+}
+
+func (s *ConfigSuite) TestFromYAMLHTTPCompression(c *C) {
+	for i, tc := range []struct {
+		compression string
+		minSize     int
+		error       string
+	}{{
+		compression: "{enabled: true}",
+		minSize:     1024,
+	}, {
+		compression: "{enabled: true, min_size: 0}",
+		minSize:     0,
+	}, {
+		compression: "{enabled: true, min_size: -1}",
+		error:       "invalid config parameter: http_compression.min_size must be >= 0",
+	}} {
+		data := []byte("" +
+			"http_compression: " + tc.compression + "\n" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		appCfg, err := FromYAML(data)
+
+		// Then
+		if tc.error != "" {
+			c.Check(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+			continue
+		}
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(appCfg.HTTPCompression.Enabled, Equals, true, Commentf("case #%d", i))
+		c.Check(appCfg.HTTPCompression.MinSize, Equals, tc.minSize, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLStandby(c *C) {
+	for i, tc := range []struct {
+		standby string
+		cluster string
+		error   string
+	}{{
+		standby: "{election: pair-1}",
+		cluster: "foo",
+	}, {
+		standby: "{election: pair-1, cluster: bar}",
+		cluster: "bar",
+	}, {
+		standby: "{election: pair-1, cluster: bazz}",
+		error:   "invalid config parameter: standby.cluster is unknown: bazz",
+	}} {
+		data := []byte("" +
+			"standby: " + tc.standby + "\n" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n" +
+			"  bar:\n" +
+			"    client_id: bar_id\n")
+
+		// When
+		appCfg, err := FromYAML(data)
+
+		// Then
+		if tc.error != "" {
+			c.Check(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+			continue
+		}
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(appCfg.Standby.Election, Equals, "pair-1", Commentf("case #%d", i))
+		c.Check(appCfg.StandbyProxy().Cluster, Equals, tc.cluster, Commentf("case #%d", i))
+	}
+}
+
+// Client IDs of all proxies get the instance name appended, and are reported
+// without duplicates.
+func (s *ConfigSuite) TestSetInstance(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n" +
+		"  bar:\n" +
+		"    client_id: bar_id\n" +
+		"  bazz:\n" +
+		"    client_id: foo_id\n")
+	appCfg, err := FromYAML(data)
+	c.Assert(err, IsNil)
+
+	// When
+	appCfg.SetInstance("blue")
+
+	// Then
+	c.Check(appCfg.Proxies["foo"].ClientID, Equals, "foo_id_blue")
+	c.Check(appCfg.ClientIDs(), DeepEquals, []string{"bar_id_blue", "foo_id_blue"})
+}
+
+func (s *ConfigSuite) TestFromYAMLClientIDTemplate(c *C) {
+	hostname, err := os.Hostname()
+	c.Assert(err, IsNil)
+	os.Setenv("POD_NAME", "web-1")
+	defer os.Unsetenv("POD_NAME")
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: pixy_{{hostname}}_{{ cluster }}_{{pod}}\n" +
+		"  bar:\n" +
+		"    client_id: pixy_{{pid}}\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].ClientID, Equals, "pixy_"+hostname+"_foo_web-1")
+	c.Check(appCfg.Proxies["bar"].ClientID, Equals, "pixy_"+strconv.Itoa(os.Getpid()))
+}
+
+func (s *ConfigSuite) TestFromYAMLClientIDTemplateInvalid(c *C) {
+	for i, tc := range []struct {
+		clientID string
+		error    string
+	}{{
+		clientID: "pixy_{{host}}",
+		error:    "client_id has unknown variable: host",
+	}, {
+		clientID: "pixy_{{cluster}",
+		error:    "client_id is malformed: pixy_{{cluster}",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: \"" + tc.clientID + "\"\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+regexp.QuoteMeta(tc.error), Commentf("case #%d", i))
+	}
+}
+
+// The default cluster must be configured.
+func (s *ConfigSuite) TestFromYAMLDefaultClusterUnknown(c *C) {
+	data := []byte("" +
+		"default_cluster: bar\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: default_cluster is unknown: bar")
+}
+
 // default.yaml contains the same configuration as returned by Default()
 func (s *ConfigSuite) TestFromYAMLFile(c *C) {
 	// When
@@ -135,3 +437,2283 @@ func (s *ConfigSuite) TestFromYAMLCustomAddresses(c *C) {
 	c.Assert(appCfg.GRPCAddr, Equals, expected.GRPCAddr)
 	c.Assert(appCfg.UnixAddr, Equals, expected.UnixAddr)
 }
+
+func (s *ConfigSuite) TestFromYAMLSTOMPDestinations(c *C) {
+	data := []byte("" +
+		"stomp_addr: 0.0.0.0:61613\n" +
+		"stomp_destinations:\n" +
+		"  /exchange/orders:\n" +
+		"    topic: orders\n" +
+		"  /queue/billing:\n" +
+		"    cluster: foo\n" +
+		"    topic: orders\n" +
+		"    group: billing\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(appCfg.STOMPAddr, Equals, "0.0.0.0:61613")
+	c.Assert(appCfg.STOMPDestinations, DeepEquals, map[string]STOMPDestination{
+		"/exchange/orders": {Topic: "orders"},
+		"/queue/billing":   {Cluster: "foo", Topic: "orders", Group: "billing"},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLSTOMPDestinationsInvalid(c *C) {
+	for i, tc := range []struct {
+		dst    string
+		errMsg string
+	}{{
+		dst:    "    group: billing\n",
+		errMsg: "invalid config parameter: stomp_destinations./queue/billing.topic must be set",
+	}, {
+		dst:    "    topic: orders\n    cluster: bar\n",
+		errMsg: "invalid config parameter: stomp_destinations./queue/billing.cluster is unknown: bar",
+	}} {
+		data := []byte("" +
+			"stomp_destinations:\n" +
+			"  /queue/billing:\n" + tc.dst +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, NotNil, Commentf("case #%d", i))
+		c.Check(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLMaxAge(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      max_age:\n" +
+		"        bar: 1h\n" +
+		"        bazz: 90s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.MaxAge, DeepEquals, map[string]time.Duration{
+		"bar":  time.Hour,
+		"bazz": 90 * time.Second,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLTenants(c *C) {
+	data := []byte("" +
+		"tenants:\n" +
+		"  acme:\n" +
+		"    prefix: acme.\n" +
+		"    api_keys: [k1, k2]\n" +
+		"  bazz:\n" +
+		"    prefix: bazz.\n" +
+		"    api_keys: [k3]\n" +
+		"admin_api_keys: [k0]\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Tenants, DeepEquals, map[string]Tenant{
+		"acme": {Prefix: "acme.", APIKeys: []string{"k1", "k2"}},
+		"bazz": {Prefix: "bazz.", APIKeys: []string{"k3"}},
+	})
+	c.Check(appCfg.AdminAPIKeys, DeepEquals, []string{"k0"})
+}
+
+func (s *ConfigSuite) TestFromYAMLTenantsInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg    string
+		errMsg string
+	}{{
+		cfg:    "tenants:\n  acme:\n    api_keys: [k1]\n",
+		errMsg: "invalid config parameter: tenants.acme.prefix must be set",
+	}, {
+		cfg:    "tenants:\n  acme:\n    prefix: acme.\n",
+		errMsg: "invalid config parameter: tenants.acme.api_keys must be set",
+	}, {
+		cfg: "tenants:\n  acme:\n    prefix: acme.\n    api_keys: [k1]\n" +
+			"  acmeeu:\n    prefix: acme.eu.\n    api_keys: [k2]\n",
+		errMsg: "invalid config parameter: tenants.acmeeu.prefix overlaps with tenants.acme.prefix",
+	}, {
+		cfg:    "mqtt_addr: 0.0.0.0:1883\ntenants:\n  acme:\n    prefix: acme.\n    api_keys: [k1]\n",
+		errMsg: "invalid config parameter: mqtt_addr cannot be used with tenants",
+	}, {
+		cfg:    "admin_api_keys: [k0, \"\"]\n",
+		errMsg: "invalid config parameter: admin_api_keys must not be empty",
+	}, {
+		cfg:    "admin_api_keys: [k0, k1]\ntenants:\n  acme:\n    prefix: acme.\n    api_keys: [k1]\n",
+		errMsg: "invalid config parameter: admin_api_keys overlap with tenants.acme.api_keys",
+	}} {
+		data := []byte(tc.cfg +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, NotNil, Commentf("case #%d", i))
+		c.Check(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLAutoProfile(c *C) {
+	data := []byte("" +
+		"auto_profile:\n" +
+		"  dir: /tmp/profiles\n" +
+		"  goroutines: 100000\n" +
+		"  max_captures: 3\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.AutoProfile, DeepEquals, AutoProfile{
+		Dir:           "/tmp/profiles",
+		CheckInterval: 10 * time.Second,
+		Goroutines:    100000,
+		CPUDuration:   10 * time.Second,
+		MinInterval:   10 * time.Minute,
+		MaxCaptures:   3,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLAutoProfileInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg    string
+		errMsg string
+	}{{
+		cfg:    "  goroutines: 0\n",
+		errMsg: "invalid config parameter: auto_profile requires at least one threshold",
+	}, {
+		cfg:    "  heap_size: -1\n",
+		errMsg: "invalid config parameter: auto_profile.heap_size must be >= 0",
+	}, {
+		cfg:    "  goroutines: 10\n  check_interval: 0s\n",
+		errMsg: "invalid config parameter: auto_profile.check_interval must be > 0",
+	}, {
+		cfg:    "  consume_latency: 1s\n  cpu_duration: 0s\n",
+		errMsg: "invalid config parameter: auto_profile.cpu_duration must be > 0",
+	}, {
+		cfg:    "  consume_latency: 1s\n  max_captures: 0\n",
+		errMsg: "invalid config parameter: auto_profile.max_captures must be > 0",
+	}} {
+		data := []byte("auto_profile:\n  dir: /tmp/profiles\n" + tc.cfg +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, NotNil, Commentf("case #%d", i))
+		c.Check(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLHooks(c *C) {
+	data := []byte("" +
+		"hooks:\n" +
+		"  consul:\n" +
+		"    events: [started, drain_started]\n" +
+		"    command: [/usr/local/bin/register, --service, kafka-pixy]\n" +
+		"  pager:\n" +
+		"    events: [shutdown_complete]\n" +
+		"    url: http://pager.local/hooks\n" +
+		"    timeout: 3s\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Hooks, DeepEquals, map[string]Hook{
+		"consul": {
+			Events:  []string{HookStarted, HookDrainStarted},
+			Command: []string{"/usr/local/bin/register", "--service", "kafka-pixy"},
+		},
+		"pager": {
+			Events:  []string{HookShutdownComplete},
+			URL:     "http://pager.local/hooks",
+			Timeout: 3 * time.Second,
+		},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLHooksInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg    string
+		errMsg string
+	}{{
+		cfg:    "    url: http://pager.local/hooks\n",
+		errMsg: "invalid config parameter: hooks.pager: events must be set",
+	}, {
+		cfg:    "    events: [started, rebooted]\n    url: http://pager.local/hooks\n",
+		errMsg: "invalid config parameter: hooks.pager: unknown event: rebooted",
+	}, {
+		cfg:    "    events: [started]\n",
+		errMsg: "invalid config parameter: hooks.pager: exactly one of command and url must be set",
+	}, {
+		cfg:    "    events: [started]\n    url: http://pager.local/hooks\n    command: [page]\n",
+		errMsg: "invalid config parameter: hooks.pager: exactly one of command and url must be set",
+	}, {
+		cfg:    "    events: [started]\n    url: http://pager.local/hooks\n    timeout: -1s\n",
+		errMsg: "invalid config parameter: hooks.pager: timeout must be >= 0",
+	}} {
+		data := []byte("hooks:\n  pager:\n" + tc.cfg +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Assert(err, NotNil, Commentf("case #%d", i))
+		c.Check(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+// Global maintenance windows are added to windows of the clusters they
+// apply to.
+func (s *ConfigSuite) TestFromYAMLMaintenanceWindows(c *C) {
+	data := []byte("" +
+		"maintenance_windows:\n" +
+		"  backup:\n" +
+		"    start: 2026-11-01T02:00:00Z\n" +
+		"    end: 2026-11-01T03:00:00Z\n" +
+		"    every: 24h\n" +
+		"    groups: [reports]\n" +
+		"  migration:\n" +
+		"    start: 2026-11-07T02:00:00Z\n" +
+		"    end: 2026-11-07T06:00:00Z\n" +
+		"    mode: read_only\n" +
+		"    clusters: [bar]\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    maintenance_windows:\n" +
+		"      upgrade:\n" +
+		"        start: 2026-11-14T02:00:00Z\n" +
+		"        end: 2026-11-14T02:30:00Z\n" +
+		"  bar:\n" +
+		"    client_id: bar_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	backup := MaintenanceWindow{
+		Start:  time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC),
+		End:    time.Date(2026, 11, 1, 3, 0, 0, 0, time.UTC),
+		Every:  24 * time.Hour,
+		Groups: []string{"reports"},
+	}
+	c.Check(appCfg.Proxies["foo"].MaintenanceWindows, DeepEquals, map[string]MaintenanceWindow{
+		"backup": backup,
+		"upgrade": {
+			Start: time.Date(2026, 11, 14, 2, 0, 0, 0, time.UTC),
+			End:   time.Date(2026, 11, 14, 2, 30, 0, 0, time.UTC),
+		},
+	})
+	c.Check(appCfg.Proxies["bar"].MaintenanceWindows, DeepEquals, map[string]MaintenanceWindow{
+		"backup": backup,
+		"migration": {
+			Start:    time.Date(2026, 11, 7, 2, 0, 0, 0, time.UTC),
+			End:      time.Date(2026, 11, 7, 6, 0, 0, 0, time.UTC),
+			Mode:     MaintenanceReadOnly,
+			Clusters: []string{"bar"},
+		},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLMaintenanceWindowsInvalid(c *C) {
+	for i, tc := range []struct {
+		global  string
+		cluster string
+		errMsg  string
+	}{{
+		global: "{end: 2026-11-01T03:00:00Z}",
+		errMsg: "invalid config parameter: maintenance_windows.w1: start must be set",
+	}, {
+		global: "{start: 2026-11-01T03:00:00Z, end: 2026-11-01T03:00:00Z}",
+		errMsg: "invalid config parameter: maintenance_windows.w1: end must be after start",
+	}, {
+		global: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z, every: 30m}",
+		errMsg: "invalid config parameter: maintenance_windows.w1: every must be >= end - start",
+	}, {
+		global: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z, mode: drain}",
+		errMsg: "invalid config parameter: maintenance_windows.w1: mode is invalid: drain",
+	}, {
+		global: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z, mode: read_only, groups: [g1]}",
+		errMsg: "invalid config parameter: maintenance_windows.w1: groups are only allowed in the pause mode",
+	}, {
+		global: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z, clusters: [bazz]}",
+		errMsg: "invalid config parameter: maintenance_windows.w1.clusters has unknown cluster: bazz",
+	}, {
+		global:  "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z}",
+		cluster: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z}",
+		errMsg:  "invalid config parameter: maintenance_windows.w1 clashes with a window of cluster foo",
+	}, {
+		cluster: "{start: 2026-11-01T02:00:00Z, end: 2026-11-01T03:00:00Z, clusters: [foo]}",
+		errMsg:  "invalid config parameter: invalid config, cluster=foo: maintenance_windows.w1.clusters is only allowed in global windows",
+	}, {
+		cluster: "{start: 2026-11-01T02:00:00Z}",
+		errMsg:  "invalid config parameter: invalid config, cluster=foo: maintenance_windows.w1: end must be after start",
+	}} {
+		data := "proxies:\n  foo:\n    client_id: foo_id\n"
+		if tc.cluster != "" {
+			data += "    maintenance_windows:\n      w1: " + tc.cluster + "\n"
+		}
+		if tc.global != "" {
+			data += "maintenance_windows:\n  w1: " + tc.global + "\n"
+		}
+
+		// When
+		_, err := FromYAML([]byte(data))
+
+		// Then
+		c.Assert(err, NotNil, Commentf("case #%d", i))
+		c.Check(err.Error(), Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLReadOnly(c *C) {
+	data := []byte("" +
+		"read_only_listeners: [tcp, mqtt]\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    read_only: true\n" +
+		"  bar:\n" +
+		"    client_id: bar_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.IsReadOnly(ListenerTCP), Equals, true)
+	c.Check(appCfg.IsReadOnly(ListenerMQTT), Equals, true)
+	c.Check(appCfg.IsReadOnly(ListenerGRPC), Equals, false)
+	c.Check(appCfg.Proxies["foo"].ReadOnly, Equals, true)
+	c.Check(appCfg.Proxies["bar"].ReadOnly, Equals, false)
+}
+
+func (s *ConfigSuite) TestFromYAMLReadOnlyInvalid(c *C) {
+	data := []byte("" +
+		"read_only_listeners: [http]\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: read_only_listeners has unknown listener: http")
+}
+
+func (s *ConfigSuite) TestFromYAMLListenerEndpoints(c *C) {
+	data := []byte("" +
+		"listener_endpoints:\n" +
+		"  tcp: [produce, consume]\n" +
+		"  grpc: []\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.ListenerEndpoints, DeepEquals, map[string][]string{
+		ListenerTCP:  {EndpointsProduce, EndpointsConsume},
+		ListenerGRPC: {},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLListenerEndpointsInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{{
+		cfg:   "{mqtt: [produce]}",
+		error: "listener_endpoints has unsupported listener: mqtt",
+	}, {
+		cfg:   "{unix: [produce, metrics]}",
+		error: "listener_endpoints.unix has unknown endpoint group: metrics",
+	}} {
+		data := []byte("" +
+			"listener_endpoints: " + tc.cfg + "\n" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLListenerPools(c *C) {
+	data := []byte("" +
+		"listener_pools:\n" +
+		"  produce:\n" +
+		"    concurrency: 100\n" +
+		"  consume:\n" +
+		"    concurrency: 500\n" +
+		"    queue_size: 50\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.ListenerPools.Limits(), DeepEquals, map[string]Limit{
+		EndpointsProduce: {Concurrency: 100},
+		EndpointsConsume: {Concurrency: 500, QueueSize: 50},
+		EndpointsOffsets: {},
+		EndpointsAdmin:   {},
+	})
+	c.Check(appCfg.ListenerPools.QueueTimeout, Equals, 3*time.Second)
+}
+
+func (s *ConfigSuite) TestFromYAMLListenerPoolsInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{{
+		cfg:   "{admin: {concurrency: -1}}",
+		error: "listener_pools.admin.concurrency must be >= 0",
+	}, {
+		cfg:   "{offsets: {concurrency: 1, queue_size: -1}}",
+		error: "listener_pools.offsets.queue_size must be >= 0",
+	}, {
+		cfg:   "{queue_timeout: 0s}",
+		error: "listener_pools.queue_timeout must be > 0",
+	}} {
+		data := []byte("" +
+			"listener_pools: " + tc.cfg + "\n" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicLists(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    producer:\n" +
+		"      topic_whitelist: [orders, 'events\\.[a-z]+']\n" +
+		"      topic_blacklist: ['__.*']\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Producer.TopicWhitelist, DeepEquals, []string{"orders", "events\\.[a-z]+"})
+	c.Check(appCfg.Proxies["foo"].Producer.TopicBlacklist, DeepEquals, []string{"__.*"})
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicListsInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    producer:\n" +
+		"      topic_blacklist: ['events[']\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+
+		"producer.topic_blacklist has invalid pattern: events\\[: .*")
+}
+
+func (s *ConfigSuite) TestFromYAMLCallbackInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{
+		{cfg: "callback_timeout: 0s", error: "producer.callback_timeout must be > 0"},
+		{cfg: "callback_url_whitelist: ['http://[']", error: "producer.callback_url_whitelist has invalid pattern: http://\\[: .*"},
+	} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    producer:\n" +
+			"      " + tc.cfg + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLDeadLetterInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{
+		{
+			cfg:   "{spool_dir: /tmp, topic: dead}",
+			error: "producer.dead_letter.spool_dir and producer.dead_letter.topic are mutually exclusive",
+		}, {
+			cfg:   "{cluster: bar}",
+			error: "producer.dead_letter.cluster requires producer.dead_letter.topic",
+		}, {
+			cfg:   "{cluster: bazz, topic: dead}",
+			error: "producer.dead_letter.cluster is unknown: bazz",
+		}, {
+			cfg:   "{cluster: bar, topic: dead}",
+			error: "producer.dead_letter.topic requires kafka.version >= 0.11.0.0",
+		},
+	} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    producer:\n" +
+			"      dead_letter: " + tc.cfg + "\n" +
+			"  bar:\n" +
+			"    kafka:\n" +
+			"      version: 0.10.2.1\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Dead letters are produced to the cluster they failed to be produced to,
+// unless another one is configured.
+func (s *ConfigSuite) TestDeadLetterProxy(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    producer:\n" +
+		"      dead_letter: {cluster: bar, topic: dead}\n" +
+		"  bar:\n" +
+		"    kafka:\n" +
+		"      version: 1.0.0\n" +
+		"    producer:\n" +
+		"      dead_letter: {topic: dead}\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.DeadLetterProxy("foo"), Equals, appCfg.Proxies["bar"])
+	c.Check(appCfg.DeadLetterProxy("bar"), Equals, appCfg.Proxies["bar"])
+	c.Check(appCfg.DeadLetterProxy("bazz"), IsNil)
+}
+
+func (s *ConfigSuite) TestFromYAMLShadowsInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{
+		{
+			cfg:   "{topic: copy}",
+			error: "shadows.orig.percent must be between 1 and 100",
+		}, {
+			cfg:   "{topic: copy, percent: 101}",
+			error: "shadows.orig.percent must be between 1 and 100",
+		}, {
+			cfg:   "{percent: 10}",
+			error: "shadows.orig must name another topic or cluster",
+		}, {
+			cfg:   "{topic: orig, percent: 10}",
+			error: "shadows.orig must name another topic or cluster",
+		}, {
+			cfg:   "{cluster: bazz, percent: 10}",
+			error: "shadows.orig.cluster is unknown: bazz",
+		}, {
+			cfg:   "{cluster: bar, percent: 10}",
+			error: "shadows.orig requires kafka.version >= 0.11.0.0",
+		},
+	} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    shadows:\n" +
+			"      orig: " + tc.cfg + "\n" +
+			"  bar:\n" +
+			"    kafka:\n" +
+			"      version: 0.10.2.1\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Copies are produced to the cluster of the original topic, unless another
+// one is configured.
+func (s *ConfigSuite) TestShadowProxies(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 1.0.0\n" +
+		"    shadows:\n" +
+		"      orig1: {cluster: bar, percent: 10}\n" +
+		"      orig2: {topic: copy, percent: 100}\n" +
+		"  bar:\n" +
+		"    kafka:\n" +
+		"      version: 1.0.0\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.ShadowProxies("foo"), DeepEquals, map[string]*Proxy{
+		"orig1": appCfg.Proxies["bar"],
+		"orig2": appCfg.Proxies["foo"],
+	})
+	c.Check(appCfg.ShadowProxies("bar"), DeepEquals, map[string]*Proxy{})
+	c.Check(appCfg.ShadowProxies("bazz"), IsNil)
+}
+
+// Topic patterns match entire topic names.
+func (s *ConfigSuite) TestCompileTopicPattern(c *C) {
+	re, err := CompileTopicPattern("events|orders\\.[0-9]+")
+	c.Assert(err, IsNil)
+
+	c.Check(re.MatchString("events"), Equals, true)
+	c.Check(re.MatchString("orders.1"), Equals, true)
+	c.Check(re.MatchString("my-events"), Equals, false)
+	c.Check(re.MatchString("orders.1x"), Equals, false)
+}
+
+func (s *ConfigSuite) TestFromYAMLMaxAgeInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      max_age:\n" +
+		"        bar: 0s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: consumer.max_age.bar must be > 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLCatchUp(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      catch_up:\n" +
+		"        bar:\n" +
+		"          min_lag: 1000\n" +
+		"          max_keys: 500\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.CatchUp, DeepEquals, map[string]CatchUp{"bar": {MinLag: 1000, MaxKeys: 500}})
+}
+
+func (s *ConfigSuite) TestFromYAMLHealthProbes(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      health_probes:\n" +
+		"        bar:\n" +
+		"          url: http://bar.local/health\n" +
+		"          interval: 10s\n" +
+		"          failure_threshold: 5\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.HealthProbes, DeepEquals, map[string]HealthProbe{
+		"bar": {URL: "http://bar.local/health", Interval: 10 * time.Second, FailureThreshold: 5},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLHealthProbesInvalid(c *C) {
+	for i, tc := range []struct {
+		probe string
+		error string
+	}{{
+		probe: "{interval: 1s}",
+		error: "consumer.health_probes.bar.url must be set",
+	}, {
+		probe: "{url: http://bar.local/health, interval: -1s}",
+		error: "consumer.health_probes.bar.interval must be >= 0",
+	}, {
+		probe: "{url: http://bar.local/health, timeout: -1s}",
+		error: "consumer.health_probes.bar.timeout must be >= 0",
+	}, {
+		probe: "{url: http://bar.local/health, success_threshold: -1}",
+		error: "consumer.health_probes.bar thresholds must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    consumer:\n" +
+			"      health_probes:\n" +
+			"        bar: " + tc.probe + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLDedup(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      dedup:\n" +
+		"        bar:\n" +
+		"          header: Message-Id\n" +
+		"          window: 10m\n" +
+		"          max_keys: 1000\n" +
+		"        bazz:\n" +
+		"          window: 1s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.Dedup, DeepEquals, map[string]Dedup{
+		"bar":  {Header: "Message-Id", Window: 10 * time.Minute, MaxKeys: 1000},
+		"bazz": {Window: time.Second},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLDedupInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      dedup:\n" +
+		"        bar:\n" +
+		"          header: Message-Id\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: consumer.dedup.bar.window must be > 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLChunkSizeInvalid(c *C) {
+	for i, tc := range []struct {
+		version   string
+		chunkSize string
+		error     string
+	}{{
+		version:   "0.11.0.0",
+		chunkSize: "1000000",
+		error:     "producer.chunk_size must be < producer.max_message_bytes",
+	}, {
+		version:   "0.10.2.1",
+		chunkSize: "1000",
+		error:     "producer.chunk_size requires kafka.version >= 0.11.0.0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    kafka:\n" +
+			"      version: " + tc.version + "\n" +
+			"    producer:\n" +
+			"      chunk_size: " + tc.chunkSize + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLEnrichment(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.11.0.0\n" +
+		"    producer:\n" +
+		"      enrichment:\n" +
+		"        headers:\n" +
+		"          env: prod\n" +
+		"        topics:\n" +
+		"          orders:\n" +
+		"            schema-id: \"42\"\n" +
+		"        producer_header: producer\n" +
+		"        override: true\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	enrichCfg := appCfg.Proxies["foo"].Producer.Enrichment
+	c.Check(enrichCfg.Headers, DeepEquals, map[string]string{"env": "prod"})
+	c.Check(enrichCfg.Topics, DeepEquals, map[string]map[string]string{"orders": {"schema-id": "42"}})
+	c.Check(enrichCfg.ProducerHeader, Equals, "producer")
+	c.Check(enrichCfg.Override, Equals, true)
+}
+
+func (s *ConfigSuite) TestFromYAMLEnrichmentInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.10.2.1\n" +
+		"    producer:\n" +
+		"      enrichment:\n" +
+		"        producer_header: producer\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: producer.enrichment requires kafka.version >= 0.11.0.0")
+}
+
+func (s *ConfigSuite) TestFromYAMLRequestIDHeaderInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.10.2.1\n" +
+		"    producer:\n" +
+		"      request_id_header: X-Request-ID\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: producer.request_id_header requires kafka.version >= 0.11.0.0")
+}
+
+func (s *ConfigSuite) TestFromYAMLTraceContextInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.10.2.1\n" +
+		"    trace_context:\n" +
+		"      enabled: true\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: trace_context requires kafka.version >= 0.11.0.0")
+}
+
+func (s *ConfigSuite) TestFromYAMLCheckMaxMessageBytesInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.10.2.1\n" +
+		"    producer:\n" +
+		"      check_max_message_bytes: true\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: producer.check_max_message_bytes requires kafka.version >= 0.11.0.0")
+}
+
+func (s *ConfigSuite) TestFromYAMLCreateMissingTopicsInvalid(c *C) {
+	for i, tc := range []struct {
+		version  string
+		producer string
+		error    string
+	}{{
+		version:  "0.10.0.0",
+		producer: "create_missing_topics: true",
+		error:    "producer.create_missing_topics requires kafka.version >= 0.10.1.0",
+	}, {
+		version:  "0.11.0.0",
+		producer: "new_topic_partitions: 0",
+		error:    "producer.new_topic_partitions must be > 0",
+	}, {
+		version:  "0.11.0.0",
+		producer: "new_topic_replication_factor: -1",
+		error:    "producer.new_topic_replication_factor must be > 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    kafka:\n" +
+			"      version: " + tc.version + "\n" +
+			"    producer:\n" +
+			"      " + tc.producer + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicDefaults(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    producer:\n" +
+		"      new_topic_replication_factor: 2\n" +
+		"    topic_defaults:\n" +
+		"      partitions: 12\n" +
+		"      config:\n" +
+		"        retention.ms: 86400000\n" +
+		"        cleanup.policy: compact\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	retention, cleanup := "86400000", "compact"
+	c.Check(appCfg.Proxies["foo"].NewTopicDetail(), DeepEquals, &sarama.TopicDetail{
+		NumPartitions:     12,
+		ReplicationFactor: 2,
+		ConfigEntries: map[string]*string{
+			"retention.ms":   &retention,
+			"cleanup.policy": &cleanup,
+		},
+	})
+}
+
+// Without topic defaults topics are created with the producer settings.
+func (s *ConfigSuite) TestNewTopicDetailDefault(c *C) {
+	proxyCfg := DefaultProxy()
+
+	// When
+	detail := proxyCfg.NewTopicDetail()
+
+	// Then
+	c.Check(detail, DeepEquals, &sarama.TopicDetail{NumPartitions: 1, ReplicationFactor: 1})
+}
+
+func (s *ConfigSuite) TestFromYAMLTopicDefaultsInvalid(c *C) {
+	for i, tc := range []struct {
+		defaults string
+		error    string
+	}{{
+		defaults: "{partitions: -1}",
+		error:    "topic_defaults.partitions must be >= 0",
+	}, {
+		defaults: "{replication_factor: -1}",
+		error:    "topic_defaults.replication_factor must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    topic_defaults: " + tc.defaults + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLGroupJanitor(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 1.1.0\n" +
+		"    group_janitor:\n" +
+		"      retention: 168h\n" +
+		"      dry_run: true\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].GroupJanitor, DeepEquals, GroupJanitor{
+		Retention:     168 * time.Hour,
+		CheckInterval: time.Hour,
+		DryRun:        true,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLGroupJanitorInvalid(c *C) {
+	for i, tc := range []struct {
+		version string
+		janitor string
+		error   string
+	}{{
+		version: "1.1.0",
+		janitor: "retention: -1s",
+		error:   "group_janitor.retention must be >= 0",
+	}, {
+		version: "1.1.0",
+		janitor: "check_interval: 0s",
+		error:   "group_janitor.check_interval must be > 0",
+	}, {
+		version: "1.0.0",
+		janitor: "retention: 1h",
+		error:   "group_janitor.retention requires kafka.version >= 1.1.0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    kafka:\n" +
+			"      version: " + tc.version + "\n" +
+			"    group_janitor:\n" +
+			"      " + tc.janitor + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLLegacyGroups(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    legacy_groups:\n" +
+		"      migrate: true\n" +
+		"      dry_run: true\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].LegacyGroups, DeepEquals, LegacyGroups{
+		Migrate:       true,
+		RetryInterval: time.Minute,
+		DryRun:        true,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLLegacyGroupsInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    legacy_groups:\n" +
+		"      migrate: true\n" +
+		"      retry_interval: 0s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: legacy_groups.retry_interval must be > 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLLagSLO(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    lag_slo:\n" +
+		"      groups:\n" +
+		"        g1:\n" +
+		"          topics: [t1, t2]\n" +
+		"          max_lag_messages: 1000\n" +
+		"          max_lag_time: 5m\n" +
+		"          objective: 0.999\n" +
+		"          long_window: 6h\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].LagSLO, DeepEquals, LagSLOs{
+		CheckInterval: 30 * time.Second,
+		Groups: map[string]LagSLO{"g1": {
+			Topics:         []string{"t1", "t2"},
+			MaxLagMessages: 1000,
+			MaxLagTime:     5 * time.Minute,
+			Objective:      0.999,
+			LongWindow:     6 * time.Hour,
+		}},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLLagSLOInvalid(c *C) {
+	for i, tc := range []struct {
+		slo   string
+		error string
+	}{{
+		slo:   "check_interval: 0s",
+		error: "lag_slo.check_interval must be > 0",
+	}, {
+		slo:   "groups: {g1: {max_lag_messages: 10}}",
+		error: "lag_slo.groups.g1.topics must not be empty",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_messages: -1}}",
+		error: "lag_slo.groups.g1.max_lag_messages must be >= 0",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_time: -1s}}",
+		error: "lag_slo.groups.g1.max_lag_time must be >= 0",
+	}, {
+		slo:   "groups: {g1: {topics: [t1]}}",
+		error: "lag_slo.groups.g1 needs max_lag_messages or max_lag_time",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_messages: 10, objective: 1}}",
+		error: "lag_slo.groups.g1.objective must be in \\[0, 1\\)",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_messages: 10, short_window: -1s}}",
+		error: "lag_slo.groups.g1 windows must be >= 0",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_messages: 10, short_window: 2h, long_window: 1h}}",
+		error: "lag_slo.groups.g1.short_window must be <= long_window",
+	}, {
+		slo:   "groups: {g1: {topics: [t1], max_lag_messages: 10, burn_rate_threshold: -1}}",
+		error: "lag_slo.groups.g1.burn_rate_threshold must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    lag_slo:\n" +
+			"      " + tc.slo + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLProduceSLO(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    produce_slo:\n" +
+		"      topics:\n" +
+		"        t1:\n" +
+		"          max_latency: 100ms\n" +
+		"          percentile: 99.9\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].ProduceSLO, DeepEquals, ProduceSLOs{
+		Topics: map[string]ProduceSLO{"t1": {
+			MaxLatency: 100 * time.Millisecond,
+			Percentile: 99.9,
+		}},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLProduceSLOInvalid(c *C) {
+	for i, tc := range []struct {
+		slo   string
+		error string
+	}{{
+		slo:   "topics: {t1: {percentile: 99}}",
+		error: "produce_slo.topics.t1.max_latency must be > 0",
+	}, {
+		slo:   "topics: {t1: {max_latency: 100ms, percentile: 100}}",
+		error: "produce_slo.topics.t1.percentile must be in \\[0, 100\\)",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    produce_slo:\n" +
+			"      " + tc.slo + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLValueCodecs(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    value_codecs:\n" +
+		"      t1: msgpack\n" +
+		"      t2: cbor\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].ValueCodecs, DeepEquals, map[string]string{"t1": "msgpack", "t2": "cbor"})
+}
+
+func (s *ConfigSuite) TestFromYAMLValueCodecsInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    value_codecs:\n" +
+		"      t1: avro\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: value_codecs.t1 is unknown: avro")
+}
+
+func (s *ConfigSuite) TestFromYAMLPrefetchCountInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      prefetch_count: -1\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: consumer.prefetch_count must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLMaxConnectionsInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    net:\n" +
+		"      max_connections: -1\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: net.max_connections must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLSecurity(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      tls:\n" +
+		"        enabled: true\n" +
+		"        ca_path: /etc/kafka-pixy/ca.crt\n" +
+		"        certificate_path: /etc/kafka-pixy/client.crt\n" +
+		"        key_path: /etc/kafka-pixy/client.key\n" +
+		"      sasl:\n" +
+		"        enabled: true\n" +
+		"        user: alice\n" +
+		"        password_path: /etc/kafka-pixy/password\n" +
+		"    zoo_keeper:\n" +
+		"      tls:\n" +
+		"        enabled: true\n" +
+		"        server_name: zk.example.com\n" +
+		"    net:\n" +
+		"      credentials_check_interval: 1m\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	proxyCfg := appCfg.Proxies["foo"]
+	c.Check(proxyCfg.Kafka.TLS, DeepEquals, ClientTLS{
+		Enabled:  true,
+		CAPath:   "/etc/kafka-pixy/ca.crt",
+		CertPath: "/etc/kafka-pixy/client.crt",
+		KeyPath:  "/etc/kafka-pixy/client.key",
+	})
+	c.Check(proxyCfg.Kafka.SASL.Enabled, Equals, true)
+	c.Check(proxyCfg.Kafka.SASL.User, Equals, "alice")
+	c.Check(proxyCfg.Kafka.SASL.PasswordPath, Equals, "/etc/kafka-pixy/password")
+	c.Check(proxyCfg.ZooKeeper.TLS, DeepEquals, ClientTLS{Enabled: true, ServerName: "zk.example.com"})
+	c.Check(proxyCfg.Net.CredentialsCheckInterval, Equals, time.Minute)
+}
+
+func (s *ConfigSuite) TestFromYAMLSecurityInvalid(c *C) {
+	for i, tc := range []struct {
+		section string
+		error   string
+	}{{
+		section: "kafka:\n  tls:\n    certificate_path: /etc/kafka-pixy/client.crt\n",
+		error:   "kafka.tls.certificate_path and kafka.tls.key_path must be set together",
+	}, {
+		section: "zoo_keeper:\n  tls:\n    key_path: /etc/kafka-pixy/client.key\n",
+		error:   "zoo_keeper.tls.certificate_path and zoo_keeper.tls.key_path must be set together",
+	}, {
+		section: "kafka:\n  sasl:\n    enabled: true\n    password: secret\n",
+		error:   "kafka.sasl.user must be set",
+	}, {
+		section: "kafka:\n  sasl:\n    enabled: true\n    user: alice\n",
+		error:   "either kafka.sasl.password or kafka.sasl.password_path must be set",
+	}, {
+		section: "net:\n  credentials_check_interval: -1s\n",
+		error:   "net.credentials_check_interval must be >= 0",
+	}} {
+		data := "" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    " + strings.Replace(strings.TrimSuffix(tc.section, "\n"), "\n", "\n    ", -1) + "\n"
+
+		// When
+		_, err := FromYAML([]byte(data))
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLAckTimeoutCeilingInvalid(c *C) {
+	for i, tc := range []struct {
+		ceiling string
+		error   string
+	}{{
+		ceiling: "-1s",
+		error:   "consumer.ack_timeout_ceiling must be >= 0",
+	}, {
+		ceiling: "1m",
+		error:   "consumer.ack_timeout_ceiling must be >= consumer.ack_timeout",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    consumer:\n" +
+			"      ack_timeout_ceiling: " + tc.ceiling + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLSecondaryZooKeeperInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    zoo_keeper:\n" +
+		"      secondary:\n" +
+		"        seed_peers: [zk-new1:2181]\n" +
+		"        failover_after: 0s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+
+		"zoo_keeper.secondary.failover_after must be > 0")
+}
+
+func (s *ConfigSuite) TestMaxAckTimeout(c *C) {
+	cfg := DefaultProxy()
+	c.Check(cfg.MaxAckTimeout(), Equals, 300*time.Second)
+	cfg.Consumer.AckTimeoutCeiling = time.Hour
+	c.Check(cfg.MaxAckTimeout(), Equals, time.Hour)
+}
+
+func (s *ConfigSuite) TestFromYAMLFetchMaxBytesCeilingInvalid(c *C) {
+	for i, tc := range []struct {
+		ceiling string
+		error   string
+	}{{
+		ceiling: "-1",
+		error:   "consumer.fetch_max_bytes_ceiling must be >= 0",
+	}, {
+		ceiling: "1024",
+		error:   "consumer.fetch_max_bytes_ceiling must be >= consumer.fetch_max_bytes",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    consumer:\n" +
+			"      fetch_max_bytes_ceiling: " + tc.ceiling + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLRetryInvalid(c *C) {
+	for i, tc := range []struct {
+		param string
+		error string
+	}{{
+		param: "retry_backoff_max: 500ms",
+		error: "producer.retry_backoff_max must be >= producer.retry_backoff",
+	}, {
+		param: "retry_jitter: -0.1",
+		error: "producer.retry_jitter must be >= 0 and < 1",
+	}, {
+		param: "retry_jitter: 1",
+		error: "producer.retry_jitter must be >= 0 and < 1",
+	}, {
+		param: "retry_budget: -1s",
+		error: "producer.retry_budget must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    producer:\n" +
+			"      " + tc.param + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Retry backoffs grow exponentially and the number of retries is limited by
+// the budget.
+func (s *ConfigSuite) TestSaramaProducerCfgRetry(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    producer:\n" +
+		"      retry_backoff: 100ms\n" +
+		"      retry_backoff_max: 1s\n" +
+		"      retry_jitter: 0\n" +
+		"      retry_max: 10\n" +
+		"      retry_budget: 2s\n")
+	appCfg, err := FromYAML(data)
+	c.Assert(err, IsNil)
+
+	// When
+	saramaCfg := appCfg.Proxies["foo"].SaramaProducerCfg()
+
+	// Then
+	c.Check(saramaCfg.Producer.Retry.BackoffFunc(1, 10), Equals, 100*time.Millisecond)
+	c.Check(saramaCfg.Producer.Retry.BackoffFunc(4, 10), Equals, 800*time.Millisecond)
+	c.Check(saramaCfg.Producer.Retry.BackoffFunc(5, 10), Equals, time.Second)
+	// 100ms + 200ms + 400ms + 800ms + 1s > 2s
+	c.Check(saramaCfg.Producer.Retry.Max, Equals, 4)
+}
+
+func (s *ConfigSuite) TestFromYAMLMuxPolicy(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      mux_policy:\n" +
+		"        bar: round_robin\n" +
+		"        bazz: weighted\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.MuxPolicy, DeepEquals, map[string]string{
+		"bar":  MuxPolicyRoundRobin,
+		"bazz": MuxPolicyWeighted,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLMuxPolicyInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      mux_policy:\n" +
+		"        bar: fifo\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: consumer.mux_policy.bar is invalid: fifo")
+}
+
+func (s *ConfigSuite) TestFromYAMLPartitionPins(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      partition_pins:\n" +
+		"        bar:\n" +
+		"          bazz:\n" +
+		"            big-host-1: [0, 3]\n" +
+		"            big-host-2: [5]\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.PartitionPins, DeepEquals, map[string]map[string]map[string][]int32{
+		"bar": {"bazz": {"big-host-1": {0, 3}, "big-host-2": {5}}},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLPartitionPinsInvalid(c *C) {
+	for i, tc := range []struct {
+		pins string
+		err  string
+	}{{
+		pins: "            big-host-1: [-1]\n",
+		err:  "consumer.partition_pins.bar.bazz.big-host-1 has invalid partition: -1",
+	}, {
+		pins: "            big-host-1: [0, 3]\n" +
+			"            big-host-2: [3]\n",
+		err: "consumer.partition_pins.bar.bazz has partition 3 pinned to more than one member",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    consumer:\n" +
+			"      partition_pins:\n" +
+			"        bar:\n" +
+			"          bazz:\n" + tc.pins)
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.err, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLMetadataCacheTTL(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      metadata_cache_ttl: 30s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Kafka.MetadataCacheTTL, Equals, 30*time.Second)
+}
+
+func (s *ConfigSuite) TestFromYAMLMetadataCacheTTLInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      metadata_cache_ttl: -1s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: kafka.metadata_cache_ttl must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLTopologyCheckIntervalInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      topology_check_interval: -1s\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: kafka.topology_check_interval must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLOffsetStorage(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    offset_storage:\n" +
+		"      backend: redis\n" +
+		"      addrs:\n" +
+		"        - redis1:6379\n" +
+		"        - redis2:6379\n" +
+		"      password: secret\n" +
+		"      db: 3\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].OffsetStorage, DeepEquals, OffsetStorage{
+		Backend:   OffsetStorageRedis,
+		Addrs:     []string{"redis1:6379", "redis2:6379"},
+		KeyPrefix: "kafka-pixy/offsets/",
+		Password:  "secret",
+		DB:        3,
+		Timeout:   5 * time.Second,
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLOffsetStorageInvalid(c *C) {
+	for i, tc := range []struct {
+		storage string
+		janitor string
+		error   string
+	}{{
+		storage: "{backend: zookeeper}",
+		error:   "offset_storage.backend is invalid: zookeeper",
+	}, {
+		storage: "{backend: etcd}",
+		error:   "offset_storage.addrs must have at least one entry",
+	}, {
+		storage: "{backend: redis, addrs: [redis:6379], db: -1}",
+		error:   "offset_storage.db must be >= 0",
+	}, {
+		storage: "{backend: etcd, addrs: [http://etcd:2379], timeout: 0s}",
+		error:   "offset_storage.timeout must be > 0",
+	}, {
+		storage: "{backend: etcd, addrs: [http://etcd:2379]}",
+		janitor: "retention: 1h",
+		error:   "group_janitor.retention requires offset_storage.backend kafka",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    kafka:\n" +
+			"      version: 1.1.0\n" +
+			"    group_janitor: {" + tc.janitor + "}\n" +
+			"    offset_storage: " + tc.storage + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLClaimCheck(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.11.0.0\n" +
+		"    claim_check:\n" +
+		"      bar:\n" +
+		"        threshold: 1048576\n" +
+		"        endpoint: http://minio:9000\n" +
+		"        bucket: blobs\n" +
+		"        prefix: bar/\n" +
+		"        access_key_id: AKID\n" +
+		"        secret_access_key: secret\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].ClaimCheck, DeepEquals, map[string]ClaimCheck{
+		"bar": {
+			Threshold:       1048576,
+			Endpoint:        "http://minio:9000",
+			Bucket:          "blobs",
+			Prefix:          "bar/",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+		},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLClaimCheckInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.11.0.0\n" +
+		"    claim_check:\n" +
+		"      bar:\n" +
+		"        threshold: 1048576\n" +
+		"        endpoint: http://minio:9000\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: claim_check.bar.bucket must be set")
+}
+
+func (s *ConfigSuite) TestFromYAMLProduceValidation(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    produce_validation:\n" +
+		"      bar:\n" +
+		"        url: http://validator/bar\n" +
+		"        timeout: 2s\n" +
+		"        failure_policy: fail_open\n" +
+		"        cache_ttl: 1m\n" +
+		"        cache_size: 1000\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].ProduceValidation, DeepEquals, map[string]ProduceValidation{
+		"bar": {
+			URL:           "http://validator/bar",
+			Timeout:       2 * time.Second,
+			FailurePolicy: FailOpen,
+			CacheTTL:      time.Minute,
+			CacheSize:     1000,
+		},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLProduceValidationInvalid(c *C) {
+	for i, tc := range []struct {
+		cfg   string
+		error string
+	}{{
+		cfg:   "{timeout: 2s}",
+		error: "produce_validation.bar.url must be set",
+	}, {
+		cfg:   "{url: http://validator, timeout: -1s}",
+		error: "produce_validation.bar.timeout must be >= 0",
+	}, {
+		cfg:   "{url: http://validator, failure_policy: ignore}",
+		error: "produce_validation.bar.failure_policy is invalid: ignore",
+	}, {
+		cfg:   "{url: http://validator, cache_ttl: -1m}",
+		error: "produce_validation.bar.cache_ttl must be >= 0",
+	}, {
+		cfg:   "{url: http://validator, cache_size: -1}",
+		error: "produce_validation.bar.cache_size must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    produce_validation:\n" +
+			"      bar: " + tc.cfg + "\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLLifecycleEvents(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    lifecycle_events:\n" +
+		"      topic: pixy-events\n" +
+		"  bar:\n" +
+		"    kafka:\n" +
+		"      seed_peers:\n" +
+		"        - 192.168.19.2:9092\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Cluster, Equals, "foo")
+	c.Check(appCfg.Proxies["foo"].LifecycleEvents.Topic, Equals, "pixy-events")
+	c.Check(appCfg.Proxies["bar"].Cluster, Equals, "bar")
+	c.Check(appCfg.Proxies["bar"].LifecycleEvents.Topic, Equals, "")
+}
+
+func (s *ConfigSuite) TestFromYAMLLimits(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    limits:\n" +
+		"      consume:\n" +
+		"        concurrency: 100\n" +
+		"        queue_size: 10\n" +
+		"      produce:\n" +
+		"        concurrency: 50\n" +
+		"      max_groups: 20\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	limits := appCfg.Proxies["foo"].Limits
+	c.Check(limits.Consume, Equals, Limit{Concurrency: 100, QueueSize: 10})
+	c.Check(limits.Produce, Equals, Limit{Concurrency: 50})
+	c.Check(limits.MaxGroups, Equals, 20)
+}
+
+func (s *ConfigSuite) TestFromYAMLTables(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n" +
+		"    tables:\n" +
+		"      users:\n" +
+		"        max_bytes: 1024\n" +
+		"        snapshot_path: /tmp/users.table\n" +
+		"        snapshot_interval: 30s\n" +
+		"      groups:\n" +
+		"        rebuild_on_start: true\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Tables, DeepEquals, map[string]Table{
+		"users":  {MaxBytes: 1024, SnapshotPath: "/tmp/users.table", SnapshotInterval: 30 * time.Second},
+		"groups": {RebuildOnStart: true},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLTablesInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    tables:\n" +
+		"      users:\n" +
+		"        max_bytes: -1\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: tables.users.max_bytes must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLPipelines(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    kafka:\n" +
+		"      version: 0.11.0.0\n" +
+		"    pipelines:\n" +
+		"      bar:\n" +
+		"        source_topic: src\n" +
+		"        target_topic: dst\n" +
+		"        transform: identity\n" +
+		"        batch_size: 10\n" +
+		"        batch_timeout: 1s\n" +
+		"      bazz:\n" +
+		"        source_topic: src\n" +
+		"        target_topic: dst\n" +
+		"        group: g\n" +
+		"        plugin: /tmp/bazz.so\n" +
+		"        transaction_timeout: 30s\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Pipelines, DeepEquals, map[string]Pipeline{
+		"bar":  {SourceTopic: "src", TargetTopic: "dst", Transform: "identity", BatchSize: 10, BatchTimeout: time.Second},
+		"bazz": {SourceTopic: "src", TargetTopic: "dst", Group: "g", Plugin: "/tmp/bazz.so", TransactionTimeout: 30 * time.Second},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLPipelinesInvalid(c *C) {
+	for i, tc := range []struct {
+		version  string
+		pipeline string
+		error    string
+	}{{
+		version:  "0.10.2.1",
+		pipeline: "source_topic: src\ntarget_topic: dst\n",
+		error:    "pipelines require kafka.version >= 0.11.0.0",
+	}, {
+		version:  "0.11.0.0",
+		pipeline: "target_topic: dst\n",
+		error:    "pipelines.bar.source_topic must be set",
+	}, {
+		version:  "0.11.0.0",
+		pipeline: "source_topic: src\n",
+		error:    "pipelines.bar.target_topic must be set",
+	}, {
+		version:  "0.11.0.0",
+		pipeline: "source_topic: src\ntarget_topic: dst\ntransform: identity\nplugin: /tmp/bar.so\n",
+		error:    "pipelines.bar: transform and plugin are mutually exclusive",
+	}, {
+		version:  "0.11.0.0",
+		pipeline: "source_topic: src\ntarget_topic: dst\nbatch_size: -1\n",
+		error:    "pipelines.bar.batch_size must be >= 0",
+	}} {
+		data := "" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    kafka:\n" +
+			"      version: " + tc.version + "\n" +
+			"    pipelines:\n" +
+			"      bar:\n" +
+			"        " + strings.Replace(strings.TrimSuffix(tc.pipeline, "\n"), "\n", "\n        ", -1) + "\n"
+
+		// When
+		_, err := FromYAML([]byte(data))
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLArchives(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    archives:\n" +
+		"      bar:\n" +
+		"        topic: t1\n" +
+		"        format: avro\n" +
+		"        max_file_size: 1048576\n" +
+		"        max_file_age: 5m\n" +
+		"        endpoint: http://minio:9000\n" +
+		"        bucket: archive\n" +
+		"        prefix: kafka/\n" +
+		"      bazz:\n" +
+		"        topic: t2\n" +
+		"        group: g\n" +
+		"        endpoint: https://storage.googleapis.com\n" +
+		"        bucket: archive\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Archives, DeepEquals, map[string]Archive{
+		"bar": {Topic: "t1", Format: ArchiveFormatAvro, MaxFileSize: 1048576, MaxFileAge: 5 * time.Minute,
+			Endpoint: "http://minio:9000", Bucket: "archive", Prefix: "kafka/"},
+		"bazz": {Topic: "t2", Group: "g", Endpoint: "https://storage.googleapis.com", Bucket: "archive"},
+	})
+}
+
+func (s *ConfigSuite) TestFromYAMLArchivesInvalid(c *C) {
+	for i, tc := range []struct {
+		archive string
+		error   string
+	}{{
+		archive: "endpoint: http://minio:9000\nbucket: archive\n",
+		error:   "archives.bar.topic must be set",
+	}, {
+		archive: "topic: t1\nformat: csv\nendpoint: http://minio:9000\nbucket: archive\n",
+		error:   "archives.bar.format must be either jsonl or avro",
+	}, {
+		archive: "topic: t1\nmax_file_size: -1\nendpoint: http://minio:9000\nbucket: archive\n",
+		error:   "archives.bar.max_file_size must be >= 0",
+	}, {
+		archive: "topic: t1\nmax_file_age: -1s\nendpoint: http://minio:9000\nbucket: archive\n",
+		error:   "archives.bar.max_file_age must be >= 0",
+	}, {
+		archive: "topic: t1\nbucket: archive\n",
+		error:   "archives.bar.endpoint must be set",
+	}, {
+		archive: "topic: t1\nendpoint: http://minio:9000\n",
+		error:   "archives.bar.bucket must be set",
+	}} {
+		data := "" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    archives:\n" +
+			"      bar:\n" +
+			"        " + strings.Replace(strings.TrimSuffix(tc.archive, "\n"), "\n", "\n        ", -1) + "\n"
+
+		// When
+		_, err := FromYAML([]byte(data))
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLMessageCache(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      message_cache:\n" +
+		"        max_bytes: 1048576\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Consumer.MessageCache.MaxBytes, Equals, 1048576)
+}
+
+func (s *ConfigSuite) TestFromYAMLMessageCacheInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      message_cache:\n" +
+		"        max_bytes: -1\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: consumer.message_cache.max_bytes must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLGroupMembership(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    consumer:\n" +
+		"      retry_backoff: 1s\n" +
+		"      group_membership:\n" +
+		"        claim_retry_backoff: 3s\n" +
+		"        release_retry_backoff: 200ms\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	gm := appCfg.Proxies["foo"].Consumer.GroupMembership
+	c.Check(gm.ClaimRetryBackoff, Equals, 3*time.Second)
+	c.Check(gm.WatchRetryBackoff, Equals, time.Duration(0))
+	c.Check(gm.ReleaseRetryBackoff, Equals, 200*time.Millisecond)
+}
+
+func (s *ConfigSuite) TestFromYAMLGroupMembershipInvalid(c *C) {
+	for i, tc := range []struct {
+		param  string
+		errMsg string
+	}{{
+		param:  "claim_retry_backoff",
+		errMsg: "consumer.group_membership.claim_retry_backoff must be >= 0",
+	}, {
+		param:  "watch_retry_backoff",
+		errMsg: "consumer.group_membership.watch_retry_backoff must be >= 0",
+	}, {
+		param:  "release_retry_backoff",
+		errMsg: "consumer.group_membership.release_retry_backoff must be >= 0",
+	}} {
+		data := []byte("" +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    consumer:\n" +
+			"      group_membership:\n" +
+			"        " + tc.param + ": -1s\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: "+tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLThroughput(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    throughput:\n" +
+		"      max_topics: 10\n" +
+		"      max_groups: 0\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.Proxies["foo"].Throughput.MaxTopics, Equals, 10)
+	c.Check(appCfg.Proxies["foo"].Throughput.MaxGroups, Equals, 0)
+}
+
+func (s *ConfigSuite) TestFromYAMLThroughputInvalid(c *C) {
+	data := []byte("" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    throughput:\n" +
+		"      max_groups: -1\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: invalid config, cluster=foo: throughput.max_groups must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLDrainPeriod(c *C) {
+	data := []byte("" +
+		"drain_period: 15s\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.DrainPeriod, Equals, 15*time.Second)
+}
+
+func (s *ConfigSuite) TestFromYAMLDrainPeriodInvalid(c *C) {
+	data := []byte("" +
+		"drain_period: -1s\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	_, err := FromYAML(data)
+
+	// Then
+	c.Check(err, ErrorMatches, "invalid config parameter: drain_period must be >= 0")
+}
+
+func (s *ConfigSuite) TestFromYAMLGC(c *C) {
+	data := []byte("" +
+		"gc:\n" +
+		"  percent: 400\n" +
+		"  ballast_size: 1073741824\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.GC.Percent, Equals, 400)
+	c.Check(appCfg.GC.BallastSize, Equals, int64(1073741824))
+}
+
+func (s *ConfigSuite) TestFromYAMLGCInvalid(c *C) {
+	for i, tc := range []struct {
+		gc     string
+		errMsg string
+	}{{
+		gc:     "  percent: -2\n",
+		errMsg: "invalid config parameter: gc.percent must be >= -1",
+	}, {
+		gc:     "  ballast_size: -1\n",
+		errMsg: "invalid config parameter: gc.ballast_size must be >= 0",
+	}} {
+		data := []byte("" +
+			"gc:\n" + tc.gc +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+func (s *ConfigSuite) TestFromYAMLHTTPProduce(c *C) {
+	data := []byte("" +
+		"http_produce:\n" +
+		"  max_body_size: 10485760\n" +
+		"  buffer_size: 104857600\n" +
+		"proxies:\n" +
+		"  foo:\n" +
+		"    client_id: foo_id\n")
+
+	// When
+	appCfg, err := FromYAML(data)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(appCfg.HTTPProduce.MaxBodySize, Equals, int64(10485760))
+	c.Check(appCfg.HTTPProduce.BufferSize, Equals, int64(104857600))
+	c.Check(appCfg.HTTPProduce.BufferTimeout, Equals, 3*time.Second)
+}
+
+func (s *ConfigSuite) TestFromYAMLHTTPProduceInvalid(c *C) {
+	for i, tc := range []struct {
+		httpProduce string
+		errMsg      string
+	}{{
+		httpProduce: "  max_body_size: -1\n",
+		errMsg:      "invalid config parameter: http_produce.max_body_size must be >= 0",
+	}, {
+		httpProduce: "  buffer_size: -1\n",
+		errMsg:      "invalid config parameter: http_produce.buffer_size must be >= 0",
+	}, {
+		httpProduce: "  buffer_timeout: 0s\n",
+		errMsg:      "invalid config parameter: http_produce.buffer_timeout must be > 0",
+	}} {
+		data := []byte("" +
+			"http_produce:\n" + tc.httpProduce +
+			"proxies:\n" +
+			"  foo:\n" +
+			"    client_id: foo_id\n")
+
+		// When
+		_, err := FromYAML(data)
+
+		// Then
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+	}
+}
@@ -15,8 +15,28 @@ const (
 	// An event of this type should be sent to the message events channel
 	// when the message is acknowledged by a client.
 	EvAcked
+
+	// An event of this type should be sent to the message events channel
+	// when a message offered to a client could not be delivered, e.g. because
+	// the client disconnected, so that it is offered again right away rather
+	// than after the ack timeout.
+	EvReclaimed
+
+	// An event of this type should be sent to the message events channel
+	// when a client asks for an ack timeout other than the configured one
+	// for a message offered to it.
+	EvExtended
+
+	// An event of this type should be sent to the message events channel
+	// when all messages before an offset are to be skipped, e.g. to get a
+	// group past a backlog, whether they have been offered or not.
+	EvSkipped
 )
 
+// NoAffinity is a request affinity that means that a request can be served
+// with a message from any partition.
+const NoAffinity int32 = -1
+
 var (
 	ErrRequestTimeout  = errors.New("long polling timeout")
 	ErrUnavailable     = errors.New("service is shutting down")
@@ -39,8 +59,28 @@ type T interface {
 
 	// AsyncConsume is an asynchronous counterpart of Consume function. It
 	// sends a response down to a buffered channel of the consumer machinery
-	// and returns a channel that a response should be expected from.
-	AsyncConsume(group, topic string) <-chan Response
+	// and returns a channel that a response should be expected from. If
+	// affinity is not NoAffinity, then the request is preferably served with
+	// a message from the partition it specifies, if one is available. When
+	// several requests of a group wait for messages, those with higher
+	// priority are served first. The request ID is recorded with the
+	// message offered to the request, for in-flight messages to be traced
+	// to the requests that hold them.
+	AsyncConsume(group, topic string, affinity, priority int32, requestID string) <-chan Response
+
+	// Subscribe makes the consumer group member subscribe to the topic right
+	// away, rather than on the first consume request. The subscription
+	// expires the usual way if the topic is not consumed for
+	// `Config.Consumer.SubscriptionTimeout`.
+	Subscribe(group, topic string) error
+
+	// Unsubscribe makes the consumer group member unsubscribe from the topic
+	// right away, rather than when the subscription expires. Partitions of
+	// the topic are released as soon as all messages offered from them are
+	// acknowledged, or the ack timeout expires. Only partitions of the topic
+	// are rebalanced among group members. If the member is not subscribed to
+	// the topic, then it is a noop.
+	Unsubscribe(group, topic string) error
 
 	// Stop sends a shutdown signal to all internal goroutines and blocks until
 	// they are stopped. It is guaranteed that all last consumed offsets of all
@@ -51,11 +91,29 @@ type T interface {
 // Request
 type Request struct {
 	Timestamp  time.Time
+	Kind       RequestKind
 	Group      string
 	Topic      string
+	Affinity   int32
+	Priority   int32
+	RequestID  string
 	ResponseCh chan Response
 }
 
+// RequestKind tells what a request is for.
+type RequestKind int
+
+const (
+	// RqConsume requests are served with a message.
+	RqConsume RequestKind = iota
+	// RqSubscribe requests subscribe the group member to the topic, they are
+	// served with an empty response.
+	RqSubscribe
+	// RqUnsubscribe requests unsubscribe the group member from the topic,
+	// they are served with an empty response.
+	RqUnsubscribe
+)
+
 // Response defines responses returned upstream by the children.
 type Response struct {
 	Msg Message
@@ -67,6 +125,26 @@ type Message struct {
 	sarama.ConsumerMessage
 	HighWaterMark int64
 	EventsCh      chan<- Event
+
+	// MemberID is the client ID of the group member that served the message.
+	MemberID string
+	// Generation is the group generation as of the rebalance that assigned
+	// the message partition to the group member.
+	Generation int32
+	// OfferedAt is when the message was first offered to a client, it is
+	// zero unless the message is offered again.
+	OfferedAt time.Time
+	// RetryNo is how many times the message has been offered again after
+	// an ack timeout expired. Offers reclaimed from clients that
+	// disconnected do not count.
+	RetryNo int
+	// RequestID is the ID of the consume request that the message was
+	// offered to last.
+	RequestID string
+	// DataLoss is the number of messages right before this one that were
+	// removed by retention before the group consumed them. It is only set
+	// when the message is offered for the first time.
+	DataLoss int64
 }
 
 func NewRequest(group, topic string) Request {
@@ -74,17 +152,50 @@ func NewRequest(group, topic string) Request {
 		Timestamp:  time.Now().UTC(),
 		Group:      group,
 		Topic:      topic,
+		Affinity:   NoAffinity,
 		ResponseCh: make(chan Response, 1),
 	}
 }
 
 func Ack(offset int64) Event {
-	return Event{EvAcked, offset}
+	return Event{T: EvAcked, Offset: offset}
+}
+
+// AckWithMeta returns an ack event that also carries application metadata to
+// be committed along with the partition offset.
+func AckWithMeta(offset int64, meta string) Event {
+	return Event{T: EvAcked, Offset: offset, Meta: meta}
+}
+
+// HoldBack returns an event that makes a message offered to a client be
+// offered again the given timeout from now, without counting as a retry.
+func HoldBack(offset int64, timeout time.Duration) Event {
+	return Event{T: EvReclaimed, Offset: offset, Timeout: timeout}
+}
+
+// Extend returns an event that makes a message offered to a client expire
+// the given timeout from now, rather than `consumer.ack_timeout` after it
+// was offered.
+func Extend(offset int64, timeout time.Duration) Event {
+	return Event{T: EvExtended, Offset: offset, Timeout: timeout}
+}
+
+// SkipTo returns an event that makes a partition consumer acknowledge all
+// messages before the given offset, dropping pending offers, and continue
+// from the offset.
+func SkipTo(offset int64) Event {
+	return Event{T: EvSkipped, Offset: offset}
 }
 
 type Event struct {
 	T      eventType
 	Offset int64
+	// Timeout is only set for EvExtended and EvReclaimed events.
+	Timeout time.Duration
+	// Meta is application metadata that EvAcked events can carry.
+	Meta string
+	// RequestID is only set for EvOffered events.
+	RequestID string
 }
 
 type eventType int
@@ -1,13 +1,17 @@
 package consumerimpl
 
 import (
+	"strings"
+
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
 	"github.com/mailgun/kafka-pixy/consumer/groupcsm"
+	"github.com/mailgun/kafka-pixy/consumer/msgcache"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/zkconn"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -31,6 +35,7 @@ type t struct {
 	kafkaClt   sarama.Client
 	zkConn     *zk.Conn
 	offsetMgrF offsetmgr.Factory
+	msgCache   *msgcache.T
 }
 
 // Spawn creates a consumer instance with the specified configuration and
@@ -41,35 +46,68 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, offsetMgrF offset
 		return nil, errors.Wrap(err, "failed to create Kafka client for message streams")
 	}
 
-	zkConn, _, err := zk.Connect(cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.SessionTimeout)
+	actDesc := parentActDesc.NewChild("cons")
+	zkConn, _, err := zkconn.Connect(actDesc, cfg, cfg.ZooKeeper.SessionTimeout)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create kazoo.Kazoo")
 	}
+	if cfg.ZooKeeper.CreateChroot {
+		if err := createPath(zkConn, cfg.ZooKeeper.Chroot+"/consumers"); err != nil {
+			zkConn.Close()
+			kafkaClt.Close()
+			return nil, errors.Wrap(err, "failed to create chroot")
+		}
+	}
 
 	c := &t{
-		actDesc:    parentActDesc.NewChild("cons"),
+		actDesc:    actDesc,
 		cfg:        cfg,
 		kafkaClt:   kafkaClt,
 		offsetMgrF: offsetMgrF,
+		msgCache:   msgcache.New(cfg.Consumer.MessageCache.MaxBytes),
 		zkConn:     zkConn,
 	}
+	if c.msgCache != nil {
+		c.actDesc.ObserveGauge("msg_cache_messages", func() int64 { return int64(c.msgCache.Stats().Messages) })
+		c.actDesc.ObserveGauge("msg_cache_bytes", func() int64 { return int64(c.msgCache.Stats().Bytes) })
+		c.actDesc.ObserveGauge("msg_cache_hits", func() int64 { return c.msgCache.Stats().Hits })
+	}
 	c.dispatcher = dispatcher.Spawn(c.actDesc, c, c.cfg)
 	return c, nil
 }
 
 // implements `consumer.T`
 func (c *t) Consume(group, topic string) (consumer.Message, error) {
-	rs := <-c.AsyncConsume(group, topic)
+	rs := <-c.AsyncConsume(group, topic, consumer.NoAffinity, 0, "")
 	return rs.Msg, rs.Err
 }
 
 // implements `consumer.T`
-func (c *t) AsyncConsume(group, topic string) <-chan consumer.Response {
+func (c *t) AsyncConsume(group, topic string, affinity, priority int32, requestID string) <-chan consumer.Response {
 	rq := consumer.NewRequest(group, topic)
+	rq.Affinity = affinity
+	rq.Priority = priority
+	rq.RequestID = requestID
 	c.dispatcher.Requests() <- rq
 	return rq.ResponseCh
 }
 
+// implements `consumer.T`
+func (c *t) Subscribe(group, topic string) error {
+	rq := consumer.NewRequest(group, topic)
+	rq.Kind = consumer.RqSubscribe
+	c.dispatcher.Requests() <- rq
+	return (<-rq.ResponseCh).Err
+}
+
+// implements `consumer.T`
+func (c *t) Unsubscribe(group, topic string) error {
+	rq := consumer.NewRequest(group, topic)
+	rq.Kind = consumer.RqUnsubscribe
+	c.dispatcher.Requests() <- rq
+	return (<-rq.ResponseCh).Err
+}
+
 // implements `consumer.T`
 func (c *t) Stop() {
 	c.dispatcher.Stop()
@@ -84,10 +122,26 @@ func (c *t) KeyOf(rq consumer.Request) dispatcher.Key {
 
 // implements `dispatcher.Factory`.
 func (c *t) SpawnChild(childSpec dispatcher.ChildSpec) {
-	groupcsm.Spawn(c.actDesc, childSpec, c.cfg, c.kafkaClt, c.zkConn, c.offsetMgrF)
+	groupcsm.Spawn(c.actDesc, childSpec, c.cfg, c.kafkaClt, c.zkConn, c.offsetMgrF, c.msgCache)
 }
 
 // String returns a string ID of this instance to be used in logs.
 func (c *t) String() string {
 	return c.actDesc.String()
 }
+
+// createPath creates all missing directories of a ZooKeeper path.
+func createPath(zkConn *zk.Conn, path string) error {
+	var dir string
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir += "/" + name
+		_, err := zkConn.Create(dir, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "while creating %v", dir)
+		}
+	}
+	return nil
+}
@@ -488,6 +488,23 @@ func (s *ConsumerSuite) TestInvalidTopic(c *C) {
 	c.Assert(err, Equals, consumer.ErrRequestTimeout)
 }
 
+// If `zoo_keeper.create_chroot` is set, then a missing chroot is created
+// along with the consumers directory in it.
+func (s *ConsumerSuite) TestCreateChroot(c *C) {
+	s.cfg.ZooKeeper.Chroot = fmt.Sprintf("/kafka-pixy-test/%d/pixy", time.Now().UnixNano())
+	s.cfg.ZooKeeper.CreateChroot = true
+
+	// When
+	cons, err := Spawn(s.ns, s.cfg, s.omf)
+
+	// Then
+	c.Assert(err, IsNil)
+	defer cons.Stop()
+	exists, _, err := cons.zkConn.Exists(s.cfg.ZooKeeper.Chroot + "/consumers")
+	c.Check(err, IsNil)
+	c.Check(exists, Equals, true)
+}
+
 // A topic that has a lot of partitions can be consumed.
 func (s *ConsumerSuite) TestLotsOfPartitions(c *C) {
 	// Given
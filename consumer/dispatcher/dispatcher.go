@@ -135,6 +135,7 @@ func Spawn(parentActDesc *actor.Descriptor, factory Factory, cfg *config.Proxy,
 	if d.requestsCh == nil {
 		d.requestsCh = make(chan consumer.Request, cfg.Consumer.ChannelBufferSize)
 	}
+	d.actDesc.ObserveQueue("requests", func() int { return len(d.requestsCh) })
 	actor.Spawn(d.actDesc, nil, d.run)
 	return d
 }
@@ -174,6 +175,7 @@ func (d *T) run() {
 	}
 	defer close(d.stoppedCh)
 	for {
+		d.actDesc.Touch()
 		select {
 		case rq, ok := <-d.requestsCh:
 			if !ok {
@@ -182,6 +184,12 @@ func (d *T) run() {
 			}
 			key := d.factory.KeyOf(rq)
 			childRequestsCh := d.children[key]
+			// There is nothing to unsubscribe if there is no child for the
+			// key, so there is no point in spawning one.
+			if childRequestsCh == nil && rq.Kind == consumer.RqUnsubscribe {
+				rq.ResponseCh <- consumer.Response{}
+				continue
+			}
 			// If there is no child for the key, then spawn one.
 			if childRequestsCh == nil {
 				childRequestsCh = make(chan consumer.Request, d.cfg.Consumer.ChannelBufferSize)
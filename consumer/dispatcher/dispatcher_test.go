@@ -71,6 +71,40 @@ func (s *DispatcherSuite) TestChildrenOnDemand(c *C) {
 	}
 }
 
+// Unsubscribe requests are served right away if there is no child for their
+// key, and dispatched to the child otherwise.
+func (s *DispatcherSuite) TestUnsubscribeNoChild(c *C) {
+	d := Spawn(s.ns, s.groupF, s.cfg)
+	defer d.Stop()
+
+	unsubscribeRq := consumer.NewRequest("g1", "t1")
+	unsubscribeRq.Kind = consumer.RqUnsubscribe
+
+	// When
+	d.Requests() <- unsubscribeRq
+
+	// Then
+	assertRejected(c, unsubscribeRq, nil, time.Second)
+	select {
+	case child := <-s.groupF.spawnedCh:
+		c.Errorf("Unexpected child: %s", child.Key())
+	default:
+	}
+
+	// When
+	consumeRq := consumer.NewRequest("g1", "t1")
+	unsubscribeRq = consumer.NewRequest("g1", "t1")
+	unsubscribeRq.Kind = consumer.RqUnsubscribe
+	sendAll(d, []consumer.Request{consumeRq, unsubscribeRq})
+
+	// Then
+	child := <-s.groupF.spawnedCh
+	defer child.Dispose()
+	c.Assert(<-child.Requests(), Equals, consumeRq)
+	c.Assert(<-child.Requests(), Equals, unsubscribeRq)
+	assertNoResponse(c, unsubscribeRq)
+}
+
 // If a child terminates while there are still requests in its channel a
 // successor is spawned to handle them.
 func (s *DispatcherSuite) TestSuccessorSpawned(c *C) {
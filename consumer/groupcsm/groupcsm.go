@@ -2,8 +2,10 @@ package groupcsm
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -11,13 +13,16 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/msgcache"
 	"github.com/mailgun/kafka-pixy/consumer/msgfetcher"
 	"github.com/mailgun/kafka-pixy/consumer/multiplexer"
 	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
 	"github.com/mailgun/kafka-pixy/consumer/subscriber"
 	"github.com/mailgun/kafka-pixy/consumer/topiccsm"
+	"github.com/mailgun/kafka-pixy/lifecycle"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
+	"github.com/mailgun/kafka-pixy/rebalancelog"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -36,16 +41,26 @@ type T struct {
 	zkConn      *zk.Conn
 	msgFetcherF msgfetcher.Factory
 	offsetMgrF  offsetmgr.Factory
+	msgCache    *msgcache.T
 	subscriber  *subscriber.T
 	topicCsmCh  chan *topiccsm.T
+	generation  int32
 	wg          sync.WaitGroup
 
 	multiplexersMu sync.Mutex
 	multiplexers   map[string]*multiplexer.T
+	wirings        map[string]wiring
+}
+
+// wiring is what a topic multiplexer was wired up with by the last rebalance.
+type wiring struct {
+	tc       *topiccsm.T
+	assigned []int32
 }
 
 func Spawn(parentActDesc *actor.Descriptor, childSpec dispatcher.ChildSpec,
 	cfg *config.Proxy, kafkaClt sarama.Client, zkConn *zk.Conn, offsetMgrF offsetmgr.Factory,
+	msgCache *msgcache.T,
 ) *T {
 	group := string(childSpec.Key())
 	actDesc := parentActDesc.NewChild(fmt.Sprintf("%s", group))
@@ -57,9 +72,12 @@ func Spawn(parentActDesc *actor.Descriptor, childSpec dispatcher.ChildSpec,
 		kafkaClt:     kafkaClt,
 		zkConn:       zkConn,
 		offsetMgrF:   offsetMgrF,
+		msgCache:     msgCache,
 		multiplexers: make(map[string]*multiplexer.T),
+		wirings:      make(map[string]wiring),
 		topicCsmCh:   make(chan *topiccsm.T, cfg.Consumer.ChannelBufferSize),
 	}
+	gc.actDesc.ObserveQueue("topic_consumers", func() int { return len(gc.topicCsmCh) })
 
 	gc.subscriber = subscriber.Spawn(gc.actDesc, gc.group, gc.cfg, gc.zkConn)
 	gc.msgFetcherF = msgfetcher.SpawnFactory(gc.actDesc, gc.cfg, gc.kafkaClt)
@@ -80,7 +98,13 @@ func (gc *T) KeyOf(req consumer.Request) dispatcher.Key {
 func (gc *T) SpawnChild(childSpec dispatcher.ChildSpec) {
 	topic := string(childSpec.Key())
 	topiccsm.Spawn(gc.actDesc, gc.group, childSpec, gc.cfg, gc.topicCsmCh,
-		func() bool { return gc.isSafe2Stop(topic) })
+		func() bool { return gc.isSafe2Stop(topic) }, gc.Generation)
+}
+
+// Generation returns the group generation as of the latest successful
+// rebalance.
+func (gc *T) Generation() int32 {
+	return atomic.LoadInt32(&gc.generation)
 }
 
 // String return string ID of this group consumer to be posted in logs.
@@ -115,6 +139,9 @@ func (gc *T) run() {
 		topicConsumers          = make(map[string]*topiccsm.T)
 		topics                  []string
 		subscriptions           map[string][]string
+		prevMembers             = []string{}
+		retrying                = false
+		generation              int32
 		ok                      = true
 		nilOrRetryCh            <-chan time.Time
 		nilOrSubscriberTopicsCh chan<- []string
@@ -125,6 +152,7 @@ func (gc *T) run() {
 		rebalanceResultCh       = make(chan error, 1)
 	)
 	for {
+		gc.actDesc.Touch()
 		select {
 		case tc := <-gc.topicCsmCh:
 			// It is assumed that only one topicConsumer can exist for a
@@ -152,7 +180,11 @@ func (gc *T) run() {
 				stopped = true
 				continue
 			}
+			generation = gc.subscriber.Generation()
 			rebalanceRequired = true
+			// New subscriptions supersede a scheduled retry.
+			rebalanceScheduled = false
+			retrying = false
 
 		case err := <-rebalanceResultCh:
 			rebalancePending = false
@@ -162,7 +194,9 @@ func (gc *T) run() {
 					goto done
 				}
 				nilOrRetryCh = time.After(gc.cfg.Consumer.RetryBackoff)
+				rebalanceRequired = true
 				rebalanceScheduled = true
+				retrying = true
 			}
 			if stopped {
 				goto done
@@ -179,9 +213,23 @@ func (gc *T) run() {
 			for topic, tc := range topicConsumers {
 				topicConsumersCopy[topic] = tc
 			}
-			subscriptions := subscriptions
+			subscriptions, generation := subscriptions, generation
+			rb := rebalancelog.Rebalance{
+				Trigger:       rebalancelog.SubscriptionsChanged,
+				Generation:    generation,
+				MembersBefore: prevMembers,
+				MembersAfter:  rebalancelog.Members(subscriptions),
+			}
+			switch {
+			case !reflect.DeepEqual(rb.MembersBefore, rb.MembersAfter):
+				rb.Trigger = rebalancelog.MembershipChanged
+			case retrying:
+				rb.Trigger = rebalancelog.Retry
+			}
+			prevMembers = rb.MembersAfter
+			retrying = false
 			actor.Spawn(rebalanceActDesc, nil, func() {
-				gc.rebalance(rebalanceActDesc, topicConsumersCopy, subscriptions, rebalanceResultCh)
+				gc.rebalance(rebalanceActDesc, topicConsumersCopy, subscriptions, rb, rebalanceResultCh)
 			})
 			rebalancePending = true
 			rebalanceRequired = false
@@ -201,15 +249,27 @@ done:
 	wg.Wait()
 }
 
+// rebalance makes the group member consume partitions assigned to it given
+// subscriptions of all group members. The outcome is recorded to the
+// rebalance history along with the details given in rb.
 func (gc *T) rebalance(actDesc *actor.Descriptor, topicConsumers map[string]*topiccsm.T,
-	subscriptions map[string][]string, rebalanceResultCh chan<- error,
+	subscriptions map[string][]string, rb rebalancelog.Rebalance, rebalanceResultCh chan<- error,
 ) {
+	generation := rb.Generation
+	rb.StartedAt = time.Now().UTC()
+	lifecycle.Publish(lifecycle.NewGroupEvent(gc.cfg, lifecycle.RebalanceStarted, gc.group))
 	assignedPartitions, err := gc.resolvePartitions(subscriptions, gc.kafkaClt.Partitions)
 	if err != nil {
+		ev := lifecycle.NewGroupEvent(gc.cfg, lifecycle.RebalanceFinished, gc.group)
+		ev.Error = err.Error()
+		lifecycle.Publish(ev)
+		rb.Duration = time.Since(rb.StartedAt)
+		rb.Error = err.Error()
+		rebalancelog.Record(gc.cfg.Cluster, gc.group, rb, gc.cfg.Consumer.RebalanceHistory)
 		rebalanceResultCh <- err
 		return
 	}
-	actDesc.Log().Infof("assigned partitions: %s", prettyfmt.Val(assignedPartitions))
+	actDesc.Log().Infof("assigned partitions: generation=%d, %s", generation, prettyfmt.Val(assignedPartitions))
 	var wg sync.WaitGroup
 	// Stop consuming partitions that are no longer assigned to this group
 	// and start consuming newly assigned partitions for topics that has been
@@ -217,7 +277,15 @@ func (gc *T) rebalance(actDesc *actor.Descriptor, topicConsumers map[string]*top
 	gc.multiplexersMu.Lock()
 	defer gc.multiplexersMu.Unlock()
 	for topic, mux := range gc.multiplexers {
-		gc.rewireMuxAsync(topic, &wg, mux, topicConsumers[topic], assignedPartitions[topic])
+		tc, assigned := topicConsumers[topic], assignedPartitions[topic]
+		// Multiplexers of topics that are consumed by the same topic consumer
+		// from the same partitions are left alone, so that subscribing to or
+		// unsubscribing from a topic does not interrupt consumption of others.
+		if w, ok := gc.wirings[topic]; ok && tc != nil && w.tc == tc && reflect.DeepEqual(w.assigned, assigned) {
+			continue
+		}
+		gc.rewireMuxAsync(topic, &wg, mux, tc, assigned)
+		gc.wirings[topic] = wiring{tc: tc, assigned: assigned}
 	}
 	// Start consuming partitions for topics that has not been consumed before.
 	for topic, assignedTopicPartitions := range assignedPartitions {
@@ -229,19 +297,31 @@ func (gc *T) rebalance(actDesc *actor.Descriptor, topicConsumers map[string]*top
 		topic := topic
 		spawnInFn := func(partition int32) multiplexer.In {
 			return partitioncsm.Spawn(gc.actDesc, gc.group, topic, partition,
-				gc.cfg, gc.subscriber, gc.msgFetcherF, gc.offsetMgrF)
+				gc.cfg, gc.subscriber, gc.msgFetcherF, gc.offsetMgrF, gc.msgCache)
+		}
+		mux = multiplexer.New(gc.actDesc, spawnInFn, gc.cfg.Consumer.MuxPolicy[gc.group])
+		if gc.cfg.Consumer.MuxPolicy[gc.group] == config.MuxPolicyTimestamp {
+			mux.SetWindow(gc.cfg.Consumer.MuxTimestampWindow)
 		}
-		mux = multiplexer.New(gc.actDesc, spawnInFn)
 		gc.rewireMuxAsync(topic, &wg, mux, tc, assignedTopicPartitions)
 		gc.multiplexers[topic] = mux
+		gc.wirings[topic] = wiring{tc: tc, assigned: assignedTopicPartitions}
 	}
 	wg.Wait()
+	atomic.StoreInt32(&gc.generation, generation)
 	// Clean up gears for topics that do not have assigned partitions anymore.
 	for topic, mux := range gc.multiplexers {
 		if !mux.IsRunning() {
 			delete(gc.multiplexers, topic)
+			delete(gc.wirings, topic)
 		}
 	}
+	ev := lifecycle.NewGroupEvent(gc.cfg, lifecycle.RebalanceFinished, gc.group)
+	ev.Assigned = assignedPartitions
+	lifecycle.Publish(ev)
+	rb.Duration = time.Since(rb.StartedAt)
+	rb.Assigned = assignedPartitions
+	rebalancelog.Record(gc.cfg.Cluster, gc.group, rb, gc.cfg.Consumer.RebalanceHistory)
 	// Notify the caller that rebalancing has completed successfully.
 	rebalanceResultCh <- nil
 	return
@@ -288,7 +368,8 @@ func (gc *T) resolvePartitions(subscriptions map[string][]string,
 			}
 			return nil, errors.Wrapf(err, "failed to get partition list, topic=%s", topic)
 		}
-		subscribersToPartitions := assignTopicPartitions(topicPartitions, topicsToMembers[topic])
+		pins := gc.cfg.Consumer.PartitionPins[gc.group][topic]
+		subscribersToPartitions := pinTopicPartitions(topicPartitions, topicsToMembers[topic], pins)
 		assignedTopicPartitions := subscribersToPartitions[gc.cfg.ClientID]
 		if len(assignedTopicPartitions) > 0 {
 			assignedPartitions[topic] = assignedTopicPartitions
@@ -332,6 +413,54 @@ func assignTopicPartitions(partitions []int32, subscribers []string) map[string]
 	return subscribersToPartitions
 }
 
+// pinTopicPartitions divides topic partitions among all consumer group
+// members subscribed to the topic, the same way as assignTopicPartitions,
+// except that partitions pinned to a subscribed member are assigned to it.
+// The remaining partitions are divided among members that have no pinned
+// partitions, or among all members if every one of them has some.
+func pinTopicPartitions(partitions []int32, subscribers []string, pins map[string][]int32) map[string][]int32 {
+	if len(pins) == 0 {
+		return assignTopicPartitions(partitions, subscribers)
+	}
+	existing := make(map[int32]bool, len(partitions))
+	for _, partition := range partitions {
+		existing[partition] = true
+	}
+	subscribersToPartitions := make(map[string][]int32)
+	pinned := make(map[int32]bool)
+	var unpinnedSubscribers []string
+	for _, groupMemberID := range subscribers {
+		for _, partition := range pins[groupMemberID] {
+			if existing[partition] && !pinned[partition] {
+				subscribersToPartitions[groupMemberID] = append(subscribersToPartitions[groupMemberID], partition)
+				pinned[partition] = true
+			}
+		}
+		if len(subscribersToPartitions[groupMemberID]) == 0 {
+			unpinnedSubscribers = append(unpinnedSubscribers, groupMemberID)
+		}
+	}
+	if len(pinned) == 0 {
+		return assignTopicPartitions(partitions, subscribers)
+	}
+	if len(unpinnedSubscribers) == 0 {
+		unpinnedSubscribers = subscribers
+	}
+	var rest []int32
+	for _, partition := range partitions {
+		if !pinned[partition] {
+			rest = append(rest, partition)
+		}
+	}
+	for groupMemberID, assigned := range assignTopicPartitions(rest, unpinnedSubscribers) {
+		subscribersToPartitions[groupMemberID] = append(subscribersToPartitions[groupMemberID], assigned...)
+	}
+	for _, assigned := range subscribersToPartitions {
+		sort.Slice(assigned, func(i, j int) bool { return assigned[i] < assigned[j] })
+	}
+	return subscribersToPartitions
+}
+
 func listTopics(topicConsumers map[string]*topiccsm.T) []string {
 	topics := make([]string, 0, len(topicConsumers))
 	for topic := range topicConsumers {
@@ -90,6 +90,49 @@ func (s *GroupConsumerSuite) TestAssignTopicPartitions(c *C) {
 		})
 }
 
+func (s *GroupConsumerSuite) TestPinTopicPartitions(c *C) {
+	for i, tc := range []struct {
+		subscribers []string
+		pins        map[string][]int32
+		assigned    map[string][]int32
+	}{{
+		// Without pins partitions are assigned as usual.
+		subscribers: []string{"a", "b"},
+		assigned:    map[string][]int32{"a": {0, 1, 2}, "b": {3, 4, 5}},
+	}, {
+		// The rest is divided among members without pins.
+		subscribers: []string{"a", "b", "c"},
+		pins:        map[string][]int32{"a": {4}},
+		assigned:    map[string][]int32{"a": {4}, "b": {0, 1, 2}, "c": {3, 5}},
+	}, {
+		// If every member has pins, then the rest is divided among all.
+		subscribers: []string{"a", "b"},
+		pins:        map[string][]int32{"a": {5}, "b": {0}},
+		assigned:    map[string][]int32{"a": {1, 2, 5}, "b": {0, 3, 4}},
+	}, {
+		// Pins of members that do not consume the topic are ignored.
+		subscribers: []string{"a", "b"},
+		pins:        map[string][]int32{"c": {0}},
+		assigned:    map[string][]int32{"a": {0, 1, 2}, "b": {3, 4, 5}},
+	}, {
+		// Partitions that do not exist are ignored.
+		subscribers: []string{"a", "b"},
+		pins:        map[string][]int32{"a": {7}},
+		assigned:    map[string][]int32{"a": {0, 1, 2}, "b": {3, 4, 5}},
+	}, {
+		// A member that is the only one consuming gets everything.
+		subscribers: []string{"a"},
+		pins:        map[string][]int32{"a": {2}},
+		assigned:    map[string][]int32{"a": {0, 1, 2, 3, 4, 5}},
+	}} {
+		// When
+		assigned := pinTopicPartitions([]int32{0, 1, 2, 3, 4, 5}, tc.subscribers, tc.pins)
+
+		// Then
+		c.Check(assigned, DeepEquals, tc.assigned, Commentf("case #%d", i))
+	}
+}
+
 func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	cfg := config.DefaultProxy()
 	cfg.ClientID = "c"
@@ -124,6 +167,34 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	})
 }
 
+// Partitions pinned to members of the group in config are assigned to them.
+func (s *GroupConsumerSuite) TestResolvePartitionsPinned(c *C) {
+	cfg := config.DefaultProxy()
+	cfg.ClientID = "c"
+	cfg.Consumer.PartitionPins = map[string]map[string]map[string][]int32{
+		"g1": {"t1": {"c": {4}}},
+		"g2": {"t1": {"c": {0}}},
+	}
+	gc := T{cfg: cfg, group: "g1"}
+	topicPartitionsFn := func(topic string) ([]int32, error) {
+		return []int32{1, 2, 3, 4, 5}, nil
+	}
+
+	// When
+	topicsToPartitions, err := gc.resolvePartitions(
+		map[string][]string{
+			"a": {"t1", "t2"},
+			"c": {"t1", "t2"},
+		}, topicPartitionsFn)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(topicsToPartitions, DeepEquals, map[string][]int32{
+		"t1": {4},
+		"t2": {4, 5},
+	})
+}
+
 func (s *GroupConsumerSuite) TestResolvePartitionsEmpty(c *C) {
 	cfg := config.DefaultProxy()
 	cfg.ClientID = "c"
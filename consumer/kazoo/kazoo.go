@@ -75,13 +75,14 @@ func (m *Model) EnsureMemberSubscription(topics []string) error {
 }
 
 // FetchGroupSubscriptions retrieves bound group member specification records
-// and returns memberID-to-topic-list map, along with a channel that will be
-// sent a message when either the number of members or subscription of any of
-// them changes.
-func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, context.CancelFunc, error) {
-	members, memberWatchCh, err := m.watchZNodeChildren(m.membersPath)
+// and returns memberID-to-topic-list map, the group generation, along with a
+// channel that will be sent a message when either the number of members or
+// subscription of any of them changes. The group generation is the number of
+// times members joined or left the group, it is the same for all members.
+func (m *Model) FetchGroupSubscriptions() (map[string][]string, int32, <-chan none.T, context.CancelFunc, error) {
+	members, generation, memberWatchCh, err := m.watchZNodeChildren(m.membersPath)
 	if err != nil {
-		return nil, nil, nil, errors.Wrapf(err, "failed to watch members")
+		return nil, 0, nil, nil, errors.Wrapf(err, "failed to watch members")
 	}
 
 	memberUpdateWatchChs := make(map[string]<-chan zk.Event, len(members))
@@ -93,13 +94,13 @@ func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, c
 			continue
 		}
 		if err != nil {
-			return nil, nil, nil, errors.Wrapf(err, "while getting znode %s", memberPath)
+			return nil, 0, nil, nil, errors.Wrapf(err, "while getting znode %s", memberPath)
 		}
 
 		// Parse the retrieved JSON encoded member spec.
 		var memberSpec memberSpec
 		if err := json.Unmarshal(jsonMemberSpec, &memberSpec); err != nil {
-			return nil, nil, nil, errors.Wrapf(err, "while parsing member %s, data=%s", memberID, string(jsonMemberSpec))
+			return nil, 0, nil, nil, errors.Wrapf(err, "while parsing member %s, data=%s", memberID, string(jsonMemberSpec))
 		}
 
 		memberUpdateWatchChs[memberID] = memberUpdateWatchCh
@@ -112,7 +113,7 @@ func (m *Model) FetchGroupSubscriptions() (map[string][]string, <-chan none.T, c
 	for memberID, memberUpdateWatchCh := range memberUpdateWatchChs {
 		go m.forwardWatch(ctx, memberID, memberUpdateWatchCh, aggregateWatchCh)
 	}
-	return subscriptions, aggregateWatchCh, cancel, nil
+	return subscriptions, generation, aggregateWatchCh, cancel, nil
 }
 
 // CreatePartitionOwner creates a partition owner znode, but only if none
@@ -318,23 +319,25 @@ func (m *Model) recursiveDeleteZNode(path string) error {
 }
 
 // watchZNodeChildren creates a watch on ZNode children. If the ZNode does not
-// exist then it is durably created with empty value.
-func (m *Model) watchZNodeChildren(path string) ([]string, <-chan zk.Event, error) {
+// exist then it is durably created with empty value. Along with the children
+// list it returns the ZNode children version, that is incremented every time
+// a child is created or deleted.
+func (m *Model) watchZNodeChildren(path string) ([]string, int32, <-chan zk.Event, error) {
 	for {
-		children, _, watchCh, err := m.zkConn.ChildrenW(path)
+		children, stat, watchCh, err := m.zkConn.ChildrenW(path)
 		if err != nil {
 			if err != zk.ErrNoNode {
-				return nil, nil, errors.Wrapf(err, "while watching %v's children", path)
+				return nil, 0, nil, errors.Wrapf(err, "while watching %v's children", path)
 			}
 			// ZNode does not exist, so let's create it.
 			err = m.durableUpsertZNode(path, nil, 0)
 			if err != nil {
-				return nil, nil, errors.WithStack(err)
+				return nil, 0, nil, errors.WithStack(err)
 			}
 			continue
 		}
 		sort.Strings(children)
-		return children, watchCh, nil
+		return children, stat.Cversion, watchCh, nil
 	}
 }
 
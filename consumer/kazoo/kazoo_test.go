@@ -290,9 +290,10 @@ func (s *ModelSuite) TestWatchChildren(c *C) {
 	s.kazoo.recursiveDeleteZNode("/eeny")
 
 	path := "/eeny/meeny/miny/moe"
-	children, eventsCh, err := s.kazoo.watchZNodeChildren(path)
+	children, cversion, eventsCh, err := s.kazoo.watchZNodeChildren(path)
 	c.Assert(err, IsNil)
 	c.Assert(children, DeepEquals, []string{})
+	c.Assert(cversion, Equals, int32(0))
 
 	select {
 	case e := <-eventsCh:
@@ -312,16 +313,17 @@ func (s *ModelSuite) TestWatchChildren(c *C) {
 		c.Error("Timeout waiting for watch event")
 	}
 
-	children, eventsCh, err = s.kazoo.watchZNodeChildren(path)
+	children, cversion, eventsCh, err = s.kazoo.watchZNodeChildren(path)
 	c.Assert(err, IsNil)
 	c.Assert(children, DeepEquals, []string{"bar", "foo"})
+	c.Assert(cversion, Equals, int32(2))
 }
 
 func (s *ModelSuite) TestChildrenWatchDelete(c *C) {
 	s.kazoo.recursiveDeleteZNode(chroot + "/eeny")
 
 	path := "/eeny/meeny/miny/moe"
-	children, eventsCh, err := s.kazoo.watchZNodeChildren(chroot + path)
+	children, _, eventsCh, err := s.kazoo.watchZNodeChildren(chroot + path)
 	c.Assert(err, IsNil)
 	c.Assert(children, DeepEquals, []string{})
 
@@ -0,0 +1,179 @@
+// Package msgcache implements a bounded cache of messages offered to clients
+// but not acknowledged yet. It outlives partition consumers, so that when a
+// partition consumer is restarted, e.g. after its group expired because
+// flaky clients stopped consuming for a while, the messages that have to be
+// offered again are taken from the cache rather than fetched from Kafka once
+// more. When the cache is full the least recently cached messages are
+// evicted first.
+package msgcache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mailgun/kafka-pixy/consumer"
+)
+
+// entryOverhead is roughly how many bytes a cached message takes up besides
+// its key, value and headers.
+const entryOverhead = 128
+
+// T is a message cache. A nil cache does not cache anything.
+type T struct {
+	maxBytes int
+
+	mu         sync.Mutex
+	bytes      int
+	lru        *list.List
+	partitions map[partitionID]map[int64]*list.Element
+
+	// Accessed atomically.
+	hits int64
+}
+
+// Stats describes usage of a cache.
+type Stats struct {
+	Messages int
+	Bytes    int
+
+	// The number of messages taken from the cache to be offered again.
+	Hits int64
+}
+
+type partitionID struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+type entry struct {
+	id   partitionID
+	msg  consumer.Message
+	size int
+}
+
+// New creates a cache that keeps messages of up to maxBytes total size. If
+// maxBytes is zero, then nil is returned.
+func New(maxBytes int) *T {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &T{
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		partitions: make(map[partitionID]map[int64]*list.Element),
+	}
+}
+
+// Put caches a message offered to a consumer group client. Messages larger
+// than the cache are not cached.
+func (c *T) Put(group string, msg consumer.Message) {
+	if c == nil {
+		return
+	}
+	msg.EventsCh = nil
+	e := &entry{
+		id:   partitionID{group, msg.Topic, msg.Partition},
+		msg:  msg,
+		size: sizeOf(msg),
+	}
+	if e.size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(e.id, msg.Offset)
+	for c.bytes+e.size > c.maxBytes {
+		oldest := c.lru.Back().Value.(*entry)
+		c.remove(oldest.id, oldest.msg.Offset)
+	}
+	offsets := c.partitions[e.id]
+	if offsets == nil {
+		offsets = make(map[int64]*list.Element)
+		c.partitions[e.id] = offsets
+	}
+	offsets[msg.Offset] = c.lru.PushFront(e)
+	c.bytes += e.size
+}
+
+// Get returns a cached message of a consumer group partition at the
+// specified offset.
+func (c *T) Get(group, topic string, partition int32, offset int64) (consumer.Message, bool) {
+	if c == nil {
+		return consumer.Message{}, false
+	}
+	c.mu.Lock()
+	el, ok := c.partitions[partitionID{group, topic, partition}][offset]
+	c.mu.Unlock()
+	if !ok {
+		return consumer.Message{}, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return el.Value.(*entry).msg, true
+}
+
+// Remove evicts a message of a consumer group partition, e.g. because it has
+// been acknowledged.
+func (c *T) Remove(group, topic string, partition int32, offset int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.remove(partitionID{group, topic, partition}, offset)
+	c.mu.Unlock()
+}
+
+// RemoveBefore evicts messages of a consumer group partition with offsets
+// lower than the specified one, that is ones that have been committed.
+func (c *T) RemoveBefore(group, topic string, partition int32, offset int64) {
+	if c == nil {
+		return
+	}
+	id := partitionID{group, topic, partition}
+	c.mu.Lock()
+	for cachedOffset := range c.partitions[id] {
+		if cachedOffset < offset {
+			c.remove(id, cachedOffset)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Stats returns usage of the cache.
+func (c *T) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.mu.Lock()
+	stats := Stats{Messages: c.lru.Len(), Bytes: c.bytes}
+	c.mu.Unlock()
+	stats.Hits = atomic.LoadInt64(&c.hits)
+	return stats
+}
+
+// remove must be called with the mutex held.
+func (c *T) remove(id partitionID, offset int64) {
+	offsets := c.partitions[id]
+	el, ok := offsets[offset]
+	if !ok {
+		return
+	}
+	c.lru.Remove(el)
+	c.bytes -= el.Value.(*entry).size
+	delete(offsets, offset)
+	if len(offsets) == 0 {
+		delete(c.partitions, id)
+	}
+}
+
+// sizeOf returns the number of bytes a message takes up in the cache.
+func sizeOf(msg consumer.Message) int {
+	size := entryOverhead + len(msg.Key) + len(msg.Value)
+	for _, h := range msg.Headers {
+		if h != nil {
+			size += len(h.Key) + len(h.Value)
+		}
+	}
+	return size
+}
@@ -0,0 +1,149 @@
+package msgcache
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+type MsgCacheSuite struct{}
+
+var _ = Suite(&MsgCacheSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+// If the size limit is zero, then nothing is cached.
+func (s *MsgCacheSuite) TestDisabled(c *C) {
+	// When
+	mc := New(0)
+
+	// Then
+	c.Check(mc, IsNil)
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+	_, ok := mc.Get("g1", "t1", 0, 1)
+	c.Check(ok, Equals, false)
+	c.Check(mc.Stats(), Equals, Stats{})
+}
+
+// Messages are cached per group partition, and removed on demand.
+func (s *MsgCacheSuite) TestPutGetRemove(c *C) {
+	mc := New(1000)
+
+	// When
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+	mc.Put("g1", newMsg("t1", 0, 2, "bar"))
+	mc.Put("g2", newMsg("t1", 0, 1, "bazz"))
+	mc.Remove("g1", "t1", 0, 2)
+
+	// Then
+	msg, ok := mc.Get("g1", "t1", 0, 1)
+	c.Check(ok, Equals, true)
+	c.Check(string(msg.Value), Equals, "foo")
+	msg, ok = mc.Get("g2", "t1", 0, 1)
+	c.Check(ok, Equals, true)
+	c.Check(string(msg.Value), Equals, "bazz")
+	for i, tc := range []struct {
+		group     string
+		topic     string
+		partition int32
+		offset    int64
+	}{
+		{group: "g1", topic: "t1", partition: 0, offset: 2},
+		{group: "g1", topic: "t1", partition: 1, offset: 1},
+		{group: "g1", topic: "t2", partition: 0, offset: 1},
+		{group: "g3", topic: "t1", partition: 0, offset: 1},
+	} {
+		_, ok := mc.Get(tc.group, tc.topic, tc.partition, tc.offset)
+		c.Check(ok, Equals, false, Commentf("case #%d", i))
+	}
+	c.Check(mc.Stats(), Equals, Stats{Messages: 2, Bytes: 2*entryOverhead + 7, Hits: 2})
+}
+
+// Events channels of messages are not cached.
+func (s *MsgCacheSuite) TestEventsChDropped(c *C) {
+	mc := New(1000)
+	msg := newMsg("t1", 0, 1, "foo")
+	msg.EventsCh = make(chan consumer.Event)
+
+	// When
+	mc.Put("g1", msg)
+
+	// Then
+	msg, _ = mc.Get("g1", "t1", 0, 1)
+	c.Check(msg.EventsCh, IsNil)
+}
+
+// When the cache is full, the least recently cached messages are evicted.
+func (s *MsgCacheSuite) TestEviction(c *C) {
+	mc := New(3*entryOverhead + 9)
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+	mc.Put("g1", newMsg("t1", 0, 2, "bar"))
+	mc.Put("g1", newMsg("t1", 0, 3, "bazz"))
+	// Putting a cached message again makes it the most recently cached.
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+
+	// When
+	mc.Put("g1", newMsg("t1", 0, 4, "blah"))
+
+	// Then
+	for i, tc := range []struct {
+		offset int64
+		cached bool
+	}{
+		{offset: 1, cached: true},
+		{offset: 2, cached: false},
+		{offset: 3, cached: false},
+		{offset: 4, cached: true},
+	} {
+		_, ok := mc.Get("g1", "t1", 0, tc.offset)
+		c.Check(ok, Equals, tc.cached, Commentf("case #%d", i))
+	}
+	c.Check(mc.Stats().Bytes, Equals, 2*entryOverhead+7)
+}
+
+// Messages larger than the cache are not cached.
+func (s *MsgCacheSuite) TestTooLarge(c *C) {
+	mc := New(entryOverhead + 3)
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+
+	// When
+	mc.Put("g1", newMsg("t1", 0, 2, "bazz"))
+
+	// Then
+	_, ok := mc.Get("g1", "t1", 0, 1)
+	c.Check(ok, Equals, true)
+	_, ok = mc.Get("g1", "t1", 0, 2)
+	c.Check(ok, Equals, false)
+}
+
+// Messages with offsets below the given one are removed.
+func (s *MsgCacheSuite) TestRemoveBefore(c *C) {
+	mc := New(1000)
+	mc.Put("g1", newMsg("t1", 0, 1, "foo"))
+	mc.Put("g1", newMsg("t1", 0, 2, "bar"))
+	mc.Put("g1", newMsg("t1", 0, 3, "bazz"))
+	mc.Put("g1", newMsg("t1", 1, 1, "blah"))
+
+	// When
+	mc.RemoveBefore("g1", "t1", 0, 3)
+
+	// Then
+	c.Check(mc.Stats().Messages, Equals, 2)
+	_, ok := mc.Get("g1", "t1", 0, 3)
+	c.Check(ok, Equals, true)
+	_, ok = mc.Get("g1", "t1", 1, 1)
+	c.Check(ok, Equals, true)
+}
+
+func newMsg(topic string, partition int32, offset int64, value string) consumer.Message {
+	return consumer.Message{ConsumerMessage: sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Value:     []byte(value),
+	}}
+}
@@ -2,14 +2,19 @@ package msgfetcher
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/features"
 	"github.com/mailgun/kafka-pixy/mapper"
 	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/partstats"
+	"github.com/mailgun/kafka-pixy/throttle"
+	"github.com/mailgun/kafka-pixy/topology"
 	"github.com/pkg/errors"
 )
 
@@ -59,11 +64,34 @@ var (
 )
 
 type factory struct {
+	// The number of times a fetch size was raised due to an oversized
+	// message, accessed atomically.
+	oversizedFetches int64
+
+	// The number of fetch responses throttled by brokers due to quota
+	// violations and their total throttle time, accessed atomically.
+	throttledFetches int64
+	fetchThrottleMs  int64
+
+	// The number of fetch requests sent to brokers and the total number of
+	// partition blocks in them, accessed atomically. Fetches of all
+	// partitions led by a broker are coalesced into one request, so the
+	// ratio between the two tells how well that works.
+	fetchRequests int64
+	fetchBlocks   int64
+
+	// The number of fetches retried because the partition leader moved,
+	// accessed atomically.
+	leaderRetries int64
+
 	actDesc  *actor.Descriptor
 	cfg      *config.Proxy
 	kafkaClt sarama.Client
 	mapper   *mapper.T
 
+	// Cancels the subscription to topology changes of the cluster.
+	unsubscribe func()
+
 	childrenMu sync.Mutex
 	children   map[instanceID]*msgFetcher
 }
@@ -83,7 +111,14 @@ func SpawnFactory(parentActDesc *actor.Descriptor, cfg *config.Proxy, kafkaClt s
 		kafkaClt: kafkaClt,
 		children: make(map[instanceID]*msgFetcher),
 	}
+	f.actDesc.ObserveGauge("oversized_fetches", func() int64 { return atomic.LoadInt64(&f.oversizedFetches) })
+	f.actDesc.ObserveGauge("throttled_fetches", func() int64 { return atomic.LoadInt64(&f.throttledFetches) })
+	f.actDesc.ObserveGauge("fetch_throttle_ms", func() int64 { return atomic.LoadInt64(&f.fetchThrottleMs) })
+	f.actDesc.ObserveGauge("fetch_requests", func() int64 { return atomic.LoadInt64(&f.fetchRequests) })
+	f.actDesc.ObserveGauge("fetch_blocks", func() int64 { return atomic.LoadInt64(&f.fetchBlocks) })
+	f.actDesc.ObserveGauge("leader_retries", func() int64 { return atomic.LoadInt64(&f.leaderRetries) })
 	f.mapper = mapper.Spawn(f.actDesc, cfg, f)
+	f.unsubscribe = topology.Subscribe(cfg.Cluster, f.onTopologyChange)
 	return f
 }
 
@@ -102,31 +137,59 @@ func (f *factory) Spawn(parentActDesc *actor.Descriptor, topic string, partition
 		return nil, sarama.OffsetNewest, sarama.ConfigurationError("That topic/partition is already being consumed")
 	}
 
+	prefetchCount := f.cfg.Consumer.PrefetchCount
+	if prefetchCount == 0 {
+		prefetchCount = f.cfg.Consumer.ChannelBufferSize
+	}
+	// At least one message has to be staged to be read.
+	if prefetchCount < 1 {
+		prefetchCount = 1
+	}
 	actDesc := parentActDesc.NewChild("msg_fetcher")
 	actDesc.AddLogField("kafka.topic", topic)
 	actDesc.AddLogField("kafka.partition", partition)
 	mf := &msgFetcher{
-		actDesc:      actDesc,
-		f:            f,
-		id:           id,
-		assignmentCh: make(chan mapper.Executor, 1),
-		messagesCh:   make(chan consumer.Message, f.cfg.Consumer.ChannelBufferSize),
-		stopCh:       make(chan none.T, 1),
-		offset:       realOffset,
+		actDesc:       actDesc,
+		f:             f,
+		id:            id,
+		assignmentCh:  make(chan mapper.Executor, 1),
+		messagesCh:    make(chan consumer.Message),
+		stopCh:        make(chan none.T, 1),
+		offset:        realOffset,
+		fetchSize:     int32(f.cfg.Consumer.FetchMaxBytes),
+		prefetchCount: prefetchCount,
 	}
 	if testReportErrors {
 		mf.errorsCh = make(chan error, f.cfg.Consumer.ChannelBufferSize)
 	}
 	f.children[id] = mf
+	mf.actDesc.ObserveQueue("messages", func() int { return int(atomic.LoadInt32(&mf.stagedCount)) })
+	mf.actDesc.ObserveGauge("fetch_bytes", func() int64 { return int64(atomic.LoadInt32(&mf.fetchSize)) })
 	actor.Spawn(mf.actDesc, &mf.wg, mf.run)
 	return mf, realOffset, nil
 }
 
 // implements `Factory`.
 func (f *factory) Stop() {
+	f.unsubscribe()
 	f.mapper.Stop()
 }
 
+// onTopologyChange triggers reassignment of fetchers of partitions that got
+// a new leader, so that they switch to it before a fetch from the former
+// leader fails. The children lock is held while triggering, so that a fetcher
+// cannot be reported stopped to the mapper before it is reassigned.
+func (f *factory) onTopologyChange(change topology.Change) {
+	f.childrenMu.Lock()
+	defer f.childrenMu.Unlock()
+	for id, mf := range f.children {
+		if change.Moved(id.topic, id.partition) {
+			mf.actDesc.Log().Info("Partition leader moved")
+			f.mapper.TriggerReassign(mf)
+		}
+	}
+}
+
 // implements `mapper.Resolver.ResolveBroker()`.
 func (f *factory) ResolveBroker(worker mapper.Worker) (*sarama.Broker, error) {
 	ms := worker.(*msgFetcher)
@@ -141,10 +204,12 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 	be := &brokerExecutor{
 		aggrActDesc:      f.actDesc.NewChild("broker", brokerConn.ID(), "aggr"),
 		execActDesc:      f.actDesc.NewChild("broker", brokerConn.ID(), "exec"),
+		f:                f,
 		cfg:              f.cfg,
 		conn:             brokerConn,
 		requestsCh:       make(chan fetchRq),
 		requestBatchesCh: make(chan []fetchRq),
+		stopCh:           make(chan none.T),
 	}
 	actor.Spawn(be.aggrActDesc, &be.wg, be.runAggregator)
 	actor.Spawn(be.execActDesc, &be.wg, be.runExecutor)
@@ -200,6 +265,9 @@ type msgFetcher struct {
 	f                     *factory
 	id                    instanceID
 	offset                int64
+	fetchSize             int32 // accessed atomically
+	prefetchCount         int
+	stagedCount           int32 // accessed atomically
 	assignmentCh          chan mapper.Executor
 	messagesCh            chan consumer.Message
 	errorsCh              chan error
@@ -227,8 +295,9 @@ func (mf *msgFetcher) Assignment() chan<- mapper.Executor {
 
 // pullMessages sends fetched requests to the broker executor assigned by the
 // redispatch goroutine; parses broker fetch responses and pushes parsed
-// `ConsumerMessages` to the message channel. It tries to keep the message
-// channel buffer full making fetch requests to the assigned broker as needed.
+// `ConsumerMessages` to the message channel. It keeps up to `prefetchCount`
+// fetched messages staged ahead of reads, making fetch requests to the
+// assigned broker as the staged messages are read.
 func (mf *msgFetcher) run() {
 	defer close(mf.messagesCh)
 	if mf.errorsCh != nil {
@@ -242,63 +311,89 @@ func (mf *msgFetcher) run() {
 		fetchResultCh       = make(chan fetchRs, 1)
 		nilOrFetchResultsCh <-chan fetchRs
 		nilOrMessagesCh     chan<- consumer.Message
+		stagedMessages      []consumer.Message
 		fetchedMessages     []consumer.Message
 		err                 error
 		currMessage         consumer.Message
-		currMessageIdx      int
+		outOfRange          bool
 	)
 	for {
+		// Fetch more messages if there is room for them, and offer the oldest
+		// staged message for reading if there is one.
+		mf.nilOrBrokerRequestsCh = nil
+		if nilOrFetchResultsCh == nil && len(stagedMessages) < mf.prefetchCount && !outOfRange {
+			mf.nilOrBrokerRequestsCh = mf.brokerRequestCh
+		}
+		nilOrMessagesCh = nil
+		if len(stagedMessages) > 0 {
+			currMessage = stagedMessages[0]
+			nilOrMessagesCh = mf.messagesCh
+		} else if outOfRange {
+			return
+		}
+		atomic.StoreInt32(&mf.stagedCount, int32(len(stagedMessages)))
+
+		mf.actDesc.Touch()
 		select {
 		case bw := <-mf.assignmentCh:
 			mf.actDesc.Log().Infof("Assigned executor: %s", bw)
 			be := bw.(*brokerExecutor)
 			mf.brokerRequestCh = be.requestsCh
 
-			// If there is a fetch request pending, then let it complete,
-			// otherwise trigger one.
-			if nilOrFetchResultsCh == nil && nilOrMessagesCh == nil {
-				mf.nilOrBrokerRequestsCh = mf.brokerRequestCh
-			}
-
-		case mf.nilOrBrokerRequestsCh <- fetchRq{mf.id.topic, mf.id.partition, mf.offset, fetchResultCh}:
-			mf.nilOrBrokerRequestsCh = nil
+		case mf.nilOrBrokerRequestsCh <- fetchRq{mf.id.topic, mf.id.partition, mf.offset, atomic.LoadInt32(&mf.fetchSize), fetchResultCh}:
 			nilOrFetchResultsCh = fetchResultCh
 
 		case fetchRs := <-nilOrFetchResultsCh:
 			nilOrFetchResultsCh = nil
 			if fetchedMessages, err = mf.parseFetchResponse(fetchRs); err != nil {
 				mf.reportError(err)
+				partstats.ObserveFetchError(mf.f.cfg.Cluster, mf.id.topic, mf.id.partition)
+				if err == errMessageTooLarge {
+					// Fetching the partition again with the same size would
+					// yield the same result, so unless the fetch size can be
+					// raised the partition is stuck.
+					if mf.raiseFetchSize() {
+						atomic.AddInt64(&mf.f.oversizedFetches, 1)
+						mf.actDesc.Log().Warnf("Raised fetch size: offset=%d, fetchSize=%d", mf.offset, atomic.LoadInt32(&mf.fetchSize))
+					} else {
+						mf.actDesc.Log().Errorf("Message larger than fetch size: offset=%d, fetchSize=%d", mf.offset, atomic.LoadInt32(&mf.fetchSize))
+					}
+					continue
+				}
 				if err == sarama.ErrOffsetOutOfRange {
 					mf.actDesc.Log().WithError(err).Error("Fatal request failure")
 					// There's no point in retrying this it will just fail the
-					// same way, therefore is nothing to do but give up.
-					return
+					// same way, therefore is nothing to do but give up as
+					// soon as the staged messages are read.
+					outOfRange = true
+					continue
 				}
 				mf.actDesc.Log().WithError(err).Error("Request failed")
+				if err == sarama.ErrNotLeaderForPartition || err == sarama.ErrLeaderNotAvailable {
+					atomic.AddInt64(&mf.f.leaderRetries, 1)
+				}
 				mf.brokerRequestCh = nil
 				mf.f.mapper.TriggerReassign(mf)
 				continue
 			}
 			// If no messages has been fetched, then trigger another request.
 			if len(fetchedMessages) == 0 {
-				mf.nilOrBrokerRequestsCh = mf.brokerRequestCh
 				continue
 			}
-			// Some messages have been fetched, start pushing them to the user.
-			currMessageIdx = 0
-			currMessage = fetchedMessages[currMessageIdx]
-			nilOrMessagesCh = mf.messagesCh
+			// Some messages have been fetched, so if the fetch size was raised
+			// to get past an oversized message it can be restored.
+			mf.restoreFetchSize(fetchedMessages)
+			// Messages that do not fit are dropped, they are fetched again
+			// when there is room for them.
+			if room := mf.prefetchCount - len(stagedMessages); len(fetchedMessages) > room {
+				fetchedMessages = fetchedMessages[:room]
+			}
+			stagedMessages = append(stagedMessages, fetchedMessages...)
+			mf.offset = fetchedMessages[len(fetchedMessages)-1].Offset + 1
 
 		case nilOrMessagesCh <- currMessage:
-			mf.offset = currMessage.Offset + 1
-			currMessageIdx++
-			if currMessageIdx < len(fetchedMessages) {
-				currMessage = fetchedMessages[currMessageIdx]
-				continue
-			}
-			// All messages have been pushed, trigger a new fetch request.
-			nilOrMessagesCh = nil
-			mf.nilOrBrokerRequestsCh = mf.brokerRequestCh
+			stagedMessages[0] = consumer.Message{}
+			stagedMessages = stagedMessages[1:]
 
 		case <-mf.stopCh:
 			return
@@ -336,18 +431,75 @@ func (mf *msgFetcher) parseFetchResponse(fetchRs fetchRs) ([]consumer.Message, e
 			fetchedMessages = append(fetchedMessages, mf.parseMessageSet(messageSet, highWaterMarkOffset)...)
 		}
 	}
+	// We got no messages. If we got a partial one, it means there is a
+	// producer that writes messages larger than the fetch size.
+	if len(fetchedMessages) == 0 && isPartial(fetchRsBlock) {
+		return nil, errMessageTooLarge
+	}
 	return fetchedMessages, nil
 }
 
-func (mf *msgFetcher) parseMessageSet(messageSet *sarama.MessageSet, highWaterMarkOffset int64) []consumer.Message {
-	// We got no messages. If we got a trailing one, it means there is a
-	// producer that writes messages larger then Consumer.FetchMaxBytes in size.
-	if len(messageSet.Messages) == 0 && messageSet.PartialTrailingMessage {
-		mf.actDesc.Log().Errorf("Skipped partial trailing message in set: offset=%d", mf.offset)
-		mf.reportError(errMessageTooLarge)
-		return nil
+// raiseFetchSize doubles the fetch size, but makes sure that it does not
+// exceed `consumer.fetch_max_bytes_ceiling`. It returns false if the fetch
+// size cannot be raised.
+func (mf *msgFetcher) raiseFetchSize() bool {
+	ceiling := int32(mf.f.cfg.Consumer.FetchMaxBytesCeiling)
+	fetchSize := atomic.LoadInt32(&mf.fetchSize)
+	if fetchSize >= ceiling {
+		return false
+	}
+	fetchSize *= 2
+	if fetchSize > ceiling || fetchSize <= 0 {
+		fetchSize = ceiling
+	}
+	atomic.StoreInt32(&mf.fetchSize, fetchSize)
+	return true
+}
+
+// restoreFetchSize restores `consumer.fetch_max_bytes` as the fetch size
+// after messages have been fetched. With the adaptive fetch feature enabled
+// a raised fetch size is only halved, and only if the fetched messages took
+// less than half of it, so that partitions with a run of large messages are
+// not fetched with a size that is too small for them over and over.
+func (mf *msgFetcher) restoreFetchSize(fetchedMessages []consumer.Message) {
+	floor := int32(mf.f.cfg.Consumer.FetchMaxBytes)
+	if !features.Enabled(features.AdaptiveFetch) {
+		atomic.StoreInt32(&mf.fetchSize, floor)
+		return
+	}
+	fetchSize := atomic.LoadInt32(&mf.fetchSize)
+	var fetchedBytes int
+	for _, msg := range fetchedMessages {
+		fetchedBytes += len(msg.Key) + len(msg.Value)
+	}
+	if fetchSize <= floor || int32(fetchedBytes) >= fetchSize/2 {
+		return
+	}
+	fetchSize /= 2
+	if fetchSize < floor {
+		fetchSize = floor
+	}
+	atomic.StoreInt32(&mf.fetchSize, fetchSize)
+}
+
+// isPartial tells whether a fetch response block ends with an incomplete
+// message, that is a message that does not fit into the fetch size.
+func isPartial(fetchRsBlock *sarama.FetchResponseBlock) bool {
+	if fetchRsBlock.Partial {
+		return true
 	}
+	for _, recordsSet := range fetchRsBlock.RecordsSet {
+		if recordsSet.RecordBatch != nil && recordsSet.RecordBatch.PartialTrailingRecord {
+			return true
+		}
+		if recordsSet.MsgSet != nil && recordsSet.MsgSet.PartialTrailingMessage {
+			return true
+		}
+	}
+	return false
+}
 
+func (mf *msgFetcher) parseMessageSet(messageSet *sarama.MessageSet, highWaterMarkOffset int64) []consumer.Message {
 	var fetchedMessages []consumer.Message
 	for _, msgBlock := range messageSet.Messages {
 		lastMsgIdx := len(msgBlock.Messages()) - 1
@@ -383,14 +535,6 @@ func (mf *msgFetcher) parseRecordBatch(recordBatch *sarama.RecordBatch, highWate
 		return nil
 	}
 
-	// We got no messages. If we got a trailing one, it means there is a
-	// producer that writes messages larger then Consumer.FetchMaxBytes in size.
-	if len(recordBatch.Records) == 0 && recordBatch.PartialTrailingRecord {
-		mf.actDesc.Log().Errorf("Skipped partial trailing record in batch: offset=%d", mf.offset)
-		mf.reportError(errMessageTooLarge)
-		return nil
-	}
-
 	var fetchedMessages []consumer.Message
 	for _, record := range recordBatch.Records {
 		offset := recordBatch.FirstOffset + record.OffsetDelta
@@ -441,10 +585,12 @@ func (mf *msgFetcher) String() string {
 type brokerExecutor struct {
 	aggrActDesc      *actor.Descriptor
 	execActDesc      *actor.Descriptor
+	f                *factory
 	cfg              *config.Proxy
 	conn             *sarama.Broker
 	requestsCh       chan fetchRq
 	requestBatchesCh chan []fetchRq
+	stopCh           chan none.T
 	wg               sync.WaitGroup
 }
 
@@ -452,6 +598,7 @@ type fetchRq struct {
 	Topic     string
 	Partition int32
 	Offset    int64
+	MaxBytes  int32
 	ReplyToCh chan<- fetchRs
 }
 
@@ -468,6 +615,7 @@ func (be *brokerExecutor) BrokerConn() *sarama.Broker {
 // implements `mapper.Executor`.
 func (be *brokerExecutor) Stop() {
 	close(be.requestsCh)
+	close(be.stopCh)
 	be.wg.Wait()
 }
 
@@ -500,7 +648,17 @@ func (be *brokerExecutor) runAggregator() {
 func (be *brokerExecutor) runExecutor() {
 	var lastErr error
 	var lastErrTime time.Time
+	var throttledUntil time.Time
 	for requestBatch := range be.requestBatchesCh {
+		// If the broker throttled the previous response due to a quota
+		// violation, then it expects no requests until the throttle time
+		// elapses. Fetch requests keep being aggregated in the meantime.
+		if throttleLeft := time.Until(throttledUntil); throttleLeft > 0 {
+			select {
+			case <-time.After(throttleLeft):
+			case <-be.stopCh:
+			}
+		}
 		// Reject consume requests for awhile after a connection failure to
 		// allow the Kafka cluster some time to recuperate.
 		if time.Since(lastErrTime) < be.cfg.Consumer.RetryBackoff {
@@ -527,8 +685,10 @@ func (be *brokerExecutor) runExecutor() {
 		}
 
 		for _, fr := range requestBatch {
-			kafkaFetchRq.AddBlock(fr.Topic, fr.Partition, fr.Offset, int32(be.cfg.Consumer.FetchMaxBytes))
+			kafkaFetchRq.AddBlock(fr.Topic, fr.Partition, fr.Offset, fr.MaxBytes)
 		}
+		atomic.AddInt64(&be.f.fetchRequests, 1)
+		atomic.AddInt64(&be.f.fetchBlocks, int64(len(requestBatch)))
 		var kafkaFetchRs *sarama.FetchResponse
 		kafkaFetchRs, lastErr = be.conn.Fetch(kafkaFetchRq)
 		if lastErr != nil {
@@ -536,6 +696,13 @@ func (be *brokerExecutor) runExecutor() {
 			be.conn.Close()
 			be.execActDesc.Log().WithError(lastErr).Info("Connection reset")
 		}
+		if kafkaFetchRs != nil && kafkaFetchRs.ThrottleTime > 0 {
+			throttledUntil = time.Now().Add(kafkaFetchRs.ThrottleTime)
+			atomic.AddInt64(&be.f.throttledFetches, 1)
+			atomic.AddInt64(&be.f.fetchThrottleMs, int64(kafkaFetchRs.ThrottleTime/time.Millisecond))
+			throttle.ObserveFetch(be.cfg.Cluster, kafkaFetchRs.ThrottleTime)
+			be.execActDesc.Log().Warnf("Fetch throttled by broker: throttleTime=%v", kafkaFetchRs.ThrottleTime)
+		}
 		// Fan the response out to the message streams.
 		for _, fr := range requestBatch {
 			fr.ReplyToCh <- fetchRs{kafkaFetchRs, lastErr}
@@ -2,6 +2,7 @@ package msgfetcher
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/features"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -87,6 +89,133 @@ func (s *MsgFetcherSuite) TestOffsetManual(c *C) {
 	}
 }
 
+// No more than `consumer.prefetch_count` messages are staged ahead of reads
+// regardless of the channel buffer size and the number of messages in a fetch
+// response. Messages that do not fit are fetched again later.
+func (s *MsgFetcherSuite) TestPrefetchCount(c *C) {
+	s.cfg.Consumer.PrefetchCount = 3
+	mockFetchResponse := sarama.NewMockFetchResponse(c, 10)
+	for i := 0; i < 10; i++ {
+		mockFetchResponse.SetMessage("my_topic", 0, int64(i+1234), testMsg)
+	}
+	s.broker0.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(s.broker0.Addr(), s.broker0.BrokerID()).
+			SetLeader("my_topic", 0, s.broker0.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(c).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 2345),
+		"FetchRequest": mockFetchResponse,
+	})
+	kafkaClt, _ := sarama.NewClient([]string{s.broker0.Addr()}, s.cfg.SaramaClientCfg())
+	defer kafkaClt.Close()
+	f := SpawnFactory(s.ns, s.cfg, kafkaClt)
+	defer f.Stop()
+
+	// When
+	mf, _, err := f.Spawn(s.ns.NewChild("my_topic", 0), "my_topic", 0, 1234)
+	c.Assert(err, IsNil)
+	defer mf.Stop()
+
+	// Then
+	stagedCount := func() int32 { return atomic.LoadInt32(&mf.(*msgFetcher).stagedCount) }
+	for i := 0; i < 100 && stagedCount() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(stagedCount(), Equals, int32(3))
+	// Give the fetcher a chance to overfill.
+	time.Sleep(100 * time.Millisecond)
+	c.Check(stagedCount(), Equals, int32(3))
+
+	for i := 0; i < 10; i++ {
+		select {
+		case message := <-mf.Messages():
+			c.Assert(message.Offset, Equals, int64(i+1234))
+		case err := <-mf.(*msgFetcher).errorsCh:
+			c.Error(err)
+		}
+		c.Check(stagedCount() <= 3, Equals, true)
+	}
+}
+
+// If a fetch response contains nothing but a partial message then the message
+// is larger than the fetch size.
+func (s *MsgFetcherSuite) TestParseFetchResponseTooLarge(c *C) {
+	mf := &msgFetcher{actDesc: s.ns, id: instanceID{"my_topic", 0}}
+	for i, block := range []*sarama.FetchResponseBlock{
+		{Partial: true},
+		{RecordsSet: []*sarama.Records{{MsgSet: &sarama.MessageSet{PartialTrailingMessage: true}}}},
+		{RecordsSet: []*sarama.Records{{RecordBatch: &sarama.RecordBatch{PartialTrailingRecord: true}}}},
+	} {
+		fetchRs := fetchRs{kafkaRs: &sarama.FetchResponse{
+			Blocks: map[string]map[int32]*sarama.FetchResponseBlock{"my_topic": {0: block}},
+		}}
+
+		// When
+		messages, err := mf.parseFetchResponse(fetchRs)
+
+		// Then
+		c.Check(messages, IsNil, Commentf("case #%d", i))
+		c.Check(err, Equals, errMessageTooLarge, Commentf("case #%d", i))
+	}
+}
+
+// Fetch size is doubled, but never exceeds the configured ceiling.
+func (s *MsgFetcherSuite) TestRaiseFetchSize(c *C) {
+	s.cfg.Consumer.FetchMaxBytes = 1000
+	s.cfg.Consumer.FetchMaxBytesCeiling = 3000
+	mf := &msgFetcher{f: &factory{cfg: s.cfg}, fetchSize: 1000}
+
+	c.Check(mf.raiseFetchSize(), Equals, true)
+	c.Check(mf.fetchSize, Equals, int32(2000))
+	c.Check(mf.raiseFetchSize(), Equals, true)
+	c.Check(mf.fetchSize, Equals, int32(3000))
+	c.Check(mf.raiseFetchSize(), Equals, false)
+	c.Check(mf.fetchSize, Equals, int32(3000))
+
+	// If the ceiling is not set, then fetch size is never raised.
+	s.cfg.Consumer.FetchMaxBytesCeiling = 0
+	mf.fetchSize = 1000
+	c.Check(mf.raiseFetchSize(), Equals, false)
+	c.Check(mf.fetchSize, Equals, int32(1000))
+}
+
+// By default a raised fetch size is restored as soon as messages are fetched.
+// With the adaptive fetch feature it is halved if the fetched messages took
+// less than half of it, but never goes below the configured one.
+func (s *MsgFetcherSuite) TestRestoreFetchSize(c *C) {
+	defer features.Configure(nil)
+	s.cfg.Consumer.FetchMaxBytes = 1000
+	mf := &msgFetcher{f: &factory{cfg: s.cfg}}
+	small := []consumer.Message{{ConsumerMessage: sarama.ConsumerMessage{Value: make([]byte, 100)}}}
+	large := []consumer.Message{
+		{ConsumerMessage: sarama.ConsumerMessage{Value: make([]byte, 1500)}},
+		{ConsumerMessage: sarama.ConsumerMessage{Value: make([]byte, 500)}},
+	}
+
+	for i, tc := range []struct {
+		adaptive  bool
+		fetchSize int32
+		fetched   []consumer.Message
+		restored  int32
+	}{
+		{adaptive: false, fetchSize: 4000, fetched: large, restored: 1000},
+		{adaptive: true, fetchSize: 4000, fetched: large, restored: 4000},
+		{adaptive: true, fetchSize: 4000, fetched: small, restored: 2000},
+		{adaptive: true, fetchSize: 1500, fetched: small, restored: 1000},
+		{adaptive: true, fetchSize: 1000, fetched: small, restored: 1000},
+	} {
+		c.Assert(features.Configure(map[string]bool{features.AdaptiveFetch: tc.adaptive}), IsNil)
+		mf.fetchSize = tc.fetchSize
+
+		// When
+		mf.restoreFetchSize(tc.fetched)
+
+		// Then
+		c.Check(mf.fetchSize, Equals, tc.restored, Commentf("case #%d", i))
+	}
+}
+
 // If `sarama.OffsetNewest` is passed as the initial offset then the first consumed
 // message is indeed corresponds to the offset that broker claims to be the
 // newest in its metadata response.
@@ -653,6 +782,56 @@ func (s *MsgFetcherSuite) TestRebalancingMultiplePartitions(c *C) {
 // When two partitions have the same broker as the leader, if one partition
 // consumer channel buffer is full then that does not affect the ability to
 // read messages by the other consumer.
+// Fetch requests sent to a broker and partition blocks in them are counted.
+func (s *MsgFetcherSuite) TestCoalescedFetches(c *C) {
+	mockFetchResponse := sarama.NewMockFetchResponse(c, 1)
+	for i := 0; i < 10; i++ {
+		mockFetchResponse.SetMessage("my_topic", 0, int64(i+1000), testMsg)
+		mockFetchResponse.SetMessage("my_topic", 1, int64(i+2000), testMsg)
+	}
+	s.broker0.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(s.broker0.Addr(), s.broker0.BrokerID()).
+			SetLeader("my_topic", 0, s.broker0.BrokerID()).
+			SetLeader("my_topic", 1, s.broker0.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(c).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 1000).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 1100).
+			SetOffset("my_topic", 1, sarama.OffsetOldest, 2000).
+			SetOffset("my_topic", 1, sarama.OffsetNewest, 2100),
+		"FetchRequest": mockFetchResponse,
+	})
+	kafkaClt, _ := sarama.NewClient([]string{s.broker0.Addr()}, s.cfg.SaramaClientCfg())
+	defer kafkaClt.Close()
+	f := SpawnFactory(s.ns, s.cfg, kafkaClt)
+	mf0, _, err := f.Spawn(s.ns.NewChild("my_topic", 0), "my_topic", 0, 1000)
+	c.Assert(err, IsNil)
+	mf1, _, err := f.Spawn(s.ns.NewChild("my_topic", 1), "my_topic", 1, 2000)
+	c.Assert(err, IsNil)
+
+	// When
+	for i := 0; i < 10; i++ {
+		c.Assert((<-mf0.Messages()).Offset, Equals, int64(i+1000))
+		c.Assert((<-mf1.Messages()).Offset, Equals, int64(i+2000))
+	}
+	mf0.Stop()
+	mf1.Stop()
+	f.Stop()
+
+	// Then
+	fetchRequests := 0
+	for _, rr := range s.broker0.History() {
+		if _, ok := rr.Request.(*sarama.FetchRequest); ok {
+			fetchRequests++
+		}
+	}
+	ff := f.(*factory)
+	c.Check(atomic.LoadInt64(&ff.fetchRequests), Equals, int64(fetchRequests))
+	// Every partition needs at least 10 fetches to get its messages.
+	c.Check(atomic.LoadInt64(&ff.fetchBlocks) >= 20, Equals, true)
+	c.Check(atomic.LoadInt64(&ff.fetchBlocks) >= int64(fetchRequests), Equals, true)
+}
+
 func (s *MsgFetcherSuite) TestInterleavedClose(c *C) {
 	s.broker0.SetHandlerByMap(map[string]sarama.MockResponse{
 		"MetadataRequest": sarama.NewMockMetadataResponse(c).
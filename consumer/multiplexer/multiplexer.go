@@ -1,28 +1,33 @@
 package multiplexer
 
 import (
+	"math/bits"
 	"reflect"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/none"
 )
 
-// T fetches messages from inputs and multiplexes them to the output, giving
-// preferences to inputs with higher lag. Multiplexer assumes ownership over
-// inputs in the sense that it decides when an new input instance needs to be
-// started, or the old one stopped.
+// T fetches messages from inputs and multiplexes them to the output in the
+// order defined by a policy, by default giving preferences to inputs with
+// higher lag. Multiplexer assumes ownership over inputs in the sense that it
+// decides when an new input instance needs to be started, or the old one
+// stopped.
 type T struct {
-	actDesc   *actor.Descriptor
-	spawnInFn SpawnInFn
-	inputs    map[int32]*input
-	output    Out
-	isRunning bool
-	stopCh    chan none.T
-	wg        sync.WaitGroup
+	actDesc       *actor.Descriptor
+	spawnInFn     SpawnInFn
+	selectInputFn selectInputFn
+	inputs        map[int32]*input
+	output        Out
+	window        time.Duration
+	isRunning     bool
+	stopCh        chan none.T
+	wg            sync.WaitGroup
 
 	sortedInsMu sync.Mutex
 	sortedIns   []*input
@@ -49,18 +54,55 @@ type Out interface {
 	Messages() chan<- consumer.Message
 }
 
+// AffineOut is an output that can ask the multiplexer to give the next
+// message from a particular partition, if one is available. It is supposed to
+// send a partition to the affinity channel right before it reads the next
+// message from the output channel.
+type AffineOut interface {
+	Out
+
+	// Affinity returns a channel that multiplexer receives preferred
+	// partitions from.
+	Affinity() <-chan int32
+}
+
 // SpawnInFn is a function type that is used by multiplexer to spawn inputs for
 // assigned partitions during rewiring.
 type SpawnInFn func(partition int32) In
 
-// New creates a new multiplexer instance.
-func New(parentActDesc *actor.Descriptor, spawnInFn SpawnInFn) *T {
-	return &T{
-		actDesc:   parentActDesc.NewChild("mux"),
-		inputs:    make(map[int32]*input),
-		spawnInFn: spawnInFn,
-		stopCh:    make(chan none.T),
+// selectInputFn is a function type that picks an input that should be
+// multiplexed next given the previously selected one.
+type selectInputFn func(prevSelectedIdx int, sortedIns []*input) int
+
+// New creates a new multiplexer instance that multiplexes inputs with the
+// specified policy, one of `config.MuxPolicy*`. An empty or unknown policy
+// means `config.MuxPolicyLag`.
+func New(parentActDesc *actor.Descriptor, spawnInFn SpawnInFn, policy string) *T {
+	m := &T{
+		actDesc:       parentActDesc.NewChild("mux"),
+		inputs:        make(map[int32]*input),
+		spawnInFn:     spawnInFn,
+		selectInputFn: selectInput,
+		stopCh:        make(chan none.T),
+	}
+	switch policy {
+	case config.MuxPolicyRoundRobin:
+		m.selectInputFn = selectInputRoundRobin
+	case config.MuxPolicyWeighted:
+		m.selectInputFn = selectInputWeighted
+	case config.MuxPolicyTimestamp:
+		m.selectInputFn = selectInputTimestamp
 	}
+	return m
+}
+
+// SetWindow makes the multiplexer hold messages back for up to the window
+// while inputs that got empty within the window may yet fetch messages that
+// should go before them. It is used with the timestamp policy to emit
+// messages in approximate timestamp order across partitions. It must be
+// called before the multiplexer is wired up.
+func (m *T) SetWindow(window time.Duration) {
+	m.window = window
 }
 
 // input represents a multiplexer input along with a message to be fetched from
@@ -70,6 +112,13 @@ type input struct {
 	partition int32
 	msg       consumer.Message
 	msgOk     bool
+
+	// When the input last got without a message available, as used when
+	// messages are held back for the window.
+	emptySince time.Time
+
+	// Current weight of the input as used by selectInputWeighted.
+	credit int64
 }
 
 // IsRunning returns `true` if multiplexer is running pumping events from the
@@ -136,7 +185,7 @@ func (m *T) WireUp(output Out, assigned []int32) {
 	// first input.
 	for _, p := range assigned {
 		if _, ok := m.inputs[p]; !ok {
-			m.inputs[p] = &input{In: m.spawnInFn(p), partition: p}
+			m.inputs[p] = &input{In: m.spawnInFn(p), partition: p, emptySince: time.Now()}
 		}
 	}
 	m.refreshSortedIns()
@@ -197,6 +246,12 @@ reset:
 	}
 	selectCases[inputCount] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.stopCh)}
 
+	// Affinity of outputs that do not support it is never received from.
+	var affinityCh <-chan int32
+	if affineOut, ok := m.output.(AffineOut); ok {
+		affinityCh = affineOut.Affinity()
+	}
+
 	inputIdx := -1
 	for {
 		// Collect next messages from inputs that have them available.
@@ -233,19 +288,110 @@ reset:
 			m.sortedIns[idx].msg = value.Interface().(consumer.Message)
 			m.sortedIns[idx].msgOk = true
 		}
+		if m.window > 0 {
+			waited, stopped, closed := m.awaitEmptyInputs()
+			switch {
+			case stopped:
+				return
+			case closed:
+				goto reset
+			case waited:
+				continue
+			}
+		}
 		// At this point there is at least one message available.
-		inputIdx = selectInput(inputIdx, m.sortedIns)
+		inputIdx = m.selectInputFn(inputIdx, m.sortedIns)
 		// Block until the output reads the next message of the selected input
-		// or a stop signal is received.
+		// or a stop signal is received. If the output asks for a message from
+		// a particular partition in the meantime, then the input of that
+		// partition is selected instead, provided it has a message available.
+	send:
 		select {
 		case <-m.stopCh:
 			return
 		case m.output.Messages() <- m.sortedIns[inputIdx].msg:
 			m.sortedIns[inputIdx].msgOk = false
+			m.sortedIns[inputIdx].emptySince = time.Now()
+		case partition := <-affinityCh:
+			if idx := m.affineInput(partition); idx != -1 {
+				inputIdx = idx
+			}
+			goto send
 		}
 	}
 }
 
+// awaitEmptyInputs waits until one of the inputs that got empty within the
+// window fetches a message, or the earliest of their windows expires. It
+// returns false right away if there are no such inputs. If a stop signal is
+// received, or a channel of an input is closed in the meantime, then it tells
+// so, and in the latter case the input is removed.
+func (m *T) awaitEmptyInputs() (waited, stopped, closed bool) {
+	now := time.Now()
+	var deadline time.Time
+	var awaitedIns []*input
+	var selectCases []reflect.SelectCase
+	for _, in := range m.sortedIns {
+		if in.msgOk || now.Sub(in.emptySince) >= m.window {
+			continue
+		}
+		if inDeadline := in.emptySince.Add(m.window); deadline.IsZero() || inDeadline.Before(deadline) {
+			deadline = inDeadline
+		}
+		awaitedIns = append(awaitedIns, in)
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(in.Messages())})
+	}
+	if len(awaitedIns) == 0 {
+		return false, false, false
+	}
+	timer := time.NewTimer(deadline.Sub(now))
+	defer timer.Stop()
+	selectCases = append(selectCases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.stopCh)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	idx, value, ok := reflect.Select(selectCases)
+	switch {
+	case idx == len(awaitedIns):
+		return false, true, false
+	case idx > len(awaitedIns):
+		return true, false, false
+	case !ok:
+		in := awaitedIns[idx]
+		m.actDesc.Log().Infof("input channel closed: partition=%d", in.partition)
+		delete(m.inputs, in.partition)
+		m.refreshSortedIns()
+		return false, false, true
+	}
+	awaitedIns[idx].msg = value.Interface().(consumer.Message)
+	awaitedIns[idx].msgOk = true
+	return true, false, false
+}
+
+// affineInput returns the index of the input of the specified partition if it
+// has a message available, or -1 otherwise.
+func (m *T) affineInput(partition int32) int {
+	for i, in := range m.sortedIns {
+		if in.partition != partition {
+			continue
+		}
+		if !in.msgOk {
+			select {
+			case msg, ok := <-in.Messages():
+				// A closed input is removed by the main loop.
+				if !ok {
+					return -1
+				}
+				in.msg = msg
+				in.msgOk = true
+			default:
+				return -1
+			}
+		}
+		return i
+	}
+	return -1
+}
+
 // makeSortedIns given a partition->input map returns a slice of all the inputs
 // from the map sorted in ascending order of partition ids.
 func makeSortedIns(inputs map[int32]*input) []*input {
@@ -297,3 +443,62 @@ func selectInput(prevSelectedIdx int, sortedIns []*input) int {
 	}
 	return selectedIdx
 }
+
+// selectInputRoundRobin picks the first input that has a message available
+// following prevSelectedIdx, wrapping around the end of the list.
+func selectInputRoundRobin(prevSelectedIdx int, sortedIns []*input) int {
+	count := len(sortedIns)
+	if prevSelectedIdx < -1 || prevSelectedIdx >= count {
+		prevSelectedIdx = -1
+	}
+	for i := 1; i <= count; i++ {
+		idx := (prevSelectedIdx + i) % count
+		if sortedIns[idx].msgOk {
+			return idx
+		}
+	}
+	return -1
+}
+
+// selectInputTimestamp picks the input with the oldest message available. If
+// there is more than one, then the one with the lowest partition is picked.
+func selectInputTimestamp(_ int, sortedIns []*input) int {
+	selectedIdx := -1
+	for i, input := range sortedIns {
+		if !input.msgOk {
+			continue
+		}
+		if selectedIdx == -1 || input.msg.Timestamp.Before(sortedIns[selectedIdx].msg.Timestamp) {
+			selectedIdx = i
+		}
+	}
+	return selectedIdx
+}
+
+// selectInputWeighted picks inputs that have messages available with the
+// smooth weighted round-robin algorithm, where the weight of an input grows
+// with the logarithm of its lag. So lagging inputs are selected more often
+// but never starve the others.
+func selectInputWeighted(_ int, sortedIns []*input) int {
+	var totalWeight int64
+	selectedIdx := -1
+	for i, input := range sortedIns {
+		if !input.msgOk {
+			continue
+		}
+		lag := input.msg.HighWaterMark - input.msg.Offset
+		if lag < 0 {
+			lag = 0
+		}
+		weight := int64(1 + bits.Len64(uint64(lag)))
+		input.credit += weight
+		totalWeight += weight
+		if selectedIdx == -1 || input.credit > sortedIns[selectedIdx].credit {
+			selectedIdx = i
+		}
+	}
+	if selectedIdx != -1 {
+		sortedIns[selectedIdx].credit -= totalWeight
+	}
+	return selectedIdx
+}
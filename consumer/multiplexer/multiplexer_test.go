@@ -7,6 +7,7 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	. "gopkg.in/check.v1"
@@ -95,6 +96,62 @@ func (s *MultiplexerSuite) TestSelectInputNone(c *C) {
 	c.Assert(selectInput(100, inputs), Equals, -1)
 }
 
+// With the round robin policy inputs that have messages take turns regardless
+// of their lag.
+func (s *MultiplexerSuite) TestSelectInputRoundRobin(c *C) {
+	inputs := []*input{
+		{},
+		{msg: lag(11), msgOk: true},
+		{msg: lag(1000), msgOk: true},
+		{},
+		{msg: lag(12), msgOk: true},
+	}
+	c.Assert(selectInputRoundRobin(-1, inputs), Equals, 1)
+	c.Assert(selectInputRoundRobin(1, inputs), Equals, 2)
+	c.Assert(selectInputRoundRobin(2, inputs), Equals, 4)
+	c.Assert(selectInputRoundRobin(3, inputs), Equals, 4)
+	c.Assert(selectInputRoundRobin(4, inputs), Equals, 1)
+	c.Assert(selectInputRoundRobin(100, inputs), Equals, 1)
+	c.Assert(selectInputRoundRobin(-1, []*input{{}, {}}), Equals, -1)
+}
+
+// With the weighted policy inputs are selected in proportion to their
+// weights, that are 1 plus the number of bits in their lag, and inputs that
+// have no messages are skipped.
+func (s *MultiplexerSuite) TestSelectInputWeighted(c *C) {
+	inputs := []*input{
+		{msg: lag(1), msgOk: true},
+		{},
+		{msg: lag(1000), msgOk: true},
+	}
+	selected := make(map[int]int)
+	var sequence []int
+	for i := 0; i < 13; i++ {
+		idx := selectInputWeighted(-1, inputs)
+		selected[idx]++
+		sequence = append(sequence, idx)
+	}
+	c.Check(selected, DeepEquals, map[int]int{0: 2, 2: 11})
+	// The low lag input is not made to wait until the end of the cycle.
+	c.Check(sequence, DeepEquals, []int{2, 2, 2, 0, 2, 2, 2, 2, 2, 0, 2, 2, 2})
+	c.Assert(selectInputWeighted(-1, []*input{{}, {}}), Equals, -1)
+}
+
+// With the timestamp policy the input with the oldest message is selected,
+// and inputs that have no messages are skipped.
+func (s *MultiplexerSuite) TestSelectInputTimestamp(c *C) {
+	inputs := []*input{
+		{},
+		{msg: stamped(1001, 3), msgOk: true},
+		{msg: stamped(2001, 2), msgOk: true},
+		{},
+		{msg: stamped(4001, 2), msgOk: true},
+	}
+	c.Assert(selectInputTimestamp(-1, inputs), Equals, 2)
+	c.Assert(selectInputTimestamp(2, inputs), Equals, 2)
+	c.Assert(selectInputTimestamp(-1, []*input{{}, {}}), Equals, -1)
+}
+
 // If there is just one input then it is forwarded to the output.
 func (s *MultiplexerSuite) TestOneInput(c *C) {
 	ins := map[int32]In{
@@ -105,7 +162,7 @@ func (s *MultiplexerSuite) TestOneInput(c *C) {
 		),
 	}
 	out := newMockOut(100)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 
 	// When
@@ -117,6 +174,34 @@ func (s *MultiplexerSuite) TestOneInput(c *C) {
 	checkMsg(c, out.messagesCh, msg(1003, 1))
 }
 
+// If an output asks for a partition, then it gets a message from that
+// partition if there is one available, and from any other partition otherwise.
+func (s *MultiplexerSuite) TestAffinity(c *C) {
+	ins := map[int32]In{
+		1: newMockIn(
+			msg(1001, 1),
+			msg(1002, 1),
+		),
+		2: newMockIn(
+			msg(2001, 1),
+		),
+	}
+	out := newMockAffineOut()
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
+	defer m.Stop()
+
+	// When
+	m.WireUp(out, []int32{1, 2})
+
+	// Then
+	out.affinityCh <- 2
+	checkMsg(c, out.messagesCh, msg(2001, 1))
+	out.affinityCh <- 2
+	checkMsg(c, out.messagesCh, msg(1001, 1))
+	out.affinityCh <- 3
+	checkMsg(c, out.messagesCh, msg(1002, 1))
+}
+
 // If there are several inputs with the same max lag then messages from them
 // are multiplexed in the round robin fashion. Note that messages are acknowledged
 func (s *MultiplexerSuite) TestSameLag(c *C) {
@@ -141,7 +226,7 @@ func (s *MultiplexerSuite) TestSameLag(c *C) {
 			msg(4001, 1),
 		)}
 	out := newMockOut(100)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 
 	// When
@@ -182,7 +267,7 @@ func (s *MultiplexerSuite) TestLargeLagPreferred(c *C) {
 		),
 	}
 	out := newMockOut(100)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	m.Stop()
 
 	// When
@@ -197,6 +282,91 @@ func (s *MultiplexerSuite) TestLargeLagPreferred(c *C) {
 	checkMsg(c, out.messagesCh, msg(2002, 1))
 }
 
+// With the round robin policy a partition with a large lag does not delay
+// messages of the others.
+func (s *MultiplexerSuite) TestRoundRobin(c *C) {
+	ins := map[int32]In{
+		1: newMockIn(
+			msg(1001, 1000),
+			msg(1002, 999),
+			msg(1003, 998),
+		),
+		2: newMockIn(
+			msg(2001, 2),
+			msg(2002, 1),
+		),
+	}
+	out := newMockOut(100)
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyRoundRobin)
+	defer m.Stop()
+
+	// When
+	m.WireUp(out, []int32{1, 2})
+
+	// Then
+	checkMsg(c, out.messagesCh, msg(1001, 1000))
+	checkMsg(c, out.messagesCh, msg(2001, 2))
+	checkMsg(c, out.messagesCh, msg(1002, 999))
+	checkMsg(c, out.messagesCh, msg(2002, 1))
+	checkMsg(c, out.messagesCh, msg(1003, 998))
+}
+
+// With the timestamp policy messages of all inputs are merged in timestamp
+// order, and once an input runs out of messages the others are not held back
+// for longer than the window.
+func (s *MultiplexerSuite) TestTimestamp(c *C) {
+	ins := map[int32]In{
+		1: newMockIn(
+			stamped(1001, 1),
+			stamped(1002, 3),
+			stamped(1003, 5),
+		),
+		2: newMockIn(
+			stamped(2001, 2),
+			stamped(2002, 4),
+		),
+	}
+	out := newMockOut(100)
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyTimestamp)
+	m.SetWindow(100 * time.Millisecond)
+	defer m.Stop()
+
+	// When
+	m.WireUp(out, []int32{1, 2})
+
+	// Then
+	checkMsg(c, out.messagesCh, stamped(1001, 1))
+	checkMsg(c, out.messagesCh, stamped(2001, 2))
+	checkMsg(c, out.messagesCh, stamped(1002, 3))
+	checkMsg(c, out.messagesCh, stamped(2002, 4))
+	begin := time.Now()
+	checkMsg(c, out.messagesCh, stamped(1003, 5))
+	c.Check(time.Since(begin) >= 90*time.Millisecond, Equals, true)
+}
+
+// If an input that has just run out of messages fetches an older message
+// within the window, then it goes first.
+func (s *MultiplexerSuite) TestTimestampLateMessage(c *C) {
+	late := &mockIn{messagesCh: make(chan consumer.Message, 1)}
+	ins := map[int32]In{
+		1: newMockIn(stamped(1001, 2)),
+		2: late,
+	}
+	out := newMockOut(100)
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyTimestamp)
+	m.SetWindow(time.Second)
+	defer m.Stop()
+
+	// When
+	m.WireUp(out, []int32{1, 2})
+	time.Sleep(50 * time.Millisecond)
+	late.messagesCh <- stamped(2001, 1)
+
+	// Then
+	checkMsg(c, out.messagesCh, stamped(2001, 1))
+	checkMsg(c, out.messagesCh, stamped(1001, 2))
+}
+
 // If there are no messages available on the inputs, multiplexer blocks waiting
 // for a message to appear in any of the inputs.
 func (s *MultiplexerSuite) TestNoMessages(c *C) {
@@ -207,7 +377,7 @@ func (s *MultiplexerSuite) TestNoMessages(c *C) {
 		3: newMockIn(),
 	}
 	out := newMockOut(100)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	m.WireUp(out, []int32{1, 2, 3})
 
@@ -235,7 +405,7 @@ func (s *MultiplexerSuite) TestWireUp(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(100)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 
@@ -262,7 +432,7 @@ func (s *MultiplexerSuite) TestWireUpAdd(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -291,7 +461,7 @@ func (s *MultiplexerSuite) TestWireUpRemove(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -318,7 +488,7 @@ func (s *MultiplexerSuite) TestWireUpAddRemove(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -346,7 +516,7 @@ func (s *MultiplexerSuite) TestWireUpSame(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -374,7 +544,7 @@ func (s *MultiplexerSuite) TestWireUpEmpty(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -400,7 +570,7 @@ func (s *MultiplexerSuite) TestWireUpNil(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out, []int32{2, 4})
@@ -427,7 +597,7 @@ func (s *MultiplexerSuite) TestWireUpOutChanged(c *C) {
 	}
 	out1 := newMockOut(0)
 	out2 := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out1, []int32{2, 4})
@@ -460,7 +630,7 @@ func (s *MultiplexerSuite) TestWireUpOutNil(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out1 := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	c.Assert(m.IsRunning(), Equals, false)
 	m.WireUp(out1, []int32{2, 4})
@@ -486,7 +656,7 @@ func (s *MultiplexerSuite) TestStop(c *C) {
 		5: newMockIn(msg(5001, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	m.WireUp(out, []int32{2, 4})
 	c.Assert(m.IsRunning(), Equals, true)
 
@@ -517,7 +687,7 @@ func (s *MultiplexerSuite) TestInputChanClose(c *C) {
 			msg(3003, 1)),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	defer m.Stop()
 	m.WireUp(out, []int32{1, 2, 3})
 	c.Assert(m.IsRunning(), Equals, true)
@@ -546,7 +716,7 @@ func (s *MultiplexerSuite) TestIsSafe2Stop(c *C) {
 		5: newMockIn(),
 	}
 	out := newMockOut(0)
-	m := New(s.ns, func(p int32) In { return ins[p] })
+	m := New(s.ns, func(p int32) In { return ins[p] }, config.MuxPolicyLag)
 	for i, tc := range []struct {
 		inputs    []int32
 		safe2Stop bool
@@ -630,6 +800,23 @@ func (mo *mockOut) Messages() chan<- consumer.Message {
 	return mo.messagesCh
 }
 
+type mockAffineOut struct {
+	mockOut
+	affinityCh chan int32
+}
+
+func newMockAffineOut() *mockAffineOut {
+	return &mockAffineOut{
+		mockOut:    mockOut{messagesCh: make(chan consumer.Message)},
+		affinityCh: make(chan int32),
+	}
+}
+
+// implements `AffineOut`
+func (mo *mockAffineOut) Affinity() <-chan int32 {
+	return mo.affinityCh
+}
+
 func msg(offset, lag int64) consumer.Message {
 	return consumer.Message{
 		ConsumerMessage: sarama.ConsumerMessage{Offset: offset},
@@ -644,6 +831,13 @@ func lag(lag int64) consumer.Message {
 	}
 }
 
+// stamped returns a message with a timestamp that many seconds past epoch.
+func stamped(offset, sec int64) consumer.Message {
+	return consumer.Message{
+		ConsumerMessage: sarama.ConsumerMessage{Offset: offset, Timestamp: time.Unix(sec, 0)},
+	}
+}
+
 func checkMsg(c *C, outCh chan consumer.Message, want consumer.Message) {
 	c.Assert(<-outCh, DeepEquals, want)
 }
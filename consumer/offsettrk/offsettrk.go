@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
@@ -14,6 +15,10 @@ import (
 
 const (
 	base64EncodeMap = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+	// Separates encoded sparse acks from application metadata in offset
+	// metadata. It is not in the encoding alphabet of sparse acks.
+	appMetaSep = "|"
 )
 
 var (
@@ -33,6 +38,18 @@ func init() {
 	}
 }
 
+// Offer describes a message offered to a client but not acknowledged yet.
+type Offer struct {
+	Offset int64
+	// When the message was first offered.
+	OfferedAt time.Time
+	// How many times the message has been offered, not counting offers
+	// reclaimed from clients that disconnected.
+	Deliveries int
+	// The ID of the consume request that the message was offered to last.
+	RequestID string
+}
+
 // T represents an entity that tracks offered and acknowledged messages and
 // maintains offset data for the current state.
 type T struct {
@@ -40,14 +57,18 @@ type T struct {
 	offerTimeout time.Duration
 	offset       offsetmgr.Offset
 	ackedRanges  []offsetRange
+	appMeta      string
 	offers       []offer
+	reclaimed    int
+	extended     int
 }
 
 // SparseAcks2Str returns human readable representation of sparsely committed
 // ranges encoded in the specified offset metadata.
 func SparseAcks2Str(offset offsetmgr.Offset) string {
 	var buf bytes.Buffer
-	ackedRanges, _ := decodeAckedRanges(offset.Val, offset.Meta)
+	sparseAcks, _ := SplitMeta(offset.Meta)
+	ackedRanges, _ := decodeAckedRanges(offset.Val, sparseAcks)
 	for i, ar := range ackedRanges {
 		if i != 0 {
 			buf.WriteString(",")
@@ -59,6 +80,22 @@ func SparseAcks2Str(offset offsetmgr.Offset) string {
 	return buf.String()
 }
 
+// SplitMeta splits offset metadata into encoded sparse acks and application
+// metadata that the latest ack carried.
+func SplitMeta(meta string) (sparseAcks, appMeta string) {
+	if i := strings.Index(meta, appMetaSep); i >= 0 {
+		return meta[:i], meta[i+len(appMetaSep):]
+	}
+	return meta, ""
+}
+
+func joinMeta(sparseAcks, appMeta string) string {
+	if appMeta == "" {
+		return sparseAcks
+	}
+	return sparseAcks + appMetaSep + appMeta
+}
+
 // New creates a new offset tracker instance.
 func New(actDesc *actor.Descriptor, offset offsetmgr.Offset, offerTimeout time.Duration) *T {
 	ot := T{
@@ -66,11 +103,15 @@ func New(actDesc *actor.Descriptor, offset offsetmgr.Offset, offerTimeout time.D
 		offerTimeout: offerTimeout,
 		offset:       offset,
 	}
-	var err error
-	ot.ackedRanges, err = decodeAckedRanges(offset.Val, offset.Meta)
+	var (
+		sparseAcks string
+		err        error
+	)
+	sparseAcks, ot.appMeta = SplitMeta(offset.Meta)
+	ot.ackedRanges, err = decodeAckedRanges(offset.Val, sparseAcks)
 	if err != nil {
 		ot.ackedRanges = nil
-		ot.offset.Meta = ""
+		ot.offset.Meta = joinMeta("", ot.appMeta)
 		ot.actDesc.Log().WithError(err).Errorf("Bad sparse acks: %v", offset)
 	}
 	return &ot
@@ -109,9 +150,11 @@ func (ot *T) OnOffered(msg consumer.Message) int {
 		return ot.offers[i].msg.Offset >= msg.Offset
 	})
 	// Ignore if the message is already in the list. It is either a retry, then
-	// the offer has already been updated in a NextRetry call, or a mistake of
-	// the caller, then we just do not care.
+	// the offer has already been updated in a NextRetry call, except for the
+	// request it was offered to, or a mistake of the caller, then we just do
+	// not care.
 	if ot.offers[i].msg.Offset == msg.Offset {
+		ot.offers[i].msg.RequestID = msg.RequestID
 		return offersCount
 	}
 	// Insert the message to the offer list keeping it sorted by offsets.
@@ -131,11 +174,110 @@ func (ot *T) OnAcked(offset int64) (offsetmgr.Offset, int) {
 			offset, !offerRemoved, !ackedRangesUpdated)
 	}
 	if ackedRangesUpdated {
-		ot.offset.Meta = encodeAckedRanges(ot.offset.Val, ot.ackedRanges)
+		ot.offset.Meta = joinMeta(encodeAckedRanges(ot.offset.Val, ot.ackedRanges), ot.appMeta)
 	}
 	return ot.offset, len(ot.offers)
 }
 
+// SetAppMeta sets application metadata that an ack carried, to be committed
+// along with the offset until an ack carries another one.
+func (ot *T) SetAppMeta(appMeta string) {
+	ot.appMeta = appMeta
+	ot.offset.Meta = joinMeta(encodeAckedRanges(ot.offset.Val, ot.ackedRanges), appMeta)
+}
+
+// OnReclaimed should be called when a message offered to a consumer could not
+// be delivered. The offer expires the given timeout from now, immediately if
+// it is zero, so that the message is returned by a NextRetry call then without
+// counting as a retry. It returns false if there is no offer with the
+// specified offset.
+func (ot *T) OnReclaimed(offset int64, timeout time.Duration) bool {
+	return ot.onReclaimed(offset, timeout, time.Now())
+}
+func (ot *T) onReclaimed(offset int64, timeout time.Duration, now time.Time) bool {
+	i := sort.Search(len(ot.offers), func(i int) bool {
+		return ot.offers[i].msg.Offset >= offset
+	})
+	if i >= len(ot.offers) || ot.offers[i].msg.Offset != offset {
+		return false
+	}
+	o := &ot.offers[i]
+	if !o.reclaimed {
+		o.reclaimed = true
+		ot.reclaimed += 1
+	}
+	o.deadline = time.Time{}
+	if timeout > 0 {
+		o.deadline = now.Add(timeout)
+	}
+	return true
+}
+
+// OnExtended should be called when a consumer asks for an ack timeout other
+// than the default one for a message offered to it. The offer expires the
+// given timeout from now. It returns false if there is no offer with the
+// specified offset.
+func (ot *T) OnExtended(offset int64, timeout time.Duration) bool {
+	return ot.onExtended(offset, timeout, time.Now())
+}
+func (ot *T) onExtended(offset int64, timeout time.Duration, now time.Time) bool {
+	i := sort.Search(len(ot.offers), func(i int) bool {
+		return ot.offers[i].msg.Offset >= offset
+	})
+	if i >= len(ot.offers) || ot.offers[i].msg.Offset != offset {
+		return false
+	}
+	o := &ot.offers[i]
+	if o.reclaimed {
+		return true
+	}
+	if !o.extended {
+		o.extended = true
+		ot.extended += 1
+	}
+	o.deadline = now.Add(timeout)
+	return true
+}
+
+// OfferedAt returns when a message with the specified offset was first
+// offered. It returns false if there is no offer with the specified offset.
+func (ot *T) OfferedAt(offset int64) (time.Time, bool) {
+	i := sort.Search(len(ot.offers), func(i int) bool {
+		return ot.offers[i].msg.Offset >= offset
+	})
+	if i >= len(ot.offers) || ot.offers[i].msg.Offset != offset {
+		return time.Time{}, false
+	}
+	return ot.offers[i].msg.OfferedAt, true
+}
+
+// OldestOfferedAt returns when the oldest of pending offers was first
+// offered, or zero time if there are no pending offers.
+func (ot *T) OldestOfferedAt() time.Time {
+	var oldest time.Time
+	for _, o := range ot.offers {
+		if oldest.IsZero() || o.msg.OfferedAt.Before(oldest) {
+			oldest = o.msg.OfferedAt
+		}
+	}
+	return oldest
+}
+
+// Offers returns messages offered to clients but not acknowledged yet,
+// sorted by offset.
+func (ot *T) Offers() []Offer {
+	offers := make([]Offer, len(ot.offers))
+	for i, o := range ot.offers {
+		offers[i] = Offer{
+			Offset:     o.msg.Offset,
+			OfferedAt:  o.msg.OfferedAt,
+			Deliveries: o.retryNo + 1,
+			RequestID:  o.msg.RequestID,
+		}
+	}
+	return offers
+}
+
 // IsAcked checks if an offset has already been acknowledged. The second
 // returned value is the smallest not acked offset that is greater than the
 // specified offset.
@@ -167,6 +309,15 @@ func (ot *T) nextRetry(now time.Time) (consumer.Message, int, bool) {
 		o := &ot.offers[i]
 		if o.deadline.Before(now) {
 			o.deadline = now.Add(ot.offerTimeout)
+			if o.extended {
+				o.extended = false
+				ot.extended -= 1
+			}
+			if o.reclaimed {
+				o.reclaimed = false
+				ot.reclaimed -= 1
+				return o.msg, o.retryNo, true
+			}
 			o.retryNo += 1
 			return o.msg, o.retryNo, true
 		}
@@ -175,8 +326,10 @@ func (ot *T) nextRetry(now time.Time) (consumer.Message, int, bool) {
 		// not expired yet. It is only true if messages are offered in the
 		// order of their offsets, which is indeed how partition consumer does
 		// it. But the offset tracker API allows any order. So the following
-		// logic is not valid in general case.
-		if o.retryNo == 0 {
+		// logic is not valid in general case. Reclaimed and extended offers
+		// break the order too, hence the entire list is checked while there
+		// are any.
+		if o.retryNo == 0 && ot.reclaimed == 0 && ot.extended == 0 {
 			return consumer.Message{}, -1, false
 		}
 	}
@@ -212,7 +365,9 @@ func (ot *T) shouldWait4Ack(now time.Time) time.Duration {
 }
 
 func (ot *T) newOffer(msg consumer.Message) offer {
-	return offer{msg, msg.Offset, 0, time.Now().Add(ot.offerTimeout)}
+	now := time.Now()
+	msg.OfferedAt = now
+	return offer{msg: msg, offset: msg.Offset, deadline: now.Add(ot.offerTimeout)}
 }
 
 // removeOffer if there is an offer with the specified offset in the list, then
@@ -225,6 +380,12 @@ func (ot *T) removeOffer(offset int64) bool {
 	if i >= offersCount || ot.offers[i].msg.Offset != offset {
 		return false
 	}
+	if ot.offers[i].reclaimed {
+		ot.reclaimed -= 1
+	}
+	if ot.offers[i].extended {
+		ot.extended -= 1
+	}
 	offersCount -= 1
 	copy(ot.offers[i:offersCount], ot.offers[i+1:])
 	ot.offers[offersCount] = offer{} // Makes it subject for garbage collection.
@@ -240,6 +401,12 @@ func (ot *T) dropOffersBefore(offset int64) {
 			break
 		}
 		drop = i + 1
+		if offer.reclaimed {
+			ot.reclaimed -= 1
+		}
+		if offer.extended {
+			ot.extended -= 1
+		}
 		ot.actDesc.Log().Errorf("Offer dropped: offset=%d", offer.offset)
 	}
 	if drop > 0 {
@@ -273,7 +440,7 @@ func (ot *T) correctOffset(offset int64) {
 		ot.ackedRanges = ot.ackedRanges[drop:]
 	}
 	ot.offset.Val = offset
-	ot.offset.Meta = encodeAckedRanges(offset, ot.ackedRanges)
+	ot.offset.Meta = joinMeta(encodeAckedRanges(offset, ot.ackedRanges), ot.appMeta)
 }
 
 // updateAckedRanges updates acked ranges with a new acked offset. It returns
@@ -412,8 +579,10 @@ func decodeInt64(buf []byte) (int64, []byte, error) {
 }
 
 type offer struct {
-	msg      consumer.Message
-	offset   int64
-	retryNo  int
-	deadline time.Time
+	msg       consumer.Message
+	offset    int64
+	retryNo   int
+	deadline  time.Time
+	reclaimed bool
+	extended  bool
 }
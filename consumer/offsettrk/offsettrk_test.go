@@ -202,6 +202,15 @@ func (s *OffsetTrkSuite) TestNew(c *C) {
 			offsetmgr.Offset{Val: 1000, Meta: "a@b"},
 			offsetmgr.Offset{Val: 1000, Meta: ""},
 		},
+		// Application metadata is preserved even if sparse acks are bad.
+		4: {
+			offsetmgr.Offset{Val: 1000, Meta: "abra1234+/P|foo|bar"},
+			offsetmgr.Offset{Val: 1000, Meta: "abra1234+/P|foo|bar"},
+		},
+		5: {
+			offsetmgr.Offset{Val: 1000, Meta: "a@b|foo"},
+			offsetmgr.Offset{Val: 1000, Meta: "|foo"},
+		},
 	} {
 		// When
 		ot := New(s.ns, tc.given, -1)
@@ -211,6 +220,33 @@ func (s *OffsetTrkSuite) TestNew(c *C) {
 	}
 }
 
+// Application metadata of the latest ack that carried one is committed along
+// with sparse acks.
+func (s *OffsetTrkSuite) TestSetAppMeta(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
+	for i, tc := range []struct {
+		acked   int64
+		appMeta string
+		meta    string
+	}{
+		0: {acked: 302, appMeta: "foo", meta: "CB|foo"},
+		1: {acked: 303, meta: "CC|foo"},
+		2: {acked: 300, appMeta: "bar", meta: "BC|bar"},
+		3: {acked: 301, meta: "|bar"},
+	} {
+		// When
+		if tc.appMeta != "" {
+			ot.SetAppMeta(tc.appMeta)
+		}
+		offset, _ := ot.OnAcked(tc.acked)
+
+		// Then
+		c.Check(offset.Meta, Equals, tc.meta, Commentf("case #%d", i))
+		sparseAcks, appMeta := SplitMeta(offset.Meta)
+		c.Check(sparseAcks+"|"+appMeta, Equals, offset.Meta, Commentf("case #%d", i))
+	}
+}
+
 func (s *OffsetTrkSuite) TestIsAcked(c *C) {
 	meta := encodeAckedRanges(301, []offsetRange{
 		{302, 305}, {307, 309}, {310, 313}})
@@ -361,6 +397,137 @@ func (s *OffsetTrkSuite) TestNextRetry(c *C) {
 	}
 }
 
+// A reclaimed offer is returned by NextRetry right away, even if it comes
+// after offers that have never been retried, and it does not count as a retry.
+func (s *OffsetTrkSuite) TestOnReclaimed(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+	for _, msg := range []consumer.Message{msg(300), msg(301), msg(302)} {
+		ot.OnOffered(msg)
+	}
+	begin := time.Now()
+
+	// When
+	ok := ot.OnReclaimed(302, 0)
+
+	// Then
+	c.Assert(ok, Equals, true)
+	retryMsg, retryNo, ok := ot.nextRetry(begin)
+	c.Assert(ok, Equals, true)
+	c.Assert(retryMsg.Offset, Equals, int64(302))
+	c.Assert(retryNo, Equals, 0)
+	_, _, ok = ot.nextRetry(begin)
+	c.Assert(ok, Equals, false)
+	c.Assert(ot.OnReclaimed(303, 0), Equals, false)
+
+	// A reclaimed offer that is acknowledged is not retried.
+	c.Assert(ot.OnReclaimed(301, 0), Equals, true)
+	ot.OnAcked(301)
+	_, _, ok = ot.nextRetry(begin)
+	c.Assert(ok, Equals, false)
+	c.Assert(ot.reclaimed, Equals, 0)
+}
+
+// A held back offer is returned by NextRetry when the timeout it is held back
+// for elapses, and it does not count as a retry.
+func (s *OffsetTrkSuite) TestOnReclaimedHeldBack(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+	for _, msg := range []consumer.Message{msg(300), msg(301)} {
+		ot.OnOffered(msg)
+	}
+	begin := time.Now()
+
+	// When
+	ok := ot.onReclaimed(301, time.Second, begin)
+
+	// Then
+	c.Assert(ok, Equals, true)
+	_, _, ok = ot.nextRetry(begin.Add(999 * time.Millisecond))
+	c.Assert(ok, Equals, false)
+	retryMsg, retryNo, ok := ot.nextRetry(begin.Add(1001 * time.Millisecond))
+	c.Assert(ok, Equals, true)
+	c.Assert(retryMsg.Offset, Equals, int64(301))
+	c.Assert(retryNo, Equals, 0)
+	c.Assert(ot.reclaimed, Equals, 0)
+}
+
+// Messages offered again carry the time they were first offered.
+func (s *OffsetTrkSuite) TestOfferedAt(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+	begin := time.Now()
+	ot.OnOffered(msg(300))
+
+	// When
+	retryMsg, _, ok := ot.nextRetry(begin.Add(6 * time.Second))
+
+	// Then
+	c.Assert(ok, Equals, true)
+	offeredAt, ok := ot.OfferedAt(300)
+	c.Assert(ok, Equals, true)
+	c.Check(retryMsg.OfferedAt, Equals, offeredAt)
+	c.Check(offeredAt.Before(begin), Equals, false)
+	_, ok = ot.OfferedAt(301)
+	c.Check(ok, Equals, false)
+}
+
+// Offers report how many times messages have been delivered and the request
+// they were offered to last.
+func (s *OffsetTrkSuite) TestOffers(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+	begin := time.Now()
+	msg300 := msg(300)
+	msg300.RequestID = "a"
+	ot.OnOffered(msg300)
+	ot.OnOffered(msg(301))
+
+	// When
+	retryMsg, _, ok := ot.nextRetry(begin.Add(6 * time.Second))
+	c.Assert(ok, Equals, true)
+	retryMsg.RequestID = "b"
+	ot.OnOffered(retryMsg)
+
+	// Then
+	offers := ot.Offers()
+	c.Assert(len(offers), Equals, 2)
+	c.Check(offers[0].Offset, Equals, int64(300))
+	c.Check(offers[0].Deliveries, Equals, 2)
+	c.Check(offers[0].RequestID, Equals, "b")
+	c.Check(offers[1].Offset, Equals, int64(301))
+	c.Check(offers[1].Deliveries, Equals, 1)
+	c.Check(offers[1].RequestID, Equals, "")
+	ot.OnAcked(300)
+	c.Check(len(ot.Offers()), Equals, 1)
+}
+
+// An extended offer is retried when its own timeout expires, regardless of
+// offers before it.
+func (s *OffsetTrkSuite) TestOnExtended(c *C) {
+	ot := New(s.ns, offsetmgr.Offset{Val: 300}, 5*time.Second)
+	for _, msg := range []consumer.Message{msg(300), msg(301), msg(302)} {
+		ot.OnOffered(msg)
+	}
+	begin := time.Now()
+
+	// When
+	c.Assert(ot.onExtended(300, time.Minute, begin), Equals, true)
+	c.Assert(ot.onExtended(302, time.Second, begin), Equals, true)
+
+	// Then
+	c.Assert(ot.onExtended(303, time.Second, begin), Equals, false)
+	retryMsg, retryNo, ok := ot.nextRetry(begin.Add(2 * time.Second))
+	c.Assert(ok, Equals, true)
+	c.Assert(retryMsg.Offset, Equals, int64(302))
+	c.Assert(retryNo, Equals, 1)
+	_, _, ok = ot.nextRetry(begin.Add(2 * time.Second))
+	c.Assert(ok, Equals, false)
+	retryMsg, _, ok = ot.nextRetry(begin.Add(6 * time.Second))
+	c.Assert(ok, Equals, true)
+	c.Assert(retryMsg.Offset, Equals, int64(301))
+	_, _, ok = ot.nextRetry(begin.Add(6 * time.Second))
+	c.Assert(ok, Equals, false)
+	ot.OnAcked(300)
+	c.Assert(ot.extended, Equals, 0)
+}
+
 func (s *OffsetTrkSuite) TestMaxOfferTimeout(c *C) {
 	ot := New(s.ns, offsetmgr.Offset{Val: 300}, -1)
 	msgs := []consumer.Message{
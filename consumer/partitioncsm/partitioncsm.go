@@ -10,11 +10,14 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/msgcache"
 	"github.com/mailgun/kafka-pixy/consumer/msgfetcher"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
 	"github.com/mailgun/kafka-pixy/consumer/subscriber"
+	"github.com/mailgun/kafka-pixy/inflight"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/partstats"
 	"github.com/pkg/errors"
 )
 
@@ -27,6 +30,10 @@ var (
 
 	// Sets an interval for periodical checks for messages to retry.
 	check4RetryInterval = time.Second
+
+	// How long an in-flight report waits for a partition consumer to list
+	// its offers.
+	offersTimeout = time.Second
 )
 
 // T ensures exclusive consumption of messages from a topic
@@ -43,8 +50,10 @@ type T struct {
 	groupMember *subscriber.T
 	msgFetcherF msgfetcher.Factory
 	offsetMgrF  offsetmgr.Factory
+	msgCache    *msgcache.T
 	messagesCh  chan consumer.Message
 	eventsCh    chan consumer.Event
+	offersRqCh  chan chan []inflight.Offer
 	stopCh      chan none.T
 	wg          sync.WaitGroup
 
@@ -54,6 +63,13 @@ type T struct {
 	offsetsOk       bool
 	offsetTrk       *offsettrk.T
 	offerCount      int32
+	oldestOfferedAt int64
+	inFlight        *inflight.Partition
+	reclaimedOffers int64
+	duplicateAcks   int64
+	lostMessages    int64
+	skippedMessages int64
+	paused          int32
 
 	// For tests only!
 	firstMsgFetched bool
@@ -62,6 +78,7 @@ type T struct {
 // Spawn creates a partition consumer instance and starts its goroutines.
 func Spawn(parentActDesc *actor.Descriptor, group, topic string, partition int32, cfg *config.Proxy,
 	groupMember *subscriber.T, msgFetcherF msgfetcher.Factory, offsetMgrF offsetmgr.Factory,
+	msgCache *msgcache.T,
 ) *T {
 	actDesc := parentActDesc.NewChild(fmt.Sprintf("%s.p%d", topic, partition))
 	actDesc.AddLogField("kafka.group", group)
@@ -76,10 +93,21 @@ func Spawn(parentActDesc *actor.Descriptor, group, topic string, partition int32
 		groupMember: groupMember,
 		msgFetcherF: msgFetcherF,
 		offsetMgrF:  offsetMgrF,
+		msgCache:    msgCache,
 		messagesCh:  make(chan consumer.Message, 1),
 		eventsCh:    make(chan consumer.Event, 1),
+		offersRqCh:  make(chan chan []inflight.Offer),
 		stopCh:      make(chan none.T),
 	}
+	pc.actDesc.ObserveQueue("messages", func() int { return len(pc.messagesCh) })
+	pc.actDesc.ObserveQueue("events", func() int { return len(pc.eventsCh) })
+	pc.actDesc.ObserveGauge("reclaimed_offers", func() int64 { return atomic.LoadInt64(&pc.reclaimedOffers) })
+	pc.actDesc.ObserveGauge("duplicate_acks", func() int64 { return atomic.LoadInt64(&pc.duplicateAcks) })
+	pc.actDesc.ObserveGauge("lost_messages", func() int64 { return atomic.LoadInt64(&pc.lostMessages) })
+	pc.actDesc.ObserveGauge("skipped_messages", func() int64 { return atomic.LoadInt64(&pc.skippedMessages) })
+	pc.actDesc.ObserveGauge("paused", func() int64 { return int64(atomic.LoadInt32(&pc.paused)) })
+	pc.actDesc.ObserveGauge("offers", func() int64 { return int64(atomic.LoadInt32(&pc.offerCount)) })
+	pc.actDesc.ObserveGauge("oldest_offer_age_ms", pc.oldestOfferAgeMs)
 	actor.Spawn(pc.actDesc, &pc.wg, pc.run)
 	return pc
 }
@@ -109,6 +137,10 @@ func (pc *T) run() {
 	defer close(pc.messagesCh)
 	defer pc.groupMember.ClaimPartition(pc.actDesc, pc.topic, pc.partition, pc.stopCh)()
 
+	pc.inFlight = inflight.RegisterPartition(pc.cfg.Cluster, pc.group, pc.topic, pc.partition,
+		func() int { return len(pc.messagesCh) }, pc.offers)
+	defer pc.inFlight.Unregister(pc.cfg.Cluster, pc.group)
+
 	var err error
 	if pc.offsetMgr, err = pc.offsetMgrF.Spawn(pc.actDesc, pc.group, pc.topic, pc.partition); err != nil {
 		panic(errors.Wrapf(err, "<%s> must never happen", pc.actDesc))
@@ -116,15 +148,22 @@ func (pc *T) run() {
 	defer pc.stopOffsetMgr()
 
 	// Wait for the initial offset to be retrieved or a stop signal.
-	select {
-	case pc.committedOffset = <-pc.offsetMgr.CommittedOffsets():
-	case <-pc.stopCh:
-		return
+	for initialized := false; !initialized; {
+		select {
+		case pc.committedOffset = <-pc.offsetMgr.CommittedOffsets():
+			initialized = true
+		case offersCh := <-pc.offersRqCh:
+			offersCh <- nil
+		case <-pc.stopCh:
+			return
+		}
 	}
 	pc.actDesc.Log().Infof("Initial offset: %s", offsetRepr(pc.committedOffset))
+	pc.observeCommit()
 	pc.offsetTrk = offsettrk.New(pc.actDesc, pc.committedOffset, pc.cfg.Consumer.AckTimeout)
 	pc.submittedOffset = pc.committedOffset
 	pc.offsetsOk = true
+	pc.msgCache.RemoveBefore(pc.group, pc.topic, pc.partition, pc.committedOffset.Val)
 	pc.notifyTestInitialized(pc.committedOffset)
 
 	// Run a fetch loop until the partition consumer is signalled to stop.
@@ -135,12 +174,23 @@ func (pc *T) run() {
 	for timeout := pc.offsetTrk.ShouldWait4Ack(); timeout > 0; timeout = pc.offsetTrk.ShouldWait4Ack() {
 		select {
 		case event := <-pc.eventsCh:
-			if event.T == consumer.EvAcked {
-				var offerCount int
-				pc.submittedOffset, offerCount = pc.offsetTrk.OnAcked(event.Offset)
-				atomic.StoreInt32(&pc.offerCount, int32(offerCount))
-				pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+			switch event.T {
+			case consumer.EvAcked:
+				pc.onAcked(event)
+			case consumer.EvReclaimed:
+				// A reclaimed offer expires right away, even if it is held
+				// back, so there is no reason to wait for it to be
+				// acknowledged.
+				if pc.offsetTrk.OnReclaimed(event.Offset, 0) {
+					atomic.AddInt64(&pc.reclaimedOffers, 1)
+				}
+			case consumer.EvExtended:
+				pc.extend(event.Offset, event.Timeout)
+			case consumer.EvSkipped:
+				pc.skipTo(event.Offset)
 			}
+		case offersCh := <-pc.offersRqCh:
+			offersCh <- pc.listOffers()
 		case <-time.After(timeout):
 			continue
 		}
@@ -148,17 +198,58 @@ func (pc *T) run() {
 }
 
 func (pc *T) runFetchLoop() bool {
+	// Messages that have been offered before and are still cached are offered
+	// again first, and only the messages that follow them are fetched.
+	cached, fetchOffset := pc.cachedMessages()
+
 	// Initialize a message fetcher to read from the initial offset.
-	mf, realOffsetVal, err := pc.msgFetcherF.Spawn(pc.actDesc, pc.topic, pc.partition, pc.submittedOffset.Val)
+	mf, realOffsetVal, err := pc.msgFetcherF.Spawn(pc.actDesc, pc.topic, pc.partition, fetchOffset)
 	if err != nil {
 		pc.actDesc.Log().WithError(err).Error("Failed to spawn fetcher")
 		return true
 	}
 	defer mf.Stop()
 
+	// If the offset to fetch from has been removed by retention, then the
+	// messages before the oldest available one are lost to the group. The
+	// first message fetched after the gap is marked with their number.
+	var dataLoss int64
+	if fetchOffset >= 0 && realOffsetVal > fetchOffset {
+		dataLoss = realOffsetVal - fetchOffset
+		if len(cached) > 0 {
+			dataLoss = realOffsetVal - cached[0].Offset
+		}
+		atomic.AddInt64(&pc.lostMessages, dataLoss)
+		pc.actDesc.Log().Errorf("Messages lost to retention: count=%d, fetchOffset=%d, realOffset=%d",
+			dataLoss, fetchOffset, realOffsetVal)
+	}
+	adjustedOffsetVal := realOffsetVal
+	if len(cached) > 0 {
+		if realOffsetVal == fetchOffset {
+			adjustedOffsetVal = cached[0].Offset
+		} else {
+			// The partition is not what it was when the messages were
+			// cached, e.g. they have been removed by retention already.
+			pc.actDesc.Log().Warnf("Cached messages dropped: count=%d, fetchOffset=%d, realOffset=%d",
+				len(cached), fetchOffset, realOffsetVal)
+			cached = cached[:0]
+		}
+	}
+	cachedCh := make(chan consumer.Message, len(cached))
+	for _, msg := range cached {
+		cachedCh <- msg
+	}
+	// Returns a channel to read the next message to offer from.
+	nextMsgInCh := func() <-chan consumer.Message {
+		if len(cachedCh) > 0 {
+			return cachedCh
+		}
+		return mf.Messages()
+	}
+
 	var offerCount int
-	pc.submittedOffset, offerCount = pc.offsetTrk.Adjust(realOffsetVal)
-	atomic.StoreInt32(&pc.offerCount, int32(offerCount))
+	pc.submittedOffset, offerCount = pc.offsetTrk.Adjust(adjustedOffsetVal)
+	pc.setOfferCount(offerCount)
 
 	// If the real offset is different from the committed one then submit it
 	// and report in the logs.
@@ -168,16 +259,24 @@ func (pc *T) runFetchLoop() bool {
 			offsetRepr(pc.submittedOffset), offsetRepr(pc.committedOffset))
 	}
 	var (
-		nilOrMsgInCh  = mf.Messages()
+		nilOrMsgInCh  = nextMsgInCh()
 		nilOrMsgOutCh chan consumer.Message
 		retryTicker   = time.NewTicker(check4RetryInterval)
 		msg           consumer.Message
 		msgOk         bool
+		paused        bool
 	)
 	defer retryTicker.Stop()
 	for {
+		pc.actDesc.Touch()
+		// While delivery is paused messages are neither fetched nor offered,
+		// but events of messages offered before are still handled.
+		msgInCh, msgOutCh := nilOrMsgInCh, nilOrMsgOutCh
+		if paused {
+			msgInCh, msgOutCh = nil, nil
+		}
 		select {
-		case msg, msgOk = <-nilOrMsgInCh:
+		case msg, msgOk = <-msgInCh:
 			// If the fetcher terminated due to failure, then quit the fetch
 			// loop signaling that it needs to be reinitialized.
 			if !msgOk {
@@ -189,12 +288,17 @@ func (pc *T) runFetchLoop() bool {
 				continue
 			}
 			msg.EventsCh = pc.eventsCh
+			partstats.ObserveFetch(pc.cfg.Cluster, pc.group, pc.topic, pc.partition, msg.Offset, len(msg.Key)+len(msg.Value))
+			if dataLoss > 0 {
+				msg.DataLoss, dataLoss = dataLoss, 0
+			}
 			pc.notifyTestFetched()
 			nilOrMsgOutCh = pc.messagesCh
 			// Stop fetching messages until this one is offered to a client.
 			nilOrMsgInCh = nil
 
 		case <-retryTicker.C:
+			paused = pc.isDeliveryPaused(paused)
 			if msgOk {
 				continue
 			}
@@ -202,7 +306,7 @@ func (pc *T) runFetchLoop() bool {
 				nilOrMsgInCh = nil
 				nilOrMsgOutCh = pc.messagesCh
 			}
-		case nilOrMsgOutCh <- msg:
+		case msgOutCh <- msg:
 			nilOrMsgOutCh = nil
 
 		case event := <-pc.eventsCh:
@@ -212,8 +316,12 @@ func (pc *T) runFetchLoop() bool {
 					pc.actDesc.Log().Errorf("Invalid offer offset %d, want=%d", event.Offset, msg.Offset)
 					continue
 				}
+				msg.RequestID = event.RequestID
+				// The data loss is reported with the first offer only.
+				msg.DataLoss = 0
 				offerCount = pc.offsetTrk.OnOffered(msg)
-				atomic.StoreInt32(&pc.offerCount, int32(offerCount))
+				pc.setOfferCount(offerCount)
+				pc.msgCache.Put(pc.group, msg)
 				if msg, msgOk = pc.nextRetry(); msgOk {
 					nilOrMsgOutCh = pc.messagesCh
 					continue
@@ -223,23 +331,161 @@ func (pc *T) runFetchLoop() bool {
 					nilOrMsgInCh = nil
 					continue
 				}
-				nilOrMsgInCh = mf.Messages()
+				nilOrMsgInCh = nextMsgInCh()
 
 			case consumer.EvAcked:
-				pc.submittedOffset, offerCount = pc.offsetTrk.OnAcked(event.Offset)
-				atomic.StoreInt32(&pc.offerCount, int32(offerCount))
-				pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+				offerCount = pc.onAcked(event)
 				if !msgOk && offerCount <= pc.cfg.Consumer.MaxPendingMessages {
-					nilOrMsgInCh = mf.Messages()
+					nilOrMsgInCh = nextMsgInCh()
+				}
+
+			case consumer.EvReclaimed:
+				if !pc.offsetTrk.OnReclaimed(event.Offset, event.Timeout) {
+					continue
+				}
+				atomic.AddInt64(&pc.reclaimedOffers, 1)
+				if msgOk {
+					continue
+				}
+				if msg, msgOk = pc.nextRetry(); msgOk {
+					nilOrMsgInCh = nil
+					nilOrMsgOutCh = pc.messagesCh
+				}
+			case consumer.EvExtended:
+				pc.extend(event.Offset, event.Timeout)
+			case consumer.EvSkipped:
+				// Take back the message waiting to be offered, unless it
+				// has been taken by a client already, and restart the
+				// fetch loop from the offset skipped to.
+				if pc.skipTo(event.Offset) {
+					select {
+					case <-pc.messagesCh:
+					default:
+					}
+					return true
 				}
 			}
 		case pc.committedOffset = <-pc.offsetMgr.CommittedOffsets():
+			pc.observeCommit()
+		case offersCh := <-pc.offersRqCh:
+			offersCh <- pc.listOffers()
 		case <-pc.stopCh:
 			return false
 		}
 	}
 }
 
+// onAcked handles an ack event and returns the number of pending offers. A
+// message can be acknowledged more than once, e.g. when a client acks a
+// message that has been redelivered to another client that acked it already.
+// Such duplicate acks are only counted, so that neither application metadata
+// they carry nor the submitted offset change.
+func (pc *T) onAcked(event consumer.Event) int {
+	if acked, _ := pc.offsetTrk.IsAcked(event.Offset); acked {
+		atomic.AddInt64(&pc.duplicateAcks, 1)
+		return int(atomic.LoadInt32(&pc.offerCount))
+	}
+	if event.Meta != "" {
+		pc.offsetTrk.SetAppMeta(event.Meta)
+	}
+	var offerCount int
+	pc.submittedOffset, offerCount = pc.offsetTrk.OnAcked(event.Offset)
+	pc.setOfferCount(offerCount)
+	pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+	pc.msgCache.Remove(pc.group, pc.topic, pc.partition, event.Offset)
+	return offerCount
+}
+
+// skipTo acknowledges all messages before the given offset, dropping pending
+// offers of them, and submits the offset. It returns false if there is
+// nothing to skip.
+func (pc *T) skipTo(offset int64) bool {
+	if offset <= pc.submittedOffset.Val {
+		return false
+	}
+	oldOffset := pc.submittedOffset
+	var offerCount int
+	pc.submittedOffset, offerCount = pc.offsetTrk.Adjust(offset)
+	pc.setOfferCount(offerCount)
+	pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+	pc.msgCache.RemoveBefore(pc.group, pc.topic, pc.partition, offset)
+	atomic.AddInt64(&pc.skippedMessages, offset-oldOffset.Val)
+	pc.actDesc.Log().Warnf("Skipped: new=%s, old=%s", offsetRepr(pc.submittedOffset), offsetRepr(oldOffset))
+	return true
+}
+
+// setOfferCount records the number of pending offers and the age of the
+// oldest of them, to be reported in metrics and group concurrency reports.
+func (pc *T) setOfferCount(offerCount int) {
+	oldestOfferedAt := pc.offsetTrk.OldestOfferedAt()
+	atomic.StoreInt32(&pc.offerCount, int32(offerCount))
+	var nanos int64
+	if !oldestOfferedAt.IsZero() {
+		nanos = oldestOfferedAt.UnixNano()
+	}
+	atomic.StoreInt64(&pc.oldestOfferedAt, nanos)
+	pc.inFlight.Update(offerCount, oldestOfferedAt)
+}
+
+// offers returns messages offered to clients but not acknowledged yet, for
+// in-flight reports. The list is made by the partition consumer goroutine on
+// request, for it owns the offset tracker. If it does not respond in time,
+// e.g. because it is stopping, then nil is returned.
+func (pc *T) offers() []inflight.Offer {
+	offersCh := make(chan []inflight.Offer, 1)
+	select {
+	case pc.offersRqCh <- offersCh:
+		return <-offersCh
+	case <-time.After(offersTimeout):
+		return nil
+	}
+}
+
+func (pc *T) listOffers() []inflight.Offer {
+	offers := pc.offsetTrk.Offers()
+	inFlightOffers := make([]inflight.Offer, len(offers))
+	for i, o := range offers {
+		inFlightOffers[i] = inflight.Offer{
+			Offset:     o.Offset,
+			OfferedAt:  o.OfferedAt,
+			Deliveries: o.Deliveries,
+			RequestID:  o.RequestID,
+		}
+	}
+	return inFlightOffers
+}
+
+func (pc *T) oldestOfferAgeMs() int64 {
+	nanos := atomic.LoadInt64(&pc.oldestOfferedAt)
+	if nanos == 0 {
+		return 0
+	}
+	return int64(time.Since(time.Unix(0, nanos)) / time.Millisecond)
+}
+
+// isDeliveryPaused tells whether delivery of messages should be paused,
+// because offset commits of the partition keep failing, given whether it was
+// paused before.
+func (pc *T) isDeliveryPaused(wasPaused bool) bool {
+	alertCfg := pc.cfg.Consumer.CommitFailureAlert
+	if !alertCfg.PauseDelivery {
+		return false
+	}
+	failures := pc.offsetMgr.Failures()
+	paused := failures >= alertCfg.Threshold
+	if paused == wasPaused {
+		return paused
+	}
+	if paused {
+		atomic.StoreInt32(&pc.paused, 1)
+		pc.actDesc.Log().Errorf("Delivery paused: failedCommits=%d", failures)
+	} else {
+		atomic.StoreInt32(&pc.paused, 0)
+		pc.actDesc.Log().Info("Delivery resumed")
+	}
+	return paused
+}
+
 // nextRetry checks with the offset tracker if there is a message ready to be
 // retried. If it gets a message that has already been retried maxRetries times,
 // then it acks the message and asks the offset tracker for another one. It
@@ -252,16 +498,65 @@ func (pc *T) nextRetry() (consumer.Message, bool) {
 			retryNo, msg.Offset, string(msg.Key), base64.StdEncoding.EncodeToString(msg.Value))
 		pc.submittedOffset, _ = pc.offsetTrk.OnAcked(msg.Offset)
 		pc.offsetMgr.SubmitOffset(pc.submittedOffset)
+		pc.msgCache.Remove(pc.group, pc.topic, pc.partition, msg.Offset)
 		// TODO: Dump expired messages to a long term storage?
 		msg, retryNo, ok = pc.offsetTrk.NextRetry()
 	}
 	if ok {
 		pc.actDesc.Log().Warnf("Retrying: retryNo=%d, offset=%d, key=%s",
 			retryNo, msg.Offset, string(msg.Key))
+		msg.RetryNo = retryNo
 	}
 	return msg, ok
 }
 
+// cachedMessages returns cached messages that immediately follow the
+// submitted offset, skipping acknowledged ones, along with the offset of the
+// first message that is not cached. If there are no such messages, then the
+// submitted offset is returned.
+func (pc *T) cachedMessages() ([]consumer.Message, int64) {
+	var msgs []consumer.Message
+	offset := pc.submittedOffset.Val
+	if pc.msgCache == nil {
+		return nil, offset
+	}
+	for {
+		if acked, next := pc.offsetTrk.IsAcked(offset); acked {
+			offset = next
+			continue
+		}
+		msg, ok := pc.msgCache.Get(pc.group, pc.topic, pc.partition, offset)
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+		offset++
+	}
+	if len(msgs) == 0 {
+		return nil, pc.submittedOffset.Val
+	}
+	pc.actDesc.Log().Infof("Offering cached messages: count=%d, fetchOffset=%d", len(msgs), offset)
+	return msgs, offset
+}
+
+// extend makes an offer expire the given timeout from now, but no later than
+// `consumer.max_processing_time` after it was first offered, so that a
+// message that is not acknowledged by then is offered again, and then dead
+// lettered by the proxy.
+func (pc *T) extend(offset int64, timeout time.Duration) {
+	offeredAt, ok := pc.offsetTrk.OfferedAt(offset)
+	if !ok {
+		pc.actDesc.Log().Errorf("Bad extend: offset=%d", offset)
+		return
+	}
+	if maxProcessingTime := pc.cfg.Consumer.MaxProcessingTime; maxProcessingTime > 0 {
+		if left := time.Until(offeredAt.Add(maxProcessingTime)); timeout > left {
+			timeout = left
+		}
+	}
+	pc.offsetTrk.OnExtended(offset, timeout)
+}
+
 func (pc *T) stopOffsetMgr() {
 	pc.offsetMgr.Stop()
 	if !pc.offsetsOk {
@@ -270,12 +565,18 @@ func (pc *T) stopOffsetMgr() {
 	// Drain committed offsets.
 	for pc.committedOffset = range pc.offsetMgr.CommittedOffsets() {
 	}
+	pc.observeCommit()
 	if pc.committedOffset != pc.submittedOffset {
 		pc.actDesc.Log().Errorf("Failed to commit offset: %s", offsetRepr(pc.submittedOffset))
 	}
 	pc.actDesc.Log().Infof("Last committed offset: %s", offsetRepr(pc.committedOffset))
 }
 
+// observeCommit records the committed offset in partition stats.
+func (pc *T) observeCommit() {
+	partstats.ObserveCommit(pc.cfg.Cluster, pc.group, pc.topic, pc.partition, pc.committedOffset.Val)
+}
+
 // notifyTestInitialized sends initial offset to initialOffsetCh channel.
 func (pc *T) notifyTestInitialized(initialOffset offsetmgr.Offset) {
 	if initialOffsetCh != nil {
@@ -1,6 +1,8 @@
 package partitioncsm
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -8,6 +10,7 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/msgcache"
 	"github.com/mailgun/kafka-pixy/consumer/msgfetcher"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
 	"github.com/mailgun/kafka-pixy/consumer/subscriber"
@@ -75,7 +78,7 @@ func (s *PartitionCsmSuite) TestOldestOffset(c *C) {
 	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: sarama.OffsetOldest, Meta: ""}})
 	offsets := s.kh.GetCommittedOffsets(group, topic)
 	c.Assert(offsets[partition], Equals, offsetmgr.Offset{Val: sarama.OffsetOldest, Meta: ""})
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	// When
 	<-pc.Messages()
@@ -95,7 +98,7 @@ func (s *PartitionCsmSuite) TestInitialOffsetTooLarge(c *C) {
 	newestOffsets := s.kh.GetNewestOffsets(topic)
 	log.Infof("*** test.1 offsets: oldest=%v, newest=%v", oldestOffsets, newestOffsets)
 	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: newestOffsets[partition] + 3}})
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 	// Wait for the partition consumer to initialize.
 	initialOffset := <-s.initOffsetCh
@@ -117,7 +120,7 @@ func (s *PartitionCsmSuite) TestInitialOffsetTooLarge(c *C) {
 // previous one is reported as offered.
 func (s *PartitionCsmSuite) TestMustBeOfferedToProceed(c *C) {
 	s.kh.SetOffsetValues(group, topic, s.kh.GetOldestOffsets(topic))
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 
 	// When
@@ -160,7 +163,7 @@ func (s *PartitionCsmSuite) TestSparseAckedNotRead(c *C) {
 	c.Assert(offsettrk.SparseAcks2Str(initOffset), Equals, "1-4,6-7")
 	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{initOffset})
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 
 	// When/Then: only messages that has not been acked previously are returned.
@@ -182,7 +185,7 @@ func (s *PartitionCsmSuite) TestSparseAckedNotRead(c *C) {
 // Messages() channel is ignored.
 func (s *PartitionCsmSuite) TestOfferInvalid(c *C) {
 	s.kh.SetOffsetValues(group, topic, s.kh.GetOldestOffsets(topic))
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 
 	msg, ok := <-pc.Messages()
@@ -206,7 +209,7 @@ func (s *PartitionCsmSuite) TestOfferedTooMany(c *C) {
 	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
 	s.cfg.Consumer.MaxPendingMessages = 3
 	s.kh.SetOffsetValues(group, topic, s.kh.GetOldestOffsets(topic))
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 	var msg consumer.Message
 
@@ -261,7 +264,7 @@ func (s *PartitionCsmSuite) TestSparseAckedCommitted(c *C) {
 	}
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	// When
 	for _, shouldAck := range acks {
@@ -279,6 +282,104 @@ func (s *PartitionCsmSuite) TestSparseAckedCommitted(c *C) {
 	c.Assert(offsettrk.SparseAcks2Str(offsetsAfter[partition]), Equals, "1-4,6-8")
 }
 
+// Duplicate acks, even if sent concurrently, are counted and do not affect
+// the committed offset or application metadata.
+func (s *PartitionCsmSuite) TestDuplicateAcks(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.kh.SetOffsetValues(group, topic, offsetsBefore)
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
+
+	var messages []consumer.Message
+	for i := 0; i < 3; i++ {
+		msg := <-pc.Messages()
+		sendEvOffered(msg)
+		messages = append(messages, msg)
+	}
+	sendEvAcked(messages[0])
+	messages[2].EventsCh <- consumer.AckWithMeta(messages[2].Offset, "foo")
+
+	// When
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendEvAcked(messages[0])
+		}()
+	}
+	wg.Wait()
+	messages[2].EventsCh <- consumer.AckWithMeta(messages[2].Offset, "bar")
+	pc.Stop()
+
+	// Then
+	c.Check(atomic.LoadInt64(&pc.duplicateAcks), Equals, int64(4))
+	offsetsAfter := s.kh.GetCommittedOffsets(group, topic)
+	c.Check(offsetsAfter[partition].Val, Equals, offsetsBefore[partition]+1)
+	c.Check(offsettrk.SparseAcks2Str(offsetsAfter[partition]), Equals, "1-2")
+	_, appMeta := offsettrk.SplitMeta(offsetsAfter[partition].Meta)
+	c.Check(appMeta, Equals, "foo")
+}
+
+// When skipped to an offset, pending offers are dropped, the offset is
+// committed, and messages are offered starting from it.
+func (s *PartitionCsmSuite) TestSkipTo(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.kh.SetOffsetValues(group, topic, offsetsBefore)
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
+
+	var msg consumer.Message
+	for i := 0; i < 3; i++ {
+		msg = <-pc.Messages()
+		sendEvOffered(msg)
+	}
+	sendEvAcked(msg)
+
+	// When
+	msg.EventsCh <- consumer.SkipTo(offsetsBefore[partition] + 10)
+
+	// Then
+	msg = <-pc.Messages()
+	c.Check(msg.Offset, Equals, offsetsBefore[partition]+10)
+	c.Check(atomic.LoadInt32(&pc.offerCount), Equals, int32(0))
+	c.Check(atomic.LoadInt64(&pc.skippedMessages), Equals, int64(10))
+	pc.Stop()
+	offsetsAfter := s.kh.GetCommittedOffsets(group, topic)
+	c.Check(offsetsAfter[partition].Val, Equals, offsetsBefore[partition]+10)
+	c.Check(offsettrk.SparseAcks2Str(offsetsAfter[partition]), Equals, "")
+}
+
+// Offers are listed on request, the acknowledged ones are not.
+func (s *PartitionCsmSuite) TestOffers(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.kh.SetOffsetValues(group, topic, offsetsBefore)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
+	defer pc.Stop()
+
+	var msgs []consumer.Message
+	for i := 0; i < 3; i++ {
+		msg := <-pc.Messages()
+		sendEvOffered(msg)
+		msgs = append(msgs, msg)
+	}
+
+	// When
+	sendEvAcked(msgs[1])
+
+	// Then
+	offers := pc.offers()
+	// The ack may be handled after the offers are listed.
+	for i := 0; i < 10 && len(offers) != 2; i++ {
+		time.Sleep(50 * time.Millisecond)
+		offers = pc.offers()
+	}
+	c.Assert(len(offers), Equals, 2)
+	c.Check(offers[0].Offset, Equals, msgs[0].Offset)
+	c.Check(offers[0].Deliveries, Equals, 1)
+	c.Check(offers[1].Offset, Equals, msgs[2].Offset)
+}
+
 // When a partition consumer is signalled to stop it waits at most
 // Consumer.AckTimeout for acks to arrive, and then commits whatever it has
 // gotten and terminates.
@@ -287,7 +388,7 @@ func (s *PartitionCsmSuite) TestSparseAckedAfterStop(c *C) {
 	s.cfg.Consumer.AckTimeout = 300 * time.Millisecond
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	var messages []consumer.Message
 	for i := 0; i < 10; i++ {
@@ -333,7 +434,7 @@ func (s *PartitionCsmSuite) TestZeroRetries(c *C) {
 	s.cfg.Consumer.MaxRetries = 0
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	msg0 := <-pc.Messages()
 	log.Infof("*** First: offset=%v", msg0.Offset)
@@ -364,7 +465,7 @@ func (s *PartitionCsmSuite) TestIndefiniteRetries(c *C) {
 	s.cfg.Consumer.MaxRetries = -1
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	msg0 := <-pc.Messages()
 	sendEvOffered(msg0)
@@ -381,7 +482,7 @@ func (s *PartitionCsmSuite) TestIndefiniteRetries(c *C) {
 		sendEvAcked(msgI)
 		// ...but retried messages are not.
 		msg0_i := <-pc.Messages()
-		c.Assert(msg0_i, DeepEquals, msg0, Commentf(
+		c.Assert(asFirstOffered(c, msg0_i, i+1), DeepEquals, msg0, Commentf(
 			"got: %d, want: %d", msg0_i.Offset, msg0.Offset))
 		sendEvOffered(msg0)
 	}
@@ -401,7 +502,7 @@ func (s *PartitionCsmSuite) TestMaxRetriesReached(c *C) {
 	s.cfg.Consumer.MaxRetries = 3
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	var messages []consumer.Message
 	for i := 0; i < 3; i++ {
@@ -425,11 +526,11 @@ func (s *PartitionCsmSuite) TestMaxRetriesReached(c *C) {
 		sendEvAcked(msgI)
 		// ...but retried messages are not.
 		msg0_i := <-pc.Messages()
-		c.Assert(msg0_i, DeepEquals, messages[0], Commentf(
+		c.Assert(asFirstOffered(c, msg0_i, i+1), DeepEquals, messages[0], Commentf(
 			"got: %d, want: %d", msg0_i.Offset, messages[0].Offset))
 		sendEvOffered(messages[0])
 		msg2_i := <-pc.Messages()
-		c.Assert(msg2_i, DeepEquals, messages[2], Commentf(
+		c.Assert(asFirstOffered(c, msg2_i, i+1), DeepEquals, messages[2], Commentf(
 			"got: %d, want: %d", msg2_i.Offset, messages[2].Offset))
 		sendEvOffered(messages[2])
 	}
@@ -458,7 +559,7 @@ func (s *PartitionCsmSuite) TestSeveralMessageReties(c *C) {
 	s.cfg.Consumer.AckTimeout = 100 * time.Millisecond
 	s.kh.SetOffsetValues(group, topic, offsetsBefore)
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 
 	// Read and confirm offered several messages, but do not ack them.
@@ -489,7 +590,7 @@ func (s *PartitionCsmSuite) TestRetryNoMoreMessages(c *C) {
 	s.cfg.Consumer.MaxRetries = 3
 	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: offsetBefore}})
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, nil)
 
 	// Read and confirm offer of 4 messages
 	var messages []consumer.Message
@@ -505,7 +606,7 @@ func (s *PartitionCsmSuite) TestRetryNoMoreMessages(c *C) {
 	// Then: Since there are no more messages in the partition, then the next
 	// message returned is a retry.
 	msg0_i := <-pc.Messages()
-	c.Assert(msg0_i, DeepEquals, messages[0], Commentf(
+	c.Assert(asFirstOffered(c, msg0_i, 1), DeepEquals, messages[0], Commentf(
 		"got: %d, want: %d", msg0_i.Offset, messages[0].Offset))
 
 	pc.Stop()
@@ -514,6 +615,39 @@ func (s *PartitionCsmSuite) TestRetryNoMoreMessages(c *C) {
 	c.Assert(offsettrk.SparseAcks2Str(offsetsAfter[partition]), Equals, "")
 }
 
+// If a partition consumer is restarted, then messages offered but not acked
+// by the previous one are offered again from the message cache.
+func (s *PartitionCsmSuite) TestCachedRedelivery(c *C) {
+	offsetsBefore := s.kh.GetOldestOffsets(topic)
+	s.cfg.Consumer.AckTimeout = 300 * time.Millisecond
+	s.kh.SetOffsetValues(group, topic, offsetsBefore)
+	msgCache := msgcache.New(1 << 20)
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, msgCache)
+	<-s.initOffsetCh
+	var messages []consumer.Message
+	for i := 0; i < 3; i++ {
+		msg := <-pc.Messages()
+		sendEvOffered(msg)
+		messages = append(messages, msg)
+	}
+	sendEvAcked(messages[1])
+	pc.Stop()
+	c.Assert(msgCache.Stats().Messages, Equals, 2)
+
+	// When
+	pc = Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, s.msgFetcherF, s.offsetMgrF, msgCache)
+	defer pc.Stop()
+
+	// Then
+	for i, want := range []int64{messages[0].Offset, messages[2].Offset, messages[2].Offset + 1} {
+		msg := expectMsg(c, pc, 3*time.Second)
+		sendEvOffered(msg)
+		c.Check(msg.Offset, Equals, want, Commentf("case #%d", i))
+	}
+	c.Check(msgCache.Stats().Hits, Equals, int64(2))
+}
+
 // If fetcher dies (detectable by closing of its message channel), that means
 // it got an error response from a broker it could not recover from, e.g.
 // a partition segment it was reading from got expired and was deleted. In this
@@ -553,7 +687,7 @@ func (s *PartitionCsmSuite) TestFetcherDeath(c *C) {
 	msgFetcherF := msgfetcher.SpawnFactory(s.ns, s.cfg, kafkaClt)
 	defer msgFetcherF.Stop()
 
-	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, msgFetcherF, s.offsetMgrF)
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, msgFetcherF, s.offsetMgrF, nil)
 	defer pc.Stop()
 
 	// When/Then
@@ -567,6 +701,51 @@ func (s *PartitionCsmSuite) TestFetcherDeath(c *C) {
 	c.Assert(msg.Offset, Equals, int64(1002))
 }
 
+// If the committed offset has been removed by retention, then the first
+// message fetched tells how many messages were lost before it.
+func (s *PartitionCsmSuite) TestDataLoss(c *C) {
+	s.kh.SetOffsets(group, topic, []offsetmgr.Offset{{Val: 990}})
+	testMsg := sarama.StringEncoder("Foo")
+
+	// FIXME: Mock broker speaks v0.8.2.x protocol only. Update it?
+	s.cfg.Kafka.Version.Set(sarama.V0_8_2_2)
+
+	mockBroker := sarama.NewMockBroker(c, 0)
+	defer mockBroker.Close()
+	mockBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(mockBroker.Addr(), mockBroker.BrokerID()).
+			SetLeader(topic, partition, mockBroker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(c).
+			SetOffset(topic, partition, sarama.OffsetOldest, 1001).
+			SetOffset(topic, partition, sarama.OffsetNewest, 1984),
+		"FetchRequest": sarama.NewMockFetchResponse(c, 1).
+			SetMessage(topic, partition, 1001, testMsg).
+			SetMessage(topic, partition, 1002, testMsg),
+	})
+
+	kafkaClt, _ := sarama.NewClient([]string{mockBroker.Addr()}, s.cfg.SaramaClientCfg())
+	defer kafkaClt.Close()
+	msgFetcherF := msgfetcher.SpawnFactory(s.ns, s.cfg, kafkaClt)
+	defer msgFetcherF.Stop()
+
+	pc := Spawn(s.ns, group, topic, partition, s.cfg, s.groupMember, msgFetcherF, s.offsetMgrF, nil)
+	defer pc.Stop()
+
+	// When
+	msg1 := expectMsg(c, pc, 3*time.Second)
+	sendEvOffered(msg1)
+	msg2 := expectMsg(c, pc, 3*time.Second)
+	sendEvOffered(msg2)
+
+	// Then
+	c.Check(msg1.Offset, Equals, int64(1001))
+	c.Check(msg1.DataLoss, Equals, int64(11))
+	c.Check(msg2.Offset, Equals, int64(1002))
+	c.Check(msg2.DataLoss, Equals, int64(0))
+	c.Check(atomic.LoadInt64(&pc.lostMessages), Equals, int64(11))
+}
+
 func sendEvOffered(msg consumer.Message) {
 	log.Infof("*** sending EvOffered: offset=%d", msg.Offset)
 	select {
@@ -585,6 +764,17 @@ func sendEvAcked(msg consumer.Message) {
 	}
 }
 
+// asFirstOffered checks the retry number of a retried message and clears it
+// along with the time of the first offer that the message carries, so that
+// it can be compared with the message as first offered.
+func asFirstOffered(c *C, msg consumer.Message, retryNo int) consumer.Message {
+	c.Check(msg.OfferedAt.IsZero(), Equals, false)
+	c.Check(msg.RetryNo, Equals, retryNo)
+	msg.OfferedAt = time.Time{}
+	msg.RetryNo = 0
+	return msg
+}
+
 func expectMsg(c *C, pc *T, timeout time.Duration) consumer.Message {
 	select {
 	case msg := <-pc.Messages():
@@ -5,11 +5,13 @@ import (
 	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer/kazoo"
+	"github.com/mailgun/kafka-pixy/lifecycle"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/samuel/go-zookeeper/zk"
@@ -41,7 +43,14 @@ type T struct {
 	subscriptionsCh chan map[string][]string
 	stopCh          chan none.T
 	claimErrorsCh   chan none.T
+	generation      int32
 	wg              sync.WaitGroup
+
+	// Retry backoffs of membership operations, see
+	// consumer.group_membership in the config.
+	claimRetryBackoff   time.Duration
+	watchRetryBackoff   time.Duration
+	releaseRetryBackoff time.Duration
 }
 
 // Spawn creates a subscriber instance and starts its goroutine.
@@ -63,6 +72,10 @@ func Spawn(parentActDesc *actor.Descriptor, group string, cfg *config.Proxy, zkC
 		subscriptionsCh: make(chan map[string][]string),
 		stopCh:          make(chan none.T),
 		claimErrorsCh:   make(chan none.T, 1),
+
+		claimRetryBackoff:   orRetryBackoff(cfg, cfg.Consumer.GroupMembership.ClaimRetryBackoff),
+		watchRetryBackoff:   orRetryBackoff(cfg, cfg.Consumer.GroupMembership.WatchRetryBackoff),
+		releaseRetryBackoff: orRetryBackoff(cfg, cfg.Consumer.GroupMembership.ReleaseRetryBackoff),
 	}
 	actor.Spawn(ss.actDesc, &ss.wg, ss.run)
 	return ss
@@ -82,6 +95,13 @@ func (s *T) Subscriptions() <-chan map[string][]string {
 	return s.subscriptionsCh
 }
 
+// Generation returns the group generation as of the latest fetched
+// subscriptions. It is the number of times members joined or left the group,
+// and it is the same for all members of the group.
+func (s *T) Generation() int32 {
+	return atomic.LoadInt32(&s.generation)
+}
+
 // ClaimPartition claims a topic/partition to be consumed by this member of the
 // consumer group. It blocks until either succeeds or canceled by the caller. It
 // returns a function that should be called to release the claim.
@@ -144,7 +164,7 @@ func (s *T) run() {
 
 		case <-s.claimErrorsCh:
 			sinceLastSubmit := time.Now().Sub(submittedAt)
-			if sinceLastSubmit > s.cfg.Consumer.RetryBackoff {
+			if sinceLastSubmit > s.claimRetryBackoff {
 				s.actDesc.Log().Infof("Resubmit triggered by claim failure: since=%v", sinceLastSubmit)
 				shouldSubmitTopics = true
 			}
@@ -161,7 +181,7 @@ func (s *T) run() {
 		if shouldSubmitTopics {
 			if err = s.kazooModel.EnsureMemberSubscription(topics); err != nil {
 				s.actDesc.Log().WithError(err).Error("Failed to submit topics")
-				nilOrTimeoutCh = time.After(s.cfg.Consumer.RetryBackoff)
+				nilOrTimeoutCh = time.After(s.watchRetryBackoff)
 				continue
 			}
 			submittedAt = time.Now()
@@ -174,14 +194,16 @@ func (s *T) run() {
 		}
 
 		if shouldFetchSubscriptions {
-			subscriptions, nilOrWatchCh, cancelWatch, err = s.kazooModel.FetchGroupSubscriptions()
+			var generation int32
+			subscriptions, generation, nilOrWatchCh, cancelWatch, err = s.kazooModel.FetchGroupSubscriptions()
 			if err != nil {
 				s.actDesc.Log().WithError(err).Error("Failed to fetch subscriptions")
-				nilOrTimeoutCh = time.After(s.cfg.Consumer.RetryBackoff)
+				nilOrTimeoutCh = time.After(s.watchRetryBackoff)
 				continue
 			}
 			shouldFetchSubscriptions = false
-			s.actDesc.Log().Infof("Fetched subscriptions: %s", prettyfmt.Val(subscriptions))
+			atomic.StoreInt32(&s.generation, generation)
+			s.actDesc.Log().Infof("Fetched subscriptions: generation=%d, %s", generation, prettyfmt.Val(subscriptions))
 			nilOrSubscriptionsCh = s.subscriptionsCh
 
 			// If fetched topics are not the same as the current subscription
@@ -204,8 +226,17 @@ func (s *T) deleteMemberSubscription() {
 			break
 		}
 		s.actDesc.Log().WithError(err).Error("Failed to unregister")
-		<-time.After(s.cfg.Consumer.RetryBackoff)
+		<-time.After(s.releaseRetryBackoff)
+	}
+}
+
+// orRetryBackoff returns the specified backoff, or consumer.retry_backoff if
+// it is zero.
+func orRetryBackoff(cfg *config.Proxy, backoff time.Duration) time.Duration {
+	if backoff > 0 {
+		return backoff
 	}
+	return cfg.Consumer.RetryBackoff
 }
 
 type partitionClaimer struct {
@@ -239,13 +270,15 @@ func (pc *partitionClaimer) claim() func() {
 		}
 		// Wait until either the retry timeout expires or the claim is canceled.
 		select {
-		case <-time.After(pc.subscriber.cfg.Consumer.RetryBackoff):
+		case <-time.After(pc.subscriber.claimRetryBackoff):
 		case <-pc.cancelCh:
 			return func() {}
 		}
 	}
 	pc.actDesc.Log().Infof("Partition claimed: via=%s, retries=%d, took=%s",
 		pc.subscriber.actDesc, retries, time.Since(beginAt))
+	lifecycle.Publish(lifecycle.NewPartitionEvent(pc.subscriber.cfg, lifecycle.PartitionClaimed,
+		pc.subscriber.group, pc.topic, pc.partition))
 	return pc.release
 }
 
@@ -264,8 +297,10 @@ func (pc *partitionClaimer) release() {
 		}
 		logFailureFn("Failed to release partition: via=%s, retries=%d, took=%s",
 			pc.subscriber.actDesc, retries, time.Since(beginAt))
-		<-time.After(pc.subscriber.cfg.Consumer.RetryBackoff)
+		<-time.After(pc.subscriber.releaseRetryBackoff)
 	}
 	pc.actDesc.Log().Infof("Partition released: via=%s, retries=%d, took=%s",
 		pc.subscriber.actDesc, retries, time.Since(beginAt))
+	lifecycle.Publish(lifecycle.NewPartitionEvent(pc.subscriber.cfg, lifecycle.PartitionReleased,
+		pc.subscriber.group, pc.topic, pc.partition))
 }
@@ -459,6 +459,40 @@ func (s *SubscriberSuite) TestClaimPartitionCanceled(c *C) {
 	wg.Wait()
 }
 
+// An attempt to claim a partition owned by another member is retried after
+// the claim retry backoff rather than the general retry backoff.
+func (s *SubscriberSuite) TestClaimRetryBackoff(c *C) {
+	cfg1 := newConfig("m1")
+	ss1 := Spawn(s.ns.NewChild("m1"), "g1", cfg1, s.zkConn)
+	defer ss1.Stop()
+	cfg2 := newConfig("m2")
+	cfg2.Consumer.RetryBackoff = 50 * time.Millisecond
+	cfg2.Consumer.GroupMembership.ClaimRetryBackoff = time.Second
+	ss2 := Spawn(s.ns.NewChild("m2"), "g1", cfg2, s.zkConn)
+	defer ss2.Stop()
+	cancelCh := make(chan none.T)
+	defer close(cancelCh)
+
+	claim1 := ss1.ClaimPartition(s.ns, "foo", 1, cancelCh)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		claim1()
+	}()
+
+	// When
+	beginAt := time.Now()
+	claim2 := ss2.ClaimPartition(s.ns, "foo", 1, cancelCh)
+	defer claim2()
+
+	// Then
+	c.Check(time.Since(beginAt) >= time.Second, Equals, true)
+	c.Check(ss2.watchRetryBackoff, Equals, 50*time.Millisecond)
+	c.Check(ss2.releaseRetryBackoff, Equals, 50*time.Millisecond)
+	owner, err := ss2.kazooModel.GetPartitionOwner("foo", 1)
+	c.Assert(err, IsNil)
+	c.Assert(owner, Equals, "m2")
+}
+
 // Claim release should not fail if it is not this instance that owns the
 // partition. This situation can happen when a Kafka-Pixy looses connection
 // with ZooKeeper for longer then the ZooKeeper session timeout, so the rest
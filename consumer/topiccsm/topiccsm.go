@@ -2,6 +2,7 @@ package topiccsm
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,22 +11,25 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/lifecycle"
 )
 
 var (
 	requestTimeoutRs         = consumer.Response{Err: consumer.ErrRequestTimeout}
+	rsUnavailable            = consumer.Response{Err: consumer.ErrUnavailable}
 	safe2StopPollingInterval = 100 * time.Millisecond
 )
 
 // T serves consume requests received from childSpec.Requests() channel with
 // messages received from Messages() channel. The topic consumer expires and
-// shuts itself down when either of the following happens:
+// shuts itself down when either there has been an unsubscribe request, or
+// one of the following happens:
 // * there has been no requests for Consumer.SubscriptionTimeout and
 //   isSafe2StopFn returns true;
 // * there has been no requests for max value of Consumer.SubscriptionTimeout
 //   and Consumer.AckTimeout
 //
-// implements `multiplexer.Out`.
+// implements `multiplexer.AffineOut`.
 type T struct {
 	actDesc       *actor.Descriptor
 	childSpec     dispatcher.ChildSpec
@@ -34,13 +38,20 @@ type T struct {
 	topic         string
 	lifespanCh    chan<- *T
 	isSafe2StopFn func() bool
+	generationFn  func() int32
 	messagesCh    chan consumer.Message
-	wg            sync.WaitGroup
+	affinityCh    chan int32
+	pendingRqs    []consumer.Request
+	// Set when an unsubscribe request is received while a consume request
+	// is being served.
+	unsubscribed bool
+	wg           sync.WaitGroup
 }
 
-// Spawn creates and starts a topic consumer instance.
+// Spawn creates and starts a topic consumer instance. generationFn is called
+// to get the group generation to be stamped on offered messages.
 func Spawn(parentActDesc *actor.Descriptor, group string, childSpec dispatcher.ChildSpec,
-	cfg *config.Proxy, lifespanCh chan<- *T, isSafe2StopFn func() bool,
+	cfg *config.Proxy, lifespanCh chan<- *T, isSafe2StopFn func() bool, generationFn func() int32,
 ) *T {
 	topic := string(childSpec.Key())
 	actDesc := parentActDesc.NewChild(fmt.Sprintf("%s", topic))
@@ -54,12 +65,15 @@ func Spawn(parentActDesc *actor.Descriptor, group string, childSpec dispatcher.C
 		topic:         topic,
 		lifespanCh:    lifespanCh,
 		isSafe2StopFn: isSafe2StopFn,
+		generationFn:  generationFn,
 
 		// Messages channel must be non-buffered. Otherwise we might end up
 		// buffering a message from a partition that no longer belongs to this
 		// consumer group member.
 		messagesCh: make(chan consumer.Message),
+		affinityCh: make(chan int32),
 	}
+	tc.actDesc.ObserveQueue("requests", func() int { return len(childSpec.Requests()) })
 	actor.Spawn(tc.actDesc, &tc.wg, tc.run)
 	return &tc
 }
@@ -74,36 +88,68 @@ func (tc *T) Messages() chan<- consumer.Message {
 	return tc.messagesCh
 }
 
+// implements `multiplexer.AffineOut`
+func (tc *T) Affinity() <-chan int32 {
+	return tc.affinityCh
+}
+
 func (tc *T) run() {
 	defer tc.childSpec.Dispose()
 	tc.lifespanCh <- tc
 	defer func() {
 		tc.lifespanCh <- tc
 	}()
+	// Pending requests have been taken off the requests channel, so the
+	// dispatcher does not know about them, hence they are answered here
+	// whatever way the topic consumer stops.
+	defer tc.replyPending(requestTimeoutRs)
 
 	latestRqTime := clock.Now().UTC()
 	expireTimer := clock.NewTimer(tc.cfg.Consumer.SubscriptionTimeout)
 	defer expireTimer.Stop()
 	for {
-		// Serve requests, and jump to the safe stop when they cease coming.
+		// Serve requests, and jump to the safe stop when they cease coming,
+		// or the topic is explicitly unsubscribed from.
 	serveRequests:
 		for {
-			select {
-			case consumeRq, ok := <-tc.childSpec.Requests():
-				if !ok {
-					tc.actDesc.Log().Info("Shutting down")
-					return
-				}
-				latestRqTime = tc.serveRequest(consumeRq)
-			case <-expireTimer.C():
-				sinceLatestRq := clock.Now().UTC().Sub(latestRqTime)
-				subscriptionTTL := tc.cfg.Consumer.SubscriptionTimeout - sinceLatestRq
-				if subscriptionTTL <= 0 {
-					tc.actDesc.Log().Info("Topic subscription expired")
-					goto wait4SafeStop
+			// Consume requests that are pending when the topic is
+			// unsubscribed from are rejected, clients that repeat them
+			// subscribe to the topic again.
+			if tc.unsubscribed {
+				tc.unsubscribed = false
+				tc.actDesc.Log().Info("Topic unsubscribed")
+				lifecycle.Publish(lifecycle.NewTopicEvent(tc.cfg, lifecycle.Unsubscribed, tc.group, tc.topic))
+				tc.replyPending(requestTimeoutRs)
+				goto wait4SafeStop
+			}
+			// Requests received while serving others go first.
+			consumeRq, ok := tc.popPending()
+			if !ok {
+				select {
+				case consumeRq, ok = <-tc.childSpec.Requests():
+					if !ok {
+						tc.actDesc.Log().Info("Shutting down")
+						tc.replyPending(rsUnavailable)
+						return
+					}
+				case <-expireTimer.C():
+					sinceLatestRq := clock.Now().UTC().Sub(latestRqTime)
+					subscriptionTTL := tc.cfg.Consumer.SubscriptionTimeout - sinceLatestRq
+					if subscriptionTTL <= 0 {
+						tc.actDesc.Log().Info("Topic subscription expired")
+						lifecycle.Publish(lifecycle.NewTopicEvent(tc.cfg, lifecycle.SubscriptionExpired, tc.group, tc.topic))
+						goto wait4SafeStop
+					}
+					expireTimer.Reset(subscriptionTTL)
+					continue
 				}
-				expireTimer.Reset(subscriptionTTL)
 			}
+			if consumeRq.Kind == consumer.RqUnsubscribe {
+				consumeRq.ResponseCh <- consumer.Response{}
+				tc.unsubscribed = true
+				continue
+			}
+			latestRqTime = tc.serveRequest(consumeRq)
 		}
 		// Keep polling isSafe2StopFn until it returns true or the ack timeout
 		// expires and then terminate. If new request arrives while waiting,
@@ -114,7 +160,7 @@ func (tc *T) run() {
 				return
 			}
 			sinceLatestRq := clock.Now().UTC().Sub(latestRqTime)
-			if sinceLatestRq >= tc.cfg.Consumer.AckTimeout {
+			if sinceLatestRq >= tc.cfg.MaxAckTimeout() {
 				tc.actDesc.Log().Error("Stopping unsafely, some messages can be consumed more than once")
 				return
 			}
@@ -122,8 +168,13 @@ func (tc *T) run() {
 			case consumeRq, ok := <-tc.childSpec.Requests():
 				if !ok {
 					tc.actDesc.Log().Info("Signaled to shutdown")
+					tc.replyPending(rsUnavailable)
 					return
 				}
+				if consumeRq.Kind == consumer.RqUnsubscribe {
+					consumeRq.ResponseCh <- consumer.Response{}
+					continue
+				}
 				tc.actDesc.Log().Info("Resume request handling")
 				latestRqTime = tc.serveRequest(consumeRq)
 				subscriptionTTL := tc.cfg.Consumer.SubscriptionTimeout - sinceLatestRq
@@ -139,24 +190,109 @@ func (tc *T) String() string {
 	return tc.actDesc.String()
 }
 
+// serveRequest waits for a message to serve the request with. Requests that
+// arrive in the meantime are received into the pending list, and if one of
+// them has higher priority, then it is served first, and the original request
+// is put to the pending list instead. Subscribe and unsubscribe requests are
+// answered right away.
 func (tc *T) serveRequest(consumeRq consumer.Request) time.Time {
+	tc.actDesc.Touch()
 	latestRqTime := clock.Now().UTC()
-	requestAge := latestRqTime.Sub(consumeRq.Timestamp)
-	requestTTL := tc.cfg.Consumer.LongPollingTimeout - requestAge
-	// The request has been waiting in the buffer for too long. If we
-	// reply with a fetched message, then there is a good chance that the
-	// client won't receive it due to the client HTTP timeout. Therefore
-	// we reject the request to avoid message loss.
-	if requestTTL <= 0 {
-		consumeRq.ResponseCh <- requestTimeoutRs
+	// The topic consumer is subscribed to the topic for as long as it runs.
+	if consumeRq.Kind == consumer.RqSubscribe {
+		consumeRq.ResponseCh <- consumer.Response{}
 		return latestRqTime
 	}
-	select {
-	case msg := <-tc.messagesCh:
-		msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: msg.Offset}
-		consumeRq.ResponseCh <- consumer.Response{Msg: msg}
-	case <-clock.After(requestTTL):
-		consumeRq.ResponseCh <- requestTimeoutRs
+	requestsCh := tc.childSpec.Requests()
+	for {
+		requestAge := clock.Now().UTC().Sub(consumeRq.Timestamp)
+		requestTTL := tc.cfg.Consumer.LongPollingTimeout - requestAge
+		// The request has been waiting in the buffer for too long. If we
+		// reply with a fetched message, then there is a good chance that the
+		// client won't receive it due to the client HTTP timeout. Therefore
+		// we reject the request to avoid message loss.
+		if requestTTL <= 0 {
+			consumeRq.ResponseCh <- requestTimeoutRs
+			return latestRqTime
+		}
+		// Pending requests are limited the same way as the buffered ones.
+		nilOrRequestsCh := requestsCh
+		if len(tc.pendingRqs) >= tc.cfg.Consumer.ChannelBufferSize {
+			nilOrRequestsCh = nil
+		}
+		timeoutCh := clock.After(requestTTL)
+		if consumeRq.Affinity != consumer.NoAffinity {
+			select {
+			case tc.affinityCh <- consumeRq.Affinity:
+			case <-timeoutCh:
+				consumeRq.ResponseCh <- requestTimeoutRs
+				return latestRqTime
+			}
+		}
+		select {
+		case msg := <-tc.messagesCh:
+			msg.MemberID = tc.cfg.ClientID
+			msg.Generation = tc.generationFn()
+			msg.RequestID = consumeRq.RequestID
+			msg.EventsCh <- consumer.Event{T: consumer.EvOffered, Offset: msg.Offset, RequestID: consumeRq.RequestID}
+			consumeRq.ResponseCh <- consumer.Response{Msg: msg}
+			return latestRqTime
+		case <-timeoutCh:
+			consumeRq.ResponseCh <- requestTimeoutRs
+			return latestRqTime
+		case rq, ok := <-nilOrRequestsCh:
+			// The closed channel is detected by the run loop.
+			if !ok {
+				requestsCh = nil
+				continue
+			}
+			latestRqTime = clock.Now().UTC()
+			if rq.Kind == consumer.RqSubscribe {
+				rq.ResponseCh <- consumer.Response{}
+				continue
+			}
+			// Unsubscribe requests are not queued, for they would be
+			// served ahead of consume requests of lower priority.
+			if rq.Kind == consumer.RqUnsubscribe {
+				rq.ResponseCh <- consumer.Response{}
+				tc.unsubscribed = true
+				continue
+			}
+			if rq.Priority > consumeRq.Priority {
+				rq, consumeRq = consumeRq, rq
+			}
+			tc.pushPending(rq)
+		}
+	}
+}
+
+// pushPending adds a request to the pending list, keeping it ordered by
+// priority, and by arrival among requests of the same priority.
+func (tc *T) pushPending(consumeRq consumer.Request) {
+	i := sort.Search(len(tc.pendingRqs), func(i int) bool {
+		return tc.pendingRqs[i].Priority < consumeRq.Priority
+	})
+	tc.pendingRqs = append(tc.pendingRqs, consumer.Request{})
+	copy(tc.pendingRqs[i+1:], tc.pendingRqs[i:])
+	tc.pendingRqs[i] = consumeRq
+}
+
+// replyPending answers all pending requests with the given response.
+func (tc *T) replyPending(rs consumer.Response) {
+	for _, rq := range tc.pendingRqs {
+		rq.ResponseCh <- rs
+	}
+	tc.pendingRqs = nil
+}
+
+// popPending removes the request with the highest priority from the pending
+// list. It returns false if the list is empty.
+func (tc *T) popPending() (consumer.Request, bool) {
+	if len(tc.pendingRqs) == 0 {
+		return consumer.Request{}, false
 	}
-	return latestRqTime
+	consumeRq := tc.pendingRqs[0]
+	tc.pendingRqs[0] = consumer.Request{}
+	tc.pendingRqs = tc.pendingRqs[1:]
+	return consumeRq, true
 }
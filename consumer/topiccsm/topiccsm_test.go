@@ -67,6 +67,18 @@ func (s *TopicCsmSuite) isSafe2Stop() bool {
 	return s.safe2Stop
 }
 
+func (s *TopicCsmSuite) generation() int32 {
+	return 7
+}
+
+// offered returns a copy of the message stamped the way a topic consumer
+// stamps messages that it offers.
+func (s *TopicCsmSuite) offered(msg consumer.Message) consumer.Message {
+	msg.MemberID = s.cfg.ClientID
+	msg.Generation = s.generation()
+	return msg
+}
+
 func (s *TopicCsmSuite) setSafe2Stop(safe2Stop bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -76,7 +88,7 @@ func (s *TopicCsmSuite) setSafe2Stop(safe2Stop bool) {
 // Requests are processed in first come first served fashion. When a message is
 // is send in response to a requests it is also reported as Offered downstream.
 func (s *TopicCsmSuite) TestRequestResponse(c *C) {
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 	defer func() {
 		close(s.requestsCh) // Signal to stop.
@@ -102,18 +114,57 @@ func (s *TopicCsmSuite) TestRequestResponse(c *C) {
 	// Then
 	for i := 0; i < 10; i++ {
 		c.Assert(<-requests[i].ResponseCh, DeepEquals,
-			consumer.Response{Msg: messages[i]})
+			consumer.Response{Msg: s.offered(messages[i])})
 		c.Assert(<-eventsChs[i], DeepEquals,
 			consumer.Event{T: consumer.EvOffered, Offset: messages[i].Offset})
 	}
 }
 
+// Requests with higher priority are served first, and requests with the same
+// priority in first come first served fashion.
+func (s *TopicCsmSuite) TestRequestPriority(c *C) {
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	defer func() {
+		close(s.requestsCh) // Signal to stop.
+		<-s.lifespanCh      // Wait for it to do so.
+	}()
+
+	requests := make([]consumer.Request, 4)
+	for i, priority := range []int32{-1, 0, 1, 0} {
+		requests[i] = newRequest()
+		requests[i].Priority = priority
+	}
+	messages := make([]consumer.Message, 4)
+	for i := range messages {
+		messages[i], _ = newMessage(i)
+	}
+
+	// When
+	for _, rq := range requests {
+		s.requestsCh <- rq
+	}
+	// Make sure that all requests are received before messages are offered.
+	for len(s.requestsCh) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, msg := range messages {
+		tc.Messages() <- msg
+	}
+
+	// Then
+	for i, rqIdx := range []int{2, 1, 3, 0} {
+		rs := <-requests[rqIdx].ResponseCh
+		c.Assert(rs.Msg.Offset, Equals, messages[i].Offset, Commentf("request #%d", rqIdx))
+	}
+}
+
 // If request has been waiting for a message longer than
 // Consumer.LongPollingTimeout then it is rejected.
 func (s *TopicCsmSuite) TestLongPollingExpires(c *C) {
 	s.cfg.Consumer.LongPollingTimeout = 300
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 	defer func() {
 		close(s.requestsCh) // Signal to stop.
@@ -134,7 +185,7 @@ func (s *TopicCsmSuite) TestLongPollingExpires(c *C) {
 	s.requestsCh <- rq3
 	c.Assert(clock.Advance(297), Equals, time.Duration(299))
 	tc.Messages() <- msg1
-	assertResponse(c, rq1, consumer.Response{Msg: msg1}, time.Second)
+	assertResponse(c, rq1, consumer.Response{Msg: s.offered(msg1)}, time.Second)
 
 	// When: The rq2 expires, but rq3 still has 1 ns to last.
 	c.Assert(clock.Advance(2), Equals, time.Duration(301))
@@ -142,14 +193,14 @@ func (s *TopicCsmSuite) TestLongPollingExpires(c *C) {
 	// Then
 	assertResponse(c, rq2, requestTimeoutRs, time.Second)
 	tc.Messages() <- msg2
-	assertResponse(c, rq3, consumer.Response{Msg: msg2}, time.Second)
+	assertResponse(c, rq3, consumer.Response{Msg: s.offered(msg2)}, time.Second)
 }
 
 // Stale requests are rejected immediately.
 func (s *TopicCsmSuite) TestStaleRequest(c *C) {
 	s.cfg.Consumer.LongPollingTimeout = 300
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 	defer func() {
 		close(s.requestsCh) // Signal to stop.
@@ -172,7 +223,7 @@ func (s *TopicCsmSuite) TestSubscriptionExpires(c *C) {
 	s.cfg.Consumer.SubscriptionTimeout = 500
 	s.cfg.Consumer.AckTimeout = 300
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 
 	c.Assert(clock.Advance(499), Equals, time.Duration(499))
@@ -198,7 +249,7 @@ func (s *TopicCsmSuite) TestAckTimeoutExpires(c *C) {
 	s.setSafe2Stop(false)
 	safe2StopPollingInterval = 5
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 
 	// When/Then
@@ -224,7 +275,7 @@ func (s *TopicCsmSuite) TestAckTimeoutRequest(c *C) {
 	s.setSafe2Stop(false)
 	safe2StopPollingInterval = 5
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 
 	c.Assert(clock.Advance(500), Equals, time.Duration(500))
@@ -255,7 +306,7 @@ func (s *TopicCsmSuite) TestAckTimeoutStop(c *C) {
 	s.setSafe2Stop(false)
 	safe2StopPollingInterval = 5
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 
 	c.Assert(clock.Advance(500), Equals, time.Duration(500))
@@ -278,7 +329,7 @@ func (s *TopicCsmSuite) TestAckTimeoutSafe(c *C) {
 	s.setSafe2Stop(false)
 	safe2StopPollingInterval = 5
 
-	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop)
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
 	c.Assert(<-s.lifespanCh, Equals, tc)
 
 	c.Assert(clock.Advance(500), Equals, time.Duration(500))
@@ -296,9 +347,176 @@ func (s *TopicCsmSuite) TestAckTimeoutSafe(c *C) {
 	assertStopped(c, s.lifespanCh, time.Second)
 }
 
+// A subscribe request is served with an empty response right away, and it
+// prolongs the subscription the same way as a consume request.
+func (s *TopicCsmSuite) TestSubscribe(c *C) {
+	s.cfg.Consumer.SubscriptionTimeout = 500
+	s.cfg.Consumer.AckTimeout = 300
+
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	c.Assert(clock.Advance(499), Equals, time.Duration(499))
+
+	// When
+	rq := newRequest()
+	rq.Kind = consumer.RqSubscribe
+	s.requestsCh <- rq
+
+	// Then
+	assertResponse(c, rq, consumer.Response{}, time.Second)
+	c.Assert(clock.Advance(499), Equals, time.Duration(998))
+	assertRunning(c, s.lifespanCh, 50*time.Millisecond)
+	c.Assert(clock.Advance(1), Equals, time.Duration(999))
+	assertStopped(c, s.lifespanCh, time.Second)
+}
+
+// A subscribe request received while a consume request is waiting for a
+// message is served right away.
+func (s *TopicCsmSuite) TestSubscribeWhileServing(c *C) {
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	defer func() {
+		close(s.requestsCh)
+		<-s.lifespanCh
+	}()
+	consumeRq := newRequest()
+	s.requestsCh <- consumeRq
+
+	// When
+	subscribeRq := newRequest()
+	subscribeRq.Kind = consumer.RqSubscribe
+	s.requestsCh <- subscribeRq
+
+	// Then
+	assertResponse(c, subscribeRq, consumer.Response{}, time.Second)
+	msg, _ := newMessage(42)
+	tc.Messages() <- msg
+	assertResponse(c, consumeRq, consumer.Response{Msg: s.offered(msg)}, time.Second)
+}
+
+// An unsubscribe request makes the topic consumer stop as soon as it is safe,
+// without waiting for the subscription to expire.
+func (s *TopicCsmSuite) TestUnsubscribe(c *C) {
+	s.cfg.Consumer.SubscriptionTimeout = 500
+	s.cfg.Consumer.AckTimeout = 700
+	s.setSafe2Stop(false)
+	safe2StopPollingInterval = 5
+
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	s.requestsCh <- newRequest()
+	msg, _ := newMessage(42)
+	tc.Messages() <- msg
+	c.Assert(clock.Advance(100), Equals, time.Duration(100))
+
+	// When
+	rq := newRequest()
+	rq.Kind = consumer.RqUnsubscribe
+	s.requestsCh <- rq
+
+	// Then
+	assertResponse(c, rq, consumer.Response{}, time.Second)
+	assertRunning(c, s.lifespanCh, 50*time.Millisecond)
+	s.setSafe2Stop(true)
+	c.Assert(clock.Advance(5), Equals, time.Duration(105))
+	assertStopped(c, s.lifespanCh, time.Second)
+}
+
+// A consume request received after an unsubscribe one while it is not safe
+// to stop yet resumes the subscription.
+func (s *TopicCsmSuite) TestUnsubscribeResumed(c *C) {
+	s.cfg.Consumer.SubscriptionTimeout = 500
+	s.cfg.Consumer.AckTimeout = 700
+	s.setSafe2Stop(false)
+	safe2StopPollingInterval = 5
+
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	defer func() {
+		close(s.requestsCh)
+		<-s.lifespanCh
+	}()
+	unsubscribeRq := newRequest()
+	unsubscribeRq.Kind = consumer.RqUnsubscribe
+	s.requestsCh <- unsubscribeRq
+	assertResponse(c, unsubscribeRq, consumer.Response{}, time.Second)
+
+	// When
+	consumeRq := newRequest()
+	s.requestsCh <- consumeRq
+	msg, _ := newMessage(42)
+	tc.Messages() <- msg
+
+	// Then
+	assertResponse(c, consumeRq, consumer.Response{Msg: s.offered(msg)}, time.Second)
+	s.setSafe2Stop(true)
+	c.Assert(clock.Advance(5), Equals, time.Duration(5))
+	assertRunning(c, s.lifespanCh, 50*time.Millisecond)
+}
+
+// Requests still pending when the topic consumer stops are answered.
+func (s *TopicCsmSuite) TestPendingAnsweredOnStop(c *C) {
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	consumeRq1 := newRequest()
+	unsubscribeRq := newRequest()
+	unsubscribeRq.Kind = consumer.RqUnsubscribe
+	consumeRq2 := newRequest()
+	consumeRq2.Priority = -1
+	s.requestsCh <- consumeRq1
+	s.requestsCh <- unsubscribeRq
+	s.requestsCh <- consumeRq2
+	// Make sure that all requests are received before a message is offered.
+	for len(s.requestsCh) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// When
+	msg, _ := newMessage(42)
+	tc.Messages() <- msg
+
+	// Then
+	assertResponse(c, consumeRq1, consumer.Response{Msg: s.offered(msg)}, time.Second)
+	assertResponse(c, unsubscribeRq, consumer.Response{}, time.Second)
+	assertStopped(c, s.lifespanCh, time.Second)
+	assertResponse(c, consumeRq2, requestTimeoutRs, time.Second)
+}
+
+// An unsubscribe request received while a consume request of negative
+// priority is served does not take its place, it is answered right away, and
+// consume requests pending behind it are rejected.
+func (s *TopicCsmSuite) TestUnsubscribeWhileServing(c *C) {
+	tc := Spawn(s.ns, group, s.childSpec, s.cfg, s.lifespanCh, s.isSafe2Stop, s.generation)
+	c.Assert(<-s.lifespanCh, Equals, tc)
+	consumeRq1 := newRequest()
+	consumeRq1.Priority = -1
+	s.requestsCh <- consumeRq1
+
+	// When
+	unsubscribeRq := newRequest()
+	unsubscribeRq.Kind = consumer.RqUnsubscribe
+	s.requestsCh <- unsubscribeRq
+	consumeRq2 := newRequest()
+	consumeRq2.Priority = -1
+	s.requestsCh <- consumeRq2
+
+	// Then
+	assertResponse(c, unsubscribeRq, consumer.Response{}, time.Second)
+	// Make sure that all requests are received before a message is offered.
+	for len(s.requestsCh) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	msg, _ := newMessage(42)
+	tc.Messages() <- msg
+	assertResponse(c, consumeRq1, consumer.Response{Msg: s.offered(msg)}, time.Second)
+	assertResponse(c, consumeRq2, requestTimeoutRs, time.Second)
+	assertStopped(c, s.lifespanCh, time.Second)
+}
+
 func newRequest() consumer.Request {
 	return consumer.Request{
 		Timestamp:  clock.Now().UTC(),
+		Affinity:   consumer.NoAffinity,
 		ResponseCh: make(chan consumer.Response, 1),
 	}
 }
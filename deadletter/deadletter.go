@@ -0,0 +1,141 @@
+// Package deadletter keeps messages that the producer failed to deliver to
+// Kafka after all retries, so that they are not lost. Dead letters are either
+// appended to a spool file in a local directory, or produced to a fallback
+// topic, possibly on another cluster.
+package deadletter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Headers that dead letters produced to a fallback topic are given.
+	HeaderTopic = "X-Dead-Letter-Topic"
+	HeaderError = "X-Dead-Letter-Error"
+)
+
+// Letter is a message that failed to be produced, along with the reason.
+type Letter struct {
+	Topic    string    `json:"topic"`
+	Key      []byte    `json:"key"`
+	Value    []byte    `json:"value"`
+	Headers  []Header  `json:"headers,omitempty"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Header is a record header of a dead letter.
+type Header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Stats describes what happened to dead letters since start.
+type Stats struct {
+	// Dead letters that were written to the sink.
+	WrittenMsgs int64
+
+	// Dead letters that failed to be written to the sink, and were lost.
+	FailedMsgs int64
+}
+
+type writer interface {
+	write(letter *Letter) error
+	close()
+}
+
+// T writes dead letters to a sink in the background.
+type T struct {
+	actDesc  *actor.Descriptor
+	writer   writer
+	letterCh chan *Letter
+	wg       sync.WaitGroup
+
+	// Updated atomically by the writer goroutine.
+	writtenMsgs int64
+	failedMsgs  int64
+}
+
+// Spawn creates a dead letter sink for the proxy config `cfg`, and starts its
+// goroutine. If a fallback topic is configured, then dead letters are
+// produced to the cluster configured by `fallbackCfg`. It returns nil if no
+// sink is configured.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, fallbackCfg *config.Proxy) (*T, error) {
+	deadLetterCfg := cfg.Producer.DeadLetter
+	t := T{
+		actDesc:  parentActDesc.NewChild("dead_letter"),
+		letterCh: make(chan *Letter, cfg.Producer.ChannelBufferSize),
+	}
+	var err error
+	switch {
+	case deadLetterCfg.SpoolDir != "":
+		if t.writer, err = newFileWriter(deadLetterCfg.SpoolDir, cfg.Cluster); err != nil {
+			return nil, errors.Wrap(err, "failed to open spool file")
+		}
+	case deadLetterCfg.Topic != "":
+		if t.writer, err = newTopicWriter(fallbackCfg, deadLetterCfg.Topic); err != nil {
+			return nil, errors.Wrap(err, "failed to create fallback producer")
+		}
+	default:
+		return nil, nil
+	}
+	actor.Spawn(t.actDesc, &t.wg, t.run)
+	return &t, nil
+}
+
+// Put queues a message that failed to be produced with `cause` to be written
+// to the sink. It blocks if the queue is full.
+func (t *T) Put(msg *sarama.ProducerMessage, cause error) {
+	letter := Letter{
+		Topic:    msg.Topic,
+		Error:    cause.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	letter.Key, _ = encode(msg.Key)
+	letter.Value, _ = encode(msg.Value)
+	for _, h := range msg.Headers {
+		letter.Headers = append(letter.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	t.letterCh <- &letter
+}
+
+// Stats returns what happened to dead letters since start.
+func (t *T) Stats() Stats {
+	return Stats{
+		WrittenMsgs: atomic.LoadInt64(&t.writtenMsgs),
+		FailedMsgs:  atomic.LoadInt64(&t.failedMsgs),
+	}
+}
+
+// Stop writes all queued dead letters and releases the sink. It must be called
+// after the producer that puts dead letters is stopped.
+func (t *T) Stop() {
+	close(t.letterCh)
+	t.wg.Wait()
+}
+
+func (t *T) run() {
+	defer t.writer.close()
+	for letter := range t.letterCh {
+		if err := t.writer.write(letter); err != nil {
+			atomic.AddInt64(&t.failedMsgs, 1)
+			t.actDesc.Log().WithError(err).Errorf("Lost dead letter: topic=%s, cause=%s", letter.Topic, letter.Error)
+			continue
+		}
+		atomic.AddInt64(&t.writtenMsgs, 1)
+	}
+}
+
+func encode(e sarama.Encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return e.Encode()
+}
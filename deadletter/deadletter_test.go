@@ -0,0 +1,109 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DeadLetterSuite struct {
+	dir string
+	cfg *config.Proxy
+}
+
+var _ = Suite(&DeadLetterSuite{})
+
+func (s *DeadLetterSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "deadletter")
+	c.Assert(err, IsNil)
+	s.cfg = config.DefaultProxy()
+	s.cfg.Cluster = "foo"
+}
+
+func (s *DeadLetterSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+// If neither a spool directory nor a topic is configured, then there is no
+// sink.
+func (s *DeadLetterSuite) TestSpawnDisabled(c *C) {
+	dl, err := Spawn(actor.Root(), s.cfg, s.cfg)
+	c.Check(err, IsNil)
+	c.Check(dl, IsNil)
+}
+
+// Dead letters are appended to a spool file named after the cluster, along
+// with the reason of the failure.
+func (s *DeadLetterSuite) TestSpoolFile(c *C) {
+	s.cfg.Producer.DeadLetter.SpoolDir = filepath.Join(s.dir, "spool")
+	dl, err := Spawn(actor.Root(), s.cfg, s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	dl.Put(&sarama.ProducerMessage{
+		Topic:   "bar",
+		Key:     sarama.StringEncoder("key1"),
+		Value:   sarama.StringEncoder("msg1"),
+		Headers: []sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("v1")}},
+	}, sarama.ErrNotEnoughReplicas)
+	dl.Put(&sarama.ProducerMessage{
+		Topic: "bar",
+		Value: sarama.StringEncoder("msg2"),
+	}, errors.New("kaboom"))
+	dl.Stop()
+
+	// Then
+	c.Check(dl.Stats(), Equals, Stats{WrittenMsgs: 2})
+	file, err := os.Open(filepath.Join(s.dir, "spool", "foo.jsonl"))
+	c.Assert(err, IsNil)
+	defer file.Close()
+	var letters []Letter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter Letter
+		c.Assert(json.Unmarshal(scanner.Bytes(), &letter), IsNil)
+		c.Check(letter.FailedAt.IsZero(), Equals, false)
+		letters = append(letters, letter)
+	}
+	c.Assert(letters, HasLen, 2)
+	c.Check(letters[0].Topic, Equals, "bar")
+	c.Check(string(letters[0].Key), Equals, "key1")
+	c.Check(string(letters[0].Value), Equals, "msg1")
+	c.Check(letters[0].Headers, DeepEquals, []Header{{Key: []byte("h1"), Value: []byte("v1")}})
+	c.Check(letters[0].Error, Equals, sarama.ErrNotEnoughReplicas.Error())
+	c.Check(letters[1].Key, IsNil)
+	c.Check(string(letters[1].Value), Equals, "msg2")
+	c.Check(letters[1].Headers, IsNil)
+	c.Check(letters[1].Error, Equals, "kaboom")
+}
+
+// Dead letters are appended to an existing spool file.
+func (s *DeadLetterSuite) TestSpoolFileAppend(c *C) {
+	s.cfg.Producer.DeadLetter.SpoolDir = s.dir
+	for i := 0; i < 2; i++ {
+		dl, err := Spawn(actor.Root(), s.cfg, s.cfg)
+		c.Assert(err, IsNil)
+		dl.Put(&sarama.ProducerMessage{Topic: "bar", Value: sarama.StringEncoder("msg")}, errors.New("kaboom"))
+		dl.Stop()
+	}
+
+	// Then
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "foo.jsonl"))
+	c.Assert(err, IsNil)
+	c.Check(bytes.Count(data, []byte("\n")), Equals, 2)
+}
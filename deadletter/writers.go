@@ -0,0 +1,82 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// fileWriter appends dead letters to a spool file as JSON lines.
+type fileWriter struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newFileWriter(dir, cluster string) (*fileWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, cluster+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (w *fileWriter) write(letter *Letter) error {
+	if err := w.enc.Encode(letter); err != nil {
+		return err
+	}
+	// A dead letter is the last resort, so it is flushed to disk right away.
+	return w.file.Sync()
+}
+
+func (w *fileWriter) close() {
+	w.file.Close()
+}
+
+// topicWriter produces dead letters to a fallback topic.
+type topicWriter struct {
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func newTopicWriter(cfg *config.Proxy, topic string) (*topicWriter, error) {
+	saramaCfg := cfg.SaramaProducerCfg()
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Producer.Return.Errors = true
+	producer, err := sarama.NewSyncProducer(cfg.Kafka.SeedPeers, saramaCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &topicWriter{topic: topic, producer: producer}, nil
+}
+
+func (w *topicWriter) write(letter *Letter) error {
+	msg := &sarama.ProducerMessage{
+		Topic: w.topic,
+		Value: sarama.ByteEncoder(letter.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(HeaderTopic), Value: []byte(letter.Topic)},
+			{Key: []byte(HeaderError), Value: []byte(letter.Error)},
+		},
+	}
+	if letter.Key != nil {
+		msg.Key = sarama.ByteEncoder(letter.Key)
+	}
+	for _, h := range letter.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	if _, _, err := w.producer.SendMessage(msg); err != nil {
+		return errors.Wrapf(err, "failed to produce to %s", w.topic)
+	}
+	return nil
+}
+
+func (w *topicWriter) close() {
+	w.producer.Close()
+}
@@ -0,0 +1,93 @@
+// Package dedup implements a de-duplication window that remembers IDs of
+// messages delivered within a period of time, so that messages produced
+// more than once, e.g. due to producer retries, are delivered only once.
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxKeys = 100000
+)
+
+// Window remembers IDs of recently delivered messages. It is safe for
+// concurrent use.
+type Window struct {
+	window  time.Duration
+	maxKeys int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type entry struct {
+	id        string
+	partition int32
+	offset    int64
+	expiresAt time.Time
+}
+
+// New creates a de-duplication window. If maxKeys is zero, then the default
+// limit is used.
+func New(window time.Duration, maxKeys int) *Window {
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	return &Window{
+		window:  window,
+		maxKeys: maxKeys,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// IsDuplicate records delivery of a message with the specified ID and
+// returns true if a message with the same ID but a different partition or
+// offset has already been delivered within the window. Repeated delivery of
+// the very same message, e.g. when it was not acknowledged in time, is not
+// considered a duplicate.
+func (w *Window) IsDuplicate(id string, partition int32, offset int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.expire(now)
+	if el, ok := w.entries[id]; ok {
+		e := el.Value.(*entry)
+		return e.partition != partition || e.offset != offset
+	}
+	if w.order.Len() >= w.maxKeys {
+		w.remove(w.order.Front())
+	}
+	e := &entry{id: id, partition: partition, offset: offset, expiresAt: now.Add(w.window)}
+	w.entries[id] = w.order.PushBack(e)
+	return false
+}
+
+// Len returns the number of remembered IDs.
+func (w *Window) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.order.Len()
+}
+
+// expire forgets IDs that were delivered longer than the window ago. Since
+// all entries have the same lifetime, the list is ordered by expiration.
+func (w *Window) expire(now time.Time) {
+	for el := w.order.Front(); el != nil; el = w.order.Front() {
+		if now.Before(el.Value.(*entry).expiresAt) {
+			return
+		}
+		w.remove(el)
+	}
+}
+
+func (w *Window) remove(el *list.Element) {
+	w.order.Remove(el)
+	delete(w.entries, el.Value.(*entry).id)
+}
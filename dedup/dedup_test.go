@@ -0,0 +1,65 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DedupSuite struct{}
+
+var _ = Suite(&DedupSuite{})
+
+func (s *DedupSuite) TestDuplicate(c *C) {
+	w := New(time.Minute, 0)
+
+	c.Check(w.IsDuplicate("a", 0, 1), Equals, false)
+	c.Check(w.IsDuplicate("b", 0, 2), Equals, false)
+	c.Check(w.IsDuplicate("a", 1, 7), Equals, true)
+	c.Check(w.IsDuplicate("a", 0, 3), Equals, true)
+}
+
+// Redelivery of the same message is not a duplicate.
+func (s *DedupSuite) TestRedelivery(c *C) {
+	w := New(time.Minute, 0)
+
+	c.Check(w.IsDuplicate("a", 0, 1), Equals, false)
+	c.Check(w.IsDuplicate("a", 0, 1), Equals, false)
+}
+
+func (s *DedupSuite) TestExpire(c *C) {
+	now := time.Unix(1000, 0)
+	w := New(time.Minute, 0)
+	w.now = func() time.Time { return now }
+	c.Check(w.IsDuplicate("a", 0, 1), Equals, false)
+	now = now.Add(30 * time.Second)
+	c.Check(w.IsDuplicate("b", 0, 2), Equals, false)
+
+	// When
+	now = now.Add(30 * time.Second)
+
+	// Then
+	c.Check(w.IsDuplicate("a", 0, 3), Equals, false)
+	c.Check(w.IsDuplicate("b", 0, 4), Equals, true)
+	c.Check(w.Len(), Equals, 2)
+}
+
+// When the limit is reached the oldest IDs are forgotten first.
+func (s *DedupSuite) TestMaxKeys(c *C) {
+	w := New(time.Minute, 2)
+	c.Check(w.IsDuplicate("a", 0, 1), Equals, false)
+	c.Check(w.IsDuplicate("b", 0, 2), Equals, false)
+
+	// When
+	c.Check(w.IsDuplicate("c", 0, 3), Equals, false)
+
+	// Then
+	c.Check(w.Len(), Equals, 2)
+	c.Check(w.IsDuplicate("b", 0, 4), Equals, true)
+	c.Check(w.IsDuplicate("a", 0, 5), Equals, false)
+}
@@ -0,0 +1,270 @@
+// Package election elects one of Kafka-Pixy instances that share an election
+// name as the active one, so that the others can stand by to take over when
+// it goes away. It implements the standard ZooKeeper leader election recipe:
+// every candidate creates an ephemeral sequential znode, and the one with the
+// lowest sequence number is the leader. A leader holds its leadership for as
+// long as its ZooKeeper session lives, so a standby takes over within the
+// session timeout after the leader dies.
+package election
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/backoff"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	candidatePrefix = "candidate-"
+	// ZooKeeper appends 10 digit sequence numbers to sequential znodes.
+	sequenceLen = 10
+)
+
+var (
+	errStopped = errors.New("stopped")
+	errLost    = errors.New("candidate znode is gone")
+
+	retryBackoff = backoff.Exponential{Base: 100 * time.Millisecond, Cap: 5 * time.Second, Jitter: 0.2}
+)
+
+// T is a candidate in an election.
+type T struct {
+	actDesc  *actor.Descriptor
+	zkConn   *zk.Conn
+	dir      string
+	clientID string
+	// A leader that cannot reach ZooKeeper for this long assumes that its
+	// session has expired, and another candidate has been elected.
+	sessionTimeout time.Duration
+	electedCh      chan struct{}
+	lostCh         chan struct{}
+	stopCh         chan struct{}
+	wg             sync.WaitGroup
+}
+
+// Spawn runs for leadership in the election with the specified name, on the
+// ZooKeeper cluster of `cfg`. Candidates are identified by client IDs.
+func Spawn(parentActDesc *actor.Descriptor, name string, cfg *config.Proxy) (*T, error) {
+	zkConn, _, err := zk.Connect(cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.SessionTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ZooKeeper")
+	}
+	e := &T{
+		actDesc:        parentActDesc.NewChild("election"),
+		zkConn:         zkConn,
+		dir:            fmt.Sprintf("%s/kafka-pixy/elections/%s", cfg.ZooKeeper.Chroot, name),
+		clientID:       cfg.ClientID,
+		sessionTimeout: cfg.ZooKeeper.SessionTimeout,
+		electedCh:      make(chan struct{}),
+		lostCh:         make(chan struct{}),
+		stopCh:         make(chan struct{}),
+	}
+	actor.Spawn(e.actDesc, &e.wg, e.run)
+	return e, nil
+}
+
+// Elected returns a channel that is closed when this candidate becomes the
+// leader.
+func (e *T) Elected() <-chan struct{} {
+	return e.electedCh
+}
+
+// Lost returns a channel that is closed when this candidate loses the
+// leadership it has been elected to, e.g. because its ZooKeeper session
+// expired. Another candidate may be elected after that, so the leader should
+// stop serving.
+func (e *T) Lost() <-chan struct{} {
+	return e.lostCh
+}
+
+// Stop withdraws from the election, giving up the leadership if held. The
+// candidate znode is deleted by ZooKeeper when the session is closed, so the
+// next candidate is elected right away.
+func (e *T) Stop() {
+	close(e.stopCh)
+	e.wg.Wait()
+	e.zkConn.Close()
+}
+
+func (e *T) run() {
+	var candidate string
+	var err error
+	for retries := 0; ; retries++ {
+		if retries > 0 {
+			select {
+			case <-time.After(retryBackoff.Backoff(retries)):
+			case <-e.stopCh:
+				return
+			}
+		}
+		if candidate == "" {
+			if candidate, err = e.createCandidate(); err != nil {
+				e.actDesc.Log().WithError(err).Error("Failed to run for leadership")
+				continue
+			}
+		}
+		err = e.waitForTurn(candidate)
+		switch {
+		case err == errStopped:
+			return
+		case err == errLost:
+			// The session has expired while standing by, so the candidate
+			// has to be created again.
+			e.actDesc.Log().Warnf("Candidate is gone, running again: %s", candidate)
+			candidate = ""
+			continue
+		case err != nil:
+			e.actDesc.Log().WithError(err).Error("Failed to wait for turn")
+			continue
+		}
+		e.actDesc.Log().Infof("Elected the leader: %s", candidate)
+		close(e.electedCh)
+		err = e.holdLeadership(candidate)
+		if err == errStopped {
+			return
+		}
+		e.actDesc.Log().WithError(err).Error("Leadership lost")
+		close(e.lostCh)
+		return
+	}
+}
+
+// createCandidate creates an ephemeral sequential znode of this candidate,
+// and returns its name. The znode is created in the protected mode, so that
+// it is not created twice if the connection is lost in the middle.
+func (e *T) createCandidate() (string, error) {
+	prefix := e.dir + "/" + candidatePrefix
+	for {
+		path, err := e.zkConn.CreateProtectedEphemeralSequential(prefix, []byte(e.clientID), zk.WorldACL(zk.PermAll))
+		if err == nil {
+			return path[len(e.dir)+1:], nil
+		}
+		if err != zk.ErrNoNode {
+			return "", errors.Wrapf(err, "failed to create %s", prefix)
+		}
+		if err := e.createDir(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// createDir creates the election directory along with all its ancestors.
+func (e *T) createDir() error {
+	path := ""
+	for _, name := range strings.Split(strings.TrimPrefix(e.dir, "/"), "/") {
+		path += "/" + name
+		_, err := e.zkConn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+	return nil
+}
+
+// waitForTurn blocks until the candidate has the lowest sequence number.
+// Every candidate watches the one just before it, so that only one is
+// notified when a candidate goes away.
+func (e *T) waitForTurn(candidate string) error {
+	for {
+		candidates, _, err := e.zkConn.Children(e.dir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list %s", e.dir)
+		}
+		sortBySequence(candidates)
+		i := indexOf(candidates, candidate)
+		if i < 0 {
+			return errLost
+		}
+		if i == 0 {
+			return nil
+		}
+		predecessor := e.dir + "/" + candidates[i-1]
+		e.actDesc.Log().Infof("Standing by: candidate=%s, predecessor=%s", candidate, candidates[i-1])
+		exists, _, eventCh, err := e.zkConn.ExistsW(predecessor)
+		if err != nil {
+			return errors.Wrapf(err, "failed to watch %s", predecessor)
+		}
+		if !exists {
+			continue
+		}
+		select {
+		case <-eventCh:
+		case <-e.stopCh:
+			return errStopped
+		}
+	}
+}
+
+// holdLeadership blocks until the candidate znode is gone, that happens when
+// the ZooKeeper session expires, or until ZooKeeper cannot be reached for
+// longer than the session timeout. In the latter case the session has
+// probably expired, but the leader cannot learn that until it reconnects.
+func (e *T) holdLeadership(candidate string) error {
+	path := e.dir + "/" + candidate
+	exists, _, eventCh, err := e.zkConn.ExistsW(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch %s", path)
+	}
+	if !exists {
+		return errLost
+	}
+	ticker := time.NewTicker(e.sessionTimeout / 4)
+	defer ticker.Stop()
+	var disconnectedSince time.Time
+	for {
+		select {
+		case <-eventCh:
+			if exists, _, eventCh, err = e.zkConn.ExistsW(path); err != nil {
+				return errors.Wrapf(err, "failed to watch %s", path)
+			}
+			if !exists {
+				return errLost
+			}
+		case <-ticker.C:
+			if e.zkConn.State() == zk.StateHasSession {
+				disconnectedSince = time.Time{}
+				continue
+			}
+			if disconnectedSince.IsZero() {
+				disconnectedSince = time.Now()
+			}
+			if time.Since(disconnectedSince) > e.sessionTimeout {
+				return errors.New("ZooKeeper unreachable for longer than session timeout")
+			}
+		case <-e.stopCh:
+			return errStopped
+		}
+	}
+}
+
+// sortBySequence sorts candidates by sequence numbers, that are zero padded
+// suffixes of their names. Names are prefixed with random IDs of the
+// protected mode, so they cannot be sorted as they are.
+func sortBySequence(candidates []string) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return sequenceOf(candidates[i]) < sequenceOf(candidates[j])
+	})
+}
+
+func sequenceOf(candidate string) string {
+	if len(candidate) < sequenceLen {
+		return candidate
+	}
+	return candidate[len(candidate)-sequenceLen:]
+}
+
+func indexOf(candidates []string, candidate string) int {
+	for i := range candidates {
+		if candidates[i] == candidate {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,84 @@
+package election
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ElectionSuite struct {
+	name string
+}
+
+var _ = Suite(&ElectionSuite{})
+
+func (s *ElectionSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ElectionSuite) SetUpTest(c *C) {
+	s.name = fmt.Sprintf("test-%d", time.Now().UnixNano())
+}
+
+// Candidates are sorted by sequence numbers regardless of protected mode
+// prefixes.
+func (s *ElectionSuite) TestSortBySequence(c *C) {
+	candidates := []string{
+		"_c_b1-candidate-0000000012",
+		"_c_a2-candidate-0000000002",
+		"_c_c3-candidate-0000000010",
+	}
+
+	// When
+	sortBySequence(candidates)
+
+	// Then
+	c.Check(candidates, DeepEquals, []string{
+		"_c_a2-candidate-0000000002",
+		"_c_c3-candidate-0000000010",
+		"_c_b1-candidate-0000000012",
+	})
+}
+
+// The first candidate is elected, and the next one is elected as soon as it
+// withdraws.
+func (s *ElectionSuite) TestFailover(c *C) {
+	e1, err := Spawn(actor.Root(), s.name, testhelpers.NewTestProxyCfg("c1"))
+	c.Assert(err, IsNil)
+	assertClosed(c, e1.Elected())
+	e2, err := Spawn(actor.Root(), s.name, testhelpers.NewTestProxyCfg("c2"))
+	c.Assert(err, IsNil)
+	defer e2.Stop()
+	assertOpen(c, e2.Elected())
+
+	// When
+	e1.Stop()
+
+	// Then
+	assertClosed(c, e2.Elected())
+	assertOpen(c, e2.Lost())
+}
+
+func assertClosed(c *C, ch <-chan struct{}) {
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		c.Error("Channel is not closed")
+	}
+}
+
+func assertOpen(c *C, ch <-chan struct{}) {
+	select {
+	case <-ch:
+		c.Error("Channel is closed")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
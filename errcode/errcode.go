@@ -0,0 +1,222 @@
+// Package errcode maps errors returned by Kafka-Pixy internals, Sarama and
+// ZooKeeper to a small stable set of error codes along with a hint whether a
+// failed request is worth retrying. The codes are reported to clients in HTTP
+// and gRPC error responses, so that they do not have to pattern-match error
+// messages that are subject to change.
+package errcode
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/archiver"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/lagslo"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/secureconn"
+	"github.com/mailgun/kafka-pixy/table"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/validator"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"google.golang.org/grpc/codes"
+)
+
+// Code is a stable machine readable error code.
+type Code string
+
+const (
+	InvalidArgument    Code = "invalid_argument"
+	Unauthenticated    Code = "unauthenticated"
+	Forbidden          Code = "forbidden"
+	NotFound           Code = "not_found"
+	FailedPrecondition Code = "failed_precondition"
+	Timeout            Code = "timeout"
+	ResourceExhausted  Code = "resource_exhausted"
+	Unavailable        Code = "unavailable"
+	Unimplemented      Code = "unimplemented"
+	EndOfStream        Code = "end_of_stream"
+	Internal           Code = "internal"
+)
+
+// Class is an error classification reported to clients.
+type Class struct {
+	Code      Code
+	Retryable bool
+}
+
+var (
+	invalidArgument    = Class{InvalidArgument, false}
+	unauthenticated    = Class{Unauthenticated, false}
+	forbidden          = Class{Forbidden, false}
+	notFound           = Class{NotFound, false}
+	failedPrecondition = Class{FailedPrecondition, false}
+	timeout            = Class{Timeout, true}
+	resourceExhausted  = Class{ResourceExhausted, true}
+	unavailable        = Class{Unavailable, true}
+	unimplemented      = Class{Unimplemented, false}
+	endOfStream        = Class{EndOfStream, false}
+	internal           = Class{Internal, false}
+
+	known = map[error]Class{
+		tenancy.ErrUnauthenticated:  unauthenticated,
+		tenancy.ErrForbidden:        forbidden,
+		proxy.ErrReadOnly:           forbidden,
+		proxy.ErrTopicNotAllowed:    forbidden,
+		proxy.ErrCallbackNotAllowed: forbidden,
+		proxy.ErrUnknownTopic:       notFound,
+		proxy.ErrDisabled:           unimplemented,
+		proxy.ErrUnavailable:        unavailable,
+		proxy.ErrLimitExceeded:      resourceExhausted,
+		proxy.ErrTooManyGroups:      resourceExhausted,
+		proxy.ErrHeadersUnsupported: failedPrecondition,
+		proxy.ErrConfigUnsupported:  failedPrecondition,
+		proxy.ErrMessageTooLarge:    invalidArgument,
+		proxy.ErrAckMetadataTooLong: invalidArgument,
+		proxy.ErrTableNotConfigured: failedPrecondition,
+		proxy.ErrStaleGeneration:    failedPrecondition,
+		proxy.ErrEndOfStream:        endOfStream,
+		proxy.ErrStopReached:        endOfStream,
+		proxy.ErrOutOfRange:         invalidArgument,
+		proxy.ErrNotConsumedHere:    failedPrecondition,
+		proxy.ErrGroupMigrating:     unavailable,
+		proxy.ErrGroupMigrated:      failedPrecondition,
+		proxy.ErrGroupActive:        failedPrecondition,
+		proxy.ErrBadMigration:       invalidArgument,
+		proxy.ErrMigrationInUse:     failedPrecondition,
+		proxy.ErrBadReplay:          invalidArgument,
+		consumer.ErrRequestTimeout:  timeout,
+		consumer.ErrUnavailable:     unavailable,
+		consumer.ErrTooManyRequests: resourceExhausted,
+		table.ErrNotReady:           unavailable,
+		table.ErrKeyNotFound:        notFound,
+		table.ErrIncomplete:         notFound,
+		lagslo.ErrNotFound:          notFound,
+		archiver.ErrNotFound:        notFound,
+		archiver.ErrReplayNotFound:  notFound,
+		validator.ErrRejected:       invalidArgument,
+		validator.ErrUnavailable:    unavailable,
+		context.DeadlineExceeded:    timeout,
+
+		secureconn.ErrBadCredentials: invalidArgument,
+
+		sarama.ErrOutOfBrokers:           unavailable,
+		sarama.ErrClosedClient:           unavailable,
+		sarama.ErrNotConnected:           unavailable,
+		sarama.ErrShuttingDown:           unavailable,
+		sarama.ErrControllerNotAvailable: unavailable,
+
+		zk.ErrConnectionClosed: unavailable,
+		zk.ErrSessionExpired:   unavailable,
+		zk.ErrClosing:          unavailable,
+		zk.ErrNoNode:           notFound,
+	}
+
+	kafkaErrors = map[sarama.KError]Class{
+		sarama.ErrUnknownTopicOrPartition:         notFound,
+		sarama.ErrOffsetOutOfRange:                invalidArgument,
+		sarama.ErrMessageSizeTooLarge:             invalidArgument,
+		sarama.ErrInvalidMessageSize:              invalidArgument,
+		sarama.ErrInvalidTopic:                    invalidArgument,
+		sarama.ErrRequestTimedOut:                 timeout,
+		sarama.ErrLeaderNotAvailable:              unavailable,
+		sarama.ErrNotLeaderForPartition:           unavailable,
+		sarama.ErrBrokerNotAvailable:              unavailable,
+		sarama.ErrReplicaNotAvailable:             unavailable,
+		sarama.ErrNetworkException:                unavailable,
+		sarama.ErrOffsetsLoadInProgress:           unavailable,
+		sarama.ErrConsumerCoordinatorNotAvailable: unavailable,
+		sarama.ErrNotCoordinatorForConsumer:       unavailable,
+		sarama.ErrNotEnoughReplicas:               unavailable,
+		sarama.ErrNotEnoughReplicasAfterAppend:    unavailable,
+		sarama.ErrRebalanceInProgress:             unavailable,
+		sarama.ErrKafkaStorageError:               unavailable,
+		sarama.ErrTopicAuthorizationFailed:        forbidden,
+		sarama.ErrGroupAuthorizationFailed:        forbidden,
+		sarama.ErrClusterAuthorizationFailed:      forbidden,
+	}
+)
+
+// Classify returns a classification of an error. The second returned value
+// is false if the error is not known to Kafka-Pixy, in which case the caller
+// is supposed to fall back to a classification derived from the response
+// status it is about to send.
+func Classify(err error) (Class, bool) {
+	if err == nil {
+		return Class{}, false
+	}
+	cause := errors.Cause(err)
+	if prodErr, ok := cause.(*sarama.ProducerError); ok {
+		cause = prodErr.Err
+	}
+	if class, ok := known[cause]; ok {
+		return class, true
+	}
+	if kafkaErr, ok := cause.(sarama.KError); ok {
+		if class, ok := kafkaErrors[kafkaErr]; ok {
+			return class, true
+		}
+		return internal, true
+	}
+	if netErr, ok := cause.(net.Error); ok {
+		if netErr.Timeout() {
+			return timeout, true
+		}
+		return unavailable, true
+	}
+	return Class{}, false
+}
+
+// FromHTTPStatus returns a classification that corresponds to an HTTP
+// response status code.
+func FromHTTPStatus(status int) Class {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return invalidArgument
+	case http.StatusUnauthorized:
+		return unauthenticated
+	case http.StatusForbidden:
+		return forbidden
+	case http.StatusNotFound:
+		return notFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return failedPrecondition
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return timeout
+	case http.StatusTooManyRequests:
+		return resourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return unavailable
+	case http.StatusNotImplemented:
+		return unimplemented
+	}
+	return internal
+}
+
+// FromGRPCCode returns a classification that corresponds to a gRPC status
+// code.
+func FromGRPCCode(code codes.Code) Class {
+	switch code {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return invalidArgument
+	case codes.Unauthenticated:
+		return unauthenticated
+	case codes.PermissionDenied:
+		return forbidden
+	case codes.NotFound:
+		return notFound
+	case codes.FailedPrecondition, codes.AlreadyExists:
+		return failedPrecondition
+	case codes.DeadlineExceeded:
+		return timeout
+	case codes.ResourceExhausted:
+		return resourceExhausted
+	case codes.Unavailable, codes.Aborted:
+		return unavailable
+	case codes.Unimplemented:
+		return unimplemented
+	}
+	return internal
+}
@@ -0,0 +1,94 @@
+package errcode
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/archiver"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/lagslo"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/secureconn"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"google.golang.org/grpc/codes"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ErrCodeSuite struct{}
+
+var _ = Suite(&ErrCodeSuite{})
+
+func (s *ErrCodeSuite) TestClassify(c *C) {
+	for i, tc := range []struct {
+		err   error
+		class Class
+	}{
+		{err: proxy.ErrUnknownTopic, class: Class{NotFound, false}},
+		{err: proxy.ErrReadOnly, class: Class{Forbidden, false}},
+		{err: proxy.ErrLimitExceeded, class: Class{ResourceExhausted, true}},
+		{err: consumer.ErrRequestTimeout, class: Class{Timeout, true}},
+		{err: proxy.ErrEndOfStream, class: Class{EndOfStream, false}},
+		{err: proxy.ErrStopReached, class: Class{EndOfStream, false}},
+		{err: errors.Wrap(proxy.ErrOutOfRange, "offsets=1-2"), class: Class{InvalidArgument, false}},
+		{err: consumer.ErrUnavailable, class: Class{Unavailable, true}},
+		{err: proxy.ErrGroupMigrating, class: Class{Unavailable, true}},
+		{err: proxy.ErrGroupMigrated, class: Class{FailedPrecondition, false}},
+		{err: lagslo.ErrNotFound, class: Class{NotFound, false}},
+		{err: archiver.ErrReplayNotFound, class: Class{NotFound, false}},
+		{err: proxy.ErrBadReplay, class: Class{InvalidArgument, false}},
+		{err: errors.Wrap(secureconn.ErrBadCredentials, "bad certificate"), class: Class{InvalidArgument, false}},
+		{err: sarama.ErrOutOfBrokers, class: Class{Unavailable, true}},
+		{err: sarama.ErrNotLeaderForPartition, class: Class{Unavailable, true}},
+		{err: sarama.ErrMessageSizeTooLarge, class: Class{InvalidArgument, false}},
+		{err: sarama.ErrUnknown, class: Class{Internal, false}},
+		{err: zk.ErrConnectionClosed, class: Class{Unavailable, true}},
+		{err: zk.ErrNoNode, class: Class{NotFound, false}},
+		{err: &net.OpError{Op: "dial", Err: errors.New("refused")}, class: Class{Unavailable, true}},
+	} {
+		class, ok := Classify(tc.err)
+		c.Check(ok, Equals, true, Commentf("case #%d", i))
+		c.Check(class, Equals, tc.class, Commentf("case #%d", i))
+	}
+}
+
+// Wrapped errors are classified by their causes.
+func (s *ErrCodeSuite) TestClassifyWrapped(c *C) {
+	class, ok := Classify(errors.Wrap(sarama.ErrLeaderNotAvailable, "failed to fetch"))
+	c.Check(ok, Equals, true)
+	c.Check(class, Equals, Class{Unavailable, true})
+
+	class, ok = Classify(&sarama.ProducerError{Err: sarama.ErrRequestTimedOut})
+	c.Check(ok, Equals, true)
+	c.Check(class, Equals, Class{Timeout, true})
+}
+
+func (s *ErrCodeSuite) TestClassifyUnknown(c *C) {
+	_, ok := Classify(errors.New("foo"))
+	c.Check(ok, Equals, false)
+	_, ok = Classify(nil)
+	c.Check(ok, Equals, false)
+}
+
+func (s *ErrCodeSuite) TestFromHTTPStatus(c *C) {
+	c.Check(FromHTTPStatus(http.StatusBadRequest), Equals, Class{InvalidArgument, false})
+	c.Check(FromHTTPStatus(http.StatusNotFound), Equals, Class{NotFound, false})
+	c.Check(FromHTTPStatus(http.StatusRequestTimeout), Equals, Class{Timeout, true})
+	c.Check(FromHTTPStatus(http.StatusTooManyRequests), Equals, Class{ResourceExhausted, true})
+	c.Check(FromHTTPStatus(http.StatusServiceUnavailable), Equals, Class{Unavailable, true})
+	c.Check(FromHTTPStatus(http.StatusInternalServerError), Equals, Class{Internal, false})
+}
+
+func (s *ErrCodeSuite) TestFromGRPCCode(c *C) {
+	c.Check(FromGRPCCode(codes.InvalidArgument), Equals, Class{InvalidArgument, false})
+	c.Check(FromGRPCCode(codes.Unauthenticated), Equals, Class{Unauthenticated, false})
+	c.Check(FromGRPCCode(codes.Unimplemented), Equals, Class{Unimplemented, false})
+	c.Check(FromGRPCCode(codes.Unavailable), Equals, Class{Unavailable, true})
+	c.Check(FromGRPCCode(codes.Unknown), Equals, Class{Internal, false})
+}
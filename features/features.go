@@ -0,0 +1,75 @@
+// Package features gates experimental behaviors behind flags that are
+// enabled in the `features` section of the config, so that risky changes can
+// be rolled out instance by instance. Flags are process wide, and every flag
+// is disabled unless enabled explicitly.
+package features
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AdaptiveFetch makes message fetchers that had to raise the fetch size to
+// get past an oversized message step it down gradually, as long as fetched
+// batches keep being large, rather than restore `consumer.fetch_max_bytes`
+// right away.
+const AdaptiveFetch = "adaptive_fetch"
+
+// Flag describes an experimental behavior.
+type Flag struct {
+	Name        string
+	Description string
+	Enabled     bool
+}
+
+// registry lists all known flags along with their descriptions.
+var registry = map[string]string{
+	AdaptiveFetch: "step the fetch size down gradually after it was raised for an oversized message",
+}
+
+var (
+	mu      sync.RWMutex
+	enabled map[string]bool
+)
+
+// Configure enables flags that are set to true in the given map and disables
+// all others. Unknown flags are rejected, so that a misspelled one does not
+// go unnoticed.
+func Configure(flags map[string]bool) error {
+	for name := range flags {
+		if _, ok := registry[name]; !ok {
+			return errors.Errorf("unknown feature: %s", name)
+		}
+	}
+	newEnabled := make(map[string]bool, len(flags))
+	for name, on := range flags {
+		if on {
+			newEnabled[name] = true
+		}
+	}
+	mu.Lock()
+	enabled = newEnabled
+	mu.Unlock()
+	return nil
+}
+
+// Enabled tells whether a flag is enabled.
+func Enabled(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled[name]
+}
+
+// All returns all known flags sorted by name.
+func All() []Flag {
+	mu.RLock()
+	defer mu.RUnlock()
+	flags := make([]Flag, 0, len(registry))
+	for name, description := range registry {
+		flags = append(flags, Flag{Name: name, Description: description, Enabled: enabled[name]})
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
@@ -0,0 +1,52 @@
+package features
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type FeaturesSuite struct{}
+
+var _ = Suite(&FeaturesSuite{})
+
+func (s *FeaturesSuite) TearDownTest(c *C) {
+	c.Assert(Configure(nil), IsNil)
+}
+
+func (s *FeaturesSuite) TestConfigure(c *C) {
+	// When
+	err := Configure(map[string]bool{AdaptiveFetch: true})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(Enabled(AdaptiveFetch), Equals, true)
+	c.Check(All(), DeepEquals, []Flag{{
+		Name:        AdaptiveFetch,
+		Description: registry[AdaptiveFetch],
+		Enabled:     true,
+	}})
+
+	// When
+	err = Configure(map[string]bool{AdaptiveFetch: false})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(Enabled(AdaptiveFetch), Equals, false)
+}
+
+// Unknown flags are rejected, and flags configured before stay as they were.
+func (s *FeaturesSuite) TestConfigureUnknown(c *C) {
+	c.Assert(Configure(map[string]bool{AdaptiveFetch: true}), IsNil)
+
+	// When
+	err := Configure(map[string]bool{"adaptive_fetsh": true})
+
+	// Then
+	c.Check(err, ErrorMatches, "unknown feature: adaptive_fetsh")
+	c.Check(Enabled(AdaptiveFetch), Equals, true)
+}
@@ -0,0 +1,220 @@
+// Package freeze freezes committed offsets of consumer groups for a period.
+// While a group is frozen, offsets it acknowledges are not committed, so
+// that its consumers can be restarted, e.g. for emergency maintenance, and
+// the messages consumed in the meantime can be replayed afterwards.
+//
+// Freezes are kept in ZooKeeper, so a group is frozen on all Kafka-Pixy
+// instances working with a cluster, no matter which one was called. Every
+// freeze is a znode named after a group with the time it ends at as data.
+// Instances watch the freeze directory and hand the freezes over to their
+// offset managers whenever it changes.
+package freeze
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/backoff"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/zkconn"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	// ErrNotFrozen is returned when a group that is not frozen is unfrozen.
+	ErrNotFrozen = errors.New("group is not frozen")
+
+	retryBackoff = backoff.Exponential{Base: 100 * time.Millisecond, Cap: 5 * time.Second, Jitter: 0.2}
+)
+
+// T maintains freezes of consumer groups of a cluster.
+type T struct {
+	actDesc  *actor.Descriptor
+	zkConn   *zk.Conn
+	dir      string
+	onChange func(freezes map[string]time.Time)
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Spawn starts watching freezes of consumer groups on the ZooKeeper cluster
+// of `cfg`. The onChange callback is called with all freezes, including
+// expired ones, mapped to the time they end at, every time they change.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, onChange func(freezes map[string]time.Time)) (*T, error) {
+	actDesc := parentActDesc.NewChild("freeze")
+	zkConn, _, err := zkconn.Connect(actDesc, cfg, cfg.ZooKeeper.SessionTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ZooKeeper")
+	}
+	f := &T{
+		actDesc:  actDesc,
+		zkConn:   zkConn,
+		dir:      fmt.Sprintf("%s/kafka-pixy/freezes", cfg.ZooKeeper.Chroot),
+		onChange: onChange,
+		stopCh:   make(chan struct{}),
+	}
+	actor.Spawn(f.actDesc, &f.wg, f.run)
+	return f, nil
+}
+
+// Freeze freezes committed offsets of a group for the specified period, and
+// returns the time the freeze ends at. If the group is frozen already, then
+// its freeze is replaced.
+func (f *T) Freeze(group string, period time.Duration) (time.Time, error) {
+	until := time.Now().Add(period).UTC()
+	data := []byte(until.Format(time.RFC3339Nano))
+	path := f.dir + "/" + group
+	for {
+		_, err := f.zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+		if err == nil {
+			return until, nil
+		}
+		switch err {
+		case zk.ErrNoNode:
+			if err := f.createDir(); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		case zk.ErrNodeExists:
+			if _, err := f.zkConn.Set(path, data, -1); err != nil {
+				return time.Time{}, errors.Wrapf(err, "failed to update %s", path)
+			}
+			// Data changes of a freeze do not trigger the child watch of
+			// the directory, so the directory is touched to trigger its data
+			// watch instead.
+			if _, err := f.zkConn.Set(f.dir, nil, -1); err != nil {
+				return time.Time{}, errors.Wrapf(err, "failed to update %s", f.dir)
+			}
+			return until, nil
+		default:
+			return time.Time{}, errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+}
+
+// Unfreeze lifts the freeze of a group, so offsets it acknowledges are
+// committed again. It returns ErrNotFrozen if the group is not frozen.
+func (f *T) Unfreeze(group string) error {
+	path := f.dir + "/" + group
+	if err := f.zkConn.Delete(path, -1); err != nil {
+		if err == zk.ErrNoNode {
+			return ErrNotFrozen
+		}
+		return errors.Wrapf(err, "failed to delete %s", path)
+	}
+	return nil
+}
+
+// FrozenUntil returns the time that the freeze of a group ends at. A zero
+// time is returned if the group is not frozen, or if its freeze has expired.
+func (f *T) FrozenUntil(group string) (time.Time, error) {
+	path := f.dir + "/" + group
+	data, _, err := f.zkConn.Get(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrapf(err, "failed to get %s", path)
+	}
+	until, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "bad freeze %s", path)
+	}
+	if !time.Now().Before(until) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// Stop stops watching freezes.
+func (f *T) Stop() {
+	close(f.stopCh)
+	f.wg.Wait()
+	f.zkConn.Close()
+}
+
+func (f *T) run() {
+	for retries := 0; ; retries++ {
+		if retries > 0 {
+			select {
+			case <-time.After(retryBackoff.Backoff(retries)):
+			case <-f.stopCh:
+				return
+			}
+		}
+		childrenEventCh, dataEventCh, err := f.load()
+		if err != nil {
+			f.actDesc.Log().WithError(err).Error("Failed to load freezes")
+			continue
+		}
+		retries = -1
+		select {
+		case <-childrenEventCh:
+		case <-dataEventCh:
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// load reads all freezes and hands them over to the onChange callback. It
+// returns channels that signal when either the list of freezes or the data
+// of the freeze directory change. If the directory does not exist, then
+// the returned channels signal when it is created.
+func (f *T) load() (<-chan zk.Event, <-chan zk.Event, error) {
+	groups, _, childrenEventCh, err := f.zkConn.ChildrenW(f.dir)
+	if err == zk.ErrNoNode {
+		exists, _, existsEventCh, err := f.zkConn.ExistsW(f.dir)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to watch %s", f.dir)
+		}
+		if exists {
+			return nil, nil, errors.Errorf("%s created while loading", f.dir)
+		}
+		f.onChange(nil)
+		return existsEventCh, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to watch %s", f.dir)
+	}
+	_, _, dataEventCh, err := f.zkConn.GetW(f.dir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to watch %s", f.dir)
+	}
+	freezes := make(map[string]time.Time, len(groups))
+	for _, group := range groups {
+		path := f.dir + "/" + group
+		data, _, err := f.zkConn.Get(path)
+		if err != nil {
+			if err == zk.ErrNoNode {
+				continue
+			}
+			return nil, nil, errors.Wrapf(err, "failed to get %s", path)
+		}
+		until, err := time.Parse(time.RFC3339Nano, string(data))
+		if err != nil {
+			f.actDesc.Log().WithError(err).Errorf("Bad freeze ignored: %s", path)
+			continue
+		}
+		freezes[group] = until
+	}
+	f.onChange(freezes)
+	return childrenEventCh, dataEventCh, nil
+}
+
+// createDir creates the freeze directory along with all its ancestors.
+func (f *T) createDir() error {
+	path := ""
+	for _, name := range strings.Split(strings.TrimPrefix(f.dir, "/"), "/") {
+		path += "/" + name
+		_, err := f.zkConn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/mockcluster"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type FreezeSuite struct {
+	mc        *mockcluster.T
+	cfg       *config.Proxy
+	freezesCh chan map[string]time.Time
+}
+
+var _ = Suite(&FreezeSuite{})
+
+func (s *FreezeSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *FreezeSuite) SetUpTest(c *C) {
+	var err error
+	s.mc, err = mockcluster.Spawn(mockcluster.Config{})
+	c.Assert(err, IsNil)
+	s.cfg = testhelpers.NewTestProxyCfg("c1")
+	s.mc.Configure(s.cfg)
+	s.freezesCh = make(chan map[string]time.Time, 100)
+}
+
+func (s *FreezeSuite) TearDownTest(c *C) {
+	s.mc.Stop()
+}
+
+func (s *FreezeSuite) onChange(freezes map[string]time.Time) {
+	s.freezesCh <- freezes
+}
+
+// Freezes made via one instance are handed over to all of them, until they
+// are lifted.
+func (s *FreezeSuite) TestFreeze(c *C) {
+	f1, err := Spawn(actor.Root(), s.cfg, func(map[string]time.Time) {})
+	c.Assert(err, IsNil)
+	defer f1.Stop()
+	f2, err := Spawn(actor.Root(), s.cfg, s.onChange)
+	c.Assert(err, IsNil)
+	defer f2.Stop()
+	c.Assert(<-s.freezesCh, HasLen, 0)
+
+	// When
+	until1, err := f1.Freeze("g1", time.Hour)
+	c.Assert(err, IsNil)
+	until2, err := f1.Freeze("g2", time.Minute)
+	c.Assert(err, IsNil)
+
+	// Then
+	s.assertFreezes(c, map[string]time.Time{"g1": until1, "g2": until2})
+	until, err := f2.FrozenUntil("g1")
+	c.Assert(err, IsNil)
+	c.Check(until.Equal(until1), Equals, true)
+
+	// When
+	c.Assert(f1.Unfreeze("g1"), IsNil)
+
+	// Then
+	s.assertFreezes(c, map[string]time.Time{"g2": until2})
+	until, err = f2.FrozenUntil("g1")
+	c.Assert(err, IsNil)
+	c.Check(until.IsZero(), Equals, true)
+	c.Check(f1.Unfreeze("g1"), Equals, ErrNotFrozen)
+}
+
+// Freezing a frozen group replaces its freeze.
+func (s *FreezeSuite) TestRefreeze(c *C) {
+	f, err := Spawn(actor.Root(), s.cfg, s.onChange)
+	c.Assert(err, IsNil)
+	defer f.Stop()
+	until1, err := f.Freeze("g1", time.Hour)
+	c.Assert(err, IsNil)
+	s.assertFreezes(c, map[string]time.Time{"g1": until1})
+
+	// When
+	until2, err := f.Freeze("g1", time.Minute)
+	c.Assert(err, IsNil)
+
+	// Then
+	s.assertFreezes(c, map[string]time.Time{"g1": until2})
+	until, err := f.FrozenUntil("g1")
+	c.Assert(err, IsNil)
+	c.Check(until.Equal(until2), Equals, true)
+}
+
+// Expired freezes are not reported.
+func (s *FreezeSuite) TestExpired(c *C) {
+	f, err := Spawn(actor.Root(), s.cfg, s.onChange)
+	c.Assert(err, IsNil)
+	defer f.Stop()
+
+	// When
+	_, err = f.Freeze("g1", -time.Second)
+	c.Assert(err, IsNil)
+
+	// Then
+	until, err := f.FrozenUntil("g1")
+	c.Assert(err, IsNil)
+	c.Check(until.IsZero(), Equals, true)
+}
+
+// assertFreezes waits for the expected freezes to be handed over.
+func (s *FreezeSuite) assertFreezes(c *C, expected map[string]time.Time) {
+	var freezes map[string]time.Time
+	for {
+		select {
+		case freezes = <-s.freezesCh:
+			if sameFreezes(freezes, expected) {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			c.Fatalf("Expected freezes have not been handed over: %v", freezes)
+		}
+	}
+}
+
+func sameFreezes(freezes, expected map[string]time.Time) bool {
+	if len(freezes) != len(expected) {
+		return false
+	}
+	for group, until := range expected {
+		if !freezes[group].Equal(until) {
+			return false
+		}
+	}
+	return true
+}
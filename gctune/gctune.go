@@ -0,0 +1,166 @@
+// Package gctune applies garbage collector tuning, and tells how much garbage
+// collection pauses delay consume requests, so that the tuning can be judged
+// by its effect on long polling latency rather than by GC numbers alone. The
+// registry is process wide, for the garbage collector is.
+package gctune
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MaxConsumes is the number of most recent consume requests that are
+// checked for being paused by garbage collection.
+const MaxConsumes = 1024
+
+// Stats describes garbage collection and its impact on consume requests.
+type Stats struct {
+	// The GC target percentage set by Apply, zero if the runtime default
+	// is used.
+	Percent int
+
+	// The size of the heap ballast allocated by Apply.
+	BallastSize int64
+
+	// The number of garbage collections and their total pause time since
+	// start.
+	NumGC      int64
+	PauseTotal time.Duration
+
+	// The longest of the recent pauses that the runtime keeps history of.
+	MaxPause time.Duration
+
+	// How recent consume requests were paused by garbage collection.
+	Consume ConsumeImpact
+}
+
+// ConsumeImpact describes how much recent consume requests were paused by
+// garbage collection. Only requests that completed within the pause history
+// kept by the runtime are considered.
+type ConsumeImpact struct {
+	// The number of recent consume requests considered.
+	Requests int
+
+	// The number of requests paused at least once.
+	Paused int
+
+	// The total pause time of all requests, and the longest total pause
+	// time of a single request.
+	PauseTotal time.Duration
+	MaxPause   time.Duration
+}
+
+type interval struct {
+	begin time.Time
+	end   time.Time
+}
+
+var (
+	mu          sync.Mutex
+	percent     int
+	ballast     []byte
+	consumes    []interval
+	nextConsume int
+
+	// For tests only!
+	now         = time.Now
+	readGCStats = debug.ReadGCStats
+)
+
+// Apply sets the GC target percentage, unless it is zero, and allocates a
+// heap ballast of the given size, unless it is zero. The ballast is kept for
+// as long as the process lives.
+func Apply(gcPercent int, ballastSize int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	if gcPercent != 0 {
+		debug.SetGCPercent(gcPercent)
+		percent = gcPercent
+	}
+	if ballastSize > 0 {
+		ballast = make([]byte, ballastSize)
+	}
+}
+
+// ConsumeStarted should be called when a consume request starts waiting for
+// a message. The returned function should be called when it is done.
+func ConsumeStarted() func() {
+	begin := now()
+	return func() {
+		consumed := interval{begin, now()}
+		mu.Lock()
+		if len(consumes) < MaxConsumes {
+			consumes = append(consumes, consumed)
+		} else {
+			consumes[nextConsume] = consumed
+		}
+		nextConsume = (nextConsume + 1) % MaxConsumes
+		mu.Unlock()
+	}
+}
+
+// Report returns garbage collection stats along with their impact on recent
+// consume requests.
+func Report() Stats {
+	var gcStats debug.GCStats
+	readGCStats(&gcStats)
+
+	mu.Lock()
+	stats := Stats{
+		Percent:     percent,
+		BallastSize: int64(len(ballast)),
+		NumGC:       gcStats.NumGC,
+		PauseTotal:  gcStats.PauseTotal,
+	}
+	recentConsumes := make([]interval, len(consumes))
+	copy(recentConsumes, consumes)
+	mu.Unlock()
+
+	pauses := make([]interval, len(gcStats.Pause))
+	for i, pause := range gcStats.Pause {
+		pauses[i] = interval{gcStats.PauseEnd[i].Add(-pause), gcStats.PauseEnd[i]}
+		if pause > stats.MaxPause {
+			stats.MaxPause = pause
+		}
+	}
+	// If the runtime pause history is not complete, then requests that
+	// started before the oldest pause known may have been paused by older
+	// ones that are not known.
+	var historyBegin time.Time
+	if int64(len(pauses)) < gcStats.NumGC && len(pauses) > 0 {
+		historyBegin = pauses[len(pauses)-1].begin
+	}
+	for _, consumed := range recentConsumes {
+		if consumed.begin.Before(historyBegin) {
+			continue
+		}
+		stats.Consume.Requests++
+		var paused time.Duration
+		for _, pause := range pauses {
+			paused += overlap(consumed, pause)
+		}
+		if paused > 0 {
+			stats.Consume.Paused++
+			stats.Consume.PauseTotal += paused
+			if paused > stats.Consume.MaxPause {
+				stats.Consume.MaxPause = paused
+			}
+		}
+	}
+	return stats
+}
+
+func overlap(a, b interval) time.Duration {
+	begin, end := a.begin, a.end
+	if b.begin.After(begin) {
+		begin = b.begin
+	}
+	if b.end.Before(end) {
+		end = b.end
+	}
+	if end.After(begin) {
+		return end.Sub(begin)
+	}
+	return 0
+}
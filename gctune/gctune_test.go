@@ -0,0 +1,82 @@
+package gctune
+
+import (
+	"runtime/debug"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type GCTuneSuite struct{}
+
+var _ = Suite(&GCTuneSuite{})
+
+func (s *GCTuneSuite) SetUpTest(c *C) {
+	consumes = nil
+	nextConsume = 0
+	now = time.Now
+	readGCStats = debug.ReadGCStats
+}
+
+// Consume requests are checked for overlapping with garbage collection
+// pauses, requests that started before the pause history are not considered.
+func (s *GCTuneSuite) TestConsumeImpact(c *C) {
+	begin := time.Now()
+	readGCStats = func(stats *debug.GCStats) {
+		stats.NumGC = 10
+		stats.PauseTotal = 50 * time.Millisecond
+		// Most recent first.
+		stats.Pause = []time.Duration{2 * time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond}
+		stats.PauseEnd = []time.Time{
+			begin.Add(902 * time.Millisecond),
+			begin.Add(503 * time.Millisecond),
+			begin.Add(105 * time.Millisecond),
+		}
+	}
+	for _, consumed := range []interval{
+		{begin, begin.Add(time.Second)},                                        // Before history.
+		{begin.Add(200 * time.Millisecond), begin.Add(time.Second)},            // Two pauses.
+		{begin.Add(501 * time.Millisecond), begin.Add(800 * time.Millisecond)}, // Part of a pause.
+		{begin.Add(600 * time.Millisecond), begin.Add(700 * time.Millisecond)}, // No pause.
+	} {
+		now = func() time.Time { return consumed.begin }
+		done := ConsumeStarted()
+		now = func() time.Time { return consumed.end }
+		done()
+	}
+
+	// When
+	stats := Report()
+
+	// Then
+	c.Check(stats.NumGC, Equals, int64(10))
+	c.Check(stats.PauseTotal, Equals, 50*time.Millisecond)
+	c.Check(stats.MaxPause, Equals, 5*time.Millisecond)
+	c.Check(stats.Consume, DeepEquals, ConsumeImpact{
+		Requests:   3,
+		Paused:     2,
+		PauseTotal: 7 * time.Millisecond,
+		MaxPause:   5 * time.Millisecond,
+	})
+}
+
+// Only the most recent consume requests are kept.
+func (s *GCTuneSuite) TestConsumeLimit(c *C) {
+	readGCStats = func(stats *debug.GCStats) {}
+	for i := 0; i < MaxConsumes+10; i++ {
+		ConsumeStarted()()
+	}
+
+	// When
+	stats := Report()
+
+	// Then
+	c.Check(stats.Consume.Requests, Equals, MaxConsumes)
+	c.Check(len(consumes), Equals, MaxConsumes)
+	c.Check(nextConsume, Equals, 10)
+}
@@ -29,6 +29,16 @@ It has these top-level messages:
 	ListConsumersRs
 	SetOffsetsRq
 	SetOffsetsRs
+	AckOffset
+	AckBatchRq
+	AckResult
+	AckBatchRs
+	ErrorDetails
+	GetHealthRq
+	ClusterHealth
+	GetHealthRs
+	DrainRq
+	DrainRs
 */
 package pb
 
@@ -106,6 +116,10 @@ type ProdRq struct {
 	AsyncMode bool `protobuf:"varint,6,opt,name=async_mode,json=asyncMode" json:"async_mode,omitempty"`
 	// Headers to include with the published message
 	Headers []*RecordHeader `protobuf:"bytes,7,rep,name=headers" json:"headers,omitempty"`
+	// If not empty, then hash of the partition key rather than of key_value
+	// is used to determine the partition to produce to, while key_value is
+	// still written as the message key.
+	PartitionKey []byte `protobuf:"bytes,8,opt,name=partition_key,json=partitionKey,proto3" json:"partition_key,omitempty"`
 }
 
 func (m *ProdRq) Reset()                    { *m = ProdRq{} }
@@ -162,6 +176,13 @@ func (m *ProdRq) GetHeaders() []*RecordHeader {
 	return nil
 }
 
+func (m *ProdRq) GetPartitionKey() []byte {
+	if m != nil {
+		return m.PartitionKey
+	}
+	return nil
+}
+
 type ProdRs struct {
 	// Partition the message was written to. The value only makes sense if
 	// ProdReq.async_mode was false.
@@ -207,6 +228,9 @@ type ConsNAckRq struct {
 	// should be acknowledged by the request.
 	AckPartition int32 `protobuf:"varint,6,opt,name=ack_partition,json=ackPartition" json:"ack_partition,omitempty"`
 	AckOffset    int64 `protobuf:"varint,7,opt,name=ack_offset,json=ackOffset" json:"ack_offset,omitempty"`
+	// Application metadata to commit along with the offset of the partition
+	// that the acknowledged message was consumed from.
+	AckMetadata string `protobuf:"bytes,8,opt,name=ack_metadata,json=ackMetadata" json:"ack_metadata,omitempty"`
 }
 
 func (m *ConsNAckRq) Reset()                    { *m = ConsNAckRq{} }
@@ -263,6 +287,13 @@ func (m *ConsNAckRq) GetAckOffset() int64 {
 	return 0
 }
 
+func (m *ConsNAckRq) GetAckMetadata() string {
+	if m != nil {
+		return m.AckMetadata
+	}
+	return ""
+}
+
 type ConsRs struct {
 	// Partition the message was read from.
 	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
@@ -337,6 +368,8 @@ type AckRq struct {
 	Partition int32 `protobuf:"varint,4,opt,name=partition" json:"partition,omitempty"`
 	// Offset in the partition that the acknowledged message was consumed from.
 	Offset int64 `protobuf:"varint,5,opt,name=offset" json:"offset,omitempty"`
+	// Application metadata to commit along with the partition offset.
+	Metadata string `protobuf:"bytes,6,opt,name=metadata" json:"metadata,omitempty"`
 }
 
 func (m *AckRq) Reset()                    { *m = AckRq{} }
@@ -379,6 +412,13 @@ func (m *AckRq) GetOffset() int64 {
 	return 0
 }
 
+func (m *AckRq) GetMetadata() string {
+	if m != nil {
+		return m.Metadata
+	}
+	return ""
+}
+
 type AckRs struct {
 }
 
@@ -404,6 +444,8 @@ type PartitionOffset struct {
 	Metadata string `protobuf:"bytes,7,opt,name=metadata" json:"metadata,omitempty"`
 	// human readable representation of sparsely committed ranges
 	SparseAcks string `protobuf:"bytes,8,opt,name=sparse_acks,json=sparseAcks" json:"sparse_acks,omitempty"`
+	// Application metadata carried by the latest ack of the partition.
+	AckMetadata string `protobuf:"bytes,9,opt,name=ack_metadata,json=ackMetadata" json:"ack_metadata,omitempty"`
 }
 
 func (m *PartitionOffset) Reset()                    { *m = PartitionOffset{} }
@@ -467,6 +509,13 @@ func (m *PartitionOffset) GetSparseAcks() string {
 	return ""
 }
 
+func (m *PartitionOffset) GetAckMetadata() string {
+	if m != nil {
+		return m.AckMetadata
+	}
+	return ""
+}
+
 type GetOffsetsRq struct {
 	// Name of a Kafka cluster
 	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
@@ -810,6 +859,250 @@ func (m *SetOffsetsRs) String() string            { return proto.CompactTextStri
 func (*SetOffsetsRs) ProtoMessage()               {}
 func (*SetOffsetsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
 
+type AckOffset struct {
+	// Partition that the acknowledged message was consumed from.
+	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	// Offset in the partition that the acknowledged message was consumed from.
+	Offset int64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	// Application metadata to commit along with the partition offset.
+	Metadata string `protobuf:"bytes,3,opt,name=metadata" json:"metadata,omitempty"`
+}
+
+func (m *AckOffset) Reset()                    { *m = AckOffset{} }
+func (m *AckOffset) String() string            { return proto.CompactTextString(m) }
+func (*AckOffset) ProtoMessage()               {}
+func (*AckOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+func (m *AckOffset) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *AckOffset) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *AckOffset) GetMetadata() string {
+	if m != nil {
+		return m.Metadata
+	}
+	return ""
+}
+
+type AckBatchRq struct {
+	// Name of a Kafka cluster to operate on.
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Name of a topic that messages were consumed from.
+	Topic string `protobuf:"bytes,2,opt,name=topic" json:"topic,omitempty"`
+	// Name of a consumer group.
+	Group string `protobuf:"bytes,3,opt,name=group" json:"group,omitempty"`
+	// Messages to acknowledge.
+	Acks []*AckOffset `protobuf:"bytes,4,rep,name=acks" json:"acks,omitempty"`
+}
+
+func (m *AckBatchRq) Reset()                    { *m = AckBatchRq{} }
+func (m *AckBatchRq) String() string            { return proto.CompactTextString(m) }
+func (*AckBatchRq) ProtoMessage()               {}
+func (*AckBatchRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+func (m *AckBatchRq) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *AckBatchRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *AckBatchRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *AckBatchRq) GetAcks() []*AckOffset {
+	if m != nil {
+		return m.Acks
+	}
+	return nil
+}
+
+type AckResult struct {
+	// Partition and offset of the acknowledged message, as in the request.
+	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	Offset    int64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	// Why the message could not be acknowledged, empty if it was.
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *AckResult) Reset()                    { *m = AckResult{} }
+func (m *AckResult) String() string            { return proto.CompactTextString(m) }
+func (*AckResult) ProtoMessage()               {}
+func (*AckResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+func (m *AckResult) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *AckResult) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *AckResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type AckBatchRs struct {
+	// Results of acknowledgements in the order they are listed in the
+	// request.
+	Results []*AckResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *AckBatchRs) Reset()                    { *m = AckBatchRs{} }
+func (m *AckBatchRs) String() string            { return proto.CompactTextString(m) }
+func (*AckBatchRs) ProtoMessage()               {}
+func (*AckBatchRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+func (m *AckBatchRs) GetResults() []*AckResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+// Error details attached to the status of a failed call.
+type ErrorDetails struct {
+	// Stable error code, e.g. `not_found` or `unavailable`.
+	Code string `protobuf:"bytes,1,opt,name=code" json:"code,omitempty"`
+	// Whether the failed call can be retried.
+	Retryable bool `protobuf:"varint,2,opt,name=retryable" json:"retryable,omitempty"`
+}
+
+func (m *ErrorDetails) Reset()                    { *m = ErrorDetails{} }
+func (m *ErrorDetails) String() string            { return proto.CompactTextString(m) }
+func (*ErrorDetails) ProtoMessage()               {}
+func (*ErrorDetails) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+func (m *ErrorDetails) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *ErrorDetails) GetRetryable() bool {
+	if m != nil {
+		return m.Retryable
+	}
+	return false
+}
+
+type GetHealthRq struct {
+}
+
+func (m *GetHealthRq) Reset()                    { *m = GetHealthRq{} }
+func (m *GetHealthRq) String() string            { return proto.CompactTextString(m) }
+func (*GetHealthRq) ProtoMessage()               {}
+func (*GetHealthRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+type ClusterHealth struct {
+	// Name of a Kafka cluster.
+	Cluster string `protobuf:"bytes,1,opt,name=cluster" json:"cluster,omitempty"`
+	// Kafka version in use with the cluster, either configured or
+	// negotiated with the brokers.
+	KafkaVersion string `protobuf:"bytes,2,opt,name=kafka_version,json=kafkaVersion" json:"kafka_version,omitempty"`
+	// Whether the brokers throttle fetches of Kafka-Pixy due to quotas.
+	Throttled bool `protobuf:"varint,3,opt,name=throttled" json:"throttled,omitempty"`
+}
+
+func (m *ClusterHealth) Reset()                    { *m = ClusterHealth{} }
+func (m *ClusterHealth) String() string            { return proto.CompactTextString(m) }
+func (*ClusterHealth) ProtoMessage()               {}
+func (*ClusterHealth) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+func (m *ClusterHealth) GetCluster() string {
+	if m != nil {
+		return m.Cluster
+	}
+	return ""
+}
+
+func (m *ClusterHealth) GetKafkaVersion() string {
+	if m != nil {
+		return m.KafkaVersion
+	}
+	return ""
+}
+
+func (m *ClusterHealth) GetThrottled() bool {
+	if m != nil {
+		return m.Throttled
+	}
+	return false
+}
+
+type GetHealthRs struct {
+	// False once the instance has been drained.
+	Serving bool `protobuf:"varint,1,opt,name=serving" json:"serving,omitempty"`
+	// Clusters sorted by name.
+	Clusters []*ClusterHealth `protobuf:"bytes,2,rep,name=clusters" json:"clusters,omitempty"`
+}
+
+func (m *GetHealthRs) Reset()                    { *m = GetHealthRs{} }
+func (m *GetHealthRs) String() string            { return proto.CompactTextString(m) }
+func (*GetHealthRs) ProtoMessage()               {}
+func (*GetHealthRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+func (m *GetHealthRs) GetServing() bool {
+	if m != nil {
+		return m.Serving
+	}
+	return false
+}
+
+func (m *GetHealthRs) GetClusters() []*ClusterHealth {
+	if m != nil {
+		return m.Clusters
+	}
+	return nil
+}
+
+type DrainRq struct {
+}
+
+func (m *DrainRq) Reset()                    { *m = DrainRq{} }
+func (m *DrainRq) String() string            { return proto.CompactTextString(m) }
+func (*DrainRq) ProtoMessage()               {}
+func (*DrainRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+type DrainRs struct {
+}
+
+func (m *DrainRs) Reset()                    { *m = DrainRs{} }
+func (m *DrainRs) String() string            { return proto.CompactTextString(m) }
+func (*DrainRs) ProtoMessage()               {}
+func (*DrainRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
+
 func init() {
 	proto.RegisterType((*RecordHeader)(nil), "RecordHeader")
 	proto.RegisterType((*ProdRq)(nil), "ProdRq")
@@ -832,6 +1125,16 @@ func init() {
 	proto.RegisterType((*ListConsumersRs)(nil), "ListConsumersRs")
 	proto.RegisterType((*SetOffsetsRq)(nil), "SetOffsetsRq")
 	proto.RegisterType((*SetOffsetsRs)(nil), "SetOffsetsRs")
+	proto.RegisterType((*AckOffset)(nil), "AckOffset")
+	proto.RegisterType((*AckBatchRq)(nil), "AckBatchRq")
+	proto.RegisterType((*AckResult)(nil), "AckResult")
+	proto.RegisterType((*AckBatchRs)(nil), "AckBatchRs")
+	proto.RegisterType((*ErrorDetails)(nil), "ErrorDetails")
+	proto.RegisterType((*GetHealthRq)(nil), "GetHealthRq")
+	proto.RegisterType((*ClusterHealth)(nil), "ClusterHealth")
+	proto.RegisterType((*GetHealthRs)(nil), "GetHealthRs")
+	proto.RegisterType((*DrainRq)(nil), "DrainRq")
+	proto.RegisterType((*DrainRs)(nil), "DrainRs")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -914,6 +1217,18 @@ type KafkaPixyClient interface {
 	//  * Invalid Argument (3): see the status description for details;
 	//  * Internal (13): see the status description and logs for details;
 	Ack(ctx context.Context, in *AckRq, opts ...grpc.CallOption) (*AckRs, error)
+	// AckBatch acknowledges several messages earlier consumed from a topic in
+	// one request. It spares consumers that process many small messages a
+	// round trip per acknowledgement, e.g. they can consume messages with
+	// no_ack and acknowledge them once in a while in batches. Acknowledgements
+	// are grouped by partition, and those of a partition are applied in the
+	// order of offsets. If some of them can not be applied, the others still
+	// are, and the response tells which ones failed, so that the client can
+	// retry them.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	AckBatch(ctx context.Context, in *AckBatchRq, opts ...grpc.CallOption) (*AckBatchRs, error)
 	// Fetches partition offsets for the specified topic and group
 	//
 	// gRPC error codes:
@@ -986,6 +1301,15 @@ func (c *kafkaPixyClient) Ack(ctx context.Context, in *AckRq, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *kafkaPixyClient) AckBatch(ctx context.Context, in *AckBatchRq, opts ...grpc.CallOption) (*AckBatchRs, error) {
+	out := new(AckBatchRs)
+	err := grpc.Invoke(ctx, "/KafkaPixy/AckBatch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *kafkaPixyClient) GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error) {
 	out := new(GetOffsetsRs)
 	err := grpc.Invoke(ctx, "/KafkaPixy/GetOffsets", in, out, c.cc, opts...)
@@ -1103,6 +1427,18 @@ type KafkaPixyServer interface {
 	//  * Invalid Argument (3): see the status description for details;
 	//  * Internal (13): see the status description and logs for details;
 	Ack(context.Context, *AckRq) (*AckRs, error)
+	// AckBatch acknowledges several messages earlier consumed from a topic in
+	// one request. It spares consumers that process many small messages a
+	// round trip per acknowledgement, e.g. they can consume messages with
+	// no_ack and acknowledge them once in a while in batches. Acknowledgements
+	// are grouped by partition, and those of a partition are applied in the
+	// order of offsets. If some of them can not be applied, the others still
+	// are, and the response tells which ones failed, so that the client can
+	// retry them.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): see the status description for details;
+	AckBatch(context.Context, *AckBatchRq) (*AckBatchRs, error)
 	// Fetches partition offsets for the specified topic and group
 	//
 	// gRPC error codes:
@@ -1198,6 +1534,24 @@ func _KafkaPixy_Ack_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KafkaPixy_AckBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckBatchRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).AckBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixy/AckBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).AckBatch(ctx, req.(*AckBatchRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _KafkaPixy_GetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetOffsetsRq)
 	if err := dec(in); err != nil {
@@ -1304,6 +1658,10 @@ var _KafkaPixy_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Ack",
 			Handler:    _KafkaPixy_Ack_Handler,
 		},
+		{
+			MethodName: "AckBatch",
+			Handler:    _KafkaPixy_AckBatch_Handler,
+		},
 		{
 			MethodName: "GetOffsets",
 			Handler:    _KafkaPixy_GetOffsets_Handler,
@@ -1329,71 +1687,422 @@ var _KafkaPixy_serviceDesc = grpc.ServiceDesc{
 	Metadata: "kafkapixy.proto",
 }
 
+// Client API for KafkaPixyAdmin service
+
+type KafkaPixyAdminClient interface {
+	// Lists all topics and metadata with optional metadata for the partitions of the topic
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	ListTopics(ctx context.Context, in *ListTopicRq, opts ...grpc.CallOption) (*ListTopicRs, error)
+	// Fetches topic metadata and optional metadata for the partitions of the topic
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the topic does not exist
+	GetTopicMetadata(ctx context.Context, in *GetTopicMetadataRq, opts ...grpc.CallOption) (*GetTopicMetadataRs, error)
+	// Lists consumer groups subscribed to a topic along with their members
+	// and the partitions assigned to them.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (*ListConsumersRs, error)
+	// Fetches partition offsets for the specified topic and group
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the group and or topic does not exist
+	GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error)
+	// Sets partition offsets for the specified topic and group
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Permission Denied (7): If the listener or the cluster is read-only
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the group and or topic does not exist
+	SetOffsets(ctx context.Context, in *SetOffsetsRq, opts ...grpc.CallOption) (*SetOffsetsRs, error)
+	// Reports whether the instance is serving or draining, along with the
+	// state of every cluster it proxies.
+	GetHealth(ctx context.Context, in *GetHealthRq, opts ...grpc.CallOption) (*GetHealthRs, error)
+	// Drains the instance: all its API servers report to health checks that
+	// it is about to shut down, while requests are still served. It cannot
+	// be undone but by restarting the instance.
+	Drain(ctx context.Context, in *DrainRq, opts ...grpc.CallOption) (*DrainRs, error)
+}
+
+type kafkaPixyAdminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewKafkaPixyAdminClient(cc *grpc.ClientConn) KafkaPixyAdminClient {
+	return &kafkaPixyAdminClient{cc}
+}
+
+func (c *kafkaPixyAdminClient) ListTopics(ctx context.Context, in *ListTopicRq, opts ...grpc.CallOption) (*ListTopicRs, error) {
+	out := new(ListTopicRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/ListTopics", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) GetTopicMetadata(ctx context.Context, in *GetTopicMetadataRq, opts ...grpc.CallOption) (*GetTopicMetadataRs, error) {
+	out := new(GetTopicMetadataRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/GetTopicMetadata", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (*ListConsumersRs, error) {
+	out := new(ListConsumersRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/ListConsumers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error) {
+	out := new(GetOffsetsRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/GetOffsets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) SetOffsets(ctx context.Context, in *SetOffsetsRq, opts ...grpc.CallOption) (*SetOffsetsRs, error) {
+	out := new(SetOffsetsRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/SetOffsets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) GetHealth(ctx context.Context, in *GetHealthRq, opts ...grpc.CallOption) (*GetHealthRs, error) {
+	out := new(GetHealthRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/GetHealth", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyAdminClient) Drain(ctx context.Context, in *DrainRq, opts ...grpc.CallOption) (*DrainRs, error) {
+	out := new(DrainRs)
+	err := grpc.Invoke(ctx, "/KafkaPixyAdmin/Drain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for KafkaPixyAdmin service
+
+type KafkaPixyAdminServer interface {
+	// Lists all topics and metadata with optional metadata for the partitions of the topic
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	ListTopics(context.Context, *ListTopicRq) (*ListTopicRs, error)
+	// Fetches topic metadata and optional metadata for the partitions of the topic
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the topic does not exist
+	GetTopicMetadata(context.Context, *GetTopicMetadataRq) (*GetTopicMetadataRs, error)
+	// Lists consumer groups subscribed to a topic along with their members
+	// and the partitions assigned to them.
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	ListConsumers(context.Context, *ListConsumersRq) (*ListConsumersRs, error)
+	// Fetches partition offsets for the specified topic and group
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the group and or topic does not exist
+	GetOffsets(context.Context, *GetOffsetsRq) (*GetOffsetsRs, error)
+	// Sets partition offsets for the specified topic and group
+	//
+	// gRPC error codes:
+	//  * Invalid Argument (3): If unable to find the cluster named in the request
+	//  * Permission Denied (7): If the listener or the cluster is read-only
+	//  * Internal (13): If Kafka returns an error on request
+	//  * NotFound (5): If the group and or topic does not exist
+	SetOffsets(context.Context, *SetOffsetsRq) (*SetOffsetsRs, error)
+	// Reports whether the instance is serving or draining, along with the
+	// state of every cluster it proxies.
+	GetHealth(context.Context, *GetHealthRq) (*GetHealthRs, error)
+	// Drains the instance: all its API servers report to health checks that
+	// it is about to shut down, while requests are still served. It cannot
+	// be undone but by restarting the instance.
+	Drain(context.Context, *DrainRq) (*DrainRs, error)
+}
+
+func RegisterKafkaPixyAdminServer(s *grpc.Server, srv KafkaPixyAdminServer) {
+	s.RegisterService(&_KafkaPixyAdmin_serviceDesc, srv)
+}
+
+func _KafkaPixyAdmin_ListTopics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).ListTopics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/ListTopics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).ListTopics(ctx, req.(*ListTopicRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_GetTopicMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTopicMetadataRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).GetTopicMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/GetTopicMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).GetTopicMetadata(ctx, req.(*GetTopicMetadataRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_ListConsumers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConsumersRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).ListConsumers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/ListConsumers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).ListConsumers(ctx, req.(*ListConsumersRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_GetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOffsetsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).GetOffsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/GetOffsets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).GetOffsets(ctx, req.(*GetOffsetsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_SetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetOffsetsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).SetOffsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/SetOffsets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).SetOffsets(ctx, req.(*SetOffsetsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_GetHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHealthRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).GetHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/GetHealth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).GetHealth(ctx, req.(*GetHealthRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixyAdmin_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyAdminServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/KafkaPixyAdmin/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyAdminServer).Drain(ctx, req.(*DrainRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KafkaPixyAdmin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "KafkaPixyAdmin",
+	HandlerType: (*KafkaPixyAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListTopics",
+			Handler:    _KafkaPixyAdmin_ListTopics_Handler,
+		},
+		{
+			MethodName: "GetTopicMetadata",
+			Handler:    _KafkaPixyAdmin_GetTopicMetadata_Handler,
+		},
+		{
+			MethodName: "ListConsumers",
+			Handler:    _KafkaPixyAdmin_ListConsumers_Handler,
+		},
+		{
+			MethodName: "GetOffsets",
+			Handler:    _KafkaPixyAdmin_GetOffsets_Handler,
+		},
+		{
+			MethodName: "SetOffsets",
+			Handler:    _KafkaPixyAdmin_SetOffsets_Handler,
+		},
+		{
+			MethodName: "GetHealth",
+			Handler:    _KafkaPixyAdmin_GetHealth_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _KafkaPixyAdmin_Drain_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "kafkapixy.proto",
+}
+
 func init() { proto.RegisterFile("kafkapixy.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 995 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0xdd, 0x8e, 0xdb, 0x44,
-	0x1b, 0x5e, 0xc7, 0xb1, 0x1d, 0xbf, 0x49, 0x36, 0xfb, 0xcd, 0xb7, 0x80, 0x31, 0xfd, 0x89, 0x5c,
-	0x55, 0x0d, 0x15, 0xb2, 0xd0, 0x52, 0xfe, 0x2a, 0x54, 0x69, 0xa9, 0xd0, 0x22, 0xa0, 0x65, 0x99,
-	0x2d, 0x20, 0x71, 0x12, 0xcd, 0x8e, 0x27, 0xa9, 0xe5, 0xc4, 0xce, 0x7a, 0x9c, 0xb6, 0x39, 0x43,
-	0xe2, 0x02, 0x38, 0xe0, 0x0a, 0xb8, 0x16, 0x4e, 0xb8, 0x00, 0xc4, 0x11, 0x37, 0xc0, 0x5d, 0xa0,
-	0x99, 0xb1, 0xe3, 0x71, 0x36, 0xed, 0xa2, 0xd5, 0x72, 0xe4, 0x79, 0xff, 0x66, 0x9e, 0xe7, 0x79,
-	0x67, 0xc6, 0x03, 0x83, 0x84, 0x4c, 0x12, 0xb2, 0x88, 0x5f, 0xac, 0xc2, 0x45, 0x9e, 0x15, 0x59,
-	0xf0, 0x01, 0xf4, 0x30, 0xa3, 0x59, 0x1e, 0x7d, 0xce, 0x48, 0xc4, 0x72, 0xb4, 0x07, 0x66, 0xc2,
-	0x56, 0x9e, 0x31, 0x34, 0x46, 0x2e, 0x16, 0x43, 0xb4, 0x0f, 0xd6, 0x33, 0x32, 0x5b, 0x32, 0xaf,
-	0x35, 0x34, 0x46, 0x3d, 0xac, 0x8c, 0xe0, 0x2f, 0x03, 0xec, 0xe3, 0x3c, 0x8b, 0xf0, 0x19, 0xf2,
-	0xc0, 0xa1, 0xb3, 0x25, 0x2f, 0x58, 0x5e, 0x96, 0x55, 0xa6, 0x28, 0x2d, 0xb2, 0x45, 0x4c, 0x65,
-	0xa9, 0x8b, 0x95, 0x81, 0xde, 0x02, 0x37, 0x61, 0xab, 0xb1, 0x9a, 0xd4, 0x94, 0x93, 0x76, 0x12,
-	0xb6, 0xfa, 0x4e, 0xd8, 0xe8, 0x16, 0xf4, 0x45, 0x70, 0x99, 0x46, 0x6c, 0x12, 0xa7, 0x2c, 0xf2,
-	0xda, 0x43, 0x63, 0xd4, 0xc1, 0xbd, 0x84, 0xad, 0xbe, 0xad, 0x7c, 0x62, 0xc5, 0x39, 0xe3, 0x9c,
-	0x4c, 0x99, 0x67, 0xc9, 0xfa, 0xca, 0x44, 0xd7, 0x01, 0x08, 0x5f, 0xa5, 0x74, 0x3c, 0xcf, 0x22,
-	0xe6, 0xd9, 0xb2, 0xd6, 0x95, 0x9e, 0x47, 0x59, 0xc4, 0xd0, 0x1d, 0x70, 0x9e, 0x4a, 0x9e, 0xdc,
-	0x73, 0x86, 0xe6, 0xa8, 0x7b, 0xd0, 0x0f, 0x75, 0xf6, 0xb8, 0x8a, 0x06, 0x0f, 0x4a, 0x76, 0x1c,
-	0x5d, 0x03, 0x77, 0x41, 0xf2, 0x22, 0x2e, 0xe2, 0x2c, 0x95, 0xfc, 0x2c, 0x5c, 0x3b, 0xd0, 0xeb,
-	0x60, 0x67, 0x93, 0x09, 0x67, 0x85, 0xa4, 0x68, 0xe2, 0xd2, 0x0a, 0x7e, 0x37, 0x00, 0x1e, 0x66,
-	0x29, 0x7f, 0x7c, 0x48, 0x93, 0x4b, 0x48, 0xb4, 0x0f, 0xd6, 0x34, 0xcf, 0x96, 0x0b, 0x29, 0x8f,
-	0x8b, 0x95, 0x81, 0x5e, 0x03, 0x3b, 0xcd, 0xc6, 0x84, 0x26, 0xa5, 0x28, 0x56, 0x9a, 0x1d, 0xd2,
-	0x04, 0xbd, 0x09, 0x1d, 0xb2, 0x2c, 0x54, 0xc0, 0x92, 0x01, 0x47, 0xd8, 0x22, 0x74, 0x0b, 0xfa,
-	0x84, 0x26, 0xe3, 0x9a, 0x80, 0x2d, 0x09, 0xf4, 0x08, 0x4d, 0x8e, 0xd7, 0x1c, 0x84, 0x66, 0x34,
-	0x19, 0x97, 0x3c, 0x1c, 0xc9, 0xc3, 0x25, 0x34, 0xf9, 0x5a, 0x51, 0xf9, 0xcd, 0x00, 0x5b, 0x50,
-	0xb9, 0xac, 0x16, 0xff, 0x69, 0xbf, 0xb5, 0x86, 0xda, 0xaf, 0x6c, 0xe8, 0x4f, 0x06, 0x58, 0x57,
-	0xd9, 0x8b, 0x86, 0x14, 0xed, 0x97, 0x4b, 0x61, 0x35, 0xb6, 0x85, 0xa3, 0x40, 0xf0, 0xe0, 0x0f,
-	0x03, 0x06, 0xeb, 0x0e, 0x28, 0xa1, 0x2f, 0x50, 0x77, 0x1f, 0xac, 0x53, 0x36, 0x8d, 0xd3, 0x52,
-	0x5c, 0x65, 0x88, 0xe3, 0xca, 0xd2, 0x48, 0x42, 0x33, 0xb1, 0x18, 0x8a, 0x3c, 0x9a, 0x2d, 0xd3,
-	0x42, 0x82, 0x32, 0xb1, 0x32, 0x5e, 0x06, 0x48, 0xd4, 0xcf, 0xc8, 0x54, 0x6e, 0x0b, 0x13, 0x8b,
-	0x21, 0xf2, 0xa1, 0x33, 0x67, 0x05, 0x89, 0x48, 0x41, 0xe4, 0x5e, 0x70, 0xf1, 0xda, 0x46, 0x37,
-	0xa1, 0xcb, 0x17, 0x24, 0xe7, 0x4c, 0xec, 0x35, 0xee, 0x75, 0x64, 0x18, 0x94, 0xeb, 0x90, 0x26,
-	0x3c, 0x78, 0x02, 0xbd, 0x23, 0x56, 0x28, 0x3e, 0xfc, 0xaa, 0xb4, 0x0e, 0xee, 0x37, 0x66, 0xe5,
-	0xe8, 0x2e, 0x38, 0x0a, 0x3e, 0xf7, 0x0c, 0xd9, 0xf4, 0xbd, 0x70, 0x43, 0x4b, 0x5c, 0x25, 0x04,
-	0xcf, 0xe1, 0x7f, 0xeb, 0xd8, 0xa3, 0x8a, 0xc7, 0x85, 0xfb, 0x78, 0x26, 0x77, 0x8d, 0xc4, 0x66,
-	0xe1, 0xd2, 0x12, 0xca, 0xe4, 0x6c, 0x31, 0x8b, 0x29, 0xe1, 0x9e, 0x39, 0x34, 0x47, 0x16, 0x5e,
-	0xdb, 0x42, 0xc7, 0x98, 0xe7, 0x5e, 0x5b, 0xba, 0xc5, 0x30, 0x98, 0x03, 0x3a, 0x62, 0xc5, 0x13,
-	0x41, 0xab, 0x5a, 0xf7, 0x12, 0x82, 0xdc, 0x81, 0xc1, 0xf3, 0xb8, 0x78, 0x5a, 0x9f, 0x60, 0x2e,
-	0xa5, 0xe9, 0xe0, 0x5d, 0xe1, 0x5e, 0x33, 0xe3, 0xc1, 0x9f, 0xc6, 0x96, 0xf5, 0xb8, 0x58, 0xef,
-	0x19, 0xcb, 0x79, 0xcd, 0xb3, 0x32, 0xd1, 0x87, 0x60, 0xd3, 0x2c, 0x9d, 0xc4, 0x53, 0xaf, 0x25,
-	0x35, 0xbc, 0x19, 0x9e, 0x2f, 0x0f, 0x1f, 0xca, 0x8c, 0xcf, 0xd2, 0x22, 0x5f, 0xe1, 0x32, 0x1d,
-	0x1d, 0x00, 0x34, 0xd0, 0x88, 0x62, 0x14, 0x9e, 0x13, 0x19, 0x6b, 0x59, 0xfe, 0xc7, 0xd0, 0xd5,
-	0xa6, 0xba, 0xe8, 0x27, 0xe3, 0x96, 0x3f, 0x99, 0xfb, 0xad, 0x8f, 0x8c, 0xe0, 0x67, 0x03, 0xba,
-	0x5f, 0xc5, 0x5c, 0x41, 0xc3, 0x1c, 0xbd, 0x0b, 0xb6, 0x94, 0xa6, 0xea, 0xbd, 0x17, 0x6a, 0xd1,
-	0x50, 0x7e, 0x79, 0x09, 0x58, 0xe5, 0xf9, 0x8f, 0xa1, 0xab, 0xb9, 0xb7, 0x2c, 0xfe, 0xb6, 0xbe,
-	0x78, 0xf7, 0xe0, 0xff, 0x5b, 0x94, 0xd0, 0x11, 0x1d, 0xeb, 0x80, 0x5e, 0xd5, 0xd2, 0x2d, 0xcd,
-	0x6b, 0x6d, 0x6d, 0xde, 0xf7, 0x30, 0x10, 0x33, 0x8a, 0x5b, 0x76, 0x39, 0x67, 0xf9, 0xd5, 0x9d,
-	0x9c, 0x7b, 0x80, 0xaa, 0x49, 0xeb, 0xe5, 0xd0, 0x8d, 0x46, 0x07, 0x0d, 0xb9, 0x67, 0x35, 0x4f,
-	0xf0, 0xab, 0x01, 0xbb, 0x55, 0xd9, 0x91, 0x98, 0x87, 0xa3, 0x4f, 0xc0, 0xa5, 0x15, 0xba, 0x52,
-	0xf8, 0x1b, 0x61, 0x33, 0x67, 0x6d, 0x96, 0xf2, 0xd7, 0x05, 0xfe, 0x37, 0xf5, 0x7c, 0xff, 0xbe,
-	0x09, 0xe7, 0x81, 0xeb, 0x4d, 0xf8, 0xc5, 0xd8, 0xd4, 0x8c, 0xa3, 0x7b, 0x60, 0x4b, 0xda, 0x15,
-	0xc2, 0x6b, 0xe1, 0x46, 0x46, 0xa8, 0x90, 0x96, 0xdb, 0x43, 0xe5, 0xfa, 0x5f, 0x40, 0x57, 0x73,
-	0x6f, 0x41, 0x76, 0xbb, 0x89, 0x6c, 0xb0, 0xc1, 0x5b, 0x47, 0xf5, 0xa3, 0x01, 0xbd, 0x93, 0x2b,
-	0xbf, 0x00, 0xf5, 0x0b, 0xaf, 0x7d, 0xd1, 0x85, 0xb7, 0xdb, 0x40, 0xc0, 0x0f, 0xfe, 0x6e, 0x81,
-	0xfb, 0xa5, 0x78, 0xf4, 0x1d, 0xc7, 0x2f, 0x56, 0xe8, 0x3a, 0x38, 0xe2, 0x5d, 0xb3, 0xa4, 0x0c,
-	0x39, 0xa1, 0x7a, 0xbf, 0xf9, 0xe5, 0x80, 0x07, 0x3b, 0xe8, 0xb6, 0x3c, 0xa7, 0x82, 0x9c, 0x78,
-	0xb8, 0xa0, 0x6e, 0x58, 0xbf, 0x61, 0x7c, 0x27, 0x54, 0xaf, 0x80, 0x60, 0x07, 0xbd, 0x01, 0xa6,
-	0x08, 0xdb, 0xa1, 0x8a, 0xa8, 0xaf, 0x08, 0xbc, 0x03, 0x50, 0xdf, 0xd4, 0xa8, 0x1f, 0xea, 0x3f,
-	0x03, 0xbf, 0x61, 0x96, 0xd9, 0x27, 0x7a, 0xf6, 0x49, 0x33, 0xfb, 0xa4, 0x99, 0x7d, 0x17, 0x60,
-	0x7d, 0xec, 0x38, 0xea, 0x69, 0xc7, 0xfe, 0xcc, 0xd7, 0x2d, 0x91, 0xfb, 0x3e, 0xf4, 0x1b, 0xad,
-	0x47, 0x7b, 0x1b, 0x5b, 0xe1, 0xcc, 0xdf, 0xf4, 0x88, 0xb2, 0x07, 0xb0, 0xb7, 0x79, 0xf4, 0xd1,
-	0x96, 0xdb, 0xe0, 0xcc, 0xdf, 0x76, 0x45, 0x04, 0x3b, 0x9f, 0xb6, 0x7f, 0x68, 0x2d, 0x4e, 0x4f,
-	0x6d, 0xf9, 0xb2, 0x7e, 0xef, 0x9f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x14, 0x1d, 0xef, 0x73, 0x6c,
-	0x0b, 0x00, 0x00,
+	// 1298 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x57, 0xeb, 0x6e, 0x1b, 0xc5,
+	0x17, 0xcf, 0x7a, 0xbd, 0xbb, 0xde, 0x63, 0x3b, 0xc9, 0x7f, 0xfe, 0x01, 0xcc, 0xd2, 0x4b, 0x98,
+	0x52, 0x9a, 0x56, 0x68, 0x85, 0x42, 0xb9, 0x55, 0xa8, 0x22, 0xbd, 0x28, 0x15, 0xa5, 0x25, 0x4c,
+	0x4a, 0x2b, 0x21, 0x21, 0x6b, 0xb2, 0x9e, 0x24, 0xab, 0x75, 0x76, 0x9d, 0x9d, 0x75, 0x5b, 0x7f,
+	0x83, 0x27, 0x40, 0x88, 0x07, 0x40, 0x3c, 0x0b, 0xef, 0x80, 0xc4, 0x33, 0xf0, 0x00, 0x7c, 0x45,
+	0x73, 0xd9, 0xf5, 0xac, 0xe3, 0xa6, 0x60, 0xd2, 0x4f, 0x9e, 0x73, 0x99, 0x39, 0xbf, 0xf3, 0x3b,
+	0x67, 0xe7, 0x8c, 0x61, 0x25, 0xa1, 0xfb, 0x09, 0x1d, 0xc5, 0xcf, 0x27, 0xe1, 0x28, 0xcf, 0x8a,
+	0x0c, 0x7f, 0x04, 0x1d, 0xc2, 0xa2, 0x2c, 0x1f, 0xdc, 0x63, 0x74, 0xc0, 0x72, 0xb4, 0x0a, 0x76,
+	0xc2, 0x26, 0x3d, 0x6b, 0xdd, 0xda, 0xf0, 0x89, 0x58, 0xa2, 0x35, 0x70, 0x9e, 0xd2, 0xe1, 0x98,
+	0xf5, 0x1a, 0xeb, 0xd6, 0x46, 0x87, 0x28, 0x01, 0xff, 0xd0, 0x00, 0x77, 0x27, 0xcf, 0x06, 0xe4,
+	0x18, 0xf5, 0xc0, 0x8b, 0x86, 0x63, 0x5e, 0xb0, 0x5c, 0x6f, 0x2b, 0x45, 0xb1, 0xb5, 0xc8, 0x46,
+	0x71, 0x24, 0xb7, 0xfa, 0x44, 0x09, 0xe8, 0x2d, 0xf0, 0x13, 0x36, 0xe9, 0xab, 0x43, 0x6d, 0x79,
+	0x68, 0x2b, 0x61, 0x93, 0xc7, 0x42, 0x46, 0x97, 0xa0, 0x2b, 0x8c, 0xe3, 0x74, 0xc0, 0xf6, 0xe3,
+	0x94, 0x0d, 0x7a, 0xcd, 0x75, 0x6b, 0xa3, 0x45, 0x3a, 0x09, 0x9b, 0x7c, 0x53, 0xea, 0x44, 0xc4,
+	0x23, 0xc6, 0x39, 0x3d, 0x60, 0x3d, 0x47, 0xee, 0x2f, 0x45, 0x74, 0x1e, 0x80, 0xf2, 0x49, 0x1a,
+	0xf5, 0x8f, 0xb2, 0x01, 0xeb, 0xb9, 0x72, 0xaf, 0x2f, 0x35, 0x0f, 0xb2, 0x01, 0x43, 0x57, 0xc0,
+	0x3b, 0x94, 0x79, 0xf2, 0x9e, 0xb7, 0x6e, 0x6f, 0xb4, 0x37, 0xbb, 0xa1, 0x99, 0x3d, 0x29, 0xad,
+	0x02, 0xc6, 0x88, 0xe6, 0x45, 0x5c, 0xc4, 0x59, 0xda, 0x17, 0x84, 0xb4, 0x64, 0x9c, 0x4e, 0xa5,
+	0xbc, 0xcf, 0x26, 0xf8, 0xa6, 0xa6, 0x80, 0xa3, 0x73, 0xe0, 0x57, 0x16, 0x49, 0x82, 0x43, 0xa6,
+	0x0a, 0xf4, 0x3a, 0xb8, 0xd9, 0xfe, 0x3e, 0x67, 0x85, 0xe4, 0xc1, 0x26, 0x5a, 0xc2, 0x7f, 0x5a,
+	0x00, 0xb7, 0xb3, 0x94, 0x3f, 0xdc, 0x8a, 0x92, 0x05, 0x78, 0x5c, 0x03, 0xe7, 0x20, 0xcf, 0xc6,
+	0x23, 0xc9, 0xa1, 0x4f, 0x94, 0x80, 0x5e, 0x03, 0x37, 0xcd, 0xfa, 0x34, 0x4a, 0x34, 0x73, 0x4e,
+	0x9a, 0x6d, 0x45, 0x09, 0x7a, 0x13, 0x5a, 0x74, 0x5c, 0x28, 0x83, 0x23, 0x0d, 0x9e, 0x90, 0x85,
+	0xe9, 0x12, 0x74, 0x69, 0x94, 0xf4, 0xa7, 0x09, 0xb8, 0x32, 0x81, 0x0e, 0x8d, 0x92, 0x9d, 0x2a,
+	0x07, 0x41, 0x6c, 0x94, 0xf4, 0x75, 0x1e, 0x9e, 0xcc, 0xc3, 0xa7, 0x51, 0xf2, 0x95, 0x54, 0xa0,
+	0xb7, 0x41, 0xb8, 0xf7, 0x8f, 0x58, 0x41, 0x07, 0xb4, 0xa0, 0x92, 0x2e, 0x9f, 0xb4, 0x69, 0x94,
+	0x3c, 0xd0, 0x2a, 0xfc, 0x9b, 0x05, 0xae, 0xc8, 0x76, 0x51, 0xba, 0x5e, 0x69, 0xdf, 0x18, 0x8d,
+	0xe1, 0x9e, 0xd6, 0x18, 0xf8, 0x17, 0x0b, 0x9c, 0xb3, 0x2c, 0x57, 0x8d, 0x8a, 0xe6, 0x8b, 0xa9,
+	0x70, 0x6a, 0x54, 0x04, 0xd0, 0xaa, 0xa8, 0x76, 0xe5, 0x71, 0x95, 0x8c, 0x3d, 0x05, 0x90, 0xe3,
+	0xbf, 0x2c, 0x58, 0xa9, 0x0a, 0xa8, 0xeb, 0x74, 0x3a, 0xf3, 0x6b, 0xe0, 0xec, 0xb1, 0x83, 0x38,
+	0xd5, 0xc4, 0x2b, 0x41, 0x5c, 0x09, 0x2c, 0x1d, 0x48, 0xd8, 0x36, 0x11, 0x4b, 0xe1, 0x17, 0x65,
+	0xe3, 0xb4, 0x90, 0x80, 0x6d, 0xa2, 0x84, 0x17, 0x82, 0x5d, 0x05, 0x7b, 0x48, 0x0f, 0x24, 0x4e,
+	0x9b, 0x88, 0x65, 0x0d, 0xbe, 0x57, 0x87, 0x8f, 0x2e, 0x42, 0x9b, 0x8f, 0x68, 0xce, 0x99, 0x68,
+	0x55, 0xae, 0x1b, 0x09, 0x94, 0x6a, 0x2b, 0x4a, 0xf8, 0x89, 0x56, 0xf3, 0x4f, 0xb6, 0xda, 0x23,
+	0xe8, 0x6c, 0xb3, 0x42, 0xa5, 0xcc, 0xcf, 0xaa, 0x54, 0xf8, 0x46, 0xed, 0x54, 0x8e, 0xae, 0x81,
+	0xa7, 0x32, 0xe4, 0x3d, 0x4b, 0xf6, 0xcc, 0x6a, 0x38, 0x43, 0x37, 0x29, 0x1d, 0xf0, 0x33, 0xf8,
+	0x5f, 0x65, 0x2b, 0x61, 0xbe, 0xfc, 0x33, 0x18, 0xca, 0xa6, 0x93, 0xd8, 0x1c, 0xa2, 0x25, 0x41,
+	0x5e, 0xce, 0x46, 0xc3, 0x38, 0xa2, 0xbc, 0x67, 0xaf, 0xdb, 0x1b, 0x0e, 0xa9, 0x64, 0x41, 0x75,
+	0xcc, 0xf3, 0x5e, 0x53, 0xaa, 0xc5, 0x12, 0x1f, 0x01, 0xda, 0x66, 0xc5, 0x23, 0x91, 0x56, 0x19,
+	0x77, 0x01, 0x42, 0xae, 0xc0, 0xca, 0xb3, 0xb8, 0x38, 0x9c, 0xde, 0x11, 0x5c, 0x52, 0xd3, 0x22,
+	0xcb, 0x42, 0x5d, 0x65, 0xc6, 0xf1, 0xef, 0xd6, 0x9c, 0x78, 0x5c, 0xc4, 0x7b, 0xca, 0x72, 0x3e,
+	0xcd, 0xb3, 0x14, 0xd1, 0xc7, 0xe0, 0x46, 0x59, 0xba, 0x1f, 0x1f, 0xf4, 0x1a, 0x92, 0xc3, 0x8b,
+	0xe1, 0xc9, 0xed, 0xe1, 0x6d, 0xe9, 0x71, 0x37, 0x2d, 0xf2, 0x09, 0xd1, 0xee, 0x68, 0x13, 0xa0,
+	0x86, 0x46, 0x6c, 0x46, 0xe1, 0x09, 0x92, 0x89, 0xe1, 0x15, 0x7c, 0x0a, 0x6d, 0xe3, 0xa8, 0x97,
+	0xcd, 0x3a, 0x5f, 0xcf, 0xba, 0x1b, 0x8d, 0x4f, 0x2c, 0xfc, 0xa3, 0x05, 0xed, 0x2f, 0x63, 0xae,
+	0xa0, 0x11, 0x8e, 0xde, 0x07, 0x57, 0x52, 0x53, 0xd6, 0xbe, 0x17, 0x1a, 0xd6, 0x50, 0xfe, 0x72,
+	0x0d, 0x58, 0xf9, 0x05, 0x0f, 0xa1, 0x6d, 0xa8, 0xe7, 0x04, 0xbf, 0x6a, 0x06, 0x6f, 0x6f, 0xfe,
+	0x7f, 0x0e, 0x13, 0x26, 0xa2, 0x1d, 0x13, 0xd0, 0x69, 0x25, 0x9d, 0x53, 0xbc, 0xc6, 0xdc, 0xe2,
+	0x3d, 0x81, 0x15, 0x71, 0xa2, 0xb8, 0xa4, 0xc7, 0x47, 0x2c, 0x3f, 0xbb, 0x2f, 0xe7, 0x3a, 0xa0,
+	0xf2, 0xd0, 0x69, 0x38, 0x74, 0xa1, 0x56, 0x41, 0x4b, 0xf6, 0xac, 0xa1, 0xc1, 0xbf, 0x5a, 0xb0,
+	0x5c, 0x6e, 0xdb, 0x16, 0xe7, 0x70, 0xf4, 0x19, 0xf8, 0x51, 0x89, 0x4e, 0x13, 0x7f, 0x21, 0xac,
+	0xfb, 0x54, 0xa2, 0xa6, 0x7f, 0xba, 0x21, 0xf8, 0x1a, 0x96, 0xeb, 0xc6, 0x7f, 0x52, 0x84, 0x93,
+	0xc0, 0xcd, 0x22, 0xfc, 0x6c, 0xcd, 0x72, 0xc6, 0xd1, 0x75, 0x70, 0x65, 0xda, 0x25, 0xc2, 0x73,
+	0xe1, 0x8c, 0x47, 0xa8, 0x90, 0xea, 0xf6, 0x50, 0xbe, 0xc1, 0x17, 0xd0, 0x36, 0xd4, 0x73, 0x90,
+	0x5d, 0xae, 0x23, 0x5b, 0x99, 0xc9, 0xdb, 0x44, 0xf5, 0xbd, 0x05, 0x9d, 0xdd, 0x33, 0xbf, 0x00,
+	0xcd, 0x0b, 0xaf, 0xf9, 0xb2, 0x0b, 0x6f, 0xb9, 0x86, 0x80, 0xe3, 0xef, 0xc0, 0xdf, 0xaa, 0x5e,
+	0x0b, 0x8b, 0xcd, 0x7f, 0x73, 0x6a, 0xd8, 0x33, 0x43, 0x2f, 0x07, 0xd8, 0x8a, 0x92, 0x5b, 0xb4,
+	0x88, 0x0e, 0xcf, 0x2c, 0xdd, 0x0b, 0xd0, 0x94, 0x23, 0x48, 0xe5, 0x0a, 0x61, 0x85, 0x9f, 0x48,
+	0x3d, 0x7e, 0x22, 0x53, 0x22, 0x8c, 0x8f, 0x87, 0x8b, 0xa6, 0xb4, 0x06, 0x0e, 0xcb, 0xf3, 0x2c,
+	0x2f, 0x03, 0x4b, 0x01, 0x6f, 0x1a, 0xc9, 0x70, 0xf4, 0x0e, 0x78, 0xb9, 0x8c, 0x51, 0xf6, 0x13,
+	0x84, 0x55, 0x58, 0x52, 0x9a, 0xf0, 0xe7, 0xd0, 0xb9, 0x2b, 0x36, 0xdf, 0x61, 0x05, 0x8d, 0x87,
+	0x1c, 0x21, 0x68, 0x46, 0xe2, 0x09, 0xac, 0xf2, 0x97, 0x6b, 0x81, 0x31, 0x67, 0x45, 0x3e, 0xa1,
+	0x7b, 0x43, 0xa6, 0xaf, 0x80, 0xa9, 0x02, 0x77, 0xa1, 0xbd, 0xcd, 0x8a, 0x7b, 0x8c, 0x0e, 0x8b,
+	0x43, 0x72, 0x8c, 0x87, 0xd0, 0xbd, 0xad, 0x48, 0x53, 0xaa, 0x53, 0x48, 0x15, 0x6f, 0x2f, 0xf1,
+	0xb7, 0xa2, 0x5f, 0xde, 0xf1, 0x8a, 0xdc, 0x8e, 0x54, 0x3e, 0x56, 0x3a, 0x11, 0xbc, 0x38, 0xcc,
+	0xb3, 0xa2, 0x18, 0xb2, 0x81, 0x1e, 0x1e, 0x53, 0x05, 0xde, 0x35, 0x83, 0xcb, 0x79, 0xc1, 0x59,
+	0xfe, 0x34, 0x4e, 0x0f, 0x64, 0xac, 0x16, 0x29, 0x45, 0x74, 0x0d, 0x5a, 0x3a, 0x2c, 0xd7, 0x13,
+	0x63, 0x39, 0xac, 0xe1, 0x24, 0x95, 0x1d, 0xfb, 0xe0, 0xdd, 0xc9, 0x69, 0x9c, 0x92, 0xe3, 0xe9,
+	0x92, 0x6f, 0xfe, 0x64, 0x83, 0x7f, 0x5f, 0x20, 0xdb, 0x89, 0x9f, 0x4f, 0xd0, 0x79, 0xf0, 0xc4,
+	0x1b, 0x7e, 0x1c, 0x31, 0xe4, 0x85, 0xea, 0x0f, 0x4d, 0xa0, 0x17, 0x1c, 0x2f, 0xa1, 0xcb, 0xd0,
+	0xd6, 0x9f, 0x99, 0x78, 0xa4, 0xa3, 0x76, 0x38, 0x7d, 0xaf, 0x07, 0x5e, 0xa8, 0x9e, 0xb3, 0x78,
+	0x09, 0xbd, 0x01, 0xb6, 0x30, 0xbb, 0xa1, 0xb2, 0xa8, 0x5f, 0x61, 0x78, 0x17, 0x5a, 0x65, 0x29,
+	0x51, 0x3b, 0x9c, 0xb6, 0x68, 0x60, 0x08, 0xc2, 0xef, 0x3d, 0x80, 0xe9, 0xdb, 0x02, 0x75, 0x43,
+	0xf3, 0xf9, 0x12, 0xd4, 0x44, 0xed, 0xbd, 0x6b, 0x7a, 0xef, 0xd6, 0xbd, 0x77, 0xeb, 0xde, 0xd7,
+	0x00, 0xaa, 0x41, 0xc1, 0x51, 0xc7, 0x18, 0x54, 0xc7, 0x81, 0x29, 0x09, 0xdf, 0x0f, 0xa1, 0x5b,
+	0xbb, 0xac, 0xd0, 0xea, 0xcc, 0xe5, 0x75, 0x1c, 0xcc, 0x6a, 0xc4, 0xb6, 0x9b, 0xb0, 0x3a, 0x3b,
+	0xac, 0xd0, 0x9c, 0xf9, 0x75, 0x1c, 0xcc, 0x51, 0x72, 0xbc, 0xb4, 0xf9, 0x47, 0x03, 0x96, 0xab,
+	0x9a, 0x6c, 0x0d, 0x8e, 0xe2, 0xf4, 0x5f, 0xa1, 0xfe, 0x8f, 0xe1, 0x17, 0xcd, 0xfa, 0x55, 0x16,
+	0xed, 0x2a, 0xf8, 0xd5, 0x07, 0x81, 0x3a, 0xa1, 0xf1, 0x65, 0x06, 0xa6, 0x24, 0x5c, 0xcf, 0x83,
+	0x23, 0x7b, 0x1b, 0xb5, 0x42, 0xdd, 0xee, 0x41, 0xb9, 0xe2, 0x78, 0xe9, 0x56, 0xf3, 0xdb, 0xc6,
+	0x68, 0x6f, 0xcf, 0x95, 0x7f, 0xf7, 0x3f, 0xf8, 0x7b, 0x00, 0xfe, 0x86, 0x1a, 0x0b, 0x01, 0x10,
+	0x00, 0x00,
 }
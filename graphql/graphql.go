@@ -0,0 +1,226 @@
+// Package graphql implements execution of GraphQL queries against a graph of
+// lazily resolved objects. Only a subset of the language that is sufficient
+// for read-only browsing is supported: a single query operation with
+// variables, aliases and arguments. Fragments, directives, introspection,
+// mutations and subscriptions are not supported.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Resolver returns a field value given field arguments. A value can be a
+// scalar that encodes to JSON, an Object, a slice of Objects, or nil.
+type Resolver func(args Args) (interface{}, error)
+
+// Object is a node of a graph. Its fields are resolved only if requested by
+// a query.
+type Object map[string]Resolver
+
+// Args represents field arguments with variables already substituted.
+type Args map[string]interface{}
+
+// Error describes a query execution error.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of query execution. If a field resolver fails, the
+// field value is set to null and an error is added to the error list.
+type Response struct {
+	Data   *OrderedMap `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// OrderedMap is a JSON object that preserves the order of its fields, so
+// that response fields are returned in the order they were requested.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Get returns the value of a field.
+func (m *OrderedMap) Get(key string) interface{} {
+	return m.values[key]
+}
+
+func (m *OrderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(key))
+		buf.WriteByte(':')
+		encoded, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs a query against the root object. An error is returned only if
+// the query cannot be parsed; field resolution errors are reported in the
+// response.
+func Execute(root Object, query string, vars map[string]interface{}) (*Response, error) {
+	op, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	allVars := make(map[string]interface{}, len(op.defaults)+len(vars))
+	for k, v := range op.defaults {
+		allVars[k] = v
+	}
+	for k, v := range vars {
+		allVars[k] = v
+	}
+	e := executor{vars: allVars}
+	rs := &Response{Data: e.resolveObject(root, op.selection, nil)}
+	rs.Errors = e.errors
+	return rs, nil
+}
+
+type executor struct {
+	vars   map[string]interface{}
+	errors []Error
+}
+
+func (e *executor) resolveObject(obj Object, selection []*field, path []interface{}) *OrderedMap {
+	result := &OrderedMap{values: make(map[string]interface{}, len(selection))}
+	for _, f := range selection {
+		key := f.responseKey()
+		fieldPath := append(append([]interface{}(nil), path...), key)
+		resolver, ok := obj[f.name]
+		if !ok {
+			e.addError(fieldPath, errors.Errorf("unknown field %q", f.name))
+			result.set(key, nil)
+			continue
+		}
+		args := make(Args, len(f.args))
+		for name, v := range f.args {
+			args[name] = v.resolve(e.vars)
+		}
+		v, err := resolver(args)
+		if err != nil {
+			e.addError(fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+		result.set(key, e.completeValue(v, f, fieldPath))
+	}
+	return result
+}
+
+func (e *executor) completeValue(v interface{}, f *field, path []interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case Object:
+		if v == nil {
+			return nil
+		}
+		if f.selection == nil {
+			e.addError(path, errors.Errorf("field %q of object type must have a selection", f.name))
+			return nil
+		}
+		return e.resolveObject(v, f.selection, path)
+	case []Object:
+		if f.selection == nil {
+			e.addError(path, errors.Errorf("field %q of object type must have a selection", f.name))
+			return nil
+		}
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.completeValue(item, f, append(append([]interface{}(nil), path...), i))
+		}
+		return list
+	}
+	if f.selection != nil {
+		e.addError(path, errors.Errorf("field %q of scalar type must not have a selection", f.name))
+		return nil
+	}
+	return v
+}
+
+func (e *executor) addError(path []interface{}, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
+
+// String returns a string argument value, or the default value if the
+// argument is not specified.
+func (a Args) String(name, defaultValue string) (string, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.Errorf("argument %q must be a string, got %v", name, v)
+	}
+	return s, nil
+}
+
+// RequiredString returns a string argument value, or an error if the
+// argument is not specified.
+func (a Args) RequiredString(name string) (string, error) {
+	if v, ok := a[name]; !ok || v == nil {
+		return "", errors.Errorf("argument %q is required", name)
+	}
+	return a.String(name, "")
+}
+
+// Int returns an integer argument value, or the default value if the
+// argument is not specified. Integers passed in variables are decoded from
+// JSON as float64, so those are accepted too as long as they are whole.
+func (a Args) Int(name string, defaultValue int64) (int64, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return defaultValue, nil
+	}
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("argument %q must be an integer, got %v", name, v)
+}
+
+// RequiredInt returns an integer argument value, or an error if the
+// argument is not specified.
+func (a Args) RequiredInt(name string) (int64, error) {
+	if v, ok := a[name]; !ok || v == nil {
+		return 0, errors.Errorf("argument %q is required", name)
+	}
+	return a.Int(name, 0)
+}
+
+// Value returns a resolver that always returns the specified value.
+func Value(v interface{}) Resolver {
+	return func(Args) (interface{}, error) { return v, nil }
+}
@@ -0,0 +1,174 @@
+package graphql
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type GraphQLSuite struct{}
+
+var _ = Suite(&GraphQLSuite{})
+
+func newTestRoot() Object {
+	item := func(id int64) Object {
+		return Object{
+			"id":   Value(id),
+			"tags": Value([]string{"a", "b"}),
+		}
+	}
+	return Object{
+		"version": Value("1.0"),
+		"item": func(args Args) (interface{}, error) {
+			id, err := args.RequiredInt("id")
+			if err != nil {
+				return nil, err
+			}
+			return item(id), nil
+		},
+		"items": func(args Args) (interface{}, error) {
+			count, err := args.Int("count", 2)
+			if err != nil {
+				return nil, err
+			}
+			items := make([]Object, count)
+			for i := range items {
+				items[i] = item(int64(i))
+			}
+			return items, nil
+		},
+		"echo": func(args Args) (interface{}, error) {
+			return args.String("text", "default")
+		},
+		"broken": func(Args) (interface{}, error) {
+			return nil, errors.New("kaboom")
+		},
+	}
+}
+
+func execute(c *C, query string, vars map[string]interface{}) string {
+	rs, err := Execute(newTestRoot(), query, vars)
+	c.Assert(err, IsNil)
+	encoded, err := json.Marshal(rs)
+	c.Assert(err, IsNil)
+	return string(encoded)
+}
+
+// Fields are returned in the order they are requested, aliases are honored.
+func (s *GraphQLSuite) TestFieldsOrderAndAliases(c *C) {
+	// When
+	rs := execute(c, `{ version, first: item(id: 7) { tags id } v2: version }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"version":"1.0","first":{"tags":["a","b"],"id":7},"v2":"1.0"}}`)
+}
+
+func (s *GraphQLSuite) TestLists(c *C) {
+	// When
+	rs := execute(c, `query { items(count: 3) { id } }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"items":[{"id":0},{"id":1},{"id":2}]}}`)
+}
+
+// Variables passed with a request override defaults specified in a query.
+func (s *GraphQLSuite) TestVariables(c *C) {
+	query := `query Q($count: Int = 1, $text: String!) {
+		items(count: $count) { id }
+		echo(text: $text)
+		dflt: echo
+	}`
+
+	// When
+	rs := execute(c, query, map[string]interface{}{"text": "hello"})
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"items":[{"id":0}],"echo":"hello","dflt":"default"}}`)
+
+	// When: variables are decoded from JSON, so numbers are float64.
+	rs = execute(c, query, map[string]interface{}{"count": float64(2), "text": "hi"})
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"items":[{"id":0},{"id":1}],"echo":"hi","dflt":"default"}}`)
+}
+
+func (s *GraphQLSuite) TestStringEscapes(c *C) {
+	// When
+	rs := execute(c, `{ echo(text: "a\"b\\cA\n") }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"echo":"a\"b\\cA\n"}}`)
+}
+
+// Field errors are reported along with partial results.
+func (s *GraphQLSuite) TestFieldErrors(c *C) {
+	// When
+	rs := execute(c, `{ version broken item { id } items { bogus } }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"version":"1.0","broken":null,"item":null,"items":[{"bogus":null},{"bogus":null}]},`+
+		`"errors":[{"message":"kaboom","path":["broken"]},`+
+		`{"message":"argument \"id\" is required","path":["item"]},`+
+		`{"message":"unknown field \"bogus\"","path":["items",0,"bogus"]},`+
+		`{"message":"unknown field \"bogus\"","path":["items",1,"bogus"]}]}`)
+}
+
+func (s *GraphQLSuite) TestSelectionMismatch(c *C) {
+	// When
+	rs := execute(c, `{ version { id } item(id: 1) }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"version":null,"item":null},`+
+		`"errors":[{"message":"field \"version\" of scalar type must not have a selection","path":["version"]},`+
+		`{"message":"field \"item\" of object type must have a selection","path":["item"]}]}`)
+}
+
+func (s *GraphQLSuite) TestArgumentTypeMismatch(c *C) {
+	// When
+	rs := execute(c, `{ items(count: "many") { id } }`, nil)
+
+	// Then
+	c.Check(rs, Equals, `{"data":{"items":null},`+
+		`"errors":[{"message":"argument \"count\" must be an integer, got many","path":["items"]}]}`)
+}
+
+func (s *GraphQLSuite) TestSyntaxErrors(c *C) {
+	for i, tc := range []struct {
+		query string
+		error string
+	}{{
+		query: `{ version`,
+		error: `syntax error at 9: expected name, got ""`,
+	}, {
+		query: `mutation { version }`,
+		error: `syntax error at 0: unsupported operation "mutation"`,
+	}, {
+		query: `{ ...frag }`,
+		error: `syntax error at 2: fragments and directives are not supported`,
+	}, {
+		query: `{ version } { version }`,
+		error: `syntax error at 12: only one operation per document is supported`,
+	}, {
+		query: `{ echo(text: "abc) }`,
+		error: `syntax error at 13: unterminated string`,
+	}, {
+		query: `{ }`,
+		error: `syntax error at 2: empty selection set`,
+	}, {
+		query: `{ ver%sion }`,
+		error: `syntax error at 5: unexpected character '%'`,
+	}} {
+		// When
+		_, err := Execute(newTestRoot(), tc.query, nil)
+
+		// Then
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(tc.error), Commentf("case #%d", i))
+	}
+}
@@ -0,0 +1,430 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// field represents a field selection of a query.
+type field struct {
+	alias     string
+	name      string
+	args      map[string]value
+	selection []*field
+}
+
+// responseKey returns a key that the field value should be returned with.
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// value is an argument value that can be either a literal or a reference to
+// a variable that is resolved at execution time.
+type value struct {
+	literal  interface{}
+	variable string
+	list     []value
+	isList   bool
+}
+
+func (v value) resolve(vars map[string]interface{}) interface{} {
+	if v.variable != "" {
+		return vars[v.variable]
+	}
+	if v.isList {
+		list := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			list[i] = item.resolve(vars)
+		}
+		return list
+	}
+	return v.literal
+}
+
+type operation struct {
+	defaults  map[string]interface{}
+	selection []*field
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// parser implements a recursive descent parser for a subset of GraphQL
+// query language: a single query operation with variables, aliases and
+// arguments. Fragments, directives, mutations and subscriptions are not
+// supported.
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func parse(src string) (*operation, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	op := &operation{defaults: make(map[string]interface{})}
+	if p.tok.kind == tokName {
+		if p.tok.text != "query" {
+			return nil, p.errorf("unsupported operation %q", p.tok.text)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if p.isPunct("(") {
+			if err := p.parseVariableDefinitions(op); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var err error
+	if op.selection, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("only one operation per document is supported")
+	}
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions(op *operation) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.isPunct(")") {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		// Types are not checked, so they are just skipped.
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if p.isPunct("=") {
+			if err := p.next(); err != nil {
+				return err
+			}
+			v, err := p.parseValue(true)
+			if err != nil {
+				return err
+			}
+			op.defaults[name] = v.literal
+		}
+	}
+	return p.next()
+}
+
+func (p *parser) skipType() error {
+	if p.isPunct("[") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return err
+	}
+	if p.isPunct("!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseSelectionSet() ([]*field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*field
+	for !p.isPunct("}") {
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return fields, p.next()
+}
+
+func (p *parser) parseField() (*field, error) {
+	if p.isPunct("...") || p.isPunct("@") {
+		return nil, p.errorf("fragments and directives are not supported")
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	f := &field{name: name}
+	if p.isPunct(":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		f.alias = name
+		if f.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isPunct("(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		f.args = make(map[string]value)
+		for !p.isPunct(")") {
+			argName, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if f.args[argName], err = p.parseValue(false); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isPunct("{") {
+		if f.selection, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseValue(constant bool) (value, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokPunct && tok.text == "$" && !constant:
+		if err := p.next(); err != nil {
+			return value{}, err
+		}
+		name, err := p.expectName()
+		return value{variable: name}, err
+	case tok.kind == tokPunct && tok.text == "[":
+		if err := p.next(); err != nil {
+			return value{}, err
+		}
+		v := value{isList: true}
+		for !p.isPunct("]") {
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return value{}, err
+			}
+			v.list = append(v.list, item)
+		}
+		return v, p.next()
+	case tok.kind == tokInt:
+		i, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return value{}, p.errorf("bad integer %s", tok.text)
+		}
+		return value{literal: i}, p.next()
+	case tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return value{}, p.errorf("bad float %s", tok.text)
+		}
+		return value{literal: f}, p.next()
+	case tok.kind == tokString:
+		return value{literal: tok.text}, p.next()
+	case tok.kind == tokName:
+		var v value
+		switch tok.text {
+		case "true":
+			v.literal = true
+		case "false":
+			v.literal = false
+		case "null":
+		default:
+			// Enum values are represented as strings.
+			v.literal = tok.text
+		}
+		return v, p.next()
+	}
+	return value{}, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *parser) isPunct(text string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.isPunct(text) {
+		return p.errorf("expected %q, got %q", text, p.tok.text)
+	}
+	return p.next()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.errorf("expected name, got %q", p.tok.text)
+	}
+	name := p.tok.text
+	return name, p.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("syntax error at %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// next advances the parser to the next token.
+func (p *parser) next() error {
+	// Skip ignored tokens: white space, commas and comments.
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokPunct, text: "...", pos: start}
+	case strings.IndexByte("!$()[]{}:=@|", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokPunct, text: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, text: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		p.pos++
+		kind := tokInt
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' || c == '+' || (c == '-' && kind == tokFloat) {
+				kind = tokFloat
+			} else if !isDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		p.tok = token{kind: kind, text: p.src[start:p.pos], pos: start}
+	case c == '"':
+		s, err := p.scanString()
+		if err != nil {
+			return err
+		}
+		p.tok = token{kind: tokString, text: s, pos: start}
+	default:
+		return errors.Errorf("syntax error at %d: unexpected character %q", start, c)
+	}
+	return nil
+}
+
+func (p *parser) scanString() (string, error) {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", errors.Errorf("syntax error at %d: unterminated string", start)
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return "", errors.Errorf("syntax error at %d: unterminated string", start)
+			}
+			esc := p.src[p.pos+1]
+			p.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				sb.WriteByte(esc)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return "", errors.Errorf("syntax error at %d: bad unicode escape", p.pos)
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					return "", errors.Errorf("syntax error at %d: bad unicode escape", p.pos)
+				}
+				sb.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				return "", errors.Errorf("syntax error at %d: bad escape sequence", p.pos-2)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteRune(r)
+			p.pos += size
+		}
+	}
+	return "", errors.Errorf("syntax error at %d: unterminated string", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,217 @@
+// Package healthprobe probes health of services that consumer groups feed.
+// Every group with a probe configured has its URL polled periodically, and
+// when enough probes in a row fail the group is paused, that is messages are
+// not offered to it until enough probes in a row succeed again. That way
+// consumption backs off while a downstream service is unavailable, without
+// any changes to clients.
+package healthprobe
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultInterval         = 5 * time.Second
+	defaultTimeout          = 2 * time.Second
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+)
+
+// Status is the outcome of the recent probes of a group.
+type Status struct {
+	Group  string
+	Paused bool
+
+	// The number of the most recent probes in a row that failed or
+	// succeeded. One of them is always zero.
+	Failures  int
+	Successes int
+
+	ProbedAt time.Time
+
+	// The error the last probe failed with, if any.
+	Err error
+}
+
+// T probes health of the services that consumer groups feed.
+type T struct {
+	actDesc *actor.Descriptor
+	httpClt *http.Client
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	cfg    config.HealthProbe
+	status Status
+	// Closed when a paused group is resumed, nil while the group is not
+	// paused.
+	resumedCh chan none.T
+}
+
+// Spawn creates a prober and starts probing the configured groups.
+func Spawn(parentActDesc *actor.Descriptor, cfg map[string]config.HealthProbe) *T {
+	t := newProber(parentActDesc.NewChild("health_probe"), cfg)
+	for name := range t.groups {
+		name := name
+		actor.Spawn(t.actDesc.NewChild(name), &t.wg, func() { t.run(name) })
+	}
+	return t
+}
+
+func newProber(actDesc *actor.Descriptor, cfg map[string]config.HealthProbe) *T {
+	t := &T{
+		actDesc: actDesc,
+		httpClt: &http.Client{},
+		stopCh:  make(chan none.T),
+		groups:  make(map[string]*group, len(cfg)),
+	}
+	for name, probeCfg := range cfg {
+		name := name
+		t.groups[name] = &group{cfg: withDefaults(probeCfg), status: Status{Group: name}}
+		t.actDesc.ObserveGauge(name+"_paused", func() int64 {
+			if t.Status(name).Paused {
+				return 1
+			}
+			return 0
+		})
+	}
+	return t
+}
+
+func withDefaults(cfg config.HealthProbe) config.HealthProbe {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.SuccessThreshold == 0 {
+		cfg.SuccessThreshold = defaultSuccessThreshold
+	}
+	return cfg
+}
+
+// Stop makes the prober stop probing and waits for the probes in progress,
+// if any, to complete. Paused groups stay paused.
+func (t *T) Stop() {
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// Paused returns nil if messages can be offered to a group, or a channel
+// that is closed when the group is resumed otherwise.
+func (t *T) Paused(group string) <-chan none.T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.groups[group]
+	if !ok || g.resumedCh == nil {
+		return nil
+	}
+	return g.resumedCh
+}
+
+// Statuses returns statuses of all probed groups sorted by group.
+func (t *T) Statuses() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]Status, 0, len(t.groups))
+	for _, g := range t.groups {
+		statuses = append(statuses, g.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Group < statuses[j].Group })
+	return statuses
+}
+
+// Status returns the status of a group. The status of a group that is not
+// probed is empty.
+func (t *T) Status(group string) Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.groups[group]
+	if !ok {
+		return Status{Group: group}
+	}
+	return g.status
+}
+
+func (t *T) run(name string) {
+	t.mu.Lock()
+	cfg := t.groups[name].cfg
+	t.mu.Unlock()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		t.onProbed(name, t.probe(cfg))
+		select {
+		case <-ticker.C:
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// probe makes a GET request to the probe URL, and returns an error unless
+// the response status is 2xx.
+func (t *T) probe(cfg config.HealthProbe) error {
+	req, err := http.NewRequest(http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return errors.Wrap(err, "bad probe URL")
+	}
+	clt := *t.httpClt
+	clt.Timeout = cfg.Timeout
+	rs, err := clt.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, rs.Body)
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+		return errors.Errorf("probe responded with %s", rs.Status)
+	}
+	return nil
+}
+
+// onProbed updates the status of a group with the outcome of a probe, and
+// pauses or resumes the group when a threshold is reached.
+func (t *T) onProbed(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g := t.groups[name]
+	g.status.ProbedAt = time.Now().UTC()
+	g.status.Err = err
+	if err != nil {
+		g.status.Failures++
+		g.status.Successes = 0
+	} else {
+		g.status.Successes++
+		g.status.Failures = 0
+	}
+	switch {
+	case !g.status.Paused && g.status.Failures >= g.cfg.FailureThreshold:
+		g.status.Paused = true
+		g.resumedCh = make(chan none.T)
+		t.actDesc.Log().WithError(err).Warnf("Group paused: group=%s, failures=%d", name, g.status.Failures)
+	case g.status.Paused && g.status.Successes >= g.cfg.SuccessThreshold:
+		g.status.Paused = false
+		close(g.resumedCh)
+		g.resumedCh = nil
+		t.actDesc.Log().Infof("Group resumed: group=%s", name)
+	}
+}
@@ -0,0 +1,145 @@
+package healthprobe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type HealthProbeSuite struct {
+	ns *actor.Descriptor
+}
+
+var _ = Suite(&HealthProbeSuite{})
+
+func (s *HealthProbeSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *HealthProbeSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+}
+
+// A group is paused after enough failed probes in a row, and resumed after
+// enough successful ones.
+func (s *HealthProbeSuite) TestPauseResume(c *C) {
+	t := newProber(s.ns, map[string]config.HealthProbe{
+		"g1": {URL: "http://g1.local/health", FailureThreshold: 2, SuccessThreshold: 2},
+	})
+	kaboom := errors.New("kaboom")
+
+	t.onProbed("g1", kaboom)
+	c.Check(t.Paused("g1"), IsNil)
+	t.onProbed("g1", nil)
+	t.onProbed("g1", kaboom)
+	c.Check(t.Paused("g1"), IsNil)
+
+	// When
+	t.onProbed("g1", kaboom)
+
+	// Then
+	resumedCh := t.Paused("g1")
+	c.Assert(resumedCh, NotNil)
+	status := t.Status("g1")
+	c.Check(status.Paused, Equals, true)
+	c.Check(status.Failures, Equals, 2)
+	c.Check(status.Err, Equals, kaboom)
+
+	// When
+	t.onProbed("g1", nil)
+	c.Check(t.Paused("g1"), NotNil)
+	t.onProbed("g1", nil)
+
+	// Then
+	c.Check(t.Paused("g1"), IsNil)
+	select {
+	case <-resumedCh:
+	default:
+		c.Error("resumed channel is not closed")
+	}
+	c.Check(t.Status("g1").Paused, Equals, false)
+}
+
+// Groups that are not probed are never paused.
+func (s *HealthProbeSuite) TestNotProbed(c *C) {
+	t := newProber(s.ns, map[string]config.HealthProbe{
+		"g1": {URL: "http://g1.local/health"},
+	})
+
+	// When
+	for i := 0; i < 5; i++ {
+		t.onProbed("g1", errors.New("kaboom"))
+	}
+
+	// Then
+	c.Check(t.Paused("g1"), NotNil)
+	c.Check(t.Paused("g2"), IsNil)
+	c.Check(t.Status("g2"), DeepEquals, Status{Group: "g2"})
+}
+
+// Only 2xx responses count as healthy.
+func (s *HealthProbeSuite) TestProbe(c *C) {
+	var status int32 = http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	t := newProber(s.ns, nil)
+	cfg := withDefaults(config.HealthProbe{URL: server.URL})
+
+	c.Check(t.probe(cfg), IsNil)
+	atomic.StoreInt32(&status, http.StatusNoContent)
+	c.Check(t.probe(cfg), IsNil)
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	c.Check(t.probe(cfg), ErrorMatches, "probe responded with 503 Service Unavailable")
+}
+
+// A probe that takes longer than the timeout fails.
+func (s *HealthProbeSuite) TestProbeTimeout(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	t := newProber(s.ns, nil)
+
+	// When
+	err := t.probe(withDefaults(config.HealthProbe{URL: server.URL, Timeout: 50 * time.Millisecond}))
+
+	// Then
+	c.Check(err, NotNil)
+}
+
+// A spawned prober pauses a group whose downstream is down.
+func (s *HealthProbeSuite) TestSpawn(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// When
+	t := Spawn(s.ns, map[string]config.HealthProbe{
+		"g1": {URL: server.URL, Interval: 10 * time.Millisecond, FailureThreshold: 2},
+	})
+	defer t.Stop()
+
+	// Then
+	for i := 0; t.Paused("g1") == nil; i++ {
+		c.Assert(i < 100, Equals, true, Commentf("group is not paused"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	statuses := t.Statuses()
+	c.Assert(len(statuses), Equals, 1)
+	c.Check(statuses[0].Paused, Equals, true)
+}
@@ -0,0 +1,136 @@
+// Package hooks fires operator hooks on lifecycle events of a Kafka-Pixy
+// instance: when it has started, when a cluster is connected, when it starts
+// draining and when it has shut down. A hook either executes a command or
+// POSTs the event to a webhook URL. Hooks are fired synchronously, so that
+// e.g. a service discovery registration is removed before draining carries
+// on, but every hook is bounded by its timeout, and a failed hook is only
+// logged.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Event is a lifecycle event as passed to hooks.
+type Event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// The cluster that the event concerns, if any.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// T fires the configured hooks.
+type T struct {
+	actDesc *actor.Descriptor
+	httpClt *http.Client
+	hooks   []hook
+}
+
+type hook struct {
+	name string
+	cfg  config.Hook
+}
+
+// New creates a firer of the configured hooks.
+func New(parentActDesc *actor.Descriptor, cfg map[string]config.Hook) *T {
+	t := &T{
+		actDesc: parentActDesc.NewChild("hooks"),
+		httpClt: &http.Client{},
+	}
+	for name, hookCfg := range cfg {
+		if hookCfg.Timeout == 0 {
+			hookCfg.Timeout = defaultTimeout
+		}
+		t.hooks = append(t.hooks, hook{name: name, cfg: hookCfg})
+	}
+	return t
+}
+
+// Fire fires all hooks of an event concurrently, and waits for them to
+// complete or time out.
+func (t *T) Fire(event, cluster string) {
+	ev := Event{Event: event, Time: time.Now().UTC(), Cluster: cluster}
+	var wg sync.WaitGroup
+	for _, h := range t.hooks {
+		if !h.firedOn(event) {
+			continue
+		}
+		h := h
+		actor.Spawn(t.actDesc.NewChild(h.name), &wg, func() {
+			if err := t.fire(h.cfg, ev); err != nil {
+				t.actDesc.Log().WithError(err).Errorf("Hook failed: hook=%s, event=%s, cluster=%s", h.name, event, cluster)
+				return
+			}
+			t.actDesc.Log().Infof("Hook fired: hook=%s, event=%s, cluster=%s", h.name, event, cluster)
+		})
+	}
+	wg.Wait()
+}
+
+func (h *hook) firedOn(event string) bool {
+	for _, e := range h.cfg.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *T) fire(cfg config.Hook, ev Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	if len(cfg.Command) > 0 {
+		return runCommand(ctx, cfg.Command, ev)
+	}
+	return t.post(ctx, cfg.URL, ev)
+}
+
+// runCommand executes a hook command with the event passed in environment
+// variables. The command is killed if it does not complete in time.
+func runCommand(ctx context.Context, command []string, ev Event) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "KAFKA_PIXY_EVENT="+ev.Event, "KAFKA_PIXY_CLUSTER="+ev.Cluster)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "command failed, output=%q", output)
+	}
+	return nil
+}
+
+// post POSTs the event to a webhook URL, and returns an error unless the
+// response status is 2xx.
+func (t *T) post(ctx context.Context, url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "bad webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rs, err := t.httpClt.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, rs.Body)
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with %s", rs.Status)
+	}
+	return nil
+}
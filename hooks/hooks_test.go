@@ -0,0 +1,122 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type HooksSuite struct {
+	ns *actor.Descriptor
+}
+
+var _ = Suite(&HooksSuite{})
+
+func (s *HooksSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *HooksSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+}
+
+// Commands are given the event in environment variables.
+func (s *HooksSuite) TestCommand(c *C) {
+	out := filepath.Join(c.MkDir(), "out")
+	t := New(s.ns, map[string]config.Hook{
+		"h1": {
+			Events:  []string{config.HookClusterConnected},
+			Command: []string{"sh", "-c", `echo "$KAFKA_PIXY_EVENT $KAFKA_PIXY_CLUSTER" > ` + out},
+		},
+	})
+
+	// When
+	t.Fire(config.HookClusterConnected, "foo")
+
+	// Then
+	data, err := ioutil.ReadFile(out)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "cluster_connected foo\n")
+}
+
+// Webhooks are POSTed the event as JSON.
+func (s *HooksSuite) TestWebhook(c *C) {
+	eventsCh := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		c.Check(r.Method, Equals, http.MethodPost)
+		c.Check(json.NewDecoder(r.Body).Decode(&ev), IsNil)
+		eventsCh <- ev
+	}))
+	defer server.Close()
+	t := New(s.ns, map[string]config.Hook{
+		"h1": {Events: []string{config.HookStarted, config.HookShutdownComplete}, URL: server.URL},
+	})
+
+	// When
+	t.Fire(config.HookShutdownComplete, "")
+
+	// Then
+	ev := <-eventsCh
+	c.Check(ev.Event, Equals, config.HookShutdownComplete)
+	c.Check(ev.Cluster, Equals, "")
+	c.Check(ev.Time.IsZero(), Equals, false)
+}
+
+// Hooks are only fired on the events they are configured for.
+func (s *HooksSuite) TestOtherEvent(c *C) {
+	out := filepath.Join(c.MkDir(), "out")
+	t := New(s.ns, map[string]config.Hook{
+		"h1": {Events: []string{config.HookStarted}, Command: []string{"touch", out}},
+	})
+
+	// When
+	t.Fire(config.HookDrainStarted, "")
+
+	// Then
+	_, err := ioutil.ReadFile(out)
+	c.Check(err, NotNil)
+}
+
+// Fire does not wait for a hook longer than its timeout.
+func (s *HooksSuite) TestTimeout(c *C) {
+	t := New(s.ns, map[string]config.Hook{
+		"h1": {Events: []string{config.HookStarted}, Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond},
+	})
+	begin := time.Now()
+
+	// When
+	t.Fire(config.HookStarted, "")
+
+	// Then
+	c.Check(time.Since(begin) < 5*time.Second, Equals, true)
+}
+
+// Failing webhooks are reported.
+func (s *HooksSuite) TestWebhookFailed(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	t := New(s.ns, nil)
+
+	// When
+	err := t.post(context.Background(), server.URL, Event{Event: config.HookStarted})
+
+	// Then
+	c.Check(err, ErrorMatches, "webhook responded with 502 Bad Gateway")
+}
@@ -0,0 +1,67 @@
+// Package hostlock implements host level locks backed by files. They prevent
+// several Kafka-Pixy instances on one host from using the same PID file, or
+// the same client IDs. A lock is released by the OS when the process that
+// holds it dies, so a lock file left behind by a crash does not prevent a
+// restart.
+package hostlock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var errLocked = errors.New("locked")
+
+// T is a lock held by this process.
+type T struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes a lock on the file at path, creating it if necessary, and
+// writes the PID of this process to it. It fails right away if the lock is
+// held by another process.
+func Acquire(path string) (*T, error) {
+	file, err := openLocked(path)
+	if err != nil {
+		if err != errLocked {
+			return nil, errors.Wrapf(err, "failed to lock %s", path)
+		}
+		if pid := readPID(path); pid != "" {
+			return nil, errors.Errorf("%s is locked by another process, pid=%s", path, pid)
+		}
+		return nil, errors.Errorf("%s is locked by another process", path)
+	}
+	if err := file.Truncate(0); err != nil {
+		release(file, path)
+		return nil, errors.Wrapf(err, "failed to truncate %s", path)
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		release(file, path)
+		return nil, errors.Wrapf(err, "failed to write %s", path)
+	}
+	return &T{path: path, file: file}, nil
+}
+
+// ForClientID returns the path of a lock file for a client ID in dir.
+func ForClientID(dir, clientID string) string {
+	return filepath.Join(dir, "kafka-pixy."+clientID+".lock")
+}
+
+// Release removes the lock file and releases the lock.
+func (l *T) Release() {
+	release(l.file, l.path)
+}
+
+func readPID(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
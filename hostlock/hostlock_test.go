@@ -0,0 +1,76 @@
+package hostlock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type HostLockSuite struct {
+	dir string
+}
+
+var _ = Suite(&HostLockSuite{})
+
+func (s *HostLockSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// The PID of the process is written to the lock file, and the file is removed
+// when the lock is released.
+func (s *HostLockSuite) TestAcquire(c *C) {
+	path := filepath.Join(s.dir, "kafka-pixy.pid")
+
+	// When
+	l, err := Acquire(path)
+
+	// Then
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, strconv.Itoa(os.Getpid()))
+	l.Release()
+	_, err = os.Stat(path)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+// A lock cannot be acquired until it is released.
+func (s *HostLockSuite) TestAcquireLocked(c *C) {
+	path := ForClientID(s.dir, "foo")
+	l, err := Acquire(path)
+	c.Assert(err, IsNil)
+
+	// When
+	_, err = Acquire(path)
+
+	// Then
+	c.Check(err, ErrorMatches, ".*kafka-pixy.foo.lock is locked by another process, pid="+strconv.Itoa(os.Getpid()))
+	l.Release()
+	l, err = Acquire(path)
+	c.Check(err, IsNil)
+	l.Release()
+}
+
+// A lock file left behind by a dead process does not prevent locking.
+func (s *HostLockSuite) TestAcquireStale(c *C) {
+	path := filepath.Join(s.dir, "kafka-pixy.pid")
+	c.Assert(ioutil.WriteFile(path, []byte("123456789"), 0644), IsNil)
+
+	// When
+	l, err := Acquire(path)
+
+	// Then
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, strconv.Itoa(os.Getpid()))
+	l.Release()
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package hostlock
+
+import (
+	"os"
+	"syscall"
+)
+
+func openLocked(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// release removes the file before unlocking it, lest a process that locks it
+// in between loses its lock file.
+func release(file *os.File, path string) {
+	os.Remove(path)
+	file.Close()
+}
@@ -0,0 +1,33 @@
+package hostlock
+
+import (
+	"os"
+	"syscall"
+)
+
+const errSharingViolation syscall.Errno = 32
+
+// openLocked opens the file with sharing disabled, so that no other process
+// can open it until it is closed.
+func openLocked(path string) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(pathp, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errSharingViolation {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// release closes the file before removing it, for a file that is open
+// without sharing cannot be removed.
+func release(file *os.File, path string) {
+	file.Close()
+	os.Remove(path)
+}
@@ -0,0 +1,260 @@
+// Package inflight keeps track of what consumer groups have in flight at
+// this Kafka-Pixy instance: consume requests waiting for messages, messages
+// offered to clients but not acknowledged yet, and messages ready to be
+// offered. Comparing them tells whether a lagging group is held back by slow
+// clients or by Kafka-Pixy itself. The registry is process wide, so that a
+// report can be made without references to the consumer machinery.
+package inflight
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Partition tracks messages of a group-topic-partition in flight. It is
+// updated by the partition consumer, and read by reports concurrently.
+type Partition struct {
+	topic     string
+	partition int32
+	queuedFn  func() int
+	offersFn  func() []Offer
+
+	// Accessed atomically.
+	unacked         int32
+	oldestOfferedAt int64
+}
+
+// Offer describes a message offered to a client but not acknowledged yet.
+type Offer struct {
+	Offset int64
+	// When the message was first offered.
+	OfferedAt time.Time
+	// How many times the message has been offered.
+	Deliveries int
+	// The ID of the consume request that the message was offered to last.
+	RequestID string
+}
+
+// PartitionOffers lists messages of a partition offered to clients of a
+// group but not acknowledged yet, sorted by offset.
+type PartitionOffers struct {
+	Topic     string
+	Partition int32
+	Offers    []Offer
+}
+
+// Report describes what a consumer group has in flight at this instance.
+type Report struct {
+	// Consume requests of the group that are waiting for messages.
+	ActiveRequests int
+
+	// Messages offered to clients but not acknowledged yet.
+	UnackedMsgs int
+
+	// How long ago the oldest unacknowledged message was offered, zero if
+	// there are no unacknowledged messages.
+	OldestUnackedAge time.Duration
+
+	// Partitions consumed by the group at this instance, sorted by topic
+	// and partition.
+	Partitions []PartitionReport
+}
+
+// PartitionReport describes what a partition of a group has in flight.
+type PartitionReport struct {
+	Topic            string
+	Partition        int32
+	UnackedMsgs      int
+	OldestUnackedAge time.Duration
+
+	// Messages fetched from Kafka and waiting for a consume request.
+	QueuedMsgs int
+}
+
+type groupID struct {
+	cluster string
+	group   string
+}
+
+type group struct {
+	requests   int
+	partitions map[*Partition]bool
+}
+
+var (
+	mu     sync.Mutex
+	groups = make(map[groupID]*group)
+
+	// For tests only!
+	now = time.Now
+)
+
+// RegisterPartition starts tracking a partition consumed by a group. The
+// number of queued messages is obtained by calling `queuedFn`, and offered
+// messages by calling `offersFn`, only when they are reported. The returned
+// partition must be unregistered when the partition consumer stops.
+func RegisterPartition(cluster, groupName, topic string, partition int32, queuedFn func() int,
+	offersFn func() []Offer,
+) *Partition {
+	p := &Partition{topic: topic, partition: partition, queuedFn: queuedFn, offersFn: offersFn}
+	id := groupID{cluster, groupName}
+	mu.Lock()
+	defer mu.Unlock()
+	g := getOrCreate(id)
+	g.partitions[p] = true
+	return p
+}
+
+// Unregister stops tracking the partition.
+func (p *Partition) Unregister(cluster, groupName string) {
+	id := groupID{cluster, groupName}
+	mu.Lock()
+	defer mu.Unlock()
+	if g := groups[id]; g != nil {
+		delete(g.partitions, p)
+		deleteIfEmpty(id, g)
+	}
+}
+
+// Update sets the number of unacknowledged messages of the partition, and
+// when the oldest of them was offered.
+func (p *Partition) Update(unacked int, oldestOfferedAt time.Time) {
+	atomic.StoreInt32(&p.unacked, int32(unacked))
+	var nanos int64
+	if !oldestOfferedAt.IsZero() {
+		nanos = oldestOfferedAt.UnixNano()
+	}
+	atomic.StoreInt64(&p.oldestOfferedAt, nanos)
+}
+
+// RequestStarted counts a consume request of a group as active until the
+// returned function is called.
+func RequestStarted(cluster, groupName string) func() {
+	id := groupID{cluster, groupName}
+	mu.Lock()
+	getOrCreate(id).requests++
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if g := groups[id]; g != nil {
+			g.requests--
+			deleteIfEmpty(id, g)
+		}
+	}
+}
+
+// GetReport returns what a consumer group has in flight at this instance.
+func GetReport(cluster, groupName string) Report {
+	mu.Lock()
+	defer mu.Unlock()
+	report := Report{Partitions: []PartitionReport{}}
+	g := groups[groupID{cluster, groupName}]
+	if g == nil {
+		return report
+	}
+	report.ActiveRequests = g.requests
+	reportedAt := now()
+	for p := range g.partitions {
+		pr := PartitionReport{
+			Topic:       p.topic,
+			Partition:   p.partition,
+			UnackedMsgs: int(atomic.LoadInt32(&p.unacked)),
+			QueuedMsgs:  p.queuedFn(),
+		}
+		if nanos := atomic.LoadInt64(&p.oldestOfferedAt); nanos != 0 && pr.UnackedMsgs > 0 {
+			pr.OldestUnackedAge = reportedAt.Sub(time.Unix(0, nanos))
+		}
+		report.UnackedMsgs += pr.UnackedMsgs
+		if pr.OldestUnackedAge > report.OldestUnackedAge {
+			report.OldestUnackedAge = pr.OldestUnackedAge
+		}
+		report.Partitions = append(report.Partitions, pr)
+	}
+	sort.Slice(report.Partitions, func(i, j int) bool {
+		pi, pj := report.Partitions[i], report.Partitions[j]
+		if pi.Topic != pj.Topic {
+			return pi.Topic < pj.Topic
+		}
+		return pi.Partition < pj.Partition
+	})
+	return report
+}
+
+// GetOffers returns messages offered to clients of a consumer group at this
+// instance but not acknowledged yet, by partition sorted by topic and
+// partition. Partitions without such messages are omitted.
+func GetOffers(cluster, groupName string) []PartitionOffers {
+	// Offers are obtained from partition consumers without the registry
+	// locked, for that takes a round trip to every one of them.
+	var partitions []*Partition
+	mu.Lock()
+	if g := groups[groupID{cluster, groupName}]; g != nil {
+		for p := range g.partitions {
+			partitions = append(partitions, p)
+		}
+	}
+	mu.Unlock()
+
+	offers := []PartitionOffers{}
+	for _, p := range partitions {
+		partitionOffers := p.offersFn()
+		if len(partitionOffers) == 0 {
+			continue
+		}
+		offers = append(offers, PartitionOffers{Topic: p.topic, Partition: p.partition, Offers: partitionOffers})
+	}
+	sort.Slice(offers, func(i, j int) bool {
+		oi, oj := offers[i], offers[j]
+		if oi.Topic != oj.Topic {
+			return oi.Topic < oj.Topic
+		}
+		return oi.Partition < oj.Partition
+	})
+	return offers
+}
+
+// Totals describes what all consumer groups of a cluster have in flight at
+// this instance.
+type Totals struct {
+	// Partitions consumed by all groups at this instance.
+	Partitions int
+
+	// Messages offered to clients but not acknowledged yet.
+	UnackedMsgs int
+}
+
+// GetTotals returns what all consumer groups of a cluster have in flight at
+// this instance.
+func GetTotals(cluster string) Totals {
+	mu.Lock()
+	defer mu.Unlock()
+	var totals Totals
+	for id, g := range groups {
+		if id.cluster != cluster {
+			continue
+		}
+		totals.Partitions += len(g.partitions)
+		for p := range g.partitions {
+			totals.UnackedMsgs += int(atomic.LoadInt32(&p.unacked))
+		}
+	}
+	return totals
+}
+
+func getOrCreate(id groupID) *group {
+	g := groups[id]
+	if g == nil {
+		g = &group{partitions: make(map[*Partition]bool)}
+		groups[id] = g
+	}
+	return g
+}
+
+func deleteIfEmpty(id groupID, g *group) {
+	if g.requests <= 0 && len(g.partitions) == 0 {
+		delete(groups, id)
+	}
+}
@@ -0,0 +1,122 @@
+package inflight
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type InFlightSuite struct{}
+
+var _ = Suite(&InFlightSuite{})
+
+func noOffers() []Offer {
+	return nil
+}
+
+func (s *InFlightSuite) SetUpTest(c *C) {
+	groups = make(map[groupID]*group)
+	now = time.Now
+}
+
+func (s *InFlightSuite) TestGetReport(c *C) {
+	begin := time.Now()
+	now = func() time.Time { return begin.Add(time.Minute) }
+	p1 := RegisterPartition("c1", "g1", "t2", 0, func() int { return 1 }, noOffers)
+	p2 := RegisterPartition("c1", "g1", "t1", 3, func() int { return 0 }, noOffers)
+	RegisterPartition("c1", "g2", "t1", 3, func() int { return 0 }, noOffers)
+	p1.Update(2, begin.Add(30*time.Second))
+	p2.Update(5, begin)
+	done := RequestStarted("c1", "g1")
+	RequestStarted("c1", "g1")
+
+	// When
+	done()
+	report := GetReport("c1", "g1")
+
+	// Then
+	c.Check(report, DeepEquals, Report{
+		ActiveRequests:   1,
+		UnackedMsgs:      7,
+		OldestUnackedAge: time.Minute,
+		Partitions: []PartitionReport{{
+			Topic:            "t1",
+			Partition:        3,
+			UnackedMsgs:      5,
+			OldestUnackedAge: time.Minute,
+		}, {
+			Topic:            "t2",
+			Partition:        0,
+			UnackedMsgs:      2,
+			OldestUnackedAge: 30 * time.Second,
+			QueuedMsgs:       1,
+		}},
+	})
+}
+
+// Offers are reported by partition sorted by topic and partition, partitions
+// without offers are omitted.
+func (s *InFlightSuite) TestGetOffers(c *C) {
+	begin := time.Now()
+	offersFn := func(offers ...Offer) func() []Offer {
+		return func() []Offer { return offers }
+	}
+	RegisterPartition("c1", "g1", "t2", 0, func() int { return 0 },
+		offersFn(Offer{Offset: 7, OfferedAt: begin, Deliveries: 2, RequestID: "rq-1"}))
+	RegisterPartition("c1", "g1", "t1", 3, func() int { return 0 },
+		offersFn(Offer{Offset: 3, OfferedAt: begin, Deliveries: 1}, Offer{Offset: 5, OfferedAt: begin, Deliveries: 1}))
+	RegisterPartition("c1", "g1", "t1", 1, func() int { return 0 }, noOffers)
+	RegisterPartition("c1", "g2", "t1", 3, func() int { return 0 },
+		offersFn(Offer{Offset: 1, OfferedAt: begin, Deliveries: 1}))
+
+	// When
+	offers := GetOffers("c1", "g1")
+
+	// Then
+	c.Check(offers, DeepEquals, []PartitionOffers{{
+		Topic:     "t1",
+		Partition: 3,
+		Offers:    []Offer{{Offset: 3, OfferedAt: begin, Deliveries: 1}, {Offset: 5, OfferedAt: begin, Deliveries: 1}},
+	}, {
+		Topic:     "t2",
+		Partition: 0,
+		Offers:    []Offer{{Offset: 7, OfferedAt: begin, Deliveries: 2, RequestID: "rq-1"}},
+	}})
+	c.Check(GetOffers("c1", "g3"), DeepEquals, []PartitionOffers{})
+}
+
+// Totals cover all groups of a cluster.
+func (s *InFlightSuite) TestGetTotals(c *C) {
+	p1 := RegisterPartition("c1", "g1", "t1", 0, func() int { return 0 }, noOffers)
+	p2 := RegisterPartition("c1", "g2", "t1", 0, func() int { return 0 }, noOffers)
+	RegisterPartition("c1", "g2", "t1", 1, func() int { return 0 }, noOffers)
+	p3 := RegisterPartition("c2", "g1", "t1", 0, func() int { return 0 }, noOffers)
+	p1.Update(2, time.Now())
+	p2.Update(5, time.Now())
+	p3.Update(7, time.Now())
+
+	// When
+	totals := GetTotals("c1")
+
+	// Then
+	c.Check(totals, DeepEquals, Totals{Partitions: 3, UnackedMsgs: 7})
+}
+
+// Groups with nothing in flight are forgotten.
+func (s *InFlightSuite) TestUnregister(c *C) {
+	p := RegisterPartition("c1", "g1", "t1", 0, func() int { return 0 }, noOffers)
+	done := RequestStarted("c1", "g1")
+
+	// When
+	p.Unregister("c1", "g1")
+	done()
+
+	// Then
+	c.Check(GetReport("c1", "g1"), DeepEquals, Report{Partitions: []PartitionReport{}})
+	c.Check(groups, HasLen, 0)
+}
@@ -0,0 +1,156 @@
+// Package janitor implements purging of consumer groups that have been idle
+// for longer than a configured retention period. A group is considered idle
+// from the moment the janitor first sees it with no members, so the
+// retention period starts over when the janitor is restarted.
+package janitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// Admin is a subset of administrative operations that the janitor needs.
+type Admin interface {
+	ListGroups() ([]string, error)
+	GetGroupMembers(group string) ([]string, error)
+	DeleteGroup(group string) error
+}
+
+// IdleGroup is a consumer group that has no members.
+type IdleGroup struct {
+	Group     string
+	IdleSince time.Time
+	PurgeAt   time.Time
+}
+
+// T is a janitor that periodically purges idle consumer groups.
+type T struct {
+	actDesc *actor.Descriptor
+	cfg     config.GroupJanitor
+	admin   Admin
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+	now     func() time.Time
+
+	mu        sync.Mutex
+	idleSince map[string]time.Time
+}
+
+// Spawn creates a janitor and starts checking groups with the configured
+// interval.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.GroupJanitor, admin Admin) *T {
+	j := newJanitor(parentActDesc.NewChild("janitor"), cfg, admin)
+	actor.Spawn(j.actDesc, &j.wg, j.run)
+	return j
+}
+
+func newJanitor(actDesc *actor.Descriptor, cfg config.GroupJanitor, admin Admin) *T {
+	return &T{
+		actDesc:   actDesc,
+		cfg:       cfg,
+		admin:     admin,
+		stopCh:    make(chan none.T),
+		now:       time.Now,
+		idleSince: make(map[string]time.Time),
+	}
+}
+
+// Stop makes the janitor stop checking groups and waits for the check in
+// progress, if any, to complete.
+func (j *T) Stop() {
+	close(j.stopCh)
+	j.wg.Wait()
+}
+
+// Scan checks all consumer groups and returns those that have no members,
+// sorted by name. Nothing is purged, so it can be used to find out what
+// groups are going to be purged.
+func (j *T) Scan() ([]IdleGroup, error) {
+	groups, err := j.admin.ListGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list groups")
+	}
+	now := j.now()
+	var idleGroups []IdleGroup
+	isIdle := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		members, err := j.admin.GetGroupMembers(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get members, group=%s", group)
+		}
+		if len(members) > 0 {
+			continue
+		}
+		isIdle[group] = true
+		j.mu.Lock()
+		idleSince, ok := j.idleSince[group]
+		if !ok {
+			idleSince = now
+			j.idleSince[group] = idleSince
+		}
+		j.mu.Unlock()
+		idleGroups = append(idleGroups, IdleGroup{
+			Group:     group,
+			IdleSince: idleSince,
+			PurgeAt:   idleSince.Add(j.cfg.Retention),
+		})
+	}
+	// Forget groups that got members or were deleted.
+	j.mu.Lock()
+	for group := range j.idleSince {
+		if !isIdle[group] {
+			delete(j.idleSince, group)
+		}
+	}
+	j.mu.Unlock()
+	sort.Slice(idleGroups, func(i, j int) bool { return idleGroups[i].Group < idleGroups[j].Group })
+	return idleGroups, nil
+}
+
+func (j *T) run() {
+	ticker := time.NewTicker(j.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.purge()
+		case <-j.stopCh:
+			return
+		}
+	}
+}
+
+// purge deletes groups that have been idle for longer than the retention
+// period.
+func (j *T) purge() {
+	idleGroups, err := j.Scan()
+	if err != nil {
+		j.actDesc.Log().WithError(err).Error("Failed to scan groups")
+		return
+	}
+	now := j.now()
+	for _, idleGroup := range idleGroups {
+		if now.Before(idleGroup.PurgeAt) {
+			continue
+		}
+		if j.cfg.DryRun {
+			j.actDesc.Log().Infof("Would purge idle group: group=%s, idleSince=%v",
+				idleGroup.Group, idleGroup.IdleSince)
+			continue
+		}
+		if err := j.admin.DeleteGroup(idleGroup.Group); err != nil {
+			j.actDesc.Log().WithError(err).Errorf("Failed to purge idle group: group=%s", idleGroup.Group)
+			continue
+		}
+		j.mu.Lock()
+		delete(j.idleSince, idleGroup.Group)
+		j.mu.Unlock()
+		j.actDesc.Log().Infof("Purged idle group: group=%s, idleSince=%v", idleGroup.Group, idleGroup.IdleSince)
+	}
+}
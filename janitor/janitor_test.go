@@ -0,0 +1,185 @@
+package janitor
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type JanitorSuite struct {
+	ns    *actor.Descriptor
+	admin *fakeAdmin
+	now   time.Time
+}
+
+var _ = Suite(&JanitorSuite{})
+
+func (s *JanitorSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *JanitorSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.admin = &fakeAdmin{members: map[string][]string{
+		"active": {"m1"},
+		"idle1":  nil,
+		"idle2":  nil,
+	}}
+	s.now = time.Date(2018, 7, 22, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *JanitorSuite) newJanitor(cfg config.GroupJanitor) *T {
+	j := newJanitor(s.ns, cfg, s.admin)
+	j.now = func() time.Time { return s.now }
+	return j
+}
+
+// Only groups with no members are reported, the time they were first seen
+// idle is remembered across scans.
+func (s *JanitorSuite) TestScan(c *C) {
+	j := s.newJanitor(config.GroupJanitor{Retention: time.Hour})
+	_, err := j.Scan()
+	c.Assert(err, IsNil)
+	s.now = s.now.Add(10 * time.Minute)
+
+	// When
+	idleGroups, err := j.Scan()
+
+	// Then
+	c.Assert(err, IsNil)
+	idleSince := s.now.Add(-10 * time.Minute)
+	c.Check(idleGroups, DeepEquals, []IdleGroup{
+		{Group: "idle1", IdleSince: idleSince, PurgeAt: idleSince.Add(time.Hour)},
+		{Group: "idle2", IdleSince: idleSince, PurgeAt: idleSince.Add(time.Hour)},
+	})
+	c.Check(s.admin.deletedGroups(), IsNil)
+}
+
+// If an idle group gets members, then its retention period starts over when
+// it is idle again.
+func (s *JanitorSuite) TestScanIdleAgain(c *C) {
+	j := s.newJanitor(config.GroupJanitor{Retention: time.Hour})
+	_, err := j.Scan()
+	c.Assert(err, IsNil)
+	s.now = s.now.Add(10 * time.Minute)
+	s.admin.setMembers("idle1", []string{"m2"})
+	_, err = j.Scan()
+	c.Assert(err, IsNil)
+	s.now = s.now.Add(10 * time.Minute)
+	s.admin.setMembers("idle1", nil)
+
+	// When
+	idleGroups, err := j.Scan()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(idleGroups[0].Group, Equals, "idle1")
+	c.Check(idleGroups[0].IdleSince, Equals, s.now)
+}
+
+// Groups are purged when they have been idle for longer than the retention.
+func (s *JanitorSuite) TestPurge(c *C) {
+	j := s.newJanitor(config.GroupJanitor{Retention: time.Hour})
+	j.purge()
+	s.now = s.now.Add(30 * time.Minute)
+	s.admin.members["idle3"] = nil
+	j.purge()
+	c.Assert(s.admin.deletedGroups(), IsNil)
+	s.now = s.now.Add(30 * time.Minute)
+
+	// When
+	j.purge()
+
+	// Then
+	c.Check(s.admin.deletedGroups(), DeepEquals, []string{"idle1", "idle2"})
+}
+
+// In the dry run mode groups are not deleted.
+func (s *JanitorSuite) TestPurgeDryRun(c *C) {
+	j := s.newJanitor(config.GroupJanitor{Retention: time.Hour, DryRun: true})
+	j.purge()
+	s.now = s.now.Add(2 * time.Hour)
+
+	// When
+	j.purge()
+
+	// Then
+	c.Check(s.admin.deletedGroups(), IsNil)
+}
+
+// If a group fails to be deleted, then the others are still purged, and the
+// failed one is retried on the next check.
+func (s *JanitorSuite) TestPurgeError(c *C) {
+	s.admin.deleteErrs = map[string]error{"idle1": errors.New("Kaboom!")}
+	j := s.newJanitor(config.GroupJanitor{Retention: time.Hour})
+	j.purge()
+	s.now = s.now.Add(2 * time.Hour)
+	j.purge()
+	c.Assert(s.admin.deletedGroups(), DeepEquals, []string{"idle2"})
+	s.admin.deleteErrs = nil
+
+	// When
+	j.purge()
+
+	// Then
+	c.Check(s.admin.deletedGroups(), DeepEquals, []string{"idle1", "idle2"})
+}
+
+type fakeAdmin struct {
+	mu         sync.Mutex
+	members    map[string][]string
+	deleted    []string
+	deleteErrs map[string]error
+}
+
+func (a *fakeAdmin) ListGroups() ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var groups []string
+	for group := range a.members {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (a *fakeAdmin) GetGroupMembers(group string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.members[group], nil
+}
+
+func (a *fakeAdmin) DeleteGroup(group string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.deleteErrs[group]; err != nil {
+		return err
+	}
+	delete(a.members, group)
+	a.deleted = append(a.deleted, group)
+	return nil
+}
+
+func (a *fakeAdmin) setMembers(group string, members []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.members[group] = members
+}
+
+func (a *fakeAdmin) deletedGroups() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sort.Strings(a.deleted)
+	return a.deleted
+}
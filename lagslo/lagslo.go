@@ -0,0 +1,307 @@
+// Package lagslo evaluates consumer lag service level objectives of groups.
+// Lag of every group with an objective is checked periodically, and every
+// check is a sample that is either within the objective or not. The fraction
+// of samples that are not within the objective divided by the error budget,
+// that is the fraction the objective allows, is the burn rate. An objective
+// fires when its burn rate is above a threshold over both a short and a long
+// window: the long window makes sure that enough of the budget is burnt to
+// be worth attention, and the short one that it is still being burnt.
+package lagslo
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// Statuses of an objective.
+const (
+	StatusNormal = "normal"
+	StatusFiring = "firing"
+)
+
+const (
+	defaultObjective         = 0.99
+	defaultLongWindow        = time.Hour
+	defaultBurnRateThreshold = 14.4
+)
+
+// ErrNotFound is returned when a group has no lag objective.
+var ErrNotFound = errors.New("the group has no lag objective. Consider adding it to `lag_slo.groups` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+
+// Admin is a subset of administrative operations that the evaluator needs.
+type Admin interface {
+	GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error)
+	FetchMessages(topic string, partition int32, offset int64, limit int) ([]admin.Message, error)
+}
+
+// Status is the outcome of the last evaluation of a group objective.
+type Status struct {
+	Group  string
+	Status string
+
+	// The lag of the group as of the last check. LagTime is the age of the
+	// oldest message the group has yet to consume, it is only checked if
+	// the objective limits it.
+	LagMessages int64
+	LagTime     time.Duration
+
+	ShortBurnRate float64
+	LongBurnRate  float64
+	CheckedAt     time.Time
+
+	// The error the last check failed with, if any. Failed checks are not
+	// counted as samples.
+	Err error
+}
+
+// T evaluates lag objectives of consumer groups.
+type T struct {
+	actDesc       *actor.Descriptor
+	checkInterval time.Duration
+	admin         Admin
+	stopCh        chan none.T
+	wg            sync.WaitGroup
+	now           func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	cfg     config.LagSLO
+	samples []sample
+	status  Status
+}
+
+type sample struct {
+	at time.Time
+	ok bool
+}
+
+// Spawn creates an evaluator and starts checking lag of the groups with the
+// configured interval.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.LagSLOs, admin Admin) *T {
+	e := newEvaluator(parentActDesc.NewChild("lag_slo"), cfg, admin)
+	actor.Spawn(e.actDesc, &e.wg, e.run)
+	return e
+}
+
+func newEvaluator(actDesc *actor.Descriptor, cfg config.LagSLOs, admin Admin) *T {
+	e := &T{
+		actDesc:       actDesc,
+		checkInterval: cfg.CheckInterval,
+		admin:         admin,
+		stopCh:        make(chan none.T),
+		now:           time.Now,
+		groups:        make(map[string]*group, len(cfg.Groups)),
+	}
+	for name, sloCfg := range cfg.Groups {
+		name := name
+		e.groups[name] = &group{cfg: withDefaults(sloCfg), status: Status{Group: name, Status: StatusNormal}}
+		e.actDesc.ObserveGauge(name+"_firing", func() int64 {
+			if e.Status(name).Status == StatusFiring {
+				return 1
+			}
+			return 0
+		})
+		e.actDesc.ObserveGauge(name+"_lag_messages", func() int64 {
+			return e.Status(name).LagMessages
+		})
+		e.actDesc.ObserveGauge(name+"_lag_ms", func() int64 {
+			return int64(e.Status(name).LagTime / time.Millisecond)
+		})
+		e.actDesc.ObserveGauge(name+"_burn_rate_pct", func() int64 {
+			return int64(e.Status(name).LongBurnRate * 100)
+		})
+	}
+	return e
+}
+
+func withDefaults(cfg config.LagSLO) config.LagSLO {
+	if cfg.Objective == 0 {
+		cfg.Objective = defaultObjective
+	}
+	if cfg.LongWindow == 0 {
+		cfg.LongWindow = defaultLongWindow
+	}
+	if cfg.ShortWindow == 0 {
+		cfg.ShortWindow = cfg.LongWindow / 12
+	}
+	if cfg.BurnRateThreshold == 0 {
+		cfg.BurnRateThreshold = defaultBurnRateThreshold
+	}
+	return cfg
+}
+
+// Stop makes the evaluator stop checking groups and waits for the check in
+// progress, if any, to complete.
+func (e *T) Stop() {
+	close(e.stopCh)
+	e.wg.Wait()
+}
+
+// Statuses returns statuses of all group objectives sorted by group.
+func (e *T) Statuses() []Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	statuses := make([]Status, 0, len(e.groups))
+	for _, g := range e.groups {
+		statuses = append(statuses, g.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Group < statuses[j].Group })
+	return statuses
+}
+
+// Status returns the status of a group objective, or a status with an
+// ErrNotFound error if the group has none.
+func (e *T) Status(group string) Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, ok := e.groups[group]
+	if !ok {
+		return Status{Group: group, Err: ErrNotFound}
+	}
+	return g.status
+}
+
+func (e *T) run() {
+	ticker := time.NewTicker(e.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.check()
+		case <-e.stopCh:
+			return
+		}
+	}
+}
+
+// check checks lag of all groups and updates their statuses.
+func (e *T) check() {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.groups))
+	for name := range e.groups {
+		names = append(names, name)
+	}
+	e.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		e.checkGroup(name)
+	}
+}
+
+func (e *T) checkGroup(name string) {
+	e.mu.Lock()
+	cfg := e.groups[name].cfg
+	e.mu.Unlock()
+
+	// Kafka is queried without holding the lock, so that statuses can be
+	// reported while a check is in progress.
+	lagMessages, lagTime, err := e.getLag(name, cfg)
+	now := e.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g := e.groups[name]
+	g.status.CheckedAt = now
+	g.status.Err = err
+	if err != nil {
+		e.actDesc.Log().WithError(err).Errorf("Failed to check lag: group=%s", name)
+		return
+	}
+	ok := (cfg.MaxLagMessages == 0 || lagMessages <= cfg.MaxLagMessages) &&
+		(cfg.MaxLagTime == 0 || lagTime <= cfg.MaxLagTime)
+	g.samples = append(g.samples, sample{at: now, ok: ok})
+	// Samples outside of the long window are not needed anymore.
+	i := 0
+	for i < len(g.samples) && !g.samples[i].at.After(now.Add(-cfg.LongWindow)) {
+		i++
+	}
+	g.samples = g.samples[i:]
+
+	g.status.LagMessages = lagMessages
+	g.status.LagTime = lagTime
+	g.status.ShortBurnRate = g.burnRate(now, cfg.ShortWindow)
+	g.status.LongBurnRate = g.burnRate(now, cfg.LongWindow)
+	wasFiring := g.status.Status == StatusFiring
+	if g.status.ShortBurnRate >= cfg.BurnRateThreshold && g.status.LongBurnRate >= cfg.BurnRateThreshold {
+		g.status.Status = StatusFiring
+	} else {
+		g.status.Status = StatusNormal
+	}
+	switch {
+	case !wasFiring && g.status.Status == StatusFiring:
+		e.actDesc.Log().Warnf("Lag objective firing: group=%s, lag=%d, lagTime=%v, burnRate=%.1f/%.1f",
+			name, lagMessages, lagTime, g.status.ShortBurnRate, g.status.LongBurnRate)
+	case wasFiring && g.status.Status == StatusNormal:
+		e.actDesc.Log().Infof("Lag objective back to normal: group=%s, lag=%d, lagTime=%v, burnRate=%.1f/%.1f",
+			name, lagMessages, lagTime, g.status.ShortBurnRate, g.status.LongBurnRate)
+	}
+}
+
+// burnRate returns the burn rate of the error budget over a window ending
+// at now. It is zero if there are no samples in the window.
+func (g *group) burnRate(now time.Time, window time.Duration) float64 {
+	var total, bad int
+	for _, s := range g.samples {
+		if !s.at.After(now.Add(-window)) {
+			continue
+		}
+		total++
+		if !s.ok {
+			bad++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - g.cfg.Objective)
+}
+
+// getLag returns the number of messages a group has yet to consume in all
+// partitions of the objective topics, and the age of the oldest of them if
+// the objective limits it. A partition that the group has not committed an
+// offset for has not been consumed at all, and messages removed by retention
+// do not count as lag.
+func (e *T) getLag(name string, cfg config.LagSLO) (int64, time.Duration, error) {
+	var lagMessages int64
+	var lagTime time.Duration
+	for _, topic := range cfg.Topics {
+		offsets, err := e.admin.GetGroupOffsets(name, topic)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to get offsets, topic=%s", topic)
+		}
+		for _, po := range offsets {
+			consumed := po.Offset
+			if consumed < po.Begin {
+				consumed = po.Begin
+			}
+			if consumed >= po.End {
+				continue
+			}
+			lagMessages += po.End - consumed
+			if cfg.MaxLagTime == 0 {
+				continue
+			}
+			messages, err := e.admin.FetchMessages(topic, po.Partition, consumed, 1)
+			if err != nil {
+				return 0, 0, errors.Wrapf(err, "failed to fetch message, topic=%s, partition=%d", topic, po.Partition)
+			}
+			if len(messages) == 0 || messages[0].Timestamp.IsZero() {
+				continue
+			}
+			if age := e.now().Sub(messages[0].Timestamp); age > lagTime {
+				lagTime = age
+			}
+		}
+	}
+	return lagMessages, lagTime, nil
+}
@@ -0,0 +1,244 @@
+package lagslo
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LagSLOSuite struct {
+	ns    *actor.Descriptor
+	admin *fakeAdmin
+	now   time.Time
+}
+
+var _ = Suite(&LagSLOSuite{})
+
+func (s *LagSLOSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *LagSLOSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.admin = &fakeAdmin{
+		offsets:    make(map[string][]admin.PartitionOffset),
+		timestamps: make(map[string]time.Time),
+	}
+	s.now = time.Date(2018, 7, 22, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *LagSLOSuite) newEvaluator(groups map[string]config.LagSLO) *T {
+	e := newEvaluator(s.ns, config.LagSLOs{CheckInterval: time.Minute, Groups: groups}, s.admin)
+	e.now = func() time.Time { return s.now }
+	return e
+}
+
+// check advances the clock by a minute and checks lag of all groups.
+func (s *LagSLOSuite) check(e *T) {
+	s.now = s.now.Add(time.Minute)
+	e.check()
+}
+
+// An objective fires when the burn rate exceeds the threshold over both
+// windows, and gets back to normal as soon as the short window burn rate
+// drops below it.
+func (s *LagSLOSuite) TestFiring(c *C) {
+	e := s.newEvaluator(map[string]config.LagSLO{"g1": {
+		Topics:            []string{"t1"},
+		MaxLagMessages:    100,
+		Objective:         0.9,
+		ShortWindow:       5 * time.Minute,
+		LongWindow:        time.Hour,
+		BurnRateThreshold: 2,
+	}})
+	s.admin.setOffsets("t1", []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 1000, Offset: 950},
+		{Partition: 1, Begin: 0, End: 1000, Offset: 990},
+	})
+	for i := 0; i < 30; i++ {
+		s.check(e)
+	}
+	c.Check(e.Status("g1").Status, Equals, StatusNormal)
+	c.Check(e.Status("g1").LagMessages, Equals, int64(60))
+
+	// When: lag is over the objective for 7 checks.
+	s.admin.setOffsets("t1", []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 1000, Offset: 850},
+		{Partition: 1, Begin: 0, End: 1000, Offset: 990},
+	})
+	for i := 0; i < 7; i++ {
+		s.check(e)
+	}
+
+	// Then: the short window burn rate is high but not enough of the budget
+	// is burnt over the long window yet.
+	status := e.Status("g1")
+	c.Check(status.Status, Equals, StatusNormal)
+	c.Check(status.LagMessages, Equals, int64(160))
+	c.Check(math.Round(status.ShortBurnRate), Equals, 10.0)
+	c.Check(status.LongBurnRate < 2, Equals, true)
+
+	// When
+	s.check(e)
+
+	// Then
+	status = e.Status("g1")
+	c.Check(status.Status, Equals, StatusFiring)
+	c.Check(status.LongBurnRate >= 2, Equals, true)
+	c.Check(status.CheckedAt, Equals, s.now)
+	for i := 0; i < 4; i++ {
+		s.check(e)
+	}
+
+	// When: lag is back within the objective.
+	s.admin.setOffsets("t1", []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 1000, Offset: 1000},
+		{Partition: 1, Begin: 0, End: 1000, Offset: 1000},
+	})
+	for i := 0; i < 4; i++ {
+		s.check(e)
+	}
+
+	// Then: the burn rate is still over the threshold in the short window.
+	c.Check(e.Status("g1").Status, Equals, StatusFiring)
+
+	// When
+	s.check(e)
+
+	// Then
+	status = e.Status("g1")
+	c.Check(status.Status, Equals, StatusNormal)
+	c.Check(status.LagMessages, Equals, int64(0))
+	c.Check(status.ShortBurnRate, Equals, 0.0)
+}
+
+// Lag time is the age of the oldest message yet to be consumed. Messages
+// removed by retention and partitions with no lag do not count.
+func (s *LagSLOSuite) TestLagTime(c *C) {
+	e := s.newEvaluator(map[string]config.LagSLO{"g1": {
+		Topics:     []string{"t1", "t2"},
+		MaxLagTime: time.Minute,
+	}})
+	s.admin.setOffsets("t1", []admin.PartitionOffset{
+		{Partition: 0, Begin: 100, End: 200, Offset: 10},
+		{Partition: 1, Begin: 0, End: 200, Offset: 200},
+	})
+	s.admin.setOffsets("t2", []admin.PartitionOffset{
+		{Partition: 0, Begin: 0, End: 200, Offset: 190},
+	})
+	s.now = s.now.Add(time.Minute)
+	s.admin.setTimestamp("t1", 0, 100, s.now.Add(-30*time.Second))
+	s.admin.setTimestamp("t1", 1, 200, s.now.Add(-time.Hour))
+	s.admin.setTimestamp("t2", 0, 190, s.now.Add(-90*time.Second))
+
+	// When
+	e.check()
+
+	// Then
+	status := e.Status("g1")
+	c.Check(status.Err, IsNil)
+	c.Check(status.LagMessages, Equals, int64(110))
+	c.Check(status.LagTime, Equals, 90*time.Second)
+	c.Check(math.Round(status.ShortBurnRate), Equals, 100.0)
+	c.Check(math.Round(status.LongBurnRate), Equals, 100.0)
+	c.Check(status.Status, Equals, StatusFiring)
+}
+
+// Failed checks are reported, but they are not counted as samples.
+func (s *LagSLOSuite) TestCheckError(c *C) {
+	e := s.newEvaluator(map[string]config.LagSLO{"g1": {
+		Topics:         []string{"t1"},
+		MaxLagMessages: 10,
+	}})
+	s.admin.setOffsets("t1", []admin.PartitionOffset{{Partition: 0, Begin: 0, End: 100, Offset: 0}})
+	s.check(e)
+	c.Check(e.Status("g1").Status, Equals, StatusFiring)
+
+	// When
+	s.admin.setOffsetsErr(errors.New("kaboom"))
+	s.check(e)
+
+	// Then
+	status := e.Status("g1")
+	c.Check(status.Err, ErrorMatches, "failed to get offsets, topic=t1: kaboom")
+	c.Check(status.Status, Equals, StatusFiring)
+	c.Check(status.CheckedAt, Equals, s.now)
+	g := e.groups["g1"]
+	c.Check(g.samples, HasLen, 1)
+}
+
+// Statuses of all objectives are reported sorted by group, and groups with
+// no objective are reported as not found.
+func (s *LagSLOSuite) TestStatuses(c *C) {
+	e := s.newEvaluator(map[string]config.LagSLO{
+		"g2": {Topics: []string{"t1"}, MaxLagMessages: 10},
+		"g1": {Topics: []string{"t1"}, MaxLagMessages: 10},
+	})
+
+	// When
+	statuses := e.Statuses()
+
+	// Then
+	c.Check(statuses, DeepEquals, []Status{
+		{Group: "g1", Status: StatusNormal},
+		{Group: "g2", Status: StatusNormal},
+	})
+	c.Check(e.Status("g3").Err, Equals, ErrNotFound)
+}
+
+type fakeAdmin struct {
+	mu         sync.Mutex
+	offsets    map[string][]admin.PartitionOffset
+	offsetsErr error
+	timestamps map[string]time.Time
+}
+
+func (a *fakeAdmin) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.offsetsErr != nil {
+		return nil, a.offsetsErr
+	}
+	return a.offsets[topic], nil
+}
+
+func (a *fakeAdmin) FetchMessages(topic string, partition int32, offset int64, limit int) ([]admin.Message, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	timestamp, ok := a.timestamps[fmt.Sprintf("%s:%d:%d", topic, partition, offset)]
+	if !ok {
+		return nil, nil
+	}
+	return []admin.Message{{Offset: offset, Timestamp: timestamp}}, nil
+}
+
+func (a *fakeAdmin) setOffsets(topic string, offsets []admin.PartitionOffset) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.offsets[topic] = offsets
+}
+
+func (a *fakeAdmin) setOffsetsErr(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.offsetsErr = err
+}
+
+func (a *fakeAdmin) setTimestamp(topic string, partition int32, offset int64, timestamp time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.timestamps[fmt.Sprintf("%s:%d:%d", topic, partition, offset)] = timestamp
+}
@@ -0,0 +1,121 @@
+// Package legacygroups implements a one-time startup migration of consumer
+// groups that committed offsets to ZooKeeper in the layout written by older
+// Kafka-Pixy versions and the wvanbergen/kafka consumergroup library. Legacy
+// offsets are copied to where groups commit offsets now, so that upgrading
+// Kafka-Pixy does not make groups start over from the configured initial
+// offset. Every group is migrated once, no matter how many Kafka-Pixy
+// instances are started, for migrations are recorded in ZooKeeper.
+package legacygroups
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// Admin is a subset of administrative operations that the migration needs.
+type Admin interface {
+	ListLegacyGroups() ([]string, error)
+	GetLegacyGroupOffsets(group string) (map[string][]admin.PartitionOffset, error)
+	MigrateLegacyGroup(group string) (admin.LegacyMigration, error)
+}
+
+// T migrates legacy consumer groups in the background.
+type T struct {
+	actDesc *actor.Descriptor
+	cfg     config.LegacyGroups
+	admin   Admin
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+	pending int64
+}
+
+// Spawn creates a migration and starts migrating legacy groups. Groups that
+// could not be migrated, e.g. because members registered by older versions
+// are still consuming them, are retried with the configured interval until
+// all are migrated.
+func Spawn(parentActDesc *actor.Descriptor, cfg config.LegacyGroups, admin Admin) *T {
+	m := newMigration(parentActDesc.NewChild("legacy_groups"), cfg, admin)
+	actor.Spawn(m.actDesc, &m.wg, m.run)
+	return m
+}
+
+func newMigration(actDesc *actor.Descriptor, cfg config.LegacyGroups, admin Admin) *T {
+	m := &T{
+		actDesc: actDesc,
+		cfg:     cfg,
+		admin:   admin,
+		stopCh:  make(chan none.T),
+	}
+	m.actDesc.ObserveGauge("pending", func() int64 {
+		return atomic.LoadInt64(&m.pending)
+	})
+	return m
+}
+
+// Stop makes the migration stop retrying and waits for the group being
+// migrated, if any.
+func (m *T) Stop() {
+	close(m.stopCh)
+	m.wg.Wait()
+}
+
+func (m *T) run() {
+	for {
+		if m.migrate() {
+			return
+		}
+		select {
+		case <-time.After(m.cfg.RetryInterval):
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// migrate migrates all legacy groups that have not been migrated yet. It
+// returns true if there is nothing left to migrate. In dry run mode legacy
+// offsets are only logged, and nothing is left to migrate after that.
+func (m *T) migrate() bool {
+	groups, err := m.admin.ListLegacyGroups()
+	if err != nil {
+		m.actDesc.Log().WithError(err).Error("Failed to list legacy groups")
+		return false
+	}
+	pending := int64(len(groups))
+	atomic.StoreInt64(&m.pending, pending)
+	for _, group := range groups {
+		select {
+		case <-m.stopCh:
+			return true
+		default:
+		}
+		if m.cfg.DryRun {
+			offsets, err := m.admin.GetLegacyGroupOffsets(group)
+			if err != nil {
+				m.actDesc.Log().WithError(err).Errorf("Failed to get legacy offsets: group=%s", group)
+				continue
+			}
+			m.actDesc.Log().Infof("Would migrate legacy group: group=%s, offsets=%v", group, offsets)
+			continue
+		}
+		migration, err := m.admin.MigrateLegacyGroup(group)
+		if err != nil {
+			if err == admin.ErrLegacyGroupActive {
+				m.actDesc.Log().Warnf("Legacy group still consumed by older versions, will retry: group=%s", group)
+				continue
+			}
+			m.actDesc.Log().WithError(err).Errorf("Failed to migrate legacy group: group=%s", group)
+			continue
+		}
+		pending--
+		atomic.StoreInt64(&m.pending, pending)
+		m.actDesc.Log().Infof("Migrated legacy group: group=%s, copied=%v", group, migration.Copied)
+	}
+	return m.cfg.DryRun || pending == 0
+}
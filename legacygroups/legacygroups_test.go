@@ -0,0 +1,155 @@
+package legacygroups
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LegacyGroupsSuite struct {
+	ns    *actor.Descriptor
+	admin *fakeAdmin
+}
+
+var _ = Suite(&LegacyGroupsSuite{})
+
+func (s *LegacyGroupsSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *LegacyGroupsSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.admin = &fakeAdmin{
+		legacy: map[string]bool{"g1": true, "g2": true},
+		errs:   make(map[string]error),
+	}
+}
+
+// All legacy groups are migrated, and then there is nothing left to do.
+func (s *LegacyGroupsSuite) TestMigrate(c *C) {
+	m := newMigration(s.ns, config.LegacyGroups{Migrate: true}, s.admin)
+
+	// When
+	done := m.migrate()
+
+	// Then
+	c.Check(done, Equals, true)
+	c.Check(s.admin.migratedGroups(), DeepEquals, []string{"g1", "g2"})
+	c.Check(atomic.LoadInt64(&m.pending), Equals, int64(0))
+}
+
+// Groups that could not be migrated are left pending, and migrated by a
+// retry once the cause is gone.
+func (s *LegacyGroupsSuite) TestMigrateRetry(c *C) {
+	s.admin.setErr("g1", admin.ErrLegacyGroupActive)
+	s.admin.setErr("g2", errors.New("kaboom"))
+	s.admin.legacy["g3"] = true
+	m := newMigration(s.ns, config.LegacyGroups{Migrate: true}, s.admin)
+
+	// When
+	done1 := m.migrate()
+	pending1 := atomic.LoadInt64(&m.pending)
+	s.admin.setErr("g1", nil)
+	s.admin.setErr("g2", nil)
+	done2 := m.migrate()
+
+	// Then
+	c.Check(done1, Equals, false)
+	c.Check(pending1, Equals, int64(2))
+	c.Check(done2, Equals, true)
+	c.Check(s.admin.migratedGroups(), DeepEquals, []string{"g1", "g2", "g3"})
+}
+
+// In dry run mode nothing is migrated.
+func (s *LegacyGroupsSuite) TestMigrateDryRun(c *C) {
+	m := newMigration(s.ns, config.LegacyGroups{Migrate: true, DryRun: true}, s.admin)
+
+	// When
+	done := m.migrate()
+
+	// Then
+	c.Check(done, Equals, true)
+	c.Check(s.admin.migratedGroups(), IsNil)
+	c.Check(atomic.LoadInt64(&m.pending), Equals, int64(2))
+}
+
+// A spawned migration keeps retrying until all groups are migrated.
+func (s *LegacyGroupsSuite) TestSpawn(c *C) {
+	s.admin.setErr("g1", admin.ErrLegacyGroupActive)
+	m := Spawn(s.ns, config.LegacyGroups{Migrate: true, RetryInterval: 10 * time.Millisecond}, s.admin)
+	defer m.Stop()
+	time.Sleep(50 * time.Millisecond)
+	c.Check(s.admin.migratedGroups(), DeepEquals, []string{"g2"})
+
+	// When
+	s.admin.setErr("g1", nil)
+
+	// Then
+	for i := 0; i < 100 && len(s.admin.migratedGroups()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(s.admin.migratedGroups(), DeepEquals, []string{"g1", "g2"})
+}
+
+type fakeAdmin struct {
+	mu       sync.Mutex
+	legacy   map[string]bool
+	migrated []string
+	errs     map[string]error
+}
+
+func (a *fakeAdmin) ListLegacyGroups() ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var groups []string
+	for group := range a.legacy {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (a *fakeAdmin) GetLegacyGroupOffsets(group string) (map[string][]admin.PartitionOffset, error) {
+	return map[string][]admin.PartitionOffset{"foo": {{Partition: 0, Offset: 42}}}, nil
+}
+
+func (a *fakeAdmin) MigrateLegacyGroup(group string) (admin.LegacyMigration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.errs[group]; err != nil {
+		return admin.LegacyMigration{}, err
+	}
+	delete(a.legacy, group)
+	a.migrated = append(a.migrated, group)
+	return admin.LegacyMigration{MigratedAt: time.Now()}, nil
+}
+
+func (a *fakeAdmin) setErr(group string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errs[group] = err
+}
+
+func (a *fakeAdmin) migratedGroups() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.migrated == nil {
+		return nil
+	}
+	migrated := append([]string(nil), a.migrated...)
+	sort.Strings(migrated)
+	return migrated
+}
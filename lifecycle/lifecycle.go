@@ -0,0 +1,124 @@
+// Package lifecycle implements a bus of internal lifecycle events, such as
+// rebalances and partition claims, that external tooling can subscribe to.
+// Events are published by actors of all proxies to a process wide bus, and
+// every event carries the alias of the cluster it happened in.
+package lifecycle
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// Type is a lifecycle event type.
+type Type string
+
+const (
+	RebalanceStarted    Type = "rebalance_started"
+	RebalanceFinished   Type = "rebalance_finished"
+	PartitionClaimed    Type = "partition_claimed"
+	PartitionReleased   Type = "partition_released"
+	SubscriptionExpired Type = "subscription_expired"
+	Unsubscribed        Type = "unsubscribed"
+	OffsetCommitFailed  Type = "offset_commit_failed"
+	// OffsetCommitRecovered is published when an offset is committed after
+	// a streak of failed commits.
+	OffsetCommitRecovered Type = "offset_commit_recovered"
+)
+
+// Event is a lifecycle event.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    Type      `json:"type"`
+	Cluster string    `json:"cluster"`
+	Group   string    `json:"group"`
+	Topic   string    `json:"topic,omitempty"`
+	// Partition is nil for events that do not concern a particular partition.
+	Partition *int32 `json:"partition,omitempty"`
+	// Partitions assigned to this Kafka-Pixy instance by a rebalance.
+	Assigned map[string][]int32 `json:"assigned,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	// Class of the error and the number of consecutive failures so far,
+	// reported for failed offset commits.
+	ErrorClass string `json:"error_class,omitempty"`
+	Failures   int    `json:"failures,omitempty"`
+}
+
+// Subscription receives all events published after it is created. If a
+// subscriber does not keep up, then events that do not fit into its buffer
+// are dropped.
+type Subscription struct {
+	eventsCh chan Event
+	dropped  int64
+}
+
+var (
+	subscriptionsMu sync.Mutex
+	subscriptions   = make(map[*Subscription]bool)
+)
+
+// Subscribe creates a subscription with the specified buffer size.
+func Subscribe(bufferSize int) *Subscription {
+	s := &Subscription{eventsCh: make(chan Event, bufferSize)}
+	subscriptionsMu.Lock()
+	subscriptions[s] = true
+	subscriptionsMu.Unlock()
+	return s
+}
+
+// Events returns a channel to receive events from. It is closed when the
+// subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.eventsCh
+}
+
+// Dropped returns the number of events dropped because the subscription
+// buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close cancels the subscription.
+func (s *Subscription) Close() {
+	subscriptionsMu.Lock()
+	if subscriptions[s] {
+		delete(subscriptions, s)
+		close(s.eventsCh)
+	}
+	subscriptionsMu.Unlock()
+}
+
+// Publish sends an event to all subscribers. It never blocks.
+func Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	subscriptionsMu.Lock()
+	for s := range subscriptions {
+		select {
+		case s.eventsCh <- ev:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+	subscriptionsMu.Unlock()
+}
+
+// NewGroupEvent creates an event that concerns a consumer group as a whole.
+func NewGroupEvent(cfg *config.Proxy, typ Type, group string) Event {
+	return Event{Type: typ, Cluster: cfg.Cluster, Group: group}
+}
+
+// NewTopicEvent creates an event that concerns a topic consumed by a
+// consumer group.
+func NewTopicEvent(cfg *config.Proxy, typ Type, group, topic string) Event {
+	return Event{Type: typ, Cluster: cfg.Cluster, Group: group, Topic: topic}
+}
+
+// NewPartitionEvent creates an event that concerns a partition consumed by a
+// consumer group.
+func NewPartitionEvent(cfg *config.Proxy, typ Type, group, topic string, partition int32) Event {
+	return Event{Type: typ, Cluster: cfg.Cluster, Group: group, Topic: topic, Partition: &partition}
+}
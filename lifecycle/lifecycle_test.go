@@ -0,0 +1,77 @@
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LifecycleSuite struct {
+	cfg *config.Proxy
+}
+
+var _ = Suite(&LifecycleSuite{})
+
+func (s *LifecycleSuite) SetUpTest(c *C) {
+	s.cfg = config.DefaultProxy()
+	s.cfg.Cluster = "foo"
+}
+
+func (s *LifecycleSuite) TestPublish(c *C) {
+	sub1 := Subscribe(10)
+	defer sub1.Close()
+	sub2 := Subscribe(10)
+	defer sub2.Close()
+
+	// When
+	ev := NewPartitionEvent(s.cfg, OffsetCommitFailed, "g1", "t1", 3)
+	ev.Error = errors.New("kaboom").Error()
+	Publish(ev)
+
+	// Then
+	for _, sub := range []*Subscription{sub1, sub2} {
+		ev := <-sub.Events()
+		c.Check(ev.Time.IsZero(), Equals, false)
+		c.Check(ev.Type, Equals, OffsetCommitFailed)
+		c.Check(ev.Cluster, Equals, "foo")
+		c.Check(ev.Group, Equals, "g1")
+		c.Check(ev.Topic, Equals, "t1")
+		c.Check(*ev.Partition, Equals, int32(3))
+		c.Check(ev.Error, Equals, "kaboom")
+	}
+}
+
+// If a subscriber does not keep up, then events are dropped rather than
+// blocking publishers.
+func (s *LifecycleSuite) TestDropped(c *C) {
+	sub := Subscribe(1)
+	defer sub.Close()
+
+	// When
+	Publish(NewGroupEvent(s.cfg, RebalanceStarted, "g1"))
+	Publish(NewGroupEvent(s.cfg, RebalanceFinished, "g1"))
+	Publish(NewTopicEvent(s.cfg, SubscriptionExpired, "g1", "t1"))
+
+	// Then
+	c.Check((<-sub.Events()).Type, Equals, RebalanceStarted)
+	c.Check(sub.Dropped(), Equals, int64(2))
+}
+
+func (s *LifecycleSuite) TestClose(c *C) {
+	sub := Subscribe(1)
+
+	// When
+	sub.Close()
+	sub.Close()
+	Publish(NewGroupEvent(s.cfg, RebalanceStarted, "g1"))
+
+	// Then
+	_, ok := <-sub.Events()
+	c.Check(ok, Equals, false)
+}
@@ -0,0 +1,85 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// Budget limits the total size of what requests hold at a time, e.g. bytes of
+// request bodies read into memory. Requests that do not fit into the budget
+// wait for other requests to release their share. A nil budget does not limit
+// anything.
+type Budget struct {
+	size int64
+
+	mu         sync.Mutex
+	used       int64
+	releasedCh chan none.T
+}
+
+// NewBudget creates a budget of the specified size. If size is zero, then nil
+// is returned.
+func NewBudget(size int64) *Budget {
+	if size <= 0 {
+		return nil
+	}
+	return &Budget{size: size, releasedCh: make(chan none.T)}
+}
+
+// Acquire takes n units of the budget. If they are not available, then it
+// waits for them for at most the specified timeout. It returns false if n is
+// more than the whole budget, or the timeout expired. If true is returned,
+// then the units must be released by calling Release.
+func (b *Budget) Acquire(n int64, timeout time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	if n > b.size {
+		return false
+	}
+	var timeoutCh <-chan time.Time
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.size {
+			b.used += n
+			b.mu.Unlock()
+			return true
+		}
+		releasedCh := b.releasedCh
+		b.mu.Unlock()
+		if timeoutCh == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			timeoutCh = timer.C
+		}
+		select {
+		case <-releasedCh:
+		case <-timeoutCh:
+			return false
+		}
+	}
+}
+
+// Release gives back n units taken by Acquire.
+func (b *Budget) Release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	close(b.releasedCh)
+	b.releasedCh = make(chan none.T)
+	b.mu.Unlock()
+}
+
+// Used returns the number of units taken.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
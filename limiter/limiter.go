@@ -0,0 +1,84 @@
+// Package limiter implements a concurrency limiter with a bounded wait queue.
+// Requests that exceed the concurrency limit wait in the queue for a free
+// slot, and requests that do not fit into the queue are shed right away. It
+// also implements a budget that limits the total size of what requests hold.
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// T is a concurrency limiter. A nil limiter does not limit anything.
+type T struct {
+	slots     chan none.T
+	maxQueued int32
+	queued    int32
+}
+
+// New creates a limiter that allows up to concurrency requests at a time,
+// and up to maxQueued requests waiting for a slot. If concurrency is zero,
+// then nil is returned.
+func New(concurrency, maxQueued int) *T {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &T{
+		slots:     make(chan none.T, concurrency),
+		maxQueued: int32(maxQueued),
+	}
+}
+
+// Acquire takes a slot. If there are no free slots, then it waits for one
+// for at most the specified timeout. It returns false if the request is
+// shed, either because the queue is full or because the timeout expired.
+// If true is returned, then the slot must be released by calling Release.
+func (l *T) Acquire(timeout time.Duration) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- none.V:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.queued, 1) > l.maxQueued {
+		atomic.AddInt32(&l.queued, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- none.V:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (l *T) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// InFlight returns the number of taken slots.
+func (l *T) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (l *T) Queued() int {
+	if l == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&l.queued))
+}
@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type LimiterSuite struct{}
+
+var _ = Suite(&LimiterSuite{})
+
+func (s *LimiterSuite) TestNil(c *C) {
+	l := New(0, 10)
+
+	c.Check(l, IsNil)
+	c.Check(l.Acquire(0), Equals, true)
+	l.Release()
+	c.Check(l.InFlight(), Equals, 0)
+}
+
+// When there are no free slots requests are queued, and released slots are
+// given to queued requests.
+func (s *LimiterSuite) TestQueue(c *C) {
+	l := New(1, 1)
+	c.Assert(l.Acquire(0), Equals, true)
+
+	// When
+	acquiredCh := make(chan bool)
+	go func() {
+		acquiredCh <- l.Acquire(3 * time.Second)
+	}()
+	for l.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.Release()
+
+	// Then
+	c.Check(<-acquiredCh, Equals, true)
+	c.Check(l.InFlight(), Equals, 1)
+	c.Check(l.Queued(), Equals, 0)
+}
+
+// Requests that do not fit into the queue are shed right away.
+func (s *LimiterSuite) TestShedQueueFull(c *C) {
+	l := New(1, 0)
+	c.Assert(l.Acquire(0), Equals, true)
+
+	// When
+	begin := time.Now()
+	acquired := l.Acquire(3 * time.Second)
+
+	// Then
+	c.Check(acquired, Equals, false)
+	c.Check(time.Since(begin) < time.Second, Equals, true)
+	c.Check(l.Queued(), Equals, 0)
+}
+
+// Queued requests are shed when the timeout expires.
+func (s *LimiterSuite) TestShedTimeout(c *C) {
+	l := New(1, 1)
+	c.Assert(l.Acquire(0), Equals, true)
+
+	// When
+	acquired := l.Acquire(50 * time.Millisecond)
+
+	// Then
+	c.Check(acquired, Equals, false)
+	c.Check(l.InFlight(), Equals, 1)
+	c.Check(l.Queued(), Equals, 0)
+}
+
+func (s *LimiterSuite) TestBudgetNil(c *C) {
+	b := NewBudget(0)
+
+	c.Check(b, IsNil)
+	c.Check(b.Acquire(100, 0), Equals, true)
+	b.Release(100)
+	c.Check(b.Used(), Equals, int64(0))
+}
+
+// Requests that do not fit into the budget wait for others to release their
+// share.
+func (s *LimiterSuite) TestBudgetWait(c *C) {
+	b := NewBudget(100)
+	c.Assert(b.Acquire(60, 0), Equals, true)
+	c.Assert(b.Acquire(30, 0), Equals, true)
+
+	// When
+	acquiredCh := make(chan bool)
+	go func() {
+		acquiredCh <- b.Acquire(50, 3*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Release(30)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-acquiredCh:
+		c.Error("Acquired over budget")
+	default:
+	}
+	b.Release(60)
+
+	// Then
+	c.Check(<-acquiredCh, Equals, true)
+	c.Check(b.Used(), Equals, int64(50))
+}
+
+// Requests are rejected when the timeout expires, or right away if they
+// exceed the whole budget.
+func (s *LimiterSuite) TestBudgetReject(c *C) {
+	b := NewBudget(100)
+	c.Assert(b.Acquire(60, 0), Equals, true)
+
+	// When
+	begin := time.Now()
+	overBudget := b.Acquire(101, 3*time.Second)
+	overBudgetTime := time.Since(begin)
+	timedOut := b.Acquire(50, 50*time.Millisecond)
+
+	// Then
+	c.Check(overBudget, Equals, false)
+	c.Check(overBudgetTime < time.Second, Equals, true)
+	c.Check(timedOut, Equals, false)
+	c.Check(b.Used(), Equals, int64(60))
+}
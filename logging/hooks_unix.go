@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"log/syslog"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	syslogrus "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+func newSyslogHook() (log.Hook, error) {
+	return syslogrus.NewSyslogHook("udp", "127.0.0.1:514", syslog.LOG_INFO|syslog.LOG_MAIL, "kafka-pixy")
+}
+
+func newEventLogHook() (log.Hook, error) {
+	return nil, errors.New("eventlog is only supported on Windows")
+}
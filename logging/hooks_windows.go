@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// EventLogSource is the name of the Windows event log source that Kafka-Pixy
+// logs to. It is registered when Kafka-Pixy is installed as a service.
+const EventLogSource = "kafka-pixy"
+
+func newSyslogHook() (log.Hook, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
+
+func newEventLogHook() (log.Hook, error) {
+	elog, err := eventlog.Open(EventLogSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open event log")
+	}
+	return &eventLogHook{elog: elog, formatter: &textFormatter{}}, nil
+}
+
+// eventLogHook is a sirupsen/logrus hook that writes to the Windows event
+// log, that is where services are expected to log to.
+type eventLogHook struct {
+	elog      *eventlog.Log
+	formatter log.Formatter
+}
+
+func (h *eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *eventLogHook) Fire(entry *log.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.elog.Error(1, string(line))
+	case log.WarnLevel:
+		return h.elog.Warning(1, string(line))
+	default:
+		return h.elog.Info(1, string(line))
+	}
+}
@@ -3,7 +3,6 @@ package logging
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log/syslog"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/config"
@@ -12,7 +11,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 	log "github.com/sirupsen/logrus"
-	syslogrus "github.com/sirupsen/logrus/hooks/syslog"
 )
 
 // Init initializes sirupsen/logrus hooks from the JSON config string. It also
@@ -34,12 +32,19 @@ func Init(jsonCfg string, cfg *config.App) error {
 		case "console":
 			stdoutEnabled = true
 		case "syslog":
-			h, err := syslogrus.NewSyslogHook("udp", "127.0.0.1:514", syslog.LOG_INFO|syslog.LOG_MAIL, "kafka-pixy")
+			h, err := newSyslogHook()
 			if err != nil {
 				continue
 			}
 			hooks = append(hooks, levelfilter.New(h, loggerCfg.level()))
 			nonStdoutEnabled = true
+		case "eventlog":
+			h, err := newEventLogHook()
+			if err != nil {
+				return err
+			}
+			hooks = append(hooks, levelfilter.New(h, loggerCfg.level()))
+			nonStdoutEnabled = true
 		case "udplog":
 			if cfg == nil {
 				return errors.Errorf("App config must be provided")
@@ -90,8 +95,8 @@ func Init(jsonCfg string, cfg *config.App) error {
 
 // loggerCfg represents a configuration of an individual logger.
 type loggerCfg struct {
-	// Name defines a logger to be used. It can be one of: console, syslog, or
-	// udplog.
+	// Name defines a logger to be used. It can be one of: console, syslog,
+	// udplog, or eventlog, the latter is only supported on Windows.
 	Name string `json:"name"`
 
 	// Severity indicates the minimum severity a logger will be logging messages at.
@@ -3,14 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"strings"
-	"syscall"
 
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/gctune"
+	"github.com/mailgun/kafka-pixy/hostlock"
 	"github.com/mailgun/kafka-pixy/logging"
+	"github.com/mailgun/kafka-pixy/mockcluster"
+	"github.com/mailgun/kafka-pixy/probe"
 	"github.com/mailgun/kafka-pixy/service"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,10 +26,15 @@ var (
 	cmdConfig         string
 	cmdTCPAddr        string
 	cmdUnixAddr       string
+	cmdMQTTAddr       string
+	cmdSTOMPAddr      string
 	cmdKafkaPeers     string
 	cmdZookeeperPeers string
 	cmdPIDFile        string
+	cmdInstance       string
 	cmdLoggingJSONCfg string
+	cmdProbe          bool
+	cmdMock           bool
 )
 
 func init() {
@@ -35,29 +42,90 @@ func init() {
 	flag.StringVar(&cmdGRPCAddr, "grpcAddr", "", "TCP address that the gRPC API should listen on")
 	flag.StringVar(&cmdTCPAddr, "tcpAddr", "", "TCP address that the HTTP API should listen on")
 	flag.StringVar(&cmdUnixAddr, "unixAddr", "", "Unix domain socket address that the HTTP API should listen on")
+	flag.StringVar(&cmdMQTTAddr, "mqttAddr", "", "TCP address that the MQTT server should listen on")
+	flag.StringVar(&cmdSTOMPAddr, "stompAddr", "", "TCP address that the STOMP server should listen on")
 	flag.StringVar(&cmdKafkaPeers, "kafkaPeers", "", "Comma separated list of brokers")
 	flag.StringVar(&cmdZookeeperPeers, "zookeeperPeers", "", "Comma separated list of ZooKeeper nodes followed by optional chroot")
 	flag.StringVar(&cmdPIDFile, "pidFile", "", "Path to the PID file")
+	flag.StringVar(&cmdInstance, "instance", "", "Name of an instance among several running on one host, appended to client IDs")
 	flag.StringVar(&cmdLoggingJSONCfg, "logging", defaultLoggingCfg, "Logging configuration")
+	flag.BoolVar(&cmdProbe, "probe", false, "Check connectivity to all configured Kafka and ZooKeeper peers, print a report and exit")
+	flag.BoolVar(&cmdMock, "mock", false, "Serve the API against in-memory mock Kafka and ZooKeeper clusters instead of configured ones, for local development")
 	flag.Parse()
 }
 
 func main() {
+	if ok, err := controlWinService(); ok {
+		if err != nil {
+			fmt.Printf("Failed to control Windows service: err=(%s)\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(runUntilStopped(run))
+}
+
+// run starts Kafka-Pixy and keeps it running until stopCh is closed. It
+// returns the process exit code.
+func run(stopCh <-chan struct{}) int {
 	cfg, err := makeConfig()
 	if err != nil {
 		fmt.Printf("Failed to load config: err=(%s)\n", err)
-		os.Exit(1)
+		return 1
+	}
+
+	// In mock mode every configured cluster is replaced with an in-memory
+	// one, all data is lost when Kafka-Pixy stops.
+	if cmdMock {
+		for cluster, proxyCfg := range cfg.Proxies {
+			mc, err := mockcluster.Spawn(mockcluster.Config{})
+			if err != nil {
+				fmt.Printf("Failed to start mock cluster: cluster=%s, err=(%s)\n", cluster, err)
+				return 1
+			}
+			defer mc.Stop()
+			mc.Configure(proxyCfg)
+		}
+	}
+
+	if cmdProbe {
+		outcomes := probe.All(cfg, probe.DefaultTimeout)
+		fmt.Print(probe.Format(outcomes))
+		if probe.Failed(outcomes) {
+			return 1
+		}
+		return 0
 	}
 
 	if err := logging.Init(cmdLoggingJSONCfg, cfg); err != nil {
 		fmt.Printf("Failed to initialize logger: err=(%s)\n", err)
-		os.Exit(1)
+		return 1
 	}
 
+	// The PID file and client ID lock files are locked for as long as the
+	// process lives, so that two instances cannot use them at once.
+	var locks []*hostlock.T
+	defer func() {
+		for _, l := range locks {
+			l.Release()
+		}
+	}()
 	if cmdPIDFile != "" {
-		if err := writePID(cmdPIDFile); err != nil {
+		l, err := hostlock.Acquire(cmdPIDFile)
+		if err != nil {
 			log.Errorf("Failed to write PID file: err=(%s)", err)
-			os.Exit(1)
+			return 1
+		}
+		locks = append(locks, l)
+	}
+	if cfg.LockDir != "" {
+		for _, clientID := range cfg.ClientIDs() {
+			l, err := hostlock.Acquire(hostlock.ForClientID(cfg.LockDir, clientID))
+			if err != nil {
+				log.Errorf("Client ID is in use by another instance: client_id=%s, err=(%s)", clientID, err)
+				return 1
+			}
+			locks = append(locks, l)
 		}
 	}
 
@@ -70,20 +138,42 @@ func main() {
 		}
 	}
 
+	// Kafka and ZooKeeper peers are probed before the service starts, so
+	// that a misconfigured cluster is reported in plain words.
+	outcomes := probe.All(cfg, probe.DefaultTimeout)
+	if err := probe.Fatal(outcomes); err != nil {
+		log.Errorf("Connectivity probe failed: err=(%s)\n%s", err, probe.Format(outcomes))
+		return 1
+	}
+	if probe.Failed(outcomes) {
+		log.Warnf("Connectivity probe found problems:\n%s", probe.Format(outcomes))
+	}
+
+	gctune.Apply(cfg.GC.Percent, cfg.GC.BallastSize)
+
 	log.Infof("Starting with config: %+v", cfg)
 	svc, err := service.Spawn(cfg)
 	if err != nil {
 		log.Errorf("Failed to start service: err=(%s)", err)
-		os.Exit(1)
+		return 1
 	}
 
-	// Spawn OS signal listener to ensure graceful stop.
-	osSigCh := make(chan os.Signal, 1)
-	signal.Notify(osSigCh, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
-
-	// Wait for a quit signal and terminate the service when it is received.
-	<-osSigCh
+	<-stopCh
 	svc.Stop()
+	return 0
+}
+
+// stopOnSignals returns a channel that is closed when the process receives
+// any of the specified OS signals.
+func stopOnSignals(signals ...os.Signal) <-chan struct{} {
+	osSigCh := make(chan os.Signal, 1)
+	signal.Notify(osSigCh, signals...)
+	stopCh := make(chan struct{})
+	go func() {
+		<-osSigCh
+		close(stopCh)
+	}()
+	return stopCh
 }
 
 func makeConfig() (*config.App, error) {
@@ -108,6 +198,12 @@ func makeConfig() (*config.App, error) {
 	if cmdUnixAddr != "" {
 		cfg.UnixAddr = cmdUnixAddr
 	}
+	if cmdMQTTAddr != "" {
+		cfg.MQTTAddr = cmdMQTTAddr
+	}
+	if cmdSTOMPAddr != "" {
+		cfg.STOMPAddr = cmdSTOMPAddr
+	}
 	if cmdKafkaPeers != "" {
 		cfg.Proxies[cfg.DefaultCluster].Kafka.SeedPeers = strings.Split(cmdKafkaPeers, ",")
 	}
@@ -120,10 +216,8 @@ func makeConfig() (*config.App, error) {
 			cfg.Proxies[cfg.DefaultCluster].ZooKeeper.SeedPeers = strings.Split(cmdZookeeperPeers, ",")
 		}
 	}
+	if cmdInstance != "" {
+		cfg.SetInstance(cmdInstance)
+	}
 	return cfg, nil
 }
-
-func writePID(path string) error {
-	pid := os.Getpid()
-	return ioutil.WriteFile(path, []byte(fmt.Sprint(pid)), 0644)
-}
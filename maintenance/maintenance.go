@@ -0,0 +1,200 @@
+// Package maintenance implements scheduled maintenance windows of a cluster.
+// During a window either consumption by consumer groups is paused, or the
+// cluster is read-only, and when the window ends the cluster gets back to
+// normal, so that routine downstream maintenance does not require pausing
+// consumers by hand. Windows starting and ending are logged with `audit.*`
+// fields, the same way other operator actions are, so that they show up in
+// audit logs.
+package maintenance
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkInterval is how often windows are checked for starting or ending.
+var checkInterval = time.Second
+
+// T tracks maintenance windows of a cluster.
+type T struct {
+	actDesc *actor.Descriptor
+	windows map[string]config.MaintenanceWindow
+	now     func() time.Time
+	stopCh  chan none.T
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[string]*occurrence
+}
+
+// occurrence is an active occurrence of a window.
+type occurrence struct {
+	start time.Time
+	end   time.Time
+	// Closed when the occurrence ends.
+	endedCh chan none.T
+}
+
+// Spawn creates a tracker of maintenance windows. Windows that are active
+// already are started before it returns, so that they apply to the very
+// first requests.
+func Spawn(parentActDesc *actor.Descriptor, windows map[string]config.MaintenanceWindow) *T {
+	t := newTracker(parentActDesc.NewChild("maintenance"), windows)
+	t.update(t.now())
+	actor.Spawn(t.actDesc, &t.wg, t.run)
+	return t
+}
+
+func newTracker(actDesc *actor.Descriptor, windows map[string]config.MaintenanceWindow) *T {
+	t := &T{
+		actDesc: actDesc,
+		windows: windows,
+		now:     time.Now,
+		stopCh:  make(chan none.T),
+		active:  make(map[string]*occurrence, len(windows)),
+	}
+	for name := range windows {
+		name := name
+		t.actDesc.ObserveGauge(name+"_active", func() int64 {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.active[name] != nil {
+				return 1
+			}
+			return 0
+		})
+	}
+	return t
+}
+
+// Stop stops tracking windows. Windows that are active stay active.
+func (t *T) Stop() {
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// IsReadOnly tells whether a `read_only` window is active.
+func (t *T) IsReadOnly() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.active {
+		if t.windows[name].Mode == config.MaintenanceReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// Paused returns nil if messages can be offered to a group, or a channel
+// that is closed when a window that pauses the group ends otherwise. Note
+// that the group may still be paused by another window by then.
+func (t *T) Paused(group string) <-chan none.T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range t.activeNames() {
+		if pauses(t.windows[name], group) {
+			return t.active[name].endedCh
+		}
+	}
+	return nil
+}
+
+func (t *T) run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.update(t.now())
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// update starts windows that are due at the specified time, and ends
+// those that are over.
+func (t *T) update(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, window := range t.windows {
+		start, end, ok := current(window, now)
+		occ := t.active[name]
+		if occ != nil && (!ok || !start.Equal(occ.start)) {
+			close(occ.endedCh)
+			delete(t.active, name)
+			t.auditLog(name, window, occ.start, occ.end).Warn("Maintenance window ended")
+			occ = nil
+		}
+		if occ == nil && ok {
+			t.active[name] = &occurrence{start: start, end: end, endedCh: make(chan none.T)}
+			t.auditLog(name, window, start, end).Warn("Maintenance window started")
+		}
+	}
+}
+
+// activeNames returns names of active windows sorted, so that the window a
+// group is reported paused by does not change at random. It must be called
+// with the mutex held.
+func (t *T) activeNames() []string {
+	names := make([]string, 0, len(t.active))
+	for name := range t.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *T) auditLog(name string, window config.MaintenanceWindow, start, end time.Time) *log.Entry {
+	mode := window.Mode
+	if mode == "" {
+		mode = config.MaintenancePause
+	}
+	fields := log.Fields{
+		"audit.by":     "maintenance_window",
+		"audit.reason": name,
+		"window.mode":  mode,
+		"window.start": start.Format(time.RFC3339),
+		"window.end":   end.Format(time.RFC3339),
+	}
+	if len(window.Groups) > 0 {
+		fields["window.groups"] = window.Groups
+	}
+	return t.actDesc.Log().WithFields(fields)
+}
+
+// current returns the occurrence of a window that the specified time falls
+// into, if any.
+func current(window config.MaintenanceWindow, now time.Time) (time.Time, time.Time, bool) {
+	start := window.Start
+	if window.Every > 0 && !now.Before(start) {
+		start = start.Add(now.Sub(start) / window.Every * window.Every)
+	}
+	end := start.Add(window.End.Sub(window.Start))
+	if now.Before(start) || !now.Before(end) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// pauses tells whether a window pauses a group.
+func pauses(window config.MaintenanceWindow, group string) bool {
+	if window.Mode == config.MaintenanceReadOnly {
+		return false
+	}
+	if len(window.Groups) == 0 {
+		return true
+	}
+	for _, windowGroup := range window.Groups {
+		if windowGroup == group {
+			return true
+		}
+	}
+	return false
+}
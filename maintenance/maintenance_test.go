@@ -0,0 +1,142 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MaintenanceSuite struct {
+	ns *actor.Descriptor
+}
+
+var _ = Suite(&MaintenanceSuite{})
+
+var t0 = time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC)
+
+func (s *MaintenanceSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *MaintenanceSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+}
+
+func (s *MaintenanceSuite) TestCurrent(c *C) {
+	once := config.MaintenanceWindow{Start: t0, End: t0.Add(time.Hour)}
+	daily := config.MaintenanceWindow{Start: t0, End: t0.Add(time.Hour), Every: 24 * time.Hour}
+	for i, tc := range []struct {
+		window config.MaintenanceWindow
+		now    time.Time
+		start  time.Time
+		ok     bool
+	}{
+		{window: once, now: t0.Add(-time.Second)},
+		{window: once, now: t0, start: t0, ok: true},
+		{window: once, now: t0.Add(time.Hour - time.Second), start: t0, ok: true},
+		{window: once, now: t0.Add(time.Hour)},
+		{window: once, now: t0.Add(24 * time.Hour)},
+		{window: daily, now: t0.Add(-time.Second)},
+		{window: daily, now: t0.Add(time.Hour)},
+		{window: daily, now: t0.Add(72*time.Hour + time.Minute), start: t0.Add(72 * time.Hour), ok: true},
+		{window: daily, now: t0.Add(73 * time.Hour)},
+	} {
+		start, end, ok := current(tc.window, tc.now)
+		c.Check(ok, Equals, tc.ok, Commentf("case #%d", i))
+		if tc.ok {
+			c.Check(start, Equals, tc.start, Commentf("case #%d", i))
+			c.Check(end, Equals, tc.start.Add(time.Hour), Commentf("case #%d", i))
+		}
+	}
+}
+
+// Groups are paused while a window that selects them is active, and
+// resumed when it ends.
+func (s *MaintenanceSuite) TestPaused(c *C) {
+	t := newTracker(s.ns, map[string]config.MaintenanceWindow{
+		"all": {Start: t0, End: t0.Add(time.Hour)},
+		"g1":  {Start: t0.Add(30 * time.Minute), End: t0.Add(2 * time.Hour), Groups: []string{"g1"}},
+	})
+
+	t.update(t0.Add(-time.Second))
+	c.Check(t.Paused("g1"), IsNil)
+	c.Check(t.Paused("g2"), IsNil)
+
+	t.update(t0)
+	c.Check(t.Paused("g1"), NotNil)
+	c.Check(t.Paused("g2"), NotNil)
+
+	t.update(t0.Add(30 * time.Minute))
+	g1PausedCh, g2PausedCh := t.Paused("g1"), t.Paused("g2")
+
+	// When
+	t.update(t0.Add(time.Hour))
+
+	// Then
+	assertClosed(c, g1PausedCh)
+	assertClosed(c, g2PausedCh)
+	c.Check(t.Paused("g1"), NotNil)
+	c.Check(t.Paused("g2"), IsNil)
+
+	t.update(t0.Add(2 * time.Hour))
+	c.Check(t.Paused("g1"), IsNil)
+	c.Check(t.IsReadOnly(), Equals, false)
+}
+
+// A recurring window that ends as soon as the next occurrence starts ends
+// the old occurrence all the same.
+func (s *MaintenanceSuite) TestBackToBack(c *C) {
+	t := newTracker(s.ns, map[string]config.MaintenanceWindow{
+		"w1": {Start: t0, End: t0.Add(time.Hour), Every: time.Hour},
+	})
+	t.update(t0)
+	pausedCh := t.Paused("g1")
+
+	// When
+	t.update(t0.Add(time.Hour))
+
+	// Then
+	assertClosed(c, pausedCh)
+	c.Check(t.Paused("g1"), NotNil)
+}
+
+func (s *MaintenanceSuite) TestReadOnly(c *C) {
+	t := newTracker(s.ns, map[string]config.MaintenanceWindow{
+		"w1": {Start: t0, End: t0.Add(time.Hour), Mode: config.MaintenanceReadOnly},
+	})
+
+	t.update(t0)
+	c.Check(t.IsReadOnly(), Equals, true)
+	c.Check(t.Paused("g1"), IsNil)
+
+	t.update(t0.Add(time.Hour))
+	c.Check(t.IsReadOnly(), Equals, false)
+}
+
+// A window that is active on spawn applies right away.
+func (s *MaintenanceSuite) TestSpawnActive(c *C) {
+	now := time.Now()
+	t := Spawn(s.ns, map[string]config.MaintenanceWindow{
+		"w1": {Start: now.Add(-time.Minute), End: now.Add(time.Hour), Mode: config.MaintenanceReadOnly},
+	})
+	defer t.Stop()
+
+	c.Check(t.IsReadOnly(), Equals, true)
+}
+
+func assertClosed(c *C, ch <-chan none.T) {
+	select {
+	case <-ch:
+	default:
+		c.Error("channel is not closed")
+	}
+}
@@ -0,0 +1,262 @@
+// Package migration keeps track of consumer group migrations. A group is
+// migrated either from an external offset storage backend to Kafka, or to
+// another group name. A migration starts paused: Kafka-Pixy stops offering
+// messages of the group, so that its members stop consuming and the offsets
+// they acknowledged get committed. Then offsets are copied to the target, and
+// the migration is completed: a group migrated to Kafka resumes with offsets
+// committed to Kafka, and a renamed group is consumed by its new name.
+//
+// Migrations are kept in ZooKeeper, so that all Kafka-Pixy instances working
+// with a cluster treat a group the same way, no matter which one was called.
+// Every migration is a znode named after a group with a JSON document as
+// data. Instances watch the migration directory and hand migrations over to
+// the proxy whenever it changes.
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/backoff"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/zkconn"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Phases of a migration.
+const (
+	PhasePaused    = "paused"
+	PhaseCompleted = "completed"
+)
+
+var (
+	// ErrNotFound is returned when a group is not being migrated.
+	ErrNotFound = errors.New("group is not being migrated")
+
+	// ErrExists is returned when a group that is already being migrated is
+	// migrated again.
+	ErrExists = errors.New("group is already being migrated")
+
+	// ErrCompleted is returned when a migration that is already completed
+	// is completed again.
+	ErrCompleted = errors.New("group migration is already completed")
+
+	retryBackoff = backoff.Exponential{Base: 100 * time.Millisecond, Cap: 5 * time.Second, Jitter: 0.2}
+)
+
+// Migration describes a consumer group migration. Exactly one of ToBackend
+// and ToGroup is set.
+type Migration struct {
+	// The offset storage backend the group is migrated to, it can only be
+	// `kafka`.
+	ToBackend string `json:"to_backend,omitempty"`
+
+	// The group name the group is migrated to.
+	ToGroup string `json:"to_group,omitempty"`
+
+	// Topics consumed by the group, offsets are copied for these only.
+	Topics []string `json:"topics"`
+
+	Phase       string     `json:"phase"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// T maintains migrations of consumer groups of a cluster.
+type T struct {
+	actDesc  *actor.Descriptor
+	zkConn   *zk.Conn
+	dir      string
+	onChange func(migrations map[string]Migration)
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Spawn starts watching migrations of consumer groups on the ZooKeeper
+// cluster of `cfg`. The onChange callback is called with all migrations
+// mapped to group names every time they change.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, onChange func(migrations map[string]Migration)) (*T, error) {
+	actDesc := parentActDesc.NewChild("migration")
+	zkConn, _, err := zkconn.Connect(actDesc, cfg, cfg.ZooKeeper.SessionTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ZooKeeper")
+	}
+	m := &T{
+		actDesc:  actDesc,
+		zkConn:   zkConn,
+		dir:      fmt.Sprintf("%s/kafka-pixy/migrations", cfg.ZooKeeper.Chroot),
+		onChange: onChange,
+		stopCh:   make(chan struct{}),
+	}
+	actor.Spawn(m.actDesc, &m.wg, m.run)
+	return m, nil
+}
+
+// Start records a paused migration of a group. It returns ErrExists if the
+// group is already being migrated.
+func (m *T) Start(group string, migration Migration) (Migration, error) {
+	migration.Phase = PhasePaused
+	migration.StartedAt = time.Now().UTC()
+	migration.CompletedAt = nil
+	data, _ := json.Marshal(migration)
+	path := m.dir + "/" + group
+	for {
+		_, err := m.zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+		switch err {
+		case nil:
+			return migration, nil
+		case zk.ErrNoNode:
+			if err := m.createDir(); err != nil {
+				return Migration{}, err
+			}
+			continue
+		case zk.ErrNodeExists:
+			return Migration{}, ErrExists
+		default:
+			return Migration{}, errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+}
+
+// Complete marks the migration of a group completed. It fails if the
+// migration has been changed since it was read by Get, as told by version.
+func (m *T) Complete(group string, migration Migration, version int32) (Migration, error) {
+	completedAt := time.Now().UTC()
+	migration.Phase = PhaseCompleted
+	migration.CompletedAt = &completedAt
+	data, _ := json.Marshal(migration)
+	path := m.dir + "/" + group
+	if _, err := m.zkConn.Set(path, data, version); err != nil {
+		if err == zk.ErrNoNode {
+			return Migration{}, ErrNotFound
+		}
+		return Migration{}, errors.Wrapf(err, "failed to update %s", path)
+	}
+	// Data changes of a migration do not trigger the child watch of the
+	// directory, so the directory is touched to trigger its data watch
+	// instead.
+	if _, err := m.zkConn.Set(m.dir, nil, -1); err != nil {
+		return Migration{}, errors.Wrapf(err, "failed to update %s", m.dir)
+	}
+	return migration, nil
+}
+
+// Delete removes the migration of a group. It returns ErrNotFound if the
+// group is not being migrated.
+func (m *T) Delete(group string) error {
+	path := m.dir + "/" + group
+	if err := m.zkConn.Delete(path, -1); err != nil {
+		if err == zk.ErrNoNode {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "failed to delete %s", path)
+	}
+	return nil
+}
+
+// Get returns the migration of a group along with its znode version. It
+// returns ErrNotFound if the group is not being migrated.
+func (m *T) Get(group string) (Migration, int32, error) {
+	path := m.dir + "/" + group
+	data, stat, err := m.zkConn.Get(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return Migration{}, 0, ErrNotFound
+		}
+		return Migration{}, 0, errors.Wrapf(err, "failed to get %s", path)
+	}
+	var migration Migration
+	if err := json.Unmarshal(data, &migration); err != nil {
+		return Migration{}, 0, errors.Wrapf(err, "bad migration %s", path)
+	}
+	return migration, stat.Version, nil
+}
+
+// Stop stops watching migrations.
+func (m *T) Stop() {
+	close(m.stopCh)
+	m.wg.Wait()
+	m.zkConn.Close()
+}
+
+func (m *T) run() {
+	for retries := 0; ; retries++ {
+		if retries > 0 {
+			select {
+			case <-time.After(retryBackoff.Backoff(retries)):
+			case <-m.stopCh:
+				return
+			}
+		}
+		childrenEventCh, dataEventCh, err := m.load()
+		if err != nil {
+			m.actDesc.Log().WithError(err).Error("Failed to load migrations")
+			continue
+		}
+		retries = -1
+		select {
+		case <-childrenEventCh:
+		case <-dataEventCh:
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// load reads all migrations and hands them over to the onChange callback. It
+// returns channels that signal when either the list of migrations or the
+// data of the migration directory change. If the directory does not exist,
+// then the returned channels signal when it is created.
+func (m *T) load() (<-chan zk.Event, <-chan zk.Event, error) {
+	groups, _, childrenEventCh, err := m.zkConn.ChildrenW(m.dir)
+	if err == zk.ErrNoNode {
+		exists, _, existsEventCh, err := m.zkConn.ExistsW(m.dir)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to watch %s", m.dir)
+		}
+		if exists {
+			return nil, nil, errors.Errorf("%s created while loading", m.dir)
+		}
+		m.onChange(nil)
+		return existsEventCh, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to watch %s", m.dir)
+	}
+	_, _, dataEventCh, err := m.zkConn.GetW(m.dir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to watch %s", m.dir)
+	}
+	migrations := make(map[string]Migration, len(groups))
+	for _, group := range groups {
+		migration, _, err := m.Get(group)
+		if err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			m.actDesc.Log().WithError(err).Errorf("Bad migration ignored: group=%s", group)
+			continue
+		}
+		migrations[group] = migration
+	}
+	m.onChange(migrations)
+	return childrenEventCh, dataEventCh, nil
+}
+
+// createDir creates the migration directory along with all its ancestors.
+func (m *T) createDir() error {
+	path := ""
+	for _, name := range strings.Split(strings.TrimPrefix(m.dir, "/"), "/") {
+		path += "/" + name
+		_, err := m.zkConn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return errors.Wrapf(err, "failed to create %s", path)
+		}
+	}
+	return nil
+}
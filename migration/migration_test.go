@@ -0,0 +1,116 @@
+package migration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/mockcluster"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MigrationSuite struct {
+	mc           *mockcluster.T
+	cfg          *config.Proxy
+	migrationsCh chan map[string]Migration
+}
+
+var _ = Suite(&MigrationSuite{})
+
+func (s *MigrationSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *MigrationSuite) SetUpTest(c *C) {
+	var err error
+	s.mc, err = mockcluster.Spawn(mockcluster.Config{})
+	c.Assert(err, IsNil)
+	s.cfg = testhelpers.NewTestProxyCfg("c1")
+	s.mc.Configure(s.cfg)
+	s.migrationsCh = make(chan map[string]Migration, 100)
+}
+
+func (s *MigrationSuite) TearDownTest(c *C) {
+	s.mc.Stop()
+}
+
+func (s *MigrationSuite) onChange(migrations map[string]Migration) {
+	s.migrationsCh <- migrations
+}
+
+// Migrations started and completed via one instance are handed over to all
+// of them, until they are deleted.
+func (s *MigrationSuite) TestLifecycle(c *C) {
+	m1, err := Spawn(actor.Root(), s.cfg, func(map[string]Migration) {})
+	c.Assert(err, IsNil)
+	defer m1.Stop()
+	m2, err := Spawn(actor.Root(), s.cfg, s.onChange)
+	c.Assert(err, IsNil)
+	defer m2.Stop()
+	c.Assert(<-s.migrationsCh, HasLen, 0)
+
+	// When
+	started, err := m1.Start("g1", Migration{ToBackend: config.OffsetStorageKafka, Topics: []string{"t1"}})
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Check(started.Phase, Equals, PhasePaused)
+	s.assertPhases(c, map[string]string{"g1": PhasePaused})
+	_, err = m1.Start("g1", Migration{ToGroup: "g2", Topics: []string{"t1"}})
+	c.Check(err, Equals, ErrExists)
+
+	// When
+	migration, version, err := m2.Get("g1")
+	c.Assert(err, IsNil)
+	completed, err := m2.Complete("g1", migration, version)
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Check(completed.Phase, Equals, PhaseCompleted)
+	c.Check(completed.CompletedAt, NotNil)
+	s.assertPhases(c, map[string]string{"g1": PhaseCompleted})
+	_, err = m1.Complete("g1", migration, version)
+	c.Check(err, ErrorMatches, "failed to update .*/kafka-pixy/migrations/g1: zk: version conflict")
+
+	// When
+	c.Assert(m1.Delete("g1"), IsNil)
+
+	// Then
+	s.assertPhases(c, map[string]string{})
+	_, _, err = m2.Get("g1")
+	c.Check(err, Equals, ErrNotFound)
+	c.Check(m1.Delete("g1"), Equals, ErrNotFound)
+}
+
+// assertPhases waits for migrations in the expected phases to be handed over.
+func (s *MigrationSuite) assertPhases(c *C, expected map[string]string) {
+	var migrations map[string]Migration
+	for {
+		select {
+		case migrations = <-s.migrationsCh:
+			if samePhases(migrations, expected) {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			c.Fatalf("Expected migrations have not been handed over: %v", migrations)
+		}
+	}
+}
+
+func samePhases(migrations map[string]Migration, expected map[string]string) bool {
+	if len(migrations) != len(expected) {
+		return false
+	}
+	for group, phase := range expected {
+		if migrations[group].Phase != phase {
+			return false
+		}
+	}
+	return true
+}
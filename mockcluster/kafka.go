@@ -0,0 +1,889 @@
+package mockcluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	brokerID          = 1
+	maxKafkaFrameSize = 100 * 1024 * 1024
+
+	apiKeyProduce         = 0
+	apiKeyFetch           = 1
+	apiKeyListOffsets     = 2
+	apiKeyMetadata        = 3
+	apiKeyOffsetCommit    = 8
+	apiKeyOffsetFetch     = 9
+	apiKeyFindCoordinator = 10
+	apiKeyListGroups      = 16
+	apiKeyAPIVersions     = 18
+	apiKeyCreateTopics    = 19
+	apiKeyDescribeConfigs = 32
+	apiKeyDeleteGroups    = 42
+
+	errNone                    = 0
+	errOffsetOutOfRange        = 1
+	errCorruptMessage          = 2
+	errUnknownTopicOrPartition = 3
+	errInvalidTopic            = 17
+	errTopicAlreadyExists      = 36
+	errInvalidPartitions       = 37
+	errGroupIDNotFound         = 69
+
+	offsetNewest = -1
+	offsetOldest = -2
+
+	compressionMask = 0x07
+	compressionGZIP = 1
+
+	// The size of a record batch without records.
+	recordBatchOverhead = 61
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// apiVersions lists requests that the mock broker supports along with their
+// versions. They are the versions that sarama uses when configured for Kafka
+// 0.11.0.0, that the mock broker pretends to be.
+var apiVersions = []struct {
+	key, minVersion, maxVersion int16
+}{
+	{apiKeyProduce, 3, 3},
+	{apiKeyFetch, 4, 5},
+	{apiKeyListOffsets, 0, 1},
+	{apiKeyMetadata, 1, 1},
+	{apiKeyOffsetCommit, 1, 1},
+	{apiKeyOffsetFetch, 1, 1},
+	{apiKeyFindCoordinator, 0, 0},
+	{apiKeyListGroups, 0, 0},
+	{apiKeyAPIVersions, 0, 0},
+	{apiKeyCreateTopics, 0, 1},
+	{apiKeyDescribeConfigs, 0, 0},
+	{apiKeyDeleteGroups, 0, 0},
+}
+
+// topicConfigs are reported by DescribeConfigs for every topic. They are
+// defaults of a real broker, and all topics have them.
+var topicConfigs = []struct {
+	name, value string
+}{
+	{"cleanup.policy", "delete"},
+	{"compression.type", "producer"},
+	{"max.message.bytes", "1000012"},
+	{"retention.bytes", "-1"},
+	{"retention.ms", "604800000"},
+}
+
+type record struct {
+	timestamp int64
+	key       []byte
+	value     []byte
+	headers   []recordHeader
+}
+
+type recordHeader struct {
+	key   []byte
+	value []byte
+}
+
+type committedOffset struct {
+	offset   int64
+	metadata string
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaBroker is a single broker Kafka cluster that keeps all messages in
+// memory. It leads all partitions, and it is the coordinator of all groups.
+type kafkaBroker struct {
+	listener          net.Listener
+	host              string
+	port              int32
+	defaultPartitions int32
+	onTopicCreated    func(topic string, partitions int32, configs map[string]string)
+	wg                sync.WaitGroup
+
+	mu sync.Mutex
+	// Records of topic partitions, the offset of a record is its index.
+	topics  map[string][][]record
+	offsets map[string]map[topicPartition]committedOffset
+	conns   map[net.Conn]struct{}
+	// It is closed and replaced every time records are appended, to wake
+	// up pending long polling fetch requests.
+	appendedCh chan struct{}
+	closed     bool
+}
+
+func spawnKafkaBroker(addr string, defaultPartitions int32, onTopicCreated func(string, int32, map[string]string)) (*kafkaBroker, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	b := &kafkaBroker{
+		listener:          listener,
+		host:              tcpAddr.IP.String(),
+		port:              int32(tcpAddr.Port),
+		defaultPartitions: defaultPartitions,
+		onTopicCreated:    onTopicCreated,
+		topics:            make(map[string][][]record),
+		offsets:           make(map[string]map[topicPartition]committedOffset),
+		conns:             make(map[net.Conn]struct{}),
+		appendedCh:        make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.accept()
+	}()
+	return b, nil
+}
+
+func (b *kafkaBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *kafkaBroker) stop() {
+	b.mu.Lock()
+	b.closed = true
+	b.listener.Close()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	close(b.appendedCh)
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *kafkaBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(conn)
+			b.mu.Lock()
+			delete(b.conns, conn)
+			b.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serve handles requests of a connection one by one, in the order that they
+// were received in, the same way real brokers do.
+func (b *kafkaBroker) serve(conn net.Conn) {
+	for {
+		frame, err := readFrame(conn, maxKafkaFrameSize)
+		if err != nil {
+			return
+		}
+		rq := &decoder{buf: frame}
+		apiKey := rq.int16()
+		apiVersion := rq.int16()
+		correlationID := rq.int32()
+		rq.string16() // client ID
+		if rq.err != nil {
+			return
+		}
+		if !isSupported(apiKey, apiVersion) {
+			log.Errorf("Mock broker does not support request: api_key=%d, api_version=%d", apiKey, apiVersion)
+			return
+		}
+		rs := &encoder{}
+		rs.int32(correlationID)
+		respond := b.handle(apiKey, apiVersion, rq, rs)
+		if rq.err != nil {
+			log.Errorf("Mock broker got malformed request: api_key=%d, api_version=%d", apiKey, apiVersion)
+			return
+		}
+		if !respond {
+			continue
+		}
+		if err := writeFrame(conn, rs.buf); err != nil {
+			return
+		}
+	}
+}
+
+func isSupported(apiKey, apiVersion int16) bool {
+	for _, v := range apiVersions {
+		if v.key == apiKey {
+			return v.minVersion <= apiVersion && apiVersion <= v.maxVersion
+		}
+	}
+	return false
+}
+
+// handle decodes a request body and encodes a response body. It returns
+// false if the request does not require a response.
+func (b *kafkaBroker) handle(apiKey, apiVersion int16, rq *decoder, rs *encoder) bool {
+	switch apiKey {
+	case apiKeyProduce:
+		return b.handleProduce(rq, rs)
+	case apiKeyFetch:
+		b.handleFetch(apiVersion, rq, rs)
+	case apiKeyListOffsets:
+		b.handleListOffsets(apiVersion, rq, rs)
+	case apiKeyMetadata:
+		b.handleMetadata(rq, rs)
+	case apiKeyOffsetCommit:
+		b.handleOffsetCommit(rq, rs)
+	case apiKeyOffsetFetch:
+		b.handleOffsetFetch(rq, rs)
+	case apiKeyFindCoordinator:
+		rq.string16() // group
+		rs.int16(errNone)
+		rs.int32(brokerID)
+		rs.string16(b.host)
+		rs.int32(b.port)
+	case apiKeyListGroups:
+		b.handleListGroups(rs)
+	case apiKeyAPIVersions:
+		rs.int16(errNone)
+		rs.int32(int32(len(apiVersions)))
+		for _, v := range apiVersions {
+			rs.int16(v.key)
+			rs.int16(v.minVersion)
+			rs.int16(v.maxVersion)
+		}
+	case apiKeyCreateTopics:
+		b.handleCreateTopics(apiVersion, rq, rs)
+	case apiKeyDescribeConfigs:
+		b.handleDescribeConfigs(rq, rs)
+	case apiKeyDeleteGroups:
+		b.handleDeleteGroups(rq, rs)
+	}
+	return true
+}
+
+func (b *kafkaBroker) handleProduce(rq *decoder, rs *encoder) bool {
+	rq.string16() // transactional ID
+	acks := rq.int16()
+	rq.int32() // timeout
+	topicCount := rq.arrayLen()
+	rs.int32(int32(topicCount))
+	for i := 0; i < topicCount; i++ {
+		topic := rq.string16()
+		rs.string16(topic)
+		partitionCount := rq.arrayLen()
+		rs.int32(int32(partitionCount))
+		for j := 0; j < partitionCount; j++ {
+			partition := rq.int32()
+			records, err := decodeRecordBatches(rq.bytes32())
+			kafkaErr, baseOffset := int16(errNone), int64(-1)
+			if err != nil {
+				kafkaErr = errCorruptMessage
+			} else {
+				kafkaErr, baseOffset = b.appendRecords(topic, partition, records)
+			}
+			rs.int32(partition)
+			rs.int16(kafkaErr)
+			rs.int64(baseOffset)
+			rs.int64(-1) // log append time
+		}
+	}
+	rs.int32(0) // throttle time
+	return acks != 0
+}
+
+func (b *kafkaBroker) appendRecords(topic string, partition int32, records []record) (int16, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	partitions, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(partitions) {
+		return errUnknownTopicOrPartition, -1
+	}
+	baseOffset := int64(len(partitions[partition]))
+	partitions[partition] = append(partitions[partition], records...)
+	if !b.closed {
+		close(b.appendedCh)
+		b.appendedCh = make(chan struct{})
+	}
+	return errNone, baseOffset
+}
+
+func (b *kafkaBroker) handleFetch(apiVersion int16, rq *decoder, rs *encoder) {
+	rq.int32() // replica ID
+	maxWait := time.Duration(rq.int32()) * time.Millisecond
+	minBytes := int(rq.int32())
+	rq.int32() // max bytes
+	rq.int8()  // isolation level
+	type fetchPartition struct {
+		partition int32
+		offset    int64
+		maxBytes  int32
+	}
+	type fetchTopic struct {
+		topic      string
+		partitions []fetchPartition
+	}
+	topicCount := rq.arrayLen()
+	fetchTopics := make([]fetchTopic, topicCount)
+	for i := range fetchTopics {
+		fetchTopics[i].topic = rq.string16()
+		partitionCount := rq.arrayLen()
+		fetchTopics[i].partitions = make([]fetchPartition, partitionCount)
+		for j := range fetchTopics[i].partitions {
+			fp := &fetchTopics[i].partitions[j]
+			fp.partition = rq.int32()
+			fp.offset = rq.int64()
+			if apiVersion >= 5 {
+				rq.int64() // log start offset
+			}
+			fp.maxBytes = rq.int32()
+		}
+	}
+	if rq.err != nil {
+		return
+	}
+
+	// If there is nothing to fetch, then the request is held until records
+	// are appended or max wait time elapses, like real brokers do.
+	deadline := time.Now().Add(maxWait)
+	for {
+		body := &encoder{}
+		body.int32(0) // throttle time
+		body.int32(int32(len(fetchTopics)))
+		fetchedBytes := 0
+		b.mu.Lock()
+		appendedCh, closed := b.appendedCh, b.closed
+		for _, ft := range fetchTopics {
+			body.string16(ft.topic)
+			body.int32(int32(len(ft.partitions)))
+			for _, fp := range ft.partitions {
+				kafkaErr, hwm, batch := b.readRecords(ft.topic, fp.partition, fp.offset, int(fp.maxBytes))
+				fetchedBytes += len(batch)
+				body.int32(fp.partition)
+				body.int16(kafkaErr)
+				body.int64(hwm)
+				body.int64(hwm) // last stable offset
+				if apiVersion >= 5 {
+					body.int64(0) // log start offset
+				}
+				body.int32(-1) // aborted transactions
+				body.bytes32(batch)
+			}
+		}
+		b.mu.Unlock()
+
+		wait := time.Until(deadline)
+		if fetchedBytes >= minBytes || wait <= 0 || closed {
+			rs.raw(body.buf)
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-appendedCh:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// readRecords returns an encoded record batch of records starting from the
+// specified offset that fit into maxBytes, but at least one record. It must
+// be called with the mutex held.
+func (b *kafkaBroker) readRecords(topic string, partition int32, offset int64, maxBytes int) (int16, int64, []byte) {
+	partitions, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(partitions) {
+		return errUnknownTopicOrPartition, -1, []byte{}
+	}
+	records := partitions[partition]
+	hwm := int64(len(records))
+	if offset < 0 || offset > hwm {
+		return errOffsetOutOfRange, hwm, []byte{}
+	}
+	if offset == hwm {
+		return errNone, hwm, []byte{}
+	}
+	return errNone, hwm, encodeRecordBatch(offset, records[offset:], maxBytes)
+}
+
+func (b *kafkaBroker) handleListOffsets(apiVersion int16, rq *decoder, rs *encoder) {
+	rq.int32() // replica ID
+	topicCount := rq.arrayLen()
+	rs.int32(int32(topicCount))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < topicCount; i++ {
+		topic := rq.string16()
+		rs.string16(topic)
+		partitionCount := rq.arrayLen()
+		rs.int32(int32(partitionCount))
+		for j := 0; j < partitionCount; j++ {
+			partition := rq.int32()
+			timestamp := rq.int64()
+			if apiVersion == 0 {
+				rq.int32() // max number of offsets
+			}
+			rs.int32(partition)
+			partitions, ok := b.topics[topic]
+			if !ok || partition < 0 || int(partition) >= len(partitions) {
+				rs.int16(errUnknownTopicOrPartition)
+				if apiVersion == 0 {
+					rs.int32(0)
+					continue
+				}
+				rs.int64(-1)
+				rs.int64(-1)
+				continue
+			}
+			records := partitions[partition]
+			offset := int64(len(records))
+			switch timestamp {
+			case offsetNewest:
+			case offsetOldest:
+				offset = 0
+			default:
+				// The offset of the first record with a timestamp that is
+				// not older than requested.
+				offset = int64(sort.Search(len(records), func(i int) bool {
+					return records[i].timestamp >= timestamp
+				}))
+			}
+			rs.int16(errNone)
+			if apiVersion == 0 {
+				rs.int32(1)
+				rs.int64(offset)
+				continue
+			}
+			rs.int64(-1) // timestamp
+			rs.int64(offset)
+		}
+	}
+}
+
+func (b *kafkaBroker) handleMetadata(rq *decoder, rs *encoder) {
+	topicCount := rq.arrayLen()
+	var topics []string
+	for i := 0; i < topicCount; i++ {
+		topics = append(topics, rq.string16())
+	}
+
+	rs.int32(1)
+	rs.int32(brokerID)
+	rs.string16(b.host)
+	rs.int32(b.port)
+	rs.nullableString16(nil) // rack
+	rs.int32(brokerID)       // controller ID
+
+	b.mu.Lock()
+	// A nil array requests metadata of all topics, and unknown topics are
+	// created on request, as brokers with `auto.create.topics.enable` do.
+	if topicCount < 0 {
+		for topic := range b.topics {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+	}
+	var created []string
+	partitionCounts := make([]int, len(topics))
+	for i, topic := range topics {
+		if _, ok := b.topics[topic]; !ok && isValidTopic(topic) {
+			b.topics[topic] = make([][]record, b.defaultPartitions)
+			created = append(created, topic)
+		}
+		partitionCounts[i] = len(b.topics[topic])
+	}
+	b.mu.Unlock()
+	for _, topic := range created {
+		b.onTopicCreated(topic, b.defaultPartitions, nil)
+	}
+
+	rs.int32(int32(len(topics)))
+	for i, topic := range topics {
+		if partitionCounts[i] == 0 {
+			rs.int16(errInvalidTopic)
+			rs.string16(topic)
+			rs.bool(false)
+			rs.int32(0)
+			continue
+		}
+		rs.int16(errNone)
+		rs.string16(topic)
+		rs.bool(false) // is internal
+		rs.int32(int32(partitionCounts[i]))
+		for partition := 0; partition < partitionCounts[i]; partition++ {
+			rs.int16(errNone)
+			rs.int32(int32(partition))
+			rs.int32(brokerID) // leader
+			rs.int32(1)        // replicas
+			rs.int32(brokerID)
+			rs.int32(1) // ISR
+			rs.int32(brokerID)
+		}
+	}
+}
+
+func (b *kafkaBroker) handleOffsetCommit(rq *decoder, rs *encoder) {
+	group := rq.string16()
+	rq.int32()    // generation
+	rq.string16() // member ID
+	topicCount := rq.arrayLen()
+	rs.int32(int32(topicCount))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	groupOffsets := b.offsets[group]
+	if groupOffsets == nil {
+		groupOffsets = make(map[topicPartition]committedOffset)
+		b.offsets[group] = groupOffsets
+	}
+	for i := 0; i < topicCount; i++ {
+		topic := rq.string16()
+		rs.string16(topic)
+		partitionCount := rq.arrayLen()
+		rs.int32(int32(partitionCount))
+		for j := 0; j < partitionCount; j++ {
+			partition := rq.int32()
+			offset := rq.int64()
+			rq.int64() // timestamp
+			metadata := rq.string16()
+			rs.int32(partition)
+			partitions, ok := b.topics[topic]
+			if !ok || partition < 0 || int(partition) >= len(partitions) {
+				rs.int16(errUnknownTopicOrPartition)
+				continue
+			}
+			groupOffsets[topicPartition{topic, partition}] = committedOffset{offset, metadata}
+			rs.int16(errNone)
+		}
+	}
+}
+
+func (b *kafkaBroker) handleOffsetFetch(rq *decoder, rs *encoder) {
+	group := rq.string16()
+	topicCount := rq.arrayLen()
+	rs.int32(int32(topicCount))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < topicCount; i++ {
+		topic := rq.string16()
+		rs.string16(topic)
+		partitionCount := rq.arrayLen()
+		rs.int32(int32(partitionCount))
+		for j := 0; j < partitionCount; j++ {
+			partition := rq.int32()
+			committed, ok := b.offsets[group][topicPartition{topic, partition}]
+			if !ok {
+				committed.offset = -1
+			}
+			rs.int32(partition)
+			rs.int64(committed.offset)
+			rs.string16(committed.metadata)
+			rs.int16(errNone)
+		}
+	}
+}
+
+func (b *kafkaBroker) handleListGroups(rs *encoder) {
+	b.mu.Lock()
+	groups := make([]string, 0, len(b.offsets))
+	for group := range b.offsets {
+		groups = append(groups, group)
+	}
+	b.mu.Unlock()
+	sort.Strings(groups)
+	rs.int16(errNone)
+	rs.int32(int32(len(groups)))
+	for _, group := range groups {
+		rs.string16(group)
+		rs.string16("consumer")
+	}
+}
+
+func (b *kafkaBroker) handleCreateTopics(apiVersion int16, rq *decoder, rs *encoder) {
+	type topicError struct {
+		topic string
+		err   int16
+	}
+	type createdTopic struct {
+		topic      string
+		partitions int32
+		configs    map[string]string
+	}
+	topicCount := rq.arrayLen()
+	var topicErrors []topicError
+	var created []createdTopic
+	b.mu.Lock()
+	for i := 0; i < topicCount; i++ {
+		topic := rq.string16()
+		partitions := rq.int32()
+		rq.int16() // replication factor
+		assignmentCount := rq.arrayLen()
+		for j := 0; j < assignmentCount; j++ {
+			rq.int32() // partition
+			replicaCount := rq.arrayLen()
+			for k := 0; k < replicaCount; k++ {
+				rq.int32()
+			}
+		}
+		configCount := rq.arrayLen()
+		configs := make(map[string]string, configCount)
+		for j := 0; j < configCount; j++ {
+			name := rq.string16()
+			configs[name] = rq.string16()
+		}
+		switch {
+		case !isValidTopic(topic):
+			topicErrors = append(topicErrors, topicError{topic, errInvalidTopic})
+		case b.topics[topic] != nil:
+			topicErrors = append(topicErrors, topicError{topic, errTopicAlreadyExists})
+		case partitions <= 0:
+			topicErrors = append(topicErrors, topicError{topic, errInvalidPartitions})
+		default:
+			b.topics[topic] = make([][]record, partitions)
+			topicErrors = append(topicErrors, topicError{topic, errNone})
+			created = append(created, createdTopic{topic, partitions, configs})
+		}
+	}
+	b.mu.Unlock()
+	for _, ct := range created {
+		b.onTopicCreated(ct.topic, ct.partitions, ct.configs)
+	}
+
+	rs.int32(int32(len(topicErrors)))
+	for _, te := range topicErrors {
+		rs.string16(te.topic)
+		rs.int16(te.err)
+		if apiVersion >= 1 {
+			rs.nullableString16(nil)
+		}
+	}
+}
+
+func (b *kafkaBroker) handleDescribeConfigs(rq *decoder, rs *encoder) {
+	rs.int32(0) // throttle time
+	resourceCount := rq.arrayLen()
+	rs.int32(int32(resourceCount))
+	for i := 0; i < resourceCount; i++ {
+		resourceType := rq.int8()
+		name := rq.string16()
+		nameCount := rq.arrayLen()
+		for j := 0; j < nameCount; j++ {
+			rq.string16()
+		}
+		b.mu.Lock()
+		_, ok := b.topics[name]
+		b.mu.Unlock()
+		if !ok {
+			rs.int16(errUnknownTopicOrPartition)
+			rs.string16("")
+			rs.int8(resourceType)
+			rs.string16(name)
+			rs.int32(0)
+			continue
+		}
+		rs.int16(errNone)
+		rs.string16("")
+		rs.int8(resourceType)
+		rs.string16(name)
+		rs.int32(int32(len(topicConfigs)))
+		for _, tc := range topicConfigs {
+			rs.string16(tc.name)
+			rs.string16(tc.value)
+			rs.bool(false) // read only
+			rs.bool(true)  // default
+			rs.bool(false) // sensitive
+		}
+	}
+}
+
+func (b *kafkaBroker) handleDeleteGroups(rq *decoder, rs *encoder) {
+	rs.int32(0) // throttle time
+	groupCount := rq.arrayLen()
+	rs.int32(int32(groupCount))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < groupCount; i++ {
+		group := rq.string16()
+		rs.string16(group)
+		if _, ok := b.offsets[group]; !ok {
+			rs.int16(errGroupIDNotFound)
+			continue
+		}
+		delete(b.offsets, group)
+		rs.int16(errNone)
+	}
+}
+
+func isValidTopic(topic string) bool {
+	if topic == "" || topic == "." || topic == ".." || len(topic) > 249 {
+		return false
+	}
+	for _, c := range topic {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRecordBatches decodes records of v2 record batches, that Kafka 0.11+
+// uses for both produce and fetch requests.
+func decodeRecordBatches(buf []byte) ([]record, error) {
+	var records []record
+	d := &decoder{buf: buf}
+	for len(d.buf) > 0 && d.err == nil {
+		d.int64() // base offset
+		batch := &decoder{buf: d.next(int(d.int32()))}
+		batch.int32() // partition leader epoch
+		if magic := batch.int8(); batch.err == nil && magic != 2 {
+			return nil, errors.Errorf("unsupported magic: %d", magic)
+		}
+		crc := uint32(batch.int32())
+		if batch.err == nil && crc != crc32.Checksum(batch.buf, castagnoli) {
+			return nil, errors.New("bad CRC")
+		}
+		attributes := batch.int16()
+		batch.int32() // last offset delta
+		firstTimestamp := batch.int64()
+		batch.int64() // max timestamp
+		batch.int64() // producer ID
+		batch.int16() // producer epoch
+		batch.int32() // base sequence
+		recordCount := batch.arrayLen()
+		switch attributes & compressionMask {
+		case 0:
+		case compressionGZIP:
+			r, err := gzip.NewReader(bytes.NewReader(batch.buf))
+			if err != nil {
+				return nil, errors.Wrap(err, "bad gzip")
+			}
+			if batch.buf, err = ioutil.ReadAll(r); err != nil {
+				return nil, errors.Wrap(err, "bad gzip")
+			}
+		default:
+			return nil, errors.Errorf("unsupported compression: %d", attributes&compressionMask)
+		}
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		for i := 0; i < recordCount && batch.err == nil; i++ {
+			r := &decoder{buf: batch.next(int(batch.varint()))}
+			r.int8() // attributes
+			var rec record
+			rec.timestamp = firstTimestamp + r.varint()
+			if firstTimestamp <= 0 {
+				rec.timestamp = now
+			}
+			r.varint() // offset delta
+			rec.key = r.varintBytes()
+			rec.value = r.varintBytes()
+			headerCount := int(r.varint())
+			for j := 0; j < headerCount && r.err == nil; j++ {
+				rec.headers = append(rec.headers, recordHeader{key: r.varintBytes(), value: r.varintBytes()})
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			records = append(records, rec)
+		}
+		if batch.err != nil {
+			return nil, batch.err
+		}
+	}
+	return records, d.err
+}
+
+// encodeRecordBatch encodes records into a v2 record batch. Records are added
+// while the batch fits into maxBytes, but there is always at least one.
+func encodeRecordBatch(baseOffset int64, records []record, maxBytes int) []byte {
+	var encodedRecords encoder
+	count := 0
+	firstTimestamp := records[0].timestamp
+	maxTimestamp := firstTimestamp
+	for i, rec := range records {
+		var r encoder
+		r.int8(0) // attributes
+		r.varint(rec.timestamp - firstTimestamp)
+		r.varint(int64(i))
+		r.varintBytes(rec.key)
+		r.varintBytes(rec.value)
+		r.varint(int64(len(rec.headers)))
+		for _, h := range rec.headers {
+			r.varintBytes(h.key)
+			r.varintBytes(h.value)
+		}
+		if count > 0 && len(encodedRecords.buf)+len(r.buf)+binary.MaxVarintLen32+recordBatchOverhead > maxBytes {
+			break
+		}
+		encodedRecords.varint(int64(len(r.buf)))
+		encodedRecords.raw(r.buf)
+		count++
+		if rec.timestamp > maxTimestamp {
+			maxTimestamp = rec.timestamp
+		}
+	}
+
+	// The CRC covers everything from attributes to the end of the batch.
+	var crcCovered encoder
+	crcCovered.int16(0) // attributes
+	crcCovered.int32(int32(count - 1))
+	crcCovered.int64(firstTimestamp)
+	crcCovered.int64(maxTimestamp)
+	crcCovered.int64(-1) // producer ID
+	crcCovered.int16(-1) // producer epoch
+	crcCovered.int32(-1) // base sequence
+	crcCovered.int32(int32(count))
+	crcCovered.raw(encodedRecords.buf)
+
+	var batch encoder
+	batch.int64(baseOffset)
+	batch.int32(int32(4 + 1 + 4 + len(crcCovered.buf)))
+	batch.int32(0) // partition leader epoch
+	batch.int8(2)  // magic
+	batch.int32(int32(crc32.Checksum(crcCovered.buf, castagnoli)))
+	batch.raw(crcCovered.buf)
+	return batch.buf
+}
+
+// partitionCount returns the number of partitions of a topic, zero if there
+// is no such topic.
+func (b *kafkaBroker) partitionCount(topic string) int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int32(len(b.topics[topic]))
+}
+
+// createTopic creates a topic, unless it already exists.
+func (b *kafkaBroker) createTopic(topic string, partitions int32) error {
+	if !isValidTopic(topic) {
+		return errors.Errorf("invalid topic: %q", topic)
+	}
+	if partitions <= 0 {
+		return errors.Errorf("invalid number of partitions: %d", partitions)
+	}
+	b.mu.Lock()
+	if b.topics[topic] != nil {
+		b.mu.Unlock()
+		return nil
+	}
+	b.topics[topic] = make([][]record, partitions)
+	b.mu.Unlock()
+	b.onTopicCreated(topic, partitions, nil)
+	return nil
+}
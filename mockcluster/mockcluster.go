@@ -0,0 +1,125 @@
+// Package mockcluster implements an in-memory simulation of a Kafka cluster
+// along with a ZooKeeper ensemble, that speaks just enough of their wire
+// protocols for Kafka-Pixy to serve its full API against it. It is what the
+// `--mock` mode runs on, and it is meant for local development and testing
+// of applications that use Kafka-Pixy, when running real Kafka and ZooKeeper
+// is too much hassle.
+//
+// The simulated cluster is a single broker that leads all partitions and
+// coordinates all consumer groups. Topics are created on first use with the
+// configured number of partitions, the way brokers with
+// `auto.create.topics.enable` do. Nothing is persisted, there is neither
+// replication nor retention, and only the requests and versions that
+// Kafka-Pixy uses are supported.
+package mockcluster
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPartitions is the number of partitions that topics are created
+	// with if Config.Partitions is not set.
+	DefaultPartitions = 4
+)
+
+// Config defines a mock cluster.
+type Config struct {
+	// Addresses to listen on. Random ports of the loopback interface are
+	// used if not set.
+	KafkaAddr     string
+	ZooKeeperAddr string
+
+	// The number of partitions that topics are created with on first use.
+	Partitions int32
+
+	// Topics to create on start, mapped to their numbers of partitions.
+	Topics map[string]int32
+}
+
+// T is a running mock cluster.
+type T struct {
+	broker *kafkaBroker
+	zk     *zkServer
+}
+
+// Spawn starts a mock cluster.
+func Spawn(cfg Config) (*T, error) {
+	if cfg.KafkaAddr == "" {
+		cfg.KafkaAddr = "127.0.0.1:0"
+	}
+	if cfg.ZooKeeperAddr == "" {
+		cfg.ZooKeeperAddr = "127.0.0.1:0"
+	}
+	if cfg.Partitions <= 0 {
+		cfg.Partitions = DefaultPartitions
+	}
+	zk, err := spawnZKServer(cfg.ZooKeeperAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start ZooKeeper")
+	}
+	// Brokers keep topic configurations in ZooKeeper, and Kafka-Pixy reads
+	// them from there.
+	onTopicCreated := func(topic string, partitions int32, configs map[string]string) {
+		if configs == nil {
+			configs = map[string]string{}
+		}
+		data, _ := json.Marshal(struct {
+			Version int               `json:"version"`
+			Config  map[string]string `json:"config"`
+		}{1, configs})
+		zk.ensurePath(fmt.Sprintf("/config/topics/%s", topic), data)
+	}
+	broker, err := spawnKafkaBroker(cfg.KafkaAddr, cfg.Partitions, onTopicCreated)
+	if err != nil {
+		zk.stop()
+		return nil, errors.Wrap(err, "failed to start Kafka")
+	}
+	mc := &T{broker: broker, zk: zk}
+	for topic, partitions := range cfg.Topics {
+		if err := broker.createTopic(topic, partitions); err != nil {
+			mc.Stop()
+			return nil, err
+		}
+	}
+	return mc, nil
+}
+
+// KafkaAddr returns the address of the mock Kafka broker.
+func (mc *T) KafkaAddr() string {
+	return mc.broker.addr()
+}
+
+// ZooKeeperAddr returns the address of the mock ZooKeeper node.
+func (mc *T) ZooKeeperAddr() string {
+	return mc.zk.addr()
+}
+
+// Configure makes a proxy use the mock cluster. Along with peer addresses it
+// overrides settings that the mock cluster does not support: the Kafka
+// version is 0.11.0.0, and only gzip compression is supported. Missing topics
+// are created on produce with as many partitions as on consume.
+func (mc *T) Configure(proxyCfg *config.Proxy) {
+	proxyCfg.Kafka.SeedPeers = []string{mc.KafkaAddr()}
+	proxyCfg.Kafka.Version.Set(sarama.V0_11_0_0)
+	proxyCfg.Kafka.NegotiateVersion = false
+	proxyCfg.ZooKeeper.SeedPeers = []string{mc.ZooKeeperAddr()}
+	proxyCfg.ZooKeeper.Chroot = ""
+	proxyCfg.ZooKeeper.Secondary.SeedPeers = nil
+	proxyCfg.Producer.CreateMissingTopics = true
+	proxyCfg.Producer.NewTopicPartitions = mc.broker.defaultPartitions
+	if proxyCfg.Producer.Compression != config.Compression(sarama.CompressionGZIP) {
+		proxyCfg.Producer.Compression = config.Compression(sarama.CompressionNone)
+	}
+}
+
+// Stop shuts the mock cluster down, all its data is lost.
+func (mc *T) Stop() {
+	mc.broker.stop()
+	mc.zk.stop()
+}
@@ -0,0 +1,342 @@
+package mockcluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/client"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/service"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/samuel/go-zookeeper/zk"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MockClusterSuite struct {
+	mc *T
+}
+
+var _ = Suite(&MockClusterSuite{})
+
+func (s *MockClusterSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *MockClusterSuite) SetUpTest(c *C) {
+	var err error
+	s.mc, err = Spawn(Config{Topics: map[string]int32{"test.1": 1}})
+	c.Assert(err, IsNil)
+}
+
+func (s *MockClusterSuite) TearDownTest(c *C) {
+	s.mc.Stop()
+}
+
+func (s *MockClusterSuite) saramaCfg() *sarama.Config {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = sarama.V0_11_0_0
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Consumer.MaxWaitTime = 100 * time.Millisecond
+	return saramaCfg
+}
+
+// Messages produced by sarama are consumed by sarama intact, and topics that
+// do not exist are created on first use.
+func (s *MockClusterSuite) TestProduceConsume(c *C) {
+	for i, tc := range []struct {
+		topic       string
+		compression sarama.CompressionCodec
+		partitions  int
+	}{
+		{topic: "test.1", compression: sarama.CompressionNone, partitions: 1},
+		{topic: "foo", compression: sarama.CompressionNone, partitions: DefaultPartitions},
+		{topic: "bar", compression: sarama.CompressionGZIP, partitions: DefaultPartitions},
+	} {
+		saramaCfg := s.saramaCfg()
+		saramaCfg.Producer.Compression = tc.compression
+		kafkaClt, err := sarama.NewClient([]string{s.mc.KafkaAddr()}, saramaCfg)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		producer, err := sarama.NewSyncProducerFromClient(kafkaClt)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		headers := []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}}
+		var msgs []*sarama.ProducerMessage
+		for j := 0; j < 3; j++ {
+			msgs = append(msgs, &sarama.ProducerMessage{
+				Topic:   tc.topic,
+				Key:     sarama.StringEncoder("k"),
+				Value:   sarama.StringEncoder(fmt.Sprintf("m%d", j)),
+				Headers: headers,
+			})
+		}
+		c.Assert(producer.SendMessages(msgs), IsNil, Commentf("case #%d", i))
+
+		// When
+		consumer, err := sarama.NewConsumerFromClient(kafkaClt)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		pc, err := consumer.ConsumePartition(tc.topic, msgs[0].Partition, msgs[0].Offset)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+
+		// Then
+		for j, produced := range msgs {
+			consumed := <-pc.Messages()
+			c.Check(consumed.Offset, Equals, produced.Offset, Commentf("case #%d, msg #%d", i, j))
+			c.Check(string(consumed.Key), Equals, "k", Commentf("case #%d, msg #%d", i, j))
+			c.Check(string(consumed.Value), Equals, fmt.Sprintf("m%d", j), Commentf("case #%d, msg #%d", i, j))
+			c.Check(consumed.Headers, DeepEquals, []*sarama.RecordHeader{&headers[0]}, Commentf("case #%d, msg #%d", i, j))
+		}
+		partitions, err := kafkaClt.Partitions(tc.topic)
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(partitions, HasLen, tc.partitions, Commentf("case #%d", i))
+		pc.Close()
+		consumer.Close()
+		producer.Close()
+	}
+}
+
+// Fetch requests wait for messages if there are none, and get them as soon
+// as they are produced.
+func (s *MockClusterSuite) TestLongPolling(c *C) {
+	saramaCfg := s.saramaCfg()
+	saramaCfg.Consumer.MaxWaitTime = 3 * time.Second
+	consumer, err := sarama.NewConsumer([]string{s.mc.KafkaAddr()}, saramaCfg)
+	c.Assert(err, IsNil)
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition("test.1", 0, sarama.OffsetNewest)
+	c.Assert(err, IsNil)
+	defer pc.Close()
+	producer, err := sarama.NewSyncProducer([]string{s.mc.KafkaAddr()}, s.saramaCfg())
+	c.Assert(err, IsNil)
+	defer producer.Close()
+	time.Sleep(100 * time.Millisecond)
+	begin := time.Now()
+
+	// When
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: "test.1", Value: sarama.StringEncoder("foo")})
+	c.Assert(err, IsNil)
+
+	// Then
+	msg := <-pc.Messages()
+	c.Check(string(msg.Value), Equals, "foo")
+	c.Check(time.Since(begin) < time.Second, Equals, true)
+}
+
+func (s *MockClusterSuite) TestListOffsets(c *C) {
+	producer, err := sarama.NewSyncProducer([]string{s.mc.KafkaAddr()}, s.saramaCfg())
+	c.Assert(err, IsNil)
+	defer producer.Close()
+	for i := 0; i < 3; i++ {
+		_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: "test.1", Value: sarama.StringEncoder("foo")})
+		c.Assert(err, IsNil)
+	}
+	kafkaClt, err := sarama.NewClient([]string{s.mc.KafkaAddr()}, s.saramaCfg())
+	c.Assert(err, IsNil)
+	defer kafkaClt.Close()
+
+	for i, tc := range []struct {
+		time   int64
+		offset int64
+	}{
+		{time: sarama.OffsetOldest, offset: 0},
+		{time: sarama.OffsetNewest, offset: 3},
+		{time: 1, offset: 0},
+		{time: time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond), offset: 3},
+	} {
+		// When
+		offset, err := kafkaClt.GetOffset("test.1", 0, tc.time)
+
+		// Then
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(offset, Equals, tc.offset, Commentf("case #%d", i))
+	}
+}
+
+func (s *MockClusterSuite) TestOffsetCommit(c *C) {
+	kafkaClt, err := sarama.NewClient([]string{s.mc.KafkaAddr()}, s.saramaCfg())
+	c.Assert(err, IsNil)
+	defer kafkaClt.Close()
+	coordinator, err := kafkaClt.Coordinator("g1")
+	c.Assert(err, IsNil)
+	commitRq := &sarama.OffsetCommitRequest{Version: 1, ConsumerGroup: "g1", ConsumerGroupGeneration: -1}
+	commitRq.AddBlock("test.1", 0, 42, sarama.ReceiveTime, "bazz")
+
+	// When
+	commitRs, err := coordinator.CommitOffset(commitRq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(commitRs.Errors["test.1"][0], Equals, sarama.ErrNoError)
+	fetchRq := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: "g1"}
+	fetchRq.AddPartition("test.1", 0)
+	fetchRs, err := coordinator.FetchOffset(fetchRq)
+	c.Assert(err, IsNil)
+	block := fetchRs.GetBlock("test.1", 0)
+	c.Check(block.Offset, Equals, int64(42))
+	c.Check(block.Metadata, Equals, "bazz")
+}
+
+// Topics created via the admin API get configurations both in brokers and
+// in ZooKeeper.
+func (s *MockClusterSuite) TestCreateTopic(c *C) {
+	clusterAdmin, err := sarama.NewClusterAdmin([]string{s.mc.KafkaAddr()}, s.saramaCfg())
+	c.Assert(err, IsNil)
+	defer clusterAdmin.Close()
+
+	// When
+	err = clusterAdmin.CreateTopic("foo", &sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 1}, false)
+
+	// Then
+	c.Assert(err, IsNil)
+	err = clusterAdmin.CreateTopic("foo", &sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 1}, false)
+	c.Check(err, ErrorMatches, ".*Topic with this name already exists.*")
+	entries, err := clusterAdmin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Check(len(entries), Equals, len(topicConfigs))
+	c.Check(s.mc.broker.partitionCount("foo"), Equals, int32(3))
+
+	zkConn, _, err := zk.Connect([]string{s.mc.ZooKeeperAddr()}, 5*time.Second)
+	c.Assert(err, IsNil)
+	defer zkConn.Close()
+	data, _, err := zkConn.Get("/config/topics/foo")
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"version":1,"config":{}}`)
+}
+
+// Ephemeral nodes are deleted when their session ends, and watches of other
+// sessions fire.
+func (s *MockClusterSuite) TestZooKeeperEphemeral(c *C) {
+	zkConn1, _, err := zk.Connect([]string{s.mc.ZooKeeperAddr()}, 5*time.Second)
+	c.Assert(err, IsNil)
+	zkConn2, _, err := zk.Connect([]string{s.mc.ZooKeeperAddr()}, 5*time.Second)
+	c.Assert(err, IsNil)
+	defer zkConn2.Close()
+	_, err = zkConn1.Create("/foo", nil, 0, zk.WorldACL(zk.PermAll))
+	c.Assert(err, IsNil)
+	path, err := zkConn1.CreateProtectedEphemeralSequential("/foo/bar", []byte("bazz"), zk.WorldACL(zk.PermAll))
+	c.Assert(err, IsNil)
+	children, _, childrenCh, err := zkConn2.ChildrenW("/foo")
+	c.Assert(err, IsNil)
+	c.Check(children, HasLen, 1)
+	data, _, err := zkConn2.Get(path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "bazz")
+
+	// When
+	zkConn1.Close()
+
+	// Then
+	select {
+	case event := <-childrenCh:
+		c.Check(event.Type, Equals, zk.EventNodeChildrenChanged)
+		c.Check(event.Path, Equals, "/foo")
+	case <-time.After(3 * time.Second):
+		c.Fatal("watch did not fire")
+	}
+	children, _, err = zkConn2.Children("/foo")
+	c.Assert(err, IsNil)
+	c.Check(children, HasLen, 0)
+}
+
+func (s *MockClusterSuite) TestZooKeeperErrors(c *C) {
+	zkConn, _, err := zk.Connect([]string{s.mc.ZooKeeperAddr()}, 5*time.Second)
+	c.Assert(err, IsNil)
+	defer zkConn.Close()
+	_, err = zkConn.Create("/foo", []byte("bar"), 0, zk.WorldACL(zk.PermAll))
+	c.Assert(err, IsNil)
+	_, err = zkConn.Create("/foo/bar", nil, 0, zk.WorldACL(zk.PermAll))
+	c.Assert(err, IsNil)
+
+	_, err = zkConn.Create("/foo", nil, 0, zk.WorldACL(zk.PermAll))
+	c.Check(err, Equals, zk.ErrNodeExists)
+	_, err = zkConn.Create("/bazz/bar", nil, 0, zk.WorldACL(zk.PermAll))
+	c.Check(err, Equals, zk.ErrNoNode)
+	_, err = zkConn.Set("/foo", []byte("bazz"), 1)
+	c.Check(err, Equals, zk.ErrBadVersion)
+	c.Check(zkConn.Delete("/foo", -1), Equals, zk.ErrNotEmpty)
+	exists, _, err := zkConn.Exists("/bazz")
+	c.Check(err, IsNil)
+	c.Check(exists, Equals, false)
+}
+
+// Kafka-Pixy serves its API against a mock cluster.
+func (s *MockClusterSuite) TestService(c *C) {
+	cfg := &config.App{Proxies: make(map[string]*config.Proxy)}
+	cfg.GRPCAddr = "127.0.0.1:19091"
+	proxyCfg := testhelpers.NewTestProxyCfg("mockcluster_test")
+	proxyCfg.Consumer.LongPollingTimeout = 500 * time.Millisecond
+	s.mc.Configure(proxyCfg)
+	cfg.Proxies["pxyM"] = proxyCfg
+	cfg.DefaultCluster = "pxyM"
+	svc, err := service.Spawn(cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	clt, err := client.Dial(cfg.GRPCAddr)
+	c.Assert(err, IsNil)
+	defer clt.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The first consume request creates the topic and makes the group start
+	// consuming from the end of it.
+	consCtx, consCancel := context.WithTimeout(ctx, time.Second)
+	err = clt.Consume(consCtx, "g1", "foo", func(ctx context.Context, msg *client.Message) error { return nil })
+	consCancel()
+	c.Assert(err, IsNil)
+	_, offset, err := clt.Produce(ctx, "foo", []byte("bar"), []byte("bazz"))
+	c.Assert(err, IsNil)
+
+	// When
+	var consumed []*client.Message
+	consCtx, consCancel = context.WithCancel(ctx)
+	err = clt.Consume(consCtx, "g1", "foo", func(ctx context.Context, msg *client.Message) error {
+		consumed = append(consumed, msg)
+		consCancel()
+		return nil
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(consumed, HasLen, 1)
+	c.Check(string(consumed[0].Value), Equals, "bazz")
+	c.Check(consumed[0].Offset, Equals, offset)
+}
+
+// Topics created on produce get the partition count and the config overrides
+// from `topic_defaults`.
+func (s *MockClusterSuite) TestServiceTopicDefaults(c *C) {
+	cfg := &config.App{Proxies: make(map[string]*config.Proxy)}
+	cfg.GRPCAddr = "127.0.0.1:19091"
+	proxyCfg := testhelpers.NewTestProxyCfg("mockcluster_test")
+	s.mc.Configure(proxyCfg)
+	proxyCfg.TopicDefaults.Partitions = 5
+	proxyCfg.TopicDefaults.Config = map[string]string{"retention.ms": "86400000"}
+	cfg.Proxies["pxyM"] = proxyCfg
+	cfg.DefaultCluster = "pxyM"
+	svc, err := service.Spawn(cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	clt, err := client.Dial(cfg.GRPCAddr)
+	c.Assert(err, IsNil)
+	defer clt.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// When
+	_, _, err = clt.Produce(ctx, "foo", []byte("bar"), []byte("bazz"))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(s.mc.broker.partitionCount("foo"), Equals, int32(5))
+	zkConn, _, err := zk.Connect([]string{s.mc.ZooKeeperAddr()}, 5*time.Second)
+	c.Assert(err, IsNil)
+	defer zkConn.Close()
+	data, _, err := zkConn.Get("/config/topics/foo")
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"version":1,"config":{"retention.ms":"86400000"}}`)
+}
@@ -0,0 +1,230 @@
+package mockcluster
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var errMalformed = errors.New("malformed packet")
+
+// decoder reads big-endian primitives that both Kafka and ZooKeeper protocols
+// are built of. The first error sticks, and all reads after it return zero
+// values, so that a packet can be decoded without checking every read.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string16 reads a string with an int16 length, as Kafka encodes them.
+func (d *decoder) string16() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// string32 reads a string with an int32 length, as ZooKeeper encodes them.
+func (d *decoder) string32() string {
+	return string(d.bytes32())
+}
+
+// bytes32 reads bytes with an int32 length, -1 stands for nil.
+func (d *decoder) bytes32() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// varint reads a zigzag encoded variable length integer, as Kafka record
+// batches encode them.
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// varintBytes reads bytes with a varint length, -1 stands for nil.
+func (d *decoder) varintBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// arrayLen reads the length of an array, -1 stands for a nil array.
+func (d *decoder) arrayLen() int {
+	n := int(d.int32())
+	if n > len(d.buf) {
+		d.err = errMalformed
+		return 0
+	}
+	return n
+}
+
+// encoder writes big-endian primitives.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+		return
+	}
+	e.int8(0)
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) string16(v string) {
+	e.int16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// nullableString16 writes a nil string as -1 length.
+func (e *encoder) nullableString16(v *string) {
+	if v == nil {
+		e.int16(-1)
+		return
+	}
+	e.string16(*v)
+}
+
+func (e *encoder) string32(v string) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) bytes32(v []byte) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.buf = append(e.buf, buf[:n]...)
+}
+
+func (e *encoder) varintBytes(v []byte) {
+	if v == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) raw(v []byte) {
+	e.buf = append(e.buf, v...)
+}
+
+// readFrame reads a packet prefixed with its int32 length.
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+		return nil, err
+	}
+	size := int(int32(binary.BigEndian.Uint32(sizeBuf[:])))
+	if size < 0 || size > maxSize {
+		return nil, errors.Errorf("bad frame size: %d", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// writeFrame writes a packet prefixed with its int32 length.
+func writeFrame(w io.Writer, packet []byte) error {
+	frame := make([]byte, 4, 4+len(packet))
+	binary.BigEndian.PutUint32(frame, uint32(len(packet)))
+	_, err := w.Write(append(frame, packet...))
+	return err
+}
@@ -0,0 +1,529 @@
+package mockcluster
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxZKFrameSize = 16 * 1024 * 1024
+
+	zkOpCreate       = 1
+	zkOpDelete       = 2
+	zkOpExists       = 3
+	zkOpGetData      = 4
+	zkOpSetData      = 5
+	zkOpGetChildren  = 8
+	zkOpSync         = 9
+	zkOpPing         = 11
+	zkOpGetChildren2 = 12
+	zkOpClose        = -11
+	zkOpSetWatches   = 101
+
+	zkErrOK                      = 0
+	zkErrUnimplemented           = -6
+	zkErrBadArguments            = -8
+	zkErrNoNode                  = -101
+	zkErrBadVersion              = -103
+	zkErrNoChildrenForEphemerals = -108
+	zkErrNodeExists              = -110
+	zkErrNotEmpty                = -111
+
+	zkFlagEphemeral = 1
+	zkFlagSequence  = 2
+
+	zkEventNodeCreated         = 1
+	zkEventNodeDeleted         = 2
+	zkEventNodeDataChanged     = 3
+	zkEventNodeChildrenChanged = 4
+
+	zkStateSyncConnected = 3
+
+	zkXidWatchEvent = -1
+)
+
+type zkNode struct {
+	data           []byte
+	children       map[string]struct{}
+	czxid          int64
+	mzxid          int64
+	pzxid          int64
+	ctime          int64
+	mtime          int64
+	version        int32
+	cversion       int32
+	ephemeralOwner int64
+}
+
+type zkWatchEvent struct {
+	session   *zkSession
+	eventType int32
+	path      string
+}
+
+type zkSession struct {
+	id      int64
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+func (s *zkSession) write(packet []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writeFrame(s.conn, packet)
+}
+
+// zkServer is a single node ZooKeeper ensemble that keeps all nodes in
+// memory. Sessions end when their connections are closed, then ephemeral
+// nodes that they own are deleted.
+type zkServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu            sync.Mutex
+	nodes         map[string]*zkNode
+	zxid          int64
+	lastSessionID int64
+	sessions      map[int64]*zkSession
+	// Watches fire once and are removed. Data watches are set by exists and
+	// get data requests, child watches are set by get children requests.
+	dataWatches  map[string]map[*zkSession]struct{}
+	childWatches map[string]map[*zkSession]struct{}
+	closed       bool
+}
+
+func spawnZKServer(addr string) (*zkServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+	s := &zkServer{
+		listener:      listener,
+		nodes:         map[string]*zkNode{"/": {children: make(map[string]struct{})}},
+		lastSessionID: time.Now().UnixNano(),
+		sessions:      make(map[int64]*zkSession),
+		dataWatches:   make(map[string]map[*zkSession]struct{}),
+		childWatches:  make(map[string]map[*zkSession]struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.accept()
+	}()
+	return s, nil
+}
+
+func (s *zkServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *zkServer) stop() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for _, session := range s.sessions {
+		session.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *zkServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			session := s.connect(conn)
+			if session == nil {
+				return
+			}
+			s.serve(session)
+			s.disconnect(session)
+		}()
+	}
+}
+
+// connect handles a connect request, that starts a new session. Sessions do
+// not outlive their connections, so clients that reconnect to resume a
+// session are told that it expired.
+func (s *zkServer) connect(conn net.Conn) *zkSession {
+	frame, err := readFrame(conn, maxZKFrameSize)
+	if err != nil {
+		return nil
+	}
+	rq := &decoder{buf: frame}
+	rq.int32() // protocol version
+	rq.int64() // last zxid seen
+	timeout := rq.int32()
+	sessionID := rq.int64()
+	if rq.err != nil {
+		return nil
+	}
+	var session *zkSession
+	s.mu.Lock()
+	if sessionID == 0 && !s.closed {
+		s.lastSessionID++
+		session = &zkSession{id: s.lastSessionID, conn: conn}
+		s.sessions[session.id] = session
+	}
+	s.mu.Unlock()
+
+	rs := &encoder{}
+	rs.int32(0) // protocol version
+	rs.int32(timeout)
+	if session == nil {
+		rs.int64(0)
+		rs.bytes32(make([]byte, 16))
+		writeFrame(conn, rs.buf)
+		return nil
+	}
+	rs.int64(session.id)
+	rs.bytes32(make([]byte, 16)) // password
+	if err := session.write(rs.buf); err != nil {
+		s.disconnect(session)
+		return nil
+	}
+	return session
+}
+
+func (s *zkServer) serve(session *zkSession) {
+	for {
+		frame, err := readFrame(session.conn, maxZKFrameSize)
+		if err != nil {
+			return
+		}
+		rq := &decoder{buf: frame}
+		xid := rq.int32()
+		opcode := rq.int32()
+		if rq.err != nil {
+			return
+		}
+		body := &encoder{}
+		s.mu.Lock()
+		zkErr, events := s.handle(session, opcode, rq, body)
+		zxid := s.zxid
+		s.mu.Unlock()
+		if rq.err != nil {
+			zkErr, body.buf = zkErrBadArguments, nil
+		}
+		rs := &encoder{}
+		rs.int32(xid)
+		rs.int64(zxid)
+		rs.int32(zkErr)
+		if zkErr == zkErrOK {
+			rs.raw(body.buf)
+		}
+		if err := session.write(rs.buf); err != nil {
+			return
+		}
+		s.notify(events)
+		if opcode == zkOpClose {
+			return
+		}
+	}
+}
+
+func (s *zkServer) disconnect(session *zkSession) {
+	s.mu.Lock()
+	delete(s.sessions, session.id)
+	for _, watches := range []map[string]map[*zkSession]struct{}{s.dataWatches, s.childWatches} {
+		for p, sessions := range watches {
+			delete(sessions, session)
+			if len(sessions) == 0 {
+				delete(watches, p)
+			}
+		}
+	}
+	var ephemerals []string
+	for p, node := range s.nodes {
+		if node.ephemeralOwner == session.id {
+			ephemerals = append(ephemerals, p)
+		}
+	}
+	var events []zkWatchEvent
+	for _, p := range ephemerals {
+		events = append(events, s.deleteNode(p)...)
+	}
+	s.mu.Unlock()
+	s.notify(events)
+}
+
+// handle decodes a request body, applies it and encodes a response body. It
+// returns a ZooKeeper error code along with watch events triggered by the
+// request. It must be called with the mutex held.
+func (s *zkServer) handle(session *zkSession, opcode int32, rq *decoder, rs *encoder) (int32, []zkWatchEvent) {
+	switch opcode {
+	case zkOpPing, zkOpClose:
+		return zkErrOK, nil
+
+	case zkOpCreate:
+		p := rq.string32()
+		data := rq.bytes32()
+		aclCount := rq.arrayLen()
+		for i := 0; i < aclCount; i++ {
+			rq.int32()    // perms
+			rq.string32() // scheme
+			rq.string32() // ID
+		}
+		flags := rq.int32()
+		if rq.err != nil || !isValidPath(p) || p == "/" {
+			return zkErrBadArguments, nil
+		}
+		var owner int64
+		if flags&zkFlagEphemeral != 0 {
+			owner = session.id
+		}
+		p, zkErr, events := s.createNode(p, data, owner, flags&zkFlagSequence != 0)
+		if zkErr == zkErrOK {
+			rs.string32(p)
+		}
+		return zkErr, events
+
+	case zkOpDelete:
+		p := rq.string32()
+		version := rq.int32()
+		node := s.nodes[p]
+		if node == nil {
+			return zkErrNoNode, nil
+		}
+		if version != -1 && version != node.version {
+			return zkErrBadVersion, nil
+		}
+		if len(node.children) > 0 {
+			return zkErrNotEmpty, nil
+		}
+		return zkErrOK, s.deleteNode(p)
+
+	case zkOpExists:
+		p := rq.string32()
+		watch := rq.bool()
+		if watch {
+			s.watch(s.dataWatches, p, session)
+		}
+		node := s.nodes[p]
+		if node == nil {
+			return zkErrNoNode, nil
+		}
+		encodeStat(rs, node)
+		return zkErrOK, nil
+
+	case zkOpGetData:
+		p := rq.string32()
+		watch := rq.bool()
+		node := s.nodes[p]
+		if node == nil {
+			return zkErrNoNode, nil
+		}
+		if watch {
+			s.watch(s.dataWatches, p, session)
+		}
+		rs.bytes32(node.data)
+		encodeStat(rs, node)
+		return zkErrOK, nil
+
+	case zkOpSetData:
+		p := rq.string32()
+		data := rq.bytes32()
+		version := rq.int32()
+		node := s.nodes[p]
+		if node == nil {
+			return zkErrNoNode, nil
+		}
+		if version != -1 && version != node.version {
+			return zkErrBadVersion, nil
+		}
+		s.zxid++
+		node.data = data
+		node.version++
+		node.mzxid = s.zxid
+		node.mtime = time.Now().UnixNano() / int64(time.Millisecond)
+		encodeStat(rs, node)
+		return zkErrOK, s.fire(s.dataWatches, p, zkEventNodeDataChanged)
+
+	case zkOpGetChildren, zkOpGetChildren2:
+		p := rq.string32()
+		watch := rq.bool()
+		node := s.nodes[p]
+		if node == nil {
+			return zkErrNoNode, nil
+		}
+		if watch {
+			s.watch(s.childWatches, p, session)
+		}
+		children := make([]string, 0, len(node.children))
+		for child := range node.children {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		rs.int32(int32(len(children)))
+		for _, child := range children {
+			rs.string32(child)
+		}
+		if opcode == zkOpGetChildren2 {
+			encodeStat(rs, node)
+		}
+		return zkErrOK, nil
+
+	case zkOpSync:
+		rs.string32(rq.string32())
+		return zkErrOK, nil
+
+	case zkOpSetWatches:
+		rq.int64() // relative zxid
+		for _, watches := range []map[string]map[*zkSession]struct{}{s.dataWatches, s.dataWatches, s.childWatches} {
+			count := rq.arrayLen()
+			for i := 0; i < count; i++ {
+				s.watch(watches, rq.string32(), session)
+			}
+		}
+		return zkErrOK, nil
+	}
+	return zkErrUnimplemented, nil
+}
+
+// createNode creates a node, and returns its actual path along with watch
+// events that it triggers. It must be called with the mutex held.
+func (s *zkServer) createNode(p string, data []byte, owner int64, sequential bool) (string, int32, []zkWatchEvent) {
+	parentPath := path.Dir(p)
+	parent := s.nodes[parentPath]
+	if parent == nil {
+		return p, zkErrNoNode, nil
+	}
+	if parent.ephemeralOwner != 0 {
+		return p, zkErrNoChildrenForEphemerals, nil
+	}
+	if sequential {
+		p += fmt.Sprintf("%010d", parent.cversion)
+	}
+	if s.nodes[p] != nil {
+		return p, zkErrNodeExists, nil
+	}
+	s.zxid++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	s.nodes[p] = &zkNode{
+		data:           data,
+		children:       make(map[string]struct{}),
+		czxid:          s.zxid,
+		mzxid:          s.zxid,
+		pzxid:          s.zxid,
+		ctime:          now,
+		mtime:          now,
+		ephemeralOwner: owner,
+	}
+	parent.children[path.Base(p)] = struct{}{}
+	parent.cversion++
+	parent.pzxid = s.zxid
+	events := s.fire(s.dataWatches, p, zkEventNodeCreated)
+	return p, zkErrOK, append(events, s.fire(s.childWatches, parentPath, zkEventNodeChildrenChanged)...)
+}
+
+// deleteNode deletes a node that has no children, and returns watch events
+// that it triggers. It must be called with the mutex held.
+func (s *zkServer) deleteNode(p string) []zkWatchEvent {
+	s.zxid++
+	delete(s.nodes, p)
+	parentPath := path.Dir(p)
+	if parent := s.nodes[parentPath]; parent != nil {
+		delete(parent.children, path.Base(p))
+		parent.cversion++
+		parent.pzxid = s.zxid
+	}
+	events := s.fire(s.dataWatches, p, zkEventNodeDeleted)
+	events = append(events, s.fire(s.childWatches, p, zkEventNodeDeleted)...)
+	return append(events, s.fire(s.childWatches, parentPath, zkEventNodeChildrenChanged)...)
+}
+
+// ensurePath creates a persistent node along with all its missing parents,
+// unless it already exists.
+func (s *zkServer) ensurePath(p string, data []byte) {
+	s.mu.Lock()
+	var events []zkWatchEvent
+	for _, dir := range parentDirs(p) {
+		if s.nodes[dir] != nil {
+			continue
+		}
+		var nodeData []byte
+		if dir == p {
+			nodeData = data
+		}
+		_, _, created := s.createNode(dir, nodeData, 0, false)
+		events = append(events, created...)
+	}
+	s.mu.Unlock()
+	s.notify(events)
+}
+
+func (s *zkServer) watch(watches map[string]map[*zkSession]struct{}, p string, session *zkSession) {
+	sessions := watches[p]
+	if sessions == nil {
+		sessions = make(map[*zkSession]struct{})
+		watches[p] = sessions
+	}
+	sessions[session] = struct{}{}
+}
+
+// fire removes watches on a path and returns events that are to be sent to
+// their sessions. It must be called with the mutex held.
+func (s *zkServer) fire(watches map[string]map[*zkSession]struct{}, p string, eventType int32) []zkWatchEvent {
+	var events []zkWatchEvent
+	for session := range watches[p] {
+		events = append(events, zkWatchEvent{session: session, eventType: eventType, path: p})
+	}
+	delete(watches, p)
+	return events
+}
+
+// notify sends watch events to sessions. It must be called without the mutex
+// held, for a slow client not to block the server.
+func (s *zkServer) notify(events []zkWatchEvent) {
+	for _, event := range events {
+		rs := &encoder{}
+		rs.int32(zkXidWatchEvent)
+		rs.int64(-1) // zxid
+		rs.int32(zkErrOK)
+		rs.int32(event.eventType)
+		rs.int32(zkStateSyncConnected)
+		rs.string32(event.path)
+		event.session.write(rs.buf)
+	}
+}
+
+func encodeStat(rs *encoder, node *zkNode) {
+	rs.int64(node.czxid)
+	rs.int64(node.mzxid)
+	rs.int64(node.ctime)
+	rs.int64(node.mtime)
+	rs.int32(node.version)
+	rs.int32(node.cversion)
+	rs.int32(0) // ACL version
+	rs.int64(node.ephemeralOwner)
+	rs.int32(int32(len(node.data)))
+	rs.int32(int32(len(node.children)))
+	rs.int64(node.pzxid)
+}
+
+func isValidPath(p string) bool {
+	return strings.HasPrefix(p, "/") && (p == "/" || !strings.HasSuffix(p, "/")) && path.Clean(p) == p
+}
+
+// parentDirs returns all directories of a path starting from the top, and
+// the path itself last.
+func parentDirs(p string) []string {
+	var dirs []string
+	for dir := p; dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
@@ -0,0 +1,106 @@
+package offsetmgr
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/pkg/errors"
+)
+
+// Classes of offset commit errors, reported in commit failure metrics and
+// lifecycle events.
+const (
+	ErrClassTimeout = "timeout"
+	ErrClassKafka   = "kafka"
+	ErrClassNetwork = "network"
+	ErrClassStore   = "store"
+)
+
+var errClasses = []string{ErrClassTimeout, ErrClassKafka, ErrClassNetwork, ErrClassStore}
+
+// errorClass returns the class of an error that a commit to Kafka failed
+// with. Errors that are neither timeouts nor Kafka errors are caused by
+// broker connection problems.
+func errorClass(err error) string {
+	cause := errors.Cause(err)
+	if cause == errRequestTimeout {
+		return ErrClassTimeout
+	}
+	if _, ok := cause.(sarama.KError); ok {
+		return ErrClassKafka
+	}
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return ErrClassTimeout
+	}
+	return ErrClassNetwork
+}
+
+// failureStats counts failed commits of all offset managers spawned by a
+// factory by error class.
+type failureStats struct {
+	counts map[string]*int64
+}
+
+func newFailureStats(actDesc *actor.Descriptor) *failureStats {
+	fs := failureStats{counts: make(map[string]*int64, len(errClasses))}
+	for _, class := range errClasses {
+		count := new(int64)
+		fs.counts[class] = count
+		actDesc.ObserveGauge("commit_failures_"+class, func() int64 { return atomic.LoadInt64(count) })
+	}
+	return &fs
+}
+
+func (fs *failureStats) add(class string) {
+	atomic.AddInt64(fs.counts[class], 1)
+}
+
+// failureStreak counts consecutive failed commits of an offset manager.
+type failureStreak struct {
+	count int32
+}
+
+func (s *failureStreak) failed() int {
+	return int(atomic.AddInt32(&s.count, 1))
+}
+
+// succeeded resets the streak and returns its length.
+func (s *failureStreak) succeeded() int {
+	return int(atomic.SwapInt32(&s.count, 0))
+}
+
+func (s *failureStreak) get() int {
+	return int(atomic.LoadInt32(&s.count))
+}
+
+// commitStats counts offset commit requests sent to Kafka by broker
+// executors of a factory, and the time they take. Every request carries
+// offsets of all partitions of a group submitted since the previous one, so
+// the ratio of committed offsets to requests tells how well commits are
+// coalesced.
+type commitStats struct {
+	requests       int64
+	offsets        int64
+	lastLatencyUs  int64
+	totalLatencyUs int64
+}
+
+func newCommitStats(actDesc *actor.Descriptor) *commitStats {
+	cs := commitStats{}
+	actDesc.ObserveGauge("commit_requests", func() int64 { return atomic.LoadInt64(&cs.requests) })
+	actDesc.ObserveGauge("committed_offsets", func() int64 { return atomic.LoadInt64(&cs.offsets) })
+	actDesc.ObserveGauge("commit_latency_last_us", func() int64 { return atomic.LoadInt64(&cs.lastLatencyUs) })
+	actDesc.ObserveGauge("commit_latency_total_us", func() int64 { return atomic.LoadInt64(&cs.totalLatencyUs) })
+	return &cs
+}
+
+func (cs *commitStats) add(offsets int, latency time.Duration) {
+	latencyUs := int64(latency / time.Microsecond)
+	atomic.AddInt64(&cs.requests, 1)
+	atomic.AddInt64(&cs.offsets, int64(offsets))
+	atomic.StoreInt64(&cs.lastLatencyUs, latencyUs)
+	atomic.AddInt64(&cs.totalLatencyUs, latencyUs)
+}
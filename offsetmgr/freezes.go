@@ -0,0 +1,35 @@
+package offsetmgr
+
+import (
+	"sync"
+	"time"
+)
+
+// groupFreezes keeps the consumer groups whose commits are frozen, mapped to
+// the time their freezes end at. Commits of a frozen group are reported to
+// offset managers as successful, but are not written anywhere, so that the
+// group keeps the offsets committed before the freeze.
+type groupFreezes struct {
+	mu      sync.RWMutex
+	freezes map[string]time.Time
+}
+
+func (gf *groupFreezes) set(freezes map[string]time.Time) {
+	copied := make(map[string]time.Time, len(freezes))
+	for group, until := range freezes {
+		copied[group] = until
+	}
+	gf.mu.Lock()
+	gf.freezes = copied
+	gf.mu.Unlock()
+}
+
+func (gf *groupFreezes) isFrozen(group string) bool {
+	gf.mu.RLock()
+	until, ok := gf.freezes[group]
+	gf.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return time.Now().Before(until)
+}
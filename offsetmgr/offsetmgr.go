@@ -7,7 +7,9 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/chaos"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/lifecycle"
 	"github.com/mailgun/kafka-pixy/mapper"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/pkg/errors"
@@ -45,6 +47,12 @@ type Factory interface {
 	// stopped a new one can be started.
 	Spawn(parentActDesc *actor.Descriptor, group, topic string, partition int32) (T, error)
 
+	// SetFreezes replaces the set of consumer groups whose commits are
+	// frozen, mapped to the time their freezes end at. Offsets submitted for
+	// a frozen group are reported as committed, but are not actually
+	// committed, so the group keeps the offsets it had before the freeze.
+	SetFreezes(freezes map[string]time.Time)
+
 	// Stop waits for the spawned offset managers to stop and then terminates. Note
 	// that all spawned offset managers has to be explicitly stopped by calling
 	// their Stop method.
@@ -76,6 +84,10 @@ type T interface {
 	// It is guaranteed that the most recent offset is committed before `Stop`
 	// returns.
 	Stop()
+
+	// Failures returns the number of consecutive failed commits. It is reset
+	// to zero when an offset is committed.
+	Failures() int
 }
 
 // Offset represents an offset data as it is stored in Kafka, that is an offset
@@ -93,6 +105,8 @@ func SpawnFactory(parentActDesc *actor.Descriptor, cfg *config.Proxy, kafkaClt s
 		cfg:      cfg,
 		children: make(map[instanceID]*offsetMgr),
 	}
+	f.failureStats = newFailureStats(f.actDesc)
+	f.commitStats = newCommitStats(f.actDesc)
 	f.mapper = mapper.Spawn(f.actDesc, cfg, f)
 	return f
 }
@@ -105,6 +119,10 @@ type factory struct {
 	cfg      *config.Proxy
 	mapper   *mapper.T
 
+	failureStats *failureStats
+	commitStats  *commitStats
+	freezes      groupFreezes
+
 	childrenMu sync.Mutex
 	children   map[instanceID]*offsetMgr
 }
@@ -139,6 +157,7 @@ func (f *factory) Spawn(namespace *actor.Descriptor, group, topic string, partit
 	if testReportErrors {
 		om.testErrorsCh = make(chan error, f.cfg.Consumer.ChannelBufferSize)
 	}
+	actDesc.ObserveGauge("commit_failures", func() int64 { return int64(om.Failures()) })
 
 	om.retryTimer = time.NewTimer(0)
 	<-om.retryTimer.C
@@ -168,6 +187,8 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 		aggrActDesc:       f.actDesc.NewChild("broker", brokerConn.ID(), "aggr"),
 		execActDesc:       f.actDesc.NewChild("broker", brokerConn.ID(), "exec"),
 		cfg:               f.cfg,
+		commitStats:       f.commitStats,
+		freezes:           &f.freezes,
 		conn:              brokerConn,
 		requestsCh:        make(chan submitRq),
 		requestBatchesCh:  make(chan map[string]map[instanceID]submitRq),
@@ -180,6 +201,11 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 	return be
 }
 
+// implements `Factory`
+func (f *factory) SetFreezes(freezes map[string]time.Time) {
+	f.freezes.set(freezes)
+}
+
 // implements `Factory.Stop()`
 func (f *factory) Stop() {
 	f.mapper.Stop()
@@ -209,6 +235,7 @@ type offsetMgr struct {
 	nilOrBrokerRequestsCh chan<- submitRq
 	retryTimer            *time.Timer
 	nilOrRetryTimerCh     <-chan time.Time
+	failures              failureStreak
 	wg                    sync.WaitGroup
 
 	// To be used in tests only!
@@ -234,6 +261,11 @@ func (om *offsetMgr) Stop() {
 	om.wg.Wait()
 }
 
+// implements `T`.
+func (om *offsetMgr) Failures() int {
+	return om.failures.get()
+}
+
 // implements `mapper.Worker`.
 func (om *offsetMgr) Assignment() chan<- mapper.Executor {
 	return om.assignmentCh
@@ -345,9 +377,13 @@ handleRequests:
 			}
 		case rs := <-responseCh:
 			if err := om.getCommitError(rs); err != nil {
+				om.onCommitFailed(err)
 				om.triggerReassign(err, "Request failed")
 				continue
 			}
+			if om.failures.succeeded() > 0 {
+				om.publishCommitRecovered()
+			}
 			committedOffset = rs.offset
 			om.committedOffsetsCh <- committedOffset
 			if stopping && latestRq.offset == committedOffset {
@@ -360,6 +396,7 @@ handleRequests:
 				if submittedRq.offset == committedOffset {
 					continue
 				}
+				om.onCommitFailed(errRequestTimeout)
 				om.triggerReassign(errRequestTimeout, "Request timeout %v", sinceSubmitted)
 				continue
 			}
@@ -391,6 +428,24 @@ func (om *offsetMgr) triggerReassign(err error, format string, args ...interface
 	om.f.mapper.TriggerReassign(om)
 }
 
+// onCommitFailed counts a failed commit and publishes a lifecycle event
+// about it.
+func (om *offsetMgr) onCommitFailed(err error) {
+	class := errorClass(err)
+	om.f.failureStats.add(class)
+	ev := lifecycle.NewPartitionEvent(om.f.cfg, lifecycle.OffsetCommitFailed, om.id.group, om.id.topic, om.id.partition)
+	ev.Error = err.Error()
+	ev.ErrorClass = class
+	ev.Failures = om.failures.failed()
+	lifecycle.Publish(ev)
+}
+
+// publishCommitRecovered publishes a lifecycle event telling that an offset
+// was committed after a streak of failed commits.
+func (om *offsetMgr) publishCommitRecovered() {
+	lifecycle.Publish(lifecycle.NewPartitionEvent(om.f.cfg, lifecycle.OffsetCommitRecovered, om.id.group, om.id.topic, om.id.partition))
+}
+
 func (om *offsetMgr) fetchInitialOffset(conn *sarama.Broker) (Offset, error) {
 	request := new(sarama.OffsetFetchRequest)
 	request.Version = 1
@@ -453,6 +508,8 @@ type brokerExecutor struct {
 	aggrActDesc       *actor.Descriptor
 	execActDesc       *actor.Descriptor
 	cfg               *config.Proxy
+	commitStats       *commitStats
+	freezes           *groupFreezes
 	conn              *sarama.Broker
 	requestsCh        chan submitRq
 	requestBatchesCh  chan map[string]map[instanceID]submitRq
@@ -503,34 +560,16 @@ func (be *brokerExecutor) runAggregator() {
 }
 
 func (be *brokerExecutor) runExecutor() {
-	nilOrRequestBatchesCh := be.requestBatchesCh
+	var nilOrRequestBatchesCh chan map[string]map[instanceID]submitRq
 	var lastErrTime time.Time
 	commitTicker := time.NewTicker(be.cfg.Consumer.OffsetsCommitInterval)
 	defer commitTicker.Stop()
-offsetCommitLoop:
 	for {
 		select {
 		case requestBatch := <-nilOrRequestBatchesCh:
 			nilOrRequestBatchesCh = nil
-			for group, groupRequests := range requestBatch {
-				kafkaRq := &sarama.OffsetCommitRequest{
-					Version:                 1,
-					ConsumerGroup:           group,
-					ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
-				}
-				for _, rq := range groupRequests {
-					kafkaRq.AddBlock(rq.id.topic, rq.id.partition, rq.offset.Val, sarama.ReceiveTime, rq.offset.Meta)
-				}
-				kafkaRs, err := be.conn.CommitOffset(kafkaRq)
-				if err != nil {
-					lastErrTime = time.Now()
-					be.execActDesc.Log().WithError(err).Error("Connection reset")
-					be.conn.Close()
-				}
-				// Fan the response out to the partition offset managers.
-				for _, rq := range groupRequests {
-					rq.resultCh <- submitRs{rq.offset, kafkaRs, err}
-				}
+			if be.commitBatch(requestBatch) != nil {
+				lastErrTime = time.Now()
 			}
 		case <-be.flushExecutorCh:
 			nilOrRequestBatchesCh = be.requestBatchesCh
@@ -541,16 +580,76 @@ offsetCommitLoop:
 			// waiting in the channel, but that is ok, for they will be retried.
 			if time.Since(lastErrTime) < be.cfg.Consumer.RetryBackoff {
 				be.execActDesc.Log().Warn("Backing off after connection error")
-				continue offsetCommitLoop
+				continue
+			}
+			// Only commit offsets that have been submitted by the tick, so
+			// that offsets of all partitions of a group submitted within an
+			// interval are committed in one request.
+			select {
+			case requestBatch := <-be.requestBatchesCh:
+				nilOrRequestBatchesCh = nil
+				if be.commitBatch(requestBatch) != nil {
+					lastErrTime = time.Now()
+				}
+			default:
 			}
-			nilOrRequestBatchesCh = be.requestBatchesCh
-
 		case <-be.execStopCh:
 			return
 		}
 	}
 }
 
+// commitBatch commits a batch of offsets to Kafka with a request per group,
+// and fans responses out to partition offset managers. It returns the last
+// connection error.
+func (be *brokerExecutor) commitBatch(requestBatch map[string]map[instanceID]submitRq) error {
+	var lastErr error
+	for group, groupRequests := range requestBatch {
+		if be.freezes.isFrozen(group) {
+			be.skipFrozen(group, groupRequests)
+			continue
+		}
+		kafkaRq := &sarama.OffsetCommitRequest{
+			Version:                 1,
+			ConsumerGroup:           group,
+			ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		}
+		for _, rq := range groupRequests {
+			kafkaRq.AddBlock(rq.id.topic, rq.id.partition, rq.offset.Val, sarama.ReceiveTime, rq.offset.Meta)
+		}
+		startedAt := time.Now()
+		var kafkaRs *sarama.OffsetCommitResponse
+		err := chaos.FailCommit()
+		if err == nil {
+			kafkaRs, err = be.conn.CommitOffset(kafkaRq)
+		}
+		be.commitStats.add(len(groupRequests), time.Since(startedAt))
+		if err != nil {
+			lastErr = err
+			be.execActDesc.Log().WithError(err).Error("Connection reset")
+			be.conn.Close()
+		}
+		// Fan the response out to the partition offset managers.
+		for _, rq := range groupRequests {
+			rq.resultCh <- submitRs{rq.offset, kafkaRs, err}
+		}
+	}
+	return lastErr
+}
+
+// skipFrozen responds to offset managers of a frozen group as if their
+// offsets were committed, without sending them to Kafka.
+func (be *brokerExecutor) skipFrozen(group string, groupRequests map[instanceID]submitRq) {
+	be.execActDesc.Log().Debugf("Commit skipped, group frozen: %s", group)
+	kafkaRs := &sarama.OffsetCommitResponse{}
+	for _, rq := range groupRequests {
+		kafkaRs.AddError(rq.id.topic, rq.id.partition, sarama.ErrNoError)
+	}
+	for _, rq := range groupRequests {
+		rq.resultCh <- submitRs{rq.offset, kafkaRs, nil}
+	}
+}
+
 func (be *brokerExecutor) String() string {
 	if be == nil {
 		return "<nil>"
@@ -3,11 +3,13 @@ package offsetmgr
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/lifecycle"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -185,6 +187,68 @@ func (s *OffsetMgrSuite) TestCommitError(c *C) {
 	c.Assert(committedOffset, DeepEquals, Offset{1000, "foo"})
 }
 
+// Consecutive failed commits are counted and reported in lifecycle events
+// along with the error class. The count is reset by a successful commit.
+func (s *OffsetMgrSuite) TestCommitErrorFailures(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(c).
+			SetCoordinator(sarama.CoordinatorGroup, "g1", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1234, "foo", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNotLeaderForPartition),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.RetryBackoff = 100 * time.Millisecond
+	cfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+
+	sub := lifecycle.Subscribe(10)
+	defer sub.Close()
+
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Assert(<-om.CommittedOffsets(), DeepEquals, Offset{1234, "foo"})
+
+	// When
+	om.SubmitOffset(Offset{1000, "foo"})
+
+	// Then
+	for i := 1; i <= 2; i++ {
+		<-om.(*offsetMgr).testErrorsCh
+		ev := <-sub.Events()
+		c.Check(ev.Type, Equals, lifecycle.OffsetCommitFailed)
+		c.Check(ev.ErrorClass, Equals, ErrClassKafka)
+		c.Check(ev.Failures, Equals, i)
+	}
+	c.Check(om.Failures() >= 2, Equals, true)
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(c).
+			SetCoordinator(sarama.CoordinatorGroup, "g1", broker1),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError),
+	})
+	go func() {
+		for range om.(*offsetMgr).testErrorsCh {
+		}
+	}()
+	c.Assert(<-om.CommittedOffsets(), DeepEquals, Offset{1000, "foo"})
+	c.Check(om.Failures(), Equals, 0)
+	om.Stop()
+}
+
 // If offset a response received from Kafka for an offset commit request does
 // not contain information for a submitted offset, then offset manager keeps,
 // retrying until it succeeds.
@@ -373,6 +437,104 @@ func (s *OffsetMgrSuite) TestCommitDifferentGroups(c *C) {
 	c.Assert(committedOffset2, DeepEquals, Offset{2019, "bar3"})
 }
 
+// While a group is frozen its offsets are reported as committed, but are not
+// sent to Kafka. Offsets of other groups are committed as usual.
+func (s *OffsetMgrSuite) TestCommitFrozen(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(c).
+			SetCoordinator(sarama.CoordinatorGroup, "g1", broker1).
+			SetCoordinator(sarama.CoordinatorGroup, "g2", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1000, "foo", sarama.ErrNoError).
+			SetOffset("g2", "t1", 7, 2000, "bar", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError).
+			SetError("g2", "t1", 7, sarama.ErrNoError),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+	f.SetFreezes(map[string]time.Time{
+		"g1": time.Now().Add(time.Hour),
+		"g2": time.Now().Add(-time.Second),
+	})
+	om1, err := f.Spawn(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	om2, err := f.Spawn(s.ns.NewChild("g2", "t1", 7), "g2", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Assert(<-om1.CommittedOffsets(), Equals, Offset{1000, "foo"})
+	c.Assert(<-om2.CommittedOffsets(), Equals, Offset{2000, "bar"})
+
+	// When
+	om1.SubmitOffset(Offset{1001, "foo1"})
+	om2.SubmitOffset(Offset{2001, "bar1"})
+
+	// Then
+	c.Check(<-om1.CommittedOffsets(), Equals, Offset{1001, "foo1"})
+	c.Check(<-om2.CommittedOffsets(), Equals, Offset{2001, "bar1"})
+	om1.Stop()
+	om2.Stop()
+	c.Check(lastCommittedOffset(broker1, "g1", "t1", 7), Equals, Offset{})
+	c.Check(lastCommittedOffset(broker1, "g2", "t1", 7), Equals, Offset{2001, "bar1"})
+}
+
+// Offsets of partitions of a group submitted within a commit interval are
+// committed in one request.
+func (s *OffsetMgrSuite) TestCommitCoalesced(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(c).
+			SetCoordinator(sarama.CoordinatorGroup, "g1", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1000, "foo", sarama.ErrNoError).
+			SetOffset("g1", "t1", 8, 2000, "bar", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError).
+			SetError("g1", "t1", 8, sarama.ErrNoError),
+	})
+
+	cfg := testhelpers.NewTestProxyCfg("c1")
+	cfg.Consumer.OffsetsCommitInterval = 500 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+	om1, err := f.Spawn(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	om2, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	c.Assert(<-om1.CommittedOffsets(), DeepEquals, Offset{1000, "foo"})
+	c.Assert(<-om2.CommittedOffsets(), DeepEquals, Offset{2000, "bar"})
+
+	// When
+	om1.SubmitOffset(Offset{1001, "foo1"})
+	om2.SubmitOffset(Offset{2001, "bar1"})
+
+	// Then
+	c.Assert(<-om1.CommittedOffsets(), DeepEquals, Offset{1001, "foo1"})
+	c.Assert(<-om2.CommittedOffsets(), DeepEquals, Offset{2001, "bar1"})
+	stats := f.(*factory).commitStats
+	c.Check(atomic.LoadInt64(&stats.requests), Equals, int64(1))
+	c.Check(atomic.LoadInt64(&stats.offsets), Equals, int64(2))
+	om1.Stop()
+	om2.Stop()
+}
+
 func (s *OffsetMgrSuite) TestCommitNetworkError(c *C) {
 	// Given
 	broker1 := sarama.NewMockBroker(c, 101)
@@ -0,0 +1,60 @@
+package offsetmgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+)
+
+// RoutingFactory spawns offset managers with one of two factories depending
+// on the group. It is used while groups are migrated from an offset store to
+// Kafka: migrated groups get their offset managers from the Kafka factory,
+// and the rest from the store factory.
+//
+// implements `Factory`
+type RoutingFactory struct {
+	storeF Factory
+	kafkaF Factory
+
+	mu          sync.RWMutex
+	kafkaGroups map[string]bool
+}
+
+// NewRoutingFactory creates a factory that spawns offset managers with
+// storeF, except for groups set by SetKafkaGroups, that get theirs from
+// kafkaF. Both factories are stopped when the routing factory is stopped.
+func NewRoutingFactory(storeF, kafkaF Factory) *RoutingFactory {
+	return &RoutingFactory{storeF: storeF, kafkaF: kafkaF}
+}
+
+// SetKafkaGroups replaces the set of groups whose offset managers are spawned
+// by the Kafka factory. Offset managers already running are not affected.
+func (f *RoutingFactory) SetKafkaGroups(groups map[string]bool) {
+	f.mu.Lock()
+	f.kafkaGroups = groups
+	f.mu.Unlock()
+}
+
+// implements `Factory`
+func (f *RoutingFactory) Spawn(namespace *actor.Descriptor, group, topic string, partition int32) (T, error) {
+	f.mu.RLock()
+	toKafka := f.kafkaGroups[group]
+	f.mu.RUnlock()
+	if toKafka {
+		return f.kafkaF.Spawn(namespace, group, topic, partition)
+	}
+	return f.storeF.Spawn(namespace, group, topic, partition)
+}
+
+// implements `Factory`
+func (f *RoutingFactory) SetFreezes(freezes map[string]time.Time) {
+	f.storeF.SetFreezes(freezes)
+	f.kafkaF.SetFreezes(freezes)
+}
+
+// implements `Factory`
+func (f *RoutingFactory) Stop() {
+	f.storeF.Stop()
+	f.kafkaF.Stop()
+}
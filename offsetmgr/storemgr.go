@@ -0,0 +1,240 @@
+package offsetmgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/lifecycle"
+	"github.com/mailgun/kafka-pixy/offsetstore"
+	"github.com/pkg/errors"
+)
+
+// SpawnStoreFactory creates an offset manager factory that commits offsets to
+// the given store rather than to Kafka. The store is closed when the factory
+// is stopped.
+func SpawnStoreFactory(parentActDesc *actor.Descriptor, cfg *config.Proxy, store offsetstore.T) Factory {
+	f := &storeFactory{
+		actDesc:  parentActDesc.NewChild("offset_mgr_f"),
+		cfg:      cfg,
+		store:    store,
+		children: make(map[instanceID]*storeOffsetMgr),
+	}
+	f.failureStats = newFailureStats(f.actDesc)
+	return f
+}
+
+// implements `Factory`
+type storeFactory struct {
+	actDesc *actor.Descriptor
+	cfg     *config.Proxy
+	store   offsetstore.T
+
+	failureStats *failureStats
+	freezes      groupFreezes
+
+	childrenMu sync.Mutex
+	children   map[instanceID]*storeOffsetMgr
+}
+
+// implements `Factory`
+func (f *storeFactory) Spawn(namespace *actor.Descriptor, group, topic string, partition int32) (T, error) {
+	id := instanceID{group, topic, partition}
+
+	f.childrenMu.Lock()
+	defer f.childrenMu.Unlock()
+	if _, ok := f.children[id]; ok {
+		return nil, errors.Errorf("offset manager %v already exists", id)
+	}
+	actDesc := namespace.NewChild("offset_mgr")
+	actDesc.AddLogField("kafka.group", group)
+	actDesc.AddLogField("kafka.topic", topic)
+	actDesc.AddLogField("kafka.partition", partition)
+	om := &storeOffsetMgr{
+		actDesc:            actDesc,
+		f:                  f,
+		id:                 id,
+		submittedOffsetsCh: make(chan Offset),
+		committedOffsetsCh: make(chan Offset, f.cfg.Consumer.ChannelBufferSize),
+	}
+	if testReportErrors {
+		om.testErrorsCh = make(chan error, f.cfg.Consumer.ChannelBufferSize)
+	}
+	actDesc.ObserveGauge("commit_failures", func() int64 { return int64(om.Failures()) })
+	f.children[id] = om
+	actor.Spawn(om.actDesc, &om.wg, om.run)
+	return om, nil
+}
+
+// implements `Factory`
+func (f *storeFactory) SetFreezes(freezes map[string]time.Time) {
+	f.freezes.set(freezes)
+}
+
+// implements `Factory.Stop()`
+func (f *storeFactory) Stop() {
+	f.store.Close()
+}
+
+func (f *storeFactory) onOffsetMgrStopped(om *storeOffsetMgr) {
+	f.childrenMu.Lock()
+	delete(f.children, om.id)
+	f.childrenMu.Unlock()
+}
+
+// storeOffsetMgr commits the latest submitted offset to the store
+// periodically. A failed commit is retried on the next tick after the retry
+// backoff has passed.
+//
+// implements `T`
+type storeOffsetMgr struct {
+	actDesc            *actor.Descriptor
+	f                  *storeFactory
+	id                 instanceID
+	submittedOffsetsCh chan Offset
+	committedOffsetsCh chan Offset
+	failures           failureStreak
+	wg                 sync.WaitGroup
+
+	// To be used in tests only!
+	testErrorsCh chan error
+}
+
+// implements `T`.
+func (om *storeOffsetMgr) SubmitOffset(offset Offset) {
+	om.submittedOffsetsCh <- offset
+}
+
+// implements `T`.
+func (om *storeOffsetMgr) CommittedOffsets() <-chan Offset {
+	return om.committedOffsetsCh
+}
+
+// implements `T`.
+func (om *storeOffsetMgr) Failures() int {
+	return om.failures.get()
+}
+
+// implements `T`.
+func (om *storeOffsetMgr) Stop() {
+	close(om.submittedOffsetsCh)
+	om.wg.Wait()
+}
+
+func (om *storeOffsetMgr) run() {
+	defer close(om.committedOffsetsCh)
+	if om.testErrorsCh != nil {
+		defer close(om.testErrorsCh)
+	}
+	defer om.f.onOffsetMgrStopped(om)
+
+	var (
+		latestOffset         = undefinedOffset
+		nilOrSubmittedOffsCh = om.submittedOffsetsCh
+		stopping             = false
+	)
+	// Retrieve the initial offset, accepting submitted offsets meanwhile.
+	var committedOffset Offset
+	for {
+		storedOffset, err := om.f.store.Fetch(om.id.group, om.id.topic, om.id.partition)
+		if err == nil {
+			committedOffset = Offset(storedOffset)
+			break
+		}
+		om.reportError(err, "Failed to fetch initial offset")
+		retryCh := time.After(om.f.cfg.Consumer.RetryBackoff)
+	waitRetry:
+		for {
+			select {
+			case offset, ok := <-nilOrSubmittedOffsCh:
+				if !ok {
+					// Return only if there is no uncommitted offset,
+					// otherwise keep running.
+					if latestOffset == undefinedOffset {
+						return
+					}
+					stopping = true
+					nilOrSubmittedOffsCh = nil
+					continue
+				}
+				latestOffset = offset
+			case <-retryCh:
+				break waitRetry
+			}
+		}
+	}
+	om.committedOffsetsCh <- committedOffset
+	if latestOffset == undefinedOffset {
+		latestOffset = committedOffset
+	}
+
+	var lastErrTime time.Time
+	commitTicker := time.NewTicker(om.f.cfg.Consumer.OffsetsCommitInterval)
+	defer commitTicker.Stop()
+	for {
+		select {
+		case offset, ok := <-nilOrSubmittedOffsCh:
+			if ok {
+				latestOffset = offset
+				continue
+			}
+			// Commit the latest offset right away, and keep retrying on
+			// ticks until it is committed.
+			stopping = true
+			nilOrSubmittedOffsCh = nil
+		case <-commitTicker.C:
+		}
+		if latestOffset == committedOffset {
+			if stopping {
+				return
+			}
+			continue
+		}
+		if time.Since(lastErrTime) < om.f.cfg.Consumer.RetryBackoff {
+			continue
+		}
+		if om.f.freezes.isFrozen(om.id.group) {
+			committedOffset = latestOffset
+			om.committedOffsetsCh <- committedOffset
+			continue
+		}
+		storedOffset := offsetstore.Offset(latestOffset)
+		if err := om.f.store.Commit(om.id.group, om.id.topic, om.id.partition, storedOffset); err != nil {
+			lastErrTime = time.Now()
+			om.onCommitFailed(err)
+			om.reportError(err, "Failed to commit offset")
+			continue
+		}
+		if om.failures.succeeded() > 0 {
+			om.publishCommitRecovered()
+		}
+		lastErrTime = time.Time{}
+		committedOffset = latestOffset
+		om.committedOffsetsCh <- committedOffset
+	}
+}
+
+func (om *storeOffsetMgr) reportError(err error, msg string) {
+	om.actDesc.Log().WithError(err).Error(msg)
+	if om.testErrorsCh != nil {
+		om.testErrorsCh <- err
+	}
+}
+
+// onCommitFailed counts a failed commit and publishes a lifecycle event
+// about it. All errors of an offset store are of the store class.
+func (om *storeOffsetMgr) onCommitFailed(err error) {
+	om.f.failureStats.add(ErrClassStore)
+	ev := lifecycle.NewPartitionEvent(om.f.cfg, lifecycle.OffsetCommitFailed, om.id.group, om.id.topic, om.id.partition)
+	ev.Error = err.Error()
+	ev.ErrorClass = ErrClassStore
+	ev.Failures = om.failures.failed()
+	lifecycle.Publish(ev)
+}
+
+// publishCommitRecovered publishes a lifecycle event telling that an offset
+// was committed after a streak of failed commits.
+func (om *storeOffsetMgr) publishCommitRecovered() {
+	lifecycle.Publish(lifecycle.NewPartitionEvent(om.f.cfg, lifecycle.OffsetCommitRecovered, om.id.group, om.id.topic, om.id.partition))
+}
@@ -0,0 +1,225 @@
+package offsetmgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/offsetstore"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type StoreMgrSuite struct {
+	ns    *actor.Descriptor
+	cfg   *config.Proxy
+	store *fakeStore
+}
+
+var _ = Suite(&StoreMgrSuite{})
+
+func (s *StoreMgrSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+	testReportErrors = true
+}
+
+func (s *StoreMgrSuite) TearDownSuite(c *C) {
+	testReportErrors = false
+}
+
+func (s *StoreMgrSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.cfg = testhelpers.NewTestProxyCfg("c1")
+	s.cfg.Consumer.OffsetsCommitInterval = 10 * time.Millisecond
+	s.cfg.Consumer.RetryBackoff = 20 * time.Millisecond
+	s.store = &fakeStore{offsets: map[instanceID]offsetstore.Offset{
+		{"g1", "t1", 8}: {Val: 2000, Meta: "bar"},
+	}}
+}
+
+// The initial offset is fetched from the store, and submitted offsets are
+// committed to it.
+func (s *StoreMgrSuite) TestCommit(c *C) {
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	defer om.Stop()
+	c.Assert(<-om.CommittedOffsets(), Equals, Offset{2000, "bar"})
+
+	// When
+	om.SubmitOffset(Offset{2001, "foo"})
+
+	// Then
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{2001, "foo"})
+	c.Check(s.store.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 2001, Meta: "foo"})
+}
+
+// While a group is frozen submitted offsets are reported as committed, but
+// are not written to the store, until the freeze is lifted.
+func (s *StoreMgrSuite) TestCommitFrozen(c *C) {
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+	f.SetFreezes(map[string]time.Time{"g1": time.Now().Add(time.Hour)})
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	defer om.Stop()
+	c.Assert(<-om.CommittedOffsets(), Equals, Offset{2000, "bar"})
+
+	// When
+	om.SubmitOffset(Offset{2001, "foo"})
+
+	// Then
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{2001, "foo"})
+	c.Check(s.store.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 2000, Meta: "bar"})
+
+	// When
+	f.SetFreezes(nil)
+	om.SubmitOffset(Offset{2002, "foo"})
+
+	// Then
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{2002, "foo"})
+	c.Check(s.store.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 2002, Meta: "foo"})
+}
+
+// Offset managers of groups migrated to Kafka are spawned by the Kafka
+// factory, and of other groups by the store factory.
+func (s *StoreMgrSuite) TestRouting(c *C) {
+	kafkaStore := &fakeStore{offsets: map[instanceID]offsetstore.Offset{
+		{"g1", "t1", 8}: {Val: 3000, Meta: "kafka"},
+	}}
+	f := NewRoutingFactory(SpawnStoreFactory(s.ns, s.cfg, s.store), SpawnStoreFactory(s.ns, s.cfg, kafkaStore))
+	defer f.Stop()
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{2000, "bar"})
+	om.Stop()
+
+	// When
+	f.SetKafkaGroups(map[string]bool{"g1": true})
+
+	// Then
+	om, err = f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	defer om.Stop()
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{3000, "kafka"})
+	om.SubmitOffset(Offset{3001, "foo"})
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{3001, "foo"})
+	c.Check(kafkaStore.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 3001, Meta: "foo"})
+	c.Check(s.store.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 2000, Meta: "bar"})
+}
+
+// If nothing has been committed for a partition, then the initial offset is
+// offsetstore.NoOffset.
+func (s *StoreMgrSuite) TestInitialNoOffset(c *C) {
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+
+	// When
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	defer om.Stop()
+
+	// Then
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{offsetstore.NoOffset, ""})
+}
+
+// The initial offset fetch is retried until it succeeds.
+func (s *StoreMgrSuite) TestInitialFetchError(c *C) {
+	s.store.setErr(errors.New("Kaboom!"))
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	defer om.Stop()
+	c.Check(<-om.(*storeOffsetMgr).testErrorsCh, ErrorMatches, "Kaboom!")
+
+	// When
+	s.store.setErr(nil)
+
+	// Then
+	c.Check(<-om.CommittedOffsets(), Equals, Offset{2000, "bar"})
+}
+
+// The latest submitted offset is committed before Stop returns, even if the
+// store fails at first.
+func (s *StoreMgrSuite) TestCommitBeforeStop(c *C) {
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+	c.Assert(<-om.CommittedOffsets(), Equals, Offset{2000, "bar"})
+	s.store.setErr(errors.New("Kaboom!"))
+	om.SubmitOffset(Offset{2001, "foo"})
+	om.SubmitOffset(Offset{2002, "bazz"})
+	c.Check(<-om.(*storeOffsetMgr).testErrorsCh, ErrorMatches, "Kaboom!")
+	s.store.setErr(nil)
+
+	// When
+	om.Stop()
+
+	// Then
+	c.Check(s.store.get("g1", "t1", 8), Equals, offsetstore.Offset{Val: 2002, Meta: "bazz"})
+}
+
+// Only one offset manager can be spawned for a group-topic-partition at a
+// time.
+func (s *StoreMgrSuite) TestSpawnDuplicate(c *C) {
+	f := SpawnStoreFactory(s.ns, s.cfg, s.store)
+	defer f.Stop()
+	om, err := f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Assert(err, IsNil)
+
+	_, err = f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Check(err, ErrorMatches, "offset manager {g1 t1 8} already exists")
+
+	om.Stop()
+	om, err = f.Spawn(s.ns.NewChild("g1", "t1", 8), "g1", "t1", 8)
+	c.Check(err, IsNil)
+	om.Stop()
+}
+
+type fakeStore struct {
+	mu      sync.Mutex
+	offsets map[instanceID]offsetstore.Offset
+	err     error
+}
+
+func (fs *fakeStore) Fetch(group, topic string, partition int32) (offsetstore.Offset, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return offsetstore.Offset{}, fs.err
+	}
+	offset, ok := fs.offsets[instanceID{group, topic, partition}]
+	if !ok {
+		return offsetstore.Offset{Val: offsetstore.NoOffset}, nil
+	}
+	return offset, nil
+}
+
+func (fs *fakeStore) Commit(group, topic string, partition int32, offset offsetstore.Offset) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return fs.err
+	}
+	fs.offsets[instanceID{group, topic, partition}] = offset
+	return nil
+}
+
+func (fs *fakeStore) Close() {}
+
+func (fs *fakeStore) get(group, topic string, partition int32) offsetstore.Offset {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.offsets[instanceID{group, topic, partition}]
+}
+
+func (fs *fakeStore) setErr(err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.err = err
+}
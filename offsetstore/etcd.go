@@ -0,0 +1,157 @@
+package offsetstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// etcdStore keeps offsets in etcd. It talks to the JSON gateway of the etcd
+// v3 API, so that no gRPC client is needed. Endpoints are tried in the
+// configured order until one of them responds.
+type etcdStore struct {
+	cfg     config.OffsetStorage
+	httpClt *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func newEtcdStore(cfg config.OffsetStorage) *etcdStore {
+	return &etcdStore{
+		cfg:     cfg,
+		httpClt: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type etcdRangeRs struct {
+	KVs []etcdKV `json:"kvs"`
+}
+
+// implements `T`.
+func (s *etcdStore) Fetch(group, topic string, partition int32) (Offset, error) {
+	var rs etcdRangeRs
+	rq := etcdKV{Key: encodeBase64(key(s.cfg.KeyPrefix, group, topic, partition))}
+	if err := s.call("/v3/kv/range", rq, &rs); err != nil {
+		return Offset{}, err
+	}
+	if len(rs.KVs) == 0 {
+		return Offset{Val: NoOffset}, nil
+	}
+	encoded, err := base64.StdEncoding.DecodeString(rs.KVs[0].Value)
+	if err != nil {
+		return Offset{}, errors.Wrap(err, "malformed value")
+	}
+	return decode(encoded)
+}
+
+// implements `T`.
+func (s *etcdStore) Commit(group, topic string, partition int32, offset Offset) error {
+	rq := etcdKV{
+		Key:   encodeBase64(key(s.cfg.KeyPrefix, group, topic, partition)),
+		Value: base64.StdEncoding.EncodeToString(encode(offset)),
+	}
+	return s.call("/v3/kv/put", rq, nil)
+}
+
+// implements `T`.
+func (s *etcdStore) Close() {
+	s.httpClt.CloseIdleConnections()
+}
+
+// call posts a request to the endpoints in order, until one of them
+// responds, and decodes the response into rs if it is not nil.
+func (s *etcdStore) call(path string, rq, rs interface{}) error {
+	body, err := json.Marshal(rq)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range s.cfg.Addrs {
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		var token string
+		if token, err = s.authenticate(endpoint); err != nil {
+			continue
+		}
+		var rsBody []byte
+		if rsBody, err = s.post(endpoint+path, token, body); err != nil {
+			if token != "" {
+				// The token might have expired, so get a new one next time.
+				s.mu.Lock()
+				s.token = ""
+				s.mu.Unlock()
+			}
+			continue
+		}
+		if rs == nil {
+			return nil
+		}
+		return errors.Wrap(json.Unmarshal(rsBody, rs), "malformed response")
+	}
+	return errors.Wrap(err, "etcd request failed")
+}
+
+// authenticate returns a token to authorize requests with, or an empty
+// string if no username is configured.
+func (s *etcdStore) authenticate(endpoint string) (string, error) {
+	if s.cfg.Username == "" {
+		return "", nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" {
+		return s.token, nil
+	}
+	body, _ := json.Marshal(map[string]string{"name": s.cfg.Username, "password": s.cfg.Password})
+	rsBody, err := s.post(endpoint+"/v3/auth/authenticate", "", body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to authenticate")
+	}
+	var rs struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rsBody, &rs); err != nil || rs.Token == "" {
+		return "", errors.Errorf("malformed authenticate response: %s", rsBody)
+	}
+	s.token = rs.Token
+	return s.token, nil
+}
+
+func (s *etcdStore) post(url, token string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rs, err := s.httpClt.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	rsBody, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status: %d, body=%s", rs.StatusCode, bytes.TrimSpace(rsBody))
+	}
+	return rsBody, nil
+}
+
+func encodeBase64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,71 @@
+// Package offsetstore implements storage of consumer group offsets outside of
+// Kafka, for Kafka compatible brokers that have no group coordinator. Offsets
+// are stored as JSON documents under keys of the form
+// `<prefix><group>/<topic>/<partition>`.
+package offsetstore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// NoOffset is the value of an offset that has never been committed. It is the
+// same value that Kafka returns in this case.
+const NoOffset = -1
+
+// Offset is an offset value decorated with a metadata string.
+type Offset struct {
+	Val  int64
+	Meta string
+}
+
+// T is a storage of consumer group offsets.
+type T interface {
+	// Fetch returns the offset committed by a group for a topic partition.
+	// If no offset has been committed, then the returned offset value is
+	// NoOffset.
+	Fetch(group, topic string, partition int32) (Offset, error)
+
+	// Commit stores the offset of a group for a topic partition.
+	Commit(group, topic string, partition int32, offset Offset) error
+
+	// Close releases connections to the backend.
+	Close()
+}
+
+// New creates a storage for the backend specified in the config. The
+// backend is connected to lazily on the first request.
+func New(cfg config.OffsetStorage) (T, error) {
+	switch cfg.Backend {
+	case config.OffsetStorageRedis:
+		return newRedisStore(cfg), nil
+	case config.OffsetStorageEtcd:
+		return newEtcdStore(cfg), nil
+	}
+	return nil, errors.Errorf("unsupported backend: %s", cfg.Backend)
+}
+
+type document struct {
+	Offset   int64  `json:"offset"`
+	Metadata string `json:"metadata"`
+}
+
+func key(prefix, group, topic string, partition int32) string {
+	return fmt.Sprintf("%s%s/%s/%d", prefix, group, topic, partition)
+}
+
+func encode(offset Offset) []byte {
+	encoded, _ := json.Marshal(document{Offset: offset.Val, Metadata: offset.Meta})
+	return encoded
+}
+
+func decode(encoded []byte) (Offset, error) {
+	var doc document
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return Offset{}, errors.Wrap(err, "malformed offset")
+	}
+	return Offset{Val: doc.Offset, Meta: doc.Metadata}, nil
+}
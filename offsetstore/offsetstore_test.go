@@ -0,0 +1,325 @@
+package offsetstore
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type OffsetStoreSuite struct{}
+
+var _ = Suite(&OffsetStoreSuite{})
+
+func (s *OffsetStoreSuite) TestRedis(c *C) {
+	srv := newFakeRedis(c, "secret")
+	defer srv.close()
+	store, err := New(config.OffsetStorage{
+		Backend:   config.OffsetStorageRedis,
+		Addrs:     []string{srv.addr()},
+		KeyPrefix: "pfx/",
+		Password:  "secret",
+		DB:        2,
+		Timeout:   time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	offset, err := store.Fetch("g1", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Check(offset, Equals, Offset{Val: NoOffset})
+
+	// When
+	err = store.Commit("g1", "t1", 7, Offset{Val: 1234, Meta: "foo"})
+
+	// Then
+	c.Assert(err, IsNil)
+	offset, err = store.Fetch("g1", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Check(offset, Equals, Offset{Val: 1234, Meta: "foo"})
+	c.Check(srv.get("pfx/g1/t1/7"), Equals, `{"offset":1234,"metadata":"foo"}`)
+	c.Check(srv.selectedDB(), Equals, "2")
+}
+
+// If the password is wrong, then an error is returned.
+func (s *OffsetStoreSuite) TestRedisAuthError(c *C) {
+	srv := newFakeRedis(c, "secret")
+	defer srv.close()
+	store, err := New(config.OffsetStorage{
+		Backend:  config.OffsetStorageRedis,
+		Addrs:    []string{srv.addr()},
+		Password: "wrong",
+		Timeout:  time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	// When
+	_, err = store.Fetch("g1", "t1", 7)
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to connect to Redis: failed to authenticate: redis error: ERR invalid password")
+}
+
+// Servers are tried in order until one of them can be connected to.
+func (s *OffsetStoreSuite) TestRedisFailover(c *C) {
+	srv := newFakeRedis(c, "")
+	defer srv.close()
+	srv.set("g1/t1/7", `{"offset":1234,"metadata":"foo"}`)
+	store, err := New(config.OffsetStorage{
+		Backend: config.OffsetStorageRedis,
+		Addrs:   []string{"127.0.0.1:1", srv.addr()},
+		Timeout: time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	// When
+	offset, err := store.Fetch("g1", "t1", 7)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(offset, Equals, Offset{Val: 1234, Meta: "foo"})
+}
+
+func (s *OffsetStoreSuite) TestEtcd(c *C) {
+	srv := newFakeEtcd("root", "secret")
+	defer srv.Close()
+	store, err := New(config.OffsetStorage{
+		Backend:   config.OffsetStorageEtcd,
+		Addrs:     []string{"http://127.0.0.1:1", srv.URL + "/"},
+		KeyPrefix: "pfx/",
+		Username:  "root",
+		Password:  "secret",
+		Timeout:   time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	offset, err := store.Fetch("g1", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Check(offset, Equals, Offset{Val: NoOffset})
+
+	// When
+	err = store.Commit("g1", "t1", 7, Offset{Val: 1234, Meta: "foo"})
+
+	// Then
+	c.Assert(err, IsNil)
+	offset, err = store.Fetch("g1", "t1", 7)
+	c.Assert(err, IsNil)
+	c.Check(offset, Equals, Offset{Val: 1234, Meta: "foo"})
+}
+
+// If no endpoint can serve a request, then the last error is returned.
+func (s *OffsetStoreSuite) TestEtcdError(c *C) {
+	srv := newFakeEtcd("root", "secret")
+	defer srv.Close()
+	store, err := New(config.OffsetStorage{
+		Backend:  config.OffsetStorageEtcd,
+		Addrs:    []string{srv.URL},
+		Username: "root",
+		Password: "wrong",
+		Timeout:  time.Second,
+	})
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	// When
+	_, err = store.Fetch("g1", "t1", 7)
+
+	// Then
+	c.Check(err, ErrorMatches, `etcd request failed: failed to authenticate: unexpected status: 401, body={"error":"authentication failed"}`)
+}
+
+func (s *OffsetStoreSuite) TestNewUnsupported(c *C) {
+	_, err := New(config.OffsetStorage{Backend: config.OffsetStorageKafka})
+	c.Check(err, ErrorMatches, "unsupported backend: kafka")
+}
+
+// fakeRedis is an in-memory server that understands AUTH, SELECT, GET and SET
+// commands.
+type fakeRedis struct {
+	c        *C
+	password string
+	ln       net.Listener
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	kv map[string]string
+	db string
+}
+
+func newFakeRedis(c *C, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	srv := &fakeRedis{c: c, password: password, ln: ln, kv: make(map[string]string)}
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.wg.Add(1)
+			go func() {
+				defer srv.wg.Done()
+				srv.serve(conn)
+			}()
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeRedis) addr() string {
+	return srv.ln.Addr().String()
+}
+
+func (srv *fakeRedis) close() {
+	srv.ln.Close()
+	srv.wg.Wait()
+}
+
+func (srv *fakeRedis) get(key string) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.kv[key]
+}
+
+func (srv *fakeRedis) set(key, value string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.kv[key] = value
+}
+
+func (srv *fakeRedis) selectedDB() string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.db
+}
+
+func (srv *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authenticated := srv.password == ""
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			if args[1] != srv.password {
+				reply = "-ERR invalid password\r\n"
+				break
+			}
+			authenticated = true
+			reply = "+OK\r\n"
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			srv.mu.Lock()
+			srv.db = args[1]
+			srv.mu.Unlock()
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			srv.mu.Lock()
+			value, ok := srv.kv[args[1]]
+			srv.mu.Unlock()
+			reply = "$-1\r\n"
+			if ok {
+				reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			}
+		case args[0] == "SET":
+			srv.set(args[1], args[2])
+			reply = "+OK\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		if line, err = rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+// newFakeEtcd creates an in-memory server that implements the range, put and
+// authenticate calls of the etcd v3 JSON gateway.
+func newFakeEtcd(username, password string) *httptest.Server {
+	var mu sync.Mutex
+	kv := make(map[string]string)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rq map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&rq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/v3/auth/authenticate" {
+			if rq["name"] != username || rq["password"] != password {
+				http.Error(w, `{"error":"authentication failed"}`, http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "t0ken"})
+			return
+		}
+		if r.Header.Get("Authorization") != "t0ken" {
+			http.Error(w, `{"error":"invalid auth token"}`, http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			rs := etcdRangeRs{}
+			if value, ok := kv[rq["key"]]; ok {
+				rs.KVs = append(rs.KVs, etcdKV{Key: rq["key"], Value: value})
+			}
+			_ = json.NewEncoder(w).Encode(rs)
+		case "/v3/kv/put":
+			if _, err := base64.StdEncoding.DecodeString(rq["value"]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			kv[rq["key"]] = rq["value"]
+			_, _ = io.WriteString(w, "{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
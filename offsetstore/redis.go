@@ -0,0 +1,175 @@
+package offsetstore
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// redisStore keeps offsets in Redis. It speaks the subset of the RESP
+// protocol needed to issue GET and SET commands over a single connection.
+// If a request fails, then the connection is dropped and the next request
+// dials the servers again in the configured order.
+type redisStore struct {
+	cfg config.OffsetStorage
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newRedisStore(cfg config.OffsetStorage) *redisStore {
+	return &redisStore{cfg: cfg}
+}
+
+// implements `T`.
+func (s *redisStore) Fetch(group, topic string, partition int32) (Offset, error) {
+	reply, err := s.do("GET", key(s.cfg.KeyPrefix, group, topic, partition))
+	if err != nil {
+		return Offset{}, err
+	}
+	if reply == nil {
+		return Offset{Val: NoOffset}, nil
+	}
+	encoded, ok := reply.([]byte)
+	if !ok {
+		return Offset{}, errors.Errorf("unexpected reply: %v", reply)
+	}
+	return decode(encoded)
+}
+
+// implements `T`.
+func (s *redisStore) Commit(group, topic string, partition int32, offset Offset) error {
+	_, err := s.do("SET", key(s.cfg.KeyPrefix, group, topic, partition), string(encode(offset)))
+	return err
+}
+
+// implements `T`.
+func (s *redisStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnect()
+}
+
+func (s *redisStore) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := s.roundTrip(args...)
+	if err != nil {
+		s.disconnect()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *redisStore) connect() error {
+	var err error
+	for _, addr := range s.cfg.Addrs {
+		if s.conn, err = net.DialTimeout("tcp", addr, s.cfg.Timeout); err != nil {
+			continue
+		}
+		s.rd = bufio.NewReader(s.conn)
+		if err = s.handshake(); err != nil {
+			s.disconnect()
+			continue
+		}
+		return nil
+	}
+	return errors.Wrap(err, "failed to connect to Redis")
+}
+
+func (s *redisStore) handshake() error {
+	if s.cfg.Password != "" {
+		if _, err := s.roundTrip("AUTH", s.cfg.Password); err != nil {
+			return errors.Wrap(err, "failed to authenticate")
+		}
+	}
+	if s.cfg.DB != 0 {
+		if _, err := s.roundTrip("SELECT", strconv.Itoa(s.cfg.DB)); err != nil {
+			return errors.Wrap(err, "failed to select database")
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.rd = nil
+	}
+}
+
+func (s *redisStore) roundTrip(args ...string) (interface{}, error) {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := s.conn.Write(encodeCommand(args)); err != nil {
+		return nil, errors.Wrap(err, "failed to send command")
+	}
+	return readReply(s.rd)
+}
+
+// encodeCommand encodes a command as a RESP array of bulk strings.
+func encodeCommand(args []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		buf.WriteString(arg)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// readReply reads a RESP reply. Simple strings and integers are returned as
+// strings and int64 respectively, bulk strings as byte slices, and a nil bulk
+// string as nil. Error replies are returned as errors.
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read reply")
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("malformed reply: %q", line)
+	}
+	payload := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, errors.Errorf("redis error: %s", payload)
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("malformed integer reply: %q", payload)
+		}
+		return n, nil
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, errors.Errorf("malformed bulk reply: %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, bulk); err != nil {
+			return nil, errors.Wrap(err, "failed to read reply")
+		}
+		return bulk[:size], nil
+	}
+	return nil, errors.Errorf("unsupported reply: %q", line)
+}
@@ -0,0 +1,189 @@
+// Package partstats keeps per partition consume statistics: how many
+// messages and bytes consumer groups fetched from a partition and how fast,
+// what offsets they fetched and committed last, and how many fetches of the
+// partition failed, so that hot partition skew can be diagnosed at the proxy
+// without access to broker metrics. Statistics are kept process wide per
+// cluster, like those of the throttle package, so that they can be recorded
+// by whichever actor observes them.
+package partstats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateWindow is the window that fetch rates are averaged over.
+const RateWindow = time.Minute
+
+// Partition holds statistics of a partition since start.
+type Partition struct {
+	Partition int32
+
+	// Totals of all groups.
+	FetchedMessages int64
+	FetchedBytes    int64
+	FetchRate       float64
+
+	// The number of fetches of the partition that failed.
+	FetchErrors int64
+
+	// Groups sorted by name.
+	Groups []Group
+}
+
+// Group holds statistics of a partition consumed by a group.
+type Group struct {
+	Group           string
+	FetchedMessages int64
+	FetchedBytes    int64
+
+	// Messages fetched per second, averaged over the RateWindow.
+	FetchRate float64
+
+	// The offset of the message fetched last, and the offset committed
+	// last. -1 if none yet.
+	LastFetchedOffset   int64
+	LastCommittedOffset int64
+}
+
+type partitionID struct {
+	cluster   string
+	topic     string
+	partition int32
+}
+
+type partition struct {
+	fetchErrors int64
+	groups      map[string]*group
+}
+
+type group struct {
+	fetchedMessages     int64
+	fetchedBytes        int64
+	lastFetchedOffset   int64
+	lastCommittedOffset int64
+	rate                rate
+}
+
+// rate estimates the number of events per second in a sliding window from
+// counts in the current and the previous fixed windows.
+type rate struct {
+	windowStart time.Time
+	curr        int64
+	prev        int64
+}
+
+var (
+	mu         sync.Mutex
+	partitions = make(map[partitionID]*partition)
+	now        = time.Now
+)
+
+// ObserveFetch records a message of the specified size fetched from a
+// partition by a group.
+func ObserveFetch(cluster, groupName, topic string, partitionNo int32, offset int64, size int) {
+	mu.Lock()
+	defer mu.Unlock()
+	g := getGroup(cluster, groupName, topic, partitionNo)
+	g.fetchedMessages++
+	g.fetchedBytes += int64(size)
+	g.lastFetchedOffset = offset
+	g.rate.add(now())
+}
+
+// ObserveCommit records an offset of a partition committed by a group.
+func ObserveCommit(cluster, groupName, topic string, partitionNo int32, offset int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	getGroup(cluster, groupName, topic, partitionNo).lastCommittedOffset = offset
+}
+
+// ObserveFetchError records a failed fetch of a partition.
+func ObserveFetchError(cluster, topic string, partitionNo int32) {
+	mu.Lock()
+	defer mu.Unlock()
+	getPartition(cluster, topic, partitionNo).fetchErrors++
+}
+
+// Get returns statistics of all partitions of a topic that were observed,
+// sorted by partition.
+func Get(cluster, topic string) []Partition {
+	mu.Lock()
+	defer mu.Unlock()
+	at := now()
+	var stats []Partition
+	for id, p := range partitions {
+		if id.cluster != cluster || id.topic != topic {
+			continue
+		}
+		ps := Partition{Partition: id.partition, FetchErrors: p.fetchErrors}
+		for name, g := range p.groups {
+			gs := Group{
+				Group:               name,
+				FetchedMessages:     g.fetchedMessages,
+				FetchedBytes:        g.fetchedBytes,
+				FetchRate:           g.rate.perSecond(at),
+				LastFetchedOffset:   g.lastFetchedOffset,
+				LastCommittedOffset: g.lastCommittedOffset,
+			}
+			ps.FetchedMessages += gs.FetchedMessages
+			ps.FetchedBytes += gs.FetchedBytes
+			ps.FetchRate += gs.FetchRate
+			ps.Groups = append(ps.Groups, gs)
+		}
+		sort.Slice(ps.Groups, func(i, j int) bool { return ps.Groups[i].Group < ps.Groups[j].Group })
+		stats = append(stats, ps)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Partition < stats[j].Partition })
+	return stats
+}
+
+// getPartition must be called with the mutex held.
+func getPartition(cluster, topic string, partitionNo int32) *partition {
+	id := partitionID{cluster, topic, partitionNo}
+	p := partitions[id]
+	if p == nil {
+		p = &partition{groups: make(map[string]*group)}
+		partitions[id] = p
+	}
+	return p
+}
+
+// getGroup must be called with the mutex held.
+func getGroup(cluster, groupName, topic string, partitionNo int32) *group {
+	p := getPartition(cluster, topic, partitionNo)
+	g := p.groups[groupName]
+	if g == nil {
+		g = &group{lastFetchedOffset: -1, lastCommittedOffset: -1}
+		p.groups[groupName] = g
+	}
+	return g
+}
+
+func (r *rate) add(at time.Time) {
+	r.roll(at)
+	r.curr++
+}
+
+// perSecond weighs the previous window count by the part of it that still
+// overlaps the sliding window.
+func (r *rate) perSecond(at time.Time) float64 {
+	r.roll(at)
+	overlap := 1 - float64(at.Sub(r.windowStart))/float64(RateWindow)
+	return (float64(r.prev)*overlap + float64(r.curr)) / RateWindow.Seconds()
+}
+
+func (r *rate) roll(at time.Time) {
+	elapsed := at.Sub(r.windowStart)
+	switch {
+	case elapsed < RateWindow:
+		return
+	case elapsed < 2*RateWindow:
+		r.prev, r.curr = r.curr, 0
+		r.windowStart = r.windowStart.Add(RateWindow)
+	default:
+		r.prev, r.curr = 0, 0
+		r.windowStart = at
+	}
+}
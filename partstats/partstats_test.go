@@ -0,0 +1,96 @@
+package partstats
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PartStatsSuite struct {
+	now time.Time
+}
+
+var _ = Suite(&PartStatsSuite{})
+
+func (s *PartStatsSuite) SetUpTest(c *C) {
+	partitions = make(map[partitionID]*partition)
+	s.now = time.Date(2018, 9, 19, 10, 30, 0, 0, time.UTC)
+	now = func() time.Time { return s.now }
+}
+
+func (s *PartStatsSuite) TearDownTest(c *C) {
+	now = time.Now
+}
+
+func (s *PartStatsSuite) TestGet(c *C) {
+	ObserveFetch("foo", "g1", "t1", 1, 10, 100)
+	ObserveFetch("foo", "g1", "t1", 1, 11, 50)
+	ObserveFetch("foo", "g2", "t1", 1, 5, 20)
+	ObserveFetch("foo", "g1", "t1", 0, 7, 30)
+	ObserveCommit("foo", "g1", "t1", 1, 11)
+	ObserveFetchError("foo", "t1", 1)
+	ObserveFetch("foo", "g1", "t2", 0, 1, 1)
+	ObserveFetch("bar", "g1", "t1", 0, 1, 1)
+
+	// When
+	stats := Get("foo", "t1")
+
+	// Then
+	c.Assert(len(stats), Equals, 2)
+	c.Check(stats[0].Partition, Equals, int32(0))
+	c.Check(stats[0].FetchedMessages, Equals, int64(1))
+	c.Check(stats[1].Partition, Equals, int32(1))
+	c.Check(stats[1].FetchedMessages, Equals, int64(3))
+	c.Check(stats[1].FetchedBytes, Equals, int64(170))
+	c.Check(stats[1].FetchErrors, Equals, int64(1))
+	c.Check(stats[1].FetchRate, Equals, float64(3)/60)
+	c.Check(stats[1].Groups, DeepEquals, []Group{{
+		Group:               "g1",
+		FetchedMessages:     2,
+		FetchedBytes:        150,
+		FetchRate:           float64(2) / 60,
+		LastFetchedOffset:   11,
+		LastCommittedOffset: 11,
+	}, {
+		Group:               "g2",
+		FetchedMessages:     1,
+		FetchedBytes:        20,
+		FetchRate:           float64(1) / 60,
+		LastFetchedOffset:   5,
+		LastCommittedOffset: -1,
+	}})
+}
+
+// Nothing is returned for topics that were not consumed.
+func (s *PartStatsSuite) TestGetUnknown(c *C) {
+	ObserveFetch("foo", "g1", "t1", 0, 7, 30)
+
+	c.Check(Get("foo", "t2"), IsNil)
+	c.Check(Get("bar", "t1"), IsNil)
+}
+
+// Fetch rates are averaged over a sliding window, and decay when nothing is
+// fetched.
+func (s *PartStatsSuite) TestRate(c *C) {
+	for i := 0; i < 120; i++ {
+		ObserveFetch("foo", "g1", "t1", 0, int64(i), 1)
+	}
+	c.Check(Get("foo", "t1")[0].FetchRate, Equals, float64(2))
+
+	// When
+	s.now = s.now.Add(RateWindow + RateWindow/4)
+
+	// Then
+	c.Check(Get("foo", "t1")[0].FetchRate, Equals, float64(1.5))
+
+	// When
+	s.now = s.now.Add(RateWindow)
+
+	// Then
+	c.Check(Get("foo", "t1")[0].FetchRate, Equals, float64(0))
+}
@@ -0,0 +1,270 @@
+// Package pipeline implements consume-transform-produce pipelines. A pipeline
+// consumes messages from a source topic, passes them through a
+// transformation, and produces the results to a target topic. Produced
+// messages and offsets of the consumed messages are committed in one Kafka
+// transaction, hence every source message is reflected in the target topic
+// exactly once, as observed by `read_committed` consumers.
+//
+// There is no coordination between Kafka-Pixy instances, a pipeline must be
+// configured on one instance only.
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBatchSize          = 100
+	defaultBatchTimeout       = 100 * time.Millisecond
+	defaultTransactionTimeout = time.Minute
+)
+
+// T is a consume-transform-produce pipeline.
+type T struct {
+	actDesc   *actor.Descriptor
+	name      string
+	cfg       config.Pipeline
+	proxyCfg  *config.Proxy
+	transform Transform
+	stopCh    chan none.T
+	wg        sync.WaitGroup
+}
+
+// Spawn creates a pipeline and starts its goroutines. Kafka errors do not
+// make it fail, the pipeline keeps retrying in the background instead.
+func Spawn(parentActDesc *actor.Descriptor, name string, cfg config.Pipeline, proxyCfg *config.Proxy) (*T, error) {
+	transform, err := resolveTransform(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Group == "" {
+		cfg.Group = name
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = defaultBatchTimeout
+	}
+	if cfg.TransactionTimeout <= 0 {
+		cfg.TransactionTimeout = defaultTransactionTimeout
+	}
+	pl := &T{
+		actDesc:   parentActDesc.NewChild("pipeline", name),
+		name:      name,
+		cfg:       cfg,
+		proxyCfg:  proxyCfg,
+		transform: transform,
+		stopCh:    make(chan none.T),
+	}
+	actor.Spawn(pl.actDesc, &pl.wg, pl.run)
+	return pl, nil
+}
+
+// Stop terminates the pipeline. A transaction in progress is aborted.
+func (pl *T) Stop() {
+	close(pl.stopCh)
+	pl.wg.Wait()
+}
+
+func (pl *T) run() {
+	for {
+		err := pl.runSession()
+		if err == nil {
+			return
+		}
+		if errors.Cause(err) == sarama.ErrInvalidProducerEpoch {
+			pl.actDesc.Log().Errorf("Pipeline fenced off by another producer with the same transactional ID, "+
+				"make sure that it is configured on one Kafka-Pixy instance only: transactionalID=%s", pl.name)
+		}
+		pl.actDesc.Log().WithError(err).Errorf("Pipeline failed, retrying in %v", pl.proxyCfg.Consumer.RetryBackoff)
+		select {
+		case <-time.After(pl.proxyCfg.Consumer.RetryBackoff):
+		case <-pl.stopCh:
+			return
+		}
+	}
+}
+
+// runSession processes batches until either the pipeline is stopped, in
+// which case nil is returned, or an error occurs. Every session initializes
+// its own producer ID, that fences off transactions of previous sessions.
+// Another Kafka-Pixy instance running a pipeline with the same name would be
+// fenced off too, and would fence this one off in turn when it retries, so
+// neither of them would make steady progress.
+func (pl *T) runSession() error {
+	saramaCfg := pl.proxyCfg.SaramaClientCfg()
+	saramaCfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	kafkaClt, err := sarama.NewClient(pl.proxyCfg.Kafka.SeedPeers, saramaCfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Kafka client")
+	}
+	defer kafkaClt.Close()
+
+	partitionerCtor, err := pl.proxyCfg.Producer.Partitioner.ToPartitionerConstructor()
+	if err != nil {
+		return err
+	}
+	tp, err := newTxnProducer(kafkaClt, pl.name, pl.cfg.TransactionTimeout, partitionerCtor(pl.cfg.TargetTopic),
+		sarama.CompressionCodec(pl.proxyCfg.Producer.Compression), pl.proxyCfg.Producer.Timeout)
+	if err != nil {
+		return err
+	}
+	offsets, err := fetchOffsets(kafkaClt, pl.cfg.SourceTopic, pl.cfg.Group)
+	if err != nil {
+		return err
+	}
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return errors.Wrap(err, "failed to create consumer")
+	}
+	defer kafkaConsumer.Close()
+
+	msgCh := make(chan *sarama.ConsumerMessage)
+	errCh := make(chan error, len(offsets))
+	doneCh := make(chan none.T)
+	var wg sync.WaitGroup
+	defer func() {
+		close(doneCh)
+		wg.Wait()
+	}()
+	for partition, offset := range offsets {
+		pc, err := pl.consumePartition(kafkaClt, kafkaConsumer, partition, offset)
+		if err != nil {
+			return err
+		}
+		partition := partition
+		actor.Spawn(pl.actDesc.NewChild("p", partition), &wg, func() {
+			pl.forwardMessages(pc, partition, msgCh, errCh, doneCh)
+		})
+	}
+	pl.actDesc.Log().Infof("Pipeline started: offsets=%v", offsets)
+	for {
+		batch, err := pl.collectBatch(msgCh, errCh)
+		if err != nil {
+			return err
+		}
+		if batch == nil {
+			return nil
+		}
+		if err := pl.processBatch(tp, batch); err != nil {
+			if abortErr := tp.abort(); abortErr != nil {
+				pl.actDesc.Log().WithError(abortErr).Warn("Failed to abort transaction")
+			}
+			return err
+		}
+	}
+}
+
+// consumePartition starts consuming a source partition from the committed
+// offset. If there is no committed offset, or it is out of range, then the
+// partition is consumed from the nearest available offset.
+func (pl *T) consumePartition(kafkaClt sarama.Client, kafkaConsumer sarama.Consumer, partition int32, offset int64,
+) (sarama.PartitionConsumer, error) {
+	newest, err := kafkaClt.GetOffset(pl.cfg.SourceTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+	}
+	oldest, err := kafkaClt.GetOffset(pl.cfg.SourceTopic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get oldest offset, partition=%d", partition)
+	}
+	if offset == sarama.OffsetNewest || offset > newest {
+		offset = newest
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+	pc, err := kafkaConsumer.ConsumePartition(pl.cfg.SourceTopic, partition, offset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to consume partition %d", partition)
+	}
+	return pc, nil
+}
+
+func (pl *T) forwardMessages(pc sarama.PartitionConsumer, partition int32, msgCh chan<- *sarama.ConsumerMessage,
+	errCh chan<- error, doneCh <-chan none.T,
+) {
+	defer pc.Close()
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				// The partition consumer closes the channel on fatal errors,
+				// e.g. when the offset is out of range. The session has to be
+				// restarted to consume the partition again.
+				errCh <- errors.Errorf("partition consumer stopped, partition=%d", partition)
+				return
+			}
+			select {
+			case msgCh <- msg:
+			case <-doneCh:
+				return
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// collectBatch waits for a message and then keeps collecting more until
+// either the batch is full or the batch timeout expires. It returns a nil
+// batch if the pipeline is stopped, and an error if consumption of a
+// partition failed.
+func (pl *T) collectBatch(msgCh <-chan *sarama.ConsumerMessage, errCh <-chan error) ([]*sarama.ConsumerMessage, error) {
+	var batch []*sarama.ConsumerMessage
+	select {
+	case msg := <-msgCh:
+		batch = append(batch, msg)
+	case err := <-errCh:
+		return nil, err
+	case <-pl.stopCh:
+		return nil, nil
+	}
+	timeout := time.NewTimer(pl.cfg.BatchTimeout)
+	defer timeout.Stop()
+	for len(batch) < pl.cfg.BatchSize {
+		select {
+		case msg := <-msgCh:
+			batch = append(batch, msg)
+		case err := <-errCh:
+			return nil, err
+		case <-timeout.C:
+			return batch, nil
+		case <-pl.stopCh:
+			return nil, nil
+		}
+	}
+	return batch, nil
+}
+
+// processBatch transforms a batch of source messages and commits the
+// results along with the source offsets in one transaction.
+func (pl *T) processBatch(tp *txnProducer, batch []*sarama.ConsumerMessage) error {
+	var results []Message
+	offsets := make(map[int32]int64)
+	for _, consMsg := range batch {
+		msg := Message{
+			Key:       consMsg.Key,
+			Value:     consMsg.Value,
+			Timestamp: consMsg.Timestamp,
+		}
+		for _, h := range consMsg.Headers {
+			msg.Headers = append(msg.Headers, *h)
+		}
+		transformed, err := pl.transform(msg)
+		if err != nil {
+			return errors.Wrapf(err, "transformation failed, partition=%d, offset=%d", consMsg.Partition, consMsg.Offset)
+		}
+		results = append(results, transformed...)
+		offsets[consMsg.Partition] = consMsg.Offset + 1
+	}
+	return tp.commit(pl.cfg.TargetTopic, results, pl.cfg.SourceTopic, pl.cfg.Group, offsets)
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PipelineSuite struct{}
+
+var _ = Suite(&PipelineSuite{})
+
+// If neither transformation nor plugin is configured, then messages are
+// copied as is.
+func (s *PipelineSuite) TestResolveTransformDefault(c *C) {
+	transform, err := resolveTransform(config.Pipeline{})
+	c.Assert(err, IsNil)
+
+	// When
+	msgs, err := transform(Message{Key: []byte("foo"), Value: []byte("bar")})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(msgs, DeepEquals, []Message{{Key: []byte("foo"), Value: []byte("bar")}})
+}
+
+func (s *PipelineSuite) TestResolveTransformRegistered(c *C) {
+	Register("test_split", func(msg Message) ([]Message, error) {
+		return []Message{{Value: msg.Value[:1]}, {Value: msg.Value[1:]}}, nil
+	})
+	transform, err := resolveTransform(config.Pipeline{Transform: "test_split"})
+	c.Assert(err, IsNil)
+
+	// When
+	msgs, err := transform(Message{Value: []byte("ab")})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(msgs, DeepEquals, []Message{{Value: []byte("a")}, {Value: []byte("b")}})
+}
+
+func (s *PipelineSuite) TestResolveTransformUnknown(c *C) {
+	_, err := resolveTransform(config.Pipeline{Transform: "unknown"})
+	c.Check(err, ErrorMatches, "unknown transformation: unknown")
+}
+
+func (s *PipelineSuite) TestRegisterDuplicate(c *C) {
+	c.Check(func() { Register(defaultTransform, nil) }, PanicMatches, "transformation already registered: identity")
+}
+
+// Record timestamps are relative to the earliest one in a batch, and
+// messages without timestamps get the current time.
+func (s *PipelineSuite) TestNewRecordBatch(c *C) {
+	now := time.Unix(1000, 0)
+	msgs := []Message{
+		{Key: []byte("a"), Value: []byte("1"), Timestamp: now.Add(-2 * time.Second)},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Value: []byte("3"), Headers: []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}}},
+	}
+
+	// When
+	batch := newRecordBatch(msgs, now, sarama.CompressionGZIP)
+
+	// Then
+	c.Check(batch.Version, Equals, int8(2))
+	c.Check(batch.Codec, Equals, sarama.CompressionGZIP)
+	c.Check(batch.IsTransactional, Equals, true)
+	c.Check(batch.LastOffsetDelta, Equals, int32(2))
+	c.Check(batch.FirstTimestamp, Equals, now.Add(-2*time.Second))
+	c.Check(batch.MaxTimestamp, Equals, now)
+	c.Assert(len(batch.Records), Equals, 3)
+	for i, rec := range batch.Records {
+		c.Check(rec.OffsetDelta, Equals, int64(i))
+		c.Check(rec.Value, DeepEquals, msgs[i].Value)
+	}
+	c.Check(batch.Records[0].TimestampDelta, Equals, time.Duration(0))
+	c.Check(batch.Records[1].TimestampDelta, Equals, 2*time.Second)
+	c.Check(batch.Records[2].Headers, DeepEquals, []*sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}})
+}
+
+// If a partition consumer stops on a fatal error, then collecting a batch
+// fails, so that the session is restarted, rather than a nil message being
+// forwarded.
+func (s *PipelineSuite) TestPartitionConsumerStopped(c *C) {
+	pl := &T{cfg: config.Pipeline{BatchSize: 10, BatchTimeout: time.Minute}, stopCh: make(chan none.T)}
+	pc := &fakePartitionConsumer{messagesCh: make(chan *sarama.ConsumerMessage, 1)}
+	pc.messagesCh <- &sarama.ConsumerMessage{Partition: 3, Offset: 7}
+	close(pc.messagesCh)
+	msgCh := make(chan *sarama.ConsumerMessage)
+	errCh := make(chan error, 1)
+	doneCh := make(chan none.T)
+	defer close(doneCh)
+	go pl.forwardMessages(pc, 3, msgCh, errCh, doneCh)
+
+	// When
+	batch, err := pl.collectBatch(msgCh, errCh)
+
+	// Then
+	c.Check(batch, IsNil)
+	c.Check(err, ErrorMatches, "partition consumer stopped, partition=3")
+}
+
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messagesCh chan *sarama.ConsumerMessage
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messagesCh }
+func (pc *fakePartitionConsumer) Close() error                             { return nil }
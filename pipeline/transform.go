@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"plugin"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Name of the transformation that is used if none is configured.
+	defaultTransform = "identity"
+
+	// Name of the function that transformation plugins must export.
+	pluginSymbol = "Transform"
+)
+
+// Message is a message passed through a transformation.
+type Message struct {
+	Key       []byte
+	Value     []byte
+	Headers   []sarama.RecordHeader
+	Timestamp time.Time
+}
+
+// Transform converts a source message into zero or more messages to be
+// produced to the target topic. If a transformation returns an error, then
+// the batch that the message belongs to is retried after a back off.
+type Transform func(msg Message) ([]Message, error)
+
+var (
+	transformsMu sync.Mutex
+	transforms   = map[string]Transform{
+		defaultTransform: func(msg Message) ([]Message, error) {
+			return []Message{msg}, nil
+		},
+	}
+)
+
+// Register makes a transformation available to pipelines by name. It is
+// supposed to be called from `init` functions of packages linked into a
+// custom Kafka-Pixy build. It panics if the name is already registered.
+func Register(name string, transform Transform) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	if _, ok := transforms[name]; ok {
+		panic("transformation already registered: " + name)
+	}
+	transforms[name] = transform
+}
+
+// resolveTransform returns a transformation configured for a pipeline.
+func resolveTransform(cfg config.Pipeline) (Transform, error) {
+	if cfg.Plugin != "" {
+		p, err := plugin.Open(cfg.Plugin)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open plugin")
+		}
+		sym, err := p.Lookup(pluginSymbol)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to lookup transformation")
+		}
+		switch fn := sym.(type) {
+		case func(Message) ([]Message, error):
+			return fn, nil
+		case *Transform:
+			return *fn, nil
+		}
+		return nil, errors.Errorf("plugin symbol %s has unexpected type %T", pluginSymbol, sym)
+	}
+	name := cfg.Transform
+	if name == "" {
+		name = defaultTransform
+	}
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	transform, ok := transforms[name]
+	if !ok {
+		return nil, errors.Errorf("unknown transformation: %s", name)
+	}
+	return transform, nil
+}
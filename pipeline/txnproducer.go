@@ -0,0 +1,345 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProduceRequest v3 is the first one to support transactions.
+	produceRequestVer = 3
+	// FindCoordinatorRequest v1 is the first one to support transaction
+	// coordinators.
+	findCoordinatorVer = 1
+	// OffsetFetchRequest v1 reads offsets stored in Kafka.
+	offsetFetchVer = 1
+)
+
+// txnProducer implements the client side of the Kafka transactional
+// producer protocol on top of the sarama request primitives, since
+// sarama.AsyncProducer does not support transactions.
+//
+// A producer instance is good for one producer epoch. If any of the
+// transaction steps fails, then the instance should be discarded and a new
+// one created, that bumps the epoch and aborts any dangling transaction.
+type txnProducer struct {
+	kafkaClt      sarama.Client
+	txnID         string
+	producerID    int64
+	producerEpoch int16
+	coordinator   *sarama.Broker
+	partitioner   sarama.Partitioner
+	codec         sarama.CompressionCodec
+	requiredAcks  sarama.RequiredAcks
+	timeout       time.Duration
+	sequences     map[int32]int32
+}
+
+func newTxnProducer(kafkaClt sarama.Client, txnID string, txnTimeout time.Duration, partitioner sarama.Partitioner,
+	codec sarama.CompressionCodec, timeout time.Duration,
+) (*txnProducer, error) {
+	tp := &txnProducer{
+		kafkaClt:     kafkaClt,
+		txnID:        txnID,
+		partitioner:  partitioner,
+		codec:        codec,
+		requiredAcks: sarama.WaitForAll,
+		timeout:      timeout,
+		sequences:    make(map[int32]int32),
+	}
+	var err error
+	if tp.coordinator, err = tp.findCoordinator(); err != nil {
+		return nil, err
+	}
+	res, err := tp.coordinator.InitProducerID(&sarama.InitProducerIDRequest{
+		TransactionalID:    &txnID,
+		TransactionTimeout: txnTimeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init producer ID")
+	}
+	if res.Err != sarama.ErrNoError {
+		return nil, errors.Wrap(res.Err, "failed to init producer ID")
+	}
+	tp.producerID = res.ProducerID
+	tp.producerEpoch = res.ProducerEpoch
+	return tp, nil
+}
+
+// findCoordinator returns the transaction coordinator of the transactional
+// ID, asking brokers one by one until one of them responds.
+func (tp *txnProducer) findCoordinator() (*sarama.Broker, error) {
+	req := sarama.FindCoordinatorRequest{
+		Version:         findCoordinatorVer,
+		CoordinatorKey:  tp.txnID,
+		CoordinatorType: sarama.CoordinatorTransaction,
+	}
+	var lastErr error = errors.New("no brokers available")
+	for _, broker := range tp.kafkaClt.Brokers() {
+		_ = broker.Open(tp.kafkaClt.Config())
+		res, err := broker.FindCoordinator(&req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.Err != sarama.ErrNoError {
+			lastErr = res.Err
+			continue
+		}
+		_ = res.Coordinator.Open(tp.kafkaClt.Config())
+		return res.Coordinator, nil
+	}
+	return nil, errors.Wrap(lastErr, "failed to find transaction coordinator")
+}
+
+// commit produces messages to the target topic and commits the source
+// offsets on behalf of the group, all in one transaction.
+func (tp *txnProducer) commit(targetTopic string, msgs []Message, sourceTopic, group string, offsets map[int32]int64) error {
+	partitioned, err := tp.partition(targetTopic, msgs)
+	if err != nil {
+		return err
+	}
+	if len(partitioned) > 0 {
+		if err := tp.addPartitions(targetTopic, partitioned); err != nil {
+			return err
+		}
+		if err := tp.produce(targetTopic, partitioned); err != nil {
+			return err
+		}
+	}
+	if err := tp.commitOffsets(sourceTopic, group, offsets); err != nil {
+		return err
+	}
+	return tp.endTxn(true)
+}
+
+// abort aborts the current transaction if any.
+func (tp *txnProducer) abort() error {
+	return tp.endTxn(false)
+}
+
+func (tp *txnProducer) partition(topic string, msgs []Message) (map[int32][]Message, error) {
+	partitions, err := tp.kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get target partitions")
+	}
+	partitioned := make(map[int32][]Message)
+	for _, msg := range msgs {
+		prodMsg := sarama.ProducerMessage{Topic: topic}
+		if msg.Key != nil {
+			prodMsg.Key = sarama.ByteEncoder(msg.Key)
+		}
+		partition, err := tp.partitioner.Partition(&prodMsg, int32(len(partitions)))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to partition message")
+		}
+		partitioned[partition] = append(partitioned[partition], msg)
+	}
+	return partitioned, nil
+}
+
+func (tp *txnProducer) addPartitions(topic string, partitioned map[int32][]Message) error {
+	req := sarama.AddPartitionsToTxnRequest{
+		TransactionalID: tp.txnID,
+		ProducerID:      tp.producerID,
+		ProducerEpoch:   tp.producerEpoch,
+		TopicPartitions: make(map[string][]int32),
+	}
+	for partition := range partitioned {
+		req.TopicPartitions[topic] = append(req.TopicPartitions[topic], partition)
+	}
+	res, err := tp.coordinator.AddPartitionsToTxn(&req)
+	if err != nil {
+		return errors.Wrap(err, "failed to add partitions to transaction")
+	}
+	for _, partitionErrs := range res.Errors {
+		for _, pe := range partitionErrs {
+			if pe.Err != sarama.ErrNoError {
+				return errors.Wrapf(pe.Err, "failed to add partition %d to transaction", pe.Partition)
+			}
+		}
+	}
+	return nil
+}
+
+func (tp *txnProducer) produce(topic string, partitioned map[int32][]Message) error {
+	requests := make(map[*sarama.Broker]*sarama.ProduceRequest)
+	now := time.Now()
+	for partition, msgs := range partitioned {
+		leader, err := tp.kafkaClt.Leader(topic, partition)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get leader, partition=%d", partition)
+		}
+		req := requests[leader]
+		if req == nil {
+			req = &sarama.ProduceRequest{
+				TransactionalID: &tp.txnID,
+				RequiredAcks:    tp.requiredAcks,
+				Timeout:         int32(tp.timeout / time.Millisecond),
+				Version:         produceRequestVer,
+			}
+			requests[leader] = req
+		}
+		batch := newRecordBatch(msgs, now, tp.codec)
+		batch.ProducerID = tp.producerID
+		batch.ProducerEpoch = tp.producerEpoch
+		batch.FirstSequence = tp.sequences[partition]
+		req.AddBatch(topic, partition, batch)
+	}
+	for broker, req := range requests {
+		_ = broker.Open(tp.kafkaClt.Config())
+		res, err := broker.Produce(req)
+		if err != nil {
+			return errors.Wrapf(err, "failed to produce, broker=%d", broker.ID())
+		}
+		for partition := range partitioned {
+			block := res.GetBlock(topic, partition)
+			if block == nil {
+				continue // Partition led by another broker.
+			}
+			if block.Err != sarama.ErrNoError {
+				return errors.Wrapf(block.Err, "failed to produce, partition=%d", partition)
+			}
+		}
+	}
+	for partition, msgs := range partitioned {
+		tp.sequences[partition] += int32(len(msgs))
+	}
+	return nil
+}
+
+func (tp *txnProducer) commitOffsets(topic, group string, offsets map[int32]int64) error {
+	res, err := tp.coordinator.AddOffsetsToTxn(&sarama.AddOffsetsToTxnRequest{
+		TransactionalID: tp.txnID,
+		ProducerID:      tp.producerID,
+		ProducerEpoch:   tp.producerEpoch,
+		GroupID:         group,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to add offsets to transaction")
+	}
+	if res.Err != sarama.ErrNoError {
+		return errors.Wrap(res.Err, "failed to add offsets to transaction")
+	}
+	groupCoordinator, err := tp.kafkaClt.Coordinator(group)
+	if err != nil {
+		return errors.Wrap(err, "failed to get group coordinator")
+	}
+	req := sarama.TxnOffsetCommitRequest{
+		TransactionalID: tp.txnID,
+		GroupID:         group,
+		ProducerID:      tp.producerID,
+		ProducerEpoch:   tp.producerEpoch,
+		Topics:          make(map[string][]*sarama.PartitionOffsetMetadata),
+	}
+	for partition, offset := range offsets {
+		req.Topics[topic] = append(req.Topics[topic], &sarama.PartitionOffsetMetadata{
+			Partition: partition,
+			Offset:    offset,
+		})
+	}
+	commitRes, err := groupCoordinator.TxnOffsetCommit(&req)
+	if err != nil {
+		return errors.Wrap(err, "failed to commit offsets")
+	}
+	for _, partitionErrs := range commitRes.Topics {
+		for _, pe := range partitionErrs {
+			if pe.Err != sarama.ErrNoError {
+				return errors.Wrapf(pe.Err, "failed to commit offset, partition=%d", pe.Partition)
+			}
+		}
+	}
+	return nil
+}
+
+func (tp *txnProducer) endTxn(commit bool) error {
+	res, err := tp.coordinator.EndTxn(&sarama.EndTxnRequest{
+		TransactionalID:   tp.txnID,
+		ProducerID:        tp.producerID,
+		ProducerEpoch:     tp.producerEpoch,
+		TransactionResult: commit,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to end transaction")
+	}
+	if res.Err != sarama.ErrNoError {
+		return errors.Wrap(res.Err, "failed to end transaction")
+	}
+	return nil
+}
+
+// fetchOffsets returns offsets committed by a group for all partitions of
+// a topic. If a group has not committed an offset for a partition, then
+// sarama.OffsetNewest is returned for it.
+func fetchOffsets(kafkaClt sarama.Client, topic, group string) (map[int32]int64, error) {
+	partitions, err := kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get source partitions")
+	}
+	coordinator, err := kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get group coordinator")
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: offsetFetchVer}
+	for _, partition := range partitions {
+		req.AddPartition(topic, partition)
+	}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch offsets")
+	}
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		block := res.GetBlock(topic, partition)
+		if block == nil {
+			return nil, errors.Errorf("offset block is missing, partition=%d", partition)
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, errors.Wrapf(block.Err, "failed to fetch offset, partition=%d", partition)
+		}
+		offsets[partition] = block.Offset
+		if block.Offset < 0 {
+			offsets[partition] = sarama.OffsetNewest
+		}
+	}
+	return offsets, nil
+}
+
+// newRecordBatch creates a transactional record batch from messages. Messages
+// that do not have a timestamp are given the specified one.
+func newRecordBatch(msgs []Message, now time.Time, codec sarama.CompressionCodec) *sarama.RecordBatch {
+	batch := &sarama.RecordBatch{
+		Version:         2,
+		Codec:           codec,
+		IsTransactional: true,
+		LastOffsetDelta: int32(len(msgs) - 1),
+	}
+	timestamps := make([]time.Time, len(msgs))
+	for i, msg := range msgs {
+		timestamps[i] = msg.Timestamp
+		if timestamps[i].IsZero() {
+			timestamps[i] = now
+		}
+		if i == 0 || timestamps[i].Before(batch.FirstTimestamp) {
+			batch.FirstTimestamp = timestamps[i]
+		}
+		if i == 0 || timestamps[i].After(batch.MaxTimestamp) {
+			batch.MaxTimestamp = timestamps[i]
+		}
+	}
+	for i, msg := range msgs {
+		rec := &sarama.Record{
+			OffsetDelta:    int64(i),
+			TimestampDelta: timestamps[i].Sub(batch.FirstTimestamp),
+			Key:            msg.Key,
+			Value:          msg.Value,
+		}
+		for j := range msg.Headers {
+			rec.Headers = append(rec.Headers, &msg.Headers[j])
+		}
+		batch.Records = append(batch.Records, rec)
+	}
+	return batch
+}
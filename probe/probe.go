@@ -0,0 +1,241 @@
+// Package probe checks that Kafka and ZooKeeper clusters of all configured
+// proxies can be worked with: every seed peer is reachable, the ZooKeeper
+// chroot exists, and Kafka brokers support the configured version. Results
+// are reported per endpoint, so that a misconfiguration is spotted on startup
+// rather than deduced from errors deep in the Kafka client later.
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/apiversion"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Checks that are made against endpoints.
+const (
+	CheckKafka     = "kafka"
+	CheckVersion   = "version"
+	CheckZooKeeper = "zookeeper"
+	CheckChroot    = "chroot"
+)
+
+// DefaultTimeout is how long a single check can take by default.
+const DefaultTimeout = 10 * time.Second
+
+// Outcome is what a check against an endpoint has shown.
+type Outcome struct {
+	Cluster  string
+	Check    string
+	Endpoint string
+	// Details of a passed check, e.g. a version supported by a broker.
+	Details string
+	Err     error
+}
+
+// All checks all Kafka and ZooKeeper peers of all configured proxies
+// concurrently, and returns results sorted by cluster, check and endpoint.
+// No check takes longer than `timeout`.
+func All(cfg *config.App, timeout time.Duration) []Outcome {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []Outcome
+	)
+	report := func(rs ...Outcome) {
+		mu.Lock()
+		results = append(results, rs...)
+		mu.Unlock()
+	}
+	for cluster, proxyCfg := range cfg.Proxies {
+		saramaCfg := proxyCfg.SaramaClientCfg()
+		saramaCfg.Net.DialTimeout = timeout
+		saramaCfg.Net.ReadTimeout = timeout
+		saramaCfg.Net.WriteTimeout = timeout
+		for _, addr := range proxyCfg.Kafka.SeedPeers {
+			wg.Add(1)
+			go func(cluster string, proxyCfg *config.Proxy, addr string) {
+				defer wg.Done()
+				report(checkKafka(cluster, proxyCfg, saramaCfg, addr)...)
+			}(cluster, proxyCfg, addr)
+		}
+		for _, addr := range proxyCfg.ZooKeeper.SeedPeers {
+			wg.Add(1)
+			go func(cluster string, proxyCfg *config.Proxy, addr string) {
+				defer wg.Done()
+				report(checkZooKeeper(cluster, proxyCfg, addr, timeout)...)
+			}(cluster, proxyCfg, addr)
+		}
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		ri, rj := results[i], results[j]
+		if ri.Cluster != rj.Cluster {
+			return ri.Cluster < rj.Cluster
+		}
+		if ri.Check != rj.Check {
+			return ri.Check < rj.Check
+		}
+		return ri.Endpoint < rj.Endpoint
+	})
+	return results
+}
+
+// Failed tells whether any check failed.
+func Failed(results []Outcome) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Fatal returns an error if a cluster cannot be worked with at all: none of
+// its Kafka or ZooKeeper peers is reachable, or a reachable broker does not
+// support the configured Kafka version. Failures of individual peers are not
+// fatal, for clusters tolerate them.
+func Fatal(results []Outcome) error {
+	passed := make(map[string]bool)
+	failed := make(map[string]Outcome)
+	for _, r := range results {
+		key := r.Cluster + "/" + r.Check
+		if r.Err == nil {
+			passed[key] = true
+			continue
+		}
+		if r.Check == CheckVersion {
+			return errors.Errorf("unsupported Kafka version, cluster=%s, endpoint=%s: %s", r.Cluster, r.Endpoint, r.Err)
+		}
+		failed[key] = r
+	}
+	keys := make([]string, 0, len(failed))
+	for key := range failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r := failed[key]
+		if !passed[key] && (r.Check == CheckKafka || r.Check == CheckZooKeeper) {
+			return errors.Errorf("no %s peer is reachable, cluster=%s", r.Check, r.Cluster)
+		}
+	}
+	return nil
+}
+
+// Format returns results as a table, one row per result.
+func Format(results []Outcome) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tCHECK\tENDPOINT\tRESULT")
+	for _, r := range results {
+		outcome := "pass"
+		if r.Details != "" {
+			outcome += ": " + r.Details
+		}
+		if r.Err != nil {
+			outcome = "FAIL: " + r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Cluster, r.Check, r.Endpoint, outcome)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func checkKafka(cluster string, proxyCfg *config.Proxy, saramaCfg *sarama.Config, addr string) []Outcome {
+	kafkaResult := Outcome{Cluster: cluster, Check: CheckKafka, Endpoint: addr}
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(saramaCfg); err != nil {
+		kafkaResult.Err = err
+		return []Outcome{kafkaResult}
+	}
+	connected, err := broker.Connected()
+	broker.Close()
+	if !connected {
+		if err == nil {
+			err = errors.New("not connected")
+		}
+		kafkaResult.Err = err
+		return []Outcome{kafkaResult}
+	}
+
+	// ApiVersionsRequest is only supported by brokers 0.10.0.0 and later,
+	// so the version of older brokers cannot be checked.
+	configured := proxyCfg.Kafka.Version
+	if !configured.IsAtLeast(sarama.V0_10_0_0) {
+		return []Outcome{kafkaResult}
+	}
+	versionResult := Outcome{Cluster: cluster, Check: CheckVersion, Endpoint: addr}
+	supported, err := apiversion.Supported(addr, saramaCfg)
+	if err != nil {
+		versionResult.Err = err
+		return []Outcome{kafkaResult, versionResult}
+	}
+	versionResult.Details = fmt.Sprintf("supports %s, configured %s", supported, configured)
+	// The configured version is too high if it is at least the supported
+	// one, but not the same.
+	tooHigh := configured.IsAtLeast(supported) && configured.String() != supported.String()
+	if !proxyCfg.Kafka.NegotiateVersion && tooHigh {
+		versionResult.Err = errors.Errorf("broker supports %s, but kafka.version is %s. Consider changing `kafka.version` or enabling `kafka.negotiate_version`",
+			supported, configured)
+	}
+	return []Outcome{kafkaResult, versionResult}
+}
+
+func checkZooKeeper(cluster string, proxyCfg *config.Proxy, addr string, timeout time.Duration) []Outcome {
+	zkResult := Outcome{Cluster: cluster, Check: CheckZooKeeper, Endpoint: addr}
+	zkConn, eventsCh, err := zk.Connect([]string{addr}, timeout, zk.WithLogger(nopLogger{}))
+	if err != nil {
+		zkResult.Err = err
+		return []Outcome{zkResult}
+	}
+	defer zkConn.Close()
+	if err := waitForSession(eventsCh, timeout); err != nil {
+		zkResult.Err = err
+		return []Outcome{zkResult}
+	}
+
+	chroot := proxyCfg.ZooKeeper.Chroot
+	if chroot == "" || chroot == "/" {
+		return []Outcome{zkResult}
+	}
+	chrootResult := Outcome{Cluster: cluster, Check: CheckChroot, Endpoint: addr + chroot}
+	exists, _, err := zkConn.Exists(chroot)
+	if err != nil {
+		chrootResult.Err = err
+	} else if !exists && proxyCfg.ZooKeeper.CreateChroot {
+		chrootResult.Details = "does not exist, will be created"
+	} else if !exists {
+		chrootResult.Err = errors.New("does not exist. Consider enabling `zoo_keeper.create_chroot`")
+	}
+	return []Outcome{zkResult, chrootResult}
+}
+
+func waitForSession(eventsCh <-chan zk.Event, timeout time.Duration) error {
+	timeoutCh := time.After(timeout)
+	for {
+		select {
+		case event := <-eventsCh:
+			if event.State == zk.StateHasSession {
+				return nil
+			}
+			if event.State == zk.StateAuthFailed {
+				return errors.New("authentication failed")
+			}
+		case <-timeoutCh:
+			return errors.Errorf("no session within %s", timeout)
+		}
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
@@ -0,0 +1,114 @@
+package probe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ProbeSuite struct {
+	broker *sarama.MockBroker
+}
+
+var _ = Suite(&ProbeSuite{})
+
+func (s *ProbeSuite) SetUpTest(c *C) {
+	s.broker = sarama.NewMockBroker(c, 0)
+	s.broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+			ApiVersions: []*sarama.ApiVersionsResponseBlock{
+				{ApiKey: 1, MaxVersion: 7}, // Fetch v7 was introduced in 1.1.0
+			},
+		}),
+	})
+}
+
+func (s *ProbeSuite) TearDownTest(c *C) {
+	s.broker.Close()
+}
+
+func (s *ProbeSuite) TestAll(c *C) {
+	cfg := config.DefaultApp("foo")
+	cfg.Proxies["foo"].Kafka.SeedPeers = []string{s.broker.Addr(), "127.0.0.1:1"}
+	cfg.Proxies["foo"].Kafka.Version.Set(sarama.V1_0_0_0)
+	cfg.Proxies["foo"].ZooKeeper.SeedPeers = []string{"127.0.0.1:1"}
+
+	// When
+	results := All(cfg, 200*time.Millisecond)
+
+	// Then
+	c.Assert(results, HasLen, 4)
+	c.Check(results[0].Check, Equals, CheckKafka)
+	c.Check(results[0].Endpoint, Equals, "127.0.0.1:1")
+	c.Check(results[0].Err, NotNil)
+	c.Check(results[1].Check, Equals, CheckKafka)
+	c.Check(results[1].Endpoint, Equals, s.broker.Addr())
+	c.Check(results[1].Err, IsNil)
+	c.Check(results[2].Check, Equals, CheckVersion)
+	c.Check(results[2].Details, Equals, "supports 1.1.0, configured 1.0.0")
+	c.Check(results[2].Err, IsNil)
+	c.Check(results[3].Check, Equals, CheckZooKeeper)
+	c.Check(results[3].Err, ErrorMatches, "no session within 200ms")
+	c.Check(Failed(results), Equals, true)
+	c.Check(Fatal(results), ErrorMatches, "no zookeeper peer is reachable, cluster=foo")
+}
+
+// A broker that does not support the configured Kafka version fails the
+// probe, unless the version is negotiated.
+func (s *ProbeSuite) TestAllVersionTooHigh(c *C) {
+	for i, tc := range []struct {
+		negotiate bool
+		error     string
+	}{{
+		negotiate: false,
+		error:     "broker supports 1.1.0, but kafka.version is 2.0.0.*",
+	}, {
+		negotiate: true,
+	}} {
+		cfg := config.DefaultApp("foo")
+		cfg.Proxies["foo"].Kafka.SeedPeers = []string{s.broker.Addr()}
+		cfg.Proxies["foo"].Kafka.Version.Set(sarama.V2_0_0_0)
+		cfg.Proxies["foo"].Kafka.NegotiateVersion = tc.negotiate
+		cfg.Proxies["foo"].ZooKeeper.SeedPeers = nil
+
+		// When
+		results := All(cfg, 200*time.Millisecond)
+
+		// Then
+		c.Assert(results, HasLen, 2, Commentf("case #%d", i))
+		c.Check(results[1].Check, Equals, CheckVersion, Commentf("case #%d", i))
+		if tc.error == "" {
+			c.Check(results[1].Err, IsNil, Commentf("case #%d", i))
+			c.Check(Fatal(results), IsNil, Commentf("case #%d", i))
+			continue
+		}
+		c.Check(results[1].Err, ErrorMatches, tc.error, Commentf("case #%d", i))
+		c.Check(Fatal(results), ErrorMatches, "unsupported Kafka version, cluster=foo, .*", Commentf("case #%d", i))
+	}
+}
+
+func (s *ProbeSuite) TestFormat(c *C) {
+	results := []Outcome{
+		{Cluster: "foo", Check: CheckKafka, Endpoint: "kafka1:9092"},
+		{Cluster: "foo", Check: CheckVersion, Endpoint: "kafka1:9092", Details: "supports 2.3.0, configured 2.3.0"},
+		{Cluster: "foo", Check: CheckChroot, Endpoint: "zk1:2181/pixy", Err: errors.New("does not exist")},
+	}
+
+	// When
+	table := Format(results)
+
+	// Then
+	c.Check(table, Equals, ""+
+		"CLUSTER  CHECK    ENDPOINT       RESULT\n"+
+		"foo      kafka    kafka1:9092    pass\n"+
+		"foo      version  kafka1:9092    pass: supports 2.3.0, configured 2.3.0\n"+
+		"foo      chroot   zk1:2181/pixy  FAIL: does not exist\n")
+}
@@ -0,0 +1,212 @@
+// Package prodslo tracks latency of synchronous produce requests to topics
+// against service level objectives. The latency of every request is split
+// into the time the message spent in the Kafka client and brokers, and the
+// rest that was spent in Kafka-Pixy, so that when a request is slower than
+// the objective allows it is clear whose fault it was. Latencies are counted
+// since start in histograms with exponentially growing buckets, that
+// percentiles are estimated from.
+package prodslo
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// Statuses of an objective.
+const (
+	StatusMet      = "met"
+	StatusViolated = "violated"
+)
+
+const defaultPercentile = 99
+
+// Latencies are counted in buckets with upper bounds growing by bucketFactor
+// from minBucketBound, that is up to about two minutes. The last bucket
+// counts everything above that.
+const (
+	minBucketBound = 100 * time.Microsecond
+	bucketFactor   = 1.25
+	bucketCount    = 64
+)
+
+var bucketBounds = func() []time.Duration {
+	bounds := make([]time.Duration, bucketCount-1)
+	bound := float64(minBucketBound)
+	for i := range bounds {
+		bounds[i] = time.Duration(bound)
+		bound *= bucketFactor
+	}
+	return bounds
+}()
+
+// Status is the state of a topic objective as of all requests observed
+// since start.
+type Status struct {
+	Topic      string
+	Status     string
+	MaxLatency time.Duration
+	Percentile float64
+
+	// The number of requests observed, and of those that took longer than
+	// MaxLatency. A violation is blamed on Kafka if the message spent
+	// longer than MaxLatency in the Kafka client and brokers alone, and on
+	// Kafka-Pixy otherwise.
+	Requests        int64
+	Violations      int64
+	KafkaViolations int64
+	ProxyViolations int64
+
+	// Estimates of the Percentile of the request latency and of the time
+	// messages spent in Kafka. They are upper bounds of the histogram
+	// buckets that the percentiles fall into.
+	Latency      time.Duration
+	KafkaLatency time.Duration
+}
+
+// T tracks produce latency objectives of topics.
+type T struct {
+	actDesc *actor.Descriptor
+
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+type topic struct {
+	cfg             config.ProduceSLO
+	requests        int64
+	violations      int64
+	kafkaViolations int64
+	latencies       histogram
+	kafkaLatencies  histogram
+}
+
+type histogram [bucketCount]int64
+
+// New creates a tracker of the configured objectives. Statuses are also
+// reported as metrics of its actor.
+func New(parentActDesc *actor.Descriptor, cfg config.ProduceSLOs) *T {
+	t := &T{
+		actDesc: parentActDesc.NewChild("produce_slo"),
+		topics:  make(map[string]*topic, len(cfg.Topics)),
+	}
+	for name, sloCfg := range cfg.Topics {
+		name := name
+		if sloCfg.Percentile == 0 {
+			sloCfg.Percentile = defaultPercentile
+		}
+		t.topics[name] = &topic{cfg: sloCfg}
+		t.actDesc.ObserveGauge(name+"_violated", func() int64 {
+			if t.Status(name).Status == StatusViolated {
+				return 1
+			}
+			return 0
+		})
+		t.actDesc.ObserveGauge(name+"_violations", func() int64 {
+			return t.Status(name).Violations
+		})
+		t.actDesc.ObserveGauge(name+"_kafka_violations", func() int64 {
+			return t.Status(name).KafkaViolations
+		})
+		t.actDesc.ObserveGauge(name+"_latency_ms", func() int64 {
+			return int64(t.Status(name).Latency / time.Millisecond)
+		})
+	}
+	return t
+}
+
+// Observe counts a produce request to a topic that took latency in total,
+// out of which kafkaLatency the message spent in Kafka. Requests to topics
+// with no objective are ignored.
+func (t *T) Observe(topicName string, latency, kafkaLatency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.topics[topicName]
+	if tp == nil {
+		return
+	}
+	tp.requests++
+	tp.latencies.add(latency)
+	tp.kafkaLatencies.add(kafkaLatency)
+	if latency <= tp.cfg.MaxLatency {
+		return
+	}
+	tp.violations++
+	if kafkaLatency > tp.cfg.MaxLatency {
+		tp.kafkaViolations++
+	}
+}
+
+// Statuses returns statuses of all topic objectives sorted by topic.
+func (t *T) Statuses() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]Status, 0, len(t.topics))
+	for name, tp := range t.topics {
+		statuses = append(statuses, tp.status(name))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Topic < statuses[j].Topic })
+	return statuses
+}
+
+// Status returns the status of a topic objective. The status of a topic with
+// no objective is empty.
+func (t *T) Status(topicName string) Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.topics[topicName]
+	if tp == nil {
+		return Status{Topic: topicName}
+	}
+	return tp.status(topicName)
+}
+
+// status must be called with the mutex held.
+func (tp *topic) status(name string) Status {
+	status := Status{
+		Topic:           name,
+		Status:          StatusMet,
+		MaxLatency:      tp.cfg.MaxLatency,
+		Percentile:      tp.cfg.Percentile,
+		Requests:        tp.requests,
+		Violations:      tp.violations,
+		KafkaViolations: tp.kafkaViolations,
+		ProxyViolations: tp.violations - tp.kafkaViolations,
+		Latency:         tp.latencies.percentile(tp.cfg.Percentile, tp.requests),
+		KafkaLatency:    tp.kafkaLatencies.percentile(tp.cfg.Percentile, tp.requests),
+	}
+	// The objective is violated if more requests than the percentile allows
+	// took longer than the maximum. That is decided by the exact counts
+	// rather than by the estimated percentile.
+	if float64(tp.violations) > float64(tp.requests)*(100-tp.cfg.Percentile)/100 {
+		status.Status = StatusViolated
+	}
+	return status
+}
+
+func (h *histogram) add(latency time.Duration) {
+	i := sort.Search(len(bucketBounds), func(i int) bool { return latency <= bucketBounds[i] })
+	h[i]++
+}
+
+// percentile returns the upper bound of the bucket that the given percentile
+// of count observations falls into. Observations above the largest bound
+// are estimated as the largest bound.
+func (h *histogram) percentile(p float64, count int64) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(count)))
+	var seen int64
+	for i, n := range h {
+		seen += n
+		if seen >= rank && i < len(bucketBounds) {
+			return bucketBounds[i]
+		}
+	}
+	return bucketBounds[len(bucketBounds)-1]
+}
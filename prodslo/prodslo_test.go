@@ -0,0 +1,125 @@
+package prodslo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ProduceSLOSuite struct {
+	ns *actor.Descriptor
+}
+
+var _ = Suite(&ProduceSLOSuite{})
+
+func (s *ProduceSLOSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ProduceSLOSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+}
+
+// An objective is met as long as no more requests than the percentile allows
+// are slower than the maximum, and violated after that.
+func (s *ProduceSLOSuite) TestViolated(c *C) {
+	t := New(s.ns, config.ProduceSLOs{Topics: map[string]config.ProduceSLO{
+		"t1": {MaxLatency: 100 * time.Millisecond, Percentile: 90},
+	}})
+	for i := 0; i < 9; i++ {
+		t.Observe("t1", 10*time.Millisecond, 5*time.Millisecond)
+	}
+	t.Observe("t1", 200*time.Millisecond, 5*time.Millisecond)
+	c.Check(t.Status("t1").Status, Equals, StatusMet)
+
+	// When
+	t.Observe("t1", 200*time.Millisecond, 5*time.Millisecond)
+
+	// Then
+	status := t.Status("t1")
+	c.Check(status.Status, Equals, StatusViolated)
+	c.Check(status.Requests, Equals, int64(11))
+	c.Check(status.Violations, Equals, int64(2))
+}
+
+// A violation is blamed on Kafka only if the message spent longer than the
+// maximum in Kafka alone.
+func (s *ProduceSLOSuite) TestBlame(c *C) {
+	t := New(s.ns, config.ProduceSLOs{Topics: map[string]config.ProduceSLO{
+		"t1": {MaxLatency: 100 * time.Millisecond},
+	}})
+
+	// When
+	t.Observe("t1", 200*time.Millisecond, 150*time.Millisecond)
+	t.Observe("t1", 200*time.Millisecond, 50*time.Millisecond)
+	t.Observe("t1", 300*time.Millisecond, 50*time.Millisecond)
+	t.Observe("t1", 50*time.Millisecond, 40*time.Millisecond)
+
+	// Then
+	status := t.Status("t1")
+	c.Check(status.Violations, Equals, int64(3))
+	c.Check(status.KafkaViolations, Equals, int64(1))
+	c.Check(status.ProxyViolations, Equals, int64(2))
+}
+
+// Percentiles are estimated as upper bounds of the buckets they fall into.
+func (s *ProduceSLOSuite) TestPercentiles(c *C) {
+	t := New(s.ns, config.ProduceSLOs{Topics: map[string]config.ProduceSLO{
+		"t1": {MaxLatency: time.Second},
+	}})
+
+	// When
+	for i := 0; i < 99; i++ {
+		t.Observe("t1", 10*time.Millisecond, time.Millisecond)
+	}
+	t.Observe("t1", 500*time.Millisecond, 400*time.Millisecond)
+
+	// Then
+	status := t.Status("t1")
+	c.Check(status.Percentile, Equals, float64(99))
+	c.Check(status.Latency >= 10*time.Millisecond, Equals, true, Commentf("%v", status.Latency))
+	c.Check(status.Latency < 13*time.Millisecond, Equals, true, Commentf("%v", status.Latency))
+	c.Check(status.KafkaLatency >= time.Millisecond, Equals, true, Commentf("%v", status.KafkaLatency))
+	c.Check(status.KafkaLatency < 2*time.Millisecond, Equals, true, Commentf("%v", status.KafkaLatency))
+}
+
+// Latencies above the largest bucket bound are estimated as that bound.
+func (s *ProduceSLOSuite) TestPercentileOverflow(c *C) {
+	t := New(s.ns, config.ProduceSLOs{Topics: map[string]config.ProduceSLO{
+		"t1": {MaxLatency: time.Second},
+	}})
+
+	// When
+	t.Observe("t1", time.Hour, time.Hour)
+
+	// Then
+	c.Check(t.Status("t1").Latency, Equals, bucketBounds[len(bucketBounds)-1])
+}
+
+// Requests to topics with no objective are ignored.
+func (s *ProduceSLOSuite) TestNoObjective(c *C) {
+	t := New(s.ns, config.ProduceSLOs{Topics: map[string]config.ProduceSLO{
+		"t1": {MaxLatency: time.Second},
+		"t2": {MaxLatency: time.Second},
+	}})
+
+	// When
+	t.Observe("t3", time.Hour, time.Hour)
+
+	// Then
+	c.Check(t.Status("t3"), DeepEquals, Status{Topic: "t3"})
+	statuses := t.Statuses()
+	c.Assert(len(statuses), Equals, 2)
+	c.Check(statuses[0].Topic, Equals, "t1")
+	c.Check(statuses[0].Requests, Equals, int64(0))
+	c.Check(statuses[0].Status, Equals, StatusMet)
+	c.Check(statuses[1].Topic, Equals, "t2")
+}
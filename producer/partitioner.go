@@ -0,0 +1,64 @@
+package producer
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// PartitionedKey is a message key that is written to Kafka as is, but that
+// does not determine the partition of the message. The message is assigned a
+// partition by PartitionKey instead, the same way it would be by a key, e.g.
+// to partition by tenant while keying by entity ID. If Key is nil, then the
+// message has no key.
+type PartitionedKey struct {
+	Key          sarama.Encoder
+	PartitionKey sarama.Encoder
+}
+
+// Encode implements sarama.Encoder.
+func (pk PartitionedKey) Encode() ([]byte, error) {
+	if pk.Key == nil {
+		return nil, nil
+	}
+	return pk.Key.Encode()
+}
+
+// Length implements sarama.Encoder.
+func (pk PartitionedKey) Length() int {
+	if pk.Key == nil {
+		return 0
+	}
+	return pk.Key.Length()
+}
+
+// WithPartitionKey returns a key that makes a message with the specified key
+// assigned a partition by partitionKey. If partitionKey is nil, then key is
+// returned as is.
+func WithPartitionKey(key, partitionKey sarama.Encoder) sarama.Encoder {
+	if partitionKey == nil {
+		return key
+	}
+	return PartitionedKey{Key: key, PartitionKey: partitionKey}
+}
+
+// partitionKeyAware wraps a partitioner constructor so that messages with a
+// PartitionedKey are partitioned by its partition key.
+func partitionKeyAware(newPartitioner sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &partitioner{newPartitioner(topic)}
+	}
+}
+
+type partitioner struct {
+	sarama.Partitioner
+}
+
+// Partition implements sarama.Partitioner.
+func (p *partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	pk, ok := msg.Key.(PartitionedKey)
+	if !ok {
+		return p.Partitioner.Partition(msg, numPartitions)
+	}
+	byPartitionKey := *msg
+	byPartitionKey.Key = pk.PartitionKey
+	return p.Partitioner.Partition(&byPartitionKey, numPartitions)
+}
@@ -0,0 +1,69 @@
+package producer
+
+import (
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+type PartitionerSuite struct{}
+
+var _ = Suite(&PartitionerSuite{})
+
+// Messages with a partitioned key land in the same partitions as messages
+// keyed by their partition keys.
+func (s *PartitionerSuite) TestPartitionKey(c *C) {
+	p := partitionKeyAware(sarama.NewHashPartitioner)("foo")
+	for i, tc := range []struct {
+		key          sarama.Encoder
+		partitionKey string
+	}{
+		{key: sarama.StringEncoder("bar"), partitionKey: "bazz"},
+		{key: sarama.StringEncoder("bazz"), partitionKey: "bar"},
+		{key: nil, partitionKey: "bar"},
+		{key: sarama.StringEncoder(""), partitionKey: "blah"},
+	} {
+		expected, err := p.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(tc.partitionKey)}, 1000)
+		c.Assert(err, IsNil)
+
+		// When
+		actual, err := p.Partition(&sarama.ProducerMessage{
+			Key: WithPartitionKey(tc.key, sarama.StringEncoder(tc.partitionKey)),
+		}, 1000)
+
+		// Then
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(actual, Equals, expected, Commentf("case #%d", i))
+	}
+}
+
+// A partitioned key is written to Kafka as the key it wraps.
+func (s *PartitionerSuite) TestPartitionedKeyEncode(c *C) {
+	for i, tc := range []struct {
+		key      sarama.Encoder
+		expected []byte
+	}{
+		{key: sarama.StringEncoder("bar"), expected: []byte("bar")},
+		{key: sarama.StringEncoder(""), expected: []byte{}},
+		{key: nil, expected: nil},
+	} {
+		// When
+		pk := WithPartitionKey(tc.key, sarama.StringEncoder("bazz"))
+
+		// Then
+		encoded, err := pk.Encode()
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(encoded, DeepEquals, tc.expected, Commentf("case #%d", i))
+		c.Check(pk.Length(), Equals, len(tc.expected), Commentf("case #%d", i))
+	}
+}
+
+// Without a partition key a key is used as is.
+func (s *PartitionerSuite) TestNoPartitionKey(c *C) {
+	key := sarama.StringEncoder("bar")
+
+	// When
+	pk := WithPartitionKey(key, nil)
+
+	// Then
+	c.Check(pk, Equals, key)
+}
@@ -3,16 +3,20 @@ package producer
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/deadletter"
+	"github.com/mailgun/kafka-pixy/topology"
 	"github.com/pkg/errors"
 )
 
 const (
 	maxEncoderReprLength = 4096
+	flushCheckInterval   = 10 * time.Millisecond
 )
 
 // T builds on top of `sarama.AsyncProducer` to improve the shutdown handling.
@@ -20,9 +24,8 @@ const (
 // messages as soon as it is ordered to shutdown. On the contrary, when `T` is
 // ordered to stop it allows some time for the buffered messages to be
 // committed to the Kafka cluster, and only when that time has elapsed it drops
-// uncommitted messages.
-//
-// TODO Consider implementing some sort of dead message processing.
+// uncommitted messages. Messages that failed to be committed are passed to a
+// dead letter sink, if one is configured.
 type T struct {
 	mergActDesc     *actor.Descriptor
 	dispActDesc     *actor.Descriptor
@@ -31,8 +34,16 @@ type T struct {
 	shutdownTimeout time.Duration
 	dispatcherCh    chan *sarama.ProducerMessage
 	responseCh      chan Response
+	deadLetters     *deadletter.T
+	unsubscribe     func()
 	wg              sync.WaitGroup
 
+	// Updated atomically by the dispatcher goroutine.
+	pendingMsgs   int64
+	pendingBytes  int64
+	succeededMsgs int64
+	failedMsgs    int64
+
 	// To be used in tests only
 	testDroppedMsgCh chan<- *sarama.ProducerMessage
 }
@@ -40,13 +51,97 @@ type T struct {
 type Response struct {
 	Msg *sarama.ProducerMessage
 	Err error
+
+	// Time from when the message was handed over to the Kafka client until
+	// it was either acknowledged or failed, including retries made by the
+	// client. The rest of the produce latency is spent in Kafka-Pixy.
+	KafkaLatency time.Duration
+}
+
+// pendingMsg is the metadata of a message submitted to the Kafka client.
+type pendingMsg struct {
+	responseCh chan Response
+	sentAt     time.Time
+}
+
+// Stats describes occupancy of the producer buffers.
+type Stats struct {
+	// Messages waiting to be passed to the Kafka client.
+	QueuedMsgs int
+
+	// Messages passed to the Kafka client, that have been neither
+	// acknowledged by Kafka nor failed yet. It includes messages that the
+	// client is retrying to send.
+	PendingMsgs  int64
+	PendingBytes int64
+
+	// Messages acknowledged by Kafka and failed since the producer started.
+	SucceededMsgs int64
+	FailedMsgs    int64
+
+	// Failed messages that were written to the dead letter sink, and that
+	// failed to be written there and were lost.
+	DeadLetteredMsgs     int64
+	DeadLetterFailedMsgs int64
+}
+
+// FlushResult describes the outcome of a flush.
+type FlushResult struct {
+	// True if the producer buffers got empty before the timeout.
+	Flushed bool
+
+	// Messages acknowledged by Kafka and failed while flushing.
+	SucceededMsgs int64
+	FailedMsgs    int64
+
+	// Occupancy of the producer buffers when the flush completed.
+	Stats Stats
+}
+
+// Add returns the sum of the stats of two producers that share a dead
+// letter sink, therefore dead letter counters are not summed up.
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		QueuedMsgs:           s.QueuedMsgs + other.QueuedMsgs,
+		PendingMsgs:          s.PendingMsgs + other.PendingMsgs,
+		PendingBytes:         s.PendingBytes + other.PendingBytes,
+		SucceededMsgs:        s.SucceededMsgs + other.SucceededMsgs,
+		FailedMsgs:           s.FailedMsgs + other.FailedMsgs,
+		DeadLetteredMsgs:     s.DeadLetteredMsgs,
+		DeadLetterFailedMsgs: s.DeadLetterFailedMsgs,
+	}
+}
+
+// Add returns the combined outcome of flushing two producers.
+func (r FlushResult) Add(other FlushResult) FlushResult {
+	return FlushResult{
+		Flushed:       r.Flushed && other.Flushed,
+		SucceededMsgs: r.SucceededMsgs + other.SucceededMsgs,
+		FailedMsgs:    r.FailedMsgs + other.FailedMsgs,
+		Stats:         r.Stats.Add(other.Stats),
+	}
 }
 
 // Spawn creates a producer instance and starts its internal goroutines.
-func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
+// Messages that fail to be produced are put to `deadLetters`, unless it is nil.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, deadLetters *deadletter.T) (*T, error) {
+	return spawn(parentActDesc, cfg, cfg.SaramaProducerCfg(), deadLetters)
+}
+
+// SpawnStrictlyOrdered is like Spawn, but the producer keeps at most one
+// request in flight to a broker. That guarantees that messages produced to a
+// partition are written in the order they were submitted even if some of
+// them have to be retried, at the expense of throughput.
+func SpawnStrictlyOrdered(parentActDesc *actor.Descriptor, cfg *config.Proxy, deadLetters *deadletter.T) (*T, error) {
 	saramaCfg := cfg.SaramaProducerCfg()
+	saramaCfg.Net.MaxOpenRequests = 1
+	return spawn(parentActDesc.NewChild("strict"), cfg, saramaCfg, deadLetters)
+}
+
+func spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, saramaCfg *sarama.Config, deadLetters *deadletter.T) (*T, error) {
 	saramaCfg.Producer.Return.Successes = true
 	saramaCfg.Producer.Return.Errors = true
+	saramaCfg.Producer.Partitioner = partitionKeyAware(saramaCfg.Producer.Partitioner)
 
 	saramaClient, err := sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg)
 	if err != nil {
@@ -65,7 +160,11 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 		shutdownTimeout: cfg.Producer.ShutdownTimeout,
 		dispatcherCh:    make(chan *sarama.ProducerMessage, cfg.Producer.ChannelBufferSize),
 		responseCh:      make(chan Response, cfg.Producer.ChannelBufferSize),
+		deadLetters:     deadLetters,
 	}
+	p.dispActDesc.ObserveQueue("queued", func() int { return len(p.dispatcherCh) })
+	p.dispActDesc.ObserveQueue("pending", func() int { return int(atomic.LoadInt64(&p.pendingMsgs)) })
+	p.unsubscribe = topology.Subscribe(cfg.Cluster, p.onTopologyChange)
 	actor.Spawn(p.mergActDesc, &p.wg, p.runMerger)
 	actor.Spawn(p.dispActDesc, &p.wg, p.runDispatcher)
 	return p, nil
@@ -73,15 +172,35 @@ func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
 
 // Stop shuts down all producer goroutines and releases all resources.
 func (p *T) Stop() {
+	p.unsubscribe()
 	close(p.dispatcherCh)
 	p.wg.Wait()
 }
 
+// onTopologyChange refreshes metadata of topics with partitions that got a
+// new leader, so that partition producers started from now on go to the new
+// leaders straight away. Partition producers that are already running switch
+// to a new leader when the former one rejects a message, for sarama does not
+// allow to reroute them.
+func (p *T) onTopologyChange(change topology.Change) {
+	if len(change.MovedPartitions) == 0 {
+		return
+	}
+	topics := make([]string, 0, len(change.MovedPartitions))
+	for topic := range change.MovedPartitions {
+		topics = append(topics, topic)
+	}
+	if err := p.saramaClient.RefreshMetadata(topics...); err != nil {
+		p.dispActDesc.Log().WithError(err).Warnf("Failed to refresh metadata: topics=%v", topics)
+	}
+}
+
 // Produce submits a message to the specified `topic` of the Kafka cluster
 // using `key` to identify a destination partition. The exact algorithm used to
 // map keys to partitions is implementation specific but it is guaranteed that
 // it returns consistent results. If `key` is `nil`, then the message is placed
-// into a random partition.
+// into a random partition. If `key` is a PartitionedKey, then its partition
+// key identifies the destination partition.
 //
 // Errors usually indicate a catastrophic failure of the Kafka cluster, or
 // missing topic if there cluster is not configured to auto create topics.
@@ -99,12 +218,58 @@ func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sa
 		Key:      key,
 		Value:    message,
 		Headers:  headers,
-		Metadata: responseCh,
+		Metadata: &pendingMsg{responseCh: responseCh},
 	}
 	p.dispatcherCh <- prodMsg
 	return responseCh
 }
 
+// Stats returns current occupancy of the producer buffers.
+func (p *T) Stats() Stats {
+	stats := Stats{
+		QueuedMsgs:    len(p.dispatcherCh),
+		PendingMsgs:   atomic.LoadInt64(&p.pendingMsgs),
+		PendingBytes:  atomic.LoadInt64(&p.pendingBytes),
+		SucceededMsgs: atomic.LoadInt64(&p.succeededMsgs),
+		FailedMsgs:    atomic.LoadInt64(&p.failedMsgs),
+	}
+	if p.deadLetters != nil {
+		deadLetterStats := p.deadLetters.Stats()
+		stats.DeadLetteredMsgs = deadLetterStats.WrittenMsgs
+		stats.DeadLetterFailedMsgs = deadLetterStats.FailedMsgs
+	}
+	return stats
+}
+
+// Flush waits until the producer buffers are empty, that is all messages
+// produced so far, and while waiting, are either acknowledged by Kafka or
+// failed, or until the timeout elapses. Messages are sent to Kafka at most
+// `producer.flush_frequency` after they were produced, so the timeout should
+// be larger than that.
+func (p *T) Flush(timeout time.Duration) FlushResult {
+	before := p.Stats()
+	timeoutCh := time.After(timeout)
+	checkTicker := time.NewTicker(flushCheckInterval)
+	defer checkTicker.Stop()
+	for {
+		stats := p.Stats()
+		flushed := stats.QueuedMsgs == 0 && stats.PendingMsgs == 0
+		if !flushed {
+			select {
+			case <-checkTicker.C:
+				continue
+			case <-timeoutCh:
+			}
+		}
+		return FlushResult{
+			Flushed:       flushed,
+			SucceededMsgs: stats.SucceededMsgs - before.SucceededMsgs,
+			FailedMsgs:    stats.FailedMsgs - before.FailedMsgs,
+			Stats:         stats,
+		}
+	}
+}
+
 // merge receives both message acknowledgements and producer errors from the
 // respective `sarama.AsyncProducer` channels, constructs `ProducerResult`s out
 // of them and sends the constructed `ProducerResult` instances to `responseCh`
@@ -163,9 +328,14 @@ func (p *T) runDispatcher() {
 				goto gracefulShutdown
 			}
 			pendingMsgCount += 1
+			atomic.AddInt64(&p.pendingMsgs, 1)
+			atomic.AddInt64(&p.pendingBytes, msgSize(prodMsg))
 			nilOrDispatcherCh = nil
 			nilOrProdInputCh = p.saramaProducer.Input()
 		case nilOrProdInputCh <- prodMsg:
+			if pm, ok := prodMsg.Metadata.(*pendingMsg); ok {
+				pm.sentAt = time.Now()
+			}
 			nilOrDispatcherCh = p.dispatcherCh
 			nilOrProdInputCh = nil
 		case prodResult := <-p.responseCh:
@@ -195,10 +365,18 @@ shutdownNow:
 }
 
 // handleProduceResult inspects a production results and if it is an error
-// then logs it.
+// then logs it and puts the message to the dead letter sink.
 func (p *T) handleProduceResult(result Response) {
-	if replyCh, ok := result.Msg.Metadata.(chan Response); ok {
-		replyCh <- result
+	atomic.AddInt64(&p.pendingMsgs, -1)
+	atomic.AddInt64(&p.pendingBytes, -msgSize(result.Msg))
+	if result.Err == nil {
+		atomic.AddInt64(&p.succeededMsgs, 1)
+	} else {
+		atomic.AddInt64(&p.failedMsgs, 1)
+	}
+	if pm, ok := result.Msg.Metadata.(*pendingMsg); ok {
+		result.KafkaLatency = time.Since(pm.sentAt)
+		pm.responseCh <- result
 	}
 	if result.Err == nil {
 		return
@@ -206,11 +384,26 @@ func (p *T) handleProduceResult(result Response) {
 	prodMsgRepr := fmt.Sprintf(`{Topic: "%s", Key: "%s", Value: "%s"}`,
 		result.Msg.Topic, encoderRepr(result.Msg.Key), encoderRepr(result.Msg.Value))
 	p.dispActDesc.Log().WithError(result.Err).Errorf("Failed to submit message: msg=%v", prodMsgRepr)
+	if p.deadLetters != nil {
+		p.deadLetters.Put(result.Msg, result.Err)
+	}
 	if p.testDroppedMsgCh != nil {
 		p.testDroppedMsgCh <- result.Msg
 	}
 }
 
+// msgSize returns the size of a message key and value in bytes.
+func msgSize(msg *sarama.ProducerMessage) int64 {
+	var size int
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	return int64(size)
+}
+
 // encoderRepr returns the string representation of an encoder value. The value
 // is truncated to `maxEncoderReprLength`.
 func encoderRepr(e sarama.Encoder) string {
@@ -45,7 +45,7 @@ func (s *ProducerSuite) TearDownTest(c *C) {
 // A started client can be stopped.
 func (s *ProducerSuite) TestStartAndStop(c *C) {
 	// Given
-	p, err := Spawn(s.ns, s.cfg)
+	p, err := Spawn(s.ns, s.cfg, nil)
 	c.Assert(err, IsNil)
 	c.Assert(p, NotNil)
 	// When
@@ -53,7 +53,7 @@ func (s *ProducerSuite) TestStartAndStop(c *C) {
 }
 
 func (s *ProducerSuite) TestProduce(c *C) {
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
 	// When
@@ -73,7 +73,7 @@ func (s *ProducerSuite) TestProduceHeaders(c *C) {
 		c.Skip("headers not supported before Kafka 0.11")
 	}
 
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
 	// When
@@ -91,7 +91,7 @@ func (s *ProducerSuite) TestProduceHeaders(c *C) {
 }
 
 func (s *ProducerSuite) TestProduceInvalidTopic(c *C) {
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 
 	// When
 	_, err := p.Produce("no-such-topic", sarama.StringEncoder("1"), sarama.StringEncoder("Foo"), nil)
@@ -106,7 +106,7 @@ func (s *ProducerSuite) TestProduceInvalidTopic(c *C) {
 // If `key` is not `nil` then produced messages are deterministically
 // distributed between partitions based on the `key` hash.
 func (s *ProducerSuite) TestAsyncProduce(c *C) {
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	p.testDroppedMsgCh = s.droppedMsgCh
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -133,7 +133,7 @@ func (s *ProducerSuite) TestAsyncProduce(c *C) {
 // partition. Therefore a batch of such messages is evenly distributed among
 // all available partitions.
 func (s *ProducerSuite) TestAsyncProduceNilKey(c *C) {
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	p.testDroppedMsgCh = s.droppedMsgCh
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -158,7 +158,7 @@ func (s *ProducerSuite) TestAsyncProduceNilKey(c *C) {
 // because none of them are retries. This test is mostly to increase coverage.
 func (s *ProducerSuite) TestTooSmallShutdownTimeout(c *C) {
 	s.cfg.Producer.ShutdownTimeout = 0
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	p.testDroppedMsgCh = s.droppedMsgCh
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -182,7 +182,7 @@ func (s *ProducerSuite) TestTooSmallShutdownTimeout(c *C) {
 // If `key` of a produced message is empty then it is deterministically
 // submitted to a particular partition determined by the empty key hash.
 func (s *ProducerSuite) TestAsyncProduceEmptyKey(c *C) {
-	p, _ := Spawn(s.ns, s.cfg)
+	p, _ := Spawn(s.ns, s.cfg, nil)
 	p.testDroppedMsgCh = s.droppedMsgCh
 	offsetsBefore := s.kh.GetNewestOffsets("test.4")
 
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/archiver"
+	"github.com/pkg/errors"
+)
+
+// ErrBadReplay is returned when a replay offset range or rate is invalid.
+var ErrBadReplay = errors.New("bad replay: offsets and rate must be >= 0, and the end offset, if any, must be greater than the start one")
+
+// GetArchiveStatuses returns statuses of all configured topic archives
+// sorted by name.
+func (p *T) GetArchiveStatuses() ([]archiver.Status, error) {
+	if len(p.archives) == 0 {
+		return nil, ErrDisabled
+	}
+	return archiver.Statuses(p.archives), nil
+}
+
+// StartArchiveReplay starts producing messages of an archive to a topic of
+// the target cluster, which can be the cluster of the proxy itself. If the
+// topic is empty, then messages are produced to the archived topic. Messages
+// with offsets from `fromOffset` inclusive to `toOffset` exclusive are
+// replayed, zero `toOffset` means all messages from `fromOffset` on. Zero
+// `rate` means that messages are produced as fast as possible.
+func (p *T) StartArchiveReplay(archive string, target *T, topic string, fromOffset, toOffset int64, rate int,
+) (archiver.Replay, error) {
+	a, err := p.getArchive(archive)
+	if err != nil {
+		return archiver.Replay{}, err
+	}
+	if fromOffset < 0 || toOffset < 0 || (toOffset > 0 && toOffset <= fromOffset) || rate < 0 {
+		return archiver.Replay{}, ErrBadReplay
+	}
+	if topic == "" {
+		topic = a.Status().Topic
+	}
+	if target.IsReadOnly() {
+		return archiver.Replay{}, ErrReadOnly
+	}
+	if !target.topicFilter.allows(topic) {
+		return archiver.Replay{}, ErrTopicNotAllowed
+	}
+	return a.StartReplay(archiver.ReplayRequest{
+		Topic:      topic,
+		Cluster:    target.cfg.Cluster,
+		TargetCfg:  target.cfg,
+		FromOffset: fromOffset,
+		ToOffset:   toOffset,
+		Rate:       rate,
+	}), nil
+}
+
+// GetArchiveReplays returns progress of all replays of an archive since
+// start.
+func (p *T) GetArchiveReplays(archive string) ([]archiver.Replay, error) {
+	a, err := p.getArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	return a.Replays(), nil
+}
+
+// GetArchiveReplay returns the progress of a replay of an archive.
+func (p *T) GetArchiveReplay(archive, id string) (archiver.Replay, error) {
+	a, err := p.getArchive(archive)
+	if err != nil {
+		return archiver.Replay{}, err
+	}
+	return a.Replay(id)
+}
+
+// CancelArchiveReplay stops a running replay of an archive.
+func (p *T) CancelArchiveReplay(archive, id string) error {
+	a, err := p.getArchive(archive)
+	if err != nil {
+		return err
+	}
+	return a.CancelReplay(id)
+}
+
+func (p *T) getArchive(name string) (*archiver.T, error) {
+	if len(p.archives) == 0 {
+		return nil, ErrDisabled
+	}
+	for _, a := range p.archives {
+		if a.Name() == name {
+			return a, nil
+		}
+	}
+	return nil, archiver.ErrNotFound
+}
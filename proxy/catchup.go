@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/none"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCatchUpMaxKeys = 1000000
+
+// catchUp is the latest per key catch-up state of a group-topic-partition.
+// It is created when the group consumes the first message from the partition
+// via this proxy instance, and created anew every time the partition is
+// claimed again.
+type catchUp struct {
+	// The events channel of the partition claim that the catch-up is for.
+	eventsCh chan<- consumer.Event
+	// Closed to stop building the index.
+	stopCh chan none.T
+	// Closed when the index is built.
+	readyCh chan none.T
+	// Messages at offsets lower than end are delivered only if they are the
+	// latest for their keys. Messages at end and beyond are delivered as is.
+	end int64
+	// Offset of the latest message of every key in the catch-up range.
+	latest map[string]int64
+}
+
+// waitCatchUp waits for the catch-up index of the partition of a message to
+// be built. The index is built in the background, so if it is not ready by
+// the deadline, then consumer.ErrRequestTimeout is returned and the message
+// has to be reclaimed, so that it is offered again when the index is ready.
+func (p *T) waitCatchUp(ctx context.Context, group, topic string, msg *consumer.Message, deadline time.Time) error {
+	catchUpCfg, ok := p.cfg.Consumer.CatchUp[group]
+	if !ok {
+		return nil
+	}
+	catchUpID := eventsChID{group, topic, msg.Partition}
+	p.catchUpsMu.Lock()
+	cu := p.catchUps[catchUpID]
+	if cu == nil || cu.eventsCh != msg.EventsCh {
+		if cu != nil {
+			close(cu.stopCh)
+		}
+		cu = &catchUp{eventsCh: msg.EventsCh, stopCh: make(chan none.T), readyCh: make(chan none.T)}
+		p.catchUps[catchUpID] = cu
+		if msg.HighWaterMark-msg.Offset >= catchUpCfg.MinLag {
+			maxKeys := catchUpCfg.MaxKeys
+			if maxKeys <= 0 {
+				maxKeys = defaultCatchUpMaxKeys
+			}
+			begin, end := msg.Offset, msg.HighWaterMark
+			actor.Spawn(p.actDesc.NewChild("catch_up", group, topic, msg.Partition), &p.catchUpWG, func() {
+				end, latest := p.indexLatest(cu.stopCh, group, topic, msg.Partition, begin, end, maxKeys)
+				p.catchUpsMu.Lock()
+				cu.end, cu.latest = end, latest
+				p.catchUpsMu.Unlock()
+				close(cu.readyCh)
+			})
+		} else {
+			close(cu.readyCh)
+		}
+	}
+	p.catchUpsMu.Unlock()
+
+	select {
+	case <-cu.readyCh:
+		return nil
+	default:
+	}
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case <-cu.readyCh:
+		return nil
+	case <-timeout.C:
+		return consumer.ErrRequestTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isSuperseded checks if a message is in the catch-up range of its partition
+// and there is a later message with the same key in the range. Messages
+// without a key are never superseded. It must be called after waitCatchUp
+// has returned nil for the message.
+func (p *T) isSuperseded(group, topic string, msg *consumer.Message) bool {
+	if _, ok := p.cfg.Consumer.CatchUp[group]; !ok {
+		return false
+	}
+	p.catchUpsMu.Lock()
+	defer p.catchUpsMu.Unlock()
+	cu := p.catchUps[eventsChID{group, topic, msg.Partition}]
+	if cu == nil || cu.eventsCh != msg.EventsCh {
+		return false
+	}
+	if msg.Offset >= cu.end {
+		cu.latest = nil // Catch-up is over, free the index.
+		return false
+	}
+	if msg.Key == nil {
+		return false
+	}
+	latest, ok := cu.latest[string(msg.Key)]
+	return ok && latest > msg.Offset
+}
+
+// stopCatchUps stops building catch-up indexes and waits for that to finish.
+func (p *T) stopCatchUps() {
+	p.catchUpsMu.Lock()
+	for catchUpID, cu := range p.catchUps {
+		close(cu.stopCh)
+		delete(p.catchUps, catchUpID)
+	}
+	p.catchUpsMu.Unlock()
+	p.catchUpWG.Wait()
+}
+
+// indexLatest reads a partition from begin to end and returns offsets of the
+// latest messages of all keys. If reading stalls or fails, the index would
+// grow beyond maxKeys, or stopCh is closed, then the index is built for the
+// part that has been read, and the returned end offset reflects that.
+func (p *T) indexLatest(stopCh <-chan none.T, group, topic string, partition int32, begin, end int64, maxKeys int,
+) (int64, map[string]int64) {
+	logEntry := p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":     group,
+		"kafka.topic":     topic,
+		"kafka.partition": partition,
+	})
+	logEntry.Infof("Catch-up started: begin=%d, end=%d", begin, end)
+	kafkaConsumer, err := sarama.NewConsumerFromClient(p.kafkaClt)
+	if err != nil {
+		logEntry.WithError(err).Error("Catch-up failed")
+		return begin, nil
+	}
+	defer kafkaConsumer.Close()
+	pc, err := kafkaConsumer.ConsumePartition(topic, partition, begin)
+	if err != nil {
+		logEntry.WithError(err).Error("Catch-up failed")
+		return begin, nil
+	}
+	defer pc.Close()
+
+	latest := make(map[string]int64)
+	next := begin
+	for next < end {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				logEntry.Errorf("Catch-up failed, limited to offset %d", next)
+				end = next
+				continue
+			}
+			if msg.Key != nil {
+				if _, ok := latest[string(msg.Key)]; !ok && len(latest) >= maxKeys {
+					logEntry.Warnf("Catch-up key limit reached, limited to offset %d", msg.Offset)
+					next, end = msg.Offset, msg.Offset
+					continue
+				}
+				latest[string(msg.Key)] = msg.Offset
+			}
+			next = msg.Offset + 1
+		case <-time.After(p.cfg.Consumer.LongPollingTimeout):
+			logEntry.Warnf("Catch-up stalled, limited to offset %d", next)
+			end = next
+		case <-stopCh:
+			logEntry.Infof("Catch-up stopped, limited to offset %d", next)
+			end = next
+		}
+	}
+	logEntry.Infof("Catch-up indexed: keys=%d", len(latest))
+	return end, latest
+}
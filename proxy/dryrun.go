@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/claimcheck"
+)
+
+// ValidateProduce checks whether a message would be accepted by `Produce`
+// without producing it. The topic must exist, or be allowed to be created
+// by `producer.create_missing_topics`, but it is never created. If the topic
+// has a validation webhook, then the message is posted to it.
+func (p *T) ValidateProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) error {
+	if p.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if !p.topicFilter.allows(topic) {
+		return ErrTopicNotAllowed
+	}
+	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return ErrHeadersUnsupported
+	}
+	p.producerMu.RLock()
+	prod := p.producer
+	p.producerMu.RUnlock()
+	if prod == nil {
+		return ErrUnavailable
+	}
+	if err := p.checkTopic(topic); err != nil {
+		return err
+	}
+	if v := p.validators[topic]; v != nil {
+		if err := p.validate(v, key, message, claimcheck.StripProducedHeader(headers)); err != nil {
+			return err
+		}
+	}
+	// Messages that are offloaded to an object store or split into chunks
+	// never hit the topic size limit.
+	if p.claimCheckStore(topic, message) != nil {
+		return nil
+	}
+	chunks, err := p.split(key, message, headers)
+	if err != nil {
+		return err
+	}
+	if chunks == nil {
+		return p.checkMessageSize(topic, key, message)
+	}
+	return nil
+}
+
+// ValidateConsume checks whether a consume request would be accepted by
+// `ConsumeContext` without consuming a message. Unlike a consume request it
+// neither joins the group, nor counts towards `limits.max_groups`.
+func (p *T) ValidateConsume(group, topic string, ackTimeout time.Duration) error {
+	if p.cfg.Consumer.Disabled {
+		return ErrDisabled
+	}
+	if ackTimeout > p.cfg.MaxAckTimeout() {
+		return ErrAckTimeoutTooLong
+	}
+	if p.isGroupLimitReached(group) {
+		return ErrTooManyGroups
+	}
+	if err := p.checkMigration(group); err != nil {
+		return err
+	}
+	p.consumerMu.RLock()
+	cons := p.consumer
+	p.consumerMu.RUnlock()
+	if cons == nil {
+		return ErrUnavailable
+	}
+	exists, err := p.topicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	return nil
+}
+
+// checkTopic is a side effect free counterpart of `ensureTopic`.
+func (p *T) checkTopic(topic string) error {
+	p.knownTopicsMu.Lock()
+	known := p.knownTopics[topic]
+	p.knownTopicsMu.Unlock()
+	if known {
+		return nil
+	}
+	exists, err := p.topicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists && !p.cfg.Producer.CreateMissingTopics {
+		return ErrUnknownTopic
+	}
+	return nil
+}
+
+// isGroupLimitReached tells whether a consume request of the group would be
+// rejected by `checkGroupLimit`.
+func (p *T) isGroupLimitReached(group string) bool {
+	if p.cfg.Limits.MaxGroups <= 0 {
+		return false
+	}
+	p.groupsMu.Lock()
+	defer p.groupsMu.Unlock()
+	if _, ok := p.groups[group]; ok {
+		return false
+	}
+	now := time.Now()
+	active := 0
+	for _, lastSeen := range p.groups {
+		if now.Sub(lastSeen) <= p.cfg.Consumer.SubscriptionTimeout {
+			active++
+		}
+	}
+	return active >= p.cfg.Limits.MaxGroups
+}
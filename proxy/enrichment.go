@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/tenancy"
+)
+
+// enricher stamps headers configured in `producer.enrichment` onto produced
+// messages. Headers are prepared on spawn in key order, so that messages get
+// them in the same order every time.
+type enricher struct {
+	common         []sarama.RecordHeader
+	byTopic        map[string][]sarama.RecordHeader
+	producerHeader string
+	override       bool
+}
+
+func newEnricher(cfg *config.Proxy) *enricher {
+	enrichCfg := &cfg.Producer.Enrichment
+	if len(enrichCfg.Headers) == 0 && len(enrichCfg.Topics) == 0 && enrichCfg.ProducerHeader == "" {
+		return nil
+	}
+	e := &enricher{
+		common:         toSortedHeaders(enrichCfg.Headers, nil),
+		byTopic:        make(map[string][]sarama.RecordHeader, len(enrichCfg.Topics)),
+		producerHeader: enrichCfg.ProducerHeader,
+		override:       enrichCfg.Override,
+	}
+	for topic, topicHeaders := range enrichCfg.Topics {
+		e.byTopic[topic] = toSortedHeaders(topicHeaders, enrichCfg.Headers)
+	}
+	return e
+}
+
+// toSortedHeaders returns record headers with values from the first map
+// overlaying values from the second one, sorted by key.
+func toSortedHeaders(values, defaults map[string]string) []sarama.RecordHeader {
+	merged := make(map[string]string, len(values)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	headers := make([]sarama.RecordHeader, 0, len(merged))
+	for k, v := range merged {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		return string(headers[i].Key) < string(headers[j].Key)
+	})
+	return headers
+}
+
+func (e *enricher) enrich(headers []sarama.RecordHeader, topic string, tenant *tenancy.Tenant) []sarama.RecordHeader {
+	stamps, ok := e.byTopic[topic]
+	if !ok {
+		stamps = e.common
+	}
+	if e.producerHeader != "" && tenant != nil {
+		stamps = append(stamps[:len(stamps):len(stamps)], sarama.RecordHeader{
+			Key:   []byte(e.producerHeader),
+			Value: []byte(tenant.Name),
+		})
+	}
+	if len(stamps) == 0 {
+		return headers
+	}
+	enriched := make([]sarama.RecordHeader, 0, len(headers)+len(stamps))
+	for _, h := range headers {
+		if e.override && hasHeader(stamps, h.Key) {
+			continue
+		}
+		enriched = append(enriched, h)
+	}
+	for _, stamp := range stamps {
+		if !e.override && hasHeader(headers, stamp.Key) {
+			continue
+		}
+		enriched = append(enriched, stamp)
+	}
+	return enriched
+}
+
+func hasHeader(headers []sarama.RecordHeader, key []byte) bool {
+	for _, h := range headers {
+		if string(h.Key) == string(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// produceLifecycleEvents produces lifecycle events of the cluster to the
+// configured topic until the subscription is closed. Events that occur while
+// the producer is being stopped are skipped.
+func (p *T) produceLifecycleEvents() {
+	topic := p.cfg.LifecycleEvents.Topic
+	for ev := range p.lifecycleSub.Events() {
+		if ev.Cluster != p.cfg.Cluster {
+			continue
+		}
+		encodedEv, err := json.Marshal(ev)
+		if err != nil {
+			p.actDesc.Log().WithError(err).Errorf("Failed to encode lifecycle event: %v", ev)
+			continue
+		}
+		p.producerMu.RLock()
+		if p.producer != nil {
+			p.producer.AsyncProduce(topic, sarama.StringEncoder(ev.Group), sarama.ByteEncoder(encodedEv), nil)
+		}
+		p.producerMu.RUnlock()
+	}
+}
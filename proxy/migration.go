@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/migration"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrGroupMigrating = errors.New("the group is being migrated, messages are not offered until the migration is completed (GET /groups/<group>/migration)")
+	ErrGroupMigrated  = errors.New("the group has been migrated to another group name (GET /groups/<group>/migration)")
+	ErrGroupActive    = errors.New("the group still has members, retry once they stop consuming and their subscriptions expire")
+	ErrBadMigration   = errors.New("a migration needs topics and exactly one target: either `kafka` backend, if offsets are kept in an offset store, or another group")
+	ErrMigrationInUse = errors.New("the group is migrated to Kafka, the migration cannot be deleted until `offset_storage.backend` is changed to `kafka` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+)
+
+// StartGroupMigration starts migrating a consumer group either to Kafka
+// offset storage or to another group name. Until the migration is completed,
+// messages are not offered to the group on any Kafka-Pixy instance working
+// with the cluster. See the migration package for details.
+func (p *T) StartGroupMigration(group, toBackend, toGroup string, topics []string) (migration.Migration, error) {
+	if p.IsReadOnly() {
+		return migration.Migration{}, ErrReadOnly
+	}
+	if p.migrations == nil {
+		return migration.Migration{}, ErrDisabled
+	}
+	if len(topics) == 0 || (toBackend == "") == (toGroup == "") || toGroup == group {
+		return migration.Migration{}, ErrBadMigration
+	}
+	if toBackend != "" && (toBackend != config.OffsetStorageKafka || p.offsetRouter == nil) {
+		return migration.Migration{}, ErrBadMigration
+	}
+	return p.migrations.Start(group, migration.Migration{ToBackend: toBackend, ToGroup: toGroup, Topics: topics})
+}
+
+// CompleteGroupMigration copies offsets committed by a consumer group to the
+// migration target and completes the migration. The group must have no
+// members, that is all its members must have stopped consuming since the
+// migration was started. It returns the completed migration along with the
+// copied offsets mapped to topics.
+func (p *T) CompleteGroupMigration(group string) (migration.Migration, map[string][]admin.PartitionOffset, error) {
+	if p.IsReadOnly() {
+		return migration.Migration{}, nil, ErrReadOnly
+	}
+	if p.migrations == nil {
+		return migration.Migration{}, nil, ErrDisabled
+	}
+	m, version, err := p.migrations.Get(group)
+	if err != nil {
+		return migration.Migration{}, nil, err
+	}
+	if m.Phase != migration.PhasePaused {
+		return migration.Migration{}, nil, migration.ErrCompleted
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return migration.Migration{}, nil, ErrUnavailable
+	}
+	members, err := p.admin.GetGroupMembers(group)
+	if err != nil {
+		return migration.Migration{}, nil, err
+	}
+	if len(members) > 0 {
+		return migration.Migration{}, nil, ErrGroupActive
+	}
+	copied := make(map[string][]admin.PartitionOffset, len(m.Topics))
+	for _, topic := range m.Topics {
+		var offsets []admin.PartitionOffset
+		if m.ToBackend != "" {
+			offsets, err = p.admin.CopyGroupOffsetsToKafka(group, topic)
+		} else {
+			offsets, err = p.admin.CloneGroupOffsets(group, m.ToGroup, topic)
+		}
+		if err != nil {
+			return migration.Migration{}, nil, errors.Wrapf(err, "failed to copy offsets, topic=%s", topic)
+		}
+		copied[topic] = offsets
+	}
+	m, err = p.migrations.Complete(group, m, version)
+	if err != nil {
+		return migration.Migration{}, nil, err
+	}
+	return m, copied, nil
+}
+
+// DeleteGroupMigration deletes the migration of a consumer group. A paused
+// migration is aborted, so that the group resumes consuming as it was before.
+// A completed migration to Kafka cannot be deleted until Kafka-Pixy is
+// configured to keep offsets in Kafka, for the group would go back to its
+// stale offsets in the offset store otherwise.
+func (p *T) DeleteGroupMigration(group string) error {
+	if p.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if p.migrations == nil {
+		return ErrDisabled
+	}
+	m, _, err := p.migrations.Get(group)
+	if err != nil {
+		return err
+	}
+	if m.Phase == migration.PhaseCompleted && m.ToBackend != "" && p.offsetRouter != nil {
+		return ErrMigrationInUse
+	}
+	return p.migrations.Delete(group)
+}
+
+// GetGroupMigration returns the migration of a consumer group, or
+// migration.ErrNotFound if the group is not being migrated.
+func (p *T) GetGroupMigration(group string) (migration.Migration, error) {
+	if p.migrations == nil {
+		return migration.Migration{}, ErrDisabled
+	}
+	m, _, err := p.migrations.Get(group)
+	return m, err
+}
+
+// checkMigration returns an error if messages should not be offered to the
+// group because of a migration.
+func (p *T) checkMigration(group string) error {
+	p.migrationsMu.RLock()
+	defer p.migrationsMu.RUnlock()
+	return p.blockedGroups[group]
+}
+
+// onMigrationsChange is called by the migration watcher every time the
+// migrations of the cluster change.
+func (p *T) onMigrationsChange(migrations map[string]migration.Migration) {
+	kafkaGroups := make(map[string]bool)
+	blockedGroups := make(map[string]error)
+	for group, m := range migrations {
+		switch {
+		case m.Phase == migration.PhasePaused:
+			blockedGroups[group] = ErrGroupMigrating
+			if m.ToGroup != "" {
+				blockedGroups[m.ToGroup] = ErrGroupMigrating
+			}
+		case m.ToGroup != "":
+			blockedGroups[group] = ErrGroupMigrated
+		case m.ToBackend == config.OffsetStorageKafka:
+			kafkaGroups[group] = true
+		}
+	}
+	// Groups are routed to Kafka before they are unblocked, so that offset
+	// managers of migrated groups are never spawned with the offset store.
+	if p.offsetRouter != nil {
+		p.offsetRouter.SetKafkaGroups(kafkaGroups)
+		p.adminMu.RLock()
+		if p.admin != nil {
+			p.admin.SetKafkaGroups(kafkaGroups)
+		}
+		p.adminMu.RUnlock()
+	}
+	p.migrationsMu.Lock()
+	p.blockedGroups = blockedGroups
+	p.migrationsMu.Unlock()
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"github.com/mailgun/kafka-pixy/inflight"
+)
+
+// Pressure tells how saturated the proxy is, so that replicas can be scaled
+// on it rather than on CPU. Every component is the depth of a work queue
+// relative to its capacity, and the overall pressure is the highest of
+// them, so that any queue getting full calls for more replicas.
+type Pressure struct {
+	// The overall pressure, percent.
+	Percent int
+
+	// Messages waiting to be passed to the Kafka client relative to
+	// `producer.channel_buffer_size`.
+	ProduceBuffer PressureComponent
+
+	// Messages offered to clients but not acknowledged yet relative to
+	// `consumer.max_pending_messages` of all partitions consumed at this
+	// instance.
+	OfferQueue PressureComponent
+
+	// Consume requests waiting for a slot relative to
+	// `limits.consume.queue_size`. It is always zero if consume requests
+	// are not limited.
+	UnservedLongPolls PressureComponent
+}
+
+// PressureComponent is a work queue that pressure is calculated from.
+type PressureComponent struct {
+	Depth    int
+	Capacity int
+	Percent  int
+}
+
+func newPressureComponent(depth, capacity int) PressureComponent {
+	pc := PressureComponent{Depth: depth, Capacity: capacity}
+	if capacity > 0 {
+		pc.Percent = depth * 100 / capacity
+	}
+	return pc
+}
+
+// Pressure returns the current pressure of the proxy.
+func (p *T) Pressure() Pressure {
+	var pressure Pressure
+	if stats, err := p.ProducerStats(); err == nil {
+		pressure.ProduceBuffer = newPressureComponent(stats.QueuedMsgs, p.cfg.Producer.ChannelBufferSize)
+	}
+	totals := inflight.GetTotals(p.cfg.Cluster)
+	pressure.OfferQueue = newPressureComponent(totals.UnackedMsgs, totals.Partitions*p.cfg.Consumer.MaxPendingMessages)
+	pressure.UnservedLongPolls = newPressureComponent(p.consumeLimiter.Queued(), p.cfg.Limits.Consume.QueueSize)
+
+	for _, pc := range []PressureComponent{pressure.ProduceBuffer, pressure.OfferQueue, pressure.UnservedLongPolls} {
+		if pc.Percent > pressure.Percent {
+			pressure.Percent = pc.Percent
+		}
+	}
+	return pressure
+}
@@ -1,29 +1,96 @@
 package proxy
 
 import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/apiversion"
+	"github.com/mailgun/kafka-pixy/archiver"
+	"github.com/mailgun/kafka-pixy/autoprof"
+	"github.com/mailgun/kafka-pixy/brokerconn"
+	"github.com/mailgun/kafka-pixy/chunk"
+	"github.com/mailgun/kafka-pixy/claimcheck"
+	"github.com/mailgun/kafka-pixy/codec"
+	"github.com/mailgun/kafka-pixy/commitalert"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/consumerimpl"
+	"github.com/mailgun/kafka-pixy/deadletter"
+	"github.com/mailgun/kafka-pixy/dedup"
+	"github.com/mailgun/kafka-pixy/freeze"
+	"github.com/mailgun/kafka-pixy/gctune"
+	"github.com/mailgun/kafka-pixy/healthprobe"
+	"github.com/mailgun/kafka-pixy/inflight"
+	"github.com/mailgun/kafka-pixy/janitor"
+	"github.com/mailgun/kafka-pixy/lagslo"
+	"github.com/mailgun/kafka-pixy/legacygroups"
+	"github.com/mailgun/kafka-pixy/lifecycle"
+	"github.com/mailgun/kafka-pixy/limiter"
+	"github.com/mailgun/kafka-pixy/maintenance"
+	"github.com/mailgun/kafka-pixy/migration"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/offsetstore"
+	"github.com/mailgun/kafka-pixy/partstats"
+	"github.com/mailgun/kafka-pixy/pipeline"
+	"github.com/mailgun/kafka-pixy/prodslo"
 	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/kafka-pixy/rebalancelog"
+	"github.com/mailgun/kafka-pixy/receipt"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"github.com/mailgun/kafka-pixy/secureconn"
+	"github.com/mailgun/kafka-pixy/shadow"
+	"github.com/mailgun/kafka-pixy/table"
+	"github.com/mailgun/kafka-pixy/tap"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/throttle"
+	"github.com/mailgun/kafka-pixy/throughput"
+	"github.com/mailgun/kafka-pixy/topology"
+	"github.com/mailgun/kafka-pixy/tracectx"
+	"github.com/mailgun/kafka-pixy/validator"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	initEventsChMapCapacity = 256
+
+	// Application metadata is committed along with sparse acks, that all
+	// have to fit in `offset.metadata.max.bytes` of Kafka, 4096 by default.
+	maxAckMetadataSize = 1024
 )
 
 var (
 	ErrUnavailable        = errors.New("service is shutting down")
 	ErrDisabled           = errors.New("service is disabled by configuration")
+	ErrTableNotConfigured = errors.New("table is not configured for the topic")
+	ErrLimitExceeded      = errors.New("too many concurrent requests. Consider increasing `limits` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrTooManyGroups      = errors.New("too many consumer groups. Consider increasing `limits.max_groups` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrUnknownTopic       = errors.New("unknown topic. Consider enabling `producer.create_missing_topics` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrTopicNotAllowed    = errors.New("producing to the topic is not allowed. Consider changing `producer.topic_whitelist` or `producer.topic_blacklist` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrReadOnly           = errors.New("producing and setting offsets are disabled. Consider changing `read_only`, `read_only_listeners` or `maintenance_windows` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
 	ErrHeadersUnsupported = errors.New("headers are not supported with this version of Kafka. Consider changing `kafka.version` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml#L35)")
+	ErrCallbackNotAllowed = errors.New("callback URL is not allowed. Consider changing `producer.callback_url_whitelist` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrConfigUnsupported  = errors.New("topic configuration cannot be described with this version of Kafka. Consider changing `kafka.version` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml#L35)")
+	ErrAckTimeoutTooLong  = errors.New("ack timeout is too long. Consider increasing `consumer.ack_timeout_ceiling` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrMessageTooLarge    = errors.New("message is larger than `max.message.bytes` of the topic. Consider enabling `producer.chunk_size` (https://github.com/mailgun/kafka-pixy/blob/master/default.yaml)")
+	ErrAckMetadataTooLong = errors.New("ack metadata is longer than 1024 bytes")
+	ErrStaleGeneration    = errors.New("ack of a stale group generation, the partition has been claimed again since the message was consumed")
+	ErrEndOfStream        = errors.New("end of stream, the group has consumed all messages currently available in the topic")
+	ErrStopReached        = errors.New("stop point reached, the group has consumed all messages before it")
+	ErrOutOfRange         = errors.New("offsets are out of the partition range")
+	ErrNotConsumedHere    = errors.New("the partition is not consumed by the group at this Kafka-Pixy instance. Consider calling the instance that owns it (GET /groups/<group>/partitions)")
+
+	// The dead letter reason of messages that were not acknowledged within
+	// `consumer.max_processing_time`.
+	errProcessingTimeout = errors.New("processing timeout")
 
 	noAck   = Ack{partition: -1}
 	autoAck = Ack{partition: -2}
@@ -36,25 +103,168 @@ type T struct {
 	kafkaClt   sarama.Client
 	offsetMgrF offsetmgr.Factory
 
+	// Secures connections to brokers, nil if neither TLS nor SASL is
+	// enabled.
+	kafkaCreds *secureconn.T
+
+	// Stamps configured headers onto produced messages, nil if
+	// `producer.enrichment` is not configured.
+	enricher *enricher
+
+	// Routes offset managers of groups migrated to Kafka, nil if offsets are
+	// kept in Kafka.
+	offsetRouter *offsetmgr.RoutingFactory
+
 	adminMu sync.RWMutex
 	admin   *admin.T
 
 	producerMu sync.RWMutex
 	producer   *producer.T
 
+	// Keeps at most one request in flight to a broker, spawned on first use
+	// by a strictly ordered produce request.
+	strictProducerMu sync.Mutex
+	strictProducer   *producer.T
+
+	// Keeps messages that the producer failed to produce, nil if disabled.
+	deadLetters *deadletter.T
+
+	// Copies produced messages to shadow topics, nil if disabled.
+	shadows *shadow.T
+
 	consumerMu sync.RWMutex
 	consumer   consumer.T
 
+	// Freezes committed offsets of consumer groups, nil if the consumer is
+	// disabled.
+	freezes *freeze.T
+
+	// Keeps track of consumer group migrations, nil if the consumer is
+	// disabled. Groups that messages must not be offered to because of a
+	// migration are mapped to the error to reject consume requests with.
+	migrations    *migration.T
+	migrationsMu  sync.RWMutex
+	blockedGroups map[string]error
+
+	// Tables are created on spawn and are never modified afterwards, so
+	// the map does not need to be synchronized.
+	tables map[string]*table.T
+
+	// Pipelines are also created on spawn and only stopped afterwards.
+	pipelines []*pipeline.T
+
+	// Topic archives, created on spawn too.
+	archives []*archiver.T
+
+	// De-duplication windows of consumer groups, also created on spawn.
+	dedupWindows map[string]*dedup.Window
+
+	// Object stores that large values of topics are offloaded to.
+	claimChecks map[string]*claimcheck.Store
+
+	// Validation webhooks of topics, also created on spawn.
+	validators map[string]*validator.T
+
+	catchUpsMu sync.Mutex
+	catchUps   map[eventsChID]*catchUp
+	catchUpWG  sync.WaitGroup
+
+	// Subscription to lifecycle events that are produced to a Kafka topic.
+	lifecycleSub *lifecycle.Subscription
+	lifecycleWG  sync.WaitGroup
+
+	// Posts offset commit failure alerts to a webhook, nil if disabled.
+	commitAlert *commitalert.T
+
+	consumeLimiter *limiter.T
+	produceLimiter *limiter.T
+
+	// Decides what topics can be produced to.
+	topicFilter *topicFilter
+
+	// Posts receipts of asynchronous produce requests to callback URLs.
+	// Goroutines that wait for outcomes to be receipted are tracked by
+	// receiptWG.
+	receiptSender *receipt.Sender
+	receiptWG     sync.WaitGroup
+
+	// Purges idle consumer groups, nil if disabled.
+	janitor *janitor.T
+
+	// Migrates groups that committed offsets to ZooKeeper in the legacy
+	// layout, nil if disabled.
+	legacyGroups *legacygroups.T
+
+	// Evaluates consumer lag objectives of groups, nil if none is configured.
+	lagSLOs *lagslo.T
+
+	// Tracks latency objectives of produce requests to topics, nil if none
+	// is configured.
+	produceSLOs *prodslo.T
+
+	// Pauses consumer groups whose downstream health probes fail, nil if no
+	// probe is configured.
+	healthProbes *healthprobe.T
+
+	// Pauses consumption or makes the cluster read-only during maintenance
+	// windows, nil if no window is configured.
+	maintenance *maintenance.T
+
+	// Counts messages and bytes produced and consumed through the proxy.
+	throughput *throughput.T
+
+	// Watches the cluster for topology changes, nil if disabled.
+	topology *topology.T
+
+	// Topics that are known to exist, so that they do not have to be
+	// checked before producing, and `max.message.bytes` of topics that
+	// messages were checked against.
+	knownTopicsMu   sync.Mutex
+	knownTopics     map[string]bool
+	maxMessageBytes map[string]cachedMaxMessageBytes
+
+	// Time of the last consume request of every group, used to enforce
+	// the limit on the number of groups.
+	groupsMu sync.Mutex
+	groups   map[string]time.Time
+
+	// Reassembles chunks of large messages. Offsets of all chunks that a
+	// message delivered to a client consisted of are kept in chunkOffsets,
+	// to be acknowledged when the message is.
+	assembler      *chunk.Assembler
+	chunkOffsetsMu sync.Mutex
+	chunkOffsets   map[chunkOffsetsID][]int64
+
+	// The number of messages of every topic that were not acknowledged
+	// within `consumer.max_processing_time`.
+	processingTimeoutsMu sync.Mutex
+	processingTimeouts   map[string]int64
+
+	// The number of acks of every topic that were rejected because they
+	// carried a stale group generation.
+	fencedAcksMu sync.Mutex
+	fencedAcks   map[string]int64
+
+	// The number of messages of every topic that were removed by retention
+	// before groups consumed them.
+	dataLossMu sync.Mutex
+	dataLoss   map[string]int64
+
 	// FIXME: We never remove stale elements from eventsChMap. It is sort of ok
 	// FIXME: since the number of group/topic/partition combinations is fairly
 	// FIXME: limited and should not cause any significant system memory usage.
 	eventsChMapMu sync.RWMutex
 	eventsChMap   map[eventsChID]chan<- consumer.Event
+	// The group generation that partitions were claimed in, acks of earlier
+	// generations are fenced.
+	claimGenerations map[eventsChID]int32
 }
 
 type Ack struct {
-	partition int32
-	offset    int64
+	partition  int32
+	offset     int64
+	generation int32
+	metadata   string
 }
 
 // NewAck creates an acknowledgement instance from a partition and an offset.
@@ -67,7 +277,25 @@ func NewAck(partition int32, offset int64) (Ack, error) {
 	if offset < 0 {
 		return Ack{}, errors.Errorf("bad offset: %d", offset)
 	}
-	return Ack{partition, offset}, nil
+	return Ack{partition: partition, offset: offset}, nil
+}
+
+// WithGeneration returns a copy of the ack that carries the group generation
+// that the acknowledged message was consumed in. If the partition has been
+// claimed again in a later generation since then, e.g. after it was assigned
+// to another member and back, then the ack is rejected with
+// ErrStaleGeneration rather than applied. Zero generation is never fenced.
+func (a Ack) WithGeneration(generation int32) Ack {
+	a.generation = generation
+	return a
+}
+
+// WithMetadata returns a copy of the ack that carries application metadata.
+// It is committed along with the partition offset, and returned by
+// `GetGroupOffsets`, until a later ack of the partition carries another one.
+func (a Ack) WithMetadata(metadata string) Ack {
+	a.metadata = metadata
+	return a
 }
 
 // NoAck returns an ack value that should be passed to proxy.Consume function
@@ -82,29 +310,310 @@ func AutoAck() Ack {
 	return autoAck
 }
 
+// Affinity tells proxy.ConsumeContext to prefer a message from the partition
+// of a message consumed earlier, so that clients polling from several
+// goroutines get messages with the same keys in order, and in batches.
+type Affinity struct {
+	partition int32
+}
+
+// NoAffinity returns an affinity value that should be passed to
+// proxy.ConsumeContext when a message can come from any partition.
+func NoAffinity() Affinity {
+	return Affinity{consumer.NoAffinity}
+}
+
+// ParseAffinity parses an affinity token returned by AffinityToken. An empty
+// token means no affinity.
+func ParseAffinity(token string) (Affinity, error) {
+	if token == "" {
+		return NoAffinity(), nil
+	}
+	partition, err := strconv.ParseInt(token, 10, 32)
+	if err != nil || partition < 0 {
+		return Affinity{}, errors.Errorf("bad affinity token: %s", token)
+	}
+	return Affinity{int32(partition)}, nil
+}
+
+// AffinityToken returns an opaque token that a client should echo in its next
+// consume request to get messages from the same partition as msg, if any.
+func AffinityToken(msg *consumer.Message) string {
+	return strconv.Itoa(int(msg.Partition))
+}
+
+// ParsePriority parses a consume request priority. An empty string means the
+// default priority 0.
+func ParsePriority(s string) (int32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	priority, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("bad priority: %s", s)
+	}
+	return int32(priority), nil
+}
+
+// Window tells proxy.ConsumeContext to deliver only messages with record
+// timestamps within a time range. Messages outside of it are acknowledged
+// and skipped, so a window is meant for a group dedicated to a replay.
+type Window struct {
+	from time.Time
+	to   time.Time
+}
+
+// NoWindow returns a window value that should be passed to
+// proxy.ConsumeContext when messages with any timestamps should be delivered.
+func NoWindow() Window {
+	return Window{}
+}
+
+// ParseWindow parses a window given as two comma separated RFC3339 times,
+// either of which can be omitted to leave the window open on that side. An
+// empty string means no window.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return NoWindow(), nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Window{}, errors.Errorf("bad window: %s", s)
+	}
+	var w Window
+	var err error
+	if parts[0] != "" {
+		if w.from, err = time.Parse(time.RFC3339, parts[0]); err != nil {
+			return Window{}, errors.Errorf("bad window: %s", s)
+		}
+	}
+	if parts[1] != "" {
+		if w.to, err = time.Parse(time.RFC3339, parts[1]); err != nil {
+			return Window{}, errors.Errorf("bad window: %s", s)
+		}
+	}
+	if !w.from.IsZero() && !w.to.IsZero() && w.to.Before(w.from) {
+		return Window{}, errors.Errorf("bad window, end is before start: %s", s)
+	}
+	return w, nil
+}
+
+// Stop tells proxy.ConsumeContext to deliver messages only up to a point,
+// either a timestamp or an offset in every partition. Messages at or after
+// the point are neither delivered nor acknowledged, so a replay job does not
+// run into live traffic, and ErrStopReached is returned once the group has
+// consumed everything before the point.
+type Stop struct {
+	at      time.Time
+	offsets map[int32]int64
+}
+
+// NoStop returns a stop value that should be passed to proxy.ConsumeContext
+// when messages should be delivered without bound.
+func NoStop() Stop {
+	return Stop{}
+}
+
+// ParseStop parses a stop point given either as an RFC3339 time, or as comma
+// separated <partition>:<offset> pairs. Partitions missing from the pairs
+// are not consumed at all. An empty string means no stop.
+func ParseStop(s string) (Stop, error) {
+	if s == "" {
+		return NoStop(), nil
+	}
+	if at, err := time.Parse(time.RFC3339, s); err == nil {
+		return Stop{at: at}, nil
+	}
+	stop := Stop{offsets: make(map[int32]int64)}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return Stop{}, errors.Errorf("bad stop: %s", s)
+		}
+		partition, err := strconv.ParseInt(parts[0], 10, 32)
+		if err != nil || partition < 0 {
+			return Stop{}, errors.Errorf("bad stop: %s", s)
+		}
+		offset, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || offset < 0 {
+			return Stop{}, errors.Errorf("bad stop: %s", s)
+		}
+		stop.offsets[int32(partition)] = offset
+	}
+	return stop, nil
+}
+
+// ParseAckTimeout parses an ack timeout that a consume request asks for, as a
+// duration string, e.g. "20m". An empty string means the configured
+// `consumer.ack_timeout`, that is returned as zero.
+func ParseAckTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ackTimeout, err := time.ParseDuration(s)
+	if err != nil || ackTimeout <= 0 {
+		return 0, errors.Errorf("bad ack timeout: %s", s)
+	}
+	return ackTimeout, nil
+}
+
+// ParseGeneration parses a group generation that an ack carries, as returned
+// with the acknowledged message. An empty string means that the ack is never
+// fenced, that is returned as zero.
+func ParseGeneration(s string) (int32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	generation, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || generation < 0 {
+		return 0, errors.Errorf("bad generation: %s", s)
+	}
+	return int32(generation), nil
+}
+
+// ParseAckMetadata checks application metadata that an ack carries.
+func ParseAckMetadata(s string) (string, error) {
+	if len(s) > maxAckMetadataSize {
+		return "", ErrAckMetadataTooLong
+	}
+	return s, nil
+}
+
+// contains tells whether a message falls into the window. Messages without
+// a timestamp, e.g. produced to Kafka before 0.10, are always delivered.
+func (w Window) contains(msg *consumer.Message) bool {
+	if msg.Timestamp.IsZero() {
+		return true
+	}
+	if !w.from.IsZero() && msg.Timestamp.Before(w.from) {
+		return false
+	}
+	if !w.to.IsZero() && msg.Timestamp.After(w.to) {
+		return false
+	}
+	return true
+}
+
+// isSet tells whether the stop bounds consumption at all.
+func (s Stop) isSet() bool {
+	return !s.at.IsZero() || s.offsets != nil
+}
+
+// passes tells whether a message is at or after the stop point. Messages
+// without a timestamp never pass a time stop.
+func (s Stop) passes(msg *consumer.Message) bool {
+	if s.offsets != nil {
+		offset, ok := s.offsets[msg.Partition]
+		return !ok || msg.Offset >= offset
+	}
+	return !s.at.IsZero() && !msg.Timestamp.IsZero() && !msg.Timestamp.Before(s.at)
+}
+
 type eventsChID struct {
 	group     string
 	topic     string
 	partition int32
 }
 
-// Spawn creates a proxy instance and starts its internal goroutines.
-func Spawn(parentActDesc *actor.Descriptor, name string, cfg *config.Proxy) (*T, error) {
+type chunkOffsetsID struct {
+	eventsChID
+	offset int64
+}
+
+// Spawn creates a proxy instance and starts its internal goroutines. If
+// `producer.dead_letter.topic` is configured, then messages that failed to be
+// produced are produced to that topic on the cluster of `deadLetterCfg`.
+// Copies of messages produced to a topic with a shadow are produced to the
+// cluster of `shadowCfgs[topic]`.
+func Spawn(parentActDesc *actor.Descriptor, name string, cfg *config.Proxy, deadLetterCfg *config.Proxy,
+	shadowCfgs map[string]*config.Proxy,
+) (*T, error) {
+	// Broker connections and throttling are tracked per cluster, so the
+	// cluster has to be known before any Kafka client is created.
+	if cfg.Cluster == "" {
+		cfg.Cluster = name
+	}
 	p := T{
-		actDesc:     parentActDesc.NewChild(name),
-		cfg:         cfg,
-		eventsChMap: make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		actDesc:      parentActDesc.NewChild(name),
+		cfg:          cfg,
+		eventsChMap:  make(map[eventsChID]chan<- consumer.Event, initEventsChMapCapacity),
+		chunkOffsets: make(map[chunkOffsetsID][]int64),
+		catchUps:     make(map[eventsChID]*catchUp),
+		groups:       make(map[string]time.Time),
+		knownTopics:  make(map[string]bool),
+
+		maxMessageBytes:    make(map[string]cachedMaxMessageBytes),
+		processingTimeouts: make(map[string]int64),
+		fencedAcks:         make(map[string]int64),
+		dataLoss:           make(map[string]int64),
+		claimGenerations:   make(map[eventsChID]int32),
+		throughput:         throughput.New(cfg.Throughput.MaxTopics, cfg.Throughput.MaxGroups),
+		enricher:           newEnricher(cfg),
 	}
+	// Connections are secured by the cluster broker dialer, so it has to
+	// be set up before any Kafka client is created as well.
 	var err error
+	if p.kafkaCreds, err = secureconn.NewKafka(p.actDesc, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to set up Kafka security")
+	}
+	dialer := brokerconn.ForCluster(cfg.Cluster, cfg.Net.MaxConnections, cfg.Net.DialTimeout)
+	if p.kafkaCreds != nil {
+		dialer.Secure(p.kafkaCreds.Secure)
+	} else {
+		dialer.Secure(nil)
+	}
+	if cfg.Kafka.NegotiateVersion {
+		p.negotiateKafkaVersion()
+	}
+	p.consumeLimiter = limiter.New(cfg.Limits.Consume.Concurrency, cfg.Limits.Consume.QueueSize)
+	p.produceLimiter = limiter.New(cfg.Limits.Produce.Concurrency, cfg.Limits.Produce.QueueSize)
+	p.actDesc.ObserveGauge("pressure", func() int64 { return int64(p.Pressure().Percent) })
+	p.assembler = chunk.NewAssembler(p.actDesc, cfg.Consumer.ChunkSpillDir, cfg.Consumer.ChunkMaxMemory,
+		cfg.Consumer.ChunkTimeout)
 
+	if p.topicFilter, err = newTopicFilter(cfg.Producer.TopicWhitelist, cfg.Producer.TopicBlacklist); err != nil {
+		return nil, errors.Wrap(err, "failed to create topic filter")
+	}
+	if p.receiptSender, err = receipt.NewSender(p.actDesc, cfg.Producer.CallbackURLWhitelist, cfg.Producer.CallbackTimeout); err != nil {
+		return nil, errors.Wrap(err, "failed to create receipt sender")
+	}
 	if p.kafkaClt, err = sarama.NewClient(cfg.Kafka.SeedPeers, cfg.SaramaClientCfg()); err != nil {
 		return nil, errors.Wrap(err, "failed to create Kafka client")
 	}
-	p.offsetMgrF = offsetmgr.SpawnFactory(p.actDesc, cfg, p.kafkaClt)
-	if p.producer, err = producer.Spawn(p.actDesc, cfg); err != nil {
+	p.topology = topology.Spawn(p.actDesc, cfg, p.kafkaClt)
+	if cfg.OffsetStorage.Backend == config.OffsetStorageKafka {
+		p.offsetMgrF = offsetmgr.SpawnFactory(p.actDesc, cfg, p.kafkaClt)
+	} else {
+		offsetStore, err := offsetstore.New(cfg.OffsetStorage)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create offset store")
+		}
+		// Groups can be migrated from the offset store to Kafka one by one,
+		// so offset managers of migrated groups are spawned by the Kafka
+		// factory.
+		p.offsetRouter = offsetmgr.NewRoutingFactory(
+			offsetmgr.SpawnStoreFactory(p.actDesc, cfg, offsetStore),
+			offsetmgr.SpawnFactory(p.actDesc, cfg, p.kafkaClt))
+		p.offsetMgrF = p.offsetRouter
+	}
+	if p.deadLetters, err = deadletter.Spawn(p.actDesc, cfg, deadLetterCfg); err != nil {
+		return nil, errors.Wrap(err, "failed to spawn dead letter sink")
+	}
+	if p.producer, err = producer.Spawn(p.actDesc, cfg, p.deadLetters); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn producer")
 	}
+	if p.shadows, err = shadow.Spawn(p.actDesc, cfg, shadowCfgs); err != nil {
+		return nil, errors.Wrap(err, "failed to spawn shadow producers")
+	}
+	if cfg.LifecycleEvents.Topic != "" {
+		p.lifecycleSub = lifecycle.Subscribe(cfg.Producer.ChannelBufferSize)
+		actor.Spawn(p.actDesc.NewChild("lifecycle"), &p.lifecycleWG, p.produceLifecycleEvents)
+	}
+	p.commitAlert = commitalert.Spawn(p.actDesc, cfg)
 	if !cfg.Consumer.Disabled {
+		if p.freezes, err = freeze.Spawn(p.actDesc, cfg, p.offsetMgrF.SetFreezes); err != nil {
+			return nil, errors.Wrap(err, "failed to spawn group freezes")
+		}
 		if p.consumer, err = consumerimpl.Spawn(p.actDesc, cfg, p.offsetMgrF); err != nil {
 			return nil, errors.Wrap(err, "failed to spawn consumer")
 		}
@@ -112,11 +621,103 @@ func Spawn(parentActDesc *actor.Descriptor, name string, cfg *config.Proxy) (*T,
 	if p.admin, err = admin.Spawn(p.actDesc, cfg); err != nil {
 		return nil, errors.Wrap(err, "failed to spawn admin")
 	}
+	if !cfg.Consumer.Disabled {
+		if p.migrations, err = migration.Spawn(p.actDesc, cfg, p.onMigrationsChange); err != nil {
+			return nil, errors.Wrap(err, "failed to spawn group migrations")
+		}
+	}
+	if cfg.GroupJanitor.Retention > 0 {
+		p.janitor = janitor.Spawn(p.actDesc, cfg.GroupJanitor, p.admin)
+	}
+	if cfg.LegacyGroups.Migrate && !cfg.ReadOnly {
+		p.legacyGroups = legacygroups.Spawn(p.actDesc, cfg.LegacyGroups, p.admin)
+	}
+	if len(cfg.LagSLO.Groups) > 0 {
+		p.lagSLOs = lagslo.Spawn(p.actDesc, cfg.LagSLO, p.admin)
+	}
+	if len(cfg.ProduceSLO.Topics) > 0 {
+		p.produceSLOs = prodslo.New(p.actDesc, cfg.ProduceSLO)
+	}
+	if len(cfg.Consumer.HealthProbes) > 0 {
+		p.healthProbes = healthprobe.Spawn(p.actDesc, cfg.Consumer.HealthProbes)
+	}
+	if len(cfg.MaintenanceWindows) > 0 {
+		p.maintenance = maintenance.Spawn(p.actDesc, cfg.MaintenanceWindows)
+	}
+	p.claimChecks = make(map[string]*claimcheck.Store, len(cfg.ClaimCheck))
+	for topic, claimCheckCfg := range cfg.ClaimCheck {
+		p.claimChecks[topic] = claimcheck.New(claimCheckCfg)
+	}
+	p.validators = make(map[string]*validator.T, len(cfg.ProduceValidation))
+	for topic, validationCfg := range cfg.ProduceValidation {
+		p.validators[topic] = validator.New(p.actDesc, topic, validationCfg)
+	}
+	p.dedupWindows = make(map[string]*dedup.Window, len(cfg.Consumer.Dedup))
+	for group, dedupCfg := range cfg.Consumer.Dedup {
+		p.dedupWindows[group] = dedup.New(dedupCfg.Window, dedupCfg.MaxKeys)
+	}
+	p.tables = make(map[string]*table.T, len(cfg.Tables))
+	for topic, tableCfg := range cfg.Tables {
+		tbl, err := table.Spawn(p.actDesc, topic, tableCfg, p.kafkaClt)
+		if err != nil {
+			p.Stop()
+			return nil, errors.Wrapf(err, "failed to spawn table, topic=%s", topic)
+		}
+		p.tables[topic] = tbl
+	}
+	for name, pipelineCfg := range cfg.Pipelines {
+		pl, err := pipeline.Spawn(p.actDesc, name, pipelineCfg, cfg)
+		if err != nil {
+			p.Stop()
+			return nil, errors.Wrapf(err, "failed to spawn pipeline, name=%s", name)
+		}
+		p.pipelines = append(p.pipelines, pl)
+	}
+	for name, archiveCfg := range cfg.Archives {
+		p.archives = append(p.archives, archiver.Spawn(p.actDesc, name, archiveCfg, cfg))
+	}
 	return &p, nil
 }
 
+// negotiateKafkaVersion replaces the configured Kafka version with the highest
+// one supported by the cluster brokers. It has to be called before any Kafka
+// client is created.
+func (p *T) negotiateKafkaVersion() {
+	cfgVersion := p.cfg.Kafka.Version
+	version, err := apiversion.Negotiate(p.cfg.Kafka.SeedPeers, p.cfg.SaramaClientCfg())
+	if err != nil {
+		p.actDesc.Log().WithError(err).Warnf("Failed to negotiate Kafka version, using configured: version=%s", cfgVersion)
+		return
+	}
+	p.cfg.Kafka.Version.Set(version)
+	p.actDesc.Log().Infof("Negotiated Kafka version: version=%s, configured=%s", p.cfg.Kafka.Version, cfgVersion)
+}
+
+// ClientID returns the client ID that the proxy identifies itself with to
+// Kafka and ZooKeeper.
+func (p *T) ClientID() string {
+	return p.cfg.ClientID
+}
+
+// KafkaVersion returns the Kafka version that is used with the cluster, that
+// is either configured or negotiated with the brokers.
+func (p *T) KafkaVersion() string {
+	return p.cfg.Kafka.Version.String()
+}
+
 // Stop terminates the proxy instances synchronously.
 func (p *T) Stop() {
+	// The janitor, the legacy group migration and the lag objective
+	// evaluator use admin, so they have to be stopped first.
+	if p.janitor != nil {
+		p.janitor.Stop()
+	}
+	if p.legacyGroups != nil {
+		p.legacyGroups.Stop()
+	}
+	if p.lagSLOs != nil {
+		p.lagSLOs.Stop()
+	}
 	var wg sync.WaitGroup
 
 	p.producerMu.RLock()
@@ -137,13 +738,49 @@ func (p *T) Stop() {
 	}
 	p.adminMu.RUnlock()
 
+	for _, tbl := range p.tables {
+		actor.Spawn(p.actDesc.NewChild("tbl_stop"), &wg, tbl.Stop)
+	}
+	for _, pl := range p.pipelines {
+		actor.Spawn(p.actDesc.NewChild("pl_stop"), &wg, pl.Stop)
+	}
+	for _, a := range p.archives {
+		actor.Spawn(p.actDesc.NewChild("arc_stop"), &wg, a.Stop)
+	}
+
 	wg.Wait()
+	p.stopCatchUps()
+	// Outcomes of all messages are known once the producer is stopped, so
+	// only then receipts can be flushed.
+	p.receiptWG.Wait()
+	p.receiptSender.Stop()
+	if p.lifecycleSub != nil {
+		p.lifecycleSub.Close()
+		p.lifecycleWG.Wait()
+	}
+	if p.commitAlert != nil {
+		p.commitAlert.Stop()
+	}
+	if p.healthProbes != nil {
+		p.healthProbes.Stop()
+	}
+	if p.maintenance != nil {
+		p.maintenance.Stop()
+	}
+	if p.freezes != nil {
+		p.freezes.Stop()
+	}
+	if p.migrations != nil {
+		p.migrations.Stop()
+	}
 	if p.offsetMgrF != nil {
 		p.offsetMgrF.Stop()
 	}
+	p.topology.Stop()
 	if p.kafkaClt != nil {
 		p.kafkaClt.Close()
 	}
+	p.assembler.Close()
 }
 
 func (p *T) stopConsumer() {
@@ -160,8 +797,23 @@ func (p *T) stopProducer() {
 	p.producerMu.Lock()
 	prod := p.producer
 	p.producer = nil
+	strictProd := p.strictProducer
+	p.strictProducer = nil
 	p.producerMu.Unlock()
-	prod.Stop()
+	if strictProd != nil {
+		var wg sync.WaitGroup
+		actor.Spawn(p.actDesc.NewChild("strict_prod_stop"), &wg, strictProd.Stop)
+		prod.Stop()
+		wg.Wait()
+	} else {
+		prod.Stop()
+	}
+	if p.deadLetters != nil {
+		p.deadLetters.Stop()
+	}
+	if p.shadows != nil {
+		p.shadows.Stop()
+	}
 }
 
 func (p *T) stopAdmin() {
@@ -174,121 +826,1425 @@ func (p *T) stopAdmin() {
 // using `key` to identify a destination partition. The exact algorithm used to
 // map keys to partitions is implementation specific but it is guaranteed that
 // it returns consistent results. If `key` is `nil`, then the message is placed
-// into a random partition.
+// into a random partition. If `key` is a `producer.PartitionedKey`, then its
+// partition key identifies the destination partition instead.
 //
 // Errors usually indicate a catastrophic failure of the Kafka cluster, or
 // missing topic if there cluster is not configured to auto create topics.
 func (p *T) Produce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) (*sarama.ProducerMessage, error) {
+	return p.produceSync(topic, key, message, headers, false)
+}
+
+// ProduceStrictlyOrdered is like `Produce`, but the message is submitted to a
+// producer that keeps at most one request in flight to a broker, so that it
+// cannot be reordered with other strictly ordered messages to the same
+// partition by retries, even if the global producer config allows pipelining.
+func (p *T) ProduceStrictlyOrdered(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) (*sarama.ProducerMessage, error) {
+	return p.produceSync(topic, key, message, headers, true)
+}
+
+func (p *T) produceSync(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader, strict bool) (*sarama.ProducerMessage, error) {
+	begin := time.Now()
+	if p.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if !p.topicFilter.allows(topic) {
+		return nil, ErrTopicNotAllowed
+	}
 	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
 		return nil, ErrHeadersUnsupported
 	}
+	if !p.produceLimiter.Acquire(p.cfg.Consumer.LongPollingTimeout) {
+		return nil, ErrLimitExceeded
+	}
+	defer p.produceLimiter.Release()
+
+	if err := p.ensureTopic(topic); err != nil {
+		return nil, err
+	}
+	headers = claimcheck.StripProducedHeader(headers)
+	if v := p.validators[topic]; v != nil {
+		if err := p.validate(v, key, message, headers); err != nil {
+			return nil, err
+		}
+	}
+	tap.PublishProduced(p.cfg.Cluster, topic, key, message, headers)
+	p.throughput.OnProduced(topic, key, message)
+	if p.shadows != nil {
+		p.shadows.Put(topic, key, message, headers)
+	}
+	if store := p.claimCheckStore(topic, message); store != nil {
+		var err error
+		if message, headers, err = p.offload(store, message, headers); err != nil {
+			return nil, err
+		}
+	}
+	chunks, err := p.split(key, message, headers)
+	if err != nil {
+		return nil, err
+	}
+	if chunks == nil {
+		if err := p.checkMessageSize(topic, key, message); err != nil {
+			return nil, err
+		}
+		rs := p.produce(topic, key, message, headers, strict)
+		p.observeProduceLatency(topic, begin, rs.KafkaLatency)
+		return rs.Msg, rs.Err
+	}
+	// Chunks are produced one by one to preserve their order.
+	var rs producer.Response
+	var kafkaLatency time.Duration
+	for _, c := range chunks {
+		rs = p.produce(topic, chunkKey(key, c), sarama.ByteEncoder(c.Value), c.Headers, strict)
+		kafkaLatency += rs.KafkaLatency
+		if rs.Err != nil {
+			break
+		}
+	}
+	p.observeProduceLatency(topic, begin, kafkaLatency)
+	if rs.Err != nil {
+		return nil, rs.Err
+	}
+	return rs.Msg, nil
+}
 
+func (p *T) produce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader, strict bool) producer.Response {
 	p.producerMu.RLock()
-	if p.producer == nil {
+	prod, err := p.producerFor(strict)
+	if err != nil {
 		p.producerMu.RUnlock()
-		return nil, ErrUnavailable
+		return producer.Response{Err: err}
 	}
-	responseCh := p.producer.AsyncProduce(topic, key, message, headers)
+	responseCh := prod.AsyncProduce(topic, key, message, headers)
 	p.producerMu.RUnlock()
 
-	rs := <-responseCh
-	return rs.Msg, rs.Err
+	return <-responseCh
 }
 
-// AsyncProduce is an asynchronously counterpart of the `Produce` function.
-// Errors are silently ignored.
-func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) {
-	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
-		return
+// observeProduceLatency counts a synchronous produce request that began at
+// begin, and of which the message spent kafkaLatency in Kafka, towards the
+// latency objective of the topic, if any.
+func (p *T) observeProduceLatency(topic string, begin time.Time, kafkaLatency time.Duration) {
+	if p.produceSLOs != nil {
+		p.produceSLOs.Observe(topic, time.Since(begin), kafkaLatency)
 	}
+}
 
-	p.producerMu.RLock()
+// producerFor returns the producer to submit messages to, spawning the
+// strictly ordered one on first use. It must be called with `producerMu`
+// locked for reading.
+func (p *T) producerFor(strict bool) (*producer.T, error) {
 	if p.producer == nil {
-		p.producerMu.RUnlock()
-		return
+		return nil, ErrUnavailable
 	}
-	p.producer.AsyncProduce(topic, key, message, headers)
-	p.producerMu.RUnlock()
-}
-
-// Consume consumes a message from the specified topic on behalf of the
-// specified consumer group. If there are no more new messages in the topic
-// at the time of the request then it will block for
-// `Config.Consumer.LongPollingTimeout`. If no new message is produced during
-// that time, then `ErrRequestTimeout` is returned.
-//
-// Note that during state transitions topic subscribe<->unsubscribe and
-// consumer group register<->deregister the method may return either
-// `ErrBufferOverflow` or `ErrRequestTimeout` even when there are messages
-// available for consumption. In that case the user should back off a bit
-// and then repeat the request.
-func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
-	if p.cfg.Consumer.Disabled {
-		return consumer.Message{}, ErrDisabled
+	if !strict {
+		return p.producer, nil
 	}
-
-	if ack != noAck && ack != autoAck {
-		p.eventsChMapMu.RLock()
-		eventsChID := eventsChID{group, topic, ack.partition}
-		eventsCh, ok := p.eventsChMap[eventsChID]
-		p.eventsChMapMu.RUnlock()
-		if ok {
-			go func() {
-				select {
-				case eventsCh <- consumer.Ack(ack.offset):
-				case <-time.After(p.cfg.Consumer.LongPollingTimeout):
-					p.actDesc.Log().WithFields(log.Fields{
-						"kafka.group":     group,
-						"kafka.topic":     topic,
-						"kafka.partition": ack.partition,
-					}).Errorf("ack timeout: offset=%d", ack.offset)
-				}
-			}()
+	p.strictProducerMu.Lock()
+	defer p.strictProducerMu.Unlock()
+	if p.strictProducer == nil {
+		strictProd, err := producer.SpawnStrictlyOrdered(p.actDesc, p.cfg, p.deadLetters)
+		if err != nil {
+			p.actDesc.Log().WithError(err).Error("Failed to spawn strictly ordered producer")
+			return nil, ErrUnavailable
 		}
+		p.strictProducer = strictProd
 	}
+	return p.strictProducer, nil
+}
 
-	p.consumerMu.RLock()
-	if p.consumer == nil {
-		p.consumerMu.RUnlock()
-		return consumer.Message{}, ErrUnavailable
-	}
-	responseCh := p.consumer.AsyncConsume(group, topic)
-	p.consumerMu.RUnlock()
+// ConsumerStats describes what happened to consumed messages since start.
+type ConsumerStats struct {
+	// The number of messages of every topic that were not acknowledged
+	// within `consumer.max_processing_time`.
+	ProcessingTimeouts map[string]int64
+	// The number of acks of every topic that were rejected because they
+	// carried a stale group generation.
+	FencedAcks map[string]int64
+	// The number of messages of every topic that were removed by retention
+	// before groups consumed them.
+	DataLoss map[string]int64
+}
 
-	rs := <-responseCh
-	if rs.Err != nil {
-		return consumer.Message{}, rs.Err
+// ConsumerStats returns what happened to consumed messages since start.
+func (p *T) ConsumerStats() ConsumerStats {
+	p.processingTimeoutsMu.Lock()
+	stats := ConsumerStats{ProcessingTimeouts: make(map[string]int64, len(p.processingTimeouts))}
+	for topic, count := range p.processingTimeouts {
+		stats.ProcessingTimeouts[topic] = count
+	}
+	p.processingTimeoutsMu.Unlock()
+	p.fencedAcksMu.Lock()
+	stats.FencedAcks = make(map[string]int64, len(p.fencedAcks))
+	for topic, count := range p.fencedAcks {
+		stats.FencedAcks[topic] = count
+	}
+	p.fencedAcksMu.Unlock()
+	p.dataLossMu.Lock()
+	stats.DataLoss = make(map[string]int64, len(p.dataLoss))
+	for topic, count := range p.dataLoss {
+		stats.DataLoss[topic] = count
 	}
+	p.dataLossMu.Unlock()
+	return stats
+}
 
-	eventsChID := eventsChID{group, topic, rs.Msg.Partition}
-	p.eventsChMapMu.Lock()
-	p.eventsChMap[eventsChID] = rs.Msg.EventsCh
-	p.eventsChMapMu.Unlock()
+// ThroughputStats returns the number of messages and bytes produced and
+// consumed through the proxy since start.
+func (p *T) ThroughputStats() throughput.Stats {
+	return p.throughput.Stats()
+}
 
-	if ack == autoAck {
-		rs.Msg.EventsCh <- consumer.Ack(rs.Msg.Offset)
-	}
-	return rs.Msg, nil
+// PartitionStats returns consume statistics of partitions of a topic.
+func (p *T) PartitionStats(topic string) []partstats.Partition {
+	return partstats.Get(p.cfg.Cluster, topic)
 }
 
-func (p *T) Ack(group, topic string, ack Ack) error {
-	eventsChID := eventsChID{group, topic, ack.partition}
-	p.eventsChMapMu.RLock()
-	eventsCh, ok := p.eventsChMap[eventsChID]
-	p.eventsChMapMu.RUnlock()
-	if !ok {
-		return errors.Errorf("acks channel missing for %v", eventsChID)
+// ProducerStats returns current occupancy of the producer buffers.
+func (p *T) ProducerStats() (producer.Stats, error) {
+	p.producerMu.RLock()
+	defer p.producerMu.RUnlock()
+	if p.producer == nil {
+		return producer.Stats{}, ErrUnavailable
 	}
-	select {
-	case eventsCh <- consumer.Ack(ack.offset):
-	case <-time.After(p.cfg.Consumer.LongPollingTimeout):
-		return errors.New("ack timeout")
+	stats := p.producer.Stats()
+	p.strictProducerMu.Lock()
+	if p.strictProducer != nil {
+		stats = stats.Add(p.strictProducer.Stats())
 	}
-	return nil
+	p.strictProducerMu.Unlock()
+	return stats, nil
 }
 
-// GetGroupOffsets for every partition of the specified topic it returns the
-// current offset range along with the latest offset and metadata committed by
+// FlushProducer waits for all messages buffered by the producer to be either
+// acknowledged by Kafka or failed, but no longer than the specified timeout.
+// If the timeout is not positive then `producer.shutdown_timeout` is used.
+func (p *T) FlushProducer(timeout time.Duration) (producer.FlushResult, error) {
+	if timeout <= 0 {
+		timeout = p.cfg.Producer.ShutdownTimeout
+	}
+	p.producerMu.RLock()
+	prod := p.producer
+	p.strictProducerMu.Lock()
+	strictProd := p.strictProducer
+	p.strictProducerMu.Unlock()
+	p.producerMu.RUnlock()
+	if prod == nil {
+		return producer.FlushResult{}, ErrUnavailable
+	}
+	deadline := time.Now().Add(timeout)
+	result := prod.Flush(timeout)
+	if strictProd != nil {
+		result = result.Add(strictProd.Flush(time.Until(deadline)))
+	}
+	return result, nil
+}
+
+// IsReadOnly tells whether producing to the cluster and setting consumer
+// group offsets are disabled, either by config or by an active `read_only`
+// maintenance window.
+func (p *T) IsReadOnly() bool {
+	return p.cfg.ReadOnly || (p.maintenance != nil && p.maintenance.IsReadOnly())
+}
+
+// WithRequestID appends a record header with the ID of the API request that
+// produces a message to the message headers, if `producer.request_id_header`
+// is configured.
+func (p *T) WithRequestID(headers []sarama.RecordHeader, requestID string) []sarama.RecordHeader {
+	if p.cfg.Producer.RequestIDHeader == "" || requestID == "" {
+		return headers
+	}
+	return append(headers, sarama.RecordHeader{
+		Key:   []byte(p.cfg.Producer.RequestIDHeader),
+		Value: []byte(requestID),
+	})
+}
+
+// WithTraceContext appends record headers with the trace context of the API
+// request that produces a message to the message headers, if `trace_context`
+// is enabled and the message does not carry a trace parent already. If the
+// request carries no valid trace context, then a new trace is started.
+func (p *T) WithTraceContext(headers []sarama.RecordHeader, tc tracectx.T) []sarama.RecordHeader {
+	if !p.cfg.TraceContext.Enabled || tracectx.HasParent(headers) {
+		return headers
+	}
+	tc = tracectx.Honor(tc)
+	headers = append(headers, sarama.RecordHeader{
+		Key:   []byte(tracectx.ParentHeader),
+		Value: []byte(tc.Parent),
+	})
+	if tc.State != "" {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(tracectx.StateHeader),
+			Value: []byte(tc.State),
+		})
+	}
+	return headers
+}
+
+// WithEnrichment stamps headers configured in `producer.enrichment` for the
+// topic onto a message produced by a tenant, if any. Unless
+// `producer.enrichment.override` is set, headers given by the client win.
+func (p *T) WithEnrichment(headers []sarama.RecordHeader, topic string, tenant *tenancy.Tenant) []sarama.RecordHeader {
+	if p.enricher == nil {
+		return headers
+	}
+	return p.enricher.enrich(headers, topic, tenant)
+}
+
+// TraceContextOf returns the trace context carried by record headers of a
+// consumed message, if `trace_context` is enabled and there is a valid one.
+func (p *T) TraceContextOf(msg *consumer.Message) (tracectx.T, bool) {
+	if !p.cfg.TraceContext.Enabled {
+		return tracectx.T{}, false
+	}
+	return tracectx.FromHeaders(msg.Headers)
+}
+
+// TranscodeValue transcodes a value consumed from a topic to the specified
+// codec, if the codec of values of the topic is configured in
+// `value_codecs`. It returns the value along with the name of the codec it
+// is encoded with, or an empty name if that is unknown. If a value cannot be
+// transcoded, then it is returned as is, for the message is consumed already.
+func (p *T) TranscodeValue(topic string, value []byte, to string) ([]byte, string) {
+	from := p.cfg.ValueCodecs[topic]
+	if from == "" || to == "" || value == nil {
+		return value, from
+	}
+	transcoded, err := codec.Transcode(value, from, to)
+	if err != nil {
+		p.actDesc.Log().WithError(err).Warnf("Failed to transcode value: topic=%s, to=%s", topic, to)
+		return value, from
+	}
+	return transcoded, to
+}
+
+// OpenTap creates a tap that receives copies of the next `count` messages
+// produced to, or consumed from, the topic, see package tap for details.
+func (p *T) OpenTap(topic string, direction tap.Direction, count int) *tap.Tap {
+	return tap.Open(p.cfg.Cluster, topic, direction, count)
+}
+
+// IsTopicAllowed tells whether the topic can be produced to.
+func (p *T) IsTopicAllowed(topic string) bool {
+	return p.topicFilter.allows(topic)
+}
+
+// AsyncProduce is an asynchronously counterpart of the `Produce` function.
+// Errors are silently ignored.
+func (p *T) AsyncProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) {
+	p.asyncProduce(topic, key, message, headers, false, nil)
+}
+
+// AsyncProduceWithReceipt is like `AsyncProduce`, but when the message is
+// either acknowledged by Kafka or failed, a receipt is posted to
+// `callbackURL`. It fails if the URL is not whitelisted by
+// `producer.callback_url_whitelist`.
+func (p *T) AsyncProduceWithReceipt(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	callbackURL, requestID string,
+) error {
+	return p.asyncProduceWithReceipt(topic, key, message, headers, false, callbackURL, requestID)
+}
+
+// AsyncProduceStrictlyOrdered is an asynchronous counterpart of the
+// `ProduceStrictlyOrdered` function. If `callbackURL` is not empty, then a
+// receipt is posted to it the same way `AsyncProduceWithReceipt` does.
+func (p *T) AsyncProduceStrictlyOrdered(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	callbackURL, requestID string,
+) error {
+	if callbackURL == "" {
+		p.asyncProduce(topic, key, message, headers, true, nil)
+		return nil
+	}
+	return p.asyncProduceWithReceipt(topic, key, message, headers, true, callbackURL, requestID)
+}
+
+func (p *T) asyncProduceWithReceipt(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	strict bool, callbackURL, requestID string,
+) error {
+	if !p.receiptSender.Allows(callbackURL) {
+		return ErrCallbackNotAllowed
+	}
+	p.asyncProduce(topic, key, message, headers, strict, func(prodMsg *sarama.ProducerMessage, err error) {
+		rcpt := receipt.Receipt{RequestID: requestID, Partition: -1, Offset: -1}
+		if err != nil {
+			rcpt.Error = err.Error()
+		} else {
+			rcpt.Partition, rcpt.Offset = prodMsg.Partition, prodMsg.Offset
+		}
+		p.receiptSender.Send(callbackURL, rcpt)
+	})
+	return nil
+}
+
+// asyncProduce submits a message to the producer without waiting for the
+// outcome. If `done` is not nil, then it is called with the outcome once the
+// message, or all its chunks, are either acknowledged by Kafka or failed.
+func (p *T) asyncProduce(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	strict bool, done func(*sarama.ProducerMessage, error),
+) {
+	fail := func(err error) {
+		if done != nil {
+			done(nil, err)
+		}
+	}
+	if p.IsReadOnly() {
+		fail(ErrReadOnly)
+		return
+	}
+	if !p.topicFilter.allows(topic) {
+		p.actDesc.Log().Errorf("Dropped message to not allowed topic: topic=%s", topic)
+		fail(ErrTopicNotAllowed)
+		return
+	}
+	if err := p.ensureTopic(topic); err != nil {
+		p.actDesc.Log().WithError(err).Errorf("Dropped message: topic=%s", topic)
+		fail(err)
+		return
+	}
+	if len(headers) > 0 && !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		fail(ErrHeadersUnsupported)
+		return
+	}
+	headers = claimcheck.StripProducedHeader(headers)
+	if v := p.validators[topic]; v != nil {
+		// Validating a message takes a round trip to the validator, so it
+		// is done in the background not to block the caller.
+		go func() {
+			if err := p.validate(v, key, message, headers); err != nil {
+				p.actDesc.Log().WithError(err).Errorf("Dropped invalid message: topic=%s", topic)
+				fail(err)
+				return
+			}
+			p.offloadAndSubmit(topic, key, message, headers, strict, done)
+		}()
+		return
+	}
+	p.offloadAndSubmit(topic, key, message, headers, strict, done)
+}
+
+// offloadAndSubmit is the part of `asyncProduce` that follows validation.
+// It copies a message to taps and shadows, offloads its value to an object
+// store if the topic has a claim-check, and submits it to the producer.
+func (p *T) offloadAndSubmit(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	strict bool, done func(*sarama.ProducerMessage, error),
+) {
+	tap.PublishProduced(p.cfg.Cluster, topic, key, message, headers)
+	p.throughput.OnProduced(topic, key, message)
+	if p.shadows != nil {
+		p.shadows.Put(topic, key, message, headers)
+	}
+	if store := p.claimCheckStore(topic, message); store != nil {
+		// Uploading a value may take a while, so it is done in the
+		// background not to block the caller.
+		go func() {
+			message, headers, err := p.offload(store, message, headers)
+			if err != nil {
+				p.actDesc.Log().WithError(err).Errorf("Failed to offload message: topic=%s", topic)
+				if done != nil {
+					done(nil, err)
+				}
+				return
+			}
+			p.submit(topic, key, message, headers, strict, done)
+		}()
+		return
+	}
+	p.submit(topic, key, message, headers, strict, done)
+}
+
+func (p *T) submit(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader,
+	strict bool, done func(*sarama.ProducerMessage, error),
+) {
+	chunks, err := p.split(key, message, headers)
+	if err != nil {
+		p.actDesc.Log().WithError(err).Error("Failed to split message")
+		if done != nil {
+			done(nil, err)
+		}
+		return
+	}
+	if chunks == nil {
+		if err := p.checkMessageSize(topic, key, message); err != nil {
+			p.actDesc.Log().Errorf("Dropped too large message: topic=%s", topic)
+			if done != nil {
+				done(nil, err)
+			}
+			return
+		}
+	}
+
+	p.producerMu.RLock()
+	prod, err := p.producerFor(strict)
+	if err != nil {
+		p.producerMu.RUnlock()
+		if done != nil {
+			done(nil, err)
+		}
+		return
+	}
+	var responseChs []<-chan producer.Response
+	if chunks == nil {
+		responseChs = append(responseChs, prod.AsyncProduce(topic, key, message, headers))
+	}
+	for _, c := range chunks {
+		responseChs = append(responseChs,
+			prod.AsyncProduce(topic, chunkKey(key, c), sarama.ByteEncoder(c.Value), c.Headers))
+	}
+	p.producerMu.RUnlock()
+
+	if done == nil {
+		return
+	}
+	p.receiptWG.Add(1)
+	go func() {
+		defer p.receiptWG.Done()
+		var rs producer.Response
+		for _, responseCh := range responseChs {
+			if rs = <-responseCh; rs.Err != nil {
+				break
+			}
+		}
+		done(rs.Msg, rs.Err)
+	}()
+}
+
+// validate posts a message to the validation webhook of its topic, and
+// returns an error if the message must not be produced.
+func (p *T) validate(v *validator.T, key, message sarama.Encoder, headers []sarama.RecordHeader) error {
+	var keyBytes, value []byte
+	var err error
+	if key != nil {
+		if keyBytes, err = key.Encode(); err != nil {
+			return errors.Wrap(err, "failed to encode key")
+		}
+	}
+	if message != nil {
+		if value, err = message.Encode(); err != nil {
+			return errors.Wrap(err, "failed to encode message")
+		}
+	}
+	return v.Validate(keyBytes, value, headers)
+}
+
+// claimCheckStore returns an object store that a message value should be
+// offloaded to, or nil if the value should be produced as is.
+func (p *T) claimCheckStore(topic string, message sarama.Encoder) *claimcheck.Store {
+	store := p.claimChecks[topic]
+	if store == nil || message == nil || message.Length() <= store.Threshold() {
+		return nil
+	}
+	return store
+}
+
+// offload uploads a message value to an object store and returns an empty
+// value along with headers that reference the uploaded one.
+func (p *T) offload(store *claimcheck.Store, message sarama.Encoder, headers []sarama.RecordHeader,
+) (sarama.Encoder, []sarama.RecordHeader, error) {
+	value, err := message.Encode()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode message")
+	}
+	ref, err := store.Put(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	refHeaders := make([]sarama.RecordHeader, 0, len(headers)+1)
+	refHeaders = append(refHeaders, headers...)
+	refHeaders = append(refHeaders, sarama.RecordHeader{Key: []byte(claimcheck.Header), Value: []byte(ref)})
+	return sarama.ByteEncoder([]byte{}), refHeaders, nil
+}
+
+// claim replaces the value of a message that was offloaded to an object
+// store with the original one.
+func (p *T) claim(topic string, msg *consumer.Message) error {
+	ref := claimcheck.Reference(&msg.ConsumerMessage)
+	if ref == "" {
+		return nil
+	}
+	store := p.claimChecks[topic]
+	if store == nil {
+		return errors.Wrap(claimcheck.ErrNotConfigured, topic)
+	}
+	value, err := store.Get(ref)
+	if err != nil {
+		return err
+	}
+	msg.Value = value
+	msg.Headers = claimcheck.StripHeader(msg.Headers)
+	return nil
+}
+
+// stampDelivery adds headers that tell how many times a message has been
+// delivered and when it was first offered to a message that is offered
+// again, replacing those the message might carry already. Messages offered
+// for the first time are not stamped.
+func stampDelivery(msg *consumer.Message) {
+	if msg.OfferedAt.IsZero() {
+		return
+	}
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers)+2)
+	for _, h := range msg.Headers {
+		if key := string(h.Key); key != DeliveryAttemptHeader && key != FirstOfferedAtHeader {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers, &sarama.RecordHeader{
+		Key:   []byte(DeliveryAttemptHeader),
+		Value: []byte(strconv.Itoa(msg.RetryNo + 1)),
+	}, &sarama.RecordHeader{
+		Key:   []byte(FirstOfferedAtHeader),
+		Value: []byte(msg.OfferedAt.UTC().Format(time.RFC3339Nano)),
+	})
+	msg.Headers = headers
+}
+
+// split splits a message into chunks if chunking is enabled and the message
+// is larger than the chunk size. Otherwise nil is returned.
+func (p *T) split(key, message sarama.Encoder, headers []sarama.RecordHeader) ([]chunk.Chunk, error) {
+	if p.cfg.Producer.ChunkSize <= 0 || message == nil || message.Length() <= p.cfg.Producer.ChunkSize {
+		return nil, nil
+	}
+	value, err := message.Encode()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode message")
+	}
+	var keyBytes []byte
+	if key != nil {
+		if keyBytes, err = key.Encode(); err != nil {
+			return nil, errors.Wrap(err, "failed to encode key")
+		}
+	}
+	return chunk.Split(keyBytes, value, headers, p.cfg.Producer.ChunkSize), nil
+}
+
+// chunkKey returns the key to produce a chunk of a message with the specified
+// key with, so that the chunk lands in the partition the message would.
+func chunkKey(key sarama.Encoder, c chunk.Chunk) sarama.Encoder {
+	if pk, ok := key.(producer.PartitionedKey); ok {
+		return producer.WithPartitionKey(sarama.ByteEncoder(c.Key), pk.PartitionKey)
+	}
+	return sarama.ByteEncoder(c.Key)
+}
+
+// Consume consumes a message from the specified topic on behalf of the
+// specified consumer group. If there are no more new messages in the topic
+// at the time of the request then it will block for
+// `Config.Consumer.LongPollingTimeout`. If no new message is produced during
+// that time, then `ErrRequestTimeout` is returned.
+//
+// Note that during state transitions topic subscribe<->unsubscribe and
+// consumer group register<->deregister the method may return either
+// `ErrBufferOverflow` or `ErrRequestTimeout` even when there are messages
+// available for consumption. In that case the user should back off a bit
+// and then repeat the request.
+func (p *T) Consume(group, topic string, ack Ack) (consumer.Message, error) {
+	return p.ConsumeContext(context.Background(), group, topic, ack, NoAffinity(), 0, NoWindow(), NoStop(), 0)
+}
+
+// ConsumeContext is the same as Consume, except that it gives up as soon as
+// the context is done, e.g. when the client that requested a message has
+// disconnected, and returns the context error. A message that has been
+// reserved for the request by then is reclaimed, that is offered again right
+// away rather than after the ack timeout. The message is preferably taken from
+// the partition given by affinity, if there is one available. If several
+// requests of the group wait for messages at this Kafka-Pixy instance, then
+// those with higher priority are served first, the default priority is 0.
+// Messages with timestamps outside of window are acknowledged and skipped.
+// Messages at or after stop are left unacknowledged and not delivered, they
+// are offered again after the ack timeout without counting as retries, and
+// once the group has consumed all messages before it, ErrStopReached is
+// returned instead of ErrRequestTimeout. If ackTimeout is not zero, then the returned message is offered again if it
+// is not acknowledged within ackTimeout, rather than `consumer.ack_timeout`.
+func (p *T) ConsumeContext(ctx context.Context, group, topic string, ack Ack, affinity Affinity, priority int32,
+	window Window, stop Stop, ackTimeout time.Duration,
+) (consumer.Message, error) {
+	if p.cfg.Consumer.Disabled {
+		return consumer.Message{}, ErrDisabled
+	}
+
+	if ack != noAck && ack != autoAck {
+		eventsChID := eventsChID{group, topic, ack.partition}
+		eventsCh, err := p.getEventsCh(eventsChID, ack)
+		if err == ErrStaleGeneration {
+			p.actDesc.Log().WithFields(log.Fields{
+				"kafka.group":     group,
+				"kafka.topic":     topic,
+				"kafka.partition": ack.partition,
+			}).Warnf("Stale ack fenced: offset=%d, generation=%d", ack.offset, ack.generation)
+		}
+		if err == nil {
+			go func() {
+				timeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+				if err := p.sendAck(eventsCh, eventsChID, ack, timeout); err != nil {
+					p.actDesc.Log().WithFields(log.Fields{
+						"kafka.group":     group,
+						"kafka.topic":     topic,
+						"kafka.partition": ack.partition,
+					}).Errorf("ack timeout: offset=%d", ack.offset)
+				}
+			}()
+		}
+	}
+
+	// Limits are checked after the ack is sent, so that the ack is not lost
+	// even if the request is rejected.
+	if ackTimeout > p.cfg.MaxAckTimeout() {
+		return consumer.Message{}, ErrAckTimeoutTooLong
+	}
+	if err := p.checkGroupLimit(group); err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.checkMigration(group); err != nil {
+		return consumer.Message{}, err
+	}
+	if err := p.waitGroupResumed(ctx, group); err != nil {
+		return consumer.Message{}, err
+	}
+	if !p.consumeLimiter.Acquire(p.cfg.Consumer.LongPollingTimeout) {
+		return consumer.Message{}, ErrLimitExceeded
+	}
+	defer p.consumeLimiter.Release()
+	defer inflight.RequestStarted(p.cfg.Cluster, group)()
+	defer gctune.ConsumeStarted()()
+	consumed := autoprof.ConsumeStarted()
+
+	// Messages that are skipped do not extend the long polling timeout.
+	deadline := time.Now().Add(p.cfg.Consumer.LongPollingTimeout)
+	stopChecked := false
+	// Data loss reported with skipped messages is reported with the message
+	// returned instead.
+	var dataLoss int64
+	var rs consumer.Response
+	for {
+		if time.Now().After(deadline) {
+			return consumer.Message{}, p.timeoutOrStop(group, topic, stop)
+		}
+		p.consumerMu.RLock()
+		if p.consumer == nil {
+			p.consumerMu.RUnlock()
+			return consumer.Message{}, ErrUnavailable
+		}
+		responseCh := p.consumer.AsyncConsume(group, topic, affinity.partition, priority, reqid.FromContext(ctx))
+		p.consumerMu.RUnlock()
+
+		select {
+		case rs = <-responseCh:
+		case <-ctx.Done():
+			// The request cannot be withdrawn from the consumer, so a message
+			// that it is eventually served with is reclaimed.
+			go func() {
+				if rs := <-responseCh; rs.Err == nil {
+					p.reclaim(group, topic, &rs.Msg, []int64{rs.Msg.Offset})
+				}
+			}()
+			return consumer.Message{}, ctx.Err()
+		}
+		if rs.Err == consumer.ErrRequestTimeout {
+			return consumer.Message{}, p.timeoutOrStop(group, topic, stop)
+		}
+		if rs.Err != nil {
+			return consumer.Message{}, rs.Err
+		}
+		if rs.Msg.DataLoss > 0 {
+			p.onDataLoss(group, topic, &rs.Msg)
+			dataLoss += rs.Msg.DataLoss
+		}
+		if !p.assemble(group, topic, &rs.Msg) {
+			continue
+		}
+		// Messages past the stop point are held back unacknowledged, so the
+		// partition stalls once its offered messages reach the limit. They
+		// are offered again after the ack timeout, but that does not count
+		// as a retry, for otherwise they would be acknowledged once they run
+		// out of retries. The group progress is checked only once per
+		// request, for it takes requests to Kafka.
+		if stop.passes(&rs.Msg) {
+			holdBackTimeout := ackTimeout
+			if holdBackTimeout == 0 {
+				holdBackTimeout = p.cfg.Consumer.AckTimeout
+			}
+			offsets := p.takeChunkOffsets(eventsChID{group, topic, rs.Msg.Partition}, rs.Msg.Offset)
+			p.holdBack(group, topic, &rs.Msg, offsets, holdBackTimeout)
+			if !stopChecked && p.isStopReached(group, topic, stop) {
+				return consumer.Message{}, ErrStopReached
+			}
+			stopChecked = true
+			continue
+		}
+		if p.isOverdue(&rs.Msg) {
+			p.deadLetterOverdue(group, topic, &rs.Msg)
+			p.ackNow(group, topic, &rs.Msg)
+			continue
+		}
+		// Until the catch-up index of the partition is built, its messages
+		// cannot be told superseded or not, so they are held back.
+		if err := p.waitCatchUp(ctx, group, topic, &rs.Msg, deadline); err != nil {
+			p.reclaim(group, topic, &rs.Msg, p.takeChunkOffsets(eventsChID{group, topic, rs.Msg.Partition}, rs.Msg.Offset))
+			return consumer.Message{}, err
+		}
+		if window.contains(&rs.Msg) && !p.isExpired(topic, &rs.Msg) && !p.isSuperseded(group, topic, &rs.Msg) &&
+			!p.isDuplicate(group, topic, &rs.Msg) {
+			break
+		}
+		// Expired, superseded and duplicate messages, and those outside of
+		// the window are acknowledged right away, so that they are never
+		// offered again.
+		p.ackNow(group, topic, &rs.Msg)
+	}
+	// If fetching an offloaded value fails the message is not acknowledged,
+	// so it will be offered again after the ack timeout. Unless retrying
+	// cannot help, then the message is delivered with the reference and the
+	// error in headers, lest it holds back the committed offset forever.
+	if err := p.claim(topic, &rs.Msg); err != nil {
+		if !claimcheck.IsPermanent(err) {
+			return consumer.Message{}, err
+		}
+		p.actDesc.Log().WithError(err).WithFields(log.Fields{
+			"kafka.group":     group,
+			"kafka.topic":     topic,
+			"kafka.partition": rs.Msg.Partition,
+		}).Errorf("Offloaded value cannot be fetched, delivering as is: offset=%d", rs.Msg.Offset)
+		rs.Msg.Headers = append(rs.Msg.Headers, &sarama.RecordHeader{
+			Key: []byte(claimcheck.ErrorHeader), Value: []byte(err.Error())})
+	}
+	stampDelivery(&rs.Msg)
+	rs.Msg.DataLoss = dataLoss
+	if ctx.Err() != nil {
+		eventsChID := eventsChID{group, topic, rs.Msg.Partition}
+		p.reclaim(group, topic, &rs.Msg, p.takeChunkOffsets(eventsChID, rs.Msg.Offset))
+		return consumer.Message{}, ctx.Err()
+	}
+
+	eventsChID := eventsChID{group, topic, rs.Msg.Partition}
+	p.eventsChMapMu.Lock()
+	// A new events channel means that the partition has been claimed again.
+	if p.eventsChMap[eventsChID] != rs.Msg.EventsCh {
+		p.claimGenerations[eventsChID] = rs.Msg.Generation
+	}
+	p.eventsChMap[eventsChID] = rs.Msg.EventsCh
+	p.eventsChMapMu.Unlock()
+
+	if ack == autoAck {
+		p.ackNow(group, topic, &rs.Msg)
+	} else if ackTimeout > 0 {
+		timeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+		if err := p.sendExtend(rs.Msg.EventsCh, eventsChID, rs.Msg.Offset, ackTimeout, timeout); err != nil {
+			p.actDesc.Log().WithFields(log.Fields{
+				"kafka.group":     group,
+				"kafka.topic":     topic,
+				"kafka.partition": rs.Msg.Partition,
+			}).Errorf("extend timeout: offset=%d", rs.Msg.Offset)
+		}
+	}
+	tap.PublishConsumed(p.cfg.Cluster, group, &rs.Msg.ConsumerMessage)
+	p.throughput.OnConsumed(group, &rs.Msg.ConsumerMessage)
+	consumed()
+	return rs.Msg, nil
+}
+
+// onDataLoss records messages of a partition that were removed by retention
+// before the group consumed them.
+func (p *T) onDataLoss(group, topic string, msg *consumer.Message) {
+	p.dataLossMu.Lock()
+	p.dataLoss[topic] += msg.DataLoss
+	p.dataLossMu.Unlock()
+	p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":     group,
+		"kafka.topic":     topic,
+		"kafka.partition": msg.Partition,
+	}).Warnf("Messages lost to retention: count=%d, offset=%d", msg.DataLoss, msg.Offset)
+}
+
+// assemble passes chunks of large messages to the assembler. It returns
+// true if the message is ready to be delivered, that is either it is not a
+// chunk or it has just been reassembled, in which case msg is replaced with
+// the reassembled message.
+func (p *T) assemble(group, topic string, msg *consumer.Message) bool {
+	for _, exp := range p.assembler.Expire() {
+		p.actDesc.Log().WithFields(log.Fields{
+			"kafka.group":     group,
+			"kafka.topic":     exp.Msg.Topic,
+			"kafka.partition": exp.Msg.Partition,
+		}).Errorf("Incomplete chunked message dropped: offsets=%v", exp.Offsets)
+		for _, offset := range exp.Offsets {
+			exp.Msg.EventsCh <- consumer.Ack(offset)
+		}
+	}
+	if !chunk.IsChunk(&msg.ConsumerMessage) {
+		return true
+	}
+	assembled, offsets, ok, err := p.assembler.Add(group, topic, *msg)
+	if err != nil {
+		p.actDesc.Log().WithError(err).Errorf("Failed to reassemble chunk, delivering as is: offset=%d", msg.Offset)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	*msg = assembled
+	p.chunkOffsetsMu.Lock()
+	p.chunkOffsets[chunkOffsetsID{eventsChID{group, topic, msg.Partition}, msg.Offset}] = offsets
+	p.chunkOffsetsMu.Unlock()
+	return true
+}
+
+// ackNow acknowledges a message that has just been consumed.
+func (p *T) ackNow(group, topic string, msg *consumer.Message) {
+	for _, offset := range p.takeChunkOffsets(eventsChID{group, topic, msg.Partition}, msg.Offset) {
+		msg.EventsCh <- consumer.Ack(offset)
+	}
+}
+
+// reclaim tells the partition consumer that a message at the specified
+// offsets has not been delivered to a client, so that it is offered again.
+func (p *T) reclaim(group, topic string, msg *consumer.Message, offsets []int64) {
+	p.holdBack(group, topic, msg, offsets, 0)
+}
+
+// holdBack makes messages with the given offsets of the partition of a
+// message, offered to a client that is not going to get them, be offered
+// again timeout from now, or right away if it is zero, without counting as
+// retries.
+func (p *T) holdBack(group, topic string, msg *consumer.Message, offsets []int64, timeout time.Duration) {
+	sendTimeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+	for _, offset := range offsets {
+		select {
+		case msg.EventsCh <- consumer.HoldBack(offset, timeout):
+		case <-sendTimeout:
+			p.actDesc.Log().WithFields(log.Fields{
+				"kafka.group":     group,
+				"kafka.topic":     topic,
+				"kafka.partition": msg.Partition,
+			}).Errorf("reclaim timeout: offset=%d", offset)
+			return
+		}
+	}
+}
+
+// sendExtend makes a message, that is all its chunks if it was reassembled
+// from chunks, be offered again if not acknowledged within ackTimeout from
+// now, unless the timeout fires first.
+func (p *T) sendExtend(eventsCh chan<- consumer.Event, eventsChID eventsChID, offset int64, ackTimeout time.Duration,
+	timeout <-chan time.Time,
+) error {
+	offsets := []int64{offset}
+	p.chunkOffsetsMu.Lock()
+	if chunkOffsets, ok := p.chunkOffsets[chunkOffsetsID{eventsChID, offset}]; ok {
+		offsets = chunkOffsets
+	}
+	p.chunkOffsetsMu.Unlock()
+	for _, offset := range offsets {
+		select {
+		case eventsCh <- consumer.Extend(offset, ackTimeout):
+		case <-timeout:
+			return errors.New("extend timeout")
+		}
+	}
+	return nil
+}
+
+// sendAck acknowledges a message, that is all its chunks if it was
+// reassembled from chunks, unless the timeout fires first.
+func (p *T) sendAck(eventsCh chan<- consumer.Event, eventsChID eventsChID, ack Ack, timeout <-chan time.Time) error {
+	// Metadata is attached to the last chunk, so that it is not overridden.
+	offsets := p.takeChunkOffsets(eventsChID, ack.offset)
+	for i, offset := range offsets {
+		event := consumer.Ack(offset)
+		if ack.metadata != "" && i == len(offsets)-1 {
+			event = consumer.AckWithMeta(offset, ack.metadata)
+		}
+		select {
+		case eventsCh <- event:
+		case <-timeout:
+			return errors.New("ack timeout")
+		}
+	}
+	return nil
+}
+
+// takeChunkOffsets returns offsets of all chunks that a message at the
+// specified offset was reassembled from, or just the offset itself if the
+// message was not chunked.
+func (p *T) takeChunkOffsets(eventsChID eventsChID, offset int64) []int64 {
+	p.chunkOffsetsMu.Lock()
+	defer p.chunkOffsetsMu.Unlock()
+	chunkOffsetsID := chunkOffsetsID{eventsChID, offset}
+	offsets, ok := p.chunkOffsets[chunkOffsetsID]
+	if !ok {
+		return []int64{offset}
+	}
+	delete(p.chunkOffsets, chunkOffsetsID)
+	return offsets
+}
+
+// LimitUsage tells how much of a concurrency limit is used. Zero limit values
+// mean no limit.
+type LimitUsage struct {
+	InFlight    int
+	Queued      int
+	Concurrency int
+	QueueSize   int
+}
+
+// Quotas describes usage of the limits configured for the cluster.
+type Quotas struct {
+	Consume LimitUsage
+	Produce LimitUsage
+
+	// Consumer groups counted against `limits.max_groups`. Groups are only
+	// tracked if the limit is set.
+	Groups    []string
+	MaxGroups int
+
+	// Connections open to the cluster brokers, and the cap on them.
+	BrokerConnections brokerconn.Stats
+
+	// Fetch throttling applied by the cluster brokers due to Kafka quotas,
+	// and whether it has happened recently. Produce throttling is not
+	// tracked, for sarama does not expose it.
+	Throttle  throttle.Stats
+	Throttled bool
+}
+
+// Quotas returns current usage of the limits configured for the cluster.
+func (p *T) Quotas() Quotas {
+	quotas := Quotas{
+		Consume: LimitUsage{
+			InFlight:    p.consumeLimiter.InFlight(),
+			Queued:      p.consumeLimiter.Queued(),
+			Concurrency: p.cfg.Limits.Consume.Concurrency,
+			QueueSize:   p.cfg.Limits.Consume.QueueSize,
+		},
+		Produce: LimitUsage{
+			InFlight:    p.produceLimiter.InFlight(),
+			Queued:      p.produceLimiter.Queued(),
+			Concurrency: p.cfg.Limits.Produce.Concurrency,
+			QueueSize:   p.cfg.Limits.Produce.QueueSize,
+		},
+		Groups:            []string{},
+		MaxGroups:         p.cfg.Limits.MaxGroups,
+		BrokerConnections: brokerconn.Get(p.cfg.Cluster),
+		Throttle:          throttle.Get(p.cfg.Cluster),
+		Throttled:         throttle.IsThrottled(p.cfg.Cluster),
+	}
+	p.groupsMu.Lock()
+	now := time.Now()
+	for g, lastSeen := range p.groups {
+		if now.Sub(lastSeen) <= p.cfg.Consumer.SubscriptionTimeout {
+			quotas.Groups = append(quotas.Groups, g)
+		}
+	}
+	p.groupsMu.Unlock()
+	sort.Strings(quotas.Groups)
+	return quotas
+}
+
+// Subscribe makes this Kafka-Pixy instance subscribe to a topic on behalf of
+// a consumer group right away, rather than on the first consume request. So
+// partitions of the topic get assigned and messages prefetched before they
+// are consumed.
+func (p *T) Subscribe(group, topic string) error {
+	if p.cfg.Consumer.Disabled {
+		return ErrDisabled
+	}
+	if err := p.checkGroupLimit(group); err != nil {
+		return err
+	}
+	if err := p.checkMigration(group); err != nil {
+		return err
+	}
+	p.consumerMu.RLock()
+	defer p.consumerMu.RUnlock()
+	if p.consumer == nil {
+		return ErrUnavailable
+	}
+	return p.consumer.Subscribe(group, topic)
+}
+
+// Unsubscribe makes this Kafka-Pixy instance unsubscribe from a topic on
+// behalf of a consumer group right away, rather than when the topic has not
+// been consumed for `consumer.subscription_timeout`. Only partitions of the
+// topic are rebalanced, consumption of other topics by the group goes on
+// uninterrupted.
+func (p *T) Unsubscribe(group, topic string) error {
+	if p.cfg.Consumer.Disabled {
+		return ErrDisabled
+	}
+	p.consumerMu.RLock()
+	defer p.consumerMu.RUnlock()
+	if p.consumer == nil {
+		return ErrUnavailable
+	}
+	return p.consumer.Unsubscribe(group, topic)
+}
+
+// checkGroupLimit makes sure that consuming on behalf of the group does not
+// exceed the limit on the number of groups. Groups that have not consumed
+// for longer than the subscription timeout are not counted.
+func (p *T) checkGroupLimit(group string) error {
+	if p.cfg.Limits.MaxGroups <= 0 {
+		return nil
+	}
+	p.groupsMu.Lock()
+	defer p.groupsMu.Unlock()
+	now := time.Now()
+	if _, ok := p.groups[group]; !ok {
+		for g, lastSeen := range p.groups {
+			if now.Sub(lastSeen) > p.cfg.Consumer.SubscriptionTimeout {
+				delete(p.groups, g)
+			}
+		}
+		if len(p.groups) >= p.cfg.Limits.MaxGroups {
+			return ErrTooManyGroups
+		}
+	}
+	p.groups[group] = now
+	return nil
+}
+
+// isExpired checks if a message is older than the maximum age configured for
+// the topic. Messages without a timestamp never expire.
+func (p *T) isExpired(topic string, msg *consumer.Message) bool {
+	maxAge, ok := p.cfg.Consumer.MaxAge[topic]
+	if !ok || msg.Timestamp.IsZero() {
+		return false
+	}
+	return time.Since(msg.Timestamp) > maxAge
+}
+
+// isOverdue checks if a message that is offered again was first offered
+// longer than `consumer.max_processing_time` ago.
+func (p *T) isOverdue(msg *consumer.Message) bool {
+	maxProcessingTime := p.cfg.Consumer.MaxProcessingTime
+	if maxProcessingTime <= 0 || msg.OfferedAt.IsZero() {
+		return false
+	}
+	return time.Since(msg.OfferedAt) >= maxProcessingTime
+}
+
+// deadLetterOverdue writes a message that was not acknowledged within
+// `consumer.max_processing_time` to the dead letter sink, if there is one.
+func (p *T) deadLetterOverdue(group, topic string, msg *consumer.Message) {
+	p.processingTimeoutsMu.Lock()
+	p.processingTimeouts[topic]++
+	p.processingTimeoutsMu.Unlock()
+	p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":     group,
+		"kafka.topic":     topic,
+		"kafka.partition": msg.Partition,
+	}).Errorf("Processing timeout: offset=%d, offeredAt=%v", msg.Offset, msg.OfferedAt)
+	if p.deadLetters == nil {
+		return
+	}
+	prodMsg := sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(msg.Value)}
+	if msg.Key != nil {
+		prodMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+	for _, h := range msg.Headers {
+		prodMsg.Headers = append(prodMsg.Headers, *h)
+	}
+	p.deadLetters.Put(&prodMsg, errProcessingTimeout)
+}
+
+// isDuplicate checks if a message with the same ID has already been consumed
+// by the group within its de-duplication window.
+func (p *T) isDuplicate(group, topic string, msg *consumer.Message) bool {
+	window := p.dedupWindows[group]
+	if window == nil {
+		return false
+	}
+	var id []byte
+	if header := p.cfg.Consumer.Dedup[group].Header; header != "" {
+		for _, h := range msg.Headers {
+			if string(h.Key) == header {
+				id = h.Value
+				break
+			}
+		}
+	} else {
+		id = msg.Key
+	}
+	if id == nil {
+		return false
+	}
+	if !window.IsDuplicate(topic+"\x00"+string(id), msg.Partition, msg.Offset) {
+		return false
+	}
+	p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":     group,
+		"kafka.topic":     topic,
+		"kafka.partition": msg.Partition,
+	}).Infof("Duplicate suppressed: offset=%d", msg.Offset)
+	return true
+}
+
+func (p *T) Ack(group, topic string, ack Ack) error {
+	eventsChID := eventsChID{group, topic, ack.partition}
+	eventsCh, err := p.getEventsCh(eventsChID, ack)
+	if err != nil {
+		return err
+	}
+	return p.sendAck(eventsCh, eventsChID, ack, time.After(p.cfg.Consumer.LongPollingTimeout))
+}
+
+// Extend postpones the moment when a message consumed earlier is offered
+// again, if it is not acknowledged, to ackTimeout from now. It lets a consumer
+// that is still handling a message send heartbeats rather than consume with a
+// very long ack timeout. If ackTimeout is zero, then `consumer.ack_timeout`
+// is used.
+func (p *T) Extend(group, topic string, ack Ack, ackTimeout time.Duration) error {
+	if ackTimeout > p.cfg.MaxAckTimeout() {
+		return ErrAckTimeoutTooLong
+	}
+	if ackTimeout == 0 {
+		ackTimeout = p.cfg.Consumer.AckTimeout
+	}
+	eventsChID := eventsChID{group, topic, ack.partition}
+	eventsCh, err := p.getEventsCh(eventsChID, ack)
+	if err != nil {
+		return err
+	}
+	return p.sendExtend(eventsCh, eventsChID, ack.offset, ackTimeout, time.After(p.cfg.Consumer.LongPollingTimeout))
+}
+
+// AckBatch acknowledges several messages. Acknowledgements are grouped by
+// partition, so that the consumer of a partition is looked up once, and
+// those of a partition are sent in the order of offsets, duplicates once. If
+// some of them can not be applied the others still are. It returns an error
+// for every acknowledgement in the order they are listed, nil if it was
+// applied.
+func (p *T) AckBatch(group, topic string, acks []Ack) []error {
+	errs := make([]error, len(acks))
+	var partitions []int32
+	byPartition := make(map[int32][]int)
+	for i, ack := range acks {
+		if _, ok := byPartition[ack.partition]; !ok {
+			partitions = append(partitions, ack.partition)
+		}
+		byPartition[ack.partition] = append(byPartition[ack.partition], i)
+	}
+	for _, partition := range partitions {
+		indices := byPartition[partition]
+		sort.SliceStable(indices, func(i, j int) bool { return acks[indices[i]].offset < acks[indices[j]].offset })
+		eventsChID := eventsChID{group, topic, partition}
+		// All acks of a partition share one timeout, once it fires the
+		// remaining ones fail too. Acks of a stale generation fail on their
+		// own.
+		timeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+		var err error
+		for j, i := range indices {
+			eventsCh, chErr := p.getEventsCh(eventsChID, acks[i])
+			if chErr != nil {
+				errs[i] = chErr
+				continue
+			}
+			if err == nil && (j == 0 || acks[indices[j-1]].offset != acks[i].offset) {
+				err = p.sendAck(eventsCh, eventsChID, acks[i], timeout)
+			}
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+const (
+	// DeliveryAttemptHeader is added to messages that are offered again, it
+	// tells how many times the message has been offered, not counting offers
+	// reclaimed from clients that disconnected.
+	DeliveryAttemptHeader = "x-pixy-delivery-attempt"
+	// FirstOfferedAtHeader is added to messages that are offered again, it
+	// tells when the message was first offered in the RFC3339 format.
+	FirstOfferedAtHeader = "x-pixy-first-offered-at"
+)
+
+// MaxForceAckCount is the maximum number of offsets that can be forcibly
+// acknowledged in one call.
+const MaxForceAckCount = 1000
+
+// ForceAckAudit tells who forcibly acknowledges messages and why, for the
+// record in the log.
+type ForceAckAudit struct {
+	By         string
+	Reason     string
+	RequestID  string
+	RemoteAddr string
+}
+
+// ForceAck acknowledges count offsets of a partition starting with the given
+// one on behalf of a consumer group, regardless of whether messages at them
+// have been offered to clients, so that the group can get past a poison
+// message that its clients fail to handle. Offsets have to be within the
+// partition range, otherwise ErrOutOfRange is returned, and the partition has
+// to be consumed by the group at this Kafka-Pixy instance, otherwise
+// ErrNotConsumedHere is returned. Every call is recorded in the log along
+// with who made it and why.
+func (p *T) ForceAck(group, topic string, partition int32, offset int64, count int, audit ForceAckAudit) error {
+	if p.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if count < 1 || count > MaxForceAckCount {
+		return errors.Errorf("bad count: %d", count)
+	}
+	if audit.By == "" || audit.Reason == "" {
+		return errors.New("who forces acks and why must be given")
+	}
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return err
+	}
+	var po *admin.PartitionOffset
+	for i := range offsets {
+		if offsets[i].Partition == partition {
+			po = &offsets[i]
+			break
+		}
+	}
+	if po == nil {
+		return errors.Wrapf(sarama.ErrUnknownTopicOrPartition, "partition=%d", partition)
+	}
+	end := offset + int64(count)
+	if offset < po.Begin || end > po.End {
+		return errors.Wrapf(ErrOutOfRange, "offsets=%d-%d, range=%d-%d", offset, end-1, po.Begin, po.End-1)
+	}
+	eventsChID := eventsChID{group, topic, partition}
+	p.eventsChMapMu.RLock()
+	eventsCh, ok := p.eventsChMap[eventsChID]
+	p.eventsChMapMu.RUnlock()
+	if !ok {
+		return ErrNotConsumedHere
+	}
+	p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":      group,
+		"kafka.topic":      topic,
+		"kafka.partition":  partition,
+		"audit.by":         audit.By,
+		"audit.reason":     audit.Reason,
+		"audit.requestID":  audit.RequestID,
+		"audit.remoteAddr": audit.RemoteAddr,
+	}).Warnf("Force ack: offsets=%d-%d", offset, end-1)
+
+	timeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+	for ; offset < end; offset++ {
+		if err := p.sendAck(eventsCh, eventsChID, Ack{partition: partition, offset: offset}, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetPartition describes how a partition has been reset to the end.
+type ResetPartition struct {
+	Partition int32
+	// The offset committed by the group before the reset.
+	Offset int64
+	// The offset the group has been fast-forwarded to.
+	End int64
+	// The number of messages skipped, it is only known if the partition has
+	// been reset and the group had an offset committed.
+	Skipped int64
+	// The client ID of another Kafka-Pixy instance that consumes the
+	// partition, in which case the partition has not been reset.
+	Owner string
+}
+
+// ResetGroupToEnd fast-forwards a consumer group to the end of every
+// partition of a topic, so that the group skips its backlog. Partitions that
+// the group consumes at this Kafka-Pixy instance are reset by their partition
+// consumers, so that pending offers are dropped and the group carries on from
+// the end without a restart. Partitions that nobody consumes are reset by
+// committing offsets directly. Partitions consumed by the group at other
+// Kafka-Pixy instances are left alone and reported with their owners, since
+// those instances would override committed offsets. Every call is recorded in
+// the log along with who made it and why.
+func (p *T) ResetGroupToEnd(group, topic string, audit ForceAckAudit) ([]ResetPartition, error) {
+	if p.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if audit.By == "" || audit.Reason == "" {
+		return nil, errors.New("who resets the group and why must be given")
+	}
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[int32]string)
+	groupOwners, err := p.GetGroupPartitionOwners(group)
+	if err != nil {
+		if _, ok := err.(admin.ErrInvalidParam); !ok {
+			return nil, err
+		}
+	}
+	for _, po := range groupOwners[topic] {
+		owners[po.Partition] = po.Owner
+	}
+	p.actDesc.Log().WithFields(log.Fields{
+		"kafka.group":      group,
+		"kafka.topic":      topic,
+		"audit.by":         audit.By,
+		"audit.reason":     audit.Reason,
+		"audit.requestID":  audit.RequestID,
+		"audit.remoteAddr": audit.RemoteAddr,
+	}).Warn("Reset to end")
+
+	resets := make([]ResetPartition, len(offsets))
+	var uncommitted []admin.PartitionOffset
+	timeout := time.After(p.cfg.Consumer.LongPollingTimeout)
+	for i, po := range offsets {
+		resets[i] = ResetPartition{Partition: po.Partition, Offset: po.Offset, End: po.End}
+		if po.Offset >= 0 && po.End > po.Offset {
+			resets[i].Skipped = po.End - po.Offset
+		}
+		eventsChID := eventsChID{group, topic, po.Partition}
+		p.eventsChMapMu.RLock()
+		eventsCh, ok := p.eventsChMap[eventsChID]
+		p.eventsChMapMu.RUnlock()
+		if ok {
+			select {
+			case eventsCh <- consumer.SkipTo(po.End):
+			case <-timeout:
+				return nil, errors.Errorf("reset timeout, partition=%d", po.Partition)
+			}
+			continue
+		}
+		if owner := owners[po.Partition]; owner != "" {
+			resets[i].Owner = owner
+			resets[i].Skipped = 0
+			continue
+		}
+		uncommitted = append(uncommitted, admin.PartitionOffset{Partition: po.Partition, Offset: po.End})
+	}
+	if len(uncommitted) > 0 {
+		if err := p.SetGroupOffsets(group, topic, uncommitted); err != nil {
+			return nil, err
+		}
+	}
+	return resets, nil
+}
+
+// getEventsCh returns the events channel of the partition consumer that an
+// ack should be sent to. If the ack carries a group generation earlier than
+// the one the partition was last claimed in, then ErrStaleGeneration is
+// returned and the ack is counted as fenced.
+func (p *T) getEventsCh(eventsChID eventsChID, ack Ack) (chan<- consumer.Event, error) {
+	p.eventsChMapMu.RLock()
+	eventsCh, ok := p.eventsChMap[eventsChID]
+	claimGeneration := p.claimGenerations[eventsChID]
+	p.eventsChMapMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("acks channel missing for %v", eventsChID)
+	}
+	if ack.generation > 0 && ack.generation < claimGeneration {
+		p.fencedAcksMu.Lock()
+		p.fencedAcks[eventsChID.topic]++
+		p.fencedAcksMu.Unlock()
+		return nil, ErrStaleGeneration
+	}
+	return eventsCh, nil
+}
+
+// GetGroupOffsets for every partition of the specified topic it returns the
+// current offset range along with the latest offset and metadata committed by
 // the specified consumer group.
 func (p *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error) {
 	p.adminMu.RLock()
@@ -299,9 +2255,81 @@ func (p *T) GetGroupOffsets(group, topic string) ([]admin.PartitionOffset, error
 	return p.admin.GetGroupOffsets(group, topic)
 }
 
+// IsEndOfStream tells whether a consumer group has consumed all messages
+// that are currently available in a topic, that is it has committed offsets
+// of all partitions up to their ends. Messages that have been consumed but not
+// acknowledged yet are not considered consumed.
+func (p *T) IsEndOfStream(group, topic string) (bool, error) {
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return false, err
+	}
+	for _, po := range offsets {
+		consumed := po.Offset
+		if consumed < po.Begin {
+			consumed = po.Begin
+		}
+		if consumed < po.End {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// timeoutOrStop returns ErrStopReached if a consume request timed out
+// because the group has consumed all messages before the stop point, or
+// ErrRequestTimeout otherwise.
+func (p *T) timeoutOrStop(group, topic string, stop Stop) error {
+	if stop.isSet() && p.isStopReached(group, topic, stop) {
+		return ErrStopReached
+	}
+	return consumer.ErrRequestTimeout
+}
+
+// isStopReached tells whether a group has committed offsets of all messages
+// of a topic before the stop point. If it cannot be told, then it has not.
+func (p *T) isStopReached(group, topic string, stop Stop) bool {
+	if !stop.at.IsZero() {
+		progress, err := p.GetGroupProgress(group, topic, admin.ProgressTarget{Timestamp: stop.at})
+		return err == nil && progress.Reached
+	}
+	offsets, err := p.GetGroupOffsets(group, topic)
+	if err != nil {
+		return false
+	}
+	for _, po := range offsets {
+		offset, ok := stop.offsets[po.Partition]
+		if !ok {
+			continue
+		}
+		consumed := po.Offset
+		if consumed < po.Begin {
+			consumed = po.Begin
+		}
+		if consumed < offset {
+			return false
+		}
+	}
+	return true
+}
+
+// GetGroupProgress tells whether a consumer group has consumed a topic up to
+// a target offset or timestamp, see admin.T.GetGroupProgress.
+func (p *T) GetGroupProgress(group, topic string, target admin.ProgressTarget) (admin.Progress, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return admin.Progress{}, ErrUnavailable
+	}
+	return p.admin.GetGroupProgress(group, topic, target)
+}
+
 // SetGroupOffsets commits specific offset values along with metadata for a list
 // of partitions of a particular topic on behalf of the specified group.
 func (p *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset) error {
+	if p.IsReadOnly() {
+		return ErrReadOnly
+	}
 	p.adminMu.RLock()
 	defer p.adminMu.RUnlock()
 	if p.admin == nil {
@@ -310,6 +2338,179 @@ func (p *T) SetGroupOffsets(group, topic string, offsets []admin.PartitionOffset
 	return p.admin.SetGroupOffsets(group, topic, offsets)
 }
 
+// TranslateGroupOffsets maps offsets committed by a group for a topic in the
+// cluster to offsets in the target cluster that the topic is mirrored to, and
+// commits them on behalf of the same group in the target cluster. The target
+// proxy must be different from this one.
+func (p *T) TranslateGroupOffsets(target *T, group, topic string) ([]admin.OffsetTranslation, error) {
+	if target.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	target.adminMu.RLock()
+	defer target.adminMu.RUnlock()
+	if p.admin == nil || target.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return admin.TranslateGroupOffsets(p.admin, target.admin, group, topic)
+}
+
+// CloneGroupOffsets commits offsets committed by a consumer group for a topic
+// on behalf of a new group, see admin.T.CloneGroupOffsets.
+func (p *T) CloneGroupOffsets(group, clone, topic string) ([]admin.PartitionOffset, error) {
+	if p.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.CloneGroupOffsets(group, clone, topic)
+}
+
+// FreezeGroup freezes committed offsets of a consumer group for a period on
+// all Kafka-Pixy instances working with the cluster, and returns the time the
+// freeze ends at. See the freeze package for details.
+func (p *T) FreezeGroup(group string, period time.Duration) (time.Time, error) {
+	if p.IsReadOnly() {
+		return time.Time{}, ErrReadOnly
+	}
+	if p.freezes == nil {
+		return time.Time{}, ErrDisabled
+	}
+	return p.freezes.Freeze(group, period)
+}
+
+// UnfreezeGroup lifts the freeze of a consumer group.
+func (p *T) UnfreezeGroup(group string) error {
+	if p.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if p.freezes == nil {
+		return ErrDisabled
+	}
+	return p.freezes.Unfreeze(group)
+}
+
+// GetGroupFreeze returns the time that the freeze of a consumer group ends
+// at, or a zero time if the group is not frozen.
+func (p *T) GetGroupFreeze(group string) (time.Time, error) {
+	if p.freezes == nil {
+		return time.Time{}, ErrDisabled
+	}
+	return p.freezes.FrozenUntil(group)
+}
+
+// GetIdleGroups returns consumer groups that have no members along with the
+// time they are going to be purged at.
+func (p *T) GetIdleGroups() ([]janitor.IdleGroup, error) {
+	if p.janitor == nil {
+		return nil, ErrDisabled
+	}
+	return p.janitor.Scan()
+}
+
+// GetKafkaCredentials returns the status of credentials that connections to
+// brokers are secured with.
+func (p *T) GetKafkaCredentials() (secureconn.Status, error) {
+	if p.kafkaCreds == nil {
+		return secureconn.Status{}, ErrDisabled
+	}
+	return p.kafkaCreds.Status(), nil
+}
+
+// UpdateKafkaCredentials makes connections to brokers established from now
+// on use the given credentials.
+func (p *T) UpdateKafkaCredentials(creds secureconn.Credentials) error {
+	if p.kafkaCreds == nil {
+		return ErrDisabled
+	}
+	return p.kafkaCreds.Update(creds)
+}
+
+// GetLagSLOStatuses returns statuses of all configured consumer lag
+// objectives sorted by group.
+func (p *T) GetLagSLOStatuses() ([]lagslo.Status, error) {
+	if p.lagSLOs == nil {
+		return nil, ErrDisabled
+	}
+	return p.lagSLOs.Statuses(), nil
+}
+
+// GetProduceSLOStatuses returns statuses of all configured produce latency
+// objectives sorted by topic.
+func (p *T) GetProduceSLOStatuses() ([]prodslo.Status, error) {
+	if p.produceSLOs == nil {
+		return nil, ErrDisabled
+	}
+	return p.produceSLOs.Statuses(), nil
+}
+
+// GetHealthProbeStatuses returns statuses of all configured downstream
+// health probes of consumer groups sorted by group.
+func (p *T) GetHealthProbeStatuses() ([]healthprobe.Status, error) {
+	if p.healthProbes == nil {
+		return nil, ErrDisabled
+	}
+	return p.healthProbes.Statuses(), nil
+}
+
+// waitGroupResumed waits for a group paused because its downstream health
+// probe fails, or by a maintenance window, to be resumed. If the group is not
+// resumed within the long polling timeout, then the request times out as if
+// there were no messages, so that clients keep polling as usual.
+func (p *T) waitGroupResumed(ctx context.Context, group string) error {
+	var timeoutCh <-chan time.Time
+	for {
+		resumedCh := p.groupPaused(group)
+		if resumedCh == nil {
+			return nil
+		}
+		if timeoutCh == nil {
+			timer := time.NewTimer(p.cfg.Consumer.LongPollingTimeout)
+			defer timer.Stop()
+			timeoutCh = timer.C
+		}
+		select {
+		case <-resumedCh:
+		case <-timeoutCh:
+			return consumer.ErrRequestTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// groupPaused returns nil if a group is not paused, or a channel that is
+// closed when the reason it is paused for is gone otherwise. Note that it
+// may still be paused for another reason by then.
+func (p *T) groupPaused(group string) <-chan none.T {
+	if p.healthProbes != nil {
+		if resumedCh := p.healthProbes.Paused(group); resumedCh != nil {
+			return resumedCh
+		}
+	}
+	if p.maintenance != nil {
+		return p.maintenance.Paused(group)
+	}
+	return nil
+}
+
+// GetGroupLagSLO returns the status of the consumer lag objective of a group,
+// or lagslo.ErrNotFound if the group has none.
+func (p *T) GetGroupLagSLO(group string) (lagslo.Status, error) {
+	if p.lagSLOs == nil {
+		return lagslo.Status{}, ErrDisabled
+	}
+	status := p.lagSLOs.Status(group)
+	if status.Err == lagslo.ErrNotFound {
+		return lagslo.Status{}, lagslo.ErrNotFound
+	}
+	return status, nil
+}
+
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
@@ -321,6 +2522,46 @@ func (p *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 	return p.admin.GetTopicConsumers(group, topic)
 }
 
+// DescribeGroup returns the generation of a consumer group along with its
+// members and their subscriptions.
+func (p *T) DescribeGroup(group string) (admin.GroupDescription, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return admin.GroupDescription{}, ErrUnavailable
+	}
+	return p.admin.DescribeGroup(group)
+}
+
+// GetRebalanceHistory returns the latest rebalances of a consumer group as
+// seen by this Kafka-Pixy instance, the latest first.
+func (p *T) GetRebalanceHistory(group string) []rebalancelog.Rebalance {
+	return rebalancelog.Get(p.cfg.Cluster, group)
+}
+
+// GetGroupOffers returns messages offered to clients of a consumer group at
+// this instance but not acknowledged yet, by partition.
+func (p *T) GetGroupOffers(group string) []inflight.PartitionOffers {
+	return inflight.GetOffers(p.cfg.Cluster, group)
+}
+
+// GetGroupConcurrency returns what a consumer group has in flight at this
+// Kafka-Pixy instance.
+func (p *T) GetGroupConcurrency(group string) inflight.Report {
+	return inflight.GetReport(p.cfg.Cluster, group)
+}
+
+// GetGroupPartitionOwners returns topic -> partition-owners-list mapping for
+// all topics consumed by a consumer group.
+func (p *T) GetGroupPartitionOwners(group string) (map[string][]admin.PartitionOwner, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.GetGroupPartitionOwners(group)
+}
+
 // GetAllTopicConsumers returns group -> client-id -> consumed-partitions-list
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
@@ -343,6 +2584,25 @@ func (p *T) ListTopics(withPartitions, withConfig bool) ([]admin.TopicMetadata,
 	return p.admin.ListTopics(withPartitions, withConfig)
 }
 
+// RefreshMetadata forces a refresh of cached cluster metadata, that topic
+// listings are served from, and makes the proxy check again whether topics
+// exist before producing to them.
+func (p *T) RefreshMetadata() error {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	if err := p.admin.RefreshMetadata(); err != nil {
+		return err
+	}
+	p.knownTopicsMu.Lock()
+	p.knownTopics = make(map[string]bool)
+	p.maxMessageBytes = make(map[string]cachedMaxMessageBytes)
+	p.knownTopicsMu.Unlock()
+	return nil
+}
+
 // GetTopicMetadata returns a topic metadata. An optional partition metadata
 // can be requested and/or detailed topic configuration can be requested.
 func (p *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (admin.TopicMetadata, error) {
@@ -353,3 +2613,101 @@ func (p *T) GetTopicMetadata(topic string, withPartitions, withConfig bool) (adm
 	}
 	return p.admin.GetTopicMetadata(topic, withPartitions, withConfig)
 }
+
+// DescribeTopicConfig returns the effective configuration of a topic, that is
+// topic overrides along with broker defaults.
+func (p *T) DescribeTopicConfig(topic string) ([]admin.ConfigEntry, error) {
+	if !p.cfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, ErrConfigUnsupported
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.DescribeTopicConfig(topic)
+}
+
+// GetTopicOffsets returns the current offset range for every partition of
+// the specified topic.
+func (p *T) GetTopicOffsets(topic string) ([]admin.PartitionOffset, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.GetTopicOffsets(topic)
+}
+
+// ListGroups returns a sorted list of all consumer groups registered in
+// ZooKeeper.
+func (p *T) ListGroups() ([]string, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.ListGroups()
+}
+
+// PeekMessages returns up to `count` most recent messages of a topic
+// partition without committing offsets on behalf of any consumer group.
+func (p *T) PeekMessages(topic string, partition int32, count int) ([]admin.Message, error) {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.PeekMessages(topic, partition, count)
+}
+
+// FetchPartition returns up to `limit` messages of a topic partition starting
+// from `offset`. It bypasses consumer groups entirely, so the caller decides
+// what partitions to read and keeps track of its progress with `Checkpoint`.
+func (p *T) FetchPartition(topic string, partition int32, offset int64, limit int) ([]admin.Message, error) {
+	if p.cfg.Consumer.Disabled {
+		return nil, ErrDisabled
+	}
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return nil, ErrUnavailable
+	}
+	return p.admin.FetchMessages(topic, partition, offset, limit)
+}
+
+// GetCheckpoint returns the offset of a topic partition checkpointed under
+// the specified name, or the oldest offset of the partition if there is none.
+func (p *T) GetCheckpoint(name, topic string, partition int32) (int64, error) {
+	offsets, err := p.GetGroupOffsets(name, topic)
+	if err != nil {
+		return 0, err
+	}
+	for _, po := range offsets {
+		if po.Partition != partition {
+			continue
+		}
+		if po.Offset < 0 {
+			return po.Begin, nil
+		}
+		return po.Offset, nil
+	}
+	return 0, sarama.ErrUnknownTopicOrPartition
+}
+
+// Checkpoint commits the offset of the next message to fetch from a topic
+// partition under the specified name. Checkpoints are stored as offsets of a
+// consumer group with that name, but the group never has any members.
+func (p *T) Checkpoint(name, topic string, partition int32, offset int64) error {
+	return p.SetGroupOffsets(name, topic, []admin.PartitionOffset{{Partition: partition, Offset: offset}})
+}
+
+// GetTableEntry returns the latest value of a key in a table materialized
+// from the specified topic.
+func (p *T) GetTableEntry(topic, key string) (table.Entry, error) {
+	tbl := p.tables[topic]
+	if tbl == nil {
+		return table.Entry{}, ErrTableNotConfigured
+	}
+	return tbl.Get(key)
+}
@@ -1,34 +1,196 @@
 package proxy
 
 import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/pkg/errors"
 )
 
 // Set represents a collection of proxy.T instances with a default value.
+// Proxies can also be accessed by alias names, and the default proxy can be
+// changed at runtime, e.g. to repoint clients during a cluster migration.
 type Set struct {
-	proxies    map[string]*T
+	proxies map[string]*T
+	aliases map[string]string
+
+	// The default proxy as configured, it is restored by ResetDefault.
+	cfgDefaultPxy *T
+
+	defaultMu  sync.RWMutex
 	defaultPxy *T
+
+	routingHeader string
+	produceRoutes map[string]*produceRoute
+	defaultRoute  produceRoute
+}
+
+type produceRoute struct {
+	routed  int64 // accessed atomically
+	cluster string
+}
+
+// ProduceRouting describes produce routing rules along with the number of
+// messages routed by each of them since start.
+type ProduceRouting struct {
+	Header       string
+	Routes       []ProduceRoute
+	DefaultRoute ProduceRoute
+}
+
+// ProduceRoute describes a produce routing rule.
+type ProduceRoute struct {
+	HeaderValue string
+	Cluster     string
+	Routed      int64
 }
 
-// NewSet creates a proxy.Set from a cluster-to-proxy map and a default proxy.
-func NewSet(proxies map[string]*T, defaultPxy *T) *Set {
+// NewSet creates a proxy.Set from a cluster-to-proxy map, a default proxy,
+// and an alias-to-cluster map that can be nil.
+func NewSet(proxies map[string]*T, defaultPxy *T, aliases map[string]string) *Set {
 	if len(proxies) < 1 {
 		panic("set must contain at least one proxy")
 	}
 	if defaultPxy == nil {
 		panic("default proxy must be provided")
 	}
-	return &Set{proxies: proxies, defaultPxy: defaultPxy}
+	for alias, cluster := range aliases {
+		if proxies[cluster] == nil {
+			panic("alias `" + alias + "` refers to unknown proxy `" + cluster + "`")
+		}
+	}
+	return &Set{
+		proxies:       proxies,
+		aliases:       aliases,
+		cfgDefaultPxy: defaultPxy,
+		defaultPxy:    defaultPxy,
+	}
 }
 
-// Get returns a proxy for a cluster name. If there is no proxy configured for
-// the cluster name, then the default proxy is returned.
+// Get returns a proxy for a cluster name or alias. If the cluster name is
+// empty, then the default proxy is returned.
 func (s *Set) Get(cluster string) (*T, error) {
 	if cluster == "" {
+		s.defaultMu.RLock()
+		defer s.defaultMu.RUnlock()
 		return s.defaultPxy, nil
 	}
+	if aliasOf, ok := s.aliases[cluster]; ok {
+		cluster = aliasOf
+	}
 	if pxy := s.proxies[cluster]; pxy != nil {
 		return pxy, nil
 	}
 	return nil, errors.Errorf("proxy `%s` does not exist", cluster)
 }
+
+// RouteProduces makes GetForProduce select clusters by a message header value
+// as the routing config prescribes. It must be called before the set is used.
+func (s *Set) RouteProduces(routing config.ProduceRouting) {
+	s.routingHeader = routing.Header
+	s.produceRoutes = make(map[string]*produceRoute, len(routing.Routes))
+	for value, cluster := range routing.Routes {
+		s.produceRoutes[value] = &produceRoute{cluster: cluster}
+	}
+	s.defaultRoute.cluster = routing.DefaultRoute
+}
+
+// GetForProduce returns a proxy that a message with the given headers should
+// be produced to. If the cluster name is empty and produce routing is
+// configured, then the cluster is selected by the routing header value.
+func (s *Set) GetForProduce(cluster string, headers []sarama.RecordHeader) (*T, error) {
+	if cluster != "" || s.routingHeader == "" {
+		return s.Get(cluster)
+	}
+	route := &s.defaultRoute
+	for _, header := range headers {
+		if strings.EqualFold(string(header.Key), s.routingHeader) {
+			if headerRoute := s.produceRoutes[string(header.Value)]; headerRoute != nil {
+				route = headerRoute
+			}
+			break
+		}
+	}
+	atomic.AddInt64(&route.routed, 1)
+	return s.Get(route.cluster)
+}
+
+// ProduceRouting returns produce routing rules sorted by header value. If
+// the default route is not configured, then it is reported with the name of
+// the current default cluster.
+func (s *Set) ProduceRouting() ProduceRouting {
+	routing := ProduceRouting{
+		Header: s.routingHeader,
+		Routes: make([]ProduceRoute, 0, len(s.produceRoutes)),
+		DefaultRoute: ProduceRoute{
+			Cluster: s.defaultRoute.cluster,
+			Routed:  atomic.LoadInt64(&s.defaultRoute.routed),
+		},
+	}
+	if routing.DefaultRoute.Cluster == "" {
+		routing.DefaultRoute.Cluster = s.Default()
+	}
+	for value, route := range s.produceRoutes {
+		routing.Routes = append(routing.Routes, ProduceRoute{
+			HeaderValue: value,
+			Cluster:     route.cluster,
+			Routed:      atomic.LoadInt64(&route.routed),
+		})
+	}
+	sort.Slice(routing.Routes, func(i, j int) bool {
+		return routing.Routes[i].HeaderValue < routing.Routes[j].HeaderValue
+	})
+	return routing
+}
+
+// Default returns the name of the default cluster.
+func (s *Set) Default() string {
+	s.defaultMu.RLock()
+	defer s.defaultMu.RUnlock()
+	return s.defaultPxy.cfg.Cluster
+}
+
+// SetDefault makes the proxy of a cluster name or alias the default one.
+func (s *Set) SetDefault(cluster string) error {
+	if cluster == "" {
+		return errors.New("cluster must be specified")
+	}
+	pxy, err := s.Get(cluster)
+	if err != nil {
+		return err
+	}
+	s.defaultMu.Lock()
+	s.defaultPxy = pxy
+	s.defaultMu.Unlock()
+	return nil
+}
+
+// ResetDefault restores the configured default proxy.
+func (s *Set) ResetDefault() {
+	s.defaultMu.Lock()
+	s.defaultPxy = s.cfgDefaultPxy
+	s.defaultMu.Unlock()
+}
+
+// Clusters returns a sorted list of names of all clusters in the set.
+func (s *Set) Clusters() []string {
+	clusters := make([]string, 0, len(s.proxies))
+	for cluster := range s.proxies {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// Aliases returns a copy of the alias-to-cluster map of the set.
+func (s *Set) Aliases() map[string]string {
+	aliases := make(map[string]string, len(s.aliases))
+	for alias, cluster := range s.aliases {
+		aliases[alias] = cluster
+	}
+	return aliases
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"regexp"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// topicFilter decides what topics can be produced to by a whitelist and a
+// blacklist of regular expressions that match entire topic names.
+type topicFilter struct {
+	whitelist []*regexp.Regexp
+	blacklist []*regexp.Regexp
+}
+
+func newTopicFilter(whitelist, blacklist []string) (*topicFilter, error) {
+	var f topicFilter
+	var err error
+	if f.whitelist, err = compileTopicPatterns(whitelist); err != nil {
+		return nil, errors.Wrap(err, "invalid whitelist")
+	}
+	if f.blacklist, err = compileTopicPatterns(blacklist); err != nil {
+		return nil, errors.Wrap(err, "invalid blacklist")
+	}
+	return &f, nil
+}
+
+// allows tells whether a topic can be produced to.
+func (f *topicFilter) allows(topic string) bool {
+	for _, re := range f.blacklist {
+		if re.MatchString(topic) {
+			return false
+		}
+	}
+	if len(f.whitelist) == 0 {
+		return true
+	}
+	for _, re := range f.whitelist {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileTopicPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := config.CompileTopicPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxMessageBytesTTL      = 5 * time.Minute
+	maxMessageBytesRetryTTL = 30 * time.Second
+)
+
+// cachedMaxMessageBytes is `max.message.bytes` of a topic, ok is false if it
+// could not be looked up.
+type cachedMaxMessageBytes struct {
+	limit     int
+	ok        bool
+	expiresAt time.Time
+}
+
+// ensureTopic makes sure that a topic exists before it is produced to. If it
+// does not, then it is either created, if `producer.create_missing_topics`
+// is set, or ErrUnknownTopic is returned.
+func (p *T) ensureTopic(topic string) error {
+	p.knownTopicsMu.Lock()
+	known := p.knownTopics[topic]
+	p.knownTopicsMu.Unlock()
+	if known {
+		return nil
+	}
+	exists, err := p.topicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if !p.cfg.Producer.CreateMissingTopics {
+			return ErrUnknownTopic
+		}
+		if err := p.createTopic(topic); err != nil {
+			return err
+		}
+		p.actDesc.Log().Infof("Created topic: topic=%s", topic)
+	}
+	p.knownTopicsMu.Lock()
+	p.knownTopics[topic] = true
+	p.knownTopicsMu.Unlock()
+	return nil
+}
+
+// checkMessageSize returns ErrMessageTooLarge if
+// `producer.check_max_message_bytes` is set and a message is larger than
+// `max.message.bytes` of the topic. If the limit cannot be looked up, then
+// the message is let through for Kafka to decide.
+func (p *T) checkMessageSize(topic string, key, message sarama.Encoder) error {
+	if !p.cfg.Producer.CheckMaxMessageBytes {
+		return nil
+	}
+	limit, ok := p.topicMaxMessageBytes(topic)
+	if !ok {
+		return nil
+	}
+	size := 0
+	if key != nil {
+		size += key.Length()
+	}
+	if message != nil {
+		size += message.Length()
+	}
+	if size > limit {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+// topicMaxMessageBytes returns `max.message.bytes` of a topic. It is looked
+// up at most once per maxMessageBytesTTL, so that changes to the topic
+// config are picked up eventually, or maxMessageBytesRetryTTL if the lookup
+// failed, so that a topic that cannot be described does not cost a
+// DescribeConfigs request per produce.
+func (p *T) topicMaxMessageBytes(topic string) (int, bool) {
+	now := time.Now()
+	p.knownTopicsMu.Lock()
+	cached, found := p.maxMessageBytes[topic]
+	fresh := found && now.Before(cached.expiresAt)
+	if !fresh {
+		// Produce requests that come while the limit is being looked up
+		// use the previous one, if any, rather than look it up too.
+		p.maxMessageBytes[topic] = cachedMaxMessageBytes{
+			limit: cached.limit, ok: cached.ok, expiresAt: now.Add(maxMessageBytesRetryTTL)}
+	}
+	p.knownTopicsMu.Unlock()
+	if fresh {
+		return cached.limit, cached.ok
+	}
+
+	limit, err := p.lookupMaxMessageBytes(topic)
+	cached = cachedMaxMessageBytes{limit: limit, ok: true, expiresAt: time.Now().Add(maxMessageBytesTTL)}
+	if err != nil {
+		p.actDesc.Log().WithError(err).Warnf("Failed to get max.message.bytes: topic=%s", topic)
+		cached = cachedMaxMessageBytes{expiresAt: time.Now().Add(maxMessageBytesRetryTTL)}
+	}
+	p.knownTopicsMu.Lock()
+	p.maxMessageBytes[topic] = cached
+	p.knownTopicsMu.Unlock()
+	return cached.limit, cached.ok
+}
+
+func (p *T) lookupMaxMessageBytes(topic string) (int, error) {
+	entries, err := p.DescribeTopicConfig(topic)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.Name != "max.message.bytes" {
+			continue
+		}
+		limit, err := strconv.Atoi(entry.Value)
+		return limit, errors.Wrapf(err, "bad max.message.bytes: %s", entry.Value)
+	}
+	return 0, errors.New("max.message.bytes is missing")
+}
+
+// topicExists checks whether a topic exists against metadata of all topics,
+// for a metadata request for a particular topic makes brokers that have
+// `auto.create.topics.enable` set create the topic.
+func (p *T) topicExists(topic string) (bool, error) {
+	if err := p.kafkaClt.RefreshMetadata(); err != nil {
+		return false, errors.Wrap(err, "failed to refresh metadata")
+	}
+	topics, err := p.kafkaClt.Topics()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get topics")
+	}
+	for _, t := range topics {
+		if t == topic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *T) createTopic(topic string) error {
+	p.adminMu.RLock()
+	defer p.adminMu.RUnlock()
+	if p.admin == nil {
+		return ErrUnavailable
+	}
+	err := p.admin.CreateTopic(topic, p.cfg.NewTopicDetail())
+	return errors.Wrap(err, "failed to create topic")
+}
@@ -0,0 +1,117 @@
+// Package rebalancelog keeps a history of the latest consumer group
+// rebalances, so that it is possible to tell why and when a group rebalanced
+// without correlating logs across the fleet. The history is kept process wide
+// per cluster and group, so it survives group consumers being disposed of
+// when idle, until the group has not rebalanced for Retention.
+package rebalancelog
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Trigger is what made a group rebalance.
+type Trigger string
+
+const (
+	// Members joined or left the group.
+	MembershipChanged Trigger = "membership_changed"
+	// Members of the group changed topics they are subscribed to.
+	SubscriptionsChanged Trigger = "subscriptions_changed"
+	// The previous rebalance failed and is being retried.
+	Retry Trigger = "retry"
+)
+
+// Retention is how long the history of a group that does not rebalance
+// anymore is kept, so that histories of groups that are gone do not pile up.
+const Retention = 24 * time.Hour
+
+// Rebalance describes a rebalance as seen by this Kafka-Pixy instance.
+type Rebalance struct {
+	StartedAt  time.Time
+	Duration   time.Duration
+	Trigger    Trigger
+	Generation int32
+
+	// Group members before and after the rebalance, sorted.
+	MembersBefore []string
+	MembersAfter  []string
+
+	// Partitions assigned to this Kafka-Pixy instance, nil if failed.
+	Assigned map[string][]int32
+	Error    string
+}
+
+type groupID struct {
+	cluster string
+	group   string
+}
+
+type history struct {
+	rebalances []Rebalance
+	updatedAt  time.Time
+}
+
+var (
+	mu        sync.Mutex
+	histories = make(map[groupID]*history)
+
+	// For tests only!
+	now = time.Now
+)
+
+// Record adds a rebalance to the history of a group, dropping the oldest
+// rebalances beyond the specified size limit. If the limit is not positive,
+// then nothing is recorded. Histories of groups that have not rebalanced for
+// Retention are dropped.
+func Record(cluster, group string, rb Rebalance, limit int) {
+	if limit <= 0 {
+		return
+	}
+	id := groupID{cluster, group}
+	recordedAt := now()
+	mu.Lock()
+	defer mu.Unlock()
+	for otherID, h := range histories {
+		if recordedAt.Sub(h.updatedAt) >= Retention {
+			delete(histories, otherID)
+		}
+	}
+	h := histories[id]
+	if h == nil {
+		h = &history{}
+		histories[id] = h
+	}
+	h.rebalances = append(h.rebalances, rb)
+	if len(h.rebalances) > limit {
+		h.rebalances = append([]Rebalance(nil), h.rebalances[len(h.rebalances)-limit:]...)
+	}
+	h.updatedAt = recordedAt
+}
+
+// Get returns the recorded rebalances of a group, the latest first.
+func Get(cluster, group string) []Rebalance {
+	mu.Lock()
+	defer mu.Unlock()
+	h := histories[groupID{cluster, group}]
+	if h == nil || now().Sub(h.updatedAt) >= Retention {
+		return []Rebalance{}
+	}
+	latestFirst := make([]Rebalance, len(h.rebalances))
+	for i, rb := range h.rebalances {
+		latestFirst[len(h.rebalances)-1-i] = rb
+	}
+	return latestFirst
+}
+
+// Members returns sorted member IDs of a group given subscriptions of its
+// members.
+func Members(subscriptions map[string][]string) []string {
+	members := make([]string, 0, len(subscriptions))
+	for member := range subscriptions {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,79 @@
+package rebalancelog
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type RebalanceLogSuite struct{}
+
+var _ = Suite(&RebalanceLogSuite{})
+
+func (s *RebalanceLogSuite) SetUpTest(c *C) {
+	histories = make(map[groupID]*history)
+	now = time.Now
+}
+
+// The latest rebalances are returned first, and only the specified number of
+// them is kept.
+func (s *RebalanceLogSuite) TestRecord(c *C) {
+	for i := int32(1); i <= 4; i++ {
+		Record("c1", "g1", Rebalance{Generation: i}, 3)
+	}
+	Record("c1", "g2", Rebalance{Generation: 7}, 3)
+	Record("c2", "g1", Rebalance{Generation: 8}, 3)
+
+	// When
+	history := Get("c1", "g1")
+
+	// Then
+	c.Assert(len(history), Equals, 3)
+	c.Check(history[0].Generation, Equals, int32(4))
+	c.Check(history[1].Generation, Equals, int32(3))
+	c.Check(history[2].Generation, Equals, int32(2))
+	c.Check(Get("c1", "g2"), DeepEquals, []Rebalance{{Generation: 7}})
+	c.Check(Get("c2", "g1"), DeepEquals, []Rebalance{{Generation: 8}})
+	c.Check(Get("c2", "g2"), DeepEquals, []Rebalance{})
+}
+
+// Nothing is recorded if the history is disabled.
+func (s *RebalanceLogSuite) TestRecordDisabled(c *C) {
+	// When
+	Record("c1", "g1", Rebalance{Generation: 1}, 0)
+
+	// Then
+	c.Check(Get("c1", "g1"), DeepEquals, []Rebalance{})
+}
+
+// Histories of groups that have not rebalanced for the retention period are
+// dropped.
+func (s *RebalanceLogSuite) TestRetention(c *C) {
+	t0 := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t0 }
+	Record("c1", "g1", Rebalance{Generation: 1}, 3)
+	Record("c1", "g2", Rebalance{Generation: 2}, 3)
+	now = func() time.Time { return t0.Add(Retention / 2) }
+	Record("c1", "g2", Rebalance{Generation: 3}, 3)
+
+	// When
+	now = func() time.Time { return t0.Add(Retention) }
+	c.Check(Get("c1", "g1"), DeepEquals, []Rebalance{})
+	Record("c1", "g3", Rebalance{Generation: 4}, 3)
+
+	// Then
+	c.Check(len(histories), Equals, 2)
+	c.Check(Get("c1", "g2"), DeepEquals, []Rebalance{{Generation: 3}, {Generation: 2}})
+	c.Check(Get("c1", "g3"), DeepEquals, []Rebalance{{Generation: 4}})
+}
+
+func (s *RebalanceLogSuite) TestMembers(c *C) {
+	c.Check(Members(map[string][]string{"m2": {"t1"}, "m1": {"t2"}, "m3": nil}),
+		DeepEquals, []string{"m1", "m2", "m3"})
+	c.Check(Members(nil), DeepEquals, []string{})
+}
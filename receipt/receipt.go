@@ -0,0 +1,99 @@
+// Package receipt delivers outcomes of asynchronous produce requests to
+// callback URLs given in the requests, so that fire-and-forget clients can
+// learn about messages that Kafka-Pixy failed to produce.
+package receipt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/pkg/errors"
+)
+
+// Receipt is posted as JSON to a callback URL when a message is either
+// acknowledged by Kafka or failed.
+type Receipt struct {
+	// ID of the produce request, to correlate a receipt with a request.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Partition and offset of the produced message, -1 if it failed.
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+
+	// Reason of the failure, empty if the message was produced.
+	Error string `json:"error,omitempty"`
+}
+
+// Sender posts receipts to callback URLs in the background.
+type Sender struct {
+	actDesc   *actor.Descriptor
+	whitelist []*regexp.Regexp
+	httpClt   *http.Client
+	wg        sync.WaitGroup
+}
+
+// NewSender creates a sender that posts receipts only to URLs that entirely
+// match one of the whitelist regular expressions.
+func NewSender(parentActDesc *actor.Descriptor, whitelist []string, timeout time.Duration) (*Sender, error) {
+	s := Sender{
+		actDesc:   parentActDesc.NewChild("receipt"),
+		whitelist: make([]*regexp.Regexp, len(whitelist)),
+		httpClt:   &http.Client{Timeout: timeout},
+	}
+	for i, pattern := range whitelist {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid callback URL pattern: %s", pattern)
+		}
+		s.whitelist[i] = re
+	}
+	return &s, nil
+}
+
+// Allows tells whether receipts can be posted to a URL.
+func (s *Sender) Allows(url string) bool {
+	for _, re := range s.whitelist {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send posts a receipt to a URL in the background. Delivery is attempted
+// once, failures are logged.
+func (s *Sender) Send(url string, rcpt Receipt) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.post(url, rcpt); err != nil {
+			s.actDesc.Log().WithError(err).Errorf("Failed to deliver receipt: url=%s, requestID=%s", url, rcpt.RequestID)
+		}
+	}()
+}
+
+// Stop waits for receipts that are being delivered.
+func (s *Sender) Stop() {
+	s.wg.Wait()
+}
+
+func (s *Sender) post(url string, rcpt Receipt) error {
+	body, err := json.Marshal(rcpt)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode receipt")
+	}
+	rs, err := s.httpClt.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+		return errors.Errorf("unexpected status: %d", rs.StatusCode)
+	}
+	return nil
+}
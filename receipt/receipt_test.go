@@ -0,0 +1,83 @@
+package receipt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ReceiptSuite struct{}
+
+var _ = Suite(&ReceiptSuite{})
+
+// Only URLs that entirely match a whitelist pattern are allowed.
+func (s *ReceiptSuite) TestAllows(c *C) {
+	sender, err := NewSender(actor.Root(), []string{"https://hooks\\.example\\.com/.*"}, time.Second)
+	c.Assert(err, IsNil)
+
+	c.Check(sender.Allows("https://hooks.example.com/pixy"), Equals, true)
+	c.Check(sender.Allows("http://hooks.example.com/pixy"), Equals, false)
+	c.Check(sender.Allows("https://hooks.example.com.evil.org/pixy"), Equals, false)
+}
+
+// If the whitelist is empty, then no URLs are allowed.
+func (s *ReceiptSuite) TestAllowsEmptyWhitelist(c *C) {
+	sender, err := NewSender(actor.Root(), nil, time.Second)
+	c.Assert(err, IsNil)
+
+	c.Check(sender.Allows("https://hooks.example.com/pixy"), Equals, false)
+}
+
+func (s *ReceiptSuite) TestInvalidPattern(c *C) {
+	_, err := NewSender(actor.Root(), []string{"https://["}, time.Second)
+	c.Check(err, ErrorMatches, "invalid callback URL pattern: https://\\[: .*")
+}
+
+// Receipts are posted as JSON, and Stop waits for them to be delivered.
+func (s *ReceiptSuite) TestSend(c *C) {
+	var contentType string
+	var posted map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		c.Check(json.NewDecoder(r.Body).Decode(&posted), IsNil)
+	}))
+	defer server.Close()
+	sender, err := NewSender(actor.Root(), []string{".*"}, time.Second)
+	c.Assert(err, IsNil)
+
+	// When
+	sender.Send(server.URL, Receipt{RequestID: "foo", Partition: -1, Offset: -1, Error: "bar"})
+	sender.Stop()
+
+	// Then
+	c.Check(contentType, Equals, "application/json")
+	c.Check(posted, DeepEquals, map[string]interface{}{
+		"request_id": "foo",
+		"partition":  float64(-1),
+		"offset":     float64(-1),
+		"error":      "bar",
+	})
+}
+
+// Failures to deliver a receipt are not fatal.
+func (s *ReceiptSuite) TestSendFailure(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	sender, err := NewSender(actor.Root(), []string{".*"}, time.Second)
+	c.Assert(err, IsNil)
+
+	sender.Send(server.URL, Receipt{RequestID: "foo"})
+	c.Check(sender.post(server.URL, Receipt{RequestID: "foo"}), ErrorMatches, "unexpected status: 500")
+	sender.Stop()
+}
@@ -0,0 +1,150 @@
+// Package redact masks message contents in everything that Kafka-Pixy logs or
+// taps, so that payloads containing personal data do not leak into
+// observability systems. Redaction rules are configured process wide, for
+// loggers are process wide too.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// Mask is what redacted contents are replaced with.
+const Mask = "[REDACTED]"
+
+type rules struct {
+	patterns   []*regexp.Regexp
+	jsonFields [][]string
+	headers    map[string]bool
+}
+
+var (
+	mu      sync.RWMutex
+	current *rules
+)
+
+// Configure sets redaction rules. Rules that are not configured are disabled.
+func Configure(cfg config.Redaction) error {
+	if len(cfg.Patterns) == 0 && len(cfg.JSONFields) == 0 && len(cfg.Headers) == 0 {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+		return nil
+	}
+	r := rules{headers: make(map[string]bool, len(cfg.Headers))}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern: %s", pattern)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, field := range cfg.JSONFields {
+		r.jsonFields = append(r.jsonFields, strings.Split(strings.TrimPrefix(field, "$."), "."))
+	}
+	for _, header := range cfg.Headers {
+		r.headers[strings.ToLower(header)] = true
+	}
+	mu.Lock()
+	current = &r
+	mu.Unlock()
+	return nil
+}
+
+// IsEnabled tells whether any redaction rule is configured.
+func IsEnabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// Value returns a message value with configured JSON fields and pattern
+// matches masked. JSON fields are only masked in values that are JSON
+// objects, the input slice is never modified.
+func Value(value []byte) []byte {
+	mu.RLock()
+	r := current
+	mu.RUnlock()
+	if r == nil || value == nil {
+		return value
+	}
+	if len(r.jsonFields) > 0 {
+		value = r.maskJSONFields(value)
+	}
+	for _, re := range r.patterns {
+		value = re.ReplaceAllLiteral(value, []byte(Mask))
+	}
+	return value
+}
+
+// HeaderValue returns the value of a record header, masked if the header
+// name is configured to be redacted.
+func HeaderValue(key, value []byte) []byte {
+	mu.RLock()
+	r := current
+	mu.RUnlock()
+	if r == nil || !r.headers[strings.ToLower(string(key))] {
+		return value
+	}
+	return []byte(Mask)
+}
+
+// Loggable returns what should be logged in place of a body that may carry
+// message contents in an arbitrary shape, e.g. an API response. If redaction
+// is enabled, then the body is masked altogether.
+func Loggable(body interface{}) interface{} {
+	if IsEnabled() {
+		return Mask
+	}
+	return body
+}
+
+func (r *rules) maskJSONFields(value []byte) []byte {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return value
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(trimmed, &doc); err != nil {
+		return value
+	}
+	masked := false
+	for _, path := range r.jsonFields {
+		if maskPath(doc, path) {
+			masked = true
+		}
+	}
+	if !masked {
+		return value
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return []byte(Mask)
+	}
+	return encoded
+}
+
+// maskPath replaces the value at a path of object field names with Mask. It
+// returns true if the path exists.
+func maskPath(doc map[string]interface{}, path []string) bool {
+	for i, name := range path {
+		fieldValue, ok := doc[name]
+		if !ok {
+			return false
+		}
+		if i == len(path)-1 {
+			doc[name] = Mask
+			return true
+		}
+		if doc, ok = fieldValue.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return false
+}
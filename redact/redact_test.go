@@ -0,0 +1,68 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type RedactSuite struct{}
+
+var _ = Suite(&RedactSuite{})
+
+func (s *RedactSuite) TearDownTest(c *C) {
+	c.Assert(Configure(config.Redaction{}), IsNil)
+}
+
+func (s *RedactSuite) TestDisabled(c *C) {
+	c.Check(IsEnabled(), Equals, false)
+	c.Check(string(Value([]byte(`{"email": "foo@example.com"}`))), Equals, `{"email": "foo@example.com"}`)
+	c.Check(string(HeaderValue([]byte("Authorization"), []byte("secret"))), Equals, "secret")
+	c.Check(Loggable("body"), Equals, "body")
+}
+
+func (s *RedactSuite) TestValue(c *C) {
+	c.Assert(Configure(config.Redaction{
+		Patterns:   []string{`\d{4}-\d{4}-\d{4}-\d{4}`},
+		JSONFields: []string{"$.user.email", "ssn", "missing.field"},
+	}), IsNil)
+
+	for i, tc := range []struct {
+		value    string
+		redacted string
+	}{{
+		value:    `{"user": {"email": "foo@example.com", "name": "foo"}, "ssn": 123}`,
+		redacted: `{"ssn":"[REDACTED]","user":{"email":"[REDACTED]","name":"foo"}}`,
+	}, {
+		value:    `{"user": "foo", "card": "1234-5678-9012-3456"}`,
+		redacted: `{"user": "foo", "card": "[REDACTED]"}`,
+	}, {
+		value:    `card 1234-5678-9012-3456, ssn 123`,
+		redacted: `card [REDACTED], ssn 123`,
+	}, {
+		value:    `{"broken": `,
+		redacted: `{"broken": `,
+	}} {
+		c.Check(string(Value([]byte(tc.value))), Equals, tc.redacted, Commentf("case #%d", i))
+	}
+	c.Check(Value(nil), IsNil)
+}
+
+// Header names are matched case insensitively.
+func (s *RedactSuite) TestHeaderValue(c *C) {
+	c.Assert(Configure(config.Redaction{Headers: []string{"authorization"}}), IsNil)
+
+	c.Check(string(HeaderValue([]byte("Authorization"), []byte("secret"))), Equals, Mask)
+	c.Check(string(HeaderValue([]byte("Trace"), []byte("abc"))), Equals, "abc")
+	c.Check(Loggable("body"), Equals, Mask)
+}
+
+func (s *RedactSuite) TestInvalidPattern(c *C) {
+	err := Configure(config.Redaction{Patterns: []string{"("}})
+	c.Check(err, ErrorMatches, "invalid pattern: \\(: .*")
+}
@@ -0,0 +1,50 @@
+// Package reqid implements generation of request IDs, that are used to trace
+// API calls from client logs to Kafka-Pixy logs and produced Kafka records.
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// MaxLength is the maximum length of a request ID passed by a caller.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// New generates a random request ID.
+func New() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// Honor returns a request ID passed by a caller if it is valid, that is it
+// is not empty, is no longer than MaxLength and consists of printable ASCII
+// characters only. Otherwise a new request ID is generated.
+func Honor(requestID string) string {
+	if requestID == "" || len(requestID) > MaxLength {
+		return New()
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < ' ' || requestID[i] > '~' {
+			return New()
+		}
+	}
+	return requestID
+}
+
+// NewContext returns a context that carries a request ID.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the request ID carried by a context, or an empty string
+// if there is none.
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
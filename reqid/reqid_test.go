@@ -0,0 +1,39 @@
+package reqid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ReqIDSuite struct{}
+
+var _ = Suite(&ReqIDSuite{})
+
+func (s *ReqIDSuite) TestNew(c *C) {
+	requestID1 := New()
+	requestID2 := New()
+	c.Check(requestID1, Matches, "[0-9a-f]{32}")
+	c.Check(requestID1, Not(Equals), requestID2)
+}
+
+func (s *ReqIDSuite) TestHonor(c *C) {
+	c.Check(Honor("foo-42"), Equals, "foo-42")
+	longest := strings.Repeat("a", MaxLength)
+	c.Check(Honor(longest), Equals, longest)
+
+	for i, requestID := range []string{"", longest + "a", "foo\nbar", "föo"} {
+		c.Check(Honor(requestID), Matches, "[0-9a-f]{32}", Commentf("case #%d", i))
+	}
+}
+
+func (s *ReqIDSuite) TestContext(c *C) {
+	c.Check(FromContext(context.Background()), Equals, "")
+	c.Check(FromContext(NewContext(context.Background(), "foo")), Equals, "foo")
+}
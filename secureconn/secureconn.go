@@ -0,0 +1,395 @@
+// Package secureconn secures connections that Kafka-Pixy makes to Kafka
+// brokers and ZooKeeper peers with TLS, and authenticates connections to
+// brokers with SASL/PLAIN. Certificates, keys and passwords are read from
+// files that are checked for changes as connections are established, at most
+// once per `net.credentials_check_interval`, or pushed via the admin API.
+// Either way new credentials are used by connections established after that,
+// while established connections are left alone. Kafka clients reconnect on
+// their own, so credentials are rotated without recreating the clients and
+// rebalancing consumer groups.
+package secureconn
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// Sources of credentials.
+const (
+	SourceFiles = "files"
+	SourceAPI   = "api"
+)
+
+// ErrBadCredentials is returned by Update when the given credentials cannot
+// be used.
+var ErrBadCredentials = errors.New("bad credentials")
+
+// Credentials are PEM encoded certificates and key, and a SASL password.
+type Credentials struct {
+	CA          []byte
+	Certificate []byte
+	Key         []byte
+	Password    string
+}
+
+// Status describes credentials in use.
+type Status struct {
+	// Either SourceFiles or SourceAPI.
+	Source   string
+	LoadedAt time.Time
+
+	// Subject and expiry time of the client certificate, if any.
+	CertificateSubject   string
+	CertificateExpiresAt time.Time
+
+	// The error that the last reload failed with, if any. The previously
+	// loaded credentials are used until a reload succeeds.
+	Err error
+}
+
+// T secures connections to peers of either the Kafka cluster or the
+// ZooKeeper ensemble of a proxy.
+type T struct {
+	actDesc       *actor.Descriptor
+	tlsCfg        config.ClientTLS
+	saslEnabled   bool
+	saslUser      string
+	passwordPath  string
+	password      string
+	clientID      string
+	checkInterval time.Duration
+	dialTimeout   time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	modTimes  map[string]time.Time
+	creds     Credentials
+	tls       *tls.Config
+	status    Status
+
+	// For tests only!
+	now func() time.Time
+}
+
+// NewKafka creates an instance that secures connections to brokers of the
+// proxy Kafka cluster. It returns nil if neither TLS nor SASL is enabled.
+func NewKafka(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
+	if !cfg.Kafka.TLS.Enabled && !cfg.Kafka.SASL.Enabled {
+		return nil, nil
+	}
+	t := newT(parentActDesc.NewChild("kafka_creds"), cfg, cfg.Kafka.TLS)
+	t.saslEnabled = cfg.Kafka.SASL.Enabled
+	t.saslUser = cfg.Kafka.SASL.User
+	t.passwordPath = cfg.Kafka.SASL.PasswordPath
+	t.password = cfg.Kafka.SASL.Password
+	return t, t.init()
+}
+
+// NewZooKeeper creates an instance that secures connections to peers of the
+// proxy ZooKeeper ensemble. It returns nil if TLS is not enabled.
+func NewZooKeeper(parentActDesc *actor.Descriptor, cfg *config.Proxy) (*T, error) {
+	if !cfg.ZooKeeper.TLS.Enabled {
+		return nil, nil
+	}
+	t := newT(parentActDesc.NewChild("zk_creds"), cfg, cfg.ZooKeeper.TLS)
+	return t, t.init()
+}
+
+func newT(actDesc *actor.Descriptor, cfg *config.Proxy, tlsCfg config.ClientTLS) *T {
+	return &T{
+		actDesc:       actDesc,
+		tlsCfg:        tlsCfg,
+		clientID:      cfg.ClientID,
+		checkInterval: cfg.Net.CredentialsCheckInterval,
+		dialTimeout:   cfg.Net.DialTimeout,
+		now:           time.Now,
+	}
+}
+
+func (t *T) init() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checkedAt = t.now()
+	t.modTimes = t.statFiles()
+	creds, err := t.readFiles()
+	if err != nil {
+		return err
+	}
+	if err := t.apply(creds, SourceFiles); err != nil {
+		return errors.Wrap(err, "failed to load credentials")
+	}
+	return nil
+}
+
+// Secure performs the TLS handshake and SASL authentication, whichever is
+// enabled, over a connection to a peer with the given address. The returned
+// connection should be used instead of the given one. If securing fails,
+// then the connection is closed.
+func (t *T) Secure(conn net.Conn, addr string) (net.Conn, error) {
+	tlsCfg, password := t.current()
+	if t.dialTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(t.dialTimeout))
+	}
+	if tlsCfg != nil {
+		tlsCfg = tlsCfg.Clone()
+		if tlsCfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			tlsCfg.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsCfg)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "TLS handshake failed, addr=%s", addr)
+		}
+		conn = tlsConn
+	}
+	if t.saslEnabled {
+		if err := authenticate(conn, t.clientID, t.saslUser, password); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "SASL authentication failed, addr=%s", addr)
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Update makes connections established from now on use the given
+// credentials. Empty fields keep the current values. Pushed credentials are
+// used until any of the configured files changes.
+func (t *T) Update(creds Credentials) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	merged := t.creds
+	if creds.CA != nil {
+		merged.CA = creds.CA
+	}
+	if creds.Certificate != nil || creds.Key != nil {
+		merged.Certificate, merged.Key = creds.Certificate, creds.Key
+	}
+	if creds.Password != "" {
+		merged.Password = creds.Password
+	}
+	if err := t.apply(merged, SourceAPI); err != nil {
+		return errors.Wrap(ErrBadCredentials, err.Error())
+	}
+	t.actDesc.Log().Infof("Credentials updated via API: subject=%s, expiresAt=%s",
+		t.status.CertificateSubject, t.status.CertificateExpiresAt)
+	return nil
+}
+
+// Status returns the status of the credentials in use.
+func (t *T) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// current returns the TLS config and the SASL password that a connection
+// should be secured with, re-reading files first if they have changed.
+func (t *T) current() (*tls.Config, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.checkInterval > 0 && now.Sub(t.checkedAt) >= t.checkInterval {
+		t.checkedAt = now
+		t.reloadIfChanged()
+	}
+	return t.tls, t.creds.Password
+}
+
+func (t *T) reloadIfChanged() {
+	modTimes := t.statFiles()
+	changed := false
+	for path, modTime := range modTimes {
+		if !t.modTimes[path].Equal(modTime) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	creds, err := t.readFiles()
+	if err == nil {
+		err = t.apply(creds, SourceFiles)
+	}
+	if err != nil {
+		// Files can be caught in the middle of a rotation, e.g. with the
+		// certificate replaced but the key not yet. So modification times
+		// are not remembered, to have another go on the next check.
+		t.status.Err = err
+		t.actDesc.Log().WithError(err).Error("Failed to reload credentials")
+		return
+	}
+	t.modTimes = modTimes
+	t.actDesc.Log().Infof("Credentials reloaded: subject=%s, expiresAt=%s",
+		t.status.CertificateSubject, t.status.CertificateExpiresAt)
+}
+
+// statFiles returns modification times of the configured files. A file that
+// cannot be stat'ed is given the zero time, so it is considered changed
+// when it shows up.
+func (t *T) statFiles() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{t.tlsCfg.CAPath, t.tlsCfg.CertPath, t.tlsCfg.KeyPath, t.passwordPath} {
+		if path == "" {
+			continue
+		}
+		var modTime time.Time
+		if fi, err := os.Stat(path); err == nil {
+			modTime = fi.ModTime()
+		}
+		modTimes[path] = modTime
+	}
+	return modTimes
+}
+
+func (t *T) readFiles() (Credentials, error) {
+	var creds Credentials
+	var err error
+	for _, f := range []struct {
+		path string
+		dst  *[]byte
+	}{
+		{t.tlsCfg.CAPath, &creds.CA},
+		{t.tlsCfg.CertPath, &creds.Certificate},
+		{t.tlsCfg.KeyPath, &creds.Key},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if *f.dst, err = ioutil.ReadFile(f.path); err != nil {
+			return Credentials{}, errors.Wrap(err, "failed to read file")
+		}
+	}
+	creds.Password = t.password
+	if t.passwordPath != "" {
+		password, err := ioutil.ReadFile(t.passwordPath)
+		if err != nil {
+			return Credentials{}, errors.Wrap(err, "failed to read file")
+		}
+		creds.Password = string(bytes.TrimRight(password, "\r\n"))
+	}
+	return creds, nil
+}
+
+// apply makes the given credentials current, provided they are valid.
+func (t *T) apply(creds Credentials, source string) error {
+	status := Status{Source: source, LoadedAt: t.now()}
+	var tlsCfg *tls.Config
+	if t.tlsCfg.Enabled {
+		tlsCfg = &tls.Config{
+			ServerName:         t.tlsCfg.ServerName,
+			InsecureSkipVerify: t.tlsCfg.InsecureSkipVerify,
+		}
+		if len(creds.CA) > 0 {
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(creds.CA) {
+				return errors.New("no CA certificates found")
+			}
+		}
+		if len(creds.Certificate) > 0 || len(creds.Key) > 0 {
+			cert, err := tls.X509KeyPair(creds.Certificate, creds.Key)
+			if err != nil {
+				return errors.Wrap(err, "bad certificate or key")
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return errors.Wrap(err, "bad certificate")
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+			status.CertificateSubject = leaf.Subject.String()
+			status.CertificateExpiresAt = leaf.NotAfter
+		}
+	}
+	if t.saslEnabled && creds.Password == "" {
+		return errors.New("empty SASL password")
+	}
+	t.creds = creds
+	t.tls = tlsCfg
+	t.status = status
+	return nil
+}
+
+// authenticate performs SASL/PLAIN authentication the way sarama does it
+// when `Net.SASL.Handshake` is enabled, that is a SaslHandshake v0 request
+// followed by a raw authentication token.
+func authenticate(conn net.Conn, clientID, user, password string) error {
+	const apiKeySaslHandshake = 17
+	var rq bytes.Buffer
+	writeInt16(&rq, apiKeySaslHandshake)
+	writeInt16(&rq, 0)
+	writeInt32(&rq, 1) // correlation ID
+	writeString(&rq, clientID)
+	writeString(&rq, sarama.SASLTypePlaintext)
+	if err := writeSized(conn, rq.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to send handshake")
+	}
+	rs, err := readSized(conn)
+	if err != nil {
+		return errors.Wrap(err, "failed to read handshake response")
+	}
+	// The response starts with the correlation ID followed by the error
+	// code, supported mechanisms are of no interest.
+	if len(rs) < 6 {
+		return errors.New("short handshake response")
+	}
+	if errCode := sarama.KError(binary.BigEndian.Uint16(rs[4:6])); errCode != sarama.ErrNoError {
+		return errors.Wrap(errCode, "handshake failed")
+	}
+	token := "\x00" + user + "\x00" + password
+	if err := writeSized(conn, []byte(token)); err != nil {
+		return errors.Wrap(err, "failed to send token")
+	}
+	// A broker closes the connection if authentication fails.
+	if _, err := readSized(conn); err != nil {
+		return errors.Wrap(err, "failed to read token response")
+	}
+	return nil
+}
+
+func writeInt16(buf *bytes.Buffer, v int16) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeInt32(buf *bytes.Buffer, v int32) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeString(buf *bytes.Buffer, v string) {
+	writeInt16(buf, int16(len(v)))
+	buf.WriteString(v)
+}
+
+func writeSized(w io.Writer, data []byte) error {
+	sized := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(sized, uint32(len(data)))
+	copy(sized[4:], data)
+	_, err := w.Write(sized)
+	return err
+}
+
+func readSized(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(size[:]))
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
@@ -0,0 +1,413 @@
+package secureconn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SecureConnSuite struct {
+	ns     *actor.Descriptor
+	dir    string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  []byte
+	now    time.Time
+}
+
+var _ = Suite(&SecureConnSuite{})
+
+func (s *SecureConnSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+	s.caKey, s.caCert, s.caPEM, _ = s.newCert(c, "ca", nil, nil)
+}
+
+func (s *SecureConnSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	s.dir = c.MkDir()
+	s.now = time.Now()
+}
+
+// newCert returns a certificate with the given common name signed by the
+// given parent. If the parent is nil, then the certificate is self-signed.
+func (s *SecureConnSuite) newCert(c *C, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*ecdsa.PrivateKey, *x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+	return key, cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeClientCert writes a client certificate with the given common name
+// and its key to files, and makes them look modified at the given time.
+func (s *SecureConnSuite) writeClientCert(c *C, cn string, modTime time.Time) {
+	_, _, certPEM, keyPEM := s.newCert(c, cn, s.caCert, s.caKey)
+	s.writeFile(c, "client.crt", certPEM, modTime)
+	s.writeFile(c, "client.key", keyPEM, modTime)
+}
+
+func (s *SecureConnSuite) writeFile(c *C, name string, data []byte, modTime time.Time) {
+	path := filepath.Join(s.dir, name)
+	c.Assert(ioutil.WriteFile(path, data, 0600), IsNil)
+	c.Assert(os.Chtimes(path, modTime, modTime), IsNil)
+}
+
+func (s *SecureConnSuite) newProxyCfg() *config.Proxy {
+	cfg := config.DefaultProxy()
+	cfg.Net.DialTimeout = 3 * time.Second
+	cfg.Net.CredentialsCheckInterval = time.Minute
+	cfg.Kafka.TLS.Enabled = true
+	cfg.Kafka.TLS.CAPath = filepath.Join(s.dir, "ca.crt")
+	cfg.Kafka.TLS.CertPath = filepath.Join(s.dir, "client.crt")
+	cfg.Kafka.TLS.KeyPath = filepath.Join(s.dir, "client.key")
+	return cfg
+}
+
+func (s *SecureConnSuite) newKafka(c *C, cfg *config.Proxy) *T {
+	sc, err := NewKafka(s.ns, cfg)
+	c.Assert(err, IsNil)
+	// Credentials are loaded on creation, before the clock can be faked.
+	s.now = sc.Status().LoadedAt
+	sc.now = func() time.Time { return s.now }
+	return sc
+}
+
+// spawnTLSServer starts a TLS server that requires client certificates
+// signed by the test CA, and reports common names of client certificates
+// of accepted connections.
+func (s *SecureConnSuite) spawnTLSServer(c *C) (net.Listener, <-chan string) {
+	_, _, certPEM, keyPEM := s.newCert(c, "localhost", s.caCert, s.caKey)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	c.Assert(err, IsNil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(s.caCert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	c.Assert(err, IsNil)
+	cnCh := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				cnCh <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			conn.Close()
+		}
+	}()
+	return listener, cnCh
+}
+
+func (s *SecureConnSuite) dial(c *C, sc *T, listener net.Listener) (net.Conn, error) {
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	c.Assert(err, IsNil)
+	return sc.Secure(conn, listener.Addr().String())
+}
+
+// Connections present the client certificate, and the server certificate is
+// verified against the CA from the file.
+func (s *SecureConnSuite) TestTLS(c *C) {
+	s.writeFile(c, "ca.crt", s.caPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	listener, cnCh := s.spawnTLSServer(c)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newProxyCfg())
+
+	// When
+	conn, err := s.dial(c, sc, listener)
+
+	// Then
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-cnCh, Equals, "client-1")
+	status := sc.Status()
+	c.Check(status.Source, Equals, SourceFiles)
+	c.Check(status.CertificateSubject, Equals, "CN=client-1")
+	c.Check(status.CertificateExpiresAt, DeepEquals, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// If the server certificate is not signed by the configured CA, then the
+// handshake fails.
+func (s *SecureConnSuite) TestTLSUnknownCA(c *C) {
+	_, _, otherCAPEM, _ := s.newCert(c, "other-ca", nil, nil)
+	s.writeFile(c, "ca.crt", otherCAPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	listener, _ := s.spawnTLSServer(c)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newProxyCfg())
+
+	// When
+	_, err := s.dial(c, sc, listener)
+
+	// Then
+	c.Check(err, ErrorMatches, "TLS handshake failed, addr=.*: x509: certificate signed by unknown authority.*")
+}
+
+// Files are checked for changes no more often than the check interval, and
+// changed files are used by connections established after that.
+func (s *SecureConnSuite) TestRotation(c *C) {
+	s.writeFile(c, "ca.crt", s.caPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	listener, cnCh := s.spawnTLSServer(c)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newProxyCfg())
+	s.writeClientCert(c, "client-2", s.now.Add(time.Second))
+
+	for i, tc := range []struct {
+		elapsed time.Duration
+		cn      string
+	}{
+		{elapsed: 30 * time.Second, cn: "client-1"},
+		{elapsed: 30 * time.Second, cn: "client-2"},
+		{elapsed: 0, cn: "client-2"},
+	} {
+		s.now = s.now.Add(tc.elapsed)
+
+		// When
+		conn, err := s.dial(c, sc, listener)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		conn.Close()
+		c.Check(<-cnCh, Equals, tc.cn, Commentf("case #%d", i))
+	}
+	c.Check(sc.Status().LoadedAt, Equals, s.now)
+}
+
+// If changed files cannot be loaded, then connections keep using the last
+// good credentials, and the error is reported until they are fixed.
+func (s *SecureConnSuite) TestRotationError(c *C) {
+	s.writeFile(c, "ca.crt", s.caPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	listener, cnCh := s.spawnTLSServer(c)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newProxyCfg())
+	s.writeFile(c, "client.crt", []byte("garbage"), s.now.Add(time.Second))
+	s.now = s.now.Add(time.Minute)
+
+	// When
+	conn, err := s.dial(c, sc, listener)
+
+	// Then
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-cnCh, Equals, "client-1")
+	c.Check(sc.Status().Err, ErrorMatches, "bad certificate or key: .*")
+
+	// When
+	s.writeClientCert(c, "client-2", s.now.Add(time.Second))
+	s.now = s.now.Add(time.Minute)
+	conn, err = s.dial(c, sc, listener)
+
+	// Then
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-cnCh, Equals, "client-2")
+	c.Check(sc.Status().Err, IsNil)
+}
+
+// Credentials pushed via the API are used until files change.
+func (s *SecureConnSuite) TestUpdate(c *C) {
+	s.writeFile(c, "ca.crt", s.caPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	listener, cnCh := s.spawnTLSServer(c)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newProxyCfg())
+	_, _, certPEM, keyPEM := s.newCert(c, "client-api", s.caCert, s.caKey)
+
+	// When
+	err := sc.Update(Credentials{Certificate: certPEM, Key: keyPEM})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(sc.Status().Source, Equals, SourceAPI)
+	s.now = s.now.Add(time.Minute)
+	conn, err := s.dial(c, sc, listener)
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-cnCh, Equals, "client-api")
+
+	// When
+	s.writeClientCert(c, "client-2", s.now.Add(time.Second))
+	s.now = s.now.Add(time.Minute)
+	conn, err = s.dial(c, sc, listener)
+
+	// Then
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-cnCh, Equals, "client-2")
+	c.Check(sc.Status().Source, Equals, SourceFiles)
+}
+
+func (s *SecureConnSuite) TestUpdateInvalid(c *C) {
+	s.writeFile(c, "ca.crt", s.caPEM, s.now)
+	s.writeClientCert(c, "client-1", s.now)
+	sc := s.newKafka(c, s.newProxyCfg())
+	_, _, certPEM, _ := s.newCert(c, "client-api", s.caCert, s.caKey)
+
+	for i, tc := range []struct {
+		creds  Credentials
+		errMsg string
+	}{
+		{creds: Credentials{CA: []byte("garbage")}, errMsg: "no CA certificates found: bad credentials"},
+		{creds: Credentials{Certificate: certPEM}, errMsg: "bad certificate or key: .*: bad credentials"},
+	} {
+		// When
+		err := sc.Update(tc.creds)
+
+		// Then
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+		c.Check(sc.Status().CertificateSubject, Equals, "CN=client-1", Commentf("case #%d", i))
+	}
+}
+
+// If credential files cannot be loaded on start, then creation fails.
+func (s *SecureConnSuite) TestNewMissingFile(c *C) {
+	_, err := NewKafka(s.ns, s.newProxyCfg())
+	c.Check(err, ErrorMatches, "failed to read file: open .*/ca.crt: no such file or directory")
+}
+
+func (s *SecureConnSuite) TestNewDisabled(c *C) {
+	sc, err := NewKafka(s.ns, config.DefaultProxy())
+	c.Check(sc, IsNil)
+	c.Check(err, IsNil)
+	sc, err = NewZooKeeper(s.ns, config.DefaultProxy())
+	c.Check(sc, IsNil)
+	c.Check(err, IsNil)
+}
+
+// spawnSASLServer starts a server that performs the broker side of the
+// SASL/PLAIN authentication, and accepts the given password only.
+func (s *SecureConnSuite) spawnSASLServer(c *C, password string, handshakeErr sarama.KError) (net.Listener, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	userCh := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			rq, err := readSized(conn)
+			if err != nil || binary.BigEndian.Uint16(rq) != 17 {
+				conn.Close()
+				continue
+			}
+			var rs bytes.Buffer
+			rs.Write(rq[4:8]) // correlation ID
+			writeInt16(&rs, int16(handshakeErr))
+			writeInt32(&rs, 1)
+			writeString(&rs, "PLAIN")
+			_ = writeSized(conn, rs.Bytes())
+			token, err := readSized(conn)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			parts := bytes.Split(token, []byte{0})
+			if len(parts) == 3 && string(parts[2]) == password {
+				userCh <- string(parts[1])
+				_ = writeSized(conn, nil)
+			}
+			conn.Close()
+		}
+	}()
+	return listener, userCh
+}
+
+func (s *SecureConnSuite) newSASLProxyCfg() *config.Proxy {
+	cfg := config.DefaultProxy()
+	cfg.Net.CredentialsCheckInterval = time.Minute
+	cfg.Kafka.SASL.Enabled = true
+	cfg.Kafka.SASL.User = "alice"
+	cfg.Kafka.SASL.PasswordPath = filepath.Join(s.dir, "password")
+	return cfg
+}
+
+// The password is read from the file, and re-read when the file changes.
+func (s *SecureConnSuite) TestSASL(c *C) {
+	s.writeFile(c, "password", []byte("secret-1\n"), s.now)
+	listener, userCh := s.spawnSASLServer(c, "secret-1", sarama.ErrNoError)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newSASLProxyCfg())
+
+	// When
+	conn, err := s.dial(c, sc, listener)
+
+	// Then
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(<-userCh, Equals, "alice")
+
+	// When
+	s.writeFile(c, "password", []byte("secret-2\n"), s.now.Add(time.Second))
+	s.now = s.now.Add(time.Minute)
+	_, err = s.dial(c, sc, listener)
+
+	// Then
+	c.Check(err, ErrorMatches, "SASL authentication failed, addr=.*: failed to read token response: EOF")
+	c.Check(sc.Status().LoadedAt, Equals, s.now)
+}
+
+func (s *SecureConnSuite) TestSASLHandshakeError(c *C) {
+	s.writeFile(c, "password", []byte("secret-1"), s.now)
+	listener, _ := s.spawnSASLServer(c, "secret-1", sarama.ErrUnsupportedSASLMechanism)
+	defer listener.Close()
+	sc := s.newKafka(c, s.newSASLProxyCfg())
+
+	// When
+	_, err := s.dial(c, sc, listener)
+
+	// Then
+	c.Check(err, ErrorMatches, "SASL authentication failed, addr=.*: handshake failed: .*SASL mechanism.*")
+}
@@ -0,0 +1,104 @@
+package grpcsrv
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/throttle"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// adminServer implements pb.KafkaPixyAdminServer. Topics, groups and offsets
+// are served by the same handlers as in the KafkaPixy service, but admin
+// callers are not tenants, so names are taken and returned as they are.
+type adminServer struct {
+	s *T
+}
+
+// ListTopics implements pb.KafkaPixyAdminServer.
+func (as *adminServer) ListTopics(ctx context.Context, req *pb.ListTopicRq) (*pb.ListTopicRs, error) {
+	return as.s.ListTopics(ctx, req)
+}
+
+// GetTopicMetadata implements pb.KafkaPixyAdminServer.
+func (as *adminServer) GetTopicMetadata(ctx context.Context, req *pb.GetTopicMetadataRq) (*pb.GetTopicMetadataRs, error) {
+	return as.s.GetTopicMetadata(ctx, req)
+}
+
+// ListConsumers implements pb.KafkaPixyAdminServer.
+func (as *adminServer) ListConsumers(ctx context.Context, req *pb.ListConsumersRq) (*pb.ListConsumersRs, error) {
+	return as.s.ListConsumers(ctx, req)
+}
+
+// GetOffsets implements pb.KafkaPixyAdminServer.
+func (as *adminServer) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffsetsRs, error) {
+	return as.s.GetOffsets(ctx, req)
+}
+
+// SetOffsets implements pb.KafkaPixyAdminServer.
+func (as *adminServer) SetOffsets(ctx context.Context, req *pb.SetOffsetsRq) (*pb.SetOffsetsRs, error) {
+	return as.s.SetOffsets(ctx, req)
+}
+
+// GetHealth implements pb.KafkaPixyAdminServer.
+func (as *adminServer) GetHealth(ctx context.Context, req *pb.GetHealthRq) (*pb.GetHealthRs, error) {
+	release, err := as.s.enter(config.EndpointsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	res := pb.GetHealthRs{Serving: atomic.LoadInt32(&as.s.draining) == 0}
+	for _, cluster := range as.s.proxySet.Clusters() {
+		clusterHealth := pb.ClusterHealth{Cluster: cluster, Throttled: throttle.IsThrottled(cluster)}
+		if pxy, err := as.s.proxySet.Get(cluster); err == nil {
+			clusterHealth.KafkaVersion = pxy.KafkaVersion()
+		}
+		res.Clusters = append(res.Clusters, &clusterHealth)
+	}
+	return &res, nil
+}
+
+// Drain implements pb.KafkaPixyAdminServer. If the server was given a drain
+// function, then it is called to drain the whole instance, otherwise only
+// this server is drained.
+func (as *adminServer) Drain(ctx context.Context, req *pb.DrainRq) (*pb.DrainRs, error) {
+	release, err := as.s.enter(config.EndpointsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	as.s.actDesc.Log().Infof("Draining on request: requestID=%s", reqid.FromContext(ctx))
+	if as.s.drain != nil {
+		as.s.drain()
+	} else {
+		as.s.Drain()
+	}
+	return &pb.DrainRs{}, nil
+}
+
+// authorizeAdmin is an interceptor that requires callers of the
+// KafkaPixyAdmin service to pass one of the admin API keys in the
+// `authorization` metadata. Keys are only required if they are configured,
+// or tenants are, so that tenants cannot call the service either way.
+func (s *T) authorizeAdmin(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, ok := info.Server.(*adminServer); !ok || (len(s.adminAPIKeys) == 0 && !s.tenancy) {
+		return handler(ctx, req)
+	}
+	var apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(mdAuthorization); len(values) > 0 {
+			apiKey = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+	if apiKey == "" || !s.adminAPIKeys[apiKey] {
+		return nil, statusError(codes.Unauthenticated, tenancy.ErrUnauthenticated)
+	}
+	return handler(ctx, req)
+}
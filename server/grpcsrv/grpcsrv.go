@@ -4,54 +4,207 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"path"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/clientstats"
+	"github.com/mailgun/kafka-pixy/codec"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
+	"github.com/mailgun/kafka-pixy/errcode"
 	"github.com/mailgun/kafka-pixy/gen/golang"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/producer"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/tracectx"
+	"github.com/mailgun/kafka-pixy/validator"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 const (
 	maxRequestSize = 1 * 1024 * 1024 // 1Mb
+
+	// The name of the service in kafkapixy.proto, as reported by the health
+	// service.
+	healthServiceName = "KafkaPixy"
+
+	mdAuthorization  = "authorization"
+	mdClientName     = "x-client-name"
+	mdClientVersion  = "x-client-version"
+	mdCluster        = "x-kafka-cluster"
+	mdRequestID      = "x-request-id"
+	mdMemberID       = "x-kafka-member-id"
+	mdGeneration     = "x-kafka-generation"
+	mdCallbackURL    = "x-kafka-callback-url"
+	mdAffinity       = "x-kafka-affinity"
+	mdPriority       = "x-kafka-priority"
+	mdWindow         = "x-kafka-between"
+	mdAckTimeout     = "x-kafka-ack-timeout"
+	mdEndOfStream    = "x-kafka-end-of-stream"
+	mdStopAt         = "x-kafka-stop-at"
+	mdDataLoss       = "x-kafka-data-loss"
+	mdStrictOrdering = "x-kafka-strict-ordering"
+	mdTombstone      = "x-kafka-tombstone"
+	mdValueCodec     = "x-kafka-value-codec"
 )
 
 type T struct {
 	actDesc  *actor.Descriptor
 	listener net.Listener
 	grpcSrv  *grpc.Server
+	health   *health.Server
 	proxySet *proxy.Set
+	readOnly bool
+	pools    *server.Pools
 	wg       sync.WaitGroup
 	errorCh  chan error
+	draining int32
+
+	// Enabled endpoint groups, nil means that all are enabled.
+	endpointGroups map[string]bool
+
+	// Whether callers are authenticated as tenants, and API keys that
+	// authorize callers of the KafkaPixyAdmin service.
+	tenancy      bool
+	adminAPIKeys map[string]bool
+
+	// Drains the whole instance on an admin request, nil means that only
+	// this server is drained.
+	drain func()
+}
+
+// Option configures optional features of the gRPC API server.
+type Option func(*options)
+
+type options struct {
+	srvOpts      []grpc.ServerOption
+	interceptors []Interceptor
+	pools        *server.Pools
+	tenancy      bool
+	adminAPIKeys []string
+	drain        func()
+}
+
+// WithServerOptions passes options to the underlying gRPC server.
+func WithServerOptions(srvOpts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.srvOpts = append(o.srvOpts, srvOpts...)
+	}
+}
+
+// WithInterceptors appends interceptors to the chain that requests go
+// through in order before they are handled.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithPools makes the server handle requests of endpoint groups on the
+// specified worker pools. Requests rejected by a pool fail with
+// `ResourceExhausted`.
+func WithPools(pools *server.Pools) Option {
+	return func(o *options) {
+		o.pools = pools
+	}
+}
+
+// WithAdminAPIKeys makes the server require callers of the KafkaPixyAdmin
+// service to pass one of the specified API keys in the `authorization`
+// metadata.
+func WithAdminAPIKeys(apiKeys []string) Option {
+	return func(o *options) {
+		o.adminAPIKeys = append(o.adminAPIKeys, apiKeys...)
+	}
+}
+
+// WithDrain makes the server call the specified function when the
+// KafkaPixyAdmin service is asked to drain the instance.
+func WithDrain(drain func()) Option {
+	return func(o *options) {
+		o.drain = drain
+	}
 }
 
-// New creates a gRPC server instance.
-func New(addr string, proxySet *proxy.Set, srvOpts ...grpc.ServerOption) (*T, error) {
-	listener, err := net.Listen("tcp", addr)
+// New creates a gRPC server instance. If readOnly is set, then the server
+// rejects produce and set offsets requests. If endpointGroups is not nil,
+// then only requests that belong to the listed endpoint groups are served.
+func New(addr string, proxySet *proxy.Set, readOnly bool, endpointGroups []string, opts ...Option) (*T, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	listener, err := server.Listen(addr)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create listener")
 	}
 
-	opts := append(srvOpts, grpc.MaxRecvMsgSize(maxRequestSize))
-	grpcSrv := grpc.NewServer(opts...)
 	s := T{
 		actDesc:  actor.Root().NewChild(fmt.Sprintf("grpc://%s", addr)),
 		listener: listener,
-		grpcSrv:  grpcSrv,
 		proxySet: proxySet,
+		readOnly: readOnly,
+		pools:    o.pools,
 		errorCh:  make(chan error, 1),
+		tenancy:  o.tenancy,
+		drain:    o.drain,
+	}
+	if len(o.adminAPIKeys) > 0 {
+		s.adminAPIKeys = make(map[string]bool, len(o.adminAPIKeys))
+		for _, apiKey := range o.adminAPIKeys {
+			s.adminAPIKeys[apiKey] = true
+		}
 	}
-	pb.RegisterKafkaPixyServer(grpcSrv, &s)
+	if endpointGroups != nil {
+		s.endpointGroups = make(map[string]bool, len(endpointGroups))
+		for _, group := range endpointGroups {
+			s.endpointGroups[group] = true
+		}
+	}
+
+	// Requests are assigned IDs and admin requests are authorized before
+	// they go through other interceptors.
+	unaryChain := []grpc.UnaryServerInterceptor{s.identify, s.authorizeAdmin}
+	var streamChain []grpc.StreamServerInterceptor
+	for _, interceptor := range o.interceptors {
+		if interceptor.Unary != nil {
+			unaryChain = append(unaryChain, interceptor.Unary)
+		}
+		if interceptor.Stream != nil {
+			streamChain = append(streamChain, interceptor.Stream)
+		}
+	}
+	srvOpts := append(o.srvOpts, grpc.MaxRecvMsgSize(maxRequestSize))
+	srvOpts = append(srvOpts, grpc.UnaryInterceptor(chainUnary(unaryChain)))
+	if len(streamChain) > 0 {
+		srvOpts = append(srvOpts, grpc.StreamInterceptor(chainStream(streamChain)))
+	}
+	s.grpcSrv = grpc.NewServer(srvOpts...)
+	pb.RegisterKafkaPixyServer(s.grpcSrv, &s)
+	pb.RegisterKafkaPixyAdminServer(s.grpcSrv, &adminServer{s: &s})
+	// The standard health service reports both the server as a whole and
+	// the Kafka-Pixy service as serving until the server is drained.
+	s.health = health.NewServer()
+	s.health.SetServingStatus(healthServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s.grpcSrv, s.health)
 	return &s, nil
 }
 
@@ -80,13 +233,21 @@ func (s *T) Stop() {
 	close(s.errorCh)
 }
 
+// Drain implements server.Drainer. It makes the health service report
+// NOT_SERVING, so that load balancers and service meshes that watch it steer
+// clients to other instances, while requests are still served.
+func (s *T) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+	s.health.Shutdown()
+}
+
 // Produce implements pb.KafkaPixyServer
 func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsProduce)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
-
+	defer release()
 	var headers []sarama.RecordHeader
 	if len(req.Headers) > 0 {
 		headers = make([]sarama.RecordHeader, 0, len(req.Headers))
@@ -100,25 +261,98 @@ func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
 			})
 		}
 	}
+	pxy, err := s.proxySet.GetForProduce(clusterFromContext(ctx, req.Cluster), headers)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
+	}
+	if s.readOnly || pxy.IsReadOnly() {
+		return nil, statusError(codes.PermissionDenied, proxy.ErrReadOnly)
+	}
+	tenant := tenancy.FromContext(ctx)
+	headers = pxy.WithRequestID(headers, reqid.FromContext(ctx))
+	headers = pxy.WithTraceContext(headers, tracectx.FromContext(ctx))
+	headers = pxy.WithEnrichment(headers, tenant.Topic(req.Topic), tenant)
 
+	if !pxy.IsTopicAllowed(tenant.Topic(req.Topic)) {
+		return nil, statusError(codes.PermissionDenied, proxy.ErrTopicNotAllowed)
+	}
+	var callbackURL string
+	strict, tombstone := false, false
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(mdCallbackURL); len(values) > 0 {
+			callbackURL = values[0]
+		}
+		if values := md.Get(mdStrictOrdering); len(values) > 0 {
+			if strict, err = strconv.ParseBool(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, errors.Errorf("bad %s: %s", mdStrictOrdering, values[0]))
+			}
+		}
+		if values := md.Get(mdTombstone); len(values) > 0 {
+			if tombstone, err = strconv.ParseBool(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, errors.Errorf("bad %s: %s", mdTombstone, values[0]))
+			}
+		}
+	}
+	// A null value cannot be told apart from an empty one in a protobuf
+	// message, so tombstones are requested with metadata.
+	var msg sarama.Encoder = sarama.StringEncoder(req.Message)
+	if tombstone {
+		if req.KeyUndefined {
+			return nil, statusError(codes.InvalidArgument, errors.New("key is required for a tombstone"))
+		}
+		if len(req.Message) > 0 {
+			return nil, statusError(codes.InvalidArgument, errors.New("tombstone must not have a message"))
+		}
+		msg = nil
+	}
+	if req.AsyncMode && strict {
+		err := pxy.AsyncProduceStrictlyOrdered(tenant.Topic(req.Topic), keyEncoderFor(req), msg,
+			headers, callbackURL, reqid.FromContext(ctx))
+		if err != nil {
+			return nil, statusError(codes.PermissionDenied, err)
+		}
+		return &pb.ProdRs{Partition: -1, Offset: -1}, nil
+	}
 	if req.AsyncMode {
-		pxy.AsyncProduce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message), headers)
+		if callbackURL == "" {
+			pxy.AsyncProduce(tenant.Topic(req.Topic), keyEncoderFor(req), msg, headers)
+			return &pb.ProdRs{Partition: -1, Offset: -1}, nil
+		}
+		err := pxy.AsyncProduceWithReceipt(tenant.Topic(req.Topic), keyEncoderFor(req), msg,
+			headers, callbackURL, reqid.FromContext(ctx))
+		if err != nil {
+			return nil, statusError(codes.PermissionDenied, err)
+		}
 		return &pb.ProdRs{Partition: -1, Offset: -1}, nil
 	}
 
-	prodMsg, err := pxy.Produce(req.Topic, keyEncoderFor(req), sarama.StringEncoder(req.Message), headers)
+	produce := pxy.Produce
+	if strict {
+		produce = pxy.ProduceStrictlyOrdered
+	}
+	prodMsg, err := produce(tenant.Topic(req.Topic), keyEncoderFor(req), msg, headers)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
+		case proxy.ErrUnknownTopic:
+			fallthrough
 		case sarama.ErrUnknownTopicOrPartition:
-			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			return nil, statusError(codes.InvalidArgument, err)
 		case proxy.ErrDisabled:
 			fallthrough
 		case proxy.ErrUnavailable:
-			return nil, status.Errorf(codes.Unavailable, err.Error())
+			return nil, statusError(codes.Unavailable, err)
 		case proxy.ErrHeadersUnsupported:
-			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+			fallthrough
+		case proxy.ErrMessageTooLarge:
+			return nil, statusError(codes.InvalidArgument, err)
+		case proxy.ErrLimitExceeded:
+			return nil, statusError(codes.ResourceExhausted, err)
+		case validator.ErrRejected:
+			return nil, statusError(codes.InvalidArgument, err)
+		case validator.ErrUnavailable:
+			return nil, statusError(codes.Unavailable, err)
 		default:
-			return nil, status.Errorf(codes.Internal, err.Error())
+			return nil, statusError(codes.Internal, err)
 		}
 	}
 	return &pb.ProdRs{Partition: prodMsg.Partition, Offset: prodMsg.Offset}, nil
@@ -126,9 +360,14 @@ func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
 
 // ConsumeNAck implements pb.KafkaPixyServer
 func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsConsume)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, statusError(codes.InvalidArgument, err)
 	}
 
 	var ack proxy.Ack
@@ -138,31 +377,135 @@ func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, er
 		ack = proxy.AutoAck()
 	} else {
 		if ack, err = proxy.NewAck(req.AckPartition, req.AckOffset); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, errors.Wrap(err, "invalid ack").Error())
+			return nil, statusError(codes.InvalidArgument, errors.Wrap(err, "invalid ack"))
 		}
+		generation, err := ackGeneration(ctx)
+		if err != nil {
+			return nil, statusError(codes.InvalidArgument, err)
+		}
+		ackMetadata, err := proxy.ParseAckMetadata(req.AckMetadata)
+		if err != nil {
+			return nil, statusError(codes.InvalidArgument, err)
+		}
+		ack = ack.WithGeneration(generation).WithMetadata(ackMetadata)
 	}
 
-	consMsg, err := pxy.Consume(req.Group, req.Topic, ack)
+	// The affinity token, the priority, the time window, the stop point and
+	// the ack timeout are passed in metadata, for they only affect which
+	// messages are served, in what order, and when they are offered again.
+	affinity := proxy.NoAffinity()
+	var priority int32
+	window := proxy.NoWindow()
+	stop := proxy.NoStop()
+	var ackTimeout time.Duration
+	endOfStream := false
+	var acceptedCodec string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(mdAffinity); len(values) > 0 {
+			if affinity, err = proxy.ParseAffinity(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, err)
+			}
+		}
+		if values := md.Get(mdPriority); len(values) > 0 {
+			if priority, err = proxy.ParsePriority(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, err)
+			}
+		}
+		if values := md.Get(mdWindow); len(values) > 0 {
+			if window, err = proxy.ParseWindow(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, err)
+			}
+		}
+		if values := md.Get(mdStopAt); len(values) > 0 {
+			if stop, err = proxy.ParseStop(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, err)
+			}
+		}
+		if values := md.Get(mdAckTimeout); len(values) > 0 {
+			if ackTimeout, err = proxy.ParseAckTimeout(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, err)
+			}
+		}
+		if values := md.Get(mdEndOfStream); len(values) > 0 {
+			if endOfStream, err = strconv.ParseBool(values[0]); err != nil {
+				return nil, statusError(codes.InvalidArgument, errors.Errorf("bad %s: %s", mdEndOfStream, values[0]))
+			}
+		}
+		// The codec that a client wants a message value transcoded to.
+		if values := md.Get(mdValueCodec); len(values) > 0 {
+			if _, ok := codec.Lookup(values[0]); !ok {
+				return nil, statusError(codes.InvalidArgument, errors.Errorf("bad %s: %s", mdValueCodec, values[0]))
+			}
+			acceptedCodec = values[0]
+		}
+	}
+
+	tenant := tenancy.FromContext(ctx)
+	group, topic := tenant.Group(req.Group), tenant.Topic(req.Topic)
+	consMsg, err := pxy.ConsumeContext(ctx, group, topic, ack, affinity, priority, window, stop, ackTimeout)
+	if err == consumer.ErrRequestTimeout && endOfStream {
+		if ok, eosErr := pxy.IsEndOfStream(group, topic); eosErr == nil && ok {
+			err = proxy.ErrEndOfStream
+		}
+	}
 	if err != nil {
 		switch err {
+		case context.Canceled:
+			return nil, statusError(codes.Canceled, err)
+		case context.DeadlineExceeded:
+			return nil, statusError(codes.DeadlineExceeded, err)
 		case consumer.ErrRequestTimeout:
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
+		case proxy.ErrEndOfStream:
+			fallthrough
+		case proxy.ErrStopReached:
+			return nil, statusError(codes.OutOfRange, err)
+		case proxy.ErrAckTimeoutTooLong:
+			return nil, statusError(codes.InvalidArgument, err)
 		case consumer.ErrTooManyRequests:
-			return nil, status.Errorf(codes.ResourceExhausted, err.Error())
+			fallthrough
+		case proxy.ErrLimitExceeded:
+			fallthrough
+		case proxy.ErrTooManyGroups:
+			return nil, statusError(codes.ResourceExhausted, err)
+		case proxy.ErrGroupMigrated:
+			return nil, statusError(codes.FailedPrecondition, err)
+		case proxy.ErrGroupMigrating:
+			fallthrough
 		case consumer.ErrUnavailable:
 			fallthrough
 		case proxy.ErrDisabled:
 			fallthrough
 		case proxy.ErrUnavailable:
-			return nil, status.Errorf(codes.Unavailable, err.Error())
+			return nil, statusError(codes.Unavailable, err)
 		default:
-			return nil, status.Errorf(codes.Internal, err.Error())
+			return nil, statusError(codes.Internal, err)
 		}
 	}
+	// The group member that served the message and the group generation are
+	// returned in the header metadata, for they are diagnostic information.
+	md := metadata.Pairs(mdMemberID, consMsg.MemberID, mdGeneration, strconv.Itoa(int(consMsg.Generation)),
+		mdAffinity, proxy.AffinityToken(&consMsg))
+	if consMsg.DataLoss > 0 {
+		md.Append(mdDataLoss, strconv.FormatInt(consMsg.DataLoss, 10))
+	}
+	consValue, valueCodec := pxy.TranscodeValue(topic, consMsg.Value, acceptedCodec)
+	if valueCodec != "" {
+		md.Append(mdValueCodec, valueCodec)
+	}
+	if traceCtx, ok := pxy.TraceContextOf(&consMsg); ok {
+		md.Append(tracectx.ParentHeader, traceCtx.Parent)
+		if traceCtx.State != "" {
+			md.Append(tracectx.StateHeader, traceCtx.State)
+		}
+	}
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		s.actDesc.Log().WithError(err).Error("Failed to set member metadata")
+	}
 	res := pb.ConsRs{
 		Partition: consMsg.Partition,
 		Offset:    consMsg.Offset,
-		Message:   consMsg.Value,
+		Message:   consValue,
 	}
 	for _, h := range consMsg.Headers {
 		res.Headers = append(res.Headers, &pb.RecordHeader{
@@ -179,32 +522,109 @@ func (s *T) ConsumeNAck(ctx context.Context, req *pb.ConsNAckRq) (*pb.ConsRs, er
 }
 
 func (s *T) Ack(ctx context.Context, req *pb.AckRq) (*pb.AckRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsConsume)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
 	}
 
 	ack, err := proxy.NewAck(req.Partition, req.Offset)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, errors.Wrap(err, "invalid ack").Error())
+		return nil, statusError(codes.InvalidArgument, errors.Wrap(err, "invalid ack"))
+	}
+	generation, err := ackGeneration(ctx)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
+	}
+	metadata, err := proxy.ParseAckMetadata(req.Metadata)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
 	}
-	if err = pxy.Ack(req.Group, req.Topic, ack); err != nil {
-		return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+	ack = ack.WithGeneration(generation).WithMetadata(metadata)
+	tenant := tenancy.FromContext(ctx)
+	if err = pxy.Ack(tenant.Group(req.Group), tenant.Topic(req.Topic), ack); err != nil {
+		if err == proxy.ErrStaleGeneration {
+			return nil, statusError(codes.FailedPrecondition, err)
+		}
+		return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 	}
 	return &pb.AckRs{}, nil
 }
 
+func (s *T) AckBatch(ctx context.Context, req *pb.AckBatchRq) (*pb.AckBatchRs, error) {
+	release, err := s.enter(config.EndpointsConsume)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
+	}
+
+	generation, err := ackGeneration(ctx)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
+	}
+	acks := make([]proxy.Ack, len(req.Acks))
+	for i, ao := range req.Acks {
+		if acks[i], err = proxy.NewAck(ao.Partition, ao.Offset); err != nil {
+			return nil, statusError(codes.InvalidArgument, errors.Wrapf(err, "invalid ack #%d", i))
+		}
+		metadata, err := proxy.ParseAckMetadata(ao.Metadata)
+		if err != nil {
+			return nil, statusError(codes.InvalidArgument, errors.Wrapf(err, "invalid ack #%d", i))
+		}
+		acks[i] = acks[i].WithGeneration(generation).WithMetadata(metadata)
+	}
+	tenant := tenancy.FromContext(ctx)
+	errs := pxy.AckBatch(tenant.Group(req.Group), tenant.Topic(req.Topic), acks)
+	rs := pb.AckBatchRs{Results: make([]*pb.AckResult, len(req.Acks))}
+	for i, ao := range req.Acks {
+		rs.Results[i] = &pb.AckResult{Partition: ao.Partition, Offset: ao.Offset}
+		if errs[i] != nil {
+			rs.Results[i].Error = errs[i].Error()
+		}
+	}
+	return &rs, nil
+}
+
+// ackGeneration returns the group generation that acks of a request carry.
+// It is passed in metadata the same way it is returned with consumed
+// messages.
+func ackGeneration(ctx context.Context) (int32, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	values := md.Get(mdGeneration)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	return proxy.ParseGeneration(values[0])
+}
+
 func (s *T) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffsetsRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsOffsets)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, statusError(codes.InvalidArgument, err)
 	}
-	partitionOffsets, err := pxy.GetGroupOffsets(req.Group, req.Topic)
+	tenant := tenancy.FromContext(ctx)
+	partitionOffsets, err := pxy.GetGroupOffsets(tenant.Group(req.Group), tenant.Topic(req.Topic))
 	if err != nil {
 		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
 		}
-		return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+		return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 	}
 
 	result := pb.GetOffsetsRs{}
@@ -226,15 +646,24 @@ func (s *T) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffset
 		row.Metadata = po.Metadata
 		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
 		row.SparseAcks = offsettrk.SparseAcks2Str(offset)
+		_, row.AckMetadata = offsettrk.SplitMeta(po.Metadata)
 		result.Offsets = append(result.Offsets, &row)
 	}
 	return &result, nil
 }
 
 func (s *T) SetOffsets(ctx context.Context, req *pb.SetOffsetsRq) (*pb.SetOffsetsRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsOffsets)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
+	}
+	if s.readOnly || pxy.IsReadOnly() {
+		return nil, statusError(codes.PermissionDenied, proxy.ErrReadOnly)
 	}
 
 	partitionOffsets := make([]admin.PartitionOffset, len(req.Offsets))
@@ -244,35 +673,46 @@ func (s *T) SetOffsets(ctx context.Context, req *pb.SetOffsetsRq) (*pb.SetOffset
 		partitionOffsets[i].Metadata = pov.Metadata
 	}
 
-	err = pxy.SetGroupOffsets(req.Group, req.Topic, partitionOffsets)
+	tenant := tenancy.FromContext(ctx)
+	err = pxy.SetGroupOffsets(tenant.Group(req.Group), tenant.Topic(req.Topic), partitionOffsets)
 	if err != nil {
 		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
 		}
-		return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+		return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 	}
 
 	return &pb.SetOffsetsRs{}, nil
 }
 
 func (s *T) ListTopics(ctx context.Context, req *pb.ListTopicRq) (*pb.ListTopicRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsAdmin)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
 	}
 
 	tms, err := pxy.ListTopics(req.GetWithPartitions(), true)
 	if err != nil {
 		if errors.Cause(err) == zk.ErrNoNode {
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
 		}
-		return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+		return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 	}
 
 	var res pb.ListTopicRs
 
+	tenant := tenancy.FromContext(ctx)
 	res.Topics = make(map[string]*pb.GetTopicMetadataRs)
 	for _, tm := range tms {
+		topic, ok := tenant.Logical(tm.Topic)
+		if !ok {
+			continue
+		}
 		var t pb.GetTopicMetadataRs
 		t.Version = tm.Config.Version
 		t.Config = tm.Config.Config
@@ -288,39 +728,45 @@ func (s *T) ListTopics(ctx context.Context, req *pb.ListTopicRq) (*pb.ListTopicR
 				t.Partitions = append(t.Partitions, &entry)
 			}
 		}
-		res.Topics[tm.Topic] = &t
+		res.Topics[topic] = &t
 	}
 	return &res, nil
 }
 
 func (s *T) ListConsumers(ctx context.Context, req *pb.ListConsumersRq) (*pb.ListConsumersRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsAdmin)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
+	if err != nil {
+		return nil, statusError(codes.InvalidArgument, err)
 	}
 
+	tenant := tenancy.FromContext(ctx)
 	var groups map[string]map[string][]int32
 	if req.Group == "" {
-		groups, err = pxy.GetAllTopicConsumers(req.Topic)
+		groups, err = pxy.GetAllTopicConsumers(tenant.Topic(req.Topic))
 		if err != nil {
 			if errors.Cause(err) == zk.ErrNoNode {
-				return nil, status.Errorf(codes.NotFound, err.Error())
+				return nil, statusError(codes.NotFound, err)
 			}
-			return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+			return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 		}
 	} else {
-		groupConsumers, err := pxy.GetTopicConsumers(req.Group, req.Topic)
+		groupConsumers, err := pxy.GetTopicConsumers(tenant.Group(req.Group), tenant.Topic(req.Topic))
 		if err != nil {
 			if errors.Cause(err) == zk.ErrNoNode {
-				return nil, status.Errorf(codes.NotFound, err.Error())
+				return nil, statusError(codes.NotFound, err)
 			}
 			if _, ok := err.(admin.ErrInvalidParam); ok {
-				return nil, status.Errorf(codes.NotFound, err.Error())
+				return nil, statusError(codes.NotFound, err)
 			}
 		}
 		groups = make(map[string]map[string][]int32)
 		if len(groupConsumers) != 0 {
-			groups[req.Group] = groupConsumers
+			groups[tenant.Group(req.Group)] = groupConsumers
 		}
 	}
 
@@ -328,6 +774,10 @@ func (s *T) ListConsumers(ctx context.Context, req *pb.ListConsumersRq) (*pb.Lis
 	res.Groups = make(map[string]*pb.ConsumerGroups, len(groups))
 
 	for group, consumers := range groups {
+		group, ok := tenant.Logical(group)
+		if !ok {
+			continue
+		}
 		consGroup := new(pb.ConsumerGroups)
 		consGroup.Consumers = make(map[string]*pb.ConsumerPartitions, len(consumers))
 		for consumer, partitions := range consumers {
@@ -339,20 +789,25 @@ func (s *T) ListConsumers(ctx context.Context, req *pb.ListConsumersRq) (*pb.Lis
 }
 
 func (s *T) GetTopicMetadata(ctx context.Context, req *pb.GetTopicMetadataRq) (*pb.GetTopicMetadataRs, error) {
-	pxy, err := s.proxySet.Get(req.Cluster)
+	release, err := s.enter(config.EndpointsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pxy, err := s.getProxy(ctx, req.Cluster)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		return nil, statusError(codes.InvalidArgument, err)
 	}
 
-	tm, err := pxy.GetTopicMetadata(req.Topic, req.WithPartitions, true)
+	tm, err := pxy.GetTopicMetadata(tenancy.FromContext(ctx).Topic(req.Topic), req.WithPartitions, true)
 	if err != nil {
 		if errors.Cause(err) == zk.ErrNoNode {
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
 		}
 		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			return nil, status.Errorf(codes.NotFound, err.Error())
+			return nil, statusError(codes.NotFound, err)
 		}
-		return nil, status.Errorf(codes.Code(http.StatusInternalServerError), err.Error())
+		return nil, statusError(codes.Code(http.StatusInternalServerError), err)
 	}
 
 	var res pb.GetTopicMetadataRs
@@ -374,9 +829,154 @@ func (s *T) GetTopicMetadata(ctx context.Context, req *pb.GetTopicMetadataRq) (*
 	return &res, nil
 }
 
+// statusError returns a status error with the specified code and the message
+// of `err`. The status is accompanied with `ErrorDetails` that classify `err`.
+func statusError(code codes.Code, err error) error {
+	class, ok := errcode.Classify(err)
+	if !ok {
+		class = errcode.FromGRPCCode(code)
+	}
+	return withErrorDetails(status.New(code, err.Error()), class)
+}
+
+// ensureErrorDetails makes sure that a status error is accompanied with
+// `ErrorDetails`. If it is not, then they are derived from the status code.
+func ensureErrorDetails(err error) error {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if _, ok := detail.(*pb.ErrorDetails); ok {
+			return err
+		}
+	}
+	return withErrorDetails(st, errcode.FromGRPCCode(st.Code()))
+}
+
+func withErrorDetails(st *status.Status, class errcode.Class) error {
+	detailed, err := st.WithDetails(&pb.ErrorDetails{Code: string(class.Code), Retryable: class.Retryable})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// identify is an interceptor that assigns an ID to every request, or honors
+// the one passed in the `x-request-id` metadata, returns it in the same header
+// metadata of the response, and passes it to handlers in the request context
+// along with the W3C trace context passed in the request metadata. Requests
+// are counted by the client library given in the `x-client-name` and
+// `x-client-version` metadata. Requests that fail with a server error are
+// logged along with their IDs.
+func (s *T) identify(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var requestID string
+	var traceCtx tracectx.T
+	var clientName, clientVersion string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(mdRequestID); len(values) > 0 {
+			requestID = values[0]
+		}
+		if values := md.Get(tracectx.ParentHeader); len(values) > 0 {
+			traceCtx.Parent = values[0]
+		}
+		if values := md.Get(tracectx.StateHeader); len(values) > 0 {
+			traceCtx.State = values[0]
+		}
+		if values := md.Get(mdClientName); len(values) > 0 {
+			clientName = values[0]
+		}
+		if values := md.Get(mdClientVersion); len(values) > 0 {
+			clientVersion = values[0]
+		}
+	}
+	clientstats.Record(clientName, clientVersion, "grpc:"+path.Base(info.FullMethod))
+	requestID = reqid.Honor(requestID)
+	if err := grpc.SetHeader(ctx, metadata.Pairs(mdRequestID, requestID)); err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to set request ID: requestID=%s", requestID)
+	}
+	ctx = tracectx.NewContext(reqid.NewContext(ctx, requestID), traceCtx)
+	res, err := handler(ctx, req)
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss:
+		s.actDesc.Log().WithError(err).Errorf("Request failed: requestID=%s, method=%s", requestID, info.FullMethod)
+	}
+	if err != nil {
+		err = ensureErrorDetails(err)
+	}
+	return res, err
+}
+
+// getProxy returns a proxy of the cluster specified in a request. If the
+// request does not specify it, then the `x-kafka-cluster` metadata is used.
+func (s *T) getProxy(ctx context.Context, cluster string) (*proxy.T, error) {
+	return s.proxySet.Get(clusterFromContext(ctx, cluster))
+}
+
+// clusterFromContext returns the cluster specified in a request, or if it is
+// empty, the one specified in the request metadata.
+func clusterFromContext(ctx context.Context, cluster string) string {
+	if cluster == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(mdCluster); len(values) > 0 {
+				cluster = values[0]
+			}
+		}
+	}
+	return cluster
+}
+
+// enter returns an error if requests of an endpoint group should not be
+// served, or if the worker pool of the group rejects the request. Otherwise
+// it returns a function that frees the taken worker.
+func (s *T) enter(group string) (func(), error) {
+	if s.endpointGroups != nil && !s.endpointGroups[group] {
+		return nil, status.Errorf(codes.Unimplemented, "endpoint group disabled: %s", group)
+	}
+	if !s.pools.Acquire(group) {
+		return nil, statusError(codes.ResourceExhausted, server.ErrPoolExhausted)
+	}
+	return func() { s.pools.Release(group) }, nil
+}
+
+// WithTenancy returns a server option that makes the server authenticate
+// callers as tenants by API keys passed in the `authorization` metadata. The
+// authentication interceptor is appended to the interceptor chain. Callers
+// of the KafkaPixyAdmin service are authorized by admin API keys instead.
+func WithTenancy(t *tenancy.T) Option {
+	authenticate := WithInterceptors(Interceptor{Unary: func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		// Health checks do not carry API keys, and admin requests carry
+		// admin API keys that are checked by authorizeAdmin.
+		switch info.Server.(type) {
+		case *health.Server, *adminServer:
+			return handler(ctx, req)
+		}
+		var apiKey string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(mdAuthorization); len(values) > 0 {
+				apiKey = values[0]
+			}
+		}
+		tenant, err := t.Authenticate(apiKey)
+		if err != nil {
+			return nil, statusError(codes.Unauthenticated, err)
+		}
+		return handler(tenancy.NewContext(ctx, tenant), req)
+	}})
+	return func(o *options) {
+		o.tenancy = true
+		authenticate(o)
+	}
+}
+
+// keyEncoderFor returns the key of a message to produce. If the request has a
+// partition key, then the message is partitioned by it rather than by the key.
 func keyEncoderFor(prodReq *pb.ProdRq) sarama.Encoder {
-	if prodReq.KeyUndefined {
-		return nil
+	var key sarama.Encoder
+	if !prodReq.KeyUndefined {
+		key = sarama.ByteEncoder(prodReq.KeyValue)
+	}
+	if len(prodReq.PartitionKey) == 0 {
+		return key
 	}
-	return sarama.ByteEncoder(prodReq.KeyValue)
+	return producer.WithPartitionKey(key, sarama.ByteEncoder(prodReq.PartitionKey))
 }
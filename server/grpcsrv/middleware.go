@@ -0,0 +1,103 @@
+package grpcsrv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// InterceptorLogging is the name of the built-in interceptor that logs every
+// served request along with its status code and duration.
+const InterceptorLogging = "logging"
+
+// Interceptor is a pair of unary and stream server interceptors. Either of
+// them can be nil, if the respective kind of requests should not be
+// intercepted.
+type Interceptor struct {
+	Unary  grpc.UnaryServerInterceptor
+	Stream grpc.StreamServerInterceptor
+}
+
+var (
+	interceptorsMu sync.Mutex
+	interceptors   = make(map[string]Interceptor)
+)
+
+func init() {
+	RegisterInterceptor(InterceptorLogging, loggingInterceptor(actor.Root().NewChild("grpc_log")))
+}
+
+// RegisterInterceptor makes an interceptor available by name to be listed in
+// `grpc_interceptors`. It is supposed to be called from `init` functions of
+// packages that are compiled into a custom Kafka-Pixy build. It panics if an
+// interceptor with the same name has already been registered.
+func RegisterInterceptor(name string, interceptor Interceptor) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	if _, ok := interceptors[name]; ok {
+		panic(fmt.Sprintf("gRPC interceptor registered twice: %s", name))
+	}
+	interceptors[name] = interceptor
+}
+
+// LookupInterceptor returns an interceptor registered with the specified name.
+func LookupInterceptor(name string) (Interceptor, bool) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	interceptor, ok := interceptors[name]
+	return interceptor, ok
+}
+
+// chainUnary combines unary interceptors into one, so that a request goes
+// through them in the order they are listed.
+func chainUnary(chain []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(chain) - 1; i >= 0; i-- {
+			interceptor, innerNext := chain[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, innerNext)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// chainStream combines stream interceptors into one, so that a stream goes
+// through them in the order they are listed.
+func chainStream(chain []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(chain) - 1; i >= 0; i-- {
+			interceptor, innerNext := chain[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, innerNext)
+			}
+		}
+		return next(srv, ss)
+	}
+}
+
+func loggingInterceptor(actDesc *actor.Descriptor) Interceptor {
+	return Interceptor{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			begin := time.Now()
+			res, err := handler(ctx, req)
+			actDesc.Log().Infof("Served: requestID=%s, method=%s, code=%s, took=%s",
+				reqid.FromContext(ctx), info.FullMethod, status.Code(err), time.Since(begin))
+			return res, err
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			begin := time.Now()
+			err := handler(srv, ss)
+			actDesc.Log().Infof("Served: method=%s, code=%s, took=%s", info.FullMethod, status.Code(err), time.Since(begin))
+			return err
+		},
+	}
+}
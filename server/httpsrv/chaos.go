@@ -0,0 +1,67 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/pkg/errors"
+)
+
+type chaosRs struct {
+	ZooKeeperDelay       string `json:"zookeeper_delay"`
+	CommitFailurePercent int    `json:"commit_failure_percent"`
+}
+
+type dropBrokerConnsRs struct {
+	Dropped int `json:"dropped"`
+}
+
+// handleGetChaos is an HTTP request handler for `GET /_chaos`
+func (s *T) handleGetChaos(w http.ResponseWriter, r *http.Request) {
+	faults := chaos.Get()
+	s.respondWithJSON(w, http.StatusOK, chaosRs{
+		ZooKeeperDelay:       faults.ZooKeeperDelay.String(),
+		CommitFailurePercent: faults.CommitFailurePercent,
+	})
+}
+
+// handleSetChaos is an HTTP request handler for `PUT /_chaos`
+func (s *T) handleSetChaos(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to read the request: err=(%s)", err))
+		return
+	}
+	var rq chaosRs
+	if err := json.Unmarshal(body, &rq); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to parse the request: err=(%s)", err))
+		return
+	}
+	faults := chaos.Faults{CommitFailurePercent: rq.CommitFailurePercent}
+	if rq.ZooKeeperDelay != "" {
+		if faults.ZooKeeperDelay, err = time.ParseDuration(rq.ZooKeeperDelay); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad zookeeper_delay: %s", rq.ZooKeeperDelay))
+			return
+		}
+	}
+	if err := chaos.Set(faults); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.actDesc.Log().Warnf("Faults injected: zookeeperDelay=%s, commitFailurePercent=%d",
+		faults.ZooKeeperDelay, faults.CommitFailurePercent)
+	s.handleGetChaos(w, r)
+}
+
+// handleDropBrokerConns is an HTTP request handler for
+// `POST /_chaos/drop_broker_connections`
+func (s *T) handleDropBrokerConns(w http.ResponseWriter, r *http.Request) {
+	dropped := chaos.DropBrokerConns()
+	s.actDesc.Log().Warnf("Broker connections dropped: count=%d", dropped)
+	s.respondWithJSON(w, http.StatusOK, dropBrokerConnsRs{Dropped: dropped})
+}
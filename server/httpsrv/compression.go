@@ -0,0 +1,112 @@
+package httpsrv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/redact"
+)
+
+const (
+	hdrAcceptEncoding  = "Accept-Encoding"
+	hdrContentEncoding = "Content-Encoding"
+	hdrVary            = "Vary"
+
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// compressedMagics are leading bytes of payloads that are already compressed,
+// hence compressing them again just burns CPU. Besides general purpose
+// compression formats it includes formats of compressed images.
+var compressedMagics = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0x04, 0x22, 0x4d, 0x18},           // lz4 frame
+	{0xff, 0x06, 0x00, 0x00, 's', 'N'}, // snappy framed
+	{'B', 'Z', 'h'},                    // bzip2
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'P', 'K', 0x03, 0x04},             // zip
+	{0xff, 0xd8, 0xff},                 // jpeg
+	{0x89, 'P', 'N', 'G'},              // png
+	{'G', 'I', 'F', '8'},               // gif
+}
+
+// isCompressed tells whether a message value is already compressed. It is
+// either detected by magic bytes, or declared by the producer with a
+// `Content-Encoding` record header.
+func isCompressed(value []byte, headers []*sarama.RecordHeader) bool {
+	for _, h := range headers {
+		if strings.EqualFold(string(h.Key), hdrContentEncoding) {
+			encoding := strings.TrimSpace(string(h.Value))
+			if encoding != "" && !strings.EqualFold(encoding, encodingIdentity) {
+				return true
+			}
+		}
+	}
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(value, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip tells whether a client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get(hdrAcceptEncoding), ",") {
+		parts := strings.Split(accepted, ";")
+		coding := strings.TrimSpace(parts[0])
+		if coding != encodingGzip && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// respondWithCompressedJSON is like `respondWithJSON`, but gzips the response
+// if compression is enabled, the client accepts it, `compressible` is true,
+// and the encoded response is at least `http_compression.min_size` bytes.
+func (s *T) respondWithCompressedJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}, compressible bool) {
+	if !s.compressionEnabled || !compressible {
+		s.respondWithJSON(w, status, body)
+		return
+	}
+	encodedRes, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, redact.Loggable(body))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(hdrContentType, "application/json")
+	w.Header().Add(hdrVary, hdrAcceptEncoding)
+	if len(encodedRes) >= s.compressionMinSize && acceptsGzip(r) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(encodedRes)
+		gw.Close()
+		encodedRes = buf.Bytes()
+		w.Header().Set(hdrContentEncoding, encodingGzip)
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(encodedRes); err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, redact.Loggable(body))
+	}
+}
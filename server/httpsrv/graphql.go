@@ -0,0 +1,345 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
+	"github.com/mailgun/kafka-pixy/graphql"
+	"github.com/mailgun/kafka-pixy/offsetmgr"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Maximum number of messages that can be peeked from a partition with
+	// a single query.
+	maxPeekCount = 1000
+	// Number of messages peeked from a partition if not specified.
+	defaultPeekCount = 10
+)
+
+// handleGraphQL is an HTTP request handler for `GET|POST /graphql`. A query
+// can be passed either in a JSON body `{"query": ..., "variables": ...}` of
+// a POST request, or in `query` and `variables` parameters of a GET request.
+func (s *T) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var rq graphQLRq
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&rq); err != nil && err != io.EOF {
+			s.respondWithGraphQLError(w, errors.Wrap(err, "failed to parse request"))
+			return
+		}
+	} else {
+		rq.Query = r.FormValue("query")
+		if vars := r.FormValue("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &rq.Variables); err != nil {
+				s.respondWithGraphQLError(w, errors.Wrap(err, "failed to parse variables"))
+				return
+			}
+		}
+	}
+	if rq.Query == "" {
+		s.respondWithGraphQLError(w, errors.New("query is missing"))
+		return
+	}
+	rs, err := graphql.Execute(s.graphQLRoot(), rq.Query, rq.Variables)
+	if err != nil {
+		s.respondWithGraphQLError(w, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+func (s *T) respondWithGraphQLError(w http.ResponseWriter, err error) {
+	s.respondWithJSON(w, http.StatusBadRequest, graphql.Response{
+		Errors: []graphql.Error{{Message: err.Error()}},
+	})
+}
+
+// graphQLRoot returns the root object of the admin graph. All root fields
+// accept an optional `cluster` argument, if omitted the default cluster is
+// used.
+func (s *T) graphQLRoot() graphql.Object {
+	return graphql.Object{
+		"clusters": graphql.Value(s.proxySet.Clusters()),
+		"topics": func(args graphql.Args) (interface{}, error) {
+			pxy, err := s.graphQLProxy(args)
+			if err != nil {
+				return nil, err
+			}
+			topics, err := pxy.ListTopics(false, false)
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]graphql.Object, len(topics))
+			for i, tm := range topics {
+				objs[i] = newGraphQLTopic(pxy, tm.Topic)
+			}
+			return objs, nil
+		},
+		"topic": func(args graphql.Args) (interface{}, error) {
+			pxy, err := s.graphQLProxy(args)
+			if err != nil {
+				return nil, err
+			}
+			topic, err := args.RequiredString("name")
+			if err != nil {
+				return nil, err
+			}
+			return newGraphQLTopic(pxy, topic), nil
+		},
+		"groups": func(args graphql.Args) (interface{}, error) {
+			pxy, err := s.graphQLProxy(args)
+			if err != nil {
+				return nil, err
+			}
+			groups, err := pxy.ListGroups()
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]graphql.Object, len(groups))
+			for i, group := range groups {
+				objs[i] = newGraphQLGroup(pxy, group)
+			}
+			return objs, nil
+		},
+		"group": func(args graphql.Args) (interface{}, error) {
+			pxy, err := s.graphQLProxy(args)
+			if err != nil {
+				return nil, err
+			}
+			group, err := args.RequiredString("name")
+			if err != nil {
+				return nil, err
+			}
+			return newGraphQLGroup(pxy, group), nil
+		},
+	}
+}
+
+func (s *T) graphQLProxy(args graphql.Args) (*proxy.T, error) {
+	cluster, err := args.String("cluster", "")
+	if err != nil {
+		return nil, err
+	}
+	return s.proxySet.Get(cluster)
+}
+
+// newGraphQLTopic returns a topic object. Topic metadata is fetched only if
+// a field that needs it is requested.
+func newGraphQLTopic(pxy *proxy.T, topic string) graphql.Object {
+	return graphql.Object{
+		"name": graphql.Value(topic),
+		"partitions": func(graphql.Args) (interface{}, error) {
+			tm, err := pxy.GetTopicMetadata(topic, true, false)
+			if err != nil {
+				return nil, err
+			}
+			offsets, err := pxy.GetTopicOffsets(topic)
+			if err != nil {
+				return nil, err
+			}
+			offsetsByPartition := make(map[int32]admin.PartitionOffset, len(offsets))
+			for _, po := range offsets {
+				offsetsByPartition[po.Partition] = po
+			}
+			objs := make([]graphql.Object, len(tm.Partitions))
+			for i, pm := range tm.Partitions {
+				po := offsetsByPartition[pm.ID]
+				objs[i] = graphql.Object{
+					"id":       graphql.Value(pm.ID),
+					"leader":   graphql.Value(pm.Leader),
+					"replicas": graphql.Value(pm.Replicas),
+					"isr":      graphql.Value(pm.ISR),
+					"begin":    graphql.Value(po.Begin),
+					"end":      graphql.Value(po.End),
+					"count":    graphql.Value(po.End - po.Begin),
+				}
+			}
+			return objs, nil
+		},
+		"config": func(graphql.Args) (interface{}, error) {
+			tm, err := pxy.GetTopicMetadata(topic, false, true)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(tm.Config.Config))
+			for name := range tm.Config.Config {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			objs := make([]graphql.Object, len(names))
+			for i, name := range names {
+				objs[i] = graphql.Object{
+					"name":  graphql.Value(name),
+					"value": graphql.Value(tm.Config.Config[name]),
+				}
+			}
+			return objs, nil
+		},
+		"consumers": func(graphql.Args) (interface{}, error) {
+			consumers, err := pxy.GetAllTopicConsumers(topic)
+			if err != nil {
+				return nil, err
+			}
+			groups := make([]string, 0, len(consumers))
+			for group := range consumers {
+				groups = append(groups, group)
+			}
+			sort.Strings(groups)
+			objs := make([]graphql.Object, len(groups))
+			for i, group := range groups {
+				objs[i] = graphql.Object{
+					"group":   graphql.Value(group),
+					"members": graphql.Value(newGraphQLMembers(consumers[group])),
+				}
+			}
+			return objs, nil
+		},
+		"messages": func(args graphql.Args) (interface{}, error) {
+			partition, err := args.RequiredInt("partition")
+			if err != nil {
+				return nil, err
+			}
+			count, err := args.Int("last", defaultPeekCount)
+			if err != nil {
+				return nil, err
+			}
+			if count < 1 || count > maxPeekCount {
+				return nil, errors.Errorf("argument \"last\" must be in range [1, %d]", maxPeekCount)
+			}
+			messages, err := pxy.PeekMessages(topic, int32(partition), int(count))
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]graphql.Object, len(messages))
+			for i, msg := range messages {
+				objs[i] = newGraphQLMessage(int32(partition), msg)
+			}
+			return objs, nil
+		},
+	}
+}
+
+// newGraphQLGroup returns a consumer group object.
+func newGraphQLGroup(pxy *proxy.T, group string) graphql.Object {
+	return graphql.Object{
+		"name": graphql.Value(group),
+		"offsets": func(args graphql.Args) (interface{}, error) {
+			topic, err := args.RequiredString("topic")
+			if err != nil {
+				return nil, err
+			}
+			offsets, err := pxy.GetGroupOffsets(group, topic)
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]graphql.Object, len(offsets))
+			for i, po := range offsets {
+				offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+				objs[i] = graphql.Object{
+					"partition":  graphql.Value(po.Partition),
+					"begin":      graphql.Value(po.Begin),
+					"end":        graphql.Value(po.End),
+					"count":      graphql.Value(po.End - po.Begin),
+					"offset":     graphql.Value(po.Offset),
+					"lag":        graphql.Value(partitionLag(po)),
+					"metadata":   graphql.Value(po.Metadata),
+					"sparseAcks": graphql.Value(offsettrk.SparseAcks2Str(offset)),
+				}
+			}
+			return objs, nil
+		},
+		"lag": func(args graphql.Args) (interface{}, error) {
+			topic, err := args.RequiredString("topic")
+			if err != nil {
+				return nil, err
+			}
+			offsets, err := pxy.GetGroupOffsets(group, topic)
+			if err != nil {
+				return nil, err
+			}
+			var lag int64
+			for _, po := range offsets {
+				lag += partitionLag(po)
+			}
+			return lag, nil
+		},
+		"members": func(args graphql.Args) (interface{}, error) {
+			topic, err := args.RequiredString("topic")
+			if err != nil {
+				return nil, err
+			}
+			consumers, err := pxy.GetTopicConsumers(group, topic)
+			if err != nil {
+				return nil, err
+			}
+			return newGraphQLMembers(consumers), nil
+		},
+	}
+}
+
+func newGraphQLMembers(consumers map[string][]int32) []graphql.Object {
+	clientIDs := make([]string, 0, len(consumers))
+	for clientID := range consumers {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Strings(clientIDs)
+	objs := make([]graphql.Object, len(clientIDs))
+	for i, clientID := range clientIDs {
+		objs[i] = graphql.Object{
+			"clientId":   graphql.Value(clientID),
+			"partitions": graphql.Value(consumers[clientID]),
+		}
+	}
+	return objs
+}
+
+// newGraphQLMessage returns a message object. Keys and values are returned
+// as strings, for binary data `keyBase64` and `valueBase64` should be used.
+func newGraphQLMessage(partition int32, msg admin.Message) graphql.Object {
+	headers := make([]graphql.Object, len(msg.Headers))
+	for i, h := range msg.Headers {
+		headers[i] = graphql.Object{
+			"key":   graphql.Value(string(h.Key)),
+			"value": graphql.Value(string(h.Value)),
+		}
+	}
+	var timestamp interface{}
+	if !msg.Timestamp.IsZero() {
+		timestamp = msg.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return graphql.Object{
+		"partition":   graphql.Value(partition),
+		"offset":      graphql.Value(msg.Offset),
+		"key":         graphql.Value(bytesOrNil(msg.Key, false)),
+		"keyBase64":   graphql.Value(bytesOrNil(msg.Key, true)),
+		"value":       graphql.Value(bytesOrNil(msg.Value, false)),
+		"valueBase64": graphql.Value(bytesOrNil(msg.Value, true)),
+		"timestamp":   graphql.Value(timestamp),
+		"headers":     graphql.Value(headers),
+	}
+}
+
+// bytesOrNil returns nil for a nil slice, so that null keys are
+// distinguishable from empty ones.
+func bytesOrNil(b []byte, base64 bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	if base64 {
+		return b // encoding/json encodes byte slices in base64.
+	}
+	return string(b)
+}
+
+type graphQLRq struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
@@ -5,24 +5,55 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/archiver"
+	"github.com/mailgun/kafka-pixy/buildinfo"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/clientstats"
+	"github.com/mailgun/kafka-pixy/codec"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/offsettrk"
+	"github.com/mailgun/kafka-pixy/errcode"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/freeze"
+	"github.com/mailgun/kafka-pixy/gctune"
+	"github.com/mailgun/kafka-pixy/lagslo"
+	"github.com/mailgun/kafka-pixy/lifecycle"
+	"github.com/mailgun/kafka-pixy/limiter"
+	"github.com/mailgun/kafka-pixy/migration"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/offsetmgr"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
+	"github.com/mailgun/kafka-pixy/producer"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/redact"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"github.com/mailgun/kafka-pixy/secureconn"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/mailgun/kafka-pixy/table"
+	"github.com/mailgun/kafka-pixy/tap"
+	"github.com/mailgun/kafka-pixy/tenancy"
+	"github.com/mailgun/kafka-pixy/throttle"
+	"github.com/mailgun/kafka-pixy/throughput"
+	"github.com/mailgun/kafka-pixy/tracectx"
+	"github.com/mailgun/kafka-pixy/validator"
 	"github.com/pkg/errors"
 )
 
@@ -31,28 +62,95 @@ const (
 	networkUnix = "unix"
 
 	// HTTP headers used by the API.
+	hdrAccept        = "Accept"
+	hdrAuthorization = "Authorization"
+	hdrClientName    = "X-Client-Name"
+	hdrClientVersion = "X-Client-Version"
 	hdrContentLength = "Content-Length"
 	hdrContentType   = "Content-Type"
+	hdrKafkaCluster  = "X-Kafka-Cluster"
 	hdrKafkaPrefix   = "X-Kafka-"
+	hdrRequestID     = "X-Request-ID"
+	hdrThrottled     = "X-Kafka-Throttled"
+	hdrKafkaVersions = "X-Kafka-Versions"
+	hdrNextAfter     = "X-Next-After"
+	hdrValueCodec    = "X-Kafka-Value-Codec"
 
 	// HTTP request parameters.
 	prmCluster              = "cluster"
 	prmTopic                = "topic"
 	prmKey                  = "key"
+	prmPartitionKey         = "partitionKey"
 	prmSync                 = "sync"
 	prmGroup                = "group"
 	prmNoAck                = "noAck"
 	prmAckPartition         = "ackPartition"
 	prmPartition            = "partition"
 	prmAckOffset            = "ackOffset"
+	prmAckGeneration        = "ackGeneration"
+	prmGeneration           = "generation"
+	prmAckMetadata          = "ackMetadata"
+	prmMetadata             = "metadata"
 	prmOffset               = "offset"
 	prmTopicsWithPartitions = "withPartitions"
 	prmTopicsWithConfig     = "withConfig"
+	prmTableKey             = "key"
+	prmEventType            = "type"
+	prmTargetCluster        = "target"
+	prmFlushTimeout         = "timeout"
+	prmCallback             = "callback"
+	prmAffinity             = "affinity"
+	prmPriority             = "priority"
+	prmWindow               = "between"
+	prmAckTimeout           = "ackTimeout"
+	prmCloneGroup           = "clone"
+	prmFreezePeriod         = "for"
+	prmTapCount             = "count"
+	prmTapDirection         = "direction"
+	prmTapTimeout           = "timeout"
+	prmFetchLimit           = "limit"
+	prmTimestamp            = "timestamp"
+	prmEndOfStream          = "endOfStream"
+	prmStopAt               = "stopAt"
+	prmForceAckCount        = "count"
+	prmForceAckBy           = "by"
+	prmForceAckReason       = "reason"
+	prmMigrateToBackend     = "toBackend"
+	prmMigrateToGroup       = "toGroup"
+	prmArchive              = "archive"
+	prmReplay               = "replay"
+	prmReplayFromOffset     = "fromOffset"
+	prmReplayToOffset       = "toOffset"
+	prmReplayRate           = "rate"
+	prmValueBytes           = "valueBytes"
+	prmStrictOrdering       = "strictOrdering"
+	prmFields               = "fields"
+	prmListLimit            = "limit"
+	prmListAfter            = "after"
+
+	// Lifecycle events stream parameters.
+	eventsBufferSize        = 256
+	eventsKeepAliveInterval = 15 * time.Second
+
+	// Message tap parameters.
+	tapDefaultCount   = 10
+	tapMaxCount       = 1000
+	tapDefaultTimeout = time.Minute
+
+	// Partition fetch parameters.
+	fetchDefaultLimit = 100
+	fetchMaxLimit     = 1000
+
+	// The initial buffer size for produce request bodies of unknown size.
+	bodyReadStep = 64 * 1024
 )
 
 var (
 	EmptyResponse          = map[string]interface{}{}
 	jsonContentTypePattern *regexp.Regexp
+
+	errBodyTooLarge = errors.New("message is too large")
+	errBufferFull   = errors.New("produce buffer is full")
 )
 
 type T struct {
@@ -63,10 +161,111 @@ type T struct {
 	proxySet   *proxy.Set
 	wg         sync.WaitGroup
 	errorCh    chan error
+	stopCh     chan none.T
 
 	// needed to pass in security info
 	certPath string
 	keyPath  string
+
+	tenancy  *tenancy.T
+	readOnly bool
+
+	// Enabled endpoint groups, nil means that all are enabled.
+	endpointGroups map[string]bool
+
+	middleware []mux.MiddlewareFunc
+
+	// Worker pools of endpoint groups, and the groups of served routes.
+	pools       *server.Pools
+	routeGroups map[*mux.Route]string
+
+	pubSubEnabled bool
+	pubSubSubs    map[string]config.PubSubSubscription
+	uiEnabled     bool
+
+	compressionEnabled bool
+	compressionMinSize int
+
+	produceMaxBodySize   int64
+	produceBuffer        *limiter.Budget
+	produceBufferTimeout time.Duration
+
+	// Set when the server is drained, accessed atomically.
+	draining int32
+}
+
+// Option configures optional features of the HTTP API server.
+type Option func(*T)
+
+// WithPubSub enables a subset of Google Cloud Pub/Sub REST API with the
+// specified subscription to topic/group mapping.
+func WithPubSub(subs map[string]config.PubSubSubscription) Option {
+	return func(s *T) {
+		s.pubSubEnabled = true
+		s.pubSubSubs = subs
+	}
+}
+
+// WithTenancy makes the server authenticate callers as tenants.
+func WithTenancy(t *tenancy.T) Option {
+	return func(s *T) {
+		s.tenancy = t
+	}
+}
+
+// WithReadOnly makes the server reject produce and set offsets requests.
+func WithReadOnly() Option {
+	return func(s *T) {
+		s.readOnly = true
+	}
+}
+
+// WithEndpointGroups makes the server serve only endpoints that belong to the
+// specified endpoint groups. `GET /_ping` is served regardless.
+func WithEndpointGroups(groups []string) Option {
+	return func(s *T) {
+		s.endpointGroups = make(map[string]bool, len(groups))
+		for _, group := range groups {
+			s.endpointGroups[group] = true
+		}
+	}
+}
+
+// WithPools makes the server handle requests of endpoint groups on the
+// specified worker pools. Requests rejected by a pool get 429 Too Many
+// Requests.
+func WithPools(pools *server.Pools) Option {
+	return func(s *T) {
+		s.pools = pools
+	}
+}
+
+// WithUI enables a web dashboard served at `/ui/`.
+func WithUI() Option {
+	return func(s *T) {
+		s.uiEnabled = true
+	}
+}
+
+// WithProduceLimits makes the server reject produce requests with bodies
+// larger than `maxBodySize`, unless it is zero, and take bodies that it reads
+// into memory from `buffer`, waiting for at most `bufferTimeout` for their
+// share. The buffer should be shared by all servers.
+func WithProduceLimits(maxBodySize int64, buffer *limiter.Budget, bufferTimeout time.Duration) Option {
+	return func(s *T) {
+		s.produceMaxBodySize = maxBodySize
+		s.produceBuffer = buffer
+		s.produceBufferTimeout = bufferTimeout
+	}
+}
+
+// WithCompression makes the server gzip consume responses that are at least
+// `minSize` bytes long, unless they carry already compressed messages.
+func WithCompression(minSize int) Option {
+	return func(s *T) {
+		s.compressionEnabled = true
+		s.compressionMinSize = minSize
+	}
 }
 
 func init() {
@@ -82,13 +281,19 @@ func init() {
 //
 // It also passes in the provided certificate and key paths for TLS. If
 // empty strings, it is run in non-TLS mode.
-func New(addr string, proxySet *proxy.Set, certPath, keyPath string) (*T, error) {
+func New(addr string, proxySet *proxy.Set, certPath, keyPath string, opts ...Option) (*T, error) {
 	network := networkUnix
 	if strings.Contains(addr, ":") {
 		network = networkTCP
 	}
 	// Start listening on the specified network/address.
-	listener, err := net.Listen(network, addr)
+	var listener net.Listener
+	var err error
+	if network == networkTCP {
+		listener, err = server.Listen(addr)
+	} else {
+		listener, err = net.Listen(network, addr)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create listener")
 	}
@@ -109,35 +314,221 @@ func New(addr string, proxySet *proxy.Set, certPath, keyPath string) (*T, error)
 		httpServer: httpServer,
 		proxySet:   proxySet,
 		errorCh:    make(chan error, 1),
+		stopCh:     make(chan none.T),
 		certPath:   certPath,
 		keyPath:    keyPath,
+
+		routeGroups: make(map[*mux.Route]string),
+	}
+	for _, opt := range opts {
+		opt(hs)
 	}
 	// Configure the API request handlers.
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleProduce).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleProduce).Methods("POST")
+	router.Use(hs.identify)
+	router.Use(hs.authenticate)
+	router.Use(hs.middleware...)
+	router.Use(hs.pooled)
+	if hs.isEnabled(config.EndpointsProduce) {
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleProduce).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleProduce).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleProduceTombstone).Methods("DELETE")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleProduceTombstone).Methods("DELETE")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages/_validate", prmCluster, prmTopic), hs.handleValidateProduce).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages/_validate", prmTopic), hs.handleValidateProduce).Methods("POST")
+		hs.assignRoutes(router, config.EndpointsProduce)
+	}
+	if hs.isEnabled(config.EndpointsConsume) {
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleConsume).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleConsume).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages/_validate", prmCluster, prmTopic), hs.handleValidateConsume).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages/_validate", prmTopic), hs.handleValidateConsume).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks", prmCluster, prmTopic), hs.handleAck).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", prmTopic), hs.handleAck).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/heartbeats", prmCluster, prmTopic), hs.handleHeartbeat).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/heartbeats", prmTopic), hs.handleHeartbeat).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/subscription", prmCluster, prmTopic), hs.handleSubscribe).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/subscription", prmTopic), hs.handleSubscribe).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/subscription", prmCluster, prmTopic), hs.handleUnsubscribe).Methods("DELETE")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/subscription", prmTopic), hs.handleUnsubscribe).Methods("DELETE")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/{%s}/messages", prmCluster, prmTopic, prmPartition), hs.handleFetchPartition).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/messages", prmTopic, prmPartition), hs.handleFetchPartition).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/{%s}/checkpoint", prmCluster, prmTopic, prmPartition), hs.handleCheckpoint).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/checkpoint", prmTopic, prmPartition), hs.handleCheckpoint).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/table/{%s:.+}", prmCluster, prmTopic, prmTableKey), hs.handleGetTableEntry).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/table/{%s:.+}", prmTopic, prmTableKey), hs.handleGetTableEntry).Methods("GET")
+		hs.assignRoutes(router, config.EndpointsConsume)
+	}
+	if hs.isEnabled(config.EndpointsOffsets) {
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleGetOffsets).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleGetOffsets).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleSetOffsets).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleSetOffsets).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/progress", prmCluster, prmTopic), hs.handleGetProgress).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/progress", prmTopic), hs.handleGetProgress).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/translate", prmCluster, prmTopic), hs.handleTranslateOffsets).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/translate", prmTopic), hs.handleTranslateOffsets).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/clone", prmCluster, prmTopic), hs.handleCloneOffsets).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/clone", prmTopic), hs.handleCloneOffsets).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets/reset_to_end", prmCluster, prmTopic), hs.handleResetToEnd).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/reset_to_end", prmTopic), hs.handleResetToEnd).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/partitions/{%s}/force_ack", prmCluster, prmTopic, prmPartition), hs.handleForceAck).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/force_ack", prmTopic, prmPartition), hs.handleForceAck).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/freeze", prmCluster, prmGroup), hs.handleGetFreeze).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/freeze", prmGroup), hs.handleGetFreeze).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/freeze", prmCluster, prmGroup), hs.handleFreeze).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/freeze", prmGroup), hs.handleFreeze).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/freeze", prmCluster, prmGroup), hs.handleUnfreeze).Methods("DELETE")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/freeze", prmGroup), hs.handleUnfreeze).Methods("DELETE")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/migration", prmCluster, prmGroup), hs.handleGetMigration).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/migration", prmGroup), hs.handleGetMigration).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/migration", prmCluster, prmGroup), hs.handleStartMigration).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/migration", prmGroup), hs.handleStartMigration).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/migration/complete", prmCluster, prmGroup), hs.handleCompleteMigration).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/migration/complete", prmGroup), hs.handleCompleteMigration).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/migration", prmCluster, prmGroup), hs.handleDeleteMigration).Methods("DELETE")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/migration", prmGroup), hs.handleDeleteMigration).Methods("DELETE")
+		hs.assignRoutes(router, config.EndpointsOffsets)
+	}
+	if hs.isEnabled(config.EndpointsAdmin) {
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers", prmCluster, prmTopic), hs.handleGetTopicConsumers).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", prmTopic), hs.handleGetTopicConsumers).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}", prmCluster, prmGroup), hs.handleDescribeGroup).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}", prmGroup), hs.handleDescribeGroup).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/messages", prmCluster, prmTopic), hs.handleConsume).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", prmTopic), hs.handleConsume).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/partitions", prmCluster, prmGroup), hs.handleGetPartitionOwners).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/partitions", prmGroup), hs.handleGetPartitionOwners).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/acks", prmCluster, prmTopic), hs.handleAck).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", prmTopic), hs.handleAck).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/rebalances", prmCluster, prmGroup), hs.handleGetRebalances).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/rebalances", prmGroup), hs.handleGetRebalances).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/concurrency", prmCluster, prmGroup), hs.handleGetGroupConcurrency).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/concurrency", prmGroup), hs.handleGetGroupConcurrency).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/offers", prmCluster, prmGroup), hs.handleGetGroupOffers).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/offers", prmGroup), hs.handleGetGroupOffers).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleGetOffsets).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleGetOffsets).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics", prmCluster), hs.handleListTopics).Methods("GET")
+		router.HandleFunc("/topics", hs.handleListTopics).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/offsets", prmCluster, prmTopic), hs.handleSetOffsets).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", prmTopic), hs.handleSetOffsets).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}", prmCluster, prmTopic), hs.handleGetTopicMetadata).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}", prmTopic), hs.handleGetTopicMetadata).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/consumers", prmCluster, prmTopic), hs.handleGetTopicConsumers).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", prmTopic), hs.handleGetTopicConsumers).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/config", prmCluster, prmTopic), hs.handleGetTopicConfig).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/config", prmTopic), hs.handleGetTopicConfig).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics", prmCluster), hs.handleListTopics).Methods("GET")
-	router.HandleFunc("/topics", hs.handleListTopics).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/size", prmCluster, prmTopic), hs.handleGetTopicSize).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/size", prmTopic), hs.handleGetTopicSize).Methods("GET")
 
-	router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}", prmCluster, prmTopic), hs.handleGetTopicMetadata).Methods("GET")
-	router.HandleFunc(fmt.Sprintf("/topics/{%s}", prmTopic), hs.handleGetTopicMetadata).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/stats", prmCluster, prmTopic), hs.handleGetTopicStats).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/stats", prmTopic), hs.handleGetTopicStats).Methods("GET")
 
-	router.HandleFunc("/_ping", hs.handlePing).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/topics/{%s}/_tap", prmCluster, prmTopic), hs.handleTap).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/topics/{%s}/_tap", prmTopic), hs.handleTap).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/idle_groups", prmCluster), hs.tenantless(hs.handleGetIdleGroups)).Methods("GET")
+		router.HandleFunc("/idle_groups", hs.tenantless(hs.handleGetIdleGroups)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/lag_slos", prmCluster), hs.tenantless(hs.handleGetLagSLOs)).Methods("GET")
+		router.HandleFunc("/lag_slos", hs.tenantless(hs.handleGetLagSLOs)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/groups/{%s}/lag_slo", prmCluster, prmGroup), hs.handleGetGroupLagSLO).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/groups/{%s}/lag_slo", prmGroup), hs.handleGetGroupLagSLO).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/produce_slos", prmCluster), hs.tenantless(hs.handleGetProduceSLOs)).Methods("GET")
+		router.HandleFunc("/produce_slos", hs.tenantless(hs.handleGetProduceSLOs)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/health_probes", prmCluster), hs.tenantless(hs.handleGetHealthProbes)).Methods("GET")
+		router.HandleFunc("/health_probes", hs.tenantless(hs.handleGetHealthProbes)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/archives", prmCluster), hs.tenantless(hs.handleGetArchives)).Methods("GET")
+		router.HandleFunc("/archives", hs.tenantless(hs.handleGetArchives)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/archives/{%s}/replays", prmCluster, prmArchive), hs.tenantless(hs.handleStartReplay)).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/archives/{%s}/replays", prmArchive), hs.tenantless(hs.handleStartReplay)).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/archives/{%s}/replays", prmCluster, prmArchive), hs.tenantless(hs.handleGetReplays)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/archives/{%s}/replays", prmArchive), hs.tenantless(hs.handleGetReplays)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/archives/{%s}/replays/{%s}", prmCluster, prmArchive, prmReplay), hs.tenantless(hs.handleGetReplay)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/archives/{%s}/replays/{%s}", prmArchive, prmReplay), hs.tenantless(hs.handleGetReplay)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/archives/{%s}/replays/{%s}", prmCluster, prmArchive, prmReplay), hs.tenantless(hs.handleCancelReplay)).Methods("DELETE")
+		router.HandleFunc(fmt.Sprintf("/archives/{%s}/replays/{%s}", prmArchive, prmReplay), hs.tenantless(hs.handleCancelReplay)).Methods("DELETE")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_credentials", prmCluster), hs.tenantless(hs.handleGetCredentials)).Methods("GET")
+		router.HandleFunc("/_credentials", hs.tenantless(hs.handleGetCredentials)).Methods("GET")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_credentials", prmCluster), hs.tenantless(hs.handleUpdateCredentials)).Methods("PUT")
+		router.HandleFunc("/_credentials", hs.tenantless(hs.handleUpdateCredentials)).Methods("PUT")
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_refresh_metadata", prmCluster), hs.tenantless(hs.handleRefreshMetadata)).Methods("POST")
+		router.HandleFunc("/_refresh_metadata", hs.tenantless(hs.handleRefreshMetadata)).Methods("POST")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_producer", prmCluster), hs.tenantless(hs.handleGetProducerStats)).Methods("GET")
+		router.HandleFunc("/_producer", hs.tenantless(hs.handleGetProducerStats)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_consumer", prmCluster), hs.tenantless(hs.handleGetConsumerStats)).Methods("GET")
+		router.HandleFunc("/_consumer", hs.tenantless(hs.handleGetConsumerStats)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_throughput", prmCluster), hs.tenantless(hs.handleGetThroughput)).Methods("GET")
+		router.HandleFunc("/_throughput", hs.tenantless(hs.handleGetThroughput)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_quotas", prmCluster), hs.tenantless(hs.handleGetQuotas)).Methods("GET")
+		router.HandleFunc("/_quotas", hs.tenantless(hs.handleGetQuotas)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_pressure", prmCluster), hs.tenantless(hs.handleGetPressure)).Methods("GET")
+		router.HandleFunc("/_pressure", hs.tenantless(hs.handleGetPressure)).Methods("GET")
+
+		router.HandleFunc(fmt.Sprintf("/clusters/{%s}/_flush", prmCluster), hs.tenantless(hs.handleFlush)).Methods("POST")
+		router.HandleFunc("/_flush", hs.tenantless(hs.handleFlush)).Methods("POST")
+
+		router.HandleFunc("/_default_cluster", hs.tenantless(hs.handleGetDefaultCluster)).Methods("GET")
+		router.HandleFunc("/_default_cluster", hs.tenantless(hs.handleSetDefaultCluster)).Methods("PUT")
+		router.HandleFunc("/_default_cluster", hs.tenantless(hs.handleResetDefaultCluster)).Methods("DELETE")
+
+		router.HandleFunc("/_produce_routes", hs.tenantless(hs.handleGetProduceRoutes)).Methods("GET")
+
+		router.HandleFunc("/_clients", hs.tenantless(hs.handleGetClients)).Methods("GET")
+
+		router.HandleFunc("/_gc", hs.tenantless(hs.handleGetGC)).Methods("GET")
+
+		router.HandleFunc("/_info", hs.tenantless(hs.handleGetInfo)).Methods("GET")
+
+		router.HandleFunc("/graphql", hs.tenantless(hs.handleGraphQL)).Methods("GET", "POST")
+
+		if hs.uiEnabled {
+			router.HandleFunc("/ui", hs.tenantless(hs.handleUIRedirect)).Methods("GET")
+			router.HandleFunc("/ui/", hs.tenantless(hs.handleUI)).Methods("GET")
+		}
+		hs.assignRoutes(router, config.EndpointsAdmin)
+	}
+	if hs.isEnabled(config.EndpointsDebug) {
+		router.HandleFunc("/_state", hs.tenantless(hs.handleGetState)).Methods("GET")
+		router.HandleFunc("/_events", hs.tenantless(hs.handleGetEvents)).Methods("GET")
+
+		if chaos.Enabled {
+			router.HandleFunc("/_chaos", hs.tenantless(hs.handleGetChaos)).Methods("GET")
+			router.HandleFunc("/_chaos", hs.tenantless(hs.handleSetChaos)).Methods("PUT")
+			router.HandleFunc("/_chaos/drop_broker_connections", hs.tenantless(hs.handleDropBrokerConns)).Methods("POST")
+		}
+		hs.assignRoutes(router, config.EndpointsDebug)
+	}
+	// Pings are not handled on any pool, for health checks to tell a busy
+	// instance from a dead one.
+	pingRoute := router.HandleFunc("/_ping", hs.handlePing).Methods("GET")
+	hs.routeGroups[pingRoute] = ""
+
+	if hs.pubSubEnabled {
+		hs.registerPubSubRoutes(router)
+	}
 	return hs, nil
 }
 
@@ -168,441 +559,3343 @@ func (s *T) ErrorCh() <-chan error {
 // for incoming requests first, and then blocks waiting for pending requests to
 // complete.
 func (s *T) Stop() {
+	// Event streams never complete on their own, so they are terminated
+	// explicitly for the shutdown not to wait for them forever.
+	close(s.stopCh)
 	s.httpServer.Shutdown(context.Background())
 	s.wg.Wait()
 	close(s.errorCh)
 }
 
-func (s *T) getProxy(r *http.Request) (*proxy.T, error) {
-	cluster := mux.Vars(r)[prmCluster]
-	return s.proxySet.Get(cluster)
+// Drain implements server.Drainer. It makes `/_ping` respond with 503, so
+// that load balancers and service meshes that check it steer clients to other
+// instances, while requests are still served.
+func (s *T) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
 }
 
-// handleProduce is an HTTP request handler for `POST /topic/{topic}/messages`
-func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-
-	pxy, err := s.getProxy(r)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
-	}
-	topic := mux.Vars(r)[prmTopic]
-	key := getParamBytes(r, prmKey)
-	_, isSync := r.Form[prmSync]
-
-	// Get the message body from the HTTP request.
-	var msg sarama.Encoder
-	if msg, err = s.readMsg(r); err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
-	}
-
-	// Look for headers with the "X-Kafka" prefix
-	var headers []sarama.RecordHeader
-	for header, values := range r.Header {
-		if !strings.HasPrefix(header, hdrKafkaPrefix) {
-			continue
-		}
-
-		headerBytes := []byte(header[len(hdrKafkaPrefix):])
-		for _, v := range values {
-			decoded, err := base64.StdEncoding.DecodeString(v)
-			if err != nil {
-				errorText := fmt.Sprintf("Invalid base64 encoding for header: %s", header)
-				s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
-				return
+// identify is a middleware that assigns an ID to every request, or honors the
+// one passed in the `X-Request-ID` header, returns it in the same header of
+// the response, and passes it to handlers in the request context along with
+// the W3C trace context passed in the request headers. Requests are counted
+// by the client library given in the `X-Client-Name` and `X-Client-Version`
+// headers. Requests that fail with a server error are logged along with
+// their IDs.
+func (s *T) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				clientstats.Record(r.Header.Get(hdrClientName), r.Header.Get(hdrClientVersion), "http:"+r.Method+" "+path)
 			}
-			headers = append(headers, sarama.RecordHeader{
-				Key:   headerBytes,
-				Value: decoded,
-			})
 		}
-	}
-
-	// Asynchronously submit the message to the Kafka cluster.
-	if !isSync {
-		pxy.AsyncProduce(topic, toEncoderPreservingNil(key), msg, headers)
-		s.respondWithJSON(w, http.StatusOK, EmptyResponse)
-		return
-	}
-
-	prodMsg, err := pxy.Produce(topic, toEncoderPreservingNil(key), msg, headers)
-	if err != nil {
-		var status int
-		switch err {
-		case sarama.ErrUnknownTopicOrPartition:
-			status = http.StatusNotFound
-		case proxy.ErrDisabled:
-			fallthrough
-		case proxy.ErrUnavailable:
-			status = http.StatusServiceUnavailable
-		case proxy.ErrHeadersUnsupported:
-			status = http.StatusBadRequest
-		default:
-			status = http.StatusInternalServerError
+		requestID := reqid.Honor(r.Header.Get(hdrRequestID))
+		w.Header().Set(hdrRequestID, requestID)
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := reqid.NewContext(r.Context(), requestID)
+		ctx = tracectx.NewContext(ctx, tracectx.T{
+			Parent: r.Header.Get(tracectx.ParentHeader),
+			State:  r.Header.Get(tracectx.StateHeader),
+		})
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		if sr.status >= http.StatusInternalServerError {
+			s.actDesc.Log().Errorf("Request failed: requestID=%s, method=%s, url=%s, status=%d", requestID, r.Method, r.URL, sr.status)
 		}
-		s.respondWithJSON(w, status, errorRs{err.Error()})
-		return
-	}
-
-	s.respondWithJSON(w, http.StatusOK, produceRs{
-		Partition: prodMsg.Partition,
-		Offset:    prodMsg.Offset,
 	})
 }
 
-// readMsg reads message from the HTTP request based on the Content-Type header.
-func (s *T) readMsg(r *http.Request) (sarama.Encoder, error) {
-	contentType := r.Header.Get(hdrContentType)
-	if contentType == "text/plain" || jsonContentTypePattern.MatchString(contentType) {
-		if _, ok := r.Header[hdrContentLength]; !ok {
-			return nil, errors.Errorf("missing %s header", hdrContentLength)
+// authenticate is a middleware that authenticates callers as tenants if
+// tenancy is configured, and passes the tenant to handlers in the request
+// context.
+func (s *T) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tenancy == nil || r.URL.Path == "/_ping" {
+			next.ServeHTTP(w, r)
+			return
 		}
-		messageSizeStr := r.Header.Get(hdrContentLength)
-		msgSize, err := strconv.Atoi(messageSizeStr)
+		tenant, err := s.tenancy.Authenticate(r.Header.Get(hdrAuthorization))
 		if err != nil {
-			return nil, errors.Errorf("invalid %s header: %s", hdrContentLength, messageSizeStr)
+			s.respondWithError(w, http.StatusUnauthorized, err)
+			return
 		}
-		msg, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to read message")
+		next.ServeHTTP(w, r.WithContext(tenancy.NewContext(r.Context(), tenant)))
+	})
+}
+
+// pooled is a middleware that handles requests on the worker pool of the
+// endpoint group their route belongs to, and rejects them if the pool has
+// neither a free worker nor room in its queue.
+func (s *T) pooled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := s.routeGroups[mux.CurrentRoute(r)]
+		if !s.pools.Acquire(group) {
+			s.respondWithError(w, http.StatusTooManyRequests, server.ErrPoolExhausted)
+			return
 		}
-		if len(msg) != msgSize {
-			return nil, errors.Errorf("message size does not match %s: expected=%v, actual=%v",
-				hdrContentLength, msgSize, len(msg))
+		defer s.pools.Release(group)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// assignRoutes assigns all registered routes that have not been assigned to
+// an endpoint group yet to the specified one.
+func (s *T) assignRoutes(router *mux.Router, group string) {
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if _, ok := s.routeGroups[route]; !ok {
+			s.routeGroups[route] = group
 		}
-		return sarama.ByteEncoder(msg), nil
-	}
-	if contentType == "application/x-www-form-urlencoded" {
-		msg := r.FormValue("msg")
-		if msg == "" {
-			return nil, errors.Errorf("empty message")
+		return nil
+	})
+}
+
+// isEnabled tells whether endpoints of a group should be served.
+func (s *T) isEnabled(group string) bool {
+	return s.endpointGroups == nil || s.endpointGroups[group]
+}
+
+// tenantless wraps handlers of endpoints that are not tenant aware, so that
+// they are not available to tenants.
+func (s *T) tenantless(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tenancy.FromContext(r.Context()) != nil {
+			s.respondWithError(w, http.StatusForbidden, tenancy.ErrForbidden)
+			return
 		}
-		return sarama.StringEncoder(msg), nil
+		handler(w, r)
 	}
-	return nil, errors.Errorf("unsupported content type %s", contentType)
 }
 
-// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
-func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
+func (s *T) getProxy(r *http.Request) (*proxy.T, error) {
+	return s.proxySet.Get(getClusterParam(r))
+}
+
+// getProduceProxy returns a proxy to produce a message with the given headers
+// to. If the request does not select a cluster explicitly, then the cluster
+// is selected by produce routing rules if any.
+func (s *T) getProduceProxy(r *http.Request, headers []sarama.RecordHeader) (*proxy.T, error) {
+	return s.proxySet.GetForProduce(getClusterParam(r), headers)
+}
+
+// isReadOnly tells whether producing and setting offsets are disabled either
+// for the server or for the cluster of the proxy.
+func (s *T) isReadOnly(pxy *proxy.T) bool {
+	return s.readOnly || pxy.IsReadOnly()
+}
+
+// handleProduce is an HTTP request handler for `POST /topic/{topic}/messages`
+func (s *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	pxy, err := s.getProxy(r)
+	headers, err := parseRecordHeaders(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	pxy, err := s.getProduceProxy(r, headers)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	ack, err := parseAck(r, true)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
 		return
 	}
-
-	consMsg, err := pxy.Consume(group, topic, ack)
-	if err != nil {
-		var status int
-		switch err {
-		case consumer.ErrRequestTimeout:
-			status = http.StatusRequestTimeout
-		case consumer.ErrTooManyRequests:
-			status = http.StatusTooManyRequests
-		case consumer.ErrUnavailable:
-			fallthrough
-		case proxy.ErrDisabled:
-			fallthrough
-		case proxy.ErrUnavailable:
-			status = http.StatusServiceUnavailable
-		default:
-			status = http.StatusInternalServerError
-		}
-		s.respondWithJSON(w, status, errorRs{err.Error()})
+	topic := getTopicParam(r)
+	if !pxy.IsTopicAllowed(topic) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrTopicNotAllowed)
 		return
 	}
+	key := getProduceKey(r)
 
-	headers := make([]consumeHeader, 0, len(consMsg.Headers))
-	for _, h := range consMsg.Headers {
-		headers = append(headers, consumeHeader{
-			Key:   string(h.Key),
-			Value: h.Value,
-		})
+	// Get the message body from the HTTP request.
+	msg, release, err := s.readMsg(r)
+	if err != nil {
+		s.respondWithError(w, readMsgErrorStatus(err), err)
+		return
 	}
-
-	s.respondWithJSON(w, http.StatusOK, consumeRs{
-		Key:       consMsg.Key,
-		Value:     consMsg.Value,
-		Partition: consMsg.Partition,
-		Offset:    consMsg.Offset,
-		Headers:   headers,
-	})
+	defer release()
+	s.produce(w, r, pxy, topic, key, msg, headers)
 }
 
-// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
-func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
+// handleProduceTombstone is an HTTP request handler for
+// `DELETE /topic/{topic}/messages`. It produces a message with the given key
+// and a null value, that compaction treats as a deletion of the key. Unlike
+// an empty body of a produce request, a null value cannot be expressed with
+// `POST`.
+func (s *T) handleProduceTombstone(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	pxy, err := s.getProxy(r)
+	headers, err := parseRecordHeaders(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	pxy, err := s.getProduceProxy(r, headers)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	ack, err := parseAck(r, false)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	if !pxy.IsTopicAllowed(topic) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrTopicNotAllowed)
+		return
+	}
+	if getParamBytes(r, prmKey) == nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("%s is required for a tombstone", prmKey))
 		return
 	}
+	s.produce(w, r, pxy, topic, getProduceKey(r), nil, headers)
+}
 
-	err = pxy.Ack(group, topic, ack)
+// produce submits a message read from a produce request to Kafka, either
+// synchronously or asynchronously as the request parameters tell, and
+// responds with the outcome.
+func (s *T) produce(w http.ResponseWriter, r *http.Request, pxy *proxy.T, topic string, key, msg sarama.Encoder, headers []sarama.RecordHeader) {
+	_, isSync := r.Form[prmSync]
+	_, isStrict := r.Form[prmStrictOrdering]
+	headers = pxy.WithRequestID(headers, reqid.FromContext(r.Context()))
+	headers = pxy.WithTraceContext(headers, tracectx.FromContext(r.Context()))
+	headers = pxy.WithEnrichment(headers, topic, tenancy.FromContext(r.Context()))
+
+	// Asynchronously submit the message to the Kafka cluster.
+	if !isSync && isStrict {
+		err := pxy.AsyncProduceStrictlyOrdered(topic, key, msg, headers,
+			r.Form.Get(prmCallback), reqid.FromContext(r.Context()))
+		if err != nil {
+			s.respondWithError(w, http.StatusForbidden, err)
+			return
+		}
+		s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+		return
+	}
+	if !isSync {
+		if callbackURL := r.Form.Get(prmCallback); callbackURL != "" {
+			err := pxy.AsyncProduceWithReceipt(topic, key, msg, headers,
+				callbackURL, reqid.FromContext(r.Context()))
+			if err != nil {
+				s.respondWithError(w, http.StatusForbidden, err)
+				return
+			}
+		} else {
+			pxy.AsyncProduce(topic, key, msg, headers)
+		}
+		s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+		return
+	}
+
+	produce := pxy.Produce
+	if isStrict {
+		produce = pxy.ProduceStrictlyOrdered
+	}
+	prodMsg, err := produce(topic, key, msg, headers)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		s.respondWithError(w, produceErrorStatus(err), err)
 		return
 	}
-	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+
+	s.respondWithJSON(w, http.StatusOK, produceRs{
+		Partition: prodMsg.Partition,
+		Offset:    prodMsg.Offset,
+	})
 }
 
-// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
-func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+// handleValidateProduce is an HTTP request handler for
+// `POST /topic/{topic}/messages/_validate`. It responds the same way as
+// `handleProduce` would, except that nothing is produced.
+func (s *T) handleValidateProduce(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	pxy, err := s.getProxy(r)
+	headers, err := parseRecordHeaders(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-	group, err := getGroupParam(r, false)
+	pxy, err := s.getProduceProxy(r, headers)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	key := getProduceKey(r)
+	msg, release, err := s.readMsg(r)
 	if err != nil {
-		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
-			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+		s.respondWithError(w, readMsgErrorStatus(err), err)
+		return
+	}
+	defer release()
+	if err := pxy.ValidateProduce(topic, key, msg, headers); err != nil {
+		status := produceErrorStatus(err)
+		if err == proxy.ErrReadOnly || err == proxy.ErrTopicNotAllowed {
+			status = http.StatusForbidden
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// parseRecordHeaders returns headers with the "X-Kafka" prefix, except the
+// one that selects a cluster, as record headers.
+func parseRecordHeaders(r *http.Request) ([]sarama.RecordHeader, error) {
+	var headers []sarama.RecordHeader
+	for header, values := range r.Header {
+		if !strings.HasPrefix(header, hdrKafkaPrefix) || header == hdrKafkaCluster {
+			continue
+		}
+
+		headerBytes := []byte(header[len(hdrKafkaPrefix):])
+		for _, v := range values {
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.Errorf("Invalid base64 encoding for header: %s", header)
+			}
+			headers = append(headers, sarama.RecordHeader{
+				Key:   headerBytes,
+				Value: decoded,
+			})
+		}
+	}
+	return headers, nil
+}
+
+// produceErrorStatus returns the HTTP status to respond with when producing
+// a message fails with the given error.
+func produceErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case proxy.ErrUnknownTopic:
+		fallthrough
+	case sarama.ErrUnknownTopicOrPartition:
+		return http.StatusNotFound
+	case proxy.ErrDisabled:
+		fallthrough
+	case proxy.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	case proxy.ErrHeadersUnsupported:
+		return http.StatusBadRequest
+	case proxy.ErrMessageTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case proxy.ErrLimitExceeded:
+		return http.StatusTooManyRequests
+	case validator.ErrRejected:
+		return http.StatusUnprocessableEntity
+	case validator.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// readMsg reads message from the HTTP request based on the Content-Type
+// header. A raw body is read into a buffer that is handed over to the
+// producer as is. Its size is taken from the produce buffer, and must be
+// given back by calling the returned release function once the message is
+// produced.
+func (s *T) readMsg(r *http.Request) (sarama.Encoder, func(), error) {
+	contentType := r.Header.Get(hdrContentType)
+	if contentType == "text/plain" || jsonContentTypePattern.MatchString(contentType) {
+		msg, err := s.readBody(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sarama.ByteEncoder(msg), func() { s.produceBuffer.Release(int64(cap(msg))) }, nil
+	}
+	if contentType == "application/x-www-form-urlencoded" {
+		msg := r.FormValue("msg")
+		if msg == "" {
+			return nil, nil, errors.Errorf("empty message")
+		}
+		return sarama.StringEncoder(msg), func() {}, nil
+	}
+	return nil, nil, errors.Errorf("unsupported content type %s", contentType)
+}
+
+// readBody reads a produce request body. If the body size is known from the
+// Content-Length header, then it is read into a buffer of exactly that size,
+// otherwise, e.g. with chunked transfer encoding, the buffer grows as the
+// body is read. Either way the buffer is taken from the produce buffer, and
+// a body that is larger than allowed is rejected as soon as that is known.
+func (s *T) readBody(r *http.Request) ([]byte, error) {
+	if r.ContentLength >= 0 {
+		if s.produceMaxBodySize > 0 && r.ContentLength > s.produceMaxBodySize {
+			return nil, errors.Wrapf(errBodyTooLarge, "size=%d, max=%d", r.ContentLength, s.produceMaxBodySize)
+		}
+		if !s.produceBuffer.Acquire(r.ContentLength, s.produceBufferTimeout) {
+			return nil, errors.Wrapf(errBufferFull, "size=%d", r.ContentLength)
+		}
+		msg := make([]byte, r.ContentLength)
+		if n, err := io.ReadFull(r.Body, msg); err != nil {
+			s.produceBuffer.Release(r.ContentLength)
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return nil, errors.Errorf("message size does not match %s: expected=%v, actual=%v",
+					hdrContentLength, r.ContentLength, n)
+			}
+			return nil, errors.Wrap(err, "failed to read message")
+		}
+		return msg, nil
+	}
+	var msg []byte
+	for {
+		if len(msg) == cap(msg) {
+			newCap := 2 * int64(cap(msg))
+			if newCap == 0 {
+				newCap = bodyReadStep
+			}
+			if s.produceMaxBodySize > 0 && newCap > s.produceMaxBodySize+1 {
+				newCap = s.produceMaxBodySize + 1
+			}
+			if !s.produceBuffer.Acquire(newCap, s.produceBufferTimeout) {
+				s.produceBuffer.Release(int64(cap(msg)))
+				return nil, errors.Wrapf(errBufferFull, "size=%d", newCap)
+			}
+			grown := make([]byte, len(msg), newCap)
+			copy(grown, msg)
+			s.produceBuffer.Release(int64(cap(msg)))
+			msg = grown
+		}
+		n, err := r.Body.Read(msg[len(msg):cap(msg)])
+		msg = msg[:len(msg)+n]
+		if s.produceMaxBodySize > 0 && int64(len(msg)) > s.produceMaxBodySize {
+			s.produceBuffer.Release(int64(cap(msg)))
+			return nil, errors.Wrapf(errBodyTooLarge, "max=%d", s.produceMaxBodySize)
+		}
+		if err == io.EOF {
+			return msg, nil
+		}
+		if err != nil {
+			s.produceBuffer.Release(int64(cap(msg)))
+			return nil, errors.Wrap(err, "failed to read message")
+		}
+	}
+}
+
+// readMsgErrorStatus returns the HTTP status code that a produce request that
+// failed to read a message with the specified error should be responded with.
+func readMsgErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case errBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errBufferFull:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
+func (s *T) handleConsume(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	ack, err := parseAck(r, true)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	affinity, err := proxy.ParseAffinity(r.FormValue(prmAffinity))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	priority, err := proxy.ParsePriority(r.FormValue(prmPriority))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	window, err := proxy.ParseWindow(r.FormValue(prmWindow))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	stop, err := proxy.ParseStop(r.FormValue(prmStopAt))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	ackTimeout, err := proxy.ParseAckTimeout(r.FormValue(prmAckTimeout))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	valueBytes, err := parseValueBytes(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, endOfStream := r.Form[prmEndOfStream]
+
+	consMsg, err := pxy.ConsumeContext(r.Context(), group, topic, ack, affinity, priority, window, stop, ackTimeout)
+	if err != nil {
+		if err == context.Canceled {
+			// The client has disconnected, so there is nobody to respond to.
 			return
 		}
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+		if err == proxy.ErrStopReached {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err == consumer.ErrRequestTimeout && endOfStream {
+			if ok, eosErr := pxy.IsEndOfStream(group, topic); eosErr == nil && ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		s.respondWithError(w, consumeErrorStatus(err), err)
 		return
 	}
 
-	offsetViews := make([]partitionInfo, len(partitionOffsets))
-	for i, po := range partitionOffsets {
-		offsetViews[i].Partition = po.Partition
-		offsetViews[i].Begin = po.Begin
-		offsetViews[i].End = po.End
-		offsetViews[i].Count = po.End - po.Begin
-		offsetViews[i].Offset = po.Offset
-		if po.Offset == sarama.OffsetNewest {
-			offsetViews[i].Lag = 0
-		} else if po.Offset == sarama.OffsetOldest {
-			offsetViews[i].Lag = po.End - po.Begin
-		} else {
-			offsetViews[i].Lag = po.End - po.Offset
+	headers := make([]consumeHeader, 0, len(consMsg.Headers))
+	for _, h := range consMsg.Headers {
+		headers = append(headers, consumeHeader{
+			Key:   string(h.Key),
+			Value: h.Value,
+		})
+	}
+
+	if traceCtx, ok := pxy.TraceContextOf(&consMsg); ok {
+		w.Header().Set(tracectx.ParentHeader, traceCtx.Parent)
+		if traceCtx.State != "" {
+			w.Header().Set(tracectx.StateHeader, traceCtx.State)
 		}
-		offsetViews[i].Metadata = po.Metadata
-		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
-		offsetViews[i].SparseAcks = offsettrk.SparseAcks2Str(offset)
 	}
-	s.respondWithJSON(w, http.StatusOK, offsetViews)
+
+	// If the value codec of the topic is known and a client accepts another
+	// one, then the value is transcoded to it.
+	acceptedCodec, _ := codec.FromAccept(r.Header.Get(hdrAccept))
+	consValue, valueCodec := pxy.TranscodeValue(topic, consMsg.Value, acceptedCodec)
+	if valueCodec != "" {
+		w.Header().Set(hdrValueCodec, valueCodec)
+	}
+
+	compressible := !isCompressed(consValue, consMsg.Headers)
+	value, valueSize := truncateValue(consValue, valueBytes)
+	s.respondWithCompressedJSON(w, r, http.StatusOK, consumeRs{
+		Key:        consMsg.Key,
+		Value:      value,
+		ValueSize:  valueSize,
+		Partition:  consMsg.Partition,
+		Offset:     consMsg.Offset,
+		Headers:    headers,
+		MemberID:   consMsg.MemberID,
+		Generation: consMsg.Generation,
+		Affinity:   proxy.AffinityToken(&consMsg),
+		DataLoss:   consMsg.DataLoss,
+	}, compressible)
 }
 
-// handleGetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
-func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+// handleValidateConsume is an HTTP request handler for
+// `GET /topic/{topic}/messages/_validate`. It responds the same way as
+// `handleConsume` would, except that no message is consumed.
+func (s *T) handleValidateConsume(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	ackTimeout, err := proxy.ParseAckTimeout(r.FormValue(prmAckTimeout))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := pxy.ValidateConsume(group, topic, ackTimeout); err != nil {
+		status := consumeErrorStatus(err)
+		if err == sarama.ErrUnknownTopicOrPartition {
+			status = http.StatusNotFound
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// parseValueBytes parses the maximum number of value bytes to respond with.
+// If the parameter is omitted, then -1 is returned, meaning that values
+// should be returned whole.
+func parseValueBytes(r *http.Request) (int, error) {
+	valueBytesStr := r.FormValue(prmValueBytes)
+	if valueBytesStr == "" {
+		return -1, nil
+	}
+	valueBytes, err := strconv.Atoi(valueBytesStr)
+	if err != nil || valueBytes < 0 {
+		return 0, errors.Errorf("bad %s: %s", prmValueBytes, valueBytesStr)
+	}
+	return valueBytes, nil
+}
+
+// truncateValue returns at most maxBytes first bytes of a value along with
+// its full size. If maxBytes is negative, then the value is returned whole
+// and the size is not reported.
+func truncateValue(value []byte, maxBytes int) ([]byte, int) {
+	if maxBytes < 0 {
+		return value, 0
+	}
+	if len(value) > maxBytes {
+		return value[:maxBytes], len(value)
+	}
+	return value, len(value)
+}
+
+// consumeErrorStatus returns the HTTP status to respond with when consuming
+// a message fails with the given error.
+func consumeErrorStatus(err error) int {
+	switch err {
+	case consumer.ErrRequestTimeout:
+		return http.StatusRequestTimeout
+	case proxy.ErrAckTimeoutTooLong:
+		return http.StatusBadRequest
+	case consumer.ErrTooManyRequests:
+		fallthrough
+	case proxy.ErrLimitExceeded:
+		fallthrough
+	case proxy.ErrTooManyGroups:
+		return http.StatusTooManyRequests
+	case proxy.ErrGroupMigrated:
+		return http.StatusGone
+	case proxy.ErrGroupMigrating:
+		fallthrough
+	case consumer.ErrUnavailable:
+		fallthrough
+	case proxy.ErrDisabled:
+		fallthrough
+	case proxy.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
+func (s *T) handleAck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	ack, err := parseAck(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = pxy.Ack(group, topic, ack)
+	if err != nil {
+		if err == proxy.ErrStaleGeneration {
+			s.respondWithError(w, http.StatusConflict, err)
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleHeartbeat is an HTTP request handler for
+// `POST /topic/{topic}/heartbeats`
+func (s *T) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	ack, err := parseAck(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if ack == proxy.AutoAck() || ack == proxy.NoAck() {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("%s and %s must be provided", prmPartition, prmOffset))
+		return
+	}
+	ackTimeout, err := proxy.ParseAckTimeout(r.FormValue(prmAckTimeout))
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = pxy.Extend(group, topic, ack, ackTimeout)
+	if err != nil {
+		if err == proxy.ErrAckTimeoutTooLong {
+			s.respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err == proxy.ErrStaleGeneration {
+			s.respondWithError(w, http.StatusConflict, err)
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleSubscribe is an HTTP request handler for
+// `POST /topics/{topic}/subscription`. It subscribes this Kafka-Pixy
+// instance to the topic on behalf of a consumer group without waiting for
+// the first consume request.
+func (s *T) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := pxy.Subscribe(group, topic); err != nil {
+		s.respondWithError(w, consumeErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleUnsubscribe is an HTTP request handler for
+// `DELETE /topics/{topic}/subscription`. It unsubscribes this Kafka-Pixy
+// instance from the topic on behalf of a consumer group without waiting for
+// the subscription to expire.
+func (s *T) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
+	topic := getTopicParam(r)
 	group, err := getGroupParam(r, false)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := pxy.Unsubscribe(group, topic); err != nil {
+		s.respondWithError(w, consumeErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleFetchPartition is an HTTP request handler for
+// `GET /topics/{topic}/partitions/{partition}/messages`. Messages are fetched
+// from the requested offset, or if it is omitted, from the offset
+// checkpointed under the name given in the `group` parameter.
+func (s *T) handleFetchPartition(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	partition, err := getPartitionParam(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
+	name, err := getGroupParam(r, true)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := fetchDefaultLimit
+	if limitStr := r.FormValue(prmFetchLimit); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 1 || limit > fetchMaxLimit {
+			s.respondWithError(w, http.StatusBadRequest,
+				errors.Errorf("bad %s, must be from 1 to %d: %s", prmFetchLimit, fetchMaxLimit, limitStr))
+			return
+		}
+	}
+	valueBytes, err := parseValueBytes(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	var offset int64
+	if offsetStr := r.FormValue(prmOffset); offsetStr != "" {
+		if offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil || offset < 0 {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmOffset, offsetStr))
+			return
+		}
+	} else if name == "" {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("either %s or %s must be provided", prmOffset, prmGroup))
+		return
+	} else if offset, err = pxy.GetCheckpoint(name, topic, partition); err != nil {
+		s.respondWithError(w, fetchErrorStatus(err), err)
+		return
+	}
+
+	messages, err := pxy.FetchPartition(topic, partition, offset, limit)
+	if err != nil {
+		s.respondWithError(w, fetchErrorStatus(err), err)
+		return
+	}
+
+	rs := fetchRs{Messages: make([]fetchedMessage, len(messages)), NextOffset: offset}
+	// Compressing is only worth it if some of the messages are not
+	// compressed already.
+	compressible := false
+	for i, msg := range messages {
+		compressible = compressible || !isCompressed(msg.Value, msg.Headers)
+		headers := make([]consumeHeader, 0, len(msg.Headers))
+		for _, h := range msg.Headers {
+			headers = append(headers, consumeHeader{
+				Key:   string(h.Key),
+				Value: h.Value,
+			})
+		}
+		value, valueSize := truncateValue(msg.Value, valueBytes)
+		rs.Messages[i] = fetchedMessage{
+			Key:       msg.Key,
+			Value:     value,
+			ValueSize: valueSize,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+			Headers:   headers,
+		}
+		rs.NextOffset = msg.Offset + 1
+	}
+	s.respondWithCompressedJSON(w, r, http.StatusOK, rs, compressible)
+}
+
+// handleCheckpoint is an HTTP request handler for
+// `POST /topics/{topic}/partitions/{partition}/checkpoint`. It commits the
+// offset of the next message to fetch under the name given in the `group`
+// parameter.
+func (s *T) handleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	partition, err := getPartitionParam(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	offsetStr := r.FormValue(prmOffset)
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmOffset, offsetStr))
+		return
+	}
+
+	if err := pxy.Checkpoint(name, topic, partition, offset); err != nil {
+		s.respondWithError(w, fetchErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// fetchErrorStatus returns the HTTP status to respond with when fetching
+// messages from a partition, or checkpointing the progress, fails with the
+// given error.
+func fetchErrorStatus(err error) int {
+	if _, ok := err.(admin.ErrInvalidParam); ok {
+		return http.StatusBadRequest
+	}
+	switch errors.Cause(err) {
+	case sarama.ErrUnknownTopicOrPartition:
+		return http.StatusNotFound
+	case proxy.ErrReadOnly:
+		return http.StatusForbidden
+	case proxy.ErrDisabled:
+		fallthrough
+	case proxy.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
+func (s *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	partitionOffsets, err := pxy.GetGroupOffsets(group, topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	offsetViews := make([]partitionInfo, len(partitionOffsets))
+	for i, po := range partitionOffsets {
+		offsetViews[i].Partition = po.Partition
+		offsetViews[i].Begin = po.Begin
+		offsetViews[i].End = po.End
+		offsetViews[i].Count = po.End - po.Begin
+		offsetViews[i].Offset = po.Offset
+		offsetViews[i].Lag = partitionLag(po)
+		offsetViews[i].Metadata = po.Metadata
+		offset := offsetmgr.Offset{Val: po.Offset, Meta: po.Metadata}
+		offsetViews[i].SparseAcks = offsettrk.SparseAcks2Str(offset)
+		_, offsetViews[i].AckMetadata = offsettrk.SplitMeta(po.Metadata)
+	}
+	s.respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+// handleGetProgress is an HTTP request handler for
+// `GET /topic/{topic}/progress`. It tells whether a consumer group has
+// consumed a topic up to a timestamp, or a partition up to an offset.
+func (s *T) handleGetProgress(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	var target admin.ProgressTarget
+	timestampStr, offsetStr := r.FormValue(prmTimestamp), r.FormValue(prmOffset)
+	switch {
+	case timestampStr != "" && offsetStr == "":
+		if target.Timestamp, err = time.Parse(time.RFC3339, timestampStr); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmTimestamp, timestampStr))
+			return
+		}
+	case offsetStr != "" && timestampStr == "":
+		if target.Offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil || target.Offset < 0 {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmOffset, offsetStr))
+			return
+		}
+		partitionStr := r.FormValue(prmPartition)
+		partition, err := strconv.ParseInt(partitionStr, 10, 32)
+		if err != nil || partition < 0 {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmPartition, partitionStr))
+			return
+		}
+		target.Partition = int32(partition)
+	default:
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("either %s or %s must be provided", prmTimestamp, prmOffset))
+		return
+	}
+
+	progress, err := pxy.GetGroupProgress(group, topic, target)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic or partition"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rs := progressRs{
+		Reached:    progress.Reached,
+		Lag:        progress.Lag,
+		Partitions: make([]partitionProgressRs, len(progress.Partitions)),
+	}
+	for i, pp := range progress.Partitions {
+		rs.Partitions[i] = partitionProgressRs{Partition: pp.Partition, Offset: pp.Offset, Target: pp.Target, Lag: pp.Lag}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleTranslateOffsets is an HTTP request handler for
+// `POST /topic/{topic}/offsets/translate`
+func (s *T) handleTranslateOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	targetCluster := r.FormValue(prmTargetCluster)
+	if targetCluster == "" {
+		s.respondWithError(w, http.StatusBadRequest, errors.New("target cluster is not specified"))
+		return
+	}
+	targetPxy, err := s.proxySet.Get(targetCluster)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if targetPxy == pxy {
+		s.respondWithError(w, http.StatusBadRequest, errors.New("target cluster is the same as the source one"))
+		return
+	}
+	if s.isReadOnly(targetPxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+
+	translations, err := pxy.TranslateGroupOffsets(targetPxy, group, topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	translationViews := make([]offsetTranslation, len(translations))
+	for i, ot := range translations {
+		translationViews[i].Partition = ot.Partition
+		translationViews[i].SourceOffset = ot.SourceOffset
+		translationViews[i].TargetOffset = ot.TargetOffset
+		if !ot.Timestamp.IsZero() {
+			timestamp := ot.Timestamp
+			translationViews[i].Timestamp = &timestamp
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, translationViews)
+}
+
+// handleCloneOffsets is an HTTP request handler for
+// `POST /topic/{topic}/offsets/clone`
+func (s *T) handleCloneOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	clone := r.FormValue(prmCloneGroup)
+	if clone == "" {
+		s.respondWithError(w, http.StatusBadRequest, errors.New("clone group is not specified"))
+		return
+	}
+	clone = tenancy.FromContext(r.Context()).Group(clone)
+
+	offsets, err := pxy.CloneGroupOffsets(group, clone, topic)
+	if err != nil {
+		if _, ok := err.(admin.ErrInvalidParam); ok {
+			s.respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	offsetViews := make([]clonedOffset, len(offsets))
+	for i, po := range offsets {
+		offsetViews[i].Partition = po.Partition
+		offsetViews[i].Offset = po.Offset
+		offsetViews[i].Metadata = po.Metadata
+	}
+	s.respondWithJSON(w, http.StatusOK, offsetViews)
+}
+
+// handleResetToEnd is an HTTP request handler for
+// `POST /topics/{topic}/offsets/reset_to_end`
+func (s *T) handleResetToEnd(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	audit := proxy.ForceAckAudit{
+		By:         r.FormValue(prmForceAckBy),
+		Reason:     r.FormValue(prmForceAckReason),
+		RequestID:  reqid.FromContext(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+	}
+	if audit.By == "" || audit.Reason == "" {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("%s and %s must be provided", prmForceAckBy, prmForceAckReason))
+		return
+	}
+
+	resets, err := pxy.ResetGroupToEnd(group, topic, audit)
+	if err != nil {
+		switch errors.Cause(err) {
+		case sarama.ErrUnknownTopicOrPartition:
+			s.respondWithError(w, http.StatusNotFound, err)
+		case proxy.ErrUnavailable:
+			s.respondWithError(w, http.StatusServiceUnavailable, err)
+		default:
+			s.respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	rs := resetToEndRs{Partitions: make([]resetPartition, len(resets))}
+	for i, reset := range resets {
+		rs.Partitions[i] = resetPartition{
+			Partition: reset.Partition,
+			Offset:    reset.Offset,
+			End:       reset.End,
+			Skipped:   reset.Skipped,
+			Owner:     reset.Owner,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleForceAck is an HTTP request handler for
+// `POST /topics/{topic}/partitions/{partition}/force_ack`
+func (s *T) handleForceAck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	partition, err := getPartitionParam(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	offsetStr := r.FormValue(prmOffset)
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmOffset, offsetStr))
+		return
+	}
+	count := 1
+	if countStr := r.FormValue(prmForceAckCount); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 || count > proxy.MaxForceAckCount {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s, must be from 1 to %d",
+				prmForceAckCount, countStr, proxy.MaxForceAckCount))
+			return
+		}
+	}
+	audit := proxy.ForceAckAudit{
+		By:         r.FormValue(prmForceAckBy),
+		Reason:     r.FormValue(prmForceAckReason),
+		RequestID:  reqid.FromContext(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+	}
+	if audit.By == "" || audit.Reason == "" {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("%s and %s must be provided", prmForceAckBy, prmForceAckReason))
+		return
+	}
+
+	if err := pxy.ForceAck(group, topic, partition, offset, count, audit); err != nil {
+		switch errors.Cause(err) {
+		case proxy.ErrOutOfRange:
+			s.respondWithError(w, http.StatusBadRequest, err)
+		case proxy.ErrNotConsumedHere:
+			s.respondWithError(w, http.StatusConflict, err)
+		case sarama.ErrUnknownTopicOrPartition:
+			s.respondWithError(w, http.StatusNotFound, err)
+		case proxy.ErrUnavailable:
+			s.respondWithError(w, http.StatusServiceUnavailable, err)
+		default:
+			s.respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleFreeze is an HTTP request handler for `POST /groups/{group}/freeze`
+func (s *T) handleFreeze(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+	periodStr := r.FormValue(prmFreezePeriod)
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmFreezePeriod, periodStr))
+		return
+	}
+
+	until, err := pxy.FreezeGroup(group, period)
+	if err != nil {
+		s.respondWithError(w, freezeErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, freezeRs{Frozen: true, Until: &until})
+}
+
+// handleUnfreeze is an HTTP request handler for `DELETE /groups/{group}/freeze`
+func (s *T) handleUnfreeze(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	if err := pxy.UnfreezeGroup(group); err != nil {
+		s.respondWithError(w, freezeErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, freezeRs{})
+}
+
+// handleGetFreeze is an HTTP request handler for `GET /groups/{group}/freeze`
+func (s *T) handleGetFreeze(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	until, err := pxy.GetGroupFreeze(group)
+	if err != nil {
+		s.respondWithError(w, freezeErrorStatus(err), err)
+		return
+	}
+	if until.IsZero() {
+		s.respondWithJSON(w, http.StatusOK, freezeRs{})
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, freezeRs{Frozen: true, Until: &until})
+}
+
+// freezeErrorStatus returns the HTTP status to respond with when a group
+// freeze call fails with the given error.
+func freezeErrorStatus(err error) int {
+	switch err {
+	case freeze.ErrNotFrozen:
+		return http.StatusNotFound
+	case proxy.ErrReadOnly:
+		return http.StatusForbidden
+	case proxy.ErrDisabled:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleStartMigration is an HTTP request handler for
+// `POST /groups/{group}/migration`
+func (s *T) handleStartMigration(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	tnt := tenancy.FromContext(r.Context())
+	group := tnt.Group(mux.Vars(r)[prmGroup])
+	toBackend := r.FormValue(prmMigrateToBackend)
+	toGroup := r.FormValue(prmMigrateToGroup)
+	if toGroup != "" {
+		toGroup = tnt.Group(toGroup)
+	}
+	topics := r.Form[prmTopic]
+
+	m, err := pxy.StartGroupMigration(group, toBackend, toGroup, topics)
+	if err != nil {
+		s.respondWithError(w, migrationErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newMigrationRs(m, nil))
+}
+
+// handleCompleteMigration is an HTTP request handler for
+// `POST /groups/{group}/migration/complete`
+func (s *T) handleCompleteMigration(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	m, copied, err := pxy.CompleteGroupMigration(group)
+	if err != nil {
+		s.respondWithError(w, migrationErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newMigrationRs(m, copied))
+}
+
+// handleDeleteMigration is an HTTP request handler for
+// `DELETE /groups/{group}/migration`
+func (s *T) handleDeleteMigration(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	if err := pxy.DeleteGroupMigration(group); err != nil {
+		s.respondWithError(w, migrationErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetMigration is an HTTP request handler for
+// `GET /groups/{group}/migration`
+func (s *T) handleGetMigration(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	m, err := pxy.GetGroupMigration(group)
+	if err != nil {
+		s.respondWithError(w, migrationErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newMigrationRs(m, nil))
+}
+
+// migrationErrorStatus returns the HTTP status to respond with when a group
+// migration call fails with the given error.
+func migrationErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case migration.ErrNotFound:
+		return http.StatusNotFound
+	case proxy.ErrBadMigration:
+		return http.StatusBadRequest
+	case migration.ErrExists:
+		fallthrough
+	case migration.ErrCompleted:
+		fallthrough
+	case proxy.ErrGroupActive:
+		fallthrough
+	case proxy.ErrMigrationInUse:
+		return http.StatusConflict
+	case sarama.ErrUnknownTopicOrPartition:
+		return http.StatusNotFound
+	case proxy.ErrReadOnly:
+		return http.StatusForbidden
+	case proxy.ErrDisabled:
+		fallthrough
+	case proxy.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleGetOffsets is an HTTP request handler for `POST /topic/{topic}/offsets`
+func (s *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.isReadOnly(pxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := getTopicParam(r)
+	group, err := getGroupParam(r, false)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to read the request: err=(%s)", err))
+		return
+	}
+
+	var partitionOffsetViews []partitionInfo
+	if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to parse the request: err=(%s)", err))
+		return
+	}
+
+	partitionOffsets := make([]admin.PartitionOffset, len(partitionOffsetViews))
+	for i, pov := range partitionOffsetViews {
+		partitionOffsets[i].Partition = pov.Partition
+		partitionOffsets[i].Offset = pov.Offset
+		partitionOffsets[i].Metadata = pov.Metadata
+	}
+
+	err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
+	if err != nil {
+		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
+func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+
+	group, err := getGroupParam(r, true)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	lst, err := parseListing(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var consumers map[string]map[string][]int32
+	if group == "" {
+		consumers, err = pxy.GetAllTopicConsumers(topic)
+		if err != nil {
+			s.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		groupConsumers, err := pxy.GetTopicConsumers(group, topic)
+		if err != nil {
+			if _, ok := err.(admin.ErrInvalidParam); ok {
+				s.respondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+			s.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		consumers = make(map[string]map[string][]int32)
+		if len(groupConsumers) != 0 {
+			consumers[group] = groupConsumers
+		}
+	}
+	if tenant := tenancy.FromContext(r.Context()); tenant != nil {
+		tenantConsumers := make(map[string]map[string][]int32, len(consumers))
+		for group, groupConsumers := range consumers {
+			if group, ok := tenant.Logical(group); ok {
+				tenantConsumers[group] = groupConsumers
+			}
+		}
+		consumers = tenantConsumers
+	}
+	groups := make([]string, 0, len(consumers))
+	for group := range consumers {
+		groups = append(groups, group)
+	}
+	groups, next := lst.page(groups)
+	setNextPage(w, next)
+	if len(groups) < len(consumers) {
+		pageConsumers := make(map[string]map[string][]int32, len(groups))
+		for _, group := range groups {
+			pageConsumers[group] = consumers[group]
+		}
+		consumers = pageConsumers
+	}
+
+	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
+	if err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, redact.Loggable(encodedRes))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+
+	w.Header().Add(hdrContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(encodedRes); err != nil {
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, redact.Loggable(encodedRes))
+	}
+}
+
+// handleDescribeGroup is an HTTP request handler for `GET /groups/{group}`
+func (s *T) handleDescribeGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	group := tenant.Group(mux.Vars(r)[prmGroup])
+
+	gd, err := pxy.DescribeGroup(group)
+	if err != nil {
+		if _, ok := err.(admin.ErrInvalidParam); ok {
+			s.respondWithError(w, http.StatusNotFound, err)
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	members := make(map[string][]string, len(gd.Members))
+	for member, topics := range gd.Members {
+		logicalTopics := make([]string, 0, len(topics))
+		for _, topic := range topics {
+			if topic, ok := tenant.Logical(topic); ok {
+				logicalTopics = append(logicalTopics, topic)
+			}
+		}
+		members[member] = logicalTopics
+	}
+	s.respondWithJSON(w, http.StatusOK, groupRs{Generation: gd.Generation, Members: members})
+}
+
+// handleGetRebalances is an HTTP request handler for
+// `GET /groups/{group}/rebalances`
+func (s *T) handleGetRebalances(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	group := tenant.Group(mux.Vars(r)[prmGroup])
+
+	history := pxy.GetRebalanceHistory(group)
+	rs := make([]rebalanceRs, len(history))
+	for i, rb := range history {
+		rs[i] = rebalanceRs{
+			StartedAt:     rb.StartedAt,
+			DurationMs:    int64(rb.Duration / time.Millisecond),
+			Trigger:       string(rb.Trigger),
+			Generation:    rb.Generation,
+			MembersBefore: rb.MembersBefore,
+			MembersAfter:  rb.MembersAfter,
+			Error:         rb.Error,
+		}
+		if rb.Assigned != nil {
+			rs[i].Assigned = make(map[string][]int32, len(rb.Assigned))
+			for topic, partitions := range rb.Assigned {
+				if topic, ok := tenant.Logical(topic); ok {
+					rs[i].Assigned[topic] = partitions
+				}
+			}
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetGroupConcurrency is an HTTP request handler for
+// `GET /groups/{group}/concurrency`
+func (s *T) handleGetGroupConcurrency(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	group := tenant.Group(mux.Vars(r)[prmGroup])
+
+	report := pxy.GetGroupConcurrency(group)
+	rs := groupConcurrencyRs{
+		ActiveRequests:     report.ActiveRequests,
+		UnackedMsgs:        report.UnackedMsgs,
+		OldestUnackedAgeMs: int64(report.OldestUnackedAge / time.Millisecond),
+		Partitions:         []partitionConcurrencyRs{},
+	}
+	for _, pr := range report.Partitions {
+		topic, ok := tenant.Logical(pr.Topic)
+		if !ok {
+			continue
+		}
+		rs.Partitions = append(rs.Partitions, partitionConcurrencyRs{
+			Topic:              topic,
+			Partition:          pr.Partition,
+			UnackedMsgs:        pr.UnackedMsgs,
+			OldestUnackedAgeMs: int64(pr.OldestUnackedAge / time.Millisecond),
+			QueuedMsgs:         pr.QueuedMsgs,
+		})
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetGroupOffers is an HTTP request handler for
+// `GET /groups/{group}/offers`. It lists messages offered to clients of a
+// group at this instance but not acknowledged yet.
+func (s *T) handleGetGroupOffers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	group := tenant.Group(mux.Vars(r)[prmGroup])
+
+	now := time.Now()
+	rs := groupOffersRs{Partitions: []partitionOffersRs{}}
+	for _, po := range pxy.GetGroupOffers(group) {
+		topic, ok := tenant.Logical(po.Topic)
+		if !ok {
+			continue
+		}
+		partitionRs := partitionOffersRs{
+			Topic:     topic,
+			Partition: po.Partition,
+			Offers:    make([]offerRs, len(po.Offers)),
+		}
+		for i, o := range po.Offers {
+			partitionRs.Offers[i] = offerRs{
+				Offset:     o.Offset,
+				AgeMs:      int64(now.Sub(o.OfferedAt) / time.Millisecond),
+				Deliveries: o.Deliveries,
+				RequestID:  o.RequestID,
+			}
+		}
+		rs.Partitions = append(rs.Partitions, partitionRs)
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetPartitionOwners is an HTTP request handler for
+// `GET /groups/{group}/partitions`
+func (s *T) handleGetPartitionOwners(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	group := tenant.Group(mux.Vars(r)[prmGroup])
+
+	owners, err := pxy.GetGroupPartitionOwners(group)
+	if err != nil {
+		if _, ok := err.(admin.ErrInvalidParam); ok {
+			s.respondWithError(w, http.StatusNotFound, err)
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ownersView := make(map[string][]partitionOwner, len(owners))
+	for topic, topicOwners := range owners {
+		topic, ok := tenant.Logical(topic)
+		if !ok {
+			continue
+		}
+		topicOwnersView := make([]partitionOwner, len(topicOwners))
+		for i, po := range topicOwners {
+			topicOwnersView[i] = partitionOwner{Partition: po.Partition, Owner: po.Owner, ClaimedAt: po.ClaimedAt}
+		}
+		ownersView[topic] = topicOwnersView
+	}
+	s.respondWithJSON(w, http.StatusOK, ownersView)
+}
+
+// handleListTopics is an HTTP request handler for `GET /topics`
+func (s *T) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, withConfig := r.Form[prmTopicsWithConfig]
+	_, withPartitions := r.Form[prmTopicsWithPartitions]
+	lst, err := parseListing(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	topicsMetadata, err := pxy.ListTopics(withPartitions, withConfig)
+	if err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tenant := tenancy.FromContext(r.Context())
+	logicalMetadata := make(map[string]admin.TopicMetadata, len(topicsMetadata))
+	topics := make([]string, 0, len(topicsMetadata))
+	for _, tm := range topicsMetadata {
+		if topic, ok := tenant.Logical(tm.Topic); ok {
+			logicalMetadata[topic] = tm
+			topics = append(topics, topic)
+		}
+	}
+	topics, next := lst.page(topics)
+	setNextPage(w, next)
+
+	if withPartitions || withConfig {
+		topicMetadataViews := make(map[string]interface{}, len(topics))
+		for _, topic := range topics {
+			topicMetadataView := newTopicMetadataView(withPartitions, withConfig, logicalMetadata[topic])
+			if topicMetadataViews[topic], err = lst.selectFields(&topicMetadataView); err != nil {
+				s.respondWithError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		s.respondWithJSON(w, http.StatusOK, topicMetadataViews)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, topics)
+}
+
+// handleGetTopicMetadata is an HTTP request handler for `GET /topics/{topic}`
+func (s *T) handleGetTopicMetadata(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var err error
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+
+	err = r.ParseForm()
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	withConfig := true
+	_, withPartitions := r.Form[prmTopicsWithPartitions]
+
+	tm, err := pxy.GetTopicMetadata(topic, withPartitions, withConfig)
+	if err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tm_view := newTopicMetadataView(withPartitions, withConfig, tm)
+	s.respondWithJSON(w, http.StatusOK, tm_view)
+}
+
+// handleGetTopicConfig is an HTTP request handler for
+// `GET /topics/{topic}/config`
+func (s *T) handleGetTopicConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+
+	entries, err := pxy.DescribeTopicConfig(topic)
+	if err != nil {
+		var status int
+		switch errors.Cause(err) {
+		case sarama.ErrUnknownTopicOrPartition:
+			status = http.StatusNotFound
+		case proxy.ErrConfigUnsupported:
+			status = http.StatusBadRequest
+		case proxy.ErrUnavailable:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	rs := topicConfigRs{Config: make([]configEntryRs, len(entries))}
+	for i, entry := range entries {
+		rs.Config[i] = configEntryRs{
+			Name:      entry.Name,
+			Value:     entry.Value,
+			Default:   entry.Default,
+			ReadOnly:  entry.ReadOnly,
+			Sensitive: entry.Sensitive,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetTopicSize is an HTTP request handler for `GET /topics/{topic}/size`
+func (s *T) handleGetTopicSize(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+
+	partitionOffsets, err := pxy.GetTopicOffsets(topic)
+	if err != nil {
+		if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+			s.respondWithError(w, http.StatusNotFound, errors.New("Unknown topic"))
+			return
+		}
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rs := topicSizeRs{Partitions: make([]partitionSizeRs, len(partitionOffsets))}
+	for i, po := range partitionOffsets {
+		rs.Partitions[i] = partitionSizeRs{
+			Partition: po.Partition,
+			Begin:     po.Begin,
+			End:       po.End,
+			Count:     po.End - po.Begin,
+		}
+		rs.Count += po.End - po.Begin
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetTopicStats is an HTTP request handler for
+// `GET /topics/{topic}/stats`. It returns consume statistics of partitions
+// of the topic that were consumed via this instance since start.
+func (s *T) handleGetTopicStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant := tenancy.FromContext(r.Context())
+	stats := pxy.PartitionStats(getTopicParam(r))
+
+	rs := topicStatsRs{Partitions: make([]partitionStatsRs, len(stats))}
+	for i, ps := range stats {
+		partitionRs := partitionStatsRs{
+			Partition:       ps.Partition,
+			FetchedMessages: ps.FetchedMessages,
+			FetchedBytes:    ps.FetchedBytes,
+			FetchRate:       ps.FetchRate,
+			FetchErrors:     ps.FetchErrors,
+			Groups:          make([]partitionGroupStatsRs, 0, len(ps.Groups)),
+		}
+		for _, gs := range ps.Groups {
+			group, ok := tenant.Logical(gs.Group)
+			if !ok {
+				continue
+			}
+			partitionRs.Groups = append(partitionRs.Groups, partitionGroupStatsRs{
+				Group:               group,
+				FetchedMessages:     gs.FetchedMessages,
+				FetchedBytes:        gs.FetchedBytes,
+				FetchRate:           gs.FetchRate,
+				LastFetchedOffset:   gs.LastFetchedOffset,
+				LastCommittedOffset: gs.LastCommittedOffset,
+			})
+		}
+		rs.Partitions[i] = partitionRs
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetTableEntry is an HTTP request handler for
+// `GET /topics/{topic}/table/{key}`
+func (s *T) handleGetTableEntry(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	topic := getTopicParam(r)
+	key := mux.Vars(r)[prmTableKey]
+
+	entry, err := pxy.GetTableEntry(topic, key)
+	if err != nil {
+		var status int
+		switch err {
+		case proxy.ErrTableNotConfigured:
+			fallthrough
+		case table.ErrKeyNotFound:
+			status = http.StatusNotFound
+		case table.ErrNotReady:
+			fallthrough
+		case table.ErrIncomplete:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, tableEntryRs{
+		Key:       []byte(key),
+		Value:     entry.Value,
+		Partition: entry.Partition,
+		Offset:    entry.Offset,
+	})
+}
+
+func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	// Clusters that throttle fetches of Kafka-Pixy due to Kafka quotas are
+	// reported in a header, for a throttled instance is still healthy.
+	var throttled []string
+	for _, cluster := range s.proxySet.Clusters() {
+		if throttle.IsThrottled(cluster) {
+			throttled = append(throttled, cluster)
+		}
+	}
+	if len(throttled) > 0 {
+		w.Header().Set(hdrThrottled, strings.Join(throttled, ","))
+	}
+	// Kafka versions in use are reported, for they can be negotiated with
+	// the brokers rather than configured.
+	kafkaVersions := make([]string, 0, len(s.proxySet.Clusters()))
+	for _, cluster := range s.proxySet.Clusters() {
+		if pxy, err := s.proxySet.Get(cluster); err == nil {
+			kafkaVersions = append(kafkaVersions, cluster+"="+pxy.KafkaVersion())
+		}
+	}
+	w.Header().Set(hdrKafkaVersions, strings.Join(kafkaVersions, ","))
+	if atomic.LoadInt32(&s.draining) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("pong"))
+}
+
+// handleGetIdleGroups is an HTTP request handler for `GET /idle_groups`. It
+// returns consumer groups that the group janitor is going to purge.
+func (s *T) handleGetIdleGroups(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	lst, err := parseListing(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	idleGroups, err := pxy.GetIdleGroups()
+	if err != nil {
+		var status int
+		switch err {
+		case proxy.ErrDisabled:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	byGroup := make(map[string]idleGroup, len(idleGroups))
+	groups := make([]string, 0, len(idleGroups))
+	for _, ig := range idleGroups {
+		byGroup[ig.Group] = idleGroup{Group: ig.Group, IdleSince: ig.IdleSince, PurgeAt: ig.PurgeAt}
+		groups = append(groups, ig.Group)
+	}
+	groups, next := lst.page(groups)
+	setNextPage(w, next)
+	idleGroupViews := make([]interface{}, len(groups))
+	for i, group := range groups {
+		if idleGroupViews[i], err = lst.selectFields(byGroup[group]); err != nil {
+			s.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, idleGroupViews)
+}
+
+// handleGetLagSLOs is an HTTP request handler for `GET /lag_slos`. It returns
+// statuses of all consumer lag objectives.
+func (s *T) handleGetLagSLOs(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	statuses, err := pxy.GetLagSLOStatuses()
+	if err != nil {
+		s.respondWithError(w, lagSLOErrorStatus(err), err)
+		return
+	}
+	statusViews := make([]lagSLORs, len(statuses))
+	for i, status := range statuses {
+		statusViews[i] = newLagSLORs(status)
+	}
+	s.respondWithJSON(w, http.StatusOK, statusViews)
+}
+
+// handleGetGroupLagSLO is an HTTP request handler for
+// `GET /groups/<group>/lag_slo`. It returns the status of the consumer lag
+// objective of a group.
+func (s *T) handleGetGroupLagSLO(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	group := tenancy.FromContext(r.Context()).Group(mux.Vars(r)[prmGroup])
+
+	status, err := pxy.GetGroupLagSLO(group)
+	if err != nil {
+		s.respondWithError(w, lagSLOErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newLagSLORs(status))
+}
+
+// lagSLOErrorStatus returns the HTTP status to respond with when a lag
+// objective call fails with the given error.
+func lagSLOErrorStatus(err error) int {
+	switch err {
+	case lagslo.ErrNotFound:
+		return http.StatusNotFound
+	case proxy.ErrDisabled:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleGetProduceSLOs is an HTTP request handler for `GET /produce_slos`.
+// It returns statuses of all produce latency objectives.
+func (s *T) handleGetProduceSLOs(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	statuses, err := pxy.GetProduceSLOStatuses()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == proxy.ErrDisabled {
+			status = http.StatusServiceUnavailable
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	statusViews := make([]produceSLORs, len(statuses))
+	for i, status := range statuses {
+		statusViews[i] = produceSLORs{
+			Topic:           status.Topic,
+			Status:          status.Status,
+			MaxLatencyMs:    int64(status.MaxLatency / time.Millisecond),
+			Percentile:      status.Percentile,
+			Requests:        status.Requests,
+			Violations:      status.Violations,
+			KafkaViolations: status.KafkaViolations,
+			ProxyViolations: status.ProxyViolations,
+			LatencyMs:       float64(status.Latency) / float64(time.Millisecond),
+			KafkaLatencyMs:  float64(status.KafkaLatency) / float64(time.Millisecond),
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, statusViews)
+}
+
+// handleGetHealthProbes is an HTTP request handler for
+// `GET /health_probes`. It returns statuses of downstream health probes of
+// all consumer groups that have them.
+func (s *T) handleGetHealthProbes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	statuses, err := pxy.GetHealthProbeStatuses()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == proxy.ErrDisabled {
+			status = http.StatusServiceUnavailable
+		}
+		s.respondWithError(w, status, err)
+		return
+	}
+	statusViews := make([]healthProbeRs, len(statuses))
+	for i, status := range statuses {
+		statusViews[i] = healthProbeRs{
+			Group:     status.Group,
+			Paused:    status.Paused,
+			Failures:  status.Failures,
+			Successes: status.Successes,
+			ProbedAt:  status.ProbedAt,
+		}
+		if status.Err != nil {
+			statusViews[i].Error = status.Err.Error()
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, statusViews)
+}
+
+// handleGetArchives is an HTTP request handler for `GET /archives`. It
+// returns statuses of all topic archives.
+func (s *T) handleGetArchives(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	statuses, err := pxy.GetArchiveStatuses()
+	if err != nil {
+		s.respondWithError(w, archiveErrorStatus(err), err)
+		return
+	}
+	statusViews := make([]archiveRs, len(statuses))
+	for i, status := range statuses {
+		statusViews[i] = archiveRs{
+			Name:             status.Name,
+			Topic:            status.Topic,
+			Lag:              status.Lag,
+			UploadedFiles:    status.UploadedFiles,
+			UploadedMessages: status.UploadedMessages,
+			UploadedAt:       status.UploadedAt,
+		}
+		if status.Err != nil {
+			statusViews[i].Error = status.Err.Error()
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, statusViews)
+}
+
+// handleStartReplay is an HTTP request handler for
+// `POST /archives/<archive>/replays`. It starts replaying messages of an
+// archive to a topic, possibly of another cluster.
+func (s *T) handleStartReplay(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	targetPxy := pxy
+	if targetCluster := r.FormValue(prmTargetCluster); targetCluster != "" {
+		if targetPxy, err = s.proxySet.Get(targetCluster); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if s.isReadOnly(targetPxy) {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	var fromOffset, toOffset int64
+	for prm, offset := range map[string]*int64{prmReplayFromOffset: &fromOffset, prmReplayToOffset: &toOffset} {
+		if offsetStr := r.FormValue(prm); offsetStr != "" {
+			if *offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil {
+				s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prm, offsetStr))
+				return
+			}
+		}
+	}
+	var rate int
+	if rateStr := r.FormValue(prmReplayRate); rateStr != "" {
+		if rate, err = strconv.Atoi(rateStr); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmReplayRate, rateStr))
+			return
+		}
+	}
+
+	replay, err := pxy.StartArchiveReplay(mux.Vars(r)[prmArchive], targetPxy, r.FormValue(prmTopic), fromOffset, toOffset, rate)
+	if err != nil {
+		s.respondWithError(w, archiveErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newReplayRs(replay))
+}
+
+// handleGetReplays is an HTTP request handler for
+// `GET /archives/<archive>/replays`. It returns progress of all replays of an
+// archive.
+func (s *T) handleGetReplays(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	replays, err := pxy.GetArchiveReplays(mux.Vars(r)[prmArchive])
+	if err != nil {
+		s.respondWithError(w, archiveErrorStatus(err), err)
+		return
+	}
+	replayViews := make([]replayRs, len(replays))
+	for i, replay := range replays {
+		replayViews[i] = newReplayRs(replay)
+	}
+	s.respondWithJSON(w, http.StatusOK, replayViews)
+}
+
+// handleGetReplay is an HTTP request handler for
+// `GET /archives/<archive>/replays/<replay>`.
+func (s *T) handleGetReplay(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	replay, err := pxy.GetArchiveReplay(mux.Vars(r)[prmArchive], mux.Vars(r)[prmReplay])
+	if err != nil {
+		s.respondWithError(w, archiveErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newReplayRs(replay))
+}
+
+// handleCancelReplay is an HTTP request handler for
+// `DELETE /archives/<archive>/replays/<replay>`.
+func (s *T) handleCancelReplay(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := pxy.CancelArchiveReplay(mux.Vars(r)[prmArchive], mux.Vars(r)[prmReplay]); err != nil {
+		s.respondWithError(w, archiveErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// archiveErrorStatus returns the HTTP status to respond with when an
+// archive call fails with the given error.
+func archiveErrorStatus(err error) int {
+	switch err {
+	case archiver.ErrNotFound, archiver.ErrReplayNotFound:
+		return http.StatusNotFound
+	case proxy.ErrBadReplay:
+		return http.StatusBadRequest
+	case proxy.ErrReadOnly, proxy.ErrTopicNotAllowed:
+		return http.StatusForbidden
+	case proxy.ErrDisabled:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleGetCredentials is an HTTP request handler for `GET /_credentials`.
+// It returns the status of credentials that connections to brokers are
+// secured with.
+func (s *T) handleGetCredentials(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	status, err := pxy.GetKafkaCredentials()
+	if err != nil {
+		s.respondWithError(w, credentialsErrorStatus(err), err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, newCredentialsRs(status))
+}
+
+// handleUpdateCredentials is an HTTP request handler for
+// `PUT /_credentials`. It makes connections to brokers established from now
+// on use credentials given in the request body.
+func (s *T) handleUpdateCredentials(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to read the request: err=(%s)", err))
+		return
+	}
+	var credsRq credentialsRq
+	if err := json.Unmarshal(body, &credsRq); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("Failed to parse the request: err=(%s)", err))
+		return
+	}
+	creds := secureconn.Credentials{Password: credsRq.Password}
+	if credsRq.CA != "" {
+		creds.CA = []byte(credsRq.CA)
+	}
+	if credsRq.Certificate != "" || credsRq.Key != "" {
+		creds.Certificate, creds.Key = []byte(credsRq.Certificate), []byte(credsRq.Key)
+	}
+	if err := pxy.UpdateKafkaCredentials(creds); err != nil {
+		s.respondWithError(w, credentialsErrorStatus(err), err)
+		return
+	}
+	status, _ := pxy.GetKafkaCredentials()
+	s.respondWithJSON(w, http.StatusOK, newCredentialsRs(status))
+}
+
+// credentialsErrorStatus returns the HTTP status to respond with when a
+// credentials call fails with the given error.
+func credentialsErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case secureconn.ErrBadCredentials:
+		return http.StatusBadRequest
+	case proxy.ErrDisabled:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleRefreshMetadata is an HTTP request handler for
+// `POST /_refresh_metadata`
+func (s *T) handleRefreshMetadata(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := pxy.RefreshMetadata(); err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetProducerStats is an HTTP request handler for `GET /_producer`. It
+// returns occupancy of the producer buffers.
+func (s *T) handleGetProducerStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats, err := pxy.ProducerStats()
+	if err != nil {
+		s.respondWithError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	s.respondWithJSON(w, http.StatusOK, toProducerStatsRs(stats))
+}
+
+// handleGetConsumerStats is an HTTP request handler for `GET /_consumer`. It
+// returns what happened to consumed messages since start.
+func (s *T) handleGetConsumerStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats := pxy.ConsumerStats()
+	s.respondWithJSON(w, http.StatusOK, consumerStatsRs{
+		ProcessingTimeouts: stats.ProcessingTimeouts,
+		FencedAcks:         stats.FencedAcks,
+		DataLoss:           stats.DataLoss,
+	})
+}
+
+// handleGetThroughput is an HTTP request handler for `GET /_throughput`. It
+// returns the number of messages and bytes produced and consumed since start.
+func (s *T) handleGetThroughput(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats := pxy.ThroughputStats()
+	rs := throughputRs{
+		Produced:        toThroughputCountersRs(stats.Produced),
+		Consumed:        toThroughputCountersRs(stats.Consumed),
+		ConsumedByGroup: make(map[string]map[string]throughputCounterRs, len(stats.ConsumedByGroup)),
+	}
+	for group, byTopic := range stats.ConsumedByGroup {
+		rs.ConsumedByGroup[group] = toThroughputCountersRs(byTopic)
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetClients is an HTTP request handler for `GET /_clients`. It returns
+// numbers of requests made to all clusters by every client library version.
+func (s *T) handleGetClients(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	report := clientstats.Report()
+	rs := clientsRs{Clients: make([]clientRs, len(report))}
+	for i, client := range report {
+		rs.Clients[i] = clientRs{
+			Name:     client.Name,
+			Version:  client.Version,
+			Requests: client.Requests,
+			LastSeen: client.LastSeen,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetGC is an HTTP request handler for `GET /_gc`. It returns garbage
+// collection stats along with how much they paused recent consume requests.
+func (s *T) handleGetGC(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	stats := gctune.Report()
+	s.respondWithJSON(w, http.StatusOK, gcRs{
+		Percent:      stats.Percent,
+		BallastSize:  stats.BallastSize,
+		NumGC:        stats.NumGC,
+		PauseTotalUs: int64(stats.PauseTotal / time.Microsecond),
+		MaxPauseUs:   int64(stats.MaxPause / time.Microsecond),
+		Consume: gcConsumeRs{
+			Requests:     stats.Consume.Requests,
+			Paused:       stats.Consume.Paused,
+			PauseTotalUs: int64(stats.Consume.PauseTotal / time.Microsecond),
+			MaxPauseUs:   int64(stats.Consume.MaxPause / time.Microsecond),
+		},
+	})
+}
+
+// handleGetInfo is an HTTP request handler for `GET /_info`. It tells what
+// build of Kafka-Pixy the instance is running, what clusters it serves, and
+// what experimental features are enabled, for fleet inventory.
+func (s *T) handleGetInfo(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	rs := infoRs{
+		Version:        buildinfo.Version,
+		Commit:         buildinfo.Commit,
+		Clusters:       make([]infoClusterRs, 0, len(s.proxySet.Clusters())),
+		DefaultCluster: s.proxySet.Default(),
+		StartedAt:      buildinfo.StartedAt(),
+		UptimeSec:      int64(buildinfo.Uptime() / time.Second),
+		Features:       []string{},
+	}
+	for _, flag := range features.All() {
+		if flag.Enabled {
+			rs.Features = append(rs.Features, flag.Name)
+		}
+	}
+	for _, cluster := range s.proxySet.Clusters() {
+		pxy, err := s.proxySet.Get(cluster)
+		if err != nil {
+			s.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		rs.Clusters = append(rs.Clusters, infoClusterRs{
+			Name:     cluster,
+			ClientID: pxy.ClientID(),
+		})
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handleGetQuotas is an HTTP request handler for `GET /_quotas`. It returns
+// current usage of the request limits configured for the cluster.
+func (s *T) handleGetQuotas(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	quotas := pxy.Quotas()
+	s.respondWithJSON(w, http.StatusOK, quotasRs{
+		Consume: toLimitUsageRs(quotas.Consume),
+		Produce: toLimitUsageRs(quotas.Produce),
+		Groups: groupsUsageRs{
+			Active:    len(quotas.Groups),
+			MaxGroups: quotas.MaxGroups,
+			Names:     quotas.Groups,
+		},
+		BrokerConnections: brokerConnectionsRs{
+			Open:           quotas.BrokerConnections.Open,
+			Queued:         quotas.BrokerConnections.Queued,
+			MaxConnections: quotas.BrokerConnections.MaxConnections,
+			Rejected:       quotas.BrokerConnections.Rejected,
+		},
+		Throttle: throttleRs{
+			Throttled:        quotas.Throttled,
+			ThrottledFetches: quotas.Throttle.ThrottledFetches,
+			FetchThrottleMs:  int64(quotas.Throttle.FetchThrottleTime / time.Millisecond),
+		},
+	})
+}
+
+// handleGetPressure is an HTTP request handler for `GET /_pressure`. It
+// returns how saturated the proxy is along with the work queues that it is
+// calculated from.
+func (s *T) handleGetPressure(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	pressure := pxy.Pressure()
+	s.respondWithJSON(w, http.StatusOK, pressureRs{
+		Pressure:          pressure.Percent,
+		ProduceBuffer:     toPressureComponentRs(pressure.ProduceBuffer),
+		OfferQueue:        toPressureComponentRs(pressure.OfferQueue),
+		UnservedLongPolls: toPressureComponentRs(pressure.UnservedLongPolls),
+	})
+}
+
+func toPressureComponentRs(pc proxy.PressureComponent) pressureComponentRs {
+	return pressureComponentRs{Depth: pc.Depth, Capacity: pc.Capacity, Pressure: pc.Percent}
+}
+
+// handleGetDefaultCluster is an HTTP request handler for
+// `GET /_default_cluster`. It returns the current default cluster along with
+// cluster aliases.
+func (s *T) handleGetDefaultCluster(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	s.respondWithDefaultCluster(w)
+}
+
+// handleSetDefaultCluster is an HTTP request handler for
+// `PUT /_default_cluster`. It makes the cluster, or the cluster behind an
+// alias, specified in the `cluster` parameter the default one.
+func (s *T) handleSetDefaultCluster(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if s.readOnly {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	if err := s.proxySet.SetDefault(r.FormValue(prmCluster)); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.actDesc.Log().Infof("Default cluster changed: cluster=%s", s.proxySet.Default())
+	s.respondWithDefaultCluster(w)
+}
+
+// handleResetDefaultCluster is an HTTP request handler for
+// `DELETE /_default_cluster`. It restores the configured default cluster.
+func (s *T) handleResetDefaultCluster(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if s.readOnly {
+		s.respondWithError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	s.proxySet.ResetDefault()
+	s.actDesc.Log().Infof("Default cluster reset: cluster=%s", s.proxySet.Default())
+	s.respondWithDefaultCluster(w)
+}
+
+func (s *T) respondWithDefaultCluster(w http.ResponseWriter) {
+	s.respondWithJSON(w, http.StatusOK, defaultClusterRs{
+		Cluster: s.proxySet.Default(),
+		Aliases: s.proxySet.Aliases(),
+	})
+}
+
+// handleGetProduceRoutes is an HTTP request handler for
+// `GET /_produce_routes`. It returns produce routing rules along with the
+// number of messages routed by each of them.
+func (s *T) handleGetProduceRoutes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	routing := s.proxySet.ProduceRouting()
+	res := produceRoutesRs{
+		Header: routing.Header,
+		Routes: make([]produceRouteRs, len(routing.Routes)),
+		DefaultRoute: produceRouteRs{
+			Cluster: routing.DefaultRoute.Cluster,
+			Routed:  routing.DefaultRoute.Routed,
+		},
+	}
+	for i, route := range routing.Routes {
+		res.Routes[i] = produceRouteRs{
+			HeaderValue: route.HeaderValue,
+			Cluster:     route.Cluster,
+			Routed:      route.Routed,
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleFlush is an HTTP request handler for `POST /_flush`. It waits for the
+// producer buffers to get empty and reports the outcome. If the buffers have
+// not got empty before the timeout then 504 is returned.
+func (s *T) handleFlush(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy, err := s.getProxy(r)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	var timeout time.Duration
+	if timeoutStr := r.FormValue(prmFlushTimeout); timeoutStr != "" {
+		if timeout, err = time.ParseDuration(timeoutStr); err != nil || timeout <= 0 {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmFlushTimeout, timeoutStr))
+			return
+		}
+	}
+	result, err := pxy.FlushProducer(timeout)
+	if err != nil {
+		s.respondWithError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	status := http.StatusOK
+	if !result.Flushed {
+		status = http.StatusGatewayTimeout
+	}
+	s.respondWithJSON(w, status, flushRs{
+		Flushed:       result.Flushed,
+		SucceededMsgs: result.SucceededMsgs,
+		FailedMsgs:    result.FailedMsgs,
+		Stats:         toProducerStatsRs(result.Stats),
+	})
+}
+
+// handleGetState is an HTTP request handler for `GET /_state`
+func (s *T) handleGetState(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	s.respondWithJSON(w, http.StatusOK, stateRs{
+		Goroutines: runtime.NumGoroutine(),
+		Actors:     actor.Dump(),
+	})
+}
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+// handleGetEvents is an HTTP request handler for `GET /_events`. It streams
+// lifecycle events as server-sent events until the client disconnects.
+func (s *T) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondWithError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
 		return
 	}
-
-	var partitionOffsetViews []partitionInfo
-	if err := json.Unmarshal(body, &partitionOffsetViews); err != nil {
-		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{errorText})
+	if err := r.ParseForm(); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	partitionOffsets := make([]admin.PartitionOffset, len(partitionOffsetViews))
-	for i, pov := range partitionOffsetViews {
-		partitionOffsets[i].Partition = pov.Partition
-		partitionOffsets[i].Offset = pov.Offset
-		partitionOffsets[i].Metadata = pov.Metadata
+	cluster := r.Form.Get(prmCluster)
+	types := make(map[lifecycle.Type]bool, len(r.Form[prmEventType]))
+	for _, typ := range r.Form[prmEventType] {
+		types[lifecycle.Type(typ)] = true
 	}
 
-	err = pxy.SetGroupOffsets(group, topic, partitionOffsets)
-	if err != nil {
-		if err = errors.Cause(err); err == sarama.ErrUnknownTopicOrPartition {
-			s.respondWithJSON(w, http.StatusNotFound, errorRs{"Unknown topic"})
+	sub := lifecycle.Subscribe(eventsBufferSize)
+	defer sub.Close()
+	keepAliveTicker := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAliveTicker.Stop()
+
+	w.Header().Add(hdrContentType, "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case ev := <-sub.Events():
+			if (cluster != "" && ev.Cluster != cluster) || (len(types) > 0 && !types[ev.Type]) {
+				continue
+			}
+			encodedEv, err := json.Marshal(ev)
+			if err != nil {
+				s.actDesc.Log().WithError(err).Errorf("Failed to encode lifecycle event: %v", ev)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, encodedEv); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAliveTicker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.stopCh:
 			return
 		}
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
-		return
 	}
-
-	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
-// handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
-func (s *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
+// handleTap is an HTTP request handler for `GET /topics/{topic}/_tap`. It
+// streams copies of the next messages produced to, or consumed from, the
+// topic as JSON lines, until the requested number of messages is tapped, the
+// timeout expires, or the client disconnects.
+func (s *T) handleTap(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var err error
-
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondWithError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
 	pxy, err := s.getProxy(r)
 	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+		s.respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	topic := mux.Vars(r)[prmTopic]
-
-	group, err := getGroupParam(r, true)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+	count := tapDefaultCount
+	if countStr := r.FormValue(prmTapCount); countStr != "" {
+		if count, err = strconv.Atoi(countStr); err != nil || count < 1 || count > tapMaxCount {
+			s.respondWithError(w, http.StatusBadRequest,
+				errors.Errorf("bad %s, must be from 1 to %d: %s", prmTapCount, tapMaxCount, countStr))
+			return
+		}
+	}
+	direction := tap.Direction(r.FormValue(prmTapDirection))
+	switch direction {
+	case "", tap.Produce, tap.Consume:
+	default:
+		s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmTapDirection, direction))
 		return
 	}
-
-	var consumers map[string]map[string][]int32
-	if group == "" {
-		consumers, err = pxy.GetAllTopicConsumers(topic)
-		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+	timeout := tapDefaultTimeout
+	if timeoutStr := r.FormValue(prmTapTimeout); timeoutStr != "" {
+		if timeout, err = time.ParseDuration(timeoutStr); err != nil || timeout <= 0 {
+			s.respondWithError(w, http.StatusBadRequest, errors.Errorf("bad %s: %s", prmTapTimeout, timeoutStr))
 			return
 		}
-	} else {
-		groupConsumers, err := pxy.GetTopicConsumers(group, topic)
-		if err != nil {
-			if _, ok := err.(admin.ErrInvalidParam); ok {
-				s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
+	}
+
+	t := pxy.OpenTap(getTopicParam(r), direction, count)
+	defer t.Close()
+	timeoutTimer := time.NewTimer(timeout)
+	defer timeoutTimer.Stop()
+
+	w.Header().Add(hdrContentType, "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case msg, ok := <-t.Messages():
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(msg); err != nil {
 				return
 			}
-			s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
+			flusher.Flush()
+		case <-timeoutTimer.C:
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.stopCh:
 			return
-		}
-		consumers = make(map[string]map[string][]int32)
-		if len(groupConsumers) != 0 {
-			consumers[group] = groupConsumers
 		}
 	}
+}
 
-	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
-	if err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	encodedRes = prettyfmt.CollapseJSON(encodedRes)
+type stateRs struct {
+	Goroutines int          `json:"goroutines"`
+	Actors     *actor.State `json:"actors"`
+}
 
-	w.Header().Add(hdrContentType, "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(encodedRes); err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", http.StatusOK, encodedRes)
-	}
+type consumerStatsRs struct {
+	ProcessingTimeouts map[string]int64 `json:"processing_timeouts"`
+	FencedAcks         map[string]int64 `json:"fenced_acks"`
+	DataLoss           map[string]int64 `json:"data_loss"`
 }
 
-// handleListTopics is an HTTP request handler for `GET /topics`
-func (s *T) handleListTopics(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	var err error
+type progressRs struct {
+	Reached    bool                  `json:"reached"`
+	Lag        int64                 `json:"lag"`
+	Partitions []partitionProgressRs `json:"partitions"`
+}
 
-	pxy, err := s.getProxy(r)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
+type partitionProgressRs struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+	Target    int64 `json:"target"`
+	Lag       int64 `json:"lag"`
+}
+
+type throughputRs struct {
+	Produced        map[string]throughputCounterRs            `json:"produced"`
+	Consumed        map[string]throughputCounterRs            `json:"consumed"`
+	ConsumedByGroup map[string]map[string]throughputCounterRs `json:"consumed_by_group"`
+}
+
+type throughputCounterRs struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func toThroughputCountersRs(counters map[string]throughput.Counter) map[string]throughputCounterRs {
+	rs := make(map[string]throughputCounterRs, len(counters))
+	for name, counter := range counters {
+		rs[name] = throughputCounterRs{Messages: counter.Messages, Bytes: counter.Bytes}
 	}
+	return rs
+}
 
-	err = r.ParseForm()
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
+type clientsRs struct {
+	Clients []clientRs `json:"clients"`
+}
+
+type clientRs struct {
+	Name     string           `json:"name"`
+	Version  string           `json:"version"`
+	Requests map[string]int64 `json:"requests"`
+	LastSeen time.Time        `json:"last_seen"`
+}
+
+type gcRs struct {
+	Percent      int         `json:"percent"`
+	BallastSize  int64       `json:"ballast_size"`
+	NumGC        int64       `json:"num_gc"`
+	PauseTotalUs int64       `json:"pause_total_us"`
+	MaxPauseUs   int64       `json:"max_pause_us"`
+	Consume      gcConsumeRs `json:"consume"`
+}
+
+type gcConsumeRs struct {
+	Requests     int   `json:"requests"`
+	Paused       int   `json:"paused"`
+	PauseTotalUs int64 `json:"pause_total_us"`
+	MaxPauseUs   int64 `json:"max_pause_us"`
+}
+
+type producerStatsRs struct {
+	QueuedMsgs    int   `json:"queued_msgs"`
+	PendingMsgs   int64 `json:"pending_msgs"`
+	PendingBytes  int64 `json:"pending_bytes"`
+	SucceededMsgs int64 `json:"succeeded_msgs"`
+	FailedMsgs    int64 `json:"failed_msgs"`
+
+	DeadLetteredMsgs     int64 `json:"dead_lettered_msgs"`
+	DeadLetterFailedMsgs int64 `json:"dead_letter_failed_msgs"`
+}
+
+func toProducerStatsRs(stats producer.Stats) producerStatsRs {
+	return producerStatsRs{
+		QueuedMsgs:           stats.QueuedMsgs,
+		PendingMsgs:          stats.PendingMsgs,
+		PendingBytes:         stats.PendingBytes,
+		SucceededMsgs:        stats.SucceededMsgs,
+		FailedMsgs:           stats.FailedMsgs,
+		DeadLetteredMsgs:     stats.DeadLetteredMsgs,
+		DeadLetterFailedMsgs: stats.DeadLetterFailedMsgs,
 	}
+}
 
-	_, withConfig := r.Form[prmTopicsWithConfig]
-	_, withPartitions := r.Form[prmTopicsWithPartitions]
+type quotasRs struct {
+	Consume           limitUsageRs        `json:"consume"`
+	Produce           limitUsageRs        `json:"produce"`
+	Groups            groupsUsageRs       `json:"groups"`
+	BrokerConnections brokerConnectionsRs `json:"broker_connections"`
+	Throttle          throttleRs          `json:"throttle"`
+}
 
-	topicsMetadata, err := pxy.ListTopics(withPartitions, withConfig)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
-		return
+type pressureRs struct {
+	Pressure          int                 `json:"pressure"`
+	ProduceBuffer     pressureComponentRs `json:"produce_buffer"`
+	OfferQueue        pressureComponentRs `json:"offer_queue"`
+	UnservedLongPolls pressureComponentRs `json:"unserved_long_polls"`
+}
+
+type pressureComponentRs struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+	Pressure int `json:"pressure"`
+}
+
+type limitUsageRs struct {
+	InFlight    int `json:"in_flight"`
+	Queued      int `json:"queued"`
+	Concurrency int `json:"concurrency"`
+	QueueSize   int `json:"queue_size"`
+}
+
+func toLimitUsageRs(usage proxy.LimitUsage) limitUsageRs {
+	return limitUsageRs{
+		InFlight:    usage.InFlight,
+		Queued:      usage.Queued,
+		Concurrency: usage.Concurrency,
+		QueueSize:   usage.QueueSize,
 	}
+}
 
-	if withPartitions || withConfig {
-		topicMetadataViews := make(map[string]*topicMetadata)
-		for _, tm := range topicsMetadata {
-			topicMetadataView := newTopicMetadataView(withPartitions, withConfig, tm)
-			topicMetadataViews[tm.Topic] = &topicMetadataView
+type groupsUsageRs struct {
+	Active    int      `json:"active"`
+	MaxGroups int      `json:"max_groups"`
+	Names     []string `json:"names"`
+}
+
+type defaultClusterRs struct {
+	Cluster string            `json:"cluster"`
+	Aliases map[string]string `json:"aliases"`
+}
+
+type produceRoutesRs struct {
+	Header       string           `json:"header"`
+	Routes       []produceRouteRs `json:"routes"`
+	DefaultRoute produceRouteRs   `json:"default_route"`
+}
+
+type produceRouteRs struct {
+	HeaderValue string `json:"header_value,omitempty"`
+	Cluster     string `json:"cluster"`
+	Routed      int64  `json:"routed"`
+}
+
+type brokerConnectionsRs struct {
+	Open           int   `json:"open"`
+	Queued         int   `json:"queued"`
+	MaxConnections int   `json:"max_connections"`
+	Rejected       int64 `json:"rejected"`
+}
+
+type throttleRs struct {
+	Throttled        bool  `json:"throttled"`
+	ThrottledFetches int64 `json:"throttled_fetches"`
+	FetchThrottleMs  int64 `json:"fetch_throttle_ms"`
+}
+
+type flushRs struct {
+	Flushed       bool            `json:"flushed"`
+	SucceededMsgs int64           `json:"succeeded_msgs"`
+	FailedMsgs    int64           `json:"failed_msgs"`
+	Stats         producerStatsRs `json:"stats"`
+}
+
+type offsetTranslation struct {
+	Partition    int32      `json:"partition"`
+	SourceOffset int64      `json:"source_offset"`
+	Timestamp    *time.Time `json:"timestamp,omitempty"`
+	TargetOffset int64      `json:"target_offset"`
+}
+
+type clonedOffset struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata,omitempty"`
+}
+
+type infoClusterRs struct {
+	Name     string `json:"name"`
+	ClientID string `json:"client_id"`
+}
+
+type infoRs struct {
+	Version        string          `json:"version"`
+	Commit         string          `json:"commit"`
+	Clusters       []infoClusterRs `json:"clusters"`
+	DefaultCluster string          `json:"default_cluster"`
+	StartedAt      time.Time       `json:"started_at"`
+	UptimeSec      int64           `json:"uptime_sec"`
+	Features       []string        `json:"features"`
+}
+
+type resetPartition struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	End       int64  `json:"end"`
+	Skipped   int64  `json:"skipped"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+type resetToEndRs struct {
+	Partitions []resetPartition `json:"partitions"`
+}
+
+type migrationRs struct {
+	ToBackend   string                    `json:"to_backend,omitempty"`
+	ToGroup     string                    `json:"to_group,omitempty"`
+	Topics      []string                  `json:"topics"`
+	Phase       string                    `json:"phase"`
+	StartedAt   time.Time                 `json:"started_at"`
+	CompletedAt *time.Time                `json:"completed_at,omitempty"`
+	Copied      map[string][]clonedOffset `json:"copied,omitempty"`
+}
+
+func newMigrationRs(m migration.Migration, copied map[string][]admin.PartitionOffset) migrationRs {
+	rs := migrationRs{
+		ToBackend:   m.ToBackend,
+		ToGroup:     m.ToGroup,
+		Topics:      m.Topics,
+		Phase:       m.Phase,
+		StartedAt:   m.StartedAt,
+		CompletedAt: m.CompletedAt,
+	}
+	if copied != nil {
+		rs.Copied = make(map[string][]clonedOffset, len(copied))
+		for topic, offsets := range copied {
+			offsetViews := make([]clonedOffset, len(offsets))
+			for i, po := range offsets {
+				offsetViews[i].Partition = po.Partition
+				offsetViews[i].Offset = po.Offset
+				offsetViews[i].Metadata = po.Metadata
+			}
+			rs.Copied[topic] = offsetViews
 		}
-		s.respondWithJSON(w, http.StatusOK, topicMetadataViews)
-		return
 	}
+	return rs
+}
 
-	topics := make([]string, 0, len(topicsMetadata))
-	for _, tm := range topicsMetadata {
-		topics = append(topics, tm.Topic)
-	}
-	s.respondWithJSON(w, http.StatusOK, topics)
+type freezeRs struct {
+	Frozen bool       `json:"frozen"`
+	Until  *time.Time `json:"until,omitempty"`
 }
 
-// handleGetTopicMetadata is an HTTP request handler for `GET /topics/{topic}`
-func (s *T) handleGetTopicMetadata(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	var err error
+type idleGroup struct {
+	Group     string    `json:"group"`
+	IdleSince time.Time `json:"idle_since"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
 
-	pxy, err := s.getProxy(r)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
+type lagSLORs struct {
+	Group         string    `json:"group"`
+	Status        string    `json:"status"`
+	LagMessages   int64     `json:"lag_messages"`
+	LagTimeMs     int64     `json:"lag_time_ms"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	CheckedAt     time.Time `json:"checked_at"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func newLagSLORs(status lagslo.Status) lagSLORs {
+	rs := lagSLORs{
+		Group:         status.Group,
+		Status:        status.Status,
+		LagMessages:   status.LagMessages,
+		LagTimeMs:     int64(status.LagTime / time.Millisecond),
+		ShortBurnRate: status.ShortBurnRate,
+		LongBurnRate:  status.LongBurnRate,
+		CheckedAt:     status.CheckedAt,
 	}
-	topic := mux.Vars(r)[prmTopic]
+	if status.Err != nil {
+		rs.Error = status.Err.Error()
+	}
+	return rs
+}
 
-	err = r.ParseForm()
-	if err != nil {
-		s.respondWithJSON(w, http.StatusBadRequest, errorRs{err.Error()})
-		return
+type produceSLORs struct {
+	Topic           string  `json:"topic"`
+	Status          string  `json:"status"`
+	MaxLatencyMs    int64   `json:"max_latency_ms"`
+	Percentile      float64 `json:"percentile"`
+	Requests        int64   `json:"requests"`
+	Violations      int64   `json:"violations"`
+	KafkaViolations int64   `json:"kafka_violations"`
+	ProxyViolations int64   `json:"proxy_violations"`
+	LatencyMs       float64 `json:"latency_ms"`
+	KafkaLatencyMs  float64 `json:"kafka_latency_ms"`
+}
+
+type healthProbeRs struct {
+	Group     string    `json:"group"`
+	Paused    bool      `json:"paused"`
+	Failures  int       `json:"failures"`
+	Successes int       `json:"successes"`
+	ProbedAt  time.Time `json:"probed_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type archiveRs struct {
+	Name             string    `json:"name"`
+	Topic            string    `json:"topic"`
+	Lag              int64     `json:"lag"`
+	UploadedFiles    int64     `json:"uploaded_files"`
+	UploadedMessages int64     `json:"uploaded_messages"`
+	UploadedAt       time.Time `json:"uploaded_at"`
+	Error            string    `json:"error,omitempty"`
+}
+
+type credentialsRq struct {
+	CA          string `json:"ca"`
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+	Password    string `json:"password"`
+}
+
+type credentialsRs struct {
+	Source               string     `json:"source"`
+	LoadedAt             time.Time  `json:"loaded_at"`
+	CertificateSubject   string     `json:"certificate_subject,omitempty"`
+	CertificateExpiresAt *time.Time `json:"certificate_expires_at,omitempty"`
+	Error                string     `json:"error,omitempty"`
+}
+
+func newCredentialsRs(status secureconn.Status) credentialsRs {
+	rs := credentialsRs{
+		Source:             status.Source,
+		LoadedAt:           status.LoadedAt,
+		CertificateSubject: status.CertificateSubject,
+	}
+	if !status.CertificateExpiresAt.IsZero() {
+		rs.CertificateExpiresAt = &status.CertificateExpiresAt
 	}
+	if status.Err != nil {
+		rs.Error = status.Err.Error()
+	}
+	return rs
+}
 
-	withConfig := true
-	_, withPartitions := r.Form[prmTopicsWithPartitions]
+type replayRs struct {
+	ID               string     `json:"id"`
+	Topic            string     `json:"topic"`
+	Cluster          string     `json:"cluster"`
+	FromOffset       int64      `json:"from_offset"`
+	ToOffset         int64      `json:"to_offset"`
+	Rate             int        `json:"rate"`
+	State            string     `json:"state"`
+	Files            int        `json:"files"`
+	FilesRead        int        `json:"files_read"`
+	ProducedMessages int64      `json:"produced_messages"`
+	StartedAt        time.Time  `json:"started_at"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+	Error            string     `json:"error,omitempty"`
+}
 
-	tm, err := pxy.GetTopicMetadata(topic, withPartitions, withConfig)
-	if err != nil {
-		s.respondWithJSON(w, http.StatusInternalServerError, errorRs{err.Error()})
-		return
+func newReplayRs(replay archiver.Replay) replayRs {
+	rs := replayRs{
+		ID:               replay.ID,
+		Topic:            replay.Topic,
+		Cluster:          replay.Cluster,
+		FromOffset:       replay.FromOffset,
+		ToOffset:         replay.ToOffset,
+		Rate:             replay.Rate,
+		State:            replay.State,
+		Files:            replay.Files,
+		FilesRead:        replay.FilesRead,
+		ProducedMessages: replay.ProducedMessages,
+		StartedAt:        replay.StartedAt,
+	}
+	if !replay.FinishedAt.IsZero() {
+		rs.FinishedAt = &replay.FinishedAt
+	}
+	if replay.Err != nil {
+		rs.Error = replay.Err.Error()
 	}
+	return rs
+}
 
-	tm_view := newTopicMetadataView(withPartitions, withConfig, tm)
-	s.respondWithJSON(w, http.StatusOK, tm_view)
+type groupRs struct {
+	Generation int32               `json:"generation"`
+	Members    map[string][]string `json:"members"`
 }
 
-func (s *T) handlePing(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("pong"))
+type rebalanceRs struct {
+	StartedAt     time.Time          `json:"started_at"`
+	DurationMs    int64              `json:"duration_ms"`
+	Trigger       string             `json:"trigger"`
+	Generation    int32              `json:"generation"`
+	MembersBefore []string           `json:"members_before"`
+	MembersAfter  []string           `json:"members_after"`
+	Assigned      map[string][]int32 `json:"assigned,omitempty"`
+	Error         string             `json:"error,omitempty"`
+}
+
+type groupConcurrencyRs struct {
+	ActiveRequests     int                      `json:"active_requests"`
+	UnackedMsgs        int                      `json:"unacked_msgs"`
+	OldestUnackedAgeMs int64                    `json:"oldest_unacked_age_ms"`
+	Partitions         []partitionConcurrencyRs `json:"partitions"`
+}
+
+type partitionConcurrencyRs struct {
+	Topic              string `json:"topic"`
+	Partition          int32  `json:"partition"`
+	UnackedMsgs        int    `json:"unacked_msgs"`
+	OldestUnackedAgeMs int64  `json:"oldest_unacked_age_ms"`
+	QueuedMsgs         int    `json:"queued_msgs"`
+}
+
+type groupOffersRs struct {
+	Partitions []partitionOffersRs `json:"partitions"`
+}
+
+type partitionOffersRs struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offers    []offerRs `json:"offers"`
+}
+
+type offerRs struct {
+	Offset     int64  `json:"offset"`
+	AgeMs      int64  `json:"age_ms"`
+	Deliveries int    `json:"deliveries"`
+	RequestID  string `json:"request_id"`
+}
+
+type partitionOwner struct {
+	Partition int32     `json:"partition"`
+	Owner     string    `json:"owner"`
+	ClaimedAt time.Time `json:"claimed_at"`
 }
 
 type produceRs struct {
@@ -616,26 +3909,89 @@ type consumeHeader struct {
 }
 
 type consumeRs struct {
+	Key        []byte          `json:"key"`
+	Value      []byte          `json:"value"`
+	ValueSize  int             `json:"value_size,omitempty"`
+	Partition  int32           `json:"partition"`
+	Offset     int64           `json:"offset"`
+	Headers    []consumeHeader `json:"headers"`
+	MemberID   string          `json:"member_id"`
+	Generation int32           `json:"generation"`
+	Affinity   string          `json:"affinity"`
+	DataLoss   int64           `json:"data_loss,omitempty"`
+}
+
+type fetchedMessage struct {
 	Key       []byte          `json:"key"`
 	Value     []byte          `json:"value"`
-	Partition int32           `json:"partition"`
+	ValueSize int             `json:"value_size,omitempty"`
 	Offset    int64           `json:"offset"`
+	Timestamp time.Time       `json:"timestamp"`
 	Headers   []consumeHeader `json:"headers"`
 }
 
+type fetchRs struct {
+	Messages   []fetchedMessage `json:"messages"`
+	NextOffset int64            `json:"next_offset"`
+}
+
+type tableEntryRs struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
 type partitionInfo struct {
-	Partition  int32  `json:"partition"`
-	Begin      int64  `json:"begin"`
-	End        int64  `json:"end"`
-	Count      int64  `json:"count"`
-	Offset     int64  `json:"offset"`
-	Lag        int64  `json:"lag"`
-	Metadata   string `json:"metadata,omitempty"`
-	SparseAcks string `json:"sparse_acks,omitempty"`
+	Partition   int32  `json:"partition"`
+	Begin       int64  `json:"begin"`
+	End         int64  `json:"end"`
+	Count       int64  `json:"count"`
+	Offset      int64  `json:"offset"`
+	Lag         int64  `json:"lag"`
+	Metadata    string `json:"metadata,omitempty"`
+	SparseAcks  string `json:"sparse_acks,omitempty"`
+	AckMetadata string `json:"ack_metadata,omitempty"`
+}
+
+type topicSizeRs struct {
+	Count      int64             `json:"count"`
+	Partitions []partitionSizeRs `json:"partitions"`
+}
+
+type partitionSizeRs struct {
+	Partition int32 `json:"partition"`
+	Begin     int64 `json:"begin"`
+	End       int64 `json:"end"`
+	Count     int64 `json:"count"`
+}
+
+type topicStatsRs struct {
+	Partitions []partitionStatsRs `json:"partitions"`
+}
+
+type partitionStatsRs struct {
+	Partition       int32                   `json:"partition"`
+	FetchedMessages int64                   `json:"fetched_messages"`
+	FetchedBytes    int64                   `json:"fetched_bytes"`
+	FetchRate       float64                 `json:"fetch_rate"`
+	FetchErrors     int64                   `json:"fetch_errors"`
+	Groups          []partitionGroupStatsRs `json:"groups"`
+}
+
+type partitionGroupStatsRs struct {
+	Group               string  `json:"group"`
+	FetchedMessages     int64   `json:"fetched_messages"`
+	FetchedBytes        int64   `json:"fetched_bytes"`
+	FetchRate           float64 `json:"fetch_rate"`
+	LastFetchedOffset   int64   `json:"last_fetched_offset"`
+	LastCommittedOffset int64   `json:"last_committed_offset"`
 }
 
 type errorRs struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable"`
 }
 
 type topicConfig struct {
@@ -643,6 +3999,18 @@ type topicConfig struct {
 	Config  map[string]string `json:"config"`
 }
 
+type topicConfigRs struct {
+	Config []configEntryRs `json:"config"`
+}
+
+type configEntryRs struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Default   bool   `json:"default"`
+	ReadOnly  bool   `json:"read_only"`
+	Sensitive bool   `json:"sensitive"`
+}
+
 type partitionMetadata struct {
 	ID       int32   `json:"partition"`
 	Leader   int32   `json:"leader"`
@@ -667,12 +4035,27 @@ func getParamBytes(r *http.Request, name string) []byte {
 	return []byte(values[0])
 }
 
+// respondWithError sends an error response with the specified `status` code.
+// Along with the error message the response body contains a stable error code
+// and a hint whether the request can be retried.
+func (s *T) respondWithError(w http.ResponseWriter, status int, err error) {
+	class, ok := errcode.Classify(err)
+	if !ok {
+		class = errcode.FromHTTPStatus(status)
+	}
+	s.respondWithJSON(w, status, errorRs{
+		Error:     err.Error(),
+		Code:      string(class.Code),
+		Retryable: class.Retryable,
+	})
+}
+
 // respondWithJSON marshals `body` to a JSON string and sends it s an HTTP
 // response body along with the specified `status` code.
 func (s *T) respondWithJSON(w http.ResponseWriter, status int, body interface{}) {
 	encodedRes, err := json.MarshalIndent(body, "", "  ")
 	if err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, body)
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, redact.Loggable(body))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -680,8 +4063,20 @@ func (s *T) respondWithJSON(w http.ResponseWriter, status int, body interface{})
 	w.Header().Add(hdrContentType, "application/json")
 	w.WriteHeader(status)
 	if _, err := w.Write(encodedRes); err != nil {
-		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, body)
+		s.actDesc.Log().WithError(err).Errorf("Failed to send HTTP response: status=%d, body=%v", status, redact.Loggable(body))
+	}
+}
+
+// partitionLag returns the number of messages that a consumer group has yet
+// to consume from a partition.
+func partitionLag(po admin.PartitionOffset) int64 {
+	switch po.Offset {
+	case sarama.OffsetNewest:
+		return 0
+	case sarama.OffsetOldest:
+		return po.End - po.Begin
 	}
+	return po.End - po.Offset
 }
 
 func getGroupParam(r *http.Request, opt bool) (string, error) {
@@ -693,7 +4088,40 @@ func getGroupParam(r *http.Request, opt bool) (string, error) {
 	if len(groups) == 0 {
 		return "", nil
 	}
-	return groups[0], nil
+	return tenancy.FromContext(r.Context()).Group(groups[0]), nil
+}
+
+// getPartitionParam returns the partition from the request path.
+func getPartitionParam(r *http.Request) (int32, error) {
+	partitionStr := mux.Vars(r)[prmPartition]
+	partition, err := strconv.ParseInt(partitionStr, 10, 32)
+	if err != nil || partition < 0 {
+		return 0, errors.Errorf("bad %s: %s", prmPartition, partitionStr)
+	}
+	return int32(partition), nil
+}
+
+// getClusterParam returns the cluster selected by a request, or an empty
+// string if the request does not select one explicitly.
+func getClusterParam(r *http.Request) string {
+	cluster := mux.Vars(r)[prmCluster]
+	// The `/clusters/<cluster>` prefix takes precedence over the header.
+	if cluster == "" {
+		cluster = r.Header.Get(hdrKafkaCluster)
+	}
+	return cluster
+}
+
+// getTopicParam returns the physical name of the topic from the request path.
+func getTopicParam(r *http.Request) string {
+	return tenancy.FromContext(r.Context()).Topic(mux.Vars(r)[prmTopic])
+}
+
+// getProduceKey returns the key of a message to produce. If a partition key
+// is given, then the message is partitioned by it rather than by the key.
+func getProduceKey(r *http.Request) sarama.Encoder {
+	key := toEncoderPreservingNil(getParamBytes(r, prmKey))
+	return producer.WithPartitionKey(key, toEncoderPreservingNil(getParamBytes(r, prmPartitionKey)))
 }
 
 // toEncoderPreservingNil converts a slice of bytes to `sarama.Encoder` but
@@ -706,13 +4134,17 @@ func toEncoderPreservingNil(b []byte) sarama.Encoder {
 }
 
 func parseAck(r *http.Request, isConsReq bool) (proxy.Ack, error) {
-	var partitionPrmName, offsetPrmName string
+	var partitionPrmName, offsetPrmName, generationPrmName, metadataPrmName string
 	if isConsReq {
 		partitionPrmName = prmAckPartition
 		offsetPrmName = prmAckOffset
+		generationPrmName = prmAckGeneration
+		metadataPrmName = prmAckMetadata
 	} else {
 		partitionPrmName = prmPartition
 		offsetPrmName = prmOffset
+		generationPrmName = prmGeneration
+		metadataPrmName = prmMetadata
 	}
 
 	r.ParseForm()
@@ -737,8 +4169,17 @@ func parseAck(r *http.Request, isConsReq bool) (proxy.Ack, error) {
 			return proxy.NoAck(), errors.Wrapf(err, "bad %s: %s", offsetPrmName, offsetStr)
 		}
 	}
+	generation, err := proxy.ParseGeneration(r.FormValue(generationPrmName))
+	if err != nil {
+		return proxy.NoAck(), err
+	}
+	metadata, err := proxy.ParseAckMetadata(r.FormValue(metadataPrmName))
+	if err != nil {
+		return proxy.NoAck(), err
+	}
 	if partitionOk && offsetOk {
-		return proxy.NewAck(int32(partition), offset)
+		ack, err := proxy.NewAck(int32(partition), offset)
+		return ack.WithGeneration(generation).WithMetadata(metadata), err
 	}
 	if !partitionOk && !offsetOk {
 		return proxy.AutoAck(), nil
@@ -0,0 +1,125 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listing defines which fields of listed items to respond with, and which
+// page of the items.
+type listing struct {
+	// Selected fields in the dotted notation, e.g. `partitions.leader`. If
+	// empty, then all fields are selected.
+	fields fieldTree
+
+	// The maximum number of items in a page. If zero, then all items
+	// following `after` are returned.
+	limit int
+
+	// The name of the last item of the previous page.
+	after string
+}
+
+// fieldTree maps selected fields to their selected subfields. A field with
+// no subfields selected is selected as a whole.
+type fieldTree map[string]fieldTree
+
+// parseListing returns field selection and pagination given by the `fields`,
+// `limit` and `after` request parameters.
+func parseListing(r *http.Request) (listing, error) {
+	var l listing
+	if fieldsStr := r.FormValue(prmFields); fieldsStr != "" {
+		l.fields = make(fieldTree)
+		for _, field := range strings.Split(fieldsStr, ",") {
+			tree := l.fields
+			for _, name := range strings.Split(strings.TrimSpace(field), ".") {
+				if name == "" {
+					return listing{}, errors.Errorf("bad %s: %s", prmFields, fieldsStr)
+				}
+				if tree[name] == nil {
+					tree[name] = make(fieldTree)
+				}
+				tree = tree[name]
+			}
+		}
+	}
+	if limitStr := r.FormValue(prmListLimit); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return listing{}, errors.Errorf("bad %s, must be > 0: %s", prmListLimit, limitStr)
+		}
+		l.limit = limit
+	}
+	l.after = r.FormValue(prmListAfter)
+	return l, nil
+}
+
+// page sorts item names and returns the ones of the requested page, along
+// with the name to pass in `after` to get the next page, that is empty if
+// the page is the last one.
+func (l listing) page(names []string) ([]string, string) {
+	sort.Strings(names)
+	names = names[sort.SearchStrings(names, l.after):]
+	if len(names) > 0 && names[0] == l.after {
+		names = names[1:]
+	}
+	if l.limit == 0 || len(names) <= l.limit {
+		return names, ""
+	}
+	return names[:l.limit], names[l.limit-1]
+}
+
+// selectFields returns a JSON encodable copy of an item with the selected
+// fields only. Selection applies to every element of arrays, and fields that
+// an item does not have are ignored.
+func (l listing) selectFields(item interface{}) (interface{}, error) {
+	if len(l.fields) == 0 {
+		return item, nil
+	}
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	return l.fields.apply(decoded), nil
+}
+
+func (t fieldTree) apply(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		selected := make(map[string]interface{}, len(t))
+		for name, subfields := range t {
+			fieldValue, ok := value[name]
+			if !ok {
+				continue
+			}
+			if len(subfields) > 0 {
+				fieldValue = subfields.apply(fieldValue)
+			}
+			selected[name] = fieldValue
+		}
+		return selected
+	case []interface{}:
+		selected := make([]interface{}, len(value))
+		for i, elem := range value {
+			selected[i] = t.apply(elem)
+		}
+		return selected
+	}
+	return value
+}
+
+// setNextPage tells the client where the next page starts, if there is one.
+func setNextPage(w http.ResponseWriter, next string) {
+	if next != "" {
+		w.Header().Set(hdrNextAfter, next)
+	}
+}
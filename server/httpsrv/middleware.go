@@ -0,0 +1,85 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/reqid"
+)
+
+// MiddlewareLogging is the name of the built-in middleware that logs every
+// served request along with its status code and duration.
+const MiddlewareLogging = "logging"
+
+var (
+	middlewareMu sync.Mutex
+	middleware   = make(map[string]mux.MiddlewareFunc)
+)
+
+func init() {
+	RegisterMiddleware(MiddlewareLogging, loggingMiddleware(actor.Root().NewChild("http_log")))
+}
+
+// RegisterMiddleware makes a middleware available by name to be listed in
+// `http_middleware`. It is supposed to be called from `init` functions of
+// packages that are compiled into a custom Kafka-Pixy build. It panics if a
+// middleware with the same name has already been registered.
+func RegisterMiddleware(name string, mw mux.MiddlewareFunc) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	if _, ok := middleware[name]; ok {
+		panic(fmt.Sprintf("HTTP middleware registered twice: %s", name))
+	}
+	middleware[name] = mw
+}
+
+// LookupMiddleware returns a middleware registered with the specified name.
+func LookupMiddleware(name string) (mux.MiddlewareFunc, bool) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	mw, ok := middleware[name]
+	return mw, ok
+}
+
+// WithMiddleware appends middleware to the chain that requests go through in
+// order, after authentication, before they are handled.
+func WithMiddleware(mws ...mux.MiddlewareFunc) Option {
+	return func(s *T) {
+		s.middleware = append(s.middleware, mws...)
+	}
+}
+
+func loggingMiddleware(actDesc *actor.Descriptor) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sr, r)
+			actDesc.Log().Infof("Served: requestID=%s, method=%s, url=%s, status=%d, took=%s",
+				reqid.FromContext(r.Context()), r.Method, r.URL, sr.status, time.Since(begin))
+		})
+	}
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// implements `http.ResponseWriter`.
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// implements `http.Flusher`, that is needed to stream events.
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
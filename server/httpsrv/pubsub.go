@@ -0,0 +1,335 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gorilla/mux"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/reqid"
+	"github.com/mailgun/kafka-pixy/tracectx"
+	"github.com/pkg/errors"
+)
+
+// Google Cloud Pub/Sub compatible API request parameters.
+const (
+	prmPubSubProject      = "project"
+	prmPubSubTopic        = "pubsubTopic"
+	prmPubSubSubscription = "subscription"
+)
+
+func (s *T) registerPubSubRoutes(router *mux.Router) {
+	if s.isEnabled(config.EndpointsProduce) {
+		router.HandleFunc(fmt.Sprintf("/v1/projects/{%s}/topics/{%s}:publish", prmPubSubProject, prmPubSubTopic), s.tenantless(s.handlePubSubPublish)).Methods("POST")
+		s.assignRoutes(router, config.EndpointsProduce)
+	}
+	if s.isEnabled(config.EndpointsConsume) {
+		router.HandleFunc(fmt.Sprintf("/v1/projects/{%s}/subscriptions/{%s}:pull", prmPubSubProject, prmPubSubSubscription), s.tenantless(s.handlePubSubPull)).Methods("POST")
+		router.HandleFunc(fmt.Sprintf("/v1/projects/{%s}/subscriptions/{%s}:acknowledge", prmPubSubProject, prmPubSubSubscription), s.tenantless(s.handlePubSubAcknowledge)).Methods("POST")
+		s.assignRoutes(router, config.EndpointsConsume)
+	}
+}
+
+// getPubSubProxy returns a proxy for a Pub/Sub project. A project named after
+// a configured cluster refers to that cluster, any other project refers to
+// the default cluster.
+func (s *T) getPubSubProxy(r *http.Request) *proxy.T {
+	pxy, err := s.proxySet.Get(mux.Vars(r)[prmPubSubProject])
+	if err != nil {
+		pxy, _ = s.proxySet.Get("")
+	}
+	return pxy
+}
+
+// handlePubSubPublish is an HTTP request handler for
+// `POST /v1/projects/{project}/topics/{topic}:publish`
+func (s *T) handlePubSubPublish(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy := s.getPubSubProxy(r)
+	if s.isReadOnly(pxy) {
+		s.respondWithPubSubError(w, http.StatusForbidden, proxy.ErrReadOnly)
+		return
+	}
+	topic := mux.Vars(r)[prmPubSubTopic]
+	var rq pubSubPublishRq
+	if err := json.NewDecoder(r.Body).Decode(&rq); err != nil {
+		s.respondWithPubSubError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse request"))
+		return
+	}
+	if len(rq.Messages) == 0 {
+		s.respondWithPubSubError(w, http.StatusBadRequest, errors.New("at least one message is required"))
+		return
+	}
+	rs := pubSubPublishRs{MessageIDs: make([]string, 0, len(rq.Messages))}
+	for _, msg := range rq.Messages {
+		var headers []sarama.RecordHeader
+		for k, v := range msg.Attributes {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+		}
+		headers = pxy.WithRequestID(headers, reqid.FromContext(r.Context()))
+		headers = pxy.WithTraceContext(headers, tracectx.FromContext(r.Context()))
+		headers = pxy.WithEnrichment(headers, topic, nil)
+		var key sarama.Encoder
+		if msg.OrderingKey != "" {
+			key = sarama.StringEncoder(msg.OrderingKey)
+		}
+		prodMsg, err := pxy.Produce(topic, key, sarama.ByteEncoder(msg.Data), headers)
+		if err != nil {
+			var status int
+			switch err {
+			case proxy.ErrUnknownTopic:
+				fallthrough
+			case sarama.ErrUnknownTopicOrPartition:
+				status = http.StatusNotFound
+			case proxy.ErrDisabled:
+				fallthrough
+			case proxy.ErrUnavailable:
+				status = http.StatusServiceUnavailable
+			case proxy.ErrHeadersUnsupported:
+				status = http.StatusBadRequest
+			case proxy.ErrMessageTooLarge:
+				status = http.StatusRequestEntityTooLarge
+			case proxy.ErrLimitExceeded:
+				status = http.StatusTooManyRequests
+			case proxy.ErrTopicNotAllowed:
+				status = http.StatusForbidden
+			default:
+				status = http.StatusInternalServerError
+			}
+			// Messages are published in order and publishing stops at the
+			// first failure, so IDs of the messages that were published
+			// are returned, and the client can retry only the rest.
+			errRs := newPubSubErrorRs(status, errors.Wrapf(err, "published %d of %d messages",
+				len(rs.MessageIDs), len(rq.Messages)))
+			errRs.MessageIDs = rs.MessageIDs
+			s.respondWithJSON(w, status, errRs)
+			return
+		}
+		rs.MessageIDs = append(rs.MessageIDs, encodePubSubID(prodMsg.Partition, prodMsg.Offset))
+	}
+	s.respondWithJSON(w, http.StatusOK, rs)
+}
+
+// handlePubSubPull is an HTTP request handler for
+// `POST /v1/projects/{project}/subscriptions/{subscription}:pull`
+//
+// Kafka-Pixy consume API returns one message at a time, therefore at most
+// one message is returned regardless of the requested `maxMessages`. That
+// is allowed by the Pub/Sub API contract.
+func (s *T) handlePubSubPull(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy := s.getPubSubProxy(r)
+	sub, err := s.getPubSubSubscription(r)
+	if err != nil {
+		s.respondWithPubSubError(w, http.StatusNotFound, err)
+		return
+	}
+	var rq pubSubPullRq
+	if err := json.NewDecoder(r.Body).Decode(&rq); err != nil && err != io.EOF {
+		s.respondWithPubSubError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse request"))
+		return
+	}
+
+	consMsg, err := pxy.Consume(sub.Group, sub.Topic, proxy.NoAck())
+	if err != nil {
+		var status int
+		switch err {
+		case consumer.ErrRequestTimeout:
+			// No messages is a valid pull response.
+			s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+			return
+		case consumer.ErrTooManyRequests:
+			fallthrough
+		case proxy.ErrLimitExceeded:
+			fallthrough
+		case proxy.ErrTooManyGroups:
+			status = http.StatusTooManyRequests
+		case proxy.ErrGroupMigrated:
+			status = http.StatusGone
+		case proxy.ErrGroupMigrating:
+			fallthrough
+		case consumer.ErrUnavailable:
+			fallthrough
+		case proxy.ErrDisabled:
+			fallthrough
+		case proxy.ErrUnavailable:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusInternalServerError
+		}
+		s.respondWithPubSubError(w, status, err)
+		return
+	}
+
+	msgID := encodePubSubID(consMsg.Partition, consMsg.Offset)
+	msg := pubSubMessage{
+		Data:      consMsg.Value,
+		MessageID: msgID,
+	}
+	if !consMsg.Timestamp.IsZero() {
+		msg.PublishTime = consMsg.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if len(consMsg.Key) > 0 {
+		msg.OrderingKey = string(consMsg.Key)
+	}
+	if len(consMsg.Headers) > 0 {
+		msg.Attributes = make(map[string]string, len(consMsg.Headers))
+		for _, h := range consMsg.Headers {
+			msg.Attributes[string(h.Key)] = string(h.Value)
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, pubSubPullRs{
+		ReceivedMessages: []pubSubReceivedMessage{{AckID: msgID, Message: msg}},
+	})
+}
+
+// handlePubSubAcknowledge is an HTTP request handler for
+// `POST /v1/projects/{project}/subscriptions/{subscription}:acknowledge`
+func (s *T) handlePubSubAcknowledge(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	pxy := s.getPubSubProxy(r)
+	sub, err := s.getPubSubSubscription(r)
+	if err != nil {
+		s.respondWithPubSubError(w, http.StatusNotFound, err)
+		return
+	}
+	var rq pubSubAcknowledgeRq
+	if err := json.NewDecoder(r.Body).Decode(&rq); err != nil {
+		s.respondWithPubSubError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse request"))
+		return
+	}
+	acks := make([]proxy.Ack, len(rq.AckIDs))
+	for i, ackID := range rq.AckIDs {
+		if acks[i], err = decodePubSubAckID(ackID); err != nil {
+			s.respondWithPubSubError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	for _, ack := range acks {
+		if err := pxy.Ack(sub.Group, sub.Topic, ack); err != nil {
+			s.respondWithPubSubError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	s.respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+func (s *T) getPubSubSubscription(r *http.Request) (config.PubSubSubscription, error) {
+	name := mux.Vars(r)[prmPubSubSubscription]
+	sub, ok := s.pubSubSubs[name]
+	if !ok {
+		return sub, errors.Errorf("unknown subscription: %s", name)
+	}
+	if sub.Group == "" {
+		sub.Group = name
+	}
+	return sub, nil
+}
+
+// respondWithPubSubError sends an error in the format used by Google APIs.
+func (s *T) respondWithPubSubError(w http.ResponseWriter, status int, err error) {
+	s.respondWithJSON(w, status, newPubSubErrorRs(status, err))
+}
+
+func newPubSubErrorRs(status int, err error) pubSubErrorRs {
+	rs := pubSubErrorRs{}
+	rs.Error.Code = status
+	rs.Error.Message = err.Error()
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		rs.Error.Status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		rs.Error.Status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		rs.Error.Status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		rs.Error.Status = "NOT_FOUND"
+	case http.StatusGone, http.StatusPreconditionFailed:
+		rs.Error.Status = "FAILED_PRECONDITION"
+	case http.StatusTooManyRequests:
+		rs.Error.Status = "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		rs.Error.Status = "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		rs.Error.Status = "DEADLINE_EXCEEDED"
+	default:
+		rs.Error.Status = "INTERNAL"
+	}
+	return rs
+}
+
+// encodePubSubID returns a message ID that is also used as an ack ID.
+func encodePubSubID(partition int32, offset int64) string {
+	return strconv.Itoa(int(partition)) + "-" + strconv.FormatInt(offset, 10)
+}
+
+func decodePubSubAckID(ackID string) (proxy.Ack, error) {
+	parts := strings.SplitN(ackID, "-", 2)
+	if len(parts) != 2 {
+		return proxy.Ack{}, errors.Errorf("bad ack id: %s", ackID)
+	}
+	partition, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return proxy.Ack{}, errors.Errorf("bad ack id: %s", ackID)
+	}
+	offset, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return proxy.Ack{}, errors.Errorf("bad ack id: %s", ackID)
+	}
+	return proxy.NewAck(int32(partition), offset)
+}
+
+type pubSubMessage struct {
+	Data        []byte            `json:"data,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId,omitempty"`
+	PublishTime string            `json:"publishTime,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+type pubSubPublishRq struct {
+	Messages []pubSubMessage `json:"messages"`
+}
+
+type pubSubPublishRs struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+type pubSubPullRq struct {
+	ReturnImmediately bool `json:"returnImmediately"`
+	MaxMessages       int  `json:"maxMessages"`
+}
+
+type pubSubReceivedMessage struct {
+	AckID   string        `json:"ackId"`
+	Message pubSubMessage `json:"message"`
+}
+
+type pubSubPullRs struct {
+	ReceivedMessages []pubSubReceivedMessage `json:"receivedMessages,omitempty"`
+}
+
+type pubSubAcknowledgeRq struct {
+	AckIDs []string `json:"ackIds"`
+}
+
+type pubSubErrorRs struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+	// IDs of messages that were published before a publish failed.
+	MessageIDs []string `json:"messageIds,omitempty"`
+}
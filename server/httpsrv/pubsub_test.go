@@ -0,0 +1,46 @@
+package httpsrv
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PubSubSuite struct{}
+
+var _ = Suite(&PubSubSuite{})
+
+// HTTP statuses are reported with the matching Pub/Sub error statuses, so
+// that Pub/Sub clients can tell which errors are worth retrying.
+func (s *PubSubSuite) TestErrorStatus(c *C) {
+	for i, tc := range []struct {
+		status int
+		want   string
+	}{
+		{status: http.StatusBadRequest, want: "INVALID_ARGUMENT"},
+		{status: http.StatusUnauthorized, want: "UNAUTHENTICATED"},
+		{status: http.StatusForbidden, want: "PERMISSION_DENIED"},
+		{status: http.StatusNotFound, want: "NOT_FOUND"},
+		{status: http.StatusGone, want: "FAILED_PRECONDITION"},
+		{status: http.StatusPreconditionFailed, want: "FAILED_PRECONDITION"},
+		{status: http.StatusRequestEntityTooLarge, want: "INVALID_ARGUMENT"},
+		{status: http.StatusTooManyRequests, want: "RESOURCE_EXHAUSTED"},
+		{status: http.StatusInternalServerError, want: "INTERNAL"},
+		{status: http.StatusServiceUnavailable, want: "UNAVAILABLE"},
+		{status: http.StatusGatewayTimeout, want: "DEADLINE_EXCEEDED"},
+	} {
+		// When
+		rs := newPubSubErrorRs(tc.status, errors.New("kaboom"))
+
+		// Then
+		c.Check(rs.Error.Code, Equals, tc.status, Commentf("case #%d", i))
+		c.Check(rs.Error.Status, Equals, tc.want, Commentf("case #%d", i))
+		c.Check(rs.Error.Message, Equals, "kaboom", Commentf("case #%d", i))
+	}
+}
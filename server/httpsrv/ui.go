@@ -0,0 +1,252 @@
+package httpsrv
+
+import (
+	"net/http"
+)
+
+// handleUIRedirect is an HTTP request handler for `GET /ui`
+func (s *T) handleUIRedirect(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+}
+
+// handleUI is an HTTP request handler for `GET /ui/`. It serves a single page
+// dashboard that is entirely driven by the GraphQL and produce API endpoints.
+func (s *T) handleUI(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set(hdrContentType, "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(uiPage))
+}
+
+// uiPage is a self-contained dashboard page. It must not depend on any
+// external resources, since Kafka-Pixy is often deployed in environments
+// without Internet access.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Kafka-Pixy</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 0; color: #222; }
+header { background: #2b3a4a; color: #fff; padding: 8px 16px; display: flex; align-items: center; }
+header h1 { font-size: 18px; margin: 0 24px 0 0; }
+main { display: grid; grid-template-columns: 1fr 1fr; gap: 16px; padding: 16px; }
+section { border: 1px solid #ccd; border-radius: 4px; padding: 8px 12px; overflow: auto; max-height: 480px; }
+section h2 { font-size: 15px; margin: 4px 0 8px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 2px 6px; border-bottom: 1px solid #eee; vertical-align: top; }
+td.num { text-align: right; font-family: monospace; }
+.ok { color: #1a7f37; } .err { color: #c62828; }
+input, select, button, textarea { font-size: 13px; margin: 2px 4px 2px 0; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+#errors { color: #c62828; padding: 0 16px; }
+</style>
+</head>
+<body>
+<header>
+  <h1>Kafka-Pixy</h1>
+  Cluster:&nbsp;<select id="cluster"></select>
+</header>
+<div id="errors"></div>
+<main>
+  <section>
+    <h2>Cluster health</h2>
+    <table id="health"></table>
+  </section>
+  <section>
+    <h2>Topics</h2>
+    <table id="topics"></table>
+  </section>
+  <section>
+    <h2>Consumer groups</h2>
+    <table id="groups"></table>
+  </section>
+  <section>
+    <h2>Lag</h2>
+    <div>
+      Group <input id="lagGroup" size="16"> Topic <input id="lagTopic" size="16">
+      <button id="lagWatch">Watch</button>
+    </div>
+    <canvas id="lagChart" width="560" height="180"></canvas>
+    <table id="lagTable"></table>
+  </section>
+  <section>
+    <h2>Peek messages</h2>
+    <div>
+      Topic <input id="peekTopic" size="16"> Partition <input id="peekPartition" size="3" value="0">
+      Last <input id="peekLast" size="3" value="10"> <button id="peek">Peek</button>
+    </div>
+    <table id="messages"></table>
+  </section>
+  <section>
+    <h2>Produce message</h2>
+    <div>Topic <input id="prodTopic" size="16"> Key <input id="prodKey" size="16"></div>
+    <textarea id="prodValue" rows="6" cols="60"></textarea><br>
+    <button id="produce">Produce</button> <span id="prodResult"></span>
+  </section>
+</main>
+<script>
+"use strict";
+var lagTimer = null, lagHistory = [];
+
+function $(id) { return document.getElementById(id); }
+
+function esc(s) {
+  if (s === null || s === undefined) { return "<i>null</i>"; }
+  return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+}
+
+function cluster() { return $("cluster").value; }
+
+function showError(err) { $("errors").textContent = err ? String(err) : ""; }
+
+function gql(query, variables) {
+  return fetch("/graphql", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({query: query, variables: variables || {}})
+  }).then(function (rs) { return rs.json(); }).then(function (rs) {
+    if (rs.errors && rs.errors.length) { throw new Error(rs.errors[0].message); }
+    return rs.data;
+  });
+}
+
+function row(cells, nums) {
+  return "<tr>" + cells.map(function (c, i) {
+    return (nums && nums[i] ? "<td class=\"num\">" : "<td>") + c + "</td>";
+  }).join("") + "</tr>";
+}
+
+function loadClusters() {
+  return gql("{ clusters }").then(function (data) {
+    $("cluster").innerHTML = data.clusters.map(function (c) {
+      return "<option>" + esc(c) + "</option>";
+    }).join("");
+    return data.clusters;
+  });
+}
+
+function loadHealth(clusters) {
+  $("health").innerHTML = row(["Cluster", "Status"]);
+  clusters.forEach(function (c) {
+    gql("query($c: String) { topics(cluster: $c) { name } }", {c: c}).then(function (data) {
+      $("health").innerHTML += row([esc(c), "<span class=\"ok\">OK</span> (" + data.topics.length + " topics)"]);
+    }, function (err) {
+      $("health").innerHTML += row([esc(c), "<span class=\"err\">" + esc(err.message) + "</span>"]);
+    });
+  });
+}
+
+function loadTopics() {
+  return gql("query($c: String) { topics(cluster: $c) { name partitions { id begin end } } }", {c: cluster()}).then(function (data) {
+    var html = row(["Topic", "Partitions", "Messages"]);
+    data.topics.forEach(function (t) {
+      var count = 0;
+      t.partitions.forEach(function (p) { count += p.end - p.begin; });
+      html += row([esc(t.name), t.partitions.length, count], [false, true, true]);
+    });
+    $("topics").innerHTML = html;
+  });
+}
+
+function loadGroups() {
+  return gql("query($c: String) { groups(cluster: $c) { name } }", {c: cluster()}).then(function (data) {
+    var html = row(["Group"]);
+    data.groups.forEach(function (g) {
+      html += row(["<a href=\"#\" data-group=\"" + esc(g.name) + "\">" + esc(g.name) + "</a>"]);
+    });
+    $("groups").innerHTML = html;
+  });
+}
+
+function watchLag() {
+  if (lagTimer) { clearInterval(lagTimer); }
+  lagHistory = [];
+  pollLag();
+  lagTimer = setInterval(pollLag, 5000);
+}
+
+function pollLag() {
+  var group = $("lagGroup").value, topic = $("lagTopic").value;
+  if (!group || !topic) { return; }
+  gql("query($c: String, $g: String!, $t: String!) { group(name: $g, cluster: $c) { offsets(topic: $t) { partition offset end lag } } }",
+    {c: cluster(), g: group, t: topic}).then(function (data) {
+    var offsets = data.group.offsets, total = 0, html = row(["Partition", "Offset", "End", "Lag"]);
+    offsets.forEach(function (o) {
+      total += o.lag;
+      html += row([o.partition, o.offset, o.end, o.lag], [true, true, true, true]);
+    });
+    $("lagTable").innerHTML = html + row(["<b>Total</b>", "", "", "<b>" + total + "</b>"], [false, false, false, true]);
+    lagHistory.push(total);
+    if (lagHistory.length > 120) { lagHistory.shift(); }
+    drawLag();
+    showError(null);
+  }, showError);
+}
+
+function drawLag() {
+  var canvas = $("lagChart"), ctx = canvas.getContext("2d");
+  var w = canvas.width, h = canvas.height, max = Math.max.apply(null, lagHistory.concat([1]));
+  ctx.clearRect(0, 0, w, h);
+  ctx.strokeStyle = "#ccd";
+  ctx.strokeRect(0, 0, w, h);
+  ctx.fillStyle = "#666";
+  ctx.fillText("max " + max, 4, 12);
+  ctx.strokeStyle = "#2b6cb0";
+  ctx.beginPath();
+  lagHistory.forEach(function (v, i) {
+    var x = i * w / 119, y = h - 4 - v * (h - 20) / max;
+    if (i === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+  });
+  ctx.stroke();
+}
+
+function peek() {
+  gql("query($c: String, $t: String!, $p: Int!, $n: Int) { topic(name: $t, cluster: $c) { messages(partition: $p, last: $n) { offset timestamp key value headers { key value } } } }",
+    {c: cluster(), t: $("peekTopic").value, p: parseInt($("peekPartition").value, 10), n: parseInt($("peekLast").value, 10)}).then(function (data) {
+    var html = row(["Offset", "Timestamp", "Key", "Value", "Headers"]);
+    data.topic.messages.forEach(function (m) {
+      var headers = m.headers.map(function (h) { return esc(h.key) + ": " + esc(h.value); }).join("<br>");
+      html += row([m.offset, esc(m.timestamp), esc(m.key), "<pre>" + esc(m.value) + "</pre>", headers], [true]);
+    });
+    $("messages").innerHTML = html;
+    showError(null);
+  }, showError);
+}
+
+function produce() {
+  var topic = $("prodTopic").value, key = $("prodKey").value;
+  var url = "/clusters/" + encodeURIComponent(cluster()) + "/topics/" + encodeURIComponent(topic) + "/messages?sync";
+  if (key) { url += "&key=" + encodeURIComponent(key); }
+  fetch(url, {method: "POST", headers: {"Content-Type": "text/plain"}, body: $("prodValue").value})
+    .then(function (rs) { return rs.json(); })
+    .then(function (rs) {
+      $("prodResult").textContent = rs.error ? "Error: " + rs.error : "Produced to partition " + rs.partition + " at offset " + rs.offset;
+    }, showError);
+}
+
+function refresh() {
+  Promise.all([loadTopics(), loadGroups()]).then(function () { showError(null); }, showError);
+}
+
+$("cluster").addEventListener("change", refresh);
+$("lagWatch").addEventListener("click", watchLag);
+$("peek").addEventListener("click", peek);
+$("produce").addEventListener("click", produce);
+$("groups").addEventListener("click", function (e) {
+  if (e.target.dataset.group) {
+    e.preventDefault();
+    $("lagGroup").value = e.target.dataset.group;
+  }
+});
+loadClusters().then(function (clusters) {
+  loadHealth(clusters);
+  refresh();
+}, showError);
+setInterval(refresh, 30000);
+</script>
+</body>
+</html>
+`
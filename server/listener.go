@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// ErrListenerClosed is returned by Accept of a listener created by Listen
+// after it has been closed.
+var ErrListenerClosed = errors.New("listener closed")
+
+// Listen creates a TCP listener that accepts connections at a comma
+// separated list of addresses, e.g. `[::]:19092,0.0.0.0:19092`. If a single
+// address is given, then it is listened on as is, so `[::]:19092` accepts
+// both IPv6 and IPv4 connections where the OS supports dual-stack sockets.
+// If several addresses are given, then every IP address is bound to its own
+// address family only, for otherwise a dual-stack IPv6 wildcard would clash
+// with the IPv4 wildcard on the same port.
+func Listen(addrs string) (net.Listener, error) {
+	addrList := strings.Split(addrs, ",")
+	if len(addrList) == 1 {
+		return net.Listen("tcp", addrs)
+	}
+	ml := &multiListener{
+		acceptCh: make(chan acceptResult),
+		closeCh:  make(chan none.T),
+	}
+	for _, addr := range addrList {
+		addr = strings.TrimSpace(addr)
+		listener, err := net.Listen(networkOf(addr), addr)
+		if err != nil {
+			for _, opened := range ml.listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		ml.listeners = append(ml.listeners, listener)
+	}
+	for _, listener := range ml.listeners {
+		go ml.accept(listener)
+	}
+	return ml, nil
+}
+
+// networkOf returns the network that an address should be bound to in order
+// to accept connections of its own address family only.
+func networkOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener merges connections accepted by several listeners.
+type multiListener struct {
+	listeners []net.Listener
+	acceptCh  chan acceptResult
+	closeOnce sync.Once
+	closeCh   chan none.T
+}
+
+func (ml *multiListener) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case ml.acceptCh <- acceptResult{conn, err}:
+		case <-ml.closeCh:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Temporary() {
+				return
+			}
+		}
+	}
+}
+
+// Accept implements net.Listener.
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case rs := <-ml.acceptCh:
+		return rs.conn, rs.err
+	case <-ml.closeCh:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close implements net.Listener.
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.closeCh)
+		for _, listener := range ml.listeners {
+			if closeErr := listener.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr implements net.Listener. It returns the address of the first listener.
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
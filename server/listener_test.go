@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ListenerSuite struct{}
+
+var _ = Suite(&ListenerSuite{})
+
+func (s *ListenerSuite) TestNetworkOf(c *C) {
+	for i, tc := range []struct {
+		addr    string
+		network string
+	}{
+		{addr: "0.0.0.0:19092", network: "tcp4"},
+		{addr: "127.0.0.1:19092", network: "tcp4"},
+		{addr: "[::]:19092", network: "tcp6"},
+		{addr: "[::1]:19092", network: "tcp6"},
+		{addr: "[::ffff:127.0.0.1]:19092", network: "tcp4"},
+		{addr: "localhost:19092", network: "tcp"},
+		{addr: ":19092", network: "tcp"},
+	} {
+		c.Check(networkOf(tc.addr), Equals, tc.network, Commentf("case #%d", i))
+	}
+}
+
+// IPv6 and IPv4 wildcards can be listened on the same port simultaneously.
+func (s *ListenerSuite) TestListenDualStack(c *C) {
+	probe, err := net.Listen("tcp4", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	// When
+	listener, err := Listen(fmt.Sprintf("[::]:%d, 0.0.0.0:%d", port, port))
+
+	// Then
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	for _, addr := range []string{
+		fmt.Sprintf("127.0.0.1:%d", port),
+		fmt.Sprintf("[::1]:%d", port),
+	} {
+		conn, err := net.Dial("tcp", addr)
+		c.Assert(err, IsNil)
+		accepted, err := listener.Accept()
+		c.Assert(err, IsNil)
+		c.Check(accepted.LocalAddr().String(), Equals, addr)
+		accepted.Close()
+		conn.Close()
+	}
+}
+
+// If one of the addresses cannot be listened on, then listeners created for
+// other addresses are closed.
+func (s *ListenerSuite) TestListenError(c *C) {
+	taken, err := net.Listen("tcp4", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	// When
+	_, err = Listen(fmt.Sprintf("[::1]:%d,127.0.0.1:%d", port, port))
+
+	// Then
+	c.Check(err, ErrorMatches, ".* address already in use")
+	listener, err := net.Listen("tcp6", fmt.Sprintf("[::1]:%d", port))
+	c.Assert(err, IsNil)
+	listener.Close()
+}
+
+func (s *ListenerSuite) TestClose(c *C) {
+	listener, err := Listen("127.0.0.1:0,[::1]:0")
+	c.Assert(err, IsNil)
+
+	// When
+	c.Check(listener.Close(), IsNil)
+	c.Check(listener.Close(), IsNil)
+
+	// Then
+	_, err = listener.Accept()
+	c.Check(err, Equals, ErrListenerClosed)
+}
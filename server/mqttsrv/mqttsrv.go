@@ -0,0 +1,509 @@
+package mqttsrv
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	protocolName  = "MQTT"
+	protocolLevel = 4
+
+	// Shared subscription prefix as it is understood by most MQTT brokers:
+	// `$share/<group>/<topic>`. It allows clients to explicitly specify the
+	// consumer group to consume on behalf of.
+	sharedSubPrefix = "$share/"
+
+	maxPacketSize       = 4 * 1024 * 1024
+	connectTimeout      = 10 * time.Second
+	consumeRetryBackoff = 500 * time.Millisecond
+
+	// The maximum number of QoS 1 messages delivered to a client and not
+	// acknowledged with PUBACK yet. While it is reached, subscriptions of
+	// the client stop consuming.
+	receiveMaximum = 1000
+)
+
+// T is an MQTT 3.1.1 front-end to Kafka-Pixy. An MQTT topic name maps to a
+// Kafka topic of the default cluster, or it can have the form of
+// `<cluster>/<topic>` to address a particular cluster. Publishes are produced
+// to Kafka, QoS 1 publishes are acknowledged with PUBACK only after Kafka
+// confirms the write. Subscriptions consume on behalf of a consumer group
+// named after the MQTT client ID, unless the `$share/<group>/` prefix is
+// used. Messages delivered with QoS 1 are acknowledged in Kafka-Pixy when the
+// client responds with PUBACK.
+type T struct {
+	actDesc  *actor.Descriptor
+	addr     string
+	listener net.Listener
+	proxySet *proxy.Set
+	readOnly bool
+	errorCh  chan error
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+
+	connsMu sync.Mutex
+	conns   map[*conn]struct{}
+}
+
+// New creates an MQTT server instance that will accept connections at the
+// specified TCP address. If readOnly is set, then publishes are rejected.
+func New(addr string, proxySet *proxy.Set, readOnly bool) (*T, error) {
+	listener, err := server.Listen(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create listener")
+	}
+	return &T{
+		actDesc:  actor.Root().NewChild("mqtt", addr),
+		addr:     addr,
+		listener: listener,
+		proxySet: proxySet,
+		readOnly: readOnly,
+		errorCh:  make(chan error, 1),
+		stopCh:   make(chan struct{}),
+		conns:    make(map[*conn]struct{}),
+	}, nil
+}
+
+// Start triggers asynchronous MQTT server start. If it fails then the error
+// will be sent down to `ErrorCh()`.
+func (s *T) Start() {
+	actor.Spawn(s.actDesc, &s.wg, func() {
+		for {
+			nc, err := s.listener.Accept()
+			if err != nil {
+				select {
+				case <-s.stopCh:
+				default:
+					s.errorCh <- errors.Wrap(err, "MQTT server failed")
+				}
+				return
+			}
+			c := s.newConn(nc)
+			actor.Spawn(c.actDesc, &s.wg, c.serve)
+		}
+	})
+}
+
+// ErrorCh returns an output channel that MQTT server running in another
+// goroutine will use if it stops with error. The channel will be closed when
+// the server is fully stopped.
+func (s *T) ErrorCh() <-chan error {
+	return s.errorCh
+}
+
+// Stop stops accepting new connections, closes all established ones and
+// waits for their handlers to terminate.
+func (s *T) Stop() {
+	close(s.stopCh)
+	s.listener.Close()
+	s.connsMu.Lock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.connsMu.Unlock()
+	s.wg.Wait()
+	close(s.errorCh)
+}
+
+func (s *T) newConn(nc net.Conn) *conn {
+	c := &conn{
+		srv:           s,
+		actDesc:       s.actDesc.NewChild("conn"),
+		nc:            nc,
+		rd:            bufio.NewReader(nc),
+		inflight:      make(map[uint16]inflightMsg),
+		inflightIDs:   make(map[inflightKey]uint16),
+		inflightSlots: make(chan struct{}, receiveMaximum),
+		subs:          make(map[string]chan struct{}),
+		stopCh:        make(chan struct{}),
+	}
+	c.actDesc.AddLogField("remote", nc.RemoteAddr().String())
+	s.connsMu.Lock()
+	s.conns[c] = struct{}{}
+	select {
+	case <-s.stopCh:
+		// The connection was accepted concurrently with Stop.
+		nc.Close()
+	default:
+	}
+	s.connsMu.Unlock()
+	return c
+}
+
+func (s *T) removeConn(c *conn) {
+	s.connsMu.Lock()
+	delete(s.conns, c)
+	s.connsMu.Unlock()
+}
+
+// resolveTopic maps an MQTT topic name to a proxy and a Kafka topic.
+func (s *T) resolveTopic(mqttTopic string) (*proxy.T, string, error) {
+	var cluster, topic string
+	parts := strings.Split(mqttTopic, "/")
+	switch len(parts) {
+	case 1:
+		topic = parts[0]
+	case 2:
+		cluster, topic = parts[0], parts[1]
+	default:
+		return nil, "", errors.Errorf("invalid topic: %s", mqttTopic)
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return nil, "", errors.Errorf("invalid topic: %s", mqttTopic)
+	}
+	pxy, err := s.proxySet.Get(cluster)
+	if err != nil {
+		return nil, "", err
+	}
+	return pxy, topic, nil
+}
+
+// inflightKey identifies a message delivered to a client with QoS 1.
+type inflightKey struct {
+	pxy       *proxy.T
+	group     string
+	topic     string
+	partition int32
+	offset    int64
+}
+
+type inflightMsg struct {
+	inflightKey
+	ack proxy.Ack
+}
+
+// conn handles a single MQTT client connection.
+type conn struct {
+	srv      *T
+	actDesc  *actor.Descriptor
+	nc       net.Conn
+	rd       *bufio.Reader
+	clientID string
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+
+	writeMu sync.Mutex
+
+	// Holds a token for every QoS 1 message that is either being consumed
+	// or is in flight, so that there are never more than receiveMaximum.
+	inflightSlots chan struct{}
+
+	mu           sync.Mutex
+	nextPacketID uint16
+	inflight     map[uint16]inflightMsg
+	inflightIDs  map[inflightKey]uint16
+	subs         map[string]chan struct{}
+}
+
+func (c *conn) serve() {
+	defer func() {
+		close(c.stopCh)
+		c.nc.Close()
+		c.wg.Wait()
+		c.srv.removeConn(c)
+	}()
+	keepAlive, err := c.handshake()
+	if err != nil {
+		c.actDesc.Log().WithError(err).Info("Handshake failed")
+		return
+	}
+	for {
+		if keepAlive > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		pkt, err := readPacket(c.rd, maxPacketSize)
+		if err != nil {
+			select {
+			case <-c.srv.stopCh:
+			default:
+				c.actDesc.Log().WithError(err).Info("Connection closed")
+			}
+			return
+		}
+		switch pkt.typ {
+		case pktPublish:
+			err = c.handlePublish(pkt)
+		case pktPubAck:
+			err = c.handlePubAck(pkt)
+		case pktSubscribe:
+			err = c.handleSubscribe(pkt)
+		case pktUnsubscribe:
+			err = c.handleUnsubscribe(pkt)
+		case pktPingReq:
+			err = c.write(encodePacket(pktPingResp, 0, nil))
+		case pktDisconnect:
+			return
+		default:
+			err = errors.Errorf("unexpected packet type: %d", pkt.typ)
+		}
+		if err != nil {
+			c.actDesc.Log().WithError(err).Error("Closing connection")
+			return
+		}
+	}
+}
+
+// handshake reads the CONNECT packet and replies with CONNACK. It returns the
+// keep alive interval requested by the client.
+func (c *conn) handshake() (time.Duration, error) {
+	c.nc.SetReadDeadline(time.Now().Add(connectTimeout))
+	pkt, err := readPacket(c.rd, maxPacketSize)
+	if err != nil {
+		return 0, err
+	}
+	c.nc.SetReadDeadline(time.Time{})
+	if pkt.typ != pktConnect {
+		return 0, errors.Errorf("expected CONNECT, got %d", pkt.typ)
+	}
+	connect, err := parseConnect(pkt.body)
+	if err != nil {
+		return 0, err
+	}
+	if connect.protocol != protocolName || connect.level != protocolLevel {
+		c.write(encodePacket(pktConnAck, 0, []byte{0, connRefusedProtocol}))
+		return 0, errors.Errorf("unsupported protocol: %s v%d", connect.protocol, connect.level)
+	}
+	c.clientID = connect.clientID
+	if c.clientID == "" {
+		if !connect.cleanSession {
+			c.write(encodePacket(pktConnAck, 0, []byte{0, connRefusedIdentifier}))
+			return 0, errors.New("empty client ID requires clean session")
+		}
+		token := make([]byte, 8)
+		_, _ = rand.Read(token)
+		c.clientID = "mqtt_" + hex.EncodeToString(token)
+	}
+	c.actDesc.AddLogField("mqtt.client_id", c.clientID)
+	if err := c.write(encodePacket(pktConnAck, 0, []byte{0, connAccepted})); err != nil {
+		return 0, err
+	}
+	return time.Duration(connect.keepAlive) * time.Second, nil
+}
+
+func (c *conn) handlePublish(pkt packet) error {
+	pub, err := parsePublish(pkt.flags, pkt.body)
+	if err != nil {
+		return err
+	}
+	if pub.qos > 1 {
+		return errors.Errorf("QoS %d is not supported", pub.qos)
+	}
+	pxy, topic, err := c.srv.resolveTopic(pub.topic)
+	if err != nil {
+		return err
+	}
+	if c.srv.readOnly || pxy.IsReadOnly() {
+		// MQTT 3.1.1 provides no way to reject a publish, so the
+		// connection is closed.
+		return proxy.ErrReadOnly
+	}
+	headers := pxy.WithEnrichment(nil, topic, nil)
+	if pub.qos == 0 {
+		pxy.AsyncProduce(topic, nil, sarama.ByteEncoder(pub.payload), headers)
+		return nil
+	}
+	if _, err := pxy.Produce(topic, nil, sarama.ByteEncoder(pub.payload), headers); err != nil {
+		// MQTT 3.1.1 provides no way to report a publish failure, so the
+		// connection is closed to make the client retry.
+		return errors.Wrap(err, "failed to produce")
+	}
+	return c.write(encodePacket(pktPubAck, 0, appendUint16(nil, pub.packetID)))
+}
+
+func (c *conn) handlePubAck(pkt packet) error {
+	packetID, err := parsePacketID(pkt.body)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	msg, ok := c.inflight[packetID]
+	if ok {
+		c.removeInflight(packetID)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.actDesc.Log().Warnf("PUBACK for unknown packet: %d", packetID)
+		return nil
+	}
+	if err := msg.pxy.Ack(msg.group, msg.topic, msg.ack); err != nil {
+		c.actDesc.Log().WithError(err).Errorf("Failed to ack: topic=%s", msg.topic)
+	}
+	return nil
+}
+
+func (c *conn) handleSubscribe(pkt packet) error {
+	sub, err := parseSubscribe(pkt.body)
+	if err != nil {
+		return err
+	}
+	rc := appendUint16(nil, sub.packetID)
+	for _, s := range sub.subscriptions {
+		group, mqttTopic := c.clientID, s.filter
+		if strings.HasPrefix(mqttTopic, sharedSubPrefix) {
+			parts := strings.SplitN(mqttTopic[len(sharedSubPrefix):], "/", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				rc = append(rc, subAckFailure)
+				continue
+			}
+			group, mqttTopic = parts[0], parts[1]
+		}
+		pxy, topic, err := c.srv.resolveTopic(mqttTopic)
+		if err != nil {
+			c.actDesc.Log().WithError(err).Warn("Subscription rejected")
+			rc = append(rc, subAckFailure)
+			continue
+		}
+		qos := s.qos
+		if qos > 1 {
+			qos = 1
+		}
+		filter := s.filter
+		c.mu.Lock()
+		if _, ok := c.subs[filter]; !ok {
+			subStopCh := make(chan struct{})
+			c.subs[filter] = subStopCh
+			actDesc := c.actDesc.NewChild("sub", group, topic)
+			actor.Spawn(actDesc, &c.wg, func() {
+				c.runSubscription(actDesc, pxy, group, topic, filter, qos, subStopCh)
+			})
+		}
+		c.mu.Unlock()
+		rc = append(rc, qos)
+	}
+	return c.write(encodePacket(pktSubAck, 0, rc))
+}
+
+func (c *conn) handleUnsubscribe(pkt packet) error {
+	unsub, err := parseUnsubscribe(pkt.body)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for _, filter := range unsub.filters {
+		if subStopCh, ok := c.subs[filter]; ok {
+			close(subStopCh)
+			delete(c.subs, filter)
+		}
+	}
+	c.mu.Unlock()
+	return c.write(encodePacket(pktUnsubAck, 0, appendUint16(nil, unsub.packetID)))
+}
+
+// runSubscription keeps consuming messages from a Kafka topic and delivering
+// them to the client until either the subscription or the connection is
+// terminated. QoS 0 messages are acknowledged on delivery, QoS 1 messages
+// are acknowledged when the client sends PUBACK for them. While the client
+// has receiveMaximum QoS 1 messages in flight, no more are consumed.
+func (c *conn) runSubscription(actDesc *actor.Descriptor, pxy *proxy.T, group, topic, mqttTopic string,
+	qos byte, subStopCh <-chan struct{},
+) {
+	ack := proxy.NoAck()
+	if qos == 0 {
+		ack = proxy.AutoAck()
+	}
+	for {
+		if qos > 0 {
+			select {
+			case c.inflightSlots <- struct{}{}:
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			}
+		} else {
+			select {
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			default:
+			}
+		}
+		msg, err := pxy.Consume(group, topic, ack)
+		if err != nil {
+			if qos > 0 {
+				<-c.inflightSlots
+			}
+			if err == consumer.ErrRequestTimeout {
+				continue
+			}
+			actDesc.Log().WithError(err).Warn("Failed to consume")
+			select {
+			case <-time.After(consumeRetryBackoff):
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			}
+			continue
+		}
+		pub := publishPkt{topic: mqttTopic, qos: qos, payload: msg.Value}
+		if qos > 0 {
+			msgAck, _ := proxy.NewAck(msg.Partition, msg.Offset)
+			pub.packetID = c.registerInflight(inflightMsg{
+				inflightKey{pxy, group, topic, msg.Partition, msg.Offset}, msgAck})
+		}
+		if err := c.write(encodePublish(pub)); err != nil {
+			actDesc.Log().WithFields(log.Fields{
+				"kafka.partition": msg.Partition,
+				"kafka.offset":    msg.Offset,
+			}).WithError(err).Warn("Failed to deliver")
+			return
+		}
+	}
+}
+
+// registerInflight assigns a packet ID to a QoS 1 message, whose slot has
+// been taken already. If the message is redelivered, because the client has
+// not acknowledged it within the ack timeout, then the entry of the previous
+// delivery is dropped, for acknowledging either of them acknowledges the
+// message. There is always a free packet ID, for there are never more than
+// receiveMaximum messages in flight.
+func (c *conn) registerInflight(msg inflightMsg) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if packetID, ok := c.inflightIDs[msg.inflightKey]; ok {
+		c.removeInflight(packetID)
+	}
+	for {
+		c.nextPacketID++
+		if c.nextPacketID == 0 {
+			continue
+		}
+		if _, ok := c.inflight[c.nextPacketID]; !ok {
+			break
+		}
+	}
+	c.inflight[c.nextPacketID] = msg
+	c.inflightIDs[msg.inflightKey] = c.nextPacketID
+	return c.nextPacketID
+}
+
+// removeInflight removes an in-flight message and frees its slot. It must be
+// called with the mutex held.
+func (c *conn) removeInflight(packetID uint16) {
+	delete(c.inflightIDs, c.inflight[packetID].inflightKey)
+	delete(c.inflight, packetID)
+	<-c.inflightSlots
+}
+
+func (c *conn) write(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.nc.Write(b)
+	return err
+}
@@ -0,0 +1,59 @@
+package mqttsrv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type ConnSuite struct{}
+
+var _ = Suite(&ConnSuite{})
+
+func newTestConn() *conn {
+	return &conn{
+		inflight:      make(map[uint16]inflightMsg),
+		inflightIDs:   make(map[inflightKey]uint16),
+		inflightSlots: make(chan struct{}, receiveMaximum),
+	}
+}
+
+// When a message is redelivered, the entry of the previous delivery is
+// dropped along with its slot.
+func (s *ConnSuite) TestRegisterInflightRedelivered(c *C) {
+	cn := newTestConn()
+	key1 := inflightKey{group: "g", topic: "t", partition: 1, offset: 10}
+	key2 := inflightKey{group: "g", topic: "t", partition: 1, offset: 11}
+	cn.inflightSlots <- struct{}{}
+	id1 := cn.registerInflight(inflightMsg{inflightKey: key1})
+	cn.inflightSlots <- struct{}{}
+	cn.registerInflight(inflightMsg{inflightKey: key2})
+
+	// When
+	cn.inflightSlots <- struct{}{}
+	id3 := cn.registerInflight(inflightMsg{inflightKey: key1})
+
+	// Then
+	c.Check(id3, Not(Equals), id1)
+	c.Check(len(cn.inflight), Equals, 2)
+	c.Check(len(cn.inflightIDs), Equals, 2)
+	c.Check(len(cn.inflightSlots), Equals, 2)
+	_, ok := cn.inflight[id1]
+	c.Check(ok, Equals, false)
+	c.Check(cn.inflight[id3].inflightKey, Equals, key1)
+}
+
+// Packet IDs in use are skipped and zero is never used, even when the IDs
+// wrap around.
+func (s *ConnSuite) TestRegisterInflightWrapAround(c *C) {
+	cn := newTestConn()
+	cn.nextPacketID = 65534
+	cn.inflightSlots <- struct{}{}
+	c.Check(cn.registerInflight(inflightMsg{inflightKey: inflightKey{offset: 1}}), Equals, uint16(65535))
+	cn.nextPacketID = 65534
+
+	// When
+	cn.inflightSlots <- struct{}{}
+	packetID := cn.registerInflight(inflightMsg{inflightKey: inflightKey{offset: 2}})
+
+	// Then
+	c.Check(packetID, Equals, uint16(1))
+}
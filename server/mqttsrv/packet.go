@@ -0,0 +1,254 @@
+package mqttsrv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	pktConnect     = 1
+	pktConnAck     = 2
+	pktPublish     = 3
+	pktPubAck      = 4
+	pktSubscribe   = 8
+	pktSubAck      = 9
+	pktUnsubscribe = 10
+	pktUnsubAck    = 11
+	pktPingReq     = 12
+	pktPingResp    = 13
+	pktDisconnect  = 14
+)
+
+// CONNACK and SUBACK return codes.
+const (
+	connAccepted          = 0x00
+	connRefusedProtocol   = 0x01
+	connRefusedIdentifier = 0x02
+	subAckFailure         = 0x80
+)
+
+// Remaining length is encoded with at most that many bytes.
+const maxRemainingLengthDigits = 4
+
+var errMalformed = errors.New("malformed packet")
+
+// packet represents a raw MQTT control packet as it is read from the wire.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a control packet from the stream. Packets with remaining
+// length exceeding `maxSize` are rejected.
+func readPacket(r *bufio.Reader, maxSize int) (packet, error) {
+	hdr, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	var size, multiplier int = 0, 1
+	for i := 0; ; i++ {
+		if i == maxRemainingLengthDigits {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		size += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if size > maxSize {
+		return packet{}, errors.Errorf("packet too large: %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{typ: hdr >> 4, flags: hdr & 0x0f, body: body}, nil
+}
+
+// encodePacket returns wire representation of a control packet.
+func encodePacket(typ, flags byte, body []byte) []byte {
+	buf := make([]byte, 0, len(body)+5)
+	buf = append(buf, typ<<4|flags&0x0f)
+	size := len(body)
+	for {
+		b := byte(size % 128)
+		size /= 128
+		if size > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if size == 0 {
+			break
+		}
+	}
+	return append(buf, body...)
+}
+
+// decoder reads MQTT primitive data types from a packet body. The first
+// decoding error is sticky and all subsequent reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+type connectPkt struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+}
+
+func parseConnect(body []byte) (connectPkt, error) {
+	d := decoder{buf: body}
+	var c connectPkt
+	c.protocol = d.string()
+	c.level = d.byte()
+	flags := d.byte()
+	c.cleanSession = flags&0x02 != 0
+	c.keepAlive = d.uint16()
+	c.clientID = d.string()
+	// Will, user name and password are parsed to validate the packet but
+	// are otherwise ignored.
+	if flags&0x04 != 0 {
+		d.string()
+		d.bytes()
+	}
+	if flags&0x80 != 0 {
+		d.string()
+	}
+	if flags&0x40 != 0 {
+		d.bytes()
+	}
+	return c, d.err
+}
+
+type publishPkt struct {
+	topic    string
+	qos      byte
+	packetID uint16
+	payload  []byte
+}
+
+func parsePublish(flags byte, body []byte) (publishPkt, error) {
+	d := decoder{buf: body}
+	p := publishPkt{qos: (flags >> 1) & 0x03}
+	p.topic = d.string()
+	if p.qos > 0 {
+		p.packetID = d.uint16()
+	}
+	if d.err != nil {
+		return publishPkt{}, d.err
+	}
+	p.payload = d.buf
+	return p, nil
+}
+
+func encodePublish(p publishPkt) []byte {
+	body := appendString(nil, p.topic)
+	if p.qos > 0 {
+		body = appendUint16(body, p.packetID)
+	}
+	body = append(body, p.payload...)
+	return encodePacket(pktPublish, p.qos<<1, body)
+}
+
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+type subscribePkt struct {
+	packetID      uint16
+	subscriptions []subscription
+}
+
+func parseSubscribe(body []byte) (subscribePkt, error) {
+	d := decoder{buf: body}
+	var s subscribePkt
+	s.packetID = d.uint16()
+	for d.err == nil && len(d.buf) > 0 {
+		filter := d.string()
+		qos := d.byte()
+		s.subscriptions = append(s.subscriptions, subscription{filter, qos})
+	}
+	if d.err == nil && len(s.subscriptions) == 0 {
+		return subscribePkt{}, errMalformed
+	}
+	return s, d.err
+}
+
+type unsubscribePkt struct {
+	packetID uint16
+	filters  []string
+}
+
+func parseUnsubscribe(body []byte) (unsubscribePkt, error) {
+	d := decoder{buf: body}
+	var u unsubscribePkt
+	u.packetID = d.uint16()
+	for d.err == nil && len(d.buf) > 0 {
+		u.filters = append(u.filters, d.string())
+	}
+	return u, d.err
+}
+
+func parsePacketID(body []byte) (uint16, error) {
+	d := decoder{buf: body}
+	id := d.uint16()
+	return id, d.err
+}
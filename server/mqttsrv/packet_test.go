@@ -0,0 +1,152 @@
+package mqttsrv
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PacketSuite struct{}
+
+var _ = Suite(&PacketSuite{})
+
+// Remaining length is encoded using a variable length encoding scheme.
+func (s *PacketSuite) TestEncodeRemainingLength(c *C) {
+	for i, tc := range []struct {
+		size    int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+	} {
+		// When
+		b := encodePacket(pktPublish, 0, make([]byte, tc.size))
+
+		// Then
+		c.Check(b[1:len(b)-tc.size], DeepEquals, tc.encoded, Commentf("case #%d", i))
+	}
+}
+
+func (s *PacketSuite) TestReadPacket(c *C) {
+	body := bytes.Repeat([]byte("x"), 300)
+	r := bufio.NewReader(bytes.NewReader(encodePacket(pktSubscribe, 2, body)))
+
+	// When
+	pkt, err := readPacket(r, maxPacketSize)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(pkt.typ, Equals, byte(pktSubscribe))
+	c.Check(pkt.flags, Equals, byte(2))
+	c.Check(pkt.body, DeepEquals, body)
+}
+
+func (s *PacketSuite) TestReadPacketTooLarge(c *C) {
+	r := bufio.NewReader(bytes.NewReader(encodePacket(pktPublish, 0, make([]byte, 11))))
+
+	// When
+	_, err := readPacket(r, 10)
+
+	// Then
+	c.Check(err, ErrorMatches, "packet too large: 11")
+}
+
+func (s *PacketSuite) TestParseConnect(c *C) {
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02|0x04|0x80)
+	body = appendUint16(body, 30)
+	body = appendString(body, "device-1")
+	body = appendString(body, "will/topic")
+	body = appendString(body, "will message")
+	body = appendString(body, "user")
+
+	// When
+	connect, err := parseConnect(body)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(connect, DeepEquals, connectPkt{
+		protocol:     "MQTT",
+		level:        4,
+		cleanSession: true,
+		keepAlive:    30,
+		clientID:     "device-1",
+	})
+}
+
+func (s *PacketSuite) TestParseConnectTruncated(c *C) {
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02)
+
+	// When
+	_, err := parseConnect(body)
+
+	// Then
+	c.Check(err, Equals, errMalformed)
+}
+
+func (s *PacketSuite) TestPublishRoundTrip(c *C) {
+	pub := publishPkt{topic: "foo/bar", qos: 1, packetID: 513, payload: []byte("hello")}
+	r := bufio.NewReader(bytes.NewReader(encodePublish(pub)))
+	pkt, err := readPacket(r, maxPacketSize)
+	c.Assert(err, IsNil)
+
+	// When
+	parsed, err := parsePublish(pkt.flags, pkt.body)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, pub)
+}
+
+func (s *PacketSuite) TestParseSubscribe(c *C) {
+	body := appendUint16(nil, 7)
+	body = appendString(body, "foo")
+	body = append(body, 1)
+	body = appendString(body, "$share/g1/bar")
+	body = append(body, 0)
+
+	// When
+	sub, err := parseSubscribe(body)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(sub, DeepEquals, subscribePkt{
+		packetID: 7,
+		subscriptions: []subscription{
+			{"foo", 1},
+			{"$share/g1/bar", 0},
+		},
+	})
+}
+
+// A SUBSCRIBE packet with no topic filters is a protocol violation.
+func (s *PacketSuite) TestParseSubscribeEmpty(c *C) {
+	// When
+	_, err := parseSubscribe(appendUint16(nil, 7))
+
+	// Then
+	c.Check(err, Equals, errMalformed)
+}
+
+func (s *PacketSuite) TestParseUnsubscribe(c *C) {
+	body := appendUint16(nil, 9)
+	body = appendString(body, "foo")
+	body = appendString(body, "bar")
+
+	// When
+	unsub, err := parseUnsubscribe(body)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(unsub, DeepEquals, unsubscribePkt{packetID: 9, filters: []string{"foo", "bar"}})
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/limiter"
+	"github.com/pkg/errors"
+)
+
+// ErrPoolExhausted is returned when a request is rejected because the worker
+// pool of its endpoint group has neither a free worker nor room in its queue.
+var ErrPoolExhausted = errors.New("too many requests of the endpoint group")
+
+// Pools are bounded worker pools that requests of endpoint groups are handled
+// on. They are shared by all listeners, so that a flood of requests of one
+// group via any listener cannot take up the capacity to serve the others. A
+// nil pools instance does not limit anything.
+type Pools struct {
+	limiters     map[string]*limiter.T
+	queueTimeout time.Duration
+}
+
+// NewPools creates worker pools of endpoint groups that have concurrency
+// configured, and reports their usage as metrics of the specified actor. If
+// there are none, then nil is returned.
+func NewPools(actDesc *actor.Descriptor, cfg config.ListenerPools) *Pools {
+	var p *Pools
+	for group, limit := range cfg.Limits() {
+		l := limiter.New(limit.Concurrency, limit.QueueSize)
+		if l == nil {
+			continue
+		}
+		if p == nil {
+			p = &Pools{limiters: make(map[string]*limiter.T), queueTimeout: cfg.QueueTimeout}
+		}
+		p.limiters[group] = l
+		actDesc.ObserveQueue(group+"_pool", l.Queued)
+		actDesc.ObserveGauge(group+"_pool_in_flight", func() int64 { return int64(l.InFlight()) })
+	}
+	return p
+}
+
+// Acquire takes a worker of an endpoint group pool, waiting in its queue for
+// at most the queue timeout. It returns false if the request is rejected. If
+// true is returned, then the worker must be freed by calling Release.
+func (p *Pools) Acquire(group string) bool {
+	if p == nil {
+		return true
+	}
+	return p.limiters[group].Acquire(p.queueTimeout)
+}
+
+// Release frees a worker of an endpoint group pool taken by Acquire.
+func (p *Pools) Release(group string) {
+	if p == nil {
+		return
+	}
+	p.limiters[group].Release()
+}
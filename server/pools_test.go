@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+type PoolsSuite struct{}
+
+var _ = Suite(&PoolsSuite{})
+
+// If no pool is configured, then nothing is limited.
+func (s *PoolsSuite) TestNoPools(c *C) {
+	// When
+	pools := NewPools(actor.Root(), config.ListenerPools{QueueTimeout: time.Second})
+
+	// Then
+	c.Check(pools, IsNil)
+	c.Check(pools.Acquire(config.EndpointsProduce), Equals, true)
+	pools.Release(config.EndpointsProduce)
+}
+
+// A group that has run out of workers and queue room does not affect the
+// other groups.
+func (s *PoolsSuite) TestGroupsIndependent(c *C) {
+	var cfg config.ListenerPools
+	cfg.Consume = config.Limit{Concurrency: 1}
+	cfg.Produce = config.Limit{Concurrency: 1}
+	cfg.QueueTimeout = 50 * time.Millisecond
+	pools := NewPools(actor.Root(), cfg)
+	c.Assert(pools.Acquire(config.EndpointsConsume), Equals, true)
+
+	// When/Then
+	c.Check(pools.Acquire(config.EndpointsConsume), Equals, false)
+	c.Check(pools.Acquire(config.EndpointsProduce), Equals, true)
+	c.Check(pools.Acquire(config.EndpointsAdmin), Equals, true)
+	c.Check(pools.Acquire(config.EndpointsDebug), Equals, true)
+
+	// When
+	pools.Release(config.EndpointsConsume)
+
+	// Then
+	c.Check(pools.Acquire(config.EndpointsConsume), Equals, true)
+}
+
+// Requests wait in a queue for a free worker.
+func (s *PoolsSuite) TestQueued(c *C) {
+	var cfg config.ListenerPools
+	cfg.Produce = config.Limit{Concurrency: 1, QueueSize: 1}
+	cfg.QueueTimeout = 3 * time.Second
+	pools := NewPools(actor.Root(), cfg)
+	c.Assert(pools.Acquire(config.EndpointsProduce), Equals, true)
+	time.AfterFunc(50*time.Millisecond, func() { pools.Release(config.EndpointsProduce) })
+
+	// When
+	begin := time.Now()
+	acquired := pools.Acquire(config.EndpointsProduce)
+
+	// Then
+	c.Check(acquired, Equals, true)
+	c.Check(time.Since(begin) < time.Second, Equals, true)
+}
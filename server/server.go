@@ -15,3 +15,9 @@ type T interface {
 	// may have occurred on server startup.
 	ErrorCh() <-chan error
 }
+
+// Drainer is implemented by servers that can report to health checks that
+// the instance is about to shut down, while still serving requests.
+type Drainer interface {
+	Drain()
+}
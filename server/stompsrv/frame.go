@@ -0,0 +1,190 @@
+package stompsrv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// STOMP 1.2 frame commands.
+const (
+	cmdConnect     = "CONNECT"
+	cmdStomp       = "STOMP"
+	cmdConnected   = "CONNECTED"
+	cmdSend        = "SEND"
+	cmdSubscribe   = "SUBSCRIBE"
+	cmdUnsubscribe = "UNSUBSCRIBE"
+	cmdAck         = "ACK"
+	cmdNack        = "NACK"
+	cmdDisconnect  = "DISCONNECT"
+	cmdMessage     = "MESSAGE"
+	cmdReceipt     = "RECEIPT"
+	cmdError       = "ERROR"
+)
+
+// STOMP 1.2 frame headers.
+const (
+	hdrAcceptVersion = "accept-version"
+	hdrVersion       = "version"
+	hdrHeartBeat     = "heart-beat"
+	hdrDestination   = "destination"
+	hdrContentLength = "content-length"
+	hdrReceipt       = "receipt"
+	hdrReceiptID     = "receipt-id"
+	hdrID            = "id"
+	hdrAck           = "ack"
+	hdrSubscription  = "subscription"
+	hdrMessageID     = "message-id"
+	hdrMessage       = "message"
+)
+
+var (
+	errMalformed    = errors.New("malformed frame")
+	headerEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	headerUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// frame represents a STOMP frame. Headers are kept in the order they were
+// received, since the first occurrence of a repeated header wins.
+type frame struct {
+	command string
+	headers [][2]string
+	body    []byte
+}
+
+func newFrame(command string, headers ...string) *frame {
+	f := &frame{command: command}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.headers = append(f.headers, [2]string{headers[i], headers[i+1]})
+	}
+	return f
+}
+
+// header returns the value of the first occurrence of the named header.
+func (f *frame) header(name string) (string, bool) {
+	for _, h := range f.headers {
+		if h[0] == name {
+			return h[1], true
+		}
+	}
+	return "", false
+}
+
+func (f *frame) get(name string) string {
+	v, _ := f.header(name)
+	return v
+}
+
+// readFrame reads a frame from the stream skipping heart-beats. Frames with
+// a body exceeding `maxSize` are rejected.
+func readFrame(r *bufio.Reader, maxSize int) (*frame, error) {
+	var command string
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line != "" {
+			command = line
+			break
+		}
+	}
+	f := &frame{command: command}
+	// CONNECT frame headers are not escaped for backward compatibility
+	// with STOMP 1.0.
+	escaped := command != cmdConnect
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, errMalformed
+		}
+		name, value := line[:i], line[i+1:]
+		if escaped {
+			name, value = headerUnescaper.Replace(name), headerUnescaper.Replace(value)
+		}
+		f.headers = append(f.headers, [2]string{name, value})
+	}
+	if lenStr, ok := f.header(hdrContentLength); ok {
+		size, err := strconv.Atoi(lenStr)
+		if err != nil || size < 0 {
+			return nil, errMalformed
+		}
+		if size > maxSize {
+			return nil, errors.Errorf("frame too large: %d", size)
+		}
+		f.body = make([]byte, size+1)
+		if _, err := io.ReadFull(r, f.body); err != nil {
+			return nil, err
+		}
+		if f.body[size] != 0 {
+			return nil, errMalformed
+		}
+		f.body = f.body[:size]
+		return f, nil
+	}
+	var body bytes.Buffer
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			break
+		}
+		if body.Len() == maxSize {
+			return nil, errors.Errorf("frame too large: >%d", maxSize)
+		}
+		body.WriteByte(b)
+	}
+	f.body = body.Bytes()
+	return f, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// encode returns wire representation of the frame.
+func (f *frame) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.command)
+	buf.WriteByte('\n')
+	escaped := f.command != cmdConnected
+	for _, h := range f.headers {
+		if escaped {
+			buf.WriteString(headerEscaper.Replace(h[0]))
+			buf.WriteByte(':')
+			buf.WriteString(headerEscaper.Replace(h[1]))
+		} else {
+			buf.WriteString(h[0])
+			buf.WriteByte(':')
+			buf.WriteString(h[1])
+		}
+		buf.WriteByte('\n')
+	}
+	if f.body != nil {
+		buf.WriteString(hdrContentLength)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(len(f.body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
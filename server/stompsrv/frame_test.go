@@ -0,0 +1,101 @@
+package stompsrv
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type FrameSuite struct{}
+
+var _ = Suite(&FrameSuite{})
+
+func (s *FrameSuite) TestReadFrame(c *C) {
+	r := bufio.NewReader(strings.NewReader(
+		"\n\r\nSEND\r\ndestination:/queue/a\\cb\nreceipt:77\nreceipt:78\n\nhello\x00"))
+
+	// When
+	f, err := readFrame(r, maxFrameSize)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(f.command, Equals, cmdSend)
+	c.Check(f.get(hdrDestination), Equals, "/queue/a:b")
+	c.Check(f.get(hdrReceipt), Equals, "77")
+	c.Check(string(f.body), Equals, "hello")
+}
+
+// If content-length header is present, then the body can contain NUL bytes.
+func (s *FrameSuite) TestReadFrameContentLength(c *C) {
+	r := bufio.NewReader(strings.NewReader("SEND\ncontent-length:3\n\na\x00b\x00"))
+
+	// When
+	f, err := readFrame(r, maxFrameSize)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(f.body, DeepEquals, []byte("a\x00b"))
+}
+
+func (s *FrameSuite) TestReadFrameTooLarge(c *C) {
+	r := bufio.NewReader(strings.NewReader("SEND\ncontent-length:11\n\n0123456789a\x00"))
+
+	// When
+	_, err := readFrame(r, 10)
+
+	// Then
+	c.Check(err, ErrorMatches, "frame too large: 11")
+}
+
+func (s *FrameSuite) TestReadFrameBadHeader(c *C) {
+	r := bufio.NewReader(strings.NewReader("SEND\nfoo\n\n\x00"))
+
+	// When
+	_, err := readFrame(r, maxFrameSize)
+
+	// Then
+	c.Check(err, Equals, errMalformed)
+}
+
+// CONNECT frame headers are not unescaped.
+func (s *FrameSuite) TestReadConnectFrame(c *C) {
+	r := bufio.NewReader(strings.NewReader("CONNECT\nlogin:a\\cb\n\n\x00"))
+
+	// When
+	f, err := readFrame(r, maxFrameSize)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(f.get("login"), Equals, "a\\cb")
+}
+
+func (s *FrameSuite) TestEncodeRoundTrip(c *C) {
+	f := newFrame(cmdMessage, hdrDestination, "/queue/a:b\nc", hdrMessageID, "1-2")
+	f.body = []byte("x\x00y")
+	r := bufio.NewReader(strings.NewReader(string(f.encode())))
+
+	// When
+	parsed, err := readFrame(r, maxFrameSize)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(parsed.command, Equals, cmdMessage)
+	c.Check(parsed.get(hdrDestination), Equals, "/queue/a:b\nc")
+	c.Check(parsed.get(hdrMessageID), Equals, "1-2")
+	c.Check(parsed.get(hdrContentLength), Equals, "3")
+	c.Check(parsed.body, DeepEquals, []byte("x\x00y"))
+}
+
+func (s *FrameSuite) TestEncodeNoBody(c *C) {
+	// When
+	b := newFrame(cmdReceipt, hdrReceiptID, "77").encode()
+
+	// Then
+	c.Check(string(b), Equals, "RECEIPT\nreceipt-id:77\n\n\x00")
+}
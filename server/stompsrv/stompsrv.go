@@ -0,0 +1,530 @@
+package stompsrv
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/server"
+	"github.com/pkg/errors"
+)
+
+const (
+	protocolVersion = "1.2"
+
+	ackModeAuto             = "auto"
+	ackModeClient           = "client"
+	ackModeClientIndividual = "client-individual"
+
+	maxFrameSize        = 4 * 1024 * 1024
+	connectTimeout      = 10 * time.Second
+	consumeRetryBackoff = 500 * time.Millisecond
+
+	// The maximum number of messages sent to a client and not acknowledged
+	// yet. While it is reached, subscriptions of the client stop consuming.
+	receiveMaximum = 1000
+)
+
+// T is a STOMP 1.2 front-end to Kafka-Pixy. It allows legacy message broker
+// clients (e.g. ones written for RabbitMQ STOMP plugin) to produce and
+// consume Kafka messages. Destinations are statically mapped to Kafka topics
+// and consumer groups in the config.
+//
+// A SEND frame is produced to the topic that its destination is mapped to. A
+// destination can have the form of `<destination>/<routing key>`, in which
+// case the routing key is used as the Kafka message key. If a SEND frame
+// requests a receipt, then the message is produced synchronously and the
+// RECEIPT is sent after Kafka confirms the write.
+//
+// A SUBSCRIBE frame starts consumption from the topic on behalf of the
+// group that the destination is mapped to. With `auto` ack mode messages are
+// acknowledged as soon as they are sent to the client. With `client` and
+// `client-individual` modes every message has to be acknowledged with an ACK
+// frame, otherwise it is offered again after `consumer.ack_timeout`. In the
+// `client` mode an ACK is cumulative, that is it acknowledges all messages
+// sent to the subscription before the acknowledged one as well.
+type T struct {
+	actDesc      *actor.Descriptor
+	addr         string
+	listener     net.Listener
+	proxySet     *proxy.Set
+	destinations map[string]config.STOMPDestination
+	readOnly     bool
+	errorCh      chan error
+	stopCh       chan struct{}
+	wg           sync.WaitGroup
+
+	connsMu sync.Mutex
+	conns   map[*conn]struct{}
+}
+
+// New creates a STOMP server instance that will accept connections at the
+// specified TCP address. If readOnly is set, then sends are rejected.
+func New(addr string, proxySet *proxy.Set, destinations map[string]config.STOMPDestination, readOnly bool) (*T, error) {
+	listener, err := server.Listen(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create listener")
+	}
+	return &T{
+		actDesc:      actor.Root().NewChild("stomp", addr),
+		addr:         addr,
+		listener:     listener,
+		proxySet:     proxySet,
+		destinations: destinations,
+		readOnly:     readOnly,
+		errorCh:      make(chan error, 1),
+		stopCh:       make(chan struct{}),
+		conns:        make(map[*conn]struct{}),
+	}, nil
+}
+
+// Start triggers asynchronous STOMP server start. If it fails then the error
+// will be sent down to `ErrorCh()`.
+func (s *T) Start() {
+	actor.Spawn(s.actDesc, &s.wg, func() {
+		for {
+			nc, err := s.listener.Accept()
+			if err != nil {
+				select {
+				case <-s.stopCh:
+				default:
+					s.errorCh <- errors.Wrap(err, "STOMP server failed")
+				}
+				return
+			}
+			c := s.newConn(nc)
+			actor.Spawn(c.actDesc, &s.wg, c.serve)
+		}
+	})
+}
+
+// ErrorCh returns an output channel that STOMP server running in another
+// goroutine will use if it stops with error. The channel will be closed when
+// the server is fully stopped.
+func (s *T) ErrorCh() <-chan error {
+	return s.errorCh
+}
+
+// Stop stops accepting new connections, closes all established ones and
+// waits for their handlers to terminate.
+func (s *T) Stop() {
+	close(s.stopCh)
+	s.listener.Close()
+	s.connsMu.Lock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.connsMu.Unlock()
+	s.wg.Wait()
+	close(s.errorCh)
+}
+
+func (s *T) newConn(nc net.Conn) *conn {
+	c := &conn{
+		srv:           s,
+		actDesc:       s.actDesc.NewChild("conn"),
+		nc:            nc,
+		rd:            bufio.NewReader(nc),
+		inflight:      make(map[string]inflightMsg),
+		inflightIDs:   make(map[inflightKey]string),
+		inflightSlots: make(chan struct{}, receiveMaximum),
+		subs:          make(map[string]chan struct{}),
+		stopCh:        make(chan struct{}),
+	}
+	c.actDesc.AddLogField("remote", nc.RemoteAddr().String())
+	s.connsMu.Lock()
+	s.conns[c] = struct{}{}
+	select {
+	case <-s.stopCh:
+		// The connection was accepted concurrently with Stop.
+		nc.Close()
+	default:
+	}
+	s.connsMu.Unlock()
+	return c
+}
+
+func (s *T) removeConn(c *conn) {
+	s.connsMu.Lock()
+	delete(s.conns, c)
+	s.connsMu.Unlock()
+}
+
+// resolveDestination finds a destination mapping for a STOMP destination. If
+// `withKey` is true then the destination can be suffixed with a routing key
+// that is returned as a message key.
+func (s *T) resolveDestination(name string, withKey bool) (*proxy.T, config.STOMPDestination, []byte, error) {
+	dst, ok := s.destinations[name]
+	var key []byte
+	if !ok && withKey {
+		if i := strings.LastIndex(name, "/"); i > 0 {
+			if dst, ok = s.destinations[name[:i]]; ok {
+				key = []byte(name[i+1:])
+			}
+		}
+	}
+	if !ok {
+		return nil, dst, nil, errors.Errorf("unknown destination: %s", name)
+	}
+	pxy, err := s.proxySet.Get(dst.Cluster)
+	if err != nil {
+		return nil, dst, nil, err
+	}
+	return pxy, dst, key, nil
+}
+
+// inflightKey identifies a message sent to a client and not acknowledged
+// yet.
+type inflightKey struct {
+	pxy       *proxy.T
+	group     string
+	topic     string
+	partition int32
+	offset    int64
+}
+
+type inflightMsg struct {
+	inflightKey
+	ack        proxy.Ack
+	subID      string
+	cumulative bool
+	// The number that the ack ID was made of, ack IDs are issued in order.
+	seq int64
+}
+
+// conn handles a single STOMP client connection.
+type conn struct {
+	srv     *T
+	actDesc *actor.Descriptor
+	nc      net.Conn
+	rd      *bufio.Reader
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+
+	writeMu sync.Mutex
+
+	// Holds a token for every message that is either being consumed with a
+	// client ack mode or is in flight, so that there are never more than
+	// receiveMaximum.
+	inflightSlots chan struct{}
+
+	mu          sync.Mutex
+	nextAckID   int64
+	inflight    map[string]inflightMsg
+	inflightIDs map[inflightKey]string
+	subs        map[string]chan struct{}
+}
+
+func (c *conn) serve() {
+	defer func() {
+		close(c.stopCh)
+		c.nc.Close()
+		c.wg.Wait()
+		c.srv.removeConn(c)
+	}()
+	if err := c.handshake(); err != nil {
+		c.actDesc.Log().WithError(err).Info("Handshake failed")
+		return
+	}
+	for {
+		f, err := readFrame(c.rd, maxFrameSize)
+		if err != nil {
+			select {
+			case <-c.srv.stopCh:
+			default:
+				c.actDesc.Log().WithError(err).Info("Connection closed")
+			}
+			return
+		}
+		switch f.command {
+		case cmdSend:
+			err = c.handleSend(f)
+		case cmdSubscribe:
+			err = c.handleSubscribe(f)
+		case cmdUnsubscribe:
+			err = c.handleUnsubscribe(f)
+		case cmdAck:
+			err = c.handleAck(f)
+		case cmdNack:
+			// Kafka-Pixy has no way to reject a message, it is offered
+			// again when the ack timeout expires.
+			err = c.sendReceipt(f)
+		case cmdDisconnect:
+			c.sendReceipt(f)
+			return
+		default:
+			err = errors.Errorf("unexpected command: %s", f.command)
+		}
+		if err != nil {
+			c.actDesc.Log().WithError(err).Error("Closing connection")
+			c.write(newFrame(cmdError, hdrMessage, err.Error()))
+			return
+		}
+	}
+}
+
+// handshake reads the CONNECT frame and replies with CONNECTED.
+func (c *conn) handshake() error {
+	c.nc.SetReadDeadline(time.Now().Add(connectTimeout))
+	f, err := readFrame(c.rd, maxFrameSize)
+	if err != nil {
+		return err
+	}
+	c.nc.SetReadDeadline(time.Time{})
+	if f.command != cmdConnect && f.command != cmdStomp {
+		return errors.Errorf("expected CONNECT, got %s", f.command)
+	}
+	versions := strings.Split(f.get(hdrAcceptVersion), ",")
+	supported := false
+	for _, v := range versions {
+		if v == protocolVersion {
+			supported = true
+		}
+	}
+	if !supported {
+		c.write(newFrame(cmdError, hdrVersion, protocolVersion, hdrMessage, "supported protocol versions are "+protocolVersion))
+		return errors.Errorf("unsupported protocol versions: %v", versions)
+	}
+	// Heart-beating is not supported, so it is disabled in both directions.
+	return c.write(newFrame(cmdConnected, hdrVersion, protocolVersion, hdrHeartBeat, "0,0"))
+}
+
+func (c *conn) handleSend(f *frame) error {
+	pxy, dst, key, err := c.srv.resolveDestination(f.get(hdrDestination), true)
+	if err != nil {
+		return err
+	}
+	if c.srv.readOnly || pxy.IsReadOnly() {
+		return proxy.ErrReadOnly
+	}
+	var keyEnc sarama.Encoder
+	if key != nil {
+		keyEnc = sarama.ByteEncoder(key)
+	}
+	headers := pxy.WithEnrichment(nil, dst.Topic, nil)
+	if _, ok := f.header(hdrReceipt); !ok {
+		pxy.AsyncProduce(dst.Topic, keyEnc, sarama.ByteEncoder(f.body), headers)
+		return nil
+	}
+	if _, err := pxy.Produce(dst.Topic, keyEnc, sarama.ByteEncoder(f.body), headers); err != nil {
+		return errors.Wrap(err, "failed to produce")
+	}
+	return c.sendReceipt(f)
+}
+
+func (c *conn) handleSubscribe(f *frame) error {
+	subID, ok := f.header(hdrID)
+	if !ok {
+		return errors.New("missing subscription id")
+	}
+	pxy, dst, _, err := c.srv.resolveDestination(f.get(hdrDestination), false)
+	if err != nil {
+		return err
+	}
+	if dst.Group == "" {
+		return errors.Errorf("destination cannot be subscribed to: %s", f.get(hdrDestination))
+	}
+	ackMode, ok := f.header(hdrAck)
+	if !ok {
+		ackMode = ackModeAuto
+	}
+	switch ackMode {
+	case ackModeAuto, ackModeClient, ackModeClientIndividual:
+	default:
+		return errors.Errorf("invalid ack mode: %s", ackMode)
+	}
+	c.mu.Lock()
+	if _, ok := c.subs[subID]; ok {
+		c.mu.Unlock()
+		return errors.Errorf("duplicate subscription id: %s", subID)
+	}
+	subStopCh := make(chan struct{})
+	c.subs[subID] = subStopCh
+	c.mu.Unlock()
+
+	actDesc := c.actDesc.NewChild("sub", dst.Group, dst.Topic)
+	destination := f.get(hdrDestination)
+	actor.Spawn(actDesc, &c.wg, func() {
+		c.runSubscription(actDesc, pxy, subID, destination, dst, ackMode, subStopCh)
+	})
+	return c.sendReceipt(f)
+}
+
+func (c *conn) handleUnsubscribe(f *frame) error {
+	subID := f.get(hdrID)
+	c.mu.Lock()
+	subStopCh, ok := c.subs[subID]
+	delete(c.subs, subID)
+	c.mu.Unlock()
+	if !ok {
+		return errors.Errorf("unknown subscription id: %s", subID)
+	}
+	close(subStopCh)
+	return c.sendReceipt(f)
+}
+
+func (c *conn) handleAck(f *frame) error {
+	acked, err := c.takeAcked(f.get(hdrID))
+	if err != nil {
+		return err
+	}
+	for _, msg := range acked {
+		if err := msg.pxy.Ack(msg.group, msg.topic, msg.ack); err != nil {
+			c.actDesc.Log().WithError(err).Errorf("Failed to ack: topic=%s", msg.topic)
+		}
+	}
+	return c.sendReceipt(f)
+}
+
+// takeAcked removes the message with an ack ID from in-flight ones, and in
+// the `client` mode all messages sent to the subscription before it too, and
+// returns them. An ack ID that was dropped because the message was sent
+// again yields no messages.
+func (c *conn) takeAcked(ackID string) ([]inflightMsg, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg, ok := c.inflight[ackID]
+	if !ok {
+		seq, err := strconv.ParseInt(ackID, 10, 64)
+		if err != nil || seq <= 0 || seq > c.nextAckID {
+			return nil, errors.Errorf("unknown ack id: %s", ackID)
+		}
+		c.actDesc.Log().Warnf("ACK for a message sent again: %s", ackID)
+		return nil, nil
+	}
+	acked := []inflightMsg{msg}
+	c.removeInflight(ackID)
+	if msg.cumulative {
+		for earlierAckID, earlier := range c.inflight {
+			if earlier.subID == msg.subID && earlier.seq < msg.seq {
+				acked = append(acked, earlier)
+				c.removeInflight(earlierAckID)
+			}
+		}
+	}
+	return acked, nil
+}
+
+// runSubscription keeps consuming messages from a Kafka topic and sending
+// them to the client until either the subscription or the connection is
+// terminated. While the client has receiveMaximum messages not acknowledged
+// yet, no more are consumed.
+func (c *conn) runSubscription(actDesc *actor.Descriptor, pxy *proxy.T, subID, destination string,
+	dst config.STOMPDestination, ackMode string, subStopCh <-chan struct{},
+) {
+	autoAck := ackMode == ackModeAuto
+	ack := proxy.NoAck()
+	if autoAck {
+		ack = proxy.AutoAck()
+	}
+	for {
+		if !autoAck {
+			select {
+			case c.inflightSlots <- struct{}{}:
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			}
+		} else {
+			select {
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			default:
+			}
+		}
+		msg, err := pxy.Consume(dst.Group, dst.Topic, ack)
+		if err != nil {
+			if !autoAck {
+				<-c.inflightSlots
+			}
+			if err == consumer.ErrRequestTimeout {
+				continue
+			}
+			actDesc.Log().WithError(err).Warn("Failed to consume")
+			select {
+			case <-time.After(consumeRetryBackoff):
+			case <-subStopCh:
+				return
+			case <-c.stopCh:
+				return
+			}
+			continue
+		}
+		msgID := strconv.Itoa(int(msg.Partition)) + "-" + strconv.FormatInt(msg.Offset, 10)
+		out := newFrame(cmdMessage,
+			hdrSubscription, subID,
+			hdrMessageID, msgID,
+			hdrDestination, destination)
+		if !autoAck {
+			msgAck, _ := proxy.NewAck(msg.Partition, msg.Offset)
+			ackID := c.registerInflight(inflightMsg{
+				inflightKey: inflightKey{pxy, dst.Group, dst.Topic, msg.Partition, msg.Offset},
+				ack:         msgAck,
+				subID:       subID,
+				cumulative:  ackMode == ackModeClient,
+			})
+			out.headers = append(out.headers, [2]string{hdrAck, ackID})
+		}
+		out.body = msg.Value
+		if out.body == nil {
+			out.body = []byte{}
+		}
+		if err := c.write(out); err != nil {
+			actDesc.Log().WithError(err).Warnf("Failed to deliver: message-id=%s", msgID)
+			return
+		}
+	}
+}
+
+// registerInflight assigns an ack ID to a message, whose slot has been taken
+// already. If the message is sent again, because the client has not
+// acknowledged it within the ack timeout, then the entry of the previous
+// delivery is dropped.
+func (c *conn) registerInflight(msg inflightMsg) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ackID, ok := c.inflightIDs[msg.inflightKey]; ok {
+		c.removeInflight(ackID)
+	}
+	c.nextAckID++
+	msg.seq = c.nextAckID
+	ackID := strconv.FormatInt(c.nextAckID, 10)
+	c.inflight[ackID] = msg
+	c.inflightIDs[msg.inflightKey] = ackID
+	return ackID
+}
+
+// removeInflight removes an in-flight message and frees its slot. It must be
+// called with the mutex held.
+func (c *conn) removeInflight(ackID string) {
+	delete(c.inflightIDs, c.inflight[ackID].inflightKey)
+	delete(c.inflight, ackID)
+	<-c.inflightSlots
+}
+
+// sendReceipt sends a RECEIPT frame if the client frame requested one.
+func (c *conn) sendReceipt(f *frame) error {
+	receipt, ok := f.header(hdrReceipt)
+	if !ok {
+		return nil
+	}
+	return c.write(newFrame(cmdReceipt, hdrReceiptID, receipt))
+}
+
+func (c *conn) write(f *frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.nc.Write(f.encode())
+	return err
+}
@@ -0,0 +1,98 @@
+package stompsrv
+
+import (
+	"sort"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+type ConnSuite struct{}
+
+var _ = Suite(&ConnSuite{})
+
+func newTestConn() *conn {
+	return &conn{
+		actDesc:       actor.Root().NewChild("T"),
+		inflight:      make(map[string]inflightMsg),
+		inflightIDs:   make(map[inflightKey]string),
+		inflightSlots: make(chan struct{}, receiveMaximum),
+	}
+}
+
+func (c *conn) testRegister(subID string, offset int64, cumulative bool) string {
+	c.inflightSlots <- struct{}{}
+	return c.registerInflight(inflightMsg{
+		inflightKey: inflightKey{group: "g", topic: "t", offset: offset},
+		subID:       subID,
+		cumulative:  cumulative,
+	})
+}
+
+func offsetsOf(msgs []inflightMsg) []int64 {
+	var offsets []int64
+	for _, msg := range msgs {
+		offsets = append(offsets, msg.offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// In the `client` mode an ACK acknowledges all messages sent to the
+// subscription before the acknowledged one as well.
+func (s *ConnSuite) TestTakeAckedCumulative(c *C) {
+	cn := newTestConn()
+	cn.testRegister("s1", 1, true)
+	cn.testRegister("s2", 2, true)
+	cn.testRegister("s1", 3, true)
+	ackID := cn.testRegister("s1", 4, true)
+	cn.testRegister("s1", 5, true)
+
+	// When
+	acked, err := cn.takeAcked(ackID)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(offsetsOf(acked), DeepEquals, []int64{1, 3, 4})
+	c.Check(len(cn.inflight), Equals, 2)
+	c.Check(len(cn.inflightSlots), Equals, 2)
+}
+
+// In the `client-individual` mode an ACK acknowledges only one message.
+func (s *ConnSuite) TestTakeAckedIndividual(c *C) {
+	cn := newTestConn()
+	cn.testRegister("s1", 1, false)
+	ackID := cn.testRegister("s1", 2, false)
+
+	// When
+	acked, err := cn.takeAcked(ackID)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(offsetsOf(acked), DeepEquals, []int64{2})
+	c.Check(len(cn.inflight), Equals, 1)
+}
+
+// When a message is sent again, the entry of the previous delivery is
+// dropped along with its slot, and an ACK with its ack ID is ignored.
+func (s *ConnSuite) TestRedelivered(c *C) {
+	cn := newTestConn()
+	oldAckID := cn.testRegister("s1", 1, false)
+
+	// When
+	newAckID := cn.testRegister("s1", 1, false)
+
+	// Then
+	c.Check(newAckID, Not(Equals), oldAckID)
+	c.Check(len(cn.inflight), Equals, 1)
+	c.Check(len(cn.inflightSlots), Equals, 1)
+	acked, err := cn.takeAcked(oldAckID)
+	c.Check(err, IsNil)
+	c.Check(acked, HasLen, 0)
+	_, err = cn.takeAcked("3")
+	c.Check(err, ErrorMatches, "unknown ack id: 3")
+	acked, err = cn.takeAcked(newAckID)
+	c.Check(err, IsNil)
+	c.Check(offsetsOf(acked), DeepEquals, []int64{1})
+	c.Check(len(cn.inflightSlots), Equals, 0)
+}
@@ -4,78 +4,213 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/autoprof"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/election"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/hooks"
+	"github.com/mailgun/kafka-pixy/limiter"
 	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/redact"
 	"github.com/mailgun/kafka-pixy/server"
 	"github.com/mailgun/kafka-pixy/server/grpcsrv"
 	"github.com/mailgun/kafka-pixy/server/httpsrv"
+	"github.com/mailgun/kafka-pixy/server/mqttsrv"
+	"github.com/mailgun/kafka-pixy/server/stompsrv"
+	"github.com/mailgun/kafka-pixy/tenancy"
 	"github.com/pkg/errors"
 )
 
 type T struct {
-	actDesc *actor.Descriptor
-	proxies map[string]*proxy.T
-	servers []server.T
-	stopCh  chan struct{}
-	wg      sync.WaitGroup
+	actDesc          *actor.Descriptor
+	cfg              *config.App
+	proxies          map[string]*proxy.T
+	proxySet         *proxy.Set
+	grpcInterceptors []grpcsrv.Interceptor
+	httpMiddleware   []mux.MiddlewareFunc
+	election         *election.T
+	servers          []server.T
+	autoProfile      *autoprof.T
+	hooks            *hooks.T
+	drainOnce        sync.Once
+	stopCh           chan struct{}
+	wg               sync.WaitGroup
 }
 
 func Spawn(cfg *config.App) (*T, error) {
+	grpcInterceptors := make([]grpcsrv.Interceptor, len(cfg.GRPCInterceptors))
+	for i, name := range cfg.GRPCInterceptors {
+		interceptor, ok := grpcsrv.LookupInterceptor(name)
+		if !ok {
+			return nil, errors.Errorf("unknown gRPC interceptor: %s", name)
+		}
+		grpcInterceptors[i] = interceptor
+	}
+	httpMiddleware := make([]mux.MiddlewareFunc, len(cfg.HTTPMiddleware))
+	for i, name := range cfg.HTTPMiddleware {
+		mw, ok := httpsrv.LookupMiddleware(name)
+		if !ok {
+			return nil, errors.Errorf("unknown HTTP middleware: %s", name)
+		}
+		httpMiddleware[i] = mw
+	}
+	if err := redact.Configure(cfg.Redaction); err != nil {
+		return nil, errors.Wrap(err, "failed to configure redaction")
+	}
+	if err := features.Configure(cfg.Features); err != nil {
+		return nil, errors.Wrap(err, "failed to configure features")
+	}
+
 	s := &T{
-		actDesc: actor.Root().NewChild("service"),
-		proxies: make(map[string]*proxy.T, len(cfg.Proxies)),
-		stopCh:  make(chan struct{}),
+		actDesc:          actor.Root().NewChild("service"),
+		cfg:              cfg,
+		proxies:          make(map[string]*proxy.T, len(cfg.Proxies)),
+		grpcInterceptors: grpcInterceptors,
+		httpMiddleware:   httpMiddleware,
+		stopCh:           make(chan struct{}),
+	}
+	s.hooks = hooks.New(s.actDesc, cfg.Hooks)
+	for _, flag := range features.All() {
+		name := flag.Name
+		s.actDesc.ObserveGauge("feature."+name, func() int64 {
+			if features.Enabled(name) {
+				return 1
+			}
+			return 0
+		})
 	}
 
 	for cluster, pxyCfg := range cfg.Proxies {
-		pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg)
+		pxy, err := proxy.Spawn(actor.Root(), cluster, pxyCfg, cfg.DeadLetterProxy(cluster), cfg.ShadowProxies(cluster))
 		if err != nil {
 			s.stopProxies()
 			return nil, errors.Wrapf(err, "failed to spawn proxy, name=%s", cluster)
 		}
 		s.proxies[cluster] = pxy
+		s.hooks.Fire(config.HookClusterConnected, cluster)
+	}
+
+	s.proxySet = proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster], cfg.ClusterAliases)
+	s.proxySet.RouteProduces(cfg.ProduceRouting)
+
+	// A standby does not even listen on API addresses, so that clients and
+	// load balancers see it as down, until it is elected.
+	if cfg.Standby.Election != "" {
+		if !cfg.HasServers() {
+			s.stopProxies()
+			return nil, errors.Errorf("at least one API server should be configured")
+		}
+		var err error
+		if s.election, err = election.Spawn(s.actDesc, cfg.Standby.Election, cfg.StandbyProxy()); err != nil {
+			s.stopProxies()
+			return nil, errors.Wrap(err, "failed to run for leadership")
+		}
+	} else if err := s.spawnServers(); err != nil {
+		s.stopProxies()
+		return nil, err
+	}
+
+	if cfg.AutoProfile.Dir != "" {
+		s.autoProfile = autoprof.Spawn(s.actDesc, cfg.AutoProfile)
 	}
+	actor.Spawn(s.actDesc, &s.wg, s.run)
+	return s, nil
+}
 
-	proxySet := proxy.NewSet(s.proxies, s.proxies[cfg.DefaultCluster])
+// spawnServers creates all configured API servers.
+func (s *T) spawnServers() error {
+	cfg, proxySet := s.cfg, s.proxySet
+	tenants := tenancy.New(cfg.Tenants)
+	// Pools are shared by all listeners, so that the capacity of an endpoint
+	// group does not grow with the number of listeners serving it.
+	pools := server.NewPools(s.actDesc, cfg.ListenerPools)
 
 	if cfg.GRPCAddr != "" {
 		securityOpts, err := cfg.GRPCSecurityOpts()
 		if err != nil {
-			s.stopProxies()
-			return nil, errors.Wrap(err, "failed to configure gRPC security")
+			return errors.Wrap(err, "failed to configure gRPC security")
+		}
+		grpcOpts := []grpcsrv.Option{grpcsrv.WithServerOptions(securityOpts...)}
+		if tenants != nil {
+			grpcOpts = append(grpcOpts, grpcsrv.WithTenancy(tenants))
 		}
-		grpcSrv, err := grpcsrv.New(cfg.GRPCAddr, proxySet, securityOpts...)
+		grpcOpts = append(grpcOpts, grpcsrv.WithAdminAPIKeys(cfg.AdminAPIKeys), grpcsrv.WithDrain(s.drainServers))
+		grpcOpts = append(grpcOpts, grpcsrv.WithInterceptors(s.grpcInterceptors...), grpcsrv.WithPools(pools))
+		grpcSrv, err := grpcsrv.New(cfg.GRPCAddr, proxySet, cfg.IsReadOnly(config.ListenerGRPC), cfg.ListenerEndpoints[config.ListenerGRPC], grpcOpts...)
 		if err != nil {
-			s.stopProxies()
-			return nil, errors.Wrap(err, "failed to start gRPC server")
+			return errors.Wrap(err, "failed to start gRPC server")
 		}
 		s.servers = append(s.servers, grpcSrv)
 	}
+	var httpOpts []httpsrv.Option
+	if cfg.PubSub.Enabled {
+		httpOpts = append(httpOpts, httpsrv.WithPubSub(cfg.PubSub.Subscriptions))
+	}
+	if cfg.UI.Enabled {
+		httpOpts = append(httpOpts, httpsrv.WithUI())
+	}
+	if cfg.HTTPCompression.Enabled {
+		httpOpts = append(httpOpts, httpsrv.WithCompression(cfg.HTTPCompression.MinSize))
+	}
+	if tenants != nil {
+		httpOpts = append(httpOpts, httpsrv.WithTenancy(tenants))
+	}
+	// The produce buffer is shared by all listeners too, for it bounds the
+	// memory that produce request bodies take.
+	produceBuffer := limiter.NewBudget(cfg.HTTPProduce.BufferSize)
+	httpOpts = append(httpOpts, httpsrv.WithProduceLimits(cfg.HTTPProduce.MaxBodySize, produceBuffer, cfg.HTTPProduce.BufferTimeout))
+	httpOpts = append(httpOpts, httpsrv.WithMiddleware(s.httpMiddleware...), httpsrv.WithPools(pools))
 	if cfg.TCPAddr != "" {
-		tcpSrv, err := httpsrv.New(cfg.TCPAddr, proxySet, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		tcpOpts := httpOpts
+		if cfg.IsReadOnly(config.ListenerTCP) {
+			tcpOpts = append(tcpOpts, httpsrv.WithReadOnly())
+		}
+		if groups := cfg.ListenerEndpoints[config.ListenerTCP]; groups != nil {
+			tcpOpts = append(tcpOpts, httpsrv.WithEndpointGroups(groups))
+		}
+		tcpSrv, err := httpsrv.New(cfg.TCPAddr, proxySet, cfg.TLS.CertPath, cfg.TLS.KeyPath, tcpOpts...)
 		if err != nil {
-			s.stopProxies()
-			return nil, errors.Wrap(err, "failed to start TCP socket based HTTP API server")
+			return errors.Wrap(err, "failed to start TCP socket based HTTP API server")
 		}
 		s.servers = append(s.servers, tcpSrv)
 	}
 	if cfg.UnixAddr != "" {
-		unixSrv, err := httpsrv.New(cfg.UnixAddr, proxySet, "", "")
+		unixOpts := httpOpts
+		if cfg.IsReadOnly(config.ListenerUnix) {
+			unixOpts = append(unixOpts, httpsrv.WithReadOnly())
+		}
+		if groups := cfg.ListenerEndpoints[config.ListenerUnix]; groups != nil {
+			unixOpts = append(unixOpts, httpsrv.WithEndpointGroups(groups))
+		}
+		unixSrv, err := httpsrv.New(cfg.UnixAddr, proxySet, "", "", unixOpts...)
 		if err != nil {
-			s.stopProxies()
-			return nil, errors.Wrapf(err, "failed to start Unix socket based HTTP API server")
+			return errors.Wrapf(err, "failed to start Unix socket based HTTP API server")
 		}
 		s.servers = append(s.servers, unixSrv)
 	}
+	if cfg.MQTTAddr != "" {
+		mqttSrv, err := mqttsrv.New(cfg.MQTTAddr, proxySet, cfg.IsReadOnly(config.ListenerMQTT))
+		if err != nil {
+			return errors.Wrap(err, "failed to start MQTT server")
+		}
+		s.servers = append(s.servers, mqttSrv)
+	}
+	if cfg.STOMPAddr != "" {
+		stompSrv, err := stompsrv.New(cfg.STOMPAddr, proxySet, cfg.STOMPDestinations, cfg.IsReadOnly(config.ListenerSTOMP))
+		if err != nil {
+			return errors.Wrap(err, "failed to start STOMP server")
+		}
+		s.servers = append(s.servers, stompSrv)
+	}
 
 	if len(s.servers) == 0 {
-		return nil, errors.Errorf("at least one API server should be configured")
+		return errors.Errorf("at least one API server should be configured")
 	}
-
-	actor.Spawn(s.actDesc, &s.wg, s.run)
-	return s, nil
+	return nil
 }
 
 func (s *T) Stop() {
@@ -85,9 +220,24 @@ func (s *T) Stop() {
 
 // run implements main supervisor loop, that boils down to starting all
 // configured API servers, waiting for a stop signal and terminating everything
-// gracefully.
+// gracefully. A standby starts API servers when it is elected, and shuts down
+// if it loses the leadership, lest two instances serve at once.
 func (s *T) run() {
-	selectCases := make([]reflect.SelectCase, len(s.servers)+1)
+	defer s.hooks.Fire(config.HookShutdownComplete, "")
+	if s.autoProfile != nil {
+		defer s.autoProfile.Stop()
+	}
+	var lostCh <-chan struct{}
+	if s.election != nil {
+		defer s.election.Stop()
+		if !s.standBy() {
+			s.stopProxies()
+			return
+		}
+		lostCh = s.election.Lost()
+	}
+
+	selectCases := make([]reflect.SelectCase, len(s.servers)+2)
 	for i, srv := range s.servers {
 		srv.Start()
 		selectCases[i] = reflect.SelectCase{
@@ -99,6 +249,12 @@ func (s *T) run() {
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(s.stopCh),
 	}
+	selectCases[len(s.servers)+1] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(lostCh),
+	}
+
+	s.hooks.Fire(config.HookStarted, "")
 
 	// Block until either an server error is reported or a Stop is called.
 	chosen, val, ok := reflect.Select(selectCases)
@@ -106,8 +262,12 @@ func (s *T) run() {
 		serverErr := val.Interface().(error)
 		s.actDesc.Log().WithError(serverErr).Error("API server crashed")
 	}
+	if chosen == len(s.servers)+1 {
+		s.actDesc.Log().Error("Leadership lost")
+	}
 
 	s.actDesc.Log().Info("Shutting down")
+	s.drain()
 
 	// Stop all proxies first. It is important to keep API servers running
 	// so that offered messages can be acknowledged by consumers.
@@ -123,6 +283,47 @@ func (s *T) run() {
 	s.actDesc.Log().Info("All API servers shutdown")
 }
 
+// standBy waits until the instance is elected and starts API servers. It
+// returns false if the service is stopped first, or servers fail to start.
+func (s *T) standBy() bool {
+	s.actDesc.Log().Infof("Standing by: election=%s", s.cfg.Standby.Election)
+	select {
+	case <-s.election.Elected():
+	case <-s.stopCh:
+		return false
+	}
+	s.actDesc.Log().Info("Elected, starting API servers")
+	if err := s.spawnServers(); err != nil {
+		s.actDesc.Log().WithError(err).Error("Failed to start API servers")
+		return false
+	}
+	return true
+}
+
+// drain reports all API servers that support it as not serving, and gives
+// load balancers and service meshes the configured drain period to steer
+// clients to other instances before proxies are stopped.
+func (s *T) drain() {
+	if s.cfg.DrainPeriod <= 0 {
+		return
+	}
+	s.drainServers()
+	s.actDesc.Log().Infof("Draining: period=%v", s.cfg.DrainPeriod)
+	time.Sleep(s.cfg.DrainPeriod)
+}
+
+// drainServers reports all API servers that support it as not serving, and
+// fires drain hooks the first time around. It is also called when the
+// instance is drained via the KafkaPixyAdmin service.
+func (s *T) drainServers() {
+	for _, srv := range s.servers {
+		if drainer, ok := srv.(server.Drainer); ok {
+			drainer.Drain()
+		}
+	}
+	s.drainOnce.Do(func() { s.hooks.Fire(config.HookDrainStarted, "") })
+}
+
 func (s *T) stopProxies() {
 	var wg sync.WaitGroup
 	for pxyAlias, pxy := range s.proxies {
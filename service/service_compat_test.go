@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/mailgun/kafka-pixy/testhelpers/compat"
+	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
+	"google.golang.org/grpc"
+	. "gopkg.in/check.v1"
+)
+
+// ServiceCompatSuite replays golden fixtures from testdata/compat against
+// both HTTP and gRPC APIs. If a fixture fails, then a change broke wire
+// compatibility with existing clients. Fixtures should only be changed for
+// deliberately breaking API versions.
+type ServiceCompatSuite struct {
+	cfg *config.App
+	kh  *kafkahelper.T
+}
+
+var _ = Suite(&ServiceCompatSuite{})
+
+func (s *ServiceCompatSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ServiceCompatSuite) SetUpTest(c *C) {
+	s.cfg = &config.App{Proxies: make(map[string]*config.Proxy)}
+	s.cfg.GRPCAddr = "127.0.0.1:19091"
+	s.cfg.UnixAddr = path.Join(os.TempDir(), "kafka-pixy.sock")
+	s.cfg.Proxies["pxyC"] = testhelpers.NewTestProxyCfg("pxyC_client_id")
+	s.cfg.DefaultCluster = "pxyC"
+	os.Remove(s.cfg.UnixAddr)
+	s.kh = kafkahelper.New(c)
+}
+
+func (s *ServiceCompatSuite) TearDownTest(c *C) {
+	s.kh.Close()
+}
+
+func (s *ServiceCompatSuite) TestFixtures(c *C) {
+	fixtures, err := compat.Load("../testdata/compat")
+	c.Assert(err, IsNil)
+	s.kh.ResetOffsets("compat", "test.4")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	unixClient := testhelpers.NewUDSHTTPClient(s.cfg.UnixAddr)
+	conn, err := grpc.Dial(s.cfg.GRPCAddr, grpc.WithInsecure())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	for _, f := range fixtures {
+		// When
+		if f.HTTP != nil {
+			err = compat.ReplayHTTP(unixClient, "http://_", f.HTTP)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = compat.ReplayGRPC(ctx, conn, f.GRPC)
+			cancel()
+		}
+
+		// Then
+		c.Check(err, IsNil, Commentf("%s: %s", f.Name, f.Description))
+	}
+}
@@ -8,13 +8,17 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/clientstats"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/server/grpcsrv"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
 	"github.com/samuel/go-zookeeper/zk"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	. "gopkg.in/check.v1"
 )
@@ -29,6 +33,17 @@ type ServiceGRPCSuite struct {
 
 var _ = Suite(&ServiceGRPCSuite{})
 
+func init() {
+	grpcsrv.RegisterInterceptor("test_reject_produce", grpcsrv.Interceptor{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if info.FullMethod == "/KafkaPixy/Produce" {
+				return nil, status.Error(codes.Aborted, "rejected by test")
+			}
+			return handler(ctx, req)
+		},
+	})
+}
+
 func (s *ServiceGRPCSuite) SetUpSuite(c *C) {
 	testhelpers.InitLogging()
 }
@@ -209,6 +224,360 @@ func (s *ServiceGRPCSuite) TestProduceInvalidProxy(c *C) {
 	c.Check(res, IsNil)
 }
 
+// The ID of a request is returned in the response header metadata.
+func (s *ServiceGRPCSuite) TestRequestID(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-42")
+
+	// When
+	var header metadata.MD
+	_, err = s.clt.ListTopics(ctx, &pb.ListTopicRq{}, grpc.Header(&header))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(header.Get("x-request-id"), DeepEquals, []string{"req-42"})
+}
+
+// Requests are counted by the client library given in the request metadata.
+func (s *ServiceGRPCSuite) TestClientStats(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-client-name", "grpc-client-stats-test", "x-client-version", "1.2.3")
+
+	// When
+	_, err = s.clt.ListTopics(ctx, &pb.ListTopicRq{})
+
+	// Then
+	c.Assert(err, IsNil)
+	var requests map[string]int64
+	for _, client := range clientstats.Report() {
+		if client.Name == "grpc-client-stats-test" && client.Version == "1.2.3" {
+			requests = client.Requests
+		}
+	}
+	c.Check(requests, DeepEquals, map[string]int64{"grpc:ListTopics": 1})
+}
+
+// The standard gRPC health service reports the server as serving.
+func (s *ServiceGRPCSuite) TestHealthCheck(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+	healthClt := healthpb.NewHealthClient(s.cltConn)
+
+	for i, service := range []string{"", "KafkaPixy"} {
+		// When
+		rs, err := healthClt.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(rs.Status, Equals, healthpb.HealthCheckResponse_SERVING, Commentf("case #%d", i))
+	}
+}
+
+// While the service drains on shutdown the health service reports the server
+// as not serving, but requests are still served.
+func (s *ServiceGRPCSuite) TestHealthCheckDraining(c *C) {
+	s.cfg.DrainPeriod = time.Second
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	s.waitSvcUp(c, 5*time.Second)
+	healthClt := healthpb.NewHealthClient(s.cltConn)
+	stoppedCh := make(chan struct{})
+
+	// When
+	go func() {
+		svc.Stop()
+		close(stoppedCh)
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	// Then
+	for i, service := range []string{"", "KafkaPixy"} {
+		rs, err := healthClt.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(rs.Status, Equals, healthpb.HealthCheckResponse_NOT_SERVING, Commentf("case #%d", i))
+	}
+	_, err = s.clt.ListTopics(context.Background(), &pb.ListTopicRq{})
+	c.Check(err, IsNil)
+	<-stoppedCh
+}
+
+// The admin service reports the health of the instance and its clusters.
+func (s *ServiceGRPCSuite) TestAdminGetHealth(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+	adminClt := pb.NewKafkaPixyAdminClient(s.cltConn)
+
+	// When
+	rs, err := adminClt.GetHealth(context.Background(), &pb.GetHealthRq{})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(rs.Serving, Equals, true)
+	c.Assert(len(rs.Clusters), Equals, 1)
+	c.Check(rs.Clusters[0].Cluster, Equals, "pxyG")
+	c.Check(rs.Clusters[0].KafkaVersion, Equals, s.proxyCfg.Kafka.Version.String())
+	c.Check(rs.Clusters[0].Throttled, Equals, false)
+}
+
+// When the instance is drained via the admin service, health checks report
+// it as not serving, but requests are still served.
+func (s *ServiceGRPCSuite) TestAdminDrain(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+	adminClt := pb.NewKafkaPixyAdminClient(s.cltConn)
+	healthClt := healthpb.NewHealthClient(s.cltConn)
+
+	// When
+	_, err = adminClt.Drain(context.Background(), &pb.DrainRq{})
+
+	// Then
+	c.Assert(err, IsNil)
+	healthRs, err := healthClt.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "KafkaPixy"})
+	c.Assert(err, IsNil)
+	c.Check(healthRs.Status, Equals, healthpb.HealthCheckResponse_NOT_SERVING)
+	adminHealthRs, err := adminClt.GetHealth(context.Background(), &pb.GetHealthRq{})
+	c.Assert(err, IsNil)
+	c.Check(adminHealthRs.Serving, Equals, false)
+	topicsRs, err := adminClt.ListTopics(context.Background(), &pb.ListTopicRq{})
+	c.Assert(err, IsNil)
+	c.Check(topicsRs.Topics["test.4"], NotNil)
+}
+
+// If tenants are configured, then the admin service only accepts admin API
+// keys, and the KafkaPixy service does not accept them.
+func (s *ServiceGRPCSuite) TestAdminAuthorization(c *C) {
+	s.cfg.Tenants = map[string]config.Tenant{"acme": {Prefix: "test.", APIKeys: []string{"tenant-key"}}}
+	s.cfg.AdminAPIKeys = []string{"admin-key"}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	adminClt := pb.NewKafkaPixyAdminClient(s.cltConn)
+
+	for i, tc := range []struct {
+		apiKey    string
+		adminCode codes.Code
+		code      codes.Code
+	}{
+		{apiKey: "", adminCode: codes.Unauthenticated, code: codes.Unauthenticated},
+		{apiKey: "tenant-key", adminCode: codes.Unauthenticated, code: codes.OK},
+		{apiKey: "Bearer admin-key", adminCode: codes.OK, code: codes.Unauthenticated},
+	} {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", tc.apiKey))
+
+		// When
+		_, adminErr := adminClt.GetTopicMetadata(ctx, &pb.GetTopicMetadataRq{Topic: "test.4"}, grpc.FailFast(false))
+		_, err := s.clt.GetTopicMetadata(ctx, &pb.GetTopicMetadataRq{Topic: "4"}, grpc.FailFast(false))
+
+		// Then
+		c.Check(status.Code(adminErr), Equals, tc.adminCode, Commentf("case #%d", i))
+		c.Check(status.Code(err), Equals, tc.code, Commentf("case #%d", i))
+	}
+}
+
+// Requests go through configured interceptors.
+func (s *ServiceGRPCSuite) TestInterceptors(c *C) {
+	s.cfg.GRPCInterceptors = []string{grpcsrv.InterceptorLogging, "test_reject_produce"}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	req := pb.ProdRq{
+		Topic:   "test.4",
+		Message: []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	grpcStatus, ok := status.FromError(err)
+	c.Check(ok, Equals, true)
+	c.Check(grpcStatus.Message(), Equals, "rejected by test")
+	c.Check(grpcStatus.Code(), Equals, codes.Aborted)
+	c.Check(res, IsNil)
+}
+
+// A cluster can be selected by the x-kafka-cluster metadata.
+func (s *ServiceGRPCSuite) TestProduceClusterMetadata(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-kafka-cluster", "invalid")
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+		Message:  []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	grpcStatus, ok := status.FromError(err)
+	c.Check(ok, Equals, true)
+	c.Check(grpcStatus.Message(), Equals, "proxy `invalid` does not exist")
+	c.Check(grpcStatus.Code(), Equals, codes.InvalidArgument)
+	c.Check(res, IsNil)
+}
+
+// A message can be produced strictly ordered by the x-kafka-strict-ordering
+// metadata.
+func (s *ServiceGRPCSuite) TestProduceStrictOrdering(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-kafka-strict-ordering", "true")
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+		Message:  []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(*res, Equals, pb.ProdRs{Partition: 2, Offset: offsetsBefore[2]})
+}
+
+func (s *ServiceGRPCSuite) TestProduceStrictOrderingInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-kafka-strict-ordering", "maybe")
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+		Message:  []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	grpcStatus, ok := status.FromError(err)
+	c.Check(ok, Equals, true)
+	c.Check(grpcStatus.Message(), Equals, "bad x-kafka-strict-ordering: maybe")
+	c.Check(grpcStatus.Code(), Equals, codes.InvalidArgument)
+	c.Check(res, IsNil)
+}
+
+// A tombstone can be produced by the x-kafka-tombstone metadata.
+func (s *ServiceGRPCSuite) TestProduceTombstone(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-kafka-tombstone", "true")
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(*res, Equals, pb.ProdRs{Partition: 2, Offset: offsetsBefore[2]})
+}
+
+// A tombstone with a message is rejected, for the message would be lost.
+func (s *ServiceGRPCSuite) TestProduceTombstoneWithMessage(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-kafka-tombstone", "true")
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+		Message:  []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	grpcStatus, ok := status.FromError(err)
+	c.Check(ok, Equals, true)
+	c.Check(grpcStatus.Message(), Equals, "tombstone must not have a message")
+	c.Check(grpcStatus.Code(), Equals, codes.InvalidArgument)
+	c.Check(res, IsNil)
+}
+
+// Requests of endpoint groups that are not enabled for the listener are
+// rejected.
+func (s *ServiceGRPCSuite) TestProduceEndpointsDisabled(c *C) {
+	s.cfg.ListenerEndpoints = map[string][]string{config.ListenerGRPC: {config.EndpointsConsume, config.EndpointsOffsets}}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	req := pb.ProdRq{
+		Topic:    "test.4",
+		KeyValue: []byte("bar"),
+		Message:  []byte("msg"),
+	}
+	res, err := s.clt.Produce(ctx, &req, grpc.FailFast(false))
+
+	// Then
+	grpcStatus, ok := status.FromError(err)
+	c.Check(ok, Equals, true)
+	c.Check(grpcStatus.Message(), Equals, "endpoint group disabled: produce")
+	c.Check(grpcStatus.Code(), Equals, codes.Unimplemented)
+	c.Check(res, IsNil)
+}
+
 func (s *ServiceGRPCSuite) TestProduceHeadersUnsupported(c *C) {
 	if s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
 		c.Skip("Headers are supported on new Kafka")
@@ -279,6 +648,30 @@ func (s *ServiceGRPCSuite) TestConsumeAutoAck(c *C) {
 	assertMsgs(c, consumed, produced)
 }
 
+// The group member that served a message and the group generation are
+// returned in the response header metadata.
+func (s *ServiceGRPCSuite) TestConsumeMemberMetadata(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("member-metadata", "test.4", map[string]int{"A": 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	var header metadata.MD
+	_, err = s.clt.ConsumeNAck(ctx, &pb.ConsNAckRq{Topic: "test.4", Group: "foo", AutoAck: true}, grpc.Header(&header))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(header.Get("x-kafka-member-id"), DeepEquals, []string{"pxyG_client_id"})
+	c.Check(header.Get("x-kafka-generation"), HasLen, 1)
+}
+
 // If message is consumed with noAck but is not explicitly acknowledged, then
 // its offset is not committed.
 func (s *ServiceGRPCSuite) TestConsumeNoAck(c *C) {
@@ -411,6 +804,81 @@ func (s *ServiceGRPCSuite) TestConsumeExplicitAck(c *C) {
 	assertMsgs(c, consumed, produced)
 }
 
+// Messages consumed with no ack can be acknowledged in batches.
+func (s *ServiceGRPCSuite) TestConsumeAckBatch(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	s.waitSvcUp(c, 5*time.Second)
+
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("ack-batch", "test.4", map[string]int{"A": 17, "B": 19, "C": 23, "D": 29})
+	consumed := make(map[string][]*pb.ConsRs)
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	ackBatchReq := pb.AckBatchRq{Topic: "test.4", Group: "foo"}
+	for i := 0; i < 88; i++ {
+		res, err := s.clt.ConsumeNAck(ctx, &pb.ConsNAckRq{Topic: "test.4", Group: "foo", NoAck: true})
+		c.Check(err, IsNil, Commentf("failed to consume message #%d", i))
+		key := string(res.KeyValue)
+		consumed[key] = append(consumed[key], res)
+		ackBatchReq.Acks = append(ackBatchReq.Acks, &pb.AckOffset{Partition: res.Partition, Offset: res.Offset})
+		if len(ackBatchReq.Acks) == 11 {
+			_, err = s.clt.AckBatch(ctx, &ackBatchReq)
+			c.Check(err, IsNil, Commentf("failed to ack batch #%d", i/11))
+			ackBatchReq.Acks = nil
+		}
+	}
+	svc.Stop()
+
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.4")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+17)
+	c.Check(offsetsAfter[1].Val, Equals, offsetsBefore[1].Val+29)
+	c.Check(offsetsAfter[2].Val, Equals, offsetsBefore[2].Val+23)
+	c.Check(offsetsAfter[3].Val, Equals, offsetsBefore[3].Val+19)
+
+	assertMsgs(c, consumed, produced)
+}
+
+// If some acks of a batch fail, the others are still applied, and the
+// response tells which ones failed.
+func (s *ServiceGRPCSuite) TestAckBatchPartialFailure(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	s.waitSvcUp(c, 5*time.Second)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("ack-batch-partial", "test.1", map[string]int{"A": 1})
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := s.clt.ConsumeNAck(ctx, &pb.ConsNAckRq{Topic: "test.1", Group: "foo", NoAck: true})
+	c.Assert(err, IsNil)
+
+	// When
+	ackBatchRs, err := s.clt.AckBatch(ctx, &pb.AckBatchRq{Topic: "test.1", Group: "foo", Acks: []*pb.AckOffset{
+		{Partition: 42, Offset: 1},
+		{Partition: res.Partition, Offset: res.Offset},
+		{Partition: res.Partition, Offset: res.Offset},
+	}})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(ackBatchRs.Results, DeepEquals, []*pb.AckResult{
+		{Partition: 42, Offset: 1, Error: "acks channel missing for {foo test.1 42}"},
+		{Partition: res.Partition, Offset: res.Offset},
+		{Partition: res.Partition, Offset: res.Offset},
+	})
+	svc.Stop()
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+1)
+}
+
 func (s *ServiceGRPCSuite) TestConsumeExplicitProxy(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
@@ -547,6 +1015,40 @@ func (s *ServiceGRPCSuite) TestConsumeInvalidProxy(c *C) {
 	c.Check(consRes, IsNil)
 }
 
+// Failed requests are accompanied with error details that classify the error
+// and tell whether a request can be retried.
+func (s *ServiceGRPCSuite) TestErrorDetails(c *C) {
+	s.cfg.Proxies[s.cfg.DefaultCluster].Consumer.LongPollingTimeout = 100 * time.Millisecond
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.waitSvcUp(c, 5*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	_, err1 := s.clt.ConsumeNAck(ctx, &pb.ConsNAckRq{
+		Topic: fmt.Sprintf("non-existent-%d", rand.Int()),
+		Group: "foo",
+	}, grpc.FailFast(false))
+	_, err2 := s.clt.ConsumeNAck(ctx, &pb.ConsNAckRq{
+		Cluster: "invalid",
+		Topic:   "test.1",
+		Group:   "foo",
+	}, grpc.FailFast(false))
+
+	// Then
+	c.Check(status.Code(err1), Equals, codes.NotFound)
+	c.Check(status.Convert(err1).Details(), DeepEquals, []interface{}{
+		&pb.ErrorDetails{Code: "timeout", Retryable: true},
+	})
+	c.Check(status.Code(err2), Equals, codes.InvalidArgument)
+	c.Check(status.Convert(err2).Details(), DeepEquals, []interface{}{
+		&pb.ErrorDetails{Code: "invalid_argument", Retryable: false},
+	})
+}
+
 func (s *ServiceGRPCSuite) TestConsumeDisabled(c *C) {
 	s.proxyCfg.Consumer.Disabled = true
 	svc, err := Spawn(s.cfg)
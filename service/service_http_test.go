@@ -1,7 +1,9 @@
 package service
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,8 +11,11 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,11 +24,20 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/codec"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/features"
+	"github.com/mailgun/kafka-pixy/gctune"
 	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/lagslo"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/proxy"
+	"github.com/mailgun/kafka-pixy/receipt"
+	"github.com/mailgun/kafka-pixy/server"
 	"github.com/mailgun/kafka-pixy/server/httpsrv"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
+	"github.com/mailgun/kafka-pixy/validator"
 	"github.com/pkg/errors"
 	. "gopkg.in/check.v1"
 )
@@ -39,6 +53,15 @@ type ServiceHTTPSuite struct {
 
 var _ = Suite(&ServiceHTTPSuite{})
 
+func init() {
+	httpsrv.RegisterMiddleware("test_stamp", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Test-Stamp", "1")
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
 func (s *ServiceHTTPSuite) SetUpSuite(c *C) {
 	testhelpers.InitLogging()
 }
@@ -132,6 +155,30 @@ func (s *ServiceHTTPSuite) TestProduce(c *C) {
 	c.Check(offsetsAfter[3], Equals, offsetsBefore[3]+10)
 }
 
+// If a produced message has `partitionKey`, then it is submitted to the same
+// partition as a message with that key would be, regardless of its key.
+func (s *ServiceHTTPSuite) TestProducePartitionKey(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	for i := 0; i < 10; i++ {
+		for partitionKey := 1; partitionKey <= 5; partitionKey++ {
+			s.unixClient.Post(fmt.Sprintf("http://_/topics/test.4/messages?key=%d&partitionKey=%d", i, partitionKey),
+				"text/plain", strings.NewReader(strconv.Itoa(i)))
+		}
+	}
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+
+	// Then
+	c.Check(offsetsAfter[0], Equals, offsetsBefore[0]+20)
+	c.Check(offsetsAfter[1], Equals, offsetsBefore[1]+10)
+	c.Check(offsetsAfter[2], Equals, offsetsBefore[2]+10)
+	c.Check(offsetsAfter[3], Equals, offsetsBefore[3]+10)
+}
+
 // If `key` of a produced message is `nil` then it is submitted to a random
 // partition. Therefore a batch of such messages is evenly distributed among
 // all available partitions.
@@ -196,6 +243,94 @@ func (s *ServiceHTTPSuite) TestUtf8Message(c *C) {
 		[][]string{[]string(nil), {"Превед Медвед"}, []string(nil), []string(nil)})
 }
 
+// A body of unknown size, sent with chunked transfer encoding, is produced
+// as a single message.
+func (s *ServiceHTTPSuite) TestProduceChunkedBody(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	msg := strings.Repeat("0123456789", 20000)
+
+	// When
+	req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?key=foo&sync",
+		struct{ io.Reader }{strings.NewReader(msg)})
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "text/plain")
+	r, err := s.unixClient.Do(req)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(req.ContentLength, Equals, int64(0))
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.1")
+	msgs := s.kh.GetMessages("test.1", offsetsBefore, offsetsAfter)
+	c.Check(msgs, DeepEquals, [][]string{{msg}})
+}
+
+// Bodies larger than allowed are rejected, whether their size is known in
+// advance or not.
+func (s *ServiceHTTPSuite) TestProduceBodyTooLarge(c *C) {
+	s.cfg.HTTPProduce.MaxBodySize = 100
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		body    io.Reader
+		errText string
+	}{{
+		body:    strings.NewReader(strings.Repeat("a", 101)),
+		errText: "size=101, max=100: message is too large",
+	}, {
+		body:    struct{ io.Reader }{strings.NewReader(strings.Repeat("a", 101))},
+		errText: "max=100: message is too large",
+	}} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages/_validate", "text/plain", tc.body)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusRequestEntityTooLarge, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+			"code":      "invalid_argument",
+			"error":     tc.errText,
+			"retryable": false,
+		}, Commentf("case #%d", i))
+	}
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages/_validate", "text/plain",
+		struct{ io.Reader }{strings.NewReader(strings.Repeat("a", 100))})
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+}
+
+// Bodies that do not fit into the produce buffer are rejected when it is
+// full for longer than the buffer timeout.
+func (s *ServiceHTTPSuite) TestProduceBufferFull(c *C) {
+	s.cfg.HTTPProduce.BufferSize = 50
+	s.cfg.HTTPProduce.BufferTimeout = 50 * time.Millisecond
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages/_validate", "text/plain",
+		strings.NewReader(strings.Repeat("a", 60)))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusTooManyRequests)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"code":      "resource_exhausted",
+		"error":     "size=60: produce buffer is full",
+		"retryable": true,
+	})
+	r, err = s.unixClient.Post("http://_/topics/test.1/messages/_validate", "text/plain",
+		strings.NewReader(strings.Repeat("a", 50)))
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+}
+
 // Headers are submitted without a problem
 func (s *ServiceHTTPSuite) TestProduceHeaders(c *C) {
 	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
@@ -223,6 +358,66 @@ func (s *ServiceHTTPSuite) TestProduceHeaders(c *C) {
 	c.Check(offsetsAfter[0], Equals, offsetsBefore[0]+1)
 }
 
+// A receipt of an asynchronously produced message is posted to the callback
+// URL given in the request.
+func (s *ServiceHTTPSuite) TestProduceCallback(c *C) {
+	receiptsCh := make(chan receipt.Receipt, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rcpt receipt.Receipt
+		c.Check(json.NewDecoder(r.Body).Decode(&rcpt), IsNil)
+		receiptsCh <- rcpt
+	}))
+	defer callback.Close()
+	s.proxyCfg.Producer.CallbackURLWhitelist = []string{"http://127\\.0\\.0\\.1:[0-9]+/.*"}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?key=foo&callback="+
+		url.QueryEscape(callback.URL+"/receipts"), strings.NewReader("bar"))
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Request-ID", "req-1")
+	r, err := s.unixClient.Do(req)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	select {
+	case rcpt := <-receiptsCh:
+		c.Check(rcpt, DeepEquals, receipt.Receipt{
+			RequestID: "req-1",
+			Partition: 0,
+			Offset:    offsetsBefore[0],
+		})
+	case <-time.After(5 * time.Second):
+		c.Error("receipt not received")
+	}
+}
+
+// Asynchronous produce requests with a callback URL that is not whitelisted
+// are rejected.
+func (s *ServiceHTTPSuite) TestProduceCallbackNotAllowed(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?callback="+url.QueryEscape("http://example.com"),
+		"text/plain", strings.NewReader("bar"))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusForbidden)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrCallbackNotAllowed.Error(),
+		"code":      "forbidden",
+		"retryable": false,
+	})
+}
+
 // Invalid base64-encoded headers should produce an error
 func (s *ServiceHTTPSuite) TestProduceInvalidHeaders(c *C) {
 	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
@@ -417,6 +612,49 @@ func (s *ServiceHTTPSuite) TestSyncProduce(c *C) {
 	c.Check(offsetsAfter[0], Equals, offsetsBefore[0]+1)
 }
 
+// A tombstone is produced with the given key and a null value.
+func (s *ServiceHTTPSuite) TestProduceTombstone(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	req, err := http.NewRequest("DELETE", "http://_/topics/test.4/messages?key=1&sync", nil)
+	c.Assert(err, IsNil)
+	r, err := s.unixClient.Do(req)
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(int(body["partition"].(float64)), Equals, 0)
+	c.Check(int64(body["offset"].(float64)), Equals, offsetsBefore[0])
+	c.Check(offsetsAfter[0], Equals, offsetsBefore[0]+1)
+}
+
+// A tombstone without a key would delete nothing, so it is rejected.
+func (s *ServiceHTTPSuite) TestProduceTombstoneNoKey(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	req, err := http.NewRequest("DELETE", "http://_/topics/test.4/messages?sync", nil)
+	c.Assert(err, IsNil)
+	r, err := s.unixClient.Do(req)
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "key is required for a tombstone")
+	c.Check(offsetsAfter, DeepEquals, offsetsBefore)
+}
+
 func (s *ServiceHTTPSuite) TestSyncProduceInvalidTopic(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
@@ -430,7 +668,121 @@ func (s *ServiceHTTPSuite) TestSyncProduceInvalidTopic(c *C) {
 	c.Check(err, IsNil)
 	c.Check(r.StatusCode, Equals, http.StatusNotFound)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, sarama.ErrUnknownTopicOrPartition.Error())
+	c.Check(body["error"], Equals, proxy.ErrUnknownTopic.Error())
+}
+
+// Strictly ordered messages are written in the order they were submitted,
+// and they are accounted for in the producer stats.
+func (s *ServiceHTTPSuite) TestProduceStrictOrdering(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	for i := 0; i < 9; i++ {
+		r, err := s.unixClient.Post("http://_/topics/test.4/messages?key=1&strictOrdering",
+			"text/plain", strings.NewReader(strconv.Itoa(i)))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+	}
+	r, err := s.unixClient.Post("http://_/topics/test.4/messages?key=1&strictOrdering&sync",
+		"text/plain", strings.NewReader("9"))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(int64(body["offset"].(float64)), Equals, offsetsBefore[0]+9)
+
+	r, err = s.unixClient.Post("http://_/_flush?timeout=10s", "text/plain", nil)
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body = ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["flushed"], Equals, true)
+	c.Check(body["stats"].(map[string]interface{})["succeeded_msgs"], Equals, float64(10))
+
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+	messages := s.kh.GetMessages("test.4", offsetsBefore, offsetsAfter)
+	c.Check(messages[0], DeepEquals, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"})
+}
+
+// A validated message is not produced.
+func (s *ServiceHTTPSuite) TestValidateProduce(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages/_validate",
+		"text/plain", strings.NewReader("Foo"))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{})
+	svc.Stop() // Have to stop before getOffsets
+	c.Check(s.kh.GetNewestOffsets("test.1"), DeepEquals, offsetsBefore)
+}
+
+func (s *ServiceHTTPSuite) TestValidateProduceInvalidTopic(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/no-such-topic/messages/_validate",
+		"text/plain", strings.NewReader("Foo"))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, proxy.ErrUnknownTopic.Error())
+}
+
+// Messages of a topic with a validation webhook are produced only if the
+// validator accepts them, otherwise the reason of the rejection is returned.
+func (s *ServiceHTTPSuite) TestProduceValidation(c *C) {
+	validatorSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var candidate validator.Candidate
+		c.Check(json.NewDecoder(r.Body).Decode(&candidate), IsNil)
+		if string(candidate.Value) != "good" {
+			http.Error(w, "value must be good", http.StatusBadRequest)
+		}
+	}))
+	defer validatorSrv.Close()
+	s.proxyCfg.ProduceValidation = map[string]config.ProduceValidation{
+		"test.1": {URL: validatorSrv.URL},
+	}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	r1, err1 := s.unixClient.Post("http://_/topics/test.1/messages?sync", "text/plain", strings.NewReader("good"))
+	r2, err2 := s.unixClient.Post("http://_/topics/test.1/messages?sync", "text/plain", strings.NewReader("bad"))
+	r3, err3 := s.unixClient.Post("http://_/topics/test.1/messages/_validate", "text/plain", strings.NewReader("bad"))
+
+	// Then
+	c.Assert(err1, IsNil)
+	c.Check(r1.StatusCode, Equals, http.StatusOK)
+	r1.Body.Close()
+	for _, tc := range []struct {
+		r   *http.Response
+		err error
+	}{{r2, err2}, {r3, err3}} {
+		c.Assert(tc.err, IsNil)
+		c.Check(tc.r.StatusCode, Equals, http.StatusUnprocessableEntity)
+		c.Check(ParseJSONBody(c, tc.r), DeepEquals, map[string]interface{}{
+			"error":     "message rejected by validator: value must be good",
+			"code":      "invalid_argument",
+			"retryable": false,
+		})
+	}
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.1")
+	c.Check(s.kh.GetMessages("test.1", offsetsBefore, offsetsAfter), DeepEquals, [][]string{{"good"}})
 }
 
 func (s *ServiceHTTPSuite) TestConsumeNoGroup(c *C) {
@@ -476,6 +828,71 @@ func (s *ServiceHTTPSuite) TestConsumeInvalidTopic(c *C) {
 	c.Check(r.StatusCode, Equals, http.StatusRequestTimeout)
 	body := ParseJSONBody(c, r).(map[string]interface{})
 	c.Check(body["error"], Equals, "long polling timeout")
+	c.Check(body["code"], Equals, "timeout")
+	c.Check(body["retryable"], Equals, true)
+}
+
+// If a group has consumed all messages of a topic, then a consume request
+// with the endOfStream flag gets 204 rather than a long polling timeout.
+func (s *ServiceHTTPSuite) TestConsumeEndOfStream(c *C) {
+	s.kh.ResetOffsets("foo", "test.4")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&endOfStream")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNoContent)
+}
+
+// A validated consume request does not consume a message.
+func (s *ServiceHTTPSuite) TestValidateConsume(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("validate", "test.1", map[string]int{"A": 1})
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages/_validate?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{})
+	svc.Stop()
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val)
+}
+
+func (s *ServiceHTTPSuite) TestValidateConsumeInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		url    string
+		status int
+	}{{
+		url:    "http://_/topics/no-such-topic/messages/_validate?group=foo",
+		status: http.StatusNotFound,
+	}, {
+		url:    "http://_/topics/test.1/messages/_validate",
+		status: http.StatusBadRequest,
+	}, {
+		url:    "http://_/topics/test.1/messages/_validate?group=foo&ackTimeout=1h",
+		status: http.StatusBadRequest,
+	}} {
+		// When
+		r, err := s.unixClient.Get(tc.url)
+
+		// Then
+		c.Check(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+	}
 }
 
 // By default auto-ack mode is assumed when consuming.
@@ -508,633 +925,3827 @@ func (s *ServiceHTTPSuite) TestConsumeAutoAck(c *C) {
 	assertMsgs(c, consumed, produced)
 }
 
-// If message is consumed with noAck but is not explicitly acknowledged, then
-// its offset is not committed.
-func (s *ServiceHTTPSuite) TestConsumeNoAck(c *C) {
-	s.proxyCfg.Consumer.AckTimeout = 500 * time.Millisecond
-	s.proxyCfg.Consumer.SubscriptionTimeout = 500 * time.Millisecond
+// Consume requests on behalf of groups beyond the limit are rejected.
+func (s *ServiceHTTPSuite) TestConsumeMaxGroups(c *C) {
+	s.proxyCfg.Limits.MaxGroups = 1
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
-
+	defer svc.Stop()
 	s.kh.ResetOffsets("foo", "test.1")
-	s.kh.PutMessages("no-ack", "test.1", map[string]int{"A": 1})
-	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	s.kh.PutMessages("max-groups", "test.1", map[string]int{"A": 1})
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
 	// When
-	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
-	c.Check(err, IsNil)
-	svc.Stop()
+	r, err = s.unixClient.Get("http://_/topics/test.1/messages?group=bar")
 
 	// Then
-	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
-	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusTooManyRequests)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrTooManyGroups.Error(),
+		"code":      "resource_exhausted",
+		"retryable": true,
+	})
 }
 
-func (s *ServiceHTTPSuite) TestConsumeExplicitAck(c *C) {
+// Pressure is the highest of work queue depths relative to their capacities.
+func (s *ServiceHTTPSuite) TestGetPressure(c *C) {
+	s.proxyCfg.Consumer.MaxPendingMessages = 4
+	s.proxyCfg.Limits.Consume.Concurrency = 10
+	s.proxyCfg.Limits.Consume.QueueSize = 5
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("pressure", "test.1", map[string]int{"A": 1})
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
-	s.kh.ResetOffsets("foo", "test.4")
-	produced := s.kh.PutMessages("explicit-ack", "test.4", map[string]int{"A": 17, "B": 19, "C": 23, "D": 29})
-	consumed := make(map[string][]*pb.ConsRs)
-	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.4")
+	// When
+	r, err = s.unixClient.Get("http://_/_pressure")
 
-	// When:
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"pressure": float64(25),
+		"produce_buffer": map[string]interface{}{
+			"depth":    float64(0),
+			"capacity": float64(s.proxyCfg.Producer.ChannelBufferSize),
+			"pressure": float64(0),
+		},
+		"offer_queue": map[string]interface{}{
+			"depth":    float64(1),
+			"capacity": float64(4),
+			"pressure": float64(25),
+		},
+		"unserved_long_polls": map[string]interface{}{
+			"depth":    float64(0),
+			"capacity": float64(5),
+			"pressure": float64(0),
+		},
+	})
+}
 
-	// First message has to be consumed with noAck.
-	res, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&noAck")
-	c.Check(err, IsNil, Commentf("failed to consume first message"))
-	consRes := ParseConsRes(c, res)
-	key := string(consRes.KeyValue)
-	consumed[key] = append(consumed[key], consRes)
-	// Whenever a message is consumed previous one is acked.
-	for i := 1; i < 88; i++ {
-		url := fmt.Sprintf("http://_/topics/test.4/messages?group=foo&ackPartition=%d&ackOffset=%d",
-			consRes.Partition, consRes.Offset)
-		httpRs, err := s.unixClient.Get(url)
-		consRes = ParseConsRes(c, httpRs)
-		c.Check(err, IsNil, Commentf("failed to consume message #%d", i))
-		c.Check(httpRs.StatusCode, Equals, http.StatusOK)
-		key := string(consRes.KeyValue)
-		consumed[key] = append(consumed[key], consRes)
-	}
-	// Ack last message.
-	url := fmt.Sprintf("http://_/topics/test.4/acks?group=foo&partition=%d&offset=%d",
-		consRes.Partition, consRes.Offset)
-	httpRs, err := s.unixClient.Post(url, "text/plain", nil)
-	c.Check(err, IsNil, Commentf("failed ack last message"))
-	ackRs := ParseJSONBody(c, httpRs).(map[string]interface{})
-	c.Check(ackRs, DeepEquals, map[string]interface{}{})
-	c.Check(httpRs.StatusCode, Equals, http.StatusOK)
-	svc.Stop()
+// Quotas report usage of the configured limits.
+func (s *ServiceHTTPSuite) TestGetQuotas(c *C) {
+	s.proxyCfg.Limits.MaxGroups = 3
+	s.proxyCfg.Limits.Consume.Concurrency = 10
+	s.proxyCfg.Limits.Consume.QueueSize = 5
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("quotas", "test.1", map[string]int{"A": 1})
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
-	// Then
-	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.4")
-	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+17)
-	c.Check(offsetsAfter[1].Val, Equals, offsetsBefore[1].Val+29)
-	c.Check(offsetsAfter[2].Val, Equals, offsetsBefore[2].Val+23)
-	c.Check(offsetsAfter[3].Val, Equals, offsetsBefore[3].Val+19)
+	// When
+	r, err = s.unixClient.Get("http://_/_quotas")
 
-	assertMsgs(c, consumed, produced)
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	brokerConns := body["broker_connections"].(map[string]interface{})
+	c.Check(brokerConns["open"].(float64) > 0, Equals, true)
+	delete(brokerConns, "open")
+	c.Check(body, DeepEquals, map[string]interface{}{
+		"consume": map[string]interface{}{
+			"in_flight":   float64(0),
+			"queued":      float64(0),
+			"concurrency": float64(10),
+			"queue_size":  float64(5),
+		},
+		"produce": map[string]interface{}{
+			"in_flight":   float64(0),
+			"queued":      float64(0),
+			"concurrency": float64(0),
+			"queue_size":  float64(0),
+		},
+		"groups": map[string]interface{}{
+			"active":     float64(1),
+			"max_groups": float64(3),
+			"names":      []interface{}{"foo"},
+		},
+		"broker_connections": map[string]interface{}{
+			"queued":          float64(0),
+			"max_connections": float64(0),
+			"rejected":        float64(0),
+		},
+		"throttle": map[string]interface{}{
+			"throttled":         false,
+			"throttled_fetches": float64(0),
+			"fetch_throttle_ms": float64(0),
+		},
+	})
 }
 
-func (s *ServiceHTTPSuite) TestConsumeDisabled(c *C) {
-	s.proxyCfg.Consumer.Disabled = true
+// Effective topic configuration includes broker defaults that are not
+// overridden for the topic.
+func (s *ServiceHTTPSuite) TestGetTopicConfig(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("DescribeConfigs not supported before Kafka v0.11")
+	}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	r, err := s.unixClient.Get("http://_/topics/test.1/config")
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "service is disabled by configuration")
+	entries := make(map[string]interface{})
+	for _, entry := range body["config"].([]interface{}) {
+		entry := entry.(map[string]interface{})
+		entries[entry["name"].(string)] = entry
+	}
+	c.Check(entries["max.message.bytes"], NotNil)
+	c.Check(entries["cleanup.policy"], DeepEquals, map[string]interface{}{
+		"name":      "cleanup.policy",
+		"value":     "delete",
+		"default":   true,
+		"read_only": false,
+		"sensitive": false,
+	})
 }
 
-// When a message that was produced with headers is conusmed, the headers should
-// be present
-func (s *ServiceHTTPSuite) TestConsumeHeaders(c *C) {
+func (s *ServiceHTTPSuite) TestGetTopicConfigUnknownTopic(c *C) {
 	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
-		c.Skip("Headers not supported before Kafka v0.11")
+		c.Skip("DescribeConfigs not supported before Kafka v0.11")
 	}
-
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
+	defer svc.Stop()
 
-	s.kh.ResetOffsets("foo", "test.4")
-	req, err := http.NewRequest("POST", "http://_/topics/test.4/messages?key=foo&sync",
-		strings.NewReader("test"))
+	// When
+	r, err := s.unixClient.Get("http://_/topics/no-such-topic/config")
+
+	// Then
 	c.Assert(err, IsNil)
-	req.Header.Add("Content-Type", "text/plain")
-	req.Header.Add("X-Kafka-Foo", base64.StdEncoding.EncodeToString([]byte("bar")))
-	rs, err := s.unixClient.Do(req)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+}
+
+// Produce and set offsets requests are rejected if the cluster is read-only,
+// but messages can still be consumed.
+func (s *ServiceHTTPSuite) TestReadOnlyCluster(c *C) {
+	s.proxyCfg.ReadOnly = true
+	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
-	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("read-only", "test.1", map[string]int{"A": 1})
 
 	// When
-	res, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
-	c.Check(err, IsNil)
-	consRes := ParseConsRes(c, res)
-	svc.Stop()
+	r1, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
+	r2, err := s.unixClient.Post("http://_/topics/test.1/offsets?group=foo",
+		"application/json", strings.NewReader(`[{"partition": 0, "offset": 0}]`))
+	c.Assert(err, IsNil)
+	r3, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
 
 	// Then
-	c.Check(consRes.Headers, Not(HasLen), 0)
-	c.Check(consRes.Headers[0], DeepEquals, &pb.RecordHeader{Key: "Foo", Value: []byte("bar")})
+	c.Check(r1.StatusCode, Equals, http.StatusForbidden)
+	c.Check(ParseJSONBody(c, r1), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrReadOnly.Error(),
+		"code":      "forbidden",
+		"retryable": false,
+	})
+	c.Check(r2.StatusCode, Equals, http.StatusForbidden)
+	c.Check(r3.StatusCode, Equals, http.StatusOK)
 }
 
-// If offsets for a group that does not exist are requested then -1 is returned
-// as the next offset to be consumed for all topic partitions.
-func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchGroup(c *C) {
+// Produce requests are rejected by a read-only listener, but are accepted by
+// the others.
+func (s *ServiceHTTPSuite) TestReadOnlyListener(c *C) {
+	s.cfg.ReadOnlyListeners = []string{config.ListenerUnix}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/offsets?group=no_such_group")
+	r1, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
+	r2, err := s.tcpClient.Post("http://127.0.0.1:19092/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	body := ParseJSONBody(c, r).([]interface{})
-	for i := 0; i < 4; i++ {
-		partitionView := body[i].(map[string]interface{})
-		c.Check(partitionView["partition"].(float64), Equals, float64(i))
-		c.Check(partitionView["offset"].(float64), Equals, float64(-1))
-	}
+	c.Check(r1.StatusCode, Equals, http.StatusForbidden)
+	c.Check(r2.StatusCode, Equals, http.StatusOK)
 }
 
-// An attempt to retrieve offsets for a topic that does not exist fails with 404.
-func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchTopic(c *C) {
+// A cluster can be selected by the X-Kafka-Cluster header.
+func (s *ServiceHTTPSuite) TestProduceClusterHeader(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/no_such_topic/offsets?group=foo")
+	rq1, _ := http.NewRequest("POST", "http://_/topics/test.1/messages?sync", strings.NewReader("Bazinga!"))
+	rq1.Header.Set("Content-Type", "text/plain")
+	rq1.Header.Set("X-Kafka-Cluster", "pxyH")
+	r1, err := s.unixClient.Do(rq1)
+	c.Assert(err, IsNil)
+	rq2, _ := http.NewRequest("POST", "http://_/topics/test.1/messages?sync", strings.NewReader("Bazinga!"))
+	rq2.Header.Set("Content-Type", "text/plain")
+	rq2.Header.Set("X-Kafka-Cluster", "invalid")
+	r2, err := s.unixClient.Do(rq2)
+	c.Assert(err, IsNil)
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusNotFound)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "Unknown topic")
+	c.Check(r1.StatusCode, Equals, http.StatusOK)
+	c.Check(r2.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r2), DeepEquals, map[string]interface{}{
+		"error":     "proxy `invalid` does not exist",
+		"code":      "invalid_argument",
+		"retryable": false,
+	})
 }
 
-// Committed offsets are returned in a following GET request.
-func (s *ServiceHTTPSuite) TestSetOffsets(c *C) {
+// Requests go through configured middleware in order.
+func (s *ServiceHTTPSuite) TestMiddleware(c *C) {
+	s.cfg.HTTPMiddleware = []string{httpsrv.MiddlewareLogging, "test_stamp", "test_stamp"}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
-		"application/json", strings.NewReader(
-			`[{"partition": 0, "offset": 1100, "metadata": "A100"},
-			  {"partition": 1, "offset": 1101, "metadata": "A101"},
-			  {"partition": 2, "offset": 1102, "metadata": "A102"},
-			  {"partition": 3, "offset": 1103, "metadata": "A103"}]`))
+	r, err := s.unixClient.Get("http://_/_ping")
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(ParseJSONBody(c, r), DeepEquals, httpsrv.EmptyResponse)
+	c.Assert(err, IsNil)
 	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(r.Header["X-Test-Stamp"], DeepEquals, []string{"1", "1"})
+}
 
-	r, err = s.unixClient.Get("http://_/topics/test.4/offsets?group=foo")
-	c.Check(err, IsNil)
-	body := ParseJSONBody(c, r).([]interface{})
-	for i := 0; i < 4; i++ {
-		partitionView := body[i].(map[string]interface{})
-		c.Check(partitionView["partition"].(float64), Equals, float64(i))
-		c.Check(partitionView["offset"].(float64), Equals, float64(1100+i))
-		c.Check(partitionView["metadata"].(string), Equals, fmt.Sprintf("A10%d", i))
-		c.Check(partitionView["count"], Equals, partitionView["end"].(float64)-partitionView["begin"].(float64))
-	}
+func (s *ServiceHTTPSuite) TestMiddlewareUnknown(c *C) {
+	s.cfg.HTTPMiddleware = []string{"bazinga"}
+
+	// When
+	svc, err := Spawn(s.cfg)
+
+	// Then
+	c.Check(err, ErrorMatches, "unknown HTTP middleware: bazinga")
+	c.Check(svc, IsNil)
 }
 
-// Result of setting offsets for a non-existent topic depends on the Kafka
-// version. It is ok for 0.8, but error for 0.9.x and higher.
-func (s *ServiceHTTPSuite) TestSetOffsetsNoSuchTopic(c *C) {
+// A listener serves only endpoints of the enabled groups, and ping.
+func (s *ServiceHTTPSuite) TestListenerEndpoints(c *C) {
+	s.cfg.ListenerEndpoints = map[string][]string{config.ListenerUnix: {config.EndpointsProduce}}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Post("http://_/topics/no_such_topic/offsets?group=foo",
-		"application/json", strings.NewReader(`[{"partition": 0, "offset": 1100, "metadata": "A100"}]`))
+	r1, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
+	r2, err := s.unixClient.Get("http://_/topics")
+	c.Assert(err, IsNil)
+	r3, err := s.unixClient.Get("http://_/_state")
+	c.Assert(err, IsNil)
+	r4, err := s.unixClient.Get("http://_/_ping")
+	c.Assert(err, IsNil)
+	r5, err := s.tcpClient.Get("http://127.0.0.1:19092/topics")
+	c.Assert(err, IsNil)
 
 	// Then
-	kafkaVersion := os.Getenv("KAFKA_VERSION")
-	if strings.HasPrefix(kafkaVersion, "0.8") {
-		c.Check(err, IsNil)
-		c.Check(r.StatusCode, Equals, http.StatusOK)
-		c.Check(ParseJSONBody(c, r), DeepEquals, httpsrv.EmptyResponse)
-		return
-	}
-
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusNotFound)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "Unknown topic")
+	c.Check(r1.StatusCode, Equals, http.StatusOK)
+	c.Check(r2.StatusCode, Equals, http.StatusNotFound)
+	c.Check(r3.StatusCode, Equals, http.StatusNotFound)
+	c.Check(r4.StatusCode, Equals, http.StatusOK)
+	c.Check(r5.StatusCode, Equals, http.StatusOK)
 }
 
-// Invalid body is detected and properly reported.
-func (s *ServiceHTTPSuite) TestSetOffsetsInvalidBody(c *C) {
+// A consume long poll that takes up the consume pool does not prevent
+// produce requests from being served.
+func (s *ServiceHTTPSuite) TestListenerPools(c *C) {
+	s.cfg.ListenerPools.Consume = config.Limit{Concurrency: 1}
+	s.cfg.ListenerPools.Produce = config.Limit{Concurrency: 1}
+	s.cfg.ListenerPools.QueueTimeout = 100 * time.Millisecond
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.4")
+	longPollDoneCh := make(chan none.T)
+	go func() {
+		defer close(longPollDoneCh)
+		r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+		c.Check(err, IsNil)
+		c.Check(r.StatusCode, Equals, http.StatusRequestTimeout)
+	}()
+	time.Sleep(500 * time.Millisecond)
 
 	// When
-	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
-		"application/json", strings.NewReader(`garbage`))
+	r1, err := s.tcpClient.Get("http://127.0.0.1:19092/topics/test.4/messages?group=foo")
+	c.Assert(err, IsNil)
+	r2, err := s.tcpClient.Post("http://127.0.0.1:19092/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "Failed to parse the request: err=(invalid character 'g' looking for beginning of value)")
+	c.Check(r1.StatusCode, Equals, http.StatusTooManyRequests)
+	c.Check(ParseJSONBody(c, r1), DeepEquals, map[string]interface{}{
+		"error":     server.ErrPoolExhausted.Error(),
+		"code":      "resource_exhausted",
+		"retryable": true,
+	})
+	c.Check(r2.StatusCode, Equals, http.StatusOK)
+	<-longPollDoneCh
 }
 
-// It is not an error to set an offset for a missing partition.
-func (s *ServiceHTTPSuite) TestSetOffsetsInvalidPartition(c *C) {
+// Produce requests to topics that are not whitelisted, or are blacklisted,
+// are rejected.
+func (s *ServiceHTTPSuite) TestProduceTopicLists(c *C) {
+	s.proxyCfg.Producer.TopicWhitelist = []string{"test\\.[0-9]+"}
+	s.proxyCfg.Producer.TopicBlacklist = []string{"test.64"}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
-	// When
-	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
-		"application/json", strings.NewReader(`[{"partition": 5}]`))
+	for i, tc := range []struct {
+		topic  string
+		status int
+	}{
+		{topic: "test.1", status: http.StatusOK},
+		{topic: "test.64", status: http.StatusForbidden},
+		{topic: "tset.1", status: http.StatusForbidden},
+	} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/"+tc.topic+"/messages",
+			"text/plain", strings.NewReader("Bazinga!"))
 
-	// Then
-	c.Check(err, IsNil)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	if s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V2_0_0_0) {
-		c.Check(r.StatusCode, Equals, http.StatusNotFound)
-		c.Check(body["error"], Equals, "Unknown topic")
-	} else {
-		c.Check(r.StatusCode, Equals, http.StatusOK)
-		c.Check(body, DeepEquals, httpsrv.EmptyResponse)
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
 	}
 }
 
-// Reported partition lags are correct, including those corresponding to -1 and
-// -2 special case offset values.
-func (s *ServiceHTTPSuite) TestGetOffsetsLag(c *C) {
+// Messages older than the maximum age are skipped and acknowledged.
+func (s *ServiceHTTPSuite) TestConsumeMaxAge(c *C) {
+	s.proxyCfg.Consumer.MaxAge = map[string]time.Duration{"test.1": 2 * time.Second}
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	s.kh.PutMessages("max-age", "test.1", map[string]int{"A": 3})
+	time.Sleep(3 * time.Second)
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
-	defer svc.Stop()
-	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
-		"application/json", strings.NewReader(
-			`[{"partition": 0, "offset": -1},
-			  {"partition": 1, "offset": -2},
-			  {"partition": 2, "offset": 1}]`))
-	c.Check(err, IsNil)
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("fresh"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
 	// When
-	r, err = s.unixClient.Get("http://_/topics/test.4/offsets?group=foo")
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	body := ParseJSONBody(c, r).([]interface{})
-	partition0View := body[0].(map[string]interface{})
-	c.Check(partition0View["lag"], Equals, float64(0))
-	partition1View := body[1].(map[string]interface{})
-	c.Check(partition1View["lag"], Equals, partition1View["end"].(float64)-partition1View["begin"].(float64))
-	partition2View := body[2].(map[string]interface{})
-	c.Check(partition2View["lag"], Equals, partition2View["end"].(float64)-partition2View["offset"].(float64))
+	c.Check(string(consRes.Message), Equals, "fresh")
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+4)
 }
 
-func (s *ServiceHTTPSuite) TestGetConsumersTopicNotConsumed(c *C) {
+// Messages with timestamps outside of the window are skipped and acknowledged.
+func (s *ServiceHTTPSuite) TestConsumeBetween(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	s.kh.PutMessages("between", "test.1", map[string]int{"A": 3})
+	// Timestamps in the window are of a second precision.
+	time.Sleep(time.Second)
+	from := time.Now().Add(time.Second).Truncate(time.Second)
+	time.Sleep(time.Until(from))
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
-	defer svc.Stop()
-
-	// Issue a consume request to initialize the consumer group.
-	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("in-window"))
 	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&between=" +
+		url.QueryEscape(from.Format(time.RFC3339)+","))
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "topic test.4 is not consumed by foo")
+	c.Check(string(consRes.Message), Equals, "in-window")
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+4)
 }
 
-func (s *ServiceHTTPSuite) TestGetConsumersUnknownGroup(c *C) {
+func (s *ServiceHTTPSuite) TestConsumeBetweenInvalid(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
+	for i, tc := range []struct {
+		window string
+		error  string
+	}{{
+		window: "2026-10-16T12:00:00Z",
+		error:  "bad window: 2026-10-16T12:00:00Z",
+	}, {
+		window: "yesterday,today",
+		error:  "bad window: yesterday,today",
+	}, {
+		window: "2026-10-16T12:00:00Z,2026-10-16T11:00:00Z",
+		error:  "bad window, end is before start: 2026-10-16T12:00:00Z,2026-10-16T11:00:00Z",
+	}} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&between=" + url.QueryEscape(tc.window))
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Messages at or after the stop offset are neither delivered nor acknowledged,
+// and once the messages before it are consumed, the completion is reported.
+func (s *ServiceHTTPSuite) TestConsumeStopAt(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	s.kh.PutMessages("stop-at", "test.1", map[string]int{"A": 3})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	consumeURL := fmt.Sprintf("http://_/topics/test.1/messages?group=foo&stopAt=0:%d", offsetsBefore[0].Val+2)
+
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		r, err := s.unixClient.Get(consumeURL)
+		c.Assert(err, IsNil)
+		statuses = append(statuses, r.StatusCode)
+	}
+	svc.Stop()
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(body["error"], Equals, "unknown consumer group foo")
+	c.Check(statuses, DeepEquals, []int{http.StatusOK, http.StatusOK, http.StatusNoContent})
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+2)
 }
 
-func (s *ServiceHTTPSuite) TestGetTopicConsumersOne(c *C) {
-	s.kh.ResetOffsets("foo", "test.4")
-	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+// Messages past the stop point are offered again after the ack timeout, but
+// that does not count as a retry, so they are never acknowledged for running
+// out of retries.
+func (s *ServiceHTTPSuite) TestConsumeStopAtMaxRetries(c *C) {
+	s.proxyCfg.Consumer.AckTimeout = 300 * time.Millisecond
+	s.proxyCfg.Consumer.LongPollingTimeout = time.Second
+	s.proxyCfg.Consumer.MaxRetries = 0
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	s.kh.PutMessages("stop-at", "test.1", map[string]int{"A": 3})
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
-	defer svc.Stop()
-	for i := 0; i < 4; i++ {
-		s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
-	}
+	consumeURL := fmt.Sprintf("http://_/topics/test.1/messages?group=foo&stopAt=0:%d", offsetsBefore[0].Val+1)
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
-
+	r, err := s.unixClient.Get(consumeURL)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	for i := 0; i < 3; i++ {
+		_, err := s.unixClient.Get(consumeURL)
+		c.Assert(err, IsNil)
+	}
+	svc.Stop()
+
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+1)
+}
+
+func (s *ServiceHTTPSuite) TestConsumeStopAtInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		stop  string
+		error string
+	}{{
+		stop:  "yesterday",
+		error: "bad stop: yesterday",
+	}, {
+		stop:  "0:10,1",
+		error: "bad stop: 0:10,1",
+	}, {
+		stop:  "0:-1",
+		error: "bad stop: 0:-1",
+	}} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&stopAt=" + url.QueryEscape(tc.stop))
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// With valueBytes only the beginning of the value is returned, along with
+// the size of the whole value.
+func (s *ServiceHTTPSuite) TestConsumeValueBytes(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	produced := s.kh.PutMessages("value-bytes", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&valueBytes=4")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	value := string(produced["A"][0].Value.(sarama.StringEncoder))
+	c.Check(ParseBase64(c, body["value"].(string)), Equals, value[:4])
+	c.Check(body["value_size"], Equals, float64(len(value)))
+}
+
+func (s *ServiceHTTPSuite) TestConsumeValueBytesInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, valueBytes := range []string{"x", "-1"} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&valueBytes=" + valueBytes)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, "bad valueBytes: "+valueBytes, Commentf("case #%d", i))
+	}
+}
+
+// When a group with catch-up configured starts behind, only the latest
+// messages of every key are delivered up to the high water mark.
+func (s *ServiceHTTPSuite) TestConsumeCatchUp(c *C) {
+	s.proxyCfg.Consumer.CatchUp = map[string]config.CatchUp{"foo": {}}
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	prefix := fmt.Sprintf("catch-up-%d-", time.Now().UnixNano())
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"c", "4"}, {"b", "5"}} {
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync&key="+prefix+kv[0],
+			"text/plain", strings.NewReader(kv[1]))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+	}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	var consumed []string
+	for i := 0; i < 4; i++ {
+		if i == 3 {
+			// Produced after the catch-up is over.
+			r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync&key="+prefix+"a",
+				"text/plain", strings.NewReader("6"))
+			c.Assert(err, IsNil)
+			c.Assert(r.StatusCode, Equals, http.StatusOK)
+		}
+		res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+		c.Assert(err, IsNil)
+		consumed = append(consumed, string(ParseConsRes(c, res).Message))
+	}
+	svc.Stop()
+
+	// Then
+	c.Check(consumed, DeepEquals, []string{"3", "4", "5", "6"})
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+6)
+}
+
+// Messages with a key that has already been consumed by a group within its
+// de-duplication window are skipped and acknowledged.
+func (s *ServiceHTTPSuite) TestConsumeDedup(c *C) {
+	s.proxyCfg.Consumer.Dedup = map[string]config.Dedup{"foo": {Window: time.Minute}}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	key := fmt.Sprintf("dedup-%d", time.Now().UnixNano())
+	for _, msg := range []string{"first", "retry", "second"} {
+		msgKey := key
+		if msg == "second" {
+			msgKey += "-2"
+		}
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync&key="+msgKey,
+			"text/plain", strings.NewReader(msg))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+	}
+
+	// When
+	var consumed []string
+	for i := 0; i < 2; i++ {
+		res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+		c.Assert(err, IsNil)
+		consumed = append(consumed, string(ParseConsRes(c, res).Message))
+	}
+	svc.Stop()
+
+	// Then
+	c.Check(consumed, DeepEquals, []string{"first", "second"})
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+3)
+}
+
+// If message is consumed with noAck but is not explicitly acknowledged, then
+// its offset is not committed.
+func (s *ServiceHTTPSuite) TestConsumeNoAck(c *C) {
+	s.proxyCfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.proxyCfg.Consumer.SubscriptionTimeout = 500 * time.Millisecond
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("no-ack", "test.1", map[string]int{"A": 1})
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+
+	// When
+	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Check(err, IsNil)
+	svc.Stop()
+
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val)
+}
+
+// Offsets forcibly acknowledged are committed regardless of whether messages
+// at them have been offered or acknowledged by clients.
+func (s *ServiceHTTPSuite) TestForceAck(c *C) {
+	s.proxyCfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.proxyCfg.Consumer.SubscriptionTimeout = 500 * time.Millisecond
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("force-ack", "test.1", map[string]int{"A": 2})
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+
+	// When
+	r, err := s.unixClient.Post(fmt.Sprintf("http://_/topics/test.1/partitions/0/force_ack?group=foo&offset=%d&count=2&by=ops&reason=poison",
+		offsetsBefore[0].Val), "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	svc.Stop()
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+2)
+}
+
+// A group consuming at the instance is fast-forwarded to the end by its
+// partition consumers, dropping the message offered but not acknowledged.
+func (s *ServiceHTTPSuite) TestResetToEnd(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("reset-to-end", "test.1", map[string]int{"A": 3})
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	newest := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/reset_to_end?group=foo&by=ops&reason=backlog",
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	partitions := ParseJSONBody(c, r).(map[string]interface{})["partitions"].([]interface{})
+	c.Assert(partitions, HasLen, len(newest))
+	c.Check(partitions[0], DeepEquals, map[string]interface{}{
+		"partition": float64(0),
+		"offset":    float64(offsetsBefore[0].Val),
+		"end":       float64(newest[0]),
+		"skipped":   float64(newest[0] - offsetsBefore[0].Val),
+	})
+	svc.Stop()
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	for i := range newest {
+		c.Check(offsetsAfter[i].Val, Equals, newest[i], Commentf("partition #%d", i))
+	}
+}
+
+// Offsets of a group that does not consume the topic anywhere are committed
+// directly.
+func (s *ServiceHTTPSuite) TestResetToEndIdle(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("reset-to-end", "test.1", map[string]int{"A": 3})
+	newest := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/reset_to_end?group=foo&by=ops&reason=backlog",
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	for i := range newest {
+		c.Check(offsetsAfter[i].Val, Equals, newest[i], Commentf("partition #%d", i))
+	}
+}
+
+func (s *ServiceHTTPSuite) TestResetToEndInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/reset_to_end?group=foo&by=ops",
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, "by and reason must be provided")
+}
+
+func (s *ServiceHTTPSuite) TestForceAckInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.PutMessages("force-ack", "test.1", map[string]int{"A": 1})
+	newest := s.kh.GetNewestOffsets("test.1")[0]
+
+	for i, tc := range []struct {
+		params string
+		status int
+		error  string
+	}{{
+		params: fmt.Sprintf("offset=%d&by=ops", newest-1),
+		status: http.StatusBadRequest,
+		error:  "by and reason must be provided",
+	}, {
+		params: fmt.Sprintf("offset=%d&count=1001&by=ops&reason=poison", newest-1),
+		status: http.StatusBadRequest,
+		error:  "bad count: 1001, must be from 1 to 1000",
+	}, {
+		params: fmt.Sprintf("offset=%d&count=2&by=ops&reason=poison", newest-1),
+		status: http.StatusBadRequest,
+		error:  fmt.Sprintf("offsets=%d-%d, range=%d-%d: offsets are out of the partition range", newest-1, newest, s.kh.GetOldestOffsets("test.1")[0], newest-1),
+	}, {
+		params: fmt.Sprintf("offset=%d&by=ops&reason=poison", newest-1),
+		status: http.StatusConflict,
+		error:  proxy.ErrNotConsumedHere.Error(),
+	}} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/test.1/partitions/0/force_ack?group=bar&"+tc.params, "text/plain", nil)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// A message consumed with an ack timeout longer than the configured one is not
+// offered again until it expires.
+func (s *ServiceHTTPSuite) TestConsumeAckTimeout(c *C) {
+	s.proxyCfg.Consumer.AckTimeoutCeiling = time.Minute
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("ack-timeout", "test.1", map[string]int{"A": 1})
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck&ackTimeout=30s")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+
+	// When
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusRequestTimeout)
+	url := fmt.Sprintf("http://_/topics/test.1/acks?group=foo&partition=%d&offset=%d",
+		consRes.Partition, consRes.Offset)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusOK)
+}
+
+// A message offered again after its ack timeout expired carries headers that
+// tell how many times it has been offered and when it was first offered.
+func (s *ServiceHTTPSuite) TestConsumeDeliveryHeaders(c *C) {
+	s.proxyCfg.Consumer.AckTimeout = 300 * time.Millisecond
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("delivery-headers", "test.1", map[string]int{"A": 1})
+	begin := time.Now()
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	firstRes := ParseConsRes(c, res)
+	time.Sleep(500 * time.Millisecond)
+
+	// When
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+
+	// Then
+	c.Assert(err, IsNil)
+	retryRes := ParseConsRes(c, res)
+	c.Check(retryRes.Offset, Equals, firstRes.Offset)
+	c.Check(firstRes.Headers, HasLen, 0)
+	c.Assert(retryRes.Headers, HasLen, 2)
+	c.Check(retryRes.Headers[0].Key, Equals, proxy.DeliveryAttemptHeader)
+	c.Check(string(retryRes.Headers[0].Value), Equals, "2")
+	c.Check(retryRes.Headers[1].Key, Equals, proxy.FirstOfferedAtHeader)
+	firstOfferedAt, err := time.Parse(time.RFC3339Nano, string(retryRes.Headers[1].Value))
+	c.Assert(err, IsNil)
+	c.Check(firstOfferedAt.After(begin), Equals, true)
+	c.Check(firstOfferedAt.Before(begin.Add(300*time.Millisecond)), Equals, true)
+}
+
+func (s *ServiceHTTPSuite) TestConsumeAckTimeoutInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		ackTimeout string
+		error      string
+	}{{
+		ackTimeout: "soon",
+		error:      "bad ack timeout: soon",
+	}, {
+		ackTimeout: "-1s",
+		error:      "bad ack timeout: -1s",
+	}, {
+		ackTimeout: "1h",
+		error:      proxy.ErrAckTimeoutTooLong.Error(),
+	}} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&ackTimeout=" + tc.ackTimeout)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// A consumer can postpone redelivery of a message it is still handling with
+// heartbeats.
+func (s *ServiceHTTPSuite) TestHeartbeat(c *C) {
+	s.proxyCfg.Consumer.AckTimeoutCeiling = time.Minute
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("heartbeat", "test.1", map[string]int{"A": 1})
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+
+	// When
+	url := fmt.Sprintf("http://_/topics/test.1/heartbeats?group=foo&partition=%d&offset=%d&ackTimeout=30s",
+		consRes.Partition, consRes.Offset)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusOK)
+
+	// Then
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusRequestTimeout)
+	url = fmt.Sprintf("http://_/topics/test.1/acks?group=foo&partition=%d&offset=%d",
+		consRes.Partition, consRes.Offset)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusOK)
+}
+
+// An ack that carries the generation of a message consumed before the
+// partition was claimed again is rejected rather than applied.
+func (s *ServiceHTTPSuite) TestAckStaleGeneration(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("stale-generation", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	staleGeneration := ParseJSONBody(c, res).(map[string]interface{})["generation"].(float64)
+	svc.Stop()
+
+	svc, err = Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, res).(map[string]interface{})
+	c.Assert(body["generation"].(float64) > staleGeneration, Equals, true)
+
+	// When
+	url := fmt.Sprintf("http://_/topics/test.1/acks?group=foo&partition=%v&offset=%v&generation=%v",
+		body["partition"], body["offset"], staleGeneration)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusConflict)
+	res, err = s.unixClient.Get("http://_/_consumer")
+	c.Assert(err, IsNil)
+	stats := ParseJSONBody(c, res).(map[string]interface{})
+	c.Check(stats["fenced_acks"], DeepEquals, map[string]interface{}{"test.1": float64(1)})
+
+	url = fmt.Sprintf("http://_/topics/test.1/acks?group=foo&partition=%v&offset=%v&generation=%v",
+		body["partition"], body["offset"], body["generation"])
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusOK)
+}
+
+// Messages produced and consumed through the proxy are counted by topic, and
+// by group and topic.
+func (s *ServiceHTTPSuite) TestThroughput(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("throughput", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	res, err := s.unixClient.Post("http://_/topics/test.4/messages?key=bar&sync",
+		"text/plain", strings.NewReader("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	// When
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	consumed := ParseJSONBody(c, res).(map[string]interface{})
+
+	// Then
+	res, err = s.unixClient.Get("http://_/_throughput")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	stats := ParseJSONBody(c, res).(map[string]interface{})
+	c.Check(stats["produced"], DeepEquals, map[string]interface{}{
+		"test.4": map[string]interface{}{"messages": float64(1), "bytes": float64(8)},
+	})
+	size := float64(len(ParseBase64(c, consumed["key"].(string))) + len(ParseBase64(c, consumed["value"].(string))))
+	c.Check(stats["consumed_by_group"], DeepEquals, map[string]interface{}{
+		"foo": map[string]interface{}{
+			"test.1": map[string]interface{}{"messages": float64(1), "bytes": size},
+		},
+	})
+}
+
+// Requests are counted by the client library given in the request headers.
+func (s *ServiceHTTPSuite) TestClientStats(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	req, err := http.NewRequest("GET", "http://_/topics", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("X-Client-Name", "http-client-stats-test")
+	req.Header.Set("X-Client-Version", "1.2.3")
+
+	// When
+	res, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	// Then
+	res, err = s.unixClient.Get("http://_/_clients")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	var requests interface{}
+	for _, client := range ParseJSONBody(c, res).(map[string]interface{})["clients"].([]interface{}) {
+		client := client.(map[string]interface{})
+		if client["name"] == "http-client-stats-test" && client["version"] == "1.2.3" {
+			requests = client["requests"]
+		}
+	}
+	c.Check(requests, DeepEquals, map[string]interface{}{"http:GET /topics": float64(1)})
+}
+
+// Consume requests are checked for being paused by garbage collection.
+func (s *ServiceHTTPSuite) TestGCStats(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("gc", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	before := gctune.Report()
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	runtime.GC()
+
+	// When
+	res, err = s.unixClient.Get("http://_/_gc")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	stats := ParseJSONBody(c, res).(map[string]interface{})
+	c.Check(stats["num_gc"].(float64) > float64(before.NumGC), Equals, true)
+	consume := stats["consume"].(map[string]interface{})
+	c.Check(consume["requests"].(float64) > 0, Equals, true)
+}
+
+func (s *ServiceHTTPSuite) TestInfo(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	res, err := s.unixClient.Get("http://_/_info")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	info := ParseJSONBody(c, res).(map[string]interface{})
+	c.Check(info["version"], Equals, "dev-build")
+	c.Check(info["clusters"], DeepEquals, []interface{}{
+		map[string]interface{}{"name": "pxyH", "client_id": s.proxyCfg.ClientID},
+	})
+	c.Check(info["default_cluster"], Equals, "pxyH")
+	c.Check(info["uptime_sec"].(float64) >= 0, Equals, true)
+	c.Check(info["features"], DeepEquals, []interface{}{})
+}
+
+// Enabled features are reported by `GET /_info`, and unknown ones keep the
+// service from starting.
+func (s *ServiceHTTPSuite) TestInfoFeatures(c *C) {
+	defer features.Configure(nil)
+	s.cfg.Features = map[string]bool{features.AdaptiveFetch: true}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	res, err := s.unixClient.Get("http://_/_info")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	info := ParseJSONBody(c, res).(map[string]interface{})
+	c.Check(info["features"], DeepEquals, []interface{}{features.AdaptiveFetch})
+
+	// When
+	s.cfg.Features = map[string]bool{"bogus": true}
+	_, err = Spawn(s.cfg)
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to configure features: unknown feature: bogus")
+}
+
+// Metadata that an ack carries is committed along with the offset, and
+// returned by offset fetches.
+func (s *ServiceHTTPSuite) TestAckMetadata(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("ack-metadata", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+
+	// When
+	url := fmt.Sprintf("http://_/topics/test.1/acks?group=foo&partition=%d&offset=%d&metadata=bar%%7Cbazz",
+		consRes.Partition, consRes.Offset)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusOK)
+	svc.Stop()
+
+	// Then
+	svc, err = Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	res, err = s.unixClient.Get("http://_/topics/test.1/offsets?group=foo")
+	c.Assert(err, IsNil)
+	body := ParseJSONBody(c, res).([]interface{})
+	partitionView := body[0].(map[string]interface{})
+	c.Check(partitionView["offset"], Equals, float64(consRes.Offset+1))
+	c.Check(partitionView["ack_metadata"], Equals, "bar|bazz")
+}
+
+func (s *ServiceHTTPSuite) TestAckMetadataTooLong(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	url := "http://_/topics/test.1/acks?group=foo&partition=0&offset=0&metadata=" + strings.Repeat("x", 1025)
+	res, err := s.unixClient.Post(url, "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, res), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrAckMetadataTooLong.Error(),
+		"code":      "invalid_argument",
+		"retryable": false,
+	})
+}
+
+// A message that is not acknowledged within the maximum processing time is
+// dead lettered rather than offered again, even if heartbeats keep coming.
+func (s *ServiceHTTPSuite) TestMaxProcessingTime(c *C) {
+	spoolDir := c.MkDir()
+	s.proxyCfg.Consumer.AckTimeoutCeiling = time.Minute
+	s.proxyCfg.Consumer.MaxProcessingTime = time.Second
+	s.proxyCfg.Producer.DeadLetter.SpoolDir = spoolDir
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("processing-time", "test.1", map[string]int{"A": 1})
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	url := fmt.Sprintf("http://_/topics/test.1/heartbeats?group=foo&partition=%d&offset=%d&ackTimeout=30s",
+		consRes.Partition, consRes.Offset)
+	res, err = s.unixClient.Post(url, "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+
+	// When
+	res, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo&noAck")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(res.StatusCode, Equals, http.StatusRequestTimeout)
+	res, err = s.unixClient.Get("http://_/_consumer")
+	c.Assert(err, IsNil)
+	c.Check(ParseJSONBody(c, res), DeepEquals, map[string]interface{}{
+		"processing_timeouts": map[string]interface{}{"test.1": float64(1)},
+		"fenced_acks":         map[string]interface{}{},
+		"data_loss":           map[string]interface{}{},
+	})
+	letters, err := ioutil.ReadFile(path.Join(spoolDir, "pxyH.jsonl"))
+	c.Assert(err, IsNil)
+	c.Check(string(letters), Matches, `(?s).*"error":"processing timeout".*`)
+}
+
+func (s *ServiceHTTPSuite) TestHeartbeatInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		params string
+		error  string
+	}{{
+		params: "group=foo",
+		error:  "partition and offset must be provided",
+	}, {
+		params: "group=foo&partition=0&offset=1&ackTimeout=1h",
+		error:  proxy.ErrAckTimeoutTooLong.Error(),
+	}} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/test.1/heartbeats?"+tc.params, "text/plain", nil)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+func (s *ServiceHTTPSuite) TestConsumeExplicitAck(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("explicit-ack", "test.4", map[string]int{"A": 17, "B": 19, "C": 23, "D": 29})
+	consumed := make(map[string][]*pb.ConsRs)
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.4")
+
+	// When:
+
+	// First message has to be consumed with noAck.
+	res, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&noAck")
+	c.Check(err, IsNil, Commentf("failed to consume first message"))
+	consRes := ParseConsRes(c, res)
+	key := string(consRes.KeyValue)
+	consumed[key] = append(consumed[key], consRes)
+	// Whenever a message is consumed previous one is acked.
+	for i := 1; i < 88; i++ {
+		url := fmt.Sprintf("http://_/topics/test.4/messages?group=foo&ackPartition=%d&ackOffset=%d",
+			consRes.Partition, consRes.Offset)
+		httpRs, err := s.unixClient.Get(url)
+		consRes = ParseConsRes(c, httpRs)
+		c.Check(err, IsNil, Commentf("failed to consume message #%d", i))
+		c.Check(httpRs.StatusCode, Equals, http.StatusOK)
+		key := string(consRes.KeyValue)
+		consumed[key] = append(consumed[key], consRes)
+	}
+	// Ack last message.
+	url := fmt.Sprintf("http://_/topics/test.4/acks?group=foo&partition=%d&offset=%d",
+		consRes.Partition, consRes.Offset)
+	httpRs, err := s.unixClient.Post(url, "text/plain", nil)
+	c.Check(err, IsNil, Commentf("failed ack last message"))
+	ackRs := ParseJSONBody(c, httpRs).(map[string]interface{})
+	c.Check(ackRs, DeepEquals, map[string]interface{}{})
+	c.Check(httpRs.StatusCode, Equals, http.StatusOK)
+	svc.Stop()
+
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.4")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+17)
+	c.Check(offsetsAfter[1].Val, Equals, offsetsBefore[1].Val+29)
+	c.Check(offsetsAfter[2].Val, Equals, offsetsBefore[2].Val+23)
+	c.Check(offsetsAfter[3].Val, Equals, offsetsBefore[3].Val+19)
+
+	assertMsgs(c, consumed, produced)
+}
+
+func (s *ServiceHTTPSuite) TestConsumeDisabled(c *C) {
+	s.proxyCfg.Consumer.Disabled = true
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "service is disabled by configuration")
+}
+
+// When a message that was produced with headers is conusmed, the headers should
+// be present
+// Messages larger than the chunk size are produced as several chunks, and
+// reassembled on consume. When the reassembled message is acknowledged, all
+// its chunks are.
+func (s *ServiceHTTPSuite) TestConsumeChunked(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	s.proxyCfg.Producer.ChunkSize = 4
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
+	req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?key=foo&sync",
+		strings.NewReader("0123456789"))
+	c.Assert(err, IsNil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("X-Kafka-Foo", base64.StdEncoding.EncodeToString([]byte("bar")))
+	rs, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+
+	// When
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
+
+	// Then
+	c.Check(string(consRes.KeyValue), Equals, "foo")
+	c.Check(string(consRes.Message), Equals, "0123456789")
+	c.Check(consRes.Headers, DeepEquals, []*pb.RecordHeader{{Key: "Foo", Value: []byte("bar")}})
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+3)
+}
+
+// The ID of a produce request is stamped on the produced message in the
+// configured header.
+func (s *ServiceHTTPSuite) TestProduceRequestID(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	s.proxyCfg.Producer.RequestIDHeader = "Request-Id"
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?key=foo&sync",
+		strings.NewReader("bar"))
+	c.Assert(err, IsNil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("X-Request-ID", "req-42")
+	rs, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	c.Check(rs.Header.Get("X-Request-ID"), Equals, "req-42")
+
+	// When
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
+
+	// Then
+	c.Check(string(consRes.Message), Equals, "bar")
+	c.Check(consRes.Headers, DeepEquals, []*pb.RecordHeader{{Key: "Request-Id", Value: []byte("req-42")}})
+}
+
+// Configured headers are stamped onto produced messages, topic specific ones
+// taking precedence over common ones, but headers given by clients win.
+func (s *ServiceHTTPSuite) TestEnrichment(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	s.cfg.Tenants = map[string]config.Tenant{"acme": {Prefix: "test.", APIKeys: []string{"k1"}}}
+	s.proxyCfg.Producer.Enrichment.Headers = map[string]string{"Env": "prod", "Geo": "us-east-1", "Schema-Id": "0"}
+	s.proxyCfg.Producer.Enrichment.Topics = map[string]map[string]string{"test.1": {"Schema-Id": "42"}}
+	s.proxyCfg.Producer.Enrichment.ProducerHeader = "Producer"
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("test.foo", "test.1")
+	req, err := http.NewRequest("POST", "http://_/topics/1/messages?key=foo&sync",
+		strings.NewReader("bar"))
+	c.Assert(err, IsNil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Authorization", "Bearer k1")
+	req.Header.Add("X-Kafka-Env", base64.StdEncoding.EncodeToString([]byte("dev")))
+	rs, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+
+	// When
+	req, err = http.NewRequest("GET", "http://_/topics/1/messages?group=foo", nil)
+	c.Assert(err, IsNil)
+	req.Header.Add("Authorization", "Bearer k1")
+	res, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+
+	// Then
+	c.Check(string(consRes.Message), Equals, "bar")
+	c.Check(consRes.Headers, DeepEquals, []*pb.RecordHeader{
+		{Key: "Env", Value: []byte("dev")},
+		{Key: "Geo", Value: []byte("us-east-1")},
+		{Key: "Schema-Id", Value: []byte("42")},
+		{Key: "Producer", Value: []byte("acme")},
+	})
+}
+
+// If trace context is enabled, then the trace context of a produce request is
+// stamped into record headers, and returned in headers of consume responses.
+func (s *ServiceHTTPSuite) TestTraceContext(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	s.proxyCfg.TraceContext.Enabled = true
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.1")
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?key=foo&sync",
+		strings.NewReader("bar"))
+	c.Assert(err, IsNil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("traceparent", parent)
+	req.Header.Add("tracestate", "foo=bar")
+	rs, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+
+	// When
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
+
+	// Then
+	c.Check(string(consRes.Message), Equals, "bar")
+	c.Check(consRes.Headers, DeepEquals, []*pb.RecordHeader{
+		{Key: "traceparent", Value: []byte(parent)},
+		{Key: "tracestate", Value: []byte("foo=bar")},
+	})
+	c.Check(res.Header.Get("traceparent"), Equals, parent)
+	c.Check(res.Header.Get("tracestate"), Equals, "foo=bar")
+}
+
+// If a request does not have an ID, then it is generated.
+func (s *ServiceHTTPSuite) TestRequestIDGenerated(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r1, err := s.unixClient.Get("http://_/_ping")
+	c.Assert(err, IsNil)
+	r2, err := s.unixClient.Get("http://_/_ping")
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Check(r1.Header.Get("X-Request-ID"), Matches, "[0-9a-f]{32}")
+	c.Check(r2.Header.Get("X-Request-ID"), Matches, "[0-9a-f]{32}")
+	c.Check(r1.Header.Get("X-Request-ID"), Not(Equals), r2.Header.Get("X-Request-ID"))
+}
+
+// Values larger than the claim-check threshold are offloaded to object
+// storage on produce and fetched back on consume.
+func (s *ServiceHTTPSuite) TestConsumeClaimCheck(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	var objectsMu sync.Mutex
+	objects := make(map[string][]byte)
+	objectStorage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		objectsMu.Lock()
+		defer objectsMu.Unlock()
+		if r.Method == http.MethodPut {
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			return
+		}
+		w.Write(objects[r.URL.Path])
+	}))
+	defer objectStorage.Close()
+	s.proxyCfg.ClaimCheck = map[string]config.ClaimCheck{
+		"test.1": {Threshold: 4, Endpoint: objectStorage.URL, Bucket: "foo"},
+	}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	s.kh.ResetOffsets("foo", "test.1")
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+		"text/plain", strings.NewReader("0123456789"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	offsetsAfter := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	res, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	c.Check(string(consRes.Message), Equals, "0123456789")
+	c.Check(consRes.Headers, HasLen, 0)
+	c.Check(len(objects), Equals, 1)
+	c.Check(s.kh.GetMessages("test.1", offsetsBefore, offsetsAfter), DeepEquals, [][]string{{""}})
+}
+
+func (s *ServiceHTTPSuite) TestConsumeHeaders(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.kh.ResetOffsets("foo", "test.4")
+	req, err := http.NewRequest("POST", "http://_/topics/test.4/messages?key=foo&sync",
+		strings.NewReader("test"))
+	c.Assert(err, IsNil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("X-Kafka-Foo", base64.StdEncoding.EncodeToString([]byte("bar")))
+	rs, err := s.unixClient.Do(req)
+	c.Assert(err, IsNil)
+	c.Assert(rs.StatusCode, Equals, http.StatusOK)
+
+	// When
+	res, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	c.Check(err, IsNil)
+	consRes := ParseConsRes(c, res)
+	svc.Stop()
+
+	// Then
+	c.Check(consRes.Headers, Not(HasLen), 0)
+	c.Check(consRes.Headers[0], DeepEquals, &pb.RecordHeader{Key: "Foo", Value: []byte("bar")})
+}
+
+// Values of a topic with a known codec are transcoded to the codec that a
+// client accepts, and returned as is otherwise.
+func (s *ServiceHTTPSuite) TestConsumeValueCodec(c *C) {
+	s.proxyCfg.ValueCodecs = map[string]string{"test.1": codec.MessagePack}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+
+	msgpack := []byte("\x82\xa1a\x01\xa1b\x92\x01\x02")
+	for i, tc := range []struct {
+		accept string
+		value  string
+		codec  string
+	}{{
+		accept: "application/json",
+		value:  `{"a":1,"b":[1,2]}`,
+		codec:  codec.JSON,
+	}, {
+		accept: "text/plain",
+		value:  string(msgpack),
+		codec:  codec.MessagePack,
+	}} {
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+			"application/msgpack", bytes.NewReader(msgpack))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK, Commentf("case #%d", i))
+		r.Body.Close()
+
+		// When
+		consReq, err := http.NewRequest("GET", "http://_/topics/test.1/messages?group=foo", nil)
+		c.Assert(err, IsNil)
+		consReq.Header.Set("Accept", tc.accept)
+		r, err = s.unixClient.Do(consReq)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Check(r.Header.Get("X-Kafka-Value-Codec"), Equals, tc.codec, Commentf("case #%d", i))
+		consRes := ParseConsRes(c, r)
+		c.Check(string(consRes.Message), Equals, tc.value, Commentf("case #%d", i))
+	}
+}
+
+// If offsets for a group that does not exist are requested then -1 is returned
+// as the next offset to be consumed for all topic partitions.
+func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchGroup(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/offsets?group=no_such_group")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).([]interface{})
+	for i := 0; i < 4; i++ {
+		partitionView := body[i].(map[string]interface{})
+		c.Check(partitionView["partition"].(float64), Equals, float64(i))
+		c.Check(partitionView["offset"].(float64), Equals, float64(-1))
+	}
+}
+
+// An attempt to retrieve offsets for a topic that does not exist fails with 404.
+func (s *ServiceHTTPSuite) TestGetOffsetsNoSuchTopic(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/no_such_topic/offsets?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "Unknown topic")
+}
+
+// Topic size is reported per partition and in total.
+func (s *ServiceHTTPSuite) TestGetTopicSize(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.PutMessages("size", "test.4", map[string]int{"A": 3, "B": 2})
+	oldest := s.kh.GetOldestOffsets("test.4")
+	newest := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/size")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	var total int64
+	for i, p := range body["partitions"].([]interface{}) {
+		partitionView := p.(map[string]interface{})
+		c.Check(partitionView["partition"], Equals, float64(i))
+		c.Check(partitionView["begin"], Equals, float64(oldest[i]))
+		c.Check(partitionView["end"], Equals, float64(newest[i]))
+		c.Check(partitionView["count"], Equals, float64(newest[i]-oldest[i]))
+		total += newest[i] - oldest[i]
+	}
+	c.Check(body["count"], Equals, float64(total))
+}
+
+// Partitions consumed by a group are reported in topic stats.
+func (s *ServiceHTTPSuite) TestGetTopicStats(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	produced := s.kh.PutMessages("stats", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Get("http://_/topics/test.1/stats")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	partitions := body["partitions"].([]interface{})
+	c.Assert(len(partitions), Equals, 1)
+	partitionView := partitions[0].(map[string]interface{})
+	c.Check(partitionView["partition"], Equals, float64(0))
+	c.Check(partitionView["fetched_messages"].(float64) >= 1, Equals, true)
+	// Stats are kept process wide, so groups of other tests can be there too.
+	var groupView map[string]interface{}
+	for _, g := range partitionView["groups"].([]interface{}) {
+		if g.(map[string]interface{})["group"] == "foo" {
+			groupView = g.(map[string]interface{})
+		}
+	}
+	c.Assert(groupView, NotNil)
+	c.Check(groupView["last_fetched_offset"].(float64) >= float64(produced["A"][0].Offset), Equals, true)
+}
+
+// Committed offsets are returned in a following GET request.
+func (s *ServiceHTTPSuite) TestSetOffsets(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
+		"application/json", strings.NewReader(
+			`[{"partition": 0, "offset": 1100, "metadata": "A100"},
+			  {"partition": 1, "offset": 1101, "metadata": "A101"},
+			  {"partition": 2, "offset": 1102, "metadata": "A102"},
+			  {"partition": 3, "offset": 1103, "metadata": "A103"}]`))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(ParseJSONBody(c, r), DeepEquals, httpsrv.EmptyResponse)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+
+	r, err = s.unixClient.Get("http://_/topics/test.4/offsets?group=foo")
+	c.Check(err, IsNil)
+	body := ParseJSONBody(c, r).([]interface{})
+	for i := 0; i < 4; i++ {
+		partitionView := body[i].(map[string]interface{})
+		c.Check(partitionView["partition"].(float64), Equals, float64(i))
+		c.Check(partitionView["offset"].(float64), Equals, float64(1100+i))
+		c.Check(partitionView["metadata"].(string), Equals, fmt.Sprintf("A10%d", i))
+		c.Check(partitionView["count"], Equals, partitionView["end"].(float64)-partitionView["begin"].(float64))
+	}
+}
+
+// Result of setting offsets for a non-existent topic depends on the Kafka
+// version. It is ok for 0.8, but error for 0.9.x and higher.
+func (s *ServiceHTTPSuite) TestSetOffsetsNoSuchTopic(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/no_such_topic/offsets?group=foo",
+		"application/json", strings.NewReader(`[{"partition": 0, "offset": 1100, "metadata": "A100"}]`))
+
+	// Then
+	kafkaVersion := os.Getenv("KAFKA_VERSION")
+	if strings.HasPrefix(kafkaVersion, "0.8") {
+		c.Check(err, IsNil)
+		c.Check(r.StatusCode, Equals, http.StatusOK)
+		c.Check(ParseJSONBody(c, r), DeepEquals, httpsrv.EmptyResponse)
+		return
+	}
+
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "Unknown topic")
+}
+
+// Invalid body is detected and properly reported.
+func (s *ServiceHTTPSuite) TestSetOffsetsInvalidBody(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
+		"application/json", strings.NewReader(`garbage`))
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "Failed to parse the request: err=(invalid character 'g' looking for beginning of value)")
+}
+
+// It is not an error to set an offset for a missing partition.
+func (s *ServiceHTTPSuite) TestSetOffsetsInvalidPartition(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
+		"application/json", strings.NewReader(`[{"partition": 5}]`))
+
+	// Then
+	c.Check(err, IsNil)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	if s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V2_0_0_0) {
+		c.Check(r.StatusCode, Equals, http.StatusNotFound)
+		c.Check(body["error"], Equals, "Unknown topic")
+	} else {
+		c.Check(r.StatusCode, Equals, http.StatusOK)
+		c.Check(body, DeepEquals, httpsrv.EmptyResponse)
+	}
+}
+
+// Reported partition lags are correct, including those corresponding to -1 and
+// -2 special case offset values.
+func (s *ServiceHTTPSuite) TestGetOffsetsLag(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
+		"application/json", strings.NewReader(
+			`[{"partition": 0, "offset": -1},
+			  {"partition": 1, "offset": -2},
+			  {"partition": 2, "offset": 1}]`))
+	c.Check(err, IsNil)
+
+	// When
+	r, err = s.unixClient.Get("http://_/topics/test.4/offsets?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).([]interface{})
+	partition0View := body[0].(map[string]interface{})
+	c.Check(partition0View["lag"], Equals, float64(0))
+	partition1View := body[1].(map[string]interface{})
+	c.Check(partition1View["lag"], Equals, partition1View["end"].(float64)-partition1View["begin"].(float64))
+	partition2View := body[2].(map[string]interface{})
+	c.Check(partition2View["lag"], Equals, partition2View["end"].(float64)-partition2View["offset"].(float64))
+}
+
+func (s *ServiceHTTPSuite) TestGetConsumersTopicNotConsumed(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// Issue a consume request to initialize the consumer group.
+	_, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "topic test.4 is not consumed by foo")
+}
+
+func (s *ServiceHTTPSuite) TestGetConsumersUnknownGroup(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"], Equals, "unknown consumer group foo")
+}
+
+func (s *ServiceHTTPSuite) TestGetTopicConsumersOne(c *C) {
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	for i := 0; i < 4; i++ {
+		s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	}
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/consumers?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	consumers := ParseJSONBody(c, r).(map[string]interface{})
+	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
+		"foo": {
+			"pxyH_client_id": {0, 1, 2, 3}},
+	})
+}
+
+// Consume responses carry the ID of the group member that served the message
+// and the group generation, the same that is reported by `GET /groups/{}`.
+func (s *ServiceHTTPSuite) TestDescribeGroup(c *C) {
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("describe.group", "test.4", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	consRs := ParseJSONBody(c, r).(map[string]interface{})
+
+	// When
+	r, err = s.unixClient.Get("http://_/groups/foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	groupRs := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(groupRs["members"], DeepEquals, map[string]interface{}{
+		"pxyH_client_id": []interface{}{"test.4"},
+	})
+	c.Check(groupRs["generation"].(float64) > 0, Equals, true)
+	c.Check(consRs["member_id"], Equals, "pxyH_client_id")
+	c.Check(consRs["generation"], Equals, groupRs["generation"])
+}
+
+func (s *ServiceHTTPSuite) TestDescribeGroupUnknown(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/groups/no_such_group")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     "unknown consumer group no_such_group",
+		"code":      "not_found",
+		"retryable": false,
+	})
+}
+
+func (s *ServiceHTTPSuite) TestGetPartitionOwners(c *C) {
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("partition.owners", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	for i := 0; i < 4; i++ {
+		s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	}
+
+	// When
+	r, err := s.unixClient.Get("http://_/groups/foo/partitions")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	owners := ParseJSONBody(c, r).(map[string]interface{})
+	topicOwners := owners["test.4"].([]interface{})
+	c.Assert(topicOwners, HasLen, 4)
+	for i, raw := range topicOwners {
+		po := raw.(map[string]interface{})
+		c.Check(po["partition"], Equals, float64(i))
+		c.Check(po["owner"], Equals, "pxyH_client_id")
+		claimedAt, err := time.Parse(time.RFC3339, po["claimed_at"].(string))
+		c.Check(err, IsNil)
+		c.Check(time.Since(claimedAt) < time.Minute, Equals, true)
+	}
+}
+
+// A rebalance caused by the group member joining the group is reported.
+// History is kept process wide, so the group is unique to the test.
+func (s *ServiceHTTPSuite) TestGetRebalances(c *C) {
+	s.kh.ResetOffsets("rebalances", "test.4")
+	s.kh.PutMessages("rebalances", "test.4", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=rebalances")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Get("http://_/groups/rebalances/rebalances")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	history := ParseJSONBody(c, r).([]interface{})
+	c.Assert(len(history) > 0, Equals, true)
+	rb := history[len(history)-1].(map[string]interface{})
+	c.Check(rb["trigger"], Equals, "membership_changed")
+	c.Check(rb["members_before"], DeepEquals, []interface{}{})
+	c.Check(rb["members_after"], DeepEquals, []interface{}{"pxyH_client_id"})
+	c.Check(rb["assigned"], DeepEquals, map[string]interface{}{
+		"test.4": []interface{}{float64(0), float64(1), float64(2), float64(3)},
+	})
+	startedAt, err := time.Parse(time.RFC3339, rb["started_at"].(string))
+	c.Check(err, IsNil)
+	c.Check(time.Since(startedAt) < time.Minute, Equals, true)
+}
+
+// A message consumed without an ack is reported as unacked until it is
+// acknowledged.
+func (s *ServiceHTTPSuite) TestGetGroupConcurrency(c *C) {
+	s.kh.ResetOffsets("concurrency", "test.1")
+	s.kh.PutMessages("concurrency", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=concurrency&noAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	time.Sleep(100 * time.Millisecond)
+
+	// When
+	r, err = s.unixClient.Get("http://_/groups/concurrency/concurrency")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	report := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(report["active_requests"], Equals, float64(0))
+	c.Check(report["unacked_msgs"], Equals, float64(1))
+	partitions := report["partitions"].([]interface{})
+	c.Assert(partitions, HasLen, 1)
+	c.Check(partitions[0].(map[string]interface{})["topic"], Equals, "test.1")
+	c.Check(partitions[0].(map[string]interface{})["unacked_msgs"], Equals, float64(1))
+}
+
+func (s *ServiceHTTPSuite) TestGetPartitionOwnersUnknownGroup(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/groups/no_such_group/partitions")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     "unknown consumer group no_such_group",
+		"code":      "not_found",
+		"retryable": false,
+	})
+}
+
+// If `group` parameter is not passed to `GET /topics/{}/consumers` then
+// a topic consumer report includes members of all consumer groups consuming
+// the topic.
+func (s *ServiceHTTPSuite) TestGetAllTopicConsumers(c *C) {
+	var wg sync.WaitGroup
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.ResetOffsets("bar", "test.1")
+	s.kh.ResetOffsets("bazz", "test.4")
+	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1})
+	s.kh.PutMessages("get.consumers", "test.1", map[string]int{"D": 1})
+
+	svc := make([]*T, 3)
+	for i := range svc {
+		i := i
+		actor.Spawn(s.ns.NewChild("spawn_svc"), &wg, func() {
+			svc[i] = spawnHTTPSvc(c, 55501+i)
+		})
+	}
+	wg.Wait()
+	defer svc[0].Stop()
+	defer svc[1].Stop()
+	defer svc[2].Stop()
+
+	for _, r := range []struct {
+		port  int
+		group string
+		topic string
+	}{
+		{port: 55501, group: "foo", topic: "test.4"},
+		{port: 55502, group: "foo", topic: "test.4"},
+		{port: 55503, group: "foo", topic: "test.4"},
+		{port: 55501, group: "bar", topic: "test.1"},
+		{port: 55502, group: "bar", topic: "test.1"},
+		{port: 55502, group: "bazz", topic: "test.4"},
+		{port: 55502, group: "bazz", topic: "test.4"},
+		{port: 55502, group: "bazz", topic: "test.4"},
+	} {
+		r := r
+		actor.Spawn(s.ns.NewChild("get"), &wg, func() {
+			_, err := s.tcpClient.Get(fmt.Sprintf(
+				"http://127.0.0.1:%d/topics/%s/messages?group=%s", r.port, r.topic, r.group))
+			c.Check(err, IsNil)
+		})
+	}
+	wg.Wait()
+
+	// When
+	r, err := s.tcpClient.Get("http://127.0.0.1:55501/topics/test.4/consumers")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+
+	consumers := ParseJSONBody(c, r).(map[string]interface{})
+	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
+		"foo": {
+			"C55501": {0, 1},
+			"C55502": {2},
+			"C55503": {3}},
+		"bazz": {
+			"C55502": {0, 1, 2, 3}},
+	})
+}
+
+// If consumers are requested for a particular group then only members of that
+// group are returned.
+func (s *ServiceHTTPSuite) TestGetTopicConsumers(c *C) {
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.ResetOffsets("bar", "test.4")
+	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1})
+
+	svc1 := spawnHTTPSvc(c, 55501)
+	defer svc1.Stop()
+	svc2 := spawnHTTPSvc(c, 55502)
+	defer svc2.Stop()
+
+	_, err := s.tcpClient.Get("http://127.0.0.1:55501/topics/test.4/messages?group=foo")
+	c.Check(err, IsNil)
+	_, err = s.tcpClient.Get("http://127.0.0.1:55502/topics/test.4/messages?group=foo")
+	c.Check(err, IsNil)
+
+	_, err = s.tcpClient.Get("http://127.0.0.1:55501/topics/test.1/messages?group=bar")
+	c.Check(err, IsNil)
+
+	// When
+	r, err := s.tcpClient.Get("http://127.0.0.1:55502/topics/test.4/consumers?group=foo")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	consumers := ParseJSONBody(c, r).(map[string]interface{})
+	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
+		"foo": {
+			"C55501": {0, 1},
+			"C55502": {2, 3}},
+	})
+}
+
+// A group member can subscribe to topics before consuming them and
+// unsubscribe from them without waiting for subscriptions to expire. Other
+// topics stay subscribed.
+func (s *ServiceHTTPSuite) TestSubscribeUnsubscribe(c *C) {
+	svc := spawnHTTPSvc(c, 55501)
+	defer svc.Stop()
+
+	// When
+	for _, topic := range []string{"test.1", "test.4"} {
+		r, err := s.tcpClient.Post("http://127.0.0.1:55501/topics/"+topic+"/subscription?group=subscriber", "text/plain", nil)
+		c.Assert(err, IsNil)
+		c.Check(r.StatusCode, Equals, http.StatusOK)
+		c.Check(ParseJSONBody(c, r), DeepEquals, httpsrv.EmptyResponse)
+	}
+
+	// Then
+	s.waitConsumers(c, "test.4", "subscriber", map[string][]int32{"C55501": {0, 1, 2, 3}})
+	s.waitConsumers(c, "test.1", "subscriber", map[string][]int32{"C55501": {0}})
+
+	// When
+	rq, _ := http.NewRequest("DELETE", "http://127.0.0.1:55501/topics/test.4/subscription?group=subscriber", nil)
+	r, err := s.tcpClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	s.waitConsumers(c, "test.4", "subscriber", nil)
+	s.waitConsumers(c, "test.1", "subscriber", map[string][]int32{"C55501": {0}})
+
+	// Unsubscribing from a topic that is not subscribed to is a noop.
+	rq, _ = http.NewRequest("DELETE", "http://127.0.0.1:55501/topics/test.4/subscription?group=subscriber", nil)
+	r, err = s.tcpClient.Do(rq)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+}
+
+// waitConsumers waits for partitions of a topic to be assigned to members of
+// a group as expected.
+func (s *ServiceHTTPSuite) waitConsumers(c *C, topic, group string, expected map[string][]int32) {
+	var consumers map[string]interface{}
+	for i := 0; i < 100; i++ {
+		r, err := s.tcpClient.Get("http://127.0.0.1:55501/topics/" + topic + "/consumers?group=" + group)
+		c.Assert(err, IsNil)
+		consumers, _ = ParseJSONBody(c, r).(map[string]interface{})
+		// Until the group is registered its consumers cannot be listed.
+		if r.StatusCode != http.StatusOK {
+			consumers = nil
+		}
+		if consumedPartitionsEqual(consumers[group], expected) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Fatalf("Unexpected consumers: topic=%s, got=%v, want=%v", topic, consumers, expected)
+}
+
+func consumedPartitionsEqual(groupConsumers interface{}, expected map[string][]int32) bool {
+	members, _ := groupConsumers.(map[string]interface{})
+	if len(members) != len(expected) {
+		return false
+	}
+	for clientID, expectedPartitions := range expected {
+		clientPartitions, _ := members[clientID].([]interface{})
+		if len(clientPartitions) != len(expectedPartitions) {
+			return false
+		}
+		for i, p := range clientPartitions {
+			if int32(p.(float64)) != expectedPartitions[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *ServiceHTTPSuite) TestGetTopics(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	var topics []string
+	ParseResponseBody(c, r, &topics)
+	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+}
+
+// Topics are listed in pages, and the response tells where the next page
+// starts, unless the page is the last one.
+func (s *ServiceHTTPSuite) TestGetTopicsPages(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		query  string
+		topics []string
+		next   string
+	}{
+		{query: "limit=2&after=test", topics: []string{"test.1", "test.4"}, next: "test.4"},
+		{query: "limit=2&after=test.1", topics: []string{"test.4", "test.64"}, next: ""},
+		{query: "after=test.4", topics: []string{"test.64"}, next: ""},
+		{query: "after=test.2", topics: []string{"test.4", "test.64"}, next: ""},
+	} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics?" + tc.query)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusOK, Commentf("case #%d", i))
+		c.Check(r.Header.Get("X-Next-After"), Equals, tc.next, Commentf("case #%d", i))
+		var topics []string
+		ParseResponseBody(c, r, &topics)
+		c.Check(topics, DeepEquals, tc.topics, Commentf("case #%d", i))
+	}
+}
+
+// Only selected fields of topic metadata are returned.
+func (s *ServiceHTTPSuite) TestGetTopicsFields(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics?withPartitions&withConfig&fields=partitions.partition&after=test.1&limit=1")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"test.4": map[string]interface{}{
+			"partitions": []interface{}{
+				map[string]interface{}{"partition": float64(0)},
+				map[string]interface{}{"partition": float64(1)},
+				map[string]interface{}{"partition": float64(2)},
+				map[string]interface{}{"partition": float64(3)},
+			},
+		},
+	})
+}
+
+func (s *ServiceHTTPSuite) TestGetTopicsListingInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		query  string
+		errMsg string
+	}{
+		{query: "limit=0", errMsg: "bad limit, must be > 0: 0"},
+		{query: "limit=foo", errMsg: "bad limit, must be > 0: foo"},
+		{query: "fields=config,,partitions", errMsg: "bad fields: config,,partitions"},
+		{query: "fields=partitions.", errMsg: "bad fields: partitions."},
+	} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics?" + tc.query)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Check(body["error"], Equals, tc.errMsg, Commentf("case #%d", i))
+	}
+}
+
+// Topics are listed from the metadata cache, and the cache can be refreshed
+// on demand.
+func (s *ServiceHTTPSuite) TestRefreshMetadata(c *C) {
+	s.proxyCfg.Kafka.MetadataCacheTTL = time.Hour
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics?withConfig")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Post("http://_/_refresh_metadata", "text/plain", nil)
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{})
+
+	r, err = s.unixClient.Get("http://_/topics")
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	var topics []string
+	ParseResponseBody(c, r, &topics)
+	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+}
+
+func (s *ServiceHTTPSuite) TestRefreshMetadataInvalidCluster(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/clusters/invalid/_refresh_metadata", "text/plain", nil)
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *ServiceHTTPSuite) TestFlush(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	for i := 0; i < 10; i++ {
+		s.unixClient.Post("http://_/topics/test.4/messages?key=1",
+			"text/plain", strings.NewReader(strconv.Itoa(i)))
+	}
+
+	// When
+	r, err := s.unixClient.Post("http://_/_flush?timeout=10s", "text/plain", nil)
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["flushed"], Equals, true)
+	c.Check(body["failed_msgs"], Equals, float64(0))
+	c.Check(body["stats"], DeepEquals, map[string]interface{}{
+		"queued_msgs":             float64(0),
+		"pending_msgs":            float64(0),
+		"pending_bytes":           float64(0),
+		"succeeded_msgs":          float64(10),
+		"failed_msgs":             float64(0),
+		"dead_lettered_msgs":      float64(0),
+		"dead_letter_failed_msgs": float64(0),
+	})
+
+	r, err = s.unixClient.Get("http://_/_producer")
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, body["stats"])
+}
+
+func (s *ServiceHTTPSuite) TestFlushBadTimeout(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/_flush?timeout=bar", "text/plain", nil)
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     "bad timeout: bar",
+		"code":      "invalid_argument",
+		"retryable": false,
+	})
+}
+
+func (s *ServiceHTTPSuite) TestGetTopicsWithPartitions(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	rs, err := s.unixClient.Get("http://_/topics?withPartitions=true")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(rs.StatusCode, Equals, http.StatusOK)
+
+	var topicsWithPartition map[string]struct {
+		Config     *struct{} `json:"config"`
+		Partitions []struct {
+			Partition int   `json:"partition"`
+			Leader    int   `json:"leader"`
+			Replicas  []int `json:"replicas"`
+			ISR       []int `json:"isr"`
+		} `json:"partitions"`
+	}
+	ParseResponseBody(c, rs, &topicsWithPartition)
+
+	var topics []string
+	for topic := range topicsWithPartition {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+
+	for topic, topicMeta := range topicsWithPartition {
+		c.Check(topicMeta.Config, IsNil)
+		expectedPartitionCount := map[string]int{
+			"__consumer_offsets": 50,
+			"test.1":             1,
+			"test.4":             4,
+			"test.64":            64,
+		}[topic]
+		c.Check(len(topicMeta.Partitions), Equals, expectedPartitionCount)
+
+		// Get replication factor used, when bootstrapping the cluster.
+		defaultReplicationFactorStr := os.Getenv("REPLICATION_FACTOR")
+		defaultReplicationFactor, _ := strconv.Atoi(defaultReplicationFactorStr)
+		if defaultReplicationFactor == 0 {
+			defaultReplicationFactor = 2
+		}
+
+		for _, partitionMeta := range topicMeta.Partitions {
+			replicationFactor := map[string]int{
+				"__consumer_offsets": 3,
+				"test.1":             defaultReplicationFactor,
+				"test.4":             defaultReplicationFactor,
+				"test.64":            defaultReplicationFactor,
+			}[topic]
+			c.Logf("Checking: topic=%v, partition=%v", topic, partitionMeta)
+			c.Check(len(partitionMeta.Replicas), Equals, replicationFactor)
+			c.Check(len(partitionMeta.ISR), Equals, replicationFactor)
+		}
+	}
+}
+
+func (s *ServiceHTTPSuite) TestGetTopicsWithConfig(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	rs, err := s.unixClient.Get("http://_/topics?withConfig")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(rs.StatusCode, Equals, http.StatusOK)
+
+	var topicsWithConfig map[string]struct {
+		Config struct {
+			Version int               `json:"version"`
+			Config  map[string]string `json:"config"`
+		} `json:"config"`
+		Partitions []struct{} `json:"partitions"`
+	}
+	ParseResponseBody(c, rs, &topicsWithConfig)
+
+	var topics []string
+	for topic := range topicsWithConfig {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+
+	for topic, topicMeta := range topicsWithConfig {
+		c.Check(topicMeta.Partitions, IsNil)
+		c.Check(topicMeta.Config.Version, Equals, 1)
+		c.Check(topicMeta.Config.Config, DeepEquals,
+			map[string]map[string]string{
+				"__consumer_offsets": {
+					"cleanup.policy":   "compact",
+					"compression.type": "producer",
+					"segment.bytes":    "104857600"},
+				"test.1":  {},
+				"test.4":  {},
+				"test.64": {},
+			}[topic])
+	}
+}
+
+func (s *ServiceHTTPSuite) TestGetTopicsWithPartitionsAndWithConfig(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	rs, err := s.unixClient.Get("http://_/topics?withPartitions&withConfig")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(rs.StatusCode, Equals, http.StatusOK)
+
+	var topicsWithConfig map[string]struct {
+		Config struct {
+			Version int               `json:"version"`
+			Config  map[string]string `json:"config"`
+		} `json:"config"`
+		Partitions []struct{} `json:"partitions"`
+	}
+	ParseResponseBody(c, rs, &topicsWithConfig)
+
+	var topics []string
+	for topic := range topicsWithConfig {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+
+	for _, topicMeta := range topicsWithConfig {
+		c.Check(topicMeta.Partitions, NotNil)
+		c.Check(topicMeta.Config.Version, NotNil)
+	}
+}
+
+// Reported partition lags are correct, including those corresponding to -1 and
+// -2 special case offset values.
+func (s *ServiceHTTPSuite) TestHealthCheck(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/_ping")
+
+	// Then
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(r.Header.Get("X-Kafka-Versions"), Equals, "pxyH="+s.proxyCfg.Kafka.Version.String())
+	body, err := ioutil.ReadAll(r.Body)
+	c.Check(err, IsNil)
+	c.Check(string(body), Equals, "pong")
+}
+
+// While the service drains on shutdown the health check fails, but requests
+// are still served.
+func (s *ServiceHTTPSuite) TestHealthCheckDraining(c *C) {
+	s.cfg.DrainPeriod = time.Second
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	stoppedCh := make(chan struct{})
+
+	// When
+	go func() {
+		svc.Stop()
+		close(stoppedCh)
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	// Then
+	r, err := s.unixClient.Get("http://_/_ping")
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	body, err := ioutil.ReadAll(r.Body)
+	c.Check(err, IsNil)
+	c.Check(string(body), Equals, "draining")
+	r, err = s.unixClient.Get("http://_/topics")
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	<-stoppedCh
+}
+
+// The state endpoint reports running group and partition consumers.
+func (s *ServiceHTTPSuite) TestGetState(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("state", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Get("http://_/_state")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(r.Body)
+	c.Assert(err, IsNil)
+	c.Check(string(body), Matches, `(?s)\{"goroutines":[1-9][0-9]*,"actors":\{"name":"/".*`)
+	c.Check(string(body), Matches, `(?s).*"name":"[^"]*/foo\.0/test\.1\.0".*`)
+	c.Check(string(body), Matches, `(?s).*"name":"[^"]*/test\.1\.p0\.0","running":1,.*"queues":\{"events":[0-9]+,"messages":[0-9]+\}.*`)
+}
+
+// Offsets are translated by timestamps of the messages to be consumed next.
+// The mirror cluster is emulated by another proxy to the same Kafka cluster.
+func (s *ServiceHTTPSuite) TestTranslateOffsets(c *C) {
+	s.cfg.Proxies["pxyM"] = testhelpers.NewTestProxyCfg("pxyM_client_id")
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	s.kh.PutMessages("translate", "test.1", map[string]int{"A": 1})
+	time.Sleep(50 * time.Millisecond)
+	s.kh.PutMessages("translate", "test.1", map[string]int{"B": 1})
+	s.kh.SetOffsetValues("foo", "test.1", []int64{offsetsBefore[0] + 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/translate?group=foo&target=pxyM",
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	var translations []struct {
+		Partition    int32      `json:"partition"`
+		SourceOffset int64      `json:"source_offset"`
+		Timestamp    *time.Time `json:"timestamp"`
+		TargetOffset int64      `json:"target_offset"`
+	}
+	ParseResponseBody(c, r, &translations)
+	c.Assert(len(translations), Equals, 1)
+	c.Check(translations[0].SourceOffset, Equals, offsetsBefore[0]+1)
+	c.Check(translations[0].Timestamp, NotNil)
+	c.Check(translations[0].TargetOffset, Equals, offsetsBefore[0]+1)
+}
+
+// A standby does not listen on API addresses until the active instance goes
+// away.
+func (s *ServiceHTTPSuite) TestStandby(c *C) {
+	s.cfg.Standby.Election = fmt.Sprintf("standby-%d", time.Now().UnixNano())
+	svc1, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	time.Sleep(500 * time.Millisecond)
+	r, err := s.unixClient.Get("http://_/_ping")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	svc2, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc2.Stop()
+
+	// When
+	svc1.Stop()
+	time.Sleep(500 * time.Millisecond)
+
+	// Then
+	r, err = s.tcpClient.Get("http://127.0.0.1:19092/_ping")
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+}
+
+// Offsets committed by a group are committed on behalf of a new group.
+func (s *ServiceHTTPSuite) TestCloneOffsets(c *C) {
+	clone := fmt.Sprintf("clone-%d", time.Now().UnixNano())
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	s.kh.SetOffsetValues("foo", "test.1", offsetsBefore)
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/clone?group=foo&clone="+clone,
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	var cloned []struct {
+		Partition int32 `json:"partition"`
+		Offset    int64 `json:"offset"`
+	}
+	ParseResponseBody(c, r, &cloned)
+	c.Assert(len(cloned), Equals, len(offsetsBefore))
+	offsetsAfter := s.kh.GetCommittedOffsets(clone, "test.1")
+	for i, po := range cloned {
+		c.Check(po.Offset, Equals, offsetsBefore[po.Partition], Commentf("case #%d", i))
+		c.Check(offsetsAfter[po.Partition].Val, Equals, offsetsBefore[po.Partition], Commentf("case #%d", i))
+	}
+
+	// When: cloned again into the same group
+	r, err = s.unixClient.Post("http://_/topics/test.1/offsets/clone?group=foo&clone="+clone,
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals,
+		fmt.Sprintf("group %s has offsets committed for test.1", clone))
+}
+
+// While a group is frozen, offsets of consumed messages are not committed.
+func (s *ServiceHTTPSuite) TestFreezeGroup(c *C) {
+	group := fmt.Sprintf("freeze-%d", time.Now().UnixNano())
+	s.kh.ResetOffsets(group, "test.1")
+	s.kh.PutMessages("freeze", "test.1", map[string]int{"A": 3})
+	offsetsBefore := s.kh.GetCommittedOffsets(group, "test.1")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+
+	// When
+	r, err := s.unixClient.Post("http://_/groups/"+group+"/freeze?for=1h", "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r).(map[string]interface{})["frozen"], Equals, true)
+	r, err = s.unixClient.Get("http://_/groups/" + group + "/freeze")
+	c.Assert(err, IsNil)
+	c.Check(ParseJSONBody(c, r).(map[string]interface{})["frozen"], Equals, true)
+
+	// When
+	for i := 0; i < 3; i++ {
+		r, err = s.unixClient.Get("http://_/topics/test.1/messages?group=" + group)
+		c.Assert(err, IsNil)
+		c.Check(r.StatusCode, Equals, http.StatusOK, Commentf("case #%d", i))
+	}
+	svc.Stop()
+
+	// Then
+	offsetsAfter := s.kh.GetCommittedOffsets(group, "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val)
+
+	// When
+	svc, err = Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	rq, _ := http.NewRequest("DELETE", "http://_/groups/"+group+"/freeze", nil)
+	r, err = s.unixClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{"frozen": false})
+	r, err = s.unixClient.Do(rq)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+}
+
+// While a group is migrated to another name, neither of the names is offered
+// messages. Once the migration is completed, offsets are copied to the new
+// name, and only the new name is.
+func (s *ServiceHTTPSuite) TestMigrateGroup(c *C) {
+	group := fmt.Sprintf("migrate-%d", time.Now().UnixNano())
+	newGroup := group + "-new"
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	s.kh.SetOffsetValues(group, "test.1", offsetsBefore)
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/groups/"+group+"/migration?toGroup="+newGroup, "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, proxy.ErrBadMigration.Error())
+
+	// When
+	r, err = s.unixClient.Post("http://_/groups/"+group+"/migration?toGroup="+newGroup+"&topic=test.1",
+		"text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["phase"], Equals, "paused")
+	c.Check(body["to_group"], Equals, newGroup)
+	s.waitConsumeStatus(c, group, http.StatusServiceUnavailable)
+	s.waitConsumeStatus(c, newGroup, http.StatusServiceUnavailable)
+
+	// When
+	r, err = s.unixClient.Post("http://_/groups/"+group+"/migration/complete", "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	var completed struct {
+		Phase  string `json:"phase"`
+		Copied map[string][]struct {
+			Partition int32 `json:"partition"`
+			Offset    int64 `json:"offset"`
+		} `json:"copied"`
+	}
+	ParseResponseBody(c, r, &completed)
+	c.Check(completed.Phase, Equals, "completed")
+	c.Assert(len(completed.Copied["test.1"]), Equals, len(offsetsBefore))
+	offsetsAfter := s.kh.GetCommittedOffsets(newGroup, "test.1")
+	for i, po := range completed.Copied["test.1"] {
+		c.Check(po.Offset, Equals, offsetsBefore[po.Partition], Commentf("case #%d", i))
+		c.Check(offsetsAfter[po.Partition].Val, Equals, offsetsBefore[po.Partition], Commentf("case #%d", i))
+	}
+	s.waitConsumeStatus(c, group, http.StatusGone)
+	s.waitConsumeStatus(c, newGroup, http.StatusOK)
+
+	// When
+	rq, _ := http.NewRequest("DELETE", "http://_/groups/"+group+"/migration", nil)
+	r, err = s.unixClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/groups/" + group + "/migration")
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	s.waitConsumeStatus(c, group, http.StatusOK)
+}
+
+// waitConsumeStatus waits for consume requests of a group to be validated
+// with the expected status, for migrations are handed over asynchronously.
+func (s *ServiceHTTPSuite) waitConsumeStatus(c *C, group string, expected int) {
+	for deadline := time.Now().Add(3 * time.Second); ; {
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages/_validate?group=" + group)
+		c.Assert(err, IsNil)
+		r.Body.Close()
+		if r.StatusCode == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.Fatalf("Consume status of %s is %d, while %d expected", group, r.StatusCode, expected)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// The default cluster can be repointed at runtime, aliases resolve to the
+// clusters behind them, and the configured default can be restored.
+func (s *ServiceHTTPSuite) TestDefaultCluster(c *C) {
+	s.cfg.Proxies["pxyM"] = testhelpers.NewTestProxyCfg("pxyM_client_id")
+	s.cfg.ClusterAliases = map[string]string{"main": "pxyM"}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	aliases := map[string]interface{}{"main": "pxyM"}
+
+	r, err := s.unixClient.Get("http://_/_default_cluster")
+	c.Assert(err, IsNil)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{"cluster": "pxyH", "aliases": aliases})
+
+	// When
+	rq, _ := http.NewRequest("PUT", "http://_/_default_cluster?cluster=main", nil)
+	r, err = s.unixClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{"cluster": "pxyM", "aliases": aliases})
+	r, err = s.unixClient.Get("http://_/clusters/main/topics/test.1")
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	rq, _ = http.NewRequest("DELETE", "http://_/_default_cluster", nil)
+	r, err = s.unixClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{"cluster": "pxyH", "aliases": aliases})
+
+	// When
+	rq, _ = http.NewRequest("PUT", "http://_/_default_cluster?cluster=bazz", nil)
+	r, err = s.unixClient.Do(rq)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+}
+
+// Produce requests that do not select a cluster are routed by a header value.
+func (s *ServiceHTTPSuite) TestProduceRouting(c *C) {
+	if !s.proxyCfg.Kafka.Version.IsAtLeast(sarama.V0_11_0_0) {
+		c.Skip("Headers not supported before Kafka v0.11")
+	}
+	s.cfg.Proxies["pxyM"] = testhelpers.NewTestProxyCfg("pxyM_client_id")
+	s.cfg.ProduceRouting = config.ProduceRouting{
+		Header: "region",
+		Routes: map[string]string{"eu": "pxyM"},
+	}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	for _, region := range []string{"eu", "eu", "us", ""} {
+		req, err := http.NewRequest("POST", "http://_/topics/test.1/messages?sync", strings.NewReader("test"))
+		c.Assert(err, IsNil)
+		req.Header.Add("Content-Type", "text/plain")
+		if region != "" {
+			req.Header.Add("X-Kafka-Region", base64.StdEncoding.EncodeToString([]byte(region)))
+		}
+		rs, err := s.unixClient.Do(req)
+		c.Assert(err, IsNil)
+		c.Check(rs.StatusCode, Equals, http.StatusOK)
+	}
+
+	// Then
+	r, err := s.unixClient.Get("http://_/_produce_routes")
+	c.Assert(err, IsNil)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"header": "region",
+		"routes": []interface{}{
+			map[string]interface{}{"header_value": "eu", "cluster": "pxyM", "routed": float64(2)},
+		},
+		"default_route": map[string]interface{}{"cluster": "pxyH", "routed": float64(2)},
+	})
+}
+
+func (s *ServiceHTTPSuite) TestTranslateOffsetsSameCluster(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/translate?group=foo&target=pxyH",
+		"text/plain", nil)
+
 	// Then
-	c.Check(err, IsNil)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     "target cluster is the same as the source one",
+		"code":      "invalid_argument",
+		"retryable": false,
+	})
+}
+
+// Groups that have members are not reported as idle.
+func (s *ServiceHTTPSuite) TestGetIdleGroups(c *C) {
+	s.proxyCfg.GroupJanitor.Retention = time.Hour
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("idle-groups", "test.1", map[string]int{"A": 1})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Get("http://_/idle_groups")
+
+	// Then
+	c.Assert(err, IsNil)
 	c.Check(r.StatusCode, Equals, http.StatusOK)
-	consumers := ParseJSONBody(c, r).(map[string]interface{})
-	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
-		"foo": {
-			"pxyH_client_id": {0, 1, 2, 3}},
+	var idleGroups []struct {
+		Group     string    `json:"group"`
+		IdleSince time.Time `json:"idle_since"`
+		PurgeAt   time.Time `json:"purge_at"`
+	}
+	ParseResponseBody(c, r, &idleGroups)
+	for _, idleGroup := range idleGroups {
+		c.Check(idleGroup.Group, Not(Equals), "foo")
+		c.Check(idleGroup.PurgeAt.Sub(idleGroup.IdleSince), Equals, time.Hour)
+	}
+}
+
+func (s *ServiceHTTPSuite) TestGetIdleGroupsDisabled(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/idle_groups")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrDisabled.Error(),
+		"code":      "unimplemented",
+		"retryable": false,
 	})
 }
 
-// If `group` parameter is not passed to `GET /topics/{}/consumers` then
-// a topic consumer report includes members of all consumer groups consuming
-// the topic.
-func (s *ServiceHTTPSuite) TestGetAllTopicConsumers(c *C) {
-	var wg sync.WaitGroup
-	s.kh.ResetOffsets("foo", "test.4")
-	s.kh.ResetOffsets("bar", "test.1")
-	s.kh.ResetOffsets("bazz", "test.4")
-	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1})
-	s.kh.PutMessages("get.consumers", "test.1", map[string]int{"D": 1})
+// Lag objectives of groups that have more messages to consume than allowed
+// fire once they are checked.
+func (s *ServiceHTTPSuite) TestGetGroupLagSLO(c *C) {
+	s.proxyCfg.LagSLO.CheckInterval = 100 * time.Millisecond
+	s.proxyCfg.LagSLO.Groups = map[string]config.LagSLO{
+		"lag-slo": {Topics: []string{"test.1"}, MaxLagMessages: 3},
+	}
+	s.kh.ResetOffsets("lag-slo", "test.1")
+	s.kh.PutMessages("lag-slo", "test.1", map[string]int{"A": 5})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
 
-	svc := make([]*T, 3)
-	for i := range svc {
-		i := i
-		actor.Spawn(s.ns.NewChild("spawn_svc"), &wg, func() {
-			svc[i] = spawnHTTPSvc(c, 55501+i)
-		})
+	var lagSLO map[string]interface{}
+	for i := 0; i < 50; i++ {
+		// When
+		r, err := s.unixClient.Get("http://_/groups/lag-slo/lag_slo")
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+		lagSLO = ParseJSONBody(c, r).(map[string]interface{})
+		if lagSLO["status"] == "firing" {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	wg.Wait()
-	defer svc[0].Stop()
-	defer svc[1].Stop()
-	defer svc[2].Stop()
+	c.Check(lagSLO["status"], Equals, "firing")
+	c.Check(lagSLO["lag_messages"], Equals, float64(5))
+	c.Check(lagSLO["long_burn_rate"], Not(Equals), float64(0))
 
-	for _, r := range []struct {
-		port  int
-		group string
-		topic string
+	// When
+	r, err := s.unixClient.Get("http://_/lag_slos")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	lagSLOs := ParseJSONBody(c, r).([]interface{})
+	c.Assert(lagSLOs, HasLen, 1)
+	c.Check(lagSLOs[0].(map[string]interface{})["group"], Equals, "lag-slo")
+
+	// When
+	r, err = s.unixClient.Get("http://_/groups/foo/lag_slo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     lagslo.ErrNotFound.Error(),
+		"code":      "not_found",
+		"retryable": false,
+	})
+}
+
+func (s *ServiceHTTPSuite) TestGetLagSLOsDisabled(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/lag_slos")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrDisabled.Error(),
+		"code":      "unimplemented",
+		"retryable": false,
+	})
+}
+
+// Messages of an archived topic are uploaded to object storage, and the
+// archive progress is reported by the archives endpoint.
+func (s *ServiceHTTPSuite) TestArchive(c *C) {
+	var objectsMu sync.Mutex
+	objects := make(map[string][]byte)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		objectsMu.Lock()
+		objects[r.URL.Path] = body
+		objectsMu.Unlock()
+	}))
+	defer storage.Close()
+	s.proxyCfg.Archives = map[string]config.Archive{
+		"archive-test": {Topic: "test.1", MaxFileSize: 1, Endpoint: storage.URL, Bucket: "archive"},
+	}
+	s.kh.ResetOffsets("archive-test", "test.1")
+	produced := s.kh.PutMessages("archive", "test.1", map[string]int{"A": 3})
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	var archive map[string]interface{}
+	for i := 0; i < 50; i++ {
+		// When
+		r, err := s.unixClient.Get("http://_/archives")
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+		archives := ParseJSONBody(c, r).([]interface{})
+		c.Assert(archives, HasLen, 1)
+		archive = archives[0].(map[string]interface{})
+		if archive["uploaded_messages"] == float64(3) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Check(archive["name"], Equals, "archive-test")
+	c.Check(archive["topic"], Equals, "test.1")
+	c.Check(archive["uploaded_files"], Equals, float64(3))
+	c.Check(archive["uploaded_messages"], Equals, float64(3))
+	c.Check(archive["lag"], Equals, float64(0))
+	objectsMu.Lock()
+	defer objectsMu.Unlock()
+	for _, msg := range produced["A"] {
+		name := fmt.Sprintf("/archive/test.1/%d/%020d.jsonl", msg.Partition, msg.Offset)
+		c.Check(string(objects[name]), Matches, `\{"topic":"test.1",.*"value":".*"\}\n`, Commentf(name))
+	}
+}
+
+// Archived messages are replayed to a topic, and the replay progress is
+// reported.
+func (s *ServiceHTTPSuite) TestArchiveReplay(c *C) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("list-type") == "2":
+			fmt.Fprint(w, "<ListBucketResult><Contents><Key>test.1/0/00000000000000000010.jsonl</Key></Contents></ListBucketResult>")
+		case r.URL.Path == "/archive/test.1/0/00000000000000000010.jsonl":
+			fmt.Fprint(w, ""+
+				`{"topic":"test.1","partition":0,"offset":10,"timestamp":"2019-08-01T11:00:00Z","key":"YQ==","value":"Zm9v"}`+"\n"+
+				`{"topic":"test.1","partition":0,"offset":11,"timestamp":"2019-08-01T11:00:00Z","key":"Yg==","value":"YmFy"}`+"\n")
+		default:
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+		}
+	}))
+	defer storage.Close()
+	s.proxyCfg.Archives = map[string]config.Archive{
+		"archive-test": {Topic: "test.1", Group: "archive-test", Endpoint: storage.URL, Bucket: "archive"},
+	}
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/archives/archive-test/replays?topic=test.4", "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	replay := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(replay["id"], Equals, "1")
+	c.Check(replay["topic"], Equals, "test.4")
+	c.Check(replay["cluster"], Equals, "pxyH")
+	for i := 0; i < 50 && replay["state"] == "running"; i++ {
+		time.Sleep(100 * time.Millisecond)
+		r, err = s.unixClient.Get("http://_/archives/archive-test/replays/1")
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+		replay = ParseJSONBody(c, r).(map[string]interface{})
+	}
+	c.Check(replay["state"], Equals, "completed")
+	c.Check(replay["files"], Equals, float64(1))
+	c.Check(replay["files_read"], Equals, float64(1))
+	c.Check(replay["produced_messages"], Equals, float64(2))
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+	var producedCount int64
+	for p := range offsetsBefore {
+		producedCount += offsetsAfter[p] - offsetsBefore[p]
+	}
+	c.Check(producedCount, Equals, int64(2))
+
+	r, err = s.unixClient.Get("http://_/archives/archive-test/replays")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Check(ParseJSONBody(c, r), HasLen, 1)
+}
+
+func (s *ServiceHTTPSuite) TestArchiveReplayErrors(c *C) {
+	s.proxyCfg.Archives = map[string]config.Archive{
+		"archive-test": {Topic: "test.1", Endpoint: "http://127.0.0.1:1", Bucket: "archive"},
+	}
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		method string
+		url    string
+		status int
 	}{
-		{port: 55501, group: "foo", topic: "test.4"},
-		{port: 55502, group: "foo", topic: "test.4"},
-		{port: 55503, group: "foo", topic: "test.4"},
-		{port: 55501, group: "bar", topic: "test.1"},
-		{port: 55502, group: "bar", topic: "test.1"},
-		{port: 55502, group: "bazz", topic: "test.4"},
-		{port: 55502, group: "bazz", topic: "test.4"},
-		{port: 55502, group: "bazz", topic: "test.4"},
+		{"POST", "http://_/archives/bazz/replays", http.StatusNotFound},
+		{"POST", "http://_/archives/archive-test/replays?fromOffset=foo", http.StatusBadRequest},
+		{"POST", "http://_/archives/archive-test/replays?fromOffset=10&toOffset=5", http.StatusBadRequest},
+		{"POST", "http://_/archives/archive-test/replays?rate=-1", http.StatusBadRequest},
+		{"POST", "http://_/archives/archive-test/replays?target=bazz", http.StatusBadRequest},
+		{"GET", "http://_/archives/bazz/replays", http.StatusNotFound},
+		{"GET", "http://_/archives/archive-test/replays/7", http.StatusNotFound},
+		{"DELETE", "http://_/archives/archive-test/replays/7", http.StatusNotFound},
 	} {
-		r := r
-		actor.Spawn(s.ns.NewChild("get"), &wg, func() {
-			_, err := s.tcpClient.Get(fmt.Sprintf(
-				"http://127.0.0.1:%d/topics/%s/messages?group=%s", r.port, r.topic, r.group))
-			c.Check(err, IsNil)
-		})
+		rq, err := http.NewRequest(tc.method, tc.url, nil)
+		c.Assert(err, IsNil)
+
+		// When
+		r, err := s.unixClient.Do(rq)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		r.Body.Close()
+	}
+}
+
+// If neither TLS nor SASL is enabled, then there are no credentials to
+// report or update.
+func (s *ServiceHTTPSuite) TestCredentialsDisabled(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, method := range []string{"GET", "PUT"} {
+		rq, err := http.NewRequest(method, "http://_/_credentials", strings.NewReader(`{"password":"secret"}`))
+		c.Assert(err, IsNil)
+
+		// When
+		r, err := s.unixClient.Do(rq)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+			"error":     proxy.ErrDisabled.Error(),
+			"code":      "unimplemented",
+			"retryable": false,
+		}, Commentf("case #%d", i))
+	}
+}
+
+// If a credential file cannot be read, then the service fails to start.
+func (s *ServiceHTTPSuite) TestCredentialsMissingFile(c *C) {
+	s.proxyCfg.Kafka.SASL.Enabled = true
+	s.proxyCfg.Kafka.SASL.User = "alice"
+	s.proxyCfg.Kafka.SASL.PasswordPath = "/no/such/file"
+
+	// When
+	_, err := Spawn(s.cfg)
+
+	// Then
+	c.Check(err, ErrorMatches, "failed to spawn proxy, name=pxyH: failed to set up Kafka security: "+
+		"failed to read file: open /no/such/file: no such file or directory")
+}
+
+func (s *ServiceHTTPSuite) TestGetArchivesDisabled(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/archives")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     proxy.ErrDisabled.Error(),
+		"code":      "unimplemented",
+		"retryable": false,
+	})
+}
+
+// Lifecycle events are streamed via the events endpoint and produced to the
+// configured topic.
+func (s *ServiceHTTPSuite) TestLifecycleEvents(c *C) {
+	s.proxyCfg.LifecycleEvents.Topic = "test.4"
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("lifecycle", "test.1", map[string]int{"A": 1})
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/_events?type=partition_claimed&type=rebalance_finished")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Check(r.Header.Get("Content-Type"), Equals, "text/event-stream")
+	linesCh := make(chan string, 100)
+	go func() {
+		defer close(linesCh)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			linesCh <- scanner.Text()
+		}
+	}()
+
+	// When
+	r, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// Then
+	var types []string
+	for len(types) < 2 {
+		select {
+		case line := <-linesCh:
+			if strings.HasPrefix(line, "event: ") {
+				types = append(types, strings.TrimPrefix(line, "event: "))
+			}
+		case <-time.After(10 * time.Second):
+			c.Fatalf("events not received: %v", types)
+		}
+	}
+	sort.Strings(types)
+	c.Check(types, DeepEquals, []string{"partition_claimed", "rebalance_finished"})
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+	produced := 0
+	for i := range offsetsAfter {
+		produced += int(offsetsAfter[i] - offsetsBefore[i])
+	}
+	c.Check(produced >= 2, Equals, true)
+}
+
+// A tap streams copies of messages produced to a topic as JSON lines, and
+// ends the stream when the requested number of messages is tapped.
+func (s *ServiceHTTPSuite) TestTap(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/_tap?count=2&direction=produce")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Check(r.Header.Get("Content-Type"), Equals, "application/x-ndjson")
+
+	// When
+	for _, value := range []string{"m1", "m2", "m3"} {
+		rs, err := s.unixClient.Post("http://_/topics/test.1/messages?sync", "text/plain", strings.NewReader(value))
+		c.Assert(err, IsNil)
+		c.Assert(rs.StatusCode, Equals, http.StatusOK)
+	}
+
+	// Then
+	var values []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var msg map[string]interface{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), &msg), IsNil)
+		c.Check(msg["direction"], Equals, "produce")
+		c.Check(msg["topic"], Equals, "test.1")
+		value, err := base64.StdEncoding.DecodeString(msg["value"].(string))
+		c.Assert(err, IsNil)
+		values = append(values, string(value))
+	}
+	c.Check(values, DeepEquals, []string{"m1", "m2"})
+}
+
+func (s *ServiceHTTPSuite) TestTapInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		params string
+		error  string
+	}{
+		{params: "count=0", error: "bad count, must be from 1 to 1000: 0"},
+		{params: "count=1001", error: "bad count, must be from 1 to 1000: 1001"},
+		{params: "direction=sideways", error: "bad direction: sideways"},
+		{params: "timeout=-1s", error: "bad timeout: -1s"},
+	} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.1/_tap?" + tc.params)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Check(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		c.Check(ParseJSONBody(c, r).(map[string]interface{})["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Tenants use logical topic and group names that are mapped to physical ones
+// by prefixing them with the tenant prefix.
+func (s *ServiceHTTPSuite) TestTenants(c *C) {
+	s.cfg.Tenants = map[string]config.Tenant{"acme": {Prefix: "test.", APIKeys: []string{"k1"}}}
+	s.kh.ResetOffsets("test.foo", "test.1")
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	do := func(method, url, apiKey string) *http.Response {
+		var body io.Reader
+		if method == http.MethodPost {
+			body = strings.NewReader("Bazinga!")
+		}
+		rq, err := http.NewRequest(method, url, body)
+		c.Assert(err, IsNil)
+		rq.Header.Set("Content-Type", "text/plain")
+		if apiKey != "" {
+			rq.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		r, err := s.unixClient.Do(rq)
+		c.Assert(err, IsNil)
+		return r
+	}
+
+	// When/Then
+	r := do(http.MethodPost, "http://_/topics/1/messages?sync", "")
+	c.Check(r.StatusCode, Equals, http.StatusUnauthorized)
+	r = do(http.MethodPost, "http://_/topics/1/messages?sync", "k2")
+	c.Check(r.StatusCode, Equals, http.StatusUnauthorized)
+	r = do(http.MethodGet, "http://_/_state", "k1")
+	c.Check(r.StatusCode, Equals, http.StatusForbidden)
+	r = do(http.MethodGet, "http://_/_ping", "")
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+
+	r = do(http.MethodPost, "http://_/topics/1/messages?sync", "k1")
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	offsetsAfter := s.kh.GetNewestOffsets("test.1")
+	c.Check(offsetsAfter[0], Equals, offsetsBefore[0]+1)
+
+	r = do(http.MethodGet, "http://_/topics/1/messages?group=foo", "k1")
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(string(ParseConsRes(c, r).Message), Equals, "Bazinga!")
+
+	r = do(http.MethodGet, "http://_/topics", "k1")
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	var topics []string
+	c.Assert(json.NewDecoder(r.Body).Decode(&topics), IsNil)
+	sort.Strings(topics)
+	c.Check(topics[:3], DeepEquals, []string{"1", "4", "64"})
+	for _, topic := range topics {
+		c.Check(strings.HasPrefix(topic, "test."), Equals, false, Commentf(topic))
+		c.Check(strings.HasPrefix(topic, "__"), Equals, false, Commentf(topic))
+	}
+
+	r = do(http.MethodGet, "http://_/topics/1/consumers", "k1")
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	var consumers map[string]interface{}
+	c.Assert(json.NewDecoder(r.Body).Decode(&consumers), IsNil)
+	c.Check(consumers["foo"], NotNil)
+	c.Check(consumers["test.foo"], IsNil)
+}
+
+// Ensure that API endpoints that explicitly select a proxy to operate on work.
+func (s *ServiceHTTPSuite) TestExplicitProxyAPIEndpoints(c *C) {
+	s.kh.ResetOffsets("foo", "test.1")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+
+	// When/Then
+	r, err := s.unixClient.Post("http://_/clusters/pxyH/topics/test.1/messages?sync", "text/plain", strings.NewReader("Bazinga!"))
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(int(body["partition"].(float64)), Equals, 0)
+	prodOffset := int64(body["offset"].(float64))
+
+	r, err = s.unixClient.Get("http://_/clusters/pxyH/topics/test.1/messages?group=foo")
+	c.Check(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	body = ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(ParseBase64(c, body["value"].(string)), Equals, "Bazinga!")
+	c.Check(int64(body["offset"].(float64)), Equals, prodOffset)
+}
+
+// Messages published via Pub/Sub compatible API can be pulled and
+// acknowledged via the same API.
+func (s *ServiceHTTPSuite) TestPubSubPublishPullAck(c *C) {
+	s.cfg.PubSub.Enabled = true
+	s.cfg.PubSub.Subscriptions = map[string]config.PubSubSubscription{
+		"sub1": {Topic: "test.1", Group: "foo"},
 	}
-	wg.Wait()
+	s.kh.ResetOffsets("foo", "test.1")
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetCommittedOffsets("foo", "test.1")
 
 	// When
-	r, err := s.tcpClient.Get("http://127.0.0.1:55501/topics/test.4/consumers")
+	r, err := s.unixClient.Post("http://_/v1/projects/pxyH/topics/test.1:publish", "application/json",
+		strings.NewReader(`{"messages": [{"data": "QmF6aW5nYSE="}]}`))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	pubRs := ParseJSONBody(c, r).(map[string]interface{})
+	msgID := pubRs["messageIds"].([]interface{})[0].(string)
+
+	r, err = s.unixClient.Post("http://_/v1/projects/pxyH/subscriptions/sub1:pull", "application/json",
+		strings.NewReader(`{"maxMessages": 10}`))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	pullRs := ParseJSONBody(c, r).(map[string]interface{})
+	received := pullRs["receivedMessages"].([]interface{})[0].(map[string]interface{})
+	msg := received["message"].(map[string]interface{})
+	ackID := received["ackId"].(string)
+
+	r, err = s.unixClient.Post("http://_/v1/projects/pxyH/subscriptions/sub1:acknowledge", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"ackIds": [%q]}`, ackID)))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	svc.Stop()
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(msg["messageId"], Equals, msgID)
+	c.Check(ParseBase64(c, msg["data"].(string)), Equals, "Bazinga!")
+	offsetsAfter := s.kh.GetCommittedOffsets("foo", "test.1")
+	c.Check(offsetsAfter[0].Val, Equals, offsetsBefore[0].Val+1)
+}
 
-	consumers := ParseJSONBody(c, r).(map[string]interface{})
-	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
-		"foo": {
-			"C55501": {0, 1},
-			"C55502": {2},
-			"C55503": {3}},
-		"bazz": {
-			"C55502": {0, 1, 2, 3}},
-	})
+// If publishing a message fails, then the rest of the request is not
+// published, and IDs of the messages published before it are returned.
+func (s *ServiceHTTPSuite) TestPubSubPublishPartialFailure(c *C) {
+	validatorSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var candidate validator.Candidate
+		c.Check(json.NewDecoder(r.Body).Decode(&candidate), IsNil)
+		if string(candidate.Value) != "good" {
+			http.Error(w, "value must be good", http.StatusBadRequest)
+		}
+	}))
+	defer validatorSrv.Close()
+	s.proxyCfg.ProduceValidation = map[string]config.ProduceValidation{
+		"test.1": {URL: validatorSrv.URL},
+	}
+	s.cfg.PubSub.Enabled = true
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.1")
+
+	// When
+	r, err := s.unixClient.Post("http://_/v1/projects/pxyH/topics/test.1:publish", "application/json",
+		strings.NewReader(`{"messages": [{"data": "Z29vZA=="}, {"data": "YmFk"}, {"data": "Z29vZA=="}]}`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Not(Equals), http.StatusOK)
+	errRs := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(errRs["messageIds"], HasLen, 1)
+	c.Check(errRs["error"].(map[string]interface{})["message"], Equals,
+		"published 1 of 3 messages: message rejected by validator: value must be good")
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.1")
+	c.Check(s.kh.GetMessages("test.1", offsetsBefore, offsetsAfter), DeepEquals, [][]string{{"good"}})
 }
 
-// If consumers are requested for a particular group then only members of that
-// group are returned.
-func (s *ServiceHTTPSuite) TestGetTopicConsumers(c *C) {
-	s.kh.ResetOffsets("foo", "test.4")
-	s.kh.ResetOffsets("bar", "test.4")
-	s.kh.PutMessages("get.consumers", "test.4", map[string]int{"A": 1, "B": 1, "C": 1})
+// Pulling from a subscription that is not configured fails.
+func (s *ServiceHTTPSuite) TestPubSubPullUnknownSubscription(c *C) {
+	s.cfg.PubSub.Enabled = true
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
 
-	svc1 := spawnHTTPSvc(c, 55501)
-	defer svc1.Stop()
-	svc2 := spawnHTTPSvc(c, 55502)
-	defer svc2.Stop()
+	// When
+	r, err := s.unixClient.Post("http://_/v1/projects/pxyH/subscriptions/sub1:pull", "application/json",
+		strings.NewReader(`{"maxMessages": 10}`))
 
-	_, err := s.tcpClient.Get("http://127.0.0.1:55501/topics/test.4/messages?group=foo")
-	c.Check(err, IsNil)
-	_, err = s.tcpClient.Get("http://127.0.0.1:55502/topics/test.4/messages?group=foo")
-	c.Check(err, IsNil)
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["error"].(map[string]interface{})["status"], Equals, "NOT_FOUND")
+}
 
-	_, err = s.tcpClient.Get("http://127.0.0.1:55501/topics/test.1/messages?group=bar")
-	c.Check(err, IsNil)
+// Produced messages can be peeked via GraphQL API, and the lag of a group
+// reflects committed offsets.
+func (s *ServiceHTTPSuite) TestGraphQLPeekAndLag(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync&key=foo",
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	prodRs := ParseJSONBody(c, r).(map[string]interface{})
+	r, err = s.unixClient.Post("http://_/topics/test.1/offsets?group=foo",
+		"application/json", strings.NewReader(`[{"partition": 0, "offset": -2}]`))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 
 	// When
-	r, err := s.tcpClient.Get("http://127.0.0.1:55502/topics/test.4/consumers?group=foo")
+	r, err = s.unixClient.Post("http://_/graphql", "application/json", strings.NewReader(`{
+		"query": "query($p: Int!) { topic(name: \"test.1\", cluster: \"pxyH\") { messages(partition: $p, last: 1) { key value offset } } group(name: \"foo\") { lag(topic: \"test.1\") } }",
+		"variables": {"p": 0}}`))
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	consumers := ParseJSONBody(c, r).(map[string]interface{})
-	assertConsumedPartitions(c, consumers, map[string]map[string][]int32{
-		"foo": {
-			"C55501": {0, 1},
-			"C55502": {2, 3}},
-	})
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["errors"], IsNil)
+	data := body["data"].(map[string]interface{})
+	messages := data["topic"].(map[string]interface{})["messages"].([]interface{})
+	c.Assert(len(messages), Equals, 1)
+	msg := messages[0].(map[string]interface{})
+	c.Check(msg["key"], Equals, "foo")
+	c.Check(msg["value"], Equals, "Bazinga!")
+	c.Check(msg["offset"], Equals, prodRs["offset"])
+	c.Check(data["group"].(map[string]interface{})["lag"].(float64) > 0, Equals, true)
 }
 
-func (s *ServiceHTTPSuite) TestGetTopics(c *C) {
+func (s *ServiceHTTPSuite) TestGraphQLSyntaxError(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics")
+	r, err := s.unixClient.Get("http://_/graphql?query=" + url.QueryEscape("{ topics { name }"))
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	var topics []string
-	ParseResponseBody(c, r, &topics)
-	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(body["errors"].([]interface{})[0].(map[string]interface{})["message"], Matches, "syntax error at .*")
 }
 
-func (s *ServiceHTTPSuite) TestGetTopicsWithPartitions(c *C) {
+func (s *ServiceHTTPSuite) TestUI(c *C) {
+	s.cfg.UI.Enabled = true
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
 	// When
-	rs, err := s.unixClient.Get("http://_/topics?withPartitions=true")
+	r, err := s.unixClient.Get("http://_/ui/")
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(rs.StatusCode, Equals, http.StatusOK)
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusOK)
+	c.Check(r.Header.Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	body, err := ioutil.ReadAll(r.Body)
+	c.Assert(err, IsNil)
+	c.Check(string(body), Matches, "(?s)<!DOCTYPE html>.*/graphql.*")
+}
 
-	var topicsWithPartition map[string]struct {
-		Config     *struct{} `json:"config"`
-		Partitions []struct {
-			Partition int   `json:"partition"`
-			Leader    int   `json:"leader"`
-			Replicas  []int `json:"replicas"`
-			ISR       []int `json:"isr"`
-		} `json:"partitions"`
-	}
-	ParseResponseBody(c, rs, &topicsWithPartition)
+// The dashboard is not served unless explicitly enabled.
+func (s *ServiceHTTPSuite) TestUIDisabled(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
 
-	var topics []string
-	for topic := range topicsWithPartition {
-		topics = append(topics, topic)
-	}
-	sort.Strings(topics)
-	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+	// When
+	r, err := s.unixClient.Get("http://_/ui/")
 
-	for topic, topicMeta := range topicsWithPartition {
-		c.Check(topicMeta.Config, IsNil)
-		expectedPartitionCount := map[string]int{
-			"__consumer_offsets": 50,
-			"test.1":             1,
-			"test.4":             4,
-			"test.64":            64,
-		}[topic]
-		c.Check(len(topicMeta.Partitions), Equals, expectedPartitionCount)
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+}
 
-		// Get replication factor used, when bootstrapping the cluster.
-		defaultReplicationFactorStr := os.Getenv("REPLICATION_FACTOR")
-		defaultReplicationFactor, _ := strconv.Atoi(defaultReplicationFactorStr)
-		if defaultReplicationFactor == 0 {
-			defaultReplicationFactor = 2
-		}
+// Consume responses are gzipped if they are long enough, unless they carry an
+// already compressed message.
+func (s *ServiceHTTPSuite) TestConsumeCompression(c *C) {
+	s.cfg.HTTPCompression.Enabled = true
+	s.cfg.HTTPCompression.MinSize = 1024
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
 
-		for _, partitionMeta := range topicMeta.Partitions {
-			replicationFactor := map[string]int{
-				"__consumer_offsets": 3,
-				"test.1":             defaultReplicationFactor,
-				"test.4":             defaultReplicationFactor,
-				"test.64":            defaultReplicationFactor,
-			}[topic]
-			c.Logf("Checking: topic=%v, partition=%v", topic, partitionMeta)
-			c.Check(len(partitionMeta.Replicas), Equals, replicationFactor)
-			c.Check(len(partitionMeta.ISR), Equals, replicationFactor)
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(strings.Repeat("blob", 1024)))
+	gw.Close()
+
+	for i, tc := range []struct {
+		value    string
+		header   string
+		encoding string
+	}{{
+		value:    strings.Repeat("text", 512),
+		encoding: "gzip",
+	}, {
+		value:    "short",
+		encoding: "",
+	}, {
+		value:    gzipped.String(),
+		encoding: "",
+	}, {
+		value:    strings.Repeat("text", 512),
+		header:   "br",
+		encoding: "",
+	}} {
+		prodReq, err := http.NewRequest("POST", "http://_/topics/test.1/messages?sync", strings.NewReader(tc.value))
+		c.Assert(err, IsNil)
+		prodReq.Header.Set("Content-Type", "text/plain")
+		if tc.header != "" {
+			prodReq.Header.Set("X-Kafka-Content-Encoding", base64.StdEncoding.EncodeToString([]byte(tc.header)))
+		}
+		r, err := s.unixClient.Do(prodReq)
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK, Commentf("case #%d", i))
+		r.Body.Close()
+
+		// When
+		consReq, err := http.NewRequest("GET", "http://_/topics/test.1/messages?group=foo", nil)
+		c.Assert(err, IsNil)
+		consReq.Header.Set("Accept-Encoding", "gzip")
+		r, err = s.unixClient.Do(consReq)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK, Commentf("case #%d", i))
+		c.Check(r.Header.Get("Content-Encoding"), Equals, tc.encoding, Commentf("case #%d", i))
+		body := r.Body
+		if tc.encoding == "gzip" {
+			body, err = gzip.NewReader(r.Body)
+			c.Assert(err, IsNil)
 		}
+		var consRes map[string]interface{}
+		c.Assert(json.NewDecoder(body).Decode(&consRes), IsNil, Commentf("case #%d", i))
+		r.Body.Close()
+		c.Check(ParseBase64(c, consRes["value"].(string)), Equals, tc.value, Commentf("case #%d", i))
 	}
 }
 
-func (s *ServiceHTTPSuite) TestGetTopicsWithConfig(c *C) {
+// Keys produced to a topic can be looked up in a table materialized from it.
+// Messages are fetched from a partition starting at an explicit offset, or
+// from the offset checkpointed under a name if the offset is omitted.
+func (s *ServiceHTTPSuite) TestFetchPartition(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
+	var offsets []int64
+	for i := 0; i < 3; i++ {
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+			"text/plain", strings.NewReader(fmt.Sprintf("m%d", i)))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+		prodRs := ParseJSONBody(c, r).(map[string]interface{})
+		offsets = append(offsets, int64(prodRs["offset"].(float64)))
+	}
+	name := fmt.Sprintf("batch-%d", time.Now().UnixNano())
 
 	// When
-	rs, err := s.unixClient.Get("http://_/topics?withConfig")
+	r, err := s.unixClient.Get(fmt.Sprintf("http://_/topics/test.1/partitions/0/messages?offset=%d&limit=2", offsets[0]))
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(rs.StatusCode, Equals, http.StatusOK)
-
-	var topicsWithConfig map[string]struct {
-		Config struct {
-			Version int               `json:"version"`
-			Config  map[string]string `json:"config"`
-		} `json:"config"`
-		Partitions []struct{} `json:"partitions"`
-	}
-	ParseResponseBody(c, rs, &topicsWithConfig)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	messages := body["messages"].([]interface{})
+	c.Assert(messages, HasLen, 2)
+	c.Check(ParseBase64(c, messages[0].(map[string]interface{})["value"].(string)), Equals, "m0")
+	c.Check(ParseBase64(c, messages[1].(map[string]interface{})["value"].(string)), Equals, "m1")
+	c.Check(body["next_offset"], Equals, float64(offsets[1]+1))
 
-	var topics []string
-	for topic := range topicsWithConfig {
-		topics = append(topics, topic)
-	}
-	sort.Strings(topics)
-	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+	// When
+	r, err = s.unixClient.Post(fmt.Sprintf("http://_/topics/test.1/partitions/0/checkpoint?group=%s&offset=%d", name, offsets[2]),
+		"text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get(fmt.Sprintf("http://_/topics/test.1/partitions/0/messages?group=%s", name))
 
-	for topic, topicMeta := range topicsWithConfig {
-		c.Check(topicMeta.Partitions, IsNil)
-		c.Check(topicMeta.Config.Version, Equals, 1)
-		c.Check(topicMeta.Config.Config, DeepEquals,
-			map[string]map[string]string{
-				"__consumer_offsets": {
-					"cleanup.policy":   "compact",
-					"compression.type": "producer",
-					"segment.bytes":    "104857600"},
-				"test.1":  {},
-				"test.4":  {},
-				"test.64": {},
-			}[topic])
-	}
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body = ParseJSONBody(c, r).(map[string]interface{})
+	messages = body["messages"].([]interface{})
+	c.Assert(messages, HasLen, 1)
+	c.Check(ParseBase64(c, messages[0].(map[string]interface{})["value"].(string)), Equals, "m2")
+	c.Check(body["next_offset"], Equals, float64(offsets[2]+1))
 }
 
-func (s *ServiceHTTPSuite) TestGetTopicsWithPartitionsAndWithConfig(c *C) {
+// Values longer than valueBytes are truncated, and all values get their
+// full size reported.
+func (s *ServiceHTTPSuite) TestFetchPartitionValueBytes(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
+	var offsets []int64
+	for _, value := range []string{"jumbo", "m"} {
+		r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync",
+			"text/plain", strings.NewReader(value))
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+		prodRs := ParseJSONBody(c, r).(map[string]interface{})
+		offsets = append(offsets, int64(prodRs["offset"].(float64)))
+	}
 
 	// When
-	rs, err := s.unixClient.Get("http://_/topics?withPartitions&withConfig")
+	r, err := s.unixClient.Get(fmt.Sprintf("http://_/topics/test.1/partitions/0/messages?offset=%d&limit=2&valueBytes=2", offsets[0]))
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(rs.StatusCode, Equals, http.StatusOK)
-
-	var topicsWithConfig map[string]struct {
-		Config struct {
-			Version int               `json:"version"`
-			Config  map[string]string `json:"config"`
-		} `json:"config"`
-		Partitions []struct{} `json:"partitions"`
-	}
-	ParseResponseBody(c, rs, &topicsWithConfig)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	messages := ParseJSONBody(c, r).(map[string]interface{})["messages"].([]interface{})
+	c.Assert(messages, HasLen, 2)
+	c.Check(ParseBase64(c, messages[0].(map[string]interface{})["value"].(string)), Equals, "ju")
+	c.Check(messages[0].(map[string]interface{})["value_size"], Equals, float64(5))
+	c.Check(ParseBase64(c, messages[1].(map[string]interface{})["value"].(string)), Equals, "m")
+	c.Check(messages[1].(map[string]interface{})["value_size"], Equals, float64(1))
+}
 
-	var topics []string
-	for topic := range topicsWithConfig {
-		topics = append(topics, topic)
-	}
-	sort.Strings(topics)
-	c.Check(topics, DeepEquals, []string{"__consumer_offsets", "test.1", "test.4", "test.64"})
+func (s *ServiceHTTPSuite) TestFetchPartitionInvalid(c *C) {
+	svc, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer svc.Stop()
 
-	for _, topicMeta := range topicsWithConfig {
-		c.Check(topicMeta.Partitions, NotNil)
-		c.Check(topicMeta.Config.Version, NotNil)
+	for i, tc := range []struct {
+		url    string
+		status int
+		error  string
+	}{{
+		url:    "http://_/topics/test.1/partitions/0/messages",
+		status: http.StatusBadRequest,
+		error:  "either offset or group must be provided",
+	}, {
+		url:    "http://_/topics/test.1/partitions/x/messages?offset=0",
+		status: http.StatusBadRequest,
+		error:  "bad partition: x",
+	}, {
+		url:    "http://_/topics/test.1/partitions/0/messages?offset=0&limit=1001",
+		status: http.StatusBadRequest,
+		error:  "bad limit, must be from 1 to 1000: 1001",
+	}, {
+		url:    "http://_/topics/test.1/partitions/0/messages?offset=0&valueBytes=-1",
+		status: http.StatusBadRequest,
+		error:  "bad valueBytes: -1",
+	}, {
+		url:    "http://_/topics/test.1/partitions/0/messages?offset=100000000000",
+		status: http.StatusBadRequest,
+		error:  "offset 100000000000 is out of range .*",
+	}} {
+		// When
+		r, err := s.unixClient.Get(tc.url)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Check(body["error"], Matches, tc.error, Commentf("case #%d", i))
 	}
 }
 
-// Reported partition lags are correct, including those corresponding to -1 and
-// -2 special case offset values.
-func (s *ServiceHTTPSuite) TestHealthCheck(c *C) {
+func (s *ServiceHTTPSuite) TestTableLookup(c *C) {
+	s.proxyCfg.Tables = map[string]config.Table{"test.1": {}}
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
+	key := fmt.Sprintf("tbl-%d", time.Now().UnixNano())
+	r, err := s.unixClient.Post("http://_/topics/test.1/messages?sync&key="+key,
+		"text/plain", strings.NewReader("Bazinga!"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	prodRs := ParseJSONBody(c, r).(map[string]interface{})
 
 	// When
-	r, err := s.unixClient.Get("http://_/_ping")
+	for i := 0; i < 100; i++ {
+		if r, err = s.unixClient.Get("http://_/topics/test.1/table/" + key); err != nil || r.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
 
 	// Then
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	body, err := ioutil.ReadAll(r.Body)
-	c.Check(err, IsNil)
-	c.Check(string(body), Equals, "pong")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Check(ParseBase64(c, body["key"].(string)), Equals, key)
+	c.Check(ParseBase64(c, body["value"].(string)), Equals, "Bazinga!")
+	c.Check(body["offset"], Equals, prodRs["offset"])
 }
 
-// Ensure that API endpoints that explicitly select a proxy to operate on work.
-func (s *ServiceHTTPSuite) TestExplicitProxyAPIEndpoints(c *C) {
-	s.kh.ResetOffsets("foo", "test.1")
+func (s *ServiceHTTPSuite) TestTableNotConfigured(c *C) {
 	svc, err := Spawn(s.cfg)
 	c.Assert(err, IsNil)
 	defer svc.Stop()
 
-	// When/Then
-	r, err := s.unixClient.Post("http://_/clusters/pxyH/topics/test.1/messages?sync", "text/plain", strings.NewReader("Bazinga!"))
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(int(body["partition"].(float64)), Equals, 0)
-	prodOffset := int64(body["offset"].(float64))
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.1/table/foo")
 
-	r, err = s.unixClient.Get("http://_/clusters/pxyH/topics/test.1/messages?group=foo")
-	c.Check(err, IsNil)
-	c.Check(r.StatusCode, Equals, http.StatusOK)
-	body = ParseJSONBody(c, r).(map[string]interface{})
-	c.Check(ParseBase64(c, body["value"].(string)), Equals, "Bazinga!")
-	c.Check(int64(body["offset"].(float64)), Equals, prodOffset)
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(r.StatusCode, Equals, http.StatusNotFound)
+	c.Check(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"error":     "table is not configured for the topic",
+		"code":      "failed_precondition",
+		"retryable": false,
+	})
 }
 
 func spawnHTTPSvc(c *C, port int) *T {
@@ -0,0 +1,191 @@
+// Package shadow duplicates a share of messages produced to topics to shadow
+// topics, possibly on another cluster, so that new consumers can be tested
+// against live traffic without touching the real topics. Copies are produced
+// in the background on a best effort basis: if a shadow producer falls
+// behind, then copies are dropped rather than slowing down produce requests.
+package shadow
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+// HeaderShadowOf is a record header that copies are given, it is set to the
+// name of the original topic.
+const HeaderShadowOf = "X-Shadow-Of"
+
+// Stats describes what happened to copies of messages produced to a topic
+// since start.
+type Stats struct {
+	// Copies that were acknowledged by the shadow cluster.
+	ShadowedMsgs int64
+
+	// Copies that were dropped because the shadow producer queue was full.
+	DroppedMsgs int64
+
+	// Copies that the shadow cluster failed to accept.
+	FailedMsgs int64
+}
+
+// T produces copies of messages to shadow topics.
+type T struct {
+	actDesc *actor.Descriptor
+	targets map[string]*target
+	wg      sync.WaitGroup
+
+	stoppedMu sync.RWMutex
+	stopped   bool
+}
+
+type target struct {
+	// Updated atomically.
+	seenMsgs     int64
+	shadowedMsgs int64
+	droppedMsgs  int64
+	failedMsgs   int64
+
+	actDesc  *actor.Descriptor
+	topic    string
+	percent  int64
+	msgCh    chan *sarama.ProducerMessage
+	producer sarama.AsyncProducer
+}
+
+// Spawn creates shadow producers for shadows configured in `cfg`, copies of
+// messages produced to a topic go to the cluster configured by
+// `shadowCfgs[topic]`. It returns nil if no shadow is configured.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, shadowCfgs map[string]*config.Proxy) (*T, error) {
+	if len(cfg.Shadows) == 0 {
+		return nil, nil
+	}
+	t := T{
+		actDesc: parentActDesc.NewChild("shadow"),
+		targets: make(map[string]*target, len(cfg.Shadows)),
+	}
+	for topic, shadowCfg := range cfg.Shadows {
+		clusterCfg := shadowCfgs[topic]
+		saramaCfg := clusterCfg.SaramaProducerCfg()
+		saramaCfg.Producer.Return.Successes = true
+		saramaCfg.Producer.Return.Errors = true
+		producer, err := sarama.NewAsyncProducer(clusterCfg.Kafka.SeedPeers, saramaCfg)
+		if err != nil {
+			t.Stop()
+			return nil, errors.Wrapf(err, "failed to create shadow producer, topic=%s", topic)
+		}
+		t.spawnTarget(topic, shadowCfg, cfg.Producer.ChannelBufferSize, producer)
+	}
+	return &t, nil
+}
+
+func (t *T) spawnTarget(topic string, shadowCfg config.Shadow, queueSize int, producer sarama.AsyncProducer) {
+	tg := target{
+		actDesc:  t.actDesc.NewChild(topic),
+		topic:    shadowCfg.Topic,
+		percent:  int64(shadowCfg.Percent),
+		msgCh:    make(chan *sarama.ProducerMessage, queueSize),
+		producer: producer,
+	}
+	if tg.topic == "" {
+		tg.topic = topic
+	}
+	tg.actDesc.ObserveQueue("copies", func() int { return len(tg.msgCh) })
+	tg.actDesc.ObserveGauge("shadowed_msgs", func() int64 { return atomic.LoadInt64(&tg.shadowedMsgs) })
+	tg.actDesc.ObserveGauge("dropped_msgs", func() int64 { return atomic.LoadInt64(&tg.droppedMsgs) })
+	tg.actDesc.ObserveGauge("failed_msgs", func() int64 { return atomic.LoadInt64(&tg.failedMsgs) })
+	t.targets[topic] = &tg
+	actor.Spawn(tg.actDesc, &t.wg, tg.run)
+}
+
+// Put queues a copy of a message produced to `topic` to be produced to the
+// shadow topic, if the topic has a shadow and the message falls into the
+// configured share. It never blocks, a copy is dropped if the queue is full.
+func (t *T) Put(topic string, key, message sarama.Encoder, headers []sarama.RecordHeader) {
+	tg := t.targets[topic]
+	if tg == nil || !tg.sample() {
+		return
+	}
+	t.stoppedMu.RLock()
+	defer t.stoppedMu.RUnlock()
+	if t.stopped {
+		return
+	}
+	select {
+	case tg.msgCh <- copyOf(tg.topic, topic, key, message, headers):
+	default:
+		atomic.AddInt64(&tg.droppedMsgs, 1)
+	}
+}
+
+// Stats returns what happened to copies of messages produced to `topic`.
+func (t *T) Stats(topic string) Stats {
+	tg := t.targets[topic]
+	if tg == nil {
+		return Stats{}
+	}
+	return Stats{
+		ShadowedMsgs: atomic.LoadInt64(&tg.shadowedMsgs),
+		DroppedMsgs:  atomic.LoadInt64(&tg.droppedMsgs),
+		FailedMsgs:   atomic.LoadInt64(&tg.failedMsgs),
+	}
+}
+
+// Stop produces all queued copies and releases shadow producers. Copies put
+// after Stop are discarded.
+func (t *T) Stop() {
+	t.stoppedMu.Lock()
+	t.stopped = true
+	for _, tg := range t.targets {
+		close(tg.msgCh)
+	}
+	t.stoppedMu.Unlock()
+	t.wg.Wait()
+}
+
+// sample tells whether the next message falls into the configured share.
+// Messages are picked evenly, e.g. every 4th with 25 percent.
+func (tg *target) sample() bool {
+	seen := atomic.AddInt64(&tg.seenMsgs, 1)
+	return seen*tg.percent/100 != (seen-1)*tg.percent/100
+}
+
+func (tg *target) run() {
+	var wg sync.WaitGroup
+	actor.Spawn(tg.actDesc.NewChild("successes"), &wg, func() {
+		for range tg.producer.Successes() {
+			atomic.AddInt64(&tg.shadowedMsgs, 1)
+		}
+	})
+	actor.Spawn(tg.actDesc.NewChild("errors"), &wg, func() {
+		for prodErr := range tg.producer.Errors() {
+			atomic.AddInt64(&tg.failedMsgs, 1)
+			tg.actDesc.Log().WithError(prodErr.Err).Errorf("Failed to produce copy: topic=%s", tg.topic)
+		}
+	})
+	for msg := range tg.msgCh {
+		tg.producer.Input() <- msg
+	}
+	tg.producer.AsyncClose()
+	wg.Wait()
+}
+
+// copyOf returns a copy of a message produced to `origTopic` that should be
+// produced to `topic`.
+func copyOf(topic, origTopic string, key, message sarama.Encoder, headers []sarama.RecordHeader) *sarama.ProducerMessage {
+	copyHeaders := make([]sarama.RecordHeader, len(headers), len(headers)+1)
+	copy(copyHeaders, headers)
+	copyHeaders = append(copyHeaders, sarama.RecordHeader{
+		Key:   []byte(HeaderShadowOf),
+		Value: []byte(origTopic),
+	})
+	return &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     key,
+		Value:   message,
+		Headers: copyHeaders,
+	}
+}
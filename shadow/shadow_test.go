@@ -0,0 +1,107 @@
+package shadow
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ShadowSuite struct{}
+
+var _ = Suite(&ShadowSuite{})
+
+func (s *ShadowSuite) TestSpawnDisabled(c *C) {
+	cfg := config.DefaultProxy()
+	sh, err := Spawn(actor.Root(), cfg, nil)
+	c.Check(err, IsNil)
+	c.Check(sh, IsNil)
+}
+
+// Only the configured share of messages produced to a shadowed topic is
+// copied.
+func (s *ShadowSuite) TestPut(c *C) {
+	producer := newMockProducer(c)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+	sh := newShadow("foo", config.Shadow{Topic: "foo_copy", Percent: 25}, producer)
+
+	// When
+	for i := 0; i < 8; i++ {
+		sh.Put("foo", nil, sarama.StringEncoder("msg"), nil)
+		sh.Put("bar", nil, sarama.StringEncoder("msg"), nil)
+	}
+	sh.Stop()
+
+	// Then
+	c.Check(sh.Stats("foo"), Equals, Stats{ShadowedMsgs: 2})
+	c.Check(sh.Stats("bar"), Equals, Stats{})
+}
+
+// Copies that fail to be produced are counted.
+func (s *ShadowSuite) TestPutFailed(c *C) {
+	producer := newMockProducer(c)
+	producer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+	sh := newShadow("foo", config.Shadow{Topic: "foo_copy", Percent: 100}, producer)
+
+	// When
+	sh.Put("foo", nil, sarama.StringEncoder("msg"), nil)
+	sh.Stop()
+
+	// Then
+	c.Check(sh.Stats("foo"), Equals, Stats{FailedMsgs: 1})
+}
+
+// Copies put after stop are discarded.
+func (s *ShadowSuite) TestPutAfterStop(c *C) {
+	sh := newShadow("foo", config.Shadow{Topic: "foo_copy", Percent: 100}, newMockProducer(c))
+	sh.Stop()
+
+	// When
+	sh.Put("foo", nil, sarama.StringEncoder("msg"), nil)
+
+	// Then
+	c.Check(sh.Stats("foo"), Equals, Stats{})
+}
+
+// Copies get a header with the original topic name, headers of the original
+// message are not modified.
+func (s *ShadowSuite) TestCopyOf(c *C) {
+	headers := make([]sarama.RecordHeader, 1, 2)
+	headers[0] = sarama.RecordHeader{Key: []byte("h1"), Value: []byte("v1")}
+
+	// When
+	msg := copyOf("foo_copy", "foo", sarama.StringEncoder("key"), sarama.StringEncoder("msg"), headers)
+
+	// Then
+	c.Check(msg.Topic, Equals, "foo_copy")
+	c.Check(msg.Key, Equals, sarama.StringEncoder("key"))
+	c.Check(msg.Value, Equals, sarama.StringEncoder("msg"))
+	c.Check(msg.Headers, DeepEquals, []sarama.RecordHeader{
+		{Key: []byte("h1"), Value: []byte("v1")},
+		{Key: []byte(HeaderShadowOf), Value: []byte("foo")},
+	})
+	c.Check(headers[:2][1], DeepEquals, sarama.RecordHeader{})
+}
+
+func newMockProducer(c *C) *mocks.AsyncProducer {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.Return.Successes = true
+	return mocks.NewAsyncProducer(c, saramaCfg)
+}
+
+func newShadow(topic string, shadowCfg config.Shadow, producer sarama.AsyncProducer) *T {
+	sh := T{
+		actDesc: actor.Root().NewChild("shadow"),
+		targets: make(map[string]*target),
+	}
+	sh.spawnTarget(topic, shadowCfg, 100, producer)
+	return &sh
+}
@@ -0,0 +1,365 @@
+// Package table implements key-value tables materialized from compacted
+// Kafka topics. A table tails all partitions of a topic and keeps the latest
+// value of every key in memory. Messages with nil values (tombstones) delete
+// respective keys.
+package table
+
+import (
+	"encoding/gob"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSnapshotInterval = time.Minute
+)
+
+var (
+	ErrNotReady    = errors.New("table is being loaded")
+	ErrKeyNotFound = errors.New("key not found")
+	ErrIncomplete  = errors.New("table memory limit reached, key may be missing")
+)
+
+// Entry is the latest value of a key along with the partition and offset of
+// the message that it comes from.
+type Entry struct {
+	Value     []byte
+	Partition int32
+	Offset    int64
+}
+
+// T is a key-value table materialized from a compacted topic.
+type T struct {
+	actDesc  *actor.Descriptor
+	topic    string
+	cfg      config.Table
+	kafkaClt sarama.Client
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+
+	mu         sync.RWMutex
+	entries    map[string]Entry
+	size       int64
+	offsets    map[int32]int64
+	pending    map[int32]int64
+	ready      bool
+	incomplete bool
+}
+
+// snapshot is the format that tables are persisted in.
+type snapshot struct {
+	Topic   string
+	Offsets map[int32]int64
+	Entries map[string]Entry
+}
+
+// Spawn creates a table for the specified topic and starts loading it. The
+// table can be queried right away, but until it catches up with the topic
+// head all lookups fail with ErrNotReady.
+func Spawn(parentActDesc *actor.Descriptor, topic string, cfg config.Table, kafkaClt sarama.Client) (*T, error) {
+	t := newTable(parentActDesc.NewChild("table", topic), topic, cfg)
+	t.kafkaClt = kafkaClt
+	if cfg.SnapshotPath != "" && !cfg.RebuildOnStart {
+		if err := t.load(); err != nil {
+			t.actDesc.Log().WithError(err).Warn("Failed to load snapshot, rebuilding")
+			t.reset()
+		}
+	}
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consumer")
+	}
+	partitions, err := kafkaClt.Partitions(topic)
+	if err != nil {
+		kafkaConsumer.Close()
+		return nil, errors.Wrap(err, "failed to get partitions")
+	}
+	oldest := make(map[int32]int64, len(partitions))
+	newest := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		if oldest[partition], newest[partition], err = t.offsetRange(partition); err != nil {
+			kafkaConsumer.Close()
+			return nil, err
+		}
+	}
+	t.checkSnapshotOffsets(oldest, newest)
+	var partitionConsumers []sarama.PartitionConsumer
+	for _, partition := range partitions {
+		pc, err := t.consumePartition(kafkaConsumer, partition, oldest[partition], newest[partition])
+		if err != nil {
+			for _, pc := range partitionConsumers {
+				pc.Close()
+			}
+			kafkaConsumer.Close()
+			return nil, err
+		}
+		partitionConsumers = append(partitionConsumers, pc)
+	}
+	t.updateReady()
+	for i, pc := range partitionConsumers {
+		pc, partition := pc, partitions[i]
+		actor.Spawn(t.actDesc.NewChild("p", partition), &t.wg, func() {
+			t.runPartitionReader(pc)
+		})
+	}
+	if cfg.SnapshotPath != "" {
+		actor.Spawn(t.actDesc.NewChild("snapshot"), &t.wg, t.runSnapshotWriter)
+	}
+	actor.Spawn(t.actDesc.NewChild("stop"), &t.wg, func() {
+		<-t.stopCh
+		for _, pc := range partitionConsumers {
+			pc.AsyncClose()
+		}
+		kafkaConsumer.Close()
+	})
+	return t, nil
+}
+
+func newTable(actDesc *actor.Descriptor, topic string, cfg config.Table) *T {
+	t := &T{
+		actDesc: actDesc,
+		topic:   topic,
+		cfg:     cfg,
+		stopCh:  make(chan none.T),
+	}
+	t.reset()
+	return t
+}
+
+func (t *T) reset() {
+	t.entries = make(map[string]Entry)
+	t.size = 0
+	t.offsets = make(map[int32]int64)
+	t.pending = make(map[int32]int64)
+	t.incomplete = false
+}
+
+// Stop terminates table loading and writes the final snapshot if the table
+// is configured to be persisted.
+func (t *T) Stop() {
+	close(t.stopCh)
+	t.wg.Wait()
+	if t.cfg.SnapshotPath != "" {
+		if err := t.save(); err != nil {
+			t.actDesc.Log().WithError(err).Error("Failed to save snapshot")
+		}
+	}
+}
+
+// Get returns the latest value of a key.
+func (t *T) Get(key string) (Entry, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.ready {
+		return Entry{}, ErrNotReady
+	}
+	entry, ok := t.entries[key]
+	if !ok {
+		if t.incomplete {
+			return Entry{}, ErrIncomplete
+		}
+		return Entry{}, ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+// offsetRange returns the oldest and the newest offsets of a partition.
+func (t *T) offsetRange(partition int32) (int64, int64, error) {
+	newest, err := t.kafkaClt.GetOffset(t.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get newest offset, partition=%d", partition)
+	}
+	oldest, err := t.kafkaClt.GetOffset(t.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get oldest offset, partition=%d", partition)
+	}
+	return oldest, newest, nil
+}
+
+// checkSnapshotOffsets resets the table if the offset of any partition is
+// out of range. Keys may have been deleted while the table was down, and if
+// their tombstones have been compacted since, then replaying the partition
+// would never delete them from the table, so it has to be rebuilt.
+func (t *T) checkSnapshotOffsets(oldest, newest map[int32]int64) {
+	for partition, offset := range t.offsets {
+		if offset < oldest[partition] || offset > newest[partition] {
+			t.actDesc.Log().Warnf("Snapshot offset out of range, rebuilding: partition=%d, offset=%d",
+				partition, offset)
+			t.reset()
+			return
+		}
+	}
+}
+
+// consumePartition starts consuming a partition from the offset following
+// the last one applied to the table. If the table does not have the offset,
+// then the partition is consumed from the beginning.
+func (t *T) consumePartition(kafkaConsumer sarama.Consumer, partition int32, oldest, newest int64,
+) (sarama.PartitionConsumer, error) {
+	offset, ok := t.offsets[partition]
+	if !ok {
+		offset = oldest
+	}
+	pc, err := kafkaConsumer.ConsumePartition(t.topic, partition, offset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to consume partition %d", partition)
+	}
+	if offset < newest {
+		t.pending[partition] = newest
+	}
+	return pc, nil
+}
+
+func (t *T) runPartitionReader(pc sarama.PartitionConsumer) {
+	for msg := range pc.Messages() {
+		t.apply(msg.Partition, msg.Offset, string(msg.Key), msg.Value)
+	}
+}
+
+// apply updates the table with a message. Messages with new keys are
+// dropped if the table memory limit is reached.
+func (t *T) apply(partition int32, offset int64, key string, value []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.offsets[partition] = offset + 1
+	if target, ok := t.pending[partition]; ok && offset+1 >= target {
+		delete(t.pending, partition)
+		t.updateReadyLocked()
+	}
+	old, exists := t.entries[key]
+	if value == nil {
+		if exists {
+			t.size -= int64(len(key) + len(old.Value))
+			delete(t.entries, key)
+		}
+		return
+	}
+	delta := int64(len(value))
+	if exists {
+		delta -= int64(len(old.Value))
+	} else {
+		delta += int64(len(key))
+		if t.cfg.MaxBytes > 0 && t.size+delta > t.cfg.MaxBytes {
+			if !t.incomplete {
+				t.actDesc.Log().Errorf("Memory limit reached, new keys are dropped: limit=%d", t.cfg.MaxBytes)
+				t.incomplete = true
+			}
+			return
+		}
+	}
+	t.entries[key] = Entry{Value: value, Partition: partition, Offset: offset}
+	t.size += delta
+}
+
+func (t *T) updateReady() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.updateReadyLocked()
+}
+
+func (t *T) updateReadyLocked() {
+	if !t.ready && len(t.pending) == 0 {
+		t.ready = true
+		t.actDesc.Log().Infof("Table loaded: keys=%d, bytes=%d", len(t.entries), t.size)
+	}
+}
+
+func (t *T) runSnapshotWriter() {
+	interval := t.cfg.SnapshotInterval
+	if interval <= 0 {
+		interval = defaultSnapshotInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.save(); err != nil {
+				t.actDesc.Log().WithError(err).Error("Failed to save snapshot")
+			}
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// save writes the table to the snapshot file. The file is replaced
+// atomically, so that a crash in the middle of a save does not corrupt the
+// previous snapshot.
+func (t *T) save() error {
+	t.mu.RLock()
+	if !t.ready {
+		// Saving a partially loaded table is pointless.
+		t.mu.RUnlock()
+		return nil
+	}
+	snap := snapshot{
+		Topic:   t.topic,
+		Offsets: make(map[int32]int64, len(t.offsets)),
+		Entries: make(map[string]Entry, len(t.entries)),
+	}
+	for p, o := range t.offsets {
+		snap.Offsets[p] = o
+	}
+	for k, e := range t.entries {
+		snap.Entries[k] = e
+	}
+	t.mu.RUnlock()
+
+	tmpPath := t.cfg.SnapshotPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	if err := gob.NewEncoder(f).Encode(&snap); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to encode")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	return errors.Wrap(os.Rename(tmpPath, t.cfg.SnapshotPath), "failed to rename file")
+}
+
+// load reads the table from the snapshot file. A missing file is not an
+// error, the table is just built from scratch then.
+func (t *T) load() error {
+	f, err := os.Open(t.cfg.SnapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return errors.Wrap(err, "failed to decode")
+	}
+	if snap.Topic != t.topic {
+		return errors.Errorf("snapshot of another topic: %s", snap.Topic)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = snap.Entries
+	if t.entries == nil {
+		t.entries = make(map[string]Entry)
+	}
+	t.offsets = snap.Offsets
+	if t.offsets == nil {
+		t.offsets = make(map[int32]int64)
+	}
+	t.size = 0
+	for k, e := range t.entries {
+		t.size += int64(len(k) + len(e.Value))
+	}
+	t.actDesc.Log().Infof("Snapshot loaded: keys=%d, bytes=%d", len(t.entries), t.size)
+	return nil
+}
@@ -0,0 +1,172 @@
+package table
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TableSuite struct {
+	ns     *actor.Descriptor
+	tmpDir string
+}
+
+var _ = Suite(&TableSuite{})
+
+func (s *TableSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *TableSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+	var err error
+	s.tmpDir, err = ioutil.TempDir("", "table_test")
+	c.Assert(err, IsNil)
+}
+
+func (s *TableSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.tmpDir)
+}
+
+// Lookups fail until all partitions catch up with offsets that were the
+// newest when the table was spawned.
+func (s *TableSuite) TestNotReady(c *C) {
+	tbl := newTable(s.ns, "foo", config.Table{})
+	tbl.pending[0] = 2
+	tbl.pending[1] = 1
+
+	// When/Then
+	tbl.apply(0, 0, "a", []byte("1"))
+	tbl.apply(1, 0, "b", []byte("2"))
+	_, err := tbl.Get("a")
+	c.Check(err, Equals, ErrNotReady)
+
+	// When/Then
+	tbl.apply(0, 1, "a", []byte("3"))
+	entry, err := tbl.Get("a")
+	c.Check(err, IsNil)
+	c.Check(entry, DeepEquals, Entry{Value: []byte("3"), Partition: 0, Offset: 1})
+}
+
+// Tombstones delete keys.
+func (s *TableSuite) TestTombstone(c *C) {
+	tbl := newTable(s.ns, "foo", config.Table{})
+	tbl.updateReady()
+	tbl.apply(0, 0, "a", []byte("1"))
+	tbl.apply(0, 1, "b", []byte("22"))
+
+	// When
+	tbl.apply(0, 2, "a", nil)
+
+	// Then
+	_, err := tbl.Get("a")
+	c.Check(err, Equals, ErrKeyNotFound)
+	entry, err := tbl.Get("b")
+	c.Check(err, IsNil)
+	c.Check(string(entry.Value), Equals, "22")
+	c.Check(tbl.size, Equals, int64(3))
+}
+
+// When the memory limit is reached new keys are dropped, but existing keys
+// are still updated.
+func (s *TableSuite) TestMaxBytes(c *C) {
+	tbl := newTable(s.ns, "foo", config.Table{MaxBytes: 10})
+	tbl.updateReady()
+	tbl.apply(0, 0, "a", []byte("1234"))
+	tbl.apply(0, 1, "b", []byte("1234"))
+
+	// When
+	tbl.apply(0, 2, "c", []byte("1234"))
+	tbl.apply(0, 3, "a", []byte("5"))
+
+	// Then
+	_, err := tbl.Get("c")
+	c.Check(err, Equals, ErrIncomplete)
+	entry, err := tbl.Get("a")
+	c.Check(err, IsNil)
+	c.Check(string(entry.Value), Equals, "5")
+	c.Check(tbl.size, Equals, int64(7))
+}
+
+func (s *TableSuite) TestSnapshotRoundTrip(c *C) {
+	cfg := config.Table{SnapshotPath: path.Join(s.tmpDir, "foo.table")}
+	tbl := newTable(s.ns, "foo", cfg)
+	tbl.updateReady()
+	tbl.apply(0, 5, "a", []byte("1"))
+	tbl.apply(1, 7, "b", []byte("22"))
+	c.Assert(tbl.save(), IsNil)
+
+	// When
+	loaded := newTable(s.ns, "foo", cfg)
+	err := loaded.load()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Check(loaded.entries, DeepEquals, tbl.entries)
+	c.Check(loaded.offsets, DeepEquals, map[int32]int64{0: 6, 1: 8})
+	c.Check(loaded.size, Equals, int64(5))
+}
+
+// A snapshot of another topic is rejected.
+func (s *TableSuite) TestSnapshotTopicMismatch(c *C) {
+	cfg := config.Table{SnapshotPath: path.Join(s.tmpDir, "foo.table")}
+	tbl := newTable(s.ns, "foo", cfg)
+	tbl.updateReady()
+	c.Assert(tbl.save(), IsNil)
+
+	// When
+	err := newTable(s.ns, "bar", cfg).load()
+
+	// Then
+	c.Check(err, ErrorMatches, "snapshot of another topic: foo")
+}
+
+// A missing snapshot is not an error.
+func (s *TableSuite) TestSnapshotMissing(c *C) {
+	cfg := config.Table{SnapshotPath: path.Join(s.tmpDir, "foo.table")}
+
+	// When
+	err := newTable(s.ns, "foo", cfg).load()
+
+	// Then
+	c.Check(err, IsNil)
+}
+
+// If the snapshot offset of any partition is out of range, then the whole
+// table is rebuilt, lest keys whose tombstones have been compacted stay.
+func (s *TableSuite) TestSnapshotOffsetOutOfRange(c *C) {
+	tbl := newTable(s.ns, "foo", config.Table{})
+	tbl.apply(0, 5, "a", []byte("1"))
+	tbl.apply(1, 7, "b", []byte("22"))
+
+	// When
+	tbl.checkSnapshotOffsets(map[int32]int64{0: 0, 1: 10}, map[int32]int64{0: 20, 1: 20})
+
+	// Then
+	c.Check(tbl.entries, HasLen, 0)
+	c.Check(tbl.offsets, HasLen, 0)
+	c.Check(tbl.size, Equals, int64(0))
+}
+
+func (s *TableSuite) TestSnapshotOffsetInRange(c *C) {
+	tbl := newTable(s.ns, "foo", config.Table{})
+	tbl.apply(0, 5, "a", []byte("1"))
+	tbl.apply(1, 7, "b", []byte("22"))
+
+	// When
+	tbl.checkSnapshotOffsets(map[int32]int64{0: 0, 1: 8}, map[int32]int64{0: 20, 1: 8})
+
+	// Then
+	c.Check(tbl.entries, HasLen, 2)
+	c.Check(tbl.offsets, DeepEquals, map[int32]int64{0: 6, 1: 8})
+}
@@ -0,0 +1,178 @@
+// Package tap lets an operator look at messages flowing through a topic for
+// debugging. A tap receives copies of the next N messages produced to, or
+// consumed from, a topic, and closes itself. Taps never affect offsets or
+// acknowledgements. Messages are published by all proxies to a process wide
+// registry, so that a tap can be opened without a reference to the proxy.
+package tap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/redact"
+)
+
+// Direction tells whether a message was produced or consumed.
+type Direction string
+
+const (
+	Produce Direction = "produce"
+	Consume Direction = "consume"
+)
+
+// Message is a copy of a message seen by a tap.
+type Message struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Cluster   string    `json:"cluster"`
+	Topic     string    `json:"topic"`
+	// Group is only reported for consumed messages.
+	Group string `json:"group,omitempty"`
+	// Partition and offset are -1 for produced messages, for they are
+	// tapped before Kafka assigns them.
+	Partition int32    `json:"partition"`
+	Offset    int64    `json:"offset"`
+	Key       []byte   `json:"key"`
+	Value     []byte   `json:"value"`
+	Headers   []Header `json:"headers,omitempty"`
+}
+
+// Header is a record header of a tapped message.
+type Header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Tap receives copies of up to a given number of messages.
+type Tap struct {
+	cluster   string
+	topic     string
+	direction Direction
+	remaining int
+	msgCh     chan Message
+}
+
+var (
+	tapsMu sync.Mutex
+	taps   = make(map[*Tap]bool)
+	// The number of open taps, accessed atomically so that publishers do
+	// not take the lock when nobody is tapping.
+	tapCount int32
+)
+
+// Open creates a tap that receives copies of the next `count` messages of
+// `topic` in `cluster`. If direction is empty, then both produced and
+// consumed messages are tapped. The count must be positive.
+func Open(cluster, topic string, direction Direction, count int) *Tap {
+	t := &Tap{
+		cluster:   cluster,
+		topic:     topic,
+		direction: direction,
+		remaining: count,
+		msgCh:     make(chan Message, count),
+	}
+	tapsMu.Lock()
+	taps[t] = true
+	atomic.AddInt32(&tapCount, 1)
+	tapsMu.Unlock()
+	return t
+}
+
+// Messages returns a channel to receive tapped messages from. It is closed
+// when the requested number of messages has been tapped, or when the tap
+// is closed.
+func (t *Tap) Messages() <-chan Message {
+	return t.msgCh
+}
+
+// Close stops tapping. It is safe to call it more than once, and after all
+// requested messages have been tapped.
+func (t *Tap) Close() {
+	tapsMu.Lock()
+	defer tapsMu.Unlock()
+	t.closeLocked()
+}
+
+func (t *Tap) closeLocked() {
+	if !taps[t] {
+		return
+	}
+	delete(taps, t)
+	atomic.AddInt32(&tapCount, -1)
+	close(t.msgCh)
+}
+
+// IsTapped tells whether there is at least one open tap. Publishers can use
+// it to avoid copying messages that nobody is going to see.
+func IsTapped() bool {
+	return atomic.LoadInt32(&tapCount) > 0
+}
+
+// PublishProduced passes a copy of a message produced to a topic to taps of
+// the topic. The copy is redacted according to the configured rules.
+func PublishProduced(cluster, topic string, key, value sarama.Encoder, headers []sarama.RecordHeader) {
+	if !IsTapped() {
+		return
+	}
+	msg := Message{
+		Direction: Produce,
+		Cluster:   cluster,
+		Topic:     topic,
+		Partition: -1,
+		Offset:    -1,
+	}
+	msg.Key, _ = encode(key)
+	msg.Value, _ = encode(value)
+	msg.Value = redact.Value(msg.Value)
+	for _, h := range headers {
+		msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: redact.HeaderValue(h.Key, h.Value)})
+	}
+	publish(msg)
+}
+
+// PublishConsumed passes a copy of a message consumed by a group to taps of
+// the message topic. The copy is redacted according to the configured rules.
+func PublishConsumed(cluster, group string, consMsg *sarama.ConsumerMessage) {
+	if !IsTapped() {
+		return
+	}
+	msg := Message{
+		Direction: Consume,
+		Cluster:   cluster,
+		Topic:     consMsg.Topic,
+		Group:     group,
+		Partition: consMsg.Partition,
+		Offset:    consMsg.Offset,
+		Key:       consMsg.Key,
+		Value:     redact.Value(consMsg.Value),
+	}
+	for _, h := range consMsg.Headers {
+		msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: redact.HeaderValue(h.Key, h.Value)})
+	}
+	publish(msg)
+}
+
+func publish(msg Message) {
+	msg.Time = time.Now().UTC()
+	tapsMu.Lock()
+	defer tapsMu.Unlock()
+	for t := range taps {
+		if t.cluster != msg.Cluster || t.topic != msg.Topic || (t.direction != "" && t.direction != msg.Direction) {
+			continue
+		}
+		// The channel buffer fits all requested messages, so it never blocks.
+		t.msgCh <- msg
+		if t.remaining--; t.remaining <= 0 {
+			t.closeLocked()
+		}
+	}
+}
+
+func encode(e sarama.Encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return e.Encode()
+}
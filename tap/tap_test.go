@@ -0,0 +1,103 @@
+package tap
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/redact"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TapSuite struct{}
+
+var _ = Suite(&TapSuite{})
+
+// A tap receives only messages of its cluster, topic and direction, and
+// closes itself when the requested number of messages has been tapped.
+func (s *TapSuite) TestFilter(c *C) {
+	t := Open("foo", "bar", Consume, 2)
+	defer t.Close()
+
+	// When
+	PublishProduced("foo", "bar", nil, sarama.StringEncoder("produced"), nil)
+	PublishConsumed("foo", "g1", &sarama.ConsumerMessage{Topic: "bazz", Value: []byte("other topic")})
+	PublishConsumed("bazz", "g1", &sarama.ConsumerMessage{Topic: "bar", Value: []byte("other cluster")})
+	PublishConsumed("foo", "g1", &sarama.ConsumerMessage{Topic: "bar", Partition: 3, Offset: 1, Value: []byte("m1")})
+	PublishConsumed("foo", "g1", &sarama.ConsumerMessage{Topic: "bar", Partition: 3, Offset: 2, Value: []byte("m2")})
+	PublishConsumed("foo", "g1", &sarama.ConsumerMessage{Topic: "bar", Partition: 3, Offset: 3, Value: []byte("m3")})
+
+	// Then
+	var values []string
+	for msg := range t.Messages() {
+		c.Check(msg.Direction, Equals, Consume)
+		c.Check(msg.Group, Equals, "g1")
+		c.Check(msg.Partition, Equals, int32(3))
+		values = append(values, string(msg.Value))
+	}
+	c.Check(values, DeepEquals, []string{"m1", "m2"})
+	c.Check(IsTapped(), Equals, false)
+}
+
+// Produced messages are tapped with their keys and headers.
+func (s *TapSuite) TestProduced(c *C) {
+	t := Open("foo", "bar", "", 1)
+
+	// When
+	PublishProduced("foo", "bar", sarama.StringEncoder("k1"), sarama.StringEncoder("v1"),
+		[]sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("hv1")}})
+
+	// Then
+	msg := <-t.Messages()
+	c.Check(msg.Direction, Equals, Produce)
+	c.Check(msg.Partition, Equals, int32(-1))
+	c.Check(msg.Offset, Equals, int64(-1))
+	c.Check(string(msg.Key), Equals, "k1")
+	c.Check(string(msg.Value), Equals, "v1")
+	c.Check(msg.Headers, DeepEquals, []Header{{Key: []byte("h1"), Value: []byte("hv1")}})
+	_, ok := <-t.Messages()
+	c.Check(ok, Equals, false)
+	t.Close()
+}
+
+// Tapped values and header values are redacted.
+func (s *TapSuite) TestRedacted(c *C) {
+	c.Assert(redact.Configure(config.Redaction{
+		JSONFields: []string{"email"},
+		Headers:    []string{"h1"},
+	}), IsNil)
+	defer redact.Configure(config.Redaction{})
+	t := Open("foo", "bar", Consume, 1)
+
+	// When
+	PublishConsumed("foo", "g1", &sarama.ConsumerMessage{
+		Topic:   "bar",
+		Value:   []byte(`{"email":"foo@example.com"}`),
+		Headers: []*sarama.RecordHeader{{Key: []byte("H1"), Value: []byte("hv1")}},
+	})
+
+	// Then
+	msg := <-t.Messages()
+	c.Check(string(msg.Value), Equals, `{"email":"[REDACTED]"}`)
+	c.Check(msg.Headers, DeepEquals, []Header{{Key: []byte("H1"), Value: []byte(redact.Mask)}})
+	t.Close()
+}
+
+// Closing a tap stops tapping.
+func (s *TapSuite) TestClose(c *C) {
+	t := Open("foo", "bar", "", 10)
+
+	// When
+	t.Close()
+	t.Close()
+
+	// Then
+	c.Check(IsTapped(), Equals, false)
+	PublishProduced("foo", "bar", nil, sarama.StringEncoder("v1"), nil)
+	_, ok := <-t.Messages()
+	c.Check(ok, Equals, false)
+}
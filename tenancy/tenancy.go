@@ -0,0 +1,100 @@
+// Package tenancy implements authentication of API callers as tenants, and
+// mapping of logical topic and group names used by tenants to physical names
+// prefixed with tenant prefixes.
+package tenancy
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid API key")
+	ErrForbidden       = errors.New("API is not available to tenants")
+)
+
+// T authenticates callers as tenants by their API keys. A nil instance means
+// that tenancy is not configured.
+type T struct {
+	byAPIKey map[string]*Tenant
+}
+
+// Tenant maps logical names to physical ones. A nil tenant leaves names as
+// they are.
+type Tenant struct {
+	Name   string
+	Prefix string
+}
+
+type contextKey struct{}
+
+// New creates a tenancy instance from tenant configs. If there are no
+// tenants, then nil is returned.
+func New(cfg map[string]config.Tenant) *T {
+	if len(cfg) == 0 {
+		return nil
+	}
+	t := &T{byAPIKey: make(map[string]*Tenant)}
+	for name, tenantCfg := range cfg {
+		tenant := &Tenant{Name: name, Prefix: tenantCfg.Prefix}
+		for _, apiKey := range tenantCfg.APIKeys {
+			t.byAPIKey[apiKey] = tenant
+		}
+	}
+	return t
+}
+
+// Authenticate returns the tenant an API key belongs to. The key can be
+// passed either as is or with the `Bearer ` prefix, as it comes in the
+// `Authorization` header.
+func (t *T) Authenticate(apiKey string) (*Tenant, error) {
+	apiKey = strings.TrimSpace(strings.TrimPrefix(apiKey, "Bearer "))
+	tenant, ok := t.byAPIKey[apiKey]
+	if !ok || apiKey == "" {
+		return nil, ErrUnauthenticated
+	}
+	return tenant, nil
+}
+
+// Topic returns the physical name of a topic.
+func (tn *Tenant) Topic(topic string) string {
+	if tn == nil {
+		return topic
+	}
+	return tn.Prefix + topic
+}
+
+// Group returns the physical name of a consumer group.
+func (tn *Tenant) Group(group string) string {
+	if tn == nil || group == "" {
+		return group
+	}
+	return tn.Prefix + group
+}
+
+// Logical returns the logical name of a topic or group by its physical name.
+// If the name does not belong to the tenant, then false is returned.
+func (tn *Tenant) Logical(name string) (string, bool) {
+	if tn == nil {
+		return name, true
+	}
+	if !strings.HasPrefix(name, tn.Prefix) {
+		return "", false
+	}
+	return name[len(tn.Prefix):], true
+}
+
+// NewContext returns a context that carries a tenant.
+func NewContext(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext returns the tenant carried by a context, or nil if there is
+// none.
+func FromContext(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(contextKey{}).(*Tenant)
+	return tenant
+}
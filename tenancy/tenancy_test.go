@@ -0,0 +1,78 @@
+package tenancy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TenancySuite struct{}
+
+var _ = Suite(&TenancySuite{})
+
+func (s *TenancySuite) TestNewNoTenants(c *C) {
+	c.Check(New(nil), IsNil)
+}
+
+func (s *TenancySuite) TestAuthenticate(c *C) {
+	t := New(map[string]config.Tenant{
+		"acme": {Prefix: "acme.", APIKeys: []string{"k1", "k2"}},
+		"bazz": {Prefix: "bazz.", APIKeys: []string{"k3"}},
+	})
+
+	for i, tc := range []struct {
+		apiKey string
+		tenant string
+	}{
+		{apiKey: "k1", tenant: "acme"},
+		{apiKey: "Bearer k2", tenant: "acme"},
+		{apiKey: "Bearer k3", tenant: "bazz"},
+	} {
+		tenant, err := t.Authenticate(tc.apiKey)
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Check(tenant.Name, Equals, tc.tenant, Commentf("case #%d", i))
+	}
+	for _, apiKey := range []string{"", "Bearer ", "k4", "Bearer k4"} {
+		_, err := t.Authenticate(apiKey)
+		c.Check(err, Equals, ErrUnauthenticated)
+	}
+}
+
+func (s *TenancySuite) TestNames(c *C) {
+	tenant := &Tenant{Name: "acme", Prefix: "acme."}
+
+	c.Check(tenant.Topic("foo"), Equals, "acme.foo")
+	c.Check(tenant.Group("bar"), Equals, "acme.bar")
+	c.Check(tenant.Group(""), Equals, "")
+	logical, ok := tenant.Logical("acme.foo")
+	c.Check(logical, Equals, "foo")
+	c.Check(ok, Equals, true)
+	_, ok = tenant.Logical("bazz.foo")
+	c.Check(ok, Equals, false)
+}
+
+// Names are left as they are if there is no tenant.
+func (s *TenancySuite) TestNamesNoTenant(c *C) {
+	tenant := FromContext(context.Background())
+
+	c.Check(tenant, IsNil)
+	c.Check(tenant.Topic("foo"), Equals, "foo")
+	c.Check(tenant.Group("bar"), Equals, "bar")
+	logical, ok := tenant.Logical("bazz.foo")
+	c.Check(logical, Equals, "bazz.foo")
+	c.Check(ok, Equals, true)
+}
+
+func (s *TenancySuite) TestContext(c *C) {
+	tenant := &Tenant{Name: "acme", Prefix: "acme."}
+
+	ctx := NewContext(context.Background(), tenant)
+
+	c.Check(FromContext(ctx), Equals, tenant)
+}
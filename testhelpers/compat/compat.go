@@ -0,0 +1,232 @@
+// Package compat replays golden request/response fixtures against a running
+// Kafka-Pixy, to detect changes that break wire compatibility of the HTTP and
+// gRPC APIs. A fixture is a JSON file that describes a request and the
+// response that existing clients expect to get for it.
+//
+// Responses are matched leniently in the ways that do not break clients:
+// fields that a fixture does not mention are ignored, so adding a field is
+// fine, but removing, renaming or changing the type of a field is not. The
+// "<any>" string value matches any value, that is handy for offsets and
+// other values that depend on the state of Kafka.
+package compat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Any is a fixture value that matches any value.
+const Any = "<any>"
+
+// Fixture is a golden request/response exchange. Exactly one of HTTP and
+// GRPC is set.
+type Fixture struct {
+	// Name of the file that the fixture was loaded from.
+	Name string `json:"-"`
+
+	Description string        `json:"description"`
+	HTTP        *HTTPExchange `json:"http,omitempty"`
+	GRPC        *GRPCExchange `json:"grpc,omitempty"`
+}
+
+// HTTPExchange is an HTTP API request and the expected response.
+type HTTPExchange struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// GRPCExchange is a gRPC API call and the expected response. Requests and
+// responses are represented in the proto3 JSON mapping with original field
+// names, where 64 bit integers are strings.
+type GRPCExchange struct {
+	// Method is the name of a `KafkaPixy` service method, e.g. "GetOffsets".
+	Method   string            `json:"method"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Request  json.RawMessage   `json:"request"`
+
+	// Code is the name of the expected status code, e.g. "NotFound".
+	// Responses of failed calls are not matched.
+	Code     string          `json:"code"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// grpcMethods maps names of gRPC methods to constructors of their request and
+// response messages.
+var grpcMethods = map[string]func() (proto.Message, proto.Message){
+	"Produce":          func() (proto.Message, proto.Message) { return &pb.ProdRq{}, &pb.ProdRs{} },
+	"ConsumeNAck":      func() (proto.Message, proto.Message) { return &pb.ConsNAckRq{}, &pb.ConsRs{} },
+	"Ack":              func() (proto.Message, proto.Message) { return &pb.AckRq{}, &pb.AckRs{} },
+	"AckBatch":         func() (proto.Message, proto.Message) { return &pb.AckBatchRq{}, &pb.AckBatchRs{} },
+	"GetOffsets":       func() (proto.Message, proto.Message) { return &pb.GetOffsetsRq{}, &pb.GetOffsetsRs{} },
+	"SetOffsets":       func() (proto.Message, proto.Message) { return &pb.SetOffsetsRq{}, &pb.SetOffsetsRs{} },
+	"ListTopics":       func() (proto.Message, proto.Message) { return &pb.ListTopicRq{}, &pb.ListTopicRs{} },
+	"ListConsumers":    func() (proto.Message, proto.Message) { return &pb.ListConsumersRq{}, &pb.ListConsumersRs{} },
+	"GetTopicMetadata": func() (proto.Message, proto.Message) { return &pb.GetTopicMetadataRq{}, &pb.GetTopicMetadataRs{} },
+}
+
+// Load reads all fixtures from `*.json` files of a directory, sorted by file
+// name.
+func Load(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	fixtures := make([]Fixture, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var f Fixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, errors.Wrapf(err, "bad fixture %s", path)
+		}
+		f.Name = filepath.Base(path)
+		if (f.HTTP == nil) == (f.GRPC == nil) {
+			return nil, errors.Errorf("bad fixture %s: exactly one of http and grpc must be set", path)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// ReplayHTTP sends the request of an HTTP fixture to `baseURL` and checks
+// the response against the expected one.
+func ReplayHTTP(clt *http.Client, baseURL string, f *HTTPExchange) error {
+	req, err := http.NewRequest(f.Method, baseURL+f.Path, strings.NewReader(f.Body))
+	if err != nil {
+		return err
+	}
+	for header, value := range f.Headers {
+		req.Header.Set(header, value)
+	}
+	res, err := clt.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != f.Status {
+		return errors.Errorf("want status %d, got %d: %s", f.Status, res.StatusCode, body)
+	}
+	if f.Response == nil {
+		return nil
+	}
+	return MatchJSON(f.Response, body)
+}
+
+// ReplayGRPC makes the call of a gRPC fixture over `conn` and checks the
+// response against the expected one.
+func ReplayGRPC(ctx context.Context, conn *grpc.ClientConn, f *GRPCExchange) error {
+	newMessages, ok := grpcMethods[f.Method]
+	if !ok {
+		return errors.Errorf("unknown method %s", f.Method)
+	}
+	rq, rs := newMessages()
+	if err := jsonpb.Unmarshal(bytes.NewReader(f.Request), rq); err != nil {
+		return errors.Wrap(err, "bad request")
+	}
+	for key, value := range f.Metadata {
+		ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+	}
+	err := conn.Invoke(ctx, "/KafkaPixy/"+f.Method, rq, rs)
+	if code := status.Code(err).String(); code != f.Code {
+		return errors.Errorf("want code %s, got %s: %v", f.Code, code, err)
+	}
+	if err != nil || f.Response == nil {
+		return nil
+	}
+	m := jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+	actual, err := m.MarshalToString(rs)
+	if err != nil {
+		return err
+	}
+	return MatchJSON(f.Response, []byte(actual))
+}
+
+// MatchJSON checks that an actual JSON document matches the expected one.
+func MatchJSON(expected, actual []byte) error {
+	var e, a interface{}
+	if err := json.Unmarshal(expected, &e); err != nil {
+		return errors.Wrap(err, "bad expected JSON")
+	}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		return errors.Wrapf(err, "bad actual JSON: %s", actual)
+	}
+	return match("$", e, a)
+}
+
+func match(path string, expected, actual interface{}) error {
+	if expected == Any {
+		if actual == nil {
+			return errors.Errorf("%s: missing", path)
+		}
+		return nil
+	}
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		actualObject, ok := actual.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("%s: want object, got %v", path, actual)
+		}
+		keys := make([]string, 0, len(expected))
+		for key := range expected {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldPath := path + "." + key
+			actualValue, ok := actualObject[key]
+			if !ok {
+				return errors.Errorf("%s: missing", fieldPath)
+			}
+			if err := match(fieldPath, expected[key], actualValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		actualArray, ok := actual.([]interface{})
+		if !ok {
+			return errors.Errorf("%s: want array, got %v", path, actual)
+		}
+		if len(actualArray) != len(expected) {
+			return errors.Errorf("%s: want %d elements, got %d", path, len(expected), len(actualArray))
+		}
+		for i := range expected {
+			if err := match(fmt.Sprintf("%s[%d]", path, i), expected[i], actualArray[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		return errors.Errorf("%s: want %#v, got %#v", path, expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package compat
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CompatSuite struct{}
+
+var _ = Suite(&CompatSuite{})
+
+func (s *CompatSuite) TestMatchJSON(c *C) {
+	for i, tc := range []struct {
+		expected string
+		actual   string
+		error    string
+	}{{
+		expected: `{"a": 1, "b": "foo"}`,
+		actual:   `{"a": 1, "b": "foo"}`,
+	}, {
+		// Added fields are compatible.
+		expected: `{"a": 1}`,
+		actual:   `{"a": 1, "b": "foo"}`,
+	}, {
+		expected: `{"a": 1, "b": "foo"}`,
+		actual:   `{"a": 1}`,
+		error:    `\$\.b: missing`,
+	}, {
+		expected: `{"a": 1}`,
+		actual:   `{"a": "1"}`,
+		error:    `\$\.a: want 1, got "1"`,
+	}, {
+		expected: `{"a": "<any>"}`,
+		actual:   `{"a": [1, 2]}`,
+	}, {
+		expected: `{"a": "<any>"}`,
+		actual:   `{"a": null}`,
+		error:    `\$\.a: missing`,
+	}, {
+		expected: `[{"a": 1}, {"a": 2}]`,
+		actual:   `[{"a": 1}, {"a": 3}]`,
+		error:    `\$\[1\]\.a: want 2, got 3`,
+	}, {
+		expected: `[1, 2]`,
+		actual:   `[1]`,
+		error:    `\$: want 2 elements, got 1`,
+	}, {
+		expected: `{"a": {"b": 1}}`,
+		actual:   `{"a": [1]}`,
+		error:    `\$\.a: want object, got \[1\]`,
+	}} {
+		// When
+		err := MatchJSON([]byte(tc.expected), []byte(tc.actual))
+
+		// Then
+		if tc.error == "" {
+			c.Check(err, IsNil, Commentf("case #%d", i))
+			continue
+		}
+		c.Check(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// All golden fixtures are well formed, and requests of gRPC ones are valid
+// messages of their methods.
+func (s *CompatSuite) TestFixtures(c *C) {
+	// When
+	fixtures, err := Load("../../testdata/compat")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(len(fixtures) > 0, Equals, true)
+	for _, f := range fixtures {
+		if f.GRPC == nil {
+			continue
+		}
+		newMessages, ok := grpcMethods[f.GRPC.Method]
+		c.Assert(ok, Equals, true, Commentf(f.Name))
+		rq, _ := newMessages()
+		c.Check(jsonpb.Unmarshal(bytes.NewReader(f.GRPC.Request), rq), IsNil, Commentf(f.Name))
+	}
+}
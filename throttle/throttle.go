@@ -0,0 +1,66 @@
+// Package throttle keeps track of quota throttling that Kafka brokers apply to
+// fetches of Kafka-Pixy. When a client exceeds its quota, brokers report how
+// long they throttled a response, and expect the client not to send more
+// requests for that long. Throttling is tracked process wide per cluster, so
+// that it can be reported regardless of which actor observed it.
+//
+// Produce responses are not covered, for sarama handles them internally and
+// does not expose their throttle time.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// RecentWindow is for how long a cluster is reported as throttled after the
+// latest throttled response.
+const RecentWindow = time.Minute
+
+// Stats describes quota throttling observed in a cluster since start.
+type Stats struct {
+	// Fetch responses that were throttled and their total throttle time.
+	ThrottledFetches  int64
+	FetchThrottleTime time.Duration
+
+	// When the latest throttled response was received, zero if never.
+	LastThrottledAt time.Time
+}
+
+var (
+	mu    sync.Mutex
+	stats = make(map[string]*Stats)
+	now   = time.Now
+)
+
+// ObserveFetch records a fetch response from a cluster that was throttled by
+// the specified time.
+func ObserveFetch(cluster string, throttleTime time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	s := stats[cluster]
+	if s == nil {
+		s = &Stats{}
+		stats[cluster] = s
+	}
+	s.ThrottledFetches++
+	s.FetchThrottleTime += throttleTime
+	s.LastThrottledAt = now()
+}
+
+// Get returns throttling stats of a cluster.
+func Get(cluster string) Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	if s := stats[cluster]; s != nil {
+		return *s
+	}
+	return Stats{}
+}
+
+// IsThrottled tells whether a cluster has throttled a fetch of Kafka-Pixy
+// within the RecentWindow.
+func IsThrottled(cluster string) bool {
+	lastThrottledAt := Get(cluster).LastThrottledAt
+	return !lastThrottledAt.IsZero() && now().Sub(lastThrottledAt) < RecentWindow
+}
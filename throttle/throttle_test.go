@@ -0,0 +1,50 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ThrottleSuite struct {
+	now time.Time
+}
+
+var _ = Suite(&ThrottleSuite{})
+
+func (s *ThrottleSuite) SetUpTest(c *C) {
+	s.now = time.Date(2009, 2, 19, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return s.now }
+}
+
+func (s *ThrottleSuite) TearDownTest(c *C) {
+	now = time.Now
+}
+
+// Throttled fetches are accounted per cluster, and a cluster is reported as
+// throttled for RecentWindow after the latest of them.
+func (s *ThrottleSuite) TestObserveFetch(c *C) {
+	c.Check(Get("foo"), DeepEquals, Stats{})
+	c.Check(IsThrottled("foo"), Equals, false)
+
+	// When
+	ObserveFetch("foo", 100*time.Millisecond)
+	ObserveFetch("foo", 200*time.Millisecond)
+
+	// Then
+	c.Check(Get("foo"), DeepEquals, Stats{
+		ThrottledFetches:  2,
+		FetchThrottleTime: 300 * time.Millisecond,
+		LastThrottledAt:   s.now,
+	})
+	c.Check(IsThrottled("foo"), Equals, true)
+	c.Check(IsThrottled("bar"), Equals, false)
+
+	s.now = s.now.Add(RecentWindow)
+	c.Check(IsThrottled("foo"), Equals, false)
+}
@@ -0,0 +1,138 @@
+// Package throughput counts messages and bytes produced and consumed through
+// a proxy by topic, and consumed by group and topic, so that chargeback and
+// capacity reports can be made without looking at the cluster. The number of
+// distinct topics and groups that are counted separately can be limited, the
+// rest are counted together under Other.
+package throughput
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// Other is the name that topics and groups over the limit are counted under.
+const Other = "_other"
+
+// Counter is the number of messages and the total size of their keys and
+// values in bytes.
+type Counter struct {
+	Messages int64
+	Bytes    int64
+}
+
+// Stats is what has been produced and consumed since start.
+type Stats struct {
+	// Produced and consumed messages by topic.
+	Produced map[string]Counter
+	Consumed map[string]Counter
+
+	// Consumed messages by group and topic.
+	ConsumedByGroup map[string]map[string]Counter
+}
+
+// T keeps throughput counters of a proxy.
+type T struct {
+	maxTopics int
+	maxGroups int
+
+	mu              sync.Mutex
+	topics          map[string]bool
+	groups          map[string]bool
+	produced        map[string]*Counter
+	consumed        map[string]*Counter
+	consumedByGroup map[string]map[string]*Counter
+}
+
+// New creates throughput counters that count at most maxTopics topics and
+// maxGroups groups separately. Zero means no limit.
+func New(maxTopics, maxGroups int) *T {
+	return &T{
+		maxTopics:       maxTopics,
+		maxGroups:       maxGroups,
+		topics:          make(map[string]bool),
+		groups:          make(map[string]bool),
+		produced:        make(map[string]*Counter),
+		consumed:        make(map[string]*Counter),
+		consumedByGroup: make(map[string]map[string]*Counter),
+	}
+}
+
+// OnProduced counts a message accepted to be produced to a topic.
+func (t *T) OnProduced(topic string, key, value sarama.Encoder) {
+	size := encodedLen(key) + encodedLen(value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	topic = t.limit(t.topics, t.maxTopics, topic)
+	add(t.produced, topic, size)
+}
+
+// OnConsumed counts a message consumed from a topic by a group.
+func (t *T) OnConsumed(group string, msg *sarama.ConsumerMessage) {
+	size := len(msg.Key) + len(msg.Value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	topic := t.limit(t.topics, t.maxTopics, msg.Topic)
+	group = t.limit(t.groups, t.maxGroups, group)
+	add(t.consumed, topic, size)
+	byTopic := t.consumedByGroup[group]
+	if byTopic == nil {
+		byTopic = make(map[string]*Counter)
+		t.consumedByGroup[group] = byTopic
+	}
+	add(byTopic, topic, size)
+}
+
+// Stats returns a snapshot of the counters.
+func (t *T) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := Stats{
+		Produced:        snapshot(t.produced),
+		Consumed:        snapshot(t.consumed),
+		ConsumedByGroup: make(map[string]map[string]Counter, len(t.consumedByGroup)),
+	}
+	for group, byTopic := range t.consumedByGroup {
+		stats.ConsumedByGroup[group] = snapshot(byTopic)
+	}
+	return stats
+}
+
+// limit returns the name to count a topic or group under. Once max names
+// are known, new ones are counted under Other. It must be called with the
+// mutex held.
+func (t *T) limit(known map[string]bool, max int, name string) string {
+	if known[name] {
+		return name
+	}
+	if max > 0 && len(known) >= max {
+		return Other
+	}
+	known[name] = true
+	return name
+}
+
+func add(counters map[string]*Counter, name string, size int) {
+	counter := counters[name]
+	if counter == nil {
+		counter = &Counter{}
+		counters[name] = counter
+	}
+	counter.Messages++
+	counter.Bytes += int64(size)
+}
+
+func snapshot(counters map[string]*Counter) map[string]Counter {
+	s := make(map[string]Counter, len(counters))
+	for name, counter := range counters {
+		s[name] = *counter
+	}
+	return s
+}
+
+func encodedLen(e sarama.Encoder) int {
+	if e == nil {
+		return 0
+	}
+	return e.Length()
+}
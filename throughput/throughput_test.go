@@ -0,0 +1,85 @@
+package throughput
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+type ThroughputSuite struct{}
+
+var _ = Suite(&ThroughputSuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+// Produced messages are counted by topic, consumed ones by topic and by group
+// and topic. Sizes include keys and values.
+func (s *ThroughputSuite) TestCount(c *C) {
+	t := New(0, 0)
+
+	// When
+	t.OnProduced("foo", sarama.StringEncoder("k1"), sarama.StringEncoder("bar"))
+	t.OnProduced("foo", nil, sarama.StringEncoder("bazz"))
+	t.OnProduced("bar", nil, nil)
+	t.OnConsumed("g1", newMsg("foo", "k1", "bar"))
+	t.OnConsumed("g2", newMsg("foo", "", "blah"))
+	t.OnConsumed("g2", newMsg("bar", "", "x"))
+
+	// Then
+	c.Check(t.Stats(), DeepEquals, Stats{
+		Produced: map[string]Counter{
+			"foo": {Messages: 2, Bytes: 9},
+			"bar": {Messages: 1, Bytes: 0},
+		},
+		Consumed: map[string]Counter{
+			"foo": {Messages: 2, Bytes: 9},
+			"bar": {Messages: 1, Bytes: 1},
+		},
+		ConsumedByGroup: map[string]map[string]Counter{
+			"g1": {"foo": {Messages: 1, Bytes: 5}},
+			"g2": {"foo": {Messages: 1, Bytes: 4}, "bar": {Messages: 1, Bytes: 1}},
+		},
+	})
+}
+
+// Topics and groups over the limits are counted under Other, while those
+// seen before the limits were reached are still counted separately.
+func (s *ThroughputSuite) TestLimits(c *C) {
+	t := New(2, 1)
+
+	// When
+	t.OnProduced("foo", nil, sarama.StringEncoder("a"))
+	t.OnConsumed("g1", newMsg("bar", "", "b"))
+	t.OnProduced("bazz", nil, sarama.StringEncoder("c"))
+	t.OnConsumed("g2", newMsg("blah", "", "d"))
+	t.OnConsumed("g2", newMsg("foo", "", "e"))
+	t.OnProduced("foo", nil, sarama.StringEncoder("f"))
+
+	// Then
+	c.Check(t.Stats(), DeepEquals, Stats{
+		Produced: map[string]Counter{
+			"foo": {Messages: 2, Bytes: 2},
+			Other: {Messages: 1, Bytes: 1},
+		},
+		Consumed: map[string]Counter{
+			"bar": {Messages: 1, Bytes: 1},
+			"foo": {Messages: 1, Bytes: 1},
+			Other: {Messages: 1, Bytes: 1},
+		},
+		ConsumedByGroup: map[string]map[string]Counter{
+			"g1":  {"bar": {Messages: 1, Bytes: 1}},
+			Other: {"foo": {Messages: 1, Bytes: 1}, Other: {Messages: 1, Bytes: 1}},
+		},
+	})
+}
+
+func newMsg(topic, key, value string) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Topic: topic, Value: []byte(value)}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	return msg
+}
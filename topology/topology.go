@@ -0,0 +1,287 @@
+// Package topology implements watching of a Kafka cluster for topology
+// changes, that is partition leadership movements, brokers joining and
+// leaving the cluster and controller failovers. Components that keep track of
+// partition leaders subscribe to changes of a cluster to update their broker
+// assignments right away, rather than when a request to a former leader fails.
+// Subscriptions are process wide per cluster, so that components do not need
+// a reference to the watcher.
+package topology
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/pkg/errors"
+)
+
+// Change describes how the topology of a cluster changed between two
+// consecutive checks.
+type Change struct {
+	// Partitions that got a different leader, by topic. Partitions of
+	// topics that were created or deleted in between are not included.
+	MovedPartitions map[string][]int32
+
+	// IDs of brokers that joined and left the cluster.
+	JoinedBrokers []int32
+	LeftBrokers   []int32
+
+	// ID of the new controller if it changed, -1 otherwise.
+	NewController int32
+}
+
+// Empty tells whether the topology did not change.
+func (c Change) Empty() bool {
+	return len(c.MovedPartitions) == 0 && len(c.JoinedBrokers) == 0 &&
+		len(c.LeftBrokers) == 0 && c.NewController < 0
+}
+
+// Moved tells whether the leader of a partition changed.
+func (c Change) Moved(topic string, partition int32) bool {
+	for _, p := range c.MovedPartitions[topic] {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("{moved=%v, joined=%v, left=%v, controller=%d}",
+		c.MovedPartitions, c.JoinedBrokers, c.LeftBrokers, c.NewController)
+}
+
+type subscription struct {
+	fn func(Change)
+}
+
+var (
+	subscriptionsMu sync.Mutex
+	subscriptions   = make(map[string]map[*subscription]bool)
+)
+
+// Subscribe registers a function to be called with every topology change
+// detected in a cluster. The function is called by the watcher goroutine, so
+// it should not block for long. The returned function cancels the
+// subscription.
+func Subscribe(cluster string, fn func(Change)) func() {
+	s := &subscription{fn: fn}
+	subscriptionsMu.Lock()
+	if subscriptions[cluster] == nil {
+		subscriptions[cluster] = make(map[*subscription]bool)
+	}
+	subscriptions[cluster][s] = true
+	subscriptionsMu.Unlock()
+	return func() {
+		subscriptionsMu.Lock()
+		delete(subscriptions[cluster], s)
+		if len(subscriptions[cluster]) == 0 {
+			delete(subscriptions, cluster)
+		}
+		subscriptionsMu.Unlock()
+	}
+}
+
+// publish calls all subscribers of a cluster with a change.
+func publish(cluster string, change Change) {
+	subscriptionsMu.Lock()
+	fns := make([]func(Change), 0, len(subscriptions[cluster]))
+	for s := range subscriptions[cluster] {
+		fns = append(fns, s.fn)
+	}
+	subscriptionsMu.Unlock()
+	for _, fn := range fns {
+		fn(change)
+	}
+}
+
+// T is a watcher that periodically checks metadata of a cluster and notifies
+// subscribers of the cluster when its topology changes.
+type T struct {
+	actDesc  *actor.Descriptor
+	cluster  string
+	interval time.Duration
+	snapshot func() (snapshot, error)
+	stopCh   chan none.T
+	wg       sync.WaitGroup
+
+	// Accessed atomically.
+	leaderChanges     int64
+	brokerJoins       int64
+	brokerLeaves      int64
+	controllerChanges int64
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// snapshot is the topology of a cluster at some point in time.
+type snapshot struct {
+	controller int32
+	brokers    map[int32]bool
+	leaders    map[topicPartition]int32
+}
+
+// Spawn creates a watcher that checks metadata of the cluster via the
+// specified client every `kafka.topology_check_interval`. If the interval is
+// zero, then nil is returned.
+func Spawn(parentActDesc *actor.Descriptor, cfg *config.Proxy, kafkaClt sarama.Client) *T {
+	if cfg.Kafka.TopologyCheckInterval <= 0 {
+		return nil
+	}
+	t := newWatcher(parentActDesc.NewChild("topology"), cfg, func() (snapshot, error) {
+		return takeSnapshot(kafkaClt)
+	})
+	actor.Spawn(t.actDesc, &t.wg, t.run)
+	return t
+}
+
+func newWatcher(actDesc *actor.Descriptor, cfg *config.Proxy, snapshotFn func() (snapshot, error)) *T {
+	t := &T{
+		actDesc:  actDesc,
+		cluster:  cfg.Cluster,
+		interval: cfg.Kafka.TopologyCheckInterval,
+		snapshot: snapshotFn,
+		stopCh:   make(chan none.T),
+	}
+	t.actDesc.ObserveGauge("leader_changes", func() int64 { return atomic.LoadInt64(&t.leaderChanges) })
+	t.actDesc.ObserveGauge("broker_joins", func() int64 { return atomic.LoadInt64(&t.brokerJoins) })
+	t.actDesc.ObserveGauge("broker_leaves", func() int64 { return atomic.LoadInt64(&t.brokerLeaves) })
+	t.actDesc.ObserveGauge("controller_changes", func() int64 { return atomic.LoadInt64(&t.controllerChanges) })
+	return t
+}
+
+// Stop makes the watcher stop checking the cluster. It is safe to call on a
+// nil watcher.
+func (t *T) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+func (t *T) run() {
+	prev, err := t.snapshot()
+	if err != nil {
+		t.actDesc.Log().WithError(err).Error("Failed to check topology")
+	}
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stopCh:
+			return
+		}
+		curr, err := t.snapshot()
+		if err != nil {
+			t.actDesc.Log().WithError(err).Error("Failed to check topology")
+			continue
+		}
+		// Nothing to compare to if the first check failed.
+		if prev.leaders == nil {
+			prev = curr
+			continue
+		}
+		change := diff(prev, curr)
+		prev = curr
+		if change.Empty() {
+			continue
+		}
+		t.actDesc.Log().Infof("Topology changed: %s", change)
+		for _, partitions := range change.MovedPartitions {
+			atomic.AddInt64(&t.leaderChanges, int64(len(partitions)))
+		}
+		atomic.AddInt64(&t.brokerJoins, int64(len(change.JoinedBrokers)))
+		atomic.AddInt64(&t.brokerLeaves, int64(len(change.LeftBrokers)))
+		if change.NewController >= 0 {
+			atomic.AddInt64(&t.controllerChanges, 1)
+		}
+		publish(t.cluster, change)
+	}
+}
+
+// takeSnapshot refreshes metadata of all topics and returns the topology
+// that it describes. Partitions that have no leader at the moment get -1 as
+// the leader ID, and so does the controller if brokers do not report it.
+func takeSnapshot(kafkaClt sarama.Client) (snapshot, error) {
+	if err := kafkaClt.RefreshMetadata(); err != nil {
+		return snapshot{}, errors.Wrap(err, "failed to refresh metadata")
+	}
+	s := snapshot{
+		controller: -1,
+		brokers:    make(map[int32]bool),
+		leaders:    make(map[topicPartition]int32),
+	}
+	if controller, err := kafkaClt.Controller(); err == nil {
+		s.controller = controller.ID()
+	}
+	for _, broker := range kafkaClt.Brokers() {
+		s.brokers[broker.ID()] = true
+	}
+	topics, err := kafkaClt.Topics()
+	if err != nil {
+		return snapshot{}, errors.Wrap(err, "failed to get topics")
+	}
+	for _, topic := range topics {
+		partitions, err := kafkaClt.Partitions(topic)
+		if err != nil {
+			return snapshot{}, errors.Wrapf(err, "failed to get partitions, topic=%s", topic)
+		}
+		for _, partition := range partitions {
+			leaderID := int32(-1)
+			if leader, err := kafkaClt.Leader(topic, partition); err == nil {
+				leaderID = leader.ID()
+			}
+			s.leaders[topicPartition{topic, partition}] = leaderID
+		}
+	}
+	return s, nil
+}
+
+// diff returns the change from one topology to another.
+func diff(prev, curr snapshot) Change {
+	change := Change{NewController: -1}
+	if curr.controller != prev.controller && curr.controller >= 0 {
+		change.NewController = curr.controller
+	}
+	for brokerID := range curr.brokers {
+		if !prev.brokers[brokerID] {
+			change.JoinedBrokers = append(change.JoinedBrokers, brokerID)
+		}
+	}
+	for brokerID := range prev.brokers {
+		if !curr.brokers[brokerID] {
+			change.LeftBrokers = append(change.LeftBrokers, brokerID)
+		}
+	}
+	sortIDs(change.JoinedBrokers)
+	sortIDs(change.LeftBrokers)
+	for tp, leaderID := range curr.leaders {
+		prevLeaderID, ok := prev.leaders[tp]
+		if !ok || prevLeaderID == leaderID {
+			continue
+		}
+		if change.MovedPartitions == nil {
+			change.MovedPartitions = make(map[string][]int32)
+		}
+		change.MovedPartitions[tp.topic] = append(change.MovedPartitions[tp.topic], tp.partition)
+	}
+	for _, partitions := range change.MovedPartitions {
+		sortIDs(partitions)
+	}
+	return change
+}
+
+func sortIDs(ids []int32) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
@@ -0,0 +1,168 @@
+package topology
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+type TopologySuite struct{}
+
+var _ = Suite(&TopologySuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *TopologySuite) TestDiff(c *C) {
+	prev := newSnapshot(1, []int32{1, 2, 3}, map[topicPartition]int32{
+		{"foo", 0}: 1,
+		{"foo", 1}: 2,
+		{"foo", 2}: 3,
+		{"bar", 0}: 1,
+		{"old", 0}: 2,
+	})
+	curr := newSnapshot(2, []int32{1, 2, 4}, map[topicPartition]int32{
+		{"foo", 0}: 1,
+		{"foo", 1}: 1,
+		{"foo", 2}: -1,
+		{"bar", 0}: 4,
+		{"new", 0}: 4,
+	})
+
+	// When
+	change := diff(prev, curr)
+
+	// Then
+	c.Check(change, DeepEquals, Change{
+		MovedPartitions: map[string][]int32{"foo": {1, 2}, "bar": {0}},
+		JoinedBrokers:   []int32{4},
+		LeftBrokers:     []int32{3},
+		NewController:   2,
+	})
+	c.Check(change.Empty(), Equals, false)
+	for i, tc := range []struct {
+		topic     string
+		partition int32
+		moved     bool
+	}{
+		{topic: "foo", partition: 0, moved: false},
+		{topic: "foo", partition: 1, moved: true},
+		{topic: "foo", partition: 2, moved: true},
+		{topic: "bar", partition: 0, moved: true},
+		{topic: "new", partition: 0, moved: false},
+	} {
+		c.Check(change.Moved(tc.topic, tc.partition), Equals, tc.moved, Commentf("case #%d", i))
+	}
+}
+
+// If the topology did not change, or the controller became unknown, then the
+// change is empty.
+func (s *TopologySuite) TestDiffEmpty(c *C) {
+	prev := newSnapshot(1, []int32{1, 2}, map[topicPartition]int32{{"foo", 0}: 1})
+	curr := newSnapshot(-1, []int32{1, 2}, map[topicPartition]int32{{"foo", 0}: 1})
+
+	// When
+	change := diff(prev, curr)
+
+	// Then
+	c.Check(change.Empty(), Equals, true)
+}
+
+// Changes are published to subscribers of the cluster only, and only until
+// they unsubscribe.
+func (s *TopologySuite) TestSubscribe(c *C) {
+	var fooChanges, barChanges []Change
+	unsubscribeFoo := Subscribe("foo", func(change Change) { fooChanges = append(fooChanges, change) })
+	unsubscribeBar := Subscribe("bar", func(change Change) { barChanges = append(barChanges, change) })
+	defer unsubscribeBar()
+	change := Change{JoinedBrokers: []int32{1}, NewController: -1}
+
+	// When
+	publish("foo", change)
+	unsubscribeFoo()
+	publish("foo", change)
+
+	// Then
+	c.Check(fooChanges, DeepEquals, []Change{change})
+	c.Check(barChanges, IsNil)
+}
+
+// The watcher publishes changes between consecutive checks and counts them.
+// Failed checks are skipped.
+func (s *TopologySuite) TestWatcher(c *C) {
+	cfg := testhelpers.NewTestProxyCfg("test")
+	cfg.Kafka.TopologyCheckInterval = 10 * time.Millisecond
+	snapshots := []snapshot{
+		newSnapshot(1, []int32{1, 2}, map[topicPartition]int32{{"foo", 0}: 1}),
+		{},
+		newSnapshot(1, []int32{1, 2}, map[topicPartition]int32{{"foo", 0}: 1}),
+		newSnapshot(2, []int32{2}, map[topicPartition]int32{{"foo", 0}: 2}),
+	}
+	checkCh := make(chan none.T, len(snapshots))
+	w := newWatcher(actor.Root().NewChild("topology"), cfg, func() (snapshot, error) {
+		if len(snapshots) == 0 {
+			return newSnapshot(2, []int32{2}, map[topicPartition]int32{{"foo", 0}: 2}), nil
+		}
+		snap := snapshots[0]
+		snapshots = snapshots[1:]
+		checkCh <- none.V
+		if snap.leaders == nil {
+			return snapshot{}, errors.New("kaboom")
+		}
+		return snap, nil
+	})
+	changesCh := make(chan Change, 10)
+	unsubscribe := Subscribe(cfg.Cluster, func(change Change) { changesCh <- change })
+	defer unsubscribe()
+
+	// When
+	actor.Spawn(w.actDesc, &w.wg, w.run)
+	for i := 0; i < 4; i++ {
+		<-checkCh
+	}
+	var change Change
+	select {
+	case change = <-changesCh:
+	case <-time.After(3 * time.Second):
+		c.Fatal("Change is not published")
+	}
+	w.Stop()
+
+	// Then
+	c.Check(change, DeepEquals, Change{
+		MovedPartitions: map[string][]int32{"foo": {0}},
+		LeftBrokers:     []int32{1},
+		NewController:   2,
+	})
+	c.Check(len(changesCh), Equals, 0)
+	c.Check(w.leaderChanges, Equals, int64(1))
+	c.Check(w.brokerJoins, Equals, int64(0))
+	c.Check(w.brokerLeaves, Equals, int64(1))
+	c.Check(w.controllerChanges, Equals, int64(1))
+}
+
+// If the check interval is zero, then no watcher is spawned.
+func (s *TopologySuite) TestDisabled(c *C) {
+	cfg := testhelpers.NewTestProxyCfg("test")
+
+	// When
+	w := Spawn(actor.Root(), cfg, nil)
+
+	// Then
+	c.Check(w, IsNil)
+	w.Stop()
+}
+
+func newSnapshot(controller int32, brokers []int32, leaders map[topicPartition]int32) snapshot {
+	s := snapshot{controller: controller, brokers: make(map[int32]bool), leaders: leaders}
+	for _, brokerID := range brokers {
+		s.brokers[brokerID] = true
+	}
+	return s
+}
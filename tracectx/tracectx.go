@@ -0,0 +1,127 @@
+// Package tracectx implements propagation of W3C trace context, see
+// https://www.w3.org/TR/trace-context/, through Kafka record headers, so that
+// a trace started by a producer continues at consumers even if their client
+// libraries are not aware of tracing.
+package tracectx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// ParentHeader is the name of both the HTTP and Kafka record header that
+	// carries the trace and parent span IDs.
+	ParentHeader = "traceparent"
+
+	// StateHeader is the name of both the HTTP and Kafka record header that
+	// carries vendor specific trace state.
+	StateHeader = "tracestate"
+
+	// version is the only trace context version that is generated.
+	version = "00"
+)
+
+// T is a W3C trace context.
+type T struct {
+	Parent string
+	State  string
+}
+
+type contextKey struct{}
+
+// New starts a new trace with random trace and parent IDs. The trace is not
+// sampled, for Kafka-Pixy does not record spans itself.
+func New() T {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return T{Parent: version + "-" + hex.EncodeToString(buf[:16]) + "-" + hex.EncodeToString(buf[16:]) + "-00"}
+}
+
+// Honor returns a trace context passed by a caller if its parent is valid.
+// Otherwise a new trace is started, and the caller trace state is dropped
+// along with the parent it belongs to.
+func Honor(tc T) T {
+	if !IsValid(tc.Parent) {
+		return New()
+	}
+	return tc
+}
+
+// IsValid tells whether a traceparent value is well formed, that is it
+// consists of a version, a trace ID, a parent ID and flags in lower case hex,
+// and neither the trace ID nor the parent ID is all zeros.
+func IsValid(parent string) bool {
+	parts := strings.Split(parent, "-")
+	if len(parts) < 4 {
+		return false
+	}
+	// Versions after 00 may append fields, but the first four stay the same.
+	if parts[0] == version && len(parts) != 4 || parts[0] == "ff" {
+		return false
+	}
+	for i, size := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != size || !isLowerHex(parts[i]) {
+			return false
+		}
+	}
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
+
+// FromHeaders returns a trace context carried by record headers of a
+// consumed message, if there is a valid one.
+func FromHeaders(headers []*sarama.RecordHeader) (T, bool) {
+	var tc T
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case ParentHeader:
+			tc.Parent = string(h.Value)
+		case StateHeader:
+			tc.State = string(h.Value)
+		}
+	}
+	return tc, IsValid(tc.Parent)
+}
+
+// HasParent tells whether record headers of a message to be produced carry a
+// trace parent already.
+func HasParent(headers []sarama.RecordHeader) bool {
+	for _, h := range headers {
+		if string(h.Key) == ParentHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// NewContext returns a context that carries a trace context passed by a
+// caller.
+func NewContext(ctx context.Context, tc T) context.Context {
+	return context.WithValue(ctx, contextKey{}, tc)
+}
+
+// FromContext returns the trace context carried by a context, or an empty
+// one if there is none.
+func FromContext(ctx context.Context) T {
+	tc, _ := ctx.Value(contextKey{}).(T)
+	return tc
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
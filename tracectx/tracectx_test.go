@@ -0,0 +1,104 @@
+package tracectx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TraceCtxSuite struct{}
+
+var _ = Suite(&TraceCtxSuite{})
+
+const validParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func (s *TraceCtxSuite) TestNew(c *C) {
+	tc1 := New()
+	tc2 := New()
+	c.Check(tc1.Parent, Matches, "00-[0-9a-f]{32}-[0-9a-f]{16}-00")
+	c.Check(tc1.State, Equals, "")
+	c.Check(IsValid(tc1.Parent), Equals, true)
+	c.Check(tc1, Not(Equals), tc2)
+}
+
+func (s *TraceCtxSuite) TestIsValid(c *C) {
+	for i, tc := range []struct {
+		parent string
+		valid  bool
+	}{
+		{parent: validParent, valid: true},
+		{parent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo", valid: true},
+		{parent: "", valid: false},
+		{parent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo", valid: false},
+		{parent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: false},
+		{parent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", valid: false},
+		{parent: "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", valid: false},
+		{parent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", valid: false},
+		{parent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", valid: false},
+		{parent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", valid: false},
+	} {
+		c.Check(IsValid(tc.parent), Equals, tc.valid, Commentf("case #%d", i))
+	}
+}
+
+func (s *TraceCtxSuite) TestHonor(c *C) {
+	tc := T{Parent: validParent, State: "foo=bar"}
+	c.Check(Honor(tc), Equals, tc)
+
+	// When
+	honored := Honor(T{Parent: "bogus", State: "foo=bar"})
+
+	// Then
+	c.Check(honored.Parent, Not(Equals), "bogus")
+	c.Check(IsValid(honored.Parent), Equals, true)
+	c.Check(honored.State, Equals, "")
+}
+
+func (s *TraceCtxSuite) TestFromHeaders(c *C) {
+	for i, tc := range []struct {
+		headers []*sarama.RecordHeader
+		tc      T
+		ok      bool
+	}{{
+		headers: []*sarama.RecordHeader{
+			{Key: []byte("foo"), Value: []byte("bar")},
+			{Key: []byte(ParentHeader), Value: []byte(validParent)},
+			{Key: []byte(StateHeader), Value: []byte("foo=bar")},
+		},
+		tc: T{Parent: validParent, State: "foo=bar"},
+		ok: true,
+	}, {
+		headers: []*sarama.RecordHeader{nil, {Key: []byte(ParentHeader), Value: []byte(validParent)}},
+		tc:      T{Parent: validParent},
+		ok:      true,
+	}, {
+		headers: []*sarama.RecordHeader{{Key: []byte(ParentHeader), Value: []byte("bogus")}},
+		tc:      T{Parent: "bogus"},
+		ok:      false,
+	}, {
+		headers: nil,
+		ok:      false,
+	}} {
+		traceCtx, ok := FromHeaders(tc.headers)
+		c.Check(ok, Equals, tc.ok, Commentf("case #%d", i))
+		c.Check(traceCtx, Equals, tc.tc, Commentf("case #%d", i))
+	}
+}
+
+func (s *TraceCtxSuite) TestHasParent(c *C) {
+	c.Check(HasParent(nil), Equals, false)
+	c.Check(HasParent([]sarama.RecordHeader{{Key: []byte(StateHeader)}}), Equals, false)
+	c.Check(HasParent([]sarama.RecordHeader{{Key: []byte(ParentHeader)}}), Equals, true)
+}
+
+func (s *TraceCtxSuite) TestContext(c *C) {
+	c.Check(FromContext(context.Background()), Equals, T{})
+	tc := T{Parent: validParent}
+	c.Check(FromContext(NewContext(context.Background(), tc)), Equals, tc)
+}
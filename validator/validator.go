@@ -0,0 +1,253 @@
+// Package validator implements produce validation webhooks. A candidate
+// message is posted as JSON to an external validator, and it is only
+// produced if the validator accepts it with a 2xx response, so that data
+// quality gates can be enforced centrally, regardless of what clients
+// produce messages.
+//
+// Verdicts are optionally cached, so that identical messages, e.g. retries
+// of the same produce request, are validated once. If a validator fails to
+// respond, then messages are either rejected or produced without validation
+// as the failure policy tells. Failures are never cached.
+package validator
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeout   = 5 * time.Second
+	defaultCacheSize = 10000
+
+	// The maximum number of bytes of a rejection response body that is
+	// reported as the reason of the rejection.
+	maxReasonBytes = 1024
+)
+
+var (
+	// ErrRejected is the cause of errors returned when a validator rejects
+	// a message.
+	ErrRejected = errors.New("message rejected by validator")
+
+	// ErrUnavailable is the cause of errors returned when a validator fails
+	// to respond and the failure policy is `fail_closed`.
+	ErrUnavailable = errors.New("validator is unavailable")
+)
+
+// Header is a record header of a candidate message.
+type Header struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Candidate is posted as JSON to a validator. Keys, values and header values
+// are encoded in base64, a null key or value is encoded as null.
+type Candidate struct {
+	Topic   string   `json:"topic"`
+	Key     []byte   `json:"key"`
+	Value   []byte   `json:"value"`
+	Headers []Header `json:"headers,omitempty"`
+}
+
+// verdictError is returned when a message is rejected, or cannot be
+// validated. Its cause is either ErrRejected or ErrUnavailable.
+type verdictError struct {
+	cause  error
+	reason string
+}
+
+func (e *verdictError) Error() string {
+	return fmt.Sprintf("%s: %s", e.cause, e.reason)
+}
+
+func (e *verdictError) Cause() error {
+	return e.cause
+}
+
+// T validates messages produced to a topic. It is safe for concurrent use.
+type T struct {
+	actDesc  *actor.Descriptor
+	cfg      config.ProduceValidation
+	topic    string
+	failOpen bool
+	httpClt  *http.Client
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List
+}
+
+// entry is a cached verdict on a message, rejection is nil if the message
+// was accepted.
+type entry struct {
+	digest    [sha256.Size]byte
+	rejection *verdictError
+	expiresAt time.Time
+}
+
+// New creates a validator of messages produced to a topic.
+func New(parentActDesc *actor.Descriptor, topic string, cfg config.ProduceValidation) *T {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	return &T{
+		actDesc:  parentActDesc.NewChild("validator", topic),
+		cfg:      cfg,
+		topic:    topic,
+		failOpen: cfg.FailurePolicy == config.FailOpen,
+		httpClt:  &http.Client{Timeout: timeout},
+		now:      time.Now,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Validate posts a candidate message to the validator and returns nil if
+// it is accepted. If the validator fails to respond, then nil is returned
+// with the `fail_open` policy, and an error caused by ErrUnavailable with
+// the `fail_closed` one.
+func (v *T) Validate(key, value []byte, headers []sarama.RecordHeader) error {
+	digest := v.digest(key, value, headers)
+	rejection, ok := v.cached(digest)
+	if !ok {
+		var err error
+		if rejection, err = v.post(key, value, headers); err != nil {
+			if v.failOpen {
+				v.actDesc.Log().WithError(err).Warn("Failed to validate message, accepting it")
+				return nil
+			}
+			return &verdictError{cause: ErrUnavailable, reason: err.Error()}
+		}
+		v.cache(digest, rejection)
+	}
+	if rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
+// post posts a candidate message to the validator and returns a rejection,
+// or nil if the message is accepted. An error is returned if the validator
+// fails to give a verdict.
+func (v *T) post(key, value []byte, headers []sarama.RecordHeader) (*verdictError, error) {
+	candidate := Candidate{Topic: v.topic, Key: key, Value: value}
+	for _, h := range headers {
+		candidate.Headers = append(candidate.Headers, Header{Key: string(h.Key), Value: h.Value})
+	}
+	body, err := json.Marshal(candidate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode candidate")
+	}
+	rs, err := v.httpClt.Post(v.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	reason, err := ioutil.ReadAll(io.LimitReader(rs.Body, maxReasonBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read response")
+	}
+	switch {
+	case rs.StatusCode >= 200 && rs.StatusCode < 300:
+		return nil, nil
+	case rs.StatusCode >= 500 || rs.StatusCode == http.StatusRequestTimeout || rs.StatusCode == http.StatusTooManyRequests:
+		return nil, errors.Errorf("unexpected status: %d", rs.StatusCode)
+	}
+	rejection := &verdictError{cause: ErrRejected, reason: strings.TrimSpace(string(reason))}
+	if rejection.reason == "" {
+		rejection.reason = http.StatusText(rs.StatusCode)
+	}
+	return rejection, nil
+}
+
+// digest returns a hash that identifies a message in the cache.
+func (v *T) digest(key, value []byte, headers []sarama.RecordHeader) [sha256.Size]byte {
+	h := sha256.New()
+	write := func(b []byte) {
+		var size [8]byte
+		// Null and empty byte arrays are told apart by their size.
+		if b == nil {
+			binary.BigEndian.PutUint64(size[:], ^uint64(0))
+		} else {
+			binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		}
+		h.Write(size[:])
+		h.Write(b)
+	}
+	write(key)
+	write(value)
+	for _, hdr := range headers {
+		write(hdr.Key)
+		write(hdr.Value)
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// cached returns a verdict on a message if there is one in the cache.
+func (v *T) cached(digest [sha256.Size]byte) (*verdictError, bool) {
+	if v.cfg.CacheTTL <= 0 {
+		return nil, false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.expire(v.now())
+	el, ok := v.entries[digest]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*entry).rejection, true
+}
+
+func (v *T) cache(digest [sha256.Size]byte, rejection *verdictError) {
+	if v.cfg.CacheTTL <= 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if el, ok := v.entries[digest]; ok {
+		v.remove(el)
+	}
+	if v.order.Len() >= v.cfg.CacheSize {
+		v.remove(v.order.Front())
+	}
+	e := &entry{digest: digest, rejection: rejection, expiresAt: v.now().Add(v.cfg.CacheTTL)}
+	v.entries[digest] = v.order.PushBack(e)
+}
+
+// expire removes verdicts that were cached longer than the TTL ago. Since
+// all entries have the same lifetime, the list is ordered by expiration.
+func (v *T) expire(now time.Time) {
+	for el := v.order.Front(); el != nil; el = v.order.Front() {
+		if now.Before(el.Value.(*entry).expiresAt) {
+			return
+		}
+		v.remove(el)
+	}
+}
+
+func (v *T) remove(el *list.Element) {
+	v.order.Remove(el)
+	delete(v.entries, el.Value.(*entry).digest)
+}
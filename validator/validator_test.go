@@ -0,0 +1,152 @@
+package validator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ValidatorSuite struct {
+	ns *actor.Descriptor
+}
+
+var _ = Suite(&ValidatorSuite{})
+
+func (s *ValidatorSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging()
+}
+
+func (s *ValidatorSuite) SetUpTest(c *C) {
+	s.ns = actor.Root().NewChild("T")
+}
+
+// A validator is posted candidate messages as JSON, and messages are
+// accepted on 2xx and rejected with the response body as the reason on 4xx.
+func (s *ValidatorSuite) TestValidate(c *C) {
+	candidatesCh := make(chan Candidate, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var candidate Candidate
+		c.Check(json.NewDecoder(r.Body).Decode(&candidate), IsNil)
+		candidatesCh <- candidate
+		if string(candidate.Value) != "good" {
+			http.Error(w, "value must be good", http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+	v := New(s.ns, "foo", config.ProduceValidation{URL: server.URL})
+	headers := []sarama.RecordHeader{{Key: []byte("h1"), Value: []byte("v1")}}
+
+	// When
+	err1 := v.Validate([]byte("k1"), []byte("good"), headers)
+	err2 := v.Validate(nil, []byte("bad"), nil)
+
+	// Then
+	c.Check(err1, IsNil)
+	c.Check(<-candidatesCh, DeepEquals, Candidate{
+		Topic:   "foo",
+		Key:     []byte("k1"),
+		Value:   []byte("good"),
+		Headers: []Header{{Key: "h1", Value: []byte("v1")}},
+	})
+	c.Check(err2, ErrorMatches, "message rejected by validator: value must be good")
+	c.Check(errors.Cause(err2), Equals, ErrRejected)
+	c.Check(<-candidatesCh, DeepEquals, Candidate{Topic: "foo", Value: []byte("bad")})
+}
+
+// If a validator fails to respond, then messages are rejected with the
+// fail_closed policy, and accepted with the fail_open one.
+func (s *ValidatorSuite) TestFailurePolicy(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	for i, tc := range []struct {
+		policy string
+		errMsg string
+	}{
+		{policy: "", errMsg: "validator is unavailable: unexpected status: 503"},
+		{policy: config.FailClosed, errMsg: "validator is unavailable: unexpected status: 503"},
+		{policy: config.FailOpen},
+	} {
+		v := New(s.ns, "foo", config.ProduceValidation{URL: server.URL, FailurePolicy: tc.policy})
+
+		// When
+		err := v.Validate(nil, []byte("bar"), nil)
+
+		// Then
+		if tc.errMsg == "" {
+			c.Check(err, IsNil, Commentf("case #%d", i))
+			continue
+		}
+		c.Check(err, ErrorMatches, tc.errMsg, Commentf("case #%d", i))
+		c.Check(errors.Cause(err), Equals, ErrUnavailable, Commentf("case #%d", i))
+	}
+}
+
+// Verdicts on identical messages are cached for the TTL, failures are not
+// cached at all.
+func (s *ValidatorSuite) TestCache(c *C) {
+	var posts, status int32
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	v := New(s.ns, "foo", config.ProduceValidation{URL: server.URL, CacheTTL: time.Minute})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	// When/Then
+	c.Check(v.Validate(nil, []byte("bar"), nil), ErrorMatches, "message rejected by validator: Bad Request")
+	c.Check(v.Validate(nil, []byte("bar"), nil), ErrorMatches, "message rejected by validator: Bad Request")
+	c.Check(atomic.LoadInt32(&posts), Equals, int32(1))
+
+	// An empty value is not the same as a null one.
+	c.Check(v.Validate(nil, []byte{}, nil), NotNil)
+	c.Check(atomic.LoadInt32(&posts), Equals, int32(2))
+
+	now = now.Add(time.Minute)
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	c.Check(v.Validate(nil, []byte("bar"), nil), ErrorMatches, "validator is unavailable: .*")
+	c.Check(v.Validate(nil, []byte("bar"), nil), ErrorMatches, "validator is unavailable: .*")
+	c.Check(atomic.LoadInt32(&posts), Equals, int32(4))
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	c.Check(v.Validate(nil, []byte("bar"), nil), IsNil)
+	c.Check(v.Validate(nil, []byte("bar"), nil), IsNil)
+	c.Check(atomic.LoadInt32(&posts), Equals, int32(5))
+}
+
+// When the cache is full, the oldest verdict is evicted.
+func (s *ValidatorSuite) TestCacheSize(c *C) {
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+	v := New(s.ns, "foo", config.ProduceValidation{URL: server.URL, CacheTTL: time.Minute, CacheSize: 2})
+
+	// When
+	for _, value := range []string{"a", "b", "c", "c", "b", "a"} {
+		c.Check(v.Validate(nil, []byte(value), nil), IsNil)
+	}
+
+	// Then
+	c.Check(atomic.LoadInt32(&posts), Equals, int32(4))
+}
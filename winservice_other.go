@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// controlWinService does nothing, for Kafka-Pixy can only be installed as a
+// service on Windows.
+func controlWinService() (bool, error) {
+	return false, nil
+}
+
+// runUntilStopped runs Kafka-Pixy until it receives a quit signal.
+func runUntilStopped(run func(stopCh <-chan struct{}) int) int {
+	return run(stopOnSignals(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM))
+}
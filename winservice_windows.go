@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/mailgun/kafka-pixy/logging"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	winServiceName        = "kafka-pixy"
+	winServiceDisplayName = "Kafka-Pixy"
+	winServiceDescription = "gRPC/REST proxy for Kafka"
+)
+
+// It is initialized along with package variables, so that it is registered
+// before command line flags are parsed in init.
+var cmdWinService = flag.String("winService", "", "Windows service command: install or uninstall. "+
+	"Other command line parameters given along with install are used to run the service")
+
+// controlWinService installs or uninstalls Kafka-Pixy as a Windows service if
+// requested on the command line. It returns true if it was requested.
+func controlWinService() (bool, error) {
+	switch *cmdWinService {
+	case "":
+		return false, nil
+	case "install":
+		return true, installWinService()
+	case "uninstall":
+		return true, uninstallWinService()
+	default:
+		return true, errors.Errorf("unknown command: %s", *cmdWinService)
+	}
+}
+
+func installWinService() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to get executable path")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to service manager")
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(winServiceName); err == nil {
+		s.Close()
+		return errors.Errorf("service %s already exists", winServiceName)
+	}
+	// The service is run with the same parameters it is installed with.
+	// Paths are made absolute, for services run in the system directory.
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "winService":
+			return
+		case "config", "pidFile":
+			if absValue, err := filepath.Abs(value); err == nil {
+				value = absValue
+			}
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, value))
+	})
+	s, err := m.CreateService(winServiceName, exePath, mgr.Config{
+		DisplayName: winServiceDisplayName,
+		Description: winServiceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to create service")
+	}
+	defer s.Close()
+	err = eventlog.InstallAsEventCreate(logging.EventLogSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return errors.Wrap(err, "failed to install event log source")
+	}
+	return nil
+}
+
+func uninstallWinService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to service manager")
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(winServiceName)
+	if err != nil {
+		return errors.Errorf("service %s is not installed", winServiceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return errors.Wrap(err, "failed to delete service")
+	}
+	if err := eventlog.Remove(logging.EventLogSource); err != nil {
+		return errors.Wrap(err, "failed to remove event log source")
+	}
+	return nil
+}
+
+// runUntilStopped runs Kafka-Pixy as a Windows service if started by the
+// service manager, or until it receives a quit signal otherwise, e.g. Ctrl+C
+// in a console.
+func runUntilStopped(run func(stopCh <-chan struct{}) int) int {
+	isInteractive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		fmt.Printf("Failed to detect session type: err=(%s)\n", err)
+		return 1
+	}
+	if isInteractive {
+		return run(stopOnSignals(os.Interrupt, syscall.SIGTERM))
+	}
+	ws := winService{run: run}
+	if err := svc.Run(winServiceName, &ws); err != nil {
+		return 1
+	}
+	return ws.exitCode
+}
+
+// winService handles requests of the Windows service manager.
+type winService struct {
+	run      func(stopCh <-chan struct{}) int
+	exitCode int
+}
+
+// Execute implements svc.Handler.
+func (ws *winService) Execute(args []string, changeReqCh <-chan svc.ChangeRequest, statusCh chan<- svc.Status) (bool, uint32) {
+	statusCh <- svc.Status{State: svc.StartPending}
+	stopCh := make(chan struct{})
+	exitCodeCh := make(chan int, 1)
+	go func() {
+		exitCodeCh <- ws.run(stopCh)
+	}()
+	statusCh <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case ws.exitCode = <-exitCodeCh:
+			// Kafka-Pixy failed to start.
+			return false, uint32(ws.exitCode)
+		case req := <-changeReqCh:
+			switch req.Cmd {
+			case svc.Interrogate:
+				statusCh <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				statusCh <- svc.Status{State: svc.StopPending}
+				close(stopCh)
+				ws.exitCode = <-exitCodeCh
+				return false, uint32(ws.exitCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package zkconn connects to the ZooKeeper ensemble of a proxy. If a
+// secondary ensemble is configured, then a connection fails over to it when
+// a session with the primary ensemble cannot be established for
+// `zoo_keeper.secondary.failover_after`, e.g. while migrating between
+// ensembles. Failing over means starting a new session, so ephemeral znodes
+// of the old one are left to expire on the primary ensemble.
+package zkconn
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/chaos"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/secureconn"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Connect creates a connection to the ZooKeeper ensemble of a proxy that
+// fails over to the secondary ensemble, if one is configured. If TLS is
+// enabled, then connections to peers are secured with credentials that are
+// re-read from files as they change.
+func Connect(actDesc *actor.Descriptor, cfg *config.Proxy, sessionTimeout time.Duration) (*zk.Conn, <-chan zk.Event, error) {
+	sc, err := secureconn.NewZooKeeper(actDesc, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer := zk.WithDialer(chaos.DialZooKeeper)
+	if sc != nil {
+		dialer = zk.WithDialer(func(network, addr string, timeout time.Duration) (net.Conn, error) {
+			conn, err := chaos.DialZooKeeper(network, addr, timeout)
+			if err != nil {
+				return nil, err
+			}
+			return sc.Secure(conn, addr)
+		})
+	}
+	secondaryCfg := cfg.ZooKeeper.Secondary
+	if len(secondaryCfg.SeedPeers) == 0 {
+		return zk.Connect(cfg.ZooKeeper.SeedPeers, sessionTimeout, dialer)
+	}
+	hp := &hostProvider{
+		actDesc:        actDesc,
+		secondaryPeers: secondaryCfg.SeedPeers,
+		failoverAfter:  secondaryCfg.FailoverAfter,
+	}
+	actDesc.ObserveGauge("zk_failed_over", func() int64 {
+		if hp.FailedOver() {
+			return 1
+		}
+		return 0
+	})
+	return zk.Connect(cfg.ZooKeeper.SeedPeers, sessionTimeout, zk.WithHostProvider(hp), dialer)
+}
+
+// hostProvider implements `zk.HostProvider`. It provides peers of the
+// primary ensemble until it is failed to connect to any of them for
+// failoverAfter, and peers of the secondary ensemble after that.
+type hostProvider struct {
+	actDesc        *actor.Descriptor
+	secondaryPeers []string
+	failoverAfter  time.Duration
+
+	mu           sync.Mutex
+	primary      zk.DNSHostProvider
+	secondary    zk.DNSHostProvider
+	failingSince time.Time
+	failedOver   bool
+
+	// For tests only!
+	now func() time.Time
+}
+
+// implements `zk.HostProvider`.
+func (hp *hostProvider) Init(servers []string) error {
+	if hp.now == nil {
+		hp.now = time.Now
+	}
+	if err := hp.primary.Init(servers); err != nil {
+		return err
+	}
+	return hp.secondary.Init(hp.secondaryPeers)
+}
+
+// implements `zk.HostProvider`.
+func (hp *hostProvider) Len() int {
+	return hp.active().Len()
+}
+
+// implements `zk.HostProvider`.
+func (hp *hostProvider) Next() (string, bool) {
+	hp.mu.Lock()
+	now := hp.now()
+	if hp.failingSince.IsZero() {
+		hp.failingSince = now
+	}
+	if !hp.failedOver && now.Sub(hp.failingSince) >= hp.failoverAfter {
+		hp.failedOver = true
+		hp.actDesc.Log().Errorf("Failing over to secondary ZooKeeper: peers=%v, failingFor=%s",
+			hp.secondaryPeers, now.Sub(hp.failingSince))
+	}
+	hp.mu.Unlock()
+	return hp.active().Next()
+}
+
+// implements `zk.HostProvider`.
+func (hp *hostProvider) Connected() {
+	hp.mu.Lock()
+	hp.failingSince = time.Time{}
+	hp.mu.Unlock()
+	hp.active().Connected()
+}
+
+// FailedOver tells whether the secondary ensemble is used.
+func (hp *hostProvider) FailedOver() bool {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return hp.failedOver
+}
+
+func (hp *hostProvider) active() *zk.DNSHostProvider {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if hp.failedOver {
+		return &hp.secondary
+	}
+	return &hp.primary
+}
@@ -0,0 +1,56 @@
+package zkconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ZKConnSuite struct{}
+
+var _ = Suite(&ZKConnSuite{})
+
+// Peers of the secondary ensemble are provided once peers of the primary one
+// have failed to connect for failoverAfter, and until restart after that.
+func (s *ZKConnSuite) TestFailover(c *C) {
+	now := time.Now()
+	hp := hostProvider{
+		actDesc:        actor.Root().NewChild("test"),
+		secondaryPeers: []string{"127.0.0.2:2181"},
+		failoverAfter:  time.Minute,
+		now:            func() time.Time { return now },
+	}
+	c.Assert(hp.Init([]string{"127.0.0.1:2181"}), IsNil)
+
+	for i, tc := range []struct {
+		elapsed   time.Duration
+		connected bool
+		peer      string
+	}{
+		{elapsed: 0, peer: "127.0.0.1:2181"},
+		{elapsed: 59 * time.Second, peer: "127.0.0.1:2181", connected: true},
+		// Time spent connected does not count.
+		{elapsed: time.Hour, peer: "127.0.0.1:2181"},
+		{elapsed: 59 * time.Second, peer: "127.0.0.1:2181"},
+		{elapsed: time.Second, peer: "127.0.0.2:2181", connected: true},
+		{elapsed: time.Hour, peer: "127.0.0.2:2181"},
+	} {
+		now = now.Add(tc.elapsed)
+
+		// When
+		peer, _ := hp.Next()
+		if tc.connected {
+			hp.Connected()
+		}
+
+		// Then
+		c.Check(peer, Equals, tc.peer, Commentf("case #%d", i))
+	}
+	c.Check(hp.FailedOver(), Equals, true)
+}